@@ -0,0 +1,437 @@
+package v1
+
+import "time"
+
+// Paths of the agent HTTP API
+const (
+	APIPrefix = "/api/v1"
+	// WatchPath streams newline delimited JSON encoded Events
+	WatchPath = APIPrefix + "/watch"
+	// MapsPath returns a JSON encoded list of MapInfo
+	MapsPath = APIPrefix + "/maps"
+	// FleetPath returns a JSON encoded list of FleetMap, or the FleetView of a single map
+	// when called with the `map` query parameter
+	FleetPath = APIPrefix + "/fleet"
+	// FleetClocksPath returns the JSON encoded list of the NodeClock of the nodes of the fleet
+	FleetClocksPath = FleetPath + "/clocks"
+	// FleetTimelinePath returns the JSON encoded list of the recent TimelineEvent of the
+	// ring buffers of the fleet, in the order they happened, of a single map when called with
+	// the `map` query parameter
+	FleetTimelinePath = FleetPath + "/timeline"
+	// ClockPath returns the JSON encoded ClockStatus of the node of the agent
+	ClockPath = APIPrefix + "/clock"
+	// ProgramPath returns the JSON encoded ProgramState of the program
+	ProgramPath = APIPrefix + "/program"
+	// ProbesPath returns the JSON encoded list of the AttachedProbe of the kprobe and kretprobe
+	// programs, empty until the program is attached
+	ProbesPath = ProgramPath + "/probes"
+	// StatusPath returns the JSON encoded ProgramStatus of the program, with no probes until
+	// it is attached
+	StatusPath = ProgramPath + "/status"
+	// PausePath pauses the program when POSTed to, and returns its ProgramState
+	PausePath = ProgramPath + "/pause"
+	// ResumePath resumes the program when POSTed to, and returns its ProgramState
+	ResumePath = ProgramPath + "/resume"
+	// TriggerPath starts a capture when POSTed to, with an optional `reason` query parameter,
+	// and returns the ProgramState of the program
+	TriggerPath = ProgramPath + "/trigger"
+	// OverridesPath returns the JSON encoded PackageOverrides persisted for the package of the
+	// program
+	OverridesPath = ProgramPath + "/overrides"
+	// SetOverridesPath replaces the overrides of the package with the JSON encoded
+	// PackageOverrides POSTed to it, and returns them
+	SetOverridesPath = OverridesPath + "/set"
+	// ClearOverridesPath removes the overrides of the package when POSTed to, and returns the
+	// empty PackageOverrides
+	ClearOverridesPath = OverridesPath + "/clear"
+	// PolicyPath returns the JSON encoded current Policy of the enforcement maps of the program
+	PolicyPath = ProgramPath + "/policy"
+	// UpdatePolicyPath applies the JSON encoded PolicyUpdate POSTed to it, and returns the
+	// new Policy
+	UpdatePolicyPath = PolicyPath + "/update"
+	// RollbackPolicyPath restores the Policy of the `version` query parameter when POSTed to,
+	// as a new version, and returns it
+	RollbackPolicyPath = PolicyPath + "/rollback"
+	// PolicyVersionsPath returns the JSON encoded list of the Policy versions kept, the latest
+	// first, or a single one when called with the `version` query parameter
+	PolicyVersionsPath = PolicyPath + "/versions"
+	// PolicyAuditPath returns the JSON encoded list of the PolicyAuditEntry of the changes of
+	// the policy, the oldest first
+	PolicyAuditPath = PolicyPath + "/audit"
+	// EnforcementPath returns the JSON encoded EnforcementState of the program
+	EnforcementPath = ProgramPath + "/enforcement"
+	// EnforcementModePath sets the `mode` query parameter as the mode of the `scope` query
+	// parameter when POSTed to, the whole program if empty, and returns the EnforcementState
+	EnforcementModePath = EnforcementPath + "/mode"
+	// DebugLogPath streams the newline delimited JSON encoded DebugLogEntry of the loads of
+	// the program, starting with the recent ones
+	DebugLogPath = APIPrefix + "/debug/logs"
+)
+
+// Paths of the probes of the agent, outside of the API prefix as Kubernetes probes and load
+// balancers are configured with them.
+const (
+	// HealthzPath returns the HealthReport of the liveness checks of the agent, with a 503
+	// status if any fails
+	HealthzPath = "/healthz"
+	// ReadyzPath returns the HealthReport of all the checks of the agent, with a 503 status
+	// if any fails
+	ReadyzPath = "/readyz"
+)
+
+// HealthReport is the result of the health checks of the agent.
+type HealthReport struct {
+	Healthy bool          `json:"healthy"`
+	Checks  []HealthCheck `json:"checks"`
+}
+
+// HealthCheck is the last result of a health check of the agent.
+type HealthCheck struct {
+	Name string `json:"name"`
+	// Liveness checks fail both probes, the others only the readiness one
+	Liveness bool `json:"liveness"`
+	// Whether the check failed fewer consecutive times than the failure threshold
+	Healthy bool `json:"healthy"`
+	// Consecutive failures of the check
+	Failures int `json:"failures"`
+	// Error of the last check, if it failed
+	Error string `json:"error,omitempty"`
+}
+
+type MapType string
+
+const (
+	RingBufMapType MapType = "ringbuf"
+	HashMapType    MapType = "hash"
+)
+
+// MapInfo describes a map watched by the agent.
+type MapInfo struct {
+	Name string   `json:"name"`
+	Type MapType  `json:"type"`
+	Keys []string `json:"keys"`
+}
+
+// Event is a single message of the watch stream, exactly one of Map, Entry, Deleted, Dropped
+// and Resync is set.
+// When a client connects, the stream starts with the current state of the agent:
+// an Event for every map, followed by the current entries of every hash map.
+// Hash map entries are only sent again when their value changes, and Deleted once removed.
+type Event struct {
+	Map   *MapInfo  `json:"map,omitempty"`
+	Entry *MapEntry `json:"entry,omitempty"`
+	// Deleted is the entry of a hash map which is gone, e.g. evicted as stale, only its key
+	// being set
+	Deleted *MapEntry `json:"deleted,omitempty"`
+	// Hint is set along with the Entry of the events of ring buffers
+	Hint *OrderingHint `json:"hint,omitempty"`
+	// Dropped is set when events had to be dropped because the client did not keep up
+	Dropped uint64 `json:"dropped,omitempty"`
+	// Resync is set once changes of hash maps were dropped, before the current state of the
+	// agent is sent again as on connect, the entries not sent again being gone
+	Resync bool `json:"resync,omitempty"`
+}
+
+// OrderingHint is when the agent received an event of a ring buffer, so the events of several
+// nodes can be ordered.
+type OrderingHint struct {
+	// Incremented for every event of the agent, starting at 1
+	Seq uint64 `json:"seq"`
+	// Wall clock of the node
+	Time time.Time `json:"time"`
+	// Time since the node booted, which unlike its wall clock is never stepped
+	Boottime time.Duration `json:"boottime"`
+}
+
+// ClockStatus is the clocks of the node of an agent, as sampled when requested.
+type ClockStatus struct {
+	Time     time.Time     `json:"time"`
+	Boottime time.Duration `json:"boottime"`
+	// Whether the kernel reports the wall clock synchronized, e.g. by an NTP daemon
+	Synchronized bool `json:"synchronized"`
+	// Maximum and estimated error of the wall clock, as reported by adjtimex
+	MaxError       time.Duration `json:"maxError"`
+	EstimatedError time.Duration `json:"estimatedError"`
+}
+
+// NodeClock is the clock of a node of the fleet, as last estimated by `bee fleet`.
+type NodeClock struct {
+	Node string `json:"node"`
+	// Clock of the node, zero if it could not be sampled
+	Status ClockStatus `json:"status"`
+	// Wall clock of the node minus the one of `bee fleet`
+	Offset time.Duration `json:"offset"`
+	// Round trip time of the sample, half of which bounds the error of the offset
+	RTT time.Duration `json:"rtt"`
+	// Time of the sample, on the clock of `bee fleet`
+	SampledAt time.Time `json:"sampledAt"`
+	// Error of the last sample, if it failed
+	Error string `json:"error,omitempty"`
+}
+
+// TimelineEvent is an event of a ring buffer of a node of the fleet, timed on the clock of
+// `bee fleet`.
+type TimelineEvent struct {
+	Node   string            `json:"node"`
+	Map    string            `json:"map"`
+	Fields map[string]string `json:"fields"`
+	Hint   OrderingHint      `json:"hint"`
+	// Time of the event on the clock of `bee fleet`, estimated from the boot time of the event
+	// and the last clock sample of the node, or the time of the node if it was never sampled
+	Time time.Time `json:"time"`
+	// Bound of the error of Time, events of different nodes closer than their skews may have
+	// happened in either order
+	Skew time.Duration `json:"skew"`
+	// Whether Time is estimated, the skew being unknown otherwise
+	Estimated bool `json:"estimated"`
+}
+
+// FleetMap describes a hash map reported by at least one node.
+type FleetMap struct {
+	Name  string   `json:"name"`
+	Keys  []string `json:"keys"`
+	Nodes int      `json:"nodes"`
+}
+
+// FleetView is a hash map merged across all the nodes reporting it.
+type FleetView struct {
+	Name  string     `json:"name"`
+	Keys  []string   `json:"keys"`
+	Nodes int        `json:"nodes"`
+	Rows  []FleetRow `json:"rows"`
+}
+
+// FleetRow aggregates the values of a single key across nodes.
+// Values which are not numeric are ignored.
+type FleetRow struct {
+	Key map[string]string `json:"key"`
+	// number of nodes reporting the key
+	Nodes int     `json:"nodes"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+}
+
+// PauseStrategy is how a program is paused.
+type PauseStrategy string
+
+const (
+	// DetachPauseStrategy detaches the programs from their kprobes and tracepoints, and
+	// attaches them again on resume. The maps, and their entries, are kept.
+	DetachPauseStrategy PauseStrategy = "detach"
+	// GatePauseStrategy sets the gate map declared by the program, which the program
+	// checks to do nothing while paused. The programs stay attached.
+	GatePauseStrategy PauseStrategy = "gate"
+)
+
+// ProgramState is whether the program of an agent is paused.
+type ProgramState struct {
+	Paused   bool          `json:"paused"`
+	Strategy PauseStrategy `json:"strategy"`
+	// Time the program was last paused or resumed at, zero if it never was
+	Since time.Time `json:"since"`
+	// Time the program is next resumed at by its schedule, if paused and scheduled
+	NextActivation *time.Time `json:"nextActivation,omitempty"`
+	// Time the current capture ends at, if the program is resumed by a trigger
+	CaptureUntil *time.Time `json:"captureUntil,omitempty"`
+	// Mode of the program, if it declares an enforce switch
+	Enforcement *EnforcementState `json:"enforcement,omitempty"`
+}
+
+// AttachedProbe is what a kprobe or kretprobe program was attached to.
+type AttachedProbe struct {
+	Program string `json:"program"`
+	// kprobe or kretprobe
+	Type string `json:"type"`
+	// Kernel functions, or globs of functions, the program is attached to
+	Patterns []string `json:"patterns"`
+	// Links the program is attached with, a kprobe.multi link or a kprobe per function
+	Link string `json:"link"`
+	// Functions the program was attached to
+	Functions []string `json:"functions"`
+	// Functions matched by the globs which could not be attached, with why, e.g. as they
+	// were inlined
+	Skipped map[string]string `json:"skipped,omitempty"`
+}
+
+// States of the ProbeStatus of a program
+const (
+	ProbeAttached = "attached"
+	// Not attached, as the program declares no hook, e.g. a tracepoint program outside of a
+	// `tracepoint/` section, or none of its network targets is found yet
+	ProbeSkipped = "skipped"
+	// Detached while the program is paused
+	ProbeDetached = "detached"
+	// Could not be attached once loaded, e.g. when resumed or to the interfaces of a pod
+	ProbeFailed = "failed"
+)
+
+// ProgramStatus is the attach state of the programs of a loaded program, and the errors it
+// ran into since, e.g. reading its maps, which are logged otherwise.
+type ProgramStatus struct {
+	// By program
+	Probes []ProbeStatus `json:"probes"`
+	// Most recent errors, the oldest first
+	Errors []RuntimeError `json:"errors"`
+	// Errors since the program was loaded, including the ones no longer kept
+	TotalErrors uint64 `json:"totalErrors"`
+}
+
+// ProbeStatus is the attach state of a program.
+type ProbeStatus struct {
+	Program string `json:"program"`
+	// kprobe, kretprobe, tracepoint, xdp or tc
+	Type string `json:"type"`
+	// ProbeAttached, ProbeSkipped, ProbeDetached or ProbeFailed
+	State string `json:"state"`
+	// Why the program is skipped or failed, or the last target it could not be attached to
+	Error string `json:"error,omitempty"`
+	// ID of the program in the kernel
+	ProgramID uint32 `json:"programId,omitempty"`
+	// IDs of the BPF links of the program, only kprobe.multi links have one: kprobes and
+	// tracepoints are attached with perf events, and XDP and TC programs with netlink
+	LinkIDs []uint32 `json:"linkIds,omitempty"`
+	// Last time the program was attached
+	AttachedAt *time.Time `json:"attachedAt,omitempty"`
+	// Kernel functions, tracepoint or interfaces the program is attached to
+	Targets []string `json:"targets,omitempty"`
+	// Functions matched by the globs which could not be attached, with why
+	Skipped map[string]string `json:"skipped,omitempty"`
+}
+
+// RuntimeError is an error of a program after it was loaded.
+type RuntimeError struct {
+	Time time.Time `json:"time"`
+	// What failed, e.g. the map read or the program attached
+	Source  string `json:"source"`
+	Message string `json:"message"`
+}
+
+// PackageOverrides are runtime overrides of the settings of a package, persisted across the
+// restarts of the agents running it, by the digest of the package. They are applied when the
+// program is next run.
+type PackageOverrides struct {
+	// Values of the parameters of the program, over the ones of `bee run --set`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// Maps the events of each sink are routed to, over the ones of `bee run --route`
+	Routes map[string][]string `json:"routes,omitempty"`
+	// Attributes added to the entries sent to the sinks, e.g. the team owning the data, over
+	// the ones of `bee run --attribute`. Keyed by KEY for all the sinks, or SINK:KEY for one of
+	// them, an empty value removes the attribute
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Whether the program is paused once attached, set when it is paused or resumed through
+	// the API
+	Paused bool `json:"paused,omitempty"`
+}
+
+// PolicyEntry is an entry of an enforcement map, e.g. an address of the deny list of an XDP
+// program. Its key and value are written as the ones of the seeds of settings maps: structs
+// are objects of their fields, char arrays strings and ipv4_addr fields IP addresses.
+type PolicyEntry struct {
+	Key interface{} `json:"key"`
+	// true, or 1, if omitted
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Policy is a version of the entries of the enforcement maps of a program, the settings maps
+// it declares in `.maps.settings` sections which are not seeded.
+type Policy struct {
+	// Incremented by every change, 0 until the policy is first changed
+	Version int `json:"version"`
+	// Entries of the maps, the maps missing having none
+	Maps map[string][]PolicyEntry `json:"maps,omitempty"`
+	// Time the version was created at, and by which client of the API
+	Time time.Time `json:"time"`
+	By   string    `json:"by,omitempty"`
+}
+
+// PolicyUpdate adds and removes entries of an enforcement map, replacing the values of the
+// keys added which are already in the map.
+type PolicyUpdate struct {
+	Map    string        `json:"map"`
+	Add    []PolicyEntry `json:"add,omitempty"`
+	Remove []interface{} `json:"remove,omitempty"`
+	// Version of the policy the update was made from, rejected with a 409 status if the
+	// policy changed since, applied to the current version if 0
+	Version int    `json:"version,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// PolicyAction is the kind of change of a PolicyAuditEntry.
+type PolicyAction string
+
+const (
+	PolicyUpdateAction   PolicyAction = "update"
+	PolicyRollbackAction PolicyAction = "rollback"
+)
+
+// PolicyAuditEntry records a change of the policy: who changed what when.
+type PolicyAuditEntry struct {
+	// Version of the policy the change created
+	Version int          `json:"version"`
+	Time    time.Time    `json:"time"`
+	By      string       `json:"by"`
+	Action  PolicyAction `json:"action"`
+	// Map and entries added and keys removed by an update
+	Map     string        `json:"map,omitempty"`
+	Added   []PolicyEntry `json:"added,omitempty"`
+	Removed []interface{} `json:"removed,omitempty"`
+	// Version restored by a rollback
+	RolledBack int    `json:"rolledBack,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+}
+
+// EnforcementMode is whether an enforcement program enforces its policy, e.g. drops the
+// packets of its deny list, or only observes what it would do.
+type EnforcementMode string
+
+const (
+	// ObserveMode only records what the program would deny, the default
+	ObserveMode EnforcementMode = "observe"
+	// EnforceMode denies what the policy of the program denies
+	EnforceMode EnforcementMode = "enforce"
+)
+
+// EnforcementState is the mode of a program declaring an enforce switch, and of its scopes.
+type EnforcementState struct {
+	// Mode of the program, and of the scopes not set
+	Mode EnforcementMode `json:"mode"`
+	// Modes set for the cgroups the program is scoped to, by the selector of `bee run --cgroup`
+	Scopes map[string]EnforcementMode `json:"scopes,omitempty"`
+	// Scopes the mode can be set for, empty if only for the whole program
+	Available []string `json:"available,omitempty"`
+	// Time the mode was last changed at, zero if it never was
+	Since time.Time `json:"since"`
+}
+
+// DebugLogLevel is how much of the load of a program is logged, each level logging what the
+// previous ones do.
+type DebugLogLevel string
+
+const (
+	// DebugLogOff logs nothing
+	DebugLogOff DebugLogLevel = "off"
+	// DebugLogInfo logs the steps of the load and the verifier statistics of each program,
+	// and why it was rejected if it was
+	DebugLogInfo DebugLogLevel = "info"
+	// DebugLogVerifier logs the instructions the verifier went through for each program
+	DebugLogVerifier DebugLogLevel = "verifier"
+	// DebugLogVerbose also logs the state of the registers at each instruction
+	DebugLogVerbose DebugLogLevel = "verbose"
+)
+
+// DebugLogEntry is a line logged while loading a program.
+type DebugLogEntry struct {
+	Time time.Time `json:"time"`
+	// Level the line is logged at, DebugLogInfo for the steps of the load and the verifier
+	// statistics, DebugLogVerifier and DebugLogVerbose for the verifier logs
+	Level DebugLogLevel `json:"level"`
+	// Program the line is about, empty if about the whole collection
+	Program string `json:"program,omitempty"`
+	Message string `json:"message"`
+	// Error is set for the lines logged when the load failed
+	Error bool `json:"error,omitempty"`
+}
@@ -0,0 +1,8 @@
+// Package v1 holds the stable types of the bumblebee Go API: the OCI package format,
+// the map entries streamed by the loader, and the wire types of the agent HTTP API.
+//
+// Within a major version, types are only changed in backwards compatible ways: fields and
+// constants may be added, but are never removed, renamed or change meaning.
+// The other packages of this module are implementations, which may change between minor releases.
+// When a type moves here, its previous name is kept as a deprecated alias for at least one minor release.
+package v1
@@ -0,0 +1,30 @@
+//go:build ignore
+// +build ignore
+
+// gen_openapi writes the OpenAPI document of the routes to openapi.json, and the Python
+// client generated from it to clients/python.
+package main
+
+import (
+	"io/ioutil"
+	"log"
+
+	"github.com/solo-io/bumblebee/pkg/agent"
+)
+
+func main() {
+	doc, err := agent.OpenAPI()
+	if err != nil {
+		log.Fatalf("could not generate the OpenAPI document: %v", err)
+	}
+	if err := ioutil.WriteFile("openapi.json", doc, 0644); err != nil {
+		log.Fatal(err)
+	}
+	client, err := agent.PythonClient(doc)
+	if err != nil {
+		log.Fatalf("could not generate the Python client: %v", err)
+	}
+	if err := ioutil.WriteFile("../../clients/python/bumblebee_client.py", client, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
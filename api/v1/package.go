@@ -0,0 +1,461 @@
+package v1
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type EbpfPackage struct {
+	// File content for eBPF compiled ELF file
+	ProgramFileBytes []byte
+	// Other ELF objects of the package, e.g. programs loaded separately from the main one
+	Objects []EbpfObject
+	// BTF of the kernel types the programs were compiled against, for kernels which don't
+	// expose their own, if any
+	BTF []byte
+	// Source code of the programs as a gzipped tarball, if any
+	Source []byte
+	// Sample events of the maps of the programs, with the entries they are expected to be
+	// decoded to, if any
+	Fixtures []Fixture
+	// Human readable description of the program
+	Description string
+	// Author(s) of the program
+	Authors string
+	// Platform this was built on
+	Platform *ocispec.Platform
+	// Digest of the manifest the package was pulled from
+	Digest digest.Digest
+	// Channel the package was pulled from, if any
+	Channel Channel
+	// Builder image the program was compiled in, pinned by digest, if known
+	BuilderImage string
+	// Oldest kernel release the package can be loaded on, when pulled from a multi-variant
+	// image, e.g. 4.18
+	MinKernelVersion string
+	// Names of the transforms applied to the package when pulled, in the order they ran
+	Transforms []string
+	// Nested config object
+	EbpfConfig
+}
+
+// EbpfPackageInfo describes a package from its manifest and config, without its programs.
+type EbpfPackageInfo struct {
+	// Manifest of the package, for multi-variant images the one of the selected variant
+	Manifest ocispec.Descriptor
+	// Image index of multi-variant images, and the manifests of all their variants
+	Index    *ocispec.Descriptor
+	Variants []ocispec.Descriptor
+	// Annotations of the manifest, e.g. the description and authors of the package
+	Annotations map[string]string
+	Config      ocispec.Descriptor
+	// Layers of the package, the main program first
+	Layers []ocispec.Descriptor
+	// Parsed config of the package
+	EbpfConfig
+}
+
+// Size returns the size of the blobs pulled with the package.
+func (i *EbpfPackageInfo) Size() int64 {
+	size := i.Manifest.Size + i.Config.Size
+	for _, layer := range i.Layers {
+		size += layer.Size
+	}
+	return size
+}
+
+// EbpfObject is a named ELF object of a package, next to its main program.
+type EbpfObject struct {
+	// Name of the object, unique in the package, e.g. tcpconnect_v6
+	Name  string
+	Bytes []byte
+	// Annotations of the layer of the object
+	Annotations map[string]string
+}
+
+// Fixture is a sample of the raw events of the ring buffers and perf event arrays of a
+// package, with the entries the publisher expects them to be decoded to, so consumers can
+// check their pipeline against them.
+type Fixture struct {
+	Name string
+	// JSON lines of the raw events, as recorded by `bee run --record`
+	Records []byte
+	// JSON lines of the expected entries, as written to golden files by `bee replay`
+	Golden []byte
+}
+
+// EbpfPackageVariant is a package of a multi-variant image, for the architecture of its
+// Platform and the kernels from a minimum release on, e.g. a build without BTF for older
+// kernels next to the default one.
+type EbpfPackageVariant struct {
+	Package *EbpfPackage
+	// Oldest kernel release the package can be loaded on, e.g. 4.18, any if empty
+	MinKernelVersion string
+}
+
+// EbpfConfig is the config of a package, declared in the config file of its program in
+// the project manifest.
+type EbpfConfig struct {
+	// Authors of the package, annotated as the authors of the image if not set by the build
+	Authors []Author `json:"authors,omitempty" yaml:"authors,omitempty"`
+	// Descriptions of the programs, by the name of their function
+	Programs []ProgramDescription `json:"programs,omitempty" yaml:"programs,omitempty"`
+	// Descriptions of the maps, and of the metrics exported from them
+	Maps []MapDescription `json:"maps,omitempty" yaml:"maps,omitempty"`
+	// Hosts the package can be loaded on
+	Constraints *PlatformConstraints `json:"constraints,omitempty" yaml:"constraints,omitempty"`
+	// Test cases of the programs, run by `bee test`
+	Tests []ProgramTest `json:"tests,omitempty" yaml:"tests,omitempty"`
+	// Groups of programs of which exactly one is loaded, selected by the kernel release
+	ProbeVariants []ProbeVariantGroup `json:"probeVariants,omitempty" yaml:"probeVariants,omitempty"`
+	// Kprobe and kretprobe programs attached to many kernel functions rather than the one of
+	// their section
+	MultiProbes []MultiProbe `json:"multiProbes,omitempty" yaml:"multiProbes,omitempty"`
+	// Whether the programs can drop packets or deny syscalls, so their blast radius must be
+	// confirmed before they are loaded
+	Enforcing bool `json:"enforcing,omitempty" yaml:"enforcing,omitempty"`
+	// Kernels the package was loaded and attached on by `bee vmtest`, attached to the
+	// package once built rather than declared
+	Compatibility []KernelCompatibility `json:"compatibility,omitempty" yaml:"-"`
+}
+
+// Author of a package.
+type Author struct {
+	Name  string `json:"name" yaml:"name"`
+	Email string `json:"email,omitempty" yaml:"email,omitempty"`
+	URL   string `json:"url,omitempty" yaml:"url,omitempty"`
+}
+
+// String formats the author as in the authors annotation of images, e.g.
+// "Jane Doe <jane@example.com>".
+func (a Author) String() string {
+	if a.Email == "" {
+		return a.Name
+	}
+	return fmt.Sprintf("%s <%s>", a.Name, a.Email)
+}
+
+// ProgramDescription describes a program of a package.
+type ProgramDescription struct {
+	// Name of the function of the program
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// Object the program is in, the main program if empty
+	Object string `json:"object,omitempty" yaml:"object,omitempty"`
+}
+
+// MapDescription describes a map of a package.
+type MapDescription struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// Metric exported from the map, if any
+	Metric *MetricDescription `json:"metric,omitempty" yaml:"metric,omitempty"`
+	// Layouts of the keys and values, extracted from the BTF of the program when packaged
+	Key   *TypeDescription `json:"key,omitempty" yaml:"-"`
+	Value *TypeDescription `json:"value,omitempty" yaml:"-"`
+}
+
+// TypeDescription describes the key or value type of a map, e.g. struct event.
+type TypeDescription struct {
+	// C name of the type, e.g. struct event or u32
+	Name   string             `json:"name"`
+	Size   int                `json:"size"`
+	Fields []FieldDescription `json:"fields,omitempty"`
+}
+
+// FieldDescription describes a field of a struct or union.
+type FieldDescription struct {
+	Name string `json:"name"`
+	// C type of the field, e.g. char[16]
+	Type string `json:"type"`
+	// Offset in bytes within the struct
+	Offset uint32 `json:"offset"`
+	// Size in bits of the field, if it is a bitfield
+	BitfieldSize uint32 `json:"bitfieldSize,omitempty"`
+	// Comment of the field in the source of the program, if packaged
+	Comment string `json:"comment,omitempty"`
+}
+
+// MetricDescription describes the metric exported from a map.
+type MetricDescription struct {
+	// counter or gauge, as declared by the section of the map
+	Type string `json:"type" yaml:"type"`
+	Help string `json:"help,omitempty" yaml:"help,omitempty"`
+	// Unit of the values, e.g. bytes
+	Unit string `json:"unit,omitempty" yaml:"unit,omitempty"`
+}
+
+// ProbeVariantGroup is a group of programs hooking the same event on different kernels, e.g.
+// a tracepoint on the kernels which have it and a kprobe fallback on the older ones. The
+// loader keeps the first variant whose range has the kernel release, and removes the others.
+type ProbeVariantGroup struct {
+	Name     string         `json:"name" yaml:"name"`
+	Variants []ProbeVariant `json:"variants" yaml:"variants"`
+}
+
+// ProbeVariant is a program of a group, loaded on a range of kernel releases.
+type ProbeVariant struct {
+	// Name of the function of the program
+	Program string `json:"program" yaml:"program"`
+	// Oldest kernel release the variant is loaded on, e.g. 5.8, any if empty
+	MinKernelVersion string `json:"minKernelVersion,omitempty" yaml:"minKernelVersion,omitempty"`
+	// Kernel release the variant is no longer loaded on from, e.g. 5.8, none if empty
+	MaxKernelVersion string `json:"maxKernelVersion,omitempty" yaml:"maxKernelVersion,omitempty"`
+}
+
+// MultiProbe attaches a kprobe or kretprobe program to a list of kernel functions. On the
+// kernels supporting kprobe.multi links, 5.18+ built with CONFIG_FPROBE, the program is
+// attached to all of them with a single link, and with a kprobe per function otherwise.
+type MultiProbe struct {
+	// Name of the function of the program
+	Program string `json:"program" yaml:"program"`
+	// Kernel functions, or globs of the functions the kernel can probe, e.g. tcp_v4_*
+	Symbols []string `json:"symbols" yaml:"symbols"`
+}
+
+// PlatformConstraints are the hosts a package can be loaded on.
+type PlatformConstraints struct {
+	// Architectures the programs were built for, e.g. x86_64, any if empty
+	Architectures []string `json:"architectures,omitempty" yaml:"architectures,omitempty"`
+	// Oldest kernel release the programs can be loaded on, e.g. 5.8
+	MinKernelVersion string `json:"minKernelVersion,omitempty" yaml:"minKernelVersion,omitempty"`
+	// Whether the kernel must expose its BTF, unless the package ships one
+	RequiresBTF bool `json:"requiresBTF,omitempty" yaml:"requiresBTF,omitempty"`
+	// Features of the host beyond the kernel the programs need, e.g. cgroup-v2 or
+	// containerd-socket, checked before they are loaded
+	Features []HostFeature `json:"features,omitempty" yaml:"features,omitempty"`
+}
+
+// HostFeatures returns the features the package requires of its host, if any.
+func (c EbpfConfig) HostFeatures() []HostFeature {
+	if c.Constraints == nil {
+		return nil
+	}
+	return c.Constraints.Features
+}
+
+// HostFeature is something the host provides besides the kernel, which the programs of a
+// package rely on, e.g. a container runtime to resolve the containers of their events.
+type HostFeature string
+
+const (
+	// The unified cgroup v2 hierarchy, which bpf_get_current_cgroup_id returns cgroups of
+	HostFeatureCgroupV2 HostFeature = "cgroup-v2"
+	// The socket of containerd, at /run/containerd/containerd.sock
+	HostFeatureContainerdSocket HostFeature = "containerd-socket"
+	// The socket of docker, at /var/run/docker.sock
+	HostFeatureDockerSocket HostFeature = "docker-socket"
+	// The socket of cri-o, at /var/run/crio/crio.sock
+	HostFeatureCRIOSocket HostFeature = "crio-socket"
+	// The hardware performance counters of the CPU, e.g. the PMU of x86 or of arm64, missing
+	// from most virtual machines
+	HostFeaturePMU HostFeature = "pmu"
+	// The BTF of the kernel, at /sys/kernel/btf/vmlinux
+	HostFeatureBTF HostFeature = "btf"
+	// Tracefs, mounted at /sys/kernel/tracing or /sys/kernel/debug/tracing
+	HostFeatureTracefs HostFeature = "tracefs"
+	// The BPF LSM, enabled in the lsm= boot parameter
+	HostFeatureBPFLSM HostFeature = "bpf-lsm"
+	// Prefix of the features which are a file of the host, e.g. path:/dev/kvm
+	HostFeaturePathPrefix = "path:"
+)
+
+// HostFeatures are the features of hosts known to bee, other than paths.
+var HostFeatures = []HostFeature{
+	HostFeatureCgroupV2,
+	HostFeatureContainerdSocket,
+	HostFeatureDockerSocket,
+	HostFeatureCRIOSocket,
+	HostFeaturePMU,
+	HostFeatureBTF,
+	HostFeatureTracefs,
+	HostFeatureBPFLSM,
+}
+
+// Validate checks the feature is known, or an absolute path.
+func (f HostFeature) Validate() error {
+	if p := strings.TrimPrefix(string(f), HostFeaturePathPrefix); p != string(f) {
+		if !path.IsAbs(p) {
+			return fmt.Errorf("host feature %s must be an absolute path", f)
+		}
+		return nil
+	}
+	for _, known := range HostFeatures {
+		if f == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown host feature %q, must be a path:/file or one of %s", f, joinFeatures(HostFeatures))
+}
+
+func joinFeatures(features []HostFeature) string {
+	names := make([]string, len(features))
+	for i, f := range features {
+		names[i] = string(f)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Prerequisite is a feature a package requires of its host, and whether the host has it.
+type Prerequisite struct {
+	Feature HostFeature `json:"feature"`
+	// What the feature is, e.g. "the unified cgroup v2 hierarchy"
+	Description string `json:"description"`
+	Met         bool   `json:"met"`
+	// Where the feature was found, or why it was not
+	Detail string `json:"detail,omitempty"`
+}
+
+// Validate checks the config is consistent.
+func (c EbpfConfig) Validate() error {
+	for _, a := range c.Authors {
+		if a.Name == "" {
+			return fmt.Errorf("authors must have a name")
+		}
+	}
+	seen := map[string]bool{}
+	for _, p := range c.Programs {
+		if p.Name == "" {
+			return fmt.Errorf("programs must have a name")
+		}
+		if seen["program "+p.Name] {
+			return fmt.Errorf("program %s is described twice", p.Name)
+		}
+		seen["program "+p.Name] = true
+	}
+	for _, m := range c.Maps {
+		if m.Name == "" {
+			return fmt.Errorf("maps must have a name")
+		}
+		if seen["map "+m.Name] {
+			return fmt.Errorf("map %s is described twice", m.Name)
+		}
+		seen["map "+m.Name] = true
+		if m.Metric != nil && m.Metric.Type != "counter" && m.Metric.Type != "gauge" {
+			return fmt.Errorf("metric of map %s must be a counter or a gauge, not %q", m.Name, m.Metric.Type)
+		}
+	}
+	for _, g := range c.ProbeVariants {
+		if g.Name == "" {
+			return fmt.Errorf("probe variant groups must have a name")
+		}
+		if seen["probe variants "+g.Name] {
+			return fmt.Errorf("probe variant group %s is declared twice", g.Name)
+		}
+		seen["probe variants "+g.Name] = true
+		if len(g.Variants) == 0 {
+			return fmt.Errorf("probe variant group %s has no variants", g.Name)
+		}
+		for _, v := range g.Variants {
+			if v.Program == "" {
+				return fmt.Errorf("the variants of probe variant group %s must have a program", g.Name)
+			}
+			if seen["variant "+v.Program] {
+				return fmt.Errorf("program %s is a probe variant twice", v.Program)
+			}
+			seen["variant "+v.Program] = true
+		}
+	}
+	if c.Constraints != nil {
+		for _, f := range c.Constraints.Features {
+			if err := f.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	for _, p := range c.MultiProbes {
+		if p.Program == "" {
+			return fmt.Errorf("multi probes must have a program")
+		}
+		if seen["multi probe "+p.Program] {
+			return fmt.Errorf("program %s is a multi probe twice", p.Program)
+		}
+		seen["multi probe "+p.Program] = true
+		if len(p.Symbols) == 0 {
+			return fmt.Errorf("multi probe %s has no symbols", p.Program)
+		}
+		for _, symbol := range p.Symbols {
+			if _, err := path.Match(symbol, ""); err != nil || symbol == "" {
+				return fmt.Errorf("multi probe %s has an invalid symbol %q", p.Program, symbol)
+			}
+		}
+	}
+	return nil
+}
+
+// KernelCompatibility is whether the programs of a package could be loaded and attached
+// on a kernel.
+type KernelCompatibility struct {
+	// Name of the kernel in the test matrix, e.g. 5.10
+	Kernel string `json:"kernel"`
+	// Release reported by the kernel, e.g. 5.10.162
+	Release    string `json:"release,omitempty"`
+	Compatible bool   `json:"compatible"`
+	// Programs which were attached
+	Programs []string `json:"programs,omitempty"`
+	// Why the package was not compatible
+	Error string `json:"error,omitempty"`
+}
+
+// ProgramTest runs a program of the package once against a synthetic input with
+// BPF_PROG_TEST_RUN, and checks its return value and the resulting entries of its maps.
+// Only the program types supported by the kernel can be run, e.g. XDP, TC and socket
+// filters. Bytes are hex encoded, in the byte order of the machine the test runs on.
+type ProgramTest struct {
+	Name string `json:"name" yaml:"name"`
+	// Name of the function of the program
+	Program string `json:"program" yaml:"program"`
+	// Input of the program, e.g. a packet for XDP and TC programs
+	Input string `json:"input,omitempty" yaml:"input,omitempty"`
+	// Entries set in the maps before the program runs
+	Setup []TestMapEntry `json:"setup,omitempty" yaml:"setup,omitempty"`
+	// Return value of the program, e.g. 1 for XDP_DROP, not checked if unset
+	Return *uint32 `json:"return,omitempty" yaml:"return,omitempty"`
+	// Entries expected in the maps once the program ran
+	Expect []TestMapEntry `json:"expect,omitempty" yaml:"expect,omitempty"`
+}
+
+// TestMapEntry is an entry of a map, set before a ProgramTest runs or expected after.
+type TestMapEntry struct {
+	Map   string `json:"map" yaml:"map"`
+	Key   string `json:"key" yaml:"key"`
+	Value string `json:"value,omitempty" yaml:"value,omitempty"`
+	// Expect the key to be missing from the map
+	Absent bool `json:"absent,omitempty" yaml:"absent,omitempty"`
+}
+
+// Channel is a tag tracking the current release of a package at a given maturity.
+// Packages are promoted from one channel to the next: nightly -> beta -> stable.
+type Channel string
+
+const (
+	ChannelNightly Channel = "nightly"
+	ChannelBeta    Channel = "beta"
+	ChannelStable  Channel = "stable"
+)
+
+var channelOrder = []Channel{ChannelNightly, ChannelBeta, ChannelStable}
+
+func ParseChannel(s string) (Channel, error) {
+	for _, c := range channelOrder {
+		if string(c) == s {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("unknown channel %q, must be one of %v", s, channelOrder)
+}
+
+// PromotedFrom returns the channel packages must be on before being promoted to this one.
+// It returns false for the first channel, which any package can be promoted to.
+func (c Channel) PromotedFrom() (Channel, bool) {
+	for i, ch := range channelOrder {
+		if ch == c && i > 0 {
+			return channelOrder[i-1], true
+		}
+	}
+	return "", false
+}
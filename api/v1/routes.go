@@ -0,0 +1,271 @@
+package v1
+
+import (
+	_ "embed"
+)
+
+// OpenAPIPath returns the OpenAPI 3 document of the API
+const OpenAPIPath = APIPrefix + "/openapi.json"
+
+// OpenAPIDocument describes the Routes of the agent and fleet APIs, it is generated from
+// them with `go generate ./api/v1`.
+//
+//go:generate go run gen_openapi.go
+//go:embed openapi.json
+var OpenAPIDocument []byte
+
+// Route is an endpoint of the agent or fleet API, as described by the OpenAPI document.
+type Route struct {
+	Method  string
+	Path    string
+	Summary string
+	// Served by `bee fleet` rather than by the agent of `bee run`
+	Fleet bool
+	// Only served when the agent is run with --api-control
+	Control bool
+	// Role required of the API key when the agent or the fleet is run with --api-keys, empty if
+	// none is
+	Role   string
+	Params []RouteParam
+	// Value of the type of the JSON request body, if any
+	Request interface{}
+	// Values of the types of the response, a slice for a JSON array, several if it depends
+	// on the parameters
+	Responses []interface{}
+	// The response is a stream of newline delimited JSON values
+	Stream bool
+	// Probe of the health of the agent, responding with a 503 status when failing
+	Probe bool
+}
+
+// RouteParam is a query parameter of a Route.
+type RouteParam struct {
+	Name        string
+	Description string
+}
+
+// Routes of the agent and fleet APIs.
+var Routes = []Route{
+	{
+		Method:    "GET",
+		Path:      OpenAPIPath,
+		Summary:   "Return the OpenAPI document of the API",
+		Responses: []interface{}{map[string]interface{}{}},
+	},
+	{
+		Method:    "GET",
+		Path:      WatchPath,
+		Summary:   "Stream the maps of the program and their entries",
+		Role:      "read",
+		Responses: []interface{}{Event{}},
+		Stream:    true,
+	},
+	{
+		Method:    "GET",
+		Path:      MapsPath,
+		Summary:   "List the maps of the program",
+		Role:      "read",
+		Responses: []interface{}{[]MapInfo{}},
+	},
+	{
+		Method:    "GET",
+		Path:      ProbesPath,
+		Summary:   "List the kernel functions the kprobe and kretprobe programs are attached to, once their globs are expanded",
+		Role:      "read",
+		Responses: []interface{}{[]AttachedProbe{}},
+	},
+	{
+		Method:    "GET",
+		Path:      StatusPath,
+		Summary:   "Return the attach state of the programs and the recent errors of the program",
+		Role:      "read",
+		Responses: []interface{}{ProgramStatus{}},
+	},
+	{
+		Method:    "GET",
+		Path:      ProgramPath,
+		Summary:   "Return whether the program is paused",
+		Control:   true,
+		Role:      "read",
+		Responses: []interface{}{ProgramState{}},
+	},
+	{
+		Method:    "POST",
+		Path:      PausePath,
+		Summary:   "Pause the program",
+		Control:   true,
+		Role:      "admin",
+		Responses: []interface{}{ProgramState{}},
+	},
+	{
+		Method:    "POST",
+		Path:      ResumePath,
+		Summary:   "Resume the program",
+		Control:   true,
+		Role:      "admin",
+		Responses: []interface{}{ProgramState{}},
+	},
+	{
+		Method:  "POST",
+		Path:    TriggerPath,
+		Summary: "Start a capture of the program, when run with a capture config",
+		Control: true,
+		Role:    "admin",
+		Params: []RouteParam{
+			{Name: "reason", Description: "Reason recorded with the capture"},
+		},
+		Responses: []interface{}{ProgramState{}},
+	},
+	{
+		Method:    "GET",
+		Path:      OverridesPath,
+		Summary:   "Return the overrides persisted for the package of the program, when run with --overrides",
+		Control:   true,
+		Role:      "read",
+		Responses: []interface{}{PackageOverrides{}},
+	},
+	{
+		Method:    "POST",
+		Path:      SetOverridesPath,
+		Summary:   "Replace the overrides of the package of the program, applied when it is next run",
+		Control:   true,
+		Role:      "admin",
+		Request:   PackageOverrides{},
+		Responses: []interface{}{PackageOverrides{}},
+	},
+	{
+		Method:    "POST",
+		Path:      ClearOverridesPath,
+		Summary:   "Remove the overrides of the package of the program",
+		Control:   true,
+		Role:      "admin",
+		Responses: []interface{}{PackageOverrides{}},
+	},
+	{
+		Method:    "GET",
+		Path:      PolicyPath,
+		Summary:   "Return the current policy of the enforcement maps of the program",
+		Control:   true,
+		Role:      "read",
+		Responses: []interface{}{Policy{}},
+	},
+	{
+		Method:    "POST",
+		Path:      UpdatePolicyPath,
+		Summary:   "Add and remove entries of an enforcement map, creating a new version of the policy",
+		Control:   true,
+		Role:      "admin",
+		Request:   PolicyUpdate{},
+		Responses: []interface{}{Policy{}},
+	},
+	{
+		Method:  "POST",
+		Path:    RollbackPolicyPath,
+		Summary: "Restore a previous version of the policy, as a new version",
+		Control: true,
+		Role:    "admin",
+		Params: []RouteParam{
+			{Name: "version", Description: "Version of the policy to restore"},
+			{Name: "comment", Description: "Comment recorded in the audit log"},
+		},
+		Responses: []interface{}{Policy{}},
+	},
+	{
+		Method:  "GET",
+		Path:    PolicyVersionsPath,
+		Summary: "List the versions of the policy kept, the latest first, or return one",
+		Control: true,
+		Role:    "read",
+		Params: []RouteParam{
+			{Name: "version", Description: "Version of the policy to return, the versions are listed if empty"},
+		},
+		Responses: []interface{}{[]Policy{}, Policy{}},
+	},
+	{
+		Method:    "GET",
+		Path:      PolicyAuditPath,
+		Summary:   "List who changed the policy, what and when, the oldest change first",
+		Control:   true,
+		Role:      "read",
+		Responses: []interface{}{[]PolicyAuditEntry{}},
+	},
+	{
+		Method:    "GET",
+		Path:      EnforcementPath,
+		Summary:   "Return whether the program enforces its policy or only observes, as a whole and for its scopes, when it declares a bee_enforce map",
+		Control:   true,
+		Role:      "read",
+		Responses: []interface{}{EnforcementState{}},
+	},
+	{
+		Method:  "POST",
+		Path:    EnforcementModePath,
+		Summary: "Switch the program, or one of its scopes, between observing and enforcing",
+		Control: true,
+		Role:    "admin",
+		Params: []RouteParam{
+			{Name: "mode", Description: "observe or enforce"},
+			{Name: "scope", Description: "Selector of the cgroups the program is scoped to, as given to --cgroup, the whole program if empty"},
+		},
+		Responses: []interface{}{EnforcementState{}},
+	},
+	{
+		Method:    "GET",
+		Path:      DebugLogPath,
+		Summary:   "Stream the debug logs of the loads of the program, when run with --debug-log-level",
+		Role:      "read",
+		Responses: []interface{}{DebugLogEntry{}},
+		Stream:    true,
+	},
+	{
+		Method:    "GET",
+		Path:      ClockPath,
+		Summary:   "Return the clocks of the node, to estimate its clock offset",
+		Role:      "read",
+		Responses: []interface{}{ClockStatus{}},
+	},
+	{
+		Method:    "GET",
+		Path:      HealthzPath,
+		Summary:   "Return whether the agent is live, e.g. for Kubernetes liveness probes",
+		Responses: []interface{}{HealthReport{}},
+		Probe:     true,
+	},
+	{
+		Method:    "GET",
+		Path:      ReadyzPath,
+		Summary:   "Return whether the agent is ready, its program loaded, its registry reachable and its sinks keeping up",
+		Responses: []interface{}{HealthReport{}},
+		Probe:     true,
+	},
+	{
+		Method:  "GET",
+		Path:    FleetPath,
+		Summary: "List the hash maps of the fleet, or merge one across the nodes",
+		Fleet:   true,
+		Role:    "read",
+		Params: []RouteParam{
+			{Name: "map", Description: "Hash map to merge, the maps are listed if empty"},
+		},
+		Responses: []interface{}{[]FleetMap{}, FleetView{}},
+	},
+	{
+		Method:    "GET",
+		Path:      FleetClocksPath,
+		Summary:   "List the clocks of the nodes, as last estimated",
+		Fleet:     true,
+		Role:      "read",
+		Responses: []interface{}{[]NodeClock{}},
+	},
+	{
+		Method:  "GET",
+		Path:    FleetTimelinePath,
+		Summary: "List the recent events of the ring buffers of the nodes, in the order they happened",
+		Fleet:   true,
+		Role:    "read",
+		Params: []RouteParam{
+			{Name: "map", Description: "Ring buffer whose events are listed, all of them if empty"},
+		},
+		Responses: []interface{}{[]TimelineEvent{}},
+	},
+}
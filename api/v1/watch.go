@@ -0,0 +1,50 @@
+package v1
+
+// KvPair is a single entry of a map, decoded to strings.
+type KvPair struct {
+	Key   map[string]string
+	Value string
+	Hash  uint64
+}
+
+// MapEntry is a KvPair of a named map.
+type MapEntry struct {
+	Name  string
+	Entry KvPair
+}
+
+// MapWatcher receives the maps of a running program, and their entries.
+type MapWatcher interface {
+	NewRingBuf(name string, keys []string)
+	NewHashMap(name string, keys []string)
+	SendEntry(entry MapEntry)
+	Close()
+}
+
+// DeletingWatcher is implemented by the watchers keeping the current entries of hash maps,
+// e.g. the agent server, which are told once an entry is gone from its map, e.g. evicted as
+// stale, so they drop it too.
+type DeletingWatcher interface {
+	// DeleteEntry receives the entry removed, only its key being set
+	DeleteEntry(entry MapEntry)
+}
+
+// BatchWatcher receives the entries of the maps of a running program in batches, e.g. to send
+// them in a single request. It is adapted to a MapWatcher with loader.NewBatcher, and a
+// MapWatcher to a BatchWatcher with loader.NewSingleEntryAdapter.
+type BatchWatcher interface {
+	NewRingBuf(name string, keys []string)
+	NewHashMap(name string, keys []string)
+	// WriteBatch receives the entries in the order they were sent, the batch is not used
+	// again once it returns
+	WriteBatch(entries []MapEntry)
+	Close()
+}
+
+// HealthReporter is implemented by the watchers sending the entries to a remote sink, e.g. a
+// webhook, which report whether the sink is reachable, so the entries can be spooled while it
+// is not.
+type HealthReporter interface {
+	// Healthy returns false once entries could not be delivered, until some are
+	Healthy() bool
+}
@@ -2,8 +2,12 @@
 set -eux
 
 CFLAGS=${CFLAGS:-}
+# libbpf name of the architecture to compile for, e.g. x86 or arm64
+TARGET_ARCH=${TARGET_ARCH:-x86}
 
-clang-13 -g -O2 -target bpf -D__TARGET_ARCH_x86 ${CFLAGS} -Wall -c $1 -o $2
+# headers of the target architecture (e.g. its vmlinux.h) take precedence over the default ones,
+# include paths passed in CFLAGS take precedence over both
+clang-13 -g -O2 -target bpf -D__TARGET_ARCH_${TARGET_ARCH} ${CFLAGS} -I/usr/local/include/${TARGET_ARCH} -Wall -c $1 -o $2
 
 # strip debug sections (see: https://github.com/libbpf/libbpf-bootstrap/blob/94000ca67c5e7be4741c09c435c9ae1777822378/examples/c/Makefile#L65)
 llvm-strip-13 -g $2
@@ -6,4 +6,8 @@ typedef u32 ipv4_addr;
 // A basic ipv6 address represented as a u32
 typedef u32 ipv6_addr;
 // A duration in NS stored as a u64
-typedef u64 duration;
\ No newline at end of file
+typedef u64 duration;
+// A W3C trace context trace ID, e.g. parsed from a traceparent header, printed as hex
+typedef u8 trace_id[16];
+// A W3C trace context span ID, e.g. parsed from a traceparent header, printed as hex
+typedef u8 span_id[8];
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/solo-io/bumblebee/pkg/benchmark"
+)
+
+// Compares the benchmark results of a change with the ones of its base, and fails if
+// they regressed more than the budget.
+func main() {
+	budgetFile := flag.String("budget", "ci/perf-budget.yaml", "file of the regressions allowed")
+	baselineFile := flag.String("baseline", "", "output of go test -bench on the base of the change")
+	currentFile := flag.String("current", "", "output of go test -bench on the change")
+	flag.Parse()
+
+	if err := check(*budgetFile, *baselineFile, *currentFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func check(budgetFile, baselineFile, currentFile string) error {
+	budget, err := benchmark.LoadPerfBudget(budgetFile)
+	if err != nil {
+		return err
+	}
+	baseline, err := readResults(baselineFile)
+	if err != nil {
+		return err
+	}
+	current, err := readResults(currentFile)
+	if err != nil {
+		return err
+	}
+	regressions := budget.Check(baseline, current)
+	for _, r := range regressions {
+		fmt.Println(r)
+	}
+	if len(regressions) > 0 {
+		return fmt.Errorf("%d metrics regressed more than the budget", len(regressions))
+	}
+	fmt.Printf("%d benchmarks within the budget\n", len(current))
+	return nil
+}
+
+func readResults(path string) (map[string]benchmark.Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return benchmark.ParseResults(f)
+}
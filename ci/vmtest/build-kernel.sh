@@ -0,0 +1,30 @@
+#!/usr/bin/env bash
+# Builds a kernel image with BPF support for bee vmtest: build-kernel.sh VERSION OUTDIR
+# The image is written to OUTDIR/VERSION/bzImage, and kept if already built.
+set -euo pipefail
+
+version=$1
+outdir=$2/$version
+if [[ -f $outdir/bzImage ]]; then
+  exit 0
+fi
+
+major=${version%%.*}
+src=$(mktemp -d)
+trap 'rm -rf "$src"' EXIT
+curl -sSfL "https://cdn.kernel.org/pub/linux/kernel/v$major.x/linux-$version.tar.xz" | tar -xJ -C "$src" --strip-components 1
+
+cd "$src"
+make defconfig kvm_guest.config
+# BPF, BTF for CO-RE, probes and tracepoints, and the 9p and virtio filesystems vmtest
+# shares the filesystem of the host with
+./scripts/config \
+  -e BPF -e BPF_SYSCALL -e BPF_JIT -e BPF_EVENTS -e DEBUG_INFO -e DEBUG_INFO_BTF \
+  -e KPROBES -e KPROBE_EVENTS -e UPROBE_EVENTS -e TRACEPOINTS -e FTRACE -e FUNCTION_TRACER \
+  -e NET_9P -e NET_9P_VIRTIO -e 9P_FS -e 9P_FS_POSIX_ACL -e FUSE_FS -e VIRTIO_FS \
+  -e VIRTIO_PCI -e VIRTIO_CONSOLE -e NET_CLS_BPF -e NET_ACT_BPF -e XDP_SOCKETS
+make olddefconfig
+make -j"$(nproc)" bzImage
+
+mkdir -p "$outdir"
+cp arch/x86/boot/bzImage "$outdir/bzImage"
@@ -0,0 +1,55 @@
+"""Land the map entries streamed by an agent in a pandas DataFrame.
+
+    $ bee run --api-port 9092 ghcr.io/solo-io/bumblebee/tcpconnect:$(bee version)
+    $ python3 events_dataframe.py localhost:9092 --seconds 30
+
+Each row is an entry update of a map, with a column per key label, the value, and the
+time it was received at. Requires pandas.
+"""
+
+import argparse
+import os
+import sys
+import time
+
+import pandas as pd
+
+sys.path.insert(0, os.path.join(os.path.dirname(__file__), ".."))
+from bumblebee_client import Client  # noqa: E402
+
+
+def collect(client: Client, seconds: float) -> pd.DataFrame:
+    rows = []
+    deadline = time.time() + seconds
+    # the stream only ends with the program, so it is read until the deadline, which is
+    # checked as events are received
+    for event in client.watch():
+        if time.time() > deadline:
+            break
+        if event.get("dropped"):
+            print("the agent dropped %d events" % event["dropped"], file=sys.stderr)
+        entry = event.get("entry")
+        if entry is None:
+            continue
+        row = {"map": entry["Name"], "value": entry["Entry"]["Value"], "received": pd.Timestamp.now()}
+        row.update(entry["Entry"]["Key"])
+        rows.append(row)
+    return pd.DataFrame(rows)
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__.splitlines()[0])
+    parser.add_argument("addr", help="address of the agent, e.g. localhost:9092")
+    parser.add_argument("--seconds", type=float, default=10, help="how long to collect events for")
+    args = parser.parse_args()
+
+    df = collect(Client(args.addr), args.seconds)
+    if df.empty:
+        print("no entries were received", file=sys.stderr)
+        return
+    print(df.groupby("map").size().rename("updates"))
+    print(df.tail(20).to_string())
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,137 @@
+// Introspection of the bee agent itself, loaded by `bee run --self-telemetry`,
+// which sets bee_target_tgid to the pid of the agent.
+
+#include <vmlinux.h>
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_tracing.h>
+
+#define MAX_ENTRIES     4096
+#define MAX_SLOT        31
+
+const volatile __u32 bee_target_tgid = 0;
+
+struct syscall_key {
+        __u32 nr;
+        // log2 of the latency of the syscall in microseconds
+        __u32 latency_slot;
+};
+
+struct cpu_key {
+        __u32 cpu;
+};
+
+struct {
+        __uint(type, BPF_MAP_TYPE_HASH);
+        __uint(max_entries, MAX_ENTRIES);
+        __type(key, u64);
+        __type(value, u64);
+} syscall_start SEC(".maps");
+
+struct {
+        __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+        __uint(max_entries, 1);
+        __type(key, u32);
+        __type(value, u64);
+} oncpu_start SEC(".maps");
+
+struct {
+        __uint(type, BPF_MAP_TYPE_HASH);
+        __uint(max_entries, MAX_ENTRIES);
+        __type(key, struct syscall_key);
+        __type(value, u64);
+} bee_self_syscalls SEC(".maps.counter");
+
+struct {
+        __uint(type, BPF_MAP_TYPE_HASH);
+        __uint(max_entries, 1024);
+        __type(key, struct cpu_key);
+        __type(value, u64);
+} bee_self_cpu_ns SEC(".maps.counter");
+
+static __always_inline bool is_target(u64 pid_tgid)
+{
+        return bee_target_tgid && (pid_tgid >> 32) == bee_target_tgid;
+}
+
+static __always_inline __u32 log2l(u64 v)
+{
+        __u32 slot = 0;
+        while (v > 1 && slot < MAX_SLOT) {
+                v >>= 1;
+                slot++;
+        }
+        return slot;
+}
+
+static __always_inline void increment(void *map, void *key, u64 delta)
+{
+        u64 zero = 0, *value;
+
+        value = bpf_map_lookup_elem(map, key);
+        if (!value) {
+                bpf_map_update_elem(map, key, &zero, BPF_NOEXIST);
+                value = bpf_map_lookup_elem(map, key);
+                if (!value)
+                        return;
+        }
+        __sync_fetch_and_add(value, delta);
+}
+
+SEC("tracepoint/raw_syscalls/sys_enter")
+int sys_enter(struct trace_event_raw_sys_enter *ctx)
+{
+        u64 pid_tgid = bpf_get_current_pid_tgid();
+        u64 ts;
+
+        if (!is_target(pid_tgid))
+                return 0;
+
+        ts = bpf_ktime_get_ns();
+        bpf_map_update_elem(&syscall_start, &pid_tgid, &ts, BPF_ANY);
+        return 0;
+}
+
+SEC("tracepoint/raw_syscalls/sys_exit")
+int sys_exit(struct trace_event_raw_sys_exit *ctx)
+{
+        u64 pid_tgid = bpf_get_current_pid_tgid();
+        struct syscall_key key = {};
+        u64 *start;
+
+        if (!is_target(pid_tgid))
+                return 0;
+
+        start = bpf_map_lookup_elem(&syscall_start, &pid_tgid);
+        if (!start)
+                return 0;
+
+        key.nr = ctx->id;
+        key.latency_slot = log2l((bpf_ktime_get_ns() - *start) / 1000);
+        bpf_map_delete_elem(&syscall_start, &pid_tgid);
+        increment(&bee_self_syscalls, &key, 1);
+        return 0;
+}
+
+SEC("tracepoint/sched/sched_switch")
+int sched_switch(struct trace_event_raw_sched_switch *ctx)
+{
+        u64 pid_tgid = bpf_get_current_pid_tgid();
+        u64 now = bpf_ktime_get_ns();
+        struct cpu_key key = {};
+        u32 zero = 0;
+        u64 *start;
+
+        start = bpf_map_lookup_elem(&oncpu_start, &zero);
+        if (!start)
+                return 0;
+
+        // the task switched out is the current one, on cpu since the previous switch
+        if (is_target(pid_tgid) && *start) {
+                key.cpu = bpf_get_smp_processor_id();
+                increment(&bee_self_cpu_ns, &key, now - *start);
+        }
+        *start = now;
+        return 0;
+}
+
+char LICENSE[] SEC("license") = "Dual BSD/GPL";
@@ -0,0 +1,39 @@
+package agent
+
+import (
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+// The types of the agent API moved to the api/v1 package.
+
+const (
+	// Deprecated: use v1.WatchPath
+	WatchPath = v1.WatchPath
+	// Deprecated: use v1.MapsPath
+	MapsPath = v1.MapsPath
+	// Deprecated: use v1.FleetPath
+	FleetPath = v1.FleetPath
+)
+
+// Deprecated: use v1.MapType
+type MapType = v1.MapType
+
+const (
+	// Deprecated: use v1.RingBufMapType
+	RingBufMapType = v1.RingBufMapType
+	// Deprecated: use v1.HashMapType
+	HashMapType = v1.HashMapType
+)
+
+type (
+	// Deprecated: use v1.MapInfo
+	MapInfo = v1.MapInfo
+	// Deprecated: use v1.Event
+	Event = v1.Event
+	// Deprecated: use v1.FleetMap
+	FleetMap = v1.FleetMap
+	// Deprecated: use v1.FleetView
+	FleetView = v1.FleetView
+	// Deprecated: use v1.FleetRow
+	FleetRow = v1.FleetRow
+)
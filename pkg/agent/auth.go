@@ -0,0 +1,341 @@
+package agent
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/solo-io/go-utils/contextutils"
+	"gopkg.in/yaml.v2"
+)
+
+// Role is what the clients of the agent API authenticated with a key are allowed to do.
+type Role string
+
+const (
+	// RoleRead watches the maps and reads the state of the program, e.g. for dashboards
+	RoleRead Role = "read"
+	// RoleAdmin also pauses, resumes and triggers the program
+	RoleAdmin Role = "admin"
+)
+
+// allows returns whether the role is allowed the requests of another.
+func (r Role) allows(required Role) bool {
+	return r == RoleAdmin || r == required
+}
+
+func (r Role) valid() bool {
+	return r == RoleRead || r == RoleAdmin
+}
+
+// AuthConfig is the keys file of the agent API. It is reloaded whenever it changes, so keys
+// are rotated by adding the new key, moving the clients to it, then removing the old one or
+// letting it expire.
+type AuthConfig struct {
+	// Keys sent as bearer tokens, stored hashed
+	Keys []APIKey `yaml:"keys,omitempty"`
+	// Secrets JWTs signed with HS256 are verified with, any of them being accepted
+	JWTSecrets []JWTSecret `yaml:"jwtSecrets,omitempty"`
+}
+
+type APIKey struct {
+	// Name of the client, logged when the key is refused
+	Name string `yaml:"name"`
+	Role Role   `yaml:"role"`
+	// Hex encoded SHA-256 of the key, as printed by `bee api-key`
+	SHA256 string `yaml:"sha256"`
+	// The key is refused after this time, never if empty
+	Expires time.Time `yaml:"expires,omitempty"`
+
+	hash []byte
+}
+
+// JWTSecret verifies the JWTs of an issuer. The role of a token is its `role` claim,
+// its `exp` and `nbf` claims are enforced.
+type JWTSecret struct {
+	Name string `yaml:"name"`
+	// File holding the secret, it is read along with the keys file, which is reloaded when
+	// the secret changes too
+	SecretFile string `yaml:"secretFile"`
+	// Tokens signed with the secret are refused after this time, never if empty
+	Expires time.Time `yaml:"expires,omitempty"`
+
+	secret []byte
+}
+
+// LoadAuthConfig reads and validates a keys file.
+func LoadAuthConfig(path string) (*AuthConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read keys file: %w", err)
+	}
+	return parseAuthConfig(data)
+}
+
+func parseAuthConfig(data []byte) (*AuthConfig, error) {
+	cfg := &AuthConfig{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("could not parse keys file: %w", err)
+	}
+	if len(cfg.Keys) == 0 && len(cfg.JWTSecrets) == 0 {
+		return nil, errors.New("keys file must have at least one key or JWT secret")
+	}
+	for i := range cfg.Keys {
+		key := &cfg.Keys[i]
+		if !key.Role.valid() {
+			return nil, fmt.Errorf("key %d must have a role, read or admin", i)
+		}
+		hash, err := hex.DecodeString(key.SHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("key %d must have the hex encoded SHA-256 of the key", i)
+		}
+		key.hash = hash
+	}
+	for i := range cfg.JWTSecrets {
+		secret := &cfg.JWTSecrets[i]
+		data, err := ioutil.ReadFile(secret.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read JWT secret %d: %w", i, err)
+		}
+		secret.secret = []byte(strings.TrimSpace(string(data)))
+		if len(secret.secret) < sha256.Size {
+			return nil, fmt.Errorf("JWT secret %d must be at least %d bytes long", i, sha256.Size)
+		}
+	}
+	return cfg, nil
+}
+
+// GenerateAPIKey returns a random key and the hash to add to a keys file.
+func GenerateAPIKey() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key := "bee_" + base64.RawURLEncoding.EncodeToString(b)
+	hash := sha256.Sum256([]byte(key))
+	return key, hex.EncodeToString(hash[:]), nil
+}
+
+// Authenticator checks the bearer tokens of API requests against the keys file, which it
+// reloads once changed.
+type Authenticator struct {
+	path     string
+	interval time.Duration
+	now      func() time.Time
+
+	lock        sync.RWMutex
+	cfg         *AuthConfig
+	currentHash [sha256.Size]byte
+}
+
+func NewAuthenticator(path string) *Authenticator {
+	return &Authenticator{
+		path:     path,
+		interval: defaultConfigPollInterval,
+		now:      time.Now,
+	}
+}
+
+// Start loads the keys file, failing if it is invalid, then reloads it whenever it changes
+// until the context is done, or immediately on SIGHUP. An invalid file keeps the previous keys.
+func (a *Authenticator) Start(ctx context.Context) error {
+	if _, err := a.reload(); err != nil {
+		return err
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+		logger := contextutils.LoggerFrom(ctx)
+		for {
+			select {
+			case <-ticker.C:
+			case <-hup:
+			case <-ctx.Done():
+				return
+			}
+			changed, err := a.reload()
+			if err != nil {
+				logger.Errorf("could not reload keys file %s, keeping the previous keys: %v", a.path, err)
+			} else if changed {
+				logger.Infof("reloaded keys file %s", a.path)
+			}
+		}
+	}()
+	return nil
+}
+
+func (a *Authenticator) reload() (bool, error) {
+	data, err := ioutil.ReadFile(a.path)
+	if err != nil {
+		return false, fmt.Errorf("could not read keys file: %w", err)
+	}
+	hash := configHash(data)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.cfg != nil && hash == a.currentHash {
+		return false, nil
+	}
+	a.currentHash = hash
+	cfg, err := parseAuthConfig(data)
+	if err != nil {
+		return false, err
+	}
+	a.cfg = cfg
+	return true, nil
+}
+
+// configHash returns the hash of the keys file and of the JWT secrets it lists, so rotating
+// a secret reloads the file too. The files which can't be read are reported once parsed.
+func configHash(data []byte) [sha256.Size]byte {
+	var files struct {
+		JWTSecrets []struct {
+			SecretFile string `yaml:"secretFile"`
+		} `yaml:"jwtSecrets"`
+	}
+	_ = yaml.Unmarshal(data, &files)
+	h := sha256.New()
+	h.Write(data)
+	for _, secret := range files.JWTSecrets {
+		content, _ := ioutil.ReadFile(secret.SecretFile)
+		contentHash := sha256.Sum256(content)
+		h.Write(contentHash[:])
+	}
+	var hash [sha256.Size]byte
+	copy(hash[:], h.Sum(nil))
+	return hash
+}
+
+// authenticate returns the role of the bearer token of the request, and the name of its
+// client: the name of its key, or the subject of its JWT.
+func (a *Authenticator) authenticate(r *http.Request) (Role, string, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return "", "", errors.New("missing bearer token")
+	}
+	a.lock.RLock()
+	cfg := a.cfg
+	a.lock.RUnlock()
+	now := a.now()
+	if strings.Count(token, ".") == 2 {
+		return verifyJWT(cfg.JWTSecrets, token, now)
+	}
+
+	hash := sha256.Sum256([]byte(token))
+	for _, key := range cfg.Keys {
+		if subtle.ConstantTimeCompare(hash[:], key.hash) != 1 {
+			continue
+		}
+		if !key.Expires.IsZero() && now.After(key.Expires) {
+			return "", "", fmt.Errorf("key %s expired at %s", key.Name, key.Expires.Format(time.RFC3339))
+		}
+		return key.Role, key.Name, nil
+	}
+	return "", "", errors.New("unknown key")
+}
+
+type jwtClaims struct {
+	Role      Role   `json:"role"`
+	Subject   string `json:"sub"`
+	ExpiresAt *int64 `json:"exp"`
+	NotBefore *int64 `json:"nbf"`
+}
+
+// verifyJWT verifies an HS256 token with any of the secrets and returns its role and subject.
+func verifyJWT(secrets []JWTSecret, token string, now time.Time) (Role, string, error) {
+	parts := strings.Split(token, ".")
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", "", errors.New("invalid JWT header")
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil || h.Alg != "HS256" {
+		return "", "", errors.New("JWTs must be signed with HS256")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", "", errors.New("invalid JWT signature")
+	}
+
+	var verified bool
+	for _, secret := range secrets {
+		if !secret.Expires.IsZero() && now.After(secret.Expires) {
+			continue
+		}
+		mac := hmac.New(sha256.New, secret.secret)
+		mac.Write([]byte(parts[0] + "." + parts[1]))
+		if hmac.Equal(mac.Sum(nil), signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return "", "", errors.New("JWT is not signed by a known secret")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", "", errors.New("invalid JWT claims")
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", "", errors.New("invalid JWT claims")
+	}
+	if claims.ExpiresAt != nil && !now.Before(time.Unix(*claims.ExpiresAt, 0)) {
+		return "", "", fmt.Errorf("JWT of %s expired", claims.Subject)
+	}
+	if claims.NotBefore != nil && now.Before(time.Unix(*claims.NotBefore, 0)) {
+		return "", "", fmt.Errorf("JWT of %s is not valid yet", claims.Subject)
+	}
+	if !claims.Role.valid() {
+		return "", "", fmt.Errorf("JWT of %s must have a role claim, read or admin", claims.Subject)
+	}
+	return claims.Role, claims.Subject, nil
+}
+
+// require serves the requests whose token has a role allowed the required one.
+func (a *Authenticator) require(role Role, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, name, err := a.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="bee"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !got.allows(role) {
+			http.Error(w, fmt.Sprintf("the %s role is required", role), http.StatusForbidden)
+			return
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(), clientNameKey{}, name)))
+	}
+}
+
+type clientNameKey struct{}
+
+// clientName returns the name of the client of an authenticated request, or its address if
+// the agent does not require keys, e.g. to audit its changes.
+func clientName(r *http.Request) string {
+	if name, ok := r.Context().Value(clientNameKey{}).(string); ok && name != "" {
+		return name
+	}
+	return r.RemoteAddr
+}
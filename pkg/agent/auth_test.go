@@ -0,0 +1,194 @@
+package agent
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+var _ = Describe("API keys", func() {
+	var (
+		dir        string
+		keysFile   string
+		readKey    string
+		adminKey   string
+		auth       *Authenticator
+		httpServer *httptest.Server
+	)
+
+	writeKeys := func(content string) {
+		Expect(ioutil.WriteFile(keysFile, []byte(content), 0600)).To(Succeed())
+	}
+
+	keyEntry := func(name string, role Role, key string, expires string) string {
+		hash := sha256.Sum256([]byte(key))
+		entry := fmt.Sprintf("- name: %s\n  role: %s\n  sha256: %x\n", name, role, hash)
+		if expires != "" {
+			entry += fmt.Sprintf("  expires: %q\n", expires)
+		}
+		return entry
+	}
+
+	jwt := func(secret, claims string) string {
+		enc := base64.RawURLEncoding
+		signed := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + enc.EncodeToString([]byte(claims))
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(signed))
+		return signed + "." + enc.EncodeToString(mac.Sum(nil))
+	}
+
+	request := func(method, path, token string) int {
+		req, err := http.NewRequest(method, httpServer.URL+path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "bee-keys")
+		Expect(err).NotTo(HaveOccurred())
+		keysFile = filepath.Join(dir, "keys.yaml")
+		readKey, _, err = GenerateAPIKey()
+		Expect(err).NotTo(HaveOccurred())
+		adminKey, _, err = GenerateAPIKey()
+		Expect(err).NotTo(HaveOccurred())
+		writeKeys("keys:\n" + keyEntry("grafana", RoleRead, readKey, "") + keyEntry("ops", RoleAdmin, adminKey, ""))
+
+		auth = NewAuthenticator(keysFile)
+		_, err = auth.reload()
+		Expect(err).NotTo(HaveOccurred())
+		server := NewServer()
+		server.SetController(&fakeController{state: v1.ProgramState{Strategy: v1.GatePauseStrategy}})
+		server.SetAuthenticator(auth)
+		httpServer = httptest.NewServer(server.Handler())
+	})
+
+	AfterEach(func() {
+		httpServer.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("requires a key to read the fleet views", func() {
+		aggregator := NewAggregator()
+		aggregator.SetAuthenticator(auth)
+		httpServer.Close()
+		httpServer = httptest.NewServer(aggregator.Handler())
+
+		Expect(request(http.MethodGet, v1.FleetPath, "")).To(Equal(http.StatusUnauthorized))
+		Expect(request(http.MethodGet, v1.FleetClocksPath, "bee_unknown")).To(Equal(http.StatusUnauthorized))
+		Expect(request(http.MethodGet, v1.FleetPath, readKey)).To(Equal(http.StatusOK))
+		Expect(request(http.MethodGet, v1.FleetTimelinePath, adminKey)).To(Equal(http.StatusOK))
+		Expect(request(http.MethodGet, v1.OpenAPIPath, "")).To(Equal(http.StatusOK))
+	})
+
+	It("allows read keys to read but not control the program", func() {
+		Expect(request(http.MethodGet, v1.MapsPath, "")).To(Equal(http.StatusUnauthorized))
+		Expect(request(http.MethodGet, v1.MapsPath, "bee_unknown")).To(Equal(http.StatusUnauthorized))
+		Expect(request(http.MethodGet, v1.MapsPath, readKey)).To(Equal(http.StatusOK))
+		Expect(request(http.MethodGet, v1.ProgramPath, readKey)).To(Equal(http.StatusOK))
+		Expect(request(http.MethodPost, v1.PausePath, readKey)).To(Equal(http.StatusForbidden))
+		Expect(request(http.MethodPost, v1.PausePath, adminKey)).To(Equal(http.StatusOK))
+	})
+
+	It("sends the token from the client", func() {
+		client := NewClient(httpServer.URL, &ClientOpts{Token: readKey})
+		_, err := client.ProgramState(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.Pause(context.Background())
+		Expect(err).To(MatchError(ContainSubstring("the admin role is required")))
+	})
+
+	It("rotates the keys when the file changes", func() {
+		newKey, _, err := GenerateAPIKey()
+		Expect(err).NotTo(HaveOccurred())
+		expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		writeKeys("keys:\n" + keyEntry("grafana", RoleRead, readKey, expires) + keyEntry("grafana-2", RoleRead, newKey, ""))
+		changed, err := auth.reload()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(request(http.MethodGet, v1.MapsPath, readKey)).To(Equal(http.StatusOK))
+		Expect(request(http.MethodGet, v1.MapsPath, newKey)).To(Equal(http.StatusOK))
+		Expect(request(http.MethodGet, v1.MapsPath, adminKey)).To(Equal(http.StatusUnauthorized))
+
+		auth.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+		Expect(request(http.MethodGet, v1.MapsPath, readKey)).To(Equal(http.StatusUnauthorized))
+		Expect(request(http.MethodGet, v1.MapsPath, newKey)).To(Equal(http.StatusOK))
+
+		// an invalid file keeps the previous keys
+		writeKeys("keys:\n- name: broken\n")
+		_, err = auth.reload()
+		Expect(err).To(MatchError(ContainSubstring("must have a role")))
+		Expect(request(http.MethodGet, v1.MapsPath, newKey)).To(Equal(http.StatusOK))
+	})
+
+	It("verifies JWTs with the role of their claims", func() {
+		secretFile := filepath.Join(dir, "jwt-secret")
+		secret := "0123456789abcdef0123456789abcdef"
+		Expect(ioutil.WriteFile(secretFile, []byte(secret+"\n"), 0600)).To(Succeed())
+		writeKeys(fmt.Sprintf("jwtSecrets:\n- name: idp\n  secretFile: %s\n", secretFile))
+		_, err := auth.reload()
+		Expect(err).NotTo(HaveOccurred())
+
+		exp := time.Now().Add(time.Hour).Unix()
+		admin := jwt(secret, fmt.Sprintf(`{"sub":"ops","role":"admin","exp":%d}`, exp))
+		Expect(request(http.MethodPost, v1.ResumePath, admin)).To(Equal(http.StatusOK))
+		read := jwt(secret, fmt.Sprintf(`{"sub":"grafana","role":"read","exp":%d}`, exp))
+		Expect(request(http.MethodGet, v1.MapsPath, read)).To(Equal(http.StatusOK))
+		Expect(request(http.MethodPost, v1.ResumePath, read)).To(Equal(http.StatusForbidden))
+
+		expired := jwt(secret, fmt.Sprintf(`{"sub":"ops","role":"admin","exp":%d}`, time.Now().Add(-time.Minute).Unix()))
+		Expect(request(http.MethodGet, v1.MapsPath, expired)).To(Equal(http.StatusUnauthorized))
+		forged := jwt("fedcba9876543210fedcba9876543210", fmt.Sprintf(`{"sub":"ops","role":"admin","exp":%d}`, exp))
+		Expect(request(http.MethodGet, v1.MapsPath, forged)).To(Equal(http.StatusUnauthorized))
+		noRole := jwt(secret, fmt.Sprintf(`{"sub":"ops","exp":%d}`, exp))
+		Expect(request(http.MethodGet, v1.MapsPath, noRole)).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("reloads the JWT secrets when rotated", func() {
+		secretFile := filepath.Join(dir, "jwt-secret")
+		oldSecret := "0123456789abcdef0123456789abcdef"
+		Expect(ioutil.WriteFile(secretFile, []byte(oldSecret), 0600)).To(Succeed())
+		writeKeys(fmt.Sprintf("jwtSecrets:\n- name: idp\n  secretFile: %s\n", secretFile))
+		_, err := auth.reload()
+		Expect(err).NotTo(HaveOccurred())
+		claims := fmt.Sprintf(`{"sub":"grafana","role":"read","exp":%d}`, time.Now().Add(time.Hour).Unix())
+		Expect(request(http.MethodGet, v1.MapsPath, jwt(oldSecret, claims))).To(Equal(http.StatusOK))
+
+		changed, err := auth.reload()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+
+		// only the secret changes, not the keys file
+		newSecret := "fedcba9876543210fedcba9876543210"
+		Expect(ioutil.WriteFile(secretFile, []byte(newSecret), 0600)).To(Succeed())
+		changed, err = auth.reload()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(request(http.MethodGet, v1.MapsPath, jwt(oldSecret, claims))).To(Equal(http.StatusUnauthorized))
+		Expect(request(http.MethodGet, v1.MapsPath, jwt(newSecret, claims))).To(Equal(http.StatusOK))
+
+		// a secret which can't be read keeps the previous one
+		Expect(os.Remove(secretFile)).To(Succeed())
+		_, err = auth.reload()
+		Expect(err).To(MatchError(ContainSubstring("could not read JWT secret 0")))
+		Expect(request(http.MethodGet, v1.MapsPath, jwt(newSecret, claims))).To(Equal(http.StatusOK))
+	})
+})
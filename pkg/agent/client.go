@@ -0,0 +1,19 @@
+package agent
+
+import (
+	"github.com/solo-io/bumblebee/pkg/client"
+)
+
+// The client of the agent API moved to the pkg/client package.
+
+type (
+	// Deprecated: use client.Client
+	Client = client.Client
+	// Deprecated: use client.Options
+	ClientOpts = client.Options
+)
+
+// Deprecated: use client.New
+func NewClient(addr string, opts *ClientOpts) *Client {
+	return client.New(addr, opts)
+}
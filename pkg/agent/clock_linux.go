@@ -0,0 +1,39 @@
+package agent
+
+import (
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// state returned by adjtimex while the clock is not synchronized
+	timeError = 5
+	// status flag of adjtimex set while the clock is not synchronized
+	staUnsync = 0x0040
+)
+
+// ReadClock samples the clocks of the node, the wall clock being reported unsynchronized if
+// the kernel does not know.
+func ReadClock() v1.ClockStatus {
+	status := v1.ClockStatus{Time: time.Now(), Boottime: boottime()}
+	var timex unix.Timex
+	state, err := unix.Adjtimex(&timex)
+	if err != nil {
+		return status
+	}
+	status.Synchronized = state != timeError && timex.Status&staUnsync == 0
+	status.MaxError = time.Duration(timex.Maxerror) * time.Microsecond
+	status.EstimatedError = time.Duration(timex.Esterror) * time.Microsecond
+	return status
+}
+
+// boottime returns the time since the node booted, including the time it was suspended.
+func boottime() time.Duration {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_BOOTTIME, &ts); err != nil {
+		return 0
+	}
+	return time.Duration(ts.Nano())
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package agent
+
+import (
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+// ReadClock samples the wall clock, reported unsynchronized as only Linux tells whether it is.
+func ReadClock() v1.ClockStatus {
+	return v1.ClockStatus{Time: time.Now()}
+}
+
+// boottime returns 0, as the time since the node booted is only known on Linux.
+func boottime() time.Duration {
+	return 0
+}
@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"context"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/client"
+)
+
+var _ = Describe("clocks", func() {
+	It("serves the clocks of the node and hints the order of the events", func() {
+		server := NewServer()
+		httpServer := httptest.NewServer(server.Handler())
+		defer httpServer.Close()
+		server.NewRingBuf("events", []string{"pid"})
+		server.NewHashMap("counts", []string{"pid"})
+
+		c := client.New(httpServer.URL, nil)
+		status, err := c.Clock(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Boottime).To(BeNumerically(">", 0))
+		Expect(status.Time).To(BeTemporally("~", time.Now(), time.Second))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stream, err := c.Events(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		defer stream.Close()
+		for i := 0; i < 2; i++ {
+			_, err := stream.Recv()
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		server.SendEntry(v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"pid": "1"}, Value: "3"}})
+		server.SendEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"pid": "1"}}})
+		server.SendEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"pid": "2"}}})
+		event, err := stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(event.Hint).To(BeNil())
+		first, err := stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		second, err := stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first.Hint.Seq).To(Equal(uint64(1)))
+		Expect(second.Hint.Seq).To(Equal(uint64(2)))
+		Expect(second.Hint.Boottime).To(BeNumerically(">=", first.Hint.Boottime))
+	})
+
+	It("orders the events of the nodes on the clock of the fleet", func() {
+		aggregator := NewAggregator()
+		now := time.Now()
+		// the wall clock of node-a is 10s ahead
+		aggregator.SetClock("node-a", v1.NodeClock{
+			Node:      "node-a",
+			Status:    v1.ClockStatus{Time: now.Add(10 * time.Second), Boottime: 100 * time.Second},
+			Offset:    10 * time.Second,
+			RTT:       2 * time.Millisecond,
+			SampledAt: now,
+		}, nil)
+		aggregator.SetClock("node-b", v1.NodeClock{
+			Node:      "node-b",
+			Status:    v1.ClockStatus{Time: now, Boottime: 50 * time.Second},
+			RTT:       4 * time.Millisecond,
+			SampledAt: now,
+		}, nil)
+
+		send := func(node string, pid string, hint v1.OrderingHint) {
+			w := aggregator.NodeWatcher(node).(client.HintedWatcher)
+			w.SendHintedEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"pid": pid}}}, hint)
+		}
+		send("node-a", "1", v1.OrderingHint{Seq: 1, Time: now.Add(11 * time.Second), Boottime: 101 * time.Second})
+		send("node-b", "2", v1.OrderingHint{Seq: 1, Time: now.Add(500 * time.Millisecond), Boottime: 50*time.Second + 500*time.Millisecond})
+		send("node-c", "3", v1.OrderingHint{Seq: 1, Time: now.Add(2 * time.Second), Boottime: time.Second})
+
+		timeline := aggregator.Timeline("events")
+		Expect(timeline).To(HaveLen(3))
+		Expect(timeline[0].Node).To(Equal("node-b"))
+		Expect(timeline[0].Time).To(BeTemporally("==", now.Add(500*time.Millisecond)))
+		Expect(timeline[1].Node).To(Equal("node-a"))
+		Expect(timeline[1].Time).To(BeTemporally("==", now.Add(time.Second)))
+		Expect(timeline[1].Skew).To(Equal(time.Millisecond + 500*time.Microsecond))
+		Expect(timeline[1].Estimated).To(BeTrue())
+		// the clock of node-c was never sampled
+		Expect(timeline[2].Node).To(Equal("node-c"))
+		Expect(timeline[2].Estimated).To(BeFalse())
+
+		Expect(aggregator.Timeline("other")).To(BeEmpty())
+		aggregator.SetClock("node-b", v1.NodeClock{}, context.DeadlineExceeded)
+		clocks := aggregator.Clocks()
+		Expect(clocks).To(HaveLen(2))
+		Expect(clocks[1].RTT).To(Equal(4 * time.Millisecond))
+		Expect(clocks[1].Error).To(Equal("context deadline exceeded"))
+	})
+})
@@ -0,0 +1,215 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/solo-io/go-utils/contextutils"
+	"gopkg.in/yaml.v2"
+)
+
+// interval the config file is checked for changes at
+const defaultConfigPollInterval = 2 * time.Second
+
+// Config is the configuration file of a running program, which is applied again
+// whenever it changes. Fields left empty keep the value set by flags.
+type Config struct {
+	// Filters applied to the entries displayed, replacing the --filter flags
+	Filters []FilterConfig `yaml:"filters,omitempty"`
+	// Interval hash, array, queue and stack maps are read at
+	PollInterval time.Duration `yaml:"pollInterval,omitempty"`
+	// Hash map keys whose value has not changed for this long stop being exported
+	StaleKeyTTL time.Duration `yaml:"staleKeyTTL,omitempty"`
+	// Windows the program runs within, it is paused outside of them
+	Schedule *Schedule `yaml:"schedule,omitempty"`
+	// Retention of the history of hash map values, e.g. rendered by the TUI
+	History *HistoryConfig `yaml:"history,omitempty"`
+}
+
+// HistoryConfig keeps the history of hash map values at decreasing resolutions, e.g. 1s
+// for 10m then 1m for 24h, within a bounded amount of memory.
+type HistoryConfig struct {
+	// Tiers from the finest resolution, each keeping the history for longer
+	Retention []RetentionConfig `yaml:"retention"`
+	// Bound of the memory of the history of all entries, e.g. 64MiB, unbounded if empty
+	MaxMemory string `yaml:"maxMemory,omitempty"`
+
+	maxBytes int64
+}
+
+type RetentionConfig struct {
+	Resolution time.Duration `yaml:"resolution"`
+	Keep       time.Duration `yaml:"keep"`
+}
+
+// MaxBytes returns the bound of the memory of the history, 0 if unbounded.
+func (h *HistoryConfig) MaxBytes() int64 {
+	return h.maxBytes
+}
+
+func (h *HistoryConfig) init() error {
+	if len(h.Retention) == 0 {
+		return fmt.Errorf("history must have at least one retention tier")
+	}
+	for i, r := range h.Retention {
+		if r.Resolution <= 0 || r.Keep < r.Resolution {
+			return fmt.Errorf("history retention %d must have a resolution, and keep it for at least as long", i)
+		}
+		if i > 0 && (r.Resolution <= h.Retention[i-1].Resolution || r.Keep <= h.Retention[i-1].Keep) {
+			return fmt.Errorf("history retention %d must be coarser and kept longer than the previous one", i)
+		}
+	}
+	h.maxBytes = 0
+	if h.MaxMemory != "" {
+		maxBytes, err := units.RAMInBytes(h.MaxMemory)
+		if err != nil || maxBytes <= 0 {
+			return fmt.Errorf("invalid history maxMemory %q", h.MaxMemory)
+		}
+		h.maxBytes = maxBytes
+	}
+	return nil
+}
+
+type FilterConfig struct {
+	Map   string `yaml:"map"`
+	Key   string `yaml:"key"`
+	Regex string `yaml:"regex"`
+}
+
+// LoadConfig reads and validates a config file, unknown fields are rejected.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config: %w", err)
+	}
+	return parseConfig(data)
+}
+
+func parseConfig(data []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("could not parse config: %w", err)
+	}
+	if cfg.PollInterval < 0 {
+		return nil, fmt.Errorf("pollInterval must be positive")
+	}
+	if cfg.StaleKeyTTL < 0 {
+		return nil, fmt.Errorf("staleKeyTTL must be positive")
+	}
+	for i, filter := range cfg.Filters {
+		if filter.Map == "" || filter.Key == "" {
+			return nil, fmt.Errorf("filter %d must have a map and a key", i)
+		}
+	}
+	if cfg.Schedule != nil {
+		if err := cfg.Schedule.init(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.History != nil {
+		if err := cfg.History.init(); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// FilterFlags returns the filters in the format of the --filter flag.
+func (c *Config) FilterFlags() []string {
+	var flags []string
+	for _, filter := range c.Filters {
+		flags = append(flags, filter.Map, filter.Key, filter.Regex)
+	}
+	return flags
+}
+
+// ConfigReloader applies a config file, and applies it again every time it changes.
+// A config which fails to apply is rolled back to the last applied one.
+type ConfigReloader struct {
+	path string
+	// apply must validate the whole config before changing anything
+	apply    func(ctx context.Context, cfg *Config) error
+	interval time.Duration
+
+	current     *Config
+	currentHash [sha256.Size]byte
+}
+
+func NewConfigReloader(path string, apply func(ctx context.Context, cfg *Config) error) *ConfigReloader {
+	return &ConfigReloader{
+		path:     path,
+		apply:    apply,
+		interval: defaultConfigPollInterval,
+	}
+}
+
+// Start applies the config, failing if it is invalid, then watches the file for changes
+// until the context is done. Sending SIGHUP to the process reloads the config immediately.
+func (r *ConfigReloader) Start(ctx context.Context) error {
+	if _, err := r.reload(ctx); err != nil {
+		return err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-hup:
+			case <-ctx.Done():
+				return
+			}
+			r.reloadAndLog(ctx)
+		}
+	}()
+	return nil
+}
+
+func (r *ConfigReloader) reloadAndLog(ctx context.Context) {
+	logger := contextutils.LoggerFrom(ctx)
+	changed, err := r.reload(ctx)
+	if err != nil {
+		logger.Errorf("could not reload config %s, keeping the previous one: %v", r.path, err)
+	} else if changed {
+		logger.Infof("reloaded config %s", r.path)
+	}
+}
+
+// reload applies the config file if it changed since the last reload, and returns whether it did.
+func (r *ConfigReloader) reload(ctx context.Context) (bool, error) {
+	data, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return false, fmt.Errorf("could not read config: %w", err)
+	}
+	hash := sha256.Sum256(data)
+	if r.current != nil && hash == r.currentHash {
+		return false, nil
+	}
+	// don't retry the same content on every tick if it fails
+	r.currentHash = hash
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return false, err
+	}
+	if err := r.apply(ctx, cfg); err != nil {
+		if r.current != nil {
+			if rollbackErr := r.apply(ctx, r.current); rollbackErr != nil {
+				return false, fmt.Errorf("%v, and could not roll back to the previous config: %w", err, rollbackErr)
+			}
+		}
+		return false, err
+	}
+	r.current = cfg
+	return true, nil
+}
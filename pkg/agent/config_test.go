@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConfigReloader", func() {
+	var (
+		ctx      context.Context
+		dir      string
+		path     string
+		applied  []*Config
+		reloader *ConfigReloader
+	)
+
+	write := func(content string) {
+		Expect(ioutil.WriteFile(path, []byte(content), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		dir, err = ioutil.TempDir("", "bee-config")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "config.yaml")
+		applied = nil
+		reloader = NewConfigReloader(path, func(ctx context.Context, cfg *Config) error {
+			if cfg.PollInterval > time.Minute {
+				return errors.New("poll interval too long")
+			}
+			applied = append(applied, cfg)
+			return nil
+		})
+		write("pollInterval: 5s\nfilters:\n- map: events\n  key: comm\n  regex: curl\n")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("applies the config once until it changes", func() {
+		changed, err := reloader.reload(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(applied).To(HaveLen(1))
+		Expect(applied[0].PollInterval).To(Equal(5 * time.Second))
+		Expect(applied[0].FilterFlags()).To(Equal([]string{"events", "comm", "curl"}))
+
+		changed, err = reloader.reload(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+
+		write("pollInterval: 10s\n")
+		changed, err = reloader.reload(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(applied).To(HaveLen(2))
+		Expect(applied[1].Filters).To(BeEmpty())
+	})
+
+	It("keeps the previous config when the new one is invalid", func() {
+		_, err := reloader.reload(ctx)
+		Expect(err).NotTo(HaveOccurred())
+
+		write("pollInterval: 5s\nunknown: true\n")
+		_, err = reloader.reload(ctx)
+		Expect(err).To(HaveOccurred())
+		Expect(applied).To(HaveLen(1))
+	})
+
+	It("rolls back when the new config fails to apply", func() {
+		_, err := reloader.reload(ctx)
+		Expect(err).NotTo(HaveOccurred())
+
+		write("pollInterval: 10m\n")
+		_, err = reloader.reload(ctx)
+		Expect(err).To(MatchError("poll interval too long"))
+		Expect(applied).To(HaveLen(2))
+		Expect(applied[1]).To(Equal(applied[0]))
+
+		// the failed config is not retried until the file changes again
+		changed, err := reloader.reload(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+	})
+})
+
+var _ = Describe("History config", func() {
+	It("parses retention tiers and the memory bound", func() {
+		cfg, err := parseConfig([]byte("history:\n  retention:\n  - {resolution: 1s, keep: 10m}\n  - {resolution: 1m, keep: 24h}\n  maxMemory: 64MiB\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.History.Retention).To(Equal([]RetentionConfig{
+			{Resolution: time.Second, Keep: 10 * time.Minute},
+			{Resolution: time.Minute, Keep: 24 * time.Hour},
+		}))
+		Expect(cfg.History.MaxBytes()).To(Equal(int64(64 << 20)))
+	})
+
+	It("rejects invalid retention", func() {
+		for _, content := range []string{
+			"history: {retention: []}",
+			"history: {retention: [{resolution: 1m, keep: 10s}]}",
+			"history: {retention: [{resolution: 1m, keep: 1h}, {resolution: 1s, keep: 24h}]}",
+			"history: {retention: [{resolution: 1s, keep: 1h}, {resolution: 1m, keep: 1h}]}",
+			"history: {retention: [{resolution: 1s, keep: 1h}], maxMemory: lots}",
+		} {
+			_, err := parseConfig([]byte(content))
+			Expect(err).To(HaveOccurred(), content)
+		}
+	})
+})
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+type fakeController struct {
+	state v1.ProgramState
+	err   error
+}
+
+func (c *fakeController) State() v1.ProgramState {
+	return c.state
+}
+
+func (c *fakeController) Pause() (v1.ProgramState, error) {
+	if c.err != nil {
+		return c.state, c.err
+	}
+	c.state.Paused, c.state.Since = true, time.Now()
+	return c.state, nil
+}
+
+func (c *fakeController) Resume() (v1.ProgramState, error) {
+	c.state.Paused, c.state.Since = false, time.Now()
+	return c.state, nil
+}
+
+type fakeTrigger struct {
+	fakeController
+	reasons []string
+}
+
+func (t *fakeTrigger) Trigger(reason string) (v1.ProgramState, error) {
+	t.reasons = append(t.reasons, reason)
+	return t.Resume()
+}
+
+type fakeStatus v1.ProgramStatus
+
+func (s fakeStatus) Status() v1.ProgramStatus {
+	return v1.ProgramStatus(s)
+}
+
+var _ = Describe("program control", func() {
+	It("pauses and resumes the program", func() {
+		server := NewServer()
+		controller := &fakeController{state: v1.ProgramState{Strategy: v1.GatePauseStrategy}}
+		server.SetController(controller)
+		httpServer := httptest.NewServer(server.Handler())
+		defer httpServer.Close()
+		client := NewClient(httpServer.URL, nil)
+		ctx := context.Background()
+
+		state, err := client.Pause(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(state.Paused).To(BeTrue())
+		Expect(state.Strategy).To(Equal(v1.GatePauseStrategy))
+
+		state, err = client.ProgramState(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(state.Paused).To(BeTrue())
+
+		state, err = client.Resume(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(state.Paused).To(BeFalse())
+
+		controller.err = errors.New("cannot detach")
+		_, err = client.Pause(ctx)
+		Expect(err).To(MatchError(ContainSubstring("cannot detach")))
+	})
+
+	It("does not allow control unless enabled", func() {
+		httpServer := httptest.NewServer(NewServer().Handler())
+		defer httpServer.Close()
+		_, err := NewClient(httpServer.URL, nil).Pause(context.Background())
+		Expect(err).To(MatchError(ContainSubstring("--api-control")))
+	})
+
+	It("serves the status of the program", func() {
+		server := NewServer()
+		httpServer := httptest.NewServer(server.Handler())
+		defer httpServer.Close()
+		client := NewClient(httpServer.URL, nil)
+
+		status, err := client.Status(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Probes).To(BeEmpty())
+
+		server.SetStatus(fakeStatus{
+			Probes:      []v1.ProbeStatus{{Program: "tc_allow", Type: "tc", State: v1.ProbeFailed, Error: "could not attach 'tc_allow' to veth1"}},
+			Errors:      []v1.RuntimeError{{Time: time.Unix(1700000000, 0).UTC(), Source: "events", Message: "read failed"}},
+			TotalErrors: 4,
+		})
+		status, err = client.Status(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Probes[0].State).To(Equal(v1.ProbeFailed))
+		Expect(status.Errors).To(HaveLen(1))
+		Expect(status.TotalErrors).To(BeEquivalentTo(4))
+	})
+
+	It("triggers captures if the controller supports it", func() {
+		server := NewServer()
+		trigger := &fakeTrigger{}
+		server.SetController(trigger)
+		httpServer := httptest.NewServer(server.Handler())
+		defer httpServer.Close()
+
+		state, err := NewClient(httpServer.URL, nil).Trigger(context.Background(), "latency spike")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(state.Paused).To(BeFalse())
+		Expect(trigger.reasons).To(Equal([]string{"latency spike"}))
+	})
+})
@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+// number of debug log entries kept for the clients connecting after they were logged
+const debugLogBacklog = 1000
+
+// debugLogs are the entries logged while loading the program, streamed to clients. Its zero
+// value is ready to use.
+type debugLogs struct {
+	lock        sync.Mutex
+	backlog     []v1.DebugLogEntry
+	subscribers map[chan v1.DebugLogEntry]struct{}
+	closed      bool
+}
+
+// DebugLog streams the entry to the clients of the debug logs, it is meant to be the
+// DebugLog of the loader.LoadOptions. It never blocks, entries are dropped for the clients
+// which do not keep up.
+func (s *Server) DebugLog(entry v1.DebugLogEntry) {
+	s.debugLogs.add(entry)
+}
+
+func (d *debugLogs) add(entry v1.DebugLogEntry) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if len(d.backlog) == debugLogBacklog {
+		d.backlog = append(d.backlog[:0], d.backlog[1:]...)
+	}
+	d.backlog = append(d.backlog, entry)
+	for sub := range d.subscribers {
+		select {
+		case sub <- entry:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel pre-filled with the backlog, closed once the program is over.
+func (d *debugLogs) subscribe() chan v1.DebugLogEntry {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	sub := make(chan v1.DebugLogEntry, debugLogBacklog+subscriberBufferSize)
+	for _, entry := range d.backlog {
+		sub <- entry
+	}
+	if d.closed {
+		close(sub)
+		return sub
+	}
+	if d.subscribers == nil {
+		d.subscribers = map[chan v1.DebugLogEntry]struct{}{}
+	}
+	d.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (d *debugLogs) unsubscribe(sub chan v1.DebugLogEntry) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.subscribers, sub)
+}
+
+func (d *debugLogs) close() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.closed = true
+	for sub := range d.subscribers {
+		close(sub)
+		delete(d.subscribers, sub)
+	}
+}
+
+func (s *Server) serveDebugLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := s.debugLogs.subscribe()
+	defer s.debugLogs.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case entry, ok := <-sub:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(entry); err != nil {
+				return
+			}
+			if len(sub) == 0 {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"context"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/client"
+)
+
+var _ = Describe("debug logs", func() {
+	It("streams the recent and new entries until the program is over", func() {
+		server := NewServer()
+		httpServer := httptest.NewServer(server.Handler())
+		defer httpServer.Close()
+		server.DebugLog(v1.DebugLogEntry{Time: time.Now(), Level: v1.DebugLogInfo, Message: "Loading 1 programs and 2 maps"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stream, err := client.New(httpServer.URL, nil).DebugLogs(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		defer stream.Close()
+
+		entry, err := stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entry.Message).To(Equal("Loading 1 programs and 2 maps"))
+
+		server.DebugLog(v1.DebugLogEntry{Time: time.Now(), Level: v1.DebugLogVerifier, Program: "kprobe", Message: "0: (bf) r6 = r1"})
+		entry, err = stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entry.Program).To(Equal("kprobe"))
+		Expect(entry.Level).To(Equal(v1.DebugLogVerifier))
+
+		server.Close()
+		_, err = stream.Recv()
+		Expect(err).To(MatchError(client.ErrStreamEnded))
+	})
+
+	It("only keeps the most recent entries", func() {
+		server := NewServer()
+		for i := 0; i < debugLogBacklog+10; i++ {
+			server.DebugLog(v1.DebugLogEntry{Level: v1.DebugLogVerifier, Message: "line"})
+		}
+		server.Close()
+		httpServer := httptest.NewServer(server.Handler())
+		defer httpServer.Close()
+
+		stream, err := client.New(httpServer.URL, nil).DebugLogs(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		defer stream.Close()
+		received := 0
+		for {
+			if _, err := stream.Recv(); err != nil {
+				Expect(err).To(MatchError(client.ErrStreamEnded))
+				break
+			}
+			received++
+		}
+		Expect(received).To(Equal(debugLogBacklog))
+	})
+})
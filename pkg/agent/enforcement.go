@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+// EnforcementSwitch switches an enforcement program between observing and enforcing, e.g. a
+// loader.Enforcement.
+type EnforcementSwitch interface {
+	State() v1.EnforcementState
+	// SetMode sets the mode of a scope of the program, the whole program if empty
+	SetMode(scope string, mode v1.EnforcementMode) (v1.EnforcementState, error)
+}
+
+// SetEnforcement allows API clients to read the enforcement mode of the program, also
+// reported in its state, and admin clients to switch it. It is only served along with the
+// controller, and must be called before the API is served.
+func (s *Server) SetEnforcement(enforcement EnforcementSwitch) {
+	s.enforcement = enforcement
+}
+
+// withEnforcement adds the enforcement mode of the program to its state, if switched.
+func (s *Server) withEnforcement(state v1.ProgramState) v1.ProgramState {
+	if s.enforcement != nil {
+		enforcement := s.enforcement.State()
+		state.Enforcement = &enforcement
+	}
+	return state
+}
+
+func (s *Server) serveEnforcement(w http.ResponseWriter, r *http.Request) {
+	writeEnforcement(w, s.enforcement.State(), http.StatusOK)
+}
+
+func (s *Server) serveEnforcementMode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mode := v1.EnforcementMode(r.URL.Query().Get("mode"))
+	if mode != v1.ObserveMode && mode != v1.EnforceMode {
+		http.Error(w, "the mode query parameter must be observe or enforce", http.StatusBadRequest)
+		return
+	}
+	state, err := s.enforcement.SetMode(r.URL.Query().Get("scope"), mode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeEnforcement(w, state, http.StatusOK)
+}
+
+func writeEnforcement(w http.ResponseWriter, state v1.EnforcementState, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(state)
+}
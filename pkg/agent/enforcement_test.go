@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/client"
+)
+
+// fakeSwitch is scoped to the cgroups of a single pod.
+type fakeSwitch struct {
+	state v1.EnforcementState
+}
+
+func (f *fakeSwitch) State() v1.EnforcementState {
+	if f.state.Mode == "" {
+		f.state.Mode = v1.ObserveMode
+	}
+	return f.state
+}
+
+func (f *fakeSwitch) SetMode(scope string, mode v1.EnforcementMode) (v1.EnforcementState, error) {
+	switch scope {
+	case "":
+		f.state.Mode = mode
+	case "pod:checkout":
+		f.state.Scopes = map[string]v1.EnforcementMode{scope: mode}
+	default:
+		return f.State(), fmt.Errorf("the program is not scoped to the cgroups %s", scope)
+	}
+	return f.State(), nil
+}
+
+var _ = Describe("enforcement mode", func() {
+	var (
+		httpServer *httptest.Server
+		c          *client.Client
+		ctx        = context.Background()
+	)
+
+	BeforeEach(func() {
+		server := NewServer()
+		server.SetController(&fakeController{state: v1.ProgramState{Strategy: v1.GatePauseStrategy}})
+		server.SetEnforcement(&fakeSwitch{})
+		httpServer = httptest.NewServer(server.Handler())
+		c = client.New(httpServer.URL, nil)
+	})
+
+	AfterEach(func() {
+		httpServer.Close()
+	})
+
+	It("switches the program and its scopes, reporting the mode in its state", func() {
+		state, err := c.Enforcement(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(state.Mode).To(Equal(v1.ObserveMode))
+
+		_, err = c.SetEnforcementMode(ctx, "pod:checkout", v1.EnforceMode)
+		Expect(err).NotTo(HaveOccurred())
+		state, err = c.SetEnforcementMode(ctx, "", v1.EnforceMode)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(state.Mode).To(Equal(v1.EnforceMode))
+		Expect(state.Scopes).To(Equal(map[string]v1.EnforcementMode{"pod:checkout": v1.EnforceMode}))
+
+		program, err := c.ProgramState(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(program.Enforcement).NotTo(BeNil())
+		Expect(program.Enforcement.Mode).To(Equal(v1.EnforceMode))
+	})
+
+	It("rejects unknown modes and scopes", func() {
+		var statusErr *client.StatusError
+		_, err := c.SetEnforcementMode(ctx, "", "deny")
+		Expect(errors.As(err, &statusErr)).To(BeTrue())
+		Expect(statusErr.Code).To(Equal(http.StatusBadRequest))
+		_, err = c.SetEnforcementMode(ctx, "pod:cart", v1.EnforceMode)
+		Expect(errors.As(err, &statusErr)).To(BeTrue())
+		Expect(statusErr.Code).To(Equal(http.StatusConflict))
+		Expect(err).To(MatchError(ContainSubstring("not scoped to the cgroups pod:cart")))
+	})
+})
@@ -0,0 +1,471 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/hashstructure/v2"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/client"
+	"github.com/solo-io/go-utils/contextutils"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// number of recent events of ring buffers kept for the timeline
+	timelineSize = 10000
+	// interval the clocks of the nodes are sampled at
+	clockInterval = 30 * time.Second
+	// requests of a clock sample, the one with the shortest round trip being kept
+	clockSamples = 4
+	// maximum drift of the clock of a node, the frequency tolerance of adjtimex
+	maxClockDrift = 500e-6
+	// difference of the boot and wall times elapsed since the clock sample of a node past
+	// which the boot time of an event is not compared with the sample, the node rebooted or
+	// its wall clock was stepped
+	maxClockStep = time.Minute
+
+	// DefaultStaleAfter is how long the entries of a node are still aggregated once its
+	// agent is unreachable, unless configured otherwise
+	DefaultStaleAfter = 2 * time.Minute
+)
+
+// Aggregator merges the hash maps of several agents, which are expected to run
+// the same package, into fleet-level views, and orders the events of their ring buffers
+// on a timeline.
+type Aggregator struct {
+	auth       *Authenticator
+	staleAfter time.Duration
+	now        func() time.Time
+
+	lock sync.RWMutex
+	keys map[string][]string
+	// map name -> node -> key hash -> entry
+	values map[string]map[string]map[uint64]v1.KvPair
+	clocks map[string]*v1.NodeClock
+	// time the agent of a node became unreachable, by node, absent while connected
+	disconnected map[string]time.Time
+	// ring of the recent events, next being the oldest once full
+	events []timelineRecord
+	next   int
+}
+
+// timelineRecord is an event of a ring buffer of a node, as received.
+type timelineRecord struct {
+	node  string
+	entry v1.MapEntry
+	hint  v1.OrderingHint
+}
+
+func NewAggregator() *Aggregator {
+	return &Aggregator{
+		staleAfter:   DefaultStaleAfter,
+		now:          time.Now,
+		keys:         map[string][]string{},
+		values:       map[string]map[string]map[uint64]v1.KvPair{},
+		clocks:       map[string]*v1.NodeClock{},
+		disconnected: map[string]time.Time{},
+	}
+}
+
+// SetAuthenticator requires the clients of the fleet API to send a key, a read one being
+// enough. It must be called before the API is served.
+func (a *Aggregator) SetAuthenticator(auth *Authenticator) {
+	a.auth = auth
+}
+
+// SetStaleAfter sets how long the entries of a node are still aggregated once its agent is
+// unreachable, DefaultStaleAfter by default. It must be called before the nodes are watched.
+func (a *Aggregator) SetStaleAfter(staleAfter time.Duration) {
+	a.staleAfter = staleAfter
+}
+
+// Watch streams the maps of all the agents, and samples their clocks, until the context
+// is done.
+func (a *Aggregator) Watch(ctx context.Context, nodes []string, opts *client.Options) error {
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, node := range nodes {
+		node := node
+		c := client.New(node, opts)
+		eg.Go(func() error {
+			return c.Watch(ctx, a.NodeWatcher(node))
+		})
+		eg.Go(func() error {
+			a.watchClock(ctx, node, c)
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+// watchClock samples the clock of the node every clockInterval until the context is done.
+func (a *Aggregator) watchClock(ctx context.Context, node string, c *client.Client) {
+	ticker := time.NewTicker(clockInterval)
+	defer ticker.Stop()
+	for {
+		clock, err := sampleClock(ctx, node, c)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			contextutils.LoggerFrom(ctx).Warnf("could not sample the clock of agent %s: %v", node, err)
+		}
+		a.SetClock(node, clock, err)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sampleClock estimates the offset of the clock of the node from the sample with the shortest
+// round trip, as the clock of the node was read about halfway through it.
+func sampleClock(ctx context.Context, node string, c *client.Client) (v1.NodeClock, error) {
+	var best *v1.NodeClock
+	var lastErr error
+	for i := 0; i < clockSamples; i++ {
+		start := time.Now()
+		status, err := c.Clock(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		rtt := time.Since(start)
+		if best == nil || rtt < best.RTT {
+			sampledAt := start.Add(rtt / 2)
+			best = &v1.NodeClock{Node: node, Status: *status, Offset: status.Time.Sub(sampledAt), RTT: rtt, SampledAt: sampledAt}
+		}
+	}
+	if best == nil {
+		return v1.NodeClock{Node: node}, lastErr
+	}
+	return *best, nil
+}
+
+// SetClock records the last clock sample of the node, or the error of the sample, the
+// previous sample being kept if any.
+func (a *Aggregator) SetClock(node string, clock v1.NodeClock, err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if err == nil {
+		a.clocks[node] = &clock
+		return
+	}
+	if a.clocks[node] == nil {
+		a.clocks[node] = &v1.NodeClock{Node: node}
+	}
+	a.clocks[node].Error = err.Error()
+}
+
+// NodeWatcher returns the watcher recording the maps reported by a node.
+func (a *Aggregator) NodeWatcher(node string) v1.MapWatcher {
+	return &nodeWatcher{aggregator: a, node: node}
+}
+
+type nodeWatcher struct {
+	aggregator *Aggregator
+	node       string
+}
+
+func (w *nodeWatcher) NewRingBuf(name string, keys []string) {
+	// events can't be meaningfully merged, they are only ordered on the timeline
+}
+
+func (w *nodeWatcher) NewHashMap(name string, keys []string) {
+	a := w.aggregator
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.keys[name] = keys
+	if a.values[name] == nil {
+		a.values[name] = map[string]map[uint64]v1.KvPair{}
+	}
+	if a.values[name][w.node] == nil {
+		a.values[name][w.node] = map[uint64]v1.KvPair{}
+	}
+}
+
+func (w *nodeWatcher) SendEntry(entry v1.MapEntry) {
+	a := w.aggregator
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	nodeValues, ok := a.values[entry.Name][w.node]
+	if !ok {
+		return
+	}
+	hash, _ := hashstructure.Hash(entry.Entry.Key, hashstructure.FormatV2, nil)
+	nodeValues[hash] = entry.Entry
+}
+
+// DeleteEntry removes an entry the node reported gone.
+func (w *nodeWatcher) DeleteEntry(entry v1.MapEntry) {
+	a := w.aggregator
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	hash, _ := hashstructure.Hash(entry.Entry.Key, hashstructure.FormatV2, nil)
+	delete(a.values[entry.Name][w.node], hash)
+}
+
+// SendHintedEntry adds an event of a ring buffer to the timeline.
+func (w *nodeWatcher) SendHintedEntry(entry v1.MapEntry, hint v1.OrderingHint) {
+	a := w.aggregator
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	record := timelineRecord{node: w.node, entry: entry, hint: hint}
+	if len(a.events) < timelineSize {
+		a.events = append(a.events, record)
+		return
+	}
+	a.events[a.next] = record
+	a.next = (a.next + 1) % timelineSize
+}
+
+// Connected drops the entries of the node, which sends the current ones again, so the
+// entries removed while it was unreachable are not aggregated anymore.
+func (w *nodeWatcher) Connected() {
+	a := w.aggregator
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	delete(a.disconnected, w.node)
+	for _, nodes := range a.values {
+		if _, ok := nodes[w.node]; ok {
+			nodes[w.node] = map[uint64]v1.KvPair{}
+		}
+	}
+}
+
+// Disconnected starts the deadline of the entries of the node, unless already unreachable.
+func (w *nodeWatcher) Disconnected(err error) {
+	a := w.aggregator
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, ok := a.disconnected[w.node]; !ok {
+		a.disconnected[w.node] = a.now()
+	}
+}
+
+// Close removes the entries and the clock of the node, once it is not watched anymore.
+func (w *nodeWatcher) Close() {
+	a := w.aggregator
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for _, nodes := range a.values {
+		delete(nodes, w.node)
+	}
+	delete(a.clocks, w.node)
+	delete(a.disconnected, w.node)
+}
+
+// stale returns whether the agent of the node has been unreachable for longer than the
+// staleness deadline, its entries not being aggregated anymore. The lock must be held.
+func (a *Aggregator) stale(node string) bool {
+	since, ok := a.disconnected[node]
+	return ok && a.now().Sub(since) > a.staleAfter
+}
+
+// liveNodes returns the nodes reporting a map whose entries are aggregated. The lock must be
+// held.
+func (a *Aggregator) liveNodes(nodes map[string]map[uint64]v1.KvPair) int {
+	live := 0
+	for node := range nodes {
+		if !a.stale(node) {
+			live++
+		}
+	}
+	return live
+}
+
+// Clocks returns the clocks of the nodes, as last sampled, sorted by node.
+func (a *Aggregator) Clocks() []v1.NodeClock {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	clocks := make([]v1.NodeClock, 0, len(a.clocks))
+	for _, clock := range a.clocks {
+		clocks = append(clocks, *clock)
+	}
+	sort.Slice(clocks, func(i, j int) bool {
+		return clocks[i].Node < clocks[j].Node
+	})
+	return clocks
+}
+
+// Timeline returns the recent events of the ring buffers of the nodes, of all the maps if
+// the name is empty, in the order they happened as estimated from the clocks of the nodes.
+// The events of a node are always in the order it received them.
+func (a *Aggregator) Timeline(name string) []v1.TimelineEvent {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	events := []v1.TimelineEvent{}
+	for _, record := range a.events {
+		if name == "" || record.entry.Name == name {
+			events = append(events, a.timelineEvent(record))
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].Time.Equal(events[j].Time) {
+			return events[i].Time.Before(events[j].Time)
+		}
+		if events[i].Node != events[j].Node {
+			return events[i].Node < events[j].Node
+		}
+		return events[i].Hint.Seq < events[j].Hint.Seq
+	})
+	return events
+}
+
+// timelineEvent times the event on the clock of the aggregator, from the boot time elapsed
+// since the clock sample of its node, which unlike its wall clock is never stepped. Its
+// skew is the error of the sample and the drift of the clock since. The lock must be held.
+func (a *Aggregator) timelineEvent(record timelineRecord) v1.TimelineEvent {
+	event := v1.TimelineEvent{
+		Node:   record.node,
+		Map:    record.entry.Name,
+		Fields: record.entry.Entry.Key,
+		Hint:   record.hint,
+		Time:   record.hint.Time,
+	}
+	clock := a.clocks[record.node]
+	if clock == nil || clock.SampledAt.IsZero() || record.hint.Boottime == 0 || clock.Status.Boottime == 0 {
+		return event
+	}
+	elapsed := record.hint.Boottime - clock.Status.Boottime
+	if abs(record.hint.Time.Sub(clock.Status.Time)-elapsed) > maxClockStep {
+		return event
+	}
+	event.Time = clock.SampledAt.Add(elapsed)
+	event.Skew = clock.RTT/2 + time.Duration(float64(abs(elapsed))*maxClockDrift)
+	event.Estimated = true
+	return event
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// Maps returns the aggregated maps, sorted by name.
+func (a *Aggregator) Maps() []v1.FleetMap {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	maps := make([]v1.FleetMap, 0, len(a.values))
+	for name, nodes := range a.values {
+		maps = append(maps, v1.FleetMap{Name: name, Keys: a.keys[name], Nodes: a.liveNodes(nodes)})
+	}
+	sort.Slice(maps, func(i, j int) bool {
+		return maps[i].Name < maps[j].Name
+	})
+	return maps
+}
+
+// View merges the given map across all nodes.
+func (a *Aggregator) View(name string) (*v1.FleetView, error) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	nodes, ok := a.values[name]
+	if !ok {
+		return nil, fmt.Errorf("no node reported map %s", name)
+	}
+
+	keys := map[uint64]map[string]string{}
+	values := map[uint64][]float64{}
+	for node, entries := range nodes {
+		if a.stale(node) {
+			continue
+		}
+		for hash, entry := range entries {
+			value, err := strconv.ParseFloat(entry.Value, 64)
+			if err != nil {
+				continue
+			}
+			keys[hash] = entry.Key
+			values[hash] = append(values[hash], value)
+		}
+	}
+
+	view := &v1.FleetView{Name: name, Keys: a.keys[name], Nodes: a.liveNodes(nodes)}
+	for hash, vals := range values {
+		view.Rows = append(view.Rows, aggregateRow(keys[hash], vals))
+	}
+	// biggest contributors first
+	sort.Slice(view.Rows, func(i, j int) bool {
+		return view.Rows[i].Sum > view.Rows[j].Sum
+	})
+	return view, nil
+}
+
+func aggregateRow(key map[string]string, values []float64) v1.FleetRow {
+	sort.Float64s(values)
+	row := v1.FleetRow{
+		Key:   key,
+		Nodes: len(values),
+		Min:   values[0],
+		Max:   values[len(values)-1],
+		P50:   percentile(values, 50),
+		P90:   percentile(values, 90),
+		P99:   percentile(values, 99),
+	}
+	for _, v := range values {
+		row.Sum += v
+	}
+	return row
+}
+
+// percentile returns the nearest-rank percentile of the sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Handler returns the http.Handler serving the fleet API.
+func (a *Aggregator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	// no key is needed, for API gateways and client generators
+	mux.HandleFunc(v1.OpenAPIPath, serveOpenAPI)
+	mux.HandleFunc(v1.FleetPath, a.require(RoleRead, a.serveFleet))
+	mux.HandleFunc(v1.FleetClocksPath, a.require(RoleRead, func(w http.ResponseWriter, r *http.Request) {
+		writeFleetJSON(w, r, a.Clocks())
+	}))
+	mux.HandleFunc(v1.FleetTimelinePath, a.require(RoleRead, func(w http.ResponseWriter, r *http.Request) {
+		writeFleetJSON(w, r, a.Timeline(r.URL.Query().Get("map")))
+	}))
+	return mux
+}
+
+func (a *Aggregator) require(role Role, handler http.HandlerFunc) http.HandlerFunc {
+	if a.auth == nil {
+		return handler
+	}
+	return a.auth.require(role, handler)
+}
+
+func (a *Aggregator) serveFleet(w http.ResponseWriter, r *http.Request) {
+	var body interface{} = a.Maps()
+	if name := r.URL.Query().Get("map"); name != "" {
+		view, err := a.View(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		body = view
+	}
+	writeFleetJSON(w, r, body)
+}
+
+func writeFleetJSON(w http.ResponseWriter, r *http.Request, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		contextutils.LoggerFrom(r.Context()).Errorf("could not write fleet response: %v", err)
+	}
+}
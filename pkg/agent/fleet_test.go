@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"errors"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/client"
+	"github.com/solo-io/bumblebee/pkg/loader"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Aggregator", func() {
+	var aggregator *Aggregator
+
+	send := func(node, dport, value string) {
+		w := aggregator.NodeWatcher(node)
+		w.NewHashMap("retransmits", []string{"dport"})
+		w.SendEntry(loader.MapEntry{
+			Name: "retransmits",
+			Entry: loader.KvPair{
+				Key:   map[string]string{"dport": dport},
+				Value: value,
+			},
+		})
+	}
+
+	BeforeEach(func() {
+		aggregator = NewAggregator()
+	})
+
+	It("merges keys across nodes", func() {
+		send("node-a", "443", "10")
+		send("node-b", "443", "30")
+		send("node-c", "443", "20")
+		// a later value replaces the previous one of the node
+		send("node-c", "443", "5")
+
+		view, err := aggregator.View("retransmits")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(view.Nodes).To(Equal(3))
+		Expect(view.Rows).To(HaveLen(1))
+		row := view.Rows[0]
+		Expect(row.Key).To(Equal(map[string]string{"dport": "443"}))
+		Expect(row.Nodes).To(Equal(3))
+		Expect(row.Sum).To(Equal(45.0))
+		Expect(row.Min).To(Equal(5.0))
+		Expect(row.Max).To(Equal(30.0))
+		Expect(row.P50).To(Equal(10.0))
+		Expect(row.P99).To(Equal(30.0))
+	})
+
+	It("keeps keys apart", func() {
+		send("node-a", "443", "10")
+		send("node-b", "80", "30")
+
+		view, err := aggregator.View("retransmits")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(view.Rows).To(HaveLen(2))
+		// sorted by sum
+		Expect(view.Rows[0].Key).To(Equal(map[string]string{"dport": "80"}))
+	})
+
+	It("ignores values which are not numeric", func() {
+		send("node-a", "443", "10")
+		send("node-b", "443", "n/a")
+
+		view, err := aggregator.View("retransmits")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(view.Rows[0].Nodes).To(Equal(1))
+	})
+
+	It("drops the entries of a node once closed", func() {
+		send("node-a", "443", "10")
+		send("node-b", "443", "30")
+		aggregator.NodeWatcher("node-b").Close()
+
+		view, err := aggregator.View("retransmits")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(view.Nodes).To(Equal(1))
+		Expect(view.Rows[0].Sum).To(Equal(10.0))
+	})
+
+	It("drops the entries deleted by a node", func() {
+		send("node-a", "443", "10")
+		send("node-a", "80", "5")
+		send("node-b", "443", "30")
+		aggregator.NodeWatcher("node-a").(v1.DeletingWatcher).DeleteEntry(loader.MapEntry{
+			Name:  "retransmits",
+			Entry: loader.KvPair{Key: map[string]string{"dport": "443"}},
+		})
+
+		view, err := aggregator.View("retransmits")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(view.Rows).To(HaveLen(2))
+		Expect(view.Rows[0].Sum).To(Equal(30.0))
+		Expect(view.Rows[1].Sum).To(Equal(5.0))
+	})
+
+	It("stops aggregating the nodes unreachable past the deadline", func() {
+		now := time.Now()
+		aggregator.now = func() time.Time { return now }
+		aggregator.SetStaleAfter(time.Minute)
+		send("node-a", "443", "10")
+		send("node-b", "443", "30")
+		w := aggregator.NodeWatcher("node-b").(client.ConnectionWatcher)
+		w.Disconnected(errors.New("connection refused"))
+
+		now = now.Add(30 * time.Second)
+		view, err := aggregator.View("retransmits")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(view.Rows[0].Sum).To(Equal(40.0))
+
+		// the deadline starts at the first failure
+		w.Disconnected(errors.New("connection refused"))
+		now = now.Add(31 * time.Second)
+		view, err = aggregator.View("retransmits")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(view.Nodes).To(Equal(1))
+		Expect(view.Rows[0].Sum).To(Equal(10.0))
+		Expect(aggregator.Maps()[0].Nodes).To(Equal(1))
+
+		// the entries are sent again once reconnected, the removed ones are not aggregated
+		w.Connected()
+		view, err = aggregator.View("retransmits")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(view.Nodes).To(Equal(2))
+		Expect(view.Rows[0].Sum).To(Equal(10.0))
+		send("node-b", "443", "5")
+		view, err = aggregator.View("retransmits")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(view.Rows[0].Sum).To(Equal(15.0))
+	})
+
+	It("errors for unknown maps", func() {
+		_, err := aggregator.View("retransmits")
+		Expect(err).To(HaveOccurred())
+	})
+})
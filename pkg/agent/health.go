@@ -0,0 +1,239 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+// HealthOpts configure the checks of the probes of the agent.
+type HealthOpts struct {
+	// Interval the checks run at, 10s by default
+	Interval time.Duration
+	// Consecutive failures of a check before it fails the probes, 3 by default
+	FailureThreshold int
+	// Time the watchers may take to receive an entry before the pipeline is reported
+	// backpressured, failing readiness, 1s by default
+	MaxDeliveryTime time.Duration
+	// Time an entry may be delivered for before the pipeline is reported stuck, failing
+	// liveness, 1m by default
+	StuckDeliveryTime time.Duration
+}
+
+func (o *HealthOpts) initDefaults() {
+	if o.Interval == 0 {
+		o.Interval = 10 * time.Second
+	}
+	if o.FailureThreshold == 0 {
+		o.FailureThreshold = 3
+	}
+	if o.MaxDeliveryTime == 0 {
+		o.MaxDeliveryTime = time.Second
+	}
+	if o.StuckDeliveryTime == 0 {
+		o.StuckDeliveryTime = time.Minute
+	}
+}
+
+// Health runs the checks of the agent served by the /healthz and /readyz probes of the
+// Server. A check fails the probes once it failed FailureThreshold consecutive times, so a
+// blip of the registry does not restart the agent.
+type Health struct {
+	opts   HealthOpts
+	lock   sync.Mutex
+	checks []*healthCheck
+}
+
+type healthCheck struct {
+	name     string
+	liveness bool
+	check    func(ctx context.Context) error
+	failures int
+	err      error
+}
+
+func NewHealth(opts HealthOpts) *Health {
+	opts.initDefaults()
+	return &Health{opts: opts}
+}
+
+// AddLivenessCheck adds a check failing both probes, so the agent is restarted, e.g. when its
+// program can't be read anymore.
+func (h *Health) AddLivenessCheck(name string, check func(ctx context.Context) error) {
+	h.addCheck(name, true, check)
+}
+
+// AddReadinessCheck adds a check only failing the readiness probe, e.g. when the registry of
+// its program is unreachable.
+func (h *Health) AddReadinessCheck(name string, check func(ctx context.Context) error) {
+	h.addCheck(name, false, check)
+}
+
+func (h *Health) addCheck(name string, liveness bool, check func(ctx context.Context) error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.checks = append(h.checks, &healthCheck{name: name, liveness: liveness, check: check})
+}
+
+// Start runs the checks every interval until the context is done.
+func (h *Health) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(h.opts.Interval)
+		defer ticker.Stop()
+		for {
+			h.Check(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Check runs the checks once, each bounded by the interval.
+func (h *Health) Check(ctx context.Context) {
+	h.lock.Lock()
+	checks := append([]*healthCheck(nil), h.checks...)
+	h.lock.Unlock()
+
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check *healthCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, h.opts.Interval)
+			defer cancel()
+			errs[i] = check.check(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for i, check := range checks {
+		check.err = errs[i]
+		if check.err != nil {
+			check.failures++
+		} else {
+			check.failures = 0
+		}
+	}
+}
+
+// Report returns the results of the liveness checks, or of all the checks for readiness.
+func (h *Health) Report(readiness bool) v1.HealthReport {
+	report := v1.HealthReport{Healthy: true, Checks: []v1.HealthCheck{}}
+	if h == nil {
+		return report
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, check := range h.checks {
+		if !readiness && !check.liveness {
+			continue
+		}
+		result := v1.HealthCheck{
+			Name:     check.name,
+			Liveness: check.liveness,
+			Healthy:  check.failures < h.opts.FailureThreshold,
+			Failures: check.failures,
+		}
+		if check.err != nil {
+			result.Error = check.err.Error()
+		}
+		report.Healthy = report.Healthy && result.Healthy
+		report.Checks = append(report.Checks, result)
+	}
+	return report
+}
+
+// WatchPipeline returns a MapWatcher forwarding everything to the watcher, checking how long
+// it takes to receive entries: the pipeline is backpressured when an entry took longer than
+// MaxDeliveryTime since the previous check, failing readiness, and stuck when an entry is
+// being delivered for longer than StuckDeliveryTime, failing liveness.
+func (h *Health) WatchPipeline(watcher v1.MapWatcher) v1.MapWatcher {
+	p := &pipelineWatcher{MapWatcher: watcher}
+	h.AddReadinessCheck("pipeline-backpressure", func(context.Context) error {
+		if slowest := p.slowestDelivery(); slowest > h.opts.MaxDeliveryTime {
+			return fmt.Errorf("an entry took %s to be delivered to the sinks, over the maximum of %s", slowest.Round(time.Millisecond), h.opts.MaxDeliveryTime)
+		}
+		return nil
+	})
+	h.AddLivenessCheck("pipeline", func(context.Context) error {
+		if since := p.deliveringSince(); !since.IsZero() && time.Since(since) > h.opts.StuckDeliveryTime {
+			return fmt.Errorf("an entry is being delivered to the sinks since %s", since.Format(time.RFC3339))
+		}
+		return nil
+	})
+	return p
+}
+
+type pipelineWatcher struct {
+	v1.MapWatcher
+	lock sync.Mutex
+	// start of the delivery in progress, zero if none is
+	delivering time.Time
+	// slowest delivery since the previous check
+	slowest time.Duration
+}
+
+// DeleteEntry forwards the deletion, if the watcher keeps the entries of hash maps.
+func (p *pipelineWatcher) DeleteEntry(entry v1.MapEntry) {
+	if deleting, ok := p.MapWatcher.(v1.DeletingWatcher); ok {
+		deleting.DeleteEntry(entry)
+	}
+}
+
+func (p *pipelineWatcher) SendEntry(entry v1.MapEntry) {
+	start := time.Now()
+	p.lock.Lock()
+	p.delivering = start
+	p.lock.Unlock()
+
+	p.MapWatcher.SendEntry(entry)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.delivering = time.Time{}
+	if took := time.Since(start); took > p.slowest {
+		p.slowest = took
+	}
+}
+
+func (p *pipelineWatcher) slowestDelivery() time.Duration {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	slowest := p.slowest
+	if !p.delivering.IsZero() && time.Since(p.delivering) > slowest {
+		slowest = time.Since(p.delivering)
+	}
+	p.slowest = 0
+	return slowest
+}
+
+func (p *pipelineWatcher) deliveringSince() time.Time {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.delivering
+}
+
+// serveHealth serves the report of the liveness checks, or of all the checks for readiness.
+func (s *Server) serveHealth(readiness bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := s.health.Report(readiness)
+		status := http.StatusOK
+		if !report.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/loader"
+)
+
+type slowWatcher struct {
+	v1.MapWatcher
+	delay time.Duration
+}
+
+func (w *slowWatcher) SendEntry(entry v1.MapEntry) {
+	time.Sleep(w.delay)
+}
+
+var _ = Describe("health probes", func() {
+	probe := func(handler http.Handler, path string) (int, v1.HealthReport) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var report v1.HealthReport
+		Expect(json.Unmarshal(rec.Body.Bytes(), &report)).To(Succeed())
+		return rec.Code, report
+	}
+
+	It("fails the probes once a check failed the threshold of consecutive times", func() {
+		health := NewHealth(HealthOpts{FailureThreshold: 2})
+		var registryErr error
+		health.AddReadinessCheck("registry", func(context.Context) error {
+			return registryErr
+		})
+		server := NewServer()
+		server.SetHealth(health)
+		handler := server.Handler()
+		ctx := context.Background()
+
+		registryErr = errors.New("unreachable")
+		health.Check(ctx)
+		status, report := probe(handler, v1.ReadyzPath)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(report.Checks).To(ConsistOf(v1.HealthCheck{Name: "registry", Healthy: true, Failures: 1, Error: "unreachable"}))
+
+		health.Check(ctx)
+		status, report = probe(handler, v1.ReadyzPath)
+		Expect(status).To(Equal(http.StatusServiceUnavailable))
+		Expect(report.Healthy).To(BeFalse())
+
+		// readiness checks do not fail liveness
+		status, report = probe(handler, v1.HealthzPath)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(report.Checks).To(BeEmpty())
+
+		registryErr = nil
+		health.Check(ctx)
+		status, report = probe(handler, v1.ReadyzPath)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(report.Checks).To(ConsistOf(v1.HealthCheck{Name: "registry", Healthy: true}))
+	})
+
+	It("fails both probes with liveness checks", func() {
+		health := NewHealth(HealthOpts{FailureThreshold: 1})
+		health.AddLivenessCheck("program", func(context.Context) error {
+			return errors.New("gone")
+		})
+		server := NewServer()
+		server.SetHealth(health)
+		handler := server.Handler()
+		health.Check(context.Background())
+
+		for _, path := range []string{v1.HealthzPath, v1.ReadyzPath} {
+			status, report := probe(handler, path)
+			Expect(status).To(Equal(http.StatusServiceUnavailable))
+			Expect(report.Checks).To(ConsistOf(v1.HealthCheck{Name: "program", Liveness: true, Failures: 1, Error: "gone"}))
+		}
+	})
+
+	It("does not authenticate the probes", func() {
+		server := NewServer()
+		server.SetAuthenticator(NewAuthenticator(""))
+		status, report := probe(server.Handler(), v1.HealthzPath)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(report.Healthy).To(BeTrue())
+	})
+
+	It("reports the backpressure of the pipeline", func() {
+		health := NewHealth(HealthOpts{FailureThreshold: 1, MaxDeliveryTime: 20 * time.Millisecond})
+		watcher := &slowWatcher{MapWatcher: loader.NewNoopWatcher(), delay: 50 * time.Millisecond}
+		pipeline := health.WatchPipeline(watcher)
+		ctx := context.Background()
+
+		pipeline.SendEntry(v1.MapEntry{})
+		health.Check(ctx)
+		report := health.Report(true)
+		Expect(report.Healthy).To(BeFalse())
+		Expect(report.Checks[0].Name).To(Equal("pipeline-backpressure"))
+		Expect(report.Checks[0].Error).To(ContainSubstring("over the maximum of 20ms"))
+		Expect(health.Report(false).Healthy).To(BeTrue())
+
+		// the slowest delivery is the one since the previous check
+		watcher.delay = 0
+		pipeline.SendEntry(v1.MapEntry{})
+		health.Check(ctx)
+		Expect(health.Report(true).Healthy).To(BeTrue())
+	})
+})
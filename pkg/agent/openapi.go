@@ -0,0 +1,242 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+// The subset of OpenAPI 3 describing the API, encoded with sorted keys so the document
+// only changes with the routes and their types.
+type (
+	openAPIDoc struct {
+		OpenAPI    string                          `json:"openapi"`
+		Info       openAPIInfo                     `json:"info"`
+		Paths      map[string]map[string]operation `json:"paths"`
+		Components components                      `json:"components"`
+	}
+	openAPIInfo struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Version     string `json:"version"`
+	}
+	operation struct {
+		Summary     string                `json:"summary"`
+		Description string                `json:"description,omitempty"`
+		OperationID string                `json:"operationId"`
+		Tags        []string              `json:"tags"`
+		Parameters  []parameter           `json:"parameters,omitempty"`
+		RequestBody *requestBody          `json:"requestBody,omitempty"`
+		Responses   map[string]response   `json:"responses"`
+		Security    []map[string][]string `json:"security,omitempty"`
+		Role        string                `json:"x-bee-role,omitempty"`
+	}
+	parameter struct {
+		Name        string  `json:"name"`
+		In          string  `json:"in"`
+		Description string  `json:"description"`
+		Schema      *schema `json:"schema"`
+	}
+	requestBody struct {
+		Required bool                 `json:"required"`
+		Content  map[string]mediaType `json:"content"`
+	}
+	response struct {
+		Description string               `json:"description"`
+		Content     map[string]mediaType `json:"content,omitempty"`
+	}
+	mediaType struct {
+		Schema *schema `json:"schema"`
+	}
+	components struct {
+		Schemas         map[string]*schema        `json:"schemas"`
+		SecuritySchemes map[string]securityScheme `json:"securitySchemes"`
+	}
+	securityScheme struct {
+		Type        string `json:"type"`
+		Scheme      string `json:"scheme"`
+		Description string `json:"description"`
+	}
+	schema struct {
+		Ref                  string             `json:"$ref,omitempty"`
+		Type                 string             `json:"type,omitempty"`
+		Format               string             `json:"format,omitempty"`
+		Items                *schema            `json:"items,omitempty"`
+		Properties           map[string]*schema `json:"properties,omitempty"`
+		AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+		Required             []string           `json:"required,omitempty"`
+		OneOf                []*schema          `json:"oneOf,omitempty"`
+		Nullable             bool               `json:"nullable,omitempty"`
+	}
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// OpenAPI returns the OpenAPI 3 document of v1.Routes, as embedded in v1.OpenAPIDocument.
+func OpenAPI() ([]byte, error) {
+	doc := openAPIDoc{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:       "bumblebee agent API",
+			Description: "Maps of the program run by `bee run --api-port`, and the fleet views of `bee fleet`.",
+			// rather than the version of bee, so the document only changes with the API
+			Version: strings.TrimPrefix(v1.APIPrefix, "/api/"),
+		},
+		Paths: map[string]map[string]operation{},
+		Components: components{
+			Schemas: map[string]*schema{},
+			SecuritySchemes: map[string]securityScheme{
+				"apiKey": {
+					Type:        "http",
+					Scheme:      "bearer",
+					Description: "API key or HS256 JWT, required when the agent or the fleet is run with --api-keys. The role of the key, read or admin, is listed by the x-bee-role of the operations.",
+				},
+			},
+		},
+	}
+	for _, route := range v1.Routes {
+		op := operation{
+			Summary:     route.Summary,
+			OperationID: operationID(route.Path),
+			Tags:        []string{"agent"},
+			Responses:   map[string]response{},
+		}
+		if route.Fleet {
+			op.Tags = []string{"fleet"}
+		}
+		if route.Control {
+			op.Description = "Only served when the agent is run with --api-control, 404 otherwise."
+			op.Tags = append(op.Tags, "control")
+			op.Responses["409"] = response{Description: "The program is not in a state allowing the action"}
+		}
+		if route.Role != "" {
+			op.Security = []map[string][]string{{"apiKey": {}}}
+			op.Role = route.Role
+			op.Responses["401"] = response{Description: "Missing or unknown key"}
+			op.Responses["403"] = response{Description: fmt.Sprintf("The key does not have the %s role", route.Role)}
+		}
+		for _, p := range route.Params {
+			op.Parameters = append(op.Parameters, parameter{Name: p.Name, In: "query", Description: p.Description, Schema: &schema{Type: "string"}})
+		}
+		if route.Request != nil {
+			op.RequestBody = &requestBody{
+				Required: true,
+				Content:  map[string]mediaType{"application/json": {Schema: schemaOf(reflect.TypeOf(route.Request), doc.Components.Schemas)}},
+			}
+			op.Responses["400"] = response{Description: "Invalid request body"}
+		}
+
+		var schemas []*schema
+		for _, r := range route.Responses {
+			schemas = append(schemas, schemaOf(reflect.TypeOf(r), doc.Components.Schemas))
+		}
+		body := schemas[0]
+		if len(schemas) > 1 {
+			body = &schema{OneOf: schemas}
+		}
+		contentType := "application/json"
+		if route.Stream {
+			contentType = "application/x-ndjson"
+		}
+		op.Responses["200"] = response{Description: "OK", Content: map[string]mediaType{contentType: {Schema: body}}}
+		if route.Probe {
+			op.Responses["503"] = response{Description: "A check failed", Content: map[string]mediaType{contentType: {Schema: body}}}
+		}
+
+		if doc.Paths[route.Path] == nil {
+			doc.Paths[route.Path] = map[string]operation{}
+		}
+		doc.Paths[route.Path][strings.ToLower(route.Method)] = op
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// operationID names an operation after its path, e.g. programPause for /api/v1/program/pause.
+func operationID(path string) string {
+	path = strings.TrimSuffix(path, ".json")
+	parts := strings.Split(strings.TrimPrefix(strings.TrimPrefix(path, v1.APIPrefix), "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = strings.Title(parts[i])
+	}
+	return strings.Join(parts, "")
+}
+
+// schemaOf returns the schema of a type, adding the structs it references to the components.
+func schemaOf(t reflect.Type, components map[string]*schema) *schema {
+	if t == timeType {
+		return &schema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := schemaOf(t.Elem(), components)
+		if s.Ref != "" {
+			// siblings of $ref are ignored
+			return s
+		}
+		s.Nullable = true
+		return s
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &schema{Type: "integer", Format: "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &schema{Type: "integer", Format: "int32"}
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number", Format: "double"}
+	case reflect.Slice, reflect.Array:
+		return &schema{Type: "array", Items: schemaOf(t.Elem(), components)}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), components)}
+	case reflect.Struct:
+		ref := &schema{Ref: "#/components/schemas/" + t.Name()}
+		if _, ok := components[t.Name()]; ok {
+			return ref
+		}
+		s := &schema{Type: "object", Properties: map[string]*schema{}}
+		// before the fields, in case they reference the struct
+		components[t.Name()] = s
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name, omitempty := field.Name, false
+			if tag, ok := field.Tag.Lookup("json"); ok {
+				opts := strings.Split(tag, ",")
+				if opts[0] == "-" {
+					continue
+				}
+				if opts[0] != "" {
+					name = opts[0]
+				}
+				for _, o := range opts[1:] {
+					omitempty = omitempty || o == "omitempty"
+				}
+			}
+			s.Properties[name] = schemaOf(field.Type, components)
+			if !omitempty && field.Type.Kind() != reflect.Ptr {
+				s.Required = append(s.Required, name)
+			}
+		}
+		sort.Strings(s.Required)
+		return ref
+	}
+	return &schema{}
+}
+
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(v1.OpenAPIDocument)
+}
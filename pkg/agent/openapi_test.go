@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/overrides"
+	"github.com/solo-io/bumblebee/pkg/policy"
+)
+
+var _ = Describe("OpenAPI", func() {
+	It("is up to date with the routes", func() {
+		doc, err := OpenAPI()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(v1.OpenAPIDocument)).To(Equal(string(doc)), "run `go generate ./api/v1` to update api/v1/openapi.json")
+	})
+
+	It("generates the Python client", func() {
+		client, err := PythonClient(v1.OpenAPIDocument)
+		Expect(err).NotTo(HaveOccurred())
+		committed, err := ioutil.ReadFile("../../clients/python/bumblebee_client.py")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(committed)).To(Equal(string(client)), "run `go generate ./api/v1` to update clients/python/bumblebee_client.py")
+		Expect(string(client)).To(ContainSubstring("def program_trigger(self, reason: str = \"\") -> \"ProgramState\":"))
+		Expect(string(client)).To(ContainSubstring("def watch(self) -> Iterator[\"Event\"]:"))
+	})
+
+	It("documents the routes served", func() {
+		server := NewServer()
+		server.SetController(&fakeTrigger{})
+		dir, err := os.MkdirTemp("", "bee-overrides")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		store, err := overrides.Open(filepath.Join(dir, overrides.FileName))
+		Expect(err).NotTo(HaveOccurred())
+		server.SetOverrides(store, digest.FromString("program"), nil)
+		policyStore, err := policy.Open(dir, "program")
+		Expect(err).NotTo(HaveOccurred())
+		server.SetPolicy(policyStore, &fakeEnforcer{})
+		server.SetEnforcement(&fakeSwitch{})
+		agentServer := httptest.NewServer(server.Handler())
+		defer agentServer.Close()
+		fleetServer := httptest.NewServer(NewAggregator().Handler())
+		defer fleetServer.Close()
+
+		for _, route := range v1.Routes {
+			url := agentServer.URL + route.Path
+			if route.Fleet {
+				url = fleetServer.URL + route.Path
+			}
+			if route.Stream {
+				// closed by the server
+				server.Close()
+			}
+			var body io.Reader
+			if route.Request != nil {
+				body = strings.NewReader("{}")
+			}
+			switch route.Path {
+			case v1.UpdatePolicyPath:
+				body = strings.NewReader(`{"map": "denylist", "add": [{"key": "10.0.0.1"}]}`)
+			case v1.RollbackPolicyPath:
+				url += "?version=0"
+			case v1.EnforcementModePath:
+				url += "?mode=enforce"
+			}
+			req, err := http.NewRequest(route.Method, url, body)
+			Expect(err).NotTo(HaveOccurred())
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK), route.Method+" "+route.Path)
+		}
+	})
+
+	It("is served as JSON", func() {
+		server := httptest.NewServer(NewServer().Handler())
+		defer server.Close()
+		resp, err := http.Get(server.URL + v1.OpenAPIPath)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+		body, err := ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+
+		var doc struct {
+			OpenAPI string                            `json:"openapi"`
+			Paths   map[string]map[string]interface{} `json:"paths"`
+		}
+		Expect(json.Unmarshal(body, &doc)).To(Succeed())
+		Expect(strings.HasPrefix(doc.OpenAPI, "3.")).To(BeTrue())
+		Expect(doc.Paths[v1.PausePath]).To(HaveKey("post"))
+	})
+})
@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/overrides"
+)
+
+// packageOverrides are the overrides of the package of the program served by the API.
+type packageOverrides struct {
+	store    *overrides.Store
+	digest   digest.Digest
+	validate func(v1.PackageOverrides) error
+}
+
+// SetOverrides allows API clients to get, set and clear the overrides persisted in the store
+// for the package of the digest, set only once validated by the function. Pausing or resuming
+// the program through the API also persists whether it is paused. It is only served along
+// with the controller, and must be called before the API is served.
+func (s *Server) SetOverrides(store *overrides.Store, dgst digest.Digest, validate func(v1.PackageOverrides) error) {
+	s.overrides = &packageOverrides{store: store, digest: dgst, validate: validate}
+}
+
+func (s *Server) serveOverrides(w http.ResponseWriter, r *http.Request) {
+	current, err := s.overrides.store.Get(s.overrides.digest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeOverrides(w, current)
+}
+
+func (s *Server) serveSetOverrides(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var set v1.PackageOverrides
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&set); err != nil {
+		http.Error(w, "invalid overrides: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.overrides.validate != nil {
+		if err := s.overrides.validate(set); err != nil {
+			http.Error(w, "invalid overrides: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := s.overrides.store.Set(s.overrides.digest, set); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeOverrides(w, set)
+}
+
+func (s *Server) serveClearOverrides(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.overrides.store.Clear(s.overrides.digest); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeOverrides(w, v1.PackageOverrides{})
+}
+
+// persistPaused returns the action persisting whether the program is paused once it succeeded,
+// the action itself if the overrides are not served.
+func (s *Server) persistPaused(action func() (v1.ProgramState, error)) func() (v1.ProgramState, error) {
+	if s.overrides == nil {
+		return action
+	}
+	return func() (v1.ProgramState, error) {
+		state, err := action()
+		if err != nil {
+			return state, err
+		}
+		if err := s.overrides.store.Update(s.overrides.digest, func(o *v1.PackageOverrides) {
+			o.Paused = state.Paused
+		}); err != nil {
+			return state, fmt.Errorf("the program is in the requested state, but it could not be persisted: %w", err)
+		}
+		return state, nil
+	}
+}
+
+func writeOverrides(w http.ResponseWriter, overrides v1.PackageOverrides) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(overrides)
+}
@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/client"
+	"github.com/solo-io/bumblebee/pkg/overrides"
+)
+
+var _ = Describe("package overrides", func() {
+	var (
+		dir        string
+		store      *overrides.Store
+		dgst       = digest.FromString("tcpconnect")
+		httpServer *httptest.Server
+		agent      *client.Client
+		ctx        = context.Background()
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "bee-overrides")
+		Expect(err).NotTo(HaveOccurred())
+		store, err = overrides.Open(filepath.Join(dir, overrides.FileName))
+		Expect(err).NotTo(HaveOccurred())
+
+		server := NewServer()
+		server.SetController(&fakeController{state: v1.ProgramState{Strategy: v1.GatePauseStrategy}})
+		server.SetOverrides(store, dgst, func(o v1.PackageOverrides) error {
+			if _, ok := o.Parameters["unknown"]; ok {
+				return errors.New("the program has no unknown parameter")
+			}
+			return nil
+		})
+		httpServer = httptest.NewServer(server.Handler())
+		agent = client.New(httpServer.URL, nil)
+	})
+
+	AfterEach(func() {
+		httpServer.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("sets, returns and clears the overrides of the package", func() {
+		set := v1.PackageOverrides{
+			Parameters: map[string]string{"target_pid": "1234"},
+			Routes:     map[string][]string{"parquet": {"events_ring"}},
+		}
+		_, err := agent.SetOverrides(ctx, set)
+		Expect(err).NotTo(HaveOccurred())
+		got, err := agent.Overrides(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*got).To(Equal(set))
+		persisted, err := store.Get(dgst)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(persisted).To(Equal(set))
+
+		Expect(agent.ClearOverrides(ctx)).To(Succeed())
+		got, err = agent.Overrides(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*got).To(Equal(v1.PackageOverrides{}))
+	})
+
+	It("persists whether the program is paused", func() {
+		_, err := agent.Pause(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		persisted, err := store.Get(dgst)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(persisted.Paused).To(BeTrue())
+
+		_, err = agent.Resume(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		persisted, err = store.Get(dgst)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(persisted.Paused).To(BeFalse())
+	})
+
+	It("rejects invalid overrides", func() {
+		_, err := agent.SetOverrides(ctx, v1.PackageOverrides{Parameters: map[string]string{"unknown": "1"}})
+		var statusErr *client.StatusError
+		Expect(errors.As(err, &statusErr)).To(BeTrue())
+		Expect(statusErr.Code).To(Equal(http.StatusBadRequest))
+		Expect(statusErr.Message).To(ContainSubstring("no unknown parameter"))
+		persisted, err := store.Get(dgst)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(persisted).To(Equal(v1.PackageOverrides{}))
+	})
+})
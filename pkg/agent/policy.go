@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/policy"
+)
+
+// PolicyEnforcer writes the policies to the enforcement maps of the program, e.g. a
+// loader.Policy.
+type PolicyEnforcer interface {
+	// Check returns an error if the policy can't be written to the maps
+	Check(v1.Policy) error
+	// Apply replaces the entries of the maps by the ones of the policy
+	Apply(v1.Policy) error
+}
+
+// enforcedPolicy is the policy of the enforcement maps of the program served by the API.
+type enforcedPolicy struct {
+	store    *policy.Store
+	enforcer PolicyEnforcer
+}
+
+// SetPolicy allows API clients to read the policy of the enforcement maps of the program
+// persisted in the store, with its versions and audit log, and admin clients to change it
+// once applied by the enforcer. It is only served along with the controller, and must be
+// called before the API is served.
+func (s *Server) SetPolicy(store *policy.Store, enforcer PolicyEnforcer) {
+	s.policy = &enforcedPolicy{store: store, enforcer: enforcer}
+}
+
+// apply checks and applies a policy, the policies which can't be written being invalid.
+func (p *enforcedPolicy) apply(next v1.Policy) error {
+	if err := p.enforcer.Check(next); err != nil {
+		return fmt.Errorf("%w: %v", policy.ErrInvalid, err)
+	}
+	return p.enforcer.Apply(next)
+}
+
+func (s *Server) servePolicy(w http.ResponseWriter, r *http.Request) {
+	current, err := s.policy.store.Current()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writePolicy(w, current)
+}
+
+func (s *Server) serveUpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var update v1.PolicyUpdate
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	// so 64 bits keys are not rounded
+	dec.UseNumber()
+	if err := dec.Decode(&update); err != nil {
+		http.Error(w, "invalid policy update: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	updated, err := s.policy.store.Update(update, clientName(r), s.policy.apply)
+	if err != nil {
+		writePolicyError(w, err)
+		return
+	}
+	writePolicy(w, updated)
+}
+
+func (s *Server) serveRollbackPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	version, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil || version < 0 {
+		http.Error(w, "the version query parameter must be the version to restore", http.StatusBadRequest)
+		return
+	}
+	restored, err := s.policy.store.Rollback(version, clientName(r), r.URL.Query().Get("comment"), s.policy.apply)
+	if err != nil {
+		writePolicyError(w, err)
+		return
+	}
+	writePolicy(w, restored)
+}
+
+func (s *Server) servePolicyVersions(w http.ResponseWriter, r *http.Request) {
+	if param := r.URL.Query().Get("version"); param != "" {
+		version, err := strconv.Atoi(param)
+		if err != nil || version < 0 {
+			http.Error(w, "invalid version "+param, http.StatusBadRequest)
+			return
+		}
+		p, err := s.policy.store.Version(version)
+		if err != nil {
+			writePolicyError(w, err)
+			return
+		}
+		writePolicy(w, p)
+		return
+	}
+	versions, err := s.policy.store.Versions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writePolicy(w, versions)
+}
+
+func (s *Server) servePolicyAudit(w http.ResponseWriter, r *http.Request) {
+	audit, err := s.policy.store.Audit()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if audit == nil {
+		audit = []v1.PolicyAuditEntry{}
+	}
+	writePolicy(w, audit)
+}
+
+func writePolicyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, policy.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, policy.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, policy.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writePolicy(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/client"
+	"github.com/solo-io/bumblebee/pkg/policy"
+)
+
+// fakeEnforcer only allows IPv4 keys in the denylist map.
+type fakeEnforcer struct {
+	applied []v1.Policy
+}
+
+func (f *fakeEnforcer) Check(p v1.Policy) error {
+	for name, entries := range p.Maps {
+		if name != "denylist" {
+			return fmt.Errorf("map '%s' is not an enforcement map of the program", name)
+		}
+		for _, entry := range entries {
+			if key, _ := entry.Key.(string); strings.Count(key, ".") != 3 {
+				return fmt.Errorf("%v is not an IPv4 address", entry.Key)
+			}
+		}
+	}
+	return nil
+}
+
+func (f *fakeEnforcer) Apply(p v1.Policy) error {
+	f.applied = append(f.applied, p)
+	return nil
+}
+
+var _ = Describe("enforcement policy", func() {
+	var (
+		dir        string
+		enforcer   *fakeEnforcer
+		httpServer *httptest.Server
+		admin      *client.Client
+		reader     *client.Client
+		ctx        = context.Background()
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "bee-policy")
+		Expect(err).NotTo(HaveOccurred())
+		store, err := policy.Open(dir, "xdp-deny")
+		Expect(err).NotTo(HaveOccurred())
+		readKey, _, err := GenerateAPIKey()
+		Expect(err).NotTo(HaveOccurred())
+		adminKey, _, err := GenerateAPIKey()
+		Expect(err).NotTo(HaveOccurred())
+		keysFile := filepath.Join(dir, "keys.yaml")
+		readHash, adminHash := sha256.Sum256([]byte(readKey)), sha256.Sum256([]byte(adminKey))
+		Expect(ioutil.WriteFile(keysFile, []byte(fmt.Sprintf("keys:\n- name: grafana\n  role: read\n  sha256: %x\n- name: ops\n  role: admin\n  sha256: %x\n", readHash, adminHash)), 0600)).To(Succeed())
+		auth := NewAuthenticator(keysFile)
+		_, err = auth.reload()
+		Expect(err).NotTo(HaveOccurred())
+
+		enforcer = &fakeEnforcer{}
+		server := NewServer()
+		server.SetController(&fakeController{state: v1.ProgramState{Strategy: v1.GatePauseStrategy}})
+		server.SetAuthenticator(auth)
+		server.SetPolicy(store, enforcer)
+		httpServer = httptest.NewServer(server.Handler())
+		admin = client.New(httpServer.URL, &client.Options{Token: adminKey})
+		reader = client.New(httpServer.URL, &client.Options{Token: readKey})
+	})
+
+	AfterEach(func() {
+		httpServer.Close()
+		os.RemoveAll(dir)
+	})
+
+	statusOf := func(err error) int {
+		var statusErr *client.StatusError
+		Expect(errors.As(err, &statusErr)).To(BeTrue(), fmt.Sprint(err))
+		return statusErr.Code
+	}
+
+	It("applies the changes of admin clients, auditing them by key name", func() {
+		updated, err := admin.UpdatePolicy(ctx, v1.PolicyUpdate{Map: "denylist", Add: []v1.PolicyEntry{{Key: "10.0.0.1"}}, Comment: "scanner"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.Version).To(Equal(1))
+		Expect(updated.By).To(Equal("ops"))
+		Expect(enforcer.applied).To(HaveLen(1))
+
+		current, err := reader.Policy(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(current.Maps).To(Equal(map[string][]v1.PolicyEntry{"denylist": {{Key: "10.0.0.1"}}}))
+		_, err = reader.UpdatePolicy(ctx, v1.PolicyUpdate{Map: "denylist", Remove: []interface{}{"10.0.0.1"}})
+		Expect(statusOf(err)).To(Equal(http.StatusForbidden))
+
+		_, err = admin.UpdatePolicy(ctx, v1.PolicyUpdate{Map: "denylist", Remove: []interface{}{"10.0.0.1"}, Version: 1})
+		Expect(err).NotTo(HaveOccurred())
+		restored, err := admin.RollbackPolicy(ctx, 1, "false positive")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restored.Version).To(Equal(3))
+		Expect(restored.Maps).To(Equal(current.Maps))
+
+		versions, err := reader.PolicyVersions(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(HaveLen(3))
+		Expect(versions[0].Version).To(Equal(3))
+		audit, err := reader.PolicyAudit(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(audit).To(HaveLen(3))
+		Expect(audit[0].By).To(Equal("ops"))
+		Expect(audit[0].Comment).To(Equal("scanner"))
+		Expect(audit[2].Action).To(Equal(v1.PolicyRollbackAction))
+		Expect(audit[2].Comment).To(Equal("false positive"))
+	})
+
+	It("rejects invalid and conflicting changes", func() {
+		_, err := admin.UpdatePolicy(ctx, v1.PolicyUpdate{Map: "denylist", Add: []v1.PolicyEntry{{Key: "nope"}}})
+		Expect(statusOf(err)).To(Equal(http.StatusBadRequest))
+		Expect(err).To(MatchError(ContainSubstring("nope is not an IPv4 address")))
+		_, err = admin.UpdatePolicy(ctx, v1.PolicyUpdate{Map: "allowlist", Add: []v1.PolicyEntry{{Key: "10.0.0.1"}}})
+		Expect(statusOf(err)).To(Equal(http.StatusBadRequest))
+		Expect(enforcer.applied).To(BeEmpty())
+
+		_, err = admin.UpdatePolicy(ctx, v1.PolicyUpdate{Map: "denylist", Add: []v1.PolicyEntry{{Key: "10.0.0.1"}}, Version: 2})
+		Expect(statusOf(err)).To(Equal(http.StatusConflict))
+		_, err = admin.RollbackPolicy(ctx, 4, "")
+		Expect(statusOf(err)).To(Equal(http.StatusNotFound))
+		audit, err := reader.PolicyAudit(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(audit).To(BeEmpty())
+	})
+})
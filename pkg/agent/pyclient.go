@@ -0,0 +1,214 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+const pythonClientHeader = `# Code generated by ` + "`go generate ./api/v1`" + ` from api/v1/openapi.json. DO NOT EDIT.
+"""Client of the agent API served by ` + "`bee run --api-port`" + `, and of the fleet API of ` + "`bee fleet`" + `.
+
+Only the standard library is required, Python 3.8 or later.
+"""
+
+import json
+import os
+import urllib.error
+import urllib.parse
+import urllib.request
+from typing import Any, Dict, Iterator, List, Optional, TypedDict, Union
+
+`
+
+const pythonClientBase = `
+
+class StatusError(Exception):
+    """Raised when the agent answers with an unexpected status."""
+
+    def __init__(self, code: int, message: str):
+        super().__init__("unexpected status %d: %s" % (code, message))
+        self.code = code
+        self.message = message
+
+
+class Client:
+    """Client of an agent, or of a fleet, listening on the given address, e.g. 10.0.0.1:9092.
+
+    The token, an API key or a JWT, is required by agents and fleets run with --api-keys, it
+    defaults to $BEE_API_TOKEN.
+    """
+
+    def __init__(self, addr: str, token: Optional[str] = None, timeout: Optional[float] = 30):
+        if not addr.startswith(("http://", "https://")):
+            addr = "http://" + addr
+        self.base_url = addr.rstrip("/")
+        self.token = token if token is not None else os.environ.get("BEE_API_TOKEN")
+        self.timeout = timeout
+
+    def _open(self, method: str, path: str, params: Dict[str, str], timeout: Optional[float], body: Any = None):
+        query = urllib.parse.urlencode({k: v for k, v in params.items() if v})
+        data = json.dumps(body).encode() if body is not None else None
+        req = urllib.request.Request(self.base_url + path + ("?" + query if query else ""), data=data, method=method)
+        if data is not None:
+            req.add_header("Content-Type", "application/json")
+        if self.token:
+            req.add_header("Authorization", "Bearer " + self.token)
+        try:
+            return urllib.request.urlopen(req, timeout=timeout)
+        except urllib.error.HTTPError as e:
+            raise StatusError(e.code, e.read().decode(errors="replace").strip()) from None
+
+    def _request(self, method: str, path: str, params: Dict[str, str], body: Any = None) -> Any:
+        with self._open(method, path, params, self.timeout, body) as resp:
+            return json.load(resp)
+
+    def _stream(self, method: str, path: str, params: Dict[str, str]) -> Iterator[Any]:
+        # streams last as long as the program, so they are not timed out
+        with self._open(method, path, params, None) as resp:
+            for line in resp:
+                line = line.strip()
+                if line:
+                    yield json.loads(line)
+`
+
+// PythonClient returns the Python client generated from the OpenAPI document of v1.Routes,
+// as returned by OpenAPI, committed as clients/python/bumblebee_client.py.
+func PythonClient(openAPI []byte) ([]byte, error) {
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPI, &doc); err != nil {
+		return nil, fmt.Errorf("could not decode the OpenAPI document: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(pythonClientHeader)
+
+	names := make([]string, 0, len(doc.Components.Schemas))
+	for name := range doc.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := doc.Components.Schemas[name]
+		props := make([]string, 0, len(s.Properties))
+		for prop := range s.Properties {
+			props = append(props, prop)
+		}
+		sort.Strings(props)
+		// fields which may be omitted can only be told apart from the required ones by
+		// the total of the whole TypedDict before Python 3.11
+		total := "True"
+		if len(s.Required) < len(props) {
+			total = "False"
+		}
+		fmt.Fprintf(&buf, "%s = TypedDict(\"%s\", {\n", name, name)
+		for _, prop := range props {
+			fmt.Fprintf(&buf, "    \"%s\": %s,\n", prop, pythonType(s.Properties[prop]))
+		}
+		fmt.Fprintf(&buf, "}, total=%s)\n", total)
+	}
+
+	buf.WriteString(pythonClientBase)
+	for _, route := range v1.Routes {
+		op, ok := doc.Paths[route.Path][strings.ToLower(route.Method)]
+		if !ok {
+			return nil, fmt.Errorf("route %s %s is not in the OpenAPI document", route.Method, route.Path)
+		}
+		body := op.Responses["200"].Content["application/json"].Schema
+		call, returns := "_request", pythonType(body)
+		if route.Stream {
+			body = op.Responses["200"].Content["application/x-ndjson"].Schema
+			call, returns = "_stream", "Iterator["+pythonType(body)+"]"
+		}
+
+		args := []string{"self"}
+		var bodyArg string
+		if op.RequestBody != nil {
+			args = append(args, "body: "+pythonType(op.RequestBody.Content["application/json"].Schema))
+			bodyArg = ", body"
+		}
+		var params []string
+		for _, p := range op.Parameters {
+			args = append(args, fmt.Sprintf("%s: str = \"\"", p.Name))
+			params = append(params, fmt.Sprintf("\"%s\": %s", p.Name, p.Name))
+		}
+		fmt.Fprintf(&buf, "\n    def %s(%s) -> %s:\n", snakeCase(op.OperationID), strings.Join(args, ", "), returns)
+		fmt.Fprintf(&buf, "        \"\"\"%s.", op.Summary)
+		var notes []string
+		if op.Description != "" {
+			notes = append(notes, op.Description)
+		}
+		if op.Role != "" {
+			server := "agent"
+			if len(op.Tags) > 0 && op.Tags[0] == "fleet" {
+				server = "fleet"
+			}
+			notes = append(notes, fmt.Sprintf("Requires the %s role when the %s is run with --api-keys.", op.Role, server))
+		}
+		for _, p := range op.Parameters {
+			notes = append(notes, fmt.Sprintf("%s: %s.", p.Name, p.Description))
+		}
+		if len(notes) > 0 {
+			buf.WriteString("\n\n        " + strings.Join(notes, "\n        ") + "\n        ")
+		}
+		buf.WriteString("\"\"\"\n")
+		fmt.Fprintf(&buf, "        return self.%s(\"%s\", \"%s\", {%s}%s)\n", call, route.Method, route.Path, strings.Join(params, ", "), bodyArg)
+	}
+	return buf.Bytes(), nil
+}
+
+// pythonType returns the type hint of a schema, referencing the TypedDicts by name so they
+// can be declared in any order.
+func pythonType(s *schema) string {
+	if s == nil {
+		return "Any"
+	}
+	var t string
+	switch {
+	case s.Ref != "":
+		t = fmt.Sprintf("\"%s\"", strings.TrimPrefix(s.Ref, "#/components/schemas/"))
+	case len(s.OneOf) > 0:
+		var types []string
+		for _, one := range s.OneOf {
+			types = append(types, pythonType(one))
+		}
+		t = "Union[" + strings.Join(types, ", ") + "]"
+	case s.Type == "boolean":
+		t = "bool"
+	case s.Type == "string":
+		// including date-times, which are RFC 3339 strings
+		t = "str"
+	case s.Type == "integer":
+		t = "int"
+	case s.Type == "number":
+		t = "float"
+	case s.Type == "array":
+		t = "List[" + pythonType(s.Items) + "]"
+	case s.Type == "object" && s.AdditionalProperties != nil:
+		t = "Dict[str, " + pythonType(s.AdditionalProperties) + "]"
+	default:
+		t = "Any"
+	}
+	if s.Nullable {
+		t = "Optional[" + t + "]"
+	}
+	return t
+}
+
+// snakeCase converts an operation ID, e.g. programPause to program_pause.
+func snakeCase(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			b.WriteByte('_')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
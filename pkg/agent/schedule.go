@@ -0,0 +1,316 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+// delay before applying the schedule again when it failed, e.g. before the program is loaded
+const scheduleRetryInterval = time.Second
+
+// Schedule is when the program runs, it is paused outside of its windows.
+type Schedule struct {
+	// Time zone of the windows, e.g. Europe/Paris, defaults to the local time zone
+	Timezone string   `yaml:"timezone,omitempty"`
+	Windows  []Window `yaml:"windows"`
+
+	loc     *time.Location
+	windows []window
+}
+
+// Window is either a periodic window, e.g. 5 minutes every hour, or a daily one, e.g.
+// from 09:00 to 17:00. Both can be restricted to days of the week.
+type Window struct {
+	// Period of a periodic window, which must divide a day so it starts at the same times
+	// every day, from midnight
+	Every time.Duration `yaml:"every,omitempty"`
+	// How long a periodic window lasts
+	Duration time.Duration `yaml:"duration,omitempty"`
+	// Delay after the start of each period the window starts at, e.g. 30m to run at half past
+	Offset time.Duration `yaml:"offset,omitempty"`
+	// Start and end of a daily window, as HH:MM. A window ending before it starts ends
+	// the next day.
+	Start string `yaml:"start,omitempty"`
+	End   string `yaml:"end,omitempty"`
+	// Days of the week windows start on, e.g. [mon, tue], every day if empty
+	Days []string `yaml:"days,omitempty"`
+}
+
+type window struct {
+	every, duration, offset time.Duration
+	days                    map[time.Weekday]bool
+}
+
+type interval struct {
+	start, end time.Time
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// init validates the schedule, and must be called before using it.
+func (s *Schedule) init() error {
+	s.loc = time.Local
+	if s.Timezone != "" {
+		loc, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return fmt.Errorf("unknown time zone %s", s.Timezone)
+		}
+		s.loc = loc
+	}
+	if len(s.Windows) == 0 {
+		return fmt.Errorf("schedule must have at least one window")
+	}
+	s.windows = nil
+	for i, w := range s.Windows {
+		parsed, err := w.parse()
+		if err != nil {
+			return fmt.Errorf("schedule window %d: %w", i, err)
+		}
+		s.windows = append(s.windows, parsed)
+	}
+	return nil
+}
+
+func (w Window) parse() (window, error) {
+	parsed := window{days: map[time.Weekday]bool{}}
+	for _, day := range w.Days {
+		key := strings.ToLower(day)
+		if len(key) > 3 {
+			key = key[:3]
+		}
+		weekday, ok := weekdays[key]
+		if !ok {
+			return parsed, fmt.Errorf("unknown day %s", day)
+		}
+		parsed.days[weekday] = true
+	}
+	periodic := w.Every != 0 || w.Duration != 0 || w.Offset != 0
+	daily := w.Start != "" || w.End != ""
+	switch {
+	case periodic && daily:
+		return parsed, fmt.Errorf("must either be periodic, with every and duration, or daily, with start and end")
+	case periodic:
+		if w.Every <= 0 || (24*time.Hour)%w.Every != 0 {
+			return parsed, fmt.Errorf("every must divide a day, e.g. 15m, 1h or 6h")
+		}
+		if w.Duration <= 0 || w.Duration > w.Every {
+			return parsed, fmt.Errorf("duration must be positive and at most every")
+		}
+		if w.Offset < 0 || w.Offset >= w.Every {
+			return parsed, fmt.Errorf("offset must be positive and less than every")
+		}
+		parsed.every, parsed.duration, parsed.offset = w.Every, w.Duration, w.Offset
+	case daily:
+		start, err := parseTimeOfDay(w.Start)
+		if err != nil {
+			return parsed, fmt.Errorf("invalid start: %w", err)
+		}
+		end, err := parseTimeOfDay(w.End)
+		if err != nil {
+			return parsed, fmt.Errorf("invalid end: %w", err)
+		}
+		if end <= start {
+			end += 24 * time.Hour
+		}
+		parsed.every, parsed.duration, parsed.offset = 24*time.Hour, end-start, start
+	default:
+		return parsed, fmt.Errorf("must either be periodic, with every and duration, or daily, with start and end")
+	}
+	return parsed, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not formatted as HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// intervals returns the windows starting from the day before t to a week after, sorted
+// and merged where they overlap.
+func (s *Schedule) intervals(t time.Time) []interval {
+	local := t.In(s.loc)
+	var intervals []interval
+	for day := -1; day <= 7; day++ {
+		midnight := time.Date(local.Year(), local.Month(), local.Day()+day, 0, 0, 0, 0, s.loc)
+		for _, w := range s.windows {
+			if len(w.days) > 0 && !w.days[midnight.Weekday()] {
+				continue
+			}
+			for start := w.offset; start < 24*time.Hour; start += w.every {
+				// windows start at a wall clock time, whatever the DST offset of the day
+				begin := time.Date(midnight.Year(), midnight.Month(), midnight.Day(), 0, 0, 0, int(start), s.loc)
+				intervals = append(intervals, interval{start: begin, end: begin.Add(w.duration)})
+			}
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].start.Before(intervals[j].start)
+	})
+	var merged []interval
+	for _, i := range intervals {
+		if n := len(merged); n > 0 && !i.start.After(merged[n-1].end) {
+			if i.end.After(merged[n-1].end) {
+				merged[n-1].end = i.end
+			}
+			continue
+		}
+		merged = append(merged, i)
+	}
+	return merged
+}
+
+// At returns whether the program runs at t, and when it is next paused if it does, or
+// resumed otherwise.
+func (s *Schedule) At(t time.Time) (bool, time.Time) {
+	for _, i := range s.intervals(t) {
+		if t.Before(i.start) {
+			return false, i.start
+		}
+		if t.Before(i.end) {
+			return true, i.end
+		}
+	}
+	// unreachable as windows start at least once a week
+	return false, time.Time{}
+}
+
+// Scheduler pauses the program outside of the windows of its schedule, and resumes it
+// within them. It only does so as windows start and end, so the program can still be
+// paused or resumed in between, through the Scheduler which implements ProgramController.
+type Scheduler struct {
+	controller ProgramController
+
+	lock     sync.Mutex
+	schedule *Schedule
+	// whether the program should run as per the schedule, nil once applied
+	pending *bool
+	// whether the program was paused by the schedule
+	paused  bool
+	failing bool
+	next    time.Time
+	updates chan struct{}
+}
+
+func NewScheduler(controller ProgramController) *Scheduler {
+	return &Scheduler{
+		controller: controller,
+		updates:    make(chan struct{}, 1),
+	}
+}
+
+// SetSchedule replaces the schedule, and applies it immediately. The program is resumed
+// if paused by the previous schedule and the new one is nil.
+func (s *Scheduler) SetSchedule(schedule *Schedule) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.schedule = schedule
+	s.next = time.Time{}
+	s.pending = nil
+	if schedule == nil && s.paused {
+		active := true
+		s.pending = &active
+	}
+	select {
+	case s.updates <- struct{}{}:
+	default:
+	}
+}
+
+// Start applies the schedule until the context is done.
+func (s *Scheduler) Start(ctx context.Context) {
+	go func() {
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+			case <-s.updates:
+			case <-ctx.Done():
+				return
+			}
+			timer.Stop()
+			timer.Reset(time.Until(s.apply(ctx, time.Now())))
+		}
+	}()
+}
+
+// apply pauses or resumes the program if a window started or ended, and returns when
+// to apply the schedule again.
+func (s *Scheduler) apply(ctx context.Context, now time.Time) time.Time {
+	logger := contextutils.LoggerFrom(ctx)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.schedule != nil && !now.Before(s.next) {
+		active, next := s.schedule.At(now)
+		s.next, s.pending = next, &active
+		if active {
+			logger.Infof("schedule window started, the program is next paused at %s", next.Format(time.RFC3339))
+		} else {
+			logger.Infof("outside of the schedule windows, the program is next resumed at %s", next.Format(time.RFC3339))
+		}
+	}
+	if s.pending != nil {
+		var err error
+		if *s.pending {
+			_, err = s.controller.Resume()
+		} else {
+			_, err = s.controller.Pause()
+		}
+		if err != nil {
+			// retried every second, e.g. until the program is loaded
+			if !s.failing {
+				logger.Warnf("could not apply the schedule, retrying: %v", err)
+			}
+			s.failing = true
+			return now.Add(scheduleRetryInterval)
+		}
+		s.paused = !*s.pending
+		s.pending, s.failing = nil, false
+	}
+	if s.schedule == nil {
+		// nothing to do until the schedule is set
+		return now.Add(24 * time.Hour)
+	}
+	return s.next
+}
+
+func (s *Scheduler) State() v1.ProgramState {
+	return s.withNextActivation(s.controller.State())
+}
+
+func (s *Scheduler) Pause() (v1.ProgramState, error) {
+	state, err := s.controller.Pause()
+	return s.withNextActivation(state), err
+}
+
+func (s *Scheduler) Resume() (v1.ProgramState, error) {
+	state, err := s.controller.Resume()
+	return s.withNextActivation(state), err
+}
+
+// withNextActivation reports when the program is next resumed by the schedule, if paused.
+func (s *Scheduler) withNextActivation(state v1.ProgramState) v1.ProgramState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if state.Paused && s.schedule != nil {
+		active, next := s.schedule.At(time.Now())
+		if active {
+			// paused within a window, the program is resumed as the next one starts
+			_, next = s.schedule.At(next)
+		}
+		state.NextActivation = &next
+	}
+	return state
+}
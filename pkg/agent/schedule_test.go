@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+var _ = Describe("Schedule", func() {
+	parse := func(content string) *Schedule {
+		cfg, err := parseConfig([]byte(content))
+		Expect(err).NotTo(HaveOccurred())
+		return cfg.Schedule
+	}
+	date := func(day, hour, min int) time.Time {
+		// 2022-05-02 is a monday
+		return time.Date(2022, 5, day, hour, min, 0, 0, time.UTC)
+	}
+
+	It("runs periodic windows", func() {
+		schedule := parse(`
+schedule:
+  timezone: UTC
+  windows:
+  - every: 1h
+    duration: 5m
+    offset: 30m
+`)
+		active, next := schedule.At(date(2, 10, 0))
+		Expect(active).To(BeFalse())
+		Expect(next).To(Equal(date(2, 10, 30)))
+
+		active, next = schedule.At(date(2, 10, 32))
+		Expect(active).To(BeTrue())
+		Expect(next).To(Equal(date(2, 10, 35)))
+
+		active, next = schedule.At(date(2, 23, 40))
+		Expect(active).To(BeFalse())
+		Expect(next).To(Equal(date(3, 0, 30)))
+	})
+
+	It("runs daily windows on some days", func() {
+		schedule := parse(`
+schedule:
+  timezone: UTC
+  windows:
+  - start: "22:00"
+    end: "02:00"
+    days: [fri]
+  - start: "09:00"
+    end: "17:00"
+    days: [monday, tue]
+`)
+		active, next := schedule.At(date(2, 12, 0))
+		Expect(active).To(BeTrue())
+		Expect(next).To(Equal(date(2, 17, 0)))
+
+		active, next = schedule.At(date(3, 18, 0))
+		Expect(active).To(BeFalse())
+		Expect(next).To(Equal(date(6, 22, 0)))
+
+		// overnight windows end the next day
+		active, next = schedule.At(date(7, 1, 0))
+		Expect(active).To(BeTrue())
+		Expect(next).To(Equal(date(7, 2, 0)))
+	})
+
+	It("merges overlapping windows", func() {
+		schedule := parse(`
+schedule:
+  timezone: UTC
+  windows:
+  - every: 1h
+    duration: 30m
+  - start: "09:15"
+    end: "10:45"
+`)
+		active, next := schedule.At(date(2, 9, 20))
+		Expect(active).To(BeTrue())
+		Expect(next).To(Equal(date(2, 10, 45)))
+	})
+
+	It("rejects invalid windows", func() {
+		for _, content := range []string{
+			"schedule: {windows: []}",
+			"schedule: {timezone: Nowhere/City, windows: [{start: '09:00', end: '10:00'}]}",
+			"schedule: {windows: [{every: 7h, duration: 1h}]}",
+			"schedule: {windows: [{every: 1h, duration: 2h}]}",
+			"schedule: {windows: [{every: 1h, duration: 5m, start: '09:00'}]}",
+			"schedule: {windows: [{start: '9am', end: '10:00'}]}",
+			"schedule: {windows: [{start: '09:00', end: '10:00', days: [someday]}]}",
+		} {
+			_, err := parseConfig([]byte(content))
+			Expect(err).To(HaveOccurred(), content)
+		}
+	})
+})
+
+var errNotLoadedYet = errors.New("not loaded yet")
+
+var _ = Describe("Scheduler", func() {
+	var (
+		controller *fakeController
+		scheduler  *Scheduler
+		ctx        context.Context
+	)
+
+	BeforeEach(func() {
+		controller = &fakeController{}
+		scheduler = NewScheduler(controller)
+		ctx = context.Background()
+	})
+
+	schedule := func(windows ...Window) *Schedule {
+		s := &Schedule{Timezone: "UTC", Windows: windows}
+		Expect(s.init()).To(Succeed())
+		return s
+	}
+
+	It("pauses the program outside of its windows", func() {
+		now := time.Date(2022, 5, 2, 10, 0, 0, 0, time.UTC)
+		scheduler.SetSchedule(schedule(Window{Every: time.Hour, Duration: 5 * time.Minute, Offset: 30 * time.Minute}))
+
+		next := scheduler.apply(ctx, now)
+		Expect(controller.state.Paused).To(BeTrue())
+		Expect(next).To(Equal(now.Add(30 * time.Minute)))
+
+		next = scheduler.apply(ctx, next)
+		Expect(controller.state.Paused).To(BeFalse())
+		Expect(next).To(Equal(now.Add(35 * time.Minute)))
+
+		// paused manually within a window, until the next one
+		_, err := scheduler.Pause()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(scheduler.apply(ctx, now.Add(33*time.Minute))).To(Equal(next))
+		Expect(controller.state.Paused).To(BeTrue())
+	})
+
+	It("retries until the program can be paused", func() {
+		now := time.Date(2022, 5, 2, 10, 0, 0, 0, time.UTC)
+		controller.err = errNotLoadedYet
+		scheduler.SetSchedule(schedule(Window{Start: "09:00", End: "09:30"}))
+
+		Expect(scheduler.apply(ctx, now)).To(Equal(now.Add(scheduleRetryInterval)))
+		Expect(controller.state.Paused).To(BeFalse())
+
+		controller.err = nil
+		scheduler.apply(ctx, now.Add(scheduleRetryInterval))
+		Expect(controller.state.Paused).To(BeTrue())
+	})
+
+	It("reports the next activation and resumes the program once unscheduled", func() {
+		scheduler.SetSchedule(schedule(Window{Every: time.Hour, Duration: time.Nanosecond}))
+		scheduler.apply(ctx, time.Now())
+		state := scheduler.State()
+		Expect(state.Paused).To(BeTrue())
+		Expect(state.NextActivation).NotTo(BeNil())
+		Expect(*state.NextActivation).To(BeTemporally("~", time.Now().Truncate(time.Hour).Add(time.Hour), time.Nanosecond))
+
+		scheduler.SetSchedule(nil)
+		scheduler.apply(ctx, time.Now())
+		Expect(scheduler.State()).To(Equal(v1.ProgramState{Since: controller.state.Since}))
+	})
+})
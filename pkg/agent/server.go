@@ -0,0 +1,471 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/hashstructure/v2"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+// number of events buffered per client before events are dropped
+const subscriberBufferSize = 1024
+
+type ServerOpts struct {
+	Port uint32
+}
+
+func (o *ServerOpts) initDefaults() {
+	if o.Port == 0 {
+		o.Port = 9092
+	}
+}
+
+// ProgramController pauses and resumes the program of the agent, e.g. a loader.Control.
+type ProgramController interface {
+	State() v1.ProgramState
+	Pause() (v1.ProgramState, error)
+	Resume() (v1.ProgramState, error)
+}
+
+// ProgramStatus reports the attach state of the programs of the program and its recent
+// errors, e.g. a loader.Status.
+type ProgramStatus interface {
+	Status() v1.ProgramStatus
+}
+
+// ProgramTrigger is implemented by the controllers which run the program once triggered,
+// allowing API clients to trigger them.
+type ProgramTrigger interface {
+	Trigger(reason string) (v1.ProgramState, error)
+}
+
+// Server exposes the maps watched by the loader over HTTP, so they can be consumed remotely.
+// It implements v1.MapWatcher, and should be passed to the loader as (one of) its watchers.
+type Server struct {
+	lock        sync.Mutex
+	maps        map[string]*mapState
+	subscribers map[*subscriber]struct{}
+	closed      bool
+	controller  ProgramController
+	auth        *Authenticator
+	debugLogs   debugLogs
+	probes      []v1.AttachedProbe
+	status      ProgramStatus
+	health      *Health
+	overrides   *packageOverrides
+	policy      *enforcedPolicy
+	enforcement EnforcementSwitch
+	// sequence number of the last event of a ring buffer
+	seq uint64
+}
+
+type mapState struct {
+	info v1.MapInfo
+	// current entries of hash maps, keyed by the hash of the entry key
+	entries map[uint64]v1.MapEntry
+}
+
+type subscriber struct {
+	events  chan v1.Event
+	dropped uint64
+	// set once a change of a hash map was dropped, the client being sent the state again
+	resync bool
+}
+
+func NewServer() *Server {
+	return &Server{
+		maps:        map[string]*mapState{},
+		subscribers: map[*subscriber]struct{}{},
+	}
+}
+
+// Start serves the API until the context is done.
+func (s *Server) Start(ctx context.Context, opts *ServerOpts) {
+	opts.initDefaults()
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", opts.Port),
+		Handler: s.Handler(),
+	}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			contextutils.LoggerFrom(ctx).Errorf("could not listen for agent API: %v", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+}
+
+// SetController allows API clients to pause and resume the program, it must be called
+// before the API is served.
+func (s *Server) SetController(controller ProgramController) {
+	s.controller = controller
+}
+
+// SetAuthenticator requires the clients of the API to send a key, with the admin role to
+// control the program. It must be called before the API is served.
+func (s *Server) SetAuthenticator(auth *Authenticator) {
+	s.auth = auth
+}
+
+// SetHealth serves the checks of the health on the /healthz and /readyz probes, which
+// otherwise always succeed. It must be called before the API is served.
+func (s *Server) SetHealth(health *Health) {
+	s.health = health
+}
+
+// Handler returns the http.Handler serving the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	// no key is needed, for API gateways and client generators
+	mux.HandleFunc(v1.OpenAPIPath, serveOpenAPI)
+	// nor for probes
+	mux.HandleFunc(v1.HealthzPath, s.serveHealth(false))
+	mux.HandleFunc(v1.ReadyzPath, s.serveHealth(true))
+	mux.HandleFunc(v1.WatchPath, s.require(RoleRead, s.serveWatch))
+	mux.HandleFunc(v1.MapsPath, s.require(RoleRead, s.serveMaps))
+	mux.HandleFunc(v1.ProbesPath, s.require(RoleRead, s.serveProbes))
+	mux.HandleFunc(v1.StatusPath, s.require(RoleRead, s.serveStatus))
+	mux.HandleFunc(v1.DebugLogPath, s.require(RoleRead, s.serveDebugLogs))
+	mux.HandleFunc(v1.ClockPath, s.require(RoleRead, serveClock))
+	if s.controller != nil {
+		mux.HandleFunc(v1.ProgramPath, s.require(RoleRead, s.serveProgram))
+		mux.HandleFunc(v1.PausePath, s.require(RoleAdmin, s.serveControl(s.persistPaused(s.controller.Pause))))
+		mux.HandleFunc(v1.ResumePath, s.require(RoleAdmin, s.serveControl(s.persistPaused(s.controller.Resume))))
+		if s.overrides != nil {
+			mux.HandleFunc(v1.OverridesPath, s.require(RoleRead, s.serveOverrides))
+			mux.HandleFunc(v1.SetOverridesPath, s.require(RoleAdmin, s.serveSetOverrides))
+			mux.HandleFunc(v1.ClearOverridesPath, s.require(RoleAdmin, s.serveClearOverrides))
+		}
+		if s.policy != nil {
+			mux.HandleFunc(v1.PolicyPath, s.require(RoleRead, s.servePolicy))
+			mux.HandleFunc(v1.UpdatePolicyPath, s.require(RoleAdmin, s.serveUpdatePolicy))
+			mux.HandleFunc(v1.RollbackPolicyPath, s.require(RoleAdmin, s.serveRollbackPolicy))
+			mux.HandleFunc(v1.PolicyVersionsPath, s.require(RoleRead, s.servePolicyVersions))
+			mux.HandleFunc(v1.PolicyAuditPath, s.require(RoleRead, s.servePolicyAudit))
+		}
+		if s.enforcement != nil {
+			mux.HandleFunc(v1.EnforcementPath, s.require(RoleRead, s.serveEnforcement))
+			mux.HandleFunc(v1.EnforcementModePath, s.require(RoleAdmin, s.serveEnforcementMode))
+		}
+		if trigger, ok := s.controller.(ProgramTrigger); ok {
+			mux.HandleFunc(v1.TriggerPath, s.require(RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+				s.serveControl(func() (v1.ProgramState, error) {
+					return trigger.Trigger(r.URL.Query().Get("reason"))
+				})(w, r)
+			}))
+		}
+	}
+	return mux
+}
+
+func (s *Server) require(role Role, handler http.HandlerFunc) http.HandlerFunc {
+	if s.auth == nil {
+		return handler
+	}
+	return s.auth.require(role, handler)
+}
+
+func (s *Server) NewRingBuf(name string, keys []string) {
+	s.newMap(v1.MapInfo{Name: name, Type: v1.RingBufMapType, Keys: keys})
+}
+
+func (s *Server) NewHashMap(name string, keys []string) {
+	s.newMap(v1.MapInfo{Name: name, Type: v1.HashMapType, Keys: keys})
+}
+
+func (s *Server) newMap(info v1.MapInfo) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	state := &mapState{info: info}
+	if info.Type == v1.HashMapType {
+		state.entries = map[uint64]v1.MapEntry{}
+	}
+	s.maps[info.Name] = state
+	s.broadcast(v1.Event{Map: &info})
+}
+
+func (s *Server) SendEntry(entry v1.MapEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	state, ok := s.maps[entry.Name]
+	if !ok {
+		return
+	}
+	if state.entries != nil {
+		hash, _ := hashstructure.Hash(entry.Entry.Key, hashstructure.FormatV2, nil)
+		if current, ok := state.entries[hash]; ok && current.Entry.Value == entry.Entry.Value {
+			// hash maps are polled, only send changes
+			return
+		}
+		state.entries[hash] = entry
+		s.broadcast(v1.Event{Entry: &entry})
+		return
+	}
+	s.seq++
+	s.broadcast(v1.Event{Entry: &entry, Hint: &v1.OrderingHint{Seq: s.seq, Time: time.Now(), Boottime: boottime()}})
+}
+
+// DeleteEntry removes the entry of a hash map, e.g. evicted as stale, and sends its deletion
+// to the clients.
+func (s *Server) DeleteEntry(entry v1.MapEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	state, ok := s.maps[entry.Name]
+	if !ok || state.entries == nil {
+		return
+	}
+	hash, _ := hashstructure.Hash(entry.Entry.Key, hashstructure.FormatV2, nil)
+	if _, ok := state.entries[hash]; !ok {
+		return
+	}
+	delete(state.entries, hash)
+	s.broadcast(v1.Event{Deleted: &entry})
+}
+
+// Close ends all watch streams, as no more entries will be sent.
+func (s *Server) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	for sub := range s.subscribers {
+		close(sub.events)
+		delete(s.subscribers, sub)
+	}
+	s.debugLogs.close()
+}
+
+// broadcast must be called with the lock held
+func (s *Server) broadcast(event v1.Event) {
+	for sub := range s.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			sub.dropped++
+			// unlike the events of ring buffers, the state of the client is now out of date
+			if event.Hint == nil {
+				sub.resync = true
+			}
+		}
+	}
+}
+
+// subscribe returns a subscriber whose channel is pre-filled with the current state
+func (s *Server) subscribe() *subscriber {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	snapshot := s.snapshot()
+	size := subscriberBufferSize
+	if len(snapshot) > size {
+		size = len(snapshot)
+	}
+	sub := &subscriber{events: make(chan v1.Event, size)}
+	for _, event := range snapshot {
+		sub.events <- event
+	}
+	if s.closed {
+		close(sub.events)
+	} else {
+		s.subscribers[sub] = struct{}{}
+	}
+	return sub
+}
+
+// snapshot returns the events of the current state: every map, then the entries of the hash
+// maps. The lock must be held.
+func (s *Server) snapshot() []v1.Event {
+	var snapshot []v1.Event
+	names := make([]string, 0, len(s.maps))
+	for name := range s.maps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		info := s.maps[name].info
+		snapshot = append(snapshot, v1.Event{Map: &info})
+	}
+	for _, name := range names {
+		for _, entry := range s.maps[name].entries {
+			entry := entry
+			snapshot = append(snapshot, v1.Event{Entry: &entry})
+		}
+	}
+	return snapshot
+}
+
+func (s *Server) unsubscribe(sub *subscriber) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.subscribers, sub)
+}
+
+// droppedEvents returns the events dropped for the subscriber since the last call, and the
+// events sending the current state again once changes of hash maps were dropped. The events
+// of hash maps buffered meanwhile are discarded, as superseded by the state.
+func (s *Server) droppedEvents(sub *subscriber) (uint64, []v1.Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	dropped := sub.dropped
+	sub.dropped = 0
+	if !sub.resync || s.closed {
+		return dropped, nil
+	}
+	sub.resync = false
+	var kept []v1.Event
+	for n := len(sub.events); n > 0; n-- {
+		if event := <-sub.events; event.Hint != nil {
+			kept = append(kept, event)
+		}
+	}
+	for _, event := range kept {
+		sub.events <- event
+	}
+	return dropped, append([]v1.Event{{Resync: true}}, s.snapshot()...)
+}
+
+func (s *Server) serveWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := s.subscribe()
+	defer s.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case event, ok := <-sub.events:
+			if !ok {
+				return
+			}
+			dropped, resync := s.droppedEvents(sub)
+			if dropped > 0 {
+				if err := enc.Encode(v1.Event{Dropped: dropped}); err != nil {
+					return
+				}
+			}
+			for _, event := range resync {
+				if err := enc.Encode(event); err != nil {
+					return
+				}
+			}
+			// unless superseded by the state sent again
+			if resync == nil || event.Hint != nil {
+				if err := enc.Encode(event); err != nil {
+					return
+				}
+			}
+			// only flush once caught up, to batch writes under load
+			if len(sub.events) == 0 {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) serveMaps(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	maps := make([]v1.MapInfo, 0, len(s.maps))
+	for _, state := range s.maps {
+		maps = append(maps, state.info)
+	}
+	s.lock.Unlock()
+	sort.Slice(maps, func(i, j int) bool {
+		return maps[i].Name < maps[j].Name
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(maps); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// AttachedProbes sets what the kprobe and kretprobe programs were attached to, served to
+// clients, e.g. as LoadOptions.ProbesAttached.
+func (s *Server) AttachedProbes(probes []v1.AttachedProbe) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.probes = probes
+}
+
+func (s *Server) serveProbes(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	probes := append([]v1.AttachedProbe{}, s.probes...)
+	s.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(probes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// SetStatus allows API clients to read the attach state of the programs and their recent
+// errors, it must be called before the API is served.
+func (s *Server) SetStatus(status ProgramStatus) {
+	s.status = status
+}
+
+func (s *Server) serveStatus(w http.ResponseWriter, r *http.Request) {
+	status := v1.ProgramStatus{Probes: []v1.ProbeStatus{}, Errors: []v1.RuntimeError{}}
+	if s.status != nil {
+		status = s.status.Status()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) serveProgram(w http.ResponseWriter, r *http.Request) {
+	writeState(w, s.withEnforcement(s.controller.State()), http.StatusOK)
+}
+
+func (s *Server) serveControl(action func() (v1.ProgramState, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		state, err := action()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeState(w, s.withEnforcement(state), http.StatusOK)
+	}
+}
+
+func serveClock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReadClock())
+}
+
+func writeState(w http.ResponseWriter, state v1.ProgramState, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(state)
+}
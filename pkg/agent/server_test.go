@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/client"
+)
+
+var _ = Describe("Server", func() {
+	It("sends the deletions of hash map entries", func() {
+		server := NewServer()
+		httpServer := httptest.NewServer(server.Handler())
+		defer httpServer.Close()
+		server.NewHashMap("counts", []string{"pid"})
+		server.SendEntry(v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"pid": "1"}, Value: "3"}})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		c := client.New(httpServer.URL, nil)
+		stream, err := c.Events(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		defer stream.Close()
+		for i := 0; i < 2; i++ {
+			_, err := stream.Recv()
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		server.DeleteEntry(v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"pid": "1"}}})
+		// unknown entries are ignored
+		server.DeleteEntry(v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"pid": "2"}}})
+		server.SendEntry(v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"pid": "3"}, Value: "1"}})
+		event, err := stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(event.Deleted).To(Equal(&v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"pid": "1"}}}))
+		event, err = stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(event.Entry.Entry.Key).To(Equal(map[string]string{"pid": "3"}))
+
+		// nor sent to the clients connecting
+		Expect(server.snapshot()).To(HaveLen(2))
+	})
+
+	It("sends the state again once changes of hash maps were dropped", func() {
+		server := NewServer()
+		server.NewRingBuf("events", []string{"pid"})
+		server.NewHashMap("counts", []string{"pid"})
+		sub := server.subscribe()
+		Expect(sub.events).To(HaveLen(2))
+
+		server.SendEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"pid": "1"}}})
+		for i := 0; i < subscriberBufferSize; i++ {
+			server.SendEntry(v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"pid": "1"}, Value: fmt.Sprint(i)}})
+		}
+		dropped, resync := server.droppedEvents(sub)
+		Expect(dropped).To(Equal(uint64(3)))
+		Expect(resync).To(Equal([]v1.Event{
+			{Resync: true},
+			{Map: &v1.MapInfo{Name: "counts", Type: v1.HashMapType, Keys: []string{"pid"}}},
+			{Map: &v1.MapInfo{Name: "events", Type: v1.RingBufMapType, Keys: []string{"pid"}}},
+			{Entry: &v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"pid": "1"}, Value: fmt.Sprint(subscriberBufferSize - 1)}}},
+		}))
+		// only the events of the ring buffer are left
+		Expect(sub.events).To(HaveLen(1))
+		event := <-sub.events
+		Expect(event.Hint).NotTo(BeNil())
+
+		// the drops of ring buffer events don't need to
+		for i := 0; i < subscriberBufferSize+1; i++ {
+			server.SendEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"pid": "1"}}})
+		}
+		dropped, resync = server.droppedEvents(sub)
+		Expect(dropped).To(Equal(uint64(1)))
+		Expect(resync).To(BeNil())
+	})
+})
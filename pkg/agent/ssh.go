@@ -0,0 +1,206 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	sshagent "golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var defaultIdentityFiles = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// DefaultSSHTimeout bounds connecting to the SSH server and the handshake by default
+const DefaultSSHTimeout = 15 * time.Second
+
+type SSHOpts struct {
+	// Destination is the SSH server to tunnel through, as `[user@]host[:port]`
+	Destination string
+	// IdentityFile is a private key used in addition to the keys of the running ssh-agent.
+	// The default keys in ~/.ssh are used if empty.
+	IdentityFile string
+	// KnownHostsFile used to verify the server, defaults to ~/.ssh/known_hosts
+	KnownHostsFile string
+	// InsecureIgnoreHostKey disables the verification of the server host key
+	InsecureIgnoreHostKey bool
+	// Timeout of connecting to the SSH server, including the handshake, defaults to
+	// DefaultSSHTimeout
+	Timeout time.Duration
+}
+
+// SSHTunnel dials connections from the SSH server it is connected to,
+// as `ssh -L` would, so agents can be reached where only SSH is open.
+type SSHTunnel struct {
+	client *ssh.Client
+}
+
+// NewSSHTunnel connects to the SSH server, authenticating with the running ssh-agent and the user's keys.
+// Connecting is aborted once the context is done, the tunnel is not closed by it.
+func NewSSHTunnel(ctx context.Context, opts *SSHOpts) (*SSHTunnel, error) {
+	home, _ := os.UserHomeDir()
+
+	username, hostport := "", opts.Destination
+	if idx := strings.LastIndex(hostport, "@"); idx >= 0 {
+		username, hostport = hostport[:idx], hostport[idx+1:]
+	}
+	if username == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("could not determine ssh user: %w", err)
+		}
+		username = u.Username
+	}
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		hostport = net.JoinHostPort(hostport, "22")
+	}
+
+	auth, err := sshAuthMethods(home, opts.IdentityFile)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if !opts.InsecureIgnoreHostKey {
+		knownHostsFile := opts.KnownHostsFile
+		if knownHostsFile == "" {
+			knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		hostKeyCallback, err = knownhosts.New(knownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read known hosts: %w", err)
+		}
+	}
+
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DefaultSSHTimeout
+	}
+	client, err := dialSSH(ctx, hostport, &ssh.ClientConfig{
+		User:            username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         timeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %w", hostport, err)
+	}
+	return &SSHTunnel{client: client}, nil
+}
+
+// dialSSH connects to the SSH server as ssh.Dial does, but within the timeout of the config,
+// handshake included, and until the context is done.
+func dialSSH(ctx context.Context, hostport string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", hostport)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(config.Timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+	handshaken := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-handshaken:
+		}
+	}()
+	c, chans, reqs, err := ssh.NewClientConn(conn, hostport, config)
+	close(handshaken)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+func sshAuthMethods(home, identityFile string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(sshagent.NewClient(conn).Signers))
+		}
+	}
+
+	var signers []ssh.Signer
+	if identityFile != "" {
+		signer, err := readSigner(identityFile)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	} else {
+		for _, name := range defaultIdentityFiles {
+			// the default keys are best effort, e.g. they may be passphrase protected
+			if signer, err := readSigner(filepath.Join(home, ".ssh", name)); err == nil {
+				signers = append(signers, signer)
+			}
+		}
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no ssh-agent or private key available to authenticate")
+	}
+	return methods, nil
+}
+
+func readSigner(path string) (ssh.Signer, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read private key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// DialContext dials the address from the SSH server, so e.g. `localhost:9092` reaches
+// an agent listening on the SSH server itself.
+func (t *SSHTunnel) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := t.client.Dial(network, addr)
+		done <- result{conn, err}
+	}()
+	select {
+	case res := <-done:
+		return res.conn, res.err
+	case <-ctx.Done():
+		go func() {
+			if res := <-done; res.conn != nil {
+				res.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+func (t *SSHTunnel) Close() error {
+	return t.client.Close()
+}
@@ -0,0 +1,223 @@
+package agent
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshServer is an in-process SSH server which only forwards the connections of clients
+// authenticated with the key, as `ssh -L` requires.
+type sshServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+}
+
+func newSSHServer(hostKey ssh.Signer, clientKey ssh.PublicKey) *sshServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() != "bee" || string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+	s := &sshServer{listener: listener, config: config}
+	go s.serve()
+	return s
+}
+
+func (s *sshServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *sshServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			for newChan := range chans {
+				if newChan.ChannelType() != "direct-tcpip" {
+					newChan.Reject(ssh.UnknownChannelType, "only direct-tcpip is supported")
+					continue
+				}
+				var payload struct {
+					Host       string
+					Port       uint32
+					OriginHost string
+					OriginPort uint32
+				}
+				if err := ssh.Unmarshal(newChan.ExtraData(), &payload); err != nil {
+					newChan.Reject(ssh.ConnectionFailed, err.Error())
+					continue
+				}
+				target, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+				if err != nil {
+					newChan.Reject(ssh.ConnectionFailed, err.Error())
+					continue
+				}
+				channel, channelReqs, err := newChan.Accept()
+				if err != nil {
+					target.Close()
+					continue
+				}
+				go ssh.DiscardRequests(channelReqs)
+				go func() {
+					defer channel.Close()
+					defer target.Close()
+					go io.Copy(target, channel)
+					io.Copy(channel, target)
+				}()
+			}
+		}()
+	}
+}
+
+func (s *sshServer) Close() error {
+	return s.listener.Close()
+}
+
+func newSSHKey() (ssh.Signer, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	der, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	signer, err := ssh.NewSignerFromKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	return signer, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+var _ = Describe("SSHTunnel", func() {
+	var (
+		dir      string
+		server   *sshServer
+		opts     *SSHOpts
+		hostKey  ssh.Signer
+		authSock string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "bee-ssh")
+		Expect(err).NotTo(HaveOccurred())
+		// only authenticate with the identity file
+		authSock = os.Getenv("SSH_AUTH_SOCK")
+		os.Unsetenv("SSH_AUTH_SOCK")
+
+		hostKey, _ = newSSHKey()
+		clientKey, clientPEM := newSSHKey()
+		server = newSSHServer(hostKey, clientKey.PublicKey())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "id_ecdsa"), clientPEM, 0600)).To(Succeed())
+		knownHosts := knownhosts.Line([]string{knownhosts.Normalize(server.addr())}, hostKey.PublicKey())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "known_hosts"), []byte(knownHosts+"\n"), 0600)).To(Succeed())
+		opts = &SSHOpts{
+			Destination:    "bee@" + server.addr(),
+			IdentityFile:   filepath.Join(dir, "id_ecdsa"),
+			KnownHostsFile: filepath.Join(dir, "known_hosts"),
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+		if authSock != "" {
+			os.Setenv("SSH_AUTH_SOCK", authSock)
+		}
+	})
+
+	It("dials the addresses from the SSH server", func() {
+		agentListener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer agentListener.Close()
+		go func() {
+			conn, err := agentListener.Accept()
+			if err == nil {
+				conn.Write([]byte("agent"))
+				conn.Close()
+			}
+		}()
+
+		tunnel, err := NewSSHTunnel(context.Background(), opts)
+		Expect(err).NotTo(HaveOccurred())
+		defer tunnel.Close()
+		conn, err := tunnel.DialContext(context.Background(), "tcp", agentListener.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+		byt, err := ioutil.ReadAll(conn)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(byt)).To(Equal("agent"))
+	})
+
+	It("verifies the host key of the SSH server", func() {
+		otherKey, _ := newSSHKey()
+		knownHosts := knownhosts.Line([]string{knownhosts.Normalize(server.addr())}, otherKey.PublicKey())
+		Expect(ioutil.WriteFile(opts.KnownHostsFile, []byte(knownHosts+"\n"), 0600)).To(Succeed())
+		_, err := NewSSHTunnel(context.Background(), opts)
+		Expect(err).To(MatchError(ContainSubstring("key mismatch")))
+
+		opts.InsecureIgnoreHostKey = true
+		tunnel, err := NewSSHTunnel(context.Background(), opts)
+		Expect(err).NotTo(HaveOccurred())
+		tunnel.Close()
+	})
+
+	Context("with a server which never answers the handshake", func() {
+		var silent net.Listener
+
+		BeforeEach(func() {
+			var err error
+			silent, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			// connections are accepted by the kernel, but nothing is ever written
+			opts.Destination = "bee@" + silent.Addr().String()
+			opts.InsecureIgnoreHostKey = true
+		})
+
+		AfterEach(func() {
+			silent.Close()
+		})
+
+		It("times out", func() {
+			opts.Timeout = 100 * time.Millisecond
+			start := time.Now()
+			_, err := NewSSHTunnel(context.Background(), opts)
+			Expect(err).To(MatchError(ContainSubstring("i/o timeout")))
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		})
+
+		It("gives up once the context is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+			start := time.Now()
+			_, err := NewSSHTunnel(ctx, opts)
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		})
+	})
+})
@@ -0,0 +1,171 @@
+package benchmark
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Result is the outcome of a benchmark, the median of its runs when run with -count.
+type Result struct {
+	Name string
+	// Value of each unit reported, e.g. ns/op and events/s
+	Metrics map[string]float64
+}
+
+// the GOMAXPROCS suffix of benchmark names, e.g. BenchmarkDecode-8
+var procsSuffix = regexp.MustCompile(`-\d+$`)
+
+// ParseResults parses the output of `go test -bench`, lines other than the results of
+// benchmarks being ignored.
+func ParseResults(r io.Reader) (map[string]Result, error) {
+	runs := map[string]map[string][]float64{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// name, iterations, then value and unit pairs
+		if len(fields) < 4 || len(fields)%2 != 0 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		if _, err := strconv.ParseInt(fields[1], 10, 64); err != nil {
+			continue
+		}
+		name := procsSuffix.ReplaceAllString(fields[0], "")
+		if runs[name] == nil {
+			runs[name] = map[string][]float64{}
+		}
+		for i := 2; i < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q of %s: %w", fields[i], name, err)
+			}
+			runs[name][fields[i+1]] = append(runs[name][fields[i+1]], value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	results := make(map[string]Result, len(runs))
+	for name, units := range runs {
+		result := Result{Name: name, Metrics: map[string]float64{}}
+		for unit, values := range units {
+			result.Metrics[unit] = median(values)
+		}
+		results[name] = result
+	}
+	return results, nil
+}
+
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// PerfBudget is the regression allowed for the benchmarks, compared with a baseline,
+// e.g. the results of the main branch on the same machine.
+type PerfBudget struct {
+	// Maximum regression in percent of each unit, e.g. `ns/op: 10`. Units not listed are
+	// not checked.
+	MaxRegression map[string]float64 `yaml:"maxRegression"`
+	// Overrides of MaxRegression for some benchmarks, by name without the GOMAXPROCS suffix
+	Benchmarks map[string]map[string]float64 `yaml:"benchmarks,omitempty"`
+}
+
+// LoadPerfBudget reads a budget file, unknown fields are rejected.
+func LoadPerfBudget(path string) (*PerfBudget, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var budget PerfBudget
+	if err := yaml.UnmarshalStrict(b, &budget); err != nil {
+		return nil, fmt.Errorf("invalid perf budget %s: %w", path, err)
+	}
+	for unit, max := range budget.MaxRegression {
+		if max < 0 {
+			return nil, fmt.Errorf("invalid perf budget %s: the regression of %s must not be negative", path, unit)
+		}
+	}
+	return &budget, nil
+}
+
+// Regression is a metric of a benchmark which regressed more than its budget.
+type Regression struct {
+	Benchmark string
+	Unit      string
+	Baseline  float64
+	Current   float64
+	// Regression in percent of the baseline
+	Percent float64
+	Budget  float64
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s: %g %s -> %g %s, %.1f%% worse, above the budget of %g%%",
+		r.Benchmark, r.Baseline, r.Unit, r.Current, r.Unit, r.Percent, r.Budget)
+}
+
+// Check returns the metrics of the current results which regressed more than the budget,
+// sorted by benchmark and unit. Benchmarks missing from either results are not compared.
+func (b *PerfBudget) Check(baseline, current map[string]Result) []Regression {
+	var regressions []Regression
+	for name, result := range current {
+		base, ok := baseline[name]
+		if !ok {
+			continue
+		}
+		for unit, value := range result.Metrics {
+			max, ok := b.maxRegression(name, unit)
+			baseValue, found := base.Metrics[unit]
+			if !ok || !found || baseValue == 0 {
+				continue
+			}
+			percent := (value - baseValue) / baseValue * 100
+			if higherIsBetter(unit) {
+				percent = -percent
+			}
+			if percent > max {
+				regressions = append(regressions, Regression{
+					Benchmark: name,
+					Unit:      unit,
+					Baseline:  baseValue,
+					Current:   value,
+					Percent:   percent,
+					Budget:    max,
+				})
+			}
+		}
+	}
+	sort.Slice(regressions, func(i, j int) bool {
+		if regressions[i].Benchmark != regressions[j].Benchmark {
+			return regressions[i].Benchmark < regressions[j].Benchmark
+		}
+		return regressions[i].Unit < regressions[j].Unit
+	})
+	return regressions
+}
+
+func (b *PerfBudget) maxRegression(name, unit string) (float64, bool) {
+	if max, ok := b.Benchmarks[name][unit]; ok {
+		return max, true
+	}
+	max, ok := b.MaxRegression[unit]
+	return max, ok
+}
+
+// higherIsBetter is true for throughputs, e.g. MB/s and events/s, and false for costs,
+// e.g. ns/op and allocs/op.
+func higherIsBetter(unit string) bool {
+	return strings.HasSuffix(unit, "/s")
+}
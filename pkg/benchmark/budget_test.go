@@ -0,0 +1,79 @@
+package benchmark_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/solo-io/bumblebee/pkg/benchmark"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PerfBudget", func() {
+	const baseline = `goos: linux
+pkg: github.com/solo-io/bumblebee/pkg/decoder
+BenchmarkDecode-8   	  500000	      2000 ns/op	  500000 events/s	      51 allocs/op
+BenchmarkDecode-8   	  500000	      2200 ns/op	  450000 events/s	      51 allocs/op
+BenchmarkDecode-8   	  500000	      9000 ns/op	  110000 events/s	      51 allocs/op
+BenchmarkScrape/entries=1024-8   	     300	   4000000 ns/op
+PASS
+ok  	github.com/solo-io/bumblebee/pkg/decoder	4.2s
+`
+
+	parse := func(output string) map[string]benchmark.Result {
+		results, err := benchmark.ParseResults(strings.NewReader(output))
+		Expect(err).NotTo(HaveOccurred())
+		return results
+	}
+
+	It("takes the median of the runs", func() {
+		results := parse(baseline)
+		Expect(results).To(HaveLen(2))
+		Expect(results["BenchmarkDecode"].Metrics).To(Equal(map[string]float64{
+			"ns/op":     2200,
+			"events/s":  450000,
+			"allocs/op": 51,
+		}))
+		Expect(results["BenchmarkScrape/entries=1024"].Metrics["ns/op"]).To(Equal(4000000.0))
+	})
+
+	It("fails on regressions above the budget", func() {
+		budget := &benchmark.PerfBudget{
+			MaxRegression: map[string]float64{"ns/op": 10, "events/s": 10},
+			Benchmarks:    map[string]map[string]float64{"BenchmarkScrape/entries=1024": {"ns/op": 50}},
+		}
+		current := parse(`
+BenchmarkDecode-8   	  500000	      2500 ns/op	  390000 events/s	      60 allocs/op
+BenchmarkScrape/entries=1024-8   	     300	   5000000 ns/op
+BenchmarkPull-8   	     300	   5000000 ns/op
+`)
+		regressions := budget.Check(parse(baseline), current)
+		Expect(regressions).To(HaveLen(2))
+		Expect(regressions[0].Unit).To(Equal("events/s"))
+		Expect(regressions[0].Percent).To(BeNumerically("~", 13.3, 0.1))
+		Expect(regressions[1].String()).To(Equal("BenchmarkDecode: 2200 ns/op -> 2500 ns/op, 13.6% worse, above the budget of 10%"))
+
+		faster := parse("BenchmarkDecode-8 500000 1000 ns/op 900000 events/s")
+		Expect(budget.Check(parse(baseline), faster)).To(BeEmpty())
+	})
+
+	It("loads budget files", func() {
+		dir, err := ioutil.TempDir("", "bee-budget")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "budget.yaml")
+
+		Expect(ioutil.WriteFile(path, []byte("maxRegression:\n  ns/op: 15\nbenchmarks:\n  BenchmarkPull:\n    ns/op: 25\n"), 0600)).To(Succeed())
+		budget, err := benchmark.LoadPerfBudget(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(budget.MaxRegression).To(Equal(map[string]float64{"ns/op": 15}))
+		Expect(budget.Benchmarks["BenchmarkPull"]).To(Equal(map[string]float64{"ns/op": 25}))
+
+		Expect(ioutil.WriteFile(path, []byte("maxRegresion:\n  ns/op: 15\n"), 0600)).To(Succeed())
+		_, err = benchmark.LoadPerfBudget(path)
+		Expect(err).To(HaveOccurred())
+	})
+})
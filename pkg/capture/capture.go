@@ -0,0 +1,369 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/printer"
+	"github.com/solo-io/go-utils/contextutils"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultDuration = time.Minute
+	defaultDir      = "captures"
+
+	// delay before pausing the program again when it failed, e.g. before it is loaded
+	pauseRetryInterval = time.Second
+
+	// EntriesFile holds the entries of the maps received during a capture, as JSON lines
+	EntriesFile = "entries.jsonl"
+	// MetadataFile holds the Metadata of a capture
+	MetadataFile = "capture.json"
+
+	// APITrigger is the name of the triggers fired through the agent API
+	APITrigger = "api"
+)
+
+// Config is when and for how long the program runs.
+type Config struct {
+	// How long the program runs once triggered, defaults to a minute
+	Duration time.Duration `yaml:"duration,omitempty"`
+	// Minimum delay between the end of a capture and the start of the next one
+	Cooldown time.Duration `yaml:"cooldown,omitempty"`
+	// Directory captures are recorded in, defaults to ./captures
+	Dir string `yaml:"dir,omitempty"`
+	// Triggers starting a capture, in addition to the agent API
+	Triggers []TriggerConfig `yaml:"triggers,omitempty"`
+}
+
+// TriggerConfig is a metric threshold or Kubernetes events starting a capture.
+type TriggerConfig struct {
+	// Name of the trigger recorded with the captures it starts
+	Name       string             `yaml:"name"`
+	Metric     *MetricTrigger     `yaml:"metric,omitempty"`
+	Kubernetes *KubernetesTrigger `yaml:"kubernetes,omitempty"`
+}
+
+func (c *Config) initDefaults() {
+	if c.Duration == 0 {
+		c.Duration = defaultDuration
+	}
+	if c.Dir == "" {
+		c.Dir = defaultDir
+	}
+}
+
+// LoadConfig reads and validates a capture config file, unknown fields are rejected.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read capture config: %w", err)
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("could not parse capture config: %w", err)
+	}
+	cfg.initDefaults()
+	return cfg, nil
+}
+
+// Controller pauses and resumes the program, e.g. a loader.Control.
+type Controller interface {
+	State() v1.ProgramState
+	Pause() (v1.ProgramState, error)
+	Resume() (v1.ProgramState, error)
+}
+
+// Metadata describes a capture, it is written once the capture ends.
+type Metadata struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Trigger which started the capture
+	Trigger Fired `json:"trigger"`
+	// Triggers fired during the capture, which did not extend it
+	Refired []Fired `json:"refired,omitempty"`
+}
+
+// Fired is a trigger firing.
+type Fired struct {
+	Name   string    `json:"name"`
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+type watchedMap struct {
+	name string
+	keys []string
+	hash bool
+}
+
+type capture struct {
+	dir      string
+	file     *os.File
+	printer  *printer.Printer
+	metadata Metadata
+	timer    *time.Timer
+}
+
+// Capturer keeps the program paused until a trigger fires, then runs it for the duration
+// of the capture and records the entries of its maps. It implements v1.MapWatcher, and
+// agent.ProgramController so the program can also be triggered through the agent API.
+type Capturer struct {
+	ctx        context.Context
+	cfg        Config
+	controller Controller
+	triggers   []namedTrigger
+
+	lock sync.Mutex
+	maps []watchedMap
+	// whether the program was paused for the first time, so it can be triggered
+	dormant bool
+	current *capture
+	lastEnd time.Time
+}
+
+// New validates the config and returns a capturer, the context is used for logging.
+func New(ctx context.Context, cfg Config, controller Controller) (*Capturer, error) {
+	cfg.initDefaults()
+	if cfg.Duration < 0 || cfg.Cooldown < 0 {
+		return nil, fmt.Errorf("the capture duration and cooldown must be positive")
+	}
+	// created up front, so it can be written to once sandboxed
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create capture directory: %w", err)
+	}
+	c := &Capturer{
+		ctx:        ctx,
+		cfg:        cfg,
+		controller: controller,
+	}
+	names := map[string]bool{APITrigger: true}
+	for i, t := range cfg.Triggers {
+		if t.Name == "" || names[t.Name] {
+			return nil, fmt.Errorf("capture trigger %d must have a unique name, other than %s", i, APITrigger)
+		}
+		names[t.Name] = true
+		var built trigger
+		var err error
+		switch {
+		case t.Metric != nil && t.Kubernetes == nil:
+			built, err = newMetricTrigger(*t.Metric)
+		case t.Kubernetes != nil && t.Metric == nil:
+			built, err = newKubernetesTrigger(*t.Kubernetes)
+		default:
+			err = errors.New("must have either a metric or kubernetes trigger")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("capture trigger %s: %w", t.Name, err)
+		}
+		c.triggers = append(c.triggers, namedTrigger{name: t.Name, trigger: built})
+	}
+	return c, nil
+}
+
+// Start pauses the program once loaded, then watches the triggers until the context is
+// done, which ends the current capture.
+func (c *Capturer) Start(ctx context.Context) {
+	go func() {
+		logger := contextutils.LoggerFrom(ctx)
+		for {
+			_, err := c.controller.Pause()
+			if err == nil {
+				break
+			}
+			logger.Debugf("could not pause the program until triggered, retrying: %v", err)
+			select {
+			case <-time.After(pauseRetryInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+		c.lock.Lock()
+		c.dormant = true
+		c.lock.Unlock()
+		logger.Infof("the program is paused until a capture is triggered")
+
+		for _, t := range c.triggers {
+			t := t
+			go t.trigger.watch(ctx, func(reason string) {
+				if _, err := c.fire(t.name, reason); err != nil {
+					logger.Debugf("ignored capture trigger %s: %v", t.name, err)
+				}
+			})
+		}
+		<-ctx.Done()
+		c.Close()
+	}()
+}
+
+// Trigger starts a capture, as fired through the agent API.
+func (c *Capturer) Trigger(reason string) (v1.ProgramState, error) {
+	return c.fire(APITrigger, reason)
+}
+
+func (c *Capturer) fire(name, reason string) (v1.ProgramState, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	fired := Fired{Name: name, Reason: reason, Time: now}
+	if !c.dormant {
+		return c.state(), errors.New("the program is not loaded yet")
+	}
+	if c.current != nil {
+		c.current.metadata.Refired = append(c.current.metadata.Refired, fired)
+		return c.state(), nil
+	}
+	if next := c.lastEnd.Add(c.cfg.Cooldown); now.Before(next) {
+		return c.state(), fmt.Errorf("captures are cooling down until %s", next.Format(time.RFC3339))
+	}
+
+	capture, err := c.open(now, fired)
+	if err != nil {
+		return c.state(), err
+	}
+	if _, err := c.controller.Resume(); err != nil {
+		capture.file.Close()
+		os.RemoveAll(capture.dir)
+		return c.state(), err
+	}
+	capture.timer = time.AfterFunc(c.cfg.Duration, func() { c.end(capture, true) })
+	c.current = capture
+	contextutils.LoggerFrom(c.ctx).Infof("capture triggered by %s, recording to %s for %s", name, capture.dir, c.cfg.Duration)
+	return c.state(), nil
+}
+
+// open creates the directory of a capture, and a printer of the maps to its entries file.
+func (c *Capturer) open(now time.Time, fired Fired) (*capture, error) {
+	dir := filepath.Join(c.cfg.Dir, now.UTC().Format("20060102T150405.000Z"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create capture directory: %w", err)
+	}
+	file, err := os.Create(filepath.Join(dir, EntriesFile))
+	if err != nil {
+		return nil, fmt.Errorf("could not create capture file: %w", err)
+	}
+	p := printer.New(file, printer.Opts{Format: printer.JSONFormat})
+	for _, m := range c.maps {
+		if m.hash {
+			p.NewHashMap(m.name, m.keys)
+		} else {
+			p.NewRingBuf(m.name, m.keys)
+		}
+	}
+	return &capture{
+		dir:      dir,
+		file:     file,
+		printer:  p,
+		metadata: Metadata{Start: now, Trigger: fired},
+	}, nil
+}
+
+// end pauses the program if it should stay dormant, and records the capture if it is
+// the current one.
+func (c *Capturer) end(capture *capture, pause bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if capture == nil || capture != c.current {
+		return
+	}
+	logger := contextutils.LoggerFrom(c.ctx)
+	capture.timer.Stop()
+	c.current = nil
+	c.lastEnd = time.Now()
+	if pause {
+		if _, err := c.controller.Pause(); err != nil {
+			logger.Errorf("could not pause the program after the capture: %v", err)
+		}
+	}
+
+	capture.metadata.End = c.lastEnd
+	if err := capture.file.Close(); err != nil {
+		logger.Errorf("could not write capture %s: %v", capture.dir, err)
+	}
+	data, _ := json.MarshalIndent(capture.metadata, "", "  ")
+	if err := ioutil.WriteFile(filepath.Join(capture.dir, MetadataFile), data, 0644); err != nil {
+		logger.Errorf("could not write capture metadata %s: %v", capture.dir, err)
+		return
+	}
+	logger.Infof("recorded capture %s", capture.dir)
+}
+
+func (c *Capturer) NewRingBuf(name string, keys []string) {
+	c.newMap(watchedMap{name: name, keys: keys})
+}
+
+func (c *Capturer) NewHashMap(name string, keys []string) {
+	c.newMap(watchedMap{name: name, keys: keys, hash: true})
+}
+
+func (c *Capturer) newMap(m watchedMap) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.maps = append(c.maps, m)
+	if c.current != nil {
+		if m.hash {
+			c.current.printer.NewHashMap(m.name, m.keys)
+		} else {
+			c.current.printer.NewRingBuf(m.name, m.keys)
+		}
+	}
+}
+
+func (c *Capturer) SendEntry(entry v1.MapEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.current != nil {
+		c.current.printer.SendEntry(entry)
+	}
+}
+
+// Close records the current capture, leaving the program running as it is unloaded.
+func (c *Capturer) Close() {
+	c.lock.Lock()
+	current := c.current
+	c.lock.Unlock()
+	c.end(current, false)
+}
+
+func (c *Capturer) State() v1.ProgramState {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.state()
+}
+
+// state must be called with the lock held
+func (c *Capturer) state() v1.ProgramState {
+	return c.withCapture(c.controller.State())
+}
+
+func (c *Capturer) Pause() (v1.ProgramState, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	state, err := c.controller.Pause()
+	return c.withCapture(state), err
+}
+
+func (c *Capturer) Resume() (v1.ProgramState, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	state, err := c.controller.Resume()
+	return c.withCapture(state), err
+}
+
+// withCapture must be called with the lock held
+func (c *Capturer) withCapture(state v1.ProgramState) v1.ProgramState {
+	if c.current != nil {
+		until := c.current.metadata.Start.Add(c.cfg.Duration)
+		state.CaptureUntil = &until
+	}
+	return state
+}
@@ -0,0 +1,317 @@
+package capture_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/capture"
+	"github.com/solo-io/bumblebee/pkg/fakes"
+	"github.com/solo-io/bumblebee/pkg/internal/kube"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+type fakeController struct {
+	lock   sync.Mutex
+	paused bool
+}
+
+func (c *fakeController) State() v1.ProgramState {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return v1.ProgramState{Paused: c.paused}
+}
+
+func (c *fakeController) Pause() (v1.ProgramState, error) {
+	c.lock.Lock()
+	c.paused = true
+	c.lock.Unlock()
+	return c.State(), nil
+}
+
+func (c *fakeController) Resume() (v1.ProgramState, error) {
+	c.lock.Lock()
+	c.paused = false
+	c.lock.Unlock()
+	return c.State(), nil
+}
+
+var _ = Describe("Capturer", func() {
+	var (
+		ctx        context.Context
+		cancel     context.CancelFunc
+		dir        string
+		controller *fakeController
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		var err error
+		dir, err = ioutil.TempDir("", "bee-capture")
+		Expect(err).NotTo(HaveOccurred())
+		controller = &fakeController{}
+	})
+
+	AfterEach(func() {
+		cancel()
+		os.RemoveAll(dir)
+	})
+
+	start := func(cfg capture.Config) *capture.Capturer {
+		cfg.Dir = dir
+		capturer, err := capture.New(ctx, cfg, controller)
+		Expect(err).NotTo(HaveOccurred())
+		capturer.NewRingBuf("events", []string{"daddr"})
+		capturer.Start(ctx)
+		Eventually(func() bool { return controller.State().Paused }).Should(BeTrue())
+		return capturer
+	}
+
+	send := func(capturer *capture.Capturer, daddr string) {
+		capturer.SendEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"daddr": daddr}}})
+	}
+
+	// recorded returns the metadata and entries of the captures, once recorded
+	recorded := func() ([]capture.Metadata, [][]string) {
+		var metadata []capture.Metadata
+		var entries [][]string
+		captures, _ := ioutil.ReadDir(dir)
+		for _, c := range captures {
+			data, err := ioutil.ReadFile(filepath.Join(dir, c.Name(), capture.MetadataFile))
+			if err != nil {
+				continue
+			}
+			var m capture.Metadata
+			Expect(json.Unmarshal(data, &m)).To(Succeed())
+			metadata = append(metadata, m)
+			data, err = ioutil.ReadFile(filepath.Join(dir, c.Name(), capture.EntriesFile))
+			Expect(err).NotTo(HaveOccurred())
+			entries = append(entries, strings.Split(strings.TrimSpace(string(data)), "\n"))
+		}
+		return metadata, entries
+	}
+
+	It("runs and records the program once triggered through the API", func() {
+		capturer := start(capture.Config{Duration: 200 * time.Millisecond, Cooldown: time.Hour})
+		send(capturer, "1.1.1.1")
+
+		state, err := capturer.Trigger("latency spike")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(state.Paused).To(BeFalse())
+		Expect(state.CaptureUntil).NotTo(BeNil())
+		send(capturer, "8.8.8.8")
+		_, err = capturer.Trigger("again")
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() int {
+			metadata, _ := recorded()
+			return len(metadata)
+		}).Should(Equal(1))
+		Expect(controller.State().Paused).To(BeTrue())
+		metadata, entries := recorded()
+		Expect(metadata[0].Trigger.Name).To(Equal(capture.APITrigger))
+		Expect(metadata[0].Trigger.Reason).To(Equal("latency spike"))
+		Expect(metadata[0].Refired).To(HaveLen(1))
+		Expect(metadata[0].End.Sub(metadata[0].Start)).To(BeNumerically(">=", 200*time.Millisecond))
+		Expect(entries[0]).To(HaveLen(1))
+		Expect(entries[0][0]).To(ContainSubstring(`"daddr":"8.8.8.8"`))
+
+		_, err = capturer.Trigger("cooling down")
+		Expect(err).To(MatchError(ContainSubstring("cooling down")))
+	})
+
+	It("is triggered by metrics above a threshold", func() {
+		value := "0.2"
+		var lock sync.Mutex
+		prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/api/v1/query"))
+			Expect(r.URL.Query().Get("query")).To(Equal("p99_latency"))
+			lock.Lock()
+			defer lock.Unlock()
+			fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"__name__":"p99_latency","service":"api"},"value":[1652000000,%q]}]}}`, value)
+		}))
+		defer prometheus.Close()
+		above := 0.5
+		start(capture.Config{
+			Duration: 100 * time.Millisecond,
+			Triggers: []capture.TriggerConfig{{
+				Name:   "latency",
+				Metric: &capture.MetricTrigger{URL: prometheus.URL, Query: "p99_latency", Above: &above, Interval: 20 * time.Millisecond},
+			}},
+		})
+
+		Consistently(func() bool { return controller.State().Paused }, 200*time.Millisecond).Should(BeTrue())
+		lock.Lock()
+		value = "0.7"
+		lock.Unlock()
+		Eventually(func() bool { return controller.State().Paused }).Should(BeFalse())
+		cancel()
+		Eventually(func() int {
+			metadata, _ := recorded()
+			return len(metadata)
+		}).Should(Equal(1))
+		metadata, _ := recorded()
+		Expect(metadata[0].Trigger.Name).To(Equal("latency"))
+		Expect(metadata[0].Trigger.Reason).To(Equal(`p99_latency{service="api"} = 0.7`))
+	})
+
+	It("is triggered by Kubernetes events created after it started", func() {
+		apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/api/v1/namespaces/prod/events"))
+			Expect(r.URL.Query().Get("fieldSelector")).To(Equal("reason=OOMKilling,type=Warning"))
+			if r.URL.Query().Get("watch") == "" {
+				fmt.Fprint(w, `{"metadata":{"resourceVersion":"10"}}`)
+				return
+			}
+			if r.URL.Query().Get("resourceVersion") == "10" {
+				// after the program was first paused
+				time.Sleep(100 * time.Millisecond)
+				fmt.Fprintln(w, `{"type":"ADDED","object":{"metadata":{"resourceVersion":"11"},"involvedObject":{"kind":"Pod","namespace":"prod","name":"api-0"},"reason":"OOMKilling","message":"out of memory"}}`)
+				return
+			}
+			<-r.Context().Done()
+		}))
+		defer apiServer.Close()
+		start(capture.Config{
+			Duration: time.Hour,
+			Triggers: []capture.TriggerConfig{{
+				Name:       "oom",
+				Kubernetes: &capture.KubernetesTrigger{Config: kube.Config{APIServer: apiServer.URL}, Namespace: "prod", Reason: "OOMKilling", Type: "Warning"},
+			}},
+		})
+
+		Eventually(func() bool { return controller.State().Paused }).Should(BeFalse())
+		cancel()
+		Eventually(func() int {
+			metadata, _ := recorded()
+			return len(metadata)
+		}).Should(Equal(1))
+		metadata, _ := recorded()
+		Expect(metadata[0].Trigger.Reason).To(Equal("OOMKilling Pod prod/api-0: out of memory"))
+	})
+
+	It("rejects invalid triggers", func() {
+		for _, triggers := range [][]capture.TriggerConfig{
+			{{Metric: &capture.MetricTrigger{URL: "http://prometheus:9090", Query: "up"}}},
+			{{Name: "api", Metric: &capture.MetricTrigger{URL: "http://prometheus:9090", Query: "up"}}},
+			{{Name: "none"}},
+			{{Name: "query", Metric: &capture.MetricTrigger{URL: "prometheus:9090", Query: "up"}}},
+			{{Name: "both", Metric: &capture.MetricTrigger{URL: "http://prometheus:9090", Query: "up"}, Kubernetes: &capture.KubernetesTrigger{Config: kube.Config{APIServer: "http://localhost:8001"}}}},
+		} {
+			_, err := capture.New(ctx, capture.Config{Dir: dir, Triggers: triggers}, controller)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+})
+
+var _ = Describe("capture sessions", func() {
+	// readSession returns the files of a session bundle, by name, in their order
+	readSession := func(data []byte) ([]string, map[string][]byte) {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		Expect(err).NotTo(HaveOccurred())
+		tr := tar.NewReader(gz)
+		var names []string
+		files := map[string][]byte{}
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+			names = append(names, hdr.Name)
+			files[hdr.Name], err = ioutil.ReadAll(tr)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		return names, files
+	}
+
+	It("bundles the entries and logs of the programs run for the duration of the session", func() {
+		events := &fakes.Loader{LoadFunc: func(ctx context.Context, opts *loader.LoadOptions) error {
+			contextutils.LoggerFrom(ctx).Infof("attached")
+			opts.Watcher.NewRingBuf("events", []string{"pid"})
+			opts.Watcher.NewHashMap("counts", []string{"comm"})
+			for i := 0; i < 3; i++ {
+				opts.Watcher.SendEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"pid": fmt.Sprint(i)}}})
+			}
+			opts.Watcher.SendEntry(v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"comm": "curl"}, Value: "1", Hash: 1}})
+			opts.Watcher.SendEntry(v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"comm": "curl"}, Value: "2", Hash: 1}})
+			<-ctx.Done()
+			return ctx.Err()
+		}}
+		failing := &fakes.Loader{LoadFunc: func(ctx context.Context, opts *loader.LoadOptions) error {
+			return errors.New("the verifier rejected the program")
+		}}
+
+		var bundle bytes.Buffer
+		index, err := capture.RunSession(context.Background(), capture.SessionOptions{
+			Programs: []capture.SessionProgram{
+				{Name: "tcpconnect", Loader: events, Opts: &loader.LoadOptions{ProgramRef: "ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7"}},
+				{Name: "broken", Loader: failing, Opts: &loader.LoadOptions{}},
+			},
+			Duration:    50 * time.Millisecond,
+			MaxEvents:   2,
+			Description: "TICKET-42",
+		}, &bundle)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(index.Description).To(Equal("TICKET-42"))
+		Expect(index.End.Sub(index.Start)).To(BeNumerically(">=", 50*time.Millisecond))
+		Expect(index.Host.BeeVersion).NotTo(BeEmpty())
+		Expect(index.Programs).To(Equal([]capture.SessionProgramInfo{
+			{Name: "tcpconnect", Ref: "ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7", Events: 2, Dropped: 1, Maps: []string{"counts", "events"}},
+			{Name: "broken", Error: "the verifier rejected the program"},
+		}))
+
+		names, files := readSession(bundle.Bytes())
+		Expect(names).To(Equal([]string{
+			capture.SessionIndexFile,
+			"host.json",
+			"programs/tcpconnect/events.jsonl",
+			"programs/tcpconnect/maps.json",
+			"programs/broken/events.jsonl",
+			"programs/broken/maps.json",
+			"logs/bee.log",
+		}))
+		Expect(strings.Count(string(files["programs/tcpconnect/events.jsonl"]), "\n")).To(Equal(2))
+		Expect(files["programs/tcpconnect/maps.json"]).To(MatchJSON(`{"counts": [{"key": {"comm": "curl"}, "value": "2"}]}`))
+		Expect(string(files["logs/bee.log"])).To(ContainSubstring(`"msg":"attached"`))
+		Expect(string(files["logs/bee.log"])).To(ContainSubstring(`"program":"tcpconnect"`))
+		for _, f := range index.Files {
+			sum := sha256.Sum256(files[f.Name])
+			Expect(f.SHA256).To(Equal(hex.EncodeToString(sum[:])), f.Name)
+		}
+
+		read, err := capture.ReadSessionIndex(bytes.NewReader(bundle.Bytes()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read.Programs).To(Equal(index.Programs))
+	})
+
+	It("requires a duration and programs of distinct names", func() {
+		_, err := capture.RunSession(context.Background(), capture.SessionOptions{}, ioutil.Discard)
+		Expect(err).To(MatchError("a capture session requires a duration"))
+		loads := &fakes.Loader{}
+		_, err = capture.RunSession(context.Background(), capture.SessionOptions{
+			Duration: time.Second,
+			Programs: []capture.SessionProgram{{Name: "a", Loader: loads, Opts: &loader.LoadOptions{}}, {Name: "a", Loader: loads, Opts: &loader.LoadOptions{}}},
+		}, ioutil.Discard)
+		Expect(err).To(MatchError(ContainSubstring("distinct names")))
+	})
+})
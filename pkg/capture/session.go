@@ -0,0 +1,386 @@
+package capture
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/internal/uname"
+	"github.com/solo-io/bumblebee/pkg/internal/version"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/solo-io/bumblebee/pkg/printer"
+	"github.com/solo-io/go-utils/contextutils"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// SessionIndexFile is the SessionIndex of a session bundle, its first file
+	SessionIndexFile = "index.json"
+	// SessionVersion is the version of the layout of the session bundles
+	SessionVersion = 1
+	// DefaultSessionMaxEvents is the most events of ring buffers recorded per program
+	DefaultSessionMaxEvents = 100000
+)
+
+// SessionProgram is a program run during a capture session.
+type SessionProgram struct {
+	// Name of the program, naming its files in the bundle
+	Name   string
+	Loader loader.Loader
+	// Load options of the program, parsed, its watcher being replaced by the one recording the
+	// entries of its maps
+	Opts *loader.LoadOptions
+}
+
+// SessionOptions configure a capture session.
+type SessionOptions struct {
+	Programs []SessionProgram
+	// How long the programs run, required
+	Duration time.Duration
+	// Most events of the ring buffers recorded per program, the later ones being counted but
+	// not recorded, DefaultSessionMaxEvents if 0
+	MaxEvents int
+	// Why the session was recorded, e.g. the ticket it is attached to
+	Description string
+}
+
+// SessionIndex describes a session bundle and its files.
+type SessionIndex struct {
+	Version     int                  `json:"version"`
+	Description string               `json:"description,omitempty"`
+	Start       time.Time            `json:"start"`
+	End         time.Time            `json:"end"`
+	Host        HostInfo             `json:"host"`
+	Programs    []SessionProgramInfo `json:"programs"`
+	Files       []SessionFile        `json:"files"`
+}
+
+// HostInfo describes the host a session was recorded on.
+type HostInfo struct {
+	Hostname      string `json:"hostname,omitempty"`
+	KernelRelease string `json:"kernelRelease,omitempty"`
+	Machine       string `json:"machine,omitempty"`
+	OS            string `json:"os"`
+	CPUs          int    `json:"cpus"`
+	CgroupMode    string `json:"cgroupMode,omitempty"`
+	BeeVersion    string `json:"beeVersion"`
+}
+
+// SessionProgramInfo is what a program of a session recorded.
+type SessionProgramInfo struct {
+	Name string `json:"name"`
+	Ref  string `json:"ref,omitempty"`
+	// Events of the ring buffers recorded, and the ones dropped once MaxEvents were
+	Events  int `json:"events"`
+	Dropped int `json:"dropped,omitempty"`
+	// Maps the program exported
+	Maps []string `json:"maps,omitempty"`
+	// Why the program failed, e.g. to load, its entries until then being recorded
+	Error string `json:"error,omitempty"`
+}
+
+// SessionFile is a file of a session bundle.
+type SessionFile struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// RunSession runs the programs together for the duration of the session, or until the context
+// is done, then writes the events of their ring buffers, the last entries of their hash maps,
+// the host metadata and the logs of the session to w, as a gzipped tar whose first file is
+// the SessionIndex. Programs failing to load don't fail the session, their errors being
+// recorded in the index, so the bundle can be attached to a support ticket as is.
+func RunSession(ctx context.Context, opts SessionOptions, w io.Writer) (*SessionIndex, error) {
+	if opts.Duration <= 0 {
+		return nil, errors.New("a capture session requires a duration")
+	}
+	if len(opts.Programs) == 0 {
+		return nil, errors.New("a capture session requires programs")
+	}
+	names := map[string]bool{}
+	for _, p := range opts.Programs {
+		if p.Name == "" || names[p.Name] {
+			return nil, fmt.Errorf("the programs of a capture session must have distinct names, not %q", p.Name)
+		}
+		names[p.Name] = true
+	}
+	if opts.MaxEvents <= 0 {
+		opts.MaxEvents = DefaultSessionMaxEvents
+	}
+
+	logs := &lockedBuffer{}
+	ctx = teeLogs(ctx, logs)
+	index := &SessionIndex{
+		Version:     SessionVersion,
+		Description: opts.Description,
+		Start:       time.Now(),
+		Host:        hostInfo(),
+	}
+	sessionCtx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+	recorders := make([]*recorder, len(opts.Programs))
+	errs := make([]error, len(opts.Programs))
+	var wg sync.WaitGroup
+	for i, p := range opts.Programs {
+		i, p := i, p
+		recorders[i] = newRecorder(opts.MaxEvents)
+		p.Opts.Watcher = recorders[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = p.Loader.Load(contextutils.WithLoggerValues(sessionCtx, "program", p.Name), p.Opts)
+		}()
+	}
+	wg.Wait()
+	index.End = time.Now()
+
+	files := map[string][]byte{}
+	var order []string
+	add := func(name, description string, data []byte) {
+		files[name] = data
+		order = append(order, name)
+		sum := sha256.Sum256(data)
+		index.Files = append(index.Files, SessionFile{Name: name, Description: description, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])})
+	}
+	host, err := json.MarshalIndent(index.Host, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	add("host.json", "the host the session was recorded on", host)
+	for i, p := range opts.Programs {
+		r := recorders[i]
+		info := SessionProgramInfo{Name: p.Name, Ref: p.Opts.ProgramRef, Maps: r.mapNames()}
+		if err := errs[i]; err != nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+			info.Error = err.Error()
+		}
+		info.Events, info.Dropped = r.counts()
+		snapshot, err := r.snapshot()
+		if err != nil {
+			return nil, err
+		}
+		add("programs/"+p.Name+"/events.jsonl", "the events of the ring buffers of "+p.Name+", as JSON lines", r.events.Bytes())
+		add("programs/"+p.Name+"/maps.json", "the last entries of the hash maps of "+p.Name+", by map", snapshot)
+		index.Programs = append(index.Programs, info)
+	}
+	add("logs/bee.log", "the logs of the session, as JSON lines", logs.Bytes())
+
+	if err := writeSession(w, index, order, files); err != nil {
+		return nil, fmt.Errorf("could not write the capture session: %w", err)
+	}
+	return index, nil
+}
+
+func writeSession(w io.Writer, index *SessionIndex, order []string, files map[string][]byte) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	write := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: index.End}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := write(SessionIndexFile, data); err != nil {
+		return err
+	}
+	for _, name := range order {
+		if err := write(name, files[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// ReadSessionIndex reads the index of a session bundle, without reading its other files.
+func ReadSessionIndex(r io.Reader) (*SessionIndex, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a capture session: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("not a capture session: %w", err)
+	}
+	if hdr.Name != SessionIndexFile {
+		return nil, fmt.Errorf("not a capture session: its first file is %s rather than %s", hdr.Name, SessionIndexFile)
+	}
+	var index SessionIndex
+	if err := json.NewDecoder(tr).Decode(&index); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", SessionIndexFile, err)
+	}
+	return &index, nil
+}
+
+func hostInfo() HostInfo {
+	info := HostInfo{
+		KernelRelease: uname.Release(),
+		Machine:       uname.Machine(),
+		OS:            runtime.GOOS,
+		CPUs:          runtime.NumCPU(),
+		BeeVersion:    version.Version,
+	}
+	info.Hostname, _ = os.Hostname()
+	if mode, err := loader.DetectCgroupMode(); err == nil {
+		info.CgroupMode = string(mode)
+	}
+	return info
+}
+
+// teeLogs returns a context whose logger also writes to w, as JSON lines.
+func teeLogs(ctx context.Context, w io.Writer) context.Context {
+	logger := contextutils.LoggerFrom(ctx).Desugar()
+	encoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	core := zapcore.NewTee(logger.Core(), zapcore.NewCore(encoder, zapcore.AddSync(w), zapcore.DebugLevel))
+	return contextutils.WithExistingLogger(ctx, zap.New(core).Sugar())
+}
+
+type lockedBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]byte{}, b.buf.Bytes()...)
+}
+
+// recorder records the events of the ring buffers of a program as JSON lines, and the last
+// entry of each key of its hash maps.
+type recorder struct {
+	lock      sync.Mutex
+	maxEvents int
+	events    bytes.Buffer
+	printer   *printer.Printer
+	maps      []watchedMap
+	recorded  int
+	dropped   int
+	// last entries of the hash maps, by map and key
+	entries map[string]map[string]v1.MapEntry
+}
+
+func newRecorder(maxEvents int) *recorder {
+	r := &recorder{maxEvents: maxEvents, entries: map[string]map[string]v1.MapEntry{}}
+	r.printer = printer.New(&r.events, printer.Opts{Format: printer.JSONFormat})
+	return r
+}
+
+func (r *recorder) NewRingBuf(name string, keys []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.maps = append(r.maps, watchedMap{name: name, keys: keys})
+	r.printer.NewRingBuf(name, keys)
+}
+
+func (r *recorder) NewHashMap(name string, keys []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.maps = append(r.maps, watchedMap{name: name, keys: keys, hash: true})
+	r.entries[name] = map[string]v1.MapEntry{}
+}
+
+func (r *recorder) SendEntry(entry v1.MapEntry) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if entries, ok := r.entries[entry.Name]; ok {
+		entries[entryKey(entry.Entry)] = entry
+		return
+	}
+	if r.recorded >= r.maxEvents {
+		r.dropped++
+		return
+	}
+	r.recorded++
+	r.printer.SendEntry(entry)
+}
+
+// entryKey identifies the key of an entry of a hash map.
+func entryKey(kv v1.KvPair) string {
+	if kv.Hash != 0 {
+		return strconv.FormatUint(kv.Hash, 10)
+	}
+	keys := make([]string, 0, len(kv.Key))
+	for k, v := range kv.Key {
+		keys = append(keys, k+"="+v)
+	}
+	sort.Strings(keys)
+	data, _ := json.Marshal(keys)
+	return string(data)
+}
+
+// Close leaves the entries to be written to the bundle.
+func (r *recorder) Close() {}
+
+func (r *recorder) counts() (int, int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.recorded, r.dropped
+}
+
+func (r *recorder) mapNames() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var names []string
+	for _, m := range r.maps {
+		names = append(names, m.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type snapshotEntry struct {
+	Key   map[string]string `json:"key"`
+	Value string            `json:"value"`
+}
+
+// snapshot returns the last entries of the hash maps, sorted by key, by map.
+func (r *recorder) snapshot() ([]byte, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	maps := map[string][]snapshotEntry{}
+	for name, entries := range r.entries {
+		keys := make([]string, 0, len(entries))
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		snapshot := make([]snapshotEntry, 0, len(keys))
+		for _, key := range keys {
+			e := entries[key].Entry
+			snapshot = append(snapshot, snapshotEntry{Key: e.Key, Value: e.Value})
+		}
+		maps[name] = snapshot
+	}
+	return json.MarshalIndent(maps, "", "  ")
+}
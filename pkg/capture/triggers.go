@@ -0,0 +1,342 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/solo-io/bumblebee/pkg/internal/kube"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+const (
+	defaultMetricInterval = 15 * time.Second
+
+	// ServiceAccountDir holds the token and CA certificate Kubernetes triggers use by default
+	ServiceAccountDir = kube.ServiceAccountDir
+
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// trigger calls fire until the context is done.
+type trigger interface {
+	watch(ctx context.Context, fire func(reason string))
+}
+
+type namedTrigger struct {
+	name    string
+	trigger trigger
+}
+
+// MetricTrigger fires when a Prometheus query returns a sample, or a sample above a threshold.
+type MetricTrigger struct {
+	// URL of the Prometheus server, e.g. http://prometheus:9090
+	URL string `yaml:"url"`
+	// Query evaluated at every interval, e.g.
+	// histogram_quantile(0.99, rate(http_request_duration_seconds_bucket[1m])) > 0.5
+	Query string `yaml:"query"`
+	// Fire when a sample is above this value, rather than when the query returns any sample
+	Above *float64 `yaml:"above,omitempty"`
+	// Interval the query is evaluated at, defaults to 15s
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+type metricTrigger struct {
+	cfg    MetricTrigger
+	client *http.Client
+}
+
+func newMetricTrigger(cfg MetricTrigger) (*metricTrigger, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid Prometheus URL %s", cfg.URL)
+	}
+	if cfg.Query == "" {
+		return nil, errors.New("a metric trigger must have a query")
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultMetricInterval
+	}
+	if cfg.Interval < 0 {
+		return nil, errors.New("the interval of a metric trigger must be positive")
+	}
+	return &metricTrigger{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Interval},
+	}, nil
+}
+
+func (t *metricTrigger) watch(ctx context.Context, fire func(reason string)) {
+	logger := contextutils.LoggerFrom(ctx)
+	ticker := time.NewTicker(t.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		reason, err := t.evaluate(ctx)
+		if err != nil {
+			logger.Warnf("could not evaluate capture trigger query %s: %v", t.cfg.Query, err)
+			continue
+		}
+		if reason != "" {
+			fire(reason)
+		}
+	}
+}
+
+type queryResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+type vectorSample struct {
+	Metric map[string]string `json:"metric"`
+	// [unix time, "value"]
+	Value [2]interface{} `json:"value"`
+}
+
+// evaluate returns why the trigger fires, or an empty reason if it doesn't.
+func (t *metricTrigger) evaluate(ctx context.Context) (string, error) {
+	query := url.Values{"query": {t.cfg.Query}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(t.cfg.URL, "/")+"/api/v1/query?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var body queryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("unexpected response %s: %w", resp.Status, err)
+	}
+	if body.Status != "success" {
+		return "", fmt.Errorf("query failed: %s", body.Error)
+	}
+	var samples []vectorSample
+	switch body.Data.ResultType {
+	case "vector":
+		if err := json.Unmarshal(body.Data.Result, &samples); err != nil {
+			return "", err
+		}
+	case "scalar":
+		var sample vectorSample
+		if err := json.Unmarshal(body.Data.Result, &sample.Value); err != nil {
+			return "", err
+		}
+		samples = append(samples, sample)
+	default:
+		return "", fmt.Errorf("the query must return a vector or a scalar, not a %s", body.Data.ResultType)
+	}
+	for _, sample := range samples {
+		raw, _ := sample.Value[1].(string)
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			continue
+		}
+		if t.cfg.Above != nil && value <= *t.cfg.Above {
+			continue
+		}
+		return fmt.Sprintf("%s%s = %s", sample.Metric["__name__"], labels(sample.Metric), raw), nil
+	}
+	return "", nil
+}
+
+func labels(metric map[string]string) string {
+	var pairs []string
+	for k, v := range metric {
+		if k != "__name__" {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", k, v))
+		}
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// KubernetesTrigger fires on Kubernetes events, e.g. OOMKilling or BackOff events of pods.
+type KubernetesTrigger struct {
+	// Namespace of the events, all of them if empty
+	Namespace string `yaml:"namespace,omitempty"`
+	// Kind and name of the object of the events, e.g. Pod, any object if empty
+	Kind string `yaml:"kind,omitempty"`
+	Name string `yaml:"name,omitempty"`
+	// Reason of the events, e.g. BackOff, any reason if empty
+	Reason string `yaml:"reason,omitempty"`
+	// Type of the events, Normal or Warning, any type if empty
+	Type string `yaml:"type,omitempty"`
+	// API server the events are watched from, its timeout is ignored
+	kube.Config `yaml:",inline"`
+}
+
+type kubernetesTrigger struct {
+	cfg        KubernetesTrigger
+	eventsPath string
+	selector   string
+	client     *kube.Client
+}
+
+func newKubernetesTrigger(cfg KubernetesTrigger) (*kubernetesTrigger, error) {
+	// no timeout, as the events are watched
+	kubeCfg := cfg.Config
+	kubeCfg.Timeout = 0
+	client, err := kube.NewClient(kubeCfg)
+	if err != nil {
+		return nil, err
+	}
+	eventsPath := "/api/v1/events"
+	if cfg.Namespace != "" {
+		eventsPath = "/api/v1/namespaces/" + url.PathEscape(cfg.Namespace) + "/events"
+	}
+	var selectors []string
+	for field, value := range map[string]string{
+		"involvedObject.kind": cfg.Kind,
+		"involvedObject.name": cfg.Name,
+		"reason":              cfg.Reason,
+		"type":                cfg.Type,
+	} {
+		if value != "" {
+			selectors = append(selectors, field+"="+value)
+		}
+	}
+	sort.Strings(selectors)
+	return &kubernetesTrigger{
+		cfg:        cfg,
+		eventsPath: eventsPath,
+		selector:   strings.Join(selectors, ","),
+		client:     client,
+	}, nil
+}
+
+type eventList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type kubernetesEvent struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	InvolvedObject struct {
+		Kind      string `json:"kind"`
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	} `json:"involvedObject"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// watch lists the events to only fire on the ones created afterwards, then watches them,
+// listing them again if the watch expired.
+func (t *kubernetesTrigger) watch(ctx context.Context, fire func(reason string)) {
+	logger := contextutils.LoggerFrom(ctx)
+	delay := minReconnectDelay
+	var resourceVersion string
+	for ctx.Err() == nil {
+		var err error
+		if resourceVersion == "" {
+			resourceVersion, err = t.list(ctx)
+		}
+		if err == nil {
+			resourceVersion, err = t.watchFrom(ctx, resourceVersion, fire)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			// the watch timed out, resume it immediately
+			delay = minReconnectDelay
+			continue
+		}
+		logger.Warnf("could not watch Kubernetes events, retrying in %s: %v", delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+func (t *kubernetesTrigger) request(ctx context.Context, query url.Values) (*http.Response, error) {
+	query.Set("fieldSelector", t.selector)
+	return t.client.Request(ctx, http.MethodGet, t.eventsPath, query, "", nil)
+}
+
+func (t *kubernetesTrigger) list(ctx context.Context) (string, error) {
+	resp, err := t.request(ctx, url.Values{"limit": {"1"}})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var list eventList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return "", fmt.Errorf("could not decode events: %w", err)
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+// watchFrom fires on the events added after the resource version until the watch ends,
+// and returns the resource version to resume from, empty if the events must be listed again.
+func (t *kubernetesTrigger) watchFrom(ctx context.Context, resourceVersion string, fire func(reason string)) (string, error) {
+	resp, err := t.request(ctx, url.Values{
+		"watch":               {"1"},
+		"resourceVersion":     {resourceVersion},
+		"allowWatchBookmarks": {"true"},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event watchEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return "", fmt.Errorf("could not decode watch event: %w", err)
+		}
+		if event.Type == "ERROR" {
+			// usually 410 Gone, once the resource version is too old
+			return "", nil
+		}
+		var kubeEvent kubernetesEvent
+		if err := json.Unmarshal(event.Object, &kubeEvent); err != nil {
+			return "", fmt.Errorf("could not decode event: %w", err)
+		}
+		resourceVersion = kubeEvent.Metadata.ResourceVersion
+		if event.Type != "ADDED" {
+			continue
+		}
+		obj := kubeEvent.InvolvedObject
+		fire(fmt.Sprintf("%s %s %s/%s: %s", kubeEvent.Reason, obj.Kind, obj.Namespace, obj.Name, kubeEvent.Message))
+	}
+	return resourceVersion, scanner.Err()
+}
@@ -1,31 +1,39 @@
+// Package cli is the bee CLI. Its commands can be embedded in other CLIs, with their own
+// name and defaults, rather than shelling out to bee:
+//
+//	root := &cobra.Command{Use: "acme"}
+//	opts := cli.NewOptions(root.PersistentFlags())
+//	root.PersistentPreRunE = cli.PreRun(opts)
+//	root.AddCommand(cli.NewPullCmd(opts), cli.NewRunCmd(opts))
+//	cli.Rebrand(root, "acme")
+//	cli.SetFlagDefault(root, "storage", "/var/lib/acme/store")
 package cli
 
 import (
+	"fmt"
 	"path/filepath"
+	"regexp"
 
 	dockercliconfig "github.com/docker/cli/cli/config"
-	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/build"
-	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/describe"
-	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/initialize"
-	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/list"
-	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/login"
-	package_cmd "github.com/solo-io/bumblebee/pkg/cli/internal/commands/package"
-	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/pull"
-	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/push"
-	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/run"
-	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/tag"
-	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/version"
 	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
-func Bee() *cobra.Command {
-	cmd := &cobra.Command{
-		Use: "bee",
-	}
-	opts := options.NewGeneralOptions(cmd.PersistentFlags())
+// Options are the options shared by the commands, set by the flags NewOptions adds.
+type Options = options.GeneralOptions
+
+// NewOptions returns the options shared by the commands, adding their flags to the flag set,
+// e.g. the persistent flags of the root command the commands are added to.
+func NewOptions(flags *pflag.FlagSet) *Options {
+	return options.NewGeneralOptions(flags)
+}
 
-	cmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+// PreRun returns the PersistentPreRunE of the root command of the commands, reading the
+// credentials, the rules of the refs and the verifier of the packages of the options once
+// the flags are parsed. Embedding CLIs with their own pre-run must call it too.
+func PreRun(opts *Options) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
 		if opts.AuthOptions.CredentialsFiles == nil {
 			// use config file first first and then dockers, the enables:
 			// - the first one will be used for writing (i.e. in login)
@@ -35,20 +43,54 @@ func Bee() *cobra.Command {
 				filepath.Join(dockercliconfig.Dir(), dockercliconfig.ConfigFileName),
 			}
 		}
+		if err := opts.LoadRefRules(); err != nil {
+			return err
+		}
+		return opts.LoadVerifier(cmd.Context())
 	}
+}
 
-	cmd.AddCommand(
-		build.Command(opts),
-		package_cmd.Command(opts),
-		run.Command(opts),
-		initialize.Command(),
-		push.Command(opts),
-		pull.Command(opts),
-		list.Command(opts),
-		tag.Command(opts),
-		describe.Command(opts),
-		login.Command(opts),
-		version.Command(opts),
-	)
+func Bee() *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "bee",
+	}
+	opts := NewOptions(cmd.PersistentFlags())
+	cmd.PersistentPreRunE = PreRun(opts)
+	cmd.AddCommand(Commands(opts)...)
 	return cmd
 }
+
+// beeInvocation matches bee invoked in the help of the commands, e.g. `$ bee run`.
+var beeInvocation = regexp.MustCompile("(^|[\\s'\"`(])bee ")
+
+// Rebrand replaces the invocations of bee in the help of the command and its subcommands with
+// the name, e.g. the one of the embedding CLI or `acme ebpf` for a subcommand of it.
+func Rebrand(cmd *cobra.Command, name string) {
+	replace := func(s string) string {
+		return beeInvocation.ReplaceAllString(s, "${1}"+name+" ")
+	}
+	cmd.Short, cmd.Long, cmd.Example = replace(cmd.Short), replace(cmd.Long), replace(cmd.Example)
+	for _, sub := range cmd.Commands() {
+		Rebrand(sub, name)
+	}
+}
+
+// SetFlagDefault changes the default of a flag of the command, or of the persistent flags it
+// inherits, e.g. the storage directory of the embedding CLI, its help showing the new default.
+func SetFlagDefault(cmd *cobra.Command, name, value string) error {
+	flag := cmd.Flags().Lookup(name)
+	if flag == nil {
+		flag = cmd.PersistentFlags().Lookup(name)
+	}
+	if flag == nil {
+		flag = cmd.InheritedFlags().Lookup(name)
+	}
+	if flag == nil {
+		return fmt.Errorf("%s has no flag %s", cmd.CommandPath(), name)
+	}
+	if err := flag.Value.Set(value); err != nil {
+		return fmt.Errorf("invalid default of --%s: %w", name, err)
+	}
+	flag.DefValue = flag.Value.String()
+	return nil
+}
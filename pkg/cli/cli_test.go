@@ -0,0 +1,43 @@
+package cli_test
+
+import (
+	"github.com/solo-io/bumblebee/pkg/cli"
+	"github.com/spf13/cobra"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("embedding the commands", func() {
+	It("adds the commands to another CLI, with its name and defaults", func() {
+		root := &cobra.Command{Use: "acme"}
+		ebpf := &cobra.Command{Use: "ebpf"}
+		root.AddCommand(ebpf)
+		opts := cli.NewOptions(ebpf.PersistentFlags())
+		ebpf.PersistentPreRunE = cli.PreRun(opts)
+		stack := cli.NewStackCmd(opts)
+		ebpf.AddCommand(cli.NewPullCmd(opts), stack)
+
+		cli.Rebrand(ebpf, "acme ebpf")
+		Expect(stack.Long).To(ContainSubstring("$ acme ebpf stack observability.yaml"))
+		Expect(stack.Long).NotTo(ContainSubstring("$ bee "))
+
+		Expect(cli.SetFlagDefault(stack, "storage", "/var/lib/acme/store")).To(Succeed())
+		Expect(opts.OCIStorageDir).To(Equal("/var/lib/acme/store"))
+		Expect(ebpf.PersistentFlags().Lookup("storage").DefValue).To(Equal("/var/lib/acme/store"))
+		Expect(cli.SetFlagDefault(ebpf, "offline", "maybe")).To(MatchError(ContainSubstring("invalid default of --offline")))
+		Expect(cli.SetFlagDefault(root, "storage", "/tmp")).To(MatchError("acme has no flag storage"))
+	})
+
+	It("builds bee from the same commands", func() {
+		var names []string
+		for _, cmd := range cli.Commands(cli.NewOptions((&cobra.Command{}).PersistentFlags())) {
+			names = append(names, cmd.Name())
+		}
+		var beeNames []string
+		for _, cmd := range cli.Bee().Commands() {
+			beeNames = append(beeNames, cmd.Name())
+		}
+		Expect(names).To(ConsistOf(beeNames))
+	})
+})
@@ -0,0 +1,293 @@
+package cli
+
+import (
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/apikey"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/attach"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/build"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/bundle"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/capture"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/debuglogs"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/describe"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/doctor"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/fleet"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/helper"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/initialize"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/inspect"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/list"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/login"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/maintain"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/maps"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/migrate"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/mirror"
+	package_cmd "github.com/solo-io/bumblebee/pkg/cli/internal/commands/package"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/pause"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/pick"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/pins"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/promote"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/pull"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/push"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/replay"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/run"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/sign"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/skeleton"
+	stack_cmd "github.com/solo-io/bumblebee/pkg/cli/internal/commands/stack"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/tag"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/test"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/variants"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/version"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/vmlinux"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/vmtest"
+	"github.com/spf13/cobra"
+)
+
+// NewBuildCmd returns the command which builds a BPF program and saves it as an OCI image, as `bee build`.
+func NewBuildCmd(opts *Options) *cobra.Command {
+	return build.Command(opts)
+}
+
+// NewVmlinuxCmd returns the command which generates a vmlinux.h from BTF, as `bee vmlinux`.
+func NewVmlinuxCmd(opts *Options) *cobra.Command {
+	return vmlinux.Command(opts)
+}
+
+// NewPackageCmd returns the command which packages a BPF program image with the bee runner in a docker image, as `bee package`.
+func NewPackageCmd(opts *Options) *cobra.Command {
+	return package_cmd.Command(opts)
+}
+
+// NewRunCmd returns the command which runs a BPF program file or OCI image, as `bee run`.
+func NewRunCmd(opts *Options) *cobra.Command {
+	return run.Command(opts)
+}
+
+// NewAttachCmd returns the command which attaches the TUI to a program run by a remote agent, as `bee attach`.
+func NewAttachCmd(opts *Options) *cobra.Command {
+	return attach.Command(opts)
+}
+
+// NewPauseCmd returns the command which pauses the program run by an agent, as `bee pause`.
+func NewPauseCmd(opts *Options) *cobra.Command {
+	return pause.Command(opts)
+}
+
+// NewResumeCmd returns the command which resumes the program run by an agent, as `bee resume`.
+func NewResumeCmd(opts *Options) *cobra.Command {
+	return pause.ResumeCommand(opts)
+}
+
+// NewTriggerCmd returns the command which starts a capture of the program run by an agent, as `bee trigger`.
+func NewTriggerCmd(opts *Options) *cobra.Command {
+	return pause.TriggerCommand(opts)
+}
+
+// NewEnforceCmd returns the command which switches the enforcement program run by an agent to enforce its policy, as `bee enforce`.
+func NewEnforceCmd(opts *Options) *cobra.Command {
+	return pause.EnforceCommand(opts)
+}
+
+// NewObserveCmd returns the command which switches the enforcement program run by an agent back to only observe, as `bee observe`.
+func NewObserveCmd(opts *Options) *cobra.Command {
+	return pause.ObserveCommand(opts)
+}
+
+// NewDebugLogsCmd returns the command which tails the logs of the load of the program run by an agent, as `bee debug-logs`.
+func NewDebugLogsCmd(opts *Options) *cobra.Command {
+	return debuglogs.Command(opts)
+}
+
+// NewFleetCmd returns the command which aggregates the maps of several agents into fleet-wide views, as `bee fleet`.
+func NewFleetCmd(opts *Options) *cobra.Command {
+	return fleet.Command(opts)
+}
+
+// NewAPIKeyCmd returns the command which generates a key for the clients of an agent, as `bee api-key`.
+func NewAPIKeyCmd(opts *Options) *cobra.Command {
+	return apikey.Command(opts)
+}
+
+// NewHelperCmd returns the command which runs the privileged helper loading programs for unprivileged processes, as `bee helper`.
+func NewHelperCmd(opts *Options) *cobra.Command {
+	return helper.Command(opts)
+}
+
+// NewInitCmd returns the command which initializes a sample BPF program, as `bee init`.
+func NewInitCmd(opts *Options) *cobra.Command {
+	return initialize.Command()
+}
+
+// NewPushCmd returns the command which pushes an OCI image to a registry, as `bee push`.
+func NewPushCmd(opts *Options) *cobra.Command {
+	return push.Command(opts)
+}
+
+// NewPullCmd returns the command which pulls an OCI image from a registry, as `bee pull`.
+func NewPullCmd(opts *Options) *cobra.Command {
+	return pull.Command(opts)
+}
+
+// NewListCmd returns the command which lists the images of the local store, as `bee list`.
+func NewListCmd(opts *Options) *cobra.Command {
+	return list.Command(opts)
+}
+
+// NewTagCmd returns the command which adds a name to an image of the local store, as `bee tag`.
+func NewTagCmd(opts *Options) *cobra.Command {
+	return tag.Command(opts)
+}
+
+// NewVariantsCmd returns the command which saves the packages of several images as a multi-variant image, as `bee variants`.
+func NewVariantsCmd(opts *Options) *cobra.Command {
+	return variants.Command(opts)
+}
+
+// NewSignCmd returns the command which signs an OCI image, as `bee sign`.
+func NewSignCmd(opts *Options) *cobra.Command {
+	return sign.Command(opts)
+}
+
+// NewPickCmd returns the command which picks a package to run and its parameters interactively, as `bee pick`.
+func NewPickCmd(opts *Options) *cobra.Command {
+	return pick.Command(opts)
+}
+
+// NewStackCmd returns the command which runs the programs of a stack file together, as `bee stack`.
+func NewStackCmd(opts *Options) *cobra.Command {
+	return stack_cmd.Command(opts)
+}
+
+// NewPromoteCmd returns the command which promotes a package digest to a release channel, as `bee promote`.
+func NewPromoteCmd(opts *Options) *cobra.Command {
+	return promote.Command(opts)
+}
+
+// NewMigrateCmd returns the command which rewrites images from the deprecated v1 media types, and migrates the local store, as `bee migrate`.
+func NewMigrateCmd(opts *Options) *cobra.Command {
+	return migrate.Command(opts)
+}
+
+// NewMaintainCmd returns the command which verifies, re-signs and re-annotates the tags of a repository, as `bee maintain`.
+func NewMaintainCmd(opts *Options) *cobra.Command {
+	return maintain.Command(opts)
+}
+
+// NewMirrorCmd returns the command which replicates images and their signatures to another registry, as `bee mirror`.
+func NewMirrorCmd(opts *Options) *cobra.Command {
+	return mirror.Command(opts)
+}
+
+// NewBundleCmd returns the command which exports and verifies packages as bundle files, as `bee bundle`.
+func NewBundleCmd(opts *Options) *cobra.Command {
+	return bundle.Command(opts)
+}
+
+// NewDescribeCmd returns the command which describes a BPF program by its OCI ref, as `bee describe`.
+func NewDescribeCmd(opts *Options) *cobra.Command {
+	return describe.Command(opts)
+}
+
+// NewDoctorCmd returns the command which checks the host has the features a package requires, as `bee doctor`.
+func NewDoctorCmd(opts *Options) *cobra.Command {
+	return doctor.Command(opts)
+}
+
+// NewInspectCmd returns the command which shows the manifest and config of a package without pulling it, as `bee inspect`.
+func NewInspectCmd(opts *Options) *cobra.Command {
+	return inspect.Command(opts)
+}
+
+// NewMapsCmd returns the command which exports and imports the state held in the maps of a program, as `bee maps`.
+func NewMapsCmd(opts *Options) *cobra.Command {
+	return maps.Command(opts)
+}
+
+// NewPinsCmd returns the command which lists and cleans up the maps and programs pinned by bee run, as `bee pins`.
+func NewPinsCmd(opts *Options) *cobra.Command {
+	return pins.Command(opts)
+}
+
+// NewTestCmd returns the command which runs the test cases and fixtures declared in a package, as `bee test`.
+func NewTestCmd(opts *Options) *cobra.Command {
+	return test.Command(opts)
+}
+
+// NewReplayCmd returns the command which decodes the events recorded by bee run, as `bee replay`.
+func NewReplayCmd(opts *Options) *cobra.Command {
+	return replay.Command(opts)
+}
+
+// NewCaptureCmd returns the command which runs programs for a bounded duration and bundles what they recorded, as `bee capture`.
+func NewCaptureCmd(opts *Options) *cobra.Command {
+	return capture.Command(opts)
+}
+
+// NewEmulateCmd returns the command which renders the maps of a BPF program with recorded or synthetic events, as `bee emulate`.
+func NewEmulateCmd(opts *Options) *cobra.Command {
+	return run.EmulateCommand(opts)
+}
+
+// NewVmtestCmd returns the command which loads and attaches a package on a matrix of kernels booted in VMs, as `bee vmtest`.
+func NewVmtestCmd(opts *Options) *cobra.Command {
+	return vmtest.Command(opts)
+}
+
+// NewSkeletonCmd returns the command which generates a loader skeleton to embed a BPF program in another binary, as `bee skeleton`.
+func NewSkeletonCmd(opts *Options) *cobra.Command {
+	return skeleton.Command(opts)
+}
+
+// NewLoginCmd returns the command which logs in to a registry, as `bee login`.
+func NewLoginCmd(opts *Options) *cobra.Command {
+	return login.Command(opts)
+}
+
+// NewVersionCmd returns the command which displays the version, as `bee version`.
+func NewVersionCmd(opts *Options) *cobra.Command {
+	return version.Command(opts)
+}
+
+// Commands returns all the commands of bee, in the order of its help.
+func Commands(opts *Options) []*cobra.Command {
+	return []*cobra.Command{
+		NewBuildCmd(opts),
+		NewVmlinuxCmd(opts),
+		NewPackageCmd(opts),
+		NewRunCmd(opts),
+		NewAttachCmd(opts),
+		NewPauseCmd(opts),
+		NewResumeCmd(opts),
+		NewTriggerCmd(opts),
+		NewEnforceCmd(opts),
+		NewObserveCmd(opts),
+		NewDebugLogsCmd(opts),
+		NewFleetCmd(opts),
+		NewAPIKeyCmd(opts),
+		NewHelperCmd(opts),
+		NewInitCmd(opts),
+		NewPushCmd(opts),
+		NewPullCmd(opts),
+		NewListCmd(opts),
+		NewTagCmd(opts),
+		NewVariantsCmd(opts),
+		NewSignCmd(opts),
+		NewPickCmd(opts),
+		NewStackCmd(opts),
+		NewPromoteCmd(opts),
+		NewMigrateCmd(opts),
+		NewMaintainCmd(opts),
+		NewMirrorCmd(opts),
+		NewBundleCmd(opts),
+		NewDescribeCmd(opts),
+		NewDoctorCmd(opts),
+		NewInspectCmd(opts),
+		NewMapsCmd(opts),
+		NewPinsCmd(opts),
+		NewTestCmd(opts),
+		NewReplayCmd(opts),
+		NewCaptureCmd(opts),
+		NewEmulateCmd(opts),
+		NewVmtestCmd(opts),
+		NewSkeletonCmd(opts),
+		NewLoginCmd(opts),
+		NewVersionCmd(opts),
+	}
+}
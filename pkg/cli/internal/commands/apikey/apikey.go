@@ -0,0 +1,90 @@
+package apikey
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/solo-io/bumblebee/pkg/agent"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+type apiKeyOptions struct {
+	general *options.GeneralOptions
+
+	name    string
+	role    string
+	expires time.Duration
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *apiKeyOptions) {
+	flags.StringVar(&opts.name, "name", "", "Name of the client the key is for, e.g. grafana")
+	flags.StringVar(&opts.role, "role", string(agent.RoleRead), "Role of the key: read, to watch the maps, or admin, to also control the program")
+	flags.DurationVar(&opts.expires, "expires", 0, "Refuse the key once this duration elapsed, e.g. 720h, never if 0")
+}
+
+// Command generates a key of the agent API.
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	apiKeyOpts := &apiKeyOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "api-key",
+		Short: "Generate a key for the clients of an agent run with --api-keys.",
+		Long: `
+The bee api-key command prints a random key, and the entry to add to the keys file of the agent,
+which only holds the hash of the key:
+$ echo "keys:" > keys.yaml
+$ bee api-key --name grafana --role read >> keys.yaml
+$ bee run --no-tty --api-port=9092 --api-keys keys.yaml ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7
+$ bee attach --token bee_... 10.0.0.1:9092
+
+The keys file is reloaded when it changes. To rotate a key, add the new one, move the clients to
+it, then remove the old one, or generate the new key with --expires to rotate it again later.
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return generate(apiKeyOpts)
+		},
+		SilenceUsage: true,
+	}
+	addToFlags(cmd.PersistentFlags(), apiKeyOpts)
+	return cmd
+}
+
+// keyEntry is an agent.APIKey as written in the keys file.
+type keyEntry struct {
+	Name    string     `yaml:"name"`
+	Role    agent.Role `yaml:"role"`
+	SHA256  string     `yaml:"sha256"`
+	Expires string     `yaml:"expires,omitempty"`
+}
+
+func generate(opts *apiKeyOptions) error {
+	role := agent.Role(opts.role)
+	if role != agent.RoleRead && role != agent.RoleAdmin {
+		return fmt.Errorf("unknown role '%s', must be read or admin", opts.role)
+	}
+	if opts.name == "" {
+		return fmt.Errorf("the key must have a --name")
+	}
+	key, hash, err := agent.GenerateAPIKey()
+	if err != nil {
+		return fmt.Errorf("could not generate key: %w", err)
+	}
+	entry := keyEntry{Name: opts.name, Role: role, SHA256: hash}
+	if opts.expires > 0 {
+		entry.Expires = time.Now().Add(opts.expires).UTC().Format(time.RFC3339)
+	}
+	out, err := yaml.Marshal([]keyEntry{entry})
+	if err != nil {
+		return err
+	}
+	// only the entry on stdout, so it can be appended to the keys of the file
+	fmt.Fprintf(os.Stderr, "Key of %s, it is not printed again: %s\n", opts.name, key)
+	fmt.Print(string(out))
+	return nil
+}
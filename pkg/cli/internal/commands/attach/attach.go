@@ -0,0 +1,89 @@
+package attach
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/solo-io/bumblebee/pkg/agent"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/client"
+	"github.com/solo-io/bumblebee/pkg/tui"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+type attachOptions struct {
+	general *options.GeneralOptions
+
+	ssh   agent.SSHOpts
+	token string
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *attachOptions) {
+	flags.StringVar(&opts.ssh.Destination, "ssh", "", "Connect to the agent through an SSH tunnel to the given [user@]host[:port], the agent address is then resolved from the SSH server, e.g. localhost:9092")
+	flags.StringVarP(&opts.ssh.IdentityFile, "ssh-identity", "i", "", "Private key used to authenticate the SSH connection, in addition to the keys of the running ssh-agent. Defaults to the keys in ~/.ssh")
+	flags.StringVar(&opts.ssh.KnownHostsFile, "ssh-known-hosts", "", "Known hosts file used to verify the SSH server. Defaults to ~/.ssh/known_hosts")
+	flags.BoolVar(&opts.ssh.InsecureIgnoreHostKey, "ssh-insecure-ignore-host-key", false, "Do not verify the host key of the SSH server")
+	flags.StringVar(&opts.token, "token", os.Getenv("BEE_API_TOKEN"), "API key or JWT sent to agents run with --api-keys, defaults to $BEE_API_TOKEN")
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	attachOpts := &attachOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "attach AGENT_ADDRESS",
+		Short: "Attach the TUI to a program run by a remote bee agent.",
+		Long: `
+The bee attach command renders the TUI for a program running on another host.
+The program must be run with the agent API enabled:
+$ bee run --no-tty --api-port=9092 ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7
+
+Then attach to it from anywhere the port is reachable:
+$ bee attach 10.0.0.1:9092
+
+The connection is re-established automatically if it is lost.
+
+If only SSH is reachable, tunnel the connection through the agent host:
+$ bee attach --ssh user@10.0.0.1 localhost:9092
+`,
+		Args: cobra.ExactArgs(1), // agent address
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return attach(cmd.Context(), args[0], attachOpts)
+		},
+		SilenceUsage: true,
+	}
+	addToFlags(cmd.PersistentFlags(), attachOpts)
+	return cmd
+}
+
+func attach(ctx context.Context, addr string, opts *attachOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopper := make(chan os.Signal, 1)
+	signal.Notify(stopper, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stopper
+		cancel()
+	}()
+
+	app := tui.NewApp(&tui.AppOpts{
+		ProgLocation: fmt.Sprintf("remote agent %s", addr),
+	})
+	clientOpts := &client.Options{Token: opts.token}
+	if opts.ssh.Destination != "" {
+		tunnel, err := agent.NewSSHTunnel(ctx, &opts.ssh)
+		if err != nil {
+			return fmt.Errorf("could not open ssh tunnel: %w", err)
+		}
+		defer tunnel.Close()
+		clientOpts.DialContext = tunnel.DialContext
+	}
+	c := client.New(addr, clientOpts)
+	return app.RunWithSource(ctx, func(ctx context.Context) error {
+		return c.Watch(ctx, &app)
+	})
+}
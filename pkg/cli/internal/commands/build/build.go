@@ -2,6 +2,7 @@ package build
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -16,10 +17,13 @@ import (
 	"github.com/spf13/pflag"
 	"oras.land/oras-go/pkg/content"
 
+	v1 "github.com/solo-io/bumblebee/api/v1"
 	"github.com/solo-io/bumblebee/builder"
 	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
 	"github.com/solo-io/bumblebee/pkg/internal/version"
+	"github.com/solo-io/bumblebee/pkg/project"
 	"github.com/solo-io/bumblebee/pkg/spec"
+	"github.com/solo-io/bumblebee/pkg/vmlinux"
 )
 
 type buildOptions struct {
@@ -31,11 +35,37 @@ type buildOptions struct {
 	CFlags     []string
 	BuildScript       string
 	BuildScriptOutput bool
+	CacheDir          string
+	CacheRef          string
+	Hermetic          bool
+	Manifest          string
+	Archs             []string
+	Vmlinux           string
+	IncludeSource     bool
+	BTFFile           string
+	FixturesDir       string
+	LegacyMediaTypes  bool
+
+	// generated vmlinux.h added to the include path, if any
+	vmlinuxHeader *vmlinux.Header
+
+	// package metadata, set when building from a manifest
+	config      v1.EbpfConfig
+	description string
+	authors     string
 
 	general *options.GeneralOptions
 }
 
 func (opts *buildOptions) validate() error {
+	if opts.Hermetic {
+		if opts.Local {
+			return fmt.Errorf("hermetic builds can't be local, they run in the build image")
+		}
+		if !isPinned(opts.BuildImage) {
+			return fmt.Errorf("hermetic builds require a build image pinned by digest, e.g. ghcr.io/solo-io/bumblebee/builder@sha256:<digest>")
+		}
+	}
 	if !opts.Local {
 		if opts.BuildScript != "" {
 			fmt.Println("ignoring specified build script for docker build")
@@ -57,6 +87,16 @@ func addToFlags(flags *pflag.FlagSet, opts *buildOptions) {
 	flags.BoolVar(&opts.BuildScriptOutput, "build-script-out", false, "Print local script bee will use to build the BPF program")
 	flags.BoolVar(&opts.BinaryOnly, "binary-only", false, "Only create output binary and do not package it into an OCI image")
 	flags.StringArrayVar(&opts.CFlags, "cflags", nil, "cflags to be used when compiling the BPF program, passed as environment variable 'CFLAGS'")
+	flags.StringVar(&opts.CacheDir, "cache-dir", "", "Directory caching compiled programs, skipping the compilation of unchanged programs")
+	flags.BoolVar(&opts.Hermetic, "hermetic", false, "Compile in a build image pinned by digest, without network access, so the toolchain is identical everywhere")
+	flags.StringVar(&opts.CacheRef, "cache-ref", "", "Remote OCI repository caching compiled programs, skipping the compilation of unchanged programs")
+	flags.StringSliceVar(&opts.Archs, "arch", nil, "Compile for each of the architectures (amd64, arm64) in parallel, and save them as a multi-arch OCI image")
+	flags.StringVar(&opts.Vmlinux, "vmlinux", "", fmt.Sprintf("Generate a vmlinux.h from BTF and add it to the include path, either 'host' for the BTF of the running kernel (%s) or the path of a BTF file", vmlinux.HostBTF))
+	flags.BoolVar(&opts.IncludeSource, "include-source", false, "Package the source of the program along with it, in a layer of the OCI image")
+	flags.StringVar(&opts.BTFFile, "btf", "", "Package a BTF file along with the program, for kernels which don't expose their own")
+	flags.StringVar(&opts.FixturesDir, "fixtures", "", "Package the sample events of a directory along with the program, the NAME.records.jsonl files recorded by 'bee run --record' and the NAME.golden.jsonl files they decode to")
+	flags.BoolVar(&opts.LegacyMediaTypes, "legacy-media-types", false, "Save the image with the deprecated v1 media types, for the registries pulled by bee releases only reading them")
+	flags.StringVarP(&opts.Manifest, "manifest", "f", "", fmt.Sprintf("Build all the programs of a project manifest, defaults to ./%s when no INPUT_FILE is given", project.DefaultManifestFile))
 }
 
 func Command(opts *options.GeneralOptions) *cobra.Command {
@@ -88,9 +128,40 @@ the input file will be passed as argument '$1' and the output filename as '$2'.
 Use the '--build-script-out' flag to see the default build script bee uses:
 $ build INPUT_FILE REGISTRY_REF --local --build-script-out
 $ build INPUT_FILE REGISTRY_REF --local --build-script=build.sh
+
+For reproducible builds across machines, use a build image pinned by digest with the '--hermetic' flag.
+The compilation then runs without network access, and the build image is recorded in the OCI image:
+$ build INPUT_FILE REGISTRY_REF --hermetic --build-image=ghcr.io/solo-io/bumblebee/builder@sha256:<digest>
+
+Compiled programs can be cached, locally or in a remote OCI repository, keyed by the sources,
+the compiler and the cflags. Unchanged programs are then only packaged:
+$ build INPUT_FILE REGISTRY_REF --cache-dir=/tmp/bee-cache
+$ build INPUT_FILE REGISTRY_REF --cache-ref=ghcr.io/my-org/bee-build-cache
+
+To publish a program for several architectures, compile them all at once into a multi-arch OCI image.
+The output files are suffixed with the architecture:
+$ build INPUT_FILE REGISTRY_REF --arch=amd64,arm64
+
+Instead of the vmlinux.h of the build image, one can be generated from the BTF of the running kernel,
+or of a BTF file, and added to the include path:
+$ build INPUT_FILE REGISTRY_REF --vmlinux=host
+$ build INPUT_FILE REGISTRY_REF --vmlinux=/path/to/vmlinux.btf
+
+The source of the program, and a BTF file for kernels without BTF, can be packaged along with the program:
+$ build INPUT_FILE REGISTRY_REF --include-source --btf=/path/to/vmlinux.btf
+
+Sample events, with the entries they are expected to decode to, can be packaged as fixtures,
+which 'bee test' runs against the decoder of the program:
+$ build INPUT_FILE REGISTRY_REF --fixtures=fixtures/
+
+All the programs of a project can be declared in a manifest (bee.yaml), and built at once:
+$ build --manifest=bee.yaml
 `,
-		Args: cobra.RangeArgs(1, 2),
+		Args: cobra.RangeArgs(0, 2),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 || buildOpts.Manifest != "" {
+				return buildManifest(cmd.Context(), args, buildOpts)
+			}
 			return build(cmd.Context(), args, buildOpts)
 		},
 		SilenceUsage: true, // Usage on error is bad
@@ -109,6 +180,45 @@ func build(ctx context.Context, args []string, opts *buildOptions) error {
 		return err
 	}
 
+	var buildScript []byte
+	if opts.Local {
+		var err error
+		buildScript, err = getBuildScript(opts.BuildScript)
+		if err != nil {
+			return fmt.Errorf("could not load build script: %v", err)
+		}
+		if opts.BuildScriptOutput {
+			fmt.Printf("%s\n", buildScript)
+			return nil
+		}
+	}
+
+	if opts.Vmlinux != "" {
+		if len(opts.Archs) > 1 {
+			return fmt.Errorf("'--vmlinux' can't be used with several archs, BTF is specific to an architecture")
+		}
+		btfFile := opts.Vmlinux
+		if btfFile == "host" {
+			btfFile = vmlinux.HostBTF
+		}
+		header, err := vmlinux.Generate(ctx, &vmlinux.Opts{
+			BTFFile:  btfFile,
+			CacheDir: filepath.Join(opts.general.ConfigDir, "vmlinux"),
+		})
+		if err != nil {
+			return err
+		}
+		opts.vmlinuxHeader = header
+	}
+
+	if opts.BTFFile != "" && len(opts.Archs) > 1 {
+		return fmt.Errorf("'--btf' can't be used with several archs, BTF is specific to an architecture")
+	}
+
+	if len(opts.Archs) > 0 {
+		return buildMultiArch(ctx, args, opts, buildScript)
+	}
+
 	inputFile := args[0]
 	outputFile := opts.OutputFile
 
@@ -135,27 +245,46 @@ func build(ctx context.Context, args []string, opts *buildOptions) error {
 		outputFile = fn.Name()
 	}
 
-	// Create and start a fork of the default spinner.
-	var buildSpinner *pterm.SpinnerPrinter
-	if opts.Local {
-		buildScript, err := getBuildScript(opts.BuildScript)
+	cache, err := newBuildCache(opts)
+	if err != nil {
+		return err
+	}
+	var cacheKey string
+	if cache != nil {
+		cacheKey, err = buildCacheKey(ctx, opts, buildScript, inputFile, "")
+		if errors.Is(err, errUnresolvedImage) {
+			pterm.Warning.Printfln("Not using the build cache: %v", err)
+			cache = nil
+		} else if err != nil {
+			return err
+		}
+	}
+	if cache != nil {
+		elf, err := cache.get(ctx, cacheKey)
 		if err != nil {
-			return fmt.Errorf("could not load build script: %v", err)
+			pterm.Warning.Printfln("Could not read build cache: %v", err)
 		}
-		if opts.BuildScriptOutput {
-			fmt.Printf("%s\n", buildScript)
-			return nil
+		if elf != nil {
+			if _, err := outputFd.Write(elf); err != nil {
+				return err
+			}
+			pterm.Success.Printfln("Using cached build of \"%s\" and wrote it to \"%s\"", inputFile, outputFile)
+			return packageOutput(ctx, args, opts, outputFd, outputFile)
 		}
+	}
 
+	// Create and start a fork of the default spinner.
+	var buildSpinner *pterm.SpinnerPrinter
+	if opts.Local {
 		buildSpinner, _ = pterm.DefaultSpinner.Start("Compiling BPF program locally")
-		if err := buildLocal(ctx, opts, buildScript, inputFile, outputFile); err != nil {
+		if err := buildLocal(ctx, opts, buildScript, inputFile, outputFile, ""); err != nil {
 			buildSpinner.UpdateText("Failed to compile BPF program locally")
 			buildSpinner.Fail()
 			return err
 		}
 	} else {
 		buildSpinner, _ = pterm.DefaultSpinner.Start("Compiling BPF program")
-		if err := buildDocker(ctx, opts, inputFile, outputFile); err != nil {
+		if err := buildDocker(ctx, opts, inputFile, outputFile, ""); err != nil {
 			buildSpinner.UpdateText("Failed to compile BPF program")
 			buildSpinner.Fail()
 			return err
@@ -164,6 +293,20 @@ func build(ctx context.Context, args []string, opts *buildOptions) error {
 	buildSpinner.UpdateText(fmt.Sprintf("Successfully compiled \"%s\" and wrote it to \"%s\"", inputFile, outputFile))
 	buildSpinner.Success() // Resolve spinner with success message.
 
+	if cache != nil {
+		elf, err := os.ReadFile(outputFile)
+		if err != nil {
+			return err
+		}
+		if err := cache.put(ctx, cacheKey, elf); err != nil {
+			pterm.Warning.Printfln("Could not write build cache: %v", err)
+		}
+	}
+
+	return packageOutput(ctx, args, opts, outputFd, outputFile)
+}
+
+func packageOutput(ctx context.Context, args []string, opts *buildOptions, outputFd *os.File, outputFile string) error {
 	if opts.BinaryOnly {
 		return nil
 	}
@@ -193,11 +336,21 @@ func build(ctx context.Context, args []string, opts *buildOptions) error {
 		return err
 	}
 	registryRef := args[1]
-	ebpfReg := spec.NewEbpfOCICLient()
+	ebpfReg := spec.NewEbpfOCICLientWith(spec.ClientOptions{LegacyMediaTypes: opts.LegacyMediaTypes})
 
-	pkg := &spec.EbpfPackage{
+	pkg := &v1.EbpfPackage{
 		ProgramFileBytes: elfBytes,
 		Platform:         getPlatformInfo(ctx),
+		Description:      opts.description,
+		Authors:          opts.authors,
+		EbpfConfig:       opts.config,
+	}
+	if !opts.Local {
+		pkg.BuilderImage = pinnedBuildImage(ctx, opts)
+	}
+	if err := addLayers(pkg, args[0], opts); err != nil {
+		registrySpinner.Fail()
+		return err
 	}
 
 	if err := ebpfReg.Push(ctx, registryRef, reg, pkg); err != nil {
@@ -212,6 +365,50 @@ func build(ctx context.Context, args []string, opts *buildOptions) error {
 	return nil
 }
 
+func buildManifest(ctx context.Context, args []string, opts *buildOptions) error {
+	if len(args) > 0 {
+		return fmt.Errorf("INPUT_FILE and REGISTRY_REF can't be set when building a manifest")
+	}
+	path := opts.Manifest
+	if path == "" {
+		path = project.DefaultManifestFile
+	}
+	manifest, err := project.Load(path)
+	if err != nil {
+		return err
+	}
+
+	for _, prog := range manifest.Programs {
+		progOpts := *opts
+		progOpts.OutputFile = prog.Output
+		progOpts.CFlags = append(append([]string{}, opts.CFlags...), prog.CFlags...)
+		if len(prog.Archs) > 0 {
+			progOpts.Archs = prog.Archs
+		}
+		progOpts.description = prog.Description
+		progOpts.authors = prog.Authors
+		if prog.Fixtures != "" {
+			progOpts.FixturesDir = prog.Fixtures
+		}
+		progOpts.config, err = prog.LoadConfig()
+		if err != nil {
+			return err
+		}
+
+		progArgs := []string{prog.Source}
+		if prog.Ref != "" {
+			progArgs = append(progArgs, prog.Ref)
+		} else if !opts.BinaryOnly {
+			return fmt.Errorf("program %s has no ref, it can only be built with '--binary-only'", prog.Name)
+		}
+		pterm.Info.Printfln("Building program %s", prog.Name)
+		if err := build(ctx, progArgs, &progOpts); err != nil {
+			return fmt.Errorf("could not build program %s: %w", prog.Name, err)
+		}
+	}
+	return nil
+}
+
 func getPlatformInfo(ctx context.Context) *ocispec.Platform {
 	cmd := exec.CommandContext(ctx, "uname", "-srm")
 	out, err := cmd.CombinedOutput()
@@ -243,10 +440,13 @@ func getBuildScript(path string) ([]byte, error) {
 	return builder.GetBuildScript(), nil
 }
 
+// directory the generated vmlinux.h is mounted in the build image
+const vmlinuxMountDir = "/usr/local/include/bee-vmlinux"
+
 func buildDocker(
 	ctx context.Context,
 	opts *buildOptions,
-	inputFile, outputFile string,
+	inputFile, outputFile, arch string,
 ) error {
 	// TODO: debug log this
 	wd, err := os.Getwd()
@@ -256,36 +456,73 @@ func buildDocker(
 
 	dockerArgs := []string{
 		"run",
+		"--rm",
 		"-v",
 		fmt.Sprintf("%s:/usr/src/bpf", wd),
 	}
+	if opts.Hermetic {
+		dockerArgs = append(dockerArgs, "--network", "none")
+	}
 
-	if len(opts.CFlags) > 0 {
-		dockerArgs = append(dockerArgs, "--env", fmt.Sprintf("CFLAGS=%s", strings.Join(opts.CFlags, " ")))
+	cflags := opts.CFlags
+	if opts.vmlinuxHeader != nil {
+		dockerArgs = append(dockerArgs, "-v", fmt.Sprintf("%s:%s:ro", opts.vmlinuxHeader.Dir(), vmlinuxMountDir))
+		cflags = append([]string{"-I" + vmlinuxMountDir}, cflags...)
+	}
+	if len(cflags) > 0 {
+		dockerArgs = append(dockerArgs, "--env", fmt.Sprintf("CFLAGS=%s", strings.Join(cflags, " ")))
+	}
+	if arch != "" {
+		dockerArgs = append(dockerArgs, "--env", fmt.Sprintf("TARGET_ARCH=%s", clangArchs[arch]))
 	}
 	dockerArgs = append(dockerArgs, opts.BuildImage, inputFile, outputFile)
 
 	dockerCmd := exec.CommandContext(ctx, opts.Builder, dockerArgs...)
 	byt, err := dockerCmd.CombinedOutput()
 	if err != nil {
-		fmt.Printf("%s\n", byt)
+		fmt.Printf("%s\n", prefixLines(arch, byt))
 		return err
 	}
 	return nil
 }
 
+func isPinned(image string) bool {
+	return strings.Contains(image, "@sha256:")
+}
+
+// pinnedBuildImage returns the build image pinned by digest, or an empty string if the
+// digest is unknown, e.g. for images which were built locally.
+func pinnedBuildImage(ctx context.Context, opts *buildOptions) string {
+	if isPinned(opts.BuildImage) {
+		return opts.BuildImage
+	}
+	out, err := exec.CommandContext(ctx, opts.Builder, "image", "inspect", "--format", "{{index .RepoDigests 0}}", opts.BuildImage).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
 func buildLocal(
 	ctx context.Context,
 	opts *buildOptions,
 	buildScript []byte,
 	inputFile,
-	outputFile string,
+	outputFile,
+	arch string,
 ) error {
 	// Pass the script into sh via stdin, then arguments
 	// TODO: need to handle CWD gracefully
 	shCmd := exec.CommandContext(ctx, "sh", "-s", "--", inputFile, outputFile)
+	cflags := opts.CFlags
+	if opts.vmlinuxHeader != nil {
+		cflags = append([]string{"-I" + opts.vmlinuxHeader.Dir()}, cflags...)
+	}
 	shCmd.Env = []string{
-		fmt.Sprintf("CFLAGS=%s", strings.Join(opts.CFlags, " ")),
+		fmt.Sprintf("CFLAGS=%s", strings.Join(cflags, " ")),
+	}
+	if arch != "" {
+		shCmd.Env = append(shCmd.Env, fmt.Sprintf("TARGET_ARCH=%s", clangArchs[arch]))
 	}
 	stdin, err := shCmd.StdinPipe()
 	if err != nil {
@@ -298,7 +535,7 @@ func buildLocal(
 	}()
 
 	out, err := shCmd.CombinedOutput()
-	pterm.Info.Printf("%s\n", out)
+	pterm.Info.Printf("%s\n", prefixLines(arch, out))
 	if err != nil {
 		return err
 	}
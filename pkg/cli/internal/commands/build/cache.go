@@ -0,0 +1,240 @@
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"oras.land/oras-go/pkg/content"
+)
+
+// buildCache stores compiled programs keyed by everything which affects the output of the compiler.
+type buildCache interface {
+	// get returns the cached program, or nil if there is none
+	get(ctx context.Context, key string) ([]byte, error)
+	put(ctx context.Context, key string, elf []byte) error
+}
+
+func newBuildCache(opts *buildOptions) (buildCache, error) {
+	switch {
+	case opts.CacheDir != "" && opts.CacheRef != "":
+		return nil, fmt.Errorf("only one of --cache-dir and --cache-ref can be set")
+	case opts.CacheDir != "":
+		if err := os.MkdirAll(opts.CacheDir, 0755); err != nil {
+			return nil, fmt.Errorf("could not create build cache dir: %w", err)
+		}
+		return &dirCache{dir: opts.CacheDir}, nil
+	case opts.CacheRef != "":
+		registry, err := content.NewRegistry(opts.general.AuthOptions.ToRegistryOptions())
+		if err != nil {
+			return nil, err
+		}
+		return &ociCache{repo: opts.CacheRef, registry: registry, client: spec.NewEbpfOCICLient()}, nil
+	}
+	return nil, nil
+}
+
+type dirCache struct {
+	dir string
+}
+
+func (c *dirCache) get(ctx context.Context, key string) ([]byte, error) {
+	elf, err := os.ReadFile(filepath.Join(c.dir, key+".o"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return elf, err
+}
+
+func (c *dirCache) put(ctx context.Context, key string, elf []byte) error {
+	// write then rename, so concurrent builds never read a partial file
+	tmp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(elf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(c.dir, key+".o"))
+}
+
+// ociCache stores programs as packages of a remote repository, tagged with their key.
+type ociCache struct {
+	repo     string
+	registry *content.Registry
+	client   spec.EbpfOCICLient
+}
+
+func (c *ociCache) ref(key string) string {
+	return fmt.Sprintf("%s:%s", c.repo, key)
+}
+
+func (c *ociCache) get(ctx context.Context, key string) ([]byte, error) {
+	if _, _, err := c.registry.Resolve(ctx, c.ref(key)); err != nil {
+		// the registry doesn't tell missing tags apart from other failures, treat both as a miss
+		return nil, nil
+	}
+	pkg, err := c.client.Pull(ctx, c.ref(key), c.registry)
+	if err != nil {
+		return nil, err
+	}
+	return pkg.ProgramFileBytes, nil
+}
+
+func (c *ociCache) put(ctx context.Context, key string, elf []byte) error {
+	return c.client.Push(ctx, c.ref(key), c.registry, &v1.EbpfPackage{ProgramFileBytes: elf})
+}
+
+// errUnresolvedImage is returned by buildCacheKey when the build image isn't pinned nor pulled,
+// as its tag could be pushed again with another compiler.
+var errUnresolvedImage = errors.New("the build image can't be resolved")
+
+// buildCacheKey hashes the sources (the input file, all the C files and headers next to it and
+// the headers of the include directories of the cflags), the compiler and its flags.
+func buildCacheKey(ctx context.Context, opts *buildOptions, buildScript []byte, inputFile, arch string) (string, error) {
+	h := sha256.New()
+	writeField := func(name string, value []byte) {
+		fmt.Fprintf(h, "%s %d\n", name, len(value))
+		h.Write(value)
+	}
+
+	if err := hashSourceTree(h, inputFile, includeDirs(opts.CFlags, opts.Local)); err != nil {
+		return "", err
+	}
+	writeField("input", []byte(filepath.ToSlash(inputFile)))
+	writeField("cflags", []byte(strings.Join(opts.CFlags, " ")))
+	if arch != "" {
+		writeField("arch", []byte(arch))
+	}
+	if opts.vmlinuxHeader != nil {
+		writeField("vmlinux", []byte(opts.vmlinuxHeader.Digest))
+	}
+	if opts.Local {
+		writeField("script", buildScript)
+		writeField("compiler", localCompilerVersion(ctx))
+	} else {
+		image, err := imageID(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		writeField("image", []byte(image))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashSourceTree(w io.Writer, inputFile string, includeDirs []string) error {
+	if err := hashFiles(w, "file ", filepath.Dir(inputFile), ".c", ".h"); err != nil {
+		return err
+	}
+	for _, dir := range includeDirs {
+		if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+			// the compiler ignores them too
+			continue
+		}
+		if err := hashFiles(w, "include "+filepath.ToSlash(dir)+" ", dir, ".h"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashFiles hashes the files of the tree with one of the extensions, named by the prefix and
+// their path in the tree.
+func hashFiles(w io.Writer, prefix, root string, exts ...string) error {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		for _, ext := range exts {
+			if filepath.Ext(path) == ext {
+				files = append(files, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not hash sources: %w", err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		byt, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("could not hash sources: %w", err)
+		}
+		rel, _ := filepath.Rel(root, file)
+		fmt.Fprintf(w, "%s%s %d\n", prefix, filepath.ToSlash(rel), len(byt))
+		w.Write(byt)
+	}
+	return nil
+}
+
+// includeDirs returns the directories headers are included from with the -I, -iquote and
+// -isystem cflags. Docker builds only mount the working directory, so only the directories
+// relative to it are returned for them, the others being the ones of the build image.
+func includeDirs(cflags []string, local bool) []string {
+	var dirs []string
+	var flags []string
+	for _, cflag := range cflags {
+		// a single cflag can hold several flags, as they are split by the shell of the build script
+		flags = append(flags, strings.Fields(cflag)...)
+	}
+	for i := 0; i < len(flags); i++ {
+		for _, prefix := range []string{"-I", "-iquote", "-isystem"} {
+			if !strings.HasPrefix(flags[i], prefix) {
+				continue
+			}
+			dir := strings.TrimPrefix(flags[i], prefix)
+			if dir == "" && i+1 < len(flags) {
+				i++
+				dir = flags[i]
+			}
+			if dir != "" && (local || !filepath.IsAbs(dir)) {
+				dirs = append(dirs, dir)
+			}
+			break
+		}
+	}
+	return dirs
+}
+
+func localCompilerVersion(ctx context.Context) []byte {
+	for _, clang := range []string{"clang-13", "clang"} {
+		if out, err := exec.CommandContext(ctx, clang, "--version").Output(); err == nil {
+			return out
+		}
+	}
+	return nil
+}
+
+// imageID returns the ID of the build image, so rebuilt images with the same tag are told apart,
+// or its ref if it is pinned by digest.
+func imageID(ctx context.Context, opts *buildOptions) (string, error) {
+	if isPinned(opts.BuildImage) {
+		return opts.BuildImage, nil
+	}
+	out, err := exec.CommandContext(ctx, opts.Builder, "image", "inspect", "--format", "{{.Id}}", opts.BuildImage).Output()
+	if err != nil {
+		return "", fmt.Errorf("%w, as %s is not pulled", errUnresolvedImage, opts.BuildImage)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
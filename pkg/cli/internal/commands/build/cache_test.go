@@ -0,0 +1,132 @@
+package build
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("buildCacheKey", func() {
+	var (
+		ctx     = context.Background()
+		dir, wd string
+		opts    *buildOptions
+	)
+
+	write := func(path, content string) {
+		path = filepath.Join(dir, path)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(path, []byte(content), 0644)).To(Succeed())
+	}
+	key := func() string {
+		key, err := buildCacheKey(ctx, opts, nil, "prog/prog.c", "")
+		Expect(err).NotTo(HaveOccurred())
+		return key
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "build-cache")
+		Expect(err).NotTo(HaveOccurred())
+		// the include directories of Docker builds are relative to the working directory
+		wd, err = os.Getwd()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chdir(dir)).To(Succeed())
+		write("prog/prog.c", `#include "prog.h"`)
+		write("prog/prog.h", "")
+		write("common/common.h", "")
+		write("system/system.h", "")
+		// a builder which only knows of the image whose ID is written in image-id
+		write("builder", "#!/bin/sh\ncat "+filepath.Join(dir, "image-id")+"\n")
+		Expect(os.Chmod(filepath.Join(dir, "builder"), 0755)).To(Succeed())
+		write("image-id", "sha256:1")
+		opts = &buildOptions{
+			BuildImage: "ghcr.io/solo-io/bumblebee/bee:0.0.1",
+			Builder:    filepath.Join(dir, "builder"),
+			CFlags:     []string{"-DDEBUG -I common", "-isystem" + filepath.Join(dir, "system")},
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.Chdir(wd)).To(Succeed())
+		os.RemoveAll(dir)
+	})
+
+	It("is stable while nothing changes", func() {
+		first := key()
+		Expect(key()).To(Equal(first))
+		// nor when files which are not sources change
+		write("prog/README.md", "prog")
+		write("common/common.c", "")
+		write(filepath.Join("system", "system.txt"), "")
+		Expect(key()).To(Equal(first))
+	})
+
+	It("changes with the sources, compiler and flags", func() {
+		for _, change := range []struct {
+			desc   string
+			change func()
+		}{
+			{"the input file", func() { write("prog/prog.c", `#include "common.h"`) }},
+			{"a header next to it", func() { write("prog/prog.h", "#define DEBUG") }},
+			{"a header added next to it", func() { write("prog/maps.h", "") }},
+			{"a header of an include directory", func() { write("common/common.h", "#define DEBUG") }},
+			{"a header nested in an include directory", func() { write("common/bpf/helpers.h", "") }},
+			{"the cflags", func() { opts.CFlags = append(opts.CFlags, "-O3") }},
+			{"the build image", func() { write("image-id", "sha256:2") }},
+		} {
+			before := key()
+			change.change()
+			Expect(key()).NotTo(Equal(before), change.desc)
+		}
+	})
+
+	It("only hashes the include directories of the builds which can read them", func() {
+		docker := key()
+		// not mounted in the build container
+		write("system/system.h", "#define DEBUG")
+		Expect(key()).To(Equal(docker))
+		opts.Local = true
+		local := key()
+		write("system/system.h", "")
+		Expect(key()).NotTo(Equal(local))
+	})
+
+	It("ignores the include directories which don't exist", func() {
+		before := key()
+		opts.CFlags = []string{"-DDEBUG -I common", "-isystem" + filepath.Join(dir, "system"), "-Imissing"}
+		Expect(key()).NotTo(Equal(before))
+		Expect(key()).To(Equal(key()))
+	})
+
+	It("refuses to key builds with images it can't resolve", func() {
+		opts.Builder = "false"
+		_, err := buildCacheKey(ctx, opts, nil, "prog/prog.c", "")
+		Expect(err).To(MatchError(errUnresolvedImage))
+
+		// but pinned images are their own ID
+		opts.BuildImage = "ghcr.io/solo-io/bumblebee/bee@sha256:0a5ee8f7dbcb0e1e4c0bd8a6f2ddb5c78d1b72a340a7fbb3958e4bd8757e8510"
+		Expect(key()).NotTo(BeEmpty())
+	})
+})
+
+var _ = Describe("includeDirs", func() {
+	It("returns the include directories of the cflags", func() {
+		for _, c := range []struct {
+			cflags []string
+			local  bool
+			dirs   []string
+		}{
+			{[]string{"-Icommon", "-I", "vendor/libbpf", "-DDEBUG"}, false, []string{"common", "vendor/libbpf"}},
+			{[]string{"-iquote ../shared -isystem/usr/include/bpf"}, false, []string{"../shared"}},
+			{[]string{"-iquote ../shared -isystem/usr/include/bpf"}, true, []string{"../shared", "/usr/include/bpf"}},
+			{[]string{"-Wall", "-I"}, true, nil},
+		} {
+			Expect(includeDirs(c.cflags, c.local)).To(Equal(c.dirs), "%v", c.cflags)
+		}
+	})
+})
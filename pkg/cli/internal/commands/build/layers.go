@@ -0,0 +1,105 @@
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/solo-io/bumblebee/pkg/spec"
+)
+
+// addLayers adds the source, BTF and fixtures layers requested by the flags to the package, and
+// documents the key and value types of its maps in its config.
+func addLayers(pkg *v1.EbpfPackage, inputFile string, opts *buildOptions) error {
+	if opts.BTFFile != "" {
+		btf, err := os.ReadFile(opts.BTFFile)
+		if err != nil {
+			return fmt.Errorf("could not read BTF file: %w", err)
+		}
+		pkg.BTF = btf
+	}
+	if opts.IncludeSource {
+		source, err := tarSource(inputFile)
+		if err != nil {
+			return fmt.Errorf("could not package source: %w", err)
+		}
+		pkg.Source = source
+	}
+	if opts.FixturesDir != "" {
+		fixtures, err := readFixtures(opts.FixturesDir)
+		if err != nil {
+			return fmt.Errorf("could not package fixtures: %w", err)
+		}
+		pkg.Fixtures = fixtures
+	}
+	collSpec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(pkg.ProgramFileBytes))
+	if err != nil {
+		return fmt.Errorf("could not parse the program: %w", err)
+	}
+	return loader.DescribeMapTypes(&pkg.EbpfConfig, collSpec, pkg.Source)
+}
+
+// readFixtures reads the fixtures of a directory, each NAME.records.jsonl file with the
+// NAME.golden.jsonl file of the entries it decodes to.
+func readFixtures(dir string) ([]v1.Fixture, error) {
+	records, err := filepath.Glob(filepath.Join(dir, "*"+spec.FixtureRecordsSuffix))
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no %s files in %s", spec.FixtureRecordsSuffix, dir)
+	}
+	var fixtures []v1.Fixture
+	for _, path := range records {
+		name := strings.TrimSuffix(filepath.Base(path), spec.FixtureRecordsSuffix)
+		fixture := v1.Fixture{Name: name}
+		if fixture.Records, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+		golden := filepath.Join(dir, name+spec.FixtureGoldenSuffix)
+		if fixture.Golden, err = os.ReadFile(golden); err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("fixture %s has no %s, generate it with 'bee replay --update'", name, filepath.Base(golden))
+			}
+			return nil, err
+		}
+		fixtures = append(fixtures, fixture)
+	}
+	return fixtures, nil
+}
+
+// tarSource returns a gzipped tarball of the source file. The modification time is left
+// unset so the layer only changes with the source.
+func tarSource(path string) ([]byte, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{
+		Name: filepath.Base(path),
+		Mode: 0644,
+		Size: int64(len(src)),
+	}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(src); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,207 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pterm/pterm"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"oras.land/oras-go/pkg/content"
+
+	"github.com/solo-io/bumblebee/pkg/spec"
+)
+
+// clangArchs maps the supported architectures to their libbpf `__TARGET_ARCH_` name,
+// passed to the build script as TARGET_ARCH.
+var clangArchs = map[string]string{
+	"amd64": "x86",
+	"arm64": "arm64",
+}
+
+func validateArchs(archs []string) error {
+	seen := map[string]bool{}
+	for _, arch := range archs {
+		if _, ok := clangArchs[arch]; !ok {
+			supported := make([]string, 0, len(clangArchs))
+			for a := range clangArchs {
+				supported = append(supported, a)
+			}
+			sort.Strings(supported)
+			return fmt.Errorf("unsupported arch %s, must be one of %s", arch, strings.Join(supported, ", "))
+		}
+		if seen[arch] {
+			return fmt.Errorf("arch %s is set more than once", arch)
+		}
+		seen[arch] = true
+	}
+	return nil
+}
+
+// archOutputFile suffixes the output file with the arch, e.g. `probe_arm64.o`
+func archOutputFile(inputFile, outputFile, arch string) string {
+	if outputFile == "" {
+		outputFile = strings.TrimSuffix(inputFile, filepath.Ext(inputFile)) + ".o"
+	}
+	ext := filepath.Ext(outputFile)
+	return fmt.Sprintf("%s_%s%s", strings.TrimSuffix(outputFile, ext), arch, ext)
+}
+
+// buildMultiArch compiles the program for all the archs in parallel, and saves them as a multi-arch image.
+func buildMultiArch(ctx context.Context, args []string, opts *buildOptions, buildScript []byte) error {
+	if err := validateArchs(opts.Archs); err != nil {
+		return err
+	}
+	if !opts.BinaryOnly && len(args) == 1 {
+		return fmt.Errorf("must specify a registry to package the output or run with '--binary-only'")
+	}
+	cache, err := newBuildCache(opts)
+	if err != nil {
+		return err
+	}
+
+	inputFile := args[0]
+	outputFiles := make([]string, len(opts.Archs))
+	errs := make([]error, len(opts.Archs))
+	buildSpinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Compiling BPF program for %s", strings.Join(opts.Archs, ", ")))
+	var wg sync.WaitGroup
+	for i, arch := range opts.Archs {
+		outputFiles[i] = archOutputFile(inputFile, opts.OutputFile, arch)
+		wg.Add(1)
+		go func(i int, arch string) {
+			defer wg.Done()
+			errs[i] = compileArch(ctx, opts, cache, buildScript, inputFile, outputFiles[i], arch)
+		}(i, arch)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", opts.Archs[i], err))
+		}
+	}
+	if len(failed) > 0 {
+		buildSpinner.UpdateText(fmt.Sprintf("Failed to compile BPF program for %d of %d architectures", len(failed), len(opts.Archs)))
+		buildSpinner.Fail()
+		return fmt.Errorf("could not compile %s:\n  %s", inputFile, strings.Join(failed, "\n  "))
+	}
+	buildSpinner.UpdateText(fmt.Sprintf("Successfully compiled \"%s\" and wrote it to %s", inputFile, strings.Join(outputFiles, ", ")))
+	buildSpinner.Success()
+
+	if opts.BinaryOnly {
+		return nil
+	}
+
+	registrySpinner, _ := pterm.DefaultSpinner.Start("Packaging BPF program")
+	var pkgs []*v1.EbpfPackage
+	for i, arch := range opts.Archs {
+		elfBytes, err := os.ReadFile(outputFiles[i])
+		if err != nil {
+			registrySpinner.Fail()
+			return err
+		}
+		pkg := &v1.EbpfPackage{
+			ProgramFileBytes: elfBytes,
+			Platform:         &ocispec.Platform{OS: "linux", Architecture: arch},
+			Description:      opts.description,
+			Authors:          opts.authors,
+			EbpfConfig:       opts.config,
+		}
+		if !opts.Local {
+			pkg.BuilderImage = pinnedBuildImage(ctx, opts)
+		}
+		if err := addLayers(pkg, inputFile, opts); err != nil {
+			registrySpinner.Fail()
+			return err
+		}
+		pkgs = append(pkgs, pkg)
+	}
+
+	reg, err := content.NewOCI(opts.general.OCIStorageDir)
+	if err != nil {
+		registrySpinner.UpdateText("Failed to initialize registry")
+		registrySpinner.Fail()
+		return err
+	}
+	registryRef := args[1]
+	if err := spec.NewEbpfOCICLientWith(spec.ClientOptions{LegacyMediaTypes: opts.LegacyMediaTypes}).PushMultiArch(ctx, registryRef, reg, pkgs); err != nil {
+		registrySpinner.UpdateText(fmt.Sprintf("Failed to save BPF OCI image: %s", registryRef))
+		registrySpinner.Fail()
+		return err
+	}
+	registrySpinner.UpdateText(fmt.Sprintf("Saved multi-arch BPF OCI image to %s", registryRef))
+	registrySpinner.Success()
+	return nil
+}
+
+// compileArch compiles the program for a single arch, using the cache if enabled.
+func compileArch(
+	ctx context.Context,
+	opts *buildOptions,
+	cache buildCache,
+	buildScript []byte,
+	inputFile, outputFile, arch string,
+) error {
+	var cacheKey string
+	if cache != nil {
+		var err error
+		cacheKey, err = buildCacheKey(ctx, opts, buildScript, inputFile, arch)
+		if errors.Is(err, errUnresolvedImage) {
+			pterm.Warning.Printfln("Not using the build cache for %s: %v", arch, err)
+			cache = nil
+		} else if err != nil {
+			return err
+		}
+	}
+	if cache != nil {
+		elf, err := cache.get(ctx, cacheKey)
+		if err != nil {
+			pterm.Warning.Printfln("Could not read build cache: %v", err)
+		}
+		if elf != nil {
+			return os.WriteFile(outputFile, elf, 0644)
+		}
+	}
+
+	if opts.Local {
+		if err := buildLocal(ctx, opts, buildScript, inputFile, outputFile, arch); err != nil {
+			return err
+		}
+	} else {
+		if err := buildDocker(ctx, opts, inputFile, outputFile, arch); err != nil {
+			return err
+		}
+	}
+
+	if cache != nil {
+		elf, err := os.ReadFile(outputFile)
+		if err != nil {
+			return err
+		}
+		if err := cache.put(ctx, cacheKey, elf); err != nil {
+			pterm.Warning.Printfln("Could not write build cache: %v", err)
+		}
+	}
+	return nil
+}
+
+// prefixLines prefixes the compiler output with the arch, so the output of parallel builds can be told apart.
+func prefixLines(arch string, out []byte) []byte {
+	if arch == "" || len(out) == 0 {
+		return out
+	}
+	prefix := []byte(fmt.Sprintf("[%s] ", arch))
+	lines := bytes.Split(bytes.TrimRight(out, "\n"), []byte("\n"))
+	for i, line := range lines {
+		lines[i] = append(append([]byte{}, prefix...), line...)
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
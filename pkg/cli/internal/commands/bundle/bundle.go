@@ -0,0 +1,136 @@
+package bundle
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pterm/pterm"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+type verifyOptions struct {
+	general *options.GeneralOptions
+
+	digest string
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *verifyOptions) {
+	flags.StringVar(&opts.digest, "digest", "", "Require the bundled package to have this digest, e.g. the digest which was deployed")
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "Export and verify packages as self-contained bundle files.",
+		Long: `
+A bundle is a tar archive of the package and every blob it references, as an OCI image layout.
+It can be archived as deployment evidence, and verified later without access to the registry.
+`,
+	}
+	cmd.AddCommand(
+		exportCommand(opts),
+		verifyCommand(opts),
+	)
+	return cmd
+}
+
+func exportCommand(opts *options.GeneralOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "export REF FILE",
+		Short: "Export a package of the local store as a bundle file.",
+		Long: `
+The package must be in the local store, e.g. after bee pull.
+$ bee bundle export ghcr.io/solo-io/bumblebee/tcpconnect:v0.0.1 tcpconnect.bundle.tar
+`,
+		Args:         cobra.ExactArgs(2), // ref, file
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return export(cmd.Context(), opts, args[0], args[1])
+		},
+	}
+}
+
+func verifyCommand(opts *options.GeneralOptions) *cobra.Command {
+	verifyOpts := &verifyOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "verify FILE",
+		Short: "Verify a bundle file offline.",
+		Long: `
+Checks that every blob of the bundled package is present and matches its digest.
+$ bee bundle verify tcpconnect.bundle.tar --digest sha256:4f9a...
+`,
+		Args:         cobra.ExactArgs(1), // file
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return verify(verifyOpts, args[0])
+		},
+	}
+	addToFlags(cmd.Flags(), verifyOpts)
+	return cmd
+}
+
+func export(ctx context.Context, opts *options.GeneralOptions, ref, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create bundle file: %w", err)
+	}
+	if err := spec.ExportBundle(ctx, opts.OCIStorageDir, ref, f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	pterm.Success.Printfln("Exported %s to %s", ref, path)
+	return nil
+}
+
+func verify(opts *verifyOptions, path string) error {
+	var expected digest.Digest
+	if opts.digest != "" {
+		parsed, err := digest.Parse(opts.digest)
+		if err != nil {
+			return fmt.Errorf("invalid digest: %w", err)
+		}
+		expected = parsed
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open bundle file: %w", err)
+	}
+	defer f.Close()
+	info, err := spec.VerifyBundle(f)
+	if err != nil {
+		return fmt.Errorf("bundle is invalid: %w", err)
+	}
+	if expected != "" && info.Digest != expected {
+		return fmt.Errorf("bundled package has digest %s, expected %s", info.Digest, expected)
+	}
+
+	digests := make([]digest.Digest, 0, len(info.Blobs))
+	for dgst := range info.Blobs {
+		digests = append(digests, dgst)
+	}
+	sort.Slice(digests, func(i, j int) bool {
+		return digests[i] < digests[j]
+	})
+	tableData := pterm.TableData{
+		[]string{"Digest", "Media Type"},
+	}
+	for _, dgst := range digests {
+		tableData = append(tableData, []string{dgst.String(), info.Blobs[dgst]})
+	}
+	pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	pterm.Success.Printfln("Verified %s (%s)", info.Ref, info.Digest)
+	return nil
+}
@@ -0,0 +1,177 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/pterm/pterm"
+	"github.com/solo-io/bumblebee/pkg/capture"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/solo-io/bumblebee/pkg/stats"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+type captureOptions struct {
+	general *options.GeneralOptions
+
+	duration    time.Duration
+	output      string
+	iface       string
+	maxEvents   int
+	description string
+	metricsPort uint32
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *captureOptions) {
+	flags.DurationVar(&opts.duration, "duration", time.Minute, "How long the programs run")
+	flags.StringVarP(&opts.output, "output", "o", "bee-capture.tar.gz", "File the session bundle is written to")
+	flags.StringVar(&opts.iface, "interface", "", "Network interface XDP and TC programs are attached to")
+	flags.IntVar(&opts.maxEvents, "max-events", capture.DefaultSessionMaxEvents, "Most events of the ring buffers recorded per program, the later ones being counted but not recorded")
+	flags.StringVar(&opts.description, "description", "", "Why the session is recorded, e.g. the ticket the bundle is attached to")
+	flags.Uint32Var(&opts.metricsPort, "metrics-port", 9091, "Port the metrics of the programs are exported on during the session")
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	captureOpts := &captureOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "capture BPF_PROGRAM...",
+		Short: "Run programs for a bounded duration, and bundle what they recorded.",
+		Long: `
+The programs run together for the duration of the session, then their events, the last entries
+of their hash maps, the host metadata and the logs of the session are written to a single
+gzipped tar, whose index.json describes its files, e.g. to attach to a support ticket.
+
+$ bee capture --duration 30s --output incident-4711.tar.gz ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7 ghcr.io/solo-io/bumblebee/exitsnoop:0.0.7
+`,
+		Args:         cobra.MinimumNArgs(1), // programs
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(cmd.Context(), captureOpts, args)
+		},
+	}
+	addToFlags(cmd.Flags(), captureOpts)
+	return cmd
+}
+
+func run(ctx context.Context, opts *captureOptions, progLocations []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	provider, err := stats.NewPrometheusMetricsProvider(ctx, &stats.PrometheusOpts{Port: opts.metricsPort, Registry: prometheus.NewRegistry()})
+	if err != nil {
+		return err
+	}
+
+	var programs []capture.SessionProgram
+	names := map[string]int{}
+	for _, progLocation := range progLocations {
+		name := programName(progLocation)
+		if names[name]++; names[name] > 1 {
+			name = fmt.Sprintf("%s-%d", name, names[name])
+		}
+		progLoader := loader.NewLoader(decoder.NewDecoderFactory(), &prefixedProvider{MetricsProvider: provider, prefix: name})
+		progBytes, err := getProgram(ctx, opts.general, progLocation)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %w", progLocation, err)
+		}
+		parsedELF, err := progLoader.Parse(ctx, bytes.NewReader(progBytes))
+		if err != nil {
+			return fmt.Errorf("could not parse %s: %w", progLocation, err)
+		}
+		programs = append(programs, capture.SessionProgram{
+			Name:   name,
+			Loader: progLoader,
+			Opts: &loader.LoadOptions{
+				ParsedELF:  parsedELF,
+				ProgramRef: progLocation,
+				Interface:  opts.iface,
+			},
+		})
+	}
+
+	f, err := os.Create(opts.output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	pterm.Info.Printfln("Capturing %d programs for %s", len(programs), opts.duration)
+	index, err := capture.RunSession(ctx, capture.SessionOptions{
+		Programs:    programs,
+		Duration:    opts.duration,
+		MaxEvents:   opts.maxEvents,
+		Description: opts.description,
+	}, f)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	for _, p := range index.Programs {
+		if p.Error != "" {
+			pterm.Warning.Printfln("%s failed: %s", p.Name, p.Error)
+			continue
+		}
+		pterm.Info.Printfln("%s recorded %d events, %d dropped, of %d maps", p.Name, p.Events, p.Dropped, len(p.Maps))
+	}
+	pterm.Success.Printfln("Wrote the capture session to %s", opts.output)
+	return nil
+}
+
+// programName names the files of a program in the bundle after the repository of its ref, or
+// its file name.
+func programName(progLocation string) string {
+	name := path.Base(progLocation)
+	if i := strings.IndexAny(name, ":@"); i > 0 {
+		name = name[:i]
+	}
+	return strings.TrimSuffix(name, ".o")
+}
+
+// getProgram reads the program from a file, or the local store or registry if there is no such file.
+func getProgram(ctx context.Context, opts *options.GeneralOptions, progLocation string) ([]byte, error) {
+	if _, err := os.Stat(progLocation); err == nil {
+		return ioutil.ReadFile(progLocation)
+	}
+	pkg, err := opts.LocalRegistry().Pull(ctx, progLocation, nil)
+	if err != nil {
+		return nil, err
+	}
+	return pkg.ProgramFileBytes, nil
+}
+
+// prefixedProvider names the metrics of a program after the program, as the programs share
+// the metrics server.
+type prefixedProvider struct {
+	stats.MetricsProvider
+	prefix string
+}
+
+func (p *prefixedProvider) prefixed(opts *stats.MetricOpts) *stats.MetricOpts {
+	prefixed := *opts
+	prefixed.Name = p.prefix + "_" + opts.Name
+	return &prefixed
+}
+
+func (p *prefixedProvider) NewSetCounter(opts *stats.MetricOpts) stats.SetInstrument {
+	return p.MetricsProvider.NewSetCounter(p.prefixed(opts))
+}
+
+func (p *prefixedProvider) NewIncrementCounter(opts *stats.MetricOpts) stats.IncrementInstrument {
+	return p.MetricsProvider.NewIncrementCounter(p.prefixed(opts))
+}
+
+func (p *prefixedProvider) NewGauge(opts *stats.MetricOpts) stats.SetInstrument {
+	return p.MetricsProvider.NewGauge(p.prefixed(opts))
+}
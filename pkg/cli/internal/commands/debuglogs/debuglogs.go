@@ -0,0 +1,106 @@
+package debuglogs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pterm/pterm"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/agent"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+type debugLogsOptions struct {
+	general *options.GeneralOptions
+
+	ssh   agent.SSHOpts
+	token string
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *debugLogsOptions) {
+	flags.StringVar(&opts.ssh.Destination, "ssh", "", "Connect to the agent through an SSH tunnel to the given [user@]host[:port], the agent address is then resolved from the SSH server, e.g. localhost:9092")
+	flags.StringVarP(&opts.ssh.IdentityFile, "ssh-identity", "i", "", "Private key used to authenticate the SSH connection, in addition to the keys of the running ssh-agent. Defaults to the keys in ~/.ssh")
+	flags.StringVar(&opts.ssh.KnownHostsFile, "ssh-known-hosts", "", "Known hosts file used to verify the SSH server. Defaults to ~/.ssh/known_hosts")
+	flags.BoolVar(&opts.ssh.InsecureIgnoreHostKey, "ssh-insecure-ignore-host-key", false, "Do not verify the host key of the SSH server")
+	flags.StringVar(&opts.token, "token", os.Getenv("BEE_API_TOKEN"), "API key or JWT sent to agents run with --api-keys, defaults to $BEE_API_TOKEN")
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	debugLogsOpts := &debugLogsOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "debug-logs AGENT_ADDRESS",
+		Short: "Tail the logs of the load of the program run by a bee agent, e.g. the verifier logs.",
+		Long: `
+The program must be run with the agent API enabled, and the level of the debug logs:
+$ bee run --no-tty --api-port=9092 --debug-log-level=verifier ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7
+
+The recent entries are printed first, followed by the new ones until the program is over:
+$ bee debug-logs 10.0.0.1:9092
+`,
+		Args: cobra.ExactArgs(1), // agent address
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return debugLogs(cmd.Context(), args[0], debugLogsOpts)
+		},
+		SilenceUsage: true,
+	}
+	addToFlags(cmd.PersistentFlags(), debugLogsOpts)
+	return cmd
+}
+
+func debugLogs(ctx context.Context, addr string, opts *debugLogsOptions) error {
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	clientOpts := &client.Options{Token: opts.token}
+	if opts.ssh.Destination != "" {
+		tunnel, err := agent.NewSSHTunnel(ctx, &opts.ssh)
+		if err != nil {
+			return fmt.Errorf("could not open ssh tunnel: %w", err)
+		}
+		defer tunnel.Close()
+		clientOpts.DialContext = tunnel.DialContext
+	}
+	stream, err := client.New(addr, clientOpts).DebugLogs(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	for {
+		entry, err := stream.Recv()
+		if errors.Is(err, client.ErrStreamEnded) {
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		printEntry(entry)
+	}
+}
+
+func printEntry(entry v1.DebugLogEntry) {
+	line := entry.Time.Local().Format("15:04:05.000") + " "
+	if entry.Program != "" {
+		line += entry.Program + ": "
+	}
+	line += entry.Message
+	switch {
+	case entry.Error:
+		pterm.Error.Println(line)
+	case entry.Level == v1.DebugLogInfo:
+		pterm.Info.Println(line)
+	default:
+		fmt.Println(line)
+	}
+}
@@ -1,9 +1,16 @@
 package describe
 
 import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/docker/go-units"
 	"github.com/pterm/pterm"
+	v1 "github.com/solo-io/bumblebee/api/v1"
 	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
-	"github.com/solo-io/bumblebee/pkg/spec"
+	"github.com/solo-io/bumblebee/pkg/loader"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 )
@@ -19,9 +26,10 @@ func Command(opts *options.GeneralOptions) *cobra.Command {
 		general: opts,
 	}
 	cmd := &cobra.Command{
-		Use:   "describe BPF_OCI_IMAGE",
-		Short: "Describe a BPF program via it's OCI ref",
-		Args:  cobra.ExactArgs(1), // image
+		Use:               "describe BPF_OCI_IMAGE",
+		Short:             "Describe a BPF program via it's OCI ref",
+		ValidArgsFunction: opts.CompleteRef,
+		Args:              cobra.ExactArgs(1), // image
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return describe(cmd, args, describeOptions)
 		},
@@ -34,19 +42,12 @@ func Command(opts *options.GeneralOptions) *cobra.Command {
 func describe(cmd *cobra.Command, args []string, opts *describeOptions) error {
 	// guaranteed to be length 1
 	ref := args[0]
-	client := spec.NewEbpfOCICLient()
-	prog, err := spec.TryFromLocal(
-		cmd.Context(),
-		ref,
-		opts.general.OCIStorageDir,
-		client,
-		opts.general.AuthOptions.ToRegistryOptions(),
-	)
+	prog, err := opts.general.LocalRegistry().Pull(cmd.Context(), ref, nil)
 	if err != nil {
 		return err
 	}
 	var (
-		platformPanel, authorsPanel, descriptionPanel string
+		platformPanel, authorsPanel, descriptionPanel, builderPanel, memoryPanel, compatibilityPanel, contentsPanel string
 	)
 
 	if prog.Description != "" {
@@ -69,13 +70,117 @@ func describe(cmd *cobra.Command, args []string, opts *describeOptions) error {
 		platformPanel = pterm.DefaultBox.WithTitle("Platform").Sprint("unknown")
 	}
 
+	if prog.BuilderImage != "" {
+		builderPanel = pterm.DefaultBox.WithTitle("Builder").Sprint(prog.BuilderImage)
+	} else {
+		builderPanel = pterm.DefaultBox.WithTitle("Builder").Sprint("unknown")
+	}
+
+	collSpec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(prog.ProgramFileBytes))
+	if err != nil {
+		return fmt.Errorf("could not parse BPF program: %w", err)
+	}
+	cpus := loader.PossibleCPUs()
+	memoryPanel = pterm.DefaultBox.
+		WithTitle(fmt.Sprintf("Estimated kernel memory (%d CPUs)", cpus)).
+		Sprint(renderUsage(loader.EstimateUsage(collSpec, cpus)))
+
+	if len(prog.Compatibility) > 0 {
+		compatibilityPanel = pterm.DefaultBox.WithTitle("Kernel compatibility").Sprint(renderCompatibility(prog.Compatibility))
+	} else {
+		compatibilityPanel = pterm.DefaultBox.WithTitle("Kernel compatibility").Sprint("not tested")
+	}
+
+	contentsPanel = pterm.DefaultBox.WithTitle("Contents").Sprint(renderContents(prog))
+
 	panels, _ := pterm.DefaultPanel.WithPanels(pterm.Panels{
 		{{Data: descriptionPanel}},
 		{{Data: authorsPanel}},
 		{{Data: platformPanel}},
+		{{Data: builderPanel}},
+		{{Data: contentsPanel}},
+		{{Data: memoryPanel}},
+		{{Data: compatibilityPanel}},
 	}).Srender()
 
 	pterm.DefaultBox.WithTitle(ref).Println(panels)
 
 	return nil
 }
+
+func renderUsage(usage loader.Usage) string {
+	var sb strings.Builder
+	for _, m := range usage.Maps {
+		fmt.Fprintf(&sb, "%-24s %-16s %10s", m.Name, m.Type, units.BytesSize(float64(m.Bytes)))
+		if m.Lazy {
+			sb.WriteString(" (at most, allocated on use)")
+		}
+		sb.WriteString("\n")
+	}
+	fmt.Fprintf(&sb, "%-24s %-16s %10s", "total", "", units.BytesSize(float64(usage.Total)))
+	return sb.String()
+}
+
+func renderContents(prog *v1.EbpfPackage) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%-24s %10s", "program.o", units.BytesSize(float64(len(prog.ProgramFileBytes))))
+	for _, obj := range prog.Objects {
+		fmt.Fprintf(&sb, "\n%-24s %10s", obj.Name+".o", units.BytesSize(float64(len(obj.Bytes))))
+	}
+	if len(prog.BTF) > 0 {
+		fmt.Fprintf(&sb, "\n%-24s %10s", "BTF", units.BytesSize(float64(len(prog.BTF))))
+	}
+	if len(prog.Source) > 0 {
+		fmt.Fprintf(&sb, "\n%-24s %10s", "source", units.BytesSize(float64(len(prog.Source))))
+	}
+	for _, fixture := range prog.Fixtures {
+		fmt.Fprintf(&sb, "\n%-24s %10s", "fixture "+fixture.Name, units.BytesSize(float64(len(fixture.Records)+len(fixture.Golden))))
+	}
+	if len(prog.Transforms) > 0 {
+		fmt.Fprintf(&sb, "\ntransformed by %s", strings.Join(prog.Transforms, ", "))
+	}
+	for _, p := range prog.Programs {
+		fmt.Fprintf(&sb, "\nprogram %-16s %s", p.Name, p.Description)
+	}
+	for _, m := range prog.Maps {
+		fmt.Fprintf(&sb, "\nmap %-20s %s", m.Name, m.Description)
+		if m.Metric != nil {
+			fmt.Fprintf(&sb, " (%s", m.Metric.Type)
+			if m.Metric.Unit != "" {
+				fmt.Fprintf(&sb, ", %s", m.Metric.Unit)
+			}
+			sb.WriteString(")")
+		}
+	}
+	if c := prog.Constraints; c != nil {
+		if len(c.Architectures) > 0 {
+			fmt.Fprintf(&sb, "\narchitectures %s", strings.Join(c.Architectures, ", "))
+		}
+		if c.MinKernelVersion != "" {
+			fmt.Fprintf(&sb, "\nkernel >= %s", c.MinKernelVersion)
+		}
+		if c.RequiresBTF {
+			sb.WriteString("\nrequires kernel BTF")
+		}
+		for _, f := range c.Features {
+			fmt.Fprintf(&sb, "\nrequires %s", f)
+		}
+	}
+	return sb.String()
+}
+
+func renderCompatibility(kernels []v1.KernelCompatibility) string {
+	var sb strings.Builder
+	for i, k := range kernels {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		status := "compatible"
+		if !k.Compatible {
+			// only the first line, the error may hold the output of the VM
+			status = "incompatible: " + strings.SplitN(k.Error, "\n", 2)[0]
+		}
+		fmt.Fprintf(&sb, "%-16s %-24s %s", k.Kernel, k.Release, status)
+	}
+	return sb.String()
+}
@@ -0,0 +1,82 @@
+package doctor
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pterm/pterm"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/spf13/cobra"
+)
+
+type doctorOptions struct {
+	general *options.GeneralOptions
+
+	json bool
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	doctorOpts := &doctorOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "doctor [BPF_OCI_IMAGE]",
+		Short: "Check the host has the features a package requires",
+		Long: `
+The doctor command checks the features the config of a package requires of the host beyond the
+kernel, e.g. the cgroup v2 hierarchy or the socket of containerd, as bee run does before loading
+it, and fails listing the missing ones. Only the config of the package is fetched. Without a
+package, all the features known to bee are checked.
+
+$ bee doctor ghcr.io/solo-io/bumblebee/tcpconnect:0.0.9
+$ bee doctor --json
+`,
+		ValidArgsFunction: opts.CompleteRef,
+		Args:              cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			features := v1.HostFeatures
+			if len(args) > 0 {
+				info, err := opts.LocalRegistry().Inspect(cmd.Context(), args[0], nil)
+				if err != nil {
+					return err
+				}
+				features = info.HostFeatures()
+			}
+			prerequisites := loader.HostPrerequisites(features)
+			if doctorOpts.json {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(prerequisites); err != nil {
+					return err
+				}
+			} else {
+				render(prerequisites)
+			}
+			if len(args) == 0 {
+				return nil
+			}
+			return loader.CheckPrerequisites(features)
+		},
+		SilenceUsage: true,
+	}
+	cmd.Flags().BoolVar(&doctorOpts.json, "json", false, "Print the prerequisites as JSON")
+	return cmd
+}
+
+func render(prerequisites []v1.Prerequisite) {
+	if len(prerequisites) == 0 {
+		pterm.Info.Println("The package requires no host features")
+		return
+	}
+	tableData := pterm.TableData{{"Feature", "Description", "Status", "Detail"}}
+	for _, p := range prerequisites {
+		status := pterm.Green("met")
+		if !p.Met {
+			status = pterm.Red("missing")
+		}
+		tableData = append(tableData, []string{string(p.Feature), p.Description, status, p.Detail})
+	}
+	pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+}
@@ -0,0 +1,104 @@
+package fleet
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/solo-io/bumblebee/pkg/agent"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/client"
+	"github.com/solo-io/go-utils/contextutils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+type fleetOptions struct {
+	general *options.GeneralOptions
+
+	port       uint32
+	token      string
+	apiKeys    string
+	staleAfter time.Duration
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *fleetOptions) {
+	flags.Uint32Var(&opts.port, "port", 9093, "Port to serve the fleet API on")
+	flags.StringVar(&opts.token, "token", os.Getenv("BEE_API_TOKEN"), "API key or JWT sent to agents run with --api-keys, a read-only one is enough. Defaults to $BEE_API_TOKEN")
+	flags.StringVar(&opts.apiKeys, "api-keys", "", "Keys file the clients of the fleet API must authenticate with, a read key being enough. Reloaded when changed, to rotate the keys")
+	flags.DurationVar(&opts.staleAfter, "stale-after", agent.DefaultStaleAfter, "How long the entries of an unreachable agent are still aggregated")
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	fleetOpts := &fleetOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "fleet AGENT_ADDRESS...",
+		Short: "Aggregate the maps of several bee agents into fleet-wide views.",
+		Long: `
+The bee fleet command watches the agents of several nodes running the same program,
+and merges their hash maps (sum, min, max and percentiles across nodes):
+$ bee fleet --port=9093 10.0.0.1:9092 10.0.0.2:9092 10.0.0.3:9092
+
+The aggregated maps can then be queried:
+$ curl localhost:9093/api/v1/fleet
+$ curl localhost:9093/api/v1/fleet?map=retransmits
+
+The events of their ring buffers are ordered on a timeline, from the estimated
+clock offsets of the nodes:
+$ curl localhost:9093/api/v1/fleet/timeline?map=events
+$ curl localhost:9093/api/v1/fleet/clocks
+`,
+		Args: cobra.MinimumNArgs(1), // agent addresses
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return fleet(cmd.Context(), args, fleetOpts)
+		},
+		SilenceUsage: true,
+	}
+	addToFlags(cmd.PersistentFlags(), fleetOpts)
+	return cmd
+}
+
+func fleet(ctx context.Context, nodes []string, opts *fleetOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopper := make(chan os.Signal, 1)
+	signal.Notify(stopper, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stopper
+		cancel()
+	}()
+
+	aggregator := agent.NewAggregator()
+	aggregator.SetStaleAfter(opts.staleAfter)
+	if opts.apiKeys != "" {
+		auth := agent.NewAuthenticator(opts.apiKeys)
+		if err := auth.Start(ctx); err != nil {
+			return err
+		}
+		aggregator.SetAuthenticator(auth)
+	}
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", opts.port),
+		Handler: aggregator.Handler(),
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			contextutils.LoggerFrom(ctx).Errorf("could not listen for fleet API: %v", err)
+			cancel()
+		}
+	}()
+
+	fmt.Printf("Serving the fleet API of %d agents on port %d\n", len(nodes), opts.port)
+	return aggregator.Watch(ctx, nodes, &client.Options{Token: opts.token})
+}
@@ -0,0 +1,87 @@
+package helper
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/cilium/ebpf/rlimit"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/solo-io/bumblebee/pkg/privsep"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+type helperOptions struct {
+	general *options.GeneralOptions
+
+	socket     string
+	allowedUID []uint
+	dangerous  bool
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *helperOptions) {
+	flags.StringVar(&opts.socket, "socket", privsep.DefaultSocket, "Path of the socket to listen on, ignored when started by systemd socket activation")
+	flags.UintSliceVar(&opts.allowedUID, "allow-uid", nil, "Only serve clients running as one of these users, the socket is then writable by all users. Otherwise clients are allowed by the group of the socket")
+	flags.BoolVar(&opts.dangerous, "allow-dangerous-probes", false, "Attach the kprobes of clients to kernel functions which are unsafe or too hot to probe, e.g. in the scheduler path")
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	helperOpts := &helperOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "helper",
+		Short: "Run the privileged helper loading programs for unprivileged bee processes.",
+		Long: `
+The bee helper command is the only part of bee which needs to run as root when
+programs are run with 'bee run --helper'. It loads and attaches the programs sent
+over its socket, and passes the descriptors of their maps back, so the long-running
+process reading the maps doesn't need to be privileged:
+$ sudo bee helper --socket /run/bee/helper.sock --allow-uid 1000
+$ bee run --helper /run/bee/helper.sock ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7
+
+Programs stay attached until the bee run process exits.
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return helper(cmd.Context(), helperOpts)
+		},
+		SilenceUsage: true,
+	}
+	addToFlags(cmd.PersistentFlags(), helperOpts)
+	return cmd
+}
+
+func helper(ctx context.Context, opts *helperOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopper := make(chan os.Signal, 1)
+	signal.Notify(stopper, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stopper
+		cancel()
+	}()
+
+	// Allow the current process to lock memory for eBPF resources.
+	if err := rlimit.RemoveMemlock(); err != nil {
+		return fmt.Errorf("could not raise memory limit (check for sudo or setcap): %v", err)
+	}
+
+	allowedUIDs := make([]uint32, 0, len(opts.allowedUID))
+	for _, uid := range opts.allowedUID {
+		allowedUIDs = append(allowedUIDs, uint32(uid))
+	}
+	// parsing the programs doesn't record any metric
+	server := privsep.NewServer(loader.NewLoader(decoder.NewDecoderFactory(), nil), &privsep.ServerOpts{
+		Socket:      opts.socket,
+		AllowedUIDs: allowedUIDs,
+
+		AllowDangerousProbes: opts.dangerous,
+	})
+	return server.Serve(ctx)
+}
@@ -0,0 +1,136 @@
+package inspect
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/docker/go-units"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pterm/pterm"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"github.com/spf13/cobra"
+)
+
+type inspectOptions struct {
+	general *options.GeneralOptions
+
+	json bool
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	inspectOpts := &inspectOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "inspect BPF_OCI_IMAGE",
+		Short: "Show the manifest and config of a package without pulling it",
+		Long: `
+The inspect command fetches only the manifest and config of a package, from the local store if it
+has the package and from its registry otherwise, and shows their digests, sizes, annotations and
+the parsed config. Unlike describe, the programs of the package are not downloaded.
+
+$ bee inspect ghcr.io/solo-io/bumblebee/tcpconnect:0.0.9
+$ bee inspect ghcr.io/solo-io/bumblebee/tcpconnect:0.0.9 --json
+`,
+		ValidArgsFunction: opts.CompleteRef,
+		Args:              cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			info, err := opts.LocalRegistry().Inspect(cmd.Context(), args[0], nil)
+			if err != nil {
+				return err
+			}
+			if inspectOpts.json {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(info)
+			}
+			render(args[0], info)
+			return nil
+		},
+		SilenceUsage: true,
+	}
+	cmd.Flags().BoolVar(&inspectOpts.json, "json", false, "Print the manifest and config as JSON")
+	return cmd
+}
+
+func render(ref string, info *v1.EbpfPackageInfo) {
+	pterm.DefaultSection.Println(ref)
+	fmt.Printf("Manifest  %s (%s)\n", info.Manifest.Digest, units.BytesSize(float64(info.Manifest.Size)))
+	if p := info.Manifest.Platform; p != nil {
+		fmt.Printf("Platform  %s %s %s\n", p.OS, p.OSVersion, p.Architecture)
+	}
+	fmt.Printf("Size      %s pulled\n", units.BytesSize(float64(info.Size())))
+	if info.Index != nil {
+		fmt.Printf("Index     %s, %d variants\n", info.Index.Digest, len(info.Variants))
+	}
+
+	if len(info.Annotations) > 0 {
+		var keys []string
+		for k := range info.Annotations {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		tableData := pterm.TableData{{"Annotation", "Value"}}
+		for _, k := range keys {
+			tableData = append(tableData, []string{k, info.Annotations[k]})
+		}
+		pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	}
+
+	tableData := pterm.TableData{{"Blob", "Media type", "Digest", "Size"}}
+	tableData = append(tableData, blobRow("config", info.Config))
+	for _, layer := range info.Layers {
+		tableData = append(tableData, blobRow(layer.Annotations[ocispec.AnnotationTitle], layer))
+	}
+	pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+
+	if len(info.Variants) > 0 {
+		tableData := pterm.TableData{{"Variant", "Arch", "Min kernel"}}
+		for _, variant := range info.Variants {
+			arch := "unknown"
+			if variant.Platform != nil {
+				arch = variant.Platform.Architecture
+			}
+			tableData = append(tableData, []string{variant.Digest.String(), arch, variant.Annotations[spec.AnnotationMinKernelVersion]})
+		}
+		pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	}
+
+	for _, p := range info.Programs {
+		fmt.Printf("program %-24s %s\n", p.Name, p.Description)
+	}
+	for _, m := range info.Maps {
+		fmt.Printf("map     %-24s %s\n", m.Name, m.Description)
+		renderType("key", m.Key)
+		renderType("value", m.Value)
+	}
+	for _, test := range info.Tests {
+		fmt.Printf("test    %-24s program %s\n", test.Name, test.Program)
+	}
+}
+
+// renderType prints the layout of the key or value of a map, with the comments of its fields.
+func renderType(kind string, typ *v1.TypeDescription) {
+	if typ == nil {
+		return
+	}
+	fmt.Printf("        %-5s %s (%dB)\n", kind, typ.Name, typ.Size)
+	for _, field := range typ.Fields {
+		line := fmt.Sprintf("          +%-4d %-16s %s", field.Offset, field.Type, field.Name)
+		if field.BitfieldSize > 0 {
+			line += fmt.Sprintf(":%d", field.BitfieldSize)
+		}
+		if field.Comment != "" {
+			line += "  // " + field.Comment
+		}
+		fmt.Println(line)
+	}
+}
+
+func blobRow(name string, desc ocispec.Descriptor) []string {
+	return []string{name, desc.MediaType, desc.Digest.String(), units.BytesSize(float64(desc.Size))}
+}
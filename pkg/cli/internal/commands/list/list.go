@@ -2,25 +2,96 @@ package list
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/docker/go-units"
 	"github.com/pterm/pterm"
 	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/spec"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"oras.land/oras-go/pkg/content"
 )
 
+type listOptions struct {
+	general *options.GeneralOptions
+
+	usage bool
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *listOptions) {
+	flags.BoolVar(&opts.usage, "usage", false, "Show the disk usage of each package, and the space saved by storing shared blobs once")
+}
+
 func Command(opts *options.GeneralOptions) *cobra.Command {
+	listOpts := &listOptions{
+		general: opts,
+	}
 	cmd := &cobra.Command{
-		Use: "list",
+		Use: "list [REPOSITORY]",
 		Short: "List saved OCI image.",
+		Long: `
+List the packages of the local store, or the tags of a repository, listed from its registry
+without pulling any package:
+
+$ bee list ghcr.io/solo-io/bumblebee/tcpconnect
+`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 0 {
+				return listTags(cmd.Context(), opts, args[0])
+			}
+			if listOpts.usage {
+				return listUsage(opts)
+			}
 			return list(cmd.Context(), opts)
 		},
 	}
+	addToFlags(cmd.PersistentFlags(), listOpts)
 
 	return cmd
 }
 
+func listUsage(opts *options.GeneralOptions) error {
+	usage, err := spec.LocalStoreUsage(opts.OCIStorageDir)
+	if err != nil {
+		return err
+	}
+
+	tableData := pterm.TableData{
+		[]string{"Name", "Size", "Shared"},
+	}
+	for _, pkg := range usage.Packages {
+		tableData = append(tableData, []string{
+			pkg.Ref,
+			units.BytesSize(float64(pkg.Size)),
+			units.BytesSize(float64(pkg.Shared)),
+		})
+	}
+	pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+
+	fmt.Printf("\n%d packages use %s on disk, %s saved by storing shared blobs once\n",
+		len(usage.Packages),
+		units.BytesSize(float64(usage.Stored)),
+		units.BytesSize(float64(usage.Saved())),
+	)
+	if usage.Unreferenced > 0 {
+		fmt.Printf("%s of blobs are no longer referenced by any package\n", units.BytesSize(float64(usage.Unreferenced)))
+	}
+	return nil
+}
+
+func listTags(ctx context.Context, opts *options.GeneralOptions, repo string) error {
+	tags, err := opts.LocalRegistry().List(ctx, repo, nil)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		fmt.Printf("%s:%s\n", repo, tag)
+	}
+	return nil
+}
+
 func list(ctx context.Context, opts *options.GeneralOptions) error {
 	localRegistry, err := content.NewOCI(opts.OCIStorageDir)
 	if err != nil {
@@ -32,6 +103,9 @@ func list(ctx context.Context, opts *options.GeneralOptions) error {
 		[]string{"Name", "OS", "OS Version", "Arch"},
 	}
 	for name, ref := range localRefs {
+		if spec.IsSignatureRef(name) {
+			continue
+		}
 		if ref.Platform != nil {
 			tableData = append(tableData, []string{
 				name,
@@ -0,0 +1,127 @@
+package maintain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/pterm/pterm"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/target"
+)
+
+type maintainOptions struct {
+	general     *options.GeneralOptions
+	keyFile     string
+	annotations map[string]string
+	normalize   bool
+	dryRun      bool
+	local       bool
+	reportFile  string
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *maintainOptions) {
+	flags.StringVar(&opts.keyFile, "key", "", "PEM private key file, or KMS key, the tags are signed again with, e.g. the new key of a key rotation")
+	flags.StringToStringVar(&opts.annotations, "annotation", nil, "Annotation set on the manifest of each tag, KEY=VALUE, or removed if the value is empty, e.g. org.opencontainers.image.vendor=solo.io")
+	flags.BoolVar(&opts.normalize, "normalize", false, "Trim the values of the annotations of the manifests, dropping empty ones")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "Report what would be done, without changing the repository")
+	flags.BoolVar(&opts.local, "local", false, "Maintain the repository of the local store rather than the one of its registry")
+	flags.StringVar(&opts.reportFile, "report-file", "", "File the JSON report of each tag is written to")
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	maintainOpts := &maintainOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "maintain REPOSITORY",
+		Short: "Verify, re-sign and re-annotate all the tags of a repository",
+		Long: `
+Walks all the tags of the repository: the signatures of each tag are verified with --verify-key,
+--verify-roots or --verify-policy, its annotations fixed, and it is signed again with --key. The tags
+failing verification are left as they are, and reported. To rotate the signing key of a repository:
+$ bee maintain --verify-key old.pub --key new.key ghcr.io/solo-io/bumblebee/tcpconnect
+
+Annotating a manifest changes its digest, so signed tags are only annotated along with --key:
+$ bee maintain --key cosign.key --normalize --annotation org.opencontainers.image.vendor=solo.io ghcr.io/solo-io/bumblebee/tcpconnect
+
+With --dry-run, the report lists what would be done without changing the repository.
+`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return maintain(cmd.Context(), maintainOpts, args[0])
+		},
+	}
+	addToFlags(cmd.Flags(), maintainOpts)
+	return cmd
+}
+
+func maintain(ctx context.Context, opts *maintainOptions, repo string) error {
+	maintenance := spec.MaintenanceOptions{
+		Verifier:    opts.general.LocalRegistry().Verifier,
+		Annotations: opts.annotations,
+		Normalize:   opts.normalize,
+		DryRun:      opts.dryRun,
+		Auth:        opts.general.AuthOptions.ToRegistryOptions(),
+	}
+	if opts.keyFile != "" {
+		key, err := options.LoadSigningKey(ctx, opts.keyFile)
+		if err != nil {
+			return err
+		}
+		maintenance.Key = key
+	}
+	if maintenance.Verifier == nil && maintenance.Key == nil && len(maintenance.Annotations) == 0 && !maintenance.Normalize {
+		return fmt.Errorf("nothing to do, set --key, --annotation, --normalize or a verifier, e.g. --verify-key")
+	}
+
+	var registry target.Target
+	var err error
+	if opts.local {
+		registry, err = content.NewOCI(opts.general.OCIStorageDir)
+	} else {
+		if opts.general.Offline {
+			return fmt.Errorf("repositories of registries can't be maintained with --offline, maintain the local one with --local")
+		}
+		registry, err = content.NewRegistry(maintenance.Auth)
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	spinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Maintaining the tags of %s", repo))
+	report, err := spec.MaintainRepository(ctx, repo, registry, maintenance)
+	if err != nil {
+		spinner.UpdateText(fmt.Sprintf("Failed to maintain %s", repo))
+		spinner.Fail()
+		return err
+	}
+	spinner.Success(report.Summary())
+	for _, tag := range report.Tags {
+		if tag.Error != "" {
+			pterm.Warning.Printfln("%s: %s", tag.Ref, tag.Error)
+		}
+	}
+	if opts.reportFile != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(opts.reportFile, data, 0644); err != nil {
+			return err
+		}
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d tags failed", report.Failed, len(report.Tags))
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package maps
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/cilium/ebpf"
+	"github.com/pterm/pterm"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+type mapsOptions struct {
+	general *options.GeneralOptions
+
+	pinMaps string
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *mapsOptions) {
+	flags.StringVar(&opts.pinMaps, "pin-maps", "", "Directory the maps of the running program are pinned to, as given to bee run --pin-maps")
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	mapsOpts := &mapsOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "maps",
+		Short: "Export and import the state held in the maps of a program.",
+		Long: `
+Snapshots hold the entries of the hash and array maps of a program along with their layout, so
+long-lived state such as allowlists or learned baselines can be migrated between nodes, or kept
+across reinstalls. The program must be run with its maps pinned:
+$ bee run --pin-maps /sys/fs/bpf/tcpconnect ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7
+`,
+	}
+	cmd.AddCommand(
+		exportCommand(mapsOpts),
+		importCommand(mapsOpts),
+	)
+	addToFlags(cmd.PersistentFlags(), mapsOpts)
+	cmd.MarkPersistentFlagRequired("pin-maps")
+	return cmd
+}
+
+func exportCommand(opts *mapsOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "export BPF_OCI_IMAGE FILE",
+		Short: "Export the maps of a running program to a snapshot file.",
+		Long: `
+$ bee maps export --pin-maps /sys/fs/bpf/tcpconnect ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7 state.json
+`,
+		Args:         cobra.ExactArgs(2), // image, file
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exportMaps(cmd.Context(), opts, args[0], args[1])
+		},
+	}
+}
+
+func importCommand(opts *mapsOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "import BPF_OCI_IMAGE FILE",
+		Short: "Import a snapshot file into the maps of a running program.",
+		Long: `
+Existing keys are overwritten, and the snapshot is rejected if the layout of its maps differs
+from the program's, e.g. after a struct changed between versions:
+$ bee maps import --pin-maps /sys/fs/bpf/tcpconnect ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7 state.json
+`,
+		Args:         cobra.ExactArgs(2), // image, file
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return importMaps(cmd.Context(), opts, args[0], args[1])
+		},
+	}
+}
+
+func exportMaps(ctx context.Context, opts *mapsOptions, ref, path string) error {
+	collSpec, maps, err := openMaps(ctx, opts, ref)
+	if err != nil {
+		return err
+	}
+	defer closeMaps(maps)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create snapshot file: %w", err)
+	}
+	w := bufio.NewWriter(file)
+	if err := loader.ExportMaps(ctx, collSpec, maps, w); err != nil {
+		file.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("could not write snapshot file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("could not write snapshot file: %w", err)
+	}
+	pterm.Success.Printfln("Exported %d maps to %s", len(maps), path)
+	return nil
+}
+
+func importMaps(ctx context.Context, opts *mapsOptions, ref, path string) error {
+	collSpec, maps, err := openMaps(ctx, opts, ref)
+	if err != nil {
+		return err
+	}
+	defer closeMaps(maps)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open snapshot file: %w", err)
+	}
+	defer file.Close()
+	if err := loader.ImportMaps(ctx, collSpec, maps, bufio.NewReader(file)); err != nil {
+		return err
+	}
+	pterm.Success.Printfln("Imported %s", path)
+	return nil
+}
+
+// openMaps parses the program from the local store, and opens its pinned maps.
+func openMaps(ctx context.Context, opts *mapsOptions, ref string) (*ebpf.CollectionSpec, map[string]*ebpf.Map, error) {
+	prog, err := opts.general.LocalRegistry().Pull(ctx, ref, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	collSpec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(prog.ProgramFileBytes))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse BPF program: %w", err)
+	}
+	maps, err := loader.OpenPinnedMaps(collSpec, opts.pinMaps)
+	if err != nil {
+		return nil, nil, err
+	}
+	return collSpec, maps, nil
+}
+
+func closeMaps(maps map[string]*ebpf.Map) {
+	for _, m := range maps {
+		m.Close()
+	}
+}
@@ -0,0 +1,134 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pterm/pterm"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/target"
+)
+
+type migrateOptions struct {
+	general *options.GeneralOptions
+	local   bool
+	store   bool
+	dryRun  bool
+	from    int
+	backup  string
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *migrateOptions) {
+	flags.BoolVar(&opts.local, "local", false, "Migrate the images of the local store rather than the ones of their registries")
+	flags.BoolVar(&opts.store, "store", false, "Upgrade the layout of the local store to the one of this release, rather than images")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "With --store, print what would be changed without changing it")
+	flags.IntVar(&opts.from, "from", -1, "With --store, version of the layout of the local store, detected if negative")
+	flags.StringVar(&opts.backup, "backup-dir", "", "With --store, directory the metadata of the local store is backed up in, next to the store if empty")
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	migrateOpts := &migrateOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "migrate [REF...]",
+		Short: "Rewrite images from the deprecated v1 media types to application/ebpf.solo.io.v2",
+		Long: `
+Rewrites the manifests of the images, and of the packages of multi-variant images, with the v2 media
+types, and tags the refs with them. The config and layers are left as they are, so they keep their
+digests and are not pushed again, but the digests of the images change: sign them again after.
+Images already migrated are left as they are.
+$ bee migrate ghcr.io/solo-io/bumblebee/tcpconnect:v0.0.1 ghcr.io/solo-io/bumblebee/exitsnoop:v0.0.1
+
+With --store, upgrades the layout of the local store written by an older release in place instead,
+backing up its index first and restoring it if the upgrade fails:
+$ bee migrate --store --dry-run
+$ bee migrate --store
+`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if migrateOpts.store {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if migrateOpts.store {
+				return migrateStore(cmd.Context(), migrateOpts)
+			}
+			return migrate(cmd.Context(), migrateOpts, args)
+		},
+	}
+	addToFlags(cmd.Flags(), migrateOpts)
+	return cmd
+}
+
+func migrate(ctx context.Context, opts *migrateOptions, refs []string) error {
+	var registry target.Target
+	var err error
+	if opts.local {
+		registry, err = content.NewOCI(opts.general.OCIStorageDir)
+	} else {
+		if opts.general.Offline {
+			return fmt.Errorf("images of registries can't be migrated with --offline, migrate the local images with --local")
+		}
+		registry, err = content.NewRegistry(opts.general.AuthOptions.ToRegistryOptions())
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		migrateSpinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Migrating image %s", ref))
+		desc, migrated, err := spec.MigrateMediaTypes(ctx, ref, registry)
+		if err != nil {
+			migrateSpinner.UpdateText(fmt.Sprintf("Failed to migrate image %s", ref))
+			migrateSpinner.Fail()
+			return err
+		}
+		if !migrated {
+			migrateSpinner.UpdateText(fmt.Sprintf("%s already has the v2 media types", ref))
+		} else {
+			migrateSpinner.UpdateText(fmt.Sprintf("Migrated image %s to %s", ref, desc.Digest))
+		}
+		migrateSpinner.Success()
+	}
+	return nil
+}
+
+func migrateStore(ctx context.Context, opts *migrateOptions) error {
+	dir := opts.general.OCIStorageDir
+	from := opts.from
+	if from < 0 {
+		var err error
+		if from, err = spec.DetectStoreVersion(dir); err != nil {
+			return err
+		}
+	}
+	report, err := spec.MigrateStore(ctx, dir, from, spec.StoreMigrationOptions{DryRun: opts.dryRun, BackupDir: opts.backup})
+	if err != nil {
+		return err
+	}
+	if len(report.Steps) == 0 {
+		fmt.Printf("The store %s already has the layout of version %d\n", dir, report.To)
+		return nil
+	}
+	for _, step := range report.Steps {
+		fmt.Printf("Version %d to %d: %s\n", step.From, step.From+1, step.Description)
+		if len(step.Changes) == 0 {
+			fmt.Println("  nothing to change")
+		}
+		for _, change := range step.Changes {
+			fmt.Printf("  %s\n", change)
+		}
+	}
+	if report.DryRun {
+		fmt.Printf("Nothing was changed, the store %s would be migrated from version %d to %d\n", dir, report.From, report.To)
+		return nil
+	}
+	pterm.Success.Printfln("Migrated the store %s from version %d to %d, backed up in %s", dir, report.From, report.To, report.BackupDir)
+	return nil
+}
@@ -0,0 +1,139 @@
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"oras.land/oras-go/pkg/content"
+)
+
+type mirrorOptions struct {
+	general    *options.GeneralOptions
+	to         string
+	interval   time.Duration
+	prune      string
+	statusFile string
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *mirrorOptions) {
+	flags.StringVar(&opts.to, "to", "", "Repository prefix the images are mirrored under, e.g. edge.local:5000/mirror")
+	flags.DurationVar(&opts.interval, "interval", 0, "Sync the mirror again every interval, e.g. 10m, rather than once")
+	flags.StringVar(&opts.prune, "prune", string(spec.PruneNone), "Policy of the mirrored tags removed from the sources: none keeps them, removed removes them along with their signatures")
+	flags.StringVar(&opts.statusFile, "status-file", "", "File the JSON status of the last sync is written to")
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	mirrorOpts := &mirrorOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "mirror SOURCE... --to REPOSITORY",
+		Short: "Replicate images, with their signatures, to another registry, e.g. of an edge site",
+		Long: `
+Copies the images of the sources whose digest changed, with their cosign and notation signatures, to
+the destination repository prefix: ghcr.io/solo-io/bumblebee/tcpconnect is mirrored to
+edge.local:5000/mirror/solo-io/bumblebee/tcpconnect. Sources are refs REPO:TAG, REPO:PATTERN for the
+tags matching a glob pattern, or REPO for all its tags.
+$ bee mirror --to edge.local:5000/mirror ghcr.io/solo-io/bumblebee/tcpconnect:v1.* ghcr.io/solo-io/bumblebee/exitsnoop
+With --interval, the mirror is synced again every interval until interrupted, and with --prune removed,
+the mirrored tags of patterns removed from the sources are removed too.
+$ bee mirror --to edge.local:5000/mirror --interval 10m --prune removed --status-file /var/run/bee-mirror.json ghcr.io/solo-io/bumblebee/tcpconnect
+`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return mirror(cmd.Context(), mirrorOpts, args)
+		},
+	}
+	addToFlags(cmd.Flags(), mirrorOpts)
+	return cmd
+}
+
+func mirror(ctx context.Context, opts *mirrorOptions, sources []string) error {
+	if opts.general.Offline {
+		return fmt.Errorf("images can't be mirrored with --offline")
+	}
+	if opts.to == "" {
+		return fmt.Errorf("the destination of the mirror must be set with --to")
+	}
+	prune := spec.MirrorPrune(opts.prune)
+	if prune != spec.PruneNone && prune != spec.PruneRemoved {
+		return fmt.Errorf("unknown prune policy %q, must be %s or %s", opts.prune, spec.PruneNone, spec.PruneRemoved)
+	}
+	registryOpts := opts.general.AuthOptions.ToRegistryOptions()
+	registry, err := content.NewRegistry(registryOpts)
+	if err != nil {
+		return err
+	}
+	m, err := spec.NewMirror(sources, opts.to, registry, registry)
+	if err != nil {
+		return err
+	}
+	m.FromAuth, m.ToAuth, m.Prune = registryOpts, registryOpts, prune
+
+	if opts.interval <= 0 {
+		status, err := m.Sync(ctx)
+		report(opts, status, err)
+		return err
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	pterm.Info.Printfln("Syncing the mirror every %s", opts.interval)
+	m.Run(ctx, opts.interval, func(status *spec.MirrorStatus, err error) {
+		report(opts, status, err)
+	})
+	return nil
+}
+
+// report prints the images which failed to sync and a summary of the sync, and writes its
+// status to the status file.
+func report(opts *mirrorOptions, status *spec.MirrorStatus, err error) {
+	for _, image := range status.Images {
+		if image.Action == spec.MirrorFailed {
+			pterm.Warning.Printfln("Failed to sync %s: %s", image.Source, image.Error)
+		}
+	}
+	summary := fmt.Sprintf("Synced the mirror in %s: %d copied, %d unchanged, %d pruned, %d failed",
+		status.Finished.Sub(status.Started).Round(time.Millisecond), status.Copied, status.Unchanged, status.Pruned, status.Failed)
+	if err != nil {
+		pterm.Error.Println(summary)
+	} else {
+		pterm.Success.Println(summary)
+	}
+	if opts.statusFile != "" {
+		if err := writeStatus(opts.statusFile, status); err != nil {
+			pterm.Warning.Printfln("Could not write the status of the mirror to %s: %v", opts.statusFile, err)
+		}
+	}
+}
+
+// writeStatus replaces the status file atomically, so it is never read half written.
+func writeStatus(path string, status *spec.MirrorStatus) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,210 @@
+package pause
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pterm/pterm"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/agent"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+type pauseOptions struct {
+	general *options.GeneralOptions
+
+	ssh   agent.SSHOpts
+	token string
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *pauseOptions) {
+	flags.StringVar(&opts.ssh.Destination, "ssh", "", "Connect to the agent through an SSH tunnel to the given [user@]host[:port], the agent address is then resolved from the SSH server, e.g. localhost:9092")
+	flags.StringVarP(&opts.ssh.IdentityFile, "ssh-identity", "i", "", "Private key used to authenticate the SSH connection, in addition to the keys of the running ssh-agent. Defaults to the keys in ~/.ssh")
+	flags.StringVar(&opts.ssh.KnownHostsFile, "ssh-known-hosts", "", "Known hosts file used to verify the SSH server. Defaults to ~/.ssh/known_hosts")
+	flags.BoolVar(&opts.ssh.InsecureIgnoreHostKey, "ssh-insecure-ignore-host-key", false, "Do not verify the host key of the SSH server")
+	flags.StringVar(&opts.token, "token", os.Getenv("BEE_API_TOKEN"), "API key or JWT sent to agents run with --api-keys, defaults to $BEE_API_TOKEN")
+}
+
+// Command pauses the program of a remote agent.
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	pauseOpts := &pauseOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "pause AGENT_ADDRESS",
+		Short: "Pause the program run by a bee agent, without unloading it.",
+		Long: `
+The program must be run with the agent API and control enabled:
+$ bee run --no-tty --api-port=9092 --api-control ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7
+
+Programs are paused by detaching them from their kprobes and tracepoints, or, if they declare
+a bee_paused map, by setting it so the program does nothing until resumed:
+$ bee pause 10.0.0.1:9092
+$ bee resume 10.0.0.1:9092
+`,
+		Args: cobra.ExactArgs(1), // agent address
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return control(cmd.Context(), args[0], pauseOpts, (*client.Client).Pause, "Paused")
+		},
+		SilenceUsage: true,
+	}
+	addToFlags(cmd.PersistentFlags(), pauseOpts)
+	return cmd
+}
+
+// ResumeCommand resumes the program of a remote agent.
+func ResumeCommand(opts *options.GeneralOptions) *cobra.Command {
+	pauseOpts := &pauseOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "resume AGENT_ADDRESS",
+		Short: "Resume the program run by a bee agent, after bee pause.",
+		Long: `
+$ bee resume 10.0.0.1:9092
+`,
+		Args: cobra.ExactArgs(1), // agent address
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return control(cmd.Context(), args[0], pauseOpts, (*client.Client).Resume, "Resumed")
+		},
+		SilenceUsage: true,
+	}
+	addToFlags(cmd.PersistentFlags(), pauseOpts)
+	return cmd
+}
+
+// TriggerCommand starts a capture of the program of a remote agent.
+func TriggerCommand(opts *options.GeneralOptions) *cobra.Command {
+	pauseOpts := &pauseOptions{
+		general: opts,
+	}
+	var reason string
+	cmd := &cobra.Command{
+		Use:   "trigger AGENT_ADDRESS",
+		Short: "Start a capture of the program run by a bee agent, which stays paused until triggered.",
+		Long: `
+The program must be run with the agent API and control enabled, and a capture config:
+$ bee run --no-tty --api-port=9092 --api-control --capture capture.yaml ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7
+$ bee trigger 10.0.0.1:9092 --reason "checkout latency spike"
+`,
+		Args: cobra.ExactArgs(1), // agent address
+		RunE: func(cmd *cobra.Command, args []string) error {
+			trigger := func(c *client.Client, ctx context.Context) (*v1.ProgramState, error) {
+				return c.Trigger(ctx, reason)
+			}
+			return control(cmd.Context(), args[0], pauseOpts, trigger, "Triggered a capture of")
+		},
+		SilenceUsage: true,
+	}
+	addToFlags(cmd.PersistentFlags(), pauseOpts)
+	cmd.Flags().StringVar(&reason, "reason", "", "Reason recorded with the capture")
+	return cmd
+}
+
+// EnforceCommand switches the program of a remote agent to enforce its policy.
+func EnforceCommand(opts *options.GeneralOptions) *cobra.Command {
+	return modeCommand(opts, v1.EnforceMode, "enforce AGENT_ADDRESS", "Switch the enforcement program run by a bee agent to enforce its policy, rather than only observe.", `
+The program must be run with the agent API and control enabled, and declare a bee_enforce
+map, which it checks to only deny what its policy denies when set. Programs start observe-only:
+$ bee run --no-tty --api-port=9092 --api-control --cgroup pod:9a1d3c1e-5b2f-4c8e-8f0a-2d7b6e4c1f3a ghcr.io/solo-io/bumblebee/exec-deny:0.0.1
+
+Switch the whole program, or only the cgroups of one of its --cgroup selectors:
+$ bee enforce 10.0.0.1:9092
+$ bee enforce 10.0.0.1:9092 --scope pod:9a1d3c1e-5b2f-4c8e-8f0a-2d7b6e4c1f3a
+`)
+}
+
+// ObserveCommand switches the program of a remote agent back to only observe.
+func ObserveCommand(opts *options.GeneralOptions) *cobra.Command {
+	return modeCommand(opts, v1.ObserveMode, "observe AGENT_ADDRESS", "Switch the enforcement program run by a bee agent back to only observe what its policy would deny.", `
+$ bee observe 10.0.0.1:9092
+$ bee observe 10.0.0.1:9092 --scope pod:9a1d3c1e-5b2f-4c8e-8f0a-2d7b6e4c1f3a
+`)
+}
+
+func modeCommand(opts *options.GeneralOptions, mode v1.EnforcementMode, use, short, long string) *cobra.Command {
+	pauseOpts := &pauseOptions{
+		general: opts,
+	}
+	var scope string
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		Long:  long,
+		Args:  cobra.ExactArgs(1), // agent address
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, closeTunnel, err := dial(cmd.Context(), args[0], pauseOpts)
+			if err != nil {
+				return err
+			}
+			defer closeTunnel()
+			state, err := c.SetEnforcementMode(cmd.Context(), scope, mode)
+			if err != nil {
+				return err
+			}
+			target := "the program"
+			if scope != "" {
+				target = "the cgroups " + scope + " of the program"
+			}
+			pterm.Success.Printfln("Set %s of %s to %s", target, args[0], mode)
+			pterm.Info.Printfln("The program is set to %s", state.Mode)
+			for scope, mode := range state.Scopes {
+				pterm.Info.Printfln("The cgroups %s are set to %s", scope, mode)
+			}
+			return nil
+		},
+		SilenceUsage: true,
+	}
+	addToFlags(cmd.PersistentFlags(), pauseOpts)
+	cmd.Flags().StringVar(&scope, "scope", "", "Selector of the cgroups the program is scoped to, as given to its --cgroup, to switch rather than the whole program")
+	return cmd
+}
+
+// dial returns a client of the agent, through an SSH tunnel if set, and a function closing it.
+func dial(ctx context.Context, addr string, opts *pauseOptions) (*client.Client, func(), error) {
+	clientOpts := &client.Options{Token: opts.token}
+	closeTunnel := func() {}
+	if opts.ssh.Destination != "" {
+		tunnel, err := agent.NewSSHTunnel(ctx, &opts.ssh)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not open ssh tunnel: %w", err)
+		}
+		clientOpts.DialContext = tunnel.DialContext
+		closeTunnel = func() { tunnel.Close() }
+	}
+	return client.New(addr, clientOpts), closeTunnel, nil
+}
+
+func control(
+	ctx context.Context,
+	addr string,
+	opts *pauseOptions,
+	action func(*client.Client, context.Context) (*v1.ProgramState, error),
+	done string,
+) error {
+	c, closeTunnel, err := dial(ctx, addr, opts)
+	if err != nil {
+		return err
+	}
+	defer closeTunnel()
+	state, err := action(c, ctx)
+	if err != nil {
+		return err
+	}
+	pterm.Success.Printfln("%s the program of %s (%s strategy)", done, addr, state.Strategy)
+	if state.Enforcement != nil {
+		pterm.Info.Printfln("The program is set to %s", state.Enforcement.Mode)
+	}
+	if state.CaptureUntil != nil {
+		pterm.Info.Printfln("The capture ends at %s", state.CaptureUntil.Local().Format(time.RFC1123))
+	}
+	if state.NextActivation != nil {
+		pterm.Info.Printfln("The program is next resumed by its schedule at %s", state.NextActivation.Local().Format(time.RFC1123))
+	}
+	return nil
+}
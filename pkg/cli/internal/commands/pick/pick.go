@@ -0,0 +1,84 @@
+package pick
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/commands/run"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/picker"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+type pickOptions struct {
+	general *options.GeneralOptions
+
+	repositories []string
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *pickOptions) {
+	flags.StringArrayVar(&opts.repositories, "repository", nil, "Repository whose tags are also offered, e.g. ghcr.io/solo-io/bumblebee/tcpconnect, along with the repositories of the local store and of previous completions")
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	pickOpts := &pickOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "pick [-- RUN_FLAGS...]",
+		Short: "Pick a package to run, and the values of its parameters, interactively",
+		Long: `
+The pick command lists the packages of the local store and the tags of their repositories in
+a fuzzy finder, with their descriptions. Once a package is picked, it prompts for the values
+of the parameters the program declares, then runs it as 'bee run' would, with the flags
+following --.
+
+$ bee pick --repository ghcr.io/solo-io/bumblebee/tcpconnect
+$ bee pick -- --no-tty -o json
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return pick(cmd, pickOpts, args)
+		},
+		SilenceUsage: true,
+	}
+	addToFlags(cmd.Flags(), pickOpts)
+	return cmd
+}
+
+func pick(cmd *cobra.Command, opts *pickOptions, runFlags []string) error {
+	ctx := cmd.Context()
+	selection, err := picker.Pick(ctx, picker.Options{
+		Completer:    opts.general.Completer(),
+		Registry:     opts.general.LocalRegistry(),
+		StorageDir:   opts.general.OCIStorageDir,
+		Repositories: opts.repositories,
+	})
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{}, runFlags...)
+	names := make([]string, 0, len(selection.Parameters))
+	for name := range selection.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "--set", name+"="+selection.Parameters[name])
+	}
+	args = append(args, selection.Ref)
+	// the command is shown so it can be run again without picking
+	pterm.Info.Printf("Running: bee run %s\n", strings.Join(args, " "))
+
+	runCmd := run.Command(opts.general)
+	runCmd.SetArgs(args)
+	// the error is printed by the root command
+	runCmd.SilenceErrors = true
+	if err := runCmd.ExecuteContext(ctx); err != nil {
+		return fmt.Errorf("could not run %s: %w", selection.Ref, err)
+	}
+	return nil
+}
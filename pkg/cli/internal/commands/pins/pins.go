@@ -0,0 +1,121 @@
+package pins
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+type cleanupOptions struct {
+	general *options.GeneralOptions
+
+	olderThan time.Duration
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *cleanupOptions) {
+	flags.DurationVar(&opts.olderThan, "older-than", 24*time.Hour, "Only unpin the objects of runs which started longer ago than this")
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pins",
+		Short: "List and clean up the maps and programs pinned by bee run.",
+		Long: `
+Pinned maps and programs stay loaded once bee run exits, e.g. so the next run picks up the
+entries of the maps. bee run records itself as the owner of the objects pinned with --pin-maps
+and --pin-progs, so the ones left over by runs which are over, or crashed, can be cleaned up.
+`,
+	}
+	cmd.AddCommand(
+		listCommand(opts),
+		cleanupCommand(opts),
+	)
+	return cmd
+}
+
+func listCommand(opts *options.GeneralOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the objects pinned by bee run, along with the run which pinned them.",
+		Long: `
+$ bee pins list
+`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			links, err := loader.Links(cmd.Context(), opts.PinInventoryDir())
+			if err != nil {
+				return err
+			}
+			return render(links)
+		},
+	}
+}
+
+func cleanupCommand(opts *options.GeneralOptions) *cobra.Command {
+	cleanupOpts := &cleanupOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "Unpin the objects of the runs of this host which are over.",
+		Long: `
+Objects still in use, e.g. maps shared with another program, stay loaded until released.
+$ bee pins cleanup --older-than 72h
+`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cleanup(cmd.Context(), cleanupOpts)
+		},
+	}
+	addToFlags(cmd.Flags(), cleanupOpts)
+	return cmd
+}
+
+func cleanup(ctx context.Context, opts *cleanupOptions) error {
+	removed, err := loader.CleanupOrphans(ctx, opts.general.PinInventoryDir(), opts.olderThan)
+	for _, l := range removed {
+		pterm.Info.Printfln("Unpinned %s %s", l.Kind, l.Path)
+	}
+	if err != nil {
+		return err
+	}
+	pterm.Success.Printfln("Unpinned %d objects", len(removed))
+	return nil
+}
+
+func render(links []loader.Link) error {
+	if len(links) == 0 {
+		pterm.Info.Println("No objects pinned by bee run")
+		return nil
+	}
+	data := pterm.TableData{{"Path", "Kind", "ID", "Program", "PID", "Started", "State"}}
+	for _, l := range links {
+		id := strconv.FormatUint(uint64(l.ID), 10)
+		if l.Error != "" {
+			id = "-"
+		}
+		state := "orphaned"
+		if l.Running {
+			state = "running"
+		}
+		data = append(data, []string{
+			l.Path,
+			l.Kind,
+			id,
+			l.Owner.Program,
+			fmt.Sprint(l.Owner.PID),
+			l.Owner.Started.Format(time.RFC3339),
+			state,
+		})
+	}
+	return pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+}
@@ -0,0 +1,64 @@
+package promote
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pterm/pterm"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"github.com/spf13/cobra"
+	"oras.land/oras-go/pkg/content"
+)
+
+type promoteOptions struct {
+	general *options.GeneralOptions
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	promoteOpts := &promoteOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "promote REPOSITORY DIGEST CHANNEL",
+		Short: "Promote a package digest to a release channel (nightly, beta, stable).",
+		Long: `
+Channels are tags of the repository tracking the current release at a given maturity.
+A digest can only be promoted to a channel once it is on the previous one: nightly -> beta -> stable.
+$ bee promote ghcr.io/solo-io/bumblebee/tcpconnect sha256:4f9a... beta
+`,
+		Args: cobra.ExactArgs(3), // repository, digest, channel
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return promote(cmd.Context(), promoteOpts.general, args[0], args[1], args[2])
+		},
+	}
+
+	return cmd
+}
+
+func promote(ctx context.Context, opts *options.GeneralOptions, repo, dgst, channelName string) error {
+	channel, err := v1.ParseChannel(channelName)
+	if err != nil {
+		return err
+	}
+	parsedDigest, err := digest.Parse(dgst)
+	if err != nil {
+		return fmt.Errorf("invalid digest: %w", err)
+	}
+
+	remoteRegistry, err := content.NewRegistry(opts.AuthOptions.ToRegistryOptions())
+	if err != nil {
+		return err
+	}
+
+	promoteSpinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Promoting %s to %s", parsedDigest, spec.ChannelRef(repo, channel)))
+	if err := spec.Promote(ctx, remoteRegistry, repo, parsedDigest, channel); err != nil {
+		promoteSpinner.UpdateText(fmt.Sprintf("Failed to promote %s", parsedDigest))
+		promoteSpinner.Fail()
+		return err
+	}
+	promoteSpinner.Success()
+	return nil
+}
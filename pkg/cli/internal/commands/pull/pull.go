@@ -3,17 +3,24 @@ package pull
 import (
 	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
+	"github.com/docker/go-units"
 	"github.com/pterm/pterm"
+	v1 "github.com/solo-io/bumblebee/api/v1"
 	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
 	"github.com/solo-io/bumblebee/pkg/spec"
 	"github.com/spf13/cobra"
-	"oras.land/oras-go/pkg/content"
-	"oras.land/oras-go/pkg/oras"
 )
 
 type pullOptions struct {
 	general *options.GeneralOptions
+
+	channel     string
+	fixturesDir string
 }
 
 func Command(opts *options.GeneralOptions) *cobra.Command {
@@ -23,43 +30,104 @@ func Command(opts *options.GeneralOptions) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:  "pull",
 		Short: "Pull an OCI image from a registry.",
+		ValidArgsFunction: opts.CompleteRef,
 		Args: cobra.ExactArgs(1), // Ref
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return pull(cmd.Context(), pullOpts.general, args[0])
+			// interrupting aborts the blobs in flight
+			ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+			if pullOpts.channel != "" {
+				return pullChannel(ctx, pullOpts, args[0])
+			}
+			return pull(ctx, pullOpts, args[0])
 		},
 	}
+	cmd.Flags().StringVar(&pullOpts.channel, "channel", "", "Pull the current release of the channel (nightly, beta, stable), the ref is then the repository without tag")
+	cmd.Flags().StringVar(&pullOpts.fixturesDir, "fixtures", "", "Write the fixtures of the package to a directory, the NAME.records.jsonl files of sample events and the NAME.golden.jsonl files they decode to")
 
 	return cmd
 }
 
-func pull(ctx context.Context, opts *options.GeneralOptions, ref string) error {
-
-	localRegistry, err := content.NewOCI(opts.OCIStorageDir)
+func pull(ctx context.Context, pullOpts *pullOptions, ref string) error {
+	opts := pullOpts.general
+	pullSpinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Pulling image %s from remote registry", ref))
+	localRegistry := opts.LocalRegistry()
+	// only the blobs of packages missing from the local store are copied
+	status := localRegistry.StartFetch(ctx, ref, nil)
+	updates, unsubscribe := status.Subscribe()
+	defer unsubscribe()
+	for stats := range updates {
+		if stats.Blobs > 0 {
+			pullSpinner.UpdateText(fmt.Sprintf("Pulling image %s from remote registry: %s", ref, stats.String()))
+		}
+	}
+	_, err := status.Wait(ctx)
 	if err != nil {
+		pullSpinner.UpdateText(fmt.Sprintf("Failed to pull image %s", ref))
+		pullSpinner.Fail()
 		return err
 	}
+	if stats := status.Stats(); stats.Transferred > 0 || stats.Reused > 0 {
+		pullSpinner.UpdateText(fmt.Sprintf("Pulled image %s: %s downloaded, %s reused from the local store",
+			ref, units.BytesSize(float64(stats.Transferred)), units.BytesSize(float64(stats.Reused))))
+	} else {
+		pullSpinner.UpdateText(fmt.Sprintf("Pulled image %s, already in the local store", ref))
+	}
+	pullSpinner.Success()
+	return writeFixtures(ctx, pullOpts, ref)
 
-	remoteRegistry, err := content.NewRegistry(opts.AuthOptions.ToRegistryOptions())
+}
+
+func pullChannel(ctx context.Context, pullOpts *pullOptions, repo string) error {
+	opts := pullOpts.general
+	if opts.Offline {
+		return fmt.Errorf("channels are resolved by the registry, they can't be pulled with --offline")
+	}
+	channel, err := v1.ParseChannel(pullOpts.channel)
 	if err != nil {
 		return err
 	}
 
+	ref := spec.ChannelRef(repo, channel)
 	pullSpinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Pulling image %s from remote registry", ref))
-	_, err = oras.Copy(
-		ctx,
-		remoteRegistry,
-		ref,
-		localRegistry,
-		"",
-		oras.WithAllowedMediaTypes(spec.AllowedMediaTypes()),
-		oras.WithPullByBFS,
-	)
+	pkg, err := opts.LocalRegistry().PullChannel(ctx, repo, channel, nil)
 	if err != nil {
 		pullSpinner.UpdateText(fmt.Sprintf("Failed to pull image %s", ref))
 		pullSpinner.Fail()
 		return err
 	}
+	pullSpinner.UpdateText(fmt.Sprintf("Pulled %s from channel %s", pkg.Digest, pkg.Channel))
 	pullSpinner.Success()
-	return nil
+	return writeFixtures(ctx, pullOpts, ref)
+}
 
+// writeFixtures writes the fixtures of the pulled package to the fixtures directory, if set,
+// laid out as 'bee build --fixtures' reads them.
+func writeFixtures(ctx context.Context, pullOpts *pullOptions, ref string) error {
+	if pullOpts.fixturesDir == "" {
+		return nil
+	}
+	pkg, err := pullOpts.general.LocalRegistry().Pull(ctx, ref, nil)
+	if err != nil {
+		return err
+	}
+	if len(pkg.Fixtures) == 0 {
+		pterm.Warning.Printfln("%s has no fixtures", ref)
+		return nil
+	}
+	if err := os.MkdirAll(pullOpts.fixturesDir, 0755); err != nil {
+		return err
+	}
+	for _, fixture := range pkg.Fixtures {
+		records := filepath.Join(pullOpts.fixturesDir, fixture.Name+spec.FixtureRecordsSuffix)
+		if err := os.WriteFile(records, fixture.Records, 0644); err != nil {
+			return err
+		}
+		golden := filepath.Join(pullOpts.fixturesDir, fixture.Name+spec.FixtureGoldenSuffix)
+		if err := os.WriteFile(golden, fixture.Golden, 0644); err != nil {
+			return err
+		}
+	}
+	pterm.Success.Printfln("Wrote %d fixtures to %s", len(pkg.Fixtures), pullOpts.fixturesDir)
+	return nil
 }
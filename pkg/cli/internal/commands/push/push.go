@@ -2,18 +2,26 @@ package push
 
 import (
 	"context"
+	"crypto"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/pterm/pterm"
 	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/project"
 	"github.com/solo-io/bumblebee/pkg/spec"
 	"github.com/spf13/cobra"
 	"oras.land/oras-go/pkg/content"
-	"oras.land/oras-go/pkg/oras"
 )
 
 type pushOptions struct {
 	general *options.GeneralOptions
+
+	manifest string
+	signKey  string
 }
 
 func Command(opts *options.GeneralOptions) *cobra.Command {
@@ -23,16 +31,36 @@ func Command(opts *options.GeneralOptions) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:  "push",
 		Short: "Push an OCI image to a specified destination.",
-		Args: cobra.ExactArgs(1), // Ref
+		ValidArgsFunction: opts.CompleteRef,
+		Args: cobra.RangeArgs(0, 1), // Ref
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return push(cmd.Context(), pushOpts.general, args[0])
+			// interrupting aborts the blobs in flight
+			ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+			var key crypto.Signer
+			if pushOpts.signKey != "" {
+				// loaded first, so a key which can't sign fails before anything is pushed
+				var err error
+				if key, err = options.LoadSigningKey(ctx, pushOpts.signKey); err != nil {
+					return err
+				}
+			}
+			if len(args) == 0 || pushOpts.manifest != "" {
+				return pushManifest(ctx, args, pushOpts, key)
+			}
+			return push(ctx, pushOpts.general, args[0], key)
 		},
 	}
+	cmd.Flags().StringVarP(&pushOpts.manifest, "manifest", "f", "", fmt.Sprintf("Push the images of all the programs of a project manifest, defaults to ./%s when no ref is given", project.DefaultManifestFile))
+	cmd.Flags().StringVar(&pushOpts.signKey, "sign-key", "", "Sign the pushed images with this key, a PEM private key file or a KMS key as taken by `bee sign --key`")
 
 	return cmd
 }
 
-func push(ctx context.Context, opts *options.GeneralOptions, ref string) error {
+func push(ctx context.Context, opts *options.GeneralOptions, ref string, key crypto.Signer) error {
+	if opts.Offline {
+		return fmt.Errorf("images can't be pushed with --offline")
+	}
 	localRegistry, err := content.NewOCI(opts.OCIStorageDir)
 	if err != nil {
 		return err
@@ -44,21 +72,58 @@ func push(ctx context.Context, opts *options.GeneralOptions, ref string) error {
 	}
 
 	pushSpinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Pushing image %s to remote registry", ref))
-	_, err = oras.Copy(
-		ctx,
-		localRegistry,
-		ref,
-		remoteRegistry,
-		"",
-		oras.WithAllowedMediaTypes(spec.AllowedMediaTypes()),
-		oras.WithPullByBFS,
-	)
+	var progress spec.ProgressTracker
+	_, err = spec.Copy(ctx, localRegistry, ref, remoteRegistry, spec.TransferOptions{
+		Progress: func(p spec.Progress) {
+			progress.Update(p)
+			pushSpinner.UpdateText(fmt.Sprintf("Pushing image %s to remote registry: %s", ref, progress.String()))
+		},
+	})
 	if err != nil {
 		pushSpinner.UpdateText(fmt.Sprintf("Failed to push image %s", ref))
 		pushSpinner.Fail()
 		return err
 	}
+	// signatures made with `bee sign --local` are pushed along with the image
+	if _, desc, err := localRegistry.Resolve(ctx, ref); err == nil {
+		if err := spec.StoreSignature(ctx, ref, desc.Digest, localRegistry, remoteRegistry); err != nil && !errors.Is(err, spec.ErrUnsigned) {
+			pushSpinner.UpdateText(fmt.Sprintf("Failed to push the signature of image %s", ref))
+			pushSpinner.Fail()
+			return err
+		}
+	}
+	if key != nil {
+		pushSpinner.UpdateText(fmt.Sprintf("Signing image %s", ref))
+		if err := spec.Sign(ctx, ref, remoteRegistry, key); err != nil {
+			pushSpinner.UpdateText(fmt.Sprintf("Failed to sign image %s", ref))
+			pushSpinner.Fail()
+			return err
+		}
+	}
 	pushSpinner.Success()
 	return nil
 
 }
+
+func pushManifest(ctx context.Context, args []string, opts *pushOptions, key crypto.Signer) error {
+	if len(args) > 0 {
+		return fmt.Errorf("a ref can't be set when pushing a manifest")
+	}
+	path := opts.manifest
+	if path == "" {
+		path = project.DefaultManifestFile
+	}
+	manifest, err := project.Load(path)
+	if err != nil {
+		return err
+	}
+	for _, prog := range manifest.Programs {
+		if prog.Ref == "" {
+			continue
+		}
+		if err := push(ctx, opts.general, prog.Ref, key); err != nil {
+			return fmt.Errorf("could not push program %s: %w", prog.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+
+	"github.com/pterm/pterm"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"github.com/solo-io/bumblebee/pkg/golden"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+type replayOptions struct {
+	general *options.GeneralOptions
+
+	golden string
+	update bool
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *replayOptions) {
+	flags.StringVar(&opts.golden, "golden", "", "Golden file of the expected entries to compare the replayed ones with, the entries are printed as JSON lines if empty")
+	flags.BoolVar(&opts.update, "update", false, "Write the replayed entries to the golden file instead of comparing them, e.g. once a change of the output is expected")
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	replayOpts := &replayOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "replay BPF_PROGRAM RECORD_FILE",
+		Short: "Decode the events recorded by bee run, and compare them with a golden file.",
+		Long: `
+The raw events of ring buffers and perf event arrays recorded with 'bee run --record' are decoded
+the same way as when the program runs, without a kernel, so changes to the program or to bee can
+be checked for decoding regressions, e.g. in CI.
+
+To record the events, then write them to a golden file once decoded:
+$ bee run --no-tty --record events.jsonl ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7
+$ bee replay --golden tcpconnect.golden.jsonl --update ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7 events.jsonl
+
+To check a new build against the golden file:
+$ bee replay --golden tcpconnect.golden.jsonl tcpconnect.o events.jsonl
+`,
+		Args:         cobra.ExactArgs(2), // program and record file
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return replay(cmd.Context(), replayOpts, args[0], args[1])
+		},
+	}
+	addToFlags(cmd.Flags(), replayOpts)
+	return cmd
+}
+
+func replay(ctx context.Context, opts *replayOptions, progLocation, recordFile string) error {
+	progBytes, err := getProgram(ctx, opts.general, progLocation)
+	if err != nil {
+		return err
+	}
+	factory := decoder.NewDecoderFactory()
+	parsedELF, err := loader.NewLoader(factory, nil).Parse(ctx, bytes.NewReader(progBytes))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(recordFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	records, err := loader.ReadRecords(f)
+	if err != nil {
+		return err
+	}
+
+	watcher := golden.NewWatcher()
+	if err := loader.Replay(ctx, factory, parsedELF, records, watcher); err != nil {
+		return err
+	}
+	entries := watcher.Entries()
+	if opts.golden == "" {
+		return golden.Write(os.Stdout, entries)
+	}
+	if err := golden.Check(opts.golden, entries, opts.update); err != nil {
+		return err
+	}
+	if opts.update {
+		pterm.Success.Printfln("Wrote %d entries to %s", len(entries), opts.golden)
+	} else {
+		pterm.Success.Printfln("%d entries match %s", len(entries), opts.golden)
+	}
+	return nil
+}
+
+// getProgram reads the program from a file, or the local store or registry if there is no such file.
+func getProgram(ctx context.Context, opts *options.GeneralOptions, progLocation string) ([]byte, error) {
+	if _, err := os.Stat(progLocation); err == nil {
+		return ioutil.ReadFile(progLocation)
+	}
+	pkg, err := opts.LocalRegistry().Pull(ctx, progLocation, nil)
+	if err != nil {
+		return nil, err
+	}
+	return pkg.ProgramFileBytes, nil
+}
@@ -0,0 +1,144 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/pterm/pterm"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/solo-io/bumblebee/pkg/secrets"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func addEmulateFlags(flags *pflag.FlagSet, opts *loader.EmulateOptions) {
+	flags.DurationVar(&opts.Interval, "interval", loader.DefaultEmulateInterval, "Time between two recorded events, or two rounds of synthetic events")
+	flags.BoolVar(&opts.Loop, "loop", false, "Replay the recorded events again once all of them are sent, until interrupted")
+	flags.IntVar(&opts.Keys, "keys", loader.DefaultEmulateKeys, "Number of keys of the synthetic entries of each hash map")
+	flags.Int64Var(&opts.Seed, "seed", 0, "Seed of the synthetic events, so an emulation can be reproduced")
+}
+
+// EmulateCommand drives the TUI and the sinks of bee run with recorded or synthetic events,
+// without loading the program.
+func EmulateCommand(opts *options.GeneralOptions) *cobra.Command {
+	runOptions := &runOptions{
+		general: opts,
+	}
+	emulateOpts := &loader.EmulateOptions{}
+	cmd := &cobra.Command{
+		Use:   "emulate BPF_PROGRAM [RECORD_FILE]",
+		Short: "Render the maps of a BPF program with recorded or synthetic events, without a kernel.",
+		Long: `
+The TUI, the printed output and the sinks of bee run are driven by the events recorded with
+'bee run --record', or by events synthesized from the BTF of the structs of the maps if no
+record file is given. Nothing is loaded, so it runs on macOS and Windows as well, to iterate on
+filters, output formats or sinks files before running the program on a Linux host.
+
+To render synthetic events of the maps of a program in the TUI:
+$ bee emulate ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7
+
+To replay recorded events in a loop, one every 500ms:
+$ bee emulate --interval 500ms --loop tcpconnect.o events.jsonl
+
+To check a sinks file, printing the events as JSON lines as well:
+$ bee emulate --no-tty -o json --sinks sinks.yaml tcpconnect.o events.jsonl
+`,
+		ValidArgsFunction: opts.CompleteRef,
+		Args:              cobra.RangeArgs(1, 2), // program and optional record file
+		SilenceUsage:      true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return emulate(cmd, args, runOptions, emulateOpts)
+		},
+	}
+	addOutputFlags(cmd.Flags(), runOptions)
+	addEmulateFlags(cmd.Flags(), emulateOpts)
+	return cmd
+}
+
+func emulate(cmd *cobra.Command, args []string, opts *runOptions, emulateOpts *loader.EmulateOptions) error {
+	ctx, err := buildContext(cmd.Context(), opts.debug)
+	if err != nil {
+		return err
+	}
+	if len(opts.output) > 0 && !opts.notty {
+		return fmt.Errorf("--output requires --no-tty, as the TUI renders the maps otherwise")
+	}
+	if opts.notty {
+		pterm.DisableStyling()
+	}
+	resolver := &secrets.Resolver{Allow: opts.allowSecrets}
+	if err := resolver.Validate(); err != nil {
+		return err
+	}
+
+	progLocation := args[0]
+	progReader, _, _, err := getProgram(ctx, opts.general, progLocation)
+	if err != nil {
+		return err
+	}
+	factory := decoder.NewDecoderFactory()
+	var progLoader loader.Loader = loader.NewLoader(factory, nil)
+	parsedELF, err := progLoader.Parse(ctx, progReader)
+	if err != nil {
+		return fmt.Errorf("could not parse BPF program: %w", err)
+	}
+	if len(args) > 1 {
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		emulateOpts.Records, err = loader.ReadRecords(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if len(emulateOpts.Records) == 0 {
+			return fmt.Errorf("no events recorded in %s", args[1])
+		}
+	}
+
+	attributes, err := parseAttributes(opts.attributes)
+	if err != nil {
+		return err
+	}
+	var watchers []v1.MapWatcher
+	if opts.sinksFile != "" {
+		sinks, err := buildSinks(ctx, opts.sinksFile, resolver)
+		if err != nil {
+			return err
+		}
+		for _, sink := range sinks {
+			watchers = append(watchers, attributes.apply("", sink))
+		}
+	}
+	if opts.notty {
+		if len(opts.output) > 0 {
+			p, err := buildPrinter(opts)
+			if err != nil {
+				return err
+			}
+			watchers = append(watchers, attributes.apply(outputSink, p))
+		}
+		var watcher v1.MapWatcher = loader.NewNoopWatcher()
+		if len(watchers) > 0 {
+			watcher = loader.NewMultiWatcher(watchers...)
+		}
+		return loader.Emulate(ctx, factory, parsedELF, watcher, *emulateOpts)
+	}
+
+	tuiApp, err := buildTuiApp(&progLoader, progLocation, opts, parsedELF)
+	if err != nil {
+		return err
+	}
+	var watcher v1.MapWatcher = tuiApp
+	if len(watchers) > 0 {
+		watcher = loader.NewMultiWatcher(append([]v1.MapWatcher{tuiApp}, watchers...)...)
+	}
+	return tuiApp.RunWithSource(ctx, func(ctx context.Context) error {
+		return loader.Emulate(ctx, factory, parsedELF, watcher, *emulateOpts)
+	})
+}
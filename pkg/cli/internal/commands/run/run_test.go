@@ -0,0 +1,32 @@
+package run
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("checkFlags", func() {
+	It("rejects the flags which can't be used together", func() {
+		for _, c := range []struct {
+			opts runOptions
+			err  string
+		}{
+			{runOptions{apiControl: true}, "--api-control requires the agent API to be served with --api-port"},
+			{runOptions{output: []string{"json"}}, "--output requires --no-tty, as the TUI renders the maps otherwise"},
+			{runOptions{helperSocket: "/run/bee/helper.sock", policy: "default"}, "--policy cannot be used with --helper, which attaches the program"},
+			{runOptions{helperSocket: "/run/bee/helper.sock", selfTelemetry: true}, "--self-telemetry cannot be used with --helper, as this process would need privileges to load it"},
+			{runOptions{sandbox: true, reattachInterval: time.Second}, "--reattach-interval cannot be used with --sandbox, which denies entering network namespaces"},
+			{runOptions{sandbox: true, nodeMetrics: time.Minute}, "--node-metrics cannot be used with --sandbox, which denies iterating over the programs and maps of the node"},
+			{runOptions{nodeTaint: true}, "--node-taint requires --node-failure-threshold"},
+		} {
+			Expect(checkFlags(&c.opts)).To(MatchError(c.err), c.err)
+		}
+	})
+
+	It("accepts the helper and the sandbox alone", func() {
+		Expect(checkFlags(&runOptions{helperSocket: "/run/bee/helper.sock", notty: true})).To(Succeed())
+		Expect(checkFlags(&runOptions{sandbox: true, apiPort: 9091, apiControl: true})).To(Succeed())
+	})
+})
@@ -0,0 +1,50 @@
+package run
+
+import (
+	"github.com/solo-io/bumblebee/pkg/capture"
+	"github.com/solo-io/bumblebee/pkg/internal/kube"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("sandboxOpts", func() {
+	It("only allows reading the files of the flags set", func() {
+		opts := &runOptions{notty: true}
+		Expect(sandboxOpts(opts, nil).ReadPaths).To(Equal([]string{"/sys/devices/system/cpu/possible"}))
+		Expect(sandboxOpts(opts, nil).WritePaths).To(BeEmpty())
+	})
+
+	It("allows reading the files reloaded by the enrichers", func() {
+		opts := &runOptions{
+			notty:        true,
+			labelsFile:   "labels.yaml",
+			geoIPDBs:     []string{"/var/lib/geoip/GeoLite2-Country.mmdb", "/opt/ipinfo/asn.mmdb"},
+			kubeMetadata: true,
+		}
+		Expect(sandboxOpts(opts, nil).ReadPaths).To(ConsistOf(
+			"/sys/devices/system/cpu/possible",
+			"/var/lib/geoip",
+			"/opt/ipinfo",
+			kube.ServiceAccountDir,
+		))
+
+		// which are not built without labels
+		opts.labelsFile = ""
+		Expect(sandboxOpts(opts, nil).ReadPaths).To(Equal([]string{"/sys/devices/system/cpu/possible"}))
+	})
+
+	It("allows reading the tokens of the Kubernetes API server", func() {
+		opts := &runOptions{notty: true, nodeFailures: 3}
+		captureCfg := &capture.Config{
+			Dir: "/var/lib/captures",
+			Triggers: []capture.TriggerConfig{
+				{Name: "oom", Kubernetes: &capture.KubernetesTrigger{Reason: "OOMKilling"}},
+				{Name: "proxied", Kubernetes: &capture.KubernetesTrigger{Config: kube.Config{APIServer: "http://localhost:8001", TokenFile: "/etc/bee/token"}}},
+			},
+		}
+		sandboxOpts := sandboxOpts(opts, captureCfg)
+		Expect(sandboxOpts.ReadPaths).To(ContainElements(kube.ServiceAccountDir, "/etc/bee/token", "/etc/resolv.conf"))
+		Expect(sandboxOpts.WritePaths).To(Equal([]string{"/var/lib/captures"}))
+	})
+})
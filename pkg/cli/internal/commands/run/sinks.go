@@ -0,0 +1,77 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/solo-io/bumblebee/pkg/secrets"
+	"github.com/solo-io/bumblebee/pkg/syslogsink"
+	"github.com/solo-io/bumblebee/pkg/webhooksink"
+)
+
+// sinksConfig is the file of the --sinks flag, declaring where events are sent.
+// Unlike the --config file, it is only read on startup, resolving the references to the
+// secrets allowed with --allow-secret.
+type sinksConfig struct {
+	Webhooks []webhooksink.Config `yaml:"webhooks,omitempty"`
+	Syslog   []syslogsink.Config  `yaml:"syslog,omitempty"`
+	// Spools the events while all the sinks are down
+	Spool *loader.SpoolOptions `yaml:"spool,omitempty"`
+}
+
+// buildSinks reads the sinks file and starts its sinks.
+func buildSinks(ctx context.Context, path string, resolver *secrets.Resolver) ([]v1.MapWatcher, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read sinks file: %w", err)
+	}
+	var cfg sinksConfig
+	if err := resolver.Unmarshal(ctx, data, &cfg); err != nil {
+		return nil, fmt.Errorf("could not parse sinks file: %w", err)
+	}
+
+	var webhooks []*webhooksink.Sink
+	for _, webhookCfg := range cfg.Webhooks {
+		sink, err := webhooksink.New(ctx, webhookCfg)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, sink)
+	}
+	var syslogs []*syslogsink.Sink
+	for _, syslogCfg := range cfg.Syslog {
+		sink, err := syslogsink.New(ctx, syslogCfg)
+		if err != nil {
+			return nil, err
+		}
+		syslogs = append(syslogs, sink)
+	}
+
+	var sinks []v1.MapWatcher
+	var reporters []v1.HealthReporter
+	for _, sink := range webhooks {
+		sinks, reporters = append(sinks, sink.MapWatcher()), append(reporters, sink)
+	}
+	for _, sink := range syslogs {
+		sinks, reporters = append(sinks, sink), append(reporters, sink)
+	}
+	if cfg.Spool != nil && len(sinks) > 0 {
+		spool, err := loader.NewSpool(ctx, loader.NewMultiWatcher(sinks...), reporters, *cfg.Spool)
+		if err != nil {
+			return nil, err
+		}
+		sinks = []v1.MapWatcher{spool}
+	}
+
+	// only started once all the sinks are valid
+	for _, sink := range webhooks {
+		sink.Start()
+	}
+	for _, sink := range syslogs {
+		sink.Start()
+	}
+	return sinks, nil
+}
@@ -0,0 +1,126 @@
+package sign
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pterm/pterm"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/target"
+)
+
+type signOptions struct {
+	general *options.GeneralOptions
+
+	keyFile  string
+	local    bool
+	format   string
+	certFile string
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *signOptions) {
+	flags.StringVar(&opts.keyFile, "key", "", "PEM private key file, e.g. the cosign.key of `cosign generate-key-pair`, encrypted keys are decrypted with $COSIGN_PASSWORD, or KMS key signing the image, as hashivault://KEY, awskms://[ENDPOINT]/ID or gcpkms://projects/P/locations/L/keyRings/R/cryptoKeys/K/cryptoKeyVersions/V")
+	flags.BoolVar(&opts.local, "local", false, "Sign the image of the local store, the signature is pushed along with the image by `bee push`")
+	flags.StringVar(&opts.format, "format", "cosign", "Format of the signature, cosign or notation")
+	flags.StringVar(&opts.certFile, "cert", "", "PEM certificate chain of the key, the signing certificate first, required by notation signatures")
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	signOpts := &signOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "sign REF",
+		Short: "Sign an OCI image with a cosign or notation signature",
+		Long: `
+The sign command stores a signature of the image in its repository, in the format of cosign,
+so it can be verified by ` + "`bee run --verify-key`" + ` or ` + "`cosign verify`" + `. Images can also be
+signed by cosign, including keyless signatures, verified with --verify-roots.
+
+Example workflow:
+$ cosign generate-key-pair
+$ bee push ghcr.io/solo-io/bumblebee/tcpconnect:v1
+$ bee sign --key cosign.key ghcr.io/solo-io/bumblebee/tcpconnect:v1
+$ bee run --verify-key cosign.pub ghcr.io/solo-io/bumblebee/tcpconnect:v1
+
+Images can be signed by the keys of Vault transit, AWS KMS or GCP KMS, which never leave the
+service:
+$ bee sign --key awskms:///alias/bee ghcr.io/solo-io/bumblebee/tcpconnect:v1
+$ bee run --verify-key awskms:///alias/bee ghcr.io/solo-io/bumblebee/tcpconnect:v1
+
+With --format notation, the signature is a notation one, made by the key of a certificate,
+verified with the trust policies of notation for the registries they apply to:
+$ bee sign --format notation --key bee.key --cert bee.crt ghcr.io/solo-io/bumblebee/tcpconnect:v1
+$ bee run --verify-policy ~/.config/notation/trustpolicy.json ghcr.io/solo-io/bumblebee/tcpconnect:v1
+`,
+		ValidArgsFunction: opts.CompleteRef,
+		Args:              cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sign(cmd.Context(), signOpts, args[0])
+		},
+		SilenceUsage: true,
+	}
+	addToFlags(cmd.Flags(), signOpts)
+	cmd.MarkFlagRequired("key")
+	return cmd
+}
+
+func sign(ctx context.Context, opts *signOptions, ref string) error {
+	var chain []*x509.Certificate
+	switch opts.format {
+	case "cosign":
+		if opts.certFile != "" {
+			return fmt.Errorf("--cert is only used by notation signatures")
+		}
+	case "notation":
+		if opts.certFile == "" {
+			return fmt.Errorf("notation signatures require the certificate chain of the key, set --cert")
+		}
+		data, err := ioutil.ReadFile(opts.certFile)
+		if err != nil {
+			return err
+		}
+		if chain, err = spec.LoadCertificateChain(data); err != nil {
+			return fmt.Errorf("could not load the certificates of %s: %w", opts.certFile, err)
+		}
+	default:
+		return fmt.Errorf("unknown signature format %q, must be cosign or notation", opts.format)
+	}
+	key, err := options.LoadSigningKey(ctx, opts.keyFile)
+	if err != nil {
+		return err
+	}
+
+	var registry target.Target
+	if opts.local {
+		registry, err = content.NewOCI(opts.general.OCIStorageDir)
+	} else {
+		if opts.general.Offline {
+			return fmt.Errorf("images of registries can't be signed with --offline, sign the local image with --local")
+		}
+		registry, err = content.NewRegistry(opts.general.AuthOptions.ToRegistryOptions())
+	}
+	if err != nil {
+		return err
+	}
+
+	signSpinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Signing image %s", ref))
+	if chain != nil {
+		err = spec.SignNotation(ctx, ref, registry, key, chain)
+	} else {
+		err = spec.Sign(ctx, ref, registry, key)
+	}
+	if err != nil {
+		signSpinner.UpdateText(fmt.Sprintf("Failed to sign image %s", ref))
+		signSpinner.Fail()
+		return err
+	}
+	signSpinner.Success()
+	return nil
+}
@@ -0,0 +1,143 @@
+package skeleton
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/pterm/pterm"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/skeleton"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var nonIdentChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+type skeletonOptions struct {
+	lang      string
+	outputDir string
+	pkg       string
+	ident     string
+	bpftool   string
+
+	general *options.GeneralOptions
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *skeletonOptions) {
+	flags.StringVarP(&opts.lang, "lang", "l", "go", "Language of the skeleton, either 'go' (cilium/ebpf loader) or 'c' (libbpf skeleton, requires bpftool)")
+	flags.StringVarP(&opts.outputDir, "output-dir", "o", ".", "Directory the skeleton and the program are written to")
+	flags.StringVar(&opts.pkg, "package", "main", "Package of the generated Go code")
+	flags.StringVar(&opts.ident, "ident", "", "Name prefixing the generated types and files, defaults to the repository name of the image")
+	flags.StringVar(&opts.bpftool, "bpftool", "bpftool", "bpftool executable used to generate libbpf skeletons")
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	skeletonOpts := &skeletonOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "skeleton BPF_OCI_IMAGE",
+		Short: "Generate a loader skeleton, to embed a BPF program in another binary.",
+		Long: `
+The bee skeleton command generates code loading the program of an image, which can be embedded in
+another binary while the program is still distributed through the registry:
+$ bee skeleton ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7 --package=tcpconnect -o pkg/tcpconnect
+
+By default a cilium/ebpf Go loader is generated, in the style of bpf2go. A libbpf skeleton header can be
+generated instead (with bpftool):
+$ bee skeleton ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7 --lang=c
+`,
+		ValidArgsFunction: opts.CompleteRef,
+		Args:              cobra.ExactArgs(1), // image
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return generate(cmd.Context(), args[0], skeletonOpts)
+		},
+		SilenceUsage: true,
+	}
+	addToFlags(cmd.PersistentFlags(), skeletonOpts)
+	return cmd
+}
+
+func generate(ctx context.Context, ref string, opts *skeletonOptions) error {
+	if opts.lang != "go" && opts.lang != "c" {
+		return fmt.Errorf("unsupported language %s, must be one of go, c", opts.lang)
+	}
+	prog, err := opts.general.LocalRegistry().Pull(ctx, ref, nil)
+	if err != nil {
+		return err
+	}
+
+	ident := opts.ident
+	if ident == "" {
+		ident = identFromRef(ref)
+	}
+	if err := os.MkdirAll(opts.outputDir, 0755); err != nil {
+		return err
+	}
+
+	var objectFile, skeletonFile string
+	var generated []byte
+	switch opts.lang {
+	case "go":
+		// same naming as bpf2go, BPF programs are little endian on the supported architectures
+		objectFile = ident + "_bpfel.o"
+		skeletonFile = ident + "_bpfel.go"
+		collection, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(prog.ProgramFileBytes))
+		if err != nil {
+			return fmt.Errorf("could not parse program: %w", err)
+		}
+		var buf bytes.Buffer
+		err = skeleton.GenerateGo(&buf, collection, skeleton.GoOpts{
+			Ref:        ref,
+			Package:    opts.pkg,
+			Ident:      ident,
+			ObjectFile: objectFile,
+		})
+		if err != nil {
+			return err
+		}
+		generated = buf.Bytes()
+	case "c":
+		objectFile = ident + ".bpf.o"
+		skeletonFile = ident + ".skel.h"
+	}
+
+	objectPath := filepath.Join(opts.outputDir, objectFile)
+	if err := os.WriteFile(objectPath, prog.ProgramFileBytes, 0644); err != nil {
+		return err
+	}
+	if opts.lang == "c" {
+		generated, err = skeleton.GenerateLibbpf(ctx, objectPath, skeleton.LibbpfOpts{
+			Name:    ident,
+			Bpftool: opts.bpftool,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	skeletonPath := filepath.Join(opts.outputDir, skeletonFile)
+	if err := os.WriteFile(skeletonPath, generated, 0644); err != nil {
+		return err
+	}
+
+	pterm.Success.Printfln("Generated %s and %s from %s", skeletonPath, objectPath, ref)
+	return nil
+}
+
+// identFromRef returns the repository name of the ref, e.g. `tcpconnect` for `ghcr.io/solo-io/tcpconnect:v1`
+func identFromRef(ref string) string {
+	name := ref
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[idx+1:]
+	}
+	if idx := strings.IndexAny(name, ":@"); idx >= 0 {
+		name = name[:idx]
+	}
+	return nonIdentChars.ReplaceAllString(name, "_")
+}
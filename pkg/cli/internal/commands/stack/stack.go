@@ -0,0 +1,113 @@
+package stack
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/cilium/ebpf/rlimit"
+	"github.com/docker/go-units"
+	"github.com/pterm/pterm"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/secrets"
+	"github.com/solo-io/bumblebee/pkg/stack"
+	"github.com/spf13/cobra"
+)
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	var allowSecrets []string
+	var plan bool
+	cmd := &cobra.Command{
+		Use:   "stack [STACK_FILE]",
+		Short: "Run the programs of a stack file together",
+		Long: `
+The stack command runs all the programs of a stack file, ./` + stack.DefaultStackFile + ` by default, with
+their parameters, scopes and sinks. Either all the programs are attached, or none is, and
+they are all detached when one fails or the command is interrupted.
+
+$ bee stack observability.yaml
+
+Values of the stack file can reference environment variables and secrets, e.g. the credentials
+of sinks, only resolved if allowed:
+
+$ bee stack observability.yaml --allow-secret=env:OTLP_TOKEN --allow-secret=k8s:monitoring/bee#*
+
+With --plan, the stack is not run: the digest each package resolves to, the hooks each program
+would attach to, the estimated memory of their maps and the problems which would keep them from
+running on this host are printed instead, without loading or storing anything. The command fails
+if any program has a problem.
+
+$ bee stack observability.yaml --plan
+`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := stack.DefaultStackFile
+			if len(args) > 0 {
+				path = args[0]
+			}
+			return runStack(cmd, opts, path, allowSecrets, plan)
+		},
+		SilenceUsage: true,
+	}
+	cmd.Flags().StringArrayVar(&allowSecrets, "allow-secret", nil, "References to environment variables and secrets the stack file may resolve, as provider:ref patterns, e.g. --allow-secret=env:OPENSEARCH_*")
+	cmd.Flags().BoolVar(&plan, "plan", false, "Print what running the stack would do on this host, and why it would fail, without running it")
+	return cmd
+}
+
+func runStack(cmd *cobra.Command, opts *options.GeneralOptions, path string, allowSecrets []string, plan bool) error {
+	resolver := &secrets.Resolver{Allow: allowSecrets}
+	if err := resolver.Validate(); err != nil {
+		return err
+	}
+	s, err := stack.LoadWith(cmd.Context(), path, resolver)
+	if err != nil {
+		return err
+	}
+	if plan {
+		return printPlan(s.Plan(cmd.Context(), stack.RunOptions{Registry: opts.LocalRegistry()}))
+	}
+	if err := rlimit.RemoveMemlock(); err != nil {
+		return fmt.Errorf("could not raise memory limit (check for sudo or setcap): %v", err)
+	}
+	ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	return s.Run(ctx, stack.RunOptions{Registry: opts.LocalRegistry()})
+}
+
+func printPlan(plan *stack.Plan) error {
+	problems := 0
+	for _, prog := range plan.Programs {
+		fmt.Printf("%s: %s\n", prog.Name, prog.Ref)
+		if prog.Digest != "" {
+			fmt.Printf("  digest  %s\n", prog.Digest)
+		}
+		for _, hook := range prog.Hooks {
+			target := hook.Target
+			if hook.HostVeth {
+				target += " (host veth)"
+			}
+			if len(hook.Netns) > 0 {
+				target += " in " + strings.Join(hook.Netns, ", ")
+			}
+			fmt.Printf("  attach  %s %s to %s\n", hook.Program, hook.Type, target)
+		}
+		if len(prog.Usage.Maps) > 0 {
+			fmt.Printf("  memory  %s in %d maps\n", units.BytesSize(float64(prog.Usage.Total)), len(prog.Usage.Maps))
+		}
+		if prog.BlastRadius != nil {
+			fmt.Printf("  enforce %s\n", strings.ReplaceAll(strings.TrimSpace(prog.BlastRadius.String()), "\n  ", "\n          "))
+		}
+		for _, problem := range prog.Problems {
+			pterm.Error.Println(problem)
+		}
+		problems += len(prog.Problems)
+	}
+	fmt.Printf("\nestimated memory of the maps: %s\n", units.BytesSize(float64(plan.MemoryBytes)))
+	if problems > 0 {
+		return fmt.Errorf("the stack would not run on this host, found %d problems", problems)
+	}
+	fmt.Println("the stack would run on this host")
+	return nil
+}
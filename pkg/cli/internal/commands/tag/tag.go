@@ -20,6 +20,7 @@ func Command(opts *options.GeneralOptions) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:  "tag",
 		Short: "Add an additional name to a local OCI image.",
+		ValidArgsFunction: opts.CompleteRef,
 		Args: cobra.ExactArgs(2), // source, target ref
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return tag(cmd.Context(), tagOpts.general, args[0], args[1])
@@ -0,0 +1,86 @@
+package test
+
+import (
+	"fmt"
+
+	"github.com/pterm/pterm"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/progtest"
+	"github.com/spf13/cobra"
+)
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	var fixturesOnly bool
+	cmd := &cobra.Command{
+		Use:   "test BPF_OCI_IMAGE",
+		Short: "Run the test cases declared in a package against its programs, and its fixtures against its decoder.",
+		Long: `
+Test cases are declared in the config file of the program in the project manifest, and run
+with BPF_PROG_TEST_RUN against synthetic inputs, e.g. packets for XDP and TC programs, so
+programs can be tested in CI once built:
+$ bee build
+$ bee test ghcr.io/my-org/xdp-allowlist:v1
+
+The fixtures packaged with 'bee build --fixtures', sample events with the entries they are
+expected to decode to, are replayed through the decoder as well. They run without a kernel,
+so consumers can check a pulled package against the expectations of its publisher anywhere:
+$ bee test --fixtures-only ghcr.io/my-org/tcpconnect:v1
+`,
+		ValidArgsFunction: opts.CompleteRef,
+		Args:              cobra.ExactArgs(1), // image
+		SilenceUsage:      true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ref := args[0]
+			pkg, err := opts.LocalRegistry().Pull(cmd.Context(), ref, nil)
+			if err != nil {
+				return err
+			}
+			if fixturesOnly {
+				pkg.Tests = nil
+			}
+			if len(pkg.Tests) == 0 && len(pkg.Fixtures) == 0 {
+				if fixturesOnly {
+					pterm.Warning.Printfln("%s has no fixtures", ref)
+				} else {
+					pterm.Warning.Printfln("%s declares no test cases and has no fixtures", ref)
+				}
+				return nil
+			}
+			var results []progtest.Result
+			if len(pkg.Tests) > 0 {
+				if results, err = progtest.Test(cmd.Context(), pkg); err != nil {
+					return err
+				}
+			}
+			if len(pkg.Fixtures) > 0 {
+				fixtures, err := progtest.Fixtures(cmd.Context(), pkg)
+				if err != nil {
+					return err
+				}
+				results = append(results, fixtures...)
+			}
+			failed := 0
+			for _, result := range results {
+				switch {
+				case result.Err != nil:
+					failed++
+					pterm.Error.Printfln("%s: %v", result.Name, result.Err)
+				case !result.Passed():
+					failed++
+					pterm.Error.Printfln("%s", result.Name)
+					for _, failure := range result.Failures {
+						pterm.Println("    " + failure)
+					}
+				default:
+					pterm.Success.Printfln("%s (%s)", result.Name, result.Duration)
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d test cases failed", failed, len(results))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&fixturesOnly, "fixtures-only", false, "Only replay the fixtures of the package, which doesn't need privileges")
+	return cmd
+}
@@ -0,0 +1,75 @@
+package variants
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pterm/pterm"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/spf13/cobra"
+)
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "variants REF SOURCE_REF[=MIN_KERNEL]...",
+		Short: "Save the packages of several images as a single multi-variant image",
+		Long: `
+The variants command saves the packages of the source images, e.g. builds for several
+architectures or for older kernels, as a single image in the local store. When a source is
+followed by a kernel release, its packages are only pulled on kernels from this release on,
+otherwise on any kernel. The host pulls the package of its architecture with the highest
+minimum kernel release its kernel satisfies.
+
+Example workflow:
+$ bee build --arch=amd64,arm64 tcpconnect.c tcpconnect:v1-btf
+$ bee build --arch=amd64,arm64 --build-script build-nobtf.sh tcpconnect.c tcpconnect:v1-nobtf
+$ bee variants tcpconnect:v1 tcpconnect:v1-btf=5.8 tcpconnect:v1-nobtf
+$ bee push tcpconnect:v1
+`,
+		ValidArgsFunction: opts.CompleteRef,
+		Args:              cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return saveVariants(cmd.Context(), opts, args[0], args[1:])
+		},
+		SilenceUsage: true,
+	}
+	return cmd
+}
+
+func saveVariants(ctx context.Context, opts *options.GeneralOptions, ref string, sources []string) error {
+	localRegistry := opts.LocalRegistry()
+	var variants []v1.EbpfPackageVariant
+	for _, source := range sources {
+		sourceRef, minKernel := source, ""
+		if i := strings.LastIndex(source, "="); i >= 0 {
+			sourceRef, minKernel = source[:i], source[i+1:]
+		}
+		sourceVariants, err := localRegistry.PullVariants(ctx, sourceRef, nil)
+		if err != nil {
+			return fmt.Errorf("could not read the packages of %s: %w", sourceRef, err)
+		}
+		for _, variant := range sourceVariants {
+			if minKernel != "" {
+				variant.MinKernelVersion = minKernel
+			}
+			variants = append(variants, variant)
+		}
+	}
+
+	if err := localRegistry.PushVariants(ctx, ref, nil, variants); err != nil {
+		return err
+	}
+	tableData := pterm.TableData{{"Architecture", "Kernels", "Digest"}}
+	for _, variant := range variants {
+		kernels := "any"
+		if variant.MinKernelVersion != "" {
+			kernels = variant.MinKernelVersion + "+"
+		}
+		tableData = append(tableData, []string{variant.Package.Platform.Architecture, kernels, variant.Package.Digest.String()})
+	}
+	pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	pterm.Success.Printfln("Saved multi-variant image %s", ref)
+	return nil
+}
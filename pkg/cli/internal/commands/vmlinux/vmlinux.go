@@ -0,0 +1,77 @@
+package vmlinux
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pterm/pterm"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/vmlinux"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+type vmlinuxOptions struct {
+	btfFile    string
+	outputFile string
+	bpftool    string
+
+	general *options.GeneralOptions
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *vmlinuxOptions) {
+	flags.StringVar(&opts.btfFile, "btf", vmlinux.HostBTF, "BTF file to generate the header from")
+	flags.StringVarP(&opts.outputFile, "output-file", "o", "", "Copy the generated header to this file")
+	flags.StringVar(&opts.bpftool, "bpftool", "bpftool", "bpftool executable used to dump the BTF")
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	vmlinuxOpts := &vmlinuxOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "vmlinux",
+		Short: "Generate a vmlinux.h from BTF, for CO-RE builds.",
+		Long: `
+The bee vmlinux command generates a vmlinux.h from the BTF of the running kernel, or of a BTF file.
+Headers are cached by BTF digest, tagged with the kernel release, and only generated once:
+$ bee vmlinux
+$ bee vmlinux --btf=/path/to/vmlinux.btf -o vmlinux.h
+
+It can also be generated and added to the include path during the build with 'bee build --vmlinux'.
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return generate(cmd.Context(), vmlinuxOpts)
+		},
+		SilenceUsage: true,
+	}
+	addToFlags(cmd.PersistentFlags(), vmlinuxOpts)
+	return cmd
+}
+
+func generate(ctx context.Context, opts *vmlinuxOptions) error {
+	header, err := vmlinux.Generate(ctx, &vmlinux.Opts{
+		BTFFile:  opts.btfFile,
+		CacheDir: filepath.Join(opts.general.ConfigDir, "vmlinux"),
+		Bpftool:  opts.bpftool,
+	})
+	if err != nil {
+		return err
+	}
+	path := header.Path
+	if opts.outputFile != "" {
+		byt, err := os.ReadFile(header.Path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(opts.outputFile, byt, 0644); err != nil {
+			return fmt.Errorf("could not write %s: %w", opts.outputFile, err)
+		}
+		path = opts.outputFile
+	}
+	pterm.Success.Printfln("Wrote vmlinux.h of %s (%s) to %s", header.Tag, header.Digest, path)
+	return nil
+}
@@ -0,0 +1,154 @@
+package vmtest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pterm/pterm"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/cli/internal/options"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"github.com/solo-io/bumblebee/pkg/vmtest"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"oras.land/oras-go/pkg/content"
+)
+
+type vmtestOptions struct {
+	general *options.GeneralOptions
+
+	kernels string
+	report  string
+	attach  bool
+	vmtest  string
+	timeout time.Duration
+	probe   string
+}
+
+func addToFlags(flags *pflag.FlagSet, opts *vmtestOptions) {
+	flags.StringVar(&opts.kernels, "kernels", "", "File declaring the kernels of the test matrix, and their image")
+	flags.StringVar(&opts.report, "report", "", "File to write the compatibility report to as JSON, e.g. to upload it as an artifact of the CI run")
+	flags.BoolVar(&opts.attach, "attach", false, "Attach the compatibility report to the package in the local store, to push it with 'bee push'")
+	flags.StringVar(&opts.vmtest, "vmtest", "", "vmtest binary booting the kernels, looked up in the PATH if empty")
+	flags.DurationVar(&opts.timeout, "timeout", 5*time.Minute, "How long each kernel may take to boot and load the package")
+	flags.StringVar(&opts.probe, "probe", "", "Load and attach a program file on the running kernel, run by bee in each VM")
+	flags.MarkHidden("probe")
+}
+
+func Command(opts *options.GeneralOptions) *cobra.Command {
+	vmtestOpts := &vmtestOptions{
+		general: opts,
+	}
+	cmd := &cobra.Command{
+		Use:   "vmtest BPF_PROGRAM",
+		Short: "Load and attach a package on a matrix of kernels, each booted in a VM.",
+		Long: `
+Each kernel of the matrix is booted in a lightweight VM with vmtest (https://github.com/danobi/vmtest),
+which shares the filesystem of the host, and the programs of the package are loaded and attached in it,
+to report the kernels the package runs on:
+$ bee vmtest --kernels kernels.yaml --report compat.json ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7
+
+The kernels are declared with the image booted, relative to the file:
+kernels:
+- name: "5.4"
+  image: kernels/5.4/bzImage
+- name: "5.10"
+  image: kernels/5.10/bzImage
+
+To attach the report to the package, shown by 'bee describe', then push it:
+$ bee vmtest --kernels kernels.yaml --attach ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7
+$ bee push ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7
+`,
+		ValidArgsFunction: opts.CompleteRef,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if vmtestOpts.probe != "" {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.ExactArgs(1)(cmd, args) // Filename or image
+		},
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if vmtestOpts.probe != "" {
+				return vmtest.Probe(cmd.Context(), vmtestOpts.probe, cmd.OutOrStdout())
+			}
+			return run(cmd.Context(), vmtestOpts, args[0])
+		},
+	}
+	addToFlags(cmd.Flags(), vmtestOpts)
+	return cmd
+}
+
+func run(ctx context.Context, opts *vmtestOptions, progLocation string) error {
+	if opts.kernels == "" {
+		return fmt.Errorf("the kernels of the test matrix must be declared with --kernels")
+	}
+	matrix, err := vmtest.LoadMatrix(opts.kernels)
+	if err != nil {
+		return err
+	}
+
+	progFile := progLocation
+	var pkg *v1.EbpfPackage
+	if _, err := os.Stat(progLocation); err != nil {
+		pkg, err = opts.general.LocalRegistry().Pull(ctx, progLocation, nil)
+		if err != nil {
+			return err
+		}
+		// the VMs read the program from the filesystem of the host
+		f, err := ioutil.TempFile("", "bee-vmtest-*.o")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		if _, err := f.Write(pkg.ProgramFileBytes); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		progFile = f.Name()
+	} else if opts.attach {
+		return fmt.Errorf("--attach requires a package, not a program file")
+	}
+
+	runner := &vmtest.Runner{VMTest: opts.vmtest, Timeout: opts.timeout}
+	spinner, _ := pterm.DefaultSpinner.Start(fmt.Sprintf("Testing %s on %d kernels", progLocation, len(matrix.Kernels)))
+	results, err := runner.Run(ctx, matrix, progFile)
+	if err != nil {
+		spinner.Fail()
+		return err
+	}
+	spinner.Success()
+
+	report := &vmtest.Report{Package: progLocation, Kernels: results}
+	for _, result := range results {
+		if result.Compatible {
+			pterm.Success.Printfln("%s (%s)", result.Kernel, result.Release)
+		} else {
+			pterm.Error.Printfln("%s: %s", result.Kernel, result.Error)
+		}
+	}
+	if opts.report != "" {
+		if err := report.Write(opts.report); err != nil {
+			return fmt.Errorf("could not write compatibility report: %w", err)
+		}
+	}
+	if opts.attach {
+		pkg.Compatibility = results
+		localRegistry, err := content.NewOCI(opts.general.OCIStorageDir)
+		if err != nil {
+			return err
+		}
+		if err := spec.NewEbpfOCICLient().Push(ctx, progLocation, localRegistry, pkg); err != nil {
+			return fmt.Errorf("could not attach compatibility report: %w", err)
+		}
+	}
+	if failed := report.Failed(); failed > 0 {
+		return fmt.Errorf("the package is not compatible with %d of %d kernels", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package options
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"github.com/spf13/cobra"
+)
+
+// Completer returns the completer of refs, caching the tags of the registries in the config
+// directory.
+func (opts *GeneralOptions) Completer() *spec.Completer {
+	completer := spec.NewCompleter(
+		opts.OCIStorageDir,
+		filepath.Join(opts.ConfigDir, "completion-cache.json"),
+		opts.AuthOptions.ToRegistryOptions(),
+	)
+	completer.Offline = opts.Offline
+	return completer
+}
+
+// CompleteRef is the ValidArgsFunction of the commands whose first argument is a ref. When no
+// ref completes the argument, the shell completes file names, as some of these commands
+// also take programs from files.
+func (opts *GeneralOptions) CompleteRef(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	// registry errors are not shown, the completions found without the registry are enough
+	completions, _ := opts.Completer().Complete(cmd.Context(), toComplete)
+	if len(completions) == 0 {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	directive := cobra.ShellCompDirectiveNoFileComp
+	var candidates []string
+	for _, c := range completions {
+		if strings.HasSuffix(c.Ref, ":") {
+			// the tag is completed next
+			directive |= cobra.ShellCompDirectiveNoSpace
+		}
+		candidates = append(candidates, c.Ref+"\t"+c.Description)
+	}
+	return candidates, directive
+}
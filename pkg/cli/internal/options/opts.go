@@ -1,6 +1,16 @@
 package options
 
 import (
+	"context"
+	"crypto"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/solo-io/bumblebee/pkg/kms"
+	"github.com/solo-io/bumblebee/pkg/overrides"
 	"github.com/solo-io/bumblebee/pkg/spec"
 	"github.com/spf13/pflag"
 	"oras.land/oras-go/pkg/content"
@@ -10,6 +20,7 @@ func NewGeneralOptions(flags *pflag.FlagSet) *GeneralOptions {
 	opts := &GeneralOptions{}
 	opts.addToFlags(flags)
 	opts.AuthOptions.addToFlags(flags)
+	opts.VerifyOptions.addToFlags(flags)
 	return opts
 }
 
@@ -17,14 +28,144 @@ type GeneralOptions struct {
 	Verbose       bool
 	OCIStorageDir string
 	ConfigDir     string
+	Offline       bool
+	// Variant pulled from multi-variant images
+	VariantArch   string
+	VariantKernel string
+	// Refuse the short refs of packages rather than expanding them
+	StrictRefs bool
+	// Strip the debug sections of the programs of pulled packages
+	StripDebugInfo bool
+
+	AuthOptions   AuthOptions
+	VerifyOptions VerifyOptions
 
-	AuthOptions AuthOptions
+	verifier *spec.Verifier
+	refs     *spec.RefRules
 }
 
 func (opts *GeneralOptions) addToFlags(flags *pflag.FlagSet) {
 	flags.BoolVarP(&opts.Verbose, "verbose", "v", false, "verbose output")
 	flags.StringVar(&opts.OCIStorageDir, "storage", spec.EbpfImageDir, "Directory to store OCI images locally")
 	flags.StringVar(&opts.ConfigDir, "config-dir", spec.EbpfConfigDir, "Directory to bumblebee configuration")
+	flags.BoolVar(&opts.Offline, "offline", false, "Only use the packages of the local store, never contacting registries")
+	flags.StringVar(&opts.VariantArch, "variant-arch", "", "Architecture of the package pulled from multi-variant images, defaults to the one of the host")
+	flags.StringVar(&opts.VariantKernel, "variant-kernel", "", "Kernel release the package pulled from multi-variant images must support, e.g. 5.4.0, defaults to the one of the host")
+	flags.BoolVar(&opts.StrictRefs, "strict-refs", false, "Refuse short refs of packages, e.g. tcpconnect:v1, rather than expanding them with the default registry, namespace and aliases of the refs.yaml file of the config directory")
+	flags.BoolVar(&opts.StripDebugInfo, "strip-debug-info", false, "Strip the DWARF sections of the programs of pulled packages, which are not loaded, to save memory, the packages of the local store being left as is")
+}
+
+// LocalRegistry returns the local store, pulling the packages it does not have from their
+// registries unless run with --offline.
+func (opts *GeneralOptions) LocalRegistry() *spec.LocalRegistry {
+	localRegistry := spec.NewLocalRegistry(opts.OCIStorageDir, opts.AuthOptions.ToRegistryOptions())
+	localRegistry.Offline = opts.Offline
+	localRegistry.Variant = spec.VariantSelector{Arch: opts.VariantArch, KernelRelease: opts.VariantKernel}
+	localRegistry.Verifier = opts.verifier
+	localRegistry.Refs = opts.refs
+	if opts.StripDebugInfo {
+		localRegistry.Transforms = append(localRegistry.Transforms, spec.StripDebugInfo)
+	}
+	return localRegistry
+}
+
+// LoadRefRules reads the rules the short refs of packages are expanded with from the refs file
+// of the config directory, if any, strict if run with --strict-refs.
+func (opts *GeneralOptions) LoadRefRules() error {
+	rules, err := spec.LoadRefRules(filepath.Join(opts.ConfigDir, spec.RefsFileName))
+	if err != nil {
+		return err
+	}
+	if opts.StrictRefs {
+		rules.Strict = true
+	}
+	opts.refs = rules
+	return nil
+}
+
+// LoadVerifier loads the verifier of the signatures of pulled packages, if run with
+// --verify-key, --verify-roots or --verify-policy. The public keys of KMS keys are read from
+// their service.
+func (opts *GeneralOptions) LoadVerifier(ctx context.Context) error {
+	v := opts.VerifyOptions
+	if v.KeyFile == "" && v.RootsFile == "" && v.PolicyFile == "" {
+		if v.Identity != "" || v.Issuer != "" {
+			return fmt.Errorf("--verify-identity and --verify-issuer require --verify-roots")
+		}
+		if v.TrustStoreDir != "" {
+			return fmt.Errorf("--verify-truststore requires --verify-policy")
+		}
+		if v.CacheTTL != 0 {
+			return fmt.Errorf("--verify-cache-ttl requires --verify-key, --verify-roots or --verify-policy")
+		}
+		return nil
+	}
+	verifierOpts := spec.VerifierOptions{
+		KeyFile:         v.KeyFile,
+		RootsFile:       v.RootsFile,
+		Identity:        v.Identity,
+		Issuer:          v.Issuer,
+		TrustPolicyFile: v.PolicyFile,
+		TrustStoreDir:   v.TrustStoreDir,
+	}
+	if kms.IsRef(v.KeyFile) {
+		key, err := kms.PublicKey(ctx, v.KeyFile, kms.Options{})
+		if err != nil {
+			return err
+		}
+		verifierOpts.KeyFile, verifierOpts.Key = "", key
+	}
+	if v.CacheTTL > 0 {
+		cache, err := spec.OpenVerificationCache(opts.VerificationCacheFile(), v.CacheTTL)
+		if err != nil {
+			return err
+		}
+		verifierOpts.Cache = cache
+	}
+	verifier, err := spec.NewVerifier(verifierOpts)
+	if err != nil {
+		return err
+	}
+	opts.verifier = verifier
+	return nil
+}
+
+// LoadSigningKey loads the key packages are signed with, either a PEM private key file,
+// decrypted with $COSIGN_PASSWORD, or the ref of a KMS key, e.g. awskms:///alias/bee, whose
+// signatures are made by its service.
+func LoadSigningKey(ctx context.Context, key string) (crypto.Signer, error) {
+	if kms.IsRef(key) {
+		return kms.NewSigner(ctx, key, kms.Options{})
+	}
+	keyBytes, err := ioutil.ReadFile(key)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := spec.LoadSigningKey(keyBytes, []byte(os.Getenv("COSIGN_PASSWORD")))
+	if err != nil {
+		return nil, fmt.Errorf("could not load the key of %s: %w", key, err)
+	}
+	return signer, nil
+}
+
+// VerificationCacheFile is the file the packages verified with --verify-cache-ttl are cached in.
+func (opts *GeneralOptions) VerificationCacheFile() string {
+	return filepath.Join(opts.OCIStorageDir, "verified.json")
+}
+
+// OverridesFile is the file the overrides of the packages run with --overrides are persisted in.
+func (opts *GeneralOptions) OverridesFile() string {
+	return filepath.Join(opts.ConfigDir, overrides.FileName)
+}
+
+// PolicyDir is the directory the policies of the packages run with --policy are persisted in.
+func (opts *GeneralOptions) PolicyDir() string {
+	return filepath.Join(opts.ConfigDir, "policies")
+}
+
+// PinInventoryDir is the directory the runs pinning maps or programs are recorded in.
+func (opts *GeneralOptions) PinInventoryDir() string {
+	return filepath.Join(opts.ConfigDir, "pins")
 }
 
 type AuthOptions struct {
@@ -43,6 +184,29 @@ func (opts *AuthOptions) addToFlags(flags *pflag.FlagSet) {
 	flags.BoolVar(&opts.PlainHTTP, "plain-http", false, "use plain http and not https")
 }
 
+// VerifyOptions are the signatures pulled packages must have, made with a key, or keyless
+// and certified by the roots, or for the registries of the notation trust policy, notation
+// signatures satisfying it.
+type VerifyOptions struct {
+	KeyFile       string
+	RootsFile     string
+	Identity      string
+	Issuer        string
+	PolicyFile    string
+	TrustStoreDir string
+	CacheTTL      time.Duration
+}
+
+func (opts *VerifyOptions) addToFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&opts.KeyFile, "verify-key", "", "Only run packages signed by the key of this public key file, e.g. cosign.pub, or of a KMS key, e.g. awskms:///alias/bee")
+	flags.StringVar(&opts.RootsFile, "verify-roots", "", "Only run packages with keyless signatures certified by the authorities of this PEM file, e.g. the roots of Fulcio")
+	flags.StringVar(&opts.Identity, "verify-identity", "", "Email or URI keyless signatures must be certified for")
+	flags.StringVar(&opts.Issuer, "verify-issuer", "", "OIDC issuer which must have authenticated the identity of keyless signatures")
+	flags.StringVar(&opts.PolicyFile, "verify-policy", "", "notation trust policy file, the packages of its registry scopes must have notation signatures satisfying it rather than cosign ones, e.g. ~/.config/notation/trustpolicy.json")
+	flags.StringVar(&opts.TrustStoreDir, "verify-truststore", "", "notation trust store of the certificates of the trust policy, defaults to the one of notation, e.g. ~/.config/notation/truststore")
+	flags.DurationVar(&opts.CacheTTL, "verify-cache-ttl", 0, "Skip the verification of the packages verified by the same keys or trust policy within this duration, e.g. 10m, cached in the local store")
+}
+
 func (opts *AuthOptions) ToRegistryOptions() content.RegistryOptions {
 	return content.RegistryOptions{
 		Configs:   opts.CredentialsFiles,
@@ -0,0 +1,390 @@
+// Package client is the Go client of the agent API served by `bee run --api-port`, and
+// of the fleet API of `bee fleet`.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultRetryDelay = 200 * time.Millisecond
+)
+
+// Client consumes the API of a remote agent. Its methods are safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      string
+	maxRetries int
+	retryDelay time.Duration
+}
+
+type Options struct {
+	// DialContext is used to connect to the agent if set, e.g. agent.SSHTunnel.DialContext
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Token sent to agents requiring a key, an API key or a JWT
+	Token string
+	// Times requests which are safe to send again are retried on connection errors and
+	// 5xx responses, defaults to 3, never if negative
+	MaxRetries int
+	// Delay before the first retry, doubled for each of the next ones, defaults to 200ms
+	RetryDelay time.Duration
+}
+
+// StatusError is returned when the agent answers with an unexpected status.
+type StatusError struct {
+	Code    int
+	Status  string
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Code == http.StatusUnauthorized || e.Code == http.StatusForbidden {
+		return "the agent refused the token: " + e.Message
+	}
+	return fmt.Sprintf("unexpected status %s: %s", e.Status, e.Message)
+}
+
+// temporary returns whether the request may succeed if sent again.
+func (e *StatusError) temporary() bool {
+	return e.Code >= http.StatusInternalServerError
+}
+
+// New returns a client for the agent listening on the given address, e.g. `10.0.0.1:9092`.
+// The options may be nil.
+func New(addr string, opts *Options) *Client {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	httpClient := &http.Client{}
+	if opts.DialContext != nil {
+		httpClient.Transport = &http.Transport{
+			DialContext: opts.DialContext,
+		}
+	}
+	c := &Client{
+		baseURL:    strings.TrimSuffix(addr, "/"),
+		httpClient: httpClient,
+		token:      opts.Token,
+		maxRetries: opts.MaxRetries,
+		retryDelay: opts.RetryDelay,
+	}
+	if c.maxRetries == 0 {
+		c.maxRetries = defaultMaxRetries
+	}
+	if c.retryDelay <= 0 {
+		c.retryDelay = defaultRetryDelay
+	}
+	return c
+}
+
+// Maps lists the maps of the program of the agent.
+func (c *Client) Maps(ctx context.Context) ([]v1.MapInfo, error) {
+	var maps []v1.MapInfo
+	if err := c.get(ctx, v1.MapsPath, &maps); err != nil {
+		return nil, err
+	}
+	return maps, nil
+}
+
+// Probes returns the kernel functions the kprobe and kretprobe programs of the agent are
+// attached to.
+func (c *Client) Probes(ctx context.Context) ([]v1.AttachedProbe, error) {
+	var probes []v1.AttachedProbe
+	if err := c.get(ctx, v1.ProbesPath, &probes); err != nil {
+		return nil, err
+	}
+	return probes, nil
+}
+
+// Status returns the attach state of the programs of the agent, and their recent errors.
+func (c *Client) Status(ctx context.Context) (*v1.ProgramStatus, error) {
+	var status v1.ProgramStatus
+	if err := c.get(ctx, v1.StatusPath, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// ProgramState returns whether the program of the agent is paused.
+func (c *Client) ProgramState(ctx context.Context) (*v1.ProgramState, error) {
+	var state v1.ProgramState
+	if err := c.get(ctx, v1.ProgramPath, &state); err != nil {
+		return nil, controlError(err)
+	}
+	return &state, nil
+}
+
+// Pause pauses the program of the agent, which must be run with the control API enabled.
+func (c *Client) Pause(ctx context.Context) (*v1.ProgramState, error) {
+	// pausing a paused program leaves it paused, so it is retried
+	return c.control(ctx, v1.PausePath, true)
+}
+
+// Resume resumes the program of the agent, which must be run with the control API enabled.
+func (c *Client) Resume(ctx context.Context) (*v1.ProgramState, error) {
+	return c.control(ctx, v1.ResumePath, true)
+}
+
+// Trigger starts a capture of the program of the agent, which must be run with the control
+// API enabled and a capture config. It is not retried, as it would extend the capture.
+func (c *Client) Trigger(ctx context.Context, reason string) (*v1.ProgramState, error) {
+	return c.control(ctx, v1.TriggerPath+"?reason="+url.QueryEscape(reason), false)
+}
+
+// Overrides returns the overrides persisted for the package of the program of the agent, which
+// must be run with the control API and --overrides enabled.
+func (c *Client) Overrides(ctx context.Context) (*v1.PackageOverrides, error) {
+	var overrides v1.PackageOverrides
+	if err := c.get(ctx, v1.OverridesPath, &overrides); err != nil {
+		return nil, controlError(err)
+	}
+	return &overrides, nil
+}
+
+// SetOverrides replaces the overrides of the package of the program of the agent, applied
+// when the program is next run.
+func (c *Client) SetOverrides(ctx context.Context, overrides v1.PackageOverrides) (*v1.PackageOverrides, error) {
+	body, err := json.Marshal(overrides)
+	if err != nil {
+		return nil, err
+	}
+	var set v1.PackageOverrides
+	// setting the same overrides twice leaves them set, so it is retried
+	if err := c.request(ctx, http.MethodPost, v1.SetOverridesPath, body, true, &set); err != nil {
+		return nil, controlError(err)
+	}
+	return &set, nil
+}
+
+// ClearOverrides removes the overrides of the package of the program of the agent.
+func (c *Client) ClearOverrides(ctx context.Context) error {
+	var cleared v1.PackageOverrides
+	return controlError(c.request(ctx, http.MethodPost, v1.ClearOverridesPath, nil, true, &cleared))
+}
+
+// Policy returns the current policy of the enforcement maps of the program of the agent,
+// which must be run with the control API and --policy enabled.
+func (c *Client) Policy(ctx context.Context) (*v1.Policy, error) {
+	var policy v1.Policy
+	if err := c.get(ctx, v1.PolicyPath, &policy); err != nil {
+		return nil, controlError(err)
+	}
+	return &policy, nil
+}
+
+// UpdatePolicy adds and removes entries of an enforcement map of the program of the agent,
+// and returns the new version of the policy. It is not retried, as it would create another
+// version.
+func (c *Client) UpdatePolicy(ctx context.Context, update v1.PolicyUpdate) (*v1.Policy, error) {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+	var policy v1.Policy
+	if err := c.request(ctx, http.MethodPost, v1.UpdatePolicyPath, body, false, &policy); err != nil {
+		return nil, controlError(err)
+	}
+	return &policy, nil
+}
+
+// RollbackPolicy restores a version of the policy of the program of the agent as a new
+// version, and returns it.
+func (c *Client) RollbackPolicy(ctx context.Context, version int, comment string) (*v1.Policy, error) {
+	query := url.Values{"version": {fmt.Sprint(version)}, "comment": {comment}}
+	var policy v1.Policy
+	if err := c.request(ctx, http.MethodPost, v1.RollbackPolicyPath+"?"+query.Encode(), nil, false, &policy); err != nil {
+		return nil, controlError(err)
+	}
+	return &policy, nil
+}
+
+// PolicyVersions lists the versions of the policy of the program of the agent kept, the
+// latest first.
+func (c *Client) PolicyVersions(ctx context.Context) ([]v1.Policy, error) {
+	var versions []v1.Policy
+	if err := c.get(ctx, v1.PolicyVersionsPath, &versions); err != nil {
+		return nil, controlError(err)
+	}
+	return versions, nil
+}
+
+// PolicyAudit lists the changes of the policy of the program of the agent, the oldest first.
+func (c *Client) PolicyAudit(ctx context.Context) ([]v1.PolicyAuditEntry, error) {
+	var audit []v1.PolicyAuditEntry
+	if err := c.get(ctx, v1.PolicyAuditPath, &audit); err != nil {
+		return nil, controlError(err)
+	}
+	return audit, nil
+}
+
+// Enforcement returns whether the program of the agent enforces its policy or only observes,
+// as a whole and for its scopes.
+func (c *Client) Enforcement(ctx context.Context) (*v1.EnforcementState, error) {
+	var state v1.EnforcementState
+	if err := c.get(ctx, v1.EnforcementPath, &state); err != nil {
+		return nil, controlError(err)
+	}
+	return &state, nil
+}
+
+// SetEnforcementMode switches a scope of the program of the agent, the whole program if
+// empty, between observing and enforcing. Setting the current mode again leaves it as it
+// is, so it is retried.
+func (c *Client) SetEnforcementMode(ctx context.Context, scope string, mode v1.EnforcementMode) (*v1.EnforcementState, error) {
+	query := url.Values{"mode": {string(mode)}, "scope": {scope}}
+	var state v1.EnforcementState
+	if err := c.request(ctx, http.MethodPost, v1.EnforcementModePath+"?"+query.Encode(), nil, true, &state); err != nil {
+		return nil, controlError(err)
+	}
+	return &state, nil
+}
+
+// FleetMaps lists the hash maps reported by the nodes of a `bee fleet`.
+func (c *Client) FleetMaps(ctx context.Context) ([]v1.FleetMap, error) {
+	var maps []v1.FleetMap
+	if err := c.get(ctx, v1.FleetPath, &maps); err != nil {
+		return nil, err
+	}
+	return maps, nil
+}
+
+// FleetView returns a hash map of a `bee fleet`, merged across its nodes.
+func (c *Client) FleetView(ctx context.Context, name string) (*v1.FleetView, error) {
+	var view v1.FleetView
+	if err := c.get(ctx, v1.FleetPath+"?map="+url.QueryEscape(name), &view); err != nil {
+		return nil, err
+	}
+	return &view, nil
+}
+
+// Clock samples the clocks of the node of the agent.
+func (c *Client) Clock(ctx context.Context) (*v1.ClockStatus, error) {
+	var status v1.ClockStatus
+	if err := c.get(ctx, v1.ClockPath, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// FleetClocks lists the clocks of the nodes of `bee fleet`, as last estimated.
+func (c *Client) FleetClocks(ctx context.Context) ([]v1.NodeClock, error) {
+	var clocks []v1.NodeClock
+	if err := c.get(ctx, v1.FleetClocksPath, &clocks); err != nil {
+		return nil, err
+	}
+	return clocks, nil
+}
+
+// FleetTimeline lists the recent events of the ring buffers of the nodes of `bee fleet` in
+// the order they happened, of all the maps if the name is empty.
+func (c *Client) FleetTimeline(ctx context.Context, name string) ([]v1.TimelineEvent, error) {
+	path := v1.FleetTimelinePath
+	if name != "" {
+		path += "?map=" + url.QueryEscape(name)
+	}
+	var events []v1.TimelineEvent
+	if err := c.get(ctx, path, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, out interface{}) error {
+	return c.request(ctx, http.MethodGet, path, nil, true, out)
+}
+
+func (c *Client) control(ctx context.Context, path string, retry bool) (*v1.ProgramState, error) {
+	var state v1.ProgramState
+	if err := c.request(ctx, http.MethodPost, path, nil, retry, &state); err != nil {
+		return nil, controlError(err)
+	}
+	return &state, nil
+}
+
+// controlError explains why the control API is not found.
+func controlError(err error) error {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound {
+		return fmt.Errorf("the agent does not allow controlling its program, it must be run with --api-control: %w", err)
+	}
+	return err
+}
+
+// request sends a request, with the JSON encoded body if not nil, and decodes its JSON
+// response, retrying it if allowed.
+func (c *Client) request(ctx context.Context, method, path string, body []byte, retry bool, out interface{}) error {
+	delay := c.retryDelay
+	for attempt := 0; ; attempt++ {
+		err := c.requestOnce(ctx, method, path, body, out)
+		if err == nil || !retry || attempt >= c.maxRetries || ctx.Err() != nil {
+			return err
+		}
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && !statusErr.temporary() {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+func (c *Client) requestOnce(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("could not decode response of %s: %w", path, err)
+	}
+	return nil
+}
+
+// do sends the request with the token of the client, if any.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.httpClient.Do(req)
+}
+
+func statusError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(resp.Body)
+	return &StatusError{Code: resp.StatusCode, Status: resp.Status, Message: strings.TrimSpace(string(body))}
+}
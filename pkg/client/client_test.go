@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		ctx      context.Context
+		requests int32
+		// statuses answered before the requests succeed
+		failures []int
+		server   *httptest.Server
+		client   *Client
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		requests, failures = 0, nil
+		mux := http.NewServeMux()
+		fail := func(w http.ResponseWriter) bool {
+			n := int(atomic.AddInt32(&requests, 1))
+			if n <= len(failures) {
+				http.Error(w, "unavailable", failures[n-1])
+				return true
+			}
+			return false
+		}
+		mux.HandleFunc(v1.MapsPath, func(w http.ResponseWriter, r *http.Request) {
+			if fail(w) {
+				return
+			}
+			Expect(r.Header.Get("Authorization")).To(Equal("Bearer bee_key"))
+			json.NewEncoder(w).Encode([]v1.MapInfo{{Name: "events", Type: v1.RingBufMapType, Keys: []string{"pid"}}})
+		})
+		mux.HandleFunc(v1.TriggerPath, func(w http.ResponseWriter, r *http.Request) {
+			if fail(w) {
+				return
+			}
+			json.NewEncoder(w).Encode(v1.ProgramState{Strategy: v1.GatePauseStrategy})
+		})
+		mux.HandleFunc(v1.WatchPath, func(w http.ResponseWriter, r *http.Request) {
+			if fail(w) {
+				return
+			}
+			enc := json.NewEncoder(w)
+			enc.Encode(v1.Event{Map: &v1.MapInfo{Name: "counts", Type: v1.HashMapType, Keys: []string{"comm"}}})
+			enc.Encode(v1.Event{Entry: &v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"comm": "curl"}, Value: "3"}}})
+		})
+		server = httptest.NewServer(mux)
+		client = New(server.URL, &Options{Token: "bee_key", RetryDelay: time.Millisecond})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("retries requests on 5xx responses", func() {
+		failures = []int{http.StatusServiceUnavailable, http.StatusBadGateway}
+		maps, err := client.Maps(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(maps).To(Equal([]v1.MapInfo{{Name: "events", Type: v1.RingBufMapType, Keys: []string{"pid"}}}))
+		Expect(requests).To(BeEquivalentTo(3))
+	})
+
+	It("gives up after the retries", func() {
+		failures = []int{500, 500, 500, 500, 500}
+		_, err := client.Maps(ctx)
+		var statusErr *StatusError
+		Expect(errors.As(err, &statusErr)).To(BeTrue())
+		Expect(statusErr.Code).To(Equal(http.StatusInternalServerError))
+		Expect(requests).To(BeEquivalentTo(defaultMaxRetries + 1))
+	})
+
+	It("does not retry client errors or triggers", func() {
+		failures = []int{http.StatusForbidden}
+		_, err := client.Maps(ctx)
+		Expect(err).To(MatchError(ContainSubstring("the agent refused the token")))
+		Expect(requests).To(BeEquivalentTo(1))
+
+		requests, failures = 0, []int{http.StatusServiceUnavailable}
+		_, err = client.Trigger(ctx, "spike")
+		Expect(err).To(HaveOccurred())
+		Expect(requests).To(BeEquivalentTo(1))
+	})
+
+	It("explains a missing control API", func() {
+		_, err := client.Pause(ctx)
+		Expect(err).To(MatchError(ContainSubstring("--api-control")))
+	})
+
+	It("streams the events", func() {
+		stream, err := client.Events(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		defer stream.Close()
+		event, err := stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(event.Map.Name).To(Equal("counts"))
+		event, err = stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(event.Entry.Entry.Value).To(Equal("3"))
+		_, err = stream.Recv()
+		Expect(err).To(Equal(ErrStreamEnded))
+	})
+
+	It("reconnects the watch, declaring the maps once", func() {
+		failures = []int{http.StatusServiceUnavailable}
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		watcher := &recordingWatcher{}
+		done := make(chan error)
+		go func() {
+			done <- client.Watch(ctx, watcher)
+		}()
+		// the failed request, then two streams
+		Eventually(func() int32 { return atomic.LoadInt32(&requests) }, 5*time.Second).Should(BeNumerically(">=", 3))
+		cancel()
+		Expect(<-done).To(Succeed())
+		Expect(watcher.maps).To(Equal([]string{"counts"}))
+		Expect(watcher.closed).To(BeTrue())
+	})
+
+	It("forwards the deletions and the resyncs of the agent", func() {
+		agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			enc := json.NewEncoder(w)
+			enc.Encode(v1.Event{Map: &v1.MapInfo{Name: "counts", Type: v1.HashMapType, Keys: []string{"comm"}}})
+			enc.Encode(v1.Event{Deleted: &v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"comm": "curl"}}}})
+			enc.Encode(v1.Event{Dropped: 2})
+			enc.Encode(v1.Event{Resync: true})
+			// the stream is then held open
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}))
+		defer agent.Close()
+		ctx, cancel := context.WithCancel(ctx)
+		watcher := &recordingWatcher{}
+		done := make(chan error)
+		go func() {
+			done <- New(agent.URL, nil).Watch(ctx, watcher)
+		}()
+		Eventually(func() int32 { return atomic.LoadInt32(&watcher.connected) }, 5*time.Second).Should(Equal(int32(2)))
+		cancel()
+		Expect(<-done).To(Succeed())
+		Expect(watcher.deleted).To(Equal([]v1.MapEntry{{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"comm": "curl"}}}}))
+	})
+})
+
+// recordingWatcher is only read once Watch returned
+type recordingWatcher struct {
+	maps    []string
+	entries []v1.MapEntry
+	deleted []v1.MapEntry
+	closed  bool
+	// connections and resyncs, read while watching
+	connected int32
+}
+
+func (w *recordingWatcher) NewRingBuf(name string, keys []string) {}
+func (w *recordingWatcher) NewHashMap(name string, keys []string) {
+	w.maps = append(w.maps, name)
+}
+func (w *recordingWatcher) SendEntry(entry v1.MapEntry) {
+	w.entries = append(w.entries, entry)
+}
+func (w *recordingWatcher) DeleteEntry(entry v1.MapEntry) {
+	w.deleted = append(w.deleted, entry)
+}
+func (w *recordingWatcher) Connected() {
+	atomic.AddInt32(&w.connected, 1)
+}
+func (w *recordingWatcher) Disconnected(err error) {}
+func (w *recordingWatcher) Close() {
+	w.closed = true
+}
\ No newline at end of file
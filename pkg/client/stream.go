@@ -0,0 +1,213 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+const (
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+)
+
+// ErrStreamEnded is returned by EventStream.Recv once the agent closed the stream, e.g.
+// as its program exited.
+var ErrStreamEnded = errors.New("stream ended")
+
+// EventStream is a single watch stream of an agent. It starts with the current state of the
+// maps, followed by new events and changed hash map values.
+type EventStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+}
+
+// Events opens a watch stream, which is not reconnected once lost, see Watch.
+func (c *Client) Events(ctx context.Context) (*EventStream, error) {
+	body, scanner, err := c.stream(ctx, v1.WatchPath)
+	if err != nil {
+		return nil, err
+	}
+	return &EventStream{body: body, scanner: scanner}, nil
+}
+
+// stream opens a stream of newline delimited JSON values.
+func (c *Client) stream(ctx context.Context, path string) (io.ReadCloser, *bufio.Scanner, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, nil, statusError(resp)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return resp.Body, scanner, nil
+}
+
+// Recv returns the next event, blocking until it is received.
+func (s *EventStream) Recv() (v1.Event, error) {
+	if !s.scanner.Scan() {
+		if err := s.scanner.Err(); err != nil {
+			return v1.Event{}, err
+		}
+		return v1.Event{}, ErrStreamEnded
+	}
+	var event v1.Event
+	if err := json.Unmarshal(s.scanner.Bytes(), &event); err != nil {
+		return v1.Event{}, fmt.Errorf("could not decode event: %w", err)
+	}
+	return event, nil
+}
+
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
+
+// DebugLogStream is the stream of the debug logs of the loads of the program of an agent,
+// starting with the recent entries.
+type DebugLogStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+}
+
+// DebugLogs opens the stream of the debug logs of the agent, which only has entries when run
+// with --debug-log-level. It ends once the program is over.
+func (c *Client) DebugLogs(ctx context.Context) (*DebugLogStream, error) {
+	body, scanner, err := c.stream(ctx, v1.DebugLogPath)
+	if err != nil {
+		return nil, err
+	}
+	return &DebugLogStream{body: body, scanner: scanner}, nil
+}
+
+// Recv returns the next entry, blocking until it is received.
+func (s *DebugLogStream) Recv() (v1.DebugLogEntry, error) {
+	if !s.scanner.Scan() {
+		if err := s.scanner.Err(); err != nil {
+			return v1.DebugLogEntry{}, err
+		}
+		return v1.DebugLogEntry{}, ErrStreamEnded
+	}
+	var entry v1.DebugLogEntry
+	if err := json.Unmarshal(s.scanner.Bytes(), &entry); err != nil {
+		return v1.DebugLogEntry{}, fmt.Errorf("could not decode debug log entry: %w", err)
+	}
+	return entry, nil
+}
+
+func (s *DebugLogStream) Close() error {
+	return s.body.Close()
+}
+
+// HintedWatcher is implemented by the watchers receiving the ordering hints of the events of
+// ring buffers, e.g. to order the events of several agents. They receive the events with
+// SendHintedEntry rather than SendEntry.
+type HintedWatcher interface {
+	SendHintedEntry(entry v1.MapEntry, hint v1.OrderingHint)
+}
+
+// ConnectionWatcher is implemented by the watchers tracking the connection to the agent, e.g.
+// to expire the entries of an agent unreachable for too long.
+type ConnectionWatcher interface {
+	// Connected is called once a watch stream is opened, before the state of the maps is
+	// sent again, and once the agent sends it again after dropping changes of its hash maps
+	Connected()
+	// Disconnected is called once the stream is lost, or could not be opened
+	Disconnected(err error)
+}
+
+// Watch streams the maps of the agent into the watcher, reconnecting whenever the
+// connection is lost, until the context is done. The watcher is closed on return.
+func (c *Client) Watch(ctx context.Context, watcher v1.MapWatcher) error {
+	defer watcher.Close()
+	logger := contextutils.LoggerFrom(ctx)
+
+	// maps already declared to the watcher, as they are sent again on every reconnect
+	declared := map[string]bool{}
+	delay := minReconnectDelay
+	for {
+		received, err := c.watchOnce(ctx, watcher, declared)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if conn, ok := watcher.(ConnectionWatcher); ok {
+			conn.Disconnected(err)
+		}
+		if received {
+			delay = minReconnectDelay
+		}
+		logger.Infof("lost connection to agent %s, reconnecting in %v: %v", c.baseURL, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// watchOnce consumes a single watch stream, it returns whether any event was received.
+func (c *Client) watchOnce(ctx context.Context, watcher v1.MapWatcher, declared map[string]bool) (bool, error) {
+	stream, err := c.Events(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer stream.Close()
+	if conn, ok := watcher.(ConnectionWatcher); ok {
+		conn.Connected()
+	}
+
+	var received bool
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return received, err
+		}
+		received = true
+		switch {
+		case event.Map != nil:
+			if declared[event.Map.Name] {
+				continue
+			}
+			declared[event.Map.Name] = true
+			if event.Map.Type == v1.HashMapType {
+				watcher.NewHashMap(event.Map.Name, event.Map.Keys)
+			} else {
+				watcher.NewRingBuf(event.Map.Name, event.Map.Keys)
+			}
+		case event.Entry != nil:
+			if hinted, ok := watcher.(HintedWatcher); ok && event.Hint != nil {
+				hinted.SendHintedEntry(*event.Entry, *event.Hint)
+				continue
+			}
+			watcher.SendEntry(*event.Entry)
+		case event.Deleted != nil:
+			if deleting, ok := watcher.(v1.DeletingWatcher); ok {
+				deleting.DeleteEntry(*event.Deleted)
+			}
+		case event.Dropped > 0:
+			contextutils.LoggerFrom(ctx).Warnf("agent dropped %d events, the connection is too slow", event.Dropped)
+		case event.Resync:
+			if conn, ok := watcher.(ConnectionWatcher); ok {
+				conn.Connected()
+			}
+		}
+	}
+}
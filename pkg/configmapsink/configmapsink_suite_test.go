@@ -0,0 +1,13 @@
+package configmapsink_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestConfigMapSink(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ConfigMapSink Suite")
+}
@@ -0,0 +1,305 @@
+// Package configmapsink writes snapshots of the hash maps of a program to a Kubernetes
+// ConfigMap, so that the current counters can be read with kubectl in clusters without a
+// metrics stack.
+package configmapsink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/hashstructure/v2"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/internal/kube"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+const (
+	// ServiceAccountDir holds the token, CA certificate and namespace the ConfigMap is
+	// written with by default
+	ServiceAccountDir = kube.ServiceAccountDir
+
+	// DefaultInterval is how often the snapshot is written
+	DefaultInterval = 30 * time.Second
+	// DefaultMaxSize bounds the size of the entries of the snapshot, well below the 1MiB
+	// objects of the API server are limited to, as the ConfigMap is written on every interval
+	DefaultMaxSize = 64 * 1024
+
+	// UpdatedKey is the key of the ConfigMap holding the time the snapshot was taken at
+	UpdatedKey = "updated"
+	// TruncatedKey is the key of the ConfigMap counting the entries left out of the snapshot
+	// once it reached its maximum size, absent otherwise
+	TruncatedKey = "truncated"
+
+	programAnnotation = "bee.solo.io/program"
+)
+
+type Opts struct {
+	// ConfigMap the snapshots are written to, NAMESPACE/NAME or NAME in the namespace of the
+	// pod bee runs in, given by $POD_NAMESPACE or the namespace of its service account. It is
+	// created if missing.
+	ConfigMap string
+	// Hash maps snapshotted, the entries of the other maps are never written
+	Maps []string
+	// Program annotated on the ConfigMap, e.g. its ref
+	Program string
+	// How often the snapshot is written, defaults to DefaultInterval
+	Interval time.Duration
+	// Maximum size of the entries of the snapshot, defaults to DefaultMaxSize. The smallest
+	// values are left out of the snapshots exceeding it.
+	MaxSize int
+	// API server the ConfigMap is written to, with a timeout of 10s unless set
+	kube.Config
+}
+
+// Sink keeps the latest value of each key of the snapshotted maps, and writes them to the
+// ConfigMap every interval, a key of the ConfigMap per map holding a JSON line per entry.
+// It implements v1.MapWatcher.
+type Sink struct {
+	opts      Opts
+	namespace string
+	name      string
+	path      string
+	client    *kube.Client
+	now       func() time.Time
+
+	lock    sync.Mutex
+	allowed map[string]bool
+	maps    map[string]map[uint64]v1.KvPair
+	changed bool
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// entry is a line of the snapshot of a map.
+type entry struct {
+	Key   map[string]string `json:"key"`
+	Value string            `json:"value"`
+}
+
+func New(opts Opts) (*Sink, error) {
+	if len(opts.Maps) == 0 {
+		return nil, errors.New("the maps snapshotted to the ConfigMap must be listed")
+	}
+	namespace, name := "", opts.ConfigMap
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		namespace, name = name[:i], name[i+1:]
+	}
+	if name == "" {
+		return nil, fmt.Errorf("invalid ConfigMap %q, must be NAMESPACE/NAME or NAME", opts.ConfigMap)
+	}
+	if opts.Interval == 0 {
+		opts.Interval = DefaultInterval
+	}
+	if opts.MaxSize == 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+	if opts.Interval < 0 || opts.MaxSize < 0 {
+		return nil, errors.New("the interval and maximum size of the snapshots must be positive")
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	client, err := kube.NewClient(opts.Config)
+	if err != nil {
+		return nil, err
+	}
+	if namespace == "" {
+		namespace = os.Getenv("POD_NAMESPACE")
+	}
+	if namespace == "" {
+		byt, err := ioutil.ReadFile(ServiceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("the namespace of the ConfigMap must be set, or given by $POD_NAMESPACE: %w", err)
+		}
+		namespace = strings.TrimSpace(string(byt))
+	}
+	allowed := map[string]bool{}
+	for _, m := range opts.Maps {
+		allowed[m] = true
+	}
+	return &Sink{
+		opts:      opts,
+		namespace: namespace,
+		name:      name,
+		path:      "/api/v1/namespaces/" + url.PathEscape(namespace) + "/configmaps",
+		client:    client,
+		now:       time.Now,
+		allowed:   allowed,
+		maps:      map[string]map[uint64]v1.KvPair{},
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// Start writes the snapshot every interval until the context is done or the sink closed,
+// and a last time then.
+func (s *Sink) Start(ctx context.Context) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			case <-s.done:
+				// the context of the program is done by now, the last write gets its own
+				flushCtx, cancel := context.WithTimeout(contextutils.WithExistingLogger(context.Background(), contextutils.LoggerFrom(ctx)), 10*time.Second)
+				s.flush(flushCtx)
+				cancel()
+				return
+			}
+			s.flush(ctx)
+		}
+	}()
+}
+
+func (s *Sink) flush(ctx context.Context) {
+	if err := s.Write(ctx); err != nil {
+		contextutils.LoggerFrom(ctx).Warnf("could not write the snapshot of the maps to ConfigMap %s/%s: %v", s.namespace, s.name, err)
+	}
+}
+
+func (s *Sink) NewRingBuf(name string, keys []string) {
+	if s.allowed[name] {
+		contextutils.LoggerFrom(context.Background()).Warnf("map %s is not snapshotted to the ConfigMap, only hash maps are", name)
+	}
+}
+
+func (s *Sink) NewHashMap(name string, keys []string) {
+	if !s.allowed[name] {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.maps[name] = map[uint64]v1.KvPair{}
+}
+
+func (s *Sink) SendEntry(entry v1.MapEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entries, ok := s.maps[entry.Name]
+	if !ok {
+		return
+	}
+	hash, _ := hashstructure.Hash(entry.Entry.Key, hashstructure.FormatV2, nil)
+	if current, ok := entries[hash]; ok && current.Value == entry.Entry.Value {
+		return
+	}
+	entries[hash] = entry.Entry
+	s.changed = true
+}
+
+// Close writes the snapshot a last time, if started.
+func (s *Sink) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+	s.wg.Wait()
+}
+
+// Write writes the snapshot to the ConfigMap, creating it if missing. It is only written
+// once an entry changed.
+func (s *Sink) Write(ctx context.Context) error {
+	s.lock.Lock()
+	if !s.changed {
+		s.lock.Unlock()
+		return nil
+	}
+	data := s.snapshot()
+	s.changed = false
+	s.lock.Unlock()
+
+	configMap := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":        s.name,
+			"namespace":   s.namespace,
+			"labels":      map[string]string{"app.kubernetes.io/managed-by": "bee"},
+			"annotations": map[string]string{programAnnotation: s.opts.Program},
+		},
+		"data": data,
+	}
+	// a merge patch, so the keys set by others are kept, and the truncated key removed when null
+	err := s.client.Do(ctx, http.MethodPatch, s.path+"/"+url.PathEscape(s.name), "application/merge-patch+json", configMap, nil)
+	if kube.IsStatus(err, http.StatusNotFound) {
+		for k, v := range data {
+			if v == nil {
+				delete(data, k)
+			}
+		}
+		err = s.client.Do(ctx, http.MethodPost, s.path, "application/json", configMap, nil)
+	}
+	if err != nil {
+		s.lock.Lock()
+		s.changed = true
+		s.lock.Unlock()
+	}
+	return err
+}
+
+// line is an entry of the snapshot of a map.
+type line struct {
+	name  string
+	json  string
+	value float64
+}
+
+// snapshot returns the data of the ConfigMap, the entries of each map sorted by decreasing
+// value, e.g. the largest counters first, as the smallest are left out of the snapshots
+// exceeding the maximum size. It must be called with the lock held.
+func (s *Sink) snapshot() map[string]interface{} {
+	var lines []line
+	for name, entries := range s.maps {
+		for _, pair := range entries {
+			b, _ := json.Marshal(entry{Key: pair.Key, Value: pair.Value})
+			// values which are not numbers count as 0
+			value, _ := strconv.ParseFloat(pair.Value, 64)
+			lines = append(lines, line{name: name, json: string(b), value: value})
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].value != lines[j].value {
+			return lines[i].value > lines[j].value
+		}
+		return lines[i].json < lines[j].json
+	})
+
+	buffers := map[string]*bytes.Buffer{}
+	for name := range s.maps {
+		buffers[name] = &bytes.Buffer{}
+	}
+	size, truncated := 0, 0
+	for _, l := range lines {
+		if size+len(l.json)+1 > s.opts.MaxSize {
+			truncated++
+			continue
+		}
+		size += len(l.json) + 1
+		buffers[l.name].WriteString(l.json + "\n")
+	}
+
+	data := map[string]interface{}{
+		UpdatedKey:   s.now().UTC().Format(time.RFC3339),
+		TruncatedKey: nil,
+	}
+	if truncated > 0 {
+		data[TruncatedKey] = fmt.Sprintf("%d entries left out, the snapshot is limited to %d bytes", truncated, s.opts.MaxSize)
+	}
+	for name, b := range buffers {
+		data[name] = b.String()
+	}
+	return data
+}
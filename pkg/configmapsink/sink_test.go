@@ -0,0 +1,141 @@
+package configmapsink_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/configmapsink"
+	"github.com/solo-io/bumblebee/pkg/internal/kube"
+)
+
+// fakeConfigMap is the API server of a single ConfigMap, applying the merge patches of its
+// data.
+type fakeConfigMap struct {
+	lock    sync.Mutex
+	data    map[string]string
+	created int
+	patched int
+}
+
+func (f *fakeConfigMap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var body struct {
+		Metadata struct {
+			Name        string            `json:"name"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+		Data map[string]*string `json:"data"`
+	}
+	Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+	switch {
+	case r.Method == http.MethodPatch && r.URL.Path == "/api/v1/namespaces/bee/configmaps/counters":
+		if f.data == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		Expect(r.Header.Get("Content-Type")).To(Equal("application/merge-patch+json"))
+		f.patched++
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/namespaces/bee/configmaps":
+		Expect(body.Metadata.Name).To(Equal("counters"))
+		Expect(body.Metadata.Annotations).To(HaveKeyWithValue("bee.solo.io/program", "tcpconnect"))
+		f.data = map[string]string{}
+		f.created++
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	for k, v := range body.Data {
+		if v == nil {
+			delete(f.data, k)
+		} else {
+			f.data[k] = *v
+		}
+	}
+}
+
+var _ = Describe("Sink", func() {
+	var (
+		server *httptest.Server
+		fake   *fakeConfigMap
+		ctx    = context.Background()
+	)
+
+	BeforeEach(func() {
+		fake = &fakeConfigMap{}
+		server = httptest.NewServer(fake)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newSink := func(maxSize int) *configmapsink.Sink {
+		sink, err := configmapsink.New(configmapsink.Opts{
+			ConfigMap: "bee/counters",
+			Maps:      []string{"connections"},
+			Program:   "tcpconnect",
+			MaxSize:   maxSize,
+			Config:    kube.Config{APIServer: server.URL},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		sink.NewHashMap("connections", []string{"daddr"})
+		sink.NewHashMap("sizes", []string{"pid"})
+		return sink
+	}
+
+	send := func(sink *configmapsink.Sink, name, daddr, value string) {
+		sink.SendEntry(v1.MapEntry{Name: name, Entry: v1.KvPair{Key: map[string]string{"daddr": daddr}, Value: value}})
+	}
+
+	It("writes the latest entries of the listed maps, creating the ConfigMap", func() {
+		sink := newSink(0)
+		send(sink, "connections", "10.0.0.1", "3")
+		send(sink, "connections", "10.0.0.2", "7")
+		send(sink, "sizes", "10.0.0.1", "1")
+		Expect(sink.Write(ctx)).To(Succeed())
+		Expect(fake.created).To(Equal(1))
+		Expect(fake.data["connections"]).To(Equal(`{"key":{"daddr":"10.0.0.2"},"value":"7"}` + "\n" + `{"key":{"daddr":"10.0.0.1"},"value":"3"}` + "\n"))
+		Expect(fake.data).NotTo(HaveKey("sizes"))
+		Expect(fake.data).To(HaveKey(configmapsink.UpdatedKey))
+
+		// unchanged snapshots are not written again
+		send(sink, "connections", "10.0.0.1", "3")
+		Expect(sink.Write(ctx)).To(Succeed())
+		Expect(fake.patched).To(Equal(0))
+		send(sink, "connections", "10.0.0.1", "9")
+		Expect(sink.Write(ctx)).To(Succeed())
+		Expect(fake.patched).To(Equal(1))
+		Expect(strings.Split(fake.data["connections"], "\n")[0]).To(Equal(`{"key":{"daddr":"10.0.0.1"},"value":"9"}`))
+	})
+
+	It("leaves the smallest values out of the snapshots exceeding the maximum size", func() {
+		sink := newSink(100)
+		send(sink, "connections", "10.0.0.1", "3")
+		send(sink, "connections", "10.0.0.2", "7")
+		send(sink, "connections", "10.0.0.3", "5")
+		Expect(sink.Write(ctx)).To(Succeed())
+		Expect(fake.data["connections"]).To(Equal(`{"key":{"daddr":"10.0.0.2"},"value":"7"}` + "\n" + `{"key":{"daddr":"10.0.0.3"},"value":"5"}` + "\n"))
+		Expect(fake.data[configmapsink.TruncatedKey]).To(HavePrefix("1 entries left out"))
+
+		sink.NewHashMap("connections", []string{"daddr"})
+		send(sink, "connections", "10.0.0.1", "3")
+		Expect(sink.Write(ctx)).To(Succeed())
+		Expect(fake.data).NotTo(HaveKey(configmapsink.TruncatedKey))
+	})
+
+	It("requires the maps and a valid ConfigMap", func() {
+		_, err := configmapsink.New(configmapsink.Opts{ConfigMap: "bee/counters", Config: kube.Config{APIServer: server.URL}})
+		Expect(err).To(MatchError(ContainSubstring("must be listed")))
+		_, err = configmapsink.New(configmapsink.Opts{ConfigMap: "bee/", Maps: []string{"connections"}, Config: kube.Config{APIServer: server.URL}})
+		Expect(err).To(MatchError(ContainSubstring("invalid ConfigMap")))
+	})
+})
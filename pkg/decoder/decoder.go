@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
@@ -16,8 +17,27 @@ const (
 	ipv4AddrTypeName = "ipv4_addr"
 	ipv6AddrTypeName = "ipv6_addr"
 	durationTypeName = "duration"
+
+	// TraceIDTypeName is the type of the fields holding a W3C trace context trace ID, as 16 bytes
+	TraceIDTypeName = "trace_id"
+	// SpanIDTypeName is the type of the fields holding a W3C trace context span ID, as 8 bytes
+	SpanIDTypeName = "span_id"
 )
 
+// TraceID is a decoded trace_id, printed as lowercase hex as in traceparent headers.
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID is a decoded span_id, printed as lowercase hex as in traceparent headers.
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
 type BinaryDecoder interface {
 	// DecodeBinaryStruct takes in a raw btf type, and translates
 	// raw binary data into a map[string]interface{} of that format.
@@ -105,6 +125,10 @@ func (d *decoder) processSingleType(typ btf.Type) (interface{}, error) {
 		}
 	case *btf.Typedef:
 		// Handle special types
+		switch typedMember.Name {
+		case TraceIDTypeName, SpanIDTypeName:
+			return d.handleID(typedMember)
+		}
 		underlying, err := getUnderlyingType(typedMember)
 		if err != nil {
 			return nil, err
@@ -164,6 +188,35 @@ func (d *decoder) handleArray(
 	return str, nil
 }
 
+// handleID decodes trace and span IDs, which are arrays of bytes
+func (d *decoder) handleID(typedMember *btf.Typedef) (interface{}, error) {
+	underlying, err := getUnderlyingType(typedMember)
+	if err != nil {
+		return nil, err
+	}
+	array, ok := underlying.(*btf.Array)
+	var size uint32 = 16
+	if typedMember.Name == SpanIDTypeName {
+		size = 8
+	}
+	if !ok || array.Nelems != size {
+		return nil, fmt.Errorf("%s must be an array of %d bytes", typedMember.Name, size)
+	}
+	if typInt, ok := array.Type.(*btf.Int); !ok || typInt.Size != 1 {
+		return nil, fmt.Errorf("%s must be an array of %d bytes", typedMember.Name, size)
+	}
+	raw := d.raw[d.offset : d.offset+size]
+	d.offset += size
+	if size == 8 {
+		var id SpanID
+		copy(id[:], raw)
+		return id, nil
+	}
+	var id TraceID
+	copy(id[:], raw)
+	return id, nil
+}
+
 func (d *decoder) handleFloat(
 	typedMember *btf.Float,
 ) (interface{}, error) {
@@ -255,6 +308,26 @@ func (d *decoder) handleInt(
 	return nil, errors.New("this should never happen")
 }
 
+// IsIPAddr returns whether the values of the type are decoded as IP addresses.
+func IsIPAddr(typ btf.Type) bool {
+	typedef, ok := typ.(*btf.Typedef)
+	return ok && (typedef.Name == ipv4AddrTypeName || typedef.Name == ipv6AddrTypeName)
+}
+
+// IsInteger returns whether the values of the type are decoded as integers, as opposed to
+// e.g. the ipv4_addr and duration typedefs of integers.
+func IsInteger(typ btf.Type) bool {
+	if typedef, ok := typ.(*btf.Typedef); ok {
+		switch typedef.Name {
+		case ipv4AddrTypeName, ipv6AddrTypeName, durationTypeName, TraceIDTypeName, SpanIDTypeName:
+			return false
+		}
+		typ, _ = getUnderlyingType(typedef)
+	}
+	typInt, ok := typ.(*btf.Int)
+	return ok && typInt.Encoding != btf.Bool && typInt.Encoding != btf.Char
+}
+
 func getUnderlyingType(tf *btf.Typedef) (btf.Type, error) {
 	switch typedMember := tf.Type.(type) {
 	case *btf.Typedef:
@@ -0,0 +1,45 @@
+package decoder_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf/btf"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+)
+
+// BenchmarkDecode decodes events shaped like the ones of tcpconnect.
+func BenchmarkDecode(b *testing.B) {
+	u32 := &btf.Int{Name: "u32", Size: 4, Bits: 32}
+	u64 := &btf.Int{Name: "u64", Size: 8, Bits: 64}
+	char := &btf.Int{Name: "char", Size: 1, Bits: 8, Encoding: btf.Char}
+	ipv4 := &btf.Typedef{Name: "ipv4_addr", Type: u32}
+	event := &btf.Struct{Name: "event_t", Size: 36, Members: []btf.Member{
+		{Name: "saddr", Type: ipv4},
+		{Name: "daddr", Type: ipv4},
+		{Name: "pid", Type: u32},
+		{Name: "ts", Type: u64, OffsetBits: 96},
+		{Name: "comm", Type: &btf.Array{Type: char, Nelems: 16}, OffsetBits: 160},
+	}}
+	raw := []byte{
+		10, 0, 0, 1,
+		10, 0, 0, 2,
+		42, 0, 0, 0,
+		0, 1, 0, 0, 0, 0, 0, 0,
+		'c', 'u', 'r', 'l', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	ctx := context.Background()
+	d := decoder.NewDecoderFactory()()
+	b.SetBytes(int64(len(raw)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if _, err := d.DecodeBtfBinary(ctx, event, raw); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "events/s")
+}
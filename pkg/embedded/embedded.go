@@ -0,0 +1,151 @@
+// Package embedded runs the eBPF packages embedded in a Go application as bundles, e.g. with
+// go:embed, without a registry nor the network, for the appliances shipped as a single binary:
+//
+//	//go:embed bundles/*.tar
+//	var bundles embed.FS
+//
+//	pkgs, err := embedded.Open(ctx, bundles, embedded.Options{Patterns: []string{"bundles/*.tar"}})
+//	...
+//	defer pkgs.Close()
+//	err = pkgs.RunStack(ctx, stack, stack.RunOptions{})
+//
+// The bundles are written by `bee bundle export`, and extracted into a local store the packages
+// are pulled from offline, so they run through the same loader as the ones of a registry.
+package embedded
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"github.com/solo-io/bumblebee/pkg/stack"
+	"oras.land/oras-go/pkg/content"
+)
+
+// DefaultPattern matches the bundles of the file system when no pattern is given.
+const DefaultPattern = "*.tar"
+
+// Options configure how the embedded packages are extracted and pulled.
+type Options struct {
+	// Globs of the bundles in the file system, as taken by fs.Glob, DefaultPattern if empty
+	Patterns []string
+	// Directory of the local store the bundles are extracted into, a temporary directory
+	// removed by Close if empty
+	Dir string
+	// Variant pulled from multi-variant bundles, the one of the host by default
+	Variant spec.VariantSelector
+	// Verifier of the signatures of the packages, which must be embedded in the store along
+	// with them, e.g. with Dir. Packages are not verified if nil.
+	Verifier *spec.Verifier
+}
+
+// Packages are the packages embedded in an application, by the ref they were exported with.
+type Packages struct {
+	// Registry pulls the packages from the store they were extracted into, offline
+	Registry *spec.LocalRegistry
+
+	refs map[string]*spec.BundleInfo
+	// temporary directory of the store, if any
+	tmpDir string
+}
+
+// Open verifies the bundles of the file system matching the patterns, and extracts their
+// packages into a local store. A bundle failing its verification or missing its ref fails, as
+// does a ref embedded twice.
+func Open(ctx context.Context, fsys fs.FS, opts Options) (*Packages, error) {
+	patterns := opts.Patterns
+	if len(patterns) == 0 {
+		patterns = []string{DefaultPattern}
+	}
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no bundle matches %v", patterns)
+	}
+
+	p := &Packages{refs: map[string]*spec.BundleInfo{}}
+	dir := opts.Dir
+	if dir == "" {
+		var err error
+		if dir, err = ioutil.TempDir("", "bee-embedded"); err != nil {
+			return nil, err
+		}
+		p.tmpDir = dir
+	}
+	for _, file := range files {
+		info, err := importBundle(ctx, fsys, dir, file)
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("could not extract the bundle %s: %w", file, err)
+		}
+		if _, ok := p.refs[info.Ref]; ok {
+			p.Close()
+			return nil, fmt.Errorf("the bundle %s embeds %s again", file, info.Ref)
+		}
+		p.refs[info.Ref] = info
+	}
+
+	p.Registry = spec.NewLocalRegistry(dir, content.RegistryOptions{})
+	p.Registry.Offline = true
+	p.Registry.Variant = opts.Variant
+	p.Registry.Verifier = opts.Verifier
+	return p, nil
+}
+
+func importBundle(ctx context.Context, fsys fs.FS, dir, file string) (*spec.BundleInfo, error) {
+	f, err := fsys.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return spec.ImportBundle(ctx, dir, f, "")
+}
+
+// Refs returns the refs of the packages, sorted.
+func (p *Packages) Refs() []string {
+	refs := make([]string, 0, len(p.refs))
+	for ref := range p.refs {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// Bundle returns the verified bundle of the package of the ref, nil if it is not embedded.
+func (p *Packages) Bundle(ref string) *spec.BundleInfo {
+	return p.refs[ref]
+}
+
+// Pull returns the package of the ref, e.g. to run its program with the loader.
+func (p *Packages) Pull(ctx context.Context, ref string) (*v1.EbpfPackage, error) {
+	if _, ok := p.refs[ref]; !ok {
+		return nil, fmt.Errorf("%s is not embedded, the embedded packages are %v", ref, p.Refs())
+	}
+	return p.Registry.Pull(ctx, ref, nil)
+}
+
+// RunStack runs the stack with the embedded packages, as stack.Run, its programs referencing
+// them by their refs. Programs referencing files are read from the disk as usual.
+func (p *Packages) RunStack(ctx context.Context, s *stack.Stack, opts stack.RunOptions) error {
+	opts.Registry = p.Registry
+	return s.Run(ctx, opts)
+}
+
+// Close removes the store the bundles were extracted into, if it is temporary.
+func (p *Packages) Close() error {
+	if p.tmpDir == "" {
+		return nil
+	}
+	return os.RemoveAll(p.tmpDir)
+}
@@ -0,0 +1,85 @@
+package embedded_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing/fstest"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/embedded"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"oras.land/oras-go/pkg/content"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("embedded packages", func() {
+	var (
+		ctx     context.Context
+		bundles fstest.MapFS
+	)
+
+	// bundle exports a package pushed to a temporary store
+	bundle := func(ref, program string) []byte {
+		dir, err := os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		store, err := content.NewOCI(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.NewEbpfOCICLient().Push(ctx, ref, store, &v1.EbpfPackage{ProgramFileBytes: []byte(program)})).To(Succeed())
+		var b bytes.Buffer
+		Expect(spec.ExportBundle(ctx, dir, ref, &b)).To(Succeed())
+		return b.Bytes()
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		bundles = fstest.MapFS{
+			"bundles/tcpconnect.tar": {Data: bundle("localhost:5000/embedded/tcpconnect:v1", "tcpconnect program")},
+			"bundles/exitsnoop.tar":  {Data: bundle("localhost:5000/embedded/exitsnoop:v1", "exitsnoop program")},
+			"README.md":              {Data: []byte("not a bundle")},
+		}
+	})
+
+	It("pulls the packages of the bundles offline", func() {
+		pkgs, err := embedded.Open(ctx, bundles, embedded.Options{Patterns: []string{"bundles/*.tar"}})
+		Expect(err).NotTo(HaveOccurred())
+		defer pkgs.Close()
+		Expect(pkgs.Refs()).To(Equal([]string{"localhost:5000/embedded/exitsnoop:v1", "localhost:5000/embedded/tcpconnect:v1"}))
+		Expect(pkgs.Registry.Offline).To(BeTrue())
+
+		pkg, err := pkgs.Pull(ctx, "localhost:5000/embedded/tcpconnect:v1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pkg.ProgramFileBytes).To(Equal([]byte("tcpconnect program")))
+		Expect(pkgs.Bundle("localhost:5000/embedded/tcpconnect:v1").Blobs).To(HaveLen(3))
+
+		_, err = pkgs.Pull(ctx, "localhost:5000/embedded/other:v1")
+		Expect(err).To(MatchError(ContainSubstring("is not embedded")))
+	})
+
+	It("keeps the store of the given directory once closed", func() {
+		dir, err := os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		pkgs, err := embedded.Open(ctx, bundles, embedded.Options{Patterns: []string{"bundles/*.tar"}, Dir: dir})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pkgs.Close()).To(Succeed())
+		_, err = os.Stat(dir + "/index.json")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("rejects tampered bundles, refs embedded twice and patterns matching nothing", func() {
+		bundles["bundles/tampered.tar"] = &fstest.MapFile{Data: bytes.Replace(bundles["bundles/exitsnoop.tar"].Data, []byte("exitsnoop program"), []byte("EXITSNOOP program"), 1)}
+		_, err := embedded.Open(ctx, bundles, embedded.Options{Patterns: []string{"bundles/tampered.tar"}})
+		Expect(err).To(MatchError(ContainSubstring("does not match its digest")))
+
+		bundles["bundles/again.tar"] = &fstest.MapFile{Data: bundles["bundles/exitsnoop.tar"].Data}
+		_, err = embedded.Open(ctx, bundles, embedded.Options{Patterns: []string{"bundles/again.tar", "bundles/exitsnoop.tar"}})
+		Expect(err).To(MatchError("the bundle bundles/exitsnoop.tar embeds localhost:5000/embedded/exitsnoop:v1 again"))
+
+		_, err = embedded.Open(ctx, bundles, embedded.Options{})
+		Expect(err).To(MatchError("no bundle matches [*.tar]"))
+	})
+})
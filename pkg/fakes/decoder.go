@@ -0,0 +1,53 @@
+package fakes
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cilium/ebpf/btf"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+)
+
+var _ decoder.BinaryDecoder = &Decoder{}
+
+// Decoder is a decoder.BinaryDecoder returning canned values. DecodeFunc is called if set,
+// otherwise the values are returned in order, the last one being repeated.
+type Decoder struct {
+	DecodeFunc func(ctx context.Context, typ btf.Type, raw []byte) (map[string]interface{}, error)
+	Values     []map[string]interface{}
+
+	lock  sync.Mutex
+	calls int
+}
+
+func (d *Decoder) DecodeBtfBinary(ctx context.Context, typ btf.Type, raw []byte) (map[string]interface{}, error) {
+	d.lock.Lock()
+	idx := d.calls
+	d.calls++
+	d.lock.Unlock()
+
+	if d.DecodeFunc != nil {
+		return d.DecodeFunc(ctx, typ, raw)
+	}
+	if len(d.Values) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	if idx >= len(d.Values) {
+		idx = len(d.Values) - 1
+	}
+	return d.Values[idx], nil
+}
+
+// Factory returns a DecoderFactory always returning this decoder.
+func (d *Decoder) Factory() decoder.DecoderFactory {
+	return func() decoder.BinaryDecoder {
+		return d
+	}
+}
+
+// Calls returns the number of values decoded.
+func (d *Decoder) Calls() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.calls
+}
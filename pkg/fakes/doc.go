@@ -0,0 +1,12 @@
+// Package fakes holds in-memory implementations of the interfaces of the major subsystems,
+// so code integrating with bee can be unit tested without a kernel, a registry or a terminal.
+//
+//	Registry        spec.EbpfOCICLient
+//	NewStore        target.Target, used for registries and the local package store
+//	Loader          loader.Loader
+//	Decoder         decoder.BinaryDecoder
+//	Sink            v1.MapWatcher
+//	MetricsProvider stats.MetricsProvider
+//
+// All fakes are safe for concurrent use.
+package fakes
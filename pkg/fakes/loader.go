@@ -0,0 +1,57 @@
+package fakes
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/cilium/ebpf"
+	"github.com/solo-io/bumblebee/pkg/loader"
+)
+
+var _ loader.Loader = &Loader{}
+
+// Loader is a loader.Loader which doesn't touch the kernel. Each method calls the matching
+// func if set, and otherwise succeeds; Load sends Entries to the watcher of the options.
+type Loader struct {
+	ParseFunc     func(ctx context.Context, reader io.ReaderAt) (*loader.ParsedELF, error)
+	LoadFunc      func(ctx context.Context, opts *loader.LoadOptions) error
+	WatchMapsFunc func(ctx context.Context, opts *loader.LoadOptions, coll map[string]*ebpf.Map) error
+
+	lock  sync.Mutex
+	loads []*loader.LoadOptions
+}
+
+func (l *Loader) Parse(ctx context.Context, reader io.ReaderAt) (*loader.ParsedELF, error) {
+	if l.ParseFunc != nil {
+		return l.ParseFunc(ctx, reader)
+	}
+	return &loader.ParsedELF{
+		Spec:        &ebpf.CollectionSpec{},
+		WatchedMaps: map[string]loader.WatchedMap{},
+	}, nil
+}
+
+func (l *Loader) Load(ctx context.Context, opts *loader.LoadOptions) error {
+	l.lock.Lock()
+	l.loads = append(l.loads, opts)
+	l.lock.Unlock()
+	if l.LoadFunc != nil {
+		return l.LoadFunc(ctx, opts)
+	}
+	return nil
+}
+
+func (l *Loader) WatchMaps(ctx context.Context, opts *loader.LoadOptions, coll map[string]*ebpf.Map) error {
+	if l.WatchMapsFunc != nil {
+		return l.WatchMapsFunc(ctx, opts, coll)
+	}
+	return nil
+}
+
+// Loads returns the options of every call to Load so far.
+func (l *Loader) Loads() []*loader.LoadOptions {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]*loader.LoadOptions{}, l.loads...)
+}
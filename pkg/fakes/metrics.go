@@ -0,0 +1,97 @@
+package fakes
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/solo-io/bumblebee/pkg/stats"
+)
+
+var _ stats.MetricsProvider = &MetricsProvider{}
+
+// MetricsProvider is a stats.MetricsProvider keeping the values of its metrics in memory,
+// by metric name and labels.
+type MetricsProvider struct {
+	lock    sync.Mutex
+	metrics map[string]map[string]int64
+}
+
+func NewMetricsProvider() *MetricsProvider {
+	return &MetricsProvider{metrics: map[string]map[string]int64{}}
+}
+
+func (m *MetricsProvider) NewSetCounter(opts *stats.MetricOpts) stats.SetInstrument {
+	return m.instrument(opts.Name)
+}
+
+func (m *MetricsProvider) NewIncrementCounter(opts *stats.MetricOpts) stats.IncrementInstrument {
+	return m.instrument(opts.Name)
+}
+
+func (m *MetricsProvider) NewGauge(opts *stats.MetricOpts) stats.SetInstrument {
+	return m.instrument(opts.Name)
+}
+
+func (m *MetricsProvider) Cardinality() []stats.SeriesCardinality {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var res []stats.SeriesCardinality
+	for name, series := range m.metrics {
+		res = append(res, stats.SeriesCardinality{Name: name, Series: len(series)})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// Value returns the current value of the series of the metric with the given labels.
+func (m *MetricsProvider) Value(name string, labels map[string]string) (int64, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	val, ok := m.metrics[name][labelsKey(labels)]
+	return val, ok
+}
+
+func (m *MetricsProvider) instrument(name string) *instrument {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.metrics[name] == nil {
+		m.metrics[name] = map[string]int64{}
+	}
+	return &instrument{provider: m, name: name}
+}
+
+type instrument struct {
+	provider *MetricsProvider
+	name     string
+}
+
+func (i *instrument) Increment(ctx context.Context, labels map[string]string) {
+	i.provider.lock.Lock()
+	defer i.provider.lock.Unlock()
+	i.provider.metrics[i.name][labelsKey(labels)]++
+}
+
+func (i *instrument) Set(ctx context.Context, val int64, labels map[string]string) {
+	i.provider.lock.Lock()
+	defer i.provider.lock.Unlock()
+	i.provider.metrics[i.name][labelsKey(labels)] = val
+}
+
+func (i *instrument) Delete(ctx context.Context, labels map[string]string) {
+	i.provider.lock.Lock()
+	defer i.provider.lock.Unlock()
+	delete(i.provider.metrics[i.name], labelsKey(labels))
+}
+
+func labelsKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
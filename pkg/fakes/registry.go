@@ -0,0 +1,154 @@
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/target"
+)
+
+var _ spec.EbpfOCICLient = &Registry{}
+
+// Registry is an EbpfOCICLient keeping pushed packages in memory, by reference.
+// The target passed to its methods is ignored.
+type Registry struct {
+	// Err, if set, is returned by every call
+	Err error
+
+	lock     sync.Mutex
+	packages map[string][]*v1.EbpfPackage
+	pulls    []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{packages: map[string][]*v1.EbpfPackage{}}
+}
+
+func (r *Registry) Push(ctx context.Context, ref string, registry target.Target, pkg *v1.EbpfPackage) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.Err != nil {
+		return r.Err
+	}
+	r.packages[ref] = []*v1.EbpfPackage{pkg}
+	return nil
+}
+
+func (r *Registry) PushMultiArch(ctx context.Context, ref string, registry target.Target, pkgs []*v1.EbpfPackage) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.Err != nil {
+		return r.Err
+	}
+	for _, pkg := range pkgs {
+		if pkg.Platform == nil || pkg.Platform.Architecture == "" {
+			return fmt.Errorf("the packages of a multi-arch image must have an architecture")
+		}
+	}
+	r.packages[ref] = append([]*v1.EbpfPackage{}, pkgs...)
+	return nil
+}
+
+// PushVariants keeps the packages of the variants, with their minimum kernel release.
+func (r *Registry) PushVariants(ctx context.Context, ref string, registry target.Target, variants []v1.EbpfPackageVariant) error {
+	pkgs := make([]*v1.EbpfPackage, 0, len(variants))
+	for _, variant := range variants {
+		if variant.Package == nil {
+			return fmt.Errorf("the variants of a multi-variant image must have a package")
+		}
+		pkg := *variant.Package
+		pkg.MinKernelVersion = variant.MinKernelVersion
+		pkgs = append(pkgs, &pkg)
+	}
+	return r.PushMultiArch(ctx, ref, registry, pkgs)
+}
+
+// Pull returns the package pushed to the reference, for multi-arch references the one
+// of the current architecture.
+func (r *Registry) Pull(ctx context.Context, ref string, registry target.Target) (*v1.EbpfPackage, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.pulls = append(r.pulls, ref)
+	return r.lookup(ref)
+}
+
+// lookup returns the package of the reference, the lock being held.
+func (r *Registry) lookup(ref string) (*v1.EbpfPackage, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	pkgs, ok := r.packages[ref]
+	if !ok {
+		return nil, fmt.Errorf("%s: not found", ref)
+	}
+	if len(pkgs) == 1 && (pkgs[0].Platform == nil || pkgs[0].Platform.Architecture == "") {
+		return pkgs[0], nil
+	}
+	for _, pkg := range pkgs {
+		if pkg.Platform != nil && pkg.Platform.Architecture == runtime.GOARCH {
+			return pkg, nil
+		}
+	}
+	return nil, fmt.Errorf("%s has no package for architecture %s", ref, runtime.GOARCH)
+}
+
+// List returns the tags pushed to the repository, sorted.
+func (r *Registry) List(ctx context.Context, repoRef string, registry target.Target) ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	var tags []string
+	for ref := range r.packages {
+		if strings.HasPrefix(ref, repoRef+":") {
+			tags = append(tags, strings.TrimPrefix(ref, repoRef+":"))
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// Inspect returns the description, authors and config of the package pushed to the reference.
+// It has no descriptors, as packages are not stored as blobs, and is not recorded as a pull.
+func (r *Registry) Inspect(ctx context.Context, ref string, registry target.Target) (*v1.EbpfPackageInfo, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	pkg, err := r.lookup(ref)
+	if err != nil {
+		return nil, err
+	}
+	annotations := map[string]string{}
+	if pkg.Description != "" {
+		annotations[ocispec.AnnotationDescription] = pkg.Description
+	}
+	if pkg.Authors != "" {
+		annotations[ocispec.AnnotationAuthors] = pkg.Authors
+	}
+	return &v1.EbpfPackageInfo{
+		Manifest:    ocispec.Descriptor{Digest: pkg.Digest, Platform: pkg.Platform},
+		Annotations: annotations,
+		EbpfConfig:  pkg.EbpfConfig,
+	}, nil
+}
+
+// Pulls returns the references pulled so far, in order.
+func (r *Registry) Pulls() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string{}, r.pulls...)
+}
+
+// NewStore returns an in-memory target, which can be used in place of a remote registry
+// or of the local package store with the real spec.EbpfOCICLient.
+func NewStore() *content.Memory {
+	return content.NewMemory()
+}
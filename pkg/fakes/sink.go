@@ -0,0 +1,90 @@
+package fakes
+
+import (
+	"sync"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+var _ v1.MapWatcher = &Sink{}
+
+// Sink is a MapWatcher recording everything it is sent.
+type Sink struct {
+	lock     sync.Mutex
+	ringBufs map[string][]string
+	hashMaps map[string][]string
+	entries  []v1.MapEntry
+	closed   bool
+}
+
+func NewSink() *Sink {
+	return &Sink{
+		ringBufs: map[string][]string{},
+		hashMaps: map[string][]string{},
+	}
+}
+
+func (s *Sink) NewRingBuf(name string, keys []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ringBufs[name] = keys
+}
+
+func (s *Sink) NewHashMap(name string, keys []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.hashMaps[name] = keys
+}
+
+func (s *Sink) SendEntry(entry v1.MapEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entries = append(s.entries, entry)
+}
+
+func (s *Sink) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+}
+
+// RingBufs returns the keys of the ring buffers declared, by name.
+func (s *Sink) RingBufs() map[string][]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return copyKeys(s.ringBufs)
+}
+
+// HashMaps returns the keys of the hash maps declared, by name.
+func (s *Sink) HashMaps() map[string][]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return copyKeys(s.hashMaps)
+}
+
+// Entries returns the entries sent so far, optionally only those of the given map.
+func (s *Sink) Entries(name string) []v1.MapEntry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var entries []v1.MapEntry
+	for _, entry := range s.entries {
+		if name == "" || entry.Name == name {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func (s *Sink) Closed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.closed
+}
+
+func copyKeys(maps map[string][]string) map[string][]string {
+	res := make(map[string][]string, len(maps))
+	for name, keys := range maps {
+		res[name] = keys
+	}
+	return res
+}
@@ -0,0 +1,187 @@
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+const (
+	// Unknown is the country, ASN or organization of the addresses the databases have no
+	// record of, e.g. private addresses
+	Unknown = "unknown"
+
+	defaultPollInterval = 10 * time.Second
+)
+
+// Info is what the databases know of an IP address.
+type Info struct {
+	// ISO 3166-1 code of the country, e.g. US
+	Country string
+	// Autonomous system number, e.g. AS15169
+	ASN string
+	// Organization of the autonomous system
+	Org string
+}
+
+// DB looks up IP addresses in MaxMind DB files, e.g. the GeoLite2 Country and ASN databases of
+// MaxMind or the databases of ipinfo, each address being looked up in all of them. The files
+// are reloaded once they change, as when they are updated by geoipupdate.
+type DB struct {
+	paths    []string
+	interval time.Duration
+
+	lock  sync.RWMutex
+	dbs   []*mmdb
+	stats []fileStat
+}
+
+// fileStat tells a database file changed, the files being too large to hash on every poll.
+type fileStat struct {
+	size    int64
+	modTime time.Time
+}
+
+// Open opens the databases, failing if one of them is not a valid MaxMind DB file.
+func Open(paths ...string) (*DB, error) {
+	db := &DB{paths: paths, interval: defaultPollInterval}
+	if _, err := db.reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Start reloads the databases whenever one of them changes until the context is done, or
+// immediately on SIGHUP. Databases failing to load are logged, the previous ones being kept.
+func (db *DB) Start(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(db.interval)
+		defer ticker.Stop()
+		logger := contextutils.LoggerFrom(ctx)
+		for {
+			select {
+			case <-ticker.C:
+			case <-hup:
+			case <-ctx.Done():
+				return
+			}
+			changed, err := db.reload()
+			if err != nil {
+				logger.Errorf("could not reload geoip databases, keeping the previous ones: %v", err)
+			} else if changed {
+				logger.Infof("reloaded geoip databases %s", strings.Join(db.paths, ", "))
+			}
+		}
+	}()
+}
+
+// reload loads the databases if one of them changed since the last reload, and returns
+// whether it did.
+func (db *DB) reload() (bool, error) {
+	stats := make([]fileStat, len(db.paths))
+	for i, path := range db.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, fmt.Errorf("could not read geoip database: %w", err)
+		}
+		stats[i] = fileStat{size: info.Size(), modTime: info.ModTime()}
+	}
+	db.lock.RLock()
+	unchanged := db.dbs != nil
+	for i := range stats {
+		unchanged = unchanged && stats[i] == db.stats[i]
+	}
+	db.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	dbs := make([]*mmdb, len(db.paths))
+	for i, path := range db.paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return false, fmt.Errorf("could not read geoip database: %w", err)
+		}
+		dbs[i], err = parseMMDB(data)
+		if err != nil {
+			return false, fmt.Errorf("could not parse geoip database %s: %w", path, err)
+		}
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.dbs, db.stats = dbs, stats
+	return true, nil
+}
+
+// Lookup returns what the databases know of an IP address, the fields they don't know being
+// Unknown. The first database knowing a field wins.
+func (db *DB) Lookup(ip net.IP) Info {
+	info := Info{}
+	db.lock.RLock()
+	dbs := db.dbs
+	db.lock.RUnlock()
+	for _, mmdb := range dbs {
+		record, err := mmdb.lookup(ip)
+		if err != nil {
+			continue
+		}
+		m, _ := record.(map[string]interface{})
+		if info.Country == "" {
+			info.Country = countryOf(m)
+		}
+		if info.ASN == "" {
+			info.ASN, info.Org = asnOf(m)
+		}
+	}
+	if info.Country == "" {
+		info.Country = Unknown
+	}
+	if info.ASN == "" {
+		info.ASN = Unknown
+	}
+	if info.Org == "" {
+		info.Org = Unknown
+	}
+	return info
+}
+
+// countryOf returns the country of a record, under country.iso_code in the MaxMind
+// databases and country in the ipinfo ones.
+func countryOf(record map[string]interface{}) string {
+	switch country := record["country"].(type) {
+	case string:
+		return country
+	case map[string]interface{}:
+		code, _ := country["iso_code"].(string)
+		return code
+	}
+	return ""
+}
+
+// asnOf returns the ASN and organization of a record, under autonomous_system_number and
+// autonomous_system_organization in the MaxMind databases, and asn and as_name in the
+// ipinfo ones.
+func asnOf(record map[string]interface{}) (string, string) {
+	if n, ok := record["autonomous_system_number"].(uint64); ok {
+		org, _ := record["autonomous_system_organization"].(string)
+		return "AS" + strconv.FormatUint(n, 10), org
+	}
+	if asn, ok := record["asn"].(string); ok && asn != "" {
+		org, _ := record["as_name"].(string)
+		return asn, org
+	}
+	return "", ""
+}
@@ -0,0 +1,13 @@
+package geoip
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestGeoIP(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "GeoIP Suite")
+}
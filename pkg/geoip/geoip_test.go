@@ -0,0 +1,195 @@
+package geoip
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// field encodings of the data section
+func encodeString(s string) []byte {
+	if len(s) >= 29 {
+		return append([]byte{typeString<<5 | 29, byte(len(s) - 29)}, s...)
+	}
+	return append([]byte{byte(typeString<<5 | len(s))}, s...)
+}
+
+func encodeUint(typ int, n uint32) []byte {
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{byte(typ<<5 | len(b))}, b...)
+}
+
+func encodePointer(offset int) []byte {
+	return []byte{byte(typePointer<<5 | offset>>8&0x7), byte(offset)}
+}
+
+// encodeMap encodes the map with its values already encoded, by sorted keys.
+func encodeMap(m map[string][]byte) []byte {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	b := []byte{byte(typeMap<<5 | len(m))}
+	for _, key := range keys {
+		b = append(append(b, encodeString(key)...), m[key]...)
+	}
+	return b
+}
+
+// network is a network of a test database, and its encoded record.
+type network struct {
+	cidr   string
+	record []byte
+}
+
+// writeMMDB returns a database of record size 24 of the networks, the records being at
+// their offset in the data section, so they can point at the records before them.
+func writeMMDB(ipVersion int, networks ...network) []byte {
+	var data []byte
+	offsets := make([]int, len(networks))
+	for i, n := range networks {
+		offsets[i] = len(data)
+		data = append(data, n.record...)
+	}
+
+	// the search tree, -1 records being empty
+	tree := [][2]int{{-1, -1}}
+	leaves := map[[2]int]int{}
+	for i, n := range networks {
+		ip, ipNet, err := net.ParseCIDR(n.cidr)
+		Expect(err).NotTo(HaveOccurred())
+		ones, bits := ipNet.Mask.Size()
+		if ipv4 := ip.To4(); ipv4 != nil {
+			ip = ipv4
+			if ipVersion == 6 {
+				ip = append(make(net.IP, 12), ipv4...)
+				ones, bits = ones+96, 128
+			}
+		}
+		Expect(bits).To(Equal(len(ip) * 8))
+		node := 0
+		for b := 0; b < ones; b++ {
+			bit := int(ip[b>>3]>>(7-uint(b&7))) & 1
+			if b == ones-1 {
+				leaves[[2]int{node, bit}] = i
+				break
+			}
+			if tree[node][bit] < 0 {
+				tree = append(tree, [2]int{-1, -1})
+				tree[node][bit] = len(tree) - 1
+			}
+			node = tree[node][bit]
+		}
+	}
+	var db []byte
+	for node, records := range tree {
+		for bit, record := range records {
+			value := len(tree)
+			if i, ok := leaves[[2]int{node, bit}]; ok {
+				value = len(tree) + dataSectionSeparator + offsets[i]
+			} else if record >= 0 {
+				value = record
+			}
+			db = append(db, byte(value>>16), byte(value>>8), byte(value))
+		}
+	}
+	db = append(db, make([]byte, dataSectionSeparator)...)
+	db = append(db, data...)
+	db = append(db, metadataMarker...)
+	return append(db, encodeMap(map[string][]byte{
+		"node_count":    encodeUint(typeUint32, uint32(len(tree))),
+		"record_size":   encodeUint(typeUint16, 24),
+		"ip_version":    encodeUint(typeUint16, uint32(ipVersion)),
+		"database_type": encodeString("Test"),
+	})...)
+}
+
+var _ = Describe("geoip", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "geoip")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		Expect(ioutil.WriteFile(path, data, 0644)).To(Succeed())
+		return path
+	}
+
+	It("looks up the country and ASN of addresses in the MaxMind databases", func() {
+		country := write("country.mmdb", writeMMDB(6,
+			network{"1.2.3.0/24", encodeMap(map[string][]byte{"country": encodeMap(map[string][]byte{"iso_code": encodeString("AU")})})},
+			network{"2a00:1450::/32", encodeMap(map[string][]byte{"country": encodeMap(map[string][]byte{"iso_code": encodeString("IE")})})},
+		))
+		asn := write("asn.mmdb", writeMMDB(4,
+			network{"1.2.0.0/16", encodeMap(map[string][]byte{
+				"autonomous_system_number":       encodeUint(typeUint32, 13335),
+				"autonomous_system_organization": encodeString("Cloudflare"),
+			})},
+		))
+		db, err := Open(country, asn)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(db.Lookup(net.ParseIP("1.2.3.4"))).To(Equal(Info{Country: "AU", ASN: "AS13335", Org: "Cloudflare"}))
+		Expect(db.Lookup(net.ParseIP("1.2.4.4"))).To(Equal(Info{Country: Unknown, ASN: "AS13335", Org: "Cloudflare"}))
+		Expect(db.Lookup(net.ParseIP("2a00:1450::1"))).To(Equal(Info{Country: "IE", ASN: Unknown, Org: Unknown}))
+		Expect(db.Lookup(net.ParseIP("10.0.0.1"))).To(Equal(Info{Country: Unknown, ASN: Unknown, Org: Unknown}))
+	})
+
+	It("looks up the addresses in the ipinfo databases", func() {
+		google := encodeMap(map[string][]byte{
+			"country": encodeString("US"),
+			"asn":     encodeString("AS15169"),
+			"as_name": encodeString("Google LLC"),
+		})
+		path := write("ipinfo.mmdb", writeMMDB(6,
+			network{"8.8.8.0/24", google},
+			network{"2001:4860::/32", encodePointer(0)},
+		))
+		db, err := Open(path)
+		Expect(err).NotTo(HaveOccurred())
+		info := Info{Country: "US", ASN: "AS15169", Org: "Google LLC"}
+		Expect(db.Lookup(net.ParseIP("8.8.8.8"))).To(Equal(info))
+		Expect(db.Lookup(net.ParseIP("2001:4860::8888"))).To(Equal(info))
+	})
+
+	It("reloads the databases once they change, keeping the previous ones if invalid", func() {
+		record := func(code string) []byte {
+			return encodeMap(map[string][]byte{"country": encodeString(code)})
+		}
+		path := write("ipinfo.mmdb", writeMMDB(4, network{"1.2.3.0/24", record("AU")}))
+		db, err := Open(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.reload()).To(BeFalse())
+
+		write("ipinfo.mmdb", writeMMDB(4, network{"1.2.3.0/24", record("NZ")}))
+		Expect(os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))).To(Succeed())
+		Expect(db.reload()).To(BeTrue())
+		Expect(db.Lookup(net.ParseIP("1.2.3.4")).Country).To(Equal("NZ"))
+
+		write("ipinfo.mmdb", []byte("not a database"))
+		_, err = db.reload()
+		Expect(err).To(MatchError(ContainSubstring("not a MaxMind DB file")))
+		Expect(db.Lookup(net.ParseIP("1.2.3.4")).Country).To(Equal("NZ"))
+
+		_, err = Open(filepath.Join(dir, "missing.mmdb"))
+		Expect(err).To(MatchError(ContainSubstring("could not read geoip database")))
+	})
+})
@@ -0,0 +1,312 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+)
+
+// metadataMarker starts the metadata of MaxMind DB files, in their last 128KiB.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+const (
+	maxMetadataSize = 128 << 10
+	// zero bytes between the search tree and the data section
+	dataSectionSeparator = 16
+)
+
+// the types of the fields of the data section
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// mmdb is a database in the MaxMind DB format, as published by MaxMind and ipinfo.
+// See https://maxmind.github.io/MaxMind-DB/ for the format.
+type mmdb struct {
+	data         []byte
+	nodeCount    uint
+	recordSize   uint
+	ipVersion    uint
+	databaseType string
+	// the data section, the records pointed to by the search tree
+	records []byte
+	// node of ::/96, the IPv4 tree of IPv6 databases
+	ipv4Start uint
+}
+
+func parseMMDB(data []byte) (*mmdb, error) {
+	from := len(data) - maxMetadataSize
+	if from < 0 {
+		from = 0
+	}
+	at := bytes.LastIndex(data[from:], metadataMarker)
+	if at < 0 {
+		return nil, errors.New("not a MaxMind DB file, no metadata found")
+	}
+	metadataStart := from + at + len(metadataMarker)
+	value, _, err := (&decoder{buf: data[metadataStart:]}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid metadata: not a map")
+	}
+	db := &mmdb{data: data}
+	db.nodeCount = uintField(metadata, "node_count")
+	db.recordSize = uintField(metadata, "record_size")
+	db.ipVersion = uintField(metadata, "ip_version")
+	db.databaseType, _ = metadata["database_type"].(string)
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", db.ipVersion)
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(from+at) {
+		return nil, fmt.Errorf("the search tree of %d nodes is larger than the file", db.nodeCount)
+	}
+	db.records = data[treeSize+dataSectionSeparator : from+at]
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.readNode(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+func uintField(m map[string]interface{}, key string) uint {
+	n, _ := m[key].(uint64)
+	return uint(n)
+}
+
+// lookup returns the record of the network of an IP address, nil if it has none.
+func (db *mmdb) lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	bits := 128
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip, bits = ipv4, 32
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if db.ipVersion == 4 {
+		// IPv6 addresses are not in IPv4 databases
+		return nil, nil
+	}
+	for i := 0; i < bits && node < db.nodeCount; i++ {
+		bit := uint(ip[i>>3]>>(7-uint(i&7))) & 1
+		node = db.readNode(node, bit)
+	}
+	if node == db.nodeCount {
+		return nil, nil
+	}
+	if node < db.nodeCount {
+		return nil, errors.New("invalid search tree, the address is not a leaf")
+	}
+	offset := node - db.nodeCount - dataSectionSeparator
+	if offset >= uint(len(db.records)) {
+		return nil, errors.New("invalid search tree, the record is out of the data section")
+	}
+	value, _, err := (&decoder{buf: db.records}).decode(offset)
+	return value, err
+}
+
+// readNode returns the left (0) or right (1) record of a node of the search tree.
+func (db *mmdb) readNode(node, bit uint) uint {
+	b := db.data[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// decoder decodes the fields of a data section, pointers being offsets in the section.
+type decoder struct {
+	buf []byte
+}
+
+// decode returns the field at the offset and the offset following it.
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ == typePointer {
+		target, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(target)
+		return value, next, err
+	}
+	return d.decodeValue(typ, size, offset)
+}
+
+// control reads the control byte of a field, and returns its type and size, the size of
+// pointers being the control byte itself.
+func (d *decoder) control(offset uint) (int, uint, uint, error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, errors.New("unexpected end of the data")
+	}
+	ctrl := d.buf[offset]
+	offset++
+	typ := int(ctrl >> 5)
+	if typ == typePointer {
+		return typ, uint(ctrl), offset, nil
+	}
+	if typ == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, errors.New("unexpected end of the data")
+		}
+		typ = 7 + int(d.buf[offset])
+		offset++
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return 0, 0, 0, errors.New("unexpected end of the data")
+		}
+		extra := uint(0)
+		for _, b := range d.buf[offset : offset+n] {
+			extra = extra<<8 | uint(b)
+		}
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + extra
+		case 2:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+	return typ, size, offset, nil
+}
+
+func (d *decoder) pointer(ctrl, offset uint) (uint, uint, error) {
+	n := (ctrl>>3)&0x3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errors.New("unexpected end of the data")
+	}
+	target := uint(0)
+	if n < 4 {
+		target = ctrl & 0x7
+	}
+	for _, b := range d.buf[offset : offset+n] {
+		target = target<<8 | uint(b)
+	}
+	switch n {
+	case 2:
+		target += 2048
+	case 3:
+		target += 526336
+	}
+	return target, offset + n, nil
+}
+
+func (d *decoder) decodeValue(typ int, size, offset uint) (interface{}, uint, error) {
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("invalid map, its keys must be strings")
+			}
+			m[k], offset, err = d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, size)
+		for i := range a {
+			var err error
+			a[i], offset, err = d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeEndMarker, typeContainer:
+		return nil, offset, nil
+	}
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errors.New("unexpected end of the data")
+	}
+	b := d.buf[offset : offset+size]
+	next := offset + size
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes, typeUint128:
+		return append([]byte(nil), b...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double of %d bytes", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float of %d bytes", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("invalid integer of %d bytes", size)
+		}
+		n := uint64(0)
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("invalid integer of %d bytes", size)
+		}
+		n := uint32(0)
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), next, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown type %d", typ)
+	}
+}
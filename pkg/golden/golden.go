@@ -0,0 +1,148 @@
+package golden
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+// maximum number of differences listed by Check
+const maxDiffs = 10
+
+// Entry is an entry sent to the sinks, as stored in golden files.
+type Entry struct {
+	Map   string            `json:"map"`
+	Key   map[string]string `json:"key,omitempty"`
+	Value string            `json:"value,omitempty"`
+}
+
+func (e Entry) String() string {
+	b, _ := json.Marshal(e)
+	return string(b)
+}
+
+// Watcher is a MapWatcher collecting the entries it receives, in order.
+type Watcher struct {
+	lock    sync.Mutex
+	entries []Entry
+}
+
+func NewWatcher() *Watcher {
+	return &Watcher{}
+}
+
+func (w *Watcher) NewRingBuf(name string, keys []string) {}
+func (w *Watcher) NewHashMap(name string, keys []string) {}
+func (w *Watcher) SendEntry(entry v1.MapEntry) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.entries = append(w.entries, Entry{Map: entry.Name, Key: entry.Entry.Key, Value: entry.Entry.Value})
+}
+func (w *Watcher) Close() {}
+
+// Entries returns the entries received so far.
+func (w *Watcher) Entries() []Entry {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]Entry(nil), w.entries...)
+}
+
+// Write writes the entries as JSON lines, the keys of each entry being sorted.
+func Write(w io.Writer, entries []Entry) error {
+	enc := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read reads the JSON lines of entries written by Write.
+func Read(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid entry on line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Diff returns the differences between the expected and actual entries, compared in order.
+func Diff(expected, actual []Entry) []string {
+	var diffs []string
+	for i := 0; i < len(expected) || i < len(actual); i++ {
+		switch {
+		case i >= len(actual):
+			diffs = append(diffs, fmt.Sprintf("entry %d: missing %s", i+1, expected[i]))
+		case i >= len(expected):
+			diffs = append(diffs, fmt.Sprintf("entry %d: unexpected %s", i+1, actual[i]))
+		case !equal(expected[i], actual[i]):
+			diffs = append(diffs, fmt.Sprintf("entry %d: expected %s, got %s", i+1, expected[i], actual[i]))
+		}
+	}
+	return diffs
+}
+
+func equal(a, b Entry) bool {
+	// no key is the same as an empty one once written
+	if len(a.Key) == 0 && len(b.Key) == 0 {
+		a.Key, b.Key = nil, nil
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Check compares the entries with the golden file at path, or writes the file with them
+// if update is set.
+func Check(path string, entries []Entry, update bool) error {
+	if update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := Write(f, entries); err != nil {
+			return err
+		}
+		return f.Close()
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open golden file, it can be created by updating it: %w", err)
+	}
+	defer f.Close()
+	expected, err := Read(f)
+	if err != nil {
+		return fmt.Errorf("could not read golden file %s: %w", path, err)
+	}
+	diffs := Diff(expected, entries)
+	if len(diffs) == 0 {
+		return nil
+	}
+	total, more := len(diffs), ""
+	if total > maxDiffs {
+		more = fmt.Sprintf("\n... and %d more", total-maxDiffs)
+		diffs = diffs[:maxDiffs]
+	}
+	return fmt.Errorf("%d entries differ from golden file %s:\n%s%s", total, path, strings.Join(diffs, "\n"), more)
+}
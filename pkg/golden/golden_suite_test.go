@@ -0,0 +1,13 @@
+package golden_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestGolden(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Golden Suite")
+}
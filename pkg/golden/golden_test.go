@@ -0,0 +1,60 @@
+package golden_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/golden"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Check", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "bee-golden")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	received := func(pids ...string) []golden.Entry {
+		watcher := golden.NewWatcher()
+		for _, pid := range pids {
+			watcher.SendEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"pid": pid, "comm": "curl"}}})
+		}
+		return watcher.Entries()
+	}
+
+	It("compares the entries with the golden file once written", func() {
+		path := filepath.Join(dir, "testdata", "events.golden.jsonl")
+		Expect(golden.Check(path, received("42", "7"), false)).To(MatchError(ContainSubstring("could not open golden file")))
+
+		Expect(golden.Check(path, received("42", "7"), true)).To(Succeed())
+		b, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(b)).To(Equal(
+			`{"map":"events","key":{"comm":"curl","pid":"42"}}` + "\n" +
+				`{"map":"events","key":{"comm":"curl","pid":"7"}}` + "\n",
+		))
+		Expect(golden.Check(path, received("42", "7"), false)).To(Succeed())
+
+		err = golden.Check(path, received("42", "8", "9"), false)
+		Expect(err).To(MatchError(ContainSubstring("2 entries differ")))
+		Expect(err).To(MatchError(ContainSubstring(`entry 2: expected {"map":"events","key":{"comm":"curl","pid":"7"}}, got {"map":"events","key":{"comm":"curl","pid":"8"}}`)))
+		Expect(err).To(MatchError(ContainSubstring(`entry 3: unexpected {"map":"events","key":{"comm":"curl","pid":"9"}}`)))
+	})
+
+	It("lists the missing entries", func() {
+		Expect(golden.Diff(received("42", "7"), received("42"))).To(Equal([]string{
+			`entry 2: missing {"map":"events","key":{"comm":"curl","pid":"7"}}`,
+		}))
+	})
+})
@@ -0,0 +1,175 @@
+// Package kube requests the Kubernetes API server, from the pod bee runs in or through a
+// `kubectl proxy`, for the packages reading and writing Kubernetes objects.
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ServiceAccountDir holds the token, CA certificate and namespace of the service account of
+// the pod bee runs in
+const ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// ErrNotInCluster is returned by NewClient when the API server is not set outside of a cluster.
+var ErrNotInCluster = errors.New("not running in a Kubernetes cluster, the API server must be set")
+
+// Config is how the API server is requested, embedded inline in the configs of the packages
+// requesting it.
+type Config struct {
+	// URL of the API server, defaults to the one of the cluster bee runs in. This can be a
+	// `kubectl proxy` when running outside of a cluster.
+	APIServer string `yaml:"apiServer,omitempty"`
+	// Service account token and CA certificate, default to the ones of the pod bee runs in
+	// when the API server is not set. The token is read on every request, as projected
+	// tokens are rotated.
+	TokenFile string `yaml:"tokenFile,omitempty"`
+	CAFile    string `yaml:"caFile,omitempty"`
+	// Timeout of the requests, none if 0, e.g. for watches
+	Timeout time.Duration `yaml:"-"`
+	// Client the requests are sent with, e.g. in tests, one with the Timeout by default. Its
+	// transport is replaced by one trusting the CA certificate, if set.
+	HTTPClient *http.Client `yaml:"-"`
+}
+
+// Client requests the API server.
+type Client struct {
+	server    string
+	tokenFile string
+	client    *http.Client
+}
+
+// NewClient returns a client of the API server, the one of the cluster bee runs in unless set.
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.APIServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, ErrNotInCluster
+		}
+		cfg.APIServer = "https://" + net.JoinHostPort(host, port)
+		if cfg.TokenFile == "" {
+			cfg.TokenFile = ServiceAccountDir + "/token"
+		}
+		if cfg.CAFile == "" {
+			cfg.CAFile = ServiceAccountDir + "/ca.crt"
+		}
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: cfg.Timeout}
+	}
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read Kubernetes CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in Kubernetes CA file %s", cfg.CAFile)
+		}
+		client = &http.Client{
+			Timeout: client.Timeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		}
+	}
+	return &Client{
+		server:    strings.TrimSuffix(cfg.APIServer, "/"),
+		tokenFile: cfg.TokenFile,
+		client:    client,
+	}, nil
+}
+
+// Server returns the URL of the API server.
+func (c *Client) Server() string {
+	return c.server
+}
+
+// StatusError is the error of a request answered with a status other than 2xx.
+type StatusError struct {
+	Code   int
+	Status string
+	// Body of the response, usually a Status object of the API server
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %s: %s", e.Status, e.Body)
+}
+
+// Request sends a request of the path of an object, e.g. /api/v1/nodes/NAME, and returns the
+// response, or a *StatusError if its status is not 2xx. The body of the response must be
+// closed.
+func (c *Client) Request(ctx context.Context, method, path string, query url.Values, contentType string, body io.Reader) (*http.Response, error) {
+	u := c.server + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.tokenFile != "" {
+		token, err := ioutil.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read Kubernetes token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return nil, &StatusError{Code: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(respBody))}
+	}
+	return resp, nil
+}
+
+// Do sends a request of the path with the body encoded in JSON, none if nil, and decodes the
+// response into out, unless nil.
+func (c *Client) Do(ctx context.Context, method, path, contentType string, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		byt, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(byt)
+	}
+	resp, err := c.Request(ctx, method, path, nil, contentType, reqBody)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// IsStatus returns whether the error is the one of a request answered with the status code.
+func IsStatus(err error, code int) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.Code == code
+}
@@ -0,0 +1,93 @@
+package kube_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/solo-io/bumblebee/pkg/internal/kube"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		server *httptest.Server
+		tokens []string
+		dir    string
+		ctx    = context.Background()
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "kube")
+		Expect(err).NotTo(HaveOccurred())
+		tokens = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokens = append(tokens, r.Header.Get("Authorization"))
+			switch r.URL.Path {
+			case "/api/v1/nodes/node-1":
+				var body map[string]string
+				if r.Method == http.MethodPatch {
+					Expect(r.Header.Get("Content-Type")).To(Equal("application/merge-patch+json"))
+					Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+				}
+				Expect(json.NewEncoder(w).Encode(map[string]string{"name": "node-1", "label": body["label"]})).To(Succeed())
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"reason":"NotFound"}` + "\n"))
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("sends and decodes JSON, reading the token on every request", func() {
+		tokenFile := filepath.Join(dir, "token")
+		Expect(ioutil.WriteFile(tokenFile, []byte("first\n"), 0600)).To(Succeed())
+		client, err := kube.NewClient(kube.Config{APIServer: server.URL + "/", TokenFile: tokenFile})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.Server()).To(Equal(server.URL))
+
+		var node map[string]string
+		Expect(client.Do(ctx, http.MethodPatch, "/api/v1/nodes/node-1", "application/merge-patch+json", map[string]string{"label": "a"}, &node)).To(Succeed())
+		Expect(node).To(Equal(map[string]string{"name": "node-1", "label": "a"}))
+
+		Expect(ioutil.WriteFile(tokenFile, []byte("rotated"), 0600)).To(Succeed())
+		Expect(client.Do(ctx, http.MethodGet, "/api/v1/nodes/node-1", "", nil, nil)).To(Succeed())
+		Expect(tokens).To(Equal([]string{"Bearer first", "Bearer rotated"}))
+	})
+
+	It("returns the status and body of the failed requests", func() {
+		client, err := kube.NewClient(kube.Config{APIServer: server.URL})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.Request(ctx, http.MethodGet, "/api/v1/nodes/node-2", nil, "", nil)
+		Expect(err).To(MatchError(`unexpected status 404 Not Found: {"reason":"NotFound"}`))
+		Expect(kube.IsStatus(err, http.StatusNotFound)).To(BeTrue())
+		Expect(kube.IsStatus(err, http.StatusConflict)).To(BeFalse())
+	})
+
+	It("requires the API server outside of a cluster", func() {
+		host, ok := os.LookupEnv("KUBERNETES_SERVICE_HOST")
+		os.Unsetenv("KUBERNETES_SERVICE_HOST")
+		if ok {
+			defer os.Setenv("KUBERNETES_SERVICE_HOST", host)
+		}
+		_, err := kube.NewClient(kube.Config{})
+		Expect(err).To(Equal(kube.ErrNotInCluster))
+	})
+
+	It("validates the CA certificate", func() {
+		caFile := filepath.Join(dir, "ca.crt")
+		Expect(ioutil.WriteFile(caFile, []byte("not a certificate"), 0600)).To(Succeed())
+		_, err := kube.NewClient(kube.Config{APIServer: server.URL, CAFile: caFile})
+		Expect(err).To(MatchError("no certificate found in Kubernetes CA file " + caFile))
+	})
+})
@@ -0,0 +1,22 @@
+// Package uname describes the kernel bee runs on.
+package uname
+
+import "golang.org/x/sys/unix"
+
+// Release returns the release of the kernel, e.g. 5.4.0-91-generic, empty if unknown.
+func Release() string {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return ""
+	}
+	return unix.ByteSliceToString(uname.Release[:])
+}
+
+// Machine returns the hardware name of the kernel, e.g. x86_64, empty if unknown.
+func Machine() string {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return ""
+	}
+	return unix.ByteSliceToString(uname.Machine[:])
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+// Package uname describes the kernel bee runs on.
+package uname
+
+// Release returns an empty release, as there is no Linux kernel to load programs on.
+func Release() string {
+	return ""
+}
+
+// Machine returns an empty hardware name, as there is no Linux kernel.
+func Machine() string {
+	return ""
+}
@@ -0,0 +1,335 @@
+// Package join joins the events of the maps of two programs on shared fields within a time
+// window, e.g. the connections of a process with its DNS lookups by pid, emitting the
+// composite events as the ones of a map of their own.
+package join
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+const (
+	// OutputMap is the name of the ring buffer the joined events are sent to the watcher as
+	OutputMap = "events"
+
+	// InnerJoin only emits the events joined with an event of the other side
+	InnerJoin = "inner"
+	// LeftJoin also emits the events of the left side joined with no event within the
+	// window, once it is over, the fields of the right side being empty
+	LeftJoin = "left"
+
+	// DefaultMaxPending is the most events of a side waiting to be joined, the oldest ones
+	// being dropped beyond
+	DefaultMaxPending = 10000
+)
+
+// Config joins the events of a map of a program, the left side, with the ones of another, the
+// right side.
+type Config struct {
+	Name  string `yaml:"name"`
+	Left  Side   `yaml:"left"`
+	Right Side   `yaml:"right"`
+	// Fields the events are joined on, named the same in both, or as LEFT=RIGHT when they are
+	// not, e.g. `sk_cookie=cookie`
+	On []string `yaml:"on"`
+	// Most time between two events joined, whichever comes first
+	Window time.Duration `yaml:"window"`
+	// InnerJoin, the default, or LeftJoin
+	Type string `yaml:"type,omitempty"`
+	// Most events of each side waiting to be joined, DefaultMaxPending if 0
+	MaxPending int `yaml:"maxPending,omitempty"`
+	// Sinks the joined events are sent to, all the sinks if empty
+	Sinks []string `yaml:"sinks,omitempty"`
+}
+
+// Side is a map of events of a program.
+type Side struct {
+	Program string `yaml:"program"`
+	Map     string `yaml:"map"`
+}
+
+func (s Side) String() string {
+	return s.Program + "/" + s.Map
+}
+
+// Validate checks the config, regardless of the fields of the maps.
+func (c *Config) Validate() error {
+	if c.Name == "" {
+		return errors.New("the join has no name")
+	}
+	for _, side := range []Side{c.Left, c.Right} {
+		if side.Program == "" || side.Map == "" {
+			return fmt.Errorf("join %s: both sides must name a program and a map", c.Name)
+		}
+	}
+	if c.Left == c.Right {
+		return fmt.Errorf("join %s: can't join the events of %s with themselves", c.Name, c.Left)
+	}
+	if len(c.On) == 0 {
+		return fmt.Errorf("join %s: no fields to join the events on", c.Name)
+	}
+	for _, on := range c.On {
+		if left, right := splitOn(on); left == "" || right == "" {
+			return fmt.Errorf("join %s: invalid field %q, must be FIELD or LEFT=RIGHT", c.Name, on)
+		}
+	}
+	if c.Window <= 0 {
+		return fmt.Errorf("join %s: the window must be positive", c.Name)
+	}
+	if c.Type != "" && c.Type != InnerJoin && c.Type != LeftJoin {
+		return fmt.Errorf("join %s: unknown type %q, must be %s or %s", c.Name, c.Type, InnerJoin, LeftJoin)
+	}
+	if c.MaxPending < 0 {
+		return fmt.Errorf("join %s: the most pending events must be positive", c.Name)
+	}
+	return nil
+}
+
+// splitOn returns the fields of the left and right events of a field the events are joined on.
+func splitOn(on string) (string, string) {
+	if i := strings.Index(on, "="); i >= 0 {
+		return on[:i], on[i+1:]
+	}
+	return on, on
+}
+
+const (
+	left = iota
+	right
+)
+
+type pendingEvent struct {
+	at      time.Time
+	key     string
+	fields  map[string]string
+	matched bool
+}
+
+// Joiner joins the events it receives on its Left and Right watchers, sending the joined ones
+// to its output watcher as the events of OutputMap. Their fields are the ones of the left
+// event, then the ones of the right event but the fields joined on, prefixed with the name of
+// the right program and an underscore, e.g. `dnssnoop_qname`.
+type Joiner struct {
+	cfg     Config
+	on      [2][]string
+	fields  []string
+	renamed map[string]string
+	max     int
+	out     v1.MapWatcher
+	now     func() time.Time
+
+	lock sync.Mutex
+	// oldest first, by side
+	queues [2][]*pendingEvent
+	// the events of the queues, by side and joined fields
+	pending [2]map[string][]*pendingEvent
+}
+
+// New returns the joiner of the config, whose sides have the fields, sending the events it
+// joins to the output watcher.
+func New(cfg Config, leftFields, rightFields []string, out v1.MapWatcher) (*Joiner, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	j := &Joiner{
+		cfg:     cfg,
+		renamed: map[string]string{},
+		max:     cfg.MaxPending,
+		out:     out,
+		now:     time.Now,
+		pending: [2]map[string][]*pendingEvent{{}, {}},
+	}
+	if j.max == 0 {
+		j.max = DefaultMaxPending
+	}
+	joined := map[string]bool{}
+	for _, on := range cfg.On {
+		l, r := splitOn(on)
+		if !contains(leftFields, l) {
+			return nil, fmt.Errorf("join %s: %s has no field %s", cfg.Name, cfg.Left, l)
+		}
+		if !contains(rightFields, r) {
+			return nil, fmt.Errorf("join %s: %s has no field %s", cfg.Name, cfg.Right, r)
+		}
+		j.on[left], j.on[right] = append(j.on[left], l), append(j.on[right], r)
+		joined[r] = true
+	}
+	j.fields = append(j.fields, leftFields...)
+	for _, field := range rightFields {
+		if joined[field] {
+			continue
+		}
+		j.renamed[field] = cfg.Right.Program + "_" + field
+		j.fields = append(j.fields, j.renamed[field])
+	}
+	return j, nil
+}
+
+// Fields returns the fields of the joined events.
+func (j *Joiner) Fields() []string {
+	return j.fields
+}
+
+// Left returns the watcher of the left program, joining the events of its map.
+func (j *Joiner) Left() v1.MapWatcher {
+	return &tap{joiner: j, side: left, name: j.cfg.Left.Map}
+}
+
+// Right returns the watcher of the right program, joining the events of its map.
+func (j *Joiner) Right() v1.MapWatcher {
+	return &tap{joiner: j, side: right, name: j.cfg.Right.Map}
+}
+
+// Run declares the joined events to the output watcher, then expires the events which were
+// not joined within the window until the context is done.
+func (j *Joiner) Run(ctx context.Context) {
+	j.out.NewRingBuf(OutputMap, j.fields)
+	interval := j.cfg.Window / 2
+	if interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		j.lock.Lock()
+		unmatched := j.expire(j.now())
+		j.lock.Unlock()
+		j.send(unmatched)
+	}
+}
+
+// add joins an event of a side with the pending events of the other side, then adds it to
+// the pending ones.
+func (j *Joiner) add(side int, fields map[string]string) {
+	values := make([]string, len(j.on[side]))
+	for i, field := range j.on[side] {
+		value, ok := fields[field]
+		if !ok {
+			return
+		}
+		values[i] = value
+	}
+	event := &pendingEvent{at: j.now(), key: strings.Join(values, "\x00"), fields: fields}
+
+	j.lock.Lock()
+	joined := j.expire(event.at)
+	for _, other := range j.pending[1-side][event.key] {
+		other.matched, event.matched = true, true
+		if side == left {
+			joined = append(joined, j.join(fields, other.fields))
+		} else {
+			joined = append(joined, j.join(other.fields, fields))
+		}
+	}
+	if len(j.queues[side]) >= j.max {
+		joined = append(joined, j.pop(side)...)
+	}
+	j.queues[side] = append(j.queues[side], event)
+	j.pending[side][event.key] = append(j.pending[side][event.key], event)
+	j.lock.Unlock()
+	j.send(joined)
+}
+
+// expire drops the pending events older than the window, and returns the left ones to emit
+// unmatched. The lock must be held.
+func (j *Joiner) expire(now time.Time) []map[string]string {
+	var unmatched []map[string]string
+	for side := range j.queues {
+		for len(j.queues[side]) > 0 && now.Sub(j.queues[side][0].at) > j.cfg.Window {
+			unmatched = append(unmatched, j.pop(side)...)
+		}
+	}
+	return unmatched
+}
+
+// pop drops the oldest pending event of the side, returning it to emit if it is a left event
+// never joined of a left join. The lock must be held.
+func (j *Joiner) pop(side int) []map[string]string {
+	event := j.queues[side][0]
+	j.queues[side] = j.queues[side][1:]
+	// the oldest event of the queue is the oldest one of its key too
+	if events := j.pending[side][event.key][1:]; len(events) > 0 {
+		j.pending[side][event.key] = events
+	} else {
+		delete(j.pending[side], event.key)
+	}
+	if side == left && !event.matched && j.cfg.Type == LeftJoin {
+		return []map[string]string{j.join(event.fields, nil)}
+	}
+	return nil
+}
+
+// join returns the fields of the joined event of a left and a right event, nil for none.
+func (j *Joiner) join(leftFields, rightFields map[string]string) map[string]string {
+	fields := make(map[string]string, len(j.fields))
+	for k, v := range leftFields {
+		fields[k] = v
+	}
+	for field, name := range j.renamed {
+		fields[name] = rightFields[field]
+	}
+	return fields
+}
+
+func (j *Joiner) send(events []map[string]string) {
+	for _, fields := range events {
+		j.out.SendEntry(v1.MapEntry{Name: OutputMap, Entry: v1.KvPair{Key: fields}})
+	}
+}
+
+// tap is the watcher of a program joining the events of the map of a side, the ones of its
+// other maps being ignored.
+type tap struct {
+	joiner *Joiner
+	side   int
+	name   string
+
+	lock sync.Mutex
+	// whether the map was declared as a map of events, the entries of hash maps are not joined
+	events bool
+}
+
+func (t *tap) NewRingBuf(name string, keys []string) {
+	if name == t.name {
+		t.lock.Lock()
+		t.events = true
+		t.lock.Unlock()
+	}
+}
+
+func (t *tap) NewHashMap(name string, keys []string) {}
+
+func (t *tap) SendEntry(entry v1.MapEntry) {
+	if entry.Name != t.name {
+		return
+	}
+	t.lock.Lock()
+	events := t.events
+	t.lock.Unlock()
+	if events {
+		t.joiner.add(t.side, entry.Entry.Key)
+	}
+}
+
+// Close is a noop, the joiner outlives the programs it joins.
+func (t *tap) Close() {}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
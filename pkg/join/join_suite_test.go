@@ -0,0 +1,13 @@
+package join
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestJoin(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Join Suite")
+}
@@ -0,0 +1,144 @@
+package join
+
+import (
+	"context"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("join", func() {
+	var (
+		sink *fakes.Sink
+		now  time.Time
+		cfg  Config
+	)
+
+	joiner := func() (*Joiner, v1.MapWatcher, v1.MapWatcher) {
+		j, err := New(cfg, []string{"pid", "daddr"}, []string{"tgid", "qname"}, sink)
+		Expect(err).NotTo(HaveOccurred())
+		j.now = func() time.Time { return now }
+		l, r := j.Left(), j.Right()
+		l.NewRingBuf("events", []string{"pid", "daddr"})
+		r.NewRingBuf("queries", []string{"tgid", "qname"})
+		return j, l, r
+	}
+	connect := func(w v1.MapWatcher, pid, daddr string) {
+		w.SendEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"pid": pid, "daddr": daddr}}})
+	}
+	query := func(w v1.MapWatcher, tgid, qname string) {
+		w.SendEntry(v1.MapEntry{Name: "queries", Entry: v1.KvPair{Key: map[string]string{"tgid": tgid, "qname": qname}}})
+	}
+	joined := func() []map[string]string {
+		var events []map[string]string
+		for _, entry := range sink.Entries(OutputMap) {
+			events = append(events, entry.Entry.Key)
+		}
+		return events
+	}
+
+	BeforeEach(func() {
+		sink = fakes.NewSink()
+		now = time.Now()
+		cfg = Config{
+			Name:   "connect_dns",
+			Left:   Side{Program: "tcpconnect", Map: "events"},
+			Right:  Side{Program: "dnssnoop", Map: "queries"},
+			On:     []string{"pid=tgid"},
+			Window: 5 * time.Second,
+		}
+	})
+
+	It("joins the events of both sides on their fields within the window", func() {
+		j, l, r := joiner()
+		Expect(j.Fields()).To(Equal([]string{"pid", "daddr", "dnssnoop_qname"}))
+
+		query(r, "42", "example.com")
+		now = now.Add(time.Second)
+		connect(l, "42", "93.184.216.34")
+		connect(l, "7", "10.0.0.1")
+		now = now.Add(2 * time.Second)
+		query(r, "7", "internal.local")
+		// out of the window of the first connection
+		now = now.Add(6 * time.Second)
+		query(r, "42", "example.org")
+
+		Expect(joined()).To(Equal([]map[string]string{
+			{"pid": "42", "daddr": "93.184.216.34", "dnssnoop_qname": "example.com"},
+			{"pid": "7", "daddr": "10.0.0.1", "dnssnoop_qname": "internal.local"},
+		}))
+	})
+
+	It("emits the left events joined with none once the window is over with a left join", func() {
+		cfg.Type = LeftJoin
+		j, l, r := joiner()
+		connect(l, "42", "93.184.216.34")
+		connect(l, "7", "10.0.0.1")
+		query(r, "7", "internal.local")
+		Expect(joined()).To(HaveLen(1))
+
+		now = now.Add(10 * time.Second)
+		j.lock.Lock()
+		unmatched := j.expire(now)
+		j.lock.Unlock()
+		j.send(unmatched)
+		Expect(joined()).To(Equal([]map[string]string{
+			{"pid": "7", "daddr": "10.0.0.1", "dnssnoop_qname": "internal.local"},
+			{"pid": "42", "daddr": "93.184.216.34", "dnssnoop_qname": ""},
+		}))
+	})
+
+	It("drops the oldest pending events beyond the most pending", func() {
+		cfg.MaxPending = 1
+		_, l, r := joiner()
+		connect(l, "42", "93.184.216.34")
+		connect(l, "7", "10.0.0.1")
+		query(r, "42", "example.com")
+		query(r, "7", "internal.local")
+		Expect(joined()).To(Equal([]map[string]string{
+			{"pid": "7", "daddr": "10.0.0.1", "dnssnoop_qname": "internal.local"},
+		}))
+	})
+
+	It("ignores the other maps, and the maps which are not of events", func() {
+		j, err := New(cfg, []string{"pid"}, []string{"tgid"}, sink)
+		Expect(err).NotTo(HaveOccurred())
+		l, r := j.Left(), j.Right()
+		l.NewHashMap("events", []string{"pid"})
+		r.NewRingBuf("queries", []string{"tgid"})
+		connect(l, "42", "")
+		l.SendEntry(v1.MapEntry{Name: "other", Entry: v1.KvPair{Key: map[string]string{"pid": "42"}}})
+		query(r, "42", "example.com")
+		Expect(joined()).To(BeEmpty())
+	})
+
+	It("declares the joined events to the output", func() {
+		j, _, _ := joiner()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		j.Run(ctx)
+		Expect(sink.RingBufs()).To(Equal(map[string][]string{OutputMap: {"pid", "daddr", "dnssnoop_qname"}}))
+	})
+
+	It("rejects invalid configs and unknown fields", func() {
+		cfg.Window = 0
+		Expect(cfg.Validate()).To(MatchError("join connect_dns: the window must be positive"))
+		cfg.Window = time.Second
+		cfg.Right = cfg.Left
+		Expect(cfg.Validate()).To(MatchError(ContainSubstring("with themselves")))
+		cfg.Right = Side{Program: "dnssnoop", Map: "queries"}
+		cfg.Type = "outer"
+		Expect(cfg.Validate()).To(MatchError(ContainSubstring("unknown type")))
+		cfg.Type = ""
+		cfg.On = []string{"pid="}
+		Expect(cfg.Validate()).To(MatchError(ContainSubstring("invalid field")))
+
+		cfg.On = []string{"pid"}
+		_, err := New(cfg, []string{"pid"}, []string{"tgid"}, sink)
+		Expect(err).To(MatchError("join connect_dns: dnssnoop/queries has no field pid"))
+	})
+})
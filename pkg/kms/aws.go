@@ -0,0 +1,90 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// newAWSSigner returns the signer of an AWS KMS key, as [ENDPOINT]/ID. The credentials and
+// region are taken from the environment and the shared AWS config, as for the aws CLI, the
+// region of key ARNs being the one of the key.
+func newAWSSigner(ctx context.Context, ref string, opts Options) (crypto.Signer, error) {
+	i := strings.IndexByte(ref, '/')
+	if i < 0 || i == len(ref)-1 {
+		return nil, fmt.Errorf("invalid AWS KMS key ref %q, must be %s[ENDPOINT]/ID", SchemeAWS+ref, SchemeAWS)
+	}
+	endpoint, keyID := ref[:i], ref[i+1:]
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create AWS session: %w", err)
+	}
+	cfg := aws.NewConfig().WithHTTPClient(client(opts))
+	if endpoint != "" {
+		cfg = cfg.WithEndpoint("https://" + endpoint)
+	}
+	// arn:aws:kms:REGION:ACCOUNT:key/ID
+	if arn := strings.Split(keyID, ":"); len(arn) > 3 && arn[0] == "arn" {
+		cfg = cfg.WithRegion(arn[3])
+	}
+	svc := kms.New(sess, cfg)
+
+	out, err := svc.GetPublicKeyWithContext(ctx, &kms.GetPublicKeyInput{KeyId: aws.String(keyID)})
+	if err != nil {
+		return nil, fmt.Errorf("could not read the public key of AWS KMS key %s: %w", keyID, err)
+	}
+	public, err := x509.ParsePKIXPublicKey(out.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the public key of AWS KMS key %s: %w", keyID, err)
+	}
+	if _, ok := public.(ed25519.PublicKey); ok {
+		return nil, fmt.Errorf("AWS KMS key %s: ed25519 keys are not supported", keyID)
+	}
+	if err := checkKey(public); err != nil {
+		return nil, fmt.Errorf("AWS KMS key %s: %w", keyID, err)
+	}
+	algorithm := kms.SigningAlgorithmSpecEcdsaSha256
+	if _, ok := public.(*rsa.PublicKey); ok {
+		algorithm = kms.SigningAlgorithmSpecRsassaPkcs1V15Sha256
+	}
+	if !hasAlgorithm(out.SigningAlgorithms, algorithm) {
+		return nil, fmt.Errorf("AWS KMS key %s can't sign with %s", keyID, algorithm)
+	}
+
+	return &signer{
+		ctx:    ctx,
+		public: public,
+		sign: func(ctx context.Context, digest []byte) ([]byte, error) {
+			out, err := svc.SignWithContext(ctx, &kms.SignInput{
+				KeyId:            aws.String(keyID),
+				Message:          digest,
+				MessageType:      aws.String(kms.MessageTypeDigest),
+				SigningAlgorithm: aws.String(algorithm),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("could not sign with AWS KMS key %s: %w", keyID, err)
+			}
+			return out.Signature, nil
+		},
+	}, nil
+}
+
+func hasAlgorithm(algorithms []*string, algorithm string) bool {
+	for _, a := range algorithms {
+		if aws.StringValue(a) == algorithm {
+			return true
+		}
+	}
+	return false
+}
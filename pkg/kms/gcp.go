@@ -0,0 +1,125 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// gcpMetadataToken is the access token of the service account of GCE instances and GKE pods.
+const gcpMetadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// the algorithms of the keys verified by bee and cosign
+var gcpAlgorithms = map[string]bool{
+	"EC_SIGN_P256_SHA256":        true,
+	"RSA_SIGN_PKCS1_2048_SHA256": true,
+	"RSA_SIGN_PKCS1_3072_SHA256": true,
+	"RSA_SIGN_PKCS1_4096_SHA256": true,
+}
+
+// newGCPSigner returns the signer of a version of a Cloud KMS key, as
+// projects/P/locations/L/keyRings/R/cryptoKeys/K/cryptoKeyVersions/V.
+func newGCPSigner(ctx context.Context, name string, opts Options) (crypto.Signer, error) {
+	if parts := strings.Split(name, "/"); len(parts) != 10 || parts[0] != "projects" || parts[8] != "cryptoKeyVersions" {
+		return nil, fmt.Errorf("invalid GCP KMS key ref %q, must be %sprojects/P/locations/L/keyRings/R/cryptoKeys/K/cryptoKeyVersions/V", SchemeGCP+name, SchemeGCP)
+	}
+	c := client(opts)
+	token := opts.GCPToken
+	if token == "" {
+		token = os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	}
+	if token == "" {
+		var err error
+		if token, err = metadataToken(ctx, c); err != nil {
+			return nil, fmt.Errorf("no GCP access token, set $GOOGLE_OAUTH_ACCESS_TOKEN, e.g. to `gcloud auth print-access-token`: %w", err)
+		}
+	}
+	endpoint := opts.GCPEndpoint
+	if endpoint == "" {
+		endpoint = "https://cloudkms.googleapis.com"
+	}
+	keyURL := strings.TrimSuffix(endpoint, "/") + "/v1/" + name
+	request := func(ctx context.Context, method, url string, body interface{}, out interface{}) error {
+		var buf bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&buf).Encode(body); err != nil {
+				return err
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, method, url, &buf)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		return doJSON(c, req, out)
+	}
+
+	var key struct {
+		PEM       string `json:"pem"`
+		Algorithm string `json:"algorithm"`
+	}
+	if err := request(ctx, http.MethodGet, keyURL+"/publicKey", nil, &key); err != nil {
+		return nil, fmt.Errorf("could not read the public key of GCP KMS key %s: %w", name, err)
+	}
+	if !gcpAlgorithms[key.Algorithm] {
+		return nil, fmt.Errorf("GCP KMS key %s: unsupported algorithm %s, only P-256 ECDSA and PKCS#1 RSA keys signing SHA-256 digests are", name, key.Algorithm)
+	}
+	block, _ := pem.Decode([]byte(key.PEM))
+	if block == nil {
+		return nil, fmt.Errorf("the public key of GCP KMS key %s is not PEM", name)
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the public key of GCP KMS key %s: %w", name, err)
+	}
+
+	return &signer{
+		ctx:    ctx,
+		public: public,
+		sign: func(ctx context.Context, digest []byte) ([]byte, error) {
+			body := map[string]interface{}{
+				"digest": map[string]string{"sha256": base64.StdEncoding.EncodeToString(digest)},
+			}
+			var resp struct {
+				Signature string `json:"signature"`
+			}
+			if err := request(ctx, http.MethodPost, keyURL+":asymmetricSign", body, &resp); err != nil {
+				return nil, fmt.Errorf("could not sign with GCP KMS key %s: %w", name, err)
+			}
+			return base64.StdEncoding.DecodeString(resp.Signature)
+		},
+	}, nil
+}
+
+// metadataToken returns the access token of the service account of the instance.
+func metadataToken(ctx context.Context, c *http.Client) (string, error) {
+	// off GCP, the metadata server never answers
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcpMetadataToken, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := doJSON(c, req, &resp); err != nil {
+		return "", err
+	}
+	if resp.AccessToken == "" {
+		return "", errors.New("the metadata server returned no access token")
+	}
+	return resp.AccessToken, nil
+}
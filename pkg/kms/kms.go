@@ -0,0 +1,136 @@
+// Package kms signs with the keys of key management services, so the private keys never leave
+// the service: the transit secrets engine of HashiCorp Vault, AWS KMS and GCP Cloud KMS.
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Schemes of the refs of the keys, as taken by cosign.
+const (
+	SchemeVault = "hashivault://"
+	SchemeAWS   = "awskms://"
+	SchemeGCP   = "gcpkms://"
+)
+
+// Options configure the clients of the services, which otherwise take their addresses and
+// credentials from the environment, as their CLIs do.
+type Options struct {
+	// Vault server and token, default to $VAULT_ADDR and $VAULT_TOKEN or ~/.vault-token
+	VaultAddr  string
+	VaultToken string
+	// Mount of the transit secrets engine, defaults to transit
+	VaultTransitMount string
+	// Access token of the GCP requests, defaults to $GOOGLE_OAUTH_ACCESS_TOKEN or the token
+	// of the service account of the instance, from the metadata server
+	GCPToken string
+	// Cloud KMS API, defaults to https://cloudkms.googleapis.com
+	GCPEndpoint string
+	// Client of the requests, http.DefaultClient by default
+	Client *http.Client
+}
+
+// IsRef reports whether the key is the ref of a key of a service rather than a file.
+func IsRef(key string) bool {
+	return strings.HasPrefix(key, SchemeVault) || strings.HasPrefix(key, SchemeAWS) || strings.HasPrefix(key, SchemeGCP)
+}
+
+// NewSigner returns the signer of the key of the ref:
+//
+//	hashivault://KEY                           key of the Vault transit secrets engine
+//	awskms://[ENDPOINT]/ID                     AWS KMS key, by ID, ARN or alias/NAME
+//	gcpkms://projects/P/locations/L/keyRings/R/cryptoKeys/K/cryptoKeyVersions/V
+//
+// Its public key is read from the service, which makes its signatures of SHA-256 digests, or
+// of ed25519 messages, with the context.
+func NewSigner(ctx context.Context, ref string, opts Options) (crypto.Signer, error) {
+	switch {
+	case strings.HasPrefix(ref, SchemeVault):
+		return newVaultSigner(ctx, strings.TrimPrefix(ref, SchemeVault), opts)
+	case strings.HasPrefix(ref, SchemeAWS):
+		return newAWSSigner(ctx, strings.TrimPrefix(ref, SchemeAWS), opts)
+	case strings.HasPrefix(ref, SchemeGCP):
+		return newGCPSigner(ctx, strings.TrimPrefix(ref, SchemeGCP), opts)
+	}
+	return nil, fmt.Errorf("unknown key ref %q, must start with %s, %s or %s", ref, SchemeVault, SchemeAWS, SchemeGCP)
+}
+
+// PublicKey returns the public key of the key of the ref.
+func PublicKey(ctx context.Context, ref string, opts Options) (crypto.PublicKey, error) {
+	signer, err := NewSigner(ctx, ref, opts)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Public(), nil
+}
+
+// signer is a crypto.Signer whose signatures are made by a service.
+type signer struct {
+	ctx    context.Context
+	public crypto.PublicKey
+	sign   func(ctx context.Context, digest []byte) ([]byte, error)
+}
+
+func (s *signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if _, ok := s.public.(ed25519.PublicKey); ok {
+		// ed25519 keys sign the message itself
+		if opts.HashFunc() != crypto.Hash(0) {
+			return nil, fmt.Errorf("ed25519 keys sign messages, not digests")
+		}
+	} else if opts.HashFunc() != crypto.SHA256 {
+		return nil, fmt.Errorf("only SHA-256 digests can be signed, not %v", opts.HashFunc())
+	}
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, fmt.Errorf("RSA-PSS signatures are not supported")
+	}
+	return s.sign(s.ctx, digest)
+}
+
+// checkKey checks signatures of the key can be verified by bee and cosign.
+func checkKey(key crypto.PublicKey) error {
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return fmt.Errorf("unsupported curve %s, only P-256 ECDSA keys sign SHA-256 digests", key.Curve.Params().Name)
+		}
+	case *rsa.PublicKey, ed25519.PublicKey:
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	return nil
+}
+
+func client(opts Options) *http.Client {
+	if opts.Client != nil {
+		return opts.Client
+	}
+	return http.DefaultClient
+}
+
+// doJSON sends the request, decoding the JSON body of the response into out.
+func doJSON(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
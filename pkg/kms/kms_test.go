@@ -0,0 +1,242 @@
+package kms_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/solo-io/bumblebee/pkg/kms"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"oras.land/oras-go/pkg/content"
+)
+
+// signDigest signs the digest of the base64 input with the key, as the services do.
+func signDigest(key *ecdsa.PrivateKey, input string) string {
+	digest, err := base64.StdEncoding.DecodeString(input)
+	Expect(err).NotTo(HaveOccurred())
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest)
+	Expect(err).NotTo(HaveOccurred())
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func publicPEM(key *ecdsa.PrivateKey) string {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	Expect(err).NotTo(HaveOccurred())
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+var _ = Describe("signers", func() {
+	var (
+		ctx    context.Context
+		key    *ecdsa.PrivateKey
+		digest [32]byte
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		digest = sha256.Sum256([]byte("payload"))
+	})
+
+	vaultServer := func() *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("X-Vault-Token") != "root" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			switch req.URL.Path {
+			case "/v1/transit/keys/bee":
+				json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+					"type":           "ecdsa-p256",
+					"latest_version": 2,
+					"keys":           map[string]interface{}{"2": map[string]string{"public_key": publicPEM(key)}},
+				}})
+			case "/v1/transit/sign/bee/sha2-256":
+				var body struct {
+					Input      string `json:"input"`
+					Prehashed  bool   `json:"prehashed"`
+					Marshaling string `json:"marshaling_algorithm"`
+					Version    int    `json:"key_version"`
+				}
+				Expect(json.NewDecoder(req.Body).Decode(&body)).To(Succeed())
+				Expect(body.Prehashed).To(BeTrue())
+				Expect(body.Marshaling).To(Equal("asn1"))
+				Expect(body.Version).To(Equal(2))
+				json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{
+					"signature": "vault:v2:" + signDigest(key, body.Input),
+				}})
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	}
+
+	It("signs with the keys of Vault transit", func() {
+		server := vaultServer()
+		defer server.Close()
+
+		signer, err := kms.NewSigner(ctx, "hashivault://bee", kms.Options{VaultAddr: server.URL, VaultToken: "root"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(signer.Public()).To(Equal(&key.PublicKey))
+		sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig)).To(BeTrue())
+
+		_, err = kms.NewSigner(ctx, "hashivault://bee", kms.Options{VaultAddr: server.URL, VaultToken: "wrong"})
+		Expect(err).To(MatchError(ContainSubstring("403")))
+	})
+
+	It("signs packages verified with the public key of the service", func() {
+		server := vaultServer()
+		defer server.Close()
+		dir, err := os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		store, err := content.NewOCI(dir)
+		Expect(err).NotTo(HaveOccurred())
+
+		opts := kms.Options{VaultAddr: server.URL, VaultToken: "root"}
+		signer, err := kms.NewSigner(ctx, "hashivault://bee", opts)
+		Expect(err).NotTo(HaveOccurred())
+		ref := "localhost:5000/kms:v1"
+		Expect(spec.PushSigned(ctx, spec.NewEbpfOCICLient(), ref, store, &spec.EbpfPackage{ProgramFileBytes: []byte("program")}, signer)).To(Succeed())
+
+		public, err := kms.PublicKey(ctx, "hashivault://bee", opts)
+		Expect(err).NotTo(HaveOccurred())
+		client := spec.NewEbpfOCICLientWith(spec.ClientOptions{Verifier: spec.NewKeyVerifier(public)})
+		_, err = client.Pull(ctx, ref, store)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("signs with the keys of GCP KMS", func() {
+		name := "projects/p/locations/global/keyRings/bee/cryptoKeys/signing/cryptoKeyVersions/1"
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			switch req.URL.Path {
+			case "/v1/" + name + "/publicKey":
+				json.NewEncoder(w).Encode(map[string]string{"pem": publicPEM(key), "algorithm": "EC_SIGN_P256_SHA256"})
+			case "/v1/" + name + ":asymmetricSign":
+				var body struct {
+					Digest struct {
+						SHA256 string `json:"sha256"`
+					} `json:"digest"`
+				}
+				Expect(json.NewDecoder(req.Body).Decode(&body)).To(Succeed())
+				json.NewEncoder(w).Encode(map[string]string{"signature": signDigest(key, body.Digest.SHA256)})
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		signer, err := kms.NewSigner(ctx, "gcpkms://"+name, kms.Options{GCPEndpoint: server.URL, GCPToken: "token"})
+		Expect(err).NotTo(HaveOccurred())
+		sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig)).To(BeTrue())
+
+		_, err = kms.NewSigner(ctx, "gcpkms://projects/p/keyRings/bee", kms.Options{GCPEndpoint: server.URL, GCPToken: "token"})
+		Expect(err).To(MatchError(ContainSubstring("invalid GCP KMS key ref")))
+	})
+
+	Context("AWS KMS", func() {
+		env := map[string]string{
+			"AWS_ACCESS_KEY_ID":           "AKIDEXAMPLE",
+			"AWS_SECRET_ACCESS_KEY":       "secret",
+			"AWS_REGION":                  "us-east-1",
+			"AWS_CONFIG_FILE":             os.DevNull,
+			"AWS_SHARED_CREDENTIALS_FILE": os.DevNull,
+		}
+		previous := map[string]*string{}
+
+		BeforeEach(func() {
+			for k, v := range env {
+				if old, ok := os.LookupEnv(k); ok {
+					previous[k] = &old
+				} else {
+					previous[k] = nil
+				}
+				os.Setenv(k, v)
+			}
+		})
+
+		AfterEach(func() {
+			for k, old := range previous {
+				if old == nil {
+					os.Unsetenv(k)
+				} else {
+					os.Setenv(k, *old)
+				}
+			}
+		})
+
+		It("signs digests with the key", func() {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+				switch req.Header.Get("X-Amz-Target") {
+				case "TrentService.GetPublicKey":
+					der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+					Expect(err).NotTo(HaveOccurred())
+					json.NewEncoder(w).Encode(map[string]interface{}{
+						"KeyId":             "alias/bee",
+						"PublicKey":         der,
+						"SigningAlgorithms": []string{"ECDSA_SHA_256"},
+					})
+				case "TrentService.Sign":
+					var body struct {
+						Message     string
+						MessageType string
+					}
+					Expect(json.NewDecoder(req.Body).Decode(&body)).To(Succeed())
+					Expect(body.MessageType).To(Equal("DIGEST"))
+					json.NewEncoder(w).Encode(map[string]string{"Signature": signDigest(key, body.Message)})
+				default:
+					w.WriteHeader(http.StatusBadRequest)
+				}
+			}))
+			defer server.Close()
+
+			endpoint := strings.TrimPrefix(server.URL, "https://")
+			signer, err := kms.NewSigner(ctx, "awskms://"+endpoint+"/alias/bee", kms.Options{Client: server.Client()})
+			Expect(err).NotTo(HaveOccurred())
+			sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig)).To(BeTrue())
+		})
+	})
+
+	It("only signs SHA-256 digests", func() {
+		server := vaultServer()
+		defer server.Close()
+
+		signer, err := kms.NewSigner(ctx, "hashivault://bee", kms.Options{VaultAddr: server.URL, VaultToken: "root"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = signer.Sign(rand.Reader, make([]byte, 48), crypto.SHA384)
+		Expect(err).To(MatchError(ContainSubstring("only SHA-256")))
+	})
+
+	It("recognizes the refs of KMS keys", func() {
+		Expect(kms.IsRef("awskms:///alias/bee")).To(BeTrue())
+		Expect(kms.IsRef("cosign.key")).To(BeFalse())
+		_, err := kms.NewSigner(ctx, "azurekms://bee", kms.Options{})
+		Expect(err).To(MatchError(ContainSubstring("unknown key ref")))
+	})
+})
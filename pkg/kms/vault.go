@@ -0,0 +1,155 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type vaultKey struct {
+	Type          string `json:"type"`
+	LatestVersion int    `json:"latest_version"`
+	Keys          map[string]struct {
+		PublicKey string `json:"public_key"`
+	} `json:"keys"`
+}
+
+// newVaultSigner returns the signer of the latest version of a key of the transit secrets
+// engine.
+func newVaultSigner(ctx context.Context, name string, opts Options) (crypto.Signer, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("invalid Vault key %q, the ref must be %sKEY", name, SchemeVault)
+	}
+	addr := opts.VaultAddr
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return nil, errors.New("the Vault address is not set, set $VAULT_ADDR")
+	}
+	token := opts.VaultToken
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if byt, err := ioutil.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+				token = strings.TrimSpace(string(byt))
+			}
+		}
+	}
+	if token == "" {
+		return nil, errors.New("no Vault token, set $VAULT_TOKEN or log in with `vault login`")
+	}
+	mount := opts.VaultTransitMount
+	if mount == "" {
+		mount = "transit"
+	}
+	base := strings.TrimSuffix(addr, "/") + "/v1/" + strings.Trim(mount, "/")
+	c := client(opts)
+	request := func(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+		var buf bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&buf).Encode(body); err != nil {
+				return err
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, method, base+path, &buf)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Vault-Token", token)
+		var resp struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := doJSON(c, req, &resp); err != nil {
+			return err
+		}
+		return json.Unmarshal(resp.Data, out)
+	}
+
+	var key vaultKey
+	if err := request(ctx, http.MethodGet, "/keys/"+url.PathEscape(name), nil, &key); err != nil {
+		return nil, fmt.Errorf("could not read Vault key %s: %w", name, err)
+	}
+	version, ok := key.Keys[strconv.Itoa(key.LatestVersion)]
+	if !ok || version.PublicKey == "" {
+		return nil, fmt.Errorf("Vault key %s of type %s has no public key, it must be an asymmetric key", name, key.Type)
+	}
+	public, err := parseVaultPublicKey(key.Type, version.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the public key of Vault key %s: %w", name, err)
+	}
+	if err := checkKey(public); err != nil {
+		return nil, fmt.Errorf("Vault key %s: %w", name, err)
+	}
+
+	return &signer{
+		ctx:    ctx,
+		public: public,
+		sign: func(ctx context.Context, digest []byte) ([]byte, error) {
+			body := map[string]interface{}{
+				"input":       base64.StdEncoding.EncodeToString(digest),
+				"key_version": key.LatestVersion,
+			}
+			path := "/sign/" + url.PathEscape(name)
+			switch public.(type) {
+			case ed25519.PublicKey:
+			case *rsa.PublicKey:
+				path += "/sha2-256"
+				body["prehashed"] = true
+				body["signature_algorithm"] = "pkcs1v15"
+			default:
+				path += "/sha2-256"
+				body["prehashed"] = true
+				body["marshaling_algorithm"] = "asn1"
+			}
+			var resp struct {
+				Signature string `json:"signature"`
+			}
+			if err := request(ctx, http.MethodPost, path, body, &resp); err != nil {
+				return nil, fmt.Errorf("could not sign with Vault key %s: %w", name, err)
+			}
+			// vault:v<version>:<base64 signature>
+			parts := strings.SplitN(resp.Signature, ":", 3)
+			if len(parts) != 3 || parts[0] != "vault" {
+				return nil, fmt.Errorf("unexpected signature %q of Vault key %s", resp.Signature, name)
+			}
+			return base64.StdEncoding.DecodeString(parts[2])
+		},
+	}, nil
+}
+
+// parseVaultPublicKey parses the public key of a transit key, PEM but for ed25519 keys.
+func parseVaultPublicKey(keyType, public string) (crypto.PublicKey, error) {
+	if keyType == "ed25519" {
+		byt, err := base64.StdEncoding.DecodeString(public)
+		if err != nil {
+			return nil, err
+		}
+		if len(byt) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 key of %d bytes", len(byt))
+		}
+		return ed25519.PublicKey(byt), nil
+	}
+	block, _ := pem.Decode([]byte(public))
+	if block == nil {
+		return nil, errors.New("the key is not PEM")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
@@ -0,0 +1,116 @@
+package kubemeta
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+const (
+	// the cgroups are indexed again at least this often, dropping the removed ones
+	cgroupRescanInterval = 30 * time.Second
+	// and at most this often, when a cgroup is not indexed yet
+	cgroupMinRescanInterval = 2 * time.Second
+)
+
+// podCgroup matches the pod UID in the cgroup of a pod, as named by the cgroupfs
+// (pod<UID>) and systemd (kubepods-besteffort-pod<UID with underscores>.slice) drivers.
+var podCgroup = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupIndex indexes the pods of the cgroups of the unified hierarchy by cgroup ID.
+type cgroupIndex struct {
+	root string
+	// overridden by tests, which can't get file handles of cgroups
+	id func(path string) (uint64, error)
+
+	lock sync.RWMutex
+	pods map[uint64]string
+	// indexes again once a cgroup is not found, unless it was just indexed
+	rescan chan struct{}
+}
+
+func newCgroupIndex() *cgroupIndex {
+	return &cgroupIndex{root: cgroupRoot, id: cgroupHandleID, pods: map[uint64]string{}, rescan: make(chan struct{}, 1)}
+}
+
+// lookup returns the UID of the pod of a cgroup, asking for the cgroups to be indexed again
+// if it is not found.
+func (x *cgroupIndex) lookup(id uint64) (string, bool) {
+	x.lock.RLock()
+	uid, ok := x.pods[id]
+	x.lock.RUnlock()
+	if !ok {
+		select {
+		case x.rescan <- struct{}{}:
+		default:
+		}
+	}
+	return uid, ok
+}
+
+func (x *cgroupIndex) run(ctx context.Context) {
+	logger := contextutils.LoggerFrom(ctx)
+	ticker := time.NewTicker(cgroupRescanInterval)
+	defer ticker.Stop()
+	var last time.Time
+	for {
+		if err := x.scan(); err != nil {
+			logger.Warnf("could not index the cgroups of the pods: %v", err)
+		}
+		last = time.Now()
+		select {
+		case <-ticker.C:
+		case <-x.rescan:
+			select {
+			case <-time.After(cgroupMinRescanInterval - time.Since(last)):
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// scan indexes the cgroups of the pods, replacing the previous index.
+func (x *cgroupIndex) scan() error {
+	root := x.root
+	if isCgroup2(filepath.Join(x.root, "unified")) {
+		root = filepath.Join(x.root, "unified")
+	}
+	pods := map[uint64]string{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// removed since
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		match := podCgroup.FindStringSubmatch(path)
+		if match == nil {
+			return nil
+		}
+		id, err := x.id(path)
+		if err != nil {
+			return nil
+		}
+		pods[id] = strings.ReplaceAll(match[1], "_", "-")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.pods = pods
+	return nil
+}
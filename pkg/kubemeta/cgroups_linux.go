@@ -0,0 +1,27 @@
+package kubemeta
+
+import (
+	"fmt"
+
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"golang.org/x/sys/unix"
+)
+
+// isCgroup2 returns whether the path is the mount point of a cgroup2 file system, e.g. the
+// unified hierarchy of hybrid nodes.
+func isCgroup2(path string) bool {
+	var st unix.Statfs_t
+	return unix.Statfs(path, &st) == nil && st.Type == unix.CGROUP2_SUPER_MAGIC
+}
+
+// cgroupHandleID returns the ID of a cgroup, its file handle in the cgroup file system.
+func cgroupHandleID(path string) (uint64, error) {
+	handle, _, err := unix.NameToHandleAt(unix.AT_FDCWD, path, 0)
+	if err != nil {
+		return 0, err
+	}
+	if len(handle.Bytes()) != 8 {
+		return 0, fmt.Errorf("unexpected file handle of %d bytes", len(handle.Bytes()))
+	}
+	return decoder.Endianess.Uint64(handle.Bytes()), nil
+}
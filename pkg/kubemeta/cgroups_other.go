@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package kubemeta
+
+import "fmt"
+
+func isCgroup2(path string) bool {
+	return false
+}
+
+// cgroupHandleID fails, as cgroups are only found on Linux.
+func cgroupHandleID(path string) (uint64, error) {
+	return 0, fmt.Errorf("cgroups are only supported on linux")
+}
@@ -0,0 +1,169 @@
+package kubemeta
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/solo-io/bumblebee/pkg/internal/kube"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+	// objects listed per page, so large clusters are not listed in a single response
+	listPageSize = 500
+)
+
+type objectList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+		Continue        string `json:"continue"`
+	} `json:"metadata"`
+	Items []json.RawMessage `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type versioned struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+}
+
+// store holds the objects of a resource, as listed and watched by an informer.
+type store interface {
+	// replace replaces all the objects with the listed ones
+	replace(objects []json.RawMessage) error
+	// update adds or updates an object
+	update(object json.RawMessage) error
+	// delete deletes an object
+	delete(object json.RawMessage) error
+}
+
+// informer keeps a store in sync with the objects of a resource, listing them and then
+// watching their changes, listing them again whenever the watch can't be resumed, so the
+// objects deleted while the watch was down do not linger.
+type informer struct {
+	client *kube.Client
+	// e.g. /api/v1/pods
+	path          string
+	fieldSelector string
+	store         store
+}
+
+// list replaces the objects of the store with the listed ones, and returns the resource
+// version to watch from.
+func (i *informer) list(ctx context.Context) (string, error) {
+	var objects []json.RawMessage
+	query := url.Values{"limit": {fmt.Sprint(listPageSize)}}
+	if i.fieldSelector != "" {
+		query.Set("fieldSelector", i.fieldSelector)
+	}
+	for {
+		resp, err := i.client.Request(ctx, http.MethodGet, i.path, query, "", nil)
+		if err != nil {
+			return "", fmt.Errorf("could not list %s: %w", i.path, err)
+		}
+		var list objectList
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("could not decode %s: %w", i.path, err)
+		}
+		objects = append(objects, list.Items...)
+		if list.Metadata.Continue == "" {
+			if err := i.store.replace(objects); err != nil {
+				return "", err
+			}
+			return list.Metadata.ResourceVersion, nil
+		}
+		query.Set("continue", list.Metadata.Continue)
+	}
+}
+
+// run watches the objects from the resource version until the context is done.
+func (i *informer) run(ctx context.Context, resourceVersion string) {
+	logger := contextutils.LoggerFrom(ctx)
+	delay := minReconnectDelay
+	for ctx.Err() == nil {
+		var err error
+		if resourceVersion == "" {
+			resourceVersion, err = i.list(ctx)
+		}
+		if err == nil {
+			resourceVersion, err = i.watch(ctx, resourceVersion)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			// the watch timed out, resume it immediately
+			delay = minReconnectDelay
+			continue
+		}
+		logger.Warnf("could not watch %s, retrying in %s: %v", i.path, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// watch applies the changes of the objects after the resource version until the watch ends,
+// and returns the resource version to resume from, empty if the objects must be listed again.
+func (i *informer) watch(ctx context.Context, resourceVersion string) (string, error) {
+	query := url.Values{
+		"watch":               {"1"},
+		"resourceVersion":     {resourceVersion},
+		"allowWatchBookmarks": {"true"},
+	}
+	if i.fieldSelector != "" {
+		query.Set("fieldSelector", i.fieldSelector)
+	}
+	resp, err := i.client.Request(ctx, http.MethodGet, i.path, query, "", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var event watchEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return "", fmt.Errorf("could not decode watch event: %w", err)
+		}
+		if event.Type == "ERROR" {
+			// usually 410 Gone, once the resource version is too old
+			return "", nil
+		}
+		var obj versioned
+		if err := json.Unmarshal(event.Object, &obj); err != nil {
+			return "", fmt.Errorf("could not decode watched object: %w", err)
+		}
+		resourceVersion = obj.Metadata.ResourceVersion
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			err = i.store.update(event.Object)
+		case "DELETED":
+			err = i.store.delete(event.Object)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return resourceVersion, scanner.Err()
+}
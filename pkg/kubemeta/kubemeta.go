@@ -0,0 +1,583 @@
+package kubemeta
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/solo-io/bumblebee/pkg/internal/kube"
+	"github.com/solo-io/go-utils/contextutils"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// ServiceAccountDir holds the token and CA certificate the API server is requested with by default
+	ServiceAccountDir = kube.ServiceAccountDir
+
+	// DefaultMaxPods is the number of pods cached by default
+	DefaultMaxPods = 100000
+	// DefaultDeletedTTL is how long deleted pods, services and nodes are still looked up by default
+	DefaultDeletedTTL = 30 * time.Second
+)
+
+// Config is how the metadata of the cluster is cached.
+type Config struct {
+	// Only cache the pods of this node, e.g. set from spec.nodeName with the downward API,
+	// bounding the memory of the cache on large clusters. The addresses of the pods of the
+	// other nodes are then unknown.
+	Node string `yaml:"node,omitempty"`
+	// Pods cached at most, the pods added once the cache is full are unknown, defaults to
+	// DefaultMaxPods
+	MaxPods int `yaml:"maxPods,omitempty"`
+	// How long deleted objects are still looked up, as events can be read after the pod they
+	// come from is deleted, defaults to DefaultDeletedTTL
+	DeletedTTL time.Duration `yaml:"deletedTTL,omitempty"`
+	// API server the objects are watched from, its timeout is ignored
+	kube.Config `yaml:",inline"`
+}
+
+// Meta is what the cluster knows of an address or a cgroup, empty for the ones which are not
+// of the cluster.
+type Meta struct {
+	Pod       string
+	Namespace string
+	// Services selecting the pod, comma separated, or the service of a cluster IP
+	Service string
+	Node    string
+}
+
+type objectMeta struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	UID       string            `json:"uid"`
+	Labels    map[string]string `json:"labels"`
+}
+
+type podObject struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		NodeName    string `json:"nodeName"`
+		HostNetwork bool   `json:"hostNetwork"`
+	} `json:"spec"`
+	Status struct {
+		PodIP  string `json:"podIP"`
+		PodIPs []struct {
+			IP string `json:"ip"`
+		} `json:"podIPs"`
+	} `json:"status"`
+}
+
+type serviceObject struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		Selector   map[string]string `json:"selector"`
+		ClusterIP  string            `json:"clusterIP"`
+		ClusterIPs []string          `json:"clusterIPs"`
+	} `json:"spec"`
+}
+
+type nodeObject struct {
+	Metadata objectMeta `json:"metadata"`
+	Status   struct {
+		Addresses []struct {
+			Type    string `json:"type"`
+			Address string `json:"address"`
+		} `json:"addresses"`
+	} `json:"status"`
+}
+
+// the cached objects, only holding what is looked up
+type pod struct {
+	name, namespace, node string
+	ips                   []string
+	labels                map[string]string
+	// the services selecting the pod, comma separated
+	services string
+	// when the pod was deleted, zero while it exists
+	deleted time.Time
+}
+
+type service struct {
+	name, namespace string
+	selector        map[string]string
+	ips             []string
+	deleted         time.Time
+}
+
+type node struct {
+	name    string
+	ips     []string
+	deleted time.Time
+}
+
+// Cache caches the pods, services and nodes of the cluster, kept in sync by watching the
+// API server as the shared informers of controllers do, to look up addresses and cgroups
+// at the rate of the events. Deleted objects are still looked up for the DeletedTTL, and the
+// objects deleted while the watches were down are dropped once listed again.
+type Cache struct {
+	cfg     Config
+	client  *kube.Client
+	cgroups *cgroupIndex
+	now     func() time.Time
+
+	lock         sync.RWMutex
+	pods         map[string]*pod
+	podsByIP     map[string]*pod
+	services     map[string]*service
+	servicesByIP map[string]*service
+	nodes        map[string]*node
+	nodesByIP    map[string]*node
+	// pods not cached as the cache was full
+	droppedPods int
+}
+
+func New(cfg Config) (*Cache, error) {
+	if cfg.MaxPods == 0 {
+		cfg.MaxPods = DefaultMaxPods
+	}
+	if cfg.MaxPods < 0 {
+		return nil, errors.New("the pods cached at most must be positive")
+	}
+	if cfg.DeletedTTL == 0 {
+		cfg.DeletedTTL = DefaultDeletedTTL
+	}
+	// no timeout, as the objects are watched
+	kubeCfg := cfg.Config
+	kubeCfg.Timeout = 0
+	client, err := kube.NewClient(kubeCfg)
+	if err != nil {
+		return nil, err
+	}
+	return newCache(cfg, client), nil
+}
+
+func newCache(cfg Config, client *kube.Client) *Cache {
+	return &Cache{
+		cfg:          cfg,
+		client:       client,
+		cgroups:      newCgroupIndex(),
+		now:          time.Now,
+		pods:         map[string]*pod{},
+		podsByIP:     map[string]*pod{},
+		services:     map[string]*service{},
+		servicesByIP: map[string]*service{},
+		nodes:        map[string]*node{},
+		nodesByIP:    map[string]*node{},
+	}
+}
+
+// Start lists the pods, services and nodes, failing if they can't be listed, e.g. as bee
+// lacks the RBAC permissions to, then watches them until the context is done.
+func (c *Cache) Start(ctx context.Context) error {
+	podSelector := ""
+	if c.cfg.Node != "" {
+		podSelector = "spec.nodeName=" + c.cfg.Node
+	}
+	informers := []*informer{
+		{client: c.client, path: "/api/v1/pods", fieldSelector: podSelector, store: podStore{c}},
+		{client: c.client, path: "/api/v1/services", store: serviceStore{c}},
+		{client: c.client, path: "/api/v1/nodes", store: nodeStore{c}},
+	}
+	versions := make([]string, len(informers))
+	eg, listCtx := errgroup.WithContext(ctx)
+	for i, inf := range informers {
+		i, inf := i, inf
+		eg.Go(func() error {
+			var err error
+			versions[i], err = inf.list(listCtx)
+			return err
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	for i, inf := range informers {
+		go inf.run(ctx, versions[i])
+	}
+	go c.sweep(ctx)
+	go c.cgroups.run(ctx)
+	return nil
+}
+
+// sweep drops the deleted objects once their DeletedTTL is over.
+func (c *Cache) sweep(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.DeletedTTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		c.lock.Lock()
+		c.dropDeleted()
+		droppedPods := c.droppedPods
+		c.droppedPods = 0
+		c.lock.Unlock()
+		if droppedPods > 0 {
+			contextutils.LoggerFrom(ctx).Warnf("%d pods were not cached as the cache holds %d pods at most", droppedPods, c.cfg.MaxPods)
+		}
+	}
+}
+
+// dropDeleted drops the deleted objects once their DeletedTTL is over. The lock must be held.
+func (c *Cache) dropDeleted() {
+	expired := func(deleted time.Time) bool {
+		return !deleted.IsZero() && c.now().Sub(deleted) >= c.cfg.DeletedTTL
+	}
+	for uid, p := range c.pods {
+		if expired(p.deleted) {
+			c.removePod(uid)
+		}
+	}
+	for key, s := range c.services {
+		if expired(s.deleted) {
+			c.removeService(key)
+		}
+	}
+	for name, n := range c.nodes {
+		if expired(n.deleted) {
+			c.removeNode(name)
+		}
+	}
+}
+
+// LookupIP returns the pod of an address, or the service of a cluster IP, or the node of an
+// address of a node. The pods of the host network are not looked up, as their address is
+// the one of their node.
+func (c *Cache) LookupIP(ip net.IP) Meta {
+	key := ip.String()
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if p, ok := c.podsByIP[key]; ok {
+		return Meta{Pod: p.name, Namespace: p.namespace, Service: p.services, Node: p.node}
+	}
+	if s, ok := c.servicesByIP[key]; ok {
+		return Meta{Namespace: s.namespace, Service: s.name}
+	}
+	if n, ok := c.nodesByIP[key]; ok {
+		return Meta{Node: n.name}
+	}
+	return Meta{}
+}
+
+// LookupCgroup returns the pod of a cgroup of this node, by the ID returned by
+// bpf_get_current_cgroup_id(). The cgroups of the pods are indexed in the background, so
+// the cgroups of pods just started are unknown until they are indexed.
+func (c *Cache) LookupCgroup(id uint64) Meta {
+	uid, ok := c.cgroups.lookup(id)
+	if !ok {
+		return Meta{}
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	p, ok := c.pods[uid]
+	if !ok {
+		return Meta{}
+	}
+	return Meta{Pod: p.name, Namespace: p.namespace, Service: p.services, Node: p.node}
+}
+
+func (c *Cache) removePod(uid string) {
+	p := c.pods[uid]
+	delete(c.pods, uid)
+	for _, ip := range p.ips {
+		if c.podsByIP[ip] == p {
+			delete(c.podsByIP, ip)
+		}
+	}
+}
+
+func (c *Cache) removeService(key string) {
+	s := c.services[key]
+	delete(c.services, key)
+	for _, ip := range s.ips {
+		if c.servicesByIP[ip] == s {
+			delete(c.servicesByIP, ip)
+		}
+	}
+}
+
+func (c *Cache) removeNode(name string) {
+	n := c.nodes[name]
+	delete(c.nodes, name)
+	for _, ip := range n.ips {
+		if c.nodesByIP[ip] == n {
+			delete(c.nodesByIP, ip)
+		}
+	}
+}
+
+// setPod caches a pod, replacing the previous version of it. The lock must be held.
+func (c *Cache) setPod(obj *podObject) {
+	uid := obj.Metadata.UID
+	if _, ok := c.pods[uid]; ok {
+		c.removePod(uid)
+	} else if len(c.pods) >= c.cfg.MaxPods {
+		c.droppedPods++
+		return
+	}
+	p := &pod{
+		name:      obj.Metadata.Name,
+		namespace: obj.Metadata.Namespace,
+		node:      obj.Spec.NodeName,
+		labels:    obj.Metadata.Labels,
+	}
+	if !obj.Spec.HostNetwork {
+		for _, ip := range obj.Status.PodIPs {
+			p.ips = append(p.ips, normalizeIP(ip.IP))
+		}
+		if len(p.ips) == 0 && obj.Status.PodIP != "" {
+			p.ips = []string{normalizeIP(obj.Status.PodIP)}
+		}
+	}
+	p.services = c.servicesOf(p)
+	c.pods[uid] = p
+	for _, ip := range p.ips {
+		c.podsByIP[ip] = p
+	}
+}
+
+// servicesOf returns the services selecting a pod, comma separated. The lock must be held.
+func (c *Cache) servicesOf(p *pod) string {
+	var names []string
+	for _, s := range c.services {
+		if s.deleted.IsZero() && s.namespace == p.namespace && selects(s.selector, p.labels) {
+			names = append(names, s.name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// relinkServices sets again the services of the pods of a namespace, all of them if empty,
+// once its services changed. The lock must be held.
+func (c *Cache) relinkServices(namespace string) {
+	for _, p := range c.pods {
+		if namespace == "" || p.namespace == namespace {
+			p.services = c.servicesOf(p)
+		}
+	}
+}
+
+func selects(selector, labels map[string]string) bool {
+	if len(selector) == 0 {
+		return false
+	}
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Cache) setService(obj *serviceObject) {
+	key := obj.Metadata.Namespace + "/" + obj.Metadata.Name
+	if _, ok := c.services[key]; ok {
+		c.removeService(key)
+	}
+	s := &service{name: obj.Metadata.Name, namespace: obj.Metadata.Namespace, selector: obj.Spec.Selector}
+	ips := obj.Spec.ClusterIPs
+	if len(ips) == 0 && obj.Spec.ClusterIP != "" {
+		ips = []string{obj.Spec.ClusterIP}
+	}
+	for _, ip := range ips {
+		// headless services have no cluster IP
+		if ip != "None" {
+			s.ips = append(s.ips, normalizeIP(ip))
+		}
+	}
+	c.services[key] = s
+	for _, ip := range s.ips {
+		c.servicesByIP[ip] = s
+	}
+}
+
+func (c *Cache) setNode(obj *nodeObject) {
+	name := obj.Metadata.Name
+	if _, ok := c.nodes[name]; ok {
+		c.removeNode(name)
+	}
+	n := &node{name: name}
+	for _, addr := range obj.Status.Addresses {
+		if addr.Type == "InternalIP" || addr.Type == "ExternalIP" {
+			n.ips = append(n.ips, normalizeIP(addr.Address))
+		}
+	}
+	c.nodes[name] = n
+	for _, ip := range n.ips {
+		c.nodesByIP[ip] = n
+	}
+}
+
+// normalizeIP returns an address as looked up, e.g. without the leading zeros of IPv6 ones.
+func normalizeIP(s string) string {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.String()
+	}
+	return s
+}
+
+// podStore is the store of the informer of the pods.
+type podStore struct{ c *Cache }
+
+func (s podStore) replace(objects []json.RawMessage) error {
+	pods := make([]podObject, len(objects))
+	for i, raw := range objects {
+		if err := json.Unmarshal(raw, &pods[i]); err != nil {
+			return fmt.Errorf("could not decode pod: %w", err)
+		}
+	}
+	s.c.lock.Lock()
+	defer s.c.lock.Unlock()
+	listed := map[string]bool{}
+	for i := range pods {
+		listed[pods[i].Metadata.UID] = true
+	}
+	// the pods deleted while the watch was down are still looked up for the DeletedTTL
+	for uid, p := range s.c.pods {
+		if !listed[uid] && p.deleted.IsZero() {
+			p.deleted = s.c.now()
+		}
+	}
+	for i := range pods {
+		s.c.setPod(&pods[i])
+	}
+	return nil
+}
+
+func (s podStore) update(raw json.RawMessage) error {
+	var obj podObject
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return fmt.Errorf("could not decode pod: %w", err)
+	}
+	s.c.lock.Lock()
+	defer s.c.lock.Unlock()
+	s.c.setPod(&obj)
+	return nil
+}
+
+func (s podStore) delete(raw json.RawMessage) error {
+	var obj podObject
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return fmt.Errorf("could not decode pod: %w", err)
+	}
+	s.c.lock.Lock()
+	defer s.c.lock.Unlock()
+	if p, ok := s.c.pods[obj.Metadata.UID]; ok && p.deleted.IsZero() {
+		p.deleted = s.c.now()
+	}
+	return nil
+}
+
+// serviceStore is the store of the informer of the services.
+type serviceStore struct{ c *Cache }
+
+func (s serviceStore) replace(objects []json.RawMessage) error {
+	services := make([]serviceObject, len(objects))
+	for i, raw := range objects {
+		if err := json.Unmarshal(raw, &services[i]); err != nil {
+			return fmt.Errorf("could not decode service: %w", err)
+		}
+	}
+	s.c.lock.Lock()
+	defer s.c.lock.Unlock()
+	listed := map[string]bool{}
+	for i := range services {
+		listed[services[i].Metadata.Namespace+"/"+services[i].Metadata.Name] = true
+	}
+	for key, svc := range s.c.services {
+		if !listed[key] && svc.deleted.IsZero() {
+			svc.deleted = s.c.now()
+		}
+	}
+	for i := range services {
+		s.c.setService(&services[i])
+	}
+	s.c.relinkServices("")
+	return nil
+}
+
+func (s serviceStore) update(raw json.RawMessage) error {
+	var obj serviceObject
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return fmt.Errorf("could not decode service: %w", err)
+	}
+	s.c.lock.Lock()
+	defer s.c.lock.Unlock()
+	s.c.setService(&obj)
+	s.c.relinkServices(obj.Metadata.Namespace)
+	return nil
+}
+
+func (s serviceStore) delete(raw json.RawMessage) error {
+	var obj serviceObject
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return fmt.Errorf("could not decode service: %w", err)
+	}
+	s.c.lock.Lock()
+	defer s.c.lock.Unlock()
+	if svc, ok := s.c.services[obj.Metadata.Namespace+"/"+obj.Metadata.Name]; ok && svc.deleted.IsZero() {
+		svc.deleted = s.c.now()
+		s.c.relinkServices(obj.Metadata.Namespace)
+	}
+	return nil
+}
+
+// nodeStore is the store of the informer of the nodes.
+type nodeStore struct{ c *Cache }
+
+func (s nodeStore) replace(objects []json.RawMessage) error {
+	nodes := make([]nodeObject, len(objects))
+	for i, raw := range objects {
+		if err := json.Unmarshal(raw, &nodes[i]); err != nil {
+			return fmt.Errorf("could not decode node: %w", err)
+		}
+	}
+	s.c.lock.Lock()
+	defer s.c.lock.Unlock()
+	listed := map[string]bool{}
+	for i := range nodes {
+		listed[nodes[i].Metadata.Name] = true
+	}
+	for name, n := range s.c.nodes {
+		if !listed[name] && n.deleted.IsZero() {
+			n.deleted = s.c.now()
+		}
+	}
+	for i := range nodes {
+		s.c.setNode(&nodes[i])
+	}
+	return nil
+}
+
+func (s nodeStore) update(raw json.RawMessage) error {
+	var obj nodeObject
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return fmt.Errorf("could not decode node: %w", err)
+	}
+	s.c.lock.Lock()
+	defer s.c.lock.Unlock()
+	s.c.setNode(&obj)
+	return nil
+}
+
+func (s nodeStore) delete(raw json.RawMessage) error {
+	var obj nodeObject
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return fmt.Errorf("could not decode node: %w", err)
+	}
+	s.c.lock.Lock()
+	defer s.c.lock.Unlock()
+	if n, ok := s.c.nodes[obj.Metadata.Name]; ok && n.deleted.IsZero() {
+		n.deleted = s.c.now()
+	}
+	return nil
+}
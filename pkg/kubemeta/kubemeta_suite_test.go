@@ -0,0 +1,13 @@
+package kubemeta
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestKubemeta(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Kubemeta Suite")
+}
@@ -0,0 +1,234 @@
+package kubemeta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/solo-io/bumblebee/pkg/internal/kube"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeAPIServer lists the objects of the resources by pages of one object, and streams the
+// events sent to their watches.
+type fakeAPIServer struct {
+	lock    sync.Mutex
+	objects map[string][]interface{}
+	watches map[string]chan watchEvent
+	// field selectors of the lists, by path
+	selectors map[string]string
+}
+
+func newFakeAPIServer() *fakeAPIServer {
+	return &fakeAPIServer{
+		objects:   map[string][]interface{}{},
+		watches:   map[string]chan watchEvent{},
+		selectors: map[string]string{},
+	}
+}
+
+func (f *fakeAPIServer) watch(path string) chan watchEvent {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.watches[path] == nil {
+		f.watches[path] = make(chan watchEvent, 10)
+	}
+	return f.watches[path]
+}
+
+func (f *fakeAPIServer) send(path, eventType string, object interface{}) {
+	raw, err := json.Marshal(object)
+	Expect(err).NotTo(HaveOccurred())
+	f.watch(path) <- watchEvent{Type: eventType, Object: raw}
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("watch") == "1" {
+		events := f.watch(r.URL.Path)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case event := <-events:
+				json.NewEncoder(w).Encode(event)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.selectors[r.URL.Path] = query.Get("fieldSelector")
+	objects := f.objects[r.URL.Path]
+	page := 0
+	fmt.Sscan(query.Get("continue"), &page)
+	list := map[string]interface{}{"metadata": map[string]string{"resourceVersion": "1"}, "items": []interface{}{}}
+	if page < len(objects) {
+		list["items"] = objects[page : page+1]
+		if page+1 < len(objects) {
+			list["metadata"] = map[string]string{"resourceVersion": "1", "continue": fmt.Sprint(page + 1)}
+		}
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+func podOf(name, uid, ip string, labels map[string]string) map[string]interface{} {
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name, "namespace": "shop", "uid": uid, "labels": labels, "resourceVersion": "2"},
+		"spec":     map[string]interface{}{"nodeName": "node-1"},
+		"status":   map[string]interface{}{"podIP": ip, "podIPs": []map[string]string{{"ip": ip}}},
+	}
+}
+
+var _ = Describe("Kubernetes metadata", func() {
+	var (
+		server *fakeAPIServer
+		cache  *Cache
+		now    time.Time
+		cancel context.CancelFunc
+		close  func()
+	)
+
+	BeforeEach(func() {
+		server = newFakeAPIServer()
+		server.objects["/api/v1/pods"] = []interface{}{
+			podOf("cart-1", "uid-1", "10.0.0.1", map[string]string{"app": "cart"}),
+			podOf("cart-2", "uid-2", "10.0.0.2", map[string]string{"app": "cart", "tier": "web"}),
+			map[string]interface{}{
+				"metadata": map[string]interface{}{"name": "proxy", "namespace": "kube-system", "uid": "uid-3"},
+				"spec":     map[string]interface{}{"nodeName": "node-1", "hostNetwork": true},
+				"status":   map[string]interface{}{"podIP": "192.168.1.10"},
+			},
+		}
+		server.objects["/api/v1/services"] = []interface{}{
+			map[string]interface{}{
+				"metadata": map[string]interface{}{"name": "cart", "namespace": "shop"},
+				"spec":     map[string]interface{}{"selector": map[string]string{"app": "cart"}, "clusterIP": "10.96.0.10", "clusterIPs": []string{"10.96.0.10"}},
+			},
+			map[string]interface{}{
+				"metadata": map[string]interface{}{"name": "web", "namespace": "shop"},
+				"spec":     map[string]interface{}{"selector": map[string]string{"tier": "web"}, "clusterIP": "None"},
+			},
+		}
+		server.objects["/api/v1/nodes"] = []interface{}{
+			map[string]interface{}{
+				"metadata": map[string]interface{}{"name": "node-1"},
+				"status":   map[string]interface{}{"addresses": []map[string]string{{"type": "InternalIP", "address": "192.168.1.10"}, {"type": "Hostname", "address": "node-1"}}},
+			},
+		}
+		httpServer := httptest.NewServer(server)
+		close = httpServer.Close
+
+		var err error
+		cache, err = New(Config{Config: kube.Config{APIServer: httpServer.URL}, Node: "node-1", MaxPods: 4})
+		Expect(err).NotTo(HaveOccurred())
+		now = time.Now()
+		cache.now = func() time.Time { return now }
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		Expect(cache.Start(ctx)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		cancel()
+		close()
+	})
+
+	// expire drops the objects deleted for as long as the DeletedTTL
+	expire := func() {
+		cache.lock.Lock()
+		defer cache.lock.Unlock()
+		now = now.Add(DefaultDeletedTTL)
+		cache.dropDeleted()
+	}
+
+	lookup := func(ip string) Meta {
+		return cache.LookupIP(net.ParseIP(ip))
+	}
+
+	It("looks up the pods, services and nodes of addresses", func() {
+		Expect(server.selectors["/api/v1/pods"]).To(Equal("spec.nodeName=node-1"))
+		Expect(lookup("10.0.0.1")).To(Equal(Meta{Pod: "cart-1", Namespace: "shop", Service: "cart", Node: "node-1"}))
+		Expect(lookup("10.0.0.2")).To(Equal(Meta{Pod: "cart-2", Namespace: "shop", Service: "cart,web", Node: "node-1"}))
+		Expect(lookup("10.96.0.10")).To(Equal(Meta{Namespace: "shop", Service: "cart"}))
+		// the address of the pods of the host network is the one of their node
+		Expect(lookup("192.168.1.10")).To(Equal(Meta{Node: "node-1"}))
+		Expect(lookup("8.8.8.8")).To(Equal(Meta{}))
+	})
+
+	It("applies the watched changes, still looking deleted pods up for a while", func() {
+		server.send("/api/v1/services", "DELETED", server.objects["/api/v1/services"][1])
+		Eventually(func() string { return lookup("10.0.0.2").Service }).Should(Equal("cart"))
+
+		server.send("/api/v1/pods", "DELETED", server.objects["/api/v1/pods"][0])
+		server.send("/api/v1/pods", "ADDED", podOf("cart-4", "uid-4", "10.0.0.4", map[string]string{"app": "cart"}))
+		Eventually(func() string { return lookup("10.0.0.4").Pod }).Should(Equal("cart-4"))
+		Expect(lookup("10.0.0.1").Pod).To(Equal("cart-1"))
+
+		// the cache is full until the deleted pod is dropped
+		server.send("/api/v1/pods", "ADDED", podOf("cart-5", "uid-5", "10.0.0.5", nil))
+		Consistently(func() Meta { return lookup("10.0.0.5") }, "100ms").Should(Equal(Meta{}))
+		expire()
+		Expect(lookup("10.0.0.1")).To(Equal(Meta{}))
+
+		// an address taken by a new pod is not dropped with the deleted one
+		server.send("/api/v1/pods", "DELETED", podOf("cart-4", "uid-4", "10.0.0.4", nil))
+		server.send("/api/v1/pods", "ADDED", podOf("cart-6", "uid-6", "10.0.0.4", nil))
+		Eventually(func() string { return lookup("10.0.0.4").Pod }).Should(Equal("cart-6"))
+		expire()
+		Expect(lookup("10.0.0.4").Pod).To(Equal("cart-6"))
+	})
+
+	It("marks the objects missing once listed again as deleted", func() {
+		objects, err := json.Marshal(server.objects["/api/v1/pods"][1:])
+		Expect(err).NotTo(HaveOccurred())
+		var raw []json.RawMessage
+		Expect(json.Unmarshal(objects, &raw)).To(Succeed())
+		Expect(podStore{cache}.replace(raw)).To(Succeed())
+		Expect(lookup("10.0.0.1").Pod).To(Equal("cart-1"))
+		expire()
+		Expect(lookup("10.0.0.1")).To(Equal(Meta{}))
+		Expect(lookup("10.0.0.2").Pod).To(Equal("cart-2"))
+	})
+
+	It("looks up the pods of cgroups", func() {
+		root, err := ioutil.TempDir("", "cgroups")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(root)
+		dirs := map[string]uint64{
+			"kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-poduid_1.slice":                                                         0,
+			"kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod0b5e7a4c_1d9f_4e3a_9b6c_5f8d2a1e7c40.slice/cri-containerd-abc.scope": 42,
+			"kubepods/burstable/pod9a1d3c1e-5b2f-4c8e-8f0a-2d7b6e4c1f3a":                                                                          43,
+			"system.slice/sshd.service": 44,
+		}
+		for dir := range dirs {
+			Expect(os.MkdirAll(filepath.Join(root, dir), 0755)).To(Succeed())
+		}
+		index := newCgroupIndex()
+		index.root = root
+		index.id = func(path string) (uint64, error) {
+			rel, _ := filepath.Rel(root, path)
+			return dirs[rel], nil
+		}
+		cache.cgroups = index
+		server.send("/api/v1/pods", "MODIFIED", podOf("cart-1", "0b5e7a4c-1d9f-4e3a-9b6c-5f8d2a1e7c40", "10.0.0.7", map[string]string{"app": "cart"}))
+		Eventually(func() string { return lookup("10.0.0.7").Pod }).Should(Equal("cart-1"))
+
+		Expect(index.scan()).To(Succeed())
+		Expect(cache.LookupCgroup(42)).To(Equal(Meta{Pod: "cart-1", Namespace: "shop", Service: "cart", Node: "node-1"}))
+		// the pod is not cached
+		Expect(cache.LookupCgroup(43)).To(Equal(Meta{}))
+		Expect(cache.LookupCgroup(44)).To(Equal(Meta{}))
+	})
+})
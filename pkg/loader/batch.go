@@ -0,0 +1,139 @@
+package loader
+
+import (
+	"sync"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+const (
+	defaultBatchMaxCount   = 500
+	defaultBatchMaxLatency = time.Second
+)
+
+// BatchOptions are when the entries batched by NewBatcher are delivered, whichever limit is
+// reached first.
+type BatchOptions struct {
+	// Entries per batch, defaults to 500
+	MaxCount int `yaml:"maxCount,omitempty"`
+	// Bytes of the names, keys and values of the entries per batch, unlimited if 0
+	MaxBytes int `yaml:"maxBytes,omitempty"`
+	// Time an entry waits for its batch to fill up, defaults to 1s
+	MaxLatency time.Duration `yaml:"maxLatency,omitempty"`
+}
+
+func (o *BatchOptions) initDefaults() {
+	if o.MaxCount <= 0 {
+		o.MaxCount = defaultBatchMaxCount
+	}
+	if o.MaxLatency <= 0 {
+		o.MaxLatency = defaultBatchMaxLatency
+	}
+}
+
+type batcher struct {
+	sink v1.BatchWatcher
+	opts BatchOptions
+
+	lock  sync.Mutex
+	batch []v1.MapEntry
+	bytes int
+	// incremented for each batch, so the timer of a delivered batch does not deliver the next
+	generation uint64
+	closed     bool
+	// held while delivering, acquired with the lock held so batches are delivered in order
+	delivering sync.Mutex
+}
+
+// NewBatcher returns a MapWatcher delivering the entries sent to it to the sink in batches.
+// Full batches are delivered by the goroutine sending their last entry, and the others once
+// their first entry waited for the MaxLatency. The sink is closed once the batched entries
+// are delivered.
+func NewBatcher(sink v1.BatchWatcher, opts BatchOptions) v1.MapWatcher {
+	opts.initDefaults()
+	return &batcher{sink: sink, opts: opts}
+}
+
+func (b *batcher) NewRingBuf(name string, keys []string) {
+	b.sink.NewRingBuf(name, keys)
+}
+
+func (b *batcher) NewHashMap(name string, keys []string) {
+	b.sink.NewHashMap(name, keys)
+}
+
+func (b *batcher) SendEntry(entry v1.MapEntry) {
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return
+	}
+	if len(b.batch) == 0 {
+		generation := b.generation
+		time.AfterFunc(b.opts.MaxLatency, func() {
+			b.lock.Lock()
+			if b.generation != generation {
+				b.lock.Unlock()
+				return
+			}
+			b.deliver()
+		})
+	}
+	b.batch = append(b.batch, entry)
+	b.bytes += entrySize(entry)
+	if len(b.batch) >= b.opts.MaxCount || (b.opts.MaxBytes > 0 && b.bytes >= b.opts.MaxBytes) {
+		b.deliver()
+		return
+	}
+	b.lock.Unlock()
+}
+
+// deliver must be called with the lock held, which it releases.
+func (b *batcher) deliver() {
+	batch := b.batch
+	b.batch, b.bytes = nil, 0
+	b.generation++
+	b.delivering.Lock()
+	defer b.delivering.Unlock()
+	b.lock.Unlock()
+	if len(batch) > 0 {
+		b.sink.WriteBatch(batch)
+	}
+}
+
+// Close delivers the batched entries and closes the sink.
+func (b *batcher) Close() {
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return
+	}
+	b.closed = true
+	b.deliver()
+	b.sink.Close()
+}
+
+func entrySize(entry v1.MapEntry) int {
+	size := len(entry.Name) + len(entry.Entry.Value)
+	for k, v := range entry.Entry.Key {
+		size += len(k) + len(v)
+	}
+	return size
+}
+
+type singleEntryAdapter struct {
+	v1.MapWatcher
+}
+
+// NewSingleEntryAdapter returns a BatchWatcher sending the entries of each batch one by one
+// to the watcher, for the simple sinks which do not benefit from batches.
+func NewSingleEntryAdapter(watcher v1.MapWatcher) v1.BatchWatcher {
+	return &singleEntryAdapter{MapWatcher: watcher}
+}
+
+func (a *singleEntryAdapter) WriteBatch(entries []v1.MapEntry) {
+	for _, entry := range entries {
+		a.SendEntry(entry)
+	}
+}
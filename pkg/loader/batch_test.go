@@ -0,0 +1,100 @@
+package loader
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+// batchRecorder records the batches it receives.
+type batchRecorder struct {
+	lock    sync.Mutex
+	batches [][]v1.MapEntry
+	closed  bool
+}
+
+func (r *batchRecorder) NewRingBuf(name string, keys []string) {}
+func (r *batchRecorder) NewHashMap(name string, keys []string) {}
+func (r *batchRecorder) WriteBatch(entries []v1.MapEntry) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.batches = append(r.batches, entries)
+}
+func (r *batchRecorder) Close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closed = true
+}
+
+func (r *batchRecorder) sizes() []int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var sizes []int
+	for _, batch := range r.batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+// entryRecorder records the entries it receives one by one.
+type entryRecorder struct {
+	noopWatcher
+	entries []v1.MapEntry
+}
+
+func (r *entryRecorder) SendEntry(entry v1.MapEntry) {
+	r.entries = append(r.entries, entry)
+}
+
+func batchEntry(value string) v1.MapEntry {
+	return v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"pid": value}}}
+}
+
+var _ = Describe("batches", func() {
+	It("delivers full batches in order", func() {
+		sink := &batchRecorder{}
+		batcher := NewBatcher(sink, BatchOptions{MaxCount: 2, MaxLatency: time.Hour})
+		for _, pid := range []string{"1", "2", "3", "4", "5"} {
+			batcher.SendEntry(batchEntry(pid))
+		}
+		Expect(sink.sizes()).To(Equal([]int{2, 2}))
+		Expect(sink.batches[1][0]).To(Equal(batchEntry("3")))
+
+		batcher.Close()
+		Expect(sink.sizes()).To(Equal([]int{2, 2, 1}))
+		Expect(sink.closed).To(BeTrue())
+		batcher.SendEntry(batchEntry("6"))
+		Expect(sink.sizes()).To(HaveLen(3))
+	})
+
+	It("delivers batches once they are big enough", func() {
+		sink := &batchRecorder{}
+		// the name, key and value of each entry are 11 bytes
+		batcher := NewBatcher(sink, BatchOptions{MaxBytes: 30, MaxLatency: time.Hour})
+		for _, pid := range []string{"10", "20", "30"} {
+			batcher.SendEntry(batchEntry(pid))
+		}
+		Expect(sink.sizes()).To(Equal([]int{3}))
+	})
+
+	It("delivers batches which did not fill up after the latency", func() {
+		sink := &batchRecorder{}
+		batcher := NewBatcher(sink, BatchOptions{MaxLatency: 20 * time.Millisecond})
+		batcher.SendEntry(batchEntry("1"))
+		batcher.SendEntry(batchEntry("2"))
+		Eventually(sink.sizes).Should(Equal([]int{2}))
+
+		batcher.SendEntry(batchEntry("3"))
+		Eventually(sink.sizes).Should(Equal([]int{2, 1}))
+		Consistently(sink.sizes, 50*time.Millisecond).Should(HaveLen(2))
+	})
+
+	It("adapts simple sinks", func() {
+		sink := &entryRecorder{}
+		NewSingleEntryAdapter(sink).WriteBatch([]v1.MapEntry{batchEntry("1"), batchEntry("2")})
+		Expect(sink.entries).To(Equal([]v1.MapEntry{batchEntry("1"), batchEntry("2")}))
+	})
+})
@@ -0,0 +1,112 @@
+package loader
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// BlastRadius is what an enforcing program, one which can drop packets or deny syscalls, can
+// affect once attached with the options, to be reviewed before it is loaded.
+type BlastRadius struct {
+	Hooks []BlastHook
+	// Settings maps the programs read their policy from, e.g. deny lists
+	EnforcementMaps []string
+	// Whether a hook applies to the whole node: a probe which is not scoped to cgroups, or a
+	// network hook of an interface of the namespace of bee, e.g. the uplink of the node
+	NodeWide bool
+}
+
+// BlastHook is a hook an enforcing program is attached to.
+type BlastHook struct {
+	// Name of the function of the program
+	Program string
+	// kprobe, kretprobe, tracepoint, xdp, tc-ingress or tc-egress
+	Type string
+	// Kernel function, tracepoint or network interface attached to
+	Target string
+	// What the hook applies to, e.g. every process of the node
+	Scope string
+}
+
+// EstimateBlastRadius returns the hooks the programs of the options would be attached to,
+// and what each of them applies to, without loading anything.
+func EstimateBlastRadius(opts *LoadOptions) BlastRadius {
+	spec := opts.ParsedELF.Spec
+	var radius BlastRadius
+	names := make([]string, 0, len(spec.Programs))
+	for name := range spec.Programs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	processes := "every process of the node"
+	if _, ok := spec.Maps[CgroupMapName]; ok && len(opts.Cgroups) > 0 {
+		processes = "the processes of the cgroups " + strings.Join(opts.Cgroups, ", ")
+	}
+	for _, name := range names {
+		prog := spec.Programs[name]
+		hook := BlastHook{Program: prog.Name, Target: prog.AttachTo, Scope: processes}
+		switch prog.Type {
+		case ebpf.Kprobe:
+			hook.Type = "kprobe"
+			if strings.HasPrefix(prog.SectionName, "kretprobe/") {
+				hook.Type = "kretprobe"
+			}
+		case ebpf.TracePoint:
+			if !strings.HasPrefix(prog.SectionName, "tracepoint/") {
+				continue
+			}
+			hook.Type = "tracepoint"
+		case ebpf.XDP, ebpf.SchedCLS:
+			hook.Type, hook.Target = "xdp", opts.Interface
+			if prog.Type == ebpf.SchedCLS {
+				hook.Type = "tc-ingress"
+				if strings.HasSuffix(prog.SectionName, "/egress") {
+					hook.Type = "tc-egress"
+				}
+			}
+			switch {
+			case opts.Interface == "":
+				hook.Scope = "no interface, so it can't be attached"
+			case len(opts.Netns) == 0:
+				hook.Scope = fmt.Sprintf("all the traffic of the %s interface of the node", opts.Interface)
+			case opts.HostVeth:
+				hook.Scope = fmt.Sprintf("the traffic of the host end of the veth of %s of the network namespaces %s", opts.Interface, strings.Join(opts.Netns, ", "))
+			default:
+				hook.Scope = fmt.Sprintf("the traffic of %s in the network namespaces %s", opts.Interface, strings.Join(opts.Netns, ", "))
+			}
+		default:
+			hook.Type = prog.Type.String()
+		}
+		radius.NodeWide = radius.NodeWide || strings.Contains(hook.Scope, "of the node")
+		radius.Hooks = append(radius.Hooks, hook)
+	}
+	for name, mapSpec := range spec.Maps {
+		if isSettingsMap(mapSpec) {
+			radius.EnforcementMaps = append(radius.EnforcementMaps, name)
+		}
+	}
+	sort.Strings(radius.EnforcementMaps)
+	return radius
+}
+
+// String summarizes the blast radius, a line per hook.
+func (b BlastRadius) String() string {
+	var lines []string
+	for _, hook := range b.Hooks {
+		target := ""
+		if hook.Target != "" {
+			target = " " + hook.Target
+		}
+		lines = append(lines, fmt.Sprintf("  %s %s%s: %s", hook.Program, hook.Type, target, hook.Scope))
+	}
+	if len(b.EnforcementMaps) > 0 {
+		lines = append(lines, "  policy read from the maps "+strings.Join(b.EnforcementMaps, ", "))
+	}
+	if b.NodeWide {
+		lines = append(lines, "  it applies to the whole node, and to every node it runs on")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,48 @@
+package loader
+
+import (
+	"github.com/cilium/ebpf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("EstimateBlastRadius", func() {
+	spec := &ebpf.CollectionSpec{
+		Programs: map[string]*ebpf.ProgramSpec{
+			"deny_exec": {Name: "deny_exec", Type: ebpf.Kprobe, SectionName: "kprobe/security_bprm_check", AttachTo: "security_bprm_check"},
+			"drop":      {Name: "drop", Type: ebpf.XDP, SectionName: "xdp"},
+			"egress":    {Name: "egress", Type: ebpf.SchedCLS, SectionName: "classifier/egress"},
+		},
+		Maps: map[string]*ebpf.MapSpec{
+			"denylist":    {Name: "denylist", Type: ebpf.Hash, SectionName: ".maps.settings"},
+			CgroupMapName: {Name: CgroupMapName, Type: ebpf.Hash, SectionName: ".maps"},
+		},
+	}
+
+	It("reports the hooks applying to the whole node", func() {
+		radius := EstimateBlastRadius(&LoadOptions{ParsedELF: &ParsedELF{Spec: spec}, Interface: "eth0"})
+		Expect(radius.Hooks).To(Equal([]BlastHook{
+			{Program: "deny_exec", Type: "kprobe", Target: "security_bprm_check", Scope: "every process of the node"},
+			{Program: "drop", Type: "xdp", Target: "eth0", Scope: "all the traffic of the eth0 interface of the node"},
+			{Program: "egress", Type: "tc-egress", Target: "eth0", Scope: "all the traffic of the eth0 interface of the node"},
+		}))
+		Expect(radius.EnforcementMaps).To(Equal([]string{"denylist"}))
+		Expect(radius.NodeWide).To(BeTrue())
+		Expect(radius.String()).To(ContainSubstring("it applies to the whole node"))
+	})
+
+	It("reports the scope of the hooks", func() {
+		radius := EstimateBlastRadius(&LoadOptions{
+			ParsedELF: &ParsedELF{Spec: spec},
+			Interface: "eth0",
+			Netns:     []string{"/var/run/netns/pod"},
+			HostVeth:  true,
+			Cgroups:   []string{"/sys/fs/cgroup/kubepods"},
+		})
+		Expect(radius.Hooks[0].Scope).To(Equal("the processes of the cgroups /sys/fs/cgroup/kubepods"))
+		Expect(radius.Hooks[1].Scope).To(Equal("the traffic of the host end of the veth of eth0 of the network namespaces /var/run/netns/pod"))
+		Expect(radius.NodeWide).To(BeFalse())
+		Expect(radius.String()).NotTo(ContainSubstring("whole node"))
+	})
+})
@@ -0,0 +1,212 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+const (
+	// CgroupMapName is the map programs declare to be scoped to cgroups: a hash map keyed by
+	// the u64 IDs of the cgroups, as returned by bpf_get_current_cgroup_id(), filled with the
+	// Cgroups of the LoadOptions.
+	CgroupMapName = "bee_cgroups"
+	// CgroupScopedConstant is the `const volatile bool` global set to true when the program
+	// is scoped to cgroups, so it only looks the cgroup up in the CgroupMapName map then.
+	CgroupScopedConstant = "bee_cgroup_scoped"
+)
+
+// CgroupMode is the cgroup hierarchies mounted on the host.
+type CgroupMode string
+
+const (
+	// CgroupV1 only mounts the hierarchies of the controllers
+	CgroupV1 CgroupMode = "v1"
+	// CgroupHybrid mounts the unified hierarchy in the unified directory, next to the
+	// hierarchies of the controllers
+	CgroupHybrid CgroupMode = "hybrid"
+	// CgroupV2 only mounts the unified hierarchy
+	CgroupV2 CgroupMode = "v2"
+)
+
+var (
+	cgroupRoot = "/sys/fs/cgroup"
+	// overridden by tests, which can't mount cgroup file systems
+	cgroupMode = DetectCgroupMode
+	cgroupID   = cgroupHandleID
+)
+
+// unifiedRoot returns where the unified hierarchy is mounted.
+func (m CgroupMode) unifiedRoot() (string, error) {
+	switch m {
+	case CgroupV2:
+		return cgroupRoot, nil
+	case CgroupHybrid:
+		return filepath.Join(cgroupRoot, "unified"), nil
+	}
+	return "", errors.New("scoping programs to cgroups requires the unified cgroup v2 hierarchy, " +
+		"the one bpf_get_current_cgroup_id() reads, but this host only mounts cgroup v1: " +
+		"network namespaces can still be selected by container or pod, but programs can't be scoped to their cgroups")
+}
+
+// resolveCgroups returns the IDs of the cgroups of the selectors, sorted: the cgroup of a
+// container:ID or the pod:UID of a Kubernetes pod, or a directory of the unified hierarchy,
+// along with all the cgroups below them.
+func resolveCgroups(selectors []string) ([]uint64, error) {
+	if len(selectors) == 0 {
+		return nil, nil
+	}
+	mode, err := cgroupMode()
+	if err != nil {
+		return nil, err
+	}
+	root, err := mode.unifiedRoot()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[uint64]bool{}
+	var ids []uint64
+	for _, selector := range selectors {
+		dirs, err := cgroupDirs(root, selector)
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if !d.IsDir() {
+					return nil
+				}
+				id, err := cgroupID(path)
+				if err != nil {
+					return fmt.Errorf("could not get the ID of cgroup %s: %w", path, err)
+				}
+				if !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// cgroupDirs returns the directories of the unified hierarchy a selector names.
+func cgroupDirs(root, selector string) ([]string, error) {
+	if strings.HasPrefix(selector, "/") {
+		rel, err := filepath.Rel(root, selector)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return nil, fmt.Errorf("cgroup %s is not in the unified hierarchy mounted at %s", selector, root)
+		}
+		return []string{selector}, nil
+	}
+	i := strings.Index(selector, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("unknown cgroup %s, must be a path, container:ID or pod:UID", selector)
+	}
+	kind, id := selector[:i], selector[i+1:]
+	patterns, err := cgroupPatterns(kind, id)
+	if err != nil {
+		return nil, err
+	}
+	procs, err := processCgroups(patterns)
+	if err != nil {
+		return nil, err
+	}
+	// the cgroup named by the pattern, above the cgroups of the processes, e.g. the pod
+	// slice above the scopes of its containers
+	found := map[string]bool{}
+	for _, cgroups := range procs {
+		path, ok := unifiedPath(cgroups)
+		if !ok {
+			continue
+		}
+		parts := strings.Split(path, "/")
+		for j, part := range parts {
+			if containsAny(part, patterns) {
+				found[filepath.Join(root, filepath.Join(parts[:j+1]...))] = true
+				break
+			}
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no process of %s %s found in the unified cgroup hierarchy", kind, id)
+	}
+	var dirs []string
+	for dir := range found {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// unifiedPath returns the path of a process in the unified hierarchy, from the "0::/path"
+// line of its cgroup file.
+func unifiedPath(cgroups string) (string, bool) {
+	for _, line := range strings.Split(cgroups, "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::"), true
+		}
+	}
+	return "", false
+}
+
+func containsAny(s string, patterns []string) bool {
+	for _, p := range patterns {
+		if strings.Contains(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeCgroups fills the cgroup map of the program with the IDs of the cgroups.
+func scopeCgroups(maps map[string]*ebpf.Map, ids []uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	m, ok := maps[CgroupMapName]
+	if !ok {
+		return fmt.Errorf("the program must declare a %s map to be scoped to cgroups", CgroupMapName)
+	}
+	if m.Type() != ebpf.Hash || m.KeySize() != 8 {
+		return fmt.Errorf("the %s map must be a hash map keyed by u64 cgroup IDs", CgroupMapName)
+	}
+	// a pinned map may have been filled by a previous run
+	var stale []uint64
+	var key uint64
+	iter := m.Iterate()
+	for iter.Next(&key, new([]byte)) {
+		stale = append(stale, key)
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("could not read %s: %w", CgroupMapName, err)
+	}
+	for _, key := range stale {
+		if err := m.Delete(key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("could not clear %s: %w", CgroupMapName, err)
+		}
+	}
+	value := make([]byte, m.ValueSize())
+	if len(value) > 0 {
+		value[0] = 1
+	}
+	for _, id := range ids {
+		if err := m.Put(id, value); err != nil {
+			return fmt.Errorf("could not add cgroup %d to %s: %w", id, CgroupMapName, err)
+		}
+	}
+	return nil
+}
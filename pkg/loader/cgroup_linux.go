@@ -0,0 +1,37 @@
+package loader
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"golang.org/x/sys/unix"
+)
+
+// DetectCgroupMode returns the cgroup hierarchies mounted on the host, from the file
+// systems mounted at /sys/fs/cgroup.
+func DetectCgroupMode() (CgroupMode, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(cgroupRoot, &st); err != nil {
+		return "", fmt.Errorf("could not detect the cgroup hierarchies: %w", err)
+	}
+	if st.Type == unix.CGROUP2_SUPER_MAGIC {
+		return CgroupV2, nil
+	}
+	if err := unix.Statfs(filepath.Join(cgroupRoot, "unified"), &st); err == nil && st.Type == unix.CGROUP2_SUPER_MAGIC {
+		return CgroupHybrid, nil
+	}
+	return CgroupV1, nil
+}
+
+// cgroupHandleID returns the ID of a cgroup, its file handle in the cgroup file system.
+func cgroupHandleID(path string) (uint64, error) {
+	handle, _, err := unix.NameToHandleAt(unix.AT_FDCWD, path, 0)
+	if err != nil {
+		return 0, err
+	}
+	if len(handle.Bytes()) != 8 {
+		return 0, fmt.Errorf("unexpected file handle of %d bytes", len(handle.Bytes()))
+	}
+	return decoder.Endianess.Uint64(handle.Bytes()), nil
+}
@@ -0,0 +1,126 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/cilium/ebpf"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cgroups", func() {
+	var (
+		origProcDir, origCgroupRoot string
+		origMode                    func() (CgroupMode, error)
+		origID                      func(string) (uint64, error)
+		mode                        CgroupMode
+		inode                       func(path string) uint64
+	)
+
+	const (
+		podSlice  = "kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod9a1d3c1e_5b2f_4c8e_8f0a_2d7b6e4c1f3a.slice"
+		container = podSlice + "/cri-containerd-4f6c1a2b9d3e7f80.scope"
+		pause     = podSlice + "/cri-containerd-0d1e2f3a4b5c6d7e.scope"
+	)
+
+	BeforeEach(func() {
+		origProcDir, origCgroupRoot, origMode, origID = procDir, cgroupRoot, cgroupMode, cgroupID
+		var err error
+		procDir, err = os.MkdirTemp("", "bee-proc")
+		Expect(err).NotTo(HaveOccurred())
+		cgroupRoot, err = os.MkdirTemp("", "bee-cgroup")
+		Expect(err).NotTo(HaveOccurred())
+		mode = CgroupV2
+		cgroupMode = func() (CgroupMode, error) { return mode, nil }
+		inode = func(path string) uint64 {
+			info, err := os.Stat(path)
+			Expect(err).NotTo(HaveOccurred())
+			return info.Sys().(*syscall.Stat_t).Ino
+		}
+		cgroupID = func(path string) (uint64, error) { return inode(path), nil }
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(procDir)
+		os.RemoveAll(cgroupRoot)
+		procDir, cgroupRoot, cgroupMode, cgroupID = origProcDir, origCgroupRoot, origMode, origID
+	})
+
+	setup := func(unified string, cgroups map[string]string) {
+		for _, dir := range []string{container, pause, "system.slice"} {
+			Expect(os.MkdirAll(filepath.Join(unified, dir), 0755)).To(Succeed())
+		}
+		for pid, cgroup := range cgroups {
+			Expect(os.MkdirAll(filepath.Join(procDir, pid), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(procDir, pid, "cgroup"), []byte(cgroup), 0644)).To(Succeed())
+		}
+	}
+
+	It("resolves containers, pods and paths of the unified hierarchy", func() {
+		setup(cgroupRoot, map[string]string{
+			"420": "0::/" + container + "\n",
+			"421": "0::/" + pause + "\n",
+			"1":   "0::/init.scope\n",
+		})
+		Expect(resolveCgroups([]string{"container:4f6c1a2b9d3e"})).To(Equal([]uint64{inode(filepath.Join(cgroupRoot, container))}))
+
+		ids, err := resolveCgroups([]string{"pod:9a1d3c1e-5b2f-4c8e-8f0a-2d7b6e4c1f3a"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ids).To(ConsistOf(
+			inode(filepath.Join(cgroupRoot, podSlice)),
+			inode(filepath.Join(cgroupRoot, container)),
+			inode(filepath.Join(cgroupRoot, pause)),
+		))
+
+		Expect(resolveCgroups([]string{filepath.Join(cgroupRoot, "system.slice")})).To(Equal([]uint64{inode(filepath.Join(cgroupRoot, "system.slice"))}))
+		_, err = resolveCgroups([]string{"/var/run"})
+		Expect(err).To(MatchError(ContainSubstring("not in the unified hierarchy")))
+		_, err = resolveCgroups([]string{"vm:1"})
+		Expect(err).To(MatchError(ContainSubstring("unknown cgroup kind")))
+		_, err = resolveCgroups([]string{"container:7d2e9b1c4a5f6e80"})
+		Expect(err).To(MatchError(ContainSubstring("no process of container")))
+	})
+
+	It("resolves cgroups in the unified directory of hybrid hierarchies", func() {
+		mode = CgroupHybrid
+		unified := filepath.Join(cgroupRoot, "unified")
+		setup(unified, map[string]string{
+			"420": "12:pids:/" + container + "\n1:name=systemd:/" + container + "\n0::/" + container + "\n",
+		})
+		Expect(resolveCgroups([]string{"container:4f6c1a2b9d3e"})).To(Equal([]uint64{inode(filepath.Join(unified, container))}))
+	})
+
+	It("explains what is unsupported with cgroup v1", func() {
+		mode = CgroupV1
+		setup(cgroupRoot, map[string]string{"500": "12:pids:/docker/7d2e9b1c4a5f6e80\n"})
+		_, err := resolveCgroups([]string{"container:7d2e9b1c4a5f6e80"})
+		Expect(err).To(MatchError(ContainSubstring("only mounts cgroup v1")))
+		// network namespaces are still resolved from the hierarchies of the controllers
+		Expect(netnsPath("container:7d2e9b1c4a5f6e80")).To(Equal(filepath.Join(procDir, "500", "ns", "net")))
+	})
+
+	It("fills the cgroup map of the program", func() {
+		m, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Hash, KeySize: 8, ValueSize: 1, MaxEntries: 16})
+		if err != nil {
+			Skip("creating maps needs privileges: " + err.Error())
+		}
+		defer m.Close()
+		Expect(m.Put(uint64(7), []byte{1})).To(Succeed())
+
+		Expect(scopeCgroups(map[string]*ebpf.Map{CgroupMapName: m}, []uint64{42, 43})).To(Succeed())
+		var keys []uint64
+		var key uint64
+		var value []byte
+		iter := m.Iterate()
+		for iter.Next(&key, &value) {
+			keys = append(keys, key)
+			Expect(value).To(Equal([]byte{1}))
+		}
+		Expect(iter.Err()).NotTo(HaveOccurred())
+		Expect(keys).To(ConsistOf(uint64(42), uint64(43)))
+
+		Expect(scopeCgroups(map[string]*ebpf.Map{}, []uint64{42})).To(MatchError(ContainSubstring("must declare a bee_cgroups map")))
+	})
+})
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package loader
+
+import "errors"
+
+var errCgroupsUnsupported = errors.New("cgroups are only supported on linux")
+
+// DetectCgroupMode fails, as there are no cgroups outside of Linux.
+func DetectCgroupMode() (CgroupMode, error) {
+	return "", errCgroupsUnsupported
+}
+
+func cgroupHandleID(path string) (uint64, error) {
+	return 0, errCgroupsUnsupported
+}
@@ -0,0 +1,120 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/cilium/ebpf"
+)
+
+// MapPinning is which maps are pinned to the PinMaps directory of the LoadOptions.
+type MapPinning string
+
+const (
+	// PinAllMaps pins every map but the read-only data, opening the pinned ones if they
+	// already exist
+	PinAllMaps MapPinning = "all"
+	// PinDeclaredMaps only pins the maps declaring it in the program, with
+	// `__uint(pinning, LIBBPF_PIN_BY_NAME)`
+	PinDeclaredMaps MapPinning = "declared"
+)
+
+func ParseMapPinning(s string) (MapPinning, error) {
+	switch p := MapPinning(s); p {
+	case PinAllMaps, PinDeclaredMaps:
+		return p, nil
+	case "":
+		return PinAllMaps, nil
+	}
+	return "", fmt.Errorf("unknown map pinning '%s', must be one of all or declared", s)
+}
+
+// pinMaps sets the pinning of the maps of the spec as the options require.
+func pinMaps(spec *ebpf.CollectionSpec, opts *LoadOptions) error {
+	if opts.PinMaps == "" {
+		return nil
+	}
+	pinning, err := ParseMapPinning(string(opts.MapPinning))
+	if err != nil {
+		return err
+	}
+	if pinning == PinDeclaredMaps {
+		return nil
+	}
+	// Specify that we'd like to pin the referenced maps, or open them if already existing.
+	for _, m := range spec.Maps {
+		// Do not pin/load read-only data
+		if strings.HasSuffix(m.Name, ".rodata") {
+			continue
+		}
+
+		// PinByName specifies that we should pin the map by name, or load it if it already exists.
+		m.Pinning = ebpf.PinByName
+	}
+	return nil
+}
+
+// loadCollection loads the spec into the kernel with the options, retrying when the
+// verifier log does not fit in its buffer.
+func loadCollection(spec *ebpf.CollectionSpec, opts *LoadOptions, debug *debugLog) (*ebpf.Collection, error) {
+	progOpts := ebpf.ProgramOptions{}
+	if opts.TargetBTF != "" {
+		f, err := os.Open(opts.TargetBTF)
+		if err != nil {
+			return nil, fmt.Errorf("could not open the target BTF: %w", err)
+		}
+		defer f.Close()
+		progOpts.TargetBTF = f
+	}
+	attempts := opts.VerifierAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	// set once the verifier log did not fit in its buffer
+	grownLogSize := 0
+	for attempt := 1; ; attempt++ {
+		progOpts.LogLevel, progOpts.LogSize = opts.verifierLog(debug)
+		if grownLogSize > progOpts.LogSize {
+			progOpts.LogSize = grownLogSize
+		}
+		coll, err := ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{
+			Maps: ebpf.MapOptions{
+				PinPath: opts.PinMaps,
+			},
+			Programs: progOpts,
+		})
+		if err == nil || !errors.Is(err, syscall.ENOSPC) || progOpts.LogLevel == 0 {
+			return coll, err
+		}
+		if attempt < attempts {
+			grownLogSize = 2 * progOpts.LogSize
+			debug.infof("", "The verifier log does not fit in %d bytes, loading again with %d", progOpts.LogSize, grownLogSize)
+			continue
+		}
+		// the debug log falls back to the info level, unless the options set the log level
+		if opts.VerifierLogLevel != 0 || !debug.truncated(err) {
+			return nil, err
+		}
+		grownLogSize = 0
+	}
+}
+
+// verifierLog returns the log level and size of the verifier, as set by the options or
+// required by the debug log.
+func (o *LoadOptions) verifierLog(debug *debugLog) (uint32, int) {
+	debugOpts := debug.programOptions()
+	level, size := debugOpts.LogLevel, debugOpts.LogSize
+	if o.VerifierLogLevel != 0 {
+		level = o.VerifierLogLevel
+	}
+	if o.VerifierLogSize > 0 {
+		size = o.VerifierLogSize
+	}
+	if level != 0 && size == 0 {
+		size = ebpf.DefaultVerifierLogSize
+	}
+	return level, size
+}
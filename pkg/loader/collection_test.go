@@ -0,0 +1,58 @@
+package loader
+
+import (
+	"github.com/cilium/ebpf"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+var _ = Describe("collection options", func() {
+	newSpec := func() *ebpf.CollectionSpec {
+		return &ebpf.CollectionSpec{Maps: map[string]*ebpf.MapSpec{
+			"events":  {Name: "events"},
+			"config":  {Name: "config", Pinning: ebpf.PinByName},
+			".rodata": {Name: ".rodata"},
+		}}
+	}
+
+	It("pins every map but the read-only data by default", func() {
+		spec := newSpec()
+		Expect(pinMaps(spec, &LoadOptions{PinMaps: "/sys/fs/bpf/bee"})).To(Succeed())
+		Expect(spec.Maps["events"].Pinning).To(Equal(ebpf.PinByName))
+		Expect(spec.Maps["config"].Pinning).To(Equal(ebpf.PinByName))
+		Expect(spec.Maps[".rodata"].Pinning).To(Equal(ebpf.PinNone))
+	})
+
+	It("only pins the maps declaring it if asked to", func() {
+		spec := newSpec()
+		Expect(pinMaps(spec, &LoadOptions{PinMaps: "/sys/fs/bpf/bee", MapPinning: PinDeclaredMaps})).To(Succeed())
+		Expect(spec.Maps["events"].Pinning).To(Equal(ebpf.PinNone))
+		Expect(spec.Maps["config"].Pinning).To(Equal(ebpf.PinByName))
+
+		Expect(pinMaps(spec, &LoadOptions{PinMaps: "/sys/fs/bpf/bee", MapPinning: "some"})).To(MatchError(ContainSubstring("must be one of all or declared")))
+	})
+
+	It("sets the verifier log of the options over the one of the debug log", func() {
+		opts := &LoadOptions{}
+		level, size := opts.verifierLog(nil)
+		Expect(level).To(BeZero())
+		Expect(size).To(BeZero())
+
+		opts.VerifierLogLevel = verifierLogLevel1
+		level, size = opts.verifierLog(nil)
+		Expect(level).To(BeEquivalentTo(verifierLogLevel1))
+		Expect(size).To(Equal(ebpf.DefaultVerifierLogSize))
+
+		debug := &debugLog{level: v1.DebugLogVerbose, send: func(v1.DebugLogEntry) {}}
+		opts = &LoadOptions{VerifierLogSize: 1 << 10}
+		level, size = opts.verifierLog(debug)
+		Expect(level).To(BeEquivalentTo(verifierLogLevel2 | verifierLogStats))
+		Expect(size).To(Equal(1 << 10))
+	})
+
+	It("does not load the program if its target BTF can't be read", func() {
+		_, err := loadCollection(newSpec(), &LoadOptions{TargetBTF: "/nonexistent/vmlinux"}, nil)
+		Expect(err).To(MatchError(ContainSubstring("could not open the target BTF")))
+	})
+})
@@ -0,0 +1,151 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/stats"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+const consumeKeyword = "consume"
+
+// isConsumeMap returns whether entries should be deleted from the map once read,
+// queue and stack maps are always consumed.
+func isConsumeMap(spec *ebpf.MapSpec) bool {
+	if spec.Type == ebpf.Queue || spec.Type == ebpf.Stack {
+		return true
+	}
+	return hasKeyword(spec, consumeKeyword)
+}
+
+type rawEntry struct {
+	key, value []byte
+}
+
+// mapReader reads all entries of a hash map, optionally deleting them once read.
+type mapReader struct {
+	consume bool
+	// set once the kernel reported it does not support atomic lookup and delete for hash maps
+	noLookupAndDelete bool
+}
+
+func (r *mapReader) read(liveMap *ebpf.Map) ([]rawEntry, error) {
+	var entries []rawEntry
+	mapIter := liveMap.Iterate()
+	for {
+		// Use generic key,value so we can decode ourselves
+		var (
+			key, value []byte
+		)
+		if !mapIter.Next(&key, &value) {
+			break
+		}
+		entries = append(entries, rawEntry{key: key, value: value})
+	}
+	if err := mapIter.Err(); err != nil {
+		return nil, err
+	}
+	if !r.consume {
+		return entries, nil
+	}
+
+	// deleting while iterating may restart the iteration, so keys are collected first
+	consumed := entries[:0]
+	for _, entry := range entries {
+		value, err := r.lookupAndDelete(liveMap, entry.key)
+		if errors.Is(err, ebpf.ErrKeyNotExist) {
+			// deleted in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		consumed = append(consumed, rawEntry{key: entry.key, value: value})
+	}
+	return consumed, nil
+}
+
+func (r *mapReader) lookupAndDelete(liveMap *ebpf.Map, key []byte) ([]byte, error) {
+	var value []byte
+	if !r.noLookupAndDelete {
+		err := liveMap.LookupAndDelete(key, &value)
+		if !errors.Is(err, ebpf.ErrNotSupported) {
+			return value, err
+		}
+		// hash maps only support lookup and delete from 5.14 onwards, fall back to
+		// a separate lookup and delete, which may lose updates made in between
+		r.noLookupAndDelete = true
+	}
+	if err := liveMap.Lookup(key, &value); err != nil {
+		return nil, err
+	}
+	if err := liveMap.Delete(key); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// startQueue pops all values from a queue or stack map on an interval and handles
+// each of them as an event, the same way as ringbuf entries.
+func (l *loader) startQueue(
+	ctx context.Context,
+	valueStruct *btf.Struct,
+	liveMap *ebpf.Map,
+	incrementInstrument stats.IncrementInstrument,
+	latency *latencyStats,
+	name string,
+	watcher v1.MapWatcher,
+	opts *LoadOptions,
+) error {
+	d := l.decoderFactory()
+	labels := opts.ParsedELF.WatchedMaps[name].labels
+	logger := contextutils.LoggerFrom(ctx)
+
+	interval := opts.liveSettings().PollInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if current := opts.liveSettings().PollInterval; current != interval {
+				ticker.Reset(current)
+				interval = current
+			}
+			for {
+				var value []byte
+				// queue and stack maps have no keys
+				err := liveMap.LookupAndDelete(nil, &value)
+				if errors.Is(err, ebpf.ErrKeyNotExist) {
+					break
+				}
+				if err != nil {
+					logger.Infof("error while popping from map '%s': %s", name, err)
+					opts.Status.recordError(name, err)
+					break
+				}
+
+				result, err := d.DecodeBtfBinary(ctx, valueStruct, value)
+				if err != nil {
+					return fmt.Errorf("error decoding value: %w", err)
+				}
+				latency.observe(result)
+				stringLabels := labels.stringify(result)
+				incrementInstrument.Increment(ctx, stringLabels)
+				watcher.SendEntry(v1.MapEntry{
+					Name: name,
+					Entry: v1.KvPair{
+						Key: stringLabels,
+					},
+				})
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package loader
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func u32Bytes(values ...uint32) []byte {
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(b[4*i:], v)
+	}
+	return b
+}
+
+var _ = Describe("mapReader", func() {
+	var m *ebpf.Map
+
+	BeforeEach(func() {
+		var err error
+		m, err = ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Hash, KeySize: 4, ValueSize: 4, MaxEntries: 8})
+		if err != nil {
+			Skip("creating maps needs privileges: " + err.Error())
+		}
+		for _, k := range []uint32{1, 2, 3} {
+			Expect(m.Put(k, k*10)).To(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		if m != nil {
+			m.Close()
+		}
+	})
+
+	values := func(entries []rawEntry) map[uint32]uint32 {
+		read := map[uint32]uint32{}
+		for _, entry := range entries {
+			read[binary.LittleEndian.Uint32(entry.key)] = binary.LittleEndian.Uint32(entry.value)
+		}
+		return read
+	}
+
+	It("reads all the entries, keeping them", func() {
+		entries, err := (&mapReader{}).read(m)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values(entries)).To(Equal(map[uint32]uint32{1: 10, 2: 20, 3: 30}))
+		Expect(m.Iterate().Next(new([]byte), new([]byte))).To(BeTrue())
+	})
+
+	It("deletes the entries once read when consumed", func() {
+		reader := &mapReader{consume: true}
+		entries, err := reader.read(m)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values(entries)).To(Equal(map[uint32]uint32{1: 10, 2: 20, 3: 30}))
+		Expect(m.Iterate().Next(new([]byte), new([]byte))).To(BeFalse())
+
+		// only the entries added since are read next
+		Expect(m.Put(uint32(2), uint32(5))).To(Succeed())
+		entries, err = reader.read(m)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values(entries)).To(Equal(map[uint32]uint32{2: 5}))
+	})
+
+	It("falls back to a lookup and a delete", func() {
+		reader := &mapReader{consume: true, noLookupAndDelete: true}
+		entries, err := reader.read(m)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values(entries)).To(Equal(map[uint32]uint32{1: 10, 2: 20, 3: 30}))
+		Expect(m.Iterate().Next(new([]byte), new([]byte))).To(BeFalse())
+	})
+})
+
+var _ = Describe("startQueue", func() {
+	u32 := &btf.Int{Name: "u32", Size: 4, Bits: 32}
+	char := &btf.Int{Name: "char", Size: 1, Bits: 8, Encoding: btf.Char}
+	event := &btf.Struct{Name: "event", Size: 8, Members: []btf.Member{
+		{Name: "pid", Type: u32},
+		{Name: "comm", Type: &btf.Array{Type: char, Nelems: 4}, OffsetBits: 32},
+	}}
+
+	It("pops the values of the queue as events", func() {
+		m, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Queue, ValueSize: 8, MaxEntries: 8})
+		if err != nil {
+			Skip("creating queue maps needs privileges and linux 4.20: " + err.Error())
+		}
+		defer m.Close()
+		for _, pid := range []uint32{42, 7, 42} {
+			comm := "cat"
+			if pid == 7 {
+				comm = "top"
+			}
+			Expect(m.Update(nil, append(u32Bytes(pid), comm+"\x00"...), ebpf.UpdateAny)).To(Succeed())
+		}
+
+		l := &loader{decoderFactory: decoder.NewDecoderFactory()}
+		instrument := &countingInstrument{counts: map[string]int{}}
+		watcher := &recordingWatcher{}
+		opts := &LoadOptions{
+			ParsedELF: &ParsedELF{WatchedMaps: map[string]WatchedMap{
+				"events": {Name: "events", Labels: []string{"pid", "comm"}, mapType: ebpf.Queue, valueStruct: event},
+			}},
+			Settings: NewSettings(LiveSettings{PollInterval: 10 * time.Millisecond}),
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		Expect(l.startQueue(ctx, event, m, instrument, nil, "events", watcher, opts)).To(Succeed())
+
+		Expect(instrument.counts).To(Equal(map[string]int{"cat": 2, "top": 1}))
+		// in the order pushed
+		Expect(watcher.entries).To(Equal([]v1.MapEntry{
+			{Name: "events", Entry: v1.KvPair{Key: map[string]string{"pid": "42", "comm": "cat"}}},
+			{Name: "events", Entry: v1.KvPair{Key: map[string]string{"pid": "7", "comm": "top"}}},
+			{Name: "events", Entry: v1.KvPair{Key: map[string]string{"pid": "42", "comm": "cat"}}},
+		}))
+		var value []byte
+		Expect(m.LookupAndDelete(nil, &value)).To(MatchError(ebpf.ErrKeyNotExist))
+	})
+})
@@ -0,0 +1,210 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+// GateMapName is the map programs declare to be paused with the gate strategy: an array
+// with a single u32, set to 1 while the program is paused and 0 otherwise.
+const GateMapName = "bee_paused"
+
+var errNotLoaded = errors.New("the program is not loaded yet")
+
+type ControlOpts struct {
+	// Strategy used to pause the program, defaults to the gate strategy if the program
+	// declares a gate map, and the detach strategy otherwise
+	Strategy v1.PauseStrategy
+	// Set if the programs can't be attached again once detached, e.g. once sandboxed
+	// or when attached by a helper, in which case only the gate strategy can be used
+	NoReattach bool
+	// Pause the program as soon as it is bound, e.g. when it was paused before the agent
+	// restarted
+	StartPaused bool
+}
+
+// Control pauses and resumes a loaded program without unloading it. It is bound to the
+// program by the loader, once loaded.
+type Control struct {
+	opts ControlOpts
+
+	lock     sync.Mutex
+	ctx      context.Context
+	attached *Attached
+	spec     *ebpf.CollectionSpec
+	gate     *ebpf.Map
+	loaded   bool
+	paused   bool
+	since    time.Time
+}
+
+func NewControl(opts ControlOpts) *Control {
+	return &Control{opts: opts}
+}
+
+// bindAttached records the programs attached by this process, so they can be detached.
+func (c *Control) bindAttached(spec *ebpf.CollectionSpec, attached *Attached) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.spec, c.attached = spec, attached
+}
+
+// bindMaps looks up the gate map, once the maps are loaded.
+func (c *Control) bindMaps(ctx context.Context, maps map[string]*ebpf.Map) error {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if gate, ok := maps[GateMapName]; ok {
+		if gate.Type() != ebpf.Array || gate.KeySize() != 4 || gate.ValueSize() != 4 || gate.MaxEntries() != 1 {
+			return fmt.Errorf("the %s map must be an array of a single u32", GateMapName)
+		}
+		// a pinned gate may have been left set by a previous run
+		if err := gate.Put(uint32(0), uint32(0)); err != nil {
+			return fmt.Errorf("could not clear %s: %w", GateMapName, err)
+		}
+		c.gate = gate
+	}
+	if c.opts.Strategy == v1.GatePauseStrategy && c.gate == nil {
+		return fmt.Errorf("the gate pause strategy requires the program to declare a %s map", GateMapName)
+	}
+	c.ctx = ctx
+	c.loaded = true
+	if c.opts.StartPaused {
+		if _, err := c.pause(); err != nil {
+			return fmt.Errorf("could not start the program paused: %w", err)
+		}
+	}
+	return nil
+}
+
+// unbind stops controlling the program, once it is unloaded.
+func (c *Control) unbind() {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.loaded = false
+	c.attached = nil
+}
+
+// strategy must be called with the lock held
+func (c *Control) strategy() v1.PauseStrategy {
+	if c.opts.Strategy != "" {
+		return c.opts.Strategy
+	}
+	if c.gate != nil {
+		return v1.GatePauseStrategy
+	}
+	return v1.DetachPauseStrategy
+}
+
+func (c *Control) State() v1.ProgramState {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.state()
+}
+
+// state must be called with the lock held
+func (c *Control) state() v1.ProgramState {
+	return v1.ProgramState{
+		Paused:   c.paused,
+		Strategy: c.strategy(),
+		Since:    c.since,
+	}
+}
+
+// Pause stops the program from running, keeping its maps. It does nothing if the
+// program is already paused.
+func (c *Control) Pause() (v1.ProgramState, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.pause()
+}
+
+// pause must be called with the lock held
+func (c *Control) pause() (v1.ProgramState, error) {
+	if !c.loaded {
+		return c.state(), errNotLoaded
+	}
+	if c.paused {
+		return c.state(), nil
+	}
+	switch c.strategy() {
+	case v1.GatePauseStrategy:
+		if err := c.gate.Put(uint32(0), uint32(1)); err != nil {
+			return c.state(), fmt.Errorf("could not set %s: %w", GateMapName, err)
+		}
+	default:
+		if c.attached == nil || c.opts.NoReattach {
+			return c.state(), fmt.Errorf("the program can't be detached, as it could not be attached again: declare a %s map to pause it", GateMapName)
+		}
+		c.attached.lock.Lock()
+		for _, l := range c.attached.links {
+			l.Close()
+		}
+		c.attached.links = nil
+		c.attached.detachedPrograms()
+		c.attached.lock.Unlock()
+	}
+	c.paused, c.since = true, time.Now()
+	contextutils.LoggerFrom(c.ctx).Infof("paused the program with the %s strategy", c.strategy())
+	return c.state(), nil
+}
+
+// Resume runs the program again after Pause. It does nothing if the program is not paused.
+func (c *Control) Resume() (v1.ProgramState, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.loaded {
+		return c.state(), errNotLoaded
+	}
+	if !c.paused {
+		return c.state(), nil
+	}
+	switch c.strategy() {
+	case v1.GatePauseStrategy:
+		if err := c.gate.Put(uint32(0), uint32(0)); err != nil {
+			return c.state(), fmt.Errorf("could not clear %s: %w", GateMapName, err)
+		}
+	default:
+		// to the targets of the last resolution when reattaching
+		c.attached.lock.Lock()
+		var links []io.Closer
+		for name, prog := range c.spec.Programs {
+			loaded := c.attached.Collection.Programs[name]
+			l, err := attachProgram(prog, loaded, c.attached.multiProbes[name], c.attached.targets)
+			c.attached.attachedProgram(prog, loaded, l, err)
+			if err != nil {
+				for _, l := range links {
+					l.Close()
+				}
+				c.attached.detachedPrograms()
+				c.attached.status.recordError(prog.Name, err)
+				c.attached.lock.Unlock()
+				return c.state(), err
+			}
+			if l != nil {
+				links = append(links, l)
+			}
+		}
+		c.attached.links = links
+		c.attached.lock.Unlock()
+	}
+	c.paused, c.since = false, time.Now()
+	contextutils.LoggerFrom(c.ctx).Infof("resumed the program")
+	return c.state(), nil
+}
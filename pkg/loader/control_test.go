@@ -0,0 +1,31 @@
+package loader
+
+import (
+	"context"
+
+	"github.com/cilium/ebpf"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Control", func() {
+	It("starts programs paused with their gate", func() {
+		gate, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1})
+		if err != nil {
+			Skip("creating maps needs privileges: " + err.Error())
+		}
+		defer gate.Close()
+
+		control := NewControl(ControlOpts{StartPaused: true})
+		Expect(control.bindMaps(context.Background(), map[string]*ebpf.Map{GateMapName: gate})).To(Succeed())
+		Expect(control.State().Paused).To(BeTrue())
+		var value uint32
+		Expect(gate.Lookup(uint32(0), &value)).To(Succeed())
+		Expect(value).To(Equal(uint32(1)))
+
+		_, err = control.Resume()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gate.Lookup(uint32(0), &value)).To(Succeed())
+		Expect(value).To(Equal(uint32(0)))
+	})
+})
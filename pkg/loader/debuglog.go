@@ -0,0 +1,121 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cilium/ebpf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+// Flags of the log level of the verifier
+const (
+	verifierLogLevel1 = 1
+	verifierLogLevel2 = 2
+	verifierLogStats  = 4
+)
+
+func ParseDebugLogLevel(s string) (v1.DebugLogLevel, error) {
+	switch l := v1.DebugLogLevel(s); l {
+	case v1.DebugLogOff, v1.DebugLogInfo, v1.DebugLogVerifier, v1.DebugLogVerbose:
+		return l, nil
+	case "":
+		return v1.DebugLogOff, nil
+	}
+	return "", fmt.Errorf("unknown debug log level '%s', must be one of off, info, verifier or verbose", s)
+}
+
+// debugLog sends the lines logged while loading a program to the DebugLog of the options.
+type debugLog struct {
+	level v1.DebugLogLevel
+	send  func(v1.DebugLogEntry)
+}
+
+// newDebugLog returns the debug log of the options, nil if disabled.
+func newDebugLog(opts *LoadOptions) *debugLog {
+	if opts.DebugLog == nil || opts.DebugLogLevel == "" || opts.DebugLogLevel == v1.DebugLogOff {
+		return nil
+	}
+	return &debugLog{level: opts.DebugLogLevel, send: opts.DebugLog}
+}
+
+func (d *debugLog) infof(program, format string, args ...interface{}) {
+	if d == nil {
+		return
+	}
+	d.send(v1.DebugLogEntry{Time: time.Now(), Level: v1.DebugLogInfo, Program: program, Message: fmt.Sprintf(format, args...)})
+}
+
+// programOptions returns the options making the verifier log as much as the level, with
+// a buffer large enough for its logs.
+func (d *debugLog) programOptions() ebpf.ProgramOptions {
+	if d == nil {
+		return ebpf.ProgramOptions{}
+	}
+	switch d.level {
+	case v1.DebugLogVerifier:
+		return ebpf.ProgramOptions{LogLevel: verifierLogLevel1 | verifierLogStats, LogSize: 1 << 20}
+	case v1.DebugLogVerbose:
+		return ebpf.ProgramOptions{LogLevel: verifierLogLevel2 | verifierLogStats, LogSize: 8 << 20}
+	default:
+		return ebpf.ProgramOptions{LogLevel: verifierLogStats}
+	}
+}
+
+// truncated returns whether the load failed as the verifier log did not fit in its
+// buffer, in which case the load is retried at the info level, whose log always fits.
+func (d *debugLog) truncated(err error) bool {
+	if d == nil || d.level == v1.DebugLogInfo || !errors.Is(err, syscall.ENOSPC) {
+		return false
+	}
+	d.infof("", "The verifier log does not fit in its buffer, loading again with the info level")
+	d.level = v1.DebugLogInfo
+	return true
+}
+
+// verifierLog sends the verifier log of a loaded program, its statistics at the info level.
+func (d *debugLog) verifierLog(program, log string) {
+	if d == nil {
+		return
+	}
+	now := time.Now()
+	for _, line := range strings.Split(strings.TrimSpace(log), "\n") {
+		if line == "" {
+			continue
+		}
+		level := d.level
+		if isVerifierStats(line) {
+			level = v1.DebugLogInfo
+		}
+		d.send(v1.DebugLogEntry{Time: now, Level: level, Program: program, Message: line})
+	}
+}
+
+// failed sends the error a load failed with, which includes the verifier log of the
+// rejected program.
+func (d *debugLog) failed(err error) {
+	if d == nil {
+		return
+	}
+	now := time.Now()
+	for _, line := range strings.Split(err.Error(), "\n") {
+		if line == "" {
+			continue
+		}
+		d.send(v1.DebugLogEntry{Time: now, Level: v1.DebugLogInfo, Message: line, Error: true})
+	}
+}
+
+// isVerifierStats returns whether a line of a verifier log is one of the statistics logged
+// at the end of the log, e.g. "processed 42 insns (limit 1000000) ...".
+func isVerifierStats(line string) bool {
+	for _, prefix := range []string{"processed ", "verification time ", "stack depth "} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
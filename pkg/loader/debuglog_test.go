@@ -0,0 +1,80 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/cilium/ebpf"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+var _ = Describe("debug logs", func() {
+	var entries []v1.DebugLogEntry
+
+	newLog := func(level v1.DebugLogLevel) *debugLog {
+		entries = nil
+		return newDebugLog(&LoadOptions{
+			DebugLog:      func(entry v1.DebugLogEntry) { entries = append(entries, entry) },
+			DebugLogLevel: level,
+		})
+	}
+
+	It("parses the levels", func() {
+		level, err := ParseDebugLogLevel("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(level).To(Equal(v1.DebugLogOff))
+		level, err = ParseDebugLogLevel("verbose")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(level).To(Equal(v1.DebugLogVerbose))
+		_, err = ParseDebugLogLevel("trace")
+		Expect(err).To(MatchError(ContainSubstring("must be one of off, info, verifier or verbose")))
+	})
+
+	It("is disabled unless there is a level and a log", func() {
+		Expect(newLog(v1.DebugLogOff)).To(BeNil())
+		Expect(newDebugLog(&LoadOptions{DebugLogLevel: v1.DebugLogInfo})).To(BeNil())
+		var disabled *debugLog
+		Expect(disabled.programOptions()).To(Equal(ebpf.ProgramOptions{}))
+		disabled.infof("", "ignored")
+		disabled.failed(errors.New("ignored"))
+	})
+
+	It("asks the verifier for as much as the level", func() {
+		Expect(newLog(v1.DebugLogInfo).programOptions().LogLevel).To(BeEquivalentTo(verifierLogStats))
+		Expect(newLog(v1.DebugLogVerifier).programOptions().LogLevel).To(BeEquivalentTo(verifierLogLevel1 | verifierLogStats))
+		Expect(newLog(v1.DebugLogVerbose).programOptions().LogLevel).To(BeEquivalentTo(verifierLogLevel2 | verifierLogStats))
+	})
+
+	It("logs the statistics of the verifier at the info level", func() {
+		log := newLog(v1.DebugLogVerifier)
+		log.verifierLog("kprobe_tcp_v4_connect", "0: (bf) r6 = r1\n1: (b7) r0 = 0\n\nprocessed 2 insns (limit 1000000) max_states_per_insn 0\nstack depth 0\n")
+		Expect(entries).To(HaveLen(4))
+		Expect(entries[0]).To(matchEntry(v1.DebugLogVerifier, "kprobe_tcp_v4_connect", "0: (bf) r6 = r1"))
+		Expect(entries[2]).To(matchEntry(v1.DebugLogInfo, "kprobe_tcp_v4_connect", "processed 2 insns (limit 1000000) max_states_per_insn 0"))
+		Expect(entries[3].Level).To(Equal(v1.DebugLogInfo))
+	})
+
+	It("loads again at the info level when the verifier log is truncated", func() {
+		log := newLog(v1.DebugLogVerbose)
+		Expect(log.truncated(errors.New("invalid argument"))).To(BeFalse())
+		Expect(log.truncated(fmt.Errorf("load program: %w", syscall.ENOSPC))).To(BeTrue())
+		Expect(log.programOptions().LogLevel).To(BeEquivalentTo(verifierLogStats))
+		Expect(log.truncated(fmt.Errorf("load program: %w", syscall.ENOSPC))).To(BeFalse())
+
+		log.failed(errors.New("program kprobe: load program: permission denied:\n0: (85) call bpf_probe_read#4\nunknown func"))
+		Expect(entries[len(entries)-1].Error).To(BeTrue())
+		Expect(entries[len(entries)-1].Message).To(Equal("unknown func"))
+	})
+})
+
+// matchEntry matches the level, program and message of a DebugLogEntry.
+func matchEntry(level v1.DebugLogLevel, program, message string) OmegaMatcher {
+	return And(
+		WithTransform(func(e v1.DebugLogEntry) v1.DebugLogLevel { return e.Level }, Equal(level)),
+		WithTransform(func(e v1.DebugLogEntry) string { return e.Program }, Equal(program)),
+		WithTransform(func(e v1.DebugLogEntry) string { return e.Message }, Equal(message)),
+	)
+}
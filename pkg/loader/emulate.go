@@ -0,0 +1,265 @@
+package loader
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+)
+
+const (
+	// DefaultEmulateInterval is the time between two emulated events
+	DefaultEmulateInterval = 100 * time.Millisecond
+	// DefaultEmulateKeys is the number of keys emulated per hash map
+	DefaultEmulateKeys = 8
+)
+
+// words of the strings of synthetic events, e.g. the comm of a process
+var syntheticWords = []string{"bash", "curl", "envoy", "nginx", "postgres", "python3", "redis", "sshd"}
+
+type EmulateOptions struct {
+	// Recorded events, e.g. by bee run --record, sent one per interval. If empty, events of
+	// every map of events of the program, and entries of every hash map, are synthesized
+	// from the BTF of their structs
+	Records []Record
+	// Time between two events, or two rounds of synthetic events, DefaultEmulateInterval if 0
+	Interval time.Duration
+	// Replay the records again once all of them are sent, until the context is done
+	Loop bool
+	// Number of keys of the synthetic entries of each hash map, DefaultEmulateKeys if 0
+	Keys int
+	// Seed of the synthetic events, so an emulation can be reproduced
+	Seed int64
+}
+
+// Emulate sends recorded or synthetic events of the program to the watcher, e.g. the TUI
+// and the sinks, without a kernel, so rendering and export can be iterated on anywhere. It
+// returns once all the records are sent, unless looping, or once the context is done. The
+// watcher is closed on return.
+func Emulate(
+	ctx context.Context,
+	decoderFactory decoder.DecoderFactory,
+	parsedELF *ParsedELF,
+	watcher v1.MapWatcher,
+	opts EmulateOptions,
+) error {
+	defer watcher.Close()
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultEmulateInterval
+	}
+	if opts.Keys <= 0 {
+		opts.Keys = DefaultEmulateKeys
+	}
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	tick := func() bool {
+		select {
+		case <-ticker.C:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	r := newReplayer(decoderFactory, parsedELF, watcher)
+	if len(opts.Records) > 0 {
+		for {
+			for i, record := range opts.Records {
+				if err := r.send(ctx, i, record); err != nil {
+					return err
+				}
+				if !tick() {
+					return nil
+				}
+			}
+			if !opts.Loop {
+				return nil
+			}
+		}
+	}
+
+	s, err := newSynthesizer(parsedELF, opts.Keys, opts.Seed)
+	if err != nil {
+		return err
+	}
+	for _, name := range s.hashMaps {
+		watcher.NewHashMap(name, parsedELF.WatchedMaps[name].Labels)
+	}
+	for {
+		for _, name := range s.eventMaps {
+			if err := r.send(ctx, 0, s.event(name)); err != nil {
+				return err
+			}
+		}
+		for _, name := range s.hashMaps {
+			if err := s.sendCounts(ctx, r.d, name, watcher); err != nil {
+				return err
+			}
+		}
+		if !tick() {
+			return nil
+		}
+	}
+}
+
+// synthesizer generates events and hash map entries matching the BTF of the maps.
+type synthesizer struct {
+	parsedELF *ParsedELF
+	rand      *rand.Rand
+	eventMaps []string
+	hashMaps  []string
+	// raw keys of each hash map, their values increasing on each round
+	keys   map[string][][]byte
+	counts map[string][]uint64
+}
+
+func newSynthesizer(parsedELF *ParsedELF, keys int, seed int64) (*synthesizer, error) {
+	s := &synthesizer{
+		parsedELF: parsedELF,
+		rand:      rand.New(rand.NewSource(seed)),
+		keys:      map[string][][]byte{},
+		counts:    map[string][]uint64{},
+	}
+	for name, bpfMap := range parsedELF.WatchedMaps {
+		switch bpfMap.mapType {
+		case ebpf.RingBuf, ebpf.PerfEventArray, ebpf.Queue, ebpf.Stack:
+			s.eventMaps = append(s.eventMaps, name)
+		case ebpf.Hash:
+			if bpfMap.btf == nil {
+				continue
+			}
+			s.hashMaps = append(s.hashMaps, name)
+		}
+	}
+	sort.Strings(s.eventMaps)
+	sort.Strings(s.hashMaps)
+	if len(s.eventMaps) == 0 && len(s.hashMaps) == 0 {
+		return nil, fmt.Errorf("the program has no maps of events nor hash maps to emulate")
+	}
+	for _, name := range s.hashMaps {
+		keyType := parsedELF.WatchedMaps[name].btf.Key
+		for i := 0; i < keys; i++ {
+			key, err := s.synthesize(keyType)
+			if err != nil {
+				return nil, fmt.Errorf("could not synthesize the keys of map %s: %w", name, err)
+			}
+			s.keys[name] = append(s.keys[name], key)
+		}
+		s.counts[name] = make([]uint64, keys)
+	}
+	for _, name := range s.eventMaps {
+		if _, err := s.synthesize(parsedELF.WatchedMaps[name].valueStruct); err != nil {
+			return nil, fmt.Errorf("could not synthesize the events of map %s: %w", name, err)
+		}
+	}
+	return s, nil
+}
+
+// event returns a synthetic event of the map, checked to be synthesizable.
+func (s *synthesizer) event(name string) Record {
+	raw, _ := s.synthesize(s.parsedELF.WatchedMaps[name].valueStruct)
+	return Record{Map: name, Data: hex.EncodeToString(raw)}
+}
+
+// sendCounts increases the values of some keys of the hash map, then sends all of them,
+// like a counter map polled by the loader.
+func (s *synthesizer) sendCounts(ctx context.Context, d decoder.BinaryDecoder, name string, watcher v1.MapWatcher) error {
+	bpfMap := s.parsedELF.WatchedMaps[name]
+	counts := s.counts[name]
+	for i := range counts {
+		// the first keys are the busiest
+		if s.rand.Intn(len(counts)) >= i {
+			counts[i] += uint64(s.rand.Intn(10) + 1)
+		}
+	}
+	for i, key := range s.keys[name] {
+		decodedKey, err := d.DecodeBtfBinary(ctx, bpfMap.btf.Key, key)
+		if err != nil {
+			return fmt.Errorf("error decoding key: %w", err)
+		}
+		watcher.SendEntry(v1.MapEntry{
+			Name:  name,
+			Entry: v1.KvPair{Key: bpfMap.labels.stringify(decodedKey), Value: fmt.Sprint(counts[i])},
+		})
+	}
+	return nil
+}
+
+// synthesize returns random bytes of the type, laid out as the kernel would write them:
+// small integers, strings picked from a few words, and random bytes for the other arrays,
+// e.g. trace IDs.
+func (s *synthesizer) synthesize(typ btf.Type) ([]byte, error) {
+	size, err := btf.Sizeof(typ)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, size)
+	if err := s.fill(typ, raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+func (s *synthesizer) fill(typ btf.Type, raw []byte) error {
+	switch t := typ.(type) {
+	case *btf.Struct:
+		for _, member := range t.Members {
+			size, err := btf.Sizeof(member.Type)
+			if err != nil {
+				return err
+			}
+			offset := int(member.OffsetBits / 8)
+			if offset+size > len(raw) {
+				return fmt.Errorf("member %s is out of its struct", member.Name)
+			}
+			if err := s.fill(member.Type, raw[offset:offset+size]); err != nil {
+				return err
+			}
+		}
+	case *btf.Typedef:
+		return s.fill(t.Type, raw)
+	case *btf.Volatile:
+		return s.fill(t.Type, raw)
+	case *btf.Const:
+		return s.fill(t.Type, raw)
+	case *btf.Int:
+		var value uint64
+		switch {
+		case t.Encoding.IsBool():
+			value = uint64(s.rand.Intn(2))
+		case t.Encoding.IsChar():
+			value = uint64('a' + s.rand.Intn(26))
+		default:
+			value = uint64(s.rand.Intn(1000))
+		}
+		switch len(raw) {
+		case 1:
+			raw[0] = byte(value)
+		case 2:
+			decoder.Endianess.PutUint16(raw, uint16(value))
+		case 4:
+			decoder.Endianess.PutUint32(raw, uint32(value))
+		case 8:
+			decoder.Endianess.PutUint64(raw, value)
+		}
+	case *btf.Array:
+		if elem, ok := t.Type.(*btf.Int); ok && elem.Name == "char" && len(raw) > 0 {
+			// nul terminated
+			copy(raw[:len(raw)-1], syntheticWords[s.rand.Intn(len(syntheticWords))])
+			return nil
+		}
+		s.rand.Read(raw)
+	case *btf.Float, *btf.Enum, *btf.Pointer:
+		// left zeroed
+	default:
+		return fmt.Errorf("unsupported type %s", typ)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package loader
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"github.com/solo-io/bumblebee/pkg/golden"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Emulate", func() {
+	u32 := &btf.Int{Name: "u32", Size: 4, Bits: 32}
+	u64 := &btf.Int{Name: "u64", Size: 8, Bits: 64}
+	char := &btf.Int{Name: "char", Size: 1, Bits: 8, Encoding: btf.Char}
+	event := &btf.Struct{Name: "event", Size: 24, Members: []btf.Member{
+		{Name: "pid", Type: u32},
+		{Name: "daddr", Type: &btf.Typedef{Name: "ipv4_addr", Type: u32}, OffsetBits: 32},
+		{Name: "comm", Type: &btf.Array{Type: char, Nelems: 16}, OffsetBits: 64},
+	}}
+	key := &btf.Struct{Name: "key", Size: 4, Members: []btf.Member{{Name: "pid", Type: u32}}}
+	parsedELF := &ParsedELF{
+		WatchedMaps: map[string]WatchedMap{
+			"events": {Name: "events", Labels: []string{"pid", "daddr", "comm"}, mapType: ebpf.RingBuf, valueStruct: event},
+			"counts": {Name: "counts", Labels: []string{"pid"}, mapType: ebpf.Hash, btf: &btf.Map{Key: key, Value: u64}},
+		},
+	}
+
+	It("sends the records at the interval", func() {
+		records := []Record{
+			{Map: "events", Data: "2a0000000a000001" + "6375726c000000000000000000000000"},
+			{Map: "events", Data: "070000007f000001" + "62617368000000000000000000000000"},
+		}
+		watcher := golden.NewWatcher()
+		start := time.Now()
+		err := Emulate(context.Background(), decoder.NewDecoderFactory(), parsedELF, watcher, EmulateOptions{
+			Records:  records,
+			Interval: 20 * time.Millisecond,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically(">=", 40*time.Millisecond))
+		Expect(watcher.Entries()).To(Equal([]golden.Entry{
+			{Map: "events", Key: map[string]string{"pid": "42", "daddr": "10.0.0.1", "comm": "curl"}},
+			{Map: "events", Key: map[string]string{"pid": "7", "daddr": "127.0.0.1", "comm": "bash"}},
+		}))
+	})
+
+	It("synthesizes events and increasing counts until done", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		watcher := golden.NewWatcher()
+		err := Emulate(ctx, decoder.NewDecoderFactory(), parsedELF, watcher, EmulateOptions{
+			Interval: 10 * time.Millisecond,
+			Keys:     3,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		events, counts := 0, map[string]int{}
+		for _, entry := range watcher.Entries() {
+			switch entry.Map {
+			case "events":
+				events++
+				Expect(entry.Key).To(HaveKey("daddr"))
+				Expect(syntheticWords).To(ContainElement(entry.Key["comm"]))
+			case "counts":
+				value, err := strconv.Atoi(entry.Value)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(value).To(BeNumerically(">=", counts[entry.Key["pid"]]))
+				counts[entry.Key["pid"]] = value
+			}
+		}
+		Expect(events).To(BeNumerically(">", 2))
+		Expect(len(counts)).To(BeNumerically("<=", 3))
+		Expect(counts).NotTo(BeEmpty())
+	})
+
+	It("rejects records which are not events of the program", func() {
+		err := Emulate(context.Background(), decoder.NewDecoderFactory(), parsedELF, golden.NewWatcher(), EmulateOptions{
+			Records: []Record{{Map: "counts", Data: "00"}},
+		})
+		Expect(err).To(MatchError(ContainSubstring("only events can be replayed")))
+	})
+})
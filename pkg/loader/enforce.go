@@ -0,0 +1,230 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/stats"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+const (
+	// EnforceMapName is the switch enforcement programs declare to only observe what they
+	// would deny until told to enforce: an array with a single u32, or a hash map of u32
+	// values keyed by the u64 IDs of the cgroups the program is scoped to, key 0 holding the
+	// mode of the cgroups without an entry. The value is 1 to enforce and 0 to observe. It is
+	// cleared once the program is loaded, so enforcement programs start observe-only.
+	EnforceMapName = "bee_enforce"
+	// EnforceModeMetric is the metric of the mode of the program and of its scopes, 1 when
+	// enforcing and 0 when observing
+	EnforceModeMetric = "bee_enforce_mode"
+	// programScope is the scope label of the mode of the whole program
+	programScope = "all"
+)
+
+// switchMap is the enforce switch, an ebpf.Map once loaded.
+type switchMap interface {
+	Put(key, value interface{}) error
+}
+
+// Enforcement switches an enforcement program between observing and enforcing, as a whole
+// or for the cgroups it is scoped to. It is bound to the program by the loader, once loaded.
+type Enforcement struct {
+	lock       sync.Mutex
+	ctx        context.Context
+	instrument stats.SetInstrument
+	enforce    switchMap
+	// IDs of the cgroups of each selector of the Cgroups of the LoadOptions, nil if the
+	// switch is an array
+	scopes map[string][]uint64
+	loaded bool
+	mode   v1.EnforcementMode
+	modes  map[string]v1.EnforcementMode
+	since  time.Time
+}
+
+func NewEnforcement() *Enforcement {
+	return &Enforcement{mode: v1.ObserveMode}
+}
+
+// ParseEnforcementMode returns the mode of its name, observe or enforce.
+func ParseEnforcementMode(mode string) (v1.EnforcementMode, error) {
+	switch v1.EnforcementMode(mode) {
+	case v1.ObserveMode, v1.EnforceMode:
+		return v1.EnforcementMode(mode), nil
+	default:
+		return "", fmt.Errorf("unknown enforcement mode %s, must be observe or enforce", mode)
+	}
+}
+
+// checkEnforceMap returns the enforce switch of the program if it declares one, or an error if
+// it is neither an array of a single u32 nor a hash map from u64 to u32.
+func checkEnforceMap(maps map[string]*ebpf.Map) (*ebpf.Map, error) {
+	m, ok := maps[EnforceMapName]
+	if !ok {
+		return nil, nil
+	}
+	array := m.Type() == ebpf.Array && m.KeySize() == 4 && m.MaxEntries() == 1
+	hash := m.Type() == ebpf.Hash && m.KeySize() == 8
+	if (!array && !hash) || m.ValueSize() != 4 {
+		return nil, fmt.Errorf("the %s map must be an array of a single u32, or a hash map from u64 cgroup IDs to u32", EnforceMapName)
+	}
+	return m, nil
+}
+
+// clearEnforceMap sets the program, and all its cgroups, to observe, as a pinned switch may
+// have been left set by a previous run.
+func clearEnforceMap(maps map[string]*ebpf.Map) error {
+	m, err := checkEnforceMap(maps)
+	if err != nil || m == nil {
+		return err
+	}
+	if m.Type() == ebpf.Array {
+		if err := m.Put(uint32(0), uint32(0)); err != nil {
+			return fmt.Errorf("could not clear %s: %w", EnforceMapName, err)
+		}
+		return nil
+	}
+	var keys []uint64
+	var key uint64
+	iter := m.Iterate()
+	for iter.Next(&key, new([]byte)) {
+		keys = append(keys, key)
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("could not read %s: %w", EnforceMapName, err)
+	}
+	for _, key := range keys {
+		if err := m.Delete(key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("could not clear %s: %w", EnforceMapName, err)
+		}
+	}
+	return nil
+}
+
+// bindMaps looks up the enforce switch once the maps are loaded, resolving each of the
+// cgroups selectors the program is scoped to.
+func (e *Enforcement) bindMaps(ctx context.Context, maps map[string]*ebpf.Map, cgroups []string, metricsProvider stats.MetricsProvider) error {
+	if e == nil {
+		return nil
+	}
+	m, err := checkEnforceMap(maps)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return fmt.Errorf("switching the enforcement mode requires the program to declare a %s map", EnforceMapName)
+	}
+	var scopes map[string][]uint64
+	if m.Type() == ebpf.Hash {
+		scopes = map[string][]uint64{}
+		for _, selector := range cgroups {
+			ids, err := resolveCgroups([]string{selector})
+			if err != nil {
+				return err
+			}
+			scopes[selector] = ids
+		}
+	}
+	e.bind(ctx, m, scopes, metricsProvider.NewGauge(&stats.MetricOpts{Name: EnforceModeMetric, Labels: []string{"scope"}}))
+	return nil
+}
+
+func (e *Enforcement) bind(ctx context.Context, enforce switchMap, scopes map[string][]uint64, instrument stats.SetInstrument) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.ctx, e.enforce, e.scopes, e.instrument = ctx, enforce, scopes, instrument
+	// the switch is cleared once the program is loaded
+	e.mode, e.modes, e.loaded = v1.ObserveMode, nil, true
+	e.instrument.Set(ctx, 0, map[string]string{"scope": programScope})
+}
+
+// unbind stops switching the program, once it is unloaded.
+func (e *Enforcement) unbind() {
+	if e == nil {
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.loaded = false
+}
+
+func (e *Enforcement) State() v1.EnforcementState {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.state()
+}
+
+// state must be called with the lock held
+func (e *Enforcement) state() v1.EnforcementState {
+	state := v1.EnforcementState{Mode: e.mode, Since: e.since}
+	if len(e.modes) > 0 {
+		state.Scopes = map[string]v1.EnforcementMode{}
+		for scope, mode := range e.modes {
+			state.Scopes[scope] = mode
+		}
+	}
+	for scope := range e.scopes {
+		state.Available = append(state.Available, scope)
+	}
+	sort.Strings(state.Available)
+	return state
+}
+
+// SetMode sets the mode of a scope, a selector of the cgroups the program is scoped to, or
+// of the whole program if empty, the scopes set keeping their own mode.
+func (e *Enforcement) SetMode(scope string, mode v1.EnforcementMode) (v1.EnforcementState, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if !e.loaded {
+		return e.state(), errNotLoaded
+	}
+	if _, err := ParseEnforcementMode(string(mode)); err != nil {
+		return e.state(), err
+	}
+	var value uint32
+	if mode == v1.EnforceMode {
+		value = 1
+	}
+	if scope == "" {
+		var key interface{} = uint32(0)
+		if e.scopes != nil {
+			key = uint64(0)
+		}
+		if err := e.enforce.Put(key, value); err != nil {
+			return e.state(), fmt.Errorf("could not set %s: %w", EnforceMapName, err)
+		}
+		e.mode = mode
+		e.instrument.Set(e.ctx, int64(value), map[string]string{"scope": programScope})
+	} else {
+		ids, ok := e.scopes[scope]
+		if !ok {
+			if e.scopes == nil {
+				return e.state(), fmt.Errorf("the %s map of the program is an array, so only the mode of the whole program can be set", EnforceMapName)
+			}
+			return e.state(), fmt.Errorf("the program is not scoped to the cgroups %s", scope)
+		}
+		for _, id := range ids {
+			if err := e.enforce.Put(id, value); err != nil {
+				return e.state(), fmt.Errorf("could not set %s: %w", EnforceMapName, err)
+			}
+		}
+		if e.modes == nil {
+			e.modes = map[string]v1.EnforcementMode{}
+		}
+		e.modes[scope] = mode
+		e.instrument.Set(e.ctx, int64(value), map[string]string{"scope": scope})
+	}
+	e.since = time.Now()
+	if scope == "" {
+		scope = "the program"
+	}
+	contextutils.LoggerFrom(e.ctx).Infof("set %s to %s", scope, mode)
+	return e.state(), nil
+}
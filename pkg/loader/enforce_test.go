@@ -0,0 +1,104 @@
+package loader
+
+import (
+	"context"
+
+	"github.com/cilium/ebpf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeSwitchMap map[interface{}]interface{}
+
+func (m fakeSwitchMap) Put(key, value interface{}) error {
+	m[key] = value
+	return nil
+}
+
+type scopeInstrument struct {
+	values map[string]int64
+}
+
+func (i *scopeInstrument) Set(ctx context.Context, val int64, labels map[string]string) {
+	i.values[labels["scope"]] = val
+}
+
+func (i *scopeInstrument) Delete(ctx context.Context, labels map[string]string) {}
+
+var _ = Describe("Enforcement", func() {
+	var (
+		enforce    fakeSwitchMap
+		instrument *scopeInstrument
+	)
+
+	BeforeEach(func() {
+		enforce = fakeSwitchMap{}
+		instrument = &scopeInstrument{values: map[string]int64{}}
+	})
+
+	It("switches the program, and the cgroups of its selectors", func() {
+		enforcement := NewEnforcement()
+		_, err := enforcement.SetMode("", v1.EnforceMode)
+		Expect(err).To(MatchError(errNotLoaded))
+
+		enforcement.bind(context.Background(), enforce, map[string][]uint64{"pod:checkout": {41, 42}}, instrument)
+		Expect(enforcement.State().Mode).To(Equal(v1.ObserveMode))
+		Expect(instrument.values).To(Equal(map[string]int64{"all": 0}))
+
+		state, err := enforcement.SetMode("pod:checkout", v1.EnforceMode)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(state.Mode).To(Equal(v1.ObserveMode))
+		Expect(state.Scopes).To(Equal(map[string]v1.EnforcementMode{"pod:checkout": v1.EnforceMode}))
+		Expect(state.Available).To(Equal([]string{"pod:checkout"}))
+		Expect(enforce).To(Equal(fakeSwitchMap{uint64(41): uint32(1), uint64(42): uint32(1)}))
+
+		state, err = enforcement.SetMode("", v1.EnforceMode)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(state.Mode).To(Equal(v1.EnforceMode))
+		Expect(state.Since).NotTo(BeZero())
+		Expect(enforce[uint64(0)]).To(Equal(uint32(1)))
+		Expect(instrument.values).To(Equal(map[string]int64{"all": 1, "pod:checkout": 1}))
+
+		_, err = enforcement.SetMode("pod:cart", v1.EnforceMode)
+		Expect(err).To(MatchError("the program is not scoped to the cgroups pod:cart"))
+		_, err = enforcement.SetMode("", "deny")
+		Expect(err).To(MatchError(ContainSubstring("unknown enforcement mode deny")))
+
+		// the switch is cleared when the program is loaded again
+		enforcement.unbind()
+		enforcement.bind(context.Background(), fakeSwitchMap{}, nil, instrument)
+		state = enforcement.State()
+		Expect(state.Mode).To(Equal(v1.ObserveMode))
+		Expect(state.Scopes).To(BeEmpty())
+	})
+
+	It("only switches the whole program with an array", func() {
+		enforcement := NewEnforcement()
+		enforcement.bind(context.Background(), enforce, nil, instrument)
+		_, err := enforcement.SetMode("", v1.EnforceMode)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(enforce).To(Equal(fakeSwitchMap{uint32(0): uint32(1)}))
+		_, err = enforcement.SetMode("pod:checkout", v1.EnforceMode)
+		Expect(err).To(MatchError(ContainSubstring("is an array, so only the mode of the whole program can be set")))
+	})
+
+	It("clears the switch left set by a previous run", func() {
+		m, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Hash, KeySize: 8, ValueSize: 4, MaxEntries: 4})
+		if err != nil {
+			Skip("creating maps needs privileges: " + err.Error())
+		}
+		defer m.Close()
+		Expect(m.Put(uint64(0), uint32(1))).To(Succeed())
+		Expect(m.Put(uint64(42), uint32(1))).To(Succeed())
+		Expect(clearEnforceMap(map[string]*ebpf.Map{EnforceMapName: m})).To(Succeed())
+		var key uint64
+		Expect(m.NextKey(nil, &key)).To(MatchError(ebpf.ErrKeyNotExist))
+
+		array, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 1})
+		Expect(err).NotTo(HaveOccurred())
+		defer array.Close()
+		Expect(clearEnforceMap(map[string]*ebpf.Map{EnforceMapName: array})).To(MatchError(ContainSubstring("must be an array of a single u32")))
+	})
+})
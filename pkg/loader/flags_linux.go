@@ -0,0 +1,10 @@
+package loader
+
+import "golang.org/x/sys/unix"
+
+// Flags of the bpf syscall
+const (
+	bpfFNoPrealloc  = unix.BPF_F_NO_PREALLOC
+	bpfFMmapable    = unix.BPF_F_MMAPABLE
+	bpfStatsRunTime = unix.BPF_STATS_RUN_TIME
+)
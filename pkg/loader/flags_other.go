@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package loader
+
+// Flags of the bpf syscall, as defined by Linux, so maps and their usage can be described
+// on other systems
+const (
+	bpfFNoPrealloc  = 0x1
+	bpfFMmapable    = 0x400
+	bpfStatsRunTime = 0
+)
@@ -0,0 +1,337 @@
+package loader
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/cilium/ebpf/btf"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"github.com/solo-io/bumblebee/pkg/geoip"
+	"github.com/solo-io/bumblebee/pkg/kubemeta"
+	"gopkg.in/yaml.v2"
+)
+
+// LabelTransform converts a field of the keys of a hash map, or of the events of a ring
+// buffer, into labels, as raw integers rarely make good labels. The transforms of a map are
+// applied in order, each to the labels produced by the previous ones.
+type LabelTransform struct {
+	// Map the transform applies to
+	Map string `yaml:"map"`
+	// Field of the map, or label produced by a previous transform, the transform applies to
+	Field string `yaml:"field"`
+	// Labels replacing the integer field, each of a range of its bits, e.g. the tgid and pid
+	// packed in the u64 returned by bpf_get_current_pid_tgid()
+	Split []BitRange `yaml:"split,omitempty"`
+	// Names of the values of the field, e.g. tcp for 6 and udp for 17 for a protocol number,
+	// the other values being kept
+	Values map[string]string `yaml:"values,omitempty"`
+	// Label the field is renamed to, after its Values are named
+	Rename string `yaml:"rename,omitempty"`
+	// Drop the field, e.g. to lower the cardinality of the metrics
+	Drop bool `yaml:"drop,omitempty"`
+	// What to look up of an ipv4_addr or ipv6_addr field in the geoip databases, among
+	// country, asn and org, each becoming a <field>_<lookup> label following the field
+	GeoIP []string `yaml:"geoip,omitempty"`
+	// What to look up of an ipv4_addr or ipv6_addr field, or of the cgroup ID of an integer
+	// field, in the metadata of the Kubernetes cluster, among pod, namespace, service and
+	// node, each becoming a <field>_<lookup> label following the field
+	Kubernetes []string `yaml:"kubernetes,omitempty"`
+}
+
+// the lookups of LabelTransform.GeoIP
+const (
+	geoIPCountry = "country"
+	geoIPASN     = "asn"
+	geoIPOrg     = "org"
+)
+
+// the lookups of LabelTransform.Kubernetes
+const (
+	kubePod       = "pod"
+	kubeNamespace = "namespace"
+	kubeService   = "service"
+	kubeNode      = "node"
+)
+
+// LabelEnrichers look up what the fields of the maps refer to, to annotate them with more
+// labels, e.g. the country of an address. Each is set if a transform looks its fields up
+// in it.
+type LabelEnrichers struct {
+	GeoIP      *geoip.DB
+	Kubernetes *kubemeta.Cache
+}
+
+// BitRange is a label of a range of the bits of an integer field.
+type BitRange struct {
+	Label string `yaml:"label"`
+	// Lowest bit of the range
+	Shift uint `yaml:"shift,omitempty"`
+	// Number of bits of the range, up to the highest bit of the field if 0
+	Bits uint `yaml:"bits,omitempty"`
+}
+
+// LoadLabelTransforms reads the transforms of a labels file, a list of LabelTransform under
+// `labels`, unknown fields are rejected.
+func LoadLabelTransforms(path string) ([]LabelTransform, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read labels file: %w", err)
+	}
+	var file struct {
+		Labels []LabelTransform `yaml:"labels"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("could not parse labels file: %w", err)
+	}
+	return file.Labels, nil
+}
+
+// TransformLabels sets the transforms of the labels of the watched maps, replacing the ones
+// set before, after checking they apply to the fields of the maps and the enrichers they
+// look their fields up in are set.
+func (p *ParsedELF) TransformLabels(transforms []LabelTransform, enrichers LabelEnrichers) error {
+	byMap := map[string][]LabelTransform{}
+	for _, t := range transforms {
+		if _, ok := p.WatchedMaps[t.Map]; !ok {
+			return fmt.Errorf("labels of map %s: the program has no such map", t.Map)
+		}
+		byMap[t.Map] = append(byMap[t.Map], t)
+	}
+	for name, m := range p.WatchedMaps {
+		fields := m.valueStruct
+		if fields == nil && m.btf != nil {
+			fields, _ = m.btf.Key.(*btf.Struct)
+		}
+		if fields == nil {
+			continue
+		}
+		m.labels = nil
+		m.Labels = getLabelsForBtfStruct(fields)
+		if len(byMap[name]) > 0 {
+			mapper, err := newLabelMapper(fields, byMap[name], enrichers)
+			if err != nil {
+				return fmt.Errorf("labels of map %s: %w", name, err)
+			}
+			m.labels = mapper
+			m.Labels = mapper.labels
+		}
+		p.WatchedMaps[name] = m
+	}
+	return nil
+}
+
+// labelMapper applies the transforms of a map to its decoded entries.
+type labelMapper struct {
+	transforms []LabelTransform
+	// labels once transformed, in the order of the fields they come from
+	labels    []string
+	enrichers LabelEnrichers
+}
+
+func newLabelMapper(fields *btf.Struct, transforms []LabelTransform, enrichers LabelEnrichers) (*labelMapper, error) {
+	labels := getLabelsForBtfStruct(fields)
+	integers := map[string]bool{}
+	addrs := map[string]bool{}
+	for _, member := range fields.Members {
+		integers[member.Name] = decoder.IsInteger(member.Type)
+		addrs[member.Name] = decoder.IsIPAddr(member.Type)
+	}
+	for i, t := range transforms {
+		at := indexOf(labels, t.Field)
+		if at < 0 {
+			return nil, fmt.Errorf("transform %d: no field %q", i, t.Field)
+		}
+		taken := func(label string) error {
+			if label == "" {
+				return fmt.Errorf("transform %d: labels must have a name", i)
+			}
+			if label != t.Field && indexOf(labels, label) >= 0 {
+				return fmt.Errorf("transform %d: there is already a %q label", i, label)
+			}
+			return nil
+		}
+		switch {
+		case len(t.GeoIP) > 0 || len(t.Kubernetes) > 0:
+			if t.Drop || len(t.Split) > 0 || len(t.Values) > 0 || t.Rename != "" {
+				return nil, fmt.Errorf("transform %d: a field looked up can't be dropped, split, named or renamed in the same transform", i)
+			}
+			if len(t.GeoIP) > 0 {
+				if !addrs[t.Field] {
+					return nil, fmt.Errorf("transform %d: only ipv4_addr and ipv6_addr fields can be looked up in the geoip databases, %q is not one", i, t.Field)
+				}
+				if enrichers.GeoIP == nil {
+					return nil, fmt.Errorf("transform %d: %q is looked up in the geoip databases, but none is set", i, t.Field)
+				}
+			}
+			if len(t.Kubernetes) > 0 {
+				if !addrs[t.Field] && !integers[t.Field] {
+					return nil, fmt.Errorf("transform %d: only ipv4_addr and ipv6_addr fields, and integer cgroup IDs, can be looked up in the Kubernetes metadata, %q is not one", i, t.Field)
+				}
+				if enrichers.Kubernetes == nil {
+					return nil, fmt.Errorf("transform %d: %q is looked up in the Kubernetes metadata, but it is not cached", i, t.Field)
+				}
+			}
+			added := make([]string, 0, len(t.GeoIP)+len(t.Kubernetes))
+			for _, lookup := range t.GeoIP {
+				switch lookup {
+				case geoIPCountry, geoIPASN, geoIPOrg:
+				default:
+					return nil, fmt.Errorf("transform %d: unknown geoip lookup %q, must be one of %s, %s or %s", i, lookup, geoIPCountry, geoIPASN, geoIPOrg)
+				}
+				added = append(added, t.Field+"_"+lookup)
+			}
+			for _, lookup := range t.Kubernetes {
+				switch lookup {
+				case kubePod, kubeNamespace, kubeService, kubeNode:
+				default:
+					return nil, fmt.Errorf("transform %d: unknown Kubernetes lookup %q, must be one of %s, %s, %s or %s", i, lookup, kubePod, kubeNamespace, kubeService, kubeNode)
+				}
+				added = append(added, t.Field+"_"+lookup)
+			}
+			for j, label := range added {
+				if indexOf(labels, label) >= 0 || indexOf(added[:j], label) >= 0 {
+					return nil, fmt.Errorf("transform %d: there is already a %q label", i, label)
+				}
+			}
+			labels = append(labels[:at+1:at+1], append(added, labels[at+1:]...)...)
+		case t.Drop:
+			if len(t.Split) > 0 || len(t.Values) > 0 || t.Rename != "" {
+				return nil, fmt.Errorf("transform %d: a dropped field can't be split, named or renamed", i)
+			}
+			labels = append(labels[:at:at], labels[at+1:]...)
+		case len(t.Split) > 0:
+			if len(t.Values) > 0 || t.Rename != "" {
+				return nil, fmt.Errorf("transform %d: a split field can't be named or renamed, transform the labels it is split into", i)
+			}
+			if !integers[t.Field] {
+				return nil, fmt.Errorf("transform %d: only integer fields can be split, %q is not one", i, t.Field)
+			}
+			split := make([]string, 0, len(t.Split))
+			for _, r := range t.Split {
+				if err := taken(r.Label); err != nil {
+					return nil, err
+				}
+				if indexOf(split, r.Label) >= 0 {
+					return nil, fmt.Errorf("transform %d: the field is split into %q twice", i, r.Label)
+				}
+				if r.Shift >= 64 || r.Shift+r.Bits > 64 {
+					return nil, fmt.Errorf("transform %d: the bits of %q are out of the 64 bits of the field", i, r.Label)
+				}
+				split = append(split, r.Label)
+				integers[r.Label] = true
+			}
+			labels = append(labels[:at:at], append(split, labels[at+1:]...)...)
+		default:
+			if len(t.Values) == 0 && t.Rename == "" {
+				return nil, fmt.Errorf("transform %d: nothing to do with %q", i, t.Field)
+			}
+			if t.Rename != "" {
+				if err := taken(t.Rename); err != nil {
+					return nil, err
+				}
+				labels[at] = t.Rename
+				integers[t.Rename] = integers[t.Field] && len(t.Values) == 0
+				addrs[t.Rename] = addrs[t.Field] && len(t.Values) == 0
+			} else if len(t.Values) > 0 {
+				integers[t.Field] = false
+				addrs[t.Field] = false
+			}
+		}
+	}
+	return &labelMapper{transforms: transforms, labels: labels, enrichers: enrichers}, nil
+}
+
+// stringify returns the labels of a decoded entry, once transformed.
+func (lm *labelMapper) stringify(decoded map[string]interface{}) map[string]string {
+	labels := stringify(decoded)
+	if lm == nil {
+		return labels
+	}
+	for _, t := range lm.transforms {
+		value := labels[t.Field]
+		if len(t.GeoIP) > 0 || len(t.Kubernetes) > 0 {
+			lm.enrich(labels, t, value)
+			continue
+		}
+		delete(labels, t.Field)
+		switch {
+		case t.Drop:
+		case len(t.Split) > 0:
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				// negative values are split as their two's complement
+				signed, _ := strconv.ParseInt(value, 10, 64)
+				n = uint64(signed)
+			}
+			for _, r := range t.Split {
+				bits := n >> r.Shift
+				if r.Bits > 0 && r.Bits < 64 {
+					bits &= 1<<r.Bits - 1
+				}
+				labels[r.Label] = strconv.FormatUint(bits, 10)
+			}
+		default:
+			if name, ok := t.Values[value]; ok {
+				value = name
+			}
+			label := t.Field
+			if t.Rename != "" {
+				label = t.Rename
+			}
+			labels[label] = value
+		}
+	}
+	return labels
+}
+
+// enrich sets the labels the field is looked up into.
+func (lm *labelMapper) enrich(labels map[string]string, t LabelTransform, value string) {
+	ip := net.ParseIP(value)
+	if len(t.GeoIP) > 0 {
+		info := geoip.Info{Country: geoip.Unknown, ASN: geoip.Unknown, Org: geoip.Unknown}
+		if ip != nil {
+			info = lm.enrichers.GeoIP.Lookup(ip)
+		}
+		for _, lookup := range t.GeoIP {
+			switch lookup {
+			case geoIPCountry:
+				labels[t.Field+"_"+lookup] = info.Country
+			case geoIPASN:
+				labels[t.Field+"_"+lookup] = info.ASN
+			case geoIPOrg:
+				labels[t.Field+"_"+lookup] = info.Org
+			}
+		}
+	}
+	if len(t.Kubernetes) > 0 {
+		var meta kubemeta.Meta
+		if ip != nil {
+			meta = lm.enrichers.Kubernetes.LookupIP(ip)
+		} else if id, err := strconv.ParseUint(value, 10, 64); err == nil {
+			meta = lm.enrichers.Kubernetes.LookupCgroup(id)
+		}
+		for _, lookup := range t.Kubernetes {
+			switch lookup {
+			case kubePod:
+				labels[t.Field+"_"+lookup] = meta.Pod
+			case kubeNamespace:
+				labels[t.Field+"_"+lookup] = meta.Namespace
+			case kubeService:
+				labels[t.Field+"_"+lookup] = meta.Service
+			case kubeNode:
+				labels[t.Field+"_"+lookup] = meta.Node
+			}
+		}
+	}
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
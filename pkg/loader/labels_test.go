@@ -0,0 +1,166 @@
+package loader
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"github.com/solo-io/bumblebee/pkg/geoip"
+	"github.com/solo-io/bumblebee/pkg/internal/kube"
+	"github.com/solo-io/bumblebee/pkg/kubemeta"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Label transforms", func() {
+	var parsedELF *ParsedELF
+
+	u8 := &btf.Int{Name: "u8", Size: 1, Bits: 8}
+	u32 := &btf.Int{Name: "u32", Size: 4, Bits: 32}
+	u64 := &btf.Int{Name: "u64", Size: 8, Bits: 64}
+	key := &btf.Struct{Name: "key", Size: 16, Members: []btf.Member{
+		{Name: "pid_tgid", Type: u64},
+		{Name: "daddr", Type: &btf.Typedef{Name: "ipv4_addr", Type: u32}, OffsetBits: 64},
+		{Name: "proto", Type: u8, OffsetBits: 96},
+	}}
+
+	BeforeEach(func() {
+		parsedELF = &ParsedELF{WatchedMaps: map[string]WatchedMap{
+			"conns":  {Name: "conns", Labels: []string{"pid_tgid", "daddr", "proto"}, mapType: ebpf.Hash, btf: &btf.Map{Key: key, Value: u64}},
+			"counts": {Name: "counts", mapType: ebpf.Hash},
+		}}
+	})
+
+	It("splits packed integers and names their values", func() {
+		Expect(parsedELF.TransformLabels([]LabelTransform{
+			{Map: "conns", Field: "pid_tgid", Split: []BitRange{{Label: "pid", Bits: 32}, {Label: "tgid", Shift: 32}}},
+			{Map: "conns", Field: "proto", Values: map[string]string{"6": "tcp", "17": "udp"}, Rename: "protocol"},
+			{Map: "conns", Field: "daddr", Drop: true},
+		}, LabelEnrichers{})).To(Succeed())
+
+		conns := parsedELF.WatchedMaps["conns"]
+		Expect(conns.Labels).To(Equal([]string{"pid", "tgid", "protocol"}))
+		raw := []byte{0x39, 0x30, 0, 0, 0xe8, 0x03, 0, 0, 10, 0, 0, 1, 17, 0, 0, 0}
+		decoded, err := decoder.NewDecoderFactory()().DecodeBtfBinary(context.Background(), key, raw)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conns.labels.stringify(decoded)).To(Equal(map[string]string{"pid": "12345", "tgid": "1000", "protocol": "udp"}))
+
+		decoded["proto"] = uint8(1)
+		Expect(conns.labels.stringify(decoded)).To(HaveKeyWithValue("protocol", "1"))
+
+		// transforms are replaced
+		Expect(parsedELF.TransformLabels(nil, LabelEnrichers{})).To(Succeed())
+		Expect(parsedELF.WatchedMaps["conns"].Labels).To(Equal([]string{"pid_tgid", "daddr", "proto"}))
+		Expect(parsedELF.WatchedMaps["conns"].labels.stringify(decoded)).To(HaveKeyWithValue("proto", "1"))
+	})
+
+	It("rejects transforms which do not apply to the fields of the map", func() {
+		for transform, message := range map[*LabelTransform]string{
+			{Map: "exits", Field: "pid"}: "the program has no such map",
+			{Map: "conns", Field: "pid"}: `no field "pid"`,
+			{Map: "conns", Field: "daddr", Split: []BitRange{{Label: "net", Shift: 24}}}:              `only integer fields can be split, "daddr"`,
+			{Map: "conns", Field: "pid_tgid", Split: []BitRange{{Label: "pid", Shift: 32, Bits: 64}}}: "out of the 64 bits",
+			{Map: "conns", Field: "pid_tgid", Split: []BitRange{{Label: "proto"}}}:                    `there is already a "proto" label`,
+			{Map: "conns", Field: "proto", Rename: "daddr"}:                                           `there is already a "daddr" label`,
+			{Map: "conns", Field: "proto", Drop: true, Rename: "protocol"}:                            "a dropped field can't be split, named or renamed",
+			{Map: "conns", Field: "proto"}:                                                            `nothing to do with "proto"`,
+		} {
+			Expect(parsedELF.TransformLabels([]LabelTransform{*transform}, LabelEnrichers{})).To(MatchError(ContainSubstring(message)))
+		}
+		// a transformed label can be transformed again, though not a named one split
+		Expect(parsedELF.TransformLabels([]LabelTransform{
+			{Map: "conns", Field: "proto", Values: map[string]string{"6": "tcp"}},
+			{Map: "conns", Field: "proto", Split: []BitRange{{Label: "low", Bits: 4}}},
+		}, LabelEnrichers{})).To(MatchError(ContainSubstring("only integer fields can be split")))
+	})
+
+	It("looks up the address fields in the geoip databases", func() {
+		transform := LabelTransform{Map: "conns", Field: "daddr", GeoIP: []string{"country", "asn"}}
+		Expect(parsedELF.TransformLabels([]LabelTransform{transform}, LabelEnrichers{})).To(MatchError(ContainSubstring(`"daddr" is looked up in the geoip databases, but none is set`)))
+
+		db := &geoip.DB{}
+		for transform, message := range map[*LabelTransform]string{
+			{Map: "conns", Field: "proto", GeoIP: []string{"country"}}:            `only ipv4_addr and ipv6_addr fields can be looked up in the geoip databases, "proto"`,
+			{Map: "conns", Field: "daddr", GeoIP: []string{"city"}}:               `unknown geoip lookup "city"`,
+			{Map: "conns", Field: "daddr", GeoIP: []string{"asn", "asn"}}:         `there is already a "daddr_asn" label`,
+			{Map: "conns", Field: "daddr", GeoIP: []string{"asn"}, Rename: "dst"}: "a field looked up can't be dropped, split, named or renamed in the same transform",
+		} {
+			Expect(parsedELF.TransformLabels([]LabelTransform{*transform}, LabelEnrichers{GeoIP: db})).To(MatchError(ContainSubstring(message)))
+		}
+
+		Expect(parsedELF.TransformLabels([]LabelTransform{
+			{Map: "conns", Field: "daddr", Rename: "dst"},
+			{Map: "conns", Field: "dst", GeoIP: []string{"country", "asn"}},
+		}, LabelEnrichers{GeoIP: db})).To(Succeed())
+		conns := parsedELF.WatchedMaps["conns"]
+		Expect(conns.Labels).To(Equal([]string{"pid_tgid", "dst", "dst_country", "dst_asn", "proto"}))
+		labels := conns.labels.stringify(map[string]interface{}{"pid_tgid": uint64(1), "daddr": net.IP{10, 0, 0, 1}, "proto": uint8(6)})
+		Expect(labels).To(HaveKeyWithValue("dst", "10.0.0.1"))
+		Expect(labels).To(HaveKeyWithValue("dst_country", geoip.Unknown))
+		Expect(labels).To(HaveKeyWithValue("dst_asn", geoip.Unknown))
+	})
+
+	It("looks up the fields in the Kubernetes metadata", func() {
+		transform := LabelTransform{Map: "conns", Field: "daddr", Kubernetes: []string{"pod", "namespace"}}
+		Expect(parsedELF.TransformLabels([]LabelTransform{transform}, LabelEnrichers{})).To(MatchError(ContainSubstring(`"daddr" is looked up in the Kubernetes metadata, but it is not cached`)))
+
+		// the cache is not started, so nothing is known
+		cache, err := kubemeta.New(kubemeta.Config{Config: kube.Config{APIServer: "http://127.0.0.1:1"}})
+		Expect(err).NotTo(HaveOccurred())
+		enrichers := LabelEnrichers{Kubernetes: cache}
+		Expect(parsedELF.TransformLabels([]LabelTransform{
+			{Map: "conns", Field: "daddr", Kubernetes: []string{"container"}},
+		}, enrichers)).To(MatchError(ContainSubstring(`unknown Kubernetes lookup "container"`)))
+		Expect(parsedELF.TransformLabels([]LabelTransform{
+			{Map: "conns", Field: "proto", Values: map[string]string{"6": "tcp"}},
+			{Map: "conns", Field: "proto", Kubernetes: []string{"pod"}},
+		}, enrichers)).To(MatchError(ContainSubstring(`integer cgroup IDs, can be looked up in the Kubernetes metadata, "proto" is not one`)))
+
+		Expect(parsedELF.TransformLabels([]LabelTransform{
+			{Map: "conns", Field: "daddr", Kubernetes: []string{"pod", "namespace"}},
+			{Map: "conns", Field: "pid_tgid", Kubernetes: []string{"service"}},
+		}, enrichers)).To(Succeed())
+		conns := parsedELF.WatchedMaps["conns"]
+		Expect(conns.Labels).To(Equal([]string{"pid_tgid", "pid_tgid_service", "daddr", "daddr_pod", "daddr_namespace", "proto"}))
+		labels := conns.labels.stringify(map[string]interface{}{"pid_tgid": uint64(1), "daddr": net.IP{10, 0, 0, 1}, "proto": uint8(6)})
+		Expect(labels).To(HaveKeyWithValue("daddr_pod", ""))
+		Expect(labels).To(HaveKeyWithValue("daddr_namespace", ""))
+		Expect(labels).To(HaveKeyWithValue("pid_tgid_service", ""))
+	})
+
+	It("reads labels files", func() {
+		dir, err := os.MkdirTemp("", "bee-labels")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "labels.yaml")
+		Expect(os.WriteFile(path, []byte(`
+labels:
+- map: conns
+  field: pid_tgid
+  split:
+  - label: pid
+    bits: 32
+  - label: tgid
+    shift: 32
+- map: conns
+  field: proto
+  values:
+    "6": tcp
+`), 0644)).To(Succeed())
+		transforms, err := LoadLabelTransforms(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(transforms).To(Equal([]LabelTransform{
+			{Map: "conns", Field: "pid_tgid", Split: []BitRange{{Label: "pid", Bits: 32}, {Label: "tgid", Shift: 32}}},
+			{Map: "conns", Field: "proto", Values: map[string]string{"6": "tcp"}},
+		}))
+
+		Expect(os.WriteFile(path, []byte("labels:\n- map: conns\n  field: proto\n  wasm: proto.wasm\n"), 0644)).To(Succeed())
+		_, err = LoadLabelTransforms(path)
+		Expect(err).To(MatchError(ContainSubstring("field wasm not found")))
+	})
+})
@@ -8,7 +8,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cilium/ebpf"
@@ -17,7 +19,9 @@ import (
 	"github.com/cilium/ebpf/ringbuf"
 	"golang.org/x/sync/errgroup"
 
+	v1 "github.com/solo-io/bumblebee/api/v1"
 	"github.com/solo-io/bumblebee/pkg/decoder"
+	"github.com/solo-io/bumblebee/pkg/internal/uname"
 	"github.com/solo-io/bumblebee/pkg/stats"
 	"github.com/solo-io/go-utils/contextutils"
 )
@@ -29,26 +33,145 @@ type ParsedELF struct {
 
 type LoadOptions struct {
 	ParsedELF *ParsedELF
-	Watcher   MapWatcher
+	Watcher   v1.MapWatcher
 	PinMaps   string
 	PinProgs  string
+	// Which maps are pinned to PinMaps, defaults to PinAllMaps
+	MapPinning MapPinning
+	// Hash map keys whose value has not changed for this long stop being exported, 0 disables eviction
+	StaleKeyTTL time.Duration
+	// Also delete stale keys from the kernel map, requires StaleKeyTTL to be set
+	DeleteStaleKeys bool
+	// Settings which can be changed while the program runs, if set StaleKeyTTL is ignored
+	Settings *Settings
+	// Called once the program is attached, before its maps are watched, e.g. to drop privileges
+	AfterAttach func(ctx context.Context) error
+	// Bound to the program once loaded, to pause and resume it, if set
+	Control *Control
+	// Alert the watcher when more events than this are lost for a map within a minute,
+	// lost events are only logged and counted if 0
+	LostEventsAlert uint64
+	// Pages of the buffer of each CPU perf event arrays are read from, defaults to 64
+	PerfBufferPages int
+	// Directory this process is recorded in as the owner of the pins, so they can be
+	// listed and cleaned up once it is over, not recorded if empty
+	PinInventory string
+	// Reference of the program, recorded as the owner of its pins
+	ProgramRef string
+	// Records the raw events of ringbufs and perf event arrays, to replay them, if set
+	Recorder *Recorder
+	// Network interface XDP and TC programs are attached to, or glob of interfaces, e.g. veth*
+	Interface string
+	// Network namespaces XDP and TC programs are attached in, to the Interface of each, by
+	// path, pid:PID, container:ID or pod:UID, the namespace of this process if empty
+	Netns []string
+	// Attach to the host end of the veth of the Interface of each of the Netns instead, e.g.
+	// to see the traffic of a pod before it reaches the namespace of the pod
+	HostVeth bool
+	// Resolve the Netns and Interface again at this interval, and whenever the interfaces of
+	// the namespace of this process change, attaching the XDP and TC programs to the new
+	// targets, e.g. the veth of a pod being started, and detaching them from the ones gone.
+	// Targets are only resolved once if 0, a missing one failing the attach
+	ReattachInterval time.Duration
+	// What to do when other programs are attached to the hook of an XDP or TC program,
+	// defaults to ConflictFail
+	ConflictPolicy ConflictPolicy
+	// Values of the `const volatile` globals of the program, set before it is loaded,
+	// e.g. to filter on a pid in the kernel
+	Constants map[string]interface{}
+	// Values of the Parameters of the program by name, parsed and set along with the
+	// Constants, e.g. as given on the command line
+	Parameters map[string]string
+	// Receives the lines logged while loading the program, up to the DebugLogLevel, e.g. the
+	// Server of the agent API to stream them to its clients
+	DebugLog      func(v1.DebugLogEntry)
+	DebugLogLevel v1.DebugLogLevel
+	// Cgroups the program is scoped to, by container:ID, pod:UID or path in the unified
+	// hierarchy, with the cgroups below them. Their IDs fill the CgroupMapName map the
+	// program must declare, and CgroupScopedConstant is set if declared.
+	Cgroups []string
+	// Log level of the verifier, a combination of 1 for the instructions, 2 for the state of
+	// the registers and 4 for the statistics, overriding the one of the DebugLogLevel. The
+	// logs are kept in the VerifierLog of the programs of the collection
+	VerifierLogLevel uint32
+	// Bytes of the buffer of the verifier log, defaults to ebpf.DefaultVerifierLogSize or
+	// what the DebugLogLevel needs
+	VerifierLogSize int
+	// Times the program is loaded when the verifier log does not fit in its buffer, which is
+	// doubled for each attempt, defaults to 1
+	VerifierAttempts int
+	// ELF file the BTF of the kernel is read from, e.g. from BTFHub, for the kernels which
+	// do not expose it in /sys/kernel/btf/vmlinux
+	TargetBTF string
+	// Values of the kernel config options, as in a .config, e.g. CONFIG_HZ=250, set as the
+	// `const volatile` globals named after them, as __kconfig externs are not supported.
+	// The options the program does not declare are ignored
+	Kconfig map[string]string
+	// Seeds of the settings maps of the program, declared in `.maps.settings` sections,
+	// fetched before it is attached then refreshed while it runs
+	Seeds []MapSeed
+	// Written to the enforcement maps of the program before it is attached, then changed
+	// while it runs, if set
+	Policy *Policy
+	// Switches the program between observing and enforcing while it runs, if set, see
+	// EnforceMapName
+	Enforcement *Enforcement
+	// Attach kprobes to the kernel functions which are unsafe or too hot to probe, e.g. in the
+	// scheduler path, see DangerousProbe
+	AllowDangerousProbes bool
+	// Ramps up the sample rate of the program once attached, if set, see SampleRateMapName
+	Ramp *Ramp
+	// Groups of programs of which only the variant of the kernel release is loaded, see
+	// SelectProbeVariants
+	ProbeVariants []v1.ProbeVariantGroup
+	// Release the probe variants are selected for, defaults to the one of the host
+	KernelRelease string
+	// Kprobe and kretprobe programs attached to many kernel functions, see ResolveMultiProbes
+	MultiProbes []v1.MultiProbe
+	// Most kernel functions a program is attached to once its globs are expanded,
+	// DefaultMaxProbeFunctions if 0
+	MaxProbeFunctions int
+	// Called with what the kprobe and kretprobe programs were attached to, once attached
+	ProbesAttached func([]v1.AttachedProbe)
+	// Features the host must have for the program to be loaded, see CheckPrerequisites
+	HostFeatures []v1.HostFeature
+	// Exports only k-anonymized aggregates of the entries of the program, if set, see Privacy
+	Privacy *Privacy
+	// Records the attach state of the programs and the errors of the program while it runs,
+	// if set
+	Status *Status
 }
 
 type Loader interface {
 	Parse(ctx context.Context, reader io.ReaderAt) (*ParsedELF, error)
 	Load(ctx context.Context, opts *LoadOptions) error
-	WatchMaps(ctx context.Context, watchedMaps map[string]WatchedMap, coll map[string]*ebpf.Map, watcher MapWatcher) error
+	WatchMaps(ctx context.Context, opts *LoadOptions, coll map[string]*ebpf.Map) error
 }
 
 type WatchedMap struct {
 	Name   string
 	Labels []string
+	// Unit declared for the map values in the section name, e.g. `.maps.counter.bytes`
+	Unit stats.Unit
+	// Fields of the events declared as trace_id and span_id, empty if none is, to correlate
+	// the events with distributed traces
+	TraceIDField string
+	SpanIDField  string
+	// Number of the largest entries of a hash map by value which are exported, the others
+	// being summed into a single entry with the OthersLabels, all of them if 0. Declared in
+	// the section name, e.g. `.maps.counter.top10`, or set with ParsedELF.SetTopK
+	TopK int
+	// Fields of the events typed as a duration whose percentiles are computed, if the map
+	// declares the `.quantiles` keyword in its section name
+	LatencyFields []string
 
 	btf     *btf.Map
 	mapType ebpf.MapType
 	mapSpec *ebpf.MapSpec
 
 	valueStruct *btf.Struct
+	// transforms of the Labels, set by ParsedELF.TransformLabels
+	labels *labelMapper
 }
 
 type loader struct {
@@ -72,6 +195,16 @@ const (
 	printMapType   = "print"
 )
 
+// hasKeyword returns whether the given keyword is one of the `.` separated parts of the section name
+func hasKeyword(spec *ebpf.MapSpec, keyword string) bool {
+	for _, k := range strings.Split(spec.SectionName, ".") {
+		if k == keyword {
+			return true
+		}
+	}
+	return false
+}
+
 func isPrintMap(spec *ebpf.MapSpec) bool {
 	return strings.Contains(spec.SectionName, printMapType)
 }
@@ -88,6 +221,16 @@ func isTrackedMap(spec *ebpf.MapSpec) bool {
 	return isCounterMap(spec) || isGaugeMap(spec) || isPrintMap(spec)
 }
 
+// getMapUnit returns the unit declared as one of the section name keywords
+func getMapUnit(spec *ebpf.MapSpec) stats.Unit {
+	for _, keyword := range strings.Split(spec.SectionName, ".") {
+		if unit, ok := stats.ParseUnit(keyword); ok {
+			return unit
+		}
+	}
+	return stats.UnitNone
+}
+
 func (l *loader) Parse(ctx context.Context, progReader io.ReaderAt) (*ParsedELF, error) {
 	spec, err := ebpf.LoadCollectionSpecFromReader(progReader)
 	if err != nil {
@@ -108,6 +251,8 @@ func (l *loader) Parse(ctx context.Context, progReader io.ReaderAt) (*ParsedELF,
 
 		watchedMap := WatchedMap{
 			Name:    name,
+			Unit:    getMapUnit(mapSpec),
+			TopK:    getMapTopK(mapSpec),
 			btf:     mapSpec.BTF,
 			mapType: mapSpec.Type,
 			mapSpec: mapSpec,
@@ -121,14 +266,28 @@ func (l *loader) Parse(ctx context.Context, progReader io.ReaderAt) (*ParsedELF,
 			mapSpec.BTF = nil
 			mapSpec.ValueSize = 0
 		}
+		if watchedMap.mapType == ebpf.Queue || watchedMap.mapType == ebpf.Stack {
+			if _, ok := mapSpec.BTF.Value.(*btf.Struct); !ok {
+				return nil, fmt.Errorf("the `value` member for map '%v' must be set to struct you will be pushing to the queue/stack", name)
+			}
+			// queue and stack maps do not support BTF keys, the value type is kept in the watched map
+			mapSpec.BTF = nil
+		}
 
 		switch mapSpec.Type {
-		case ebpf.RingBuf:
+		case ebpf.RingBuf, ebpf.PerfEventArray, ebpf.Queue, ebpf.Stack:
 			structType := watchedMap.btf.Value.(*btf.Struct)
 			watchedMap.valueStruct = structType
 			labelKeys := getLabelsForBtfStruct(structType)
 
 			watchedMap.Labels = labelKeys
+			watchedMap.TraceIDField, watchedMap.SpanIDField = getCorrelationFields(structType)
+			if hasKeyword(mapSpec, quantilesKeyword) {
+				watchedMap.LatencyFields = getLatencyFields(structType)
+				if len(watchedMap.LatencyFields) == 0 {
+					return nil, fmt.Errorf("map '%v' declares quantiles, but none of the fields of its struct is a duration", name)
+				}
+			}
 		case ebpf.Hash:
 			labelKeys, err := getLabelsForHashMapKey(mapSpec)
 			if err != nil {
@@ -156,134 +315,518 @@ func (l *loader) Load(ctx context.Context, opts *LoadOptions) error {
 	// on shutdown notify watcher we have no more entries to send
 	defer opts.Watcher.Close()
 
+	if opts.DeleteStaleKeys && opts.liveSettings().StaleKeyTTL == 0 {
+		return errors.New("deleting stale keys requires a stale key TTL to be set")
+	}
+	if err := opts.Privacy.check(opts); err != nil {
+		return err
+	}
+
+	attached, err := Attach(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer attached.Close()
+	opts.Control.bindAttached(opts.ParsedELF.Spec, attached)
+	opts.Status.bindAttached(attached)
+	defer opts.Status.unbind()
+	opts.Ramp.bindPrograms(attached.Collection.Programs)
+
+	if opts.AfterAttach != nil {
+		if err := opts.AfterAttach(ctx); err != nil {
+			return err
+		}
+	}
+	return l.WatchMaps(ctx, opts, attached.Collection.Maps)
+}
+
+// Attached is a collection loaded into the kernel, with its programs attached.
+type Attached struct {
+	Collection *ebpf.Collection
+	spec       *ebpf.CollectionSpec
+	// guards the links and targets, changed when reattaching and by the Control
+	lock   sync.Mutex
+	closed bool
+	links  []io.Closer
+	// interfaces XDP and TC programs are attached to, again when resumed
+	targets  []netTarget
+	resolver *netResolver
+	// kernel functions of the multi probes, by the name of their program
+	multiProbes map[string]*ProbeTargets
+	// attach state of the programs, by the name of their function
+	states map[string]*probeState
+	// records the errors of reattaching, if set
+	status *Status
+}
+
+// Probes returns what the kprobe and kretprobe programs were last attached to.
+func (a *Attached) Probes() []v1.AttachedProbe {
+	return probeReport(a.spec, a.multiProbes)
+}
+
+// Close detaches the programs and releases the collection, unless pinned.
+func (a *Attached) Close() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.closed = true
+	for _, l := range a.links {
+		l.Close()
+	}
+	if a.resolver != nil {
+		a.resolver.Close()
+	}
+	a.Collection.Close()
+}
+
+// LinkFDs returns the descriptors of the bpf links of the programs, which keep them attached
+// while any is open. Programs attached with perf events or netlink are left out.
+func (a *Attached) LinkFDs() []int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	var fds []int
+	for _, l := range a.links {
+		fds = appendLinkFDs(fds, l)
+	}
+	return fds
+}
+
+func appendLinkFDs(fds []int, l io.Closer) []int {
+	switch l := l.(type) {
+	case interface{ FD() int }:
+		fds = append(fds, l.FD())
+	case multiLink:
+		for _, l := range l {
+			fds = appendLinkFDs(fds, l)
+		}
+	case *netLinks:
+		for _, t := range l.links {
+			fds = appendLinkFDs(fds, t.Closer)
+		}
+	}
+	return fds
+}
+
+// netTargets opens the network namespaces of the options, closed with the Attached. Unless
+// reattaching, a namespace or interface which can't be found fails.
+func (a *Attached) netTargets(ctx context.Context, opts *LoadOptions) error {
+	a.resolver = newNetResolver(opts)
+	targets, skipped, err := a.resolver.resolve(opts.ReattachInterval == 0)
+	if err != nil {
+		return err
+	}
+	for _, err := range skipped {
+		contextutils.LoggerFrom(ctx).Warnf("not attaching to a network target until found: %v", err)
+	}
+	a.targets = targets
+	return nil
+}
+
+// multiLink closes the links of a program attached to several interfaces.
+type multiLink []io.Closer
+
+func (m multiLink) Close() error {
+	var firstErr error
+	for _, l := range m {
+		if err := l.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Attach loads the program into the kernel and attaches it, without watching its maps.
+// Only the ParsedELF and pinning options are used.
+func Attach(ctx context.Context, opts *LoadOptions) (*Attached, error) {
 	// bail out before loading stuff into kernel if context canceled
 	if ctx.Err() != nil {
 		contextutils.LoggerFrom(ctx).Info("load entrypoint context is done")
-		return ctx.Err()
+		return nil, ctx.Err()
+	}
+	if err := CheckPrerequisites(opts.HostFeatures); err != nil {
+		return nil, err
 	}
 
-	if opts.PinMaps != "" {
-		// Specify that we'd like to pin the referenced maps, or open them if already existing.
-		for _, m := range opts.ParsedELF.Spec.Maps {
-			// Do not pin/load read-only data
-			if strings.HasSuffix(m.Name, ".rodata") {
-				continue
-			}
-
-			// PinByName specifies that we should pin the map by name, or load it if it already exists.
-			m.Pinning = ebpf.PinByName
+	if len(opts.ProbeVariants) > 0 {
+		release := opts.KernelRelease
+		if release == "" {
+			release = uname.Release()
+		}
+		selected, err := SelectProbeVariants(opts.ParsedELF.Spec, opts.ProbeVariants, release)
+		if err != nil {
+			return nil, err
+		}
+		for group, program := range selected {
+			contextutils.LoggerFrom(ctx).Infof("selected the %s variant of probe group %s for kernel %s", program, group, release)
 		}
 	}
+	blacklist := readKprobeBlacklist()
+	var functions []string
+	if needsProbeableFunctions(opts.ParsedELF.Spec, opts.MultiProbes) {
+		functions = readProbeableFunctions()
+	}
+	multiProbes, err := ResolveMultiProbes(opts.ParsedELF.Spec, opts.MultiProbes, functions, blacklist, opts.MaxProbeFunctions)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkProbes(opts.ParsedELF.Spec, multiProbes, opts.AllowDangerousProbes, blacklist); err != nil {
+		return nil, err
+	}
+	if useKprobeMulti(opts.ParsedELF.Spec, multiProbes) {
+		contextutils.LoggerFrom(ctx).Infof("attaching %d multi probes with kprobe.multi links", len(multiProbes))
+	}
+	if err := pinMaps(opts.ParsedELF.Spec, opts); err != nil {
+		return nil, err
+	}
 
 	spec := opts.ParsedELF.Spec
+	constants, err := kconfigConstants(opts.ParsedELF.Parameters(), opts.Kconfig)
+	if err != nil {
+		return nil, err
+	}
+	parameters, err := ParseConstants(opts.ParsedELF.Parameters(), opts.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range parameters {
+		constants[name] = value
+	}
+	cgroups, err := resolveCgroups(opts.Cgroups)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSeeds(spec, opts.Seeds); err != nil {
+		return nil, err
+	}
+	if len(cgroups) > 0 {
+		if _, ok := spec.Maps[CgroupMapName]; !ok {
+			return nil, fmt.Errorf("the program must declare a %s map to be scoped to cgroups", CgroupMapName)
+		}
+		for _, param := range opts.ParsedELF.Parameters() {
+			if param.Name == CgroupScopedConstant {
+				constants[CgroupScopedConstant] = true
+			}
+		}
+	}
+	for name, value := range opts.Constants {
+		constants[name] = value
+	}
+	if len(constants) > 0 {
+		if err := spec.RewriteConstants(constants); err != nil {
+			return nil, fmt.Errorf("could not set the constants of the program: %w", err)
+		}
+	}
 	// Load our eBPF spec into the kernel
-	coll, err := ebpf.NewCollectionWithOptions(opts.ParsedELF.Spec, ebpf.CollectionOptions{
-		Maps: ebpf.MapOptions{
-			PinPath: opts.PinMaps,
-		},
-	})
+	debug := newDebugLog(opts)
+	debug.infof("", "Loading %d programs and %d maps", len(spec.Programs), len(spec.Maps))
+	pins := newPinRollback(opts.PinMaps)
+	coll, err := loadCollection(spec, opts, debug)
 	if err != nil {
-		return err
+		debug.failed(err)
+		return nil, err
+	}
+	for name, prog := range coll.Programs {
+		debug.verifierLog(name, prog.VerifierLog)
+	}
+	attached := &Attached{Collection: coll, spec: spec, multiProbes: multiProbes, status: opts.Status}
+	// nothing is left attached or pinned once an attach failed
+	rollback := func() {
+		opts.Policy.unbind()
+		pins.rollback(coll.Maps)
+		attached.Close()
+	}
+	if err := scopeCgroups(coll.Maps, cgroups); err != nil {
+		rollback()
+		return nil, err
+	}
+	if err := seedMaps(ctx, spec, coll.Maps, opts.Seeds); err != nil {
+		rollback()
+		return nil, err
+	}
+	if err := opts.Policy.bindMaps(coll.Maps); err != nil {
+		rollback()
+		return nil, err
+	}
+	if err := clearEnforceMap(coll.Maps); err != nil {
+		rollback()
+		return nil, err
+	}
+	if err := attached.netTargets(ctx, opts); err != nil {
+		rollback()
+		return nil, err
+	}
+	if err := attachPrograms(ctx, opts, spec, attached, pins); err != nil {
+		debug.failed(err)
+		rollback()
+		return nil, err
+	}
+	debug.infof("", "Attached %d programs", len(spec.Programs))
+	probes := attached.Probes()
+	for _, probe := range probes {
+		if len(probe.Patterns) == 1 && !IsProbeGlob(probe.Patterns[0]) {
+			continue
+		}
+		contextutils.LoggerFrom(ctx).Infof("attached the %s '%s' to %d functions matching %s with %s links, skipped %d", probe.Type, probe.Program, len(probe.Functions), strings.Join(probe.Patterns, ", "), probe.Link, len(probe.Skipped))
 	}
-	defer coll.Close()
+	if opts.ProbesAttached != nil {
+		opts.ProbesAttached(probes)
+	}
+	if err := recordPins(ctx, opts); err != nil {
+		rollback()
+		return nil, err
+	}
+	if opts.ReattachInterval > 0 {
+		go attached.reattach(ctx, opts.ReattachInterval)
+	}
+	return attached, nil
+}
 
+// attachPrograms attaches the programs in the order of their names, failing with an
+// AttachError listing the programs attached before the failure, which the caller detaches.
+func attachPrograms(ctx context.Context, opts *LoadOptions, spec *ebpf.CollectionSpec, attached *Attached, pins *pinRollback) error {
+	coll := attached.Collection
+	names := make([]string, 0, len(spec.Programs))
+	for name := range spec.Programs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 	// For each program, add kprope/tracepoint
-	for name, prog := range spec.Programs {
-		select {
-		case <-ctx.Done():
-			contextutils.LoggerFrom(ctx).Info("while loading progs context is done")
-			return ctx.Err()
-		default:
-			switch prog.Type {
-			case ebpf.Kprobe:
-				var kp link.Link
-				var err error
-				if strings.HasPrefix(prog.SectionName, "kretprobe/") {
-					kp, err = link.Kretprobe(prog.AttachTo, coll.Programs[name])
-					if err != nil {
-						return fmt.Errorf("error attaching kretprobe '%v': %w", prog.Name, err)
-					}
-				} else {
-					kp, err = link.Kprobe(prog.AttachTo, coll.Programs[name])
-					if err != nil {
-						return fmt.Errorf("error attaching kprobe '%v': %w", prog.Name, err)
-					}
-				}
-				defer kp.Close()
-			case ebpf.TracePoint:
-				var tp link.Link
-				var err error
-				if strings.HasPrefix(prog.SectionName, "tracepoint/") {
-					tokens := strings.Split(prog.AttachTo, "/")
-					if len(tokens) != 2 {
-						return fmt.Errorf("unexpected tracepoint section '%v'", prog.AttachTo)
-					}
-					tp, err = link.Tracepoint(tokens[0], tokens[1], coll.Programs[name])
-					if err != nil {
-						return fmt.Errorf("error attaching to tracepoint '%v': %w", prog.Name, err)
-					}
-				}
-				defer tp.Close()
-			default:
-				return errors.New("only kprobe programs supported")
+	for i, name := range names {
+		prog := spec.Programs[name]
+		if err := attachOne(ctx, opts, prog, coll.Programs[name], attached, pins); err != nil {
+			if len(names) == 1 {
+				return err
 			}
-			if opts.PinProgs != "" {
-				if err := createDir(ctx, opts.PinProgs, 0700); err != nil {
-					return err
-				}
+			return &AttachError{
+				Failed:     map[string]error{prog.Name: fmt.Errorf("program %d of %d: %w", i+1, len(names), err)},
+				RolledBack: programNames(spec, names[:i]),
+				Skipped:    programNames(spec, names[i+1:]),
+			}
+		}
+	}
+	return nil
+}
 
-				pinFile := filepath.Join(opts.PinProgs, prog.Name)
-				if err := coll.Programs[name].Pin(pinFile); err != nil {
-					return fmt.Errorf("could not pin program '%s': %v", prog.Name, err)
-				}
-				fmt.Printf("Successfully pinned program '%v'\n", pinFile)
+// attachOne attaches and pins a program of the collection.
+func attachOne(ctx context.Context, opts *LoadOptions, prog *ebpf.ProgramSpec, loaded *ebpf.Program, attached *Attached, pins *pinRollback) error {
+	select {
+	case <-ctx.Done():
+		contextutils.LoggerFrom(ctx).Info("while loading progs context is done")
+		return ctx.Err()
+	default:
+	}
+	l, err := attachProgram(prog, loaded, attached.multiProbes[prog.Name], attached.targets)
+	// not shared with the Control nor the Status yet
+	attached.attachedProgram(prog, loaded, l, err)
+	if err != nil {
+		return err
+	}
+	if l != nil {
+		attached.links = append(attached.links, l)
+	}
+	if opts.PinProgs != "" {
+		if err := createDir(ctx, opts.PinProgs, 0700); err != nil {
+			return err
+		}
+
+		pinFile := filepath.Join(opts.PinProgs, prog.Name)
+		if err := loaded.Pin(pinFile); err != nil {
+			return fmt.Errorf("could not pin program '%s': %v", prog.Name, err)
+		}
+		pins.pinnedProgram(loaded)
+		fmt.Printf("Successfully pinned program '%v'\n", pinFile)
+	}
+	return nil
+}
+
+// programNames returns the names of the functions of the programs of the spec.
+func programNames(spec *ebpf.CollectionSpec, names []string) []string {
+	programs := make([]string, 0, len(names))
+	for _, name := range names {
+		programs = append(programs, spec.Programs[name].Name)
+	}
+	return programs
+}
+
+// attachProgram attaches a loaded program to its kprobe, tracepoint or network hook, the
+// returned link is nil for tracepoint programs not declared in a `tracepoint/` section.
+// Kprobe programs are attached to every kernel function of their probe targets, if any, and
+// network programs to every target.
+func attachProgram(prog *ebpf.ProgramSpec, loaded *ebpf.Program, probeTargets *ProbeTargets, targets []netTarget) (io.Closer, error) {
+	switch prog.Type {
+	case ebpf.Kprobe:
+		if probeTargets != nil {
+			return attachMultiProbe(prog, loaded, probeTargets)
+		}
+		if strings.HasPrefix(prog.SectionName, "kretprobe/") {
+			kp, err := link.Kretprobe(prog.AttachTo, loaded)
+			if err != nil {
+				return nil, fmt.Errorf("error attaching kretprobe '%v': %w", prog.Name, err)
 			}
+			return kp, nil
+		}
+		kp, err := link.Kprobe(prog.AttachTo, loaded)
+		if err != nil {
+			return nil, fmt.Errorf("error attaching kprobe '%v': %w", prog.Name, err)
+		}
+		return kp, nil
+	case ebpf.TracePoint:
+		if !strings.HasPrefix(prog.SectionName, "tracepoint/") {
+			return nil, nil
 		}
+		tokens := strings.Split(prog.AttachTo, "/")
+		if len(tokens) != 2 {
+			return nil, fmt.Errorf("unexpected tracepoint section '%v'", prog.AttachTo)
+		}
+		tp, err := link.Tracepoint(tokens[0], tokens[1], loaded)
+		if err != nil {
+			return nil, fmt.Errorf("error attaching to tracepoint '%v': %w", prog.Name, err)
+		}
+		return tp, nil
+	case ebpf.XDP:
+		return attachNet(attachXDP, prog, loaded, targets)
+	case ebpf.SchedCLS:
+		return attachNet(attachTC, prog, loaded, targets)
+	default:
+		return nil, errors.New("only kprobe, tracepoint, XDP and TC programs supported")
 	}
+}
 
-	return l.WatchMaps(ctx, opts.ParsedELF.WatchedMaps, coll.Maps, opts.Watcher)
+func attachNet(
+	attach func(*ebpf.ProgramSpec, *ebpf.Program, netTarget) (io.Closer, error),
+	prog *ebpf.ProgramSpec,
+	loaded *ebpf.Program,
+	targets []netTarget,
+) (io.Closer, error) {
+	links := &netLinks{prog: prog, loaded: loaded, attach: attach, links: map[string]targetLink{}}
+	for _, t := range targets {
+		l, err := attach(prog, loaded, t)
+		if err != nil {
+			links.Close()
+			return nil, err
+		}
+		links.links[t.key()] = targetLink{Closer: l, name: t.name()}
+	}
+	return links, nil
 }
 
 func (l *loader) WatchMaps(
 	ctx context.Context,
-	watchedMaps map[string]WatchedMap,
+	opts *LoadOptions,
 	maps map[string]*ebpf.Map,
-	watcher MapWatcher,
 ) error {
 	contextutils.LoggerFrom(ctx).Info("enter watchMaps()")
+	if err := opts.Privacy.check(opts); err != nil {
+		return err
+	}
+	if err := opts.Control.bindMaps(ctx, maps); err != nil {
+		return err
+	}
+	defer opts.Control.unbind()
+	defer opts.Policy.unbind()
+	if err := opts.Enforcement.bindMaps(ctx, maps, opts.Cgroups, l.metricsProvider); err != nil {
+		return err
+	}
+	defer opts.Enforcement.unbind()
+	watcher := opts.Watcher
 	eg, ctx := errgroup.WithContext(ctx)
-	for name, bpfMap := range watchedMaps {
+	var lost *lostTracker
+	for _, bpfMap := range opts.ParsedELF.WatchedMaps {
+		if bpfMap.mapType == ebpf.RingBuf || bpfMap.mapType == ebpf.PerfEventArray {
+			lost = newLostTracker(l.metricsProvider, watcher, opts.LostEventsAlert)
+			break
+		}
+	}
+	ramp, err := startSampleRate(ctx, maps, opts.Ramp, lost, l.metricsProvider)
+	if err != nil {
+		return err
+	}
+	if ramp != nil {
+		eg.Go(ramp)
+	}
+	for name, bpfMap := range opts.ParsedELF.WatchedMaps {
 		name := name
 		bpfMap := bpfMap
 
+		metricOpts := &stats.MetricOpts{
+			Name:   name,
+			Labels: bpfMap.Labels,
+			Unit:   bpfMap.Unit,
+		}
+		latency := newLatencyStats(name, bpfMap.LatencyFields, l.metricsProvider)
+		if latency != nil {
+			if opts.Privacy != nil {
+				latency.minCount = opts.Privacy.minCount()
+			}
+			eg.Go(func() error {
+				latency.run(ctx, watcher)
+				return nil
+			})
+		}
+
 		switch bpfMap.mapType {
 		case ebpf.RingBuf:
 			var increment stats.IncrementInstrument
 			if isCounterMap(bpfMap.mapSpec) {
-				increment = l.metricsProvider.NewIncrementCounter(name, bpfMap.Labels)
+				increment = l.metricsProvider.NewIncrementCounter(metricOpts)
 			} else if isPrintMap(bpfMap.mapSpec) {
 				increment = &noop{}
 			}
+			increment, watcher := opts.Privacy.events(name, increment, watcher)
 			eg.Go(func() error {
 				watcher.NewRingBuf(name, bpfMap.Labels)
-				return l.startRingBuf(ctx, bpfMap.valueStruct, maps[name], increment, name, watcher)
+				return l.startRingBuf(ctx, bpfMap.valueStruct, maps[name], increment, latency, name, watcher, opts)
+			})
+			if lostMap, ok := maps[name+LostMapSuffix]; ok {
+				eg.Go(func() error {
+					return l.startLostMap(ctx, lostMap, name, lost, opts)
+				})
+			}
+		case ebpf.PerfEventArray:
+			var increment stats.IncrementInstrument
+			if isCounterMap(bpfMap.mapSpec) {
+				increment = l.metricsProvider.NewIncrementCounter(metricOpts)
+			} else {
+				increment = &noop{}
+			}
+			increment, watcher := opts.Privacy.events(name, increment, watcher)
+			eg.Go(func() error {
+				// samples are events, so they are rendered the same way as ringbuf entries
+				watcher.NewRingBuf(name, bpfMap.Labels)
+				return l.startPerfBuf(ctx, bpfMap.valueStruct, maps[name], increment, latency, name, watcher, lost, opts)
+			})
+		case ebpf.Queue, ebpf.Stack:
+			var increment stats.IncrementInstrument
+			if isCounterMap(bpfMap.mapSpec) {
+				increment = l.metricsProvider.NewIncrementCounter(metricOpts)
+			} else {
+				increment = &noop{}
+			}
+			increment, watcher := opts.Privacy.events(name, increment, watcher)
+			eg.Go(func() error {
+				// entries are events, so they are rendered the same way as ringbuf entries
+				watcher.NewRingBuf(name, bpfMap.Labels)
+				return l.startQueue(ctx, bpfMap.valueStruct, maps[name], increment, latency, name, watcher, opts)
 			})
 		case ebpf.Array:
 			fallthrough
 		case ebpf.Hash:
+			if opts.Privacy != nil && !isCounterMap(bpfMap.mapSpec) {
+				contextutils.LoggerFrom(ctx).Infof("not exporting map '%s' in the aggregation only mode, as it is not a counter", name)
+				continue
+			}
 			labelKeys := bpfMap.Labels
 			var instrument stats.SetInstrument
 			if isCounterMap(bpfMap.mapSpec) {
-				instrument = l.metricsProvider.NewSetCounter(bpfMap.Name, labelKeys)
+				instrument = l.metricsProvider.NewSetCounter(metricOpts)
 			} else if isGaugeMap(bpfMap.mapSpec) {
-				instrument = l.metricsProvider.NewGauge(bpfMap.Name, labelKeys)
+				instrument = l.metricsProvider.NewGauge(metricOpts)
 			} else {
 				instrument = &noop{}
 			}
 			eg.Go(func() error {
 				// TODO: output type of instrument in UI?
 				watcher.NewHashMap(name, labelKeys)
-				return l.startHashMap(ctx, bpfMap.mapSpec, maps[name], instrument, name, watcher)
+				return l.startHashMap(ctx, bpfMap.mapSpec, maps[name], instrument, name, watcher, opts)
 			})
 		default:
 			// TODO: Support more map types
@@ -291,7 +834,18 @@ func (l *loader) WatchMaps(
 		}
 	}
 
-	err := eg.Wait()
+	for _, seed := range opts.Seeds {
+		seed := seed
+		if seed.Interval == 0 {
+			continue
+		}
+		eg.Go(func() error {
+			refreshSeed(ctx, opts.ParsedELF.Spec.Maps[seed.Map], maps[seed.Map], seed)
+			return nil
+		})
+	}
+
+	err = eg.Wait()
 	contextutils.LoggerFrom(ctx).Info("after waitgroup")
 	return err
 }
@@ -301,11 +855,14 @@ func (l *loader) startRingBuf(
 	valueStruct *btf.Struct,
 	liveMap *ebpf.Map,
 	incrementInstrument stats.IncrementInstrument,
+	latency *latencyStats,
 	name string,
-	watcher MapWatcher,
+	watcher v1.MapWatcher,
+	opts *LoadOptions,
 ) error {
 	// Initialize decoder
 	d := l.decoderFactory()
+	labels := opts.ParsedELF.WatchedMaps[name].labels
 	logger := contextutils.LoggerFrom(ctx)
 
 	// Open a ringbuf reader from userspace RINGBUF map described in the
@@ -334,18 +891,21 @@ func (l *loader) startRingBuf(
 				return nil
 			}
 			logger.Infof("error while reading from ringbuf '%s' reader: %s", name, err)
+			opts.Status.recordError(name, err)
 			continue
 		}
+		opts.Recorder.record(ctx, name, record.RawSample)
 		result, err := d.DecodeBtfBinary(ctx, valueStruct, record.RawSample)
 		if err != nil {
 			return err
 		}
 
-		stringLabels := stringify(result)
+		latency.observe(result)
+		stringLabels := labels.stringify(result)
 		incrementInstrument.Increment(ctx, stringLabels)
-		watcher.SendEntry(MapEntry{
+		watcher.SendEntry(v1.MapEntry{
 			Name: name,
-			Entry: KvPair{
+			Entry: v1.KvPair{
 				Key: stringLabels,
 			},
 		})
@@ -358,32 +918,55 @@ func (l *loader) startHashMap(
 	liveMap *ebpf.Map,
 	instrument stats.SetInstrument,
 	name string,
-	watcher MapWatcher,
+	watcher v1.MapWatcher,
+	opts *LoadOptions,
 ) error {
 	d := l.decoderFactory()
+	labels := opts.ParsedELF.WatchedMaps[name].labels
+	logger := contextutils.LoggerFrom(ctx)
+	consume := isConsumeMap(mapSpec)
+	var reader entryReader = &mapReader{consume: consume}
+	mmapped, err := newMmapReader(mapSpec, liveMap)
+	if err != nil {
+		logger.Infof("reading map '%s' with lookups: %v", name, err)
+	} else if mmapped != nil {
+		defer mmapped.close()
+		reader = mmapped
+	}
+	settings := opts.liveSettings()
+	// the keys of consumed maps are deleted once read, so as many as the map holds are kept
+	tracker := newStaleKeyTracker(settings.StaleKeyTTL, consume, int(mapSpec.MaxEntries))
+	// running totals of consumed counter maps, as each read only returns the increment since
+	// the last read, dropped along with the keys of the tracker
+	totals := map[string]uint64{}
+	var top *topK
+	if watched := opts.ParsedELF.WatchedMaps[name]; watched.TopK > 0 {
+		top = newTopK(watched.TopK, watched.Labels, isCounterMap(mapSpec))
+	}
 
-	ticker := time.NewTicker(1 * time.Second)
+	ticker := time.NewTicker(settings.PollInterval)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-ticker.C:
-			mapIter := liveMap.Iterate()
-			for {
-				// Use generic key,value so we can decode ourselves
-				var (
-					key, value []byte
-				)
-				if !mapIter.Next(&key, &value) {
-					break
+		case now := <-ticker.C:
+			if current := opts.liveSettings(); current != settings {
+				if current.PollInterval != settings.PollInterval {
+					ticker.Reset(current.PollInterval)
 				}
-				if err := mapIter.Err(); err != nil {
-					return err
-				}
-				decodedKey, err := d.DecodeBtfBinary(ctx, mapSpec.BTF.Key, key)
+				tracker.ttl = current.StaleKeyTTL
+				settings = current
+			}
+			entries, err := reader.read(liveMap)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				decodedKey, err := d.DecodeBtfBinary(ctx, mapSpec.BTF.Key, entry.key)
 				if err != nil {
 					return fmt.Errorf("error decoding key: %w", err)
 				}
 
-				decodedValue, err := d.DecodeBtfBinary(ctx, mapSpec.BTF.Value, value)
+				decodedValue, err := d.DecodeBtfBinary(ctx, mapSpec.BTF.Value, entry.value)
 				if err != nil {
 					return fmt.Errorf("error decoding value: %w", err)
 				}
@@ -396,14 +979,70 @@ func (l *loader) startHashMap(
 				if !ok {
 					log.Fatal("only uint64 allowed")
 				}
-				stringLabels := stringify(decodedKey)
+				if consume && isCounterMap(mapSpec) {
+					intVal += totals[string(entry.key)]
+					totals[string(entry.key)] = intVal
+				}
+				stringLabels := labels.stringify(decodedKey)
+				if !tracker.observe(now, entry.key, stringLabels, intVal) {
+					continue
+				}
+				if opts.Privacy.holdsBack(intVal) {
+					continue
+				}
+				if top != nil {
+					top.add(entry.key, stringLabels, intVal)
+					continue
+				}
 				instrument.Set(ctx, int64(intVal), stringLabels)
-				thisKvPair := KvPair{Key: stringLabels, Value: fmt.Sprint(intVal)}
-				watcher.SendEntry(MapEntry{
+				thisKvPair := v1.KvPair{Key: stringLabels, Value: fmt.Sprint(intVal)}
+				watcher.SendEntry(v1.MapEntry{
 					Name:  name,
 					Entry: thisKvPair,
 				})
 			}
+			if top != nil {
+				ranked, others, gone := top.rank()
+				for _, labels := range gone {
+					instrument.Delete(ctx, labels)
+					deleteEntry(watcher, v1.MapEntry{Name: name, Entry: v1.KvPair{Key: labels}})
+				}
+				// the others entry is sent last, once the largest entries of the read are
+				if others != nil {
+					ranked = append(ranked, *others)
+				}
+				for _, entry := range ranked {
+					if opts.Privacy.holdsBack(entry.value) {
+						continue
+					}
+					instrument.Set(ctx, int64(entry.value), entry.labels)
+					watcher.SendEntry(v1.MapEntry{
+						Name:  name,
+						Entry: v1.KvPair{Key: entry.labels, Value: fmt.Sprint(entry.value)},
+					})
+				}
+			}
+
+			stale, removed := tracker.sweep(now)
+			// unless eviction is disabled, the last known value of every key being exported
+			if settings.StaleKeyTTL > 0 {
+				for _, key := range removed {
+					instrument.Delete(ctx, key.labels)
+					deleteEntry(watcher, v1.MapEntry{Name: name, Entry: v1.KvPair{Key: key.labels}})
+				}
+			}
+			for _, key := range stale {
+				logger.Infof("evicting stale key %v from map '%s'", key.labels, name)
+				instrument.Delete(ctx, key.labels)
+				deleteEntry(watcher, v1.MapEntry{Name: name, Entry: v1.KvPair{Key: key.labels}})
+				delete(totals, string(key.raw))
+				if consume || !opts.DeleteStaleKeys {
+					continue
+				}
+				if err := liveMap.Delete(key.raw); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+					logger.Infof("could not delete stale key %v from map '%s': %s", key.labels, name, err)
+				}
+			}
 
 		case <-ctx.Done():
 			// fmt.Println("got done in hashmap loop, returning")
@@ -430,6 +1069,23 @@ func getLabelsForHashMapKey(mapSpec *ebpf.MapSpec) ([]string, error) {
 	return getLabelsForBtfStruct(structKey), nil
 }
 
+// getCorrelationFields returns the first members of the struct typed as a trace_id and span_id.
+func getCorrelationFields(structType *btf.Struct) (traceID string, spanID string) {
+	for _, member := range structType.Members {
+		typedef, ok := member.Type.(*btf.Typedef)
+		if !ok {
+			continue
+		}
+		switch {
+		case typedef.Name == decoder.TraceIDTypeName && traceID == "":
+			traceID = member.Name
+		case typedef.Name == decoder.SpanIDTypeName && spanID == "":
+			spanID = member.Name
+		}
+	}
+	return traceID, spanID
+}
+
 func getLabelsForBtfStruct(structKey *btf.Struct) []string {
 	keys := make([]string, 0, len(structKey.Members))
 	for _, v := range structKey.Members {
@@ -453,6 +1109,12 @@ func (n *noop) Set(
 ) {
 }
 
+func (n *noop) Delete(
+	ctx context.Context,
+	labels map[string]string,
+) {
+}
+
 func createDir(ctx context.Context, path string, perm os.FileMode) error {
 	file, err := os.Stat(path)
 	if os.IsNotExist(err) {
@@ -0,0 +1,46 @@
+package loader
+
+import (
+	"context"
+	"io"
+
+	"github.com/cilium/ebpf"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Attach", func() {
+	It("does not load the program if its constants can't be set", func() {
+		// without a .rodata section the program declares no constants
+		opts := &LoadOptions{
+			ParsedELF: &ParsedELF{Spec: &ebpf.CollectionSpec{
+				Maps:     map[string]*ebpf.MapSpec{},
+				Programs: map[string]*ebpf.ProgramSpec{},
+			}},
+			Constants: map[string]interface{}{"bee_target_tgid": uint32(42)},
+		}
+		_, err := Attach(context.Background(), opts)
+		Expect(err).To(MatchError(ContainSubstring("could not set the constants of the program")))
+	})
+
+	It("returns the descriptors of the bpf links", func() {
+		attached := &Attached{links: []io.Closer{
+			fdLink(3),
+			multiLink{fdLink(4), nopLink{}},
+			&netLinks{links: map[string]targetLink{"eth0": {Closer: fdLink(5), name: "eth0"}}},
+			nopLink{},
+		}}
+		Expect(attached.LinkFDs()).To(Equal([]int{3, 4, 5}))
+	})
+})
+
+// fdLink is a bpf link with a descriptor.
+type fdLink int
+
+func (l fdLink) Close() error { return nil }
+func (l fdLink) FD() int      { return int(l) }
+
+// nopLink is an attachment without a descriptor, as a perf event.
+type nopLink struct{}
+
+func (nopLink) Close() error { return nil }
@@ -0,0 +1,178 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/stats"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+const (
+	// LostMapSuffix names the map a program counts the events it could not submit to a
+	// ring buffer in, e.g. events_lost for the events ring buffer: an array, or per-CPU
+	// array, with a single u64 the program increments when bpf_ringbuf_reserve fails.
+	LostMapSuffix = "_lost"
+	// LostEventsMapName is the ring buffer the alerts of lost events are sent to watchers as
+	LostEventsMapName = "bee_lost_events"
+	// LostEventsMetric is the metric of the total number of events lost per map
+	LostEventsMetric = "bee_lost_events"
+
+	// PerfLostReason is the reason of samples the kernel dropped as a perf buffer was full
+	PerfLostReason = "perf_buffer_full"
+	// RingBufLostReason is the reason of events the program could not reserve space for
+	RingBufLostReason = "ringbuf_reserve_failed"
+
+	// window the number of lost events is compared to the alert threshold over
+	lostAlertWindow = time.Minute
+)
+
+var lostLabels = []string{"map", "reason"}
+
+type lostKey struct {
+	name, reason string
+}
+
+type lostWindow struct {
+	start   time.Time
+	lost    uint64
+	alerted bool
+}
+
+// lostTracker accounts for the events of a program lost before they were read, and alerts
+// the watcher when more than the threshold are lost within a minute.
+type lostTracker struct {
+	lock       sync.Mutex
+	instrument stats.SetInstrument
+	watcher    v1.MapWatcher
+	threshold  uint64
+	totals     map[lostKey]uint64
+	windows    map[lostKey]*lostWindow
+}
+
+func newLostTracker(metricsProvider stats.MetricsProvider, watcher v1.MapWatcher, threshold uint64) *lostTracker {
+	t := &lostTracker{
+		instrument: metricsProvider.NewSetCounter(&stats.MetricOpts{Name: LostEventsMetric, Labels: lostLabels}),
+		watcher:    watcher,
+		threshold:  threshold,
+		totals:     map[lostKey]uint64{},
+		windows:    map[lostKey]*lostWindow{},
+	}
+	if threshold > 0 {
+		watcher.NewRingBuf(LostEventsMapName, []string{"map", "reason", "lost", "window"})
+	}
+	return t
+}
+
+func (t *lostTracker) add(ctx context.Context, now time.Time, name, reason string, lost uint64) {
+	if lost == 0 {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	key := lostKey{name: name, reason: reason}
+	t.totals[key] += lost
+	t.instrument.Set(ctx, int64(t.totals[key]), map[string]string{"map": name, "reason": reason})
+
+	window := t.windows[key]
+	if window == nil || now.Sub(window.start) >= lostAlertWindow {
+		window = &lostWindow{start: now}
+		t.windows[key] = window
+	}
+	window.lost += lost
+	logger := contextutils.LoggerFrom(ctx)
+	if t.threshold == 0 || window.lost <= t.threshold || window.alerted {
+		logger.Warnf("lost %d events of map '%s': %s", lost, name, reason)
+		return
+	}
+	window.alerted = true
+	logger.Errorf("lost %d events of map '%s' within %s, more than the %d allowed: %s", window.lost, name, lostAlertWindow, t.threshold, reason)
+	t.watcher.SendEntry(v1.MapEntry{
+		Name: LostEventsMapName,
+		Entry: v1.KvPair{
+			Key: map[string]string{
+				"map":    name,
+				"reason": reason,
+				"lost":   strconv.FormatUint(window.lost, 10),
+				"window": lostAlertWindow.String(),
+			},
+		},
+	})
+}
+
+// total returns the number of events lost from all maps.
+func (t *lostTracker) total() uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var total uint64
+	for _, lost := range t.totals {
+		total += lost
+	}
+	return total
+}
+
+// startLostMap polls the map a program counts the events it lost in on an interval.
+func (l *loader) startLostMap(
+	ctx context.Context,
+	liveMap *ebpf.Map,
+	name string,
+	tracker *lostTracker,
+	opts *LoadOptions,
+) error {
+	logger := contextutils.LoggerFrom(ctx)
+	perCPU := liveMap.Type() == ebpf.PerCPUArray
+	if !perCPU && liveMap.Type() != ebpf.Array {
+		return fmt.Errorf("the lost events map of '%s' must be an array or per-CPU array", name)
+	}
+
+	// events lost before the map was watched, e.g. by a previous run when pinned, are not counted
+	last, err := readLostCount(liveMap, perCPU)
+	if err != nil {
+		return fmt.Errorf("could not read the lost events of map '%s': %w", name, err)
+	}
+	interval := opts.liveSettings().PollInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if current := opts.liveSettings().PollInterval; current != interval {
+				ticker.Reset(current)
+				interval = current
+			}
+			total, err := readLostCount(liveMap, perCPU)
+			if err != nil {
+				logger.Infof("error while reading the lost events of map '%s': %s", name, err)
+				continue
+			}
+			if total > last {
+				tracker.add(ctx, time.Now(), name, RingBufLostReason, total-last)
+			}
+			last = total
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func readLostCount(liveMap *ebpf.Map, perCPU bool) (uint64, error) {
+	if !perCPU {
+		var count uint64
+		err := liveMap.Lookup(uint32(0), &count)
+		return count, err
+	}
+	var counts []uint64
+	if err := liveMap.Lookup(uint32(0), &counts); err != nil {
+		return 0, err
+	}
+	var total uint64
+	for _, count := range counts {
+		total += count
+	}
+	return total, nil
+}
@@ -0,0 +1,90 @@
+package loader
+
+import (
+	"context"
+	"time"
+
+	"github.com/cilium/ebpf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type recordingInstrument struct {
+	values map[string]int64
+}
+
+func (i *recordingInstrument) Set(ctx context.Context, val int64, labels map[string]string) {
+	i.values[labels["map"]+"/"+labels["reason"]] = val
+}
+
+func (i *recordingInstrument) Delete(ctx context.Context, labels map[string]string) {}
+
+var _ = Describe("lostTracker", func() {
+	var (
+		ctx        context.Context
+		instrument *recordingInstrument
+		watcher    *recordingWatcher
+		tracker    *lostTracker
+		start      time.Time
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		instrument = &recordingInstrument{values: map[string]int64{}}
+		watcher = &recordingWatcher{}
+		tracker = &lostTracker{
+			instrument: instrument,
+			watcher:    watcher,
+			threshold:  100,
+			totals:     map[lostKey]uint64{},
+			windows:    map[lostKey]*lostWindow{},
+		}
+		start = time.Now()
+	})
+
+	It("counts the events lost per map and reason", func() {
+		tracker.add(ctx, start, "events", PerfLostReason, 10)
+		tracker.add(ctx, start, "events", PerfLostReason, 5)
+		tracker.add(ctx, start, "exec_events", RingBufLostReason, 3)
+		Expect(instrument.values).To(Equal(map[string]int64{
+			"events/" + PerfLostReason:         15,
+			"exec_events/" + RingBufLostReason: 3,
+		}))
+		Expect(watcher.entries).To(BeEmpty())
+	})
+
+	It("alerts once per window when more events than the threshold are lost", func() {
+		tracker.add(ctx, start, "events", PerfLostReason, 60)
+		tracker.add(ctx, start.Add(10*time.Second), "events", PerfLostReason, 60)
+		tracker.add(ctx, start.Add(20*time.Second), "events", PerfLostReason, 60)
+		Expect(watcher.entries).To(HaveLen(1))
+		Expect(watcher.entries[0].Name).To(Equal(LostEventsMapName))
+		Expect(watcher.entries[0].Entry.Key).To(HaveKeyWithValue("lost", "120"))
+		Expect(watcher.entries[0].Entry.Key).To(HaveKeyWithValue("map", "events"))
+
+		// the next window starts over
+		tracker.add(ctx, start.Add(lostAlertWindow), "events", PerfLostReason, 60)
+		Expect(watcher.entries).To(HaveLen(1))
+		tracker.add(ctx, start.Add(lostAlertWindow+time.Second), "events", PerfLostReason, 60)
+		Expect(watcher.entries).To(HaveLen(2))
+	})
+
+	It("sums the lost events counted per CPU", func() {
+		m, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.PerCPUArray, KeySize: 4, ValueSize: 8, MaxEntries: 1})
+		if err != nil {
+			Skip("creating maps needs privileges: " + err.Error())
+		}
+		defer m.Close()
+		cpus := PossibleCPUs()
+		counts := make([]uint64, cpus)
+		for i := range counts {
+			counts[i] = 2
+		}
+		Expect(m.Put(uint32(0), counts)).To(Succeed())
+
+		total, err := readLostCount(m, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(total).To(Equal(uint64(2 * cpus)))
+	})
+})
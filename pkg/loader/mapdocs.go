@@ -0,0 +1,228 @@
+package loader
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+var (
+	// structStart matches the line opening a struct or union, e.g. `struct event {`
+	structStart = regexp.MustCompile(`^\s*(?:typedef\s+)?(?:struct|union)\s*(\w*)\s*\{`)
+	// structEnd matches the line closing it, with the name of a typedef, e.g. `} event_t;`
+	structEnd = regexp.MustCompile(`^\s*\}\s*(\w*)`)
+	// fieldName matches the name of the field a declaration ends with, e.g. `char comm[16];`
+	fieldName = regexp.MustCompile(`(\w+)\s*(?:\[[^\]]*\]\s*)*(?::\s*\d+\s*)?;`)
+)
+
+// DescribeMapTypes sets the Key and Value of the descriptions of the maps of the config to the
+// layouts of their types in the BTF of the spec, describing the maps the config does not. The
+// fields are documented with their comments in the source, a gzipped tarball as in the source
+// layer of packages, if not empty. The spec must not be parsed, as parsing drops the BTF of
+// event maps.
+func DescribeMapTypes(cfg *v1.EbpfConfig, collSpec *ebpf.CollectionSpec, source []byte) error {
+	comments, err := sourceComments(source)
+	if err != nil {
+		return fmt.Errorf("could not read the comments of the source: %w", err)
+	}
+	names := make([]string, 0, len(collSpec.Maps))
+	for name, m := range collSpec.Maps {
+		// the maps of the globals, e.g. tcpconnect.rodata, are not declared by the program
+		if m.BTF == nil || strings.Contains(name, ".") {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// the descriptions may be shared with the configs of other packages
+	cfg.Maps = append([]v1.MapDescription(nil), cfg.Maps...)
+	for _, name := range names {
+		m := collSpec.Maps[name]
+		desc := mapDescription(cfg, name)
+		desc.Key = describeType(m.BTF.Key, comments)
+		desc.Value = describeType(m.BTF.Value, comments)
+	}
+	return nil
+}
+
+// mapDescription returns the description of the map in the config, added if missing.
+func mapDescription(cfg *v1.EbpfConfig, name string) *v1.MapDescription {
+	for i := range cfg.Maps {
+		if cfg.Maps[i].Name == name {
+			return &cfg.Maps[i]
+		}
+	}
+	cfg.Maps = append(cfg.Maps, v1.MapDescription{Name: name})
+	return &cfg.Maps[len(cfg.Maps)-1]
+}
+
+// describeType returns the layout of the type, with the comments of the fields of its struct,
+// nil for the keys of maps which have none, e.g. ringbufs.
+func describeType(typ btf.Type, comments map[string]map[string]string) *v1.TypeDescription {
+	if _, ok := typ.(*btf.Void); ok {
+		return nil
+	}
+	schema := newTypeSchema(typ)
+	if schema == nil {
+		return nil
+	}
+	fieldComments := comments[typeCommentKey(typ)]
+	if fieldComments == nil {
+		fieldComments = comments[typeCommentKey(skipQualifiers(typ))]
+	}
+	desc := &v1.TypeDescription{Name: schema.Name, Size: schema.Size}
+	for _, field := range schema.Fields {
+		desc.Fields = append(desc.Fields, v1.FieldDescription{
+			Name:         field.Name,
+			Type:         field.Type,
+			Offset:       field.Offset,
+			BitfieldSize: field.BitfieldSize,
+			Comment:      fieldComments[field.Name],
+		})
+	}
+	return desc
+}
+
+// typeCommentKey returns the name the comments of the type are found by, as the struct is
+// named in the source, e.g. event for struct event and event_t for a typedef.
+func typeCommentKey(typ btf.Type) string {
+	if named, ok := typ.(btf.NamedType); ok {
+		return named.TypeName()
+	}
+	return ""
+}
+
+// sourceComments returns the comments of the fields of the structs and unions of the C files
+// of the gzipped tarball, by the name of the struct and field. The comment of a field is the
+// one following it on its line, or otherwise the ones just before it.
+func sourceComments(source []byte) (map[string]map[string]string, error) {
+	comments := map[string]map[string]string{}
+	if len(source) == 0 {
+		return comments, nil
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(source))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return comments, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := parseStructComments(tr, comments); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseStructComments adds the comments of the fields of the structs of a C file.
+func parseStructComments(r io.Reader, comments map[string]map[string]string) error {
+	var (
+		depth   int
+		name    string
+		fields  map[string]string
+		pending []string
+		inBlock bool
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inBlock {
+			end := strings.Index(line, "*/")
+			if end < 0 {
+				pending = append(pending, trimComment(line))
+				continue
+			}
+			pending = append(pending, trimComment(line[:end]))
+			line = line[end+2:]
+			inBlock = false
+		}
+		code, comment, opensBlock := splitComment(line)
+		if opensBlock {
+			inBlock = true
+		}
+		if strings.TrimSpace(code) == "" {
+			if comment != "" {
+				pending = append(pending, comment)
+			}
+			continue
+		}
+		if depth == 0 {
+			if match := structStart.FindStringSubmatch(code); match != nil {
+				depth, name, fields = 1, match[1], map[string]string{}
+				// declared on a single line
+				if strings.Count(code, "{") == strings.Count(code, "}") {
+					depth = 0
+				}
+			}
+			pending = nil
+			continue
+		}
+		if match := structEnd.FindStringSubmatch(code); match != nil && depth == 1 {
+			// by the name of the struct and of its typedef
+			for _, name := range []string{name, match[1]} {
+				if name != "" && len(fields) > 0 {
+					comments[name] = fields
+				}
+			}
+			depth, pending = 0, nil
+			continue
+		}
+		depth += strings.Count(code, "{") - strings.Count(code, "}")
+		if depth == 1 {
+			if match := fieldName.FindAllStringSubmatch(code, -1); match != nil {
+				if comment == "" {
+					comment = strings.Join(pending, " ")
+				}
+				if comment = strings.TrimSpace(comment); comment != "" {
+					fields[match[len(match)-1][1]] = comment
+				}
+			}
+		}
+		pending = nil
+	}
+	return scanner.Err()
+}
+
+// splitComment splits the code of a line from its comment, and returns whether the line opens
+// a block comment it does not close.
+func splitComment(line string) (code, comment string, opensBlock bool) {
+	lineComment := strings.Index(line, "//")
+	blockComment := strings.Index(line, "/*")
+	switch {
+	case lineComment >= 0 && (blockComment < 0 || lineComment < blockComment):
+		return line[:lineComment], trimComment(line[lineComment+2:]), false
+	case blockComment >= 0:
+		rest := line[blockComment+2:]
+		end := strings.Index(rest, "*/")
+		if end < 0 {
+			return line[:blockComment], trimComment(rest), true
+		}
+		return line[:blockComment] + rest[end+2:], trimComment(rest[:end]), false
+	default:
+		return line, "", false
+	}
+}
+
+// trimComment trims the spaces and leading stars of a comment line.
+func trimComment(comment string) string {
+	return strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(comment), "*"))
+}
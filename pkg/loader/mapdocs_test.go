@@ -0,0 +1,94 @@
+package loader
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const mapDocsSource = `
+#include "vmlinux.h"
+
+struct event {
+	u32 pid; // id of the process
+	/* name of the command,
+	 * truncated to 16 bytes */
+	char comm[16];
+	u8 flags : 4;
+};
+
+typedef struct {
+	u32 saddr; /* source address */
+	u32 daddr;
+} flow_t;
+
+struct event *unused(void) { return 0; }
+`
+
+var _ = Describe("map documentation", func() {
+	u32 := &btf.Int{Name: "u32", Size: 4}
+	u8 := &btf.Int{Name: "u8", Size: 1}
+	char := &btf.Int{Name: "char", Size: 1}
+	event := &btf.Struct{Name: "event", Size: 24, Members: []btf.Member{
+		{Name: "pid", Type: u32},
+		{Name: "comm", Type: &btf.Array{Type: char, Nelems: 16}, OffsetBits: 32},
+		{Name: "flags", Type: u8, OffsetBits: 160, BitfieldSize: 4},
+	}}
+	flow := &btf.Typedef{Name: "flow_t", Type: &btf.Struct{Size: 8, Members: []btf.Member{
+		{Name: "saddr", Type: u32},
+		{Name: "daddr", Type: u32, OffsetBits: 32},
+	}}}
+	collSpec := &ebpf.CollectionSpec{Maps: map[string]*ebpf.MapSpec{
+		"events":       {Name: "events", Type: ebpf.RingBuf, BTF: &btf.Map{Key: &btf.Void{}, Value: event}},
+		"flows":        {Name: "flows", Type: ebpf.Hash, BTF: &btf.Map{Key: flow, Value: u32}},
+		"probe.rodata": {Name: "probe.rodata", Type: ebpf.Array, BTF: &btf.Map{Key: u32, Value: u32}},
+	}}
+
+	sourceLayer := func() []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		Expect(tw.WriteHeader(&tar.Header{Name: "probe.c", Mode: 0644, Size: int64(len(mapDocsSource))})).To(Succeed())
+		_, err := tw.Write([]byte(mapDocsSource))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tw.Close()).To(Succeed())
+		Expect(gz.Close()).To(Succeed())
+		return buf.Bytes()
+	}
+
+	It("documents the keys and values of the maps with the comments of the source", func() {
+		cfg := v1.EbpfConfig{Maps: []v1.MapDescription{{Name: "flows", Description: "Bytes by flow"}}}
+		Expect(DescribeMapTypes(&cfg, collSpec, sourceLayer())).To(Succeed())
+		Expect(cfg.Maps).To(HaveLen(2))
+
+		flows := cfg.Maps[0]
+		Expect(flows.Description).To(Equal("Bytes by flow"))
+		Expect(flows.Key).To(Equal(&v1.TypeDescription{Name: "flow_t", Size: 8, Fields: []v1.FieldDescription{
+			{Name: "saddr", Type: "u32", Comment: "source address"},
+			{Name: "daddr", Type: "u32", Offset: 4},
+		}}))
+		Expect(flows.Value).To(Equal(&v1.TypeDescription{Name: "u32", Size: 4}))
+
+		events := cfg.Maps[1]
+		Expect(events.Name).To(Equal("events"))
+		Expect(events.Key).To(BeNil())
+		Expect(events.Value.Fields).To(Equal([]v1.FieldDescription{
+			{Name: "pid", Type: "u32", Comment: "id of the process"},
+			{Name: "comm", Type: "char[16]", Offset: 4, Comment: "name of the command, truncated to 16 bytes"},
+			{Name: "flags", Type: "u8", Offset: 20, BitfieldSize: 4},
+		}))
+	})
+
+	It("documents the layouts without comments if the source is not packaged", func() {
+		var cfg v1.EbpfConfig
+		Expect(DescribeMapTypes(&cfg, collSpec, nil)).To(Succeed())
+		Expect(cfg.Maps[0].Value.Fields[0]).To(Equal(v1.FieldDescription{Name: "pid", Type: "u32"}))
+	})
+})
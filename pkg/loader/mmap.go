@@ -0,0 +1,37 @@
+package loader
+
+import (
+	"github.com/cilium/ebpf"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+)
+
+// entryReader reads all the entries of a map on each poll.
+type entryReader interface {
+	read(liveMap *ebpf.Map) ([]rawEntry, error)
+}
+
+// mmapReader reads the values of a BPF_F_MMAPABLE array map from a memory mapping of the
+// map, so polling the map does not cost a syscall per entry, but a copy of the values:
+// about 50 times faster for an array of 16384 entries, see BenchmarkScrapeArray.
+type mmapReader struct {
+	data []byte
+	// values are 8 bytes aligned in the mapping
+	stride     int
+	valueSize  int
+	maxEntries int
+}
+
+func (r *mmapReader) read(*ebpf.Map) ([]rawEntry, error) {
+	entries := make([]rawEntry, r.maxEntries)
+	// keys and values in one allocation
+	buf := make([]byte, r.maxEntries*(4+r.valueSize))
+	for i := range entries {
+		key := buf[:4:4]
+		decoder.Endianess.PutUint32(key, uint32(i))
+		value := buf[4 : 4+r.valueSize : 4+r.valueSize]
+		copy(value, r.data[i*r.stride:])
+		buf = buf[4+r.valueSize:]
+		entries[i] = rawEntry{key: key, value: value}
+	}
+	return entries, nil
+}
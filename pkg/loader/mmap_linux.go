@@ -0,0 +1,37 @@
+package loader
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// newMmapReader maps the values of the map in memory, it returns nil without an error if
+// the map can't be mapped, e.g. it is not an array or was not created with BPF_F_MMAPABLE.
+// Kernels before 5.5 don't allow mapping arrays, the map is then read with lookups.
+func newMmapReader(spec *ebpf.MapSpec, liveMap *ebpf.Map) (*mmapReader, error) {
+	if spec.Type != ebpf.Array || spec.Flags&bpfFMmapable == 0 || isConsumeMap(spec) {
+		return nil, nil
+	}
+	valueSize := int(liveMap.ValueSize())
+	maxEntries := int(liveMap.MaxEntries())
+	stride := (valueSize + 7) &^ 7
+	pageSize := os.Getpagesize()
+	size := (stride*maxEntries + pageSize - 1) / pageSize * pageSize
+	data, err := unix.Mmap(liveMap.FD(), 0, size, unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("could not mmap array: %w", err)
+	}
+	return &mmapReader{
+		data:       data,
+		stride:     stride,
+		valueSize:  valueSize,
+		maxEntries: maxEntries,
+	}, nil
+}
+
+func (r *mmapReader) close() error {
+	return unix.Munmap(r.data)
+}
@@ -0,0 +1,51 @@
+package loader
+
+import (
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("mmap reader", func() {
+	spec := &ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 12, MaxEntries: 700, Flags: unix.BPF_F_MMAPABLE}
+
+	It("reads the same entries as lookups", func() {
+		m, err := ebpf.NewMap(spec)
+		if err != nil {
+			Skip("creating mmapable maps needs privileges and linux 5.5: " + err.Error())
+		}
+		defer m.Close()
+		for _, i := range []uint32{0, 1, 341, 699} {
+			Expect(m.Put(i, []byte{byte(i), 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, byte(i >> 8)})).To(Succeed())
+		}
+
+		reader, err := newMmapReader(spec, m)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reader).NotTo(BeNil())
+		defer reader.close()
+		entries, err := reader.read(m)
+		Expect(err).NotTo(HaveOccurred())
+		expected, err := (&mapReader{}).read(m)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(Equal(expected))
+
+		// the mapping follows the updates of the map
+		Expect(m.Put(uint32(341), make([]byte, 12))).To(Succeed())
+		entries, err = reader.read(m)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries[341].value).To(Equal(make([]byte, 12)))
+	})
+
+	It("is only used for mmapable arrays", func() {
+		for _, s := range []*ebpf.MapSpec{
+			{Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 1},
+			{Type: ebpf.Hash, KeySize: 4, ValueSize: 8, MaxEntries: 1, Flags: unix.BPF_F_MMAPABLE},
+		} {
+			reader, err := newMmapReader(s, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reader).To(BeNil())
+		}
+	})
+})
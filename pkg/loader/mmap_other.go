@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package loader
+
+import "github.com/cilium/ebpf"
+
+// newMmapReader returns nil, as maps can only be mapped in memory on Linux.
+func newMmapReader(spec *ebpf.MapSpec, liveMap *ebpf.Map) (*mmapReader, error) {
+	return nil, nil
+}
+
+func (r *mmapReader) close() error {
+	return nil
+}
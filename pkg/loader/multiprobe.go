@@ -0,0 +1,281 @@
+package loader
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+// DefaultMaxProbeFunctions is the most kernel functions a program is attached to once its
+// globs are expanded, unless configured otherwise
+const DefaultMaxProbeFunctions = 128
+
+// attachTraceKprobeMulti is the BPF_TRACE_KPROBE_MULTI attach type, which programs attached
+// with a kprobe.multi link are loaded with.
+const attachTraceKprobeMulti = ebpf.AttachType(42)
+
+// probeableFunctions lists the functions the kernel can probe, in the tracefs mounted by
+// default or in the one of debugfs.
+var probeableFunctions = []string{
+	"/sys/kernel/tracing/available_filter_functions",
+	"/sys/kernel/debug/tracing/available_filter_functions",
+}
+
+// ProbeTargets are the kernel functions a kprobe or kretprobe program is attached to, when
+// it is a multi probe or its section is a glob, e.g. `kprobe/tcp_*`.
+type ProbeTargets struct {
+	// Functions, or globs of functions, of the program
+	Patterns []string
+	// Functions the patterns resolved to, sorted
+	Functions []string
+	// Functions only matched by globs, skipped if they can't be attached with a kprobe, e.g.
+	// as they were inlined, rather than failing the attach
+	globbed map[string]bool
+	// why the functions were skipped when last attached
+	skipped map[string]string
+	// whether the program was last attached with a kprobe.multi link
+	multiLink bool
+}
+
+// IsProbeGlob returns whether a kernel function of a probe is a glob of functions.
+func IsProbeGlob(symbol string) bool {
+	return strings.ContainsAny(symbol, "*?[")
+}
+
+// ResolveMultiProbes returns the kernel functions of the multi probes, and of the kprobe and
+// kretprobe programs whose section is a glob, by the name of their program. The globs are
+// expanded against the functions the kernel can probe, less the ones it refuses to probe, and
+// the functions which are not globs are kept as they are. A glob matching no function fails,
+// as does a program resolved to more than max functions, DefaultMaxProbeFunctions if 0.
+func ResolveMultiProbes(spec *ebpf.CollectionSpec, probes []v1.MultiProbe, functions []string, blacklist map[string]bool, max int) (map[string]*ProbeTargets, error) {
+	if max <= 0 {
+		max = DefaultMaxProbeFunctions
+	}
+	patterns := map[string][]string{}
+	for _, probe := range probes {
+		prog, ok := spec.Programs[probe.Program]
+		if !ok {
+			return nil, fmt.Errorf("multi probe %s: the program has no %s function", probe.Program, probe.Program)
+		}
+		if !isKprobeSection(prog) {
+			return nil, fmt.Errorf("multi probe %s: only kprobe and kretprobe programs can be attached to many functions", probe.Program)
+		}
+		patterns[probe.Program] = probe.Symbols
+	}
+	for name, prog := range spec.Programs {
+		if _, ok := patterns[name]; !ok && isKprobeSection(prog) && IsProbeGlob(prog.AttachTo) {
+			patterns[name] = []string{prog.AttachTo}
+		}
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	resolved := map[string]*ProbeTargets{}
+	for name, symbols := range patterns {
+		targets, err := expandSymbols(symbols, functions, blacklist)
+		if err != nil {
+			return nil, fmt.Errorf("multi probe %s: %w", name, err)
+		}
+		if len(targets.Functions) > max {
+			return nil, fmt.Errorf("multi probe %s: %s matches %d functions, more than the %d a program can be attached to: narrow it down or raise the maximum", name, strings.Join(symbols, ", "), len(targets.Functions), max)
+		}
+		resolved[name] = targets
+	}
+	return resolved, nil
+}
+
+// needsProbeableFunctions returns whether resolving the multi probes of the spec requires the
+// functions the kernel can probe, to expand their globs.
+func needsProbeableFunctions(spec *ebpf.CollectionSpec, probes []v1.MultiProbe) bool {
+	for _, probe := range probes {
+		for _, symbol := range probe.Symbols {
+			if IsProbeGlob(symbol) {
+				return true
+			}
+		}
+	}
+	for _, prog := range spec.Programs {
+		if isKprobeSection(prog) && IsProbeGlob(prog.AttachTo) {
+			return true
+		}
+	}
+	return false
+}
+
+func isKprobeSection(prog *ebpf.ProgramSpec) bool {
+	return prog.Type == ebpf.Kprobe && (strings.HasPrefix(prog.SectionName, "kprobe/") || strings.HasPrefix(prog.SectionName, "kretprobe/"))
+}
+
+// expandSymbols returns the targets of the symbols, each glob being replaced by the
+// functions it matches.
+func expandSymbols(symbols []string, functions []string, blacklist map[string]bool) (*ProbeTargets, error) {
+	targets := &ProbeTargets{Patterns: symbols, globbed: map[string]bool{}}
+	listed := map[string]bool{}
+	for _, symbol := range symbols {
+		if !IsProbeGlob(symbol) && !listed[symbol] {
+			listed[symbol] = true
+			targets.Functions = append(targets.Functions, symbol)
+		}
+	}
+	for _, symbol := range symbols {
+		if !IsProbeGlob(symbol) {
+			continue
+		}
+		if functions == nil {
+			return nil, fmt.Errorf("the functions the kernel can probe are not readable to expand %s, mount tracefs", symbol)
+		}
+		matched := false
+		for _, function := range functions {
+			if ok, _ := path.Match(symbol, function); !ok || blacklist[function] {
+				continue
+			}
+			matched = true
+			if !listed[function] && !targets.globbed[function] {
+				targets.globbed[function] = true
+				targets.Functions = append(targets.Functions, function)
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("%s matches none of the functions the kernel can probe", symbol)
+		}
+	}
+	sort.Strings(targets.Functions)
+	return targets, nil
+}
+
+// readProbeableFunctions returns the functions the kernel can probe, nil if tracefs is not
+// mounted or readable.
+func readProbeableFunctions() []string {
+	for _, file := range probeableFunctions {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		functions, err := parseProbeableFunctions(f)
+		f.Close()
+		if err == nil {
+			return functions
+		}
+	}
+	return nil
+}
+
+// parseProbeableFunctions parses the "function [module]" lines of available_filter_functions,
+// the functions of modules being listed once.
+func parseProbeableFunctions(r io.Reader) ([]string, error) {
+	var functions []string
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || seen[fields[0]] {
+			continue
+		}
+		seen[fields[0]] = true
+		functions = append(functions, fields[0])
+	}
+	return functions, scanner.Err()
+}
+
+// useKprobeMulti sets the attach type of the multi probe programs so they are attached with a
+// kprobe.multi link, if the kernel supports it. The kernel won't attach programs of this
+// attach type with a perf event, so it must be set before they are loaded.
+func useKprobeMulti(spec *ebpf.CollectionSpec, multiProbes map[string]*ProbeTargets) bool {
+	if len(multiProbes) == 0 || !haveKprobeMulti() {
+		return false
+	}
+	for name := range multiProbes {
+		spec.Programs[name].AttachType = attachTraceKprobeMulti
+	}
+	return true
+}
+
+// attachMultiProbe attaches a kprobe or kretprobe program to all the functions of its
+// targets, with a kprobe.multi link if it was loaded for one, or with a kprobe per function.
+// The functions only matched by globs which can't be attached with a kprobe are skipped,
+// unless none can be.
+func attachMultiProbe(prog *ebpf.ProgramSpec, loaded *ebpf.Program, targets *ProbeTargets) (io.Closer, error) {
+	ret := strings.HasPrefix(prog.SectionName, "kretprobe/")
+	targets.skipped = nil
+	targets.multiLink = prog.AttachType == attachTraceKprobeMulti
+	if targets.multiLink {
+		l, err := kprobeMulti(loaded, targets.Functions, ret)
+		if err != nil {
+			return nil, fmt.Errorf("error attaching '%v' to %d functions: %w", prog.Name, len(targets.Functions), err)
+		}
+		return l, nil
+	}
+	var links multiLink
+	for _, symbol := range targets.Functions {
+		attach := link.Kprobe
+		if ret {
+			attach = link.Kretprobe
+		}
+		l, err := attach(symbol, loaded)
+		if err != nil && targets.globbed[symbol] {
+			if targets.skipped == nil {
+				targets.skipped = map[string]string{}
+			}
+			targets.skipped[symbol] = err.Error()
+			continue
+		}
+		if err != nil {
+			links.Close()
+			return nil, fmt.Errorf("error attaching '%v' to %s: %w", prog.Name, symbol, err)
+		}
+		links = append(links, l)
+	}
+	if len(links) == 0 {
+		return nil, fmt.Errorf("error attaching '%v': none of the %d functions matching %s could be attached", prog.Name, len(targets.Functions), strings.Join(targets.Patterns, ", "))
+	}
+	return links, nil
+}
+
+// probeReport returns what the kprobe and kretprobe programs of the spec were attached to,
+// sorted by program.
+func probeReport(spec *ebpf.CollectionSpec, multiProbes map[string]*ProbeTargets) []v1.AttachedProbe {
+	var probes []v1.AttachedProbe
+	for name, prog := range spec.Programs {
+		if !isKprobeSection(prog) {
+			continue
+		}
+		probe := v1.AttachedProbe{Program: prog.Name, Type: "kprobe", Link: "kprobe"}
+		if strings.HasPrefix(prog.SectionName, "kretprobe/") {
+			probe.Type = "kretprobe"
+		}
+		targets, ok := multiProbes[name]
+		if !ok {
+			probe.Patterns, probe.Functions = []string{prog.AttachTo}, []string{prog.AttachTo}
+			probes = append(probes, probe)
+			continue
+		}
+		probe.Patterns = targets.Patterns
+		if targets.multiLink {
+			probe.Link = "kprobe.multi"
+		}
+		for _, function := range targets.Functions {
+			if _, ok := targets.skipped[function]; !ok {
+				probe.Functions = append(probe.Functions, function)
+			}
+		}
+		if len(targets.skipped) > 0 {
+			probe.Skipped = map[string]string{}
+			for function, reason := range targets.skipped {
+				probe.Skipped[function] = reason
+			}
+		}
+		probes = append(probes, probe)
+	}
+	sort.Slice(probes, func(i, j int) bool {
+		return probes[i].Program < probes[j].Program
+	})
+	return probes
+}
@@ -0,0 +1,138 @@
+package loader
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"golang.org/x/sys/unix"
+)
+
+// bpfFKprobeMultiReturn is the BPF_F_KPROBE_MULTI_RETURN flag of kprobe.multi links,
+// attaching them to the returns of the functions.
+const bpfFKprobeMultiReturn = 1
+
+// kprobeMultiAttr is the kprobe_multi member of the link_create attributes of the bpf
+// syscall.
+type kprobeMultiAttr struct {
+	progFd     uint32
+	targetFd   uint32
+	attachType uint32
+	flags      uint32
+	multiFlags uint32
+	count      uint32
+	syms       uint64
+	addrs      uint64
+	cookies    uint64
+}
+
+// kprobeMultiLink is a kprobe.multi link, detached once closed.
+type kprobeMultiLink struct {
+	fd int
+}
+
+func (l *kprobeMultiLink) Close() error {
+	return unix.Close(l.fd)
+}
+
+func (l *kprobeMultiLink) FD() int {
+	return l.fd
+}
+
+// linkInfo is the start of the bpf_link_info the kernel fills in, which it truncates to the
+// size given.
+type linkInfo struct {
+	linkType uint32
+	id       uint32
+	progID   uint32
+}
+
+// objInfoAttr is the info member of the attributes of the bpf syscall.
+type objInfoAttr struct {
+	fd      uint32
+	infoLen uint32
+	info    uint64
+}
+
+func (l *kprobeMultiLink) id() (uint32, error) {
+	var info linkInfo
+	attr := objInfoAttr{
+		fd:      uint32(l.fd),
+		infoLen: uint32(unsafe.Sizeof(info)),
+		info:    uint64(uintptr(unsafe.Pointer(&info))),
+	}
+	_, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_OBJ_GET_INFO_BY_FD, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	runtime.KeepAlive(&info)
+	if errno != 0 {
+		return 0, errno
+	}
+	return info.id, nil
+}
+
+// kprobeMulti attaches the program to the entries, or returns, of the functions with a
+// single kprobe.multi link.
+func kprobeMulti(prog *ebpf.Program, symbols []string, ret bool) (io.Closer, error) {
+	if len(symbols) == 0 {
+		return nil, errors.New("no functions to attach to")
+	}
+	names := make([][]byte, len(symbols))
+	syms := make([]uintptr, len(symbols))
+	for i, symbol := range symbols {
+		names[i] = append([]byte(symbol), 0)
+		syms[i] = uintptr(unsafe.Pointer(&names[i][0]))
+	}
+	attr := kprobeMultiAttr{
+		progFd:     uint32(prog.FD()),
+		attachType: uint32(attachTraceKprobeMulti),
+		count:      uint32(len(symbols)),
+		syms:       uint64(uintptr(unsafe.Pointer(&syms[0]))),
+	}
+	if ret {
+		attr.multiFlags = bpfFKprobeMultiReturn
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_LINK_CREATE, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	// the names are read by the kernel during the syscall
+	runtime.KeepAlive(names)
+	runtime.KeepAlive(syms)
+	if errno != 0 {
+		return nil, errno
+	}
+	return &kprobeMultiLink{fd: int(fd)}, nil
+}
+
+var (
+	kprobeMultiOnce      sync.Once
+	kprobeMultiSupported bool
+)
+
+// haveKprobeMulti returns whether the kernel supports kprobe.multi links, by attaching a
+// program which does nothing to vprintk, as the kernels which don't support them, or were
+// built without CONFIG_FPROBE, reject the attach type.
+func haveKprobeMulti() bool {
+	kprobeMultiOnce.Do(func() {
+		prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+			Type:       ebpf.Kprobe,
+			AttachType: attachTraceKprobeMulti,
+			Instructions: asm.Instructions{
+				asm.Mov.Imm(asm.R0, 0),
+				asm.Return(),
+			},
+			License: "GPL",
+		})
+		if err != nil {
+			return
+		}
+		defer prog.Close()
+		l, err := kprobeMulti(prog, []string{"vprintk"}, false)
+		if err != nil {
+			return
+		}
+		l.Close()
+		kprobeMultiSupported = true
+	})
+	return kprobeMultiSupported
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package loader
+
+import (
+	"errors"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+func kprobeMulti(prog *ebpf.Program, symbols []string, ret bool) (io.Closer, error) {
+	return nil, errors.New("kprobe.multi links are only supported on linux")
+}
+
+func haveKprobeMulti() bool {
+	return false
+}
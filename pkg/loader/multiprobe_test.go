@@ -0,0 +1,99 @@
+package loader
+
+import (
+	"strings"
+
+	"github.com/cilium/ebpf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("multi probes", func() {
+	spec := &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{
+		"connect":     {Name: "connect", Type: ebpf.Kprobe, SectionName: "kprobe/tcp_v4_connect", AttachTo: "tcp_v4_connect"},
+		"connect_ret": {Name: "connect_ret", Type: ebpf.Kprobe, SectionName: "kretprobe/tcp_v4_connect", AttachTo: "tcp_v4_connect"},
+		"exec":        {Name: "exec", Type: ebpf.TracePoint, SectionName: "tracepoint/sched/sched_process_exec"},
+	}}
+
+	functions, err := parseProbeableFunctions(strings.NewReader(
+		"tcp_v4_connect\ntcp_v6_connect\ntcp_sendmsg\nnmi_handle\ntcp_v4_rcv [nf_conntrack]\ntcp_v4_rcv [other]\n"))
+	Expect(err).NotTo(HaveOccurred())
+
+	It("parses the functions the kernel can probe, once per name", func() {
+		Expect(functions).To(Equal([]string{"tcp_v4_connect", "tcp_v6_connect", "tcp_sendmsg", "nmi_handle", "tcp_v4_rcv"}))
+	})
+
+	It("expands the globs of the symbols of each program", func() {
+		resolved, err := ResolveMultiProbes(spec, []v1.MultiProbe{
+			{Program: "connect", Symbols: []string{"tcp_v?_connect", "tcp_sendmsg"}},
+			{Program: "connect_ret", Symbols: []string{"tcp_sendmsg", "inet_*"}},
+		}, append(functions, "inet_listen"), nil, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resolved).To(HaveLen(2))
+		Expect(resolved["connect"].Functions).To(Equal([]string{"tcp_sendmsg", "tcp_v4_connect", "tcp_v6_connect"}))
+		Expect(resolved["connect"].globbed).To(Equal(map[string]bool{"tcp_v4_connect": true, "tcp_v6_connect": true}))
+		Expect(resolved["connect_ret"].Functions).To(Equal([]string{"inet_listen", "tcp_sendmsg"}))
+	})
+
+	It("expands the globs of the sections of kprobes", func() {
+		globSpec := &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{
+			"tcp":     {Name: "tcp", Type: ebpf.Kprobe, SectionName: "kprobe/tcp_v*", AttachTo: "tcp_v*"},
+			"connect": spec.Programs["connect"],
+		}}
+		Expect(needsProbeableFunctions(globSpec, nil)).To(BeTrue())
+		Expect(needsProbeableFunctions(spec, nil)).To(BeFalse())
+		resolved, err := ResolveMultiProbes(globSpec, nil, functions, nil, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resolved).To(HaveLen(1))
+		Expect(resolved["tcp"].Patterns).To(Equal([]string{"tcp_v*"}))
+		Expect(resolved["tcp"].Functions).To(Equal([]string{"tcp_v4_connect", "tcp_v4_rcv", "tcp_v6_connect"}))
+
+		_, err = ResolveMultiProbes(globSpec, nil, functions, nil, 2)
+		Expect(err).To(MatchError("multi probe tcp: tcp_v* matches 3 functions, more than the 2 a program can be attached to: narrow it down or raise the maximum"))
+	})
+
+	It("leaves out the functions of the blacklist matched by globs", func() {
+		resolved, err := ResolveMultiProbes(spec, []v1.MultiProbe{{Program: "connect", Symbols: []string{"*"}}}, functions, map[string]bool{"nmi_handle": true}, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resolved["connect"].Functions).NotTo(ContainElement("nmi_handle"))
+		Expect(resolved["connect"].Functions).To(HaveLen(4))
+
+		err = checkProbes(spec, map[string]*ProbeTargets{"connect": {Functions: []string{"tcp_v4_connect", "nmi_handle"}}}, true, map[string]bool{"nmi_handle": true})
+		Expect(err).To(MatchError(ContainSubstring("does not allow probing nmi_handle")))
+	})
+
+	It("checks every function of the multi probes", func() {
+		err := checkProbes(spec, map[string]*ProbeTargets{"connect": {Functions: []string{"tcp_v4_connect", "__schedule"}}}, false, nil)
+		Expect(err).To(MatchError(ContainSubstring("refusing to attach 'connect' to __schedule")))
+	})
+
+	It("reports what the kprobes were attached to", func() {
+		targets := &ProbeTargets{
+			Patterns:  []string{"tcp_v?_connect"},
+			Functions: []string{"tcp_v4_connect", "tcp_v6_connect"},
+			skipped:   map[string]string{"tcp_v6_connect": "no such file or directory"},
+		}
+		Expect(probeReport(spec, map[string]*ProbeTargets{"connect": targets})).To(Equal([]v1.AttachedProbe{
+			{
+				Program: "connect", Type: "kprobe", Link: "kprobe", Patterns: []string{"tcp_v?_connect"}, Functions: []string{"tcp_v4_connect"},
+				Skipped: map[string]string{"tcp_v6_connect": "no such file or directory"},
+			},
+			{Program: "connect_ret", Type: "kretprobe", Link: "kprobe", Patterns: []string{"tcp_v4_connect"}, Functions: []string{"tcp_v4_connect"}},
+		}))
+		targets.skipped, targets.multiLink = nil, true
+		Expect(probeReport(spec, map[string]*ProbeTargets{"connect": targets})[0].Link).To(Equal("kprobe.multi"))
+	})
+
+	It("rejects unknown programs, programs other than kprobes and globs matching nothing", func() {
+		_, err := ResolveMultiProbes(spec, []v1.MultiProbe{{Program: "missing", Symbols: []string{"tcp_sendmsg"}}}, functions, nil, 0)
+		Expect(err).To(MatchError(ContainSubstring("the program has no missing function")))
+		_, err = ResolveMultiProbes(spec, []v1.MultiProbe{{Program: "exec", Symbols: []string{"tcp_sendmsg"}}}, functions, nil, 0)
+		Expect(err).To(MatchError(ContainSubstring("only kprobe and kretprobe programs")))
+		_, err = ResolveMultiProbes(spec, []v1.MultiProbe{{Program: "connect", Symbols: []string{"udp_*"}}}, functions, nil, 0)
+		Expect(err).To(MatchError("multi probe connect: udp_* matches none of the functions the kernel can probe"))
+		_, err = ResolveMultiProbes(spec, []v1.MultiProbe{{Program: "connect", Symbols: []string{"udp_*"}}}, nil, nil, 0)
+		Expect(err).To(MatchError(ContainSubstring("mount tracefs")))
+	})
+})
@@ -0,0 +1,166 @@
+package loader
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// ConflictPolicy is what to do when other programs, e.g. from Cilium, are already attached
+// to the hook of an XDP or TC program.
+type ConflictPolicy string
+
+const (
+	// ConflictFail refuses to attach the program, the default
+	ConflictFail ConflictPolicy = "fail"
+	// ConflictChain attaches TC programs after the others, in the order of their priority.
+	// XDP programs can only be chained through a dispatcher, like the one of libxdp, so
+	// they are not attached.
+	ConflictChain ConflictPolicy = "chain"
+	// ConflictReplace detaches the other programs
+	ConflictReplace ConflictPolicy = "replace"
+)
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case ConflictFail, ConflictChain, ConflictReplace:
+		return p, nil
+	case "":
+		return ConflictFail, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy '%s', must be one of fail, chain or replace", s)
+}
+
+// HookProgram is a program attached to a network hook.
+type HookProgram struct {
+	ID   ebpf.ProgramID
+	Name string
+}
+
+func (p HookProgram) String() string {
+	if p.Name == "" {
+		return fmt.Sprintf("id %d", p.ID)
+	}
+	return fmt.Sprintf("%s (id %d)", p.Name, p.ID)
+}
+
+// ConflictError is returned when programs are already attached to the hook of a program.
+type ConflictError struct {
+	Program   string
+	Hook      string
+	Interface string
+	Existing  []HookProgram
+	// Why the policy could not resolve the conflict, empty for ConflictFail
+	Reason string
+}
+
+func (e *ConflictError) Error() string {
+	existing := make([]string, len(e.Existing))
+	for i, p := range e.Existing {
+		existing[i] = p.String()
+	}
+	msg := fmt.Sprintf("could not attach '%s' to the %s hook of %s, which already has %s attached, e.g. by another tool",
+		e.Program, e.Hook, e.Interface, strings.Join(existing, ", "))
+	if e.Reason != "" {
+		return msg + ": " + e.Reason
+	}
+	return msg + ": set the conflict policy to chain or replace to attach it anyway"
+}
+
+// netTarget is the interface XDP and TC programs are attached to.
+type netTarget struct {
+	iface string
+	// index of the interface, looked up by name when attaching if 0
+	ifindex int
+	policy  ConflictPolicy
+	// namespace of the interface, of this process if nil
+	ns *netns
+}
+
+// key identifies the interface across resolutions of the targets, an interface recreated
+// with the same name, or in a recreated namespace, being another target.
+func (t netTarget) key() string {
+	ns := ""
+	if t.ns != nil {
+		ns = t.ns.id
+	}
+	return fmt.Sprintf("%s/%s#%d", ns, t.iface, t.ifindex)
+}
+
+// name is the interface, with its namespace unless the one of this process.
+func (t netTarget) name() string {
+	if t.ns == nil {
+		return t.iface
+	}
+	return fmt.Sprintf("%s in the network namespace %s", t.iface, t.ns.name)
+}
+
+// IsInterfaceGlob returns whether a network interface is a glob of interfaces, e.g. veth*.
+func IsInterfaceGlob(iface string) bool {
+	return strings.ContainsAny(iface, "*?[")
+}
+
+func matchInterface(pattern, iface string) bool {
+	ok, _ := path.Match(pattern, iface)
+	return ok
+}
+
+// netLinks are the links of a network program to its targets, by their key, so it can be
+// attached to the targets appearing while it runs and detached from the ones gone.
+type netLinks struct {
+	prog   *ebpf.ProgramSpec
+	loaded *ebpf.Program
+	attach func(*ebpf.ProgramSpec, *ebpf.Program, netTarget) (io.Closer, error)
+	links  map[string]targetLink
+}
+
+// targetLink is the link of a program to a target, by the name of the target.
+type targetLink struct {
+	io.Closer
+	name string
+}
+
+// sync attaches the program to the targets it is not attached to, and detaches it from the
+// ones which are not targets anymore, returning the names of both and the errors of the
+// targets it could not be attached to, tried again on the next sync.
+func (l *netLinks) sync(targets []netTarget) (attached, detached []string, errs []error) {
+	keep := map[string]bool{}
+	for _, t := range targets {
+		key := t.key()
+		keep[key] = true
+		if _, ok := l.links[key]; ok {
+			continue
+		}
+		link, err := l.attach(l.prog, l.loaded, t)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		l.links[key] = targetLink{Closer: link, name: t.name()}
+		attached = append(attached, t.name())
+	}
+	for key, link := range l.links {
+		if keep[key] {
+			continue
+		}
+		// the interface is most likely gone with its programs, which the link tolerates
+		link.Close()
+		delete(l.links, key)
+		detached = append(detached, link.name)
+	}
+	return attached, detached, errs
+}
+
+func (l *netLinks) Close() error {
+	var firstErr error
+	for key, link := range l.links {
+		if err := link.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(l.links, key)
+	}
+	return firstErr
+}
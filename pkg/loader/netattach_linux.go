@@ -0,0 +1,373 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"syscall"
+
+	"github.com/cilium/ebpf"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"golang.org/x/sys/unix"
+)
+
+// link returns the index of the interface in its namespace.
+func (t netTarget) link(conn *rtnetlink, prog *ebpf.ProgramSpec) (int, error) {
+	if t.iface == "" {
+		return 0, fmt.Errorf("the %s program '%s' requires a network interface to be attached to", prog.Type, prog.Name)
+	}
+	if t.ifindex != 0 {
+		return t.ifindex, nil
+	}
+	ifindex, _, err := linkByName(conn, t.iface)
+	if err != nil {
+		return 0, fmt.Errorf("could not attach '%s' to %s: %w", prog.Name, t.name(), err)
+	}
+	return ifindex, nil
+}
+
+const (
+	xdpAttachedNone = 0
+	xdpAttachedDrv  = 1
+	xdpAttachedSkb  = 2
+	xdpAttachedHw   = 3
+)
+
+// xdpModeFlags are the flags attaching in the mode of a program attached with the mode.
+var xdpModeFlags = map[uint8]uint32{
+	xdpAttachedDrv: unix.XDP_FLAGS_DRV_MODE,
+	xdpAttachedSkb: unix.XDP_FLAGS_SKB_MODE,
+	xdpAttachedHw:  unix.XDP_FLAGS_HW_MODE,
+}
+
+// xdpLink detaches an XDP program from its interface on Close, unless replaced since.
+type xdpLink struct {
+	ns      *netns
+	ifindex int
+	id      ebpf.ProgramID
+}
+
+func attachXDP(prog *ebpf.ProgramSpec, loaded *ebpf.Program, target netTarget) (io.Closer, error) {
+	conn, release, err := target.ns.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	ifindex, err := target.link(conn, prog)
+	if err != nil {
+		return nil, err
+	}
+	ifname := target.name()
+
+	mode, existing, err := queryXDP(conn, ifindex)
+	if err != nil {
+		return nil, fmt.Errorf("could not query the XDP program of %s: %w", ifname, err)
+	}
+	// fail if a program was attached since the query
+	flags := uint32(unix.XDP_FLAGS_UPDATE_IF_NOEXIST)
+	if existing != 0 {
+		conflict := &ConflictError{Program: prog.Name, Hook: "xdp", Interface: ifname, Existing: []HookProgram{hookProgram(existing)}}
+		switch target.policy {
+		case ConflictReplace:
+			// in the mode of the replaced program, which can't be attached in another one
+			flags = xdpModeFlags[mode]
+		case ConflictChain:
+			conflict.Reason = "XDP programs can only be chained through a dispatcher such as libxdp's, attach the program with TC or set the conflict policy to replace"
+			return nil, conflict
+		default:
+			return nil, conflict
+		}
+	}
+	if err := setXDP(conn, ifindex, loaded.FD(), flags); err != nil {
+		if errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EEXIST) {
+			// attached since the query
+			_, existing, _ = queryXDP(conn, ifindex)
+			return nil, &ConflictError{Program: prog.Name, Hook: "xdp", Interface: ifname, Existing: []HookProgram{hookProgram(existing)}}
+		}
+		return nil, fmt.Errorf("error attaching XDP program '%v' to %s: %w", prog.Name, ifname, err)
+	}
+
+	l := &xdpLink{ns: target.ns, ifindex: ifindex}
+	if info, err := loaded.Info(); err == nil {
+		l.id, _ = info.ID()
+	}
+	return l, nil
+}
+
+func (l *xdpLink) Close() error {
+	conn, release, err := l.ns.dial()
+	if err != nil {
+		return err
+	}
+	defer release()
+	mode, id, err := queryXDP(conn, l.ifindex)
+	if err != nil {
+		return err
+	}
+	if id == 0 || (l.id != 0 && id != l.id) {
+		// detached or replaced by someone else
+		return nil
+	}
+	return setXDP(conn, l.ifindex, -1, xdpModeFlags[mode])
+}
+
+// queryXDP returns the mode and id of the XDP program attached to the interface, 0 if none is.
+func queryXDP(conn *rtnetlink, ifindex int) (uint8, ebpf.ProgramID, error) {
+	replies, err := conn.request(unix.RTM_GETLINK, 0, ifInfoMsg(ifindex))
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, m := range replies {
+		if m.Header.Type != unix.RTM_NEWLINK || len(m.Data) < unix.SizeofIfInfomsg {
+			continue
+		}
+		attrs, err := parseAttrs(m.Data[unix.SizeofIfInfomsg:])
+		if err != nil {
+			return 0, 0, err
+		}
+		xdp, ok := attrs[unix.IFLA_XDP]
+		if !ok {
+			return xdpAttachedNone, 0, nil
+		}
+		xdpAttrs, err := parseAttrs(xdp)
+		if err != nil {
+			return 0, 0, err
+		}
+		var mode uint8
+		if attached := xdpAttrs[unix.IFLA_XDP_ATTACHED]; len(attached) > 0 {
+			mode = attached[0]
+		}
+		var id ebpf.ProgramID
+		if progID := xdpAttrs[unix.IFLA_XDP_PROG_ID]; len(progID) >= 4 {
+			id = ebpf.ProgramID(decoder.Endianess.Uint32(progID))
+		}
+		return mode, id, nil
+	}
+	return 0, 0, errors.New("no such link")
+}
+
+func setXDP(conn *rtnetlink, ifindex, fd int, flags uint32) error {
+	body := append(ifInfoMsg(ifindex), nlNested(unix.IFLA_XDP,
+		nlAttr(unix.IFLA_XDP_FD, nlUint32(uint32(int32(fd)))),
+		nlAttr(unix.IFLA_XDP_FLAGS, nlUint32(flags)),
+	)...)
+	_, err := conn.request(unix.RTM_SETLINK, 0, body)
+	return err
+}
+
+func ifInfoMsg(ifindex int) []byte {
+	msg := make([]byte, unix.SizeofIfInfomsg)
+	msg[0] = unix.AF_UNSPEC
+	decoder.Endianess.PutUint32(msg[4:8], uint32(ifindex))
+	return msg
+}
+
+// hookProgram names the program with the id, if it can still be looked up.
+func hookProgram(id ebpf.ProgramID) HookProgram {
+	p := HookProgram{ID: id}
+	if prog, err := ebpf.NewProgramFromID(id); err == nil {
+		if info, err := prog.Info(); err == nil {
+			p.Name = info.Name
+		}
+		prog.Close()
+	}
+	return p
+}
+
+const (
+	tcHClsact     = 0xfffffff1
+	tcHMinIngress = 0xfff2
+	tcHMinEgress  = 0xfff3
+
+	tcaKind    = 1
+	tcaOptions = 2
+
+	tcaBpfFD    = 6
+	tcaBpfName  = 7
+	tcaBpfFlags = 8
+	tcaBpfID    = 11
+
+	tcaBpfFlagActDirect = 1
+
+	sizeofTcMsg = 20
+	// priority of the filter of a program, unless chained after others
+	tcDefaultPriority = 1
+	tcHandle          = 1
+)
+
+// ETH_P_ALL in network byte order, as the protocol of filters
+var tcProtocolAll = decoder.Endianess.Uint16([]byte{0, unix.ETH_P_ALL})
+
+// tcFilter is a bpf filter of the clsact qdisc of an interface.
+type tcFilter struct {
+	priority uint16
+	protocol uint16
+	handle   uint32
+	program  HookProgram
+}
+
+// info is the tcm_info of the filter, its priority and protocol
+func (f tcFilter) info() uint32 {
+	return uint32(f.priority)<<16 | uint32(f.protocol)
+}
+
+// tcLink deletes the filter of a TC program on Close. The clsact qdisc is kept, other
+// programs possibly using it.
+type tcLink struct {
+	ns      *netns
+	ifindex int
+	parent  uint32
+	filter  tcFilter
+}
+
+// tcDirection returns the hook of a TC program from its section, e.g. classifier/egress,
+// ingress by default.
+func tcDirection(prog *ebpf.ProgramSpec) (string, uint32) {
+	if strings.HasSuffix(prog.SectionName, "/egress") {
+		return "tc egress", tcHClsact&0xffff0000 | tcHMinEgress
+	}
+	return "tc ingress", tcHClsact&0xffff0000 | tcHMinIngress
+}
+
+func attachTC(prog *ebpf.ProgramSpec, loaded *ebpf.Program, target netTarget) (io.Closer, error) {
+	conn, release, err := target.ns.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	ifindex, err := target.link(conn, prog)
+	if err != nil {
+		return nil, err
+	}
+	ifname := target.name()
+
+	if err := ensureClsact(conn, ifindex); err != nil {
+		return nil, fmt.Errorf("could not add the clsact qdisc to %s: %w", ifname, err)
+	}
+	hook, parent := tcDirection(prog)
+	existing, err := listTCFilters(conn, ifindex, parent)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the TC filters of %s: %w", ifname, err)
+	}
+
+	priority := uint16(tcDefaultPriority)
+	if len(existing) > 0 {
+		conflict := &ConflictError{Program: prog.Name, Hook: hook, Interface: ifname}
+		for _, f := range existing {
+			conflict.Existing = append(conflict.Existing, f.program)
+		}
+		switch target.policy {
+		case ConflictChain:
+			// after the others, run when they return TC_ACT_UNSPEC
+			for _, f := range existing {
+				if f.priority >= priority {
+					priority = f.priority + 1
+				}
+			}
+			if priority == 0 {
+				conflict.Reason = "no priority is left after the existing filters"
+				return nil, conflict
+			}
+		case ConflictReplace:
+			for _, f := range existing {
+				if err := deleteTCFilter(conn, ifindex, parent, f); err != nil {
+					return nil, fmt.Errorf("could not replace %s on %s: %w", f.program, ifname, err)
+				}
+			}
+		default:
+			return nil, conflict
+		}
+	}
+
+	filter := tcFilter{priority: priority, protocol: tcProtocolAll, handle: tcHandle}
+	body := append(tcMsg(ifindex, filter.handle, parent, filter.info()),
+		nlAttr(tcaKind, nlString("bpf"))...)
+	body = append(body, nlNested(tcaOptions,
+		nlAttr(tcaBpfFD, nlUint32(uint32(loaded.FD()))),
+		nlAttr(tcaBpfName, nlString(prog.Name)),
+		nlAttr(tcaBpfFlags, nlUint32(tcaBpfFlagActDirect)),
+	)...)
+	if _, err := conn.request(unix.RTM_NEWTFILTER, unix.NLM_F_CREATE|unix.NLM_F_EXCL, body); err != nil {
+		return nil, fmt.Errorf("error attaching TC program '%v' to %s: %w", prog.Name, ifname, err)
+	}
+	return &tcLink{ns: target.ns, ifindex: ifindex, parent: parent, filter: filter}, nil
+}
+
+func (l *tcLink) Close() error {
+	conn, release, err := l.ns.dial()
+	if err != nil {
+		return err
+	}
+	defer release()
+	err = deleteTCFilter(conn, l.ifindex, l.parent, l.filter)
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.EINVAL) {
+		// already deleted, e.g. with the interface
+		return nil
+	}
+	return err
+}
+
+func ensureClsact(conn *rtnetlink, ifindex int) error {
+	body := append(tcMsg(ifindex, tcHClsact&0xffff0000, tcHClsact, 0), nlAttr(tcaKind, nlString("clsact"))...)
+	_, err := conn.request(unix.RTM_NEWQDISC, unix.NLM_F_CREATE|unix.NLM_F_EXCL, body)
+	if errors.Is(err, syscall.EEXIST) {
+		return nil
+	}
+	return err
+}
+
+// listTCFilters returns the bpf filters of a hook of the clsact qdisc.
+func listTCFilters(conn *rtnetlink, ifindex int, parent uint32) ([]tcFilter, error) {
+	replies, err := conn.request(unix.RTM_GETTFILTER, unix.NLM_F_DUMP, tcMsg(ifindex, 0, parent, 0))
+	if err != nil {
+		return nil, err
+	}
+	var filters []tcFilter
+	for _, m := range replies {
+		if m.Header.Type != unix.RTM_NEWTFILTER || len(m.Data) < sizeofTcMsg {
+			continue
+		}
+		handle := decoder.Endianess.Uint32(m.Data[8:12])
+		info := decoder.Endianess.Uint32(m.Data[16:20])
+		attrs, err := parseAttrs(m.Data[sizeofTcMsg:])
+		if err != nil {
+			return nil, err
+		}
+		// the dump also has an entry per priority, without a handle
+		if attrString(attrs[tcaKind]) != "bpf" || handle == 0 {
+			continue
+		}
+		options, err := parseAttrs(attrs[tcaOptions])
+		if err != nil {
+			return nil, err
+		}
+		var id ebpf.ProgramID
+		if b := options[tcaBpfID]; len(b) >= 4 {
+			id = ebpf.ProgramID(decoder.Endianess.Uint32(b))
+		}
+		filters = append(filters, tcFilter{
+			priority: uint16(info >> 16),
+			protocol: uint16(info),
+			handle:   handle,
+			program:  HookProgram{ID: id, Name: attrString(options[tcaBpfName])},
+		})
+	}
+	return filters, nil
+}
+
+func deleteTCFilter(conn *rtnetlink, ifindex int, parent uint32, f tcFilter) error {
+	body := append(tcMsg(ifindex, f.handle, parent, f.info()), nlAttr(tcaKind, nlString("bpf"))...)
+	_, err := conn.request(unix.RTM_DELTFILTER, 0, body)
+	return err
+}
+
+func tcMsg(ifindex int, handle, parent, info uint32) []byte {
+	msg := make([]byte, sizeofTcMsg)
+	msg[0] = unix.AF_UNSPEC
+	decoder.Endianess.PutUint32(msg[4:8], uint32(ifindex))
+	decoder.Endianess.PutUint32(msg[8:12], handle)
+	decoder.Endianess.PutUint32(msg[12:16], parent)
+	decoder.Endianess.PutUint32(msg[16:20], info)
+	return msg
+}
@@ -0,0 +1,243 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("Network attachments", func() {
+	const (
+		vethName     = "bee-test0"
+		peerName     = "bee-test1"
+		vethInfoPeer = 1
+	)
+	var (
+		conn   *rtnetlink
+		target netTarget
+	)
+
+	BeforeEach(func() {
+		var err error
+		conn, err = dialRtnetlink()
+		Expect(err).NotTo(HaveOccurred())
+		// a veth pair, so the programs of other tools are left alone
+		body := append(ifInfoMsg(0), nlAttr(unix.IFLA_IFNAME, nlString(vethName))...)
+		body = append(body, nlNested(unix.IFLA_LINKINFO,
+			nlAttr(unix.IFLA_INFO_KIND, nlString("veth")),
+			nlNested(unix.IFLA_INFO_DATA,
+				nlAttr(vethInfoPeer, append(ifInfoMsg(0), nlAttr(unix.IFLA_IFNAME, nlString(peerName))...)),
+			),
+		)...)
+		if _, err := conn.request(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL, body); err != nil {
+			conn.close()
+			Skip("creating network interfaces needs privileges: " + err.Error())
+		}
+		target = netTarget{iface: vethName, policy: ConflictFail}
+	})
+
+	AfterEach(func() {
+		if iface, err := net.InterfaceByName(vethName); err == nil {
+			conn.request(unix.RTM_DELLINK, 0, ifInfoMsg(iface.Index))
+		}
+		conn.close()
+	})
+
+	// program returns the given value, e.g. XDP_PASS
+	program := func(name string, typ ebpf.ProgramType, ret int64) (*ebpf.ProgramSpec, *ebpf.Program) {
+		spec := &ebpf.ProgramSpec{
+			Name: name,
+			Type: typ,
+			Instructions: asm.Instructions{
+				asm.LoadImm(asm.R0, ret, asm.DWord),
+				asm.Return(),
+			},
+			License: "MIT",
+		}
+		prog, err := ebpf.NewProgram(spec)
+		Expect(err).NotTo(HaveOccurred())
+		return spec, prog
+	}
+
+	programID := func(prog *ebpf.Program) ebpf.ProgramID {
+		info, err := prog.Info()
+		Expect(err).NotTo(HaveOccurred())
+		id, ok := info.ID()
+		Expect(ok).To(BeTrue())
+		return id
+	}
+
+	attachedXDP := func() ebpf.ProgramID {
+		iface, err := net.InterfaceByName(vethName)
+		Expect(err).NotTo(HaveOccurred())
+		_, id, err := queryXDP(conn, iface.Index)
+		Expect(err).NotTo(HaveOccurred())
+		return id
+	}
+
+	tcFilters := func() []tcFilter {
+		iface, err := net.InterfaceByName(vethName)
+		Expect(err).NotTo(HaveOccurred())
+		_, parent := tcDirection(&ebpf.ProgramSpec{SectionName: "classifier"})
+		filters, err := listTCFilters(conn, iface.Index, parent)
+		Expect(err).NotTo(HaveOccurred())
+		return filters
+	}
+
+	It("requires an interface", func() {
+		spec, prog := program("xdp_pass", ebpf.XDP, 2)
+		defer prog.Close()
+		_, err := attachXDP(spec, prog, netTarget{})
+		Expect(err).To(MatchError(ContainSubstring("requires a network interface")))
+	})
+
+	It("detaches from the interfaces gone when reattaching", func() {
+		spec, prog := program("xdp_pass", ebpf.XDP, 2)
+		defer prog.Close()
+		opts := &LoadOptions{Interface: "bee-test[01]", ReattachInterval: time.Second}
+		attached := &Attached{resolver: newNetResolver(opts)}
+		targets, _, err := attached.resolver.resolve(true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(targets).To(HaveLen(2))
+		l, err := attachNet(attachXDP, spec, prog, targets)
+		Expect(err).NotTo(HaveOccurred())
+		attached.links = []io.Closer{l}
+		Expect(attachedXDP()).To(Equal(programID(prog)))
+
+		iface, err := net.InterfaceByName(vethName)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.request(unix.RTM_DELLINK, 0, ifInfoMsg(iface.Index))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(attached.resync(context.Background())).To(BeTrue())
+		Expect(attached.targets).To(BeEmpty())
+		Expect(l.(*netLinks).links).To(BeEmpty())
+
+		attached.Collection = &ebpf.Collection{}
+		attached.Close()
+		Expect(attached.resync(context.Background())).To(BeFalse())
+	})
+
+	Context("XDP", func() {
+		var (
+			firstSpec, secondSpec *ebpf.ProgramSpec
+			first, second         *ebpf.Program
+			firstLink             io.Closer
+		)
+
+		BeforeEach(func() {
+			firstSpec, first = program("xdp_first", ebpf.XDP, 2)
+			secondSpec, second = program("xdp_second", ebpf.XDP, 2)
+			var err error
+			firstLink, err = attachXDP(firstSpec, first, target)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			first.Close()
+			second.Close()
+		})
+
+		It("detaches the program on close", func() {
+			Expect(attachedXDP()).To(Equal(programID(first)))
+			Expect(firstLink.Close()).To(Succeed())
+			Expect(attachedXDP()).To(BeZero())
+		})
+
+		It("fails when another program is attached", func() {
+			_, err := attachXDP(secondSpec, second, target)
+			var conflict *ConflictError
+			Expect(errors.As(err, &conflict)).To(BeTrue())
+			Expect(conflict.Hook).To(Equal("xdp"))
+			Expect(conflict.Existing).To(Equal([]HookProgram{{ID: programID(first), Name: "xdp_first"}}))
+			Expect(err.Error()).To(ContainSubstring("xdp_first (id"))
+			Expect(attachedXDP()).To(Equal(programID(first)))
+		})
+
+		It("can't chain programs", func() {
+			target.policy = ConflictChain
+			_, err := attachXDP(secondSpec, second, target)
+			Expect(err).To(MatchError(ContainSubstring("dispatcher")))
+		})
+
+		It("replaces the other program", func() {
+			target.policy = ConflictReplace
+			secondLink, err := attachXDP(secondSpec, second, target)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(attachedXDP()).To(Equal(programID(second)))
+
+			// the replaced program is gone already
+			Expect(firstLink.Close()).To(Succeed())
+			Expect(attachedXDP()).To(Equal(programID(second)))
+			Expect(secondLink.Close()).To(Succeed())
+			Expect(attachedXDP()).To(BeZero())
+		})
+	})
+
+	Context("TC", func() {
+		var (
+			firstSpec, secondSpec *ebpf.ProgramSpec
+			first, second         *ebpf.Program
+		)
+
+		BeforeEach(func() {
+			// TC_ACT_UNSPEC, running the next filter
+			firstSpec, first = program("tc_first", ebpf.SchedCLS, -1)
+			secondSpec, second = program("tc_second", ebpf.SchedCLS, -1)
+			firstSpec.SectionName, secondSpec.SectionName = "classifier", "classifier"
+			_, err := attachTC(firstSpec, first, target)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			first.Close()
+			second.Close()
+		})
+
+		It("fails when another program is attached", func() {
+			_, err := attachTC(secondSpec, second, target)
+			var conflict *ConflictError
+			Expect(errors.As(err, &conflict)).To(BeTrue())
+			Expect(conflict.Hook).To(Equal("tc ingress"))
+			Expect(conflict.Existing).To(Equal([]HookProgram{{ID: programID(first), Name: "tc_first"}}))
+			Expect(tcFilters()).To(HaveLen(1))
+		})
+
+		It("chains the program after the others", func() {
+			target.policy = ConflictChain
+			l, err := attachTC(secondSpec, second, target)
+			Expect(err).NotTo(HaveOccurred())
+			filters := tcFilters()
+			Expect(filters).To(HaveLen(2))
+			Expect(filters[1].priority).To(Equal(uint16(2)))
+			Expect(filters[1].program.Name).To(Equal("tc_second"))
+
+			Expect(l.Close()).To(Succeed())
+			Expect(tcFilters()).To(HaveLen(1))
+		})
+
+		It("replaces the other programs", func() {
+			target.policy = ConflictReplace
+			_, err := attachTC(secondSpec, second, target)
+			Expect(err).NotTo(HaveOccurred())
+			filters := tcFilters()
+			Expect(filters).To(HaveLen(1))
+			Expect(filters[0].program.ID).To(Equal(programID(second)))
+		})
+
+		It("attaches egress programs to the egress hook", func() {
+			secondSpec.SectionName = "classifier/egress"
+			l, err := attachTC(secondSpec, second, target)
+			Expect(err).NotTo(HaveOccurred())
+			defer l.Close()
+			Expect(tcFilters()).To(HaveLen(1))
+		})
+	})
+})
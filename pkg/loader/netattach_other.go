@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package loader
+
+import (
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+func attachXDP(prog *ebpf.ProgramSpec, loaded *ebpf.Program, target netTarget) (io.Closer, error) {
+	return nil, errNetUnsupported
+}
+
+func attachTC(prog *ebpf.ProgramSpec, loaded *ebpf.Program, target netTarget) (io.Closer, error) {
+	return nil, errNetUnsupported
+}
@@ -0,0 +1,144 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"golang.org/x/sys/unix"
+)
+
+// rtnetlink sends the requests attaching XDP and TC programs to network interfaces, the
+// netlink helpers of the syscall package only dumping links, addresses and routes.
+type rtnetlink struct {
+	fd  int
+	seq uint32
+}
+
+const nlaTypeMask = ^uint16(unix.NLA_F_NESTED | 0x4000)
+
+func dialRtnetlink() (*rtnetlink, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("could not open netlink socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("could not bind netlink socket: %w", err)
+	}
+	return &rtnetlink{fd: fd}, nil
+}
+
+func (c *rtnetlink) close() error {
+	return unix.Close(c.fd)
+}
+
+// request sends a message and returns the replies, once the kernel acked it or, for
+// dumps, at the end of the dump.
+func (c *rtnetlink) request(typ, flags uint16, body []byte) ([]syscall.NetlinkMessage, error) {
+	c.seq++
+	flags |= unix.NLM_F_REQUEST
+	if flags&unix.NLM_F_DUMP != unix.NLM_F_DUMP {
+		flags |= unix.NLM_F_ACK
+	}
+	msg := make([]byte, unix.NLMSG_HDRLEN, unix.NLMSG_HDRLEN+len(body))
+	decoder.Endianess.PutUint32(msg[0:4], uint32(unix.NLMSG_HDRLEN+len(body)))
+	decoder.Endianess.PutUint16(msg[4:6], typ)
+	decoder.Endianess.PutUint16(msg[6:8], flags)
+	decoder.Endianess.PutUint32(msg[8:12], c.seq)
+	msg = append(msg, body...)
+	if err := unix.Sendto(c.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("could not send netlink request: %w", err)
+	}
+
+	var replies []syscall.NetlinkMessage
+	for {
+		// the replies point into the buffer, which is not reused
+		buf := make([]byte, 8*os.Getpagesize())
+		n, _, err := unix.Recvfrom(c.fd, buf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("could not read netlink reply: %w", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse netlink reply: %w", err)
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != c.seq {
+				continue
+			}
+			switch m.Header.Type {
+			case unix.NLMSG_DONE:
+				return replies, nil
+			case unix.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return nil, errors.New("truncated netlink error")
+				}
+				if errno := -int32(decoder.Endianess.Uint32(m.Data[:4])); errno != 0 {
+					return nil, syscall.Errno(errno)
+				}
+				return replies, nil
+			default:
+				replies = append(replies, m)
+			}
+		}
+	}
+}
+
+// nlAttr encodes a netlink attribute, padded to 4 bytes.
+func nlAttr(typ uint16, data []byte) []byte {
+	length := unix.SizeofRtAttr + len(data)
+	attr := make([]byte, (length+3)&^3)
+	decoder.Endianess.PutUint16(attr[0:2], uint16(length))
+	decoder.Endianess.PutUint16(attr[2:4], typ)
+	copy(attr[unix.SizeofRtAttr:], data)
+	return attr
+}
+
+func nlNested(typ uint16, attrs ...[]byte) []byte {
+	var data []byte
+	for _, a := range attrs {
+		data = append(data, a...)
+	}
+	return nlAttr(typ|unix.NLA_F_NESTED, data)
+}
+
+func nlUint32(v uint32) []byte {
+	b := make([]byte, 4)
+	decoder.Endianess.PutUint32(b, v)
+	return b
+}
+
+func nlString(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+// parseAttrs returns the attributes by type, nested attributes are parsed again from
+// their value.
+func parseAttrs(b []byte) (map[uint16][]byte, error) {
+	attrs := make(map[uint16][]byte)
+	for len(b) >= unix.SizeofRtAttr {
+		length := int(decoder.Endianess.Uint16(b[0:2]))
+		if length < unix.SizeofRtAttr || length > len(b) {
+			return nil, errors.New("invalid netlink attribute")
+		}
+		attrs[decoder.Endianess.Uint16(b[2:4])&nlaTypeMask] = b[unix.SizeofRtAttr:length]
+		aligned := (length + 3) &^ 3
+		if aligned > len(b) {
+			break
+		}
+		b = b[aligned:]
+	}
+	return attrs, nil
+}
+
+func attrString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
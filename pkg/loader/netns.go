@@ -0,0 +1,112 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// minContainerIDLength avoids matching the cgroups of other containers with a short prefix,
+// 12 characters being the short IDs of docker and crictl.
+const minContainerIDLength = 12
+
+var procDir = "/proc"
+
+// netnsPath returns the file of the network namespace of a selector.
+func netnsPath(selector string) (string, error) {
+	kind, value := "", selector
+	if i := strings.Index(selector, ":"); i > 0 && !strings.HasPrefix(selector, "/") {
+		kind, value = selector[:i], selector[i+1:]
+	}
+	switch kind {
+	case "":
+		return selector, nil
+	case "pid":
+		pid, err := strconv.Atoi(value)
+		if err != nil || pid <= 0 {
+			return "", fmt.Errorf("invalid pid in network namespace %s", selector)
+		}
+		return filepath.Join(procDir, strconv.Itoa(pid), "ns", "net"), nil
+	case "container", "pod":
+		pid, err := cgroupPID(kind, value)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(procDir, strconv.Itoa(pid), "ns", "net"), nil
+	}
+	return "", fmt.Errorf("unknown network namespace %s, must be a path, pid:PID, container:ID or pod:UID", selector)
+}
+
+// cgroupPID returns the lowest pid in the cgroup of a container or pod, the runtimes naming
+// the cgroups after their ID, e.g. cri-containerd-ID.scope in kubepods-podUID.slice with
+// the dashes of the UID replaced by underscores. All the processes of a pod share its
+// network namespace. The cgroups are matched in the paths of every hierarchy, so both
+// cgroup v1 and v2 are supported.
+func cgroupPID(kind, id string) (int, error) {
+	patterns, err := cgroupPatterns(kind, id)
+	if err != nil {
+		return 0, err
+	}
+	procs, err := processCgroups(patterns)
+	if err != nil {
+		return 0, err
+	}
+	if len(procs) == 0 {
+		return 0, fmt.Errorf("no process of %s %s found in %s", kind, id, procDir)
+	}
+	var pids []int
+	for pid := range procs {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids[0], nil
+}
+
+// cgroupPatterns returns the parts of the cgroup paths naming a container or pod.
+func cgroupPatterns(kind, id string) ([]string, error) {
+	switch kind {
+	case "container":
+		if len(id) < minContainerIDLength {
+			return nil, fmt.Errorf("container ID '%s' is too short, at least %d characters are required", id, minContainerIDLength)
+		}
+		return []string{id}, nil
+	case "pod":
+		if id == "" {
+			return nil, errors.New("pod UID is empty")
+		}
+		return []string{"pod" + id, "pod" + strings.ReplaceAll(id, "-", "_")}, nil
+	}
+	return nil, fmt.Errorf("unknown cgroup kind %s, must be container or pod", kind)
+}
+
+// processCgroups returns the cgroup files of the processes in a cgroup path matching one
+// of the patterns, by pid.
+func processCgroups(patterns []string) (map[int]string, error) {
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil, err
+	}
+	procs := map[int]string{}
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		// exited since or not readable
+		cgroups, err := os.ReadFile(filepath.Join(procDir, e.Name(), "cgroup"))
+		if err != nil {
+			continue
+		}
+		for _, p := range patterns {
+			if strings.Contains(string(cgroups), p) {
+				procs[pid] = string(cgroups)
+				break
+			}
+		}
+	}
+	return procs, nil
+}
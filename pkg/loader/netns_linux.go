@@ -0,0 +1,277 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"golang.org/x/sys/unix"
+)
+
+// netns is a network namespace XDP and TC programs are attached in. Its netlink socket is
+// opened once, from a thread which entered the namespace, the programs being detached
+// once sandboxed, when entering namespaces is denied.
+type netns struct {
+	// selector of the namespace, e.g. pid:1234
+	name string
+	// device and inode of the namespace, see netnsID
+	id   string
+	file *os.File
+
+	mu   sync.Mutex
+	conn *rtnetlink
+}
+
+// openNetns opens a network namespace by path, e.g. /var/run/netns/blue, pid:PID,
+// container:ID, or pod:UID of a Kubernetes pod.
+func openNetns(selector string) (*netns, error) {
+	path, err := netnsPath(selector)
+	if err != nil {
+		return nil, err
+	}
+	return openNetnsPath(selector, path)
+}
+
+// openNetnsPath opens the network namespace of the selector at its resolved path.
+func openNetnsPath(selector, path string) (*netns, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open the network namespace %s: %w", selector, err)
+	}
+	ns, err := newNetns(selector, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return ns, nil
+}
+
+func newNetns(name string, f *os.File) (*netns, error) {
+	var st unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+		return nil, fmt.Errorf("could not stat the network namespace %s: %w", name, err)
+	}
+	ns := &netns{name: name, id: fmt.Sprintf("%d:%d", st.Dev, st.Ino), file: f}
+	err := ns.enter(func() error {
+		var err error
+		ns.conn, err = dialRtnetlink()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ns, nil
+}
+
+// enter runs fn on a thread which entered the namespace. The thread is locked to a goroutine
+// of its own and only handed back to the scheduler once back in the namespace of this
+// process, otherwise it exits with the goroutine.
+func (ns *netns) enter(fn func() error) error {
+	errc := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		orig, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+		if err != nil {
+			runtime.UnlockOSThread()
+			errc <- fmt.Errorf("could not open the network namespace of this process: %w", err)
+			return
+		}
+		defer orig.Close()
+		if err := unix.Setns(int(ns.file.Fd()), unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			errc <- fmt.Errorf("could not enter the network namespace %s: %w", ns.name, err)
+			return
+		}
+		fnErr := fn()
+		if err := unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET); err != nil {
+			errc <- fmt.Errorf("could not leave the network namespace %s: %w", ns.name, err)
+			return
+		}
+		runtime.UnlockOSThread()
+		errc <- fnErr
+	}()
+	return <-errc
+}
+
+// dial returns a netlink socket of the namespace, of this process if nil, and releases it
+// with the returned function. Requests don't interleave on the socket of a namespace.
+func (ns *netns) dial() (*rtnetlink, func(), error) {
+	if ns == nil {
+		conn, err := dialRtnetlink()
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, func() { conn.close() }, nil
+	}
+	ns.mu.Lock()
+	return ns.conn, ns.mu.Unlock, nil
+}
+
+func (ns *netns) Close() error {
+	ns.conn.close()
+	return ns.file.Close()
+}
+
+// netnsID returns the device and inode of the network namespace at the path, which are the
+// same whatever the path it is reached by, e.g. the /proc of any process of a pod, and differ
+// once the pod is recreated.
+func netnsID(path string) (string, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%d", st.Dev, st.Ino), nil
+}
+
+// linkByName returns the index of a network interface of the namespace of the socket.
+func linkByName(conn *rtnetlink, name string) (int, map[uint16][]byte, error) {
+	body := append(ifInfoMsg(0), nlAttr(unix.IFLA_IFNAME, nlString(name))...)
+	replies, err := conn.request(unix.RTM_GETLINK, 0, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, m := range replies {
+		if m.Header.Type != unix.RTM_NEWLINK || len(m.Data) < unix.SizeofIfInfomsg {
+			continue
+		}
+		attrs, err := parseAttrs(m.Data[unix.SizeofIfInfomsg:])
+		if err != nil {
+			return 0, nil, err
+		}
+		return int(int32(decoder.Endianess.Uint32(m.Data[4:8]))), attrs, nil
+	}
+	return 0, nil, errors.New("no such link")
+}
+
+// hostVeth returns the interface of this namespace at the other end of the veth of another
+// namespace, e.g. the host side of the eth0 of a pod.
+func hostVeth(ns *netns, iface string) (string, error) {
+	conn, release, err := ns.dial()
+	if err != nil {
+		return "", err
+	}
+	_, attrs, err := linkByName(conn, iface)
+	release()
+	if err != nil {
+		return "", fmt.Errorf("could not find %s in the network namespace %s: %w", iface, ns.name, err)
+	}
+	info, err := parseAttrs(attrs[unix.IFLA_LINKINFO])
+	if err != nil {
+		return "", err
+	}
+	peer := attrs[unix.IFLA_LINK]
+	if attrString(info[unix.IFLA_INFO_KIND]) != "veth" || len(peer) < 4 {
+		return "", fmt.Errorf("%s of the network namespace %s is not a veth", iface, ns.name)
+	}
+
+	host, err := dialRtnetlink()
+	if err != nil {
+		return "", err
+	}
+	defer host.close()
+	replies, err := host.request(unix.RTM_GETLINK, 0, ifInfoMsg(int(decoder.Endianess.Uint32(peer))))
+	if err != nil {
+		return "", fmt.Errorf("could not find the peer of %s of the network namespace %s, it must be in the namespace of bee: %w", iface, ns.name, err)
+	}
+	for _, m := range replies {
+		if m.Header.Type != unix.RTM_NEWLINK || len(m.Data) < unix.SizeofIfInfomsg {
+			continue
+		}
+		attrs, err := parseAttrs(m.Data[unix.SizeofIfInfomsg:])
+		if err != nil {
+			return "", err
+		}
+		return attrString(attrs[unix.IFLA_IFNAME]), nil
+	}
+	return "", fmt.Errorf("could not find the peer of %s of the network namespace %s", iface, ns.name)
+}
+
+// netInterface is a network interface, by its name and index in its namespace.
+type netInterface struct {
+	name  string
+	index int
+}
+
+// interfaceIndex returns the index of an interface of the namespace, of this process if nil.
+func interfaceIndex(ns *netns, iface string) (int, error) {
+	conn, release, err := ns.dial()
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	ifindex, _, err := linkByName(conn, iface)
+	return ifindex, err
+}
+
+// listInterfaces returns the interfaces of the namespace, of this process if nil.
+func listInterfaces(ns *netns) ([]netInterface, error) {
+	conn, release, err := ns.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	replies, err := conn.request(unix.RTM_GETLINK, unix.NLM_F_DUMP, ifInfoMsg(0))
+	if err != nil {
+		return nil, err
+	}
+	var ifaces []netInterface
+	for _, m := range replies {
+		if m.Header.Type != unix.RTM_NEWLINK || len(m.Data) < unix.SizeofIfInfomsg {
+			continue
+		}
+		attrs, err := parseAttrs(m.Data[unix.SizeofIfInfomsg:])
+		if err != nil {
+			return nil, err
+		}
+		ifaces = append(ifaces, netInterface{
+			name:  attrString(attrs[unix.IFLA_IFNAME]),
+			index: int(int32(decoder.Endianess.Uint32(m.Data[4:8]))),
+		})
+	}
+	return ifaces, nil
+}
+
+// watchLinks signals on the returned channel when interfaces of the namespace of this process
+// are created, changed or deleted, e.g. the host end of the veth of a pod being started, until
+// the context is done. Signals are coalesced while not received.
+func watchLinks(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("could not open netlink socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: unix.RTMGRP_LINK}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("could not subscribe to the changes of the interfaces: %w", err)
+	}
+	// closing the socket does not interrupt a blocked read, so the context is checked at this
+	// interval
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 1}); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer close(changes)
+		defer unix.Close(fd)
+		buf := make([]byte, os.Getpagesize())
+		for ctx.Err() == nil {
+			_, _, err := unix.Recvfrom(fd, buf, 0)
+			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+				continue
+			}
+			// the kernel dropped events, which also calls for a resync, when ENOBUFS
+			if err != nil && !errors.Is(err, unix.ENOBUFS) {
+				return
+			}
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes, nil
+}
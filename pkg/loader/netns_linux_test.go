@@ -0,0 +1,220 @@
+package loader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("Network namespaces", func() {
+	Context("selectors", func() {
+		var origProcDir string
+
+		BeforeEach(func() {
+			origProcDir = procDir
+			var err error
+			procDir, err = os.MkdirTemp("", "bee-proc")
+			Expect(err).NotTo(HaveOccurred())
+			cgroups := map[string]string{
+				"1":   "0::/init.scope\n",
+				"420": "0::/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod9a1d3c1e_5b2f_4c8e_8f0a_2d7b6e4c1f3a.slice/cri-containerd-4f6c1a2b9d3e7f80.scope\n",
+				"421": "0::/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod9a1d3c1e_5b2f_4c8e_8f0a_2d7b6e4c1f3a.slice/cri-containerd-4f6c1a2b9d3e7f80.scope\n",
+				"500": "12:pids:/docker/7d2e9b1c4a5f6e80\n",
+			}
+			for pid, cgroup := range cgroups {
+				Expect(os.MkdirAll(filepath.Join(procDir, pid), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(procDir, pid, "cgroup"), []byte(cgroup), 0644)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(procDir)
+			procDir = origProcDir
+		})
+
+		It("resolves paths and pids", func() {
+			Expect(netnsPath("/var/run/netns/blue")).To(Equal("/var/run/netns/blue"))
+			Expect(netnsPath("pid:1")).To(Equal(filepath.Join(procDir, "1", "ns", "net")))
+			_, err := netnsPath("pid:init")
+			Expect(err).To(MatchError(ContainSubstring("invalid pid")))
+			_, err = netnsPath("vm:1")
+			Expect(err).To(MatchError(ContainSubstring("unknown network namespace")))
+		})
+
+		It("resolves containers and pods from their cgroups", func() {
+			Expect(netnsPath("container:4f6c1a2b9d3e")).To(Equal(filepath.Join(procDir, "420", "ns", "net")))
+			Expect(netnsPath("container:7d2e9b1c4a5f6e80")).To(Equal(filepath.Join(procDir, "500", "ns", "net")))
+			Expect(netnsPath("pod:9a1d3c1e-5b2f-4c8e-8f0a-2d7b6e4c1f3a")).To(Equal(filepath.Join(procDir, "420", "ns", "net")))
+
+			_, err := netnsPath("container:4f6c")
+			Expect(err).To(MatchError(ContainSubstring("too short")))
+			_, err = netnsPath("pod:0c4e2b7a-1d9f-4e3a-9b6c-5f8d2a1e7c40")
+			Expect(err).To(MatchError(ContainSubstring("no process of pod")))
+		})
+	})
+
+	Context("resolution", func() {
+		It("resolves globs of interfaces and their indexes when reattaching", func() {
+			r := newNetResolver(&LoadOptions{Interface: "l?", ReattachInterval: time.Second})
+			targets, skipped, err := r.resolve(true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(skipped).To(BeEmpty())
+			Expect(targets).To(HaveLen(1))
+			Expect(targets[0].iface).To(Equal("lo"))
+			Expect(targets[0].ifindex).NotTo(BeZero())
+
+			r = newNetResolver(&LoadOptions{Interface: "lo", ReattachInterval: time.Second})
+			targets, _, err = r.resolve(true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(targets[0].ifindex).NotTo(BeZero())
+		})
+
+		It("skips the missing targets unless strict", func() {
+			r := newNetResolver(&LoadOptions{Interface: "bee-missing*"})
+			_, _, err := r.resolve(true)
+			Expect(err).To(MatchError("no interface matches bee-missing*"))
+			targets, _, err := r.resolve(false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(targets).To(BeEmpty())
+
+			r = newNetResolver(&LoadOptions{Interface: "bee-missing", ReattachInterval: time.Second})
+			_, skipped, err := r.resolve(false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(skipped).To(HaveLen(1))
+
+			r = newNetResolver(&LoadOptions{Interface: "eth0", Netns: []string{"/var/run/netns/bee-missing"}, ReattachInterval: time.Second})
+			_, skipped, err = r.resolve(false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(skipped).To(HaveLen(1))
+			Expect(skipped[0]).To(MatchError(ContainSubstring("could not open the network namespace /var/run/netns/bee-missing")))
+		})
+	})
+
+	Context("attachments", func() {
+		const (
+			vethName     = "bee-test2"
+			peerName     = "bee-test3"
+			vethInfoPeer = 1
+		)
+		var (
+			conn *rtnetlink
+			ns   *netns
+		)
+
+		BeforeEach(func() {
+			ns = nil
+			var err error
+			conn, err = dialRtnetlink()
+			Expect(err).NotTo(HaveOccurred())
+			body := append(ifInfoMsg(0), nlAttr(unix.IFLA_IFNAME, nlString(vethName))...)
+			body = append(body, nlNested(unix.IFLA_LINKINFO,
+				nlAttr(unix.IFLA_INFO_KIND, nlString("veth")),
+				nlNested(unix.IFLA_INFO_DATA,
+					nlAttr(vethInfoPeer, append(ifInfoMsg(0), nlAttr(unix.IFLA_IFNAME, nlString(peerName))...)),
+				),
+			)...)
+			if _, err := conn.request(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL, body); err != nil {
+				conn.close()
+				Skip("creating network interfaces needs privileges: " + err.Error())
+			}
+
+			// a namespace of its own, kept by its file once the thread which created it exits
+			files := make(chan *os.File, 1)
+			errs := make(chan error, 1)
+			go func() {
+				runtime.LockOSThread()
+				if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+					errs <- err
+					return
+				}
+				f, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+				if err != nil {
+					errs <- err
+					return
+				}
+				files <- f
+			}()
+			var f *os.File
+			select {
+			case err := <-errs:
+				Skip("creating network namespaces needs privileges: " + err.Error())
+			case f = <-files:
+			}
+			ns, err = newNetns("test", f)
+			Expect(err).NotTo(HaveOccurred())
+
+			peer, _, err := linkByName(conn, peerName)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = conn.request(unix.RTM_SETLINK, 0, append(ifInfoMsg(peer), nlAttr(unix.IFLA_NET_NS_FD, nlUint32(uint32(f.Fd())))...))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			if ifindex, _, err := linkByName(conn, vethName); err == nil {
+				conn.request(unix.RTM_DELLINK, 0, ifInfoMsg(ifindex))
+			}
+			conn.close()
+			if ns != nil {
+				ns.Close()
+			}
+		})
+
+		It("attaches in the namespace", func() {
+			spec := &ebpf.ProgramSpec{
+				Name: "xdp_pass",
+				Type: ebpf.XDP,
+				Instructions: asm.Instructions{
+					asm.LoadImm(asm.R0, 2, asm.DWord),
+					asm.Return(),
+				},
+				License: "MIT",
+			}
+			prog, err := ebpf.NewProgram(spec)
+			Expect(err).NotTo(HaveOccurred())
+			defer prog.Close()
+
+			_, err = attachXDP(spec, prog, netTarget{iface: peerName})
+			Expect(err).To(MatchError(ContainSubstring("could not attach 'xdp_pass' to " + peerName)))
+
+			l, err := attachXDP(spec, prog, netTarget{iface: peerName, ns: ns})
+			Expect(err).NotTo(HaveOccurred())
+			nsConn, release, err := ns.dial()
+			Expect(err).NotTo(HaveOccurred())
+			ifindex, _, err := linkByName(nsConn, peerName)
+			Expect(err).NotTo(HaveOccurred())
+			_, id, err := queryXDP(nsConn, ifindex)
+			release()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(id).NotTo(BeZero())
+
+			Expect(l.Close()).To(Succeed())
+		})
+
+		It("reuses the namespaces while they are targets", func() {
+			selector := fmt.Sprintf("/proc/self/fd/%d", ns.file.Fd())
+			r := newNetResolver(&LoadOptions{Interface: "bee-test*", Netns: []string{selector}, ReattachInterval: time.Second})
+			r.namespaces[ns.id] = ns
+			targets, _, err := r.resolve(true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(targets).To(HaveLen(1))
+			Expect(targets[0].iface).To(Equal(peerName))
+			Expect(targets[0].ns).To(BeIdenticalTo(ns))
+			r.release()
+			Expect(r.namespaces).To(HaveKey(ns.id))
+		})
+
+		It("finds the host end of a veth", func() {
+			Expect(hostVeth(ns, peerName)).To(Equal(vethName))
+			_, err := hostVeth(ns, "lo")
+			Expect(err).To(MatchError(ContainSubstring("is not a veth")))
+		})
+	})
+})
@@ -0,0 +1,56 @@
+//go:build !linux
+// +build !linux
+
+package loader
+
+import (
+	"context"
+	"errors"
+)
+
+// errNetUnsupported is returned when attaching network programs, or entering network
+// namespaces, outside of Linux.
+var errNetUnsupported = errors.New("network programs and namespaces are only supported on linux")
+
+// netns is a network namespace, which can't be opened outside of Linux.
+type netns struct {
+	name string
+	id   string
+}
+
+func openNetns(selector string) (*netns, error) {
+	return nil, errNetUnsupported
+}
+
+func (ns *netns) Close() error {
+	return nil
+}
+
+func hostVeth(ns *netns, iface string) (string, error) {
+	return "", errNetUnsupported
+}
+
+func openNetnsPath(selector, path string) (*netns, error) {
+	return nil, errNetUnsupported
+}
+
+func netnsID(path string) (string, error) {
+	return "", errNetUnsupported
+}
+
+type netInterface struct {
+	name  string
+	index int
+}
+
+func interfaceIndex(ns *netns, iface string) (int, error) {
+	return 0, errNetUnsupported
+}
+
+func listInterfaces(ns *netns) ([]netInterface, error) {
+	return nil, errNetUnsupported
+}
+
+func watchLinks(ctx context.Context) (<-chan struct{}, error) {
+	return nil, errNetUnsupported
+}
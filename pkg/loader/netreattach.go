@@ -0,0 +1,232 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+// netResolver resolves the interfaces XDP and TC programs are attached to from the network
+// namespaces and interface of the options. It keeps the namespaces it opened while they are
+// targets, so resolving again only opens the ones of the pods and containers started since.
+type netResolver struct {
+	opts *LoadOptions
+	// open namespaces, by their netnsID
+	namespaces map[string]*netns
+	// host ends of the veths of the namespaces, by their netnsID
+	peers map[string]string
+	// namespaces of the targets of the last resolution, the others being closed with release
+	used map[string]bool
+}
+
+func newNetResolver(opts *LoadOptions) *netResolver {
+	return &netResolver{opts: opts, namespaces: map[string]*netns{}, peers: map[string]string{}}
+}
+
+// resolve returns the targets of the options. Namespaces which can't be opened and
+// interfaces which can't be found fail when strict, e.g. on the first resolution of a program
+// not reattached, and are skipped otherwise, e.g. as their pod is not started yet or was
+// deleted, as reported by the returned errors.
+func (r *netResolver) resolve(strict bool) ([]netTarget, []error, error) {
+	opts := r.opts
+	r.used = map[string]bool{}
+	if len(opts.Netns) == 0 {
+		if opts.HostVeth {
+			return nil, nil, errors.New("attaching to the host end of a veth requires a network namespace")
+		}
+		return r.interfaces(nil, opts.Interface, strict)
+	}
+	if opts.HostVeth && IsInterfaceGlob(opts.Interface) {
+		return nil, nil, fmt.Errorf("attaching to the host end of a veth requires the name of its interface, not the glob %s", opts.Interface)
+	}
+	var (
+		targets []netTarget
+		skipped []error
+		peers   = map[string]string{}
+	)
+	for _, selector := range opts.Netns {
+		ts, errs, err := r.resolveNetns(selector, peers, strict)
+		if err != nil {
+			if strict {
+				return nil, nil, err
+			}
+			skipped = append(skipped, err)
+			continue
+		}
+		targets = append(targets, ts...)
+		skipped = append(skipped, errs...)
+	}
+	r.peers = peers
+	return targets, skipped, nil
+}
+
+// resolveNetns returns the targets of a namespace selector, recording the host end of its
+// veth in the peers.
+func (r *netResolver) resolveNetns(selector string, peers map[string]string, strict bool) ([]netTarget, []error, error) {
+	path, err := netnsPath(selector)
+	if err != nil {
+		return nil, nil, err
+	}
+	id, err := netnsID(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open the network namespace %s: %w", selector, err)
+	}
+	if r.opts.HostVeth {
+		peer, ok := r.peers[id]
+		if !ok {
+			ns, err := openNetnsPath(selector, path)
+			if err != nil {
+				return nil, nil, err
+			}
+			peer, err = hostVeth(ns, r.opts.Interface)
+			ns.Close()
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		peers[id] = peer
+		return r.interfaces(nil, peer, strict)
+	}
+	ns, ok := r.namespaces[id]
+	if !ok {
+		ns, err = openNetnsPath(selector, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		r.namespaces[ns.id] = ns
+	}
+	r.used[ns.id] = true
+	return r.interfaces(ns, r.opts.Interface, strict)
+}
+
+// interfaces returns the targets of the interface, or of the interfaces matching its glob, in
+// the namespace. The indexes of the interfaces are resolved when reattaching, to tell them
+// from the ones recreated with the same name.
+func (r *netResolver) interfaces(ns *netns, iface string, strict bool) ([]netTarget, []error, error) {
+	target := netTarget{iface: iface, policy: r.opts.ConflictPolicy, ns: ns}
+	if !IsInterfaceGlob(iface) {
+		if r.opts.ReattachInterval > 0 && iface != "" {
+			ifindex, err := interfaceIndex(ns, iface)
+			if err != nil {
+				err = fmt.Errorf("could not find %s: %w", target.name(), err)
+				if strict {
+					return nil, nil, err
+				}
+				return nil, []error{err}, nil
+			}
+			target.ifindex = ifindex
+		}
+		return []netTarget{target}, nil, nil
+	}
+	ifaces, err := listInterfaces(ns)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not list the interfaces matching %s: %w", target.name(), err)
+	}
+	var targets []netTarget
+	for _, i := range ifaces {
+		if matchInterface(iface, i.name) {
+			t := target
+			t.iface, t.ifindex = i.name, i.index
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 && strict {
+		return nil, nil, fmt.Errorf("no interface matches %s", target.name())
+	}
+	return targets, nil, nil
+}
+
+// release closes the namespaces which are not targets anymore, once detached from.
+func (r *netResolver) release() {
+	for id, ns := range r.namespaces {
+		if !r.used[id] {
+			ns.Close()
+			delete(r.namespaces, id)
+		}
+	}
+}
+
+func (r *netResolver) Close() {
+	for id, ns := range r.namespaces {
+		ns.Close()
+		delete(r.namespaces, id)
+	}
+}
+
+// reattach resolves the network targets again whenever the interfaces of the namespace of
+// this process change, e.g. as the veth of a pod is created, and at the interval otherwise,
+// for the namespaces of the other processes, until the context is done or the programs are
+// closed.
+func (a *Attached) reattach(ctx context.Context, interval time.Duration) {
+	logger := contextutils.LoggerFrom(ctx)
+	changes, err := watchLinks(ctx)
+	if err != nil {
+		logger.Warnf("reattaching every %s only, as the changes of the interfaces can't be watched: %v", interval, err)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case _, ok := <-changes:
+			if !ok {
+				changes = nil
+				continue
+			}
+		}
+		if !a.resync(ctx) {
+			return
+		}
+	}
+}
+
+// resync attaches the network programs to the targets which appeared since the last
+// resolution and detaches them from the ones gone, returning false once the programs are
+// closed. The targets are updated while paused, for the programs to be attached to the
+// current ones when resumed.
+func (a *Attached) resync(ctx context.Context) bool {
+	logger := contextutils.LoggerFrom(ctx)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.closed {
+		return false
+	}
+	targets, skipped, err := a.resolver.resolve(false)
+	if err != nil {
+		logger.Warnf("could not resolve the network targets again: %v", err)
+		a.status.recordError("network targets", err)
+		return true
+	}
+	for _, err := range skipped {
+		logger.Debugf("skipped a network target: %v", err)
+	}
+	for _, l := range a.links {
+		links, ok := l.(*netLinks)
+		if !ok {
+			continue
+		}
+		attached, detached, errs := links.sync(targets)
+		sort.Strings(attached)
+		sort.Strings(detached)
+		for _, name := range attached {
+			logger.Infof("attached '%s' to %s", links.prog.Name, name)
+		}
+		for _, name := range detached {
+			logger.Infof("detached '%s' from %s, which is gone", links.prog.Name, name)
+		}
+		for _, err := range errs {
+			logger.Warnf("could not reattach: %v", err)
+			a.status.recordError(links.prog.Name, err)
+		}
+		a.syncedTargets(links, attached, errs)
+	}
+	a.targets = targets
+	a.resolver.release()
+	return true
+}
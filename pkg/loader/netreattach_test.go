@@ -0,0 +1,83 @@
+package loader
+
+import (
+	"errors"
+	"io"
+
+	"github.com/cilium/ebpf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeLink records the targets it is closed for.
+type fakeLink struct {
+	closed *[]string
+	iface  string
+}
+
+func (l fakeLink) Close() error {
+	*l.closed = append(*l.closed, l.iface)
+	return nil
+}
+
+var _ = Describe("Reattaching", func() {
+	var (
+		closed   []string
+		attaches []string
+		links    *netLinks
+	)
+
+	BeforeEach(func() {
+		closed, attaches = nil, nil
+		links = &netLinks{
+			prog: &ebpf.ProgramSpec{Name: "tc_allow"},
+			attach: func(prog *ebpf.ProgramSpec, loaded *ebpf.Program, t netTarget) (io.Closer, error) {
+				if t.iface == "busy0" {
+					return nil, errors.New("could not attach 'tc_allow' to busy0")
+				}
+				attaches = append(attaches, t.iface)
+				return fakeLink{closed: &closed, iface: t.iface}, nil
+			},
+			links: map[string]targetLink{},
+		}
+	})
+
+	It("attaches to the new targets and detaches from the ones gone", func() {
+		attached, detached, errs := links.sync([]netTarget{{iface: "veth1", ifindex: 4}, {iface: "veth2", ifindex: 5}})
+		Expect(attached).To(ConsistOf("veth1", "veth2"))
+		Expect(detached).To(BeEmpty())
+		Expect(errs).To(BeEmpty())
+
+		attached, detached, _ = links.sync([]netTarget{{iface: "veth2", ifindex: 5}, {iface: "veth3", ifindex: 6}})
+		Expect(attached).To(Equal([]string{"veth3"}))
+		Expect(detached).To(Equal([]string{"veth1"}))
+		Expect(closed).To(Equal([]string{"veth1"}))
+		Expect(attaches).To(Equal([]string{"veth1", "veth2", "veth3"}))
+
+		Expect(links.Close()).To(Succeed())
+		Expect(closed).To(ConsistOf("veth1", "veth2", "veth3"))
+	})
+
+	It("tells interfaces recreated with the same name apart", func() {
+		links.sync([]netTarget{{iface: "veth1", ifindex: 4}})
+		attached, detached, _ := links.sync([]netTarget{{iface: "veth1", ifindex: 9}})
+		Expect(attached).To(Equal([]string{"veth1"}))
+		Expect(detached).To(Equal([]string{"veth1"}))
+	})
+
+	It("tries the targets it could not attach to again", func() {
+		_, _, errs := links.sync([]netTarget{{iface: "busy0", ifindex: 3}})
+		Expect(errs).To(HaveLen(1))
+		Expect(links.links).To(BeEmpty())
+		_, _, errs = links.sync([]netTarget{{iface: "busy0", ifindex: 3}})
+		Expect(errs).To(HaveLen(1))
+	})
+
+	It("matches globs of interfaces", func() {
+		Expect(IsInterfaceGlob("veth*")).To(BeTrue())
+		Expect(IsInterfaceGlob("eth0")).To(BeFalse())
+		Expect(matchInterface("veth*", "veth3a1f")).To(BeTrue())
+		Expect(matchInterface("veth*", "eth0")).To(BeFalse())
+	})
+})
@@ -0,0 +1,259 @@
+package loader
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/solo-io/bumblebee/pkg/stats"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+const (
+	// NodeProgramsMetric is the metric of the number of programs loaded on the node, per type
+	NodeProgramsMetric = "bee_node_programs"
+	// NodeMapsMetric is the metric of the number of maps created on the node, per type
+	NodeMapsMetric = "bee_node_maps"
+	// NodeMapMemoryMetric is the metric of the memory charged for the maps of the node
+	NodeMapMemoryMetric = "bee_node_map_memory_bytes"
+	// NodeProgramMemoryMetric is the metric of the memory charged for the programs of the node
+	NodeProgramMemoryMetric = "bee_node_program_memory_bytes"
+	// NodeJITEnabledMetric is the value of net.core.bpf_jit_enable: 0 if off, 1 if on, 2 if
+	// also dumping the images to the kernel log
+	NodeJITEnabledMetric = "bee_node_jit_enabled"
+	// NodeJITLimitMetric is the value of net.core.bpf_jit_limit, the memory the images of the
+	// programs of unprivileged users can take
+	NodeJITLimitMetric = "bee_node_jit_limit_bytes"
+	// NodeJITHeadroomMetric is the estimated memory left below the JIT limit
+	NodeJITHeadroomMetric = "bee_node_jit_headroom_bytes"
+
+	jitEnableSysctl = "/proc/sys/net/core/bpf_jit_enable"
+	jitLimitSysctl  = "/proc/sys/net/core/bpf_jit_limit"
+)
+
+// NodeUsage is the usage of the BPF subsystem by all the processes of the node.
+type NodeUsage struct {
+	// Number of programs and maps per type, e.g. Kprobe or Hash
+	Programs map[string]int
+	Maps     map[string]int
+	// Memory charged for the maps and programs, as reported by their fdinfo
+	MapBytes     uint64
+	ProgramBytes uint64
+	// Memory charged for the programs which were JIT compiled
+	JITedBytes uint64
+	// Value of net.core.bpf_jit_enable, -1 if the kernel has no JIT
+	JITEnabled int64
+	// Value of net.core.bpf_jit_limit, 0 if unknown
+	JITLimit uint64
+}
+
+// JITHeadroom estimates the memory left below the JIT limit, from the memory charged for the
+// programs which were JIT compiled. The kernel does not report the size of their images,
+// which are usually smaller than the programs, so it is a lower bound.
+func (u *NodeUsage) JITHeadroom() uint64 {
+	if u.JITedBytes >= u.JITLimit {
+		return 0
+	}
+	return u.JITLimit - u.JITedBytes
+}
+
+// ReadNodeUsage iterates over the programs and maps of the node, which needs CAP_SYS_ADMIN.
+// Objects released while iterating are skipped.
+func ReadNodeUsage() (*NodeUsage, error) {
+	usage := &NodeUsage{
+		Programs:   map[string]int{},
+		Maps:       map[string]int{},
+		JITEnabled: -1,
+	}
+	var progID ebpf.ProgramID
+	for {
+		next, err := ebpf.ProgramGetNextID(progID)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not iterate over the programs of the node: %w", err)
+		}
+		progID = next
+		prog, err := ebpf.NewProgramFromID(progID)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not open program %d: %w", progID, err)
+		}
+		usage.Programs[prog.Type().String()]++
+		fields, err := readFdInfo(prog.FD())
+		prog.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not read the fdinfo of program %d: %w", progID, err)
+		}
+		memlock := fdInfoUint(fields, "memlock")
+		usage.ProgramBytes += memlock
+		if fdInfoUint(fields, "prog_jited") == 1 {
+			usage.JITedBytes += memlock
+		}
+	}
+
+	var mapID ebpf.MapID
+	for {
+		next, err := ebpf.MapGetNextID(mapID)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not iterate over the maps of the node: %w", err)
+		}
+		mapID = next
+		m, err := ebpf.NewMapFromID(mapID)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not open map %d: %w", mapID, err)
+		}
+		usage.Maps[m.Type().String()]++
+		fields, err := readFdInfo(m.FD())
+		m.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not read the fdinfo of map %d: %w", mapID, err)
+		}
+		usage.MapBytes += fdInfoUint(fields, "memlock")
+	}
+
+	if enabled, err := readSysctl(jitEnableSysctl); err == nil {
+		usage.JITEnabled = int64(enabled)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if limit, err := readSysctl(jitLimitSysctl); err == nil {
+		usage.JITLimit = limit
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// ExportNodeUsage reads the usage of the node on the interval and exports it as the bee_node_*
+// metrics until the context is done.
+func ExportNodeUsage(ctx context.Context, metricsProvider stats.MetricsProvider, interval time.Duration) {
+	exporter := newNodeExporter(metricsProvider)
+	go func() {
+		logger := contextutils.LoggerFrom(ctx)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			usage, err := ReadNodeUsage()
+			if err != nil {
+				logger.Warnf("could not read the BPF usage of the node: %v", err)
+			} else {
+				exporter.export(ctx, usage)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+type nodeExporter struct {
+	programs, maps         stats.SetInstrument
+	mapBytes, programBytes stats.SetInstrument
+	jitEnabled, jitLimit   stats.SetInstrument
+	jitHeadroom            stats.SetInstrument
+	// types exported by the last export, set to 0 once they have no objects anymore
+	programTypes, mapTypes map[string]bool
+}
+
+func newNodeExporter(metricsProvider stats.MetricsProvider) *nodeExporter {
+	gauge := func(name string, labels ...string) stats.SetInstrument {
+		return metricsProvider.NewGauge(&stats.MetricOpts{Name: name, Labels: labels})
+	}
+	return &nodeExporter{
+		programs:     gauge(NodeProgramsMetric, "type"),
+		maps:         gauge(NodeMapsMetric, "type"),
+		mapBytes:     gauge(NodeMapMemoryMetric),
+		programBytes: gauge(NodeProgramMemoryMetric),
+		jitEnabled:   gauge(NodeJITEnabledMetric),
+		jitLimit:     gauge(NodeJITLimitMetric),
+		jitHeadroom:  gauge(NodeJITHeadroomMetric),
+		programTypes: map[string]bool{},
+		mapTypes:     map[string]bool{},
+	}
+}
+
+func (e *nodeExporter) export(ctx context.Context, usage *NodeUsage) {
+	exportTypes(ctx, e.programs, usage.Programs, e.programTypes)
+	exportTypes(ctx, e.maps, usage.Maps, e.mapTypes)
+	e.mapBytes.Set(ctx, int64(usage.MapBytes), nil)
+	e.programBytes.Set(ctx, int64(usage.ProgramBytes), nil)
+	e.jitEnabled.Set(ctx, usage.JITEnabled, nil)
+	if usage.JITLimit > 0 {
+		e.jitLimit.Set(ctx, int64(usage.JITLimit), nil)
+		e.jitHeadroom.Set(ctx, int64(usage.JITHeadroom()), nil)
+	}
+}
+
+func exportTypes(ctx context.Context, instrument stats.SetInstrument, counts map[string]int, exported map[string]bool) {
+	for objType := range exported {
+		if _, ok := counts[objType]; !ok {
+			instrument.Set(ctx, 0, map[string]string{"type": objType})
+		}
+	}
+	for objType, count := range counts {
+		instrument.Set(ctx, int64(count), map[string]string{"type": objType})
+		exported[objType] = true
+	}
+}
+
+func readFdInfo(fd int) (map[string]string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/self/fdinfo/%d", fd))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseFdInfo(f)
+}
+
+// parseFdInfo parses the "name:\tvalue" lines of an fdinfo file.
+func parseFdInfo(r io.Reader) (map[string]string, error) {
+	fields := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		fields[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+	}
+	return fields, scanner.Err()
+}
+
+// fdInfoUint returns the value of the field, 0 if missing or not a number.
+func fdInfoUint(fields map[string]string, name string) uint64 {
+	val, _ := strconv.ParseUint(fields[name], 10, 64)
+	return val
+}
+
+func readSysctl(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	val, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	return val, nil
+}
@@ -0,0 +1,87 @@
+package loader
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cilium/ebpf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// typeInstrument records the values of a node metric by type, empty if it has no type.
+type typeInstrument struct {
+	values map[string]int64
+}
+
+func (i *typeInstrument) Set(ctx context.Context, val int64, labels map[string]string) {
+	i.values[labels["type"]] = val
+}
+
+func (i *typeInstrument) Delete(ctx context.Context, labels map[string]string) {}
+
+var _ = Describe("node usage", func() {
+	It("parses fdinfo files", func() {
+		fields, err := parseFdInfo(strings.NewReader("pos:\t0\nflags:\t02000002\nprog_type:\t2\nprog_jited:\t1\nmemlock:\t4096\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fdInfoUint(fields, "memlock")).To(Equal(uint64(4096)))
+		Expect(fdInfoUint(fields, "prog_jited")).To(Equal(uint64(1)))
+		Expect(fdInfoUint(fields, "missing")).To(BeZero())
+	})
+
+	It("estimates the headroom below the JIT limit", func() {
+		usage := NodeUsage{JITLimit: 8192, JITedBytes: 4096}
+		Expect(usage.JITHeadroom()).To(Equal(uint64(4096)))
+		usage.JITedBytes = 16384
+		Expect(usage.JITHeadroom()).To(BeZero())
+	})
+
+	It("exports the types without objects anymore as 0", func() {
+		ctx := context.Background()
+		gauge := func() *typeInstrument { return &typeInstrument{values: map[string]int64{}} }
+		programs, maps, mapBytes, headroom := gauge(), gauge(), gauge(), gauge()
+		exporter := &nodeExporter{
+			programs:     programs,
+			maps:         maps,
+			mapBytes:     mapBytes,
+			programBytes: gauge(),
+			jitEnabled:   gauge(),
+			jitLimit:     gauge(),
+			jitHeadroom:  headroom,
+			programTypes: map[string]bool{},
+			mapTypes:     map[string]bool{},
+		}
+		exporter.export(ctx, &NodeUsage{
+			Programs:   map[string]int{"Kprobe": 3},
+			Maps:       map[string]int{"Hash": 2, "RingBuf": 1},
+			MapBytes:   1 << 20,
+			JITEnabled: 1,
+			JITLimit:   1 << 30,
+			JITedBytes: 1 << 12,
+		})
+		exporter.export(ctx, &NodeUsage{
+			Programs:   map[string]int{"Kprobe": 2},
+			Maps:       map[string]int{"Hash": 2},
+			MapBytes:   1 << 19,
+			JITEnabled: 1,
+			JITLimit:   1 << 30,
+		})
+		Expect(programs.values).To(Equal(map[string]int64{"Kprobe": 2}))
+		Expect(maps.values).To(Equal(map[string]int64{"Hash": 2, "RingBuf": 0}))
+		Expect(mapBytes.values).To(Equal(map[string]int64{"": 1 << 19}))
+		Expect(headroom.values).To(Equal(map[string]int64{"": 1 << 30}))
+	})
+
+	It("counts the maps of the node", func() {
+		m, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Hash, KeySize: 4, ValueSize: 8, MaxEntries: 128})
+		if err != nil {
+			Skip("creating maps needs privileges: " + err.Error())
+		}
+		defer m.Close()
+		usage, err := ReadNodeUsage()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(usage.Maps[ebpf.Hash.String()]).To(BeNumerically(">=", 1))
+		Expect(usage.MapBytes).NotTo(BeZero())
+	})
+})
@@ -0,0 +1,223 @@
+package loader
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf/btf"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+)
+
+type paramKind int
+
+const (
+	paramInt paramKind = iota
+	paramUint
+	paramBool
+	paramString
+)
+
+// Parameter is a `const volatile` global of a program, which can be set before the program
+// is loaded with LoadOptions.Constants, e.g. a pid to filter on in the kernel. Only the
+// integers, booleans and char arrays are parameters.
+type Parameter struct {
+	Name string
+	// C type, e.g. u32 or char[16]
+	Type string
+	// Value compiled into the program
+	Default string
+
+	kind paramKind
+	size int
+}
+
+// Parameters returns the parameters declared by the program, sorted by name.
+func (p *ParsedELF) Parameters() []Parameter {
+	rodata := p.Spec.Maps[".rodata"]
+	if rodata == nil || rodata.BTF == nil {
+		return nil
+	}
+	datasec, ok := rodata.BTF.Value.(*btf.Datasec)
+	if !ok {
+		return nil
+	}
+	var contents []byte
+	if len(rodata.Contents) == 1 {
+		contents, _ = rodata.Contents[0].Value.([]byte)
+	}
+
+	var params []Parameter
+	for _, secinfo := range datasec.Vars {
+		v, ok := secinfo.Type.(*btf.Var)
+		if !ok {
+			continue
+		}
+		param := Parameter{Name: v.Name, Type: btfTypeName(v.Type), size: int(secinfo.Size)}
+		switch t := skipQualifiers(v.Type).(type) {
+		case *btf.Int:
+			switch {
+			case t.Encoding&btf.Bool != 0:
+				param.kind = paramBool
+			case t.Encoding.IsSigned():
+				param.kind = paramInt
+			default:
+				param.kind = paramUint
+			}
+			if t.Size != 1 && t.Size != 2 && t.Size != 4 && t.Size != 8 {
+				continue
+			}
+		case *btf.Array:
+			elem, ok := skipQualifiers(t.Type).(*btf.Int)
+			if !ok || elem.Size != 1 {
+				continue
+			}
+			param.kind = paramString
+		default:
+			continue
+		}
+		if end := int(secinfo.Offset) + param.size; end <= len(contents) {
+			param.Default = param.format(contents[secinfo.Offset:end])
+		}
+		params = append(params, param)
+	}
+	sort.Slice(params, func(i, j int) bool {
+		return params[i].Name < params[j].Name
+	})
+	return params
+}
+
+func (p Parameter) format(value []byte) string {
+	switch p.kind {
+	case paramString:
+		return string(bytes.TrimRight(value, "\x00"))
+	case paramBool:
+		return strconv.FormatBool(value[0] != 0)
+	}
+	var u uint64
+	switch p.size {
+	case 1:
+		u = uint64(value[0])
+	case 2:
+		u = uint64(decoder.Endianess.Uint16(value))
+	case 4:
+		u = uint64(decoder.Endianess.Uint32(value))
+	case 8:
+		u = decoder.Endianess.Uint64(value)
+	}
+	if p.kind == paramInt {
+		// sign extend from the size of the parameter
+		shift := 64 - 8*p.size
+		return strconv.FormatInt(int64(u<<shift)>>shift, 10)
+	}
+	return strconv.FormatUint(u, 10)
+}
+
+// Parse parses the value of the parameter, as set in LoadOptions.Constants. Integers may be
+// decimal, hexadecimal with a 0x prefix or octal with a 0 prefix, and strings must leave room
+// for their terminating NUL.
+func (p Parameter) Parse(value string) (interface{}, error) {
+	switch p.kind {
+	case paramBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false", p.Name)
+		}
+		return b, nil
+	case paramString:
+		if len(value) >= p.size {
+			return nil, fmt.Errorf("%s must be shorter than %d bytes", p.Name, p.size)
+		}
+		buf := make([]byte, p.size)
+		copy(buf, value)
+		return buf, nil
+	case paramInt:
+		n, err := strconv.ParseInt(value, 0, 8*p.size)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a %s: %w", p.Name, p.Type, err)
+		}
+		switch p.size {
+		case 1:
+			return int8(n), nil
+		case 2:
+			return int16(n), nil
+		case 4:
+			return int32(n), nil
+		}
+		return n, nil
+	}
+	n, err := strconv.ParseUint(value, 0, 8*p.size)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a %s: %w", p.Name, p.Type, err)
+	}
+	switch p.size {
+	case 1:
+		return uint8(n), nil
+	case 2:
+		return uint16(n), nil
+	case 4:
+		return uint32(n), nil
+	}
+	return n, nil
+}
+
+// ParseConstants parses the values of the parameters of a program, by name, to be set in
+// LoadOptions.Constants.
+func ParseConstants(params []Parameter, values map[string]string) (map[string]interface{}, error) {
+	byName := map[string]Parameter{}
+	var names []string
+	for _, p := range params {
+		byName[p.Name] = p
+		names = append(names, p.Name)
+	}
+	constants := map[string]interface{}{}
+	for name, value := range values {
+		p, ok := byName[name]
+		if !ok {
+			if len(names) == 0 {
+				return nil, fmt.Errorf("unknown parameter %s, the program declares none", name)
+			}
+			return nil, fmt.Errorf("unknown parameter %s, must be one of %s", name, strings.Join(names, ", "))
+		}
+		v, err := p.Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		constants[name] = v
+	}
+	return constants, nil
+}
+
+// kconfigConstants parses the values of kernel config options, as written in a .config, e.g.
+// "y" or "250", for the parameters named after them, e.g. CONFIG_HZ. Tristates are set as
+// libbpf sets its __kconfig externs: y is true or 1, m is 2, and n is false or 0. The
+// options the program does not declare are ignored.
+func kconfigConstants(params []Parameter, kconfig map[string]string) (map[string]interface{}, error) {
+	constants := map[string]interface{}{}
+	for _, p := range params {
+		value, ok := kconfig[p.Name]
+		if !ok {
+			continue
+		}
+		switch {
+		case p.kind == paramString:
+			value = strings.Trim(value, `"`)
+		case p.kind == paramBool && (value == "y" || value == "n"):
+			value = strconv.FormatBool(value == "y")
+		case value == "y":
+			value = "1"
+		case value == "m":
+			value = "2"
+		case value == "n":
+			value = "0"
+		}
+		v, err := p.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("kconfig option %w", err)
+		}
+		constants[p.Name] = v
+	}
+	return constants, nil
+}
@@ -0,0 +1,94 @@
+package loader
+
+import (
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Parameters", func() {
+	u32 := &btf.Int{Name: "u32", Size: 4}
+	s16 := &btf.Int{Name: "s16", Size: 2, Encoding: btf.Signed}
+	boolean := &btf.Int{Name: "bool", Size: 1, Encoding: btf.Bool}
+	char := &btf.Int{Name: "char", Size: 1, Encoding: btf.Char}
+	parsedELF := &ParsedELF{Spec: &ebpf.CollectionSpec{
+		Maps: map[string]*ebpf.MapSpec{
+			".rodata": {
+				Name: ".rodata",
+				BTF: &btf.Map{Value: &btf.Datasec{Name: ".rodata", Size: 24, Vars: []btf.VarSecinfo{
+					{Type: &btf.Var{Name: "target_pid", Type: &btf.Volatile{Type: &btf.Const{Type: u32}}}, Offset: 0, Size: 4},
+					{Type: &btf.Var{Name: "min_port", Type: &btf.Const{Type: s16}}, Offset: 4, Size: 2},
+					{Type: &btf.Var{Name: "verbose", Type: boolean}, Offset: 6, Size: 1},
+					{Type: &btf.Var{Name: "comm", Type: &btf.Array{Type: char, Nelems: 8}}, Offset: 8, Size: 8},
+					{Type: &btf.Var{Name: "weights", Type: &btf.Array{Type: u32, Nelems: 2}}, Offset: 16, Size: 8},
+				}}},
+				Contents: []ebpf.MapKV{{Key: uint32(0), Value: []byte{
+					42, 0, 0, 0, 0xff, 0xff, 1, 0,
+					'c', 'u', 'r', 'l', 0, 0, 0, 0,
+					0, 0, 0, 0, 0, 0, 0, 0,
+				}}},
+			},
+		},
+	}}
+
+	It("lists the integers, booleans and strings of .rodata", func() {
+		params := parsedELF.Parameters()
+		Expect(params).To(HaveLen(4))
+		Expect(params[0].Name).To(Equal("comm"))
+		Expect(params[0].Type).To(Equal("char[8]"))
+		Expect(params[0].Default).To(Equal("curl"))
+		Expect(params[1].Default).To(Equal("-1"))
+		Expect(params[2].Name).To(Equal("target_pid"))
+		Expect(params[2].Type).To(Equal("u32"))
+		Expect(params[2].Default).To(Equal("42"))
+		Expect(params[3].Default).To(Equal("true"))
+	})
+
+	It("validates the values of parameters", func() {
+		params := parsedELF.Parameters()
+		constants, err := ParseConstants(params, map[string]string{
+			"target_pid": "0x10",
+			"min_port":   "-80",
+			"verbose":    "false",
+			"comm":       "nginx",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(constants).To(Equal(map[string]interface{}{
+			"target_pid": uint32(16),
+			"min_port":   int16(-80),
+			"verbose":    false,
+			"comm":       []byte{'n', 'g', 'i', 'n', 'x', 0, 0, 0},
+		}))
+
+		_, err = ParseConstants(params, map[string]string{"target_pid": "-1"})
+		Expect(err).To(MatchError(ContainSubstring("target_pid must be a u32")))
+		_, err = ParseConstants(params, map[string]string{"comm": "systemd-journal"})
+		Expect(err).To(MatchError(ContainSubstring("shorter than 8 bytes")))
+		_, err = ParseConstants(params, map[string]string{"weights": "1"})
+		Expect(err).To(MatchError(ContainSubstring("unknown parameter weights")))
+	})
+
+	It("sets the kconfig options the program declares", func() {
+		params := parsedELF.Parameters()
+		constants, err := kconfigConstants(params, map[string]string{
+			"target_pid":     "y",
+			"verbose":        "n",
+			"comm":           `"6.1.0"`,
+			"CONFIG_BPF_LSM": "y",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(constants).To(Equal(map[string]interface{}{
+			"target_pid": uint32(1),
+			"verbose":    false,
+			"comm":       []byte{'6', '.', '1', '.', '0', 0, 0, 0},
+		}))
+
+		constants, err = kconfigConstants(params, map[string]string{"target_pid": "m"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(constants).To(HaveKeyWithValue("target_pid", uint32(2)))
+		_, err = kconfigConstants(params, map[string]string{"min_port": "fast"})
+		Expect(err).To(MatchError(ContainSubstring("kconfig option min_port must be a s16")))
+	})
+})
@@ -0,0 +1,85 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/perf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/stats"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+// default number of pages of the buffer of each CPU a perf event array is read from
+const defaultPerfBufferPages = 64
+
+// startPerfBuf reads the samples of a perf event array, and handles each of them as an
+// event, the same way as ringbuf entries.
+func (l *loader) startPerfBuf(
+	ctx context.Context,
+	valueStruct *btf.Struct,
+	liveMap *ebpf.Map,
+	incrementInstrument stats.IncrementInstrument,
+	latency *latencyStats,
+	name string,
+	watcher v1.MapWatcher,
+	lost *lostTracker,
+	opts *LoadOptions,
+) error {
+	d := l.decoderFactory()
+	labels := opts.ParsedELF.WatchedMaps[name].labels
+	logger := contextutils.LoggerFrom(ctx)
+
+	pages := opts.PerfBufferPages
+	if pages <= 0 {
+		pages = defaultPerfBufferPages
+	}
+	rd, err := perf.NewReader(liveMap, pages*os.Getpagesize())
+	if err != nil {
+		return fmt.Errorf("opening perf event reader: %v", err)
+	}
+	defer rd.Close()
+	// Close the reader once done, which will exit the read loop.
+	go func() {
+		<-ctx.Done()
+		if err := rd.Close(); err != nil {
+			logger.Infof("error while closing perf event array '%s' reader: %s", name, err)
+		}
+	}()
+
+	for {
+		record, err := rd.Read()
+		if err != nil {
+			if errors.Is(err, perf.ErrClosed) {
+				return nil
+			}
+			logger.Infof("error while reading from perf event array '%s' reader: %s", name, err)
+			opts.Status.recordError(name, err)
+			continue
+		}
+		if record.LostSamples > 0 {
+			lost.add(ctx, time.Now(), name, PerfLostReason, record.LostSamples)
+			continue
+		}
+		opts.Recorder.record(ctx, name, record.RawSample)
+		result, err := d.DecodeBtfBinary(ctx, valueStruct, record.RawSample)
+		if err != nil {
+			return err
+		}
+
+		latency.observe(result)
+		stringLabels := labels.stringify(result)
+		incrementInstrument.Increment(ctx, stringLabels)
+		watcher.SendEntry(v1.MapEntry{
+			Name: name,
+			Entry: v1.KvPair{
+				Key: stringLabels,
+			},
+		})
+	}
+}
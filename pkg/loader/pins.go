@@ -0,0 +1,255 @@
+package loader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+// Kinds of the objects pinned by a run
+const (
+	MapPinKind     = "map"
+	ProgramPinKind = "program"
+)
+
+// PinOwner records the run which pinned the objects of a bpffs directory, as the pins
+// outlive it. It is kept in the pin inventory directory, as bpffs only holds BPF objects.
+type PinOwner struct {
+	// Directory of the pins
+	Dir string `json:"dir"`
+	// Kind of the objects pinned in the directory
+	Kind string `json:"kind"`
+	// Reference of the program, e.g. its OCI image
+	Program string    `json:"program,omitempty"`
+	Host    string    `json:"host"`
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+}
+
+// Link is a BPF object pinned by a run, which keeps it loaded once the run is over.
+// The kprobes and tracepoints attached by bee are perf event links, which can't be pinned
+// and are released along with the process, so the objects left over by a run are its
+// pinned maps and programs.
+type Link struct {
+	Path  string   `json:"path"`
+	Kind  string   `json:"kind"`
+	Owner PinOwner `json:"owner"`
+	// ID of the object in the kernel, 0 if it could not be opened
+	ID uint32 `json:"id,omitempty"`
+	// Whether the run which pinned the object is still running, on this host
+	Running bool `json:"running"`
+	// Why the object could not be opened, e.g. as it is not a BPF object
+	Error string `json:"error,omitempty"`
+}
+
+// recordPins records this process as the owner of the pins of a program.
+func recordPins(ctx context.Context, opts *LoadOptions) error {
+	if opts.PinInventory == "" {
+		return nil
+	}
+	host, _ := os.Hostname()
+	for kind, dir := range map[string]string{MapPinKind: opts.PinMaps, ProgramPinKind: opts.PinProgs} {
+		if dir == "" {
+			continue
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		owner := PinOwner{
+			Dir:     abs,
+			Kind:    kind,
+			Program: opts.ProgramRef,
+			Host:    host,
+			PID:     os.Getpid(),
+			Started: time.Now(),
+		}
+		if err := writePinOwner(opts.PinInventory, owner); err != nil {
+			return fmt.Errorf("could not record the owner of the pins of %s: %w", dir, err)
+		}
+		contextutils.LoggerFrom(ctx).Debugf("recorded pins of %s in %s", abs, opts.PinInventory)
+	}
+	return nil
+}
+
+// pinOwnerFile is the record of a pin directory in the inventory, the record of a
+// directory being replaced by the run pinning to it last.
+func pinOwnerFile(inventory string, owner PinOwner) string {
+	sum := sha256.Sum256([]byte(owner.Kind + ":" + owner.Dir))
+	return filepath.Join(inventory, hex.EncodeToString(sum[:8])+".json")
+}
+
+func writePinOwner(inventory string, owner PinOwner) error {
+	if err := os.MkdirAll(inventory, 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(owner, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(pinOwnerFile(inventory, owner), data, 0600)
+}
+
+func readPinOwners(inventory string) ([]PinOwner, error) {
+	files, err := ioutil.ReadDir(inventory)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read pin inventory: %w", err)
+	}
+	var owners []PinOwner
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(inventory, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("could not read pin inventory: %w", err)
+		}
+		var owner PinOwner
+		if err := json.Unmarshal(data, &owner); err != nil {
+			return nil, fmt.Errorf("invalid pin record %s: %w", file.Name(), err)
+		}
+		owners = append(owners, owner)
+	}
+	return owners, nil
+}
+
+// Links lists the objects pinned by the runs recorded in the inventory directory, along
+// with their kernel IDs.
+func Links(ctx context.Context, inventory string) ([]Link, error) {
+	owners, err := readPinOwners(inventory)
+	if err != nil {
+		return nil, err
+	}
+	var links []Link
+	for _, owner := range owners {
+		pins, err := ioutil.ReadDir(owner.Dir)
+		if errors.Is(err, os.ErrNotExist) {
+			// removed by hand, the record is dropped by CleanupOrphans
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read pins of %s: %w", owner.Dir, err)
+		}
+		running := owner.running()
+		for _, pin := range pins {
+			if pin.IsDir() {
+				continue
+			}
+			l := Link{
+				Path:    filepath.Join(owner.Dir, pin.Name()),
+				Kind:    owner.Kind,
+				Owner:   owner,
+				Running: running,
+			}
+			if id, err := pinnedID(l.Path, l.Kind); err != nil {
+				l.Error = err.Error()
+			} else {
+				l.ID = id
+			}
+			links = append(links, l)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Path < links[j].Path })
+	return links, nil
+}
+
+// CleanupOrphans unpins the objects of the runs which are over and started more than
+// olderThan ago, e.g. runs which crashed, and returns them. The objects are unloaded by
+// the kernel once unpinned, unless still in use. Pins of other hosts are left alone.
+func CleanupOrphans(ctx context.Context, inventory string, olderThan time.Duration) ([]Link, error) {
+	links, err := Links(ctx, inventory)
+	if err != nil {
+		return nil, err
+	}
+	owners, err := readPinOwners(inventory)
+	if err != nil {
+		return nil, err
+	}
+	logger := contextutils.LoggerFrom(ctx)
+	host, _ := os.Hostname()
+	isOrphan := func(owner PinOwner) bool {
+		return owner.Host == host && !owner.running() && time.Since(owner.Started) > olderThan
+	}
+
+	var removed []Link
+	for _, l := range links {
+		if !isOrphan(l.Owner) {
+			continue
+		}
+		if err := os.Remove(l.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, fmt.Errorf("could not unpin %s: %w", l.Path, err)
+		}
+		logger.Infof("unpinned %s %s of %s", l.Kind, l.Path, l.Owner.Program)
+		removed = append(removed, l)
+	}
+	for _, owner := range owners {
+		if !isOrphan(owner) {
+			continue
+		}
+		// only removed if empty, e.g. pins which are not ours are kept
+		if err := os.Remove(owner.Dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Infof("kept pin directory %s: %v", owner.Dir, err)
+			continue
+		}
+		if err := os.Remove(pinOwnerFile(inventory, owner)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, fmt.Errorf("could not remove pin record of %s: %w", owner.Dir, err)
+		}
+	}
+	return removed, nil
+}
+
+// running returns whether the process of the run is still alive, assuming it is if it
+// ran on another host.
+func (o PinOwner) running() bool {
+	if host, _ := os.Hostname(); host != o.Host {
+		return true
+	}
+	return processRunning(o.PID)
+}
+
+func pinnedID(path, kind string) (uint32, error) {
+	switch kind {
+	case MapPinKind:
+		m, err := ebpf.LoadPinnedMap(path, &ebpf.LoadPinOptions{ReadOnly: true})
+		if err != nil {
+			return 0, err
+		}
+		defer m.Close()
+		info, err := m.Info()
+		if err != nil {
+			return 0, err
+		}
+		id, _ := info.ID()
+		return uint32(id), nil
+	case ProgramPinKind:
+		// programs can only be opened read-write
+		p, err := ebpf.LoadPinnedProgram(path, nil)
+		if err != nil {
+			return 0, err
+		}
+		defer p.Close()
+		info, err := p.Info()
+		if err != nil {
+			return 0, err
+		}
+		id, _ := info.ID()
+		return uint32(id), nil
+	default:
+		return 0, fmt.Errorf("unknown kind of pin %s", kind)
+	}
+}
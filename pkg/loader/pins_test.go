@@ -0,0 +1,89 @@
+package loader
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pin inventory", func() {
+	var (
+		ctx       context.Context
+		root      string
+		inventory string
+		host      string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		root, err = ioutil.TempDir("", "bee-pins")
+		Expect(err).NotTo(HaveOccurred())
+		inventory = filepath.Join(root, "inventory")
+		host, _ = os.Hostname()
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(root)
+	})
+
+	// pin creates a directory of pins owned by a run, regular files standing in for the
+	// BPF objects as bpffs may not be mounted
+	pin := func(name string, owner PinOwner, files ...string) string {
+		dir := filepath.Join(root, name)
+		Expect(os.MkdirAll(dir, 0700)).To(Succeed())
+		for _, f := range files {
+			Expect(ioutil.WriteFile(filepath.Join(dir, f), nil, 0600)).To(Succeed())
+		}
+		owner.Dir = dir
+		Expect(writePinOwner(inventory, owner)).To(Succeed())
+		return dir
+	}
+
+	It("records the pins of a run", func() {
+		opts := &LoadOptions{PinInventory: inventory, PinMaps: filepath.Join(root, "maps"), ProgramRef: "tcpconnect:0.0.7"}
+		Expect(os.MkdirAll(opts.PinMaps, 0700)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(opts.PinMaps, "events"), nil, 0600)).To(Succeed())
+		Expect(recordPins(ctx, opts)).To(Succeed())
+
+		links, err := Links(ctx, inventory)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(links).To(HaveLen(1))
+		Expect(links[0].Path).To(Equal(filepath.Join(opts.PinMaps, "events")))
+		Expect(links[0].Kind).To(Equal(MapPinKind))
+		Expect(links[0].Owner.Program).To(Equal("tcpconnect:0.0.7"))
+		Expect(links[0].Owner.PID).To(Equal(os.Getpid()))
+		Expect(links[0].Running).To(BeTrue())
+		// not a BPF object
+		Expect(links[0].Error).NotTo(BeEmpty())
+	})
+
+	It("only cleans up the pins of old runs which are over", func() {
+		// pid_max is at most 2^22, so no process has this PID
+		const deadPID = 1 << 23
+		old := time.Now().Add(-48 * time.Hour)
+		crashed := pin("crashed", PinOwner{Kind: MapPinKind, Host: host, PID: deadPID, Started: old}, "events", "counts")
+		recent := pin("recent", PinOwner{Kind: MapPinKind, Host: host, PID: deadPID, Started: time.Now()}, "events")
+		running := pin("running", PinOwner{Kind: ProgramPinKind, Host: host, PID: os.Getpid(), Started: old}, "kprobe")
+		remote := pin("remote", PinOwner{Kind: MapPinKind, Host: host + "-other", PID: deadPID, Started: old}, "events")
+
+		removed, err := CleanupOrphans(ctx, inventory, 24*time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(removed).To(HaveLen(2))
+		Expect(removed[0].Path).To(Equal(filepath.Join(crashed, "counts")))
+		Expect(removed[1].Path).To(Equal(filepath.Join(crashed, "events")))
+		Expect(crashed).NotTo(BeADirectory())
+		for _, dir := range []string{recent, running, remote} {
+			Expect(dir).To(BeADirectory())
+		}
+
+		links, err := Links(ctx, inventory)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(links).To(HaveLen(3))
+	})
+})
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package loader
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// processRunning returns whether a process of this host is alive.
+func processRunning(pid int) bool {
+	err := unix.Kill(pid, 0)
+	return err == nil || errors.Is(err, unix.EPERM)
+}
@@ -0,0 +1,13 @@
+package loader
+
+import "os"
+
+// processRunning returns whether a process of this host is alive.
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
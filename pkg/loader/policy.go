@@ -0,0 +1,164 @@
+package loader
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/cilium/ebpf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"gopkg.in/yaml.v2"
+)
+
+// Policy writes the entries of a v1.Policy to the enforcement maps of a program: the settings
+// maps it declares in `.maps.settings` sections which are not seeded, e.g. the allow and deny
+// lists of an LSM or XDP program. It is bound to the program by the loader, which writes the
+// current policy before the program is attached, so it is enforced from the start.
+type Policy struct {
+	specs map[string]*ebpf.MapSpec
+
+	lock    sync.Mutex
+	current v1.Policy
+	maps    map[string]*ebpf.Map
+}
+
+// NewPolicy returns the policy of the enforcement maps of the program, the settings maps
+// which are not seeded, starting with the current version.
+func NewPolicy(spec *ebpf.CollectionSpec, seeds []MapSeed, current v1.Policy) (*Policy, error) {
+	seeded := map[string]bool{}
+	for _, seed := range seeds {
+		seeded[seed.Map] = true
+	}
+	p := &Policy{specs: map[string]*ebpf.MapSpec{}, current: current}
+	for name, mapSpec := range spec.Maps {
+		if !isSettingsMap(mapSpec) || seeded[name] {
+			continue
+		}
+		if err := checkSeeds(spec, []MapSeed{{Map: name}}); err != nil {
+			// e.g. a settings map of a type the policy can't be written to
+			continue
+		}
+		p.specs[name] = mapSpec
+	}
+	if err := p.Check(current); err != nil {
+		return nil, fmt.Errorf("invalid policy version %d: %w", current.Version, err)
+	}
+	return p, nil
+}
+
+// Maps returns the names of the enforcement maps, sorted.
+func (p *Policy) Maps() []string {
+	var names []string
+	for name := range p.specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check returns an error if the entries of the policy can't be written to the maps.
+func (p *Policy) Check(policy v1.Policy) error {
+	_, err := p.encode(policy)
+	return err
+}
+
+// Apply replaces the entries of the enforcement maps by the ones of the policy, emptying the
+// maps the policy has no entries for. The policy is encoded entirely before the maps are
+// written, so an invalid policy leaves them as they are.
+func (p *Policy) Apply(policy v1.Policy) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.maps == nil {
+		return errNotLoaded
+	}
+	if err := p.write(policy); err != nil {
+		return err
+	}
+	p.current = policy
+	return nil
+}
+
+// bindMaps writes the current policy to the maps once loaded.
+func (p *Policy) bindMaps(maps map[string]*ebpf.Map) error {
+	if p == nil {
+		return nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.maps = maps
+	if err := p.write(p.current); err != nil {
+		p.maps = nil
+		return fmt.Errorf("could not apply policy version %d: %w", p.current.Version, err)
+	}
+	return nil
+}
+
+// unbind stops writing the maps, once the program is unloaded.
+func (p *Policy) unbind() {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.maps = nil
+}
+
+// write must be called with the lock held, once bound
+func (p *Policy) write(policy v1.Policy) error {
+	encoded, err := p.encode(policy)
+	if err != nil {
+		return err
+	}
+	for _, name := range p.Maps() {
+		if _, err := replaceEntries(p.maps[name], encoded[name]); err != nil {
+			return fmt.Errorf("could not write map '%s': %w", name, err)
+		}
+	}
+	return nil
+}
+
+// encode encodes the entries of the policy by map.
+func (p *Policy) encode(policy v1.Policy) (map[string][]seedEntry, error) {
+	encoded := map[string][]seedEntry{}
+	for name, entries := range policy.Maps {
+		mapSpec, ok := p.specs[name]
+		if !ok {
+			return nil, fmt.Errorf("map '%s' is not an enforcement map of the program, only its settings maps which are not seeded are: %v", name, p.Maps())
+		}
+		keys, values := make([]interface{}, len(entries)), make([]interface{}, len(entries))
+		for i, entry := range entries {
+			var err error
+			if keys[i], err = yamlValue(entry.Key); err != nil {
+				return nil, err
+			}
+			if entry.Value == nil {
+				values[i] = true
+			} else if values[i], err = yamlValue(entry.Value); err != nil {
+				return nil, err
+			}
+		}
+		mapEntries, err := encodeEntries(mapSpec.BTF, mapSpec.KeySize, mapSpec.ValueSize, keys, values)
+		if err != nil {
+			return nil, fmt.Errorf("map '%s': %w", name, err)
+		}
+		if uint32(len(mapEntries)) > mapSpec.MaxEntries {
+			return nil, fmt.Errorf("map '%s' has %d entries, more than its %d", name, len(mapEntries), mapSpec.MaxEntries)
+		}
+		encoded[name] = mapEntries
+	}
+	return encoded, nil
+}
+
+// yamlValue returns a JSON value as decoded from YAML, as the entries of seeds are.
+func yamlValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	if err := yaml.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
@@ -0,0 +1,101 @@
+package loader
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Policy", func() {
+	u32 := &btf.Int{Name: "u32", Size: 4}
+	u8 := &btf.Int{Name: "u8", Size: 1}
+	ipv4 := &btf.Typedef{Name: "ipv4_addr", Type: u32}
+	denylist := &ebpf.MapSpec{Name: "denylist", Type: ebpf.Hash, KeySize: 4, ValueSize: 1, MaxEntries: 2,
+		SectionName: ".maps.settings", BTF: &btf.Map{Key: ipv4, Value: u8}}
+	spec := &ebpf.CollectionSpec{Maps: map[string]*ebpf.MapSpec{
+		"denylist":  denylist,
+		"allowlist": {Name: "allowlist", Type: ebpf.Hash, KeySize: 4, ValueSize: 1, MaxEntries: 16, SectionName: ".maps.settings", BTF: denylist.BTF},
+		"drops":     {Name: "drops", Type: ebpf.Hash, KeySize: 4, ValueSize: 1, SectionName: ".maps.counter", BTF: denylist.BTF},
+	}}
+	seeds := []MapSeed{{Map: "allowlist"}}
+
+	entries := func(keys ...string) []v1.PolicyEntry {
+		var entries []v1.PolicyEntry
+		for _, key := range keys {
+			entries = append(entries, v1.PolicyEntry{Key: key})
+		}
+		return entries
+	}
+
+	It("only writes the settings maps which are not seeded", func() {
+		policy, err := NewPolicy(spec, seeds, v1.Policy{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy.Maps()).To(Equal([]string{"denylist"}))
+
+		Expect(policy.Check(v1.Policy{Maps: map[string][]v1.PolicyEntry{"denylist": entries("10.0.0.1", "10.0.0.2")}})).To(Succeed())
+		Expect(policy.Check(v1.Policy{Maps: map[string][]v1.PolicyEntry{"allowlist": entries("10.0.0.1")}})).To(MatchError(ContainSubstring("map 'allowlist' is not an enforcement map of the program")))
+		Expect(policy.Check(v1.Policy{Maps: map[string][]v1.PolicyEntry{"drops": entries("10.0.0.1")}})).To(MatchError(ContainSubstring("map 'drops' is not an enforcement map")))
+		Expect(policy.Check(v1.Policy{Maps: map[string][]v1.PolicyEntry{"denylist": entries("10.0.0.1", "10.0.0.2", "10.0.0.3")}})).To(MatchError(ContainSubstring("has 3 entries, more than its 2")))
+		Expect(policy.Check(v1.Policy{Maps: map[string][]v1.PolicyEntry{"denylist": entries("nope")}})).To(MatchError(ContainSubstring("not an IPv4 address")))
+		Expect(policy.Check(v1.Policy{Maps: map[string][]v1.PolicyEntry{"denylist": {{Key: "10.0.0.1", Value: 1.5}}}})).To(MatchError(ContainSubstring("must be an integer")))
+
+		_, err = NewPolicy(spec, seeds, v1.Policy{Version: 3, Maps: map[string][]v1.PolicyEntry{"drops": entries("10.0.0.1")}})
+		Expect(err).To(MatchError(ContainSubstring("invalid policy version 3")))
+		Expect(policy.Apply(v1.Policy{})).To(MatchError(errNotLoaded))
+	})
+
+	It("encodes the values of the entries as the ones of seeds", func() {
+		policy, err := NewPolicy(spec, seeds, v1.Policy{})
+		Expect(err).NotTo(HaveOccurred())
+		var decoded v1.Policy
+		// as decoded from a request, the numbers kept as written
+		dec := json.NewDecoder(strings.NewReader(`{"maps": {"denylist": [{"key": "10.0.0.2", "value": 7}, {"key": "10.0.0.1"}]}}`))
+		dec.UseNumber()
+		Expect(dec.Decode(&decoded)).To(Succeed())
+		encoded, err := policy.encode(decoded)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(encoded["denylist"]).To(Equal([]seedEntry{
+			{key: []byte{10, 0, 0, 1}, value: []byte{1}},
+			{key: []byte{10, 0, 0, 2}, value: []byte{7}},
+		}))
+	})
+
+	It("replaces the entries of the maps", func() {
+		m, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Hash, KeySize: 4, ValueSize: 1, MaxEntries: 2})
+		if err != nil {
+			Skip("creating maps needs privileges: " + err.Error())
+		}
+		defer m.Close()
+		// e.g. left pinned by a previous run
+		Expect(m.Put([]byte{192, 168, 0, 1}, []byte{1})).To(Succeed())
+		keys := func() [][]byte {
+			var keys [][]byte
+			var key, value []byte
+			iter := m.Iterate()
+			for iter.Next(&key, &value) {
+				keys = append(keys, append([]byte(nil), key...))
+			}
+			Expect(iter.Err()).NotTo(HaveOccurred())
+			return keys
+		}
+
+		policy, err := NewPolicy(spec, seeds, v1.Policy{Version: 1, Maps: map[string][]v1.PolicyEntry{"denylist": entries("10.0.0.1")}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy.bindMaps(map[string]*ebpf.Map{"denylist": m})).To(Succeed())
+		Expect(keys()).To(Equal([][]byte{{10, 0, 0, 1}}))
+
+		Expect(policy.Apply(v1.Policy{Version: 2, Maps: map[string][]v1.PolicyEntry{"denylist": entries("10.0.0.1", "nope")}})).To(HaveOccurred())
+		Expect(keys()).To(Equal([][]byte{{10, 0, 0, 1}}))
+		Expect(policy.Apply(v1.Policy{Version: 2})).To(Succeed())
+		Expect(keys()).To(BeEmpty())
+
+		policy.unbind()
+		Expect(policy.Apply(v1.Policy{Version: 3})).To(MatchError(errNotLoaded))
+	})
+})
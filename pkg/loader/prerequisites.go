@@ -0,0 +1,153 @@
+package loader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+// hostRoot is the root the files of the host features are checked in, changed by tests.
+var hostRoot = "/"
+
+// detectCgroupMode detects the cgroup hierarchies of the host, changed by tests.
+var detectCgroupMode = DetectCgroupMode
+
+// featureCheck checks a feature of the host, returning where it was found, or why not.
+type featureCheck struct {
+	description string
+	check       func() (bool, string)
+}
+
+var featureChecks = map[v1.HostFeature]featureCheck{
+	v1.HostFeatureCgroupV2: {"the unified cgroup v2 hierarchy", checkCgroupV2},
+	v1.HostFeatureContainerdSocket: {"the socket of containerd", func() (bool, string) {
+		return checkSocket("/run/containerd/containerd.sock")
+	}},
+	v1.HostFeatureDockerSocket: {"the socket of docker", func() (bool, string) {
+		return checkSocket("/var/run/docker.sock", "/run/docker.sock")
+	}},
+	v1.HostFeatureCRIOSocket: {"the socket of cri-o", func() (bool, string) {
+		return checkSocket("/var/run/crio/crio.sock", "/run/crio/crio.sock")
+	}},
+	v1.HostFeaturePMU: {"the hardware performance counters of the CPU", checkPMU},
+	v1.HostFeatureBTF: {"the BTF of the kernel", func() (bool, string) {
+		return checkPath("/sys/kernel/btf/vmlinux")
+	}},
+	v1.HostFeatureTracefs: {"tracefs", func() (bool, string) {
+		return checkPath("/sys/kernel/tracing/events", "/sys/kernel/debug/tracing/events")
+	}},
+	v1.HostFeatureBPFLSM: {"the BPF LSM", checkBPFLSM},
+}
+
+// CheckPrerequisites checks the features on this host, returning a PrerequisiteError
+// listing the missing ones, if any.
+func CheckPrerequisites(features []v1.HostFeature) error {
+	var missing []v1.Prerequisite
+	for _, p := range HostPrerequisites(features) {
+		if !p.Met {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		return &PrerequisiteError{Missing: missing}
+	}
+	return nil
+}
+
+// HostPrerequisites checks the features on this host, in their order. Unknown features
+// are not met.
+func HostPrerequisites(features []v1.HostFeature) []v1.Prerequisite {
+	prerequisites := make([]v1.Prerequisite, 0, len(features))
+	for _, f := range features {
+		p := v1.Prerequisite{Feature: f}
+		if file := strings.TrimPrefix(string(f), v1.HostFeaturePathPrefix); file != string(f) {
+			p.Description = "the file " + file
+			p.Met, p.Detail = checkPath(file)
+		} else if c, ok := featureChecks[f]; ok {
+			p.Description = c.description
+			p.Met, p.Detail = c.check()
+		} else {
+			p.Description = "an unknown feature"
+			p.Detail = f.Validate().Error()
+		}
+		prerequisites = append(prerequisites, p)
+	}
+	return prerequisites
+}
+
+// PrerequisiteError is returned when the host lacks features the package requires.
+type PrerequisiteError struct {
+	Missing []v1.Prerequisite
+}
+
+func (e *PrerequisiteError) Error() string {
+	missing := make([]string, len(e.Missing))
+	for i, p := range e.Missing {
+		missing[i] = fmt.Sprintf("%s (%s): %s", p.Feature, p.Description, p.Detail)
+	}
+	return "the host lacks prerequisites of the package: " + strings.Join(missing, "; ")
+}
+
+// checkPath returns the first of the files which exists.
+func checkPath(paths ...string) (bool, string) {
+	for _, p := range paths {
+		if _, err := os.Stat(filepath.Join(hostRoot, p)); err == nil {
+			return true, p
+		}
+	}
+	return false, "no " + strings.Join(paths, " nor ")
+}
+
+// checkSocket returns the first of the files which is a socket.
+func checkSocket(paths ...string) (bool, string) {
+	for _, p := range paths {
+		if info, err := os.Stat(filepath.Join(hostRoot, p)); err == nil && info.Mode()&os.ModeSocket != 0 {
+			return true, p
+		}
+	}
+	return false, "no socket at " + strings.Join(paths, " nor ")
+}
+
+func checkCgroupV2() (bool, string) {
+	mode, err := detectCgroupMode()
+	switch {
+	case err != nil:
+		return false, err.Error()
+	case mode == CgroupV1:
+		return false, "only cgroup v1 hierarchies are mounted"
+	}
+	return true, fmt.Sprintf("%s cgroup hierarchies", mode)
+}
+
+// checkPMU looks for the event source of the PMU of the CPU: cpu on x86, cpu_core on hybrid
+// Intel CPUs and armv8_pmuv3 on arm64.
+func checkPMU() (bool, string) {
+	dir := "/sys/bus/event_source/devices"
+	entries, err := os.ReadDir(filepath.Join(hostRoot, dir))
+	if err != nil {
+		return false, fmt.Sprintf("no event sources in %s", dir)
+	}
+	for _, e := range entries {
+		if name := e.Name(); name == "cpu" || name == "cpu_core" || strings.HasPrefix(name, "armv8_pmuv3") || strings.HasPrefix(name, "armv7_") {
+			return true, filepath.Join(dir, name)
+		}
+	}
+	return false, fmt.Sprintf("no event source of the PMU of the CPU in %s, e.g. on a virtual machine without a virtual PMU", dir)
+}
+
+func checkBPFLSM() (bool, string) {
+	file := "/sys/kernel/security/lsm"
+	lsms, err := os.ReadFile(filepath.Join(hostRoot, file))
+	if err != nil {
+		return false, fmt.Sprintf("could not read the active LSMs from %s, securityfs must be mounted", file)
+	}
+	for _, lsm := range strings.Split(strings.TrimSpace(string(lsms)), ",") {
+		if lsm == "bpf" {
+			return true, file
+		}
+	}
+	return false, fmt.Sprintf("the active LSMs are %s, add bpf to the lsm= boot parameter", strings.TrimSpace(string(lsms)))
+}
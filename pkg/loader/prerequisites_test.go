@@ -0,0 +1,90 @@
+package loader
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Prerequisites", func() {
+	var (
+		origRoot string
+		origMode func() (CgroupMode, error)
+	)
+
+	BeforeEach(func() {
+		origRoot, origMode = hostRoot, detectCgroupMode
+		var err error
+		// short, for the path of the socket
+		hostRoot, err = os.MkdirTemp("", "bee")
+		Expect(err).NotTo(HaveOccurred())
+		detectCgroupMode = func() (CgroupMode, error) {
+			return CgroupV1, nil
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(hostRoot)
+		hostRoot, detectCgroupMode = origRoot, origMode
+	})
+
+	write := func(file, content string) {
+		Expect(os.MkdirAll(filepath.Dir(filepath.Join(hostRoot, file)), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(hostRoot, file), []byte(content), 0644)).To(Succeed())
+	}
+
+	It("checks the features of the host", func() {
+		write("/sys/bus/event_source/devices/cpu/type", "4\n")
+		write("/sys/kernel/security/lsm", "lockdown,capability,landlock,yama,apparmor\n")
+		write("/dev/kvm", "")
+		Expect(os.MkdirAll(filepath.Join(hostRoot, "/run/containerd"), 0755)).To(Succeed())
+		l, err := net.Listen("unix", filepath.Join(hostRoot, "/run/containerd/containerd.sock"))
+		Expect(err).NotTo(HaveOccurred())
+		defer l.Close()
+		write("/var/run/docker.sock", "not a socket")
+
+		Expect(HostPrerequisites([]v1.HostFeature{
+			v1.HostFeatureContainerdSocket, v1.HostFeaturePMU, "path:/dev/kvm",
+		})).To(Equal([]v1.Prerequisite{
+			{Feature: v1.HostFeatureContainerdSocket, Description: "the socket of containerd", Met: true, Detail: "/run/containerd/containerd.sock"},
+			{Feature: v1.HostFeaturePMU, Description: "the hardware performance counters of the CPU", Met: true, Detail: "/sys/bus/event_source/devices/cpu"},
+			{Feature: "path:/dev/kvm", Description: "the file /dev/kvm", Met: true, Detail: "/dev/kvm"},
+		}))
+		Expect(CheckPrerequisites([]v1.HostFeature{v1.HostFeatureContainerdSocket})).To(Succeed())
+
+		err = CheckPrerequisites([]v1.HostFeature{v1.HostFeatureCgroupV2, v1.HostFeatureDockerSocket, v1.HostFeatureBPFLSM, v1.HostFeatureBTF})
+		var prerequisites *PrerequisiteError
+		Expect(errors.As(err, &prerequisites)).To(BeTrue())
+		Expect(prerequisites.Missing).To(HaveLen(4))
+		Expect(err).To(MatchError("the host lacks prerequisites of the package: " +
+			"cgroup-v2 (the unified cgroup v2 hierarchy): only cgroup v1 hierarchies are mounted; " +
+			"docker-socket (the socket of docker): no socket at /var/run/docker.sock nor /run/docker.sock; " +
+			"bpf-lsm (the BPF LSM): the active LSMs are lockdown,capability,landlock,yama,apparmor, add bpf to the lsm= boot parameter; " +
+			"btf (the BTF of the kernel): no /sys/kernel/btf/vmlinux"))
+	})
+
+	It("reports the unknown features rather than failing", func() {
+		detectCgroupMode = func() (CgroupMode, error) {
+			return CgroupHybrid, nil
+		}
+		Expect(HostPrerequisites([]v1.HostFeature{v1.HostFeatureCgroupV2, "gpu"})).To(Equal([]v1.Prerequisite{
+			{Feature: v1.HostFeatureCgroupV2, Description: "the unified cgroup v2 hierarchy", Met: true, Detail: "hybrid cgroup hierarchies"},
+			{Feature: "gpu", Description: "an unknown feature", Detail: `unknown host feature "gpu", must be a path:/file or one of cgroup-v2, containerd-socket, docker-socket, crio-socket, pmu, btf, tracefs, bpf-lsm`},
+		}))
+	})
+
+	It("validates the features of configs", func() {
+		config := v1.EbpfConfig{Constraints: &v1.PlatformConstraints{Features: []v1.HostFeature{"path:dev/kvm"}}}
+		Expect(config.Validate()).To(MatchError("host feature path:dev/kvm must be an absolute path"))
+		config.Constraints.Features = []v1.HostFeature{v1.HostFeatureTracefs, "path:/dev/kvm"}
+		Expect(config.Validate()).To(Succeed())
+		Expect(config.HostFeatures()).To(HaveLen(2))
+		Expect(v1.EbpfConfig{}.HostFeatures()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,134 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/stats"
+)
+
+// Privacy exports only aggregates of the entries of a program, k-anonymized, so its raw events
+// never leave the node, for the deployments with strict data residency or privacy
+// requirements. It is enforced before the entries reach the watcher and the metrics, so it
+// holds whatever the sinks:
+//   - the events of ring buffers, perf event arrays, queues and stacks are counted by label
+//     set, and sent to the watcher as the entries of a hash map of their counts instead,
+//   - the label sets of events and counter maps are exported once they count MinCount
+//     events, the ones of fewer being held back until they do,
+//   - the quantiles of latency fields are exported over windows of MinCount events or more,
+//   - gauges and other hash maps are not exported, as their values are not counts their keys
+//     can be anonymized by, nor can raw events be recorded.
+type Privacy struct {
+	// Fewest events an exported label set or quantile aggregates, 1 if 0
+	MinCount uint64 `yaml:"minCount,omitempty"`
+}
+
+func (p *Privacy) minCount() uint64 {
+	if p == nil || p.MinCount == 0 {
+		return 1
+	}
+	return p.MinCount
+}
+
+// check returns why the options would let raw events leave the node.
+func (p *Privacy) check(opts *LoadOptions) error {
+	if p == nil {
+		return nil
+	}
+	if opts.Recorder != nil {
+		return errors.New("the raw events of a program can't be recorded in the aggregation only mode")
+	}
+	return nil
+}
+
+// holdsBack returns whether a count is too low to be exported.
+func (p *Privacy) holdsBack(count uint64) bool {
+	return p != nil && count < p.minCount()
+}
+
+// eventCounter counts the events of a map by label set, exporting the counts of the label
+// sets of MinCount events or more as the metric of the map and to the watcher as a hash map,
+// the events themselves being dropped.
+type eventCounter struct {
+	v1.MapWatcher
+	name     string
+	minCount uint64
+	inner    stats.IncrementInstrument
+
+	lock   sync.Mutex
+	keys   []string
+	counts map[string]uint64
+}
+
+func newEventCounter(name string, privacy *Privacy, inner stats.IncrementInstrument, watcher v1.MapWatcher) *eventCounter {
+	if inner == nil {
+		inner = &noop{}
+	}
+	return &eventCounter{
+		MapWatcher: watcher,
+		name:       name,
+		minCount:   privacy.minCount(),
+		inner:      inner,
+		counts:     map[string]uint64{},
+	}
+}
+
+// NewRingBuf declares the map of events as the hash map of their counts.
+func (c *eventCounter) NewRingBuf(name string, keys []string) {
+	c.lock.Lock()
+	c.keys = keys
+	c.lock.Unlock()
+	c.MapWatcher.NewHashMap(name, keys)
+}
+
+// SendEntry drops the events, their counts being sent by Increment.
+func (c *eventCounter) SendEntry(entry v1.MapEntry) {}
+
+// Close leaves the watcher open, as it is shared by the maps of the program.
+func (c *eventCounter) Close() {}
+
+func (c *eventCounter) Increment(ctx context.Context, labels map[string]string) {
+	c.lock.Lock()
+	key := c.key(labels)
+	c.counts[key]++
+	count := c.counts[key]
+	c.lock.Unlock()
+	if count < c.minCount {
+		return
+	}
+	// the events held back are counted once their label set is exported
+	increments := uint64(1)
+	if count == c.minCount {
+		increments = count
+	}
+	for i := uint64(0); i < increments; i++ {
+		c.inner.Increment(ctx, labels)
+	}
+	c.MapWatcher.SendEntry(v1.MapEntry{
+		Name:  c.name,
+		Entry: v1.KvPair{Key: labels, Value: strconv.FormatUint(count, 10)},
+	})
+}
+
+// key identifies a label set, in the order of the keys of the map. The lock must be held.
+func (c *eventCounter) key(labels map[string]string) string {
+	values := make([]string, len(c.keys))
+	for i, k := range c.keys {
+		values[i] = labels[k]
+	}
+	return strings.Join(values, "\x00")
+}
+
+// events returns the instrument and watcher of the events of a map: the given ones, or an
+// eventCounter counting them in the aggregation only mode.
+func (p *Privacy) events(name string, increment stats.IncrementInstrument, watcher v1.MapWatcher) (stats.IncrementInstrument, v1.MapWatcher) {
+	if p == nil {
+		return increment, watcher
+	}
+	counter := newEventCounter(name, p, increment, watcher)
+	return counter, counter
+}
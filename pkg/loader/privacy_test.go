@@ -0,0 +1,71 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type countingInstrument struct {
+	counts map[string]int
+}
+
+func (i *countingInstrument) Increment(ctx context.Context, labels map[string]string) {
+	i.counts[labels["comm"]]++
+}
+
+var _ = Describe("aggregation only mode", func() {
+	var (
+		ctx        context.Context
+		instrument *countingInstrument
+		watcher    *recordingWatcher
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		instrument = &countingInstrument{counts: map[string]int{}}
+		watcher = &recordingWatcher{}
+	})
+
+	It("exports the counts of the label sets of MinCount events only", func() {
+		increment, events := (&Privacy{MinCount: 3}).events("exec_events", instrument, watcher)
+		events.NewRingBuf("exec_events", []string{"comm"})
+		for _, comm := range []string{"curl", "curl", "ssh", "curl", "curl"} {
+			labels := map[string]string{"comm": comm}
+			increment.Increment(ctx, labels)
+			events.SendEntry(v1.MapEntry{Name: "exec_events", Entry: v1.KvPair{Key: labels}})
+		}
+		events.Close()
+
+		Expect(instrument.counts).To(Equal(map[string]int{"curl": 4}))
+		Expect(watcher.maps).To(Equal([]string{"exec_events"}))
+		Expect(watcher.entries).To(Equal([]v1.MapEntry{
+			{Name: "exec_events", Entry: v1.KvPair{Key: map[string]string{"comm": "curl"}, Value: "3"}},
+			{Name: "exec_events", Entry: v1.KvPair{Key: map[string]string{"comm": "curl"}, Value: "4"}},
+		}))
+		Expect(watcher.closed).To(BeFalse())
+	})
+
+	It("holds back the counts below MinCount", func() {
+		privacy := &Privacy{MinCount: 5}
+		Expect(privacy.holdsBack(4)).To(BeTrue())
+		Expect(privacy.holdsBack(5)).To(BeFalse())
+		Expect((&Privacy{}).holdsBack(1)).To(BeFalse())
+
+		var noPrivacy *Privacy
+		Expect(noPrivacy.holdsBack(0)).To(BeFalse())
+		increment, events := noPrivacy.events("exec_events", instrument, watcher)
+		Expect(increment).To(BeIdenticalTo(instrument))
+		Expect(events).To(BeIdenticalTo(watcher))
+	})
+
+	It("does not record raw events", func() {
+		privacy := &Privacy{MinCount: 5}
+		Expect(privacy.check(&LoadOptions{})).To(Succeed())
+		Expect(privacy.check(&LoadOptions{Recorder: NewRecorder(&bytes.Buffer{})})).To(MatchError(ContainSubstring("can't be recorded")))
+	})
+})
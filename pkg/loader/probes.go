@@ -0,0 +1,145 @@
+package loader
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// kprobeBlacklist lists the functions the kernel refuses to probe, when debugfs is mounted.
+const kprobeBlacklist = "/sys/kernel/debug/kprobes/blacklist"
+
+// dangerousProbe is a kernel function, or glob of functions, kprobes are refused on unless
+// allowed.
+type dangerousProbe struct {
+	pattern string
+	reason  string
+}
+
+const (
+	schedulerPath = "it runs on every context switch, a probe slows down every CPU of the node"
+	timekeeping   = "it is called on every clock read, a probe slows down the whole node"
+	allocator     = "it is called on every allocation of the kernel, a probe slows down the whole node"
+	locking       = "it is called on every lock of the kernel, a probe slows down the whole node and may deadlock"
+	reentrant     = "it runs BPF programs or their helpers, a probe may recurse or deadlock"
+)
+
+// dangerousProbes are the functions which are unsafe or too hot to probe. The functions are
+// matched with their suffixes, e.g. finish_task_switch.isra.0.
+var dangerousProbes = []dangerousProbe{
+	{"schedule", schedulerPath},
+	{"__schedule", schedulerPath},
+	{"schedule_idle", schedulerPath},
+	{"context_switch", schedulerPath},
+	{"__switch_to*", schedulerPath},
+	{"finish_task_switch*", schedulerPath},
+	{"pick_next_task*", schedulerPath},
+	{"put_prev_task*", schedulerPath},
+	{"enqueue_task*", schedulerPath},
+	{"dequeue_task*", schedulerPath},
+	{"update_curr*", schedulerPath},
+	{"update_rq_clock*", schedulerPath},
+	{"scheduler_tick", schedulerPath},
+	{"do_idle", schedulerPath},
+	{"cpuidle_*", schedulerPath},
+	{"ktime_get*", timekeeping},
+	{"sched_clock*", timekeeping},
+	{"native_sched_clock", timekeeping},
+	{"read_tsc", timekeeping},
+	{"__kmalloc*", allocator},
+	{"kmalloc*", allocator},
+	{"kfree", allocator},
+	{"kmem_cache_alloc*", allocator},
+	{"kmem_cache_free", allocator},
+	{"_raw_spin_lock*", locking},
+	{"_raw_spin_unlock*", locking},
+	{"_raw_read_lock*", locking},
+	{"_raw_write_lock*", locking},
+	{"queued_spin_lock_slowpath", locking},
+	{"__rcu_read_lock", locking},
+	{"__rcu_read_unlock", locking},
+	{"bpf_prog_run*", reentrant},
+	{"__bpf_prog_run*", reentrant},
+	{"__bpf_trace_*", reentrant},
+	{"bpf_trace_run*", reentrant},
+	{"bpf_ringbuf_*", reentrant},
+	{"bpf_perf_event_output*", reentrant},
+	{"perf_event_output*", reentrant},
+}
+
+// DangerousProbe returns why kprobes on the kernel function are refused unless dangerous
+// probes are allowed, empty if they are not refused.
+func DangerousProbe(symbol string) string {
+	base := symbol
+	if i := strings.Index(symbol, "."); i > 0 {
+		base = symbol[:i]
+	}
+	for _, probe := range dangerousProbes {
+		for _, name := range []string{symbol, base} {
+			if ok, _ := path.Match(probe.pattern, name); ok {
+				return probe.reason
+			}
+		}
+	}
+	return ""
+}
+
+// checkProbes returns an error if a kprobe or kretprobe of the spec is attached to a function
+// the kernel refuses to probe, or to a dangerous function unless allowed. The multi probes
+// are checked against all their functions rather than the one of their section.
+func checkProbes(spec *ebpf.CollectionSpec, multiProbes map[string]*ProbeTargets, allowDangerous bool, blacklist map[string]bool) error {
+	for name, prog := range spec.Programs {
+		if prog.Type != ebpf.Kprobe {
+			continue
+		}
+		symbols := []string{prog.AttachTo}
+		if targets, ok := multiProbes[name]; ok {
+			symbols = targets.Functions
+		}
+		for _, symbol := range symbols {
+			if blacklist[symbol] {
+				return fmt.Errorf("the kernel does not allow probing %s, which '%s' is attached to", symbol, prog.Name)
+			}
+			if allowDangerous {
+				continue
+			}
+			if reason := DangerousProbe(symbol); reason != "" {
+				return fmt.Errorf("refusing to attach '%s' to %s, %s: allow dangerous probes to attach it anyway", prog.Name, symbol, reason)
+			}
+		}
+	}
+	return nil
+}
+
+// readKprobeBlacklist returns the functions the kernel refuses to probe, nil if debugfs is not
+// mounted or readable.
+func readKprobeBlacklist() map[string]bool {
+	f, err := os.Open(kprobeBlacklist)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	blacklist, err := parseKprobeBlacklist(f)
+	if err != nil {
+		return nil
+	}
+	return blacklist
+}
+
+// parseKprobeBlacklist parses the "start-end symbol" lines of the kprobe blacklist.
+func parseKprobeBlacklist(r io.Reader) (map[string]bool, error) {
+	blacklist := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 {
+			blacklist[fields[1]] = true
+		}
+	}
+	return blacklist, scanner.Err()
+}
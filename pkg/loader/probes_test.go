@@ -0,0 +1,48 @@
+package loader
+
+import (
+	"strings"
+
+	"github.com/cilium/ebpf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dangerous probes", func() {
+	kprobe := func(symbol string) *ebpf.CollectionSpec {
+		return &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{
+			"probe": {Name: "probe", Type: ebpf.Kprobe, SectionName: "kprobe/" + symbol, AttachTo: symbol},
+		}}
+	}
+
+	It("matches the functions of the deny-list with their suffixes", func() {
+		Expect(DangerousProbe("__schedule")).To(ContainSubstring("context switch"))
+		Expect(DangerousProbe("finish_task_switch.isra.0")).To(ContainSubstring("context switch"))
+		Expect(DangerousProbe("ktime_get_ns")).NotTo(BeEmpty())
+		Expect(DangerousProbe("tcp_v4_connect")).To(BeEmpty())
+	})
+
+	It("refuses dangerous probes unless allowed", func() {
+		err := checkProbes(kprobe("__schedule"), nil, false, nil)
+		Expect(err).To(MatchError(ContainSubstring("refusing to attach 'probe' to __schedule")))
+		Expect(checkProbes(kprobe("__schedule"), nil, true, nil)).To(Succeed())
+		Expect(checkProbes(kprobe("tcp_v4_connect"), nil, false, nil)).To(Succeed())
+	})
+
+	It("refuses the functions of the kernel blacklist even if allowed", func() {
+		blacklist, err := parseKprobeBlacklist(strings.NewReader(
+			"0xffffffff81000000-0xffffffff81000010\tstartup_64\n0xffffffff81020000-0xffffffff81020040\tnmi_handle\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(blacklist).To(HaveKey("nmi_handle"))
+		err = checkProbes(kprobe("nmi_handle"), nil, true, blacklist)
+		Expect(err).To(MatchError(ContainSubstring("does not allow probing nmi_handle")))
+	})
+
+	It("ignores the programs other than kprobes", func() {
+		spec := &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{
+			"sched": {Name: "sched", Type: ebpf.TracePoint, SectionName: "tracepoint/sched/sched_switch", AttachTo: "sched/sched_switch"},
+		}}
+		Expect(checkProbes(spec, nil, false, nil)).To(Succeed())
+	})
+})
@@ -0,0 +1,238 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf/btf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/stats"
+)
+
+const (
+	quantilesKeyword = "quantiles"
+	durationTypeName = "duration"
+
+	// LatencyStatsSuffix is the suffix of the hash map the latency stats of the events of a
+	// `.quantiles` map are sent to the watcher as, keyed by field and stat
+	LatencyStatsSuffix = "_latency"
+
+	// the stats are computed over the events of the last minute, in slices of 10s
+	latencyWindow = time.Minute
+	latencySlices = 6
+	// interval the stats are exported at
+	latencyInterval = time.Second
+	// relative error of the quantiles
+	latencyAccuracy = 0.01
+)
+
+// latencyQuantiles are the quantiles exported for each latency field, by stat name.
+var latencyQuantiles = []struct {
+	stat     string
+	quantile float64
+}{
+	{"p50", 0.5},
+	{"p95", 0.95},
+	{"p99", 0.99},
+}
+
+// latencyGamma is the ratio of the bounds of the buckets of the histograms, so that the
+// middle of a bucket is within latencyAccuracy of all its values.
+var latencyGamma = (1 + latencyAccuracy) / (1 - latencyAccuracy)
+
+// getLatencyFields returns the members of the struct of an event map declaring the
+// `.quantiles` keyword which are typed as a duration.
+func getLatencyFields(structType *btf.Struct) []string {
+	var fields []string
+	for _, member := range structType.Members {
+		if typedef, ok := member.Type.(*btf.Typedef); ok && typedef.Name == durationTypeName {
+			fields = append(fields, member.Name)
+		}
+	}
+	return fields
+}
+
+// latencyHistogram counts durations in buckets growing exponentially, so any quantile is
+// estimated within latencyAccuracy whatever the range of the durations, in constant memory
+// for a given range.
+type latencyHistogram struct {
+	buckets map[int]uint64
+	// durations of 0, which are not bucketed
+	zeros uint64
+	count uint64
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{buckets: map[int]uint64{}}
+}
+
+func (h *latencyHistogram) add(d time.Duration) {
+	h.count++
+	if d <= 0 {
+		h.zeros++
+		return
+	}
+	h.buckets[int(math.Ceil(math.Log(float64(d))/math.Log(latencyGamma)))]++
+}
+
+func (h *latencyHistogram) merge(other *latencyHistogram) {
+	h.count += other.count
+	h.zeros += other.zeros
+	for bucket, n := range other.buckets {
+		h.buckets[bucket] += n
+	}
+}
+
+// quantile returns the estimated quantile of the durations, 0 if there are none.
+func (h *latencyHistogram) quantile(q float64) time.Duration {
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	if rank <= h.zeros {
+		return 0
+	}
+	buckets := make([]int, 0, len(h.buckets))
+	for bucket := range h.buckets {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+	seen := h.zeros
+	for _, bucket := range buckets {
+		seen += h.buckets[bucket]
+		if seen >= rank {
+			return time.Duration(2 * math.Pow(latencyGamma, float64(bucket)) / (latencyGamma + 1))
+		}
+	}
+	return 0
+}
+
+// latencySlice is a slice of the window of the stats.
+type latencySlice struct {
+	start  time.Time
+	events uint64
+	fields map[string]*latencyHistogram
+}
+
+// latencyStats computes the percentiles of the latency fields of the events of a map, and
+// their rate, over a sliding window, exporting the percentiles as gauges and sending them
+// to the watcher as a hash map.
+type latencyStats struct {
+	name   string
+	fields []string
+	gauges map[string]stats.SetInstrument
+	// fewest events the quantiles are exported over, see Privacy
+	minCount uint64
+
+	lock sync.Mutex
+	// oldest first, the last one being current
+	slices []*latencySlice
+	now    func() time.Time
+}
+
+// newLatencyStats returns the stats of the latency fields of the map, nil if it has none.
+func newLatencyStats(name string, fields []string, provider stats.MetricsProvider) *latencyStats {
+	if len(fields) == 0 {
+		return nil
+	}
+	s := &latencyStats{name: name, fields: fields, gauges: map[string]stats.SetInstrument{}, now: time.Now}
+	for _, field := range fields {
+		s.gauges[field] = provider.NewGauge(&stats.MetricOpts{Name: name + "_" + field + "_ns", Labels: []string{"quantile"}})
+	}
+	return s
+}
+
+// observe adds the latency fields of a decoded event.
+func (s *latencyStats) observe(decoded map[string]interface{}) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	slice := s.currentSlice()
+	slice.events++
+	for _, field := range s.fields {
+		d, ok := decoded[field].(time.Duration)
+		if !ok {
+			continue
+		}
+		h := slice.fields[field]
+		if h == nil {
+			h = newLatencyHistogram()
+			slice.fields[field] = h
+		}
+		h.add(d)
+	}
+}
+
+// currentSlice returns the slice of the current time, dropping the slices out of the window.
+// The lock must be held.
+func (s *latencyStats) currentSlice() *latencySlice {
+	now := s.now()
+	sliceDuration := latencyWindow / latencySlices
+	if n := len(s.slices); n == 0 || now.Sub(s.slices[n-1].start) >= sliceDuration {
+		s.slices = append(s.slices, &latencySlice{start: now.Truncate(sliceDuration), fields: map[string]*latencyHistogram{}})
+	}
+	for len(s.slices) > 0 && now.Sub(s.slices[0].start) >= latencyWindow {
+		s.slices = s.slices[1:]
+	}
+	return s.slices[len(s.slices)-1]
+}
+
+// snapshot returns the histogram of each field over the window, and the rate of the events.
+func (s *latencyStats) snapshot() (map[string]*latencyHistogram, float64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.currentSlice()
+	histograms := map[string]*latencyHistogram{}
+	for _, field := range s.fields {
+		histograms[field] = newLatencyHistogram()
+	}
+	var events uint64
+	for _, slice := range s.slices {
+		events += slice.events
+		for field, h := range slice.fields {
+			histograms[field].merge(h)
+		}
+	}
+	// the rate of the first events is not made up from a very short elapsed time
+	elapsed := s.now().Sub(s.slices[0].start)
+	if elapsed < latencyInterval {
+		elapsed = latencyInterval
+	}
+	return histograms, float64(events) / elapsed.Seconds()
+}
+
+// run exports the stats every latencyInterval until the context is done.
+func (s *latencyStats) run(ctx context.Context, watcher v1.MapWatcher) {
+	name := s.name + LatencyStatsSuffix
+	watcher.NewHashMap(name, []string{"field", "stat"})
+	ticker := time.NewTicker(latencyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		histograms, rate := s.snapshot()
+		for _, field := range s.fields {
+			h := histograms[field]
+			if h.count < s.minCount {
+				continue
+			}
+			for _, q := range latencyQuantiles {
+				value := h.quantile(q.quantile)
+				s.gauges[field].Set(ctx, int64(value), map[string]string{"quantile": strconv.FormatFloat(q.quantile, 'f', -1, 64)})
+				watcher.SendEntry(latencyEntry(name, field, q.stat, strconv.FormatInt(int64(value), 10)))
+			}
+			watcher.SendEntry(latencyEntry(name, field, "rate", fmt.Sprintf("%.2f", rate)))
+		}
+	}
+}
+
+func latencyEntry(name, field, stat, value string) v1.MapEntry {
+	return v1.MapEntry{Name: name, Entry: v1.KvPair{Key: map[string]string{"field": field, "stat": stat}, Value: value}}
+}
@@ -0,0 +1,59 @@
+package loader
+
+import (
+	"time"
+
+	"github.com/cilium/ebpf/btf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("latency quantiles", func() {
+	It("estimates the quantiles within their accuracy", func() {
+		h := newLatencyHistogram()
+		for i := 1; i <= 10000; i++ {
+			h.add(time.Duration(i) * time.Microsecond)
+		}
+		h.add(0)
+		for _, q := range []float64{0.5, 0.95, 0.99} {
+			exact := float64(time.Duration(q*10001) * time.Microsecond)
+			Expect(float64(h.quantile(q))).To(BeNumerically("~", exact, exact*(latencyAccuracy+0.001)))
+		}
+		Expect(h.quantile(0)).To(BeZero())
+		Expect(newLatencyHistogram().quantile(0.5)).To(BeZero())
+	})
+
+	It("computes the stats over the events of the window", func() {
+		now := time.Date(2026, 10, 14, 8, 0, 0, 0, time.UTC)
+		s := &latencyStats{name: "reqs", fields: []string{"latency"}, now: func() time.Time { return now }}
+		for i := 0; i < 100; i++ {
+			s.observe(map[string]interface{}{"latency": time.Second, "pid": uint32(1)})
+		}
+		now = now.Add(30 * time.Second)
+		for i := 0; i < 100; i++ {
+			s.observe(map[string]interface{}{"latency": time.Millisecond})
+		}
+		now = now.Add(10 * time.Second)
+		histograms, rate := s.snapshot()
+		Expect(histograms["latency"].count).To(Equal(uint64(200)))
+		Expect(float64(histograms["latency"].quantile(0.99))).To(BeNumerically("~", float64(time.Second), float64(10*time.Millisecond)))
+		Expect(rate).To(BeNumerically("~", 5.0))
+
+		// the slow events are out of the window
+		now = now.Add(25 * time.Second)
+		histograms, _ = s.snapshot()
+		Expect(histograms["latency"].count).To(Equal(uint64(100)))
+		Expect(float64(histograms["latency"].quantile(0.99))).To(BeNumerically("~", float64(time.Millisecond), float64(10*time.Microsecond)))
+	})
+
+	It("finds the latency fields of the events", func() {
+		duration := &btf.Typedef{Name: "duration", Type: &btf.Int{Name: "u64", Size: 8}}
+		event := &btf.Struct{Name: "event", Members: []btf.Member{
+			{Name: "pid", Type: &btf.Int{Name: "u32", Size: 4}},
+			{Name: "connect", Type: duration},
+			{Name: "first_byte", Type: duration},
+		}}
+		Expect(getLatencyFields(event)).To(Equal([]string{"connect", "first_byte"}))
+	})
+})
@@ -0,0 +1,242 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/solo-io/bumblebee/pkg/stats"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+const (
+	// SampleRateMapName is the map programs declare to be ramped up once attached: an array
+	// with a single u32, the percentage of the events the program handles. It is set to 100
+	// when the program is not ramped up.
+	SampleRateMapName = "bee_sample_rate"
+	// SampleRateMetric is the metric of the current sample rate of the program, in percent
+	SampleRateMetric = "bee_sample_rate"
+
+	fullSampleRate = 100
+)
+
+// RampOpts configure how the sample rate of a program is ramped up to 100% once attached, so
+// a program handling more events than expected is caught before it handles all of them.
+type RampOpts struct {
+	// Duration of the ramp up, the program starts at full rate if 0
+	Window time.Duration
+	// Sample rate the program starts at, in percent, defaults to 1
+	Start uint32
+	// Number of times the sample rate is raised within the window, defaults to 10
+	Steps int
+	// The ramp up is aborted once more events than this are lost during a step, not checked if 0
+	MaxLostEvents uint64
+	// The ramp up is aborted once the programs take more than this share of a CPU during a
+	// step, e.g. 0.05 for 5% of a CPU, as measured by the BPF stats. Not checked if 0
+	MaxCPU float64
+}
+
+// Validate returns an error if the options are out of range.
+func (o *RampOpts) Validate() error {
+	if o.Window < 0 {
+		return fmt.Errorf("invalid ramp window %s", o.Window)
+	}
+	if o.Start > fullSampleRate {
+		return fmt.Errorf("invalid ramp start %d%%, must be at most 100%%", o.Start)
+	}
+	if o.Steps < 0 {
+		return fmt.Errorf("invalid number of ramp steps %d", o.Steps)
+	}
+	if o.MaxCPU < 0 {
+		return fmt.Errorf("invalid ramp CPU threshold %v", o.MaxCPU)
+	}
+	return nil
+}
+
+func (o *RampOpts) initDefaults() {
+	if o.Start == 0 {
+		o.Start = 1
+	}
+	if o.Steps == 0 {
+		o.Steps = 10
+	}
+}
+
+// Ramp raises the sample rate of a program over the window of its options, after it is
+// attached. It is bound to the program by the loader, once loaded.
+type Ramp struct {
+	opts RampOpts
+
+	lock     sync.Mutex
+	programs map[string]*ebpf.Program
+}
+
+func NewRamp(opts RampOpts) *Ramp {
+	opts.initDefaults()
+	return &Ramp{opts: opts}
+}
+
+// bindPrograms records the programs loaded by this process, so the CPU they take can be
+// measured. It is not measured for the programs loaded by a helper.
+func (r *Ramp) bindPrograms(programs map[string]*ebpf.Program) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.programs = programs
+}
+
+// runtime returns the time the bound programs ran for, false if unknown.
+func (r *Ramp) runtime() (time.Duration, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.programs) == 0 {
+		return 0, false
+	}
+	var total time.Duration
+	for _, prog := range r.programs {
+		info, err := prog.Info()
+		if err != nil {
+			return 0, false
+		}
+		runtime, ok := info.Runtime()
+		if !ok {
+			return 0, false
+		}
+		total += runtime
+	}
+	return total, true
+}
+
+// rateMap is the sample rate map, an ebpf.Map once loaded.
+type rateMap interface {
+	Put(key, value interface{}) error
+}
+
+// startSampleRate sets the sample rate map of the program, if it declares one: to 100% if it
+// is not ramped up, and otherwise to the start of the ramp, which is then run until done.
+func startSampleRate(
+	ctx context.Context,
+	maps map[string]*ebpf.Map,
+	ramp *Ramp,
+	lost *lostTracker,
+	metricsProvider stats.MetricsProvider,
+) (func() error, error) {
+	ramping := ramp != nil && ramp.opts.Window > 0
+	rate, ok := maps[SampleRateMapName]
+	if !ok {
+		if ramping {
+			return nil, fmt.Errorf("ramping up the sample rate requires the program to declare a %s map", SampleRateMapName)
+		}
+		return nil, nil
+	}
+	if rate.Type() != ebpf.Array || rate.KeySize() != 4 || rate.ValueSize() != 4 || rate.MaxEntries() != 1 {
+		return nil, fmt.Errorf("the %s map must be an array of a single u32", SampleRateMapName)
+	}
+	instrument := metricsProvider.NewGauge(&stats.MetricOpts{Name: SampleRateMetric})
+	if !ramping {
+		return nil, setSampleRate(ctx, rate, instrument, fullSampleRate)
+	}
+	lostEvents := func() uint64 { return 0 }
+	if lost != nil {
+		lostEvents = lost.total
+	}
+	if err := setSampleRate(ctx, rate, instrument, ramp.opts.Start); err != nil {
+		return nil, err
+	}
+	return func() error {
+		if ramp.opts.MaxCPU > 0 {
+			// the run time of programs is only counted while enabled
+			if stats, err := ebpf.EnableStats(uint32(bpfStatsRunTime)); err == nil {
+				defer stats.Close()
+			} else {
+				contextutils.LoggerFrom(ctx).Warnf("could not enable the BPF stats, the CPU of the program is not checked while ramping up: %v", err)
+			}
+		}
+		return ramp.run(ctx, rate, instrument, lostEvents, time.Now)
+	}, nil
+}
+
+// rampRate returns the sample rate of the step, the rates rising linearly from the start.
+func (r *Ramp) rampRate(step int) uint32 {
+	if step >= r.opts.Steps {
+		return fullSampleRate
+	}
+	return r.opts.Start + (fullSampleRate-r.opts.Start)*uint32(step)/uint32(r.opts.Steps)
+}
+
+func setSampleRate(ctx context.Context, rate rateMap, instrument stats.SetInstrument, value uint32) error {
+	if err := rate.Put(uint32(0), value); err != nil {
+		return fmt.Errorf("could not set %s: %w", SampleRateMapName, err)
+	}
+	instrument.Set(ctx, int64(value), nil)
+	return nil
+}
+
+// run raises the sample rate of the program on every step of the ramp, once the thresholds
+// were not exceeded during the previous step. Otherwise the rate is set back to the one of
+// the previous step, and kept there.
+func (r *Ramp) run(
+	ctx context.Context,
+	rate rateMap,
+	instrument stats.SetInstrument,
+	lostEvents func() uint64,
+	now func() time.Time,
+) error {
+	logger := contextutils.LoggerFrom(ctx)
+	interval := r.opts.Window / time.Duration(r.opts.Steps)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastLost := lostEvents()
+	lastRuntime, measured := r.runtime()
+	if r.opts.MaxCPU > 0 && !measured {
+		logger.Warnf("the run time of the programs is unknown, their CPU is not checked while ramping up")
+	}
+	lastTime := now()
+	for step := 1; step <= r.opts.Steps; step++ {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+		current := r.rampRate(step - 1)
+		if r.opts.MaxLostEvents > 0 {
+			lost := lostEvents()
+			if lost-lastLost > r.opts.MaxLostEvents {
+				return r.abort(ctx, rate, instrument, step, fmt.Sprintf("%d events were lost at %d%%, more than the %d allowed", lost-lastLost, current, r.opts.MaxLostEvents))
+			}
+			lastLost = lost
+		}
+		if r.opts.MaxCPU > 0 && measured {
+			runtime, ok := r.runtime()
+			elapsed := now().Sub(lastTime)
+			if ok && elapsed > 0 {
+				if cpu := float64(runtime-lastRuntime) / float64(elapsed); cpu > r.opts.MaxCPU {
+					return r.abort(ctx, rate, instrument, step, fmt.Sprintf("the programs took %.1f%% of a CPU at %d%%, more than the %.1f%% allowed", cpu*100, current, r.opts.MaxCPU*100))
+				}
+			}
+			lastRuntime, measured = runtime, ok
+		}
+		lastTime = now()
+		next := r.rampRate(step)
+		if err := setSampleRate(ctx, rate, instrument, next); err != nil {
+			return err
+		}
+		logger.Infof("raised the sample rate of the program to %d%%", next)
+	}
+	return nil
+}
+
+// abort sets the sample rate back to the one of the step before the failed one.
+func (r *Ramp) abort(ctx context.Context, rate rateMap, instrument stats.SetInstrument, step int, reason string) error {
+	previous := r.opts.Start
+	if step >= 2 {
+		previous = r.rampRate(step - 2)
+	}
+	contextutils.LoggerFrom(ctx).Errorf("aborted the ramp up of the sample rate, keeping it at %d%%: %s", previous, reason)
+	return setSampleRate(ctx, rate, instrument, previous)
+}
@@ -0,0 +1,60 @@
+package loader
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// recordingRate records the sample rates set in the map.
+type recordingRate struct {
+	rates []uint32
+}
+
+func (r *recordingRate) Put(key, value interface{}) error {
+	r.rates = append(r.rates, value.(uint32))
+	return nil
+}
+
+var _ = Describe("Ramp", func() {
+	var (
+		ctx        = context.Background()
+		rate       *recordingRate
+		instrument *typeInstrument
+	)
+
+	BeforeEach(func() {
+		rate = &recordingRate{}
+		instrument = &typeInstrument{values: map[string]int64{}}
+	})
+
+	It("raises the sample rate linearly up to 100%", func() {
+		ramp := NewRamp(RampOpts{Window: 50 * time.Millisecond, Start: 10, Steps: 3})
+		Expect(ramp.run(ctx, rate, instrument, func() uint64 { return 0 }, time.Now)).To(Succeed())
+		Expect(rate.rates).To(Equal([]uint32{40, 70, 100}))
+		Expect(instrument.values).To(Equal(map[string]int64{"": 100}))
+	})
+
+	It("keeps the rate of the previous step once too many events are lost", func() {
+		var lost uint64
+		ramp := NewRamp(RampOpts{Window: 50 * time.Millisecond, Start: 10, Steps: 5, MaxLostEvents: 100})
+		Expect(ramp.run(ctx, rate, instrument, func() uint64 {
+			// losing 1000 events a step once above 40%
+			if len(rate.rates) >= 2 {
+				return atomic.AddUint64(&lost, 1000)
+			}
+			return 0
+		}, time.Now)).To(Succeed())
+		Expect(rate.rates).To(Equal([]uint32{28, 46, 28}))
+		Expect(instrument.values).To(Equal(map[string]int64{"": 28}))
+	})
+
+	It("rejects invalid options", func() {
+		Expect((&RampOpts{Start: 101}).Validate()).To(MatchError(ContainSubstring("at most 100%")))
+		Expect((&RampOpts{MaxCPU: -1}).Validate()).To(HaveOccurred())
+		Expect((&RampOpts{Window: time.Minute, MaxCPU: 0.05}).Validate()).To(Succeed())
+	})
+})
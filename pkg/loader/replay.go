@@ -0,0 +1,137 @@
+package loader
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+// Record is a raw event of a map, as written by the program, e.g. a ringbuf entry.
+type Record struct {
+	Map string `json:"map"`
+	// Hex encoded bytes of the event
+	Data string `json:"data"`
+}
+
+// Recorder writes the raw events read from ringbufs and perf event arrays as JSON lines
+// of Records, so they can be replayed without a kernel.
+type Recorder struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+func (r *Recorder) record(ctx context.Context, name string, raw []byte) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.enc.Encode(Record{Map: name, Data: hex.EncodeToString(raw)}); err != nil {
+		contextutils.LoggerFrom(ctx).Warnf("could not record event of map %s: %v", name, err)
+	}
+}
+
+// ReadRecords reads the JSON lines of Records written by a Recorder.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	// events are at most a ringbuf entry, but hex encoded
+	scanner.Buffer(nil, 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("invalid record on line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Replay decodes recorded events the same way as when the program runs, and sends them
+// to the watcher, e.g. the sinks, without loading the program. The maps of events are
+// announced to the watcher first, in the order of their first record.
+func Replay(
+	ctx context.Context,
+	decoderFactory decoder.DecoderFactory,
+	parsedELF *ParsedELF,
+	records []Record,
+	watcher v1.MapWatcher,
+) error {
+	r := newReplayer(decoderFactory, parsedELF, watcher)
+	for i, record := range records {
+		if err := r.send(ctx, i, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayer decodes records and sends them to a watcher, announcing their maps first.
+type replayer struct {
+	d         decoder.BinaryDecoder
+	parsedELF *ParsedELF
+	watcher   v1.MapWatcher
+	announced map[string]bool
+}
+
+func newReplayer(decoderFactory decoder.DecoderFactory, parsedELF *ParsedELF, watcher v1.MapWatcher) *replayer {
+	return &replayer{
+		d:         decoderFactory(),
+		parsedELF: parsedELF,
+		watcher:   watcher,
+		announced: map[string]bool{},
+	}
+}
+
+// send decodes the record i and sends it to the watcher.
+func (r *replayer) send(ctx context.Context, i int, record Record) error {
+	bpfMap, ok := r.parsedELF.WatchedMaps[record.Map]
+	if !ok {
+		return fmt.Errorf("record %d: the program has no map %s", i, record.Map)
+	}
+	switch bpfMap.mapType {
+	case ebpf.RingBuf, ebpf.PerfEventArray, ebpf.Queue, ebpf.Stack:
+	default:
+		return fmt.Errorf("record %d: only events can be replayed, map %s is a %s", i, record.Map, bpfMap.mapType)
+	}
+	raw, err := hex.DecodeString(record.Data)
+	if err != nil {
+		return fmt.Errorf("record %d: invalid data: %w", i, err)
+	}
+	// the decoder expects whole events
+	if size, err := btf.Sizeof(bpfMap.valueStruct); err == nil && len(raw) < size {
+		return fmt.Errorf("record %d: the events of map %s are %d bytes, not %d", i, record.Map, size, len(raw))
+	}
+	if !r.announced[record.Map] {
+		r.announced[record.Map] = true
+		r.watcher.NewRingBuf(record.Map, bpfMap.Labels)
+	}
+	result, err := r.d.DecodeBtfBinary(ctx, bpfMap.valueStruct, raw)
+	if err != nil {
+		return fmt.Errorf("record %d: could not decode event of map %s: %w", i, record.Map, err)
+	}
+	r.watcher.SendEntry(v1.MapEntry{
+		Name: record.Map,
+		Entry: v1.KvPair{
+			Key: bpfMap.labels.stringify(result),
+		},
+	})
+	return nil
+}
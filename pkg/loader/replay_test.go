@@ -0,0 +1,56 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"github.com/solo-io/bumblebee/pkg/golden"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replay", func() {
+	u32 := &btf.Int{Name: "u32", Size: 4, Bits: 32}
+	event := &btf.Struct{Name: "event", Size: 8, Members: []btf.Member{
+		{Name: "pid", Type: u32},
+		{Name: "daddr", Type: &btf.Typedef{Name: "ipv4_addr", Type: u32}, OffsetBits: 32},
+	}}
+	parsedELF := &ParsedELF{
+		WatchedMaps: map[string]WatchedMap{
+			"events": {Name: "events", Labels: []string{"pid", "daddr"}, mapType: ebpf.RingBuf, valueStruct: event},
+			"counts": {Name: "counts", mapType: ebpf.Hash},
+		},
+	}
+
+	It("decodes the recorded events", func() {
+		ctx := context.Background()
+		var buf bytes.Buffer
+		recorder := NewRecorder(&buf)
+		recorder.record(ctx, "events", []byte{42, 0, 0, 0, 10, 0, 0, 1})
+		recorder.record(ctx, "events", []byte{7, 0, 0, 0, 127, 0, 0, 1})
+		Expect(buf.String()).To(HavePrefix(`{"map":"events","data":"2a0000000a000001"}` + "\n"))
+
+		records, err := ReadRecords(&buf)
+		Expect(err).NotTo(HaveOccurred())
+		watcher := golden.NewWatcher()
+		Expect(Replay(ctx, decoder.NewDecoderFactory(), parsedELF, records, watcher)).To(Succeed())
+		Expect(watcher.Entries()).To(Equal([]golden.Entry{
+			{Map: "events", Key: map[string]string{"pid": "42", "daddr": "10.0.0.1"}},
+			{Map: "events", Key: map[string]string{"pid": "7", "daddr": "127.0.0.1"}},
+		}))
+	})
+
+	It("rejects records which are not events of the program", func() {
+		ctx := context.Background()
+		err := Replay(ctx, decoder.NewDecoderFactory(), parsedELF, []Record{{Map: "exits", Data: "00"}}, golden.NewWatcher())
+		Expect(err).To(MatchError(ContainSubstring("the program has no map exits")))
+		err = Replay(ctx, decoder.NewDecoderFactory(), parsedELF, []Record{{Map: "counts", Data: "00"}}, golden.NewWatcher())
+		Expect(err).To(MatchError(ContainSubstring("only events can be replayed")))
+		err = Replay(ctx, decoder.NewDecoderFactory(), parsedELF, []Record{{Map: "events", Data: "2a000000"}}, golden.NewWatcher())
+		Expect(err).To(MatchError(ContainSubstring("the events of map events are 8 bytes, not 4")))
+	})
+})
@@ -0,0 +1,94 @@
+package loader
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// AttachError reports an all-or-nothing attach of several programs which failed: the programs
+// attached before the failure are detached again, and their pins removed, so none of them is
+// left attached.
+type AttachError struct {
+	// Programs which failed to attach, by name
+	Failed map[string]error
+	// Programs attached before the failure, detached again, in the order they were attached
+	RolledBack []string
+	// Programs which were not attached, as the attach stopped at the failure
+	Skipped []string
+}
+
+func (e *AttachError) Error() string {
+	var parts []string
+	for _, name := range e.failed() {
+		parts = append(parts, fmt.Sprintf("could not attach '%s': %v", name, e.Failed[name]))
+	}
+	if len(e.RolledBack) > 0 {
+		parts = append(parts, "detached "+strings.Join(e.RolledBack, ", ")+" again")
+	}
+	if len(e.Skipped) > 0 {
+		parts = append(parts, "did not attach "+strings.Join(e.Skipped, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Unwrap returns the error of the first program which failed, by name.
+func (e *AttachError) Unwrap() error {
+	if failed := e.failed(); len(failed) > 0 {
+		return e.Failed[failed[0]]
+	}
+	return nil
+}
+
+func (e *AttachError) failed() []string {
+	names := make([]string, 0, len(e.Failed))
+	for name := range e.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pinRollback removes the pins an attach created if it fails, leaving the pins which existed
+// before it, e.g. the maps shared with a previous run.
+type pinRollback struct {
+	existing map[string]bool
+	dir      string
+	programs []*ebpf.Program
+}
+
+// newPinRollback records the pins of the maps of the directory, if any, before the attach.
+func newPinRollback(mapsDir string) *pinRollback {
+	r := &pinRollback{dir: mapsDir, existing: map[string]bool{}}
+	if mapsDir == "" {
+		return r
+	}
+	entries, _ := os.ReadDir(mapsDir)
+	for _, entry := range entries {
+		r.existing[entry.Name()] = true
+	}
+	return r
+}
+
+// pinnedProgram records a program pinned by the attach.
+func (r *pinRollback) pinnedProgram(prog *ebpf.Program) {
+	r.programs = append(r.programs, prog)
+}
+
+// rollback removes the pins of the programs, and of the maps created by the attach.
+func (r *pinRollback) rollback(maps map[string]*ebpf.Map) {
+	for _, prog := range r.programs {
+		prog.Unpin()
+	}
+	if r.dir == "" {
+		return
+	}
+	for name, m := range maps {
+		if !r.existing[name] && m.IsPinned() {
+			m.Unpin()
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package loader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("attach rollback", func() {
+	It("reports the failed, detached and skipped programs", func() {
+		errVerifier := errors.New("permission denied")
+		err := &AttachError{
+			Failed:     map[string]error{"probe_c": errVerifier, "probe_b": errors.New("no such tracepoint")},
+			RolledBack: []string{"probe_a"},
+			Skipped:    []string{"probe_d", "probe_e"},
+		}
+		Expect(err).To(MatchError("could not attach 'probe_b': no such tracepoint; could not attach 'probe_c': permission denied; " +
+			"detached probe_a again; did not attach probe_d, probe_e"))
+		Expect(errors.Unwrap(err)).To(MatchError("no such tracepoint"))
+		Expect(errors.Is(&AttachError{Failed: map[string]error{"probe_c": errVerifier}}, errVerifier)).To(BeTrue())
+	})
+
+	It("only removes the pins of the maps created by the attach", func() {
+		dir, err := os.MkdirTemp("", "bee-rollback")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		Expect(os.WriteFile(filepath.Join(dir, "shared"), nil, 0600)).To(Succeed())
+
+		pins := newPinRollback(dir)
+		Expect(pins.existing).To(Equal(map[string]bool{"shared": true}))
+		Expect(newPinRollback("").existing).To(BeEmpty())
+	})
+})
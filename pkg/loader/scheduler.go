@@ -0,0 +1,303 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LoadSchedulerOpts configure how ScheduleLoads loads programs, so the verifier work of the
+// programs an agent loads at boot does not take all the CPU of the node.
+type LoadSchedulerOpts struct {
+	// Programs loaded at the same time, 1 by default
+	Concurrency int `yaml:"concurrency,omitempty"`
+	// Share of its time each of the Concurrency loaders spends loading, between 0 and 1: once a
+	// load took d, the next one starts d*(1-budget)/budget later. Loads are not paced if 0.
+	CPUBudget float64 `yaml:"cpuBudget,omitempty"`
+	// Most tasks of priority 0 or less loaded at the same time, throttling bulk rollouts so
+	// they leave loaders to the tasks of higher priorities, Concurrency if 0
+	BulkConcurrency int `yaml:"bulkConcurrency,omitempty"`
+}
+
+// Validate returns an error if the concurrency or the CPU budget are out of range.
+func (o *LoadSchedulerOpts) Validate() error {
+	if o.Concurrency < 0 {
+		return fmt.Errorf("invalid load concurrency %d", o.Concurrency)
+	}
+	if o.CPUBudget < 0 || o.CPUBudget > 1 {
+		return fmt.Errorf("invalid load CPU budget %v, must be between 0 and 1", o.CPUBudget)
+	}
+	if o.BulkConcurrency < 0 {
+		return fmt.Errorf("invalid bulk load concurrency %d", o.BulkConcurrency)
+	}
+	return nil
+}
+
+// LoadTask is a program loaded by ScheduleLoads.
+type LoadTask struct {
+	Name string
+	// Tasks of higher priorities are loaded first, e.g. the critical programs of a node
+	Priority int
+	// Most time the task may take, queued and loading, its context being done beyond. No
+	// limit if 0
+	Timeout time.Duration
+	// Cancel a task of a lower priority in progress when no loader is free, e.g. to detach a
+	// misbehaving program rather than waiting for the pulls of a rollout. The task canceled
+	// is queued again, so its Load must be safe to run again once canceled
+	Preempt bool
+	Load    func(ctx context.Context) error
+}
+
+// LoadScheduler runs the tasks submitted to it by descending priority, the ones of the same
+// priority in the order submitted, at most Concurrency at a time and paced by the CPUBudget.
+type LoadScheduler struct {
+	opts LoadSchedulerOpts
+
+	lock sync.Mutex
+	seq  uint64
+	// by descending priority, then submission
+	queue   []*queuedLoad
+	running map[*queuedLoad]bool
+	// loaders loading or pausing
+	busy int
+	bulk int
+}
+
+type queuedLoad struct {
+	task LoadTask
+	seq  uint64
+	// done once the task is, or its timeout is over
+	request       context.Context
+	cancelRequest context.CancelFunc
+	// of the current attempt, canceled when preempted
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan error
+	// canceled to run a task of a higher priority
+	preempted bool
+	// the task it canceled, while it waits for a loader
+	victim *queuedLoad
+}
+
+// NewLoadScheduler returns a scheduler of the options, or an error if they are invalid.
+func NewLoadScheduler(opts LoadSchedulerOpts) (*LoadScheduler, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Concurrency == 0 {
+		opts.Concurrency = 1
+	}
+	if opts.BulkConcurrency == 0 || opts.BulkConcurrency > opts.Concurrency {
+		opts.BulkConcurrency = opts.Concurrency
+	}
+	return &LoadScheduler{opts: opts, running: map[*queuedLoad]bool{}}, nil
+}
+
+// Run queues the task and returns its error once loaded, or the one of its context if done
+// before.
+func (s *LoadScheduler) Run(ctx context.Context, task LoadTask) error {
+	return s.wait(s.submit(ctx, task))
+}
+
+// submit queues the task, starting it if a loader is free.
+func (s *LoadScheduler) submit(ctx context.Context, task LoadTask) *queuedLoad {
+	q := &queuedLoad{task: task, done: make(chan error, 1)}
+	if task.Timeout > 0 {
+		q.request, q.cancelRequest = context.WithTimeout(ctx, task.Timeout)
+	} else {
+		q.request, q.cancelRequest = context.WithCancel(ctx)
+	}
+	q.ctx, q.cancel = context.WithCancel(q.request)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.seq++
+	q.seq = s.seq
+	s.enqueue(q)
+	s.dispatch()
+	return q
+}
+
+func (s *LoadScheduler) wait(q *queuedLoad) error {
+	select {
+	case err := <-q.done:
+		return err
+	case <-q.request.Done():
+	}
+	s.lock.Lock()
+	for i, queued := range s.queue {
+		if queued == q {
+			// never started
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			s.lock.Unlock()
+			q.cancel()
+			return q.request.Err()
+		}
+	}
+	s.lock.Unlock()
+	// the load in progress returns once its context is done
+	return <-q.done
+}
+
+// enqueue must be called with the lock held
+func (s *LoadScheduler) enqueue(q *queuedLoad) {
+	i := sort.Search(len(s.queue), func(i int) bool {
+		queued := s.queue[i]
+		return queued.task.Priority < q.task.Priority || (queued.task.Priority == q.task.Priority && queued.seq > q.seq)
+	})
+	s.queue = append(s.queue, nil)
+	copy(s.queue[i+1:], s.queue[i:])
+	s.queue[i] = q
+}
+
+// dispatch starts the queued tasks while loaders are free, preempting the tasks of lower
+// priorities for the ones which preempt. It must be called with the lock held.
+func (s *LoadScheduler) dispatch() {
+	for i := 0; i < len(s.queue); {
+		q := s.queue[i]
+		if err := q.request.Err(); err != nil {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			q.cancel()
+			q.done <- err
+			continue
+		}
+		bulk := q.task.Priority <= 0
+		if s.busy >= s.opts.Concurrency || (bulk && s.bulk >= s.opts.BulkConcurrency) {
+			if q.task.Preempt {
+				s.preempt(q)
+			}
+			// the tasks of lower priorities wait for this one, but bulk ones for other bulk ones
+			if s.busy >= s.opts.Concurrency || !bulk {
+				return
+			}
+			i++
+			continue
+		}
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		s.busy++
+		if bulk {
+			s.bulk++
+		}
+		s.running[q] = true
+		go s.load(q)
+	}
+}
+
+// preempt cancels the running task of the lowest priority below the one of the task, unless
+// the task already canceled one. It must be called with the lock held.
+func (s *LoadScheduler) preempt(q *queuedLoad) {
+	if q.victim != nil && s.running[q.victim] {
+		return
+	}
+	var victim *queuedLoad
+	for running := range s.running {
+		if running.preempted || running.task.Preempt || running.task.Priority >= q.task.Priority {
+			continue
+		}
+		if victim == nil || running.task.Priority < victim.task.Priority || (running.task.Priority == victim.task.Priority && running.seq > victim.seq) {
+			victim = running
+		}
+	}
+	if victim != nil {
+		q.victim, victim.preempted = victim, true
+		victim.cancel()
+	}
+}
+
+func (s *LoadScheduler) load(q *queuedLoad) {
+	start := time.Now()
+	err := q.task.Load(q.ctx)
+	took := time.Since(start)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.running, q)
+	if q.preempted && err != nil && q.request.Err() == nil {
+		// loaded again with a new context
+		q.cancel()
+		q.ctx, q.cancel = context.WithCancel(q.request)
+		q.preempted = false
+		s.enqueue(q)
+		s.release(q, 0)
+		return
+	}
+	q.cancel()
+	q.cancelRequest()
+	q.done <- err
+	var pause time.Duration
+	if s.opts.CPUBudget > 0 {
+		pause = time.Duration(float64(took) * (1 - s.opts.CPUBudget) / s.opts.CPUBudget)
+	}
+	s.release(q, pause)
+}
+
+// release frees the loader of the task once paused, then starts the next tasks. It must be
+// called with the lock held.
+func (s *LoadScheduler) release(q *queuedLoad, pause time.Duration) {
+	free := func() {
+		s.busy--
+		if q.task.Priority <= 0 {
+			s.bulk--
+		}
+		s.dispatch()
+	}
+	if pause <= 0 {
+		free()
+		return
+	}
+	time.AfterFunc(pause, func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		free()
+	})
+}
+
+// ScheduleLoads loads the tasks by descending priority, the ones of the same priority in the
+// order given, at most Concurrency at a time and paced by the CPUBudget. Once a load failed no
+// other load starts and the loads in progress are canceled, so the programs loaded can be
+// unloaded right away, and the first error is returned once they returned.
+func ScheduleLoads(ctx context.Context, tasks []LoadTask, opts LoadSchedulerOpts) error {
+	scheduler, err := NewLoadScheduler(opts)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		lock     sync.Mutex
+		firstErr error
+	)
+	failed := func(err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	ordered := append([]LoadTask(nil), tasks...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Priority > ordered[j].Priority
+	})
+	queued := make([]*queuedLoad, len(ordered))
+	for i, task := range ordered {
+		load := task.Load
+		// canceled before the next load starts
+		task.Load = func(ctx context.Context) error {
+			err := load(ctx)
+			// unless preempted or out of time, reported once waited for
+			if err != nil && ctx.Err() == nil {
+				failed(err)
+			}
+			return err
+		}
+		queued[i] = scheduler.submit(ctx, task)
+	}
+	for _, q := range queued {
+		if err := scheduler.wait(q); err != nil {
+			failed(err)
+		}
+	}
+	return firstErr
+}
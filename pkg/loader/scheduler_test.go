@@ -0,0 +1,192 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Load scheduler", func() {
+	var (
+		lock  sync.Mutex
+		order []string
+	)
+
+	BeforeEach(func() {
+		order = nil
+	})
+
+	task := func(name string, priority int, err error) LoadTask {
+		return LoadTask{Name: name, Priority: priority, Load: func(context.Context) error {
+			lock.Lock()
+			defer lock.Unlock()
+			order = append(order, name)
+			return err
+		}}
+	}
+
+	It("loads the tasks by descending priority", func() {
+		tasks := []LoadTask{task("a", 0, nil), task("critical", 10, nil), task("b", 0, nil), task("c", 1, nil)}
+		Expect(ScheduleLoads(context.Background(), tasks, LoadSchedulerOpts{})).To(Succeed())
+		Expect(order).To(Equal([]string{"critical", "c", "a", "b"}))
+	})
+
+	It("loads at most concurrency tasks at a time", func() {
+		var running, maxRunning int
+		var tasks []LoadTask
+		for i := 0; i < 8; i++ {
+			tasks = append(tasks, LoadTask{Load: func(context.Context) error {
+				lock.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				lock.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				lock.Lock()
+				running--
+				lock.Unlock()
+				return nil
+			}})
+		}
+		Expect(ScheduleLoads(context.Background(), tasks, LoadSchedulerOpts{Concurrency: 3})).To(Succeed())
+		Expect(maxRunning).To(Equal(3))
+	})
+
+	It("starts no load once one failed", func() {
+		failure := errors.New("verifier rejected the program")
+		tasks := []LoadTask{task("a", 0, nil), task("b", 0, failure), task("c", 0, nil)}
+		Expect(ScheduleLoads(context.Background(), tasks, LoadSchedulerOpts{})).To(MatchError(failure))
+		Expect(order).To(Equal([]string{"a", "b"}))
+	})
+
+	It("paces the loads with the CPU budget", func() {
+		var tasks []LoadTask
+		for i := 0; i < 3; i++ {
+			tasks = append(tasks, LoadTask{Load: func(context.Context) error {
+				time.Sleep(20 * time.Millisecond)
+				return nil
+			}})
+		}
+		start := time.Now()
+		// each load is followed by a pause 3 times as long, but the last
+		Expect(ScheduleLoads(context.Background(), tasks, LoadSchedulerOpts{CPUBudget: 0.25})).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically(">=", 180*time.Millisecond))
+	})
+
+	It("cancels the loads in progress once one failed", func() {
+		failure := errors.New("verifier rejected the program")
+		canceled := make(chan error, 1)
+		tasks := []LoadTask{
+			{Name: "pull", Load: func(ctx context.Context) error {
+				<-ctx.Done()
+				canceled <- ctx.Err()
+				return ctx.Err()
+			}},
+			{Name: "exec", Load: func(context.Context) error {
+				time.Sleep(10 * time.Millisecond)
+				return failure
+			}},
+		}
+		Expect(ScheduleLoads(context.Background(), tasks, LoadSchedulerOpts{Concurrency: 2})).To(MatchError(failure))
+		Expect(canceled).To(Receive(MatchError(context.Canceled)))
+	})
+
+	It("times out the tasks, queued and loading", func() {
+		scheduler, err := NewLoadScheduler(LoadSchedulerOpts{})
+		Expect(err).NotTo(HaveOccurred())
+		release, started := make(chan struct{}), make(chan struct{})
+		defer close(release)
+		go scheduler.Run(context.Background(), LoadTask{Load: func(context.Context) error {
+			close(started)
+			<-release
+			return nil
+		}})
+		<-started
+		queued := LoadTask{Timeout: 20 * time.Millisecond, Load: func(context.Context) error {
+			Fail("the task should not start once out of time")
+			return nil
+		}}
+		Expect(scheduler.Run(context.Background(), queued)).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("preempts the tasks of lower priorities, which are loaded again", func() {
+		scheduler, err := NewLoadScheduler(LoadSchedulerOpts{})
+		Expect(err).NotTo(HaveOccurred())
+		started := make(chan struct{}, 2)
+		var attempts int
+		pulled := make(chan error, 1)
+		go func() {
+			pulled <- scheduler.Run(context.Background(), LoadTask{Name: "pull", Load: func(ctx context.Context) error {
+				lock.Lock()
+				attempts++
+				first := attempts == 1
+				lock.Unlock()
+				started <- struct{}{}
+				if !first {
+					return nil
+				}
+				<-ctx.Done()
+				return ctx.Err()
+			}})
+		}()
+		Eventually(started).Should(Receive())
+
+		detached := false
+		Expect(scheduler.Run(context.Background(), LoadTask{Name: "detach", Priority: 100, Preempt: true, Load: func(context.Context) error {
+			detached = true
+			return nil
+		}})).To(Succeed())
+		Expect(detached).To(BeTrue())
+		Eventually(pulled).Should(Receive(BeNil()))
+		Expect(attempts).To(Equal(2))
+	})
+
+	It("throttles the bulk tasks", func() {
+		scheduler, err := NewLoadScheduler(LoadSchedulerOpts{Concurrency: 3, BulkConcurrency: 1})
+		Expect(err).NotTo(HaveOccurred())
+		var running, maxBulk int
+		bulk := LoadTask{Load: func(context.Context) error {
+			lock.Lock()
+			running++
+			if running > maxBulk {
+				maxBulk = running
+			}
+			lock.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			lock.Lock()
+			running--
+			lock.Unlock()
+			return nil
+		}}
+		release := make(chan struct{})
+		critical := make(chan error, 1)
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				Expect(scheduler.Run(context.Background(), bulk)).To(Succeed())
+			}()
+		}
+		// a loader is left to the critical tasks while the bulk ones run
+		go func() {
+			critical <- scheduler.Run(context.Background(), LoadTask{Priority: 10, Load: func(context.Context) error {
+				<-release
+				return nil
+			}})
+		}()
+		wg.Wait()
+		close(release)
+		Eventually(critical).Should(Receive(BeNil()))
+		Expect(maxBulk).To(Equal(1))
+	})
+
+	It("rejects CPU budgets over 1", func() {
+		Expect(ScheduleLoads(context.Background(), nil, LoadSchedulerOpts{CPUBudget: 1.5})).To(MatchError(ContainSubstring("between 0 and 1")))
+	})
+})
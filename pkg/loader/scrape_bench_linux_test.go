@@ -0,0 +1,99 @@
+package loader
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"golang.org/x/sys/unix"
+)
+
+// BenchmarkScrape reads and decodes every entry of a counter hash map, as done on each
+// poll of the map.
+func BenchmarkScrape(b *testing.B) {
+	u32 := &btf.Int{Name: "u32", Size: 4, Bits: 32}
+	u64 := &btf.Int{Name: "u64", Size: 8, Bits: 64}
+	key := &btf.Struct{Name: "flow", Size: 8, Members: []btf.Member{
+		{Name: "daddr", Type: &btf.Typedef{Name: "ipv4_addr", Type: u32}},
+		{Name: "pid", Type: u32, OffsetBits: 32},
+	}}
+
+	for _, entries := range []int{1024, 16384} {
+		b.Run(fmt.Sprintf("entries=%d", entries), func(b *testing.B) {
+			m, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Hash, KeySize: 8, ValueSize: 8, MaxEntries: uint32(entries)})
+			if err != nil {
+				b.Skip("creating maps needs privileges: " + err.Error())
+			}
+			defer m.Close()
+			for i := 0; i < entries; i++ {
+				k := make([]byte, 8)
+				binary.LittleEndian.PutUint32(k, uint32(0x0a000000+i))
+				binary.LittleEndian.PutUint32(k[4:], uint32(i))
+				if err := m.Put(k, uint64(i)); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			ctx := context.Background()
+			d := decoder.NewDecoderFactory()()
+			reader := &mapReader{}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				raw, err := reader.read(m)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, entry := range raw {
+					decodedKey, err := d.DecodeBtfBinary(ctx, key, entry.key)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if _, err := d.DecodeBtfBinary(ctx, u64, entry.value); err != nil {
+						b.Fatal(err)
+					}
+					stringify(decodedKey)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkScrapeArray reads every entry of a BPF_F_MMAPABLE array, with lookups and
+// from the memory mapping of the array.
+func BenchmarkScrapeArray(b *testing.B) {
+	const entries = 16384
+	spec := &ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: entries, Flags: unix.BPF_F_MMAPABLE}
+	m, err := ebpf.NewMap(spec)
+	if err != nil {
+		b.Skip("creating mmapable maps needs privileges and linux 5.5: " + err.Error())
+	}
+	defer m.Close()
+	mmapped, err := newMmapReader(spec, m)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer mmapped.close()
+
+	for _, bench := range []struct {
+		name   string
+		reader entryReader
+	}{
+		{"lookup", &mapReader{}},
+		{"mmap", mmapped},
+	} {
+		reader := bench.reader
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := reader.read(m); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"github.com/solo-io/go-utils/contextutils"
+	"gopkg.in/yaml.v2"
+)
+
+// settingsMapType is the keyword of the section name of the maps holding the settings of a
+// program, e.g. `.maps.settings`, which are written by user space rather than by the program,
+// and can be seeded with LoadOptions.Seeds.
+const settingsMapType = "settings"
+
+func isSettingsMap(spec *ebpf.MapSpec) bool {
+	return hasKeyword(spec, settingsMapType)
+}
+
+// MapSeed seeds a settings map of the program with the entries of an external source before
+// the program is attached, then refreshes it while the program runs, so e.g. an IP allowlist
+// stays in sync with a list managed elsewhere.
+type MapSeed struct {
+	// Name of the settings map
+	Map string
+	// Source of the entries, e.g. its URL, as logged
+	Source string
+	// Fetch returns the entries of the map, as a YAML or JSON document: a mapping of the keys
+	// to their values, a sequence of keys, or one key per line, the values of the keys of a
+	// sequence or of lines being true, or 1. Structs are mappings of their fields, char arrays
+	// strings and ipv4_addr fields IP addresses.
+	Fetch func(ctx context.Context) ([]byte, error)
+	// Interval the source is fetched again at, the map is not refreshed if 0
+	Interval time.Duration
+}
+
+// checkSeeds returns an error if a seed is not the one of a settings map of the program.
+func checkSeeds(spec *ebpf.CollectionSpec, seeds []MapSeed) error {
+	for _, seed := range seeds {
+		mapSpec, ok := spec.Maps[seed.Map]
+		if !ok {
+			return fmt.Errorf("cannot seed map '%s', the program has no such map", seed.Map)
+		}
+		if !isSettingsMap(mapSpec) {
+			return fmt.Errorf("cannot seed map '%s', only the maps declared in a `.maps.%s` section can be", seed.Map, settingsMapType)
+		}
+		switch mapSpec.Type {
+		case ebpf.Hash, ebpf.LRUHash, ebpf.Array:
+		default:
+			return fmt.Errorf("cannot seed map '%s', only hash and array maps can be, not %s", seed.Map, mapSpec.Type)
+		}
+		if mapSpec.BTF == nil {
+			return fmt.Errorf("cannot seed map '%s', it has no BTF to encode its entries with", seed.Map)
+		}
+	}
+	return nil
+}
+
+// seedMaps fetches the sources of the seeds and replaces the entries of their maps, failing
+// on the first source which can't be fetched or read.
+func seedMaps(ctx context.Context, spec *ebpf.CollectionSpec, maps map[string]*ebpf.Map, seeds []MapSeed) error {
+	for _, seed := range seeds {
+		doc, err := seed.Fetch(ctx)
+		if err != nil {
+			return fmt.Errorf("could not fetch the seed of map '%s' from %s: %w", seed.Map, seed.Source, err)
+		}
+		n, err := syncMap(spec.Maps[seed.Map], maps[seed.Map], doc)
+		if err != nil {
+			return fmt.Errorf("could not seed map '%s' from %s: %w", seed.Map, seed.Source, err)
+		}
+		contextutils.LoggerFrom(ctx).Infof("seeded map %s with %d entries from %s", seed.Map, n, seed.Source)
+	}
+	return nil
+}
+
+// refreshSeed fetches the source of the seed every interval until the context is done,
+// replacing the entries of its map when the source changed. A failed refresh is logged and
+// keeps the previous entries.
+func refreshSeed(ctx context.Context, spec *ebpf.MapSpec, m *ebpf.Map, seed MapSeed) {
+	logger := contextutils.LoggerFrom(ctx)
+	var last []byte
+	ticker := time.NewTicker(seed.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		doc, err := seed.Fetch(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Warnf("could not refresh map %s from %s, keeping its entries: %v", seed.Map, seed.Source, err)
+			}
+			continue
+		}
+		if last != nil && bytes.Equal(doc, last) {
+			continue
+		}
+		n, err := syncMap(spec, m, doc)
+		if err != nil {
+			logger.Warnf("could not refresh map %s from %s: %v", seed.Map, seed.Source, err)
+			continue
+		}
+		last = doc
+		logger.Debugf("refreshed map %s with %d entries from %s", seed.Map, n, seed.Source)
+	}
+}
+
+type seedEntry struct {
+	key, value []byte
+}
+
+// syncMap replaces the entries of the map by the ones of the document, returning their
+// number. The document is encoded entirely before the map is written, so an invalid document
+// leaves the map as it is. The keys of hash maps missing from the document are deleted, and
+// the values of array maps reset.
+func syncMap(spec *ebpf.MapSpec, m *ebpf.Map, doc []byte) (int, error) {
+	entries, err := parseSeed(spec.BTF, m.KeySize(), m.ValueSize(), doc)
+	if err != nil {
+		return 0, err
+	}
+	return replaceEntries(m, entries)
+}
+
+// replaceEntries replaces the entries of the map by the encoded ones, returning their number.
+func replaceEntries(m *ebpf.Map, entries []seedEntry) (int, error) {
+	if uint32(len(entries)) > m.MaxEntries() {
+		return 0, fmt.Errorf("the source has %d entries, more than the %d of the map", len(entries), m.MaxEntries())
+	}
+	seeded := map[string]bool{}
+	for _, entry := range entries {
+		if err := m.Put(entry.key, entry.value); err != nil {
+			return 0, fmt.Errorf("could not write entry: %w", err)
+		}
+		seeded[string(entry.key)] = true
+	}
+
+	var stale [][]byte
+	key, value := make([]byte, m.KeySize()), make([]byte, m.ValueSize())
+	iter := m.Iterate()
+	for iter.Next(&key, &value) {
+		if !seeded[string(key)] {
+			stale = append(stale, append([]byte(nil), key...))
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return 0, fmt.Errorf("could not read the map: %w", err)
+	}
+	zero := make([]byte, m.ValueSize())
+	for _, key := range stale {
+		if m.Type() == ebpf.Array {
+			if err := m.Put(key, zero); err != nil {
+				return 0, fmt.Errorf("could not reset entry: %w", err)
+			}
+			continue
+		}
+		if err := m.Delete(key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return 0, fmt.Errorf("could not delete stale entry: %w", err)
+		}
+	}
+	return len(entries), nil
+}
+
+// parseSeed encodes the entries of the document with the BTF of the map.
+func parseSeed(typ *btf.Map, keySize, valueSize uint32, doc []byte) ([]seedEntry, error) {
+	var parsed interface{}
+	if err := yaml.Unmarshal(doc, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid document: %w", err)
+	}
+	var keys, values []interface{}
+	switch v := parsed.(type) {
+	case nil:
+	case map[interface{}]interface{}:
+		for key, value := range v {
+			keys, values = append(keys, key), append(values, value)
+		}
+	case []interface{}:
+		for _, key := range v {
+			keys, values = append(keys, key), append(values, true)
+		}
+	default:
+		// lines of plain keys are read as a single scalar, comments removed
+		for _, key := range strings.Fields(fmt.Sprint(v)) {
+			keys, values = append(keys, key), append(values, true)
+		}
+	}
+	return encodeEntries(typ, keySize, valueSize, keys, values)
+}
+
+// encodeEntries encodes the keys and their values, as decoded from YAML, with the BTF of the
+// map, sorted by key.
+func encodeEntries(typ *btf.Map, keySize, valueSize uint32, keys, values []interface{}) ([]seedEntry, error) {
+	entries := make([]seedEntry, 0, len(keys))
+	for i := range keys {
+		entry := seedEntry{key: make([]byte, keySize), value: make([]byte, valueSize)}
+		if err := encodeBTF(typ.Key, keys[i], entry.key); err != nil {
+			return nil, fmt.Errorf("invalid key %v: %w", keys[i], err)
+		}
+		if err := encodeBTF(typ.Value, values[i], entry.value); err != nil {
+			return nil, fmt.Errorf("invalid value of key %v: %w", keys[i], err)
+		}
+		entries = append(entries, entry)
+	}
+	// written in a stable order, the one of the keys
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+	for i := 1; i < len(entries); i++ {
+		if bytes.Equal(entries[i-1].key, entries[i].key) {
+			return nil, fmt.Errorf("duplicate key %v", entries[i].key)
+		}
+	}
+	return entries, nil
+}
+
+// encodeBTF encodes the YAML value as the type, into the buffer of its size.
+func encodeBTF(typ btf.Type, value interface{}, buf []byte) error {
+	if typedef, ok := typ.(*btf.Typedef); ok && typedef.Name == "ipv4_addr" {
+		s, _ := value.(string)
+		ip := net.ParseIP(s).To4()
+		if ip == nil || len(buf) != 4 {
+			return fmt.Errorf("%v is not an IPv4 address", value)
+		}
+		copy(buf, ip)
+		return nil
+	}
+	switch t := skipQualifiers(typ).(type) {
+	case *btf.Int:
+		return encodeInt(t, value, buf)
+	case *btf.Enum:
+		if s, ok := value.(string); ok {
+			for _, v := range t.Values {
+				if v.Name == s {
+					decoder.Endianess.PutUint32(buf, uint32(v.Value))
+					return nil
+				}
+			}
+		}
+		return encodeInt(&btf.Int{Size: 4, Encoding: btf.Signed}, value, buf)
+	case *btf.Array:
+		elem, ok := skipQualifiers(t.Type).(*btf.Int)
+		s, isString := value.(string)
+		if !ok || elem.Size != 1 || !isString {
+			return fmt.Errorf("%s must be a string", btfTypeName(typ))
+		}
+		if len(s) >= len(buf) {
+			return fmt.Errorf("%q must be shorter than %d bytes", s, len(buf))
+		}
+		copy(buf, s)
+		return nil
+	case *btf.Struct:
+		fields, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be a mapping of its fields", btfTypeName(typ))
+		}
+		for name, fieldValue := range fields {
+			member, ok := structMember(t, fmt.Sprint(name))
+			if !ok {
+				return fmt.Errorf("%s has no field %v", btfTypeName(typ), name)
+			}
+			if member.BitfieldSize > 0 || member.OffsetBits%8 != 0 {
+				return fmt.Errorf("field %s of %s is a bitfield, which can't be set", member.Name, btfTypeName(typ))
+			}
+			size, err := btf.Sizeof(member.Type)
+			if err != nil {
+				return err
+			}
+			offset := member.OffsetBits / 8
+			if err := encodeBTF(member.Type, fieldValue, buf[offset:offset+uint32(size)]); err != nil {
+				return fmt.Errorf("field %s: %w", member.Name, err)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("values of %s can't be set", btfTypeName(typ))
+}
+
+func structMember(s *btf.Struct, name string) (btf.Member, bool) {
+	for _, member := range s.Members {
+		if member.Name == name {
+			return member, true
+		}
+	}
+	return btf.Member{}, false
+}
+
+// encodeInt encodes an integer or boolean, given as a number, a boolean or a string, integers
+// being decimal, hexadecimal with a 0x prefix or octal with a 0 prefix.
+func encodeInt(t *btf.Int, value interface{}, buf []byte) error {
+	var u uint64
+	switch v := value.(type) {
+	case bool:
+		if v {
+			u = 1
+		}
+	case int:
+		u = uint64(v)
+	case uint64:
+		u = v
+	case string:
+		if t.Encoding&btf.Bool != 0 {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("%q must be true or false", v)
+			}
+			return encodeInt(t, b, buf)
+		}
+		var err error
+		if t.Encoding.IsSigned() {
+			var n int64
+			n, err = strconv.ParseInt(v, 0, 8*int(t.Size))
+			u = uint64(n)
+		} else {
+			u, err = strconv.ParseUint(v, 0, 8*int(t.Size))
+		}
+		if err != nil {
+			return fmt.Errorf("%q must be a %d bytes integer: %w", v, t.Size, err)
+		}
+	default:
+		return fmt.Errorf("%v must be an integer", value)
+	}
+	if t.Size < 8 {
+		// the bits above the size must be the sign extension or zeros
+		high := u >> (8 * t.Size)
+		signed := t.Encoding.IsSigned() && high == ^uint64(0)>>(8*t.Size)
+		if high != 0 && !signed {
+			return fmt.Errorf("%v overflows %d bytes", value, t.Size)
+		}
+	}
+	switch t.Size {
+	case 1:
+		buf[0] = uint8(u)
+	case 2:
+		decoder.Endianess.PutUint16(buf, uint16(u))
+	case 4:
+		decoder.Endianess.PutUint32(buf, uint32(u))
+	case 8:
+		decoder.Endianess.PutUint64(buf, u)
+	default:
+		return fmt.Errorf("integers of %d bytes can't be set", t.Size)
+	}
+	return nil
+}
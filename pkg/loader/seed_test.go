@@ -0,0 +1,117 @@
+package loader
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Map seeds", func() {
+	u32 := &btf.Int{Name: "u32", Size: 4}
+	u16 := &btf.Int{Name: "u16", Size: 2}
+	u8 := &btf.Int{Name: "u8", Size: 1}
+	ipv4 := &btf.Typedef{Name: "ipv4_addr", Type: u32}
+	rule := &btf.Struct{Name: "rule", Size: 8, Members: []btf.Member{
+		{Name: "port", Type: u16},
+		{Name: "comm", Type: &btf.Array{Type: u8, Nelems: 6}, OffsetBits: 16},
+	}}
+	allowlist := &ebpf.MapSpec{Name: "allowlist", Type: ebpf.Hash, KeySize: 4, ValueSize: 1, MaxEntries: 16,
+		SectionName: ".maps.settings", BTF: &btf.Map{Key: ipv4, Value: u8}}
+
+	It("only seeds the settings maps of the program", func() {
+		spec := &ebpf.CollectionSpec{Maps: map[string]*ebpf.MapSpec{
+			"allowlist": allowlist,
+			"events":    {Name: "events", Type: ebpf.Hash, SectionName: ".maps.counter", BTF: allowlist.BTF},
+		}}
+		Expect(checkSeeds(spec, []MapSeed{{Map: "allowlist"}})).To(Succeed())
+		Expect(checkSeeds(spec, []MapSeed{{Map: "events"}})).To(MatchError(ContainSubstring("only the maps declared in a `.maps.settings` section")))
+		Expect(checkSeeds(spec, []MapSeed{{Map: "missing"}})).To(MatchError(ContainSubstring("no such map")))
+	})
+
+	It("reads mappings, sequences and lines of keys", func() {
+		entries, err := parseSeed(allowlist.BTF, 4, 1, []byte("# office\n10.0.0.1\n10.0.0.2 # vpn\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(Equal([]seedEntry{
+			{key: []byte{10, 0, 0, 1}, value: []byte{1}},
+			{key: []byte{10, 0, 0, 2}, value: []byte{1}},
+		}))
+
+		entries, err = parseSeed(allowlist.BTF, 4, 1, []byte(`["10.0.0.2"]`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(Equal([]seedEntry{{key: []byte{10, 0, 0, 2}, value: []byte{1}}}))
+
+		entries, err = parseSeed(allowlist.BTF, 4, 1, []byte("10.0.0.3: 0x7\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(Equal([]seedEntry{{key: []byte{10, 0, 0, 3}, value: []byte{7}}}))
+
+		entries, err = parseSeed(allowlist.BTF, 4, 1, []byte(""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+	})
+
+	It("encodes structs with their fields", func() {
+		typ := &btf.Map{Key: u32, Value: rule}
+		entries, err := parseSeed(typ, 4, 8, []byte("1: {port: 443, comm: curl}\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].value).To(Equal([]byte{0xbb, 0x01, 'c', 'u', 'r', 'l', 0, 0}))
+
+		_, err = parseSeed(typ, 4, 8, []byte("1: {port: 70000}\n"))
+		Expect(err).To(MatchError(ContainSubstring("overflows 2 bytes")))
+		_, err = parseSeed(typ, 4, 8, []byte("1: {uid: 0}\n"))
+		Expect(err).To(MatchError(ContainSubstring("struct rule has no field uid")))
+		_, err = parseSeed(typ, 4, 8, []byte("1: {comm: toolongcomm}\n"))
+		Expect(err).To(MatchError(ContainSubstring("must be shorter than 6 bytes")))
+		_, err = parseSeed(typ, 4, 8, []byte("- 1\n"))
+		Expect(err).To(MatchError(ContainSubstring("must be a mapping of its fields")))
+		_, err = parseSeed(allowlist.BTF, 4, 1, []byte("- 10.0.0.256\n"))
+		Expect(err).To(MatchError(ContainSubstring("not an IPv4 address")))
+	})
+
+	It("replaces the entries of the map", func() {
+		m, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Hash, KeySize: 4, ValueSize: 1, MaxEntries: 16})
+		if err != nil {
+			Skip("creating maps needs privileges: " + err.Error())
+		}
+		defer m.Close()
+		Expect(m.Put([]byte{192, 168, 0, 1}, []byte{1})).To(Succeed())
+
+		spec := &ebpf.CollectionSpec{Maps: map[string]*ebpf.MapSpec{"allowlist": allowlist}}
+		fetched := "- 10.0.0.1\n- 10.0.0.2\n"
+		seed := MapSeed{Map: "allowlist", Source: "test", Fetch: func(context.Context) ([]byte, error) {
+			return []byte(fetched), nil
+		}}
+		Expect(seedMaps(context.Background(), spec, map[string]*ebpf.Map{"allowlist": m}, []MapSeed{seed})).To(Succeed())
+		keys := func() [][]byte {
+			var keys [][]byte
+			var key, value []byte
+			iter := m.Iterate()
+			for iter.Next(&key, &value) {
+				keys = append(keys, append([]byte(nil), key...))
+			}
+			Expect(iter.Err()).NotTo(HaveOccurred())
+			return keys
+		}
+		Expect(keys()).To(ConsistOf([]byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}))
+
+		// an invalid document leaves the map as it is
+		fetched = "- 10.0.0.1\n- nope\n"
+		_, err = syncMap(allowlist, m, []byte(fetched))
+		Expect(err).To(HaveOccurred())
+		Expect(keys()).To(HaveLen(2))
+
+		_, err = syncMap(allowlist, m, []byte("10.0.0.2\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys()).To(Equal([][]byte{{10, 0, 0, 2}}))
+
+		seed.Fetch = func(context.Context) ([]byte, error) {
+			return nil, errors.New("unreachable")
+		}
+		Expect(seedMaps(context.Background(), spec, map[string]*ebpf.Map{"allowlist": m}, []MapSeed{seed})).To(MatchError(ContainSubstring("could not fetch the seed of map 'allowlist' from test: unreachable")))
+	})
+})
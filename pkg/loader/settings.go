@@ -0,0 +1,51 @@
+package loader
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultPollInterval = time.Second
+
+// LiveSettings are the settings of a loaded program which can be changed while it runs.
+type LiveSettings struct {
+	// Interval hash, array, queue and stack maps are read at, defaults to 1s
+	PollInterval time.Duration
+	// Hash map keys whose value has not changed for this long stop being exported, 0 disables eviction
+	StaleKeyTTL time.Duration
+}
+
+// Settings holds the current LiveSettings of a loaded program, the maps being watched
+// pick up changes on their next read.
+type Settings struct {
+	lock     sync.RWMutex
+	settings LiveSettings
+}
+
+func NewSettings(settings LiveSettings) *Settings {
+	return &Settings{settings: settings}
+}
+
+func (s *Settings) Get() LiveSettings {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.settings
+}
+
+func (s *Settings) Set(settings LiveSettings) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.settings = settings
+}
+
+// liveSettings returns the current settings of the options, with defaults applied.
+func (o *LoadOptions) liveSettings() LiveSettings {
+	settings := LiveSettings{StaleKeyTTL: o.StaleKeyTTL}
+	if o.Settings != nil {
+		settings = o.Settings.Get()
+	}
+	if settings.PollInterval <= 0 {
+		settings.PollInterval = defaultPollInterval
+	}
+	return settings
+}
@@ -0,0 +1,284 @@
+package loader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+// SnapshotVersion is the version of the format written by ExportMaps
+const SnapshotVersion = 1
+
+// Snapshot is the contents of the maps of a program, along with their schema so it can
+// be checked against the program it is imported into.
+type Snapshot struct {
+	Version int           `json:"version"`
+	Maps    []MapSnapshot `json:"maps"`
+}
+
+type MapSnapshot struct {
+	Name       string       `json:"name"`
+	Type       string       `json:"type"`
+	KeySize    uint32       `json:"keySize"`
+	ValueSize  uint32       `json:"valueSize"`
+	MaxEntries uint32       `json:"maxEntries"`
+	Key        *TypeSchema  `json:"key,omitempty"`
+	Value      *TypeSchema  `json:"value,omitempty"`
+	Entries    []EntryBytes `json:"entries"`
+}
+
+// EntryBytes is an entry as stored by the kernel, in the byte order of the node.
+type EntryBytes struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// TypeSchema is the layout of a key or value, from the BTF of the program.
+type TypeSchema struct {
+	Name   string        `json:"name,omitempty"`
+	Size   int           `json:"size"`
+	Fields []FieldSchema `json:"fields,omitempty"`
+}
+
+type FieldSchema struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Offset in bytes within the struct
+	Offset uint32 `json:"offset"`
+	// Size in bits of the field, if it is a bitfield
+	BitfieldSize uint32 `json:"bitfieldSize,omitempty"`
+}
+
+// SnapshotMaps returns the names of the maps of a program holding state which can be
+// exported, in order. Events, per-CPU maps and read-only data are left out.
+func SnapshotMaps(spec *ebpf.CollectionSpec) []string {
+	var names []string
+	for name, m := range spec.Maps {
+		if strings.HasSuffix(name, ".rodata") {
+			continue
+		}
+		switch m.Type {
+		case ebpf.Hash, ebpf.Array, ebpf.LRUHash:
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OpenPinnedMaps opens the maps of a program pinned in the given directory, e.g. by
+// `bee run --pin-maps`. Only the maps which can be snapshotted are opened.
+func OpenPinnedMaps(spec *ebpf.CollectionSpec, dir string) (map[string]*ebpf.Map, error) {
+	maps := map[string]*ebpf.Map{}
+	for _, name := range SnapshotMaps(spec) {
+		m, err := ebpf.LoadPinnedMap(filepath.Join(dir, name), nil)
+		if errors.Is(err, os.ErrNotExist) {
+			closeMaps(maps)
+			return nil, fmt.Errorf("map '%s' is not pinned in %s, is the program running with --pin-maps?", name, dir)
+		}
+		if err != nil {
+			closeMaps(maps)
+			return nil, fmt.Errorf("could not open pinned map '%s': %w", name, err)
+		}
+		maps[name] = m
+	}
+	return maps, nil
+}
+
+func closeMaps(maps map[string]*ebpf.Map) {
+	for _, m := range maps {
+		m.Close()
+	}
+}
+
+// ExportMaps writes the contents of the maps of a program holding state, e.g. allowlists or
+// learned baselines, so they can be imported on another node or after a reinstall.
+func ExportMaps(ctx context.Context, spec *ebpf.CollectionSpec, maps map[string]*ebpf.Map, w io.Writer) error {
+	snapshot := Snapshot{Version: SnapshotVersion}
+	for _, name := range SnapshotMaps(spec) {
+		m, ok := maps[name]
+		if !ok {
+			return fmt.Errorf("map '%s' is not loaded", name)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		mapSnapshot := newMapSnapshot(name, spec.Maps[name])
+		mapSnapshot.KeySize, mapSnapshot.ValueSize, mapSnapshot.MaxEntries = m.KeySize(), m.ValueSize(), m.MaxEntries()
+		var key, value []byte
+		iter := m.Iterate()
+		for iter.Next(&key, &value) {
+			mapSnapshot.Entries = append(mapSnapshot.Entries, EntryBytes{
+				Key:   append([]byte(nil), key...),
+				Value: append([]byte(nil), value...),
+			})
+		}
+		if err := iter.Err(); err != nil {
+			return fmt.Errorf("could not read map '%s': %w", name, err)
+		}
+		contextutils.LoggerFrom(ctx).Debugf("exported %d entries of map %s", len(mapSnapshot.Entries), name)
+		snapshot.Maps = append(snapshot.Maps, mapSnapshot)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(snapshot)
+}
+
+// ImportMaps adds the entries of a snapshot to the maps of a program, overwriting existing
+// keys. Every map of the snapshot must exist in the program with the same layout, maps of
+// the program missing from the snapshot are left as they are.
+func ImportMaps(ctx context.Context, spec *ebpf.CollectionSpec, maps map[string]*ebpf.Map, r io.Reader) error {
+	var snapshot Snapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return fmt.Errorf("could not decode snapshot: %w", err)
+	}
+	if snapshot.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d, expected %d", snapshot.Version, SnapshotVersion)
+	}
+	// check all the maps before writing any of them
+	for _, mapSnapshot := range snapshot.Maps {
+		if err := checkSnapshot(spec, maps, mapSnapshot); err != nil {
+			return fmt.Errorf("cannot import map '%s': %w", mapSnapshot.Name, err)
+		}
+	}
+	for _, mapSnapshot := range snapshot.Maps {
+		m := maps[mapSnapshot.Name]
+		for _, entry := range mapSnapshot.Entries {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err := m.Put(entry.Key, entry.Value); err != nil {
+				return fmt.Errorf("could not import entry of map '%s': %w", mapSnapshot.Name, err)
+			}
+		}
+		contextutils.LoggerFrom(ctx).Debugf("imported %d entries of map %s", len(mapSnapshot.Entries), mapSnapshot.Name)
+	}
+	return nil
+}
+
+// checkSnapshot returns an error if the map of the snapshot cannot be imported into the
+// program, as it would be misread by it.
+func checkSnapshot(spec *ebpf.CollectionSpec, maps map[string]*ebpf.Map, mapSnapshot MapSnapshot) error {
+	mapSpec, ok := spec.Maps[mapSnapshot.Name]
+	m, loaded := maps[mapSnapshot.Name]
+	if !ok || !loaded {
+		return errors.New("the program has no such map")
+	}
+	expected := newMapSnapshot(mapSnapshot.Name, mapSpec)
+	switch {
+	case expected.Type != mapSnapshot.Type:
+		return fmt.Errorf("it is a %s in the program, not a %s", expected.Type, mapSnapshot.Type)
+	case m.KeySize() != mapSnapshot.KeySize || m.ValueSize() != mapSnapshot.ValueSize:
+		return fmt.Errorf("its keys and values are %d and %d bytes in the program, not %d and %d",
+			m.KeySize(), m.ValueSize(), mapSnapshot.KeySize, mapSnapshot.ValueSize)
+	case !reflect.DeepEqual(expected.Key, mapSnapshot.Key):
+		return errors.New("its key has a different layout in the program")
+	case !reflect.DeepEqual(expected.Value, mapSnapshot.Value):
+		return errors.New("its value has a different layout in the program")
+	case uint32(len(mapSnapshot.Entries)) > m.MaxEntries():
+		return fmt.Errorf("it has %d entries, more than the %d the program allows", len(mapSnapshot.Entries), m.MaxEntries())
+	}
+	for _, entry := range mapSnapshot.Entries {
+		if uint32(len(entry.Key)) != mapSnapshot.KeySize || uint32(len(entry.Value)) != mapSnapshot.ValueSize {
+			return errors.New("it has entries of the wrong size")
+		}
+	}
+	return nil
+}
+
+// newMapSnapshot returns an empty snapshot of the map, with the schema from its spec.
+func newMapSnapshot(name string, m *ebpf.MapSpec) MapSnapshot {
+	mapSnapshot := MapSnapshot{
+		Name:       name,
+		Type:       m.Type.String(),
+		KeySize:    m.KeySize,
+		ValueSize:  m.ValueSize,
+		MaxEntries: m.MaxEntries,
+		Entries:    []EntryBytes{},
+	}
+	if m.BTF != nil {
+		mapSnapshot.Key = newTypeSchema(m.BTF.Key)
+		mapSnapshot.Value = newTypeSchema(m.BTF.Value)
+	}
+	return mapSnapshot
+}
+
+func newTypeSchema(typ btf.Type) *TypeSchema {
+	if typ == nil {
+		return nil
+	}
+	size, _ := btf.Sizeof(typ)
+	schema := &TypeSchema{Name: btfTypeName(typ), Size: size}
+	var members []btf.Member
+	switch t := skipQualifiers(typ).(type) {
+	case *btf.Struct:
+		members = t.Members
+	case *btf.Union:
+		members = t.Members
+	}
+	for _, member := range members {
+		schema.Fields = append(schema.Fields, FieldSchema{
+			Name:         member.Name,
+			Type:         btfTypeName(member.Type),
+			Offset:       member.OffsetBits / 8,
+			BitfieldSize: member.BitfieldSize,
+		})
+	}
+	return schema
+}
+
+// skipQualifiers returns the type a typedef or qualifier refers to.
+func skipQualifiers(typ btf.Type) btf.Type {
+	for {
+		switch t := typ.(type) {
+		case *btf.Typedef:
+			typ = t.Type
+		case *btf.Volatile:
+			typ = t.Type
+		case *btf.Const:
+			typ = t.Type
+		case *btf.Restrict:
+			typ = t.Type
+		default:
+			return typ
+		}
+	}
+}
+
+// btfTypeName returns the C name of a type, e.g. u32, struct event or char[16].
+func btfTypeName(typ btf.Type) string {
+	switch t := typ.(type) {
+	case *btf.Array:
+		return fmt.Sprintf("%s[%d]", btfTypeName(t.Type), t.Nelems)
+	case *btf.Pointer:
+		return btfTypeName(t.Target) + "*"
+	case *btf.Volatile:
+		return btfTypeName(t.Type)
+	case *btf.Const:
+		return btfTypeName(t.Type)
+	case *btf.Restrict:
+		return btfTypeName(t.Type)
+	case *btf.Struct:
+		return strings.TrimSpace("struct " + t.Name)
+	case *btf.Union:
+		return strings.TrimSpace("union " + t.Name)
+	case *btf.Enum:
+		return strings.TrimSpace("enum " + t.Name)
+	case btf.NamedType:
+		if t.TypeName() != "" {
+			return t.TypeName()
+		}
+	}
+	return "void"
+}
@@ -0,0 +1,79 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Map snapshots", func() {
+	u32 := &btf.Int{Name: "u32", Size: 4}
+	u8 := &btf.Int{Name: "u8", Size: 1}
+	key := &btf.Struct{Name: "flow", Size: 8, Members: []btf.Member{
+		{Name: "saddr", Type: &btf.Typedef{Name: "ipv4_addr", Type: u32}},
+		{Name: "comm", Type: &btf.Array{Type: u8, Nelems: 4}, OffsetBits: 32},
+	}}
+	program := func() *ebpf.CollectionSpec {
+		return &ebpf.CollectionSpec{
+			Maps: map[string]*ebpf.MapSpec{
+				"allowed":    {Name: "allowed", Type: ebpf.Hash, KeySize: 8, ValueSize: 4, MaxEntries: 16, BTF: &btf.Map{Key: key, Value: u32}},
+				"events":     {Name: "events", Type: ebpf.RingBuf, MaxEntries: 4096},
+				"per_cpu":    {Name: "per_cpu", Type: ebpf.PerCPUArray, KeySize: 4, ValueSize: 8, MaxEntries: 1},
+				"bee.rodata": {Name: "bee.rodata", Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1},
+			},
+		}
+	}
+
+	It("only exports maps holding state", func() {
+		Expect(SnapshotMaps(program())).To(Equal([]string{"allowed"}))
+	})
+
+	It("describes the layout of keys and values", func() {
+		snapshot := newMapSnapshot("allowed", program().Maps["allowed"])
+		Expect(snapshot.Type).To(Equal("Hash"))
+		Expect(snapshot.Key).To(Equal(&TypeSchema{Name: "struct flow", Size: 8, Fields: []FieldSchema{
+			{Name: "saddr", Type: "ipv4_addr", Offset: 0},
+			{Name: "comm", Type: "u8[4]", Offset: 4},
+		}}))
+		Expect(snapshot.Value).To(Equal(&TypeSchema{Name: "u32", Size: 4}))
+	})
+
+	It("rejects snapshots which do not match the program", func() {
+		import_ := func(snapshot Snapshot) error {
+			data, err := json.Marshal(snapshot)
+			Expect(err).NotTo(HaveOccurred())
+			return ImportMaps(context.Background(), program(), map[string]*ebpf.Map{}, bytes.NewReader(data))
+		}
+		Expect(import_(Snapshot{Version: 2})).To(MatchError(ContainSubstring("unsupported snapshot version")))
+		Expect(import_(Snapshot{Version: SnapshotVersion, Maps: []MapSnapshot{{Name: "denied"}}})).
+			To(MatchError(ContainSubstring("no such map")))
+	})
+
+	It("exports and imports the entries of maps", func() {
+		spec := program()
+		load := func() map[string]*ebpf.Map {
+			m, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Hash, KeySize: 8, ValueSize: 4, MaxEntries: 16})
+			if err != nil {
+				Skip("creating maps needs privileges: " + err.Error())
+			}
+			return map[string]*ebpf.Map{"allowed": m}
+		}
+		exported, imported := load(), load()
+		defer closeMaps(exported)
+		defer closeMaps(imported)
+		Expect(exported["allowed"].Put([]byte{10, 0, 0, 1, 'c', 'u', 'r', 'l'}, uint32(7))).To(Succeed())
+
+		var buf bytes.Buffer
+		Expect(ExportMaps(context.Background(), spec, exported, &buf)).To(Succeed())
+		Expect(ImportMaps(context.Background(), spec, imported, &buf)).To(Succeed())
+		var value uint32
+		Expect(imported["allowed"].Lookup([]byte{10, 0, 0, 1, 'c', 'u', 'r', 'l'}, &value)).To(Succeed())
+		Expect(value).To(Equal(uint32(7)))
+	})
+})
@@ -0,0 +1,461 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/go-utils/contextutils"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultSpoolMaxSize       = 256 * 1024 * 1024
+	defaultSpoolRetryInterval = 10 * time.Second
+	defaultSpoolReplayRate    = 1000
+
+	// the spool is written in this many segments, the oldest one being dropped at once
+	spoolSegments = 8
+	segmentSuffix = ".jsonl"
+)
+
+// DropPolicy is which entries a full spool drops.
+type DropPolicy string
+
+const (
+	// DropOldest drops the oldest segment of the spool, an eighth of it, as a ring buffer
+	DropOldest DropPolicy = "drop-oldest"
+	// DropNewest drops the entries sent once the spool is full
+	DropNewest DropPolicy = "drop-newest"
+)
+
+// ParseDropPolicy parses a drop policy, defaulting to drop-oldest.
+func ParseDropPolicy(s string) (DropPolicy, error) {
+	switch p := DropPolicy(s); p {
+	case DropOldest, DropNewest:
+		return p, nil
+	case "":
+		return DropOldest, nil
+	}
+	return "", fmt.Errorf("unknown drop policy '%s', must be one of drop-oldest or drop-newest", s)
+}
+
+// SpoolOptions are where NewSpool spools the entries, and how many.
+type SpoolOptions struct {
+	// Directory of the spool, the entries left in it are replayed by the next run
+	Dir string `yaml:"dir"`
+	// Size of the spooled entries, e.g. 64MiB, defaults to 256MiB
+	MaxSize string `yaml:"maxSize,omitempty"`
+	// Entries dropped once the spool is full, drop-oldest or drop-newest, defaults to drop-oldest
+	DropPolicy string `yaml:"dropPolicy,omitempty"`
+	// Interval the sinks are probed at while they are down, defaults to 10s
+	RetryInterval time.Duration `yaml:"retryInterval,omitempty"`
+	// Entries per second replayed once a sink is back, defaults to 1000
+	ReplayRate float64 `yaml:"replayRate,omitempty"`
+}
+
+// spoolSegment is a file of the spool, a JSON line per entry.
+type spoolSegment struct {
+	path    string
+	size    int64
+	entries int
+	// entries already replayed, if the sinks went down again while replaying it
+	replayed int
+}
+
+type spool struct {
+	ctx           context.Context
+	watcher       v1.MapWatcher
+	reporters     []v1.HealthReporter
+	dir           string
+	maxSize       int64
+	policy        DropPolicy
+	retryInterval time.Duration
+	limiter       *rate.Limiter
+
+	lock     sync.Mutex
+	ringbufs map[string]bool
+	// whether the events are spooled rather than sent, until the spool is replayed
+	spooling bool
+	// oldest first, the events being written to the last one if file is set
+	segments  []*spoolSegment
+	file      *os.File
+	replaying *spoolSegment
+	size      int64
+	next      uint64
+	// the last spooled event, sent to probe the sinks while they are down
+	last    *v1.MapEntry
+	dropped uint64
+	closed  bool
+
+	stop context.CancelFunc
+	done chan struct{}
+	// done once the spool is closed
+	stopCtx context.Context
+}
+
+// NewSpool returns a MapWatcher sending the entries to the watcher, but spooling the events of
+// ring buffers to a bounded directory while all the sinks of the reporters are down, e.g. on a
+// network partition, and replaying them once a sink is back. While they are down, the last
+// spooled event is sent every RetryInterval to probe them, so it may be delivered twice.
+// The entries of hash maps are sent as is, as they are current state rather than events.
+// The watcher is closed along with the spool.
+func NewSpool(ctx context.Context, watcher v1.MapWatcher, reporters []v1.HealthReporter, opts SpoolOptions) (v1.MapWatcher, error) {
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("the directory of the spool is required")
+	}
+	maxSize := int64(defaultSpoolMaxSize)
+	if opts.MaxSize != "" {
+		var err error
+		if maxSize, err = units.RAMInBytes(opts.MaxSize); err != nil || maxSize <= 0 {
+			return nil, fmt.Errorf("invalid spool max size %s", opts.MaxSize)
+		}
+	}
+	policy, err := ParseDropPolicy(opts.DropPolicy)
+	if err != nil {
+		return nil, err
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = defaultSpoolRetryInterval
+	}
+	if opts.ReplayRate <= 0 {
+		opts.ReplayRate = defaultSpoolReplayRate
+	}
+	if err := os.MkdirAll(opts.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create spool directory: %w", err)
+	}
+	s := &spool{
+		ctx:           ctx,
+		watcher:       watcher,
+		reporters:     reporters,
+		dir:           opts.Dir,
+		maxSize:       maxSize,
+		policy:        policy,
+		retryInterval: opts.RetryInterval,
+		limiter:       rate.NewLimiter(rate.Limit(opts.ReplayRate), 1),
+		ringbufs:      map[string]bool{},
+		done:          make(chan struct{}),
+	}
+	if err := s.loadSegments(); err != nil {
+		return nil, fmt.Errorf("could not read spool directory: %w", err)
+	}
+	if len(s.segments) > 0 {
+		contextutils.LoggerFrom(ctx).Infof("replaying the events left in the spool %s", s.dir)
+		s.spooling = true
+	}
+	s.stopCtx, s.stop = context.WithCancel(context.Background())
+	go s.run()
+	return s, nil
+}
+
+// loadSegments adds the segments left by a previous run.
+func (s *spool) loadSegments() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	var seqs []uint64
+	for _, file := range files {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), segmentSuffix), 10, 64)
+		if err != nil || !strings.HasSuffix(file.Name(), segmentSuffix) || file.IsDir() {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	for _, seq := range seqs {
+		path := s.segmentPath(seq)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, &spoolSegment{path: path, size: int64(len(data)), entries: bytes.Count(data, []byte("\n"))})
+		s.size += int64(len(data))
+		s.next = seq + 1
+	}
+	return nil
+}
+
+func (s *spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, segmentSuffix))
+}
+
+func (s *spool) NewRingBuf(name string, keys []string) {
+	s.lock.Lock()
+	s.ringbufs[name] = true
+	s.lock.Unlock()
+	s.watcher.NewRingBuf(name, keys)
+}
+
+func (s *spool) NewHashMap(name string, keys []string) {
+	s.watcher.NewHashMap(name, keys)
+}
+
+func (s *spool) SendEntry(entry v1.MapEntry) {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	if !s.ringbufs[entry.Name] {
+		s.lock.Unlock()
+		s.watcher.SendEntry(entry)
+		return
+	}
+	if !s.spooling && s.sinksDown() {
+		contextutils.LoggerFrom(s.ctx).Warnf("all the sinks are down, spooling the events to %s", s.dir)
+		s.spooling = true
+	}
+	if s.spooling {
+		s.spoolEntry(entry)
+		s.lock.Unlock()
+		return
+	}
+	s.lock.Unlock()
+	s.watcher.SendEntry(entry)
+}
+
+// Close stops replaying the spool, leaving the entries not replayed yet to the next run.
+func (s *spool) Close() {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	s.lock.Unlock()
+	s.stop()
+	<-s.done
+
+	s.lock.Lock()
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	var left int
+	for _, seg := range s.segments {
+		if seg.entries == 0 {
+			os.Remove(seg.path)
+		}
+		left += seg.entries - seg.replayed
+	}
+	s.lock.Unlock()
+	if left > 0 {
+		contextutils.LoggerFrom(s.ctx).Warnf("%d events are left in the spool %s, they are replayed by the next run", left, s.dir)
+	}
+	s.watcher.Close()
+}
+
+// sinksDown returns whether all the sinks are down, false if none reports its health.
+func (s *spool) sinksDown() bool {
+	for _, reporter := range s.reporters {
+		if reporter.Healthy() {
+			return false
+		}
+	}
+	return len(s.reporters) > 0
+}
+
+// spoolEntry writes the entry to the last segment, making room for it as per the drop policy.
+// The lock must be held.
+func (s *spool) spoolEntry(entry v1.MapEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		s.dropped++
+		return
+	}
+	line = append(line, '\n')
+	for s.size+int64(len(line)) > s.maxSize {
+		if s.policy != DropOldest || !s.dropOldestSegment() {
+			s.dropped++
+			return
+		}
+	}
+	if s.file == nil || s.segments[len(s.segments)-1].size >= s.maxSize/spoolSegments {
+		if err := s.rotate(); err != nil {
+			contextutils.LoggerFrom(s.ctx).Errorf("could not create spool segment: %v", err)
+			s.dropped++
+			return
+		}
+	}
+	seg := s.segments[len(s.segments)-1]
+	if _, err := s.file.Write(line); err != nil {
+		contextutils.LoggerFrom(s.ctx).Errorf("could not write to spool segment %s: %v", seg.path, err)
+		s.dropped++
+		return
+	}
+	seg.size += int64(len(line))
+	seg.entries++
+	s.size += int64(len(line))
+	s.last = &entry
+}
+
+// rotate closes the last segment and starts a new one. The lock must be held.
+func (s *spool) rotate() error {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	path := s.segmentPath(s.next)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	s.next++
+	s.file = f
+	s.segments = append(s.segments, &spoolSegment{path: path})
+	return nil
+}
+
+// dropOldestSegment removes the oldest segment which is neither written to nor replayed, and
+// returns false if there is none. The lock must be held.
+func (s *spool) dropOldestSegment() bool {
+	for i, seg := range s.segments {
+		if seg == s.replaying || (s.file != nil && i == len(s.segments)-1) {
+			continue
+		}
+		s.removeSegment(seg)
+		s.dropped += uint64(seg.entries - seg.replayed)
+		return true
+	}
+	return false
+}
+
+// removeSegment removes the segment and its file. The lock must be held.
+func (s *spool) removeSegment(seg *spoolSegment) {
+	for i := range s.segments {
+		if s.segments[i] == seg {
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			break
+		}
+	}
+	if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+		contextutils.LoggerFrom(s.ctx).Warnf("could not remove spool segment %s: %v", seg.path, err)
+	}
+	s.size -= seg.size
+}
+
+func (s *spool) run() {
+	defer close(s.done)
+	logger := contextutils.LoggerFrom(s.ctx)
+	ticker := time.NewTicker(s.retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCtx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.lock.Lock()
+		spooling, last, dropped := s.spooling, s.last, s.dropped
+		s.dropped = 0
+		s.lock.Unlock()
+		if dropped > 0 {
+			logger.Warnf("dropped %d events, the spool %s is full", dropped, s.dir)
+		}
+		if !spooling {
+			continue
+		}
+		if !s.sinksDown() {
+			s.replay()
+		} else if last != nil {
+			s.watcher.SendEntry(*last)
+		}
+	}
+}
+
+// replay sends the spooled events to the sinks, oldest first, until none is left or the sinks
+// are down again. The events sent meanwhile are spooled after them, so the order is kept.
+func (s *spool) replay() {
+	for {
+		s.lock.Lock()
+		seg := s.nextReplayed()
+		if seg == nil {
+			s.spooling, s.last = false, nil
+			s.lock.Unlock()
+			contextutils.LoggerFrom(s.ctx).Infof("replayed the events of the spool %s", s.dir)
+			return
+		}
+		s.replaying = seg
+		s.lock.Unlock()
+
+		replayed := s.replaySegment(seg)
+		s.lock.Lock()
+		s.replaying = nil
+		if replayed {
+			s.removeSegment(seg)
+		}
+		s.lock.Unlock()
+		if !replayed {
+			return
+		}
+	}
+}
+
+// nextReplayed returns the oldest segment, closing it if it is written to, or nil once the
+// spool is empty. The lock must be held.
+func (s *spool) nextReplayed() *spoolSegment {
+	if len(s.segments) == 0 {
+		return nil
+	}
+	seg := s.segments[0]
+	if s.file != nil && len(s.segments) == 1 {
+		s.file.Close()
+		s.file = nil
+		if seg.entries == 0 {
+			s.removeSegment(seg)
+			return nil
+		}
+	}
+	return seg
+}
+
+// replaySegment sends the events of the segment not replayed yet, and returns false if it
+// stopped as the sinks are down again or the spool is closed.
+func (s *spool) replaySegment(seg *spoolSegment) bool {
+	logger := contextutils.LoggerFrom(s.ctx)
+	f, err := os.Open(seg.path)
+	if err != nil {
+		logger.Errorf("could not read spool segment %s, dropping it: %v", seg.path, err)
+		return true
+	}
+	defer f.Close()
+	s.lock.Lock()
+	skip := seg.replayed
+	s.lock.Unlock()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 4*1024*1024)
+	for i := 0; scanner.Scan(); i++ {
+		if i < skip {
+			continue
+		}
+		if err := s.limiter.Wait(s.stopCtx); err != nil || s.sinksDown() {
+			return false
+		}
+		var entry v1.MapEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logger.Warnf("skipping invalid event of spool segment %s: %v", seg.path, err)
+		} else {
+			s.watcher.SendEntry(entry)
+		}
+		s.lock.Lock()
+		seg.replayed = i + 1
+		s.lock.Unlock()
+	}
+	if err := scanner.Err(); err != nil {
+		logger.Errorf("could not read spool segment %s, dropping the rest of it: %v", seg.path, err)
+	}
+	return true
+}
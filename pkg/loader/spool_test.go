@@ -0,0 +1,167 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+// outageSink drops the entries while down, and is back once it receives an entry after
+// recover is called, as a webhook answering again.
+type outageSink struct {
+	noopWatcher
+	lock      sync.Mutex
+	down      bool
+	recovered bool
+	entries   []string
+}
+
+func (s *outageSink) SendEntry(entry v1.MapEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.down && !s.recovered {
+		return
+	}
+	s.down = false
+	s.entries = append(s.entries, entry.Name+"/"+entry.Entry.Key["pid"])
+}
+
+func (s *outageSink) Healthy() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return !s.down
+}
+
+func (s *outageSink) fail() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.down, s.recovered = true, false
+}
+
+func (s *outageSink) recover() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.recovered = true
+}
+
+func (s *outageSink) received() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.entries...)
+}
+
+func spoolEvent(name string, pid int) v1.MapEntry {
+	return v1.MapEntry{Name: name, Entry: v1.KvPair{Key: map[string]string{"pid": fmt.Sprint(pid)}}}
+}
+
+// spooledPids returns the pids of the events left in the segments of the spool.
+func spooledPids(dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	Expect(err).NotTo(HaveOccurred())
+	var pids []string
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		Expect(err).NotTo(HaveOccurred())
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			if i := strings.Index(line, `"pid":"`); i >= 0 {
+				pids = append(pids, strings.SplitN(line[i+7:], `"`, 2)[0])
+			}
+		}
+	}
+	return pids
+}
+
+var _ = Describe("spool", func() {
+	var (
+		dir  string
+		sink *outageSink
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "spool")
+		Expect(err).NotTo(HaveOccurred())
+		sink = &outageSink{}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	newSpool := func(opts SpoolOptions) v1.MapWatcher {
+		opts.Dir = dir
+		opts.RetryInterval = 10 * time.Millisecond
+		opts.ReplayRate = 10000
+		spool, err := NewSpool(context.Background(), sink, []v1.HealthReporter{sink}, opts)
+		Expect(err).NotTo(HaveOccurred())
+		spool.NewRingBuf("events", []string{"pid"})
+		spool.NewHashMap("counts", []string{"pid"})
+		return spool
+	}
+
+	It("spools the events while the sinks are down and replays them in order once they are back", func() {
+		spool := newSpool(SpoolOptions{})
+		defer spool.Close()
+		spool.SendEntry(spoolEvent("events", 1))
+
+		sink.fail()
+		spool.SendEntry(spoolEvent("events", 2))
+		spool.SendEntry(spoolEvent("counts", 3))
+		spool.SendEntry(spoolEvent("events", 4))
+		Expect(spooledPids(dir)).To(Equal([]string{"2", "4"}))
+		Consistently(sink.received, "50ms").Should(Equal([]string{"events/1"}))
+
+		// the probe is delivered, then the spool is replayed
+		sink.recover()
+		Eventually(sink.received).Should(Equal([]string{"events/1", "events/4", "events/2", "events/4"}))
+		Eventually(func() []string { return spooledPids(dir) }).Should(BeEmpty())
+
+		spool.SendEntry(spoolEvent("events", 5))
+		Expect(sink.received()).To(ContainElement("events/5"))
+	})
+
+	It("drops the oldest events once full, and replays the spool on the next run", func() {
+		spool := newSpool(SpoolOptions{MaxSize: "1KiB"})
+		sink.fail()
+		for pid := 0; pid < 40; pid++ {
+			spool.SendEntry(spoolEvent("events", pid))
+		}
+		spool.Close()
+		pids := spooledPids(dir)
+		Expect(len(pids)).To(BeNumerically("<", 40))
+		Expect(pids).NotTo(ContainElement("0"))
+		Expect(pids[len(pids)-1]).To(Equal("39"))
+
+		sink = &outageSink{}
+		spool = newSpool(SpoolOptions{MaxSize: "1KiB"})
+		defer spool.Close()
+		Eventually(func() int { return len(sink.received()) }).Should(Equal(len(pids)))
+		Expect(sink.received()[0]).To(Equal("events/" + pids[0]))
+	})
+
+	It("drops the newest events once full if configured", func() {
+		spool := newSpool(SpoolOptions{MaxSize: "1KiB", DropPolicy: "drop-newest"})
+		sink.fail()
+		for pid := 0; pid < 40; pid++ {
+			spool.SendEntry(spoolEvent("events", pid))
+		}
+		spool.Close()
+		pids := spooledPids(dir)
+		Expect(pids[0]).To(Equal("0"))
+		Expect(pids).NotTo(ContainElement("39"))
+	})
+
+	It("rejects unknown drop policies", func() {
+		_, err := NewSpool(context.Background(), sink, nil, SpoolOptions{Dir: dir, DropPolicy: "drop-all"})
+		Expect(err).To(MatchError("unknown drop policy 'drop-all', must be one of drop-oldest or drop-newest"))
+	})
+})
@@ -0,0 +1,99 @@
+package loader
+
+import (
+	"sort"
+	"time"
+)
+
+// trackedKey is a hash map key observed while polling the map.
+type trackedKey struct {
+	raw     []byte
+	labels  map[string]string
+	value   uint64
+	updated time.Time
+	// whether the key was present in the latest iteration of the map
+	seen bool
+	// whether the key went stale and is no longer exported
+	expired bool
+}
+
+// staleKeyTracker tracks when the value of each key of a hash map last changed,
+// so keys which stopped updating (e.g. exited PIDs, closed connections) can be evicted.
+type staleKeyTracker struct {
+	ttl  time.Duration
+	keys map[string]*trackedKey
+	// keys missing from an iteration are not considered removed, used for
+	// consumed maps where keys are deleted every time they are read
+	keepAbsent bool
+	// most keys kept absent, the ones updated the longest ago going stale beyond, so the keys
+	// of consumed maps are bounded even without a ttl. Unbounded if 0.
+	maxAbsent int
+}
+
+func newStaleKeyTracker(ttl time.Duration, keepAbsent bool, maxAbsent int) *staleKeyTracker {
+	return &staleKeyTracker{
+		ttl:        ttl,
+		keys:       map[string]*trackedKey{},
+		keepAbsent: keepAbsent,
+		maxAbsent:  maxAbsent,
+	}
+}
+
+// observe records the current value of a key, and returns whether it should still be exported.
+// An expired key is exported again as soon as its value changes.
+func (s *staleKeyTracker) observe(now time.Time, raw []byte, labels map[string]string, value uint64) bool {
+	key, ok := s.keys[string(raw)]
+	if !ok || key.value != value {
+		key = &trackedKey{
+			raw:     raw,
+			labels:  labels,
+			value:   value,
+			updated: now,
+		}
+		s.keys[string(raw)] = key
+	}
+	key.seen = true
+	return !key.expired
+}
+
+// sweep must be called after each iteration of the map. It returns the keys which went stale
+// since the last sweep, as well as exported keys which have been removed from the map.
+func (s *staleKeyTracker) sweep(now time.Time) (stale []*trackedKey, removed []*trackedKey) {
+	for raw, key := range s.keys {
+		switch {
+		case !key.seen && !s.keepAbsent:
+			if !key.expired {
+				removed = append(removed, key)
+			}
+			delete(s.keys, raw)
+		case !key.expired && s.ttl > 0 && now.Sub(key.updated) > s.ttl:
+			key.expired = true
+			stale = append(stale, key)
+			if s.keepAbsent {
+				// the key is no longer in the kernel map, so forget about it entirely
+				delete(s.keys, raw)
+			}
+		}
+		key.seen = false
+	}
+	if s.keepAbsent && s.maxAbsent > 0 && len(s.keys) > s.maxAbsent {
+		stale = append(stale, s.evictOldest(len(s.keys)-s.maxAbsent)...)
+	}
+	return stale, removed
+}
+
+// evictOldest forgets the n keys updated the longest ago, and returns them.
+func (s *staleKeyTracker) evictOldest(n int) []*trackedKey {
+	keys := make([]*trackedKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].updated.Before(keys[j].updated)
+	})
+	for _, key := range keys[:n] {
+		key.expired = true
+		delete(s.keys, string(key.raw))
+	}
+	return keys[:n]
+}
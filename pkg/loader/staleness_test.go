@@ -0,0 +1,82 @@
+package loader
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("staleKeyTracker", func() {
+	var (
+		tracker *staleKeyTracker
+		start   time.Time
+		labels  map[string]string
+	)
+
+	BeforeEach(func() {
+		tracker = newStaleKeyTracker(5*time.Second, false, 0)
+		start = time.Now()
+		labels = map[string]string{"pid": "1"}
+	})
+
+	It("expires keys whose value did not change", func() {
+		Expect(tracker.observe(start, []byte{1}, labels, 1)).To(BeTrue())
+		stale, removed := tracker.sweep(start)
+		Expect(stale).To(BeEmpty())
+		Expect(removed).To(BeEmpty())
+
+		later := start.Add(10 * time.Second)
+		Expect(tracker.observe(later, []byte{1}, labels, 1)).To(BeTrue())
+		stale, removed = tracker.sweep(later)
+		Expect(stale).To(HaveLen(1))
+		Expect(stale[0].labels).To(Equal(labels))
+		Expect(removed).To(BeEmpty())
+
+		// no longer exported until the value changes
+		Expect(tracker.observe(later, []byte{1}, labels, 1)).To(BeFalse())
+		Expect(tracker.observe(later, []byte{1}, labels, 2)).To(BeTrue())
+	})
+
+	It("keeps keys whose value changes", func() {
+		tracker.observe(start, []byte{1}, labels, 1)
+		tracker.sweep(start)
+
+		later := start.Add(10 * time.Second)
+		Expect(tracker.observe(later, []byte{1}, labels, 2)).To(BeTrue())
+		stale, _ := tracker.sweep(later)
+		Expect(stale).To(BeEmpty())
+	})
+
+	It("bounds the keys of consumed maps without a ttl", func() {
+		tracker = newStaleKeyTracker(0, true, 2)
+		for i := byte(1); i <= 3; i++ {
+			tracker.observe(start.Add(time.Duration(i)*time.Second), []byte{i}, map[string]string{"pid": fmt.Sprint(i)}, 1)
+		}
+		stale, removed := tracker.sweep(start.Add(5 * time.Second))
+		Expect(removed).To(BeEmpty())
+		// the key updated the longest ago
+		Expect(stale).To(HaveLen(1))
+		Expect(stale[0].labels).To(Equal(map[string]string{"pid": "1"}))
+		Expect(tracker.keys).To(HaveLen(2))
+
+		// absent keys are kept, and exported again once read
+		stale, _ = tracker.sweep(start.Add(time.Hour))
+		Expect(stale).To(BeEmpty())
+		Expect(tracker.observe(start.Add(time.Hour), []byte{1}, map[string]string{"pid": "1"}, 1)).To(BeTrue())
+		stale, _ = tracker.sweep(start.Add(time.Hour))
+		Expect(stale).To(HaveLen(1))
+		Expect(stale[0].labels).To(Equal(map[string]string{"pid": "2"}))
+	})
+
+	It("reports keys removed from the map", func() {
+		tracker.observe(start, []byte{1}, labels, 1)
+		tracker.sweep(start)
+
+		stale, removed := tracker.sweep(start.Add(time.Second))
+		Expect(stale).To(BeEmpty())
+		Expect(removed).To(HaveLen(1))
+		Expect(tracker.keys).To(BeEmpty())
+	})
+})
@@ -0,0 +1,279 @@
+package loader
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+// DefaultMaxErrors is the most recent errors a Status keeps, unless configured otherwise
+const DefaultMaxErrors = 100
+
+// Status records the errors of a loaded program while it runs, e.g. reading its maps or
+// attaching it to the interfaces of a pod, which are only logged otherwise, and reports them
+// with the attach state of its programs. It is bound to the program by the loader, once
+// attached.
+type Status struct {
+	max int
+	now func() time.Time
+
+	lock     sync.Mutex
+	attached *Attached
+	// the oldest first
+	errors []v1.RuntimeError
+	total  uint64
+}
+
+// NewStatus returns a status keeping the max most recent errors, DefaultMaxErrors if 0.
+func NewStatus(max int) *Status {
+	if max <= 0 {
+		max = DefaultMaxErrors
+	}
+	return &Status{max: max, now: time.Now}
+}
+
+// bindAttached reports the attach state of the programs attached.
+func (s *Status) bindAttached(attached *Attached) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.attached = attached
+}
+
+// unbind stops reporting the attach state of the programs, once unloaded. The errors are
+// kept, for the ones which failed the program to be reported.
+func (s *Status) unbind() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.attached = nil
+}
+
+// recordError records an error of the source, e.g. the map it was read from, the oldest
+// one being dropped beyond the most kept.
+func (s *Status) recordError(source string, err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.total++
+	if len(s.errors) >= s.max {
+		s.errors = append(s.errors[:0], s.errors[len(s.errors)-s.max+1:]...)
+	}
+	s.errors = append(s.errors, v1.RuntimeError{Time: s.now(), Source: source, Message: err.Error()})
+}
+
+// Status returns the attach state of the programs, none until attached, and the recent
+// errors.
+func (s *Status) Status() v1.ProgramStatus {
+	status, attached := s.errorStatus()
+	status.Probes = []v1.ProbeStatus{}
+	if attached != nil {
+		status.Probes = attached.probeStatus()
+	}
+	return status
+}
+
+// errorStatus returns the status of the recent errors, and the programs it is bound to.
+func (s *Status) errorStatus() (v1.ProgramStatus, *Attached) {
+	status := v1.ProgramStatus{Errors: []v1.RuntimeError{}}
+	if s == nil {
+		return status, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	status.Errors = append(status.Errors, s.errors...)
+	status.TotalErrors = s.total
+	return status, s.attached
+}
+
+// probeState is the attach state of a program, by the name of its function.
+type probeState struct {
+	state string
+	err   string
+	at    time.Time
+	// nil unless attached
+	link io.Closer
+	id   ebpf.ProgramID
+}
+
+// attachedProgram records the result of attaching a program, with the link returned by
+// attachProgram. The lock of the Attached must be held once it is shared, e.g. reattaching.
+func (a *Attached) attachedProgram(prog *ebpf.ProgramSpec, loaded *ebpf.Program, l io.Closer, err error) {
+	if a.states == nil {
+		a.states = map[string]*probeState{}
+	}
+	state := a.states[prog.Name]
+	if state == nil {
+		state = &probeState{}
+		if loaded != nil {
+			if info, err := loaded.Info(); err == nil {
+				state.id, _ = info.ID()
+			}
+		}
+		a.states[prog.Name] = state
+	}
+	state.link, state.err = l, ""
+	switch {
+	case err != nil:
+		state.state, state.err = v1.ProbeFailed, err.Error()
+	case l == nil:
+		state.state, state.err = v1.ProbeSkipped, "its section declares no hook to attach it to"
+	default:
+		state.state = v1.ProbeAttached
+		// network programs are only attached once a target is found
+		if links, ok := l.(*netLinks); !ok || len(links.links) > 0 {
+			state.at = time.Now()
+		}
+	}
+}
+
+// detachedPrograms records the programs as detached, once paused.
+func (a *Attached) detachedPrograms() {
+	for _, state := range a.states {
+		if state.state == v1.ProbeAttached {
+			state.state = v1.ProbeDetached
+		}
+		state.link = nil
+	}
+}
+
+// syncedTargets records the result of attaching a network program to its targets again,
+// with the errors of the targets it could not be attached to. The lock must be held.
+func (a *Attached) syncedTargets(links *netLinks, attached []string, errs []error) {
+	state := a.states[links.prog.Name]
+	if state == nil {
+		return
+	}
+	state.err = ""
+	if len(errs) > 0 {
+		state.err = errs[len(errs)-1].Error()
+	}
+	if len(attached) > 0 {
+		state.at = time.Now()
+	}
+	state.state = v1.ProbeAttached
+	if len(links.links) == 0 && len(errs) > 0 {
+		state.state = v1.ProbeFailed
+	}
+}
+
+// probeStatus returns the attach state of the programs, sorted by program.
+func (a *Attached) probeStatus() []v1.ProbeStatus {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	probes := []v1.ProbeStatus{}
+	for name, prog := range a.spec.Programs {
+		state, ok := a.states[prog.Name]
+		if !ok {
+			continue
+		}
+		probe := v1.ProbeStatus{
+			Program:   prog.Name,
+			Type:      probeType(prog),
+			State:     state.state,
+			Error:     state.err,
+			ProgramID: uint32(state.id),
+		}
+		if !state.at.IsZero() {
+			at := state.at
+			probe.AttachedAt = &at
+		}
+		probe.LinkIDs = linkIDs(state.link)
+		switch links := state.link.(type) {
+		case *netLinks:
+			for _, l := range links.links {
+				probe.Targets = append(probe.Targets, l.name)
+			}
+			sort.Strings(probe.Targets)
+			if len(probe.Targets) == 0 && probe.State == v1.ProbeAttached {
+				probe.State = v1.ProbeSkipped
+				if probe.Error == "" {
+					probe.Error = "none of its network targets is found yet"
+				}
+			}
+		case nil:
+		default:
+			if targets, ok := a.multiProbes[name]; ok {
+				for _, function := range targets.Functions {
+					if _, ok := targets.skipped[function]; !ok {
+						probe.Targets = append(probe.Targets, function)
+					}
+				}
+				for function, reason := range targets.skipped {
+					if probe.Skipped == nil {
+						probe.Skipped = map[string]string{}
+					}
+					probe.Skipped[function] = reason
+				}
+			} else {
+				probe.Targets = []string{prog.AttachTo}
+			}
+		}
+		probes = append(probes, probe)
+	}
+	sort.Slice(probes, func(i, j int) bool {
+		return probes[i].Program < probes[j].Program
+	})
+	return probes
+}
+
+// Status returns the attach state of the programs, and the recent errors recorded by the
+// Status of the LoadOptions, if set.
+func (a *Attached) Status() v1.ProgramStatus {
+	status, _ := a.status.errorStatus()
+	status.Probes = a.probeStatus()
+	return status
+}
+
+func probeType(prog *ebpf.ProgramSpec) string {
+	switch prog.Type {
+	case ebpf.Kprobe:
+		if strings.HasPrefix(prog.SectionName, "kretprobe/") {
+			return "kretprobe"
+		}
+		return "kprobe"
+	case ebpf.TracePoint:
+		return "tracepoint"
+	case ebpf.XDP:
+		return "xdp"
+	case ebpf.SchedCLS:
+		return "tc"
+	}
+	return prog.Type.String()
+}
+
+// bpfLink is a link with an ID, e.g. a kprobe.multi link.
+type bpfLink interface {
+	id() (uint32, error)
+}
+
+// linkIDs returns the IDs of the BPF links among the links of a program.
+func linkIDs(l io.Closer) []uint32 {
+	var ids []uint32
+	switch l := l.(type) {
+	case bpfLink:
+		if id, err := l.id(); err == nil {
+			ids = append(ids, id)
+		}
+	case multiLink:
+		for _, l := range l {
+			ids = append(ids, linkIDs(l)...)
+		}
+	case *netLinks:
+		for _, l := range l.links {
+			ids = append(ids, linkIDs(l.Closer)...)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,119 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cilium/ebpf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("program status", func() {
+	var (
+		closed   []string
+		kprobe   *ebpf.ProgramSpec
+		tc       *ebpf.ProgramSpec
+		attached *Attached
+		links    *netLinks
+	)
+
+	BeforeEach(func() {
+		closed = nil
+		kprobe = &ebpf.ProgramSpec{Name: "tcp_connect", Type: ebpf.Kprobe, SectionName: "kprobe/tcp_v4_connect", AttachTo: "tcp_v4_connect"}
+		tc = &ebpf.ProgramSpec{Name: "tc_allow", Type: ebpf.SchedCLS, SectionName: "classifier"}
+		attached = &Attached{
+			spec:   &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{kprobe.Name: kprobe, tc.Name: tc}},
+			status: NewStatus(2),
+		}
+		links = &netLinks{
+			prog: tc,
+			attach: func(prog *ebpf.ProgramSpec, loaded *ebpf.Program, t netTarget) (io.Closer, error) {
+				if t.iface == "busy0" {
+					return nil, errors.New("could not attach 'tc_allow' to busy0")
+				}
+				return fakeLink{closed: &closed, iface: t.iface}, nil
+			},
+			links: map[string]targetLink{},
+		}
+	})
+
+	It("reports the attach state of the programs", func() {
+		attached.attachedProgram(kprobe, nil, fakeLink{closed: &closed}, nil)
+		attached.attachedProgram(tc, nil, links, nil)
+
+		probes := attached.Status().Probes
+		Expect(probes).To(HaveLen(2))
+		Expect(probes[0].Program).To(Equal("tc_allow"))
+		Expect(probes[0].Type).To(Equal("tc"))
+		Expect(probes[0].State).To(Equal(v1.ProbeSkipped))
+		Expect(probes[0].Error).To(ContainSubstring("network targets"))
+		Expect(probes[1].Program).To(Equal("tcp_connect"))
+		Expect(probes[1].Type).To(Equal("kprobe"))
+		Expect(probes[1].State).To(Equal(v1.ProbeAttached))
+		Expect(probes[1].Targets).To(Equal([]string{"tcp_v4_connect"}))
+		Expect(probes[1].AttachedAt).NotTo(BeNil())
+
+		// attached to the interfaces found since, but one
+		newLinks, _, errs := links.sync([]netTarget{{iface: "veth1", ifindex: 4}, {iface: "busy0", ifindex: 3}})
+		attached.syncedTargets(links, newLinks, errs)
+		probes = attached.Status().Probes
+		Expect(probes[0].State).To(Equal(v1.ProbeAttached))
+		Expect(probes[0].Targets).To(Equal([]string{"veth1"}))
+		Expect(probes[0].Error).To(ContainSubstring("busy0"))
+
+		attached.detachedPrograms()
+		for _, probe := range attached.Status().Probes {
+			Expect(probe.State).To(Equal(v1.ProbeDetached))
+			Expect(probe.Targets).To(BeEmpty())
+		}
+
+		attached.attachedProgram(kprobe, nil, nil, errors.New("error attaching kprobe 'tcp_connect'"))
+		probes = attached.Status().Probes
+		Expect(probes[1].State).To(Equal(v1.ProbeFailed))
+		Expect(probes[1].Error).To(Equal("error attaching kprobe 'tcp_connect'"))
+	})
+
+	It("fails the network programs which could not be attached to any target", func() {
+		attached.attachedProgram(tc, nil, links, nil)
+		newLinks, _, errs := links.sync([]netTarget{{iface: "busy0", ifindex: 3}})
+		attached.syncedTargets(links, newLinks, errs)
+		probe := attached.Status().Probes[0]
+		Expect(probe.State).To(Equal(v1.ProbeFailed))
+		Expect(probe.AttachedAt).To(BeNil())
+	})
+
+	It("keeps the most recent errors", func() {
+		status := attached.status
+		now := time.Unix(1700000000, 0)
+		status.now = func() time.Time { return now }
+		for i := 0; i < 3; i++ {
+			status.recordError("events", fmt.Errorf("read %d failed", i))
+		}
+		status.recordError("events", nil)
+
+		Expect(status.Status()).To(Equal(v1.ProgramStatus{
+			Probes: []v1.ProbeStatus{},
+			Errors: []v1.RuntimeError{
+				{Time: now, Source: "events", Message: "read 1 failed"},
+				{Time: now, Source: "events", Message: "read 2 failed"},
+			},
+			TotalErrors: 3,
+		}))
+
+		status.bindAttached(attached)
+		attached.attachedProgram(kprobe, nil, fakeLink{closed: &closed}, nil)
+		Expect(status.Status().Probes).To(HaveLen(1))
+		status.unbind()
+		Expect(status.Status().Probes).To(BeEmpty())
+		Expect(status.Status().Errors).To(HaveLen(2))
+
+		var noStatus *Status
+		noStatus.recordError("events", errors.New("read failed"))
+		Expect(noStatus.Status().Errors).To(BeEmpty())
+	})
+})
@@ -0,0 +1,157 @@
+package loader
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/solo-io/bumblebee/pkg/stats"
+)
+
+// topKeyword prefixes the section name keyword declaring the number of entries of a top-N
+// hash map, e.g. `.maps.counter.top10`
+const topKeyword = "top"
+
+// getMapTopK returns the number of entries of the top keyword of the section name, 0 if none
+func getMapTopK(spec *ebpf.MapSpec) int {
+	for _, keyword := range strings.Split(spec.SectionName, ".") {
+		if !strings.HasPrefix(keyword, topKeyword) {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimPrefix(keyword, topKeyword)); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// SetTopK changes the number of entries of top-N hash maps by name, overriding the top
+// keyword of their section name, and disabling it if 0.
+func (p *ParsedELF) SetTopK(limits map[string]int) error {
+	for name, n := range limits {
+		m, ok := p.WatchedMaps[name]
+		if !ok {
+			return fmt.Errorf("top of map %s: the program has no such map", name)
+		}
+		if m.mapType != ebpf.Hash && m.mapType != ebpf.Array {
+			return fmt.Errorf("top of map %s: only the entries of hash maps can be ranked, it is a %s", name, m.mapType)
+		}
+		if n < 0 {
+			return fmt.Errorf("top of map %s: the number of entries must be positive", name)
+		}
+		m.TopK = n
+		p.WatchedMaps[name] = m
+	}
+	return nil
+}
+
+// OthersLabels returns the labels of the entry the entries of a top-N map which are not
+// among its largest ones are summed into, all set to stats.OverflowLabelValue.
+func OthersLabels(labels []string) map[string]string {
+	others := make(map[string]string, len(labels))
+	for _, label := range labels {
+		others[label] = stats.OverflowLabelValue
+	}
+	return others
+}
+
+type rankedEntry struct {
+	raw    []byte
+	labels map[string]string
+	value  uint64
+}
+
+// topK ranks the entries of each read of a hash map, to only export the n largest ones
+// and the sum of the others.
+type topK struct {
+	n      int
+	others map[string]string
+	read   []rankedEntry
+	// labels of the entries exported after the previous read, by raw key
+	exported       map[string]map[string]string
+	othersExported bool
+	// the others entry of counter maps only adds the increases of the entries it sums, as a
+	// counter can't go down when an entry leaves them, e.g. back among the largest ones
+	monotonic   bool
+	othersTotal uint64
+	// values of the entries of the previous read, by raw key, if monotonic
+	previous map[string]uint64
+}
+
+func newTopK(n int, labels []string, monotonic bool) *topK {
+	return &topK{
+		n:         n,
+		others:    OthersLabels(labels),
+		exported:  map[string]map[string]string{},
+		monotonic: monotonic,
+		previous:  map[string]uint64{},
+	}
+}
+
+func (t *topK) add(raw []byte, labels map[string]string, value uint64) {
+	t.read = append(t.read, rankedEntry{raw: raw, labels: labels, value: value})
+}
+
+// rank returns the largest entries of the read by decreasing value, the sum of the other
+// entries if any, and the labels of the entries which are no longer exported. The next
+// read starts.
+func (t *topK) rank() (top []rankedEntry, others *rankedEntry, gone []map[string]string) {
+	sort.SliceStable(t.read, func(i, j int) bool {
+		if t.read[i].value != t.read[j].value {
+			return t.read[i].value > t.read[j].value
+		}
+		return string(t.read[i].raw) < string(t.read[j].raw)
+	})
+	top = t.read
+	if len(top) > t.n {
+		others = &rankedEntry{labels: t.others}
+		for _, entry := range top[t.n:] {
+			others.value += entry.value
+		}
+		if t.monotonic {
+			others.value = t.othersTotal + t.increase(top[t.n:])
+			t.othersTotal = others.value
+		}
+		top = top[:t.n]
+	}
+	if t.monotonic {
+		previous := make(map[string]uint64, len(t.read))
+		for _, entry := range t.read {
+			previous[string(entry.raw)] = entry.value
+		}
+		t.previous = previous
+	}
+
+	exported := make(map[string]map[string]string, len(top))
+	for _, entry := range top {
+		exported[string(entry.raw)] = entry.labels
+	}
+	for raw, labels := range t.exported {
+		if _, ok := exported[raw]; !ok {
+			gone = append(gone, labels)
+		}
+	}
+	if t.othersExported && others == nil {
+		gone = append(gone, t.others)
+	}
+	t.exported = exported
+	t.othersExported = others != nil
+	t.read = nil
+	return top, others, gone
+}
+
+// increase returns the sum of the increases of the entries since the previous read, the whole
+// value of the entries not read before or reset since.
+func (t *topK) increase(entries []rankedEntry) uint64 {
+	var sum uint64
+	for _, entry := range entries {
+		previous, ok := t.previous[string(entry.raw)]
+		if !ok || entry.value < previous {
+			previous = 0
+		}
+		sum += entry.value - previous
+	}
+	return sum
+}
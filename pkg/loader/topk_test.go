@@ -0,0 +1,86 @@
+package loader
+
+import (
+	"github.com/cilium/ebpf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Top-N maps", func() {
+	pid := func(p string) map[string]string { return map[string]string{"pid": p} }
+
+	It("exports the largest entries of each read, and the sum of the others", func() {
+		top := newTopK(2, []string{"pid"}, false)
+		top.add([]byte{1}, pid("1"), 5)
+		top.add([]byte{2}, pid("2"), 40)
+		top.add([]byte{3}, pid("3"), 7)
+		top.add([]byte{4}, pid("4"), 3)
+		ranked, others, gone := top.rank()
+		Expect(ranked).To(Equal([]rankedEntry{
+			{raw: []byte{2}, labels: pid("2"), value: 40},
+			{raw: []byte{3}, labels: pid("3"), value: 7},
+		}))
+		Expect(others).To(Equal(&rankedEntry{labels: map[string]string{"pid": "other"}, value: 8}))
+		Expect(gone).To(BeEmpty())
+
+		// the entry of pid 3 is no longer among the largest
+		top.add([]byte{1}, pid("1"), 50)
+		top.add([]byte{2}, pid("2"), 40)
+		top.add([]byte{3}, pid("3"), 7)
+		top.add([]byte{4}, pid("4"), 3)
+		ranked, others, gone = top.rank()
+		Expect(ranked[0].labels).To(Equal(pid("1")))
+		Expect(ranked[1].labels).To(Equal(pid("2")))
+		Expect(others.value).To(Equal(uint64(10)))
+		Expect(gone).To(ConsistOf(pid("3")))
+
+		// the others entry goes away once all the entries are among the largest
+		top.add([]byte{1}, pid("1"), 50)
+		ranked, others, gone = top.rank()
+		Expect(ranked).To(HaveLen(1))
+		Expect(others).To(BeNil())
+		Expect(gone).To(ConsistOf(pid("2"), map[string]string{"pid": "other"}))
+	})
+
+	It("only adds the increases of the other entries of counter maps", func() {
+		top := newTopK(1, []string{"pid"}, true)
+		top.add([]byte{1}, pid("1"), 50)
+		top.add([]byte{2}, pid("2"), 10)
+		top.add([]byte{3}, pid("3"), 5)
+		_, others, _ := top.rank()
+		Expect(others.value).To(Equal(uint64(15)))
+
+		// pid 2 leaves the others, back among the largest, and pid 3 increases
+		top.add([]byte{1}, pid("1"), 50)
+		top.add([]byte{2}, pid("2"), 60)
+		top.add([]byte{3}, pid("3"), 8)
+		ranked, others, gone := top.rank()
+		Expect(ranked[0].labels).To(Equal(pid("2")))
+		Expect(gone).To(ConsistOf(pid("1")))
+		// pid 1 was read before, only its increase is added
+		Expect(others.value).To(Equal(uint64(18)))
+
+		// pid 3 is deleted from the map, and pid 1 reset
+		top.add([]byte{1}, pid("1"), 2)
+		top.add([]byte{2}, pid("2"), 60)
+		_, others, _ = top.rank()
+		Expect(others.value).To(Equal(uint64(20)))
+	})
+
+	It("is declared in the section name of the map, or overridden", func() {
+		Expect(getMapTopK(&ebpf.MapSpec{SectionName: ".maps.counter.top10"})).To(Equal(10))
+		Expect(getMapTopK(&ebpf.MapSpec{SectionName: ".maps.counter"})).To(Equal(0))
+		Expect(getMapTopK(&ebpf.MapSpec{SectionName: ".maps.topology"})).To(Equal(0))
+
+		parsedELF := &ParsedELF{WatchedMaps: map[string]WatchedMap{
+			"counts": {Name: "counts", mapType: ebpf.Hash, TopK: 10},
+			"events": {Name: "events", mapType: ebpf.RingBuf},
+		}}
+		Expect(parsedELF.SetTopK(map[string]int{"counts": 3})).To(Succeed())
+		Expect(parsedELF.WatchedMaps["counts"].TopK).To(Equal(3))
+		Expect(parsedELF.SetTopK(map[string]int{"events": 3})).To(MatchError(ContainSubstring("only the entries of hash maps can be ranked")))
+		Expect(parsedELF.SetTopK(map[string]int{"exits": 3})).To(MatchError(ContainSubstring("the program has no such map")))
+		Expect(parsedELF.SetTopK(map[string]int{"counts": -1})).To(MatchError(ContainSubstring("must be positive")))
+	})
+})
@@ -0,0 +1,128 @@
+package loader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+const (
+	// size of struct htab_elem, without the key and value
+	hashElemOverhead = 48
+	// size of a hash table bucket, there is a bucket per entry, rounded up to a power of 2
+	hashBucketSize  = 16
+	ringBufPageSize = 4096
+)
+
+// MapUsage is the estimated kernel memory used by a map once loaded.
+type MapUsage struct {
+	Name  string
+	Type  ebpf.MapType
+	Bytes uint64
+	// Whether the memory is allocated as entries are added, rather than when the map is created
+	Lazy bool
+}
+
+type Usage struct {
+	Maps  []MapUsage
+	Total uint64
+}
+
+// EstimateUsage estimates the kernel memory needed by the maps of a program, for a machine
+// with the given number of possible CPUs. The estimate follows how the kernel sizes maps and
+// leaves out small fixed overheads, it is meant for capacity planning rather than accounting.
+func EstimateUsage(spec *ebpf.CollectionSpec, cpus int) Usage {
+	usage := Usage{}
+	for name, m := range spec.Maps {
+		mapUsage := MapUsage{
+			Name:  name,
+			Type:  m.Type,
+			Bytes: estimateMapBytes(m, uint64(cpus)),
+			Lazy:  m.Flags&bpfFNoPrealloc != 0,
+		}
+		usage.Maps = append(usage.Maps, mapUsage)
+		usage.Total += mapUsage.Bytes
+	}
+	sort.Slice(usage.Maps, func(i, j int) bool {
+		if usage.Maps[i].Bytes != usage.Maps[j].Bytes {
+			return usage.Maps[i].Bytes > usage.Maps[j].Bytes
+		}
+		return usage.Maps[i].Name < usage.Maps[j].Name
+	})
+	return usage
+}
+
+func estimateMapBytes(m *ebpf.MapSpec, cpus uint64) uint64 {
+	entries := uint64(m.MaxEntries)
+	key := roundUp(uint64(m.KeySize), 8)
+	value := roundUp(uint64(m.ValueSize), 8)
+	switch m.Type {
+	case ebpf.Hash, ebpf.LRUHash:
+		return entries*(hashElemOverhead+key+value) + buckets(entries)
+	case ebpf.PerCPUHash, ebpf.LRUCPUHash:
+		// the element holds a pointer to the per-CPU values
+		return entries*(hashElemOverhead+key+8+value*cpus) + buckets(entries)
+	case ebpf.Array, ebpf.ProgramArray, ebpf.PerfEventArray, ebpf.CGroupArray, ebpf.ArrayOfMaps:
+		return entries * value
+	case ebpf.PerCPUArray:
+		return entries * value * cpus
+	case ebpf.RingBuf:
+		// the data area plus the consumer and producer pages
+		return entries + 2*ringBufPageSize
+	case ebpf.Queue, ebpf.Stack:
+		// one slot is kept free
+		return (entries + 1) * uint64(m.ValueSize)
+	default:
+		return entries * (key + value)
+	}
+}
+
+func buckets(entries uint64) uint64 {
+	n := uint64(1)
+	for n < entries {
+		n <<= 1
+	}
+	return n * hashBucketSize
+}
+
+func roundUp(n, to uint64) uint64 {
+	return (n + to - 1) / to * to
+}
+
+// PossibleCPUs returns the number of CPUs per-CPU maps are allocated for on this machine.
+func PossibleCPUs() int {
+	data, err := ioutil.ReadFile("/sys/devices/system/cpu/possible")
+	if err != nil {
+		return runtime.NumCPU()
+	}
+	cpus, err := parseCPURanges(strings.TrimSpace(string(data)))
+	if err != nil {
+		return runtime.NumCPU()
+	}
+	return cpus
+}
+
+// parseCPURanges returns the number of CPUs in a list like `0-3,8-11`.
+func parseCPURanges(ranges string) (int, error) {
+	var cpus int
+	for _, r := range strings.Split(ranges, ",") {
+		bounds := strings.SplitN(r, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return 0, fmt.Errorf("invalid CPU range %q", r)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return 0, fmt.Errorf("invalid CPU range %q", r)
+			}
+		}
+		cpus += last - first + 1
+	}
+	return cpus, nil
+}
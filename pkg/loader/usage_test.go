@@ -0,0 +1,44 @@
+package loader
+
+import (
+	"github.com/cilium/ebpf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("EstimateUsage", func() {
+	It("sizes maps the way the kernel allocates them", func() {
+		usage := EstimateUsage(&ebpf.CollectionSpec{
+			Maps: map[string]*ebpf.MapSpec{
+				"events":   {Type: ebpf.RingBuf, MaxEntries: 1 << 24},
+				"counts":   {Type: ebpf.Hash, KeySize: 4, ValueSize: 8, MaxEntries: 1000},
+				"per_cpu":  {Type: ebpf.PerCPUArray, KeySize: 4, ValueSize: 8, MaxEntries: 10},
+				"per_cpuh": {Type: ebpf.PerCPUHash, KeySize: 4, ValueSize: 8, MaxEntries: 1000},
+			},
+		}, 4)
+
+		Expect(usage.Maps).To(HaveLen(4))
+		// sorted by size
+		Expect(usage.Maps[0].Name).To(Equal("events"))
+		Expect(usage.Maps[0].Bytes).To(Equal(uint64(1<<24 + 2*4096)))
+
+		sizes := map[string]uint64{}
+		for _, m := range usage.Maps {
+			sizes[m.Name] = m.Bytes
+		}
+		// 1000 entries of 48 + 8 + 8 bytes, and 1024 buckets
+		Expect(sizes["counts"]).To(Equal(uint64(1000*64 + 1024*16)))
+		Expect(sizes["per_cpu"]).To(Equal(uint64(10 * 8 * 4)))
+		Expect(sizes["per_cpuh"]).To(Equal(uint64(1000*(48+8+8+8*4) + 1024*16)))
+		Expect(usage.Total).To(Equal(sizes["events"] + sizes["counts"] + sizes["per_cpu"] + sizes["per_cpuh"]))
+	})
+
+	It("parses CPU ranges", func() {
+		Expect(parseCPURanges("0")).To(Equal(1))
+		Expect(parseCPURanges("0-7")).To(Equal(8))
+		Expect(parseCPURanges("0-3,8-11,16")).To(Equal(9))
+		_, err := parseCPURanges("3-1")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,85 @@
+package loader
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/spec"
+)
+
+// SelectProbeVariants removes the programs of the probe variant groups which are not selected
+// for the kernel release from the spec, the first variant of each group whose range has the
+// release being kept. It returns the program selected for each group, and fails if a group
+// has no variant for the release. The programs of no group are left as is.
+func SelectProbeVariants(collSpec *ebpf.CollectionSpec, groups []v1.ProbeVariantGroup, release string) (map[string]string, error) {
+	if len(groups) == 0 {
+		return nil, nil
+	}
+	if release == "" {
+		return nil, fmt.Errorf("the kernel release is required to select the probe variants")
+	}
+	selected := map[string]string{}
+	for _, group := range groups {
+		var choice string
+		for _, variant := range group.Variants {
+			if _, ok := collSpec.Programs[variant.Program]; !ok {
+				return nil, fmt.Errorf("probe variant group %s: the program has no %s function", group.Name, variant.Program)
+			}
+			ok, err := variantMatches(variant, release)
+			if err != nil {
+				return nil, fmt.Errorf("probe variant group %s: %w", group.Name, err)
+			}
+			if ok && choice == "" {
+				choice = variant.Program
+			}
+		}
+		if choice == "" {
+			return nil, fmt.Errorf("probe variant group %s has no variant for kernel %s, its variants are %s", group.Name, release, variantRanges(group))
+		}
+		selected[group.Name] = choice
+		for _, variant := range group.Variants {
+			if variant.Program != choice {
+				delete(collSpec.Programs, variant.Program)
+			}
+		}
+	}
+	return selected, nil
+}
+
+// variantMatches returns whether the release is within the range of the variant.
+func variantMatches(variant v1.ProbeVariant, release string) (bool, error) {
+	if variant.MinKernelVersion != "" {
+		ok, err := spec.KernelAtLeast(release, variant.MinKernelVersion)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	if variant.MaxKernelVersion != "" {
+		ok, err := spec.KernelAtLeast(release, variant.MaxKernelVersion)
+		if err != nil || ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// variantRanges formats the programs of the group with their ranges, e.g.
+// "exec_tp for 5.8+, exec_kprobe before 5.8".
+func variantRanges(group v1.ProbeVariantGroup) string {
+	var ranges []string
+	for _, variant := range group.Variants {
+		r := variant.Program
+		switch {
+		case variant.MinKernelVersion != "" && variant.MaxKernelVersion != "":
+			r += fmt.Sprintf(" for %s to %s", variant.MinKernelVersion, variant.MaxKernelVersion)
+		case variant.MinKernelVersion != "":
+			r += fmt.Sprintf(" for %s+", variant.MinKernelVersion)
+		case variant.MaxKernelVersion != "":
+			r += fmt.Sprintf(" before %s", variant.MaxKernelVersion)
+		}
+		ranges = append(ranges, r)
+	}
+	return strings.Join(ranges, ", ")
+}
@@ -0,0 +1,54 @@
+package loader
+
+import (
+	"github.com/cilium/ebpf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("probe variants", func() {
+	var collSpec *ebpf.CollectionSpec
+
+	groups := []v1.ProbeVariantGroup{{
+		Name: "exec",
+		Variants: []v1.ProbeVariant{
+			{Program: "exec_tp", MinKernelVersion: "5.8"},
+			{Program: "exec_kprobe", MaxKernelVersion: "5.8"},
+		},
+	}}
+
+	BeforeEach(func() {
+		collSpec = &ebpf.CollectionSpec{Programs: map[string]*ebpf.ProgramSpec{
+			"exec_tp":     {Name: "exec_tp", Type: ebpf.TracePoint, SectionName: "tracepoint/sched/sched_process_exec"},
+			"exec_kprobe": {Name: "exec_kprobe", Type: ebpf.Kprobe, SectionName: "kprobe/do_execve", AttachTo: "do_execve"},
+			"exit":        {Name: "exit", Type: ebpf.TracePoint, SectionName: "tracepoint/sched/sched_process_exit"},
+		}}
+	})
+
+	It("keeps the variant of the kernel release of each group", func() {
+		selected, err := SelectProbeVariants(collSpec, groups, "5.10.0-21-amd64")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(selected).To(Equal(map[string]string{"exec": "exec_tp"}))
+		Expect(collSpec.Programs).To(HaveLen(2))
+		Expect(collSpec.Programs).To(HaveKey("exit"))
+	})
+
+	It("falls back to the variants of older kernels", func() {
+		selected, err := SelectProbeVariants(collSpec, groups, "5.4.0-91-generic")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(selected).To(Equal(map[string]string{"exec": "exec_kprobe"}))
+		Expect(collSpec.Programs).NotTo(HaveKey("exec_tp"))
+	})
+
+	It("fails if no variant of a group has the release", func() {
+		recent := []v1.ProbeVariantGroup{{Name: "exec", Variants: []v1.ProbeVariant{{Program: "exec_tp", MinKernelVersion: "5.8"}}}}
+		_, err := SelectProbeVariants(collSpec, recent, "4.18.0")
+		Expect(err).To(MatchError("probe variant group exec has no variant for kernel 4.18.0, its variants are exec_tp for 5.8+"))
+
+		missing := []v1.ProbeVariantGroup{{Name: "exec", Variants: []v1.ProbeVariant{{Program: "exec_fentry"}}}}
+		_, err = SelectProbeVariants(collSpec, missing, "5.10.0")
+		Expect(err).To(MatchError(ContainSubstring("no exec_fentry function")))
+	})
+})
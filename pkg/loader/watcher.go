@@ -1,22 +1,19 @@
 package loader
 
-type KvPair struct {
-	Key   map[string]string
-	Value string
-	Hash  uint64
-}
+import (
+	"sort"
 
-type MapEntry struct {
-	Name  string
-	Entry KvPair
-}
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
 
-type MapWatcher interface {
-	NewRingBuf(name string, keys []string)
-	NewHashMap(name string, keys []string)
-	SendEntry(entry MapEntry)
-	Close()
-}
+type (
+	// Deprecated: use v1.KvPair
+	KvPair = v1.KvPair
+	// Deprecated: use v1.MapEntry
+	MapEntry = v1.MapEntry
+	// Deprecated: use v1.MapWatcher
+	MapWatcher = v1.MapWatcher
+)
 
 type noopWatcher struct{}
 
@@ -26,7 +23,7 @@ func (w *noopWatcher) NewRingBuf(name string, keys []string) {
 func (w *noopWatcher) NewHashMap(name string, keys []string) {
 	// noop
 }
-func (w *noopWatcher) SendEntry(entry MapEntry) {
+func (w *noopWatcher) SendEntry(entry v1.MapEntry) {
 	// noop
 }
 func (w *noopWatcher) Close() {
@@ -36,3 +33,149 @@ func (w *noopWatcher) Close() {
 func NewNoopWatcher() *noopWatcher {
 	return &noopWatcher{}
 }
+
+// deleteEntry tells the watcher the entry of a hash map is gone, if it keeps the entries.
+func deleteEntry(watcher v1.MapWatcher, entry v1.MapEntry) {
+	if deleting, ok := watcher.(v1.DeletingWatcher); ok {
+		deleting.DeleteEntry(entry)
+	}
+}
+
+type multiWatcher struct {
+	watchers []v1.MapWatcher
+}
+
+// NewMultiWatcher returns a MapWatcher forwarding everything to all of the given watchers.
+func NewMultiWatcher(watchers ...v1.MapWatcher) v1.MapWatcher {
+	return &multiWatcher{watchers: watchers}
+}
+
+func (m *multiWatcher) NewRingBuf(name string, keys []string) {
+	for _, w := range m.watchers {
+		w.NewRingBuf(name, keys)
+	}
+}
+func (m *multiWatcher) NewHashMap(name string, keys []string) {
+	for _, w := range m.watchers {
+		w.NewHashMap(name, keys)
+	}
+}
+func (m *multiWatcher) SendEntry(entry v1.MapEntry) {
+	for _, w := range m.watchers {
+		w.SendEntry(entry)
+	}
+}
+func (m *multiWatcher) DeleteEntry(entry v1.MapEntry) {
+	for _, w := range m.watchers {
+		deleteEntry(w, entry)
+	}
+}
+func (m *multiWatcher) Close() {
+	for _, w := range m.watchers {
+		w.Close()
+	}
+}
+
+type routedWatcher struct {
+	watcher v1.MapWatcher
+	maps    map[string]bool
+}
+
+// NewRoutedWatcher returns a MapWatcher forwarding only the given maps to the watcher, so
+// the events of each map can be routed to some sinks.
+func NewRoutedWatcher(watcher v1.MapWatcher, maps []string) v1.MapWatcher {
+	routed := &routedWatcher{watcher: watcher, maps: map[string]bool{}}
+	for _, name := range maps {
+		routed.maps[name] = true
+	}
+	return routed
+}
+
+func (r *routedWatcher) NewRingBuf(name string, keys []string) {
+	if r.maps[name] {
+		r.watcher.NewRingBuf(name, keys)
+	}
+}
+func (r *routedWatcher) NewHashMap(name string, keys []string) {
+	if r.maps[name] {
+		r.watcher.NewHashMap(name, keys)
+	}
+}
+func (r *routedWatcher) SendEntry(entry v1.MapEntry) {
+	if r.maps[entry.Name] {
+		r.watcher.SendEntry(entry)
+	}
+}
+func (r *routedWatcher) DeleteEntry(entry v1.MapEntry) {
+	if r.maps[entry.Name] {
+		deleteEntry(r.watcher, entry)
+	}
+}
+func (r *routedWatcher) Close() {
+	r.watcher.Close()
+}
+
+type attributedWatcher struct {
+	watcher    v1.MapWatcher
+	attributes map[string]string
+	keys       []string
+}
+
+// NewAttributedWatcher returns a MapWatcher adding the attributes to the labels of every entry
+// forwarded to the watcher, replacing the labels of the same name, e.g. to tag the entries
+// of a sink with the team owning them.
+func NewAttributedWatcher(watcher v1.MapWatcher, attributes map[string]string) v1.MapWatcher {
+	if len(attributes) == 0 {
+		return watcher
+	}
+	attributed := &attributedWatcher{watcher: watcher, attributes: attributes}
+	for k := range attributes {
+		attributed.keys = append(attributed.keys, k)
+	}
+	sort.Strings(attributed.keys)
+	return attributed
+}
+
+// withAttributes returns the keys of a map followed by the attributes it doesn't have.
+func (a *attributedWatcher) withAttributes(keys []string) []string {
+	all := append([]string(nil), keys...)
+	for _, k := range a.keys {
+		found := false
+		for _, key := range keys {
+			found = found || key == k
+		}
+		if !found {
+			all = append(all, k)
+		}
+	}
+	return all
+}
+
+func (a *attributedWatcher) NewRingBuf(name string, keys []string) {
+	a.watcher.NewRingBuf(name, a.withAttributes(keys))
+}
+func (a *attributedWatcher) NewHashMap(name string, keys []string) {
+	a.watcher.NewHashMap(name, a.withAttributes(keys))
+}
+func (a *attributedWatcher) SendEntry(entry v1.MapEntry) {
+	a.watcher.SendEntry(a.attributed(entry))
+}
+func (a *attributedWatcher) DeleteEntry(entry v1.MapEntry) {
+	deleteEntry(a.watcher, a.attributed(entry))
+}
+
+// attributed returns the entry with the attributes added to its labels.
+func (a *attributedWatcher) attributed(entry v1.MapEntry) v1.MapEntry {
+	labels := make(map[string]string, len(entry.Entry.Key)+len(a.attributes))
+	for k, v := range entry.Entry.Key {
+		labels[k] = v
+	}
+	for k, v := range a.attributes {
+		labels[k] = v
+	}
+	entry.Entry.Key = labels
+	return entry
+}
+func (a *attributedWatcher) Close() {
+	a.watcher.Close()
+}
@@ -0,0 +1,80 @@
+package loader
+
+import (
+	v1 "github.com/solo-io/bumblebee/api/v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type recordingWatcher struct {
+	maps    []string
+	keys    [][]string
+	entries []v1.MapEntry
+	deleted []v1.MapEntry
+	closed  bool
+}
+
+func (w *recordingWatcher) NewRingBuf(name string, keys []string) {
+	w.maps, w.keys = append(w.maps, name), append(w.keys, keys)
+}
+func (w *recordingWatcher) NewHashMap(name string, keys []string) {
+	w.maps, w.keys = append(w.maps, name), append(w.keys, keys)
+}
+func (w *recordingWatcher) SendEntry(entry v1.MapEntry)   { w.entries = append(w.entries, entry) }
+func (w *recordingWatcher) DeleteEntry(entry v1.MapEntry) { w.deleted = append(w.deleted, entry) }
+func (w *recordingWatcher) Close()                        { w.closed = true }
+
+var _ = Describe("NewMultiWatcher", func() {
+	It("forwards the deletions to the watchers keeping the entries", func() {
+		recorder := &recordingWatcher{}
+		watcher := NewMultiWatcher(NewNoopWatcher(), NewRoutedWatcher(NewAttributedWatcher(recorder, map[string]string{"team": "payments"}), []string{"counts"}))
+		deleteEntry(watcher, v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"pid": "42"}}})
+		deleteEntry(watcher, v1.MapEntry{Name: "sizes", Entry: v1.KvPair{Key: map[string]string{"pid": "42"}}})
+
+		Expect(recorder.deleted).To(Equal([]v1.MapEntry{{Name: "counts", Entry: v1.KvPair{Key: map[string]string{
+			"pid": "42", "team": "payments",
+		}}}}))
+	})
+})
+
+var _ = Describe("NewRoutedWatcher", func() {
+	It("only forwards the routed maps", func() {
+		recorder := &recordingWatcher{}
+		watcher := NewRoutedWatcher(recorder, []string{"exec_events", "counts"})
+		watcher.NewRingBuf("exec_events", nil)
+		watcher.NewRingBuf("open_events", nil)
+		watcher.NewHashMap("counts", nil)
+		watcher.SendEntry(v1.MapEntry{Name: "exec_events"})
+		watcher.SendEntry(v1.MapEntry{Name: "open_events"})
+		watcher.Close()
+
+		Expect(recorder.maps).To(Equal([]string{"exec_events", "counts"}))
+		Expect(recorder.entries).To(Equal([]v1.MapEntry{{Name: "exec_events"}}))
+		Expect(recorder.closed).To(BeTrue())
+	})
+})
+
+var _ = Describe("NewAttributedWatcher", func() {
+	It("adds the attributes to the labels of the entries", func() {
+		recorder := &recordingWatcher{}
+		watcher := NewAttributedWatcher(recorder, map[string]string{"team": "payments", "pid": "0"})
+		watcher.NewRingBuf("events", []string{"pid", "comm"})
+		key := map[string]string{"pid": "42", "comm": "curl"}
+		watcher.SendEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: key}})
+		watcher.Close()
+
+		Expect(recorder.keys).To(Equal([][]string{{"pid", "comm", "team"}}))
+		Expect(recorder.entries).To(Equal([]v1.MapEntry{{Name: "events", Entry: v1.KvPair{Key: map[string]string{
+			"pid": "0", "comm": "curl", "team": "payments",
+		}}}}))
+		// the labels of the other watchers are left as they are
+		Expect(key).To(Equal(map[string]string{"pid": "42", "comm": "curl"}))
+		Expect(recorder.closed).To(BeTrue())
+	})
+
+	It("returns the watcher without attributes", func() {
+		recorder := &recordingWatcher{}
+		Expect(NewAttributedWatcher(recorder, nil)).To(BeIdenticalTo(recorder))
+	})
+})
@@ -0,0 +1,163 @@
+// Package mapseed fetches the entries the settings maps of programs are seeded with from
+// files, HTTP endpoints and Kubernetes ConfigMaps.
+package mapseed
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/solo-io/bumblebee/pkg/internal/kube"
+	"github.com/solo-io/bumblebee/pkg/loader"
+)
+
+// ServiceAccountDir holds the token and CA certificate ConfigMaps are read with by default
+const ServiceAccountDir = kube.ServiceAccountDir
+
+// ConfigMapPrefix prefixes the sources which are keys of Kubernetes ConfigMaps, as
+// configmap:namespace/name#key.
+const ConfigMapPrefix = "configmap:"
+
+// maxSize bounds the documents fetched, so a misconfigured endpoint can't exhaust memory.
+const maxSize = 16 << 20
+
+// Fetcher fetches the sources of seeds: the path of a file, an http:// or https:// URL, or
+// the key of a ConfigMap as configmap:namespace/name#key.
+type Fetcher struct {
+	// Kubernetes API server and credentials, default to the ones of the pod bee runs in
+	KubernetesAPIServer string
+	KubernetesTokenFile string
+	KubernetesCAFile    string
+	// Client of the HTTP requests, one with a 10s timeout by default
+	Client *http.Client
+}
+
+// Seed returns the seed of a settings map from a flag, as map=source, refreshed every
+// interval.
+func (f *Fetcher) Seed(flag string, interval time.Duration) (loader.MapSeed, error) {
+	i := strings.IndexByte(flag, '=')
+	if i <= 0 || i == len(flag)-1 {
+		return loader.MapSeed{}, fmt.Errorf("seed %q must be <map>=<source>", flag)
+	}
+	name, source := flag[:i], flag[i+1:]
+	fetch, err := f.source(source)
+	if err != nil {
+		return loader.MapSeed{}, err
+	}
+	return loader.MapSeed{Map: name, Source: source, Fetch: fetch, Interval: interval}, nil
+}
+
+func (f *Fetcher) source(source string) (func(ctx context.Context) ([]byte, error), error) {
+	switch {
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		if _, err := url.Parse(source); err != nil {
+			return nil, fmt.Errorf("invalid seed URL %q: %w", source, err)
+		}
+		return func(ctx context.Context) ([]byte, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+			if err != nil {
+				return nil, err
+			}
+			return get(f.client(), req)
+		}, nil
+	case strings.HasPrefix(source, ConfigMapPrefix):
+		return f.configMap(strings.TrimPrefix(source, ConfigMapPrefix))
+	}
+	return func(context.Context) ([]byte, error) {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return readAll(file)
+	}, nil
+}
+
+// configMap returns the fetch of a key of a ConfigMap, as namespace/name#key, from its data
+// or its binaryData.
+func (f *Fetcher) configMap(ref string) (func(ctx context.Context) ([]byte, error), error) {
+	invalid := fmt.Errorf("ConfigMap seed %q must be %s<namespace>/<name>#<key>", ref, ConfigMapPrefix)
+	i := strings.LastIndexByte(ref, '#')
+	if i <= 0 || i == len(ref)-1 {
+		return nil, invalid
+	}
+	parts, key := strings.Split(ref[:i], "/"), ref[i+1:]
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, invalid
+	}
+
+	client, err := kube.NewClient(kube.Config{
+		APIServer:  f.KubernetesAPIServer,
+		TokenFile:  f.KubernetesTokenFile,
+		CAFile:     f.KubernetesCAFile,
+		HTTPClient: f.client(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ConfigMaps can't be read: %w", err)
+	}
+	configMapPath := "/api/v1/namespaces/" + url.PathEscape(parts[0]) + "/configmaps/" + url.PathEscape(parts[1])
+
+	return func(ctx context.Context) ([]byte, error) {
+		resp, err := client.Request(ctx, http.MethodGet, configMapPath, nil, "", nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not read ConfigMap %s/%s: %w", parts[0], parts[1], err)
+		}
+		defer resp.Body.Close()
+		body, err := readAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("could not read ConfigMap %s/%s: %w", parts[0], parts[1], err)
+		}
+		var configMap struct {
+			Data       map[string]string `json:"data"`
+			BinaryData map[string]string `json:"binaryData"`
+		}
+		if err := json.Unmarshal(body, &configMap); err != nil {
+			return nil, fmt.Errorf("could not decode ConfigMap %s/%s: %w", parts[0], parts[1], err)
+		}
+		if value, ok := configMap.Data[key]; ok {
+			return []byte(value), nil
+		}
+		if encoded, ok := configMap.BinaryData[key]; ok {
+			return base64.StdEncoding.DecodeString(encoded)
+		}
+		return nil, fmt.Errorf("ConfigMap %s/%s has no key %s", parts[0], parts[1], key)
+	}, nil
+}
+
+func (f *Fetcher) client() *http.Client {
+	if f.Client != nil {
+		return f.Client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+func get(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return readAll(resp.Body)
+}
+
+func readAll(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("the source is larger than %d bytes", maxSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,13 @@
+package mapseed_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestMapSeed(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "MapSeed Suite")
+}
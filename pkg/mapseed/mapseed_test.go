@@ -0,0 +1,94 @@
+package mapseed_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/solo-io/bumblebee/pkg/mapseed"
+)
+
+var _ = Describe("map seeds", func() {
+	ctx := context.Background()
+
+	It("reads files", func() {
+		dir, err := ioutil.TempDir("", "mapseed")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "allowlist")
+		Expect(ioutil.WriteFile(path, []byte("10.0.0.1\n"), 0600)).To(Succeed())
+
+		seed, err := (&mapseed.Fetcher{}).Seed("allowlist="+path, time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(seed.Map).To(Equal("allowlist"))
+		Expect(seed.Source).To(Equal(path))
+		Expect(seed.Interval).To(Equal(time.Minute))
+		Expect(seed.Fetch(ctx)).To(Equal([]byte("10.0.0.1\n")))
+	})
+
+	It("fetches URLs", func() {
+		status := http.StatusOK
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte("- 10.0.0.1\n"))
+		}))
+		defer server.Close()
+
+		seed, err := (&mapseed.Fetcher{}).Seed("allowlist="+server.URL+"/allowlist", 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(seed.Fetch(ctx)).To(Equal([]byte("- 10.0.0.1\n")))
+
+		status = http.StatusBadGateway
+		_, err = seed.Fetch(ctx)
+		Expect(err).To(MatchError(ContainSubstring("unexpected status 502")))
+	})
+
+	It("reads the keys of ConfigMaps", func() {
+		dir, err := ioutil.TempDir("", "mapseed")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		tokenFile := filepath.Join(dir, "token")
+		Expect(ioutil.WriteFile(tokenFile, []byte("sa-token\n"), 0600)).To(Succeed())
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v1/namespaces/net/configmaps/allowlist" || r.Header.Get("Authorization") != "Bearer sa-token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"data": {"ips": "10.0.0.1\n"}, "binaryData": {"raw": "MTAuMC4wLjI="}}`))
+		}))
+		defer server.Close()
+		fetcher := &mapseed.Fetcher{KubernetesAPIServer: server.URL, KubernetesTokenFile: tokenFile}
+
+		seed, err := fetcher.Seed("allowlist=configmap:net/allowlist#ips", time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(seed.Fetch(ctx)).To(Equal([]byte("10.0.0.1\n")))
+
+		seed, err = fetcher.Seed("allowlist=configmap:net/allowlist#raw", time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(seed.Fetch(ctx)).To(Equal([]byte("10.0.0.2")))
+
+		seed, err = fetcher.Seed("allowlist=configmap:net/allowlist#missing", time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = seed.Fetch(ctx)
+		Expect(err).To(MatchError("ConfigMap net/allowlist has no key missing"))
+
+		seed, err = fetcher.Seed("allowlist=configmap:net/denylist#ips", time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = seed.Fetch(ctx)
+		Expect(err).To(MatchError(ContainSubstring("could not read ConfigMap net/denylist: unexpected status 403")))
+	})
+
+	It("rejects invalid seeds", func() {
+		_, err := (&mapseed.Fetcher{}).Seed("allowlist", 0)
+		Expect(err).To(MatchError(ContainSubstring("must be <map>=<source>")))
+		_, err = (&mapseed.Fetcher{KubernetesAPIServer: "https://k8s"}).Seed("allowlist=configmap:allowlist#ips", 0)
+		Expect(err).To(MatchError(ContainSubstring("must be configmap:<namespace>/<name>#<key>")))
+	})
+})
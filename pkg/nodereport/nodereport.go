@@ -0,0 +1,275 @@
+// Package nodereport reports the repeated load failures of a program to the Kubernetes node
+// bee runs on, so that scheduling and alerting react to them rather than the failures staying
+// in the logs of bee.
+package nodereport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/solo-io/bumblebee/pkg/internal/kube"
+)
+
+const (
+	// ServiceAccountDir holds the token and CA certificate the node is updated with by default
+	ServiceAccountDir = kube.ServiceAccountDir
+
+	// DefaultThreshold is the number of consecutive load failures the node is reported after
+	DefaultThreshold = 3
+
+	// Prefix of the annotation counting the consecutive load failures of a program, and of
+	// the taint of the node, followed by the name of the program
+	annotationPrefix = "bee.solo.io/"
+	// Namespace of the events of nodes, which are not namespaced
+	eventNamespace = "default"
+	// Attempts at updating the node when it changes concurrently
+	updateAttempts = 5
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// Config is how the load failures of a program are reported to its node.
+type Config struct {
+	// Name of the program in the annotation, taint and events of the node, e.g. tcpconnect
+	Program string
+	// Node bee runs on, defaults to $NODE_NAME, e.g. set from spec.nodeName with the
+	// downward API
+	Node string
+	// Consecutive load failures after which the node is reported, defaults to DefaultThreshold
+	Threshold int
+	// Also taint the node NoSchedule once reported, which is otherwise only annotated
+	Taint bool
+	// API server the node is updated through, with a timeout of 10s unless set
+	kube.Config
+}
+
+// Reporter counts the consecutive load failures of a program in an annotation of its node,
+// which outlives the restarts of bee, e.g. by a DaemonSet. Once the count reaches the
+// threshold, a Warning event is raised for the node, and the node is tainted if configured,
+// until the program loads again.
+type Reporter struct {
+	cfg      Config
+	nodePath string
+	client   *kube.Client
+	now      func() time.Time
+}
+
+func New(cfg Config) (*Reporter, error) {
+	if cfg.Node == "" {
+		cfg.Node = os.Getenv("NODE_NAME")
+		if cfg.Node == "" {
+			return nil, errors.New("the node bee runs on must be set, or given by $NODE_NAME")
+		}
+	}
+	cfg.Program = ProgramName(cfg.Program)
+	if cfg.Program == "" {
+		return nil, errors.New("the program reported to the node must have a name")
+	}
+	if cfg.Threshold == 0 {
+		cfg.Threshold = DefaultThreshold
+	}
+	if cfg.Threshold < 0 {
+		return nil, errors.New("the threshold of load failures must be positive")
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	client, err := kube.NewClient(cfg.Config)
+	if err != nil {
+		return nil, err
+	}
+	return &Reporter{
+		cfg:      cfg,
+		nodePath: "/api/v1/nodes/" + url.PathEscape(cfg.Node),
+		client:   client,
+		now:      time.Now,
+	}, nil
+}
+
+// ProgramName returns the name of a program valid in annotations and taints, from its
+// name or ref, e.g. tcpconnect for ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7.
+func ProgramName(ref string) string {
+	name := ref
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.IndexAny(name, ":@"); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.TrimSuffix(name, ".o")
+	name = strings.Trim(invalidNameChars.ReplaceAllString(name, "-"), "-_.")
+	// the annotation name, <program>.load-failures, must not exceed 63 characters
+	if len(name) > 49 {
+		name = strings.TrimRight(name[:49], "-_.")
+	}
+	return name
+}
+
+// AnnotationKey is the annotation of the node counting the consecutive load failures of
+// the program.
+func (r *Reporter) AnnotationKey() string {
+	return annotationPrefix + r.cfg.Program + ".load-failures"
+}
+
+// TaintKey is the key of the taint of the node once the program failed to load too many
+// times in a row.
+func (r *Reporter) TaintKey() string {
+	return annotationPrefix + r.cfg.Program + ".load-failed"
+}
+
+// Failed counts a load failure of the program, and reports the node once the failures reach
+// the threshold.
+func (r *Reporter) Failed(ctx context.Context, loadErr error) error {
+	var failures int
+	var n *node
+	err := r.update(ctx, func(current *node) (map[string]interface{}, bool) {
+		n = current
+		failures, _ = strconv.Atoi(current.Metadata.Annotations[r.AnnotationKey()])
+		failures++
+		tainted := false
+		if failures >= r.cfg.Threshold && r.cfg.Taint && current.taint(r.TaintKey()) < 0 {
+			current.Spec.Taints = append(current.Spec.Taints, taint{Key: r.TaintKey(), Value: "true", Effect: "NoSchedule"})
+			tainted = true
+		}
+		return map[string]interface{}{r.AnnotationKey(): strconv.Itoa(failures)}, tainted
+	})
+	if err != nil {
+		return err
+	}
+	if failures < r.cfg.Threshold {
+		return nil
+	}
+	// only the first line, errors of the verifier hold its whole log
+	reason := strings.SplitN(loadErr.Error(), "\n", 2)[0]
+	return r.event(ctx, n, "Warning", "BeeProgramLoadFailed",
+		fmt.Sprintf("program %s failed to load %d times in a row: %s", r.cfg.Program, failures, reason))
+}
+
+// Succeeded resets the count of the load failures of the program, and removes its taint
+// from the node. An event is raised if the node was reported.
+func (r *Reporter) Succeeded(ctx context.Context) error {
+	var failures int
+	var n *node
+	err := r.update(ctx, func(current *node) (map[string]interface{}, bool) {
+		n = current
+		value, counted := current.Metadata.Annotations[r.AnnotationKey()]
+		i := current.taint(r.TaintKey())
+		if !counted && i < 0 {
+			return nil, false
+		}
+		failures, _ = strconv.Atoi(value)
+		if i >= 0 {
+			current.Spec.Taints = append(current.Spec.Taints[:i], current.Spec.Taints[i+1:]...)
+		}
+		// null removes the annotation with a merge patch
+		return map[string]interface{}{r.AnnotationKey(): nil}, i >= 0
+	})
+	if err != nil || failures < r.cfg.Threshold {
+		return err
+	}
+	return r.event(ctx, n, "Normal", "BeeProgramLoaded",
+		fmt.Sprintf("program %s loaded after failing %d times in a row", r.cfg.Program, failures))
+}
+
+type node struct {
+	Metadata struct {
+		Name            string            `json:"name"`
+		UID             string            `json:"uid"`
+		ResourceVersion string            `json:"resourceVersion"`
+		Annotations     map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Taints []taint `json:"taints"`
+	} `json:"spec"`
+}
+
+type taint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect"`
+	// set by the API server for NoExecute taints
+	TimeAdded string `json:"timeAdded,omitempty"`
+}
+
+func (n *node) taint(key string) int {
+	for i, t := range n.Spec.Taints {
+		if t.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// update patches the annotations returned by the change, and the taints of the node if
+// changed, getting the node again if it changed concurrently. The node is not patched if
+// the change returns no annotations.
+func (r *Reporter) update(ctx context.Context, change func(n *node) (annotations map[string]interface{}, taintsChanged bool)) error {
+	for attempt := 1; ; attempt++ {
+		var n node
+		if err := r.client.Do(ctx, http.MethodGet, r.nodePath, "", nil, &n); err != nil {
+			return fmt.Errorf("could not get node %s: %w", r.cfg.Node, err)
+		}
+		annotations, taintsChanged := change(&n)
+		if annotations == nil {
+			return nil
+		}
+		// the resource version makes the patch fail if the node changed since
+		patch := map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"resourceVersion": n.Metadata.ResourceVersion,
+				"annotations":     annotations,
+			},
+		}
+		if taintsChanged {
+			// lists are replaced by merge patches
+			patch["spec"] = map[string]interface{}{"taints": n.Spec.Taints}
+		}
+		err := r.client.Do(ctx, http.MethodPatch, r.nodePath, "application/merge-patch+json", patch, nil)
+		if kube.IsStatus(err, http.StatusConflict) && attempt < updateAttempts {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not update node %s: %w", r.cfg.Node, err)
+		}
+		return nil
+	}
+}
+
+// event raises an event for the node.
+func (r *Reporter) event(ctx context.Context, n *node, eventType, reason, message string) error {
+	now := r.now().UTC()
+	timestamp := now.Format(time.RFC3339)
+	event := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":      fmt.Sprintf("%s.%x", r.cfg.Node, now.UnixNano()),
+			"namespace": eventNamespace,
+		},
+		"involvedObject": map[string]interface{}{
+			"kind":       "Node",
+			"apiVersion": "v1",
+			"name":       r.cfg.Node,
+			"uid":        n.Metadata.UID,
+		},
+		"type":               eventType,
+		"reason":             reason,
+		"message":            message,
+		"source":             map[string]interface{}{"component": "bee", "host": r.cfg.Node},
+		"reportingComponent": "bee",
+		"reportingInstance":  r.cfg.Node,
+		"firstTimestamp":     timestamp,
+		"lastTimestamp":      timestamp,
+		"count":              1,
+	}
+	if err := r.client.Do(ctx, http.MethodPost, "/api/v1/namespaces/"+eventNamespace+"/events", "application/json", event, nil); err != nil {
+		return fmt.Errorf("could not create event of node %s: %w", r.cfg.Node, err)
+	}
+	return nil
+}
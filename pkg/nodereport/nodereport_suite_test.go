@@ -0,0 +1,13 @@
+package nodereport_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestNodeReport(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "NodeReport Suite")
+}
@@ -0,0 +1,164 @@
+package nodereport_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/solo-io/bumblebee/pkg/internal/kube"
+	"github.com/solo-io/bumblebee/pkg/nodereport"
+)
+
+// fakeNode is the API server of a single node, applying the merge patches of its
+// annotations and taints.
+type fakeNode struct {
+	lock            sync.Mutex
+	resourceVersion int
+	annotations     map[string]string
+	taints          []map[string]string
+	events          []map[string]interface{}
+	// patches answered with a conflict, as if the node changed concurrently
+	conflicts int
+}
+
+func (f *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/nodes/node-1":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name":            "node-1",
+				"uid":             "0b5e7a4c",
+				"resourceVersion": strconv.Itoa(f.resourceVersion),
+				"annotations":     f.annotations,
+			},
+			"spec": map[string]interface{}{"taints": f.taints},
+		})
+	case r.Method == http.MethodPatch && r.URL.Path == "/api/v1/nodes/node-1":
+		Expect(r.Header.Get("Content-Type")).To(Equal("application/merge-patch+json"))
+		var patch struct {
+			Metadata struct {
+				ResourceVersion string             `json:"resourceVersion"`
+				Annotations     map[string]*string `json:"annotations"`
+			} `json:"metadata"`
+			Spec *struct {
+				Taints []map[string]string `json:"taints"`
+			} `json:"spec"`
+		}
+		Expect(json.NewDecoder(r.Body).Decode(&patch)).To(Succeed())
+		if f.conflicts > 0 || patch.Metadata.ResourceVersion != strconv.Itoa(f.resourceVersion) {
+			f.conflicts--
+			f.resourceVersion++
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		for k, v := range patch.Metadata.Annotations {
+			if v == nil {
+				delete(f.annotations, k)
+			} else {
+				f.annotations[k] = *v
+			}
+		}
+		if patch.Spec != nil {
+			f.taints = patch.Spec.Taints
+		}
+		f.resourceVersion++
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/namespaces/default/events":
+		var event map[string]interface{}
+		Expect(json.NewDecoder(r.Body).Decode(&event)).To(Succeed())
+		f.events = append(f.events, event)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+var _ = Describe("Node reports", func() {
+	var (
+		node      *fakeNode
+		apiServer *httptest.Server
+	)
+
+	BeforeEach(func() {
+		node = &fakeNode{
+			annotations: map[string]string{"kubernetes.io/os": "linux"},
+			taints:      []map[string]string{{"key": "dedicated", "value": "infra", "effect": "NoSchedule"}},
+		}
+		apiServer = httptest.NewServer(node)
+	})
+
+	AfterEach(func() {
+		apiServer.Close()
+	})
+
+	newReporter := func(taint bool) *nodereport.Reporter {
+		reporter, err := nodereport.New(nodereport.Config{
+			Program:   "ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7",
+			Node:      "node-1",
+			Threshold: 2,
+			Taint:     taint,
+			Config:    kube.Config{APIServer: apiServer.URL},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return reporter
+	}
+
+	It("taints the node and raises events once the program failed too many times", func() {
+		reporter := newReporter(true)
+		ctx := context.Background()
+		loadErr := errors.New("could not load: permission denied\nverifier log")
+
+		Expect(reporter.Failed(ctx, loadErr)).To(Succeed())
+		Expect(node.annotations).To(HaveKeyWithValue("bee.solo.io/tcpconnect.load-failures", "1"))
+		Expect(node.taints).To(HaveLen(1))
+		Expect(node.events).To(BeEmpty())
+
+		Expect(reporter.Failed(ctx, loadErr)).To(Succeed())
+		Expect(node.annotations).To(HaveKeyWithValue("bee.solo.io/tcpconnect.load-failures", "2"))
+		Expect(node.taints).To(ContainElement(map[string]string{"key": "bee.solo.io/tcpconnect.load-failed", "value": "true", "effect": "NoSchedule"}))
+		Expect(node.events).To(HaveLen(1))
+		Expect(node.events[0]).To(HaveKeyWithValue("type", "Warning"))
+		Expect(node.events[0]).To(HaveKeyWithValue("reason", "BeeProgramLoadFailed"))
+		Expect(node.events[0]).To(HaveKeyWithValue("message", "program tcpconnect failed to load 2 times in a row: could not load: permission denied"))
+		Expect(node.events[0]["involvedObject"]).To(HaveKeyWithValue("uid", "0b5e7a4c"))
+
+		Expect(reporter.Succeeded(ctx)).To(Succeed())
+		Expect(node.annotations).To(Equal(map[string]string{"kubernetes.io/os": "linux"}))
+		Expect(node.taints).To(Equal([]map[string]string{{"key": "dedicated", "value": "infra", "effect": "NoSchedule"}}))
+		Expect(node.events).To(HaveLen(2))
+		Expect(node.events[1]).To(HaveKeyWithValue("reason", "BeeProgramLoaded"))
+
+		// nothing to reset
+		Expect(reporter.Succeeded(ctx)).To(Succeed())
+		Expect(node.events).To(HaveLen(2))
+	})
+
+	It("only annotates the node unless tainting", func() {
+		reporter := newReporter(false)
+		for i := 0; i < 3; i++ {
+			Expect(reporter.Failed(context.Background(), errors.New("failed"))).To(Succeed())
+		}
+		Expect(node.annotations).To(HaveKeyWithValue("bee.solo.io/tcpconnect.load-failures", "3"))
+		Expect(node.taints).To(HaveLen(1))
+		Expect(node.events).To(HaveLen(2))
+	})
+
+	It("updates the node again when it changed concurrently", func() {
+		node.conflicts = 2
+		Expect(newReporter(true).Failed(context.Background(), errors.New("failed"))).To(Succeed())
+		Expect(node.annotations).To(HaveKeyWithValue("bee.solo.io/tcpconnect.load-failures", "1"))
+	})
+
+	It("names programs after their refs", func() {
+		Expect(nodereport.ProgramName("ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7")).To(Equal("tcpconnect"))
+		Expect(nodereport.ProgramName("./probes/tc allowlist.o")).To(Equal("tc-allowlist"))
+		Expect(nodereport.ProgramName("localhost:5000/execsnoop@sha256:0a1b")).To(Equal("execsnoop"))
+	})
+})
@@ -0,0 +1,13 @@
+package opensearchsink_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestOpenSearchSink(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "OpenSearch Sink Suite")
+}
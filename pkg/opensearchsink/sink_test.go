@@ -0,0 +1,118 @@
+package opensearchsink_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/opensearchsink"
+)
+
+// fakeCluster rejects the first bulk request as overloaded, then rejects the documents
+// whose saddr is "invalid".
+type fakeCluster struct {
+	lock      sync.Mutex
+	templates map[string]string
+	bulks     int
+	indexed   []map[string]string
+}
+
+func (c *fakeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	if user, pass, _ := r.BasicAuth(); user != "bee" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/_index_template/"):
+		c.templates[strings.TrimPrefix(r.URL.Path, "/_index_template/")] = string(body)
+		w.Write([]byte(`{"acknowledged":true}`))
+	case r.URL.Path == "/_bulk":
+		c.bulks++
+		if c.bulks == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		var items []string
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		for i := 1; i < len(lines); i += 2 {
+			var doc map[string]string
+			json.Unmarshal([]byte(lines[i]), &doc)
+			if doc["saddr"] == "invalid" {
+				items = append(items, `{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}`)
+				continue
+			}
+			c.indexed = append(c.indexed, doc)
+			items = append(items, `{"index":{"status":201}}`)
+		}
+		w.Write([]byte(`{"errors":true,"items":[` + strings.Join(items, ",") + `]}`))
+	}
+}
+
+var _ = Describe("opensearch sink", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("retries overloaded requests and dead-letters rejected documents", func() {
+		cluster := &fakeCluster{templates: map[string]string{}}
+		server := httptest.NewServer(cluster)
+		defer server.Close()
+
+		sink, err := opensearchsink.New(context.Background(), opensearchsink.Opts{
+			URL:           strings.Replace(server.URL, "http://", "http://bee:secret@", 1),
+			FlushInterval: time.Hour,
+			DeadLetterDir: dir,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		sink.Start()
+		sink.NewRingBuf("Events", []string{"saddr"})
+		sink.NewHashMap("counts", []string{"saddr"})
+		sink.SendEntry(v1.MapEntry{Name: "Events", Entry: v1.KvPair{Key: map[string]string{"saddr": "10.0.0.1"}}})
+		sink.SendEntry(v1.MapEntry{Name: "Events", Entry: v1.KvPair{Key: map[string]string{"saddr": "invalid"}}})
+		sink.SendEntry(v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"saddr": "10.0.0.1"}, Value: "1"}})
+		sink.Close()
+
+		Expect(cluster.bulks).To(Equal(2))
+		Expect(cluster.templates).To(HaveKey("bee-events"))
+		Expect(cluster.templates["bee-events"]).To(ContainSubstring(`"saddr":{"type":"keyword"}`))
+		Expect(cluster.indexed).To(HaveLen(1))
+		Expect(cluster.indexed[0]["saddr"]).To(Equal("10.0.0.1"))
+		Expect(cluster.indexed[0]).To(HaveKey(opensearchsink.TimestampField))
+
+		f, err := os.Open(filepath.Join(dir, "bee-events.jsonl"))
+		Expect(err).NotTo(HaveOccurred())
+		defer f.Close()
+		var lines []map[string]interface{}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var line map[string]interface{}
+			Expect(json.Unmarshal(scanner.Bytes(), &line)).To(Succeed())
+			lines = append(lines, line)
+		}
+		Expect(lines).To(HaveLen(1))
+		Expect(lines[0]["error"]).To(ContainSubstring("mapper_parsing_exception"))
+		Expect(lines[0]["document"]).To(HaveKeyWithValue("saddr", "invalid"))
+	})
+})
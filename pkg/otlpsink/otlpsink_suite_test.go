@@ -0,0 +1,13 @@
+package otlpsink_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestOTLPSink(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "OTLP Sink Suite")
+}
@@ -0,0 +1,376 @@
+package otlpsink
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+const (
+	defaultServiceName   = "bee"
+	defaultBatchSize     = 512
+	defaultFlushInterval = 5 * time.Second
+	defaultMaxRetries    = 5
+
+	// number of spans buffered before events are dropped
+	queueSize = 10000
+
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+
+	// MapAttribute is the attribute holding the name of the map of each event
+	MapAttribute = "bee.map"
+
+	scopeName = "github.com/solo-io/bumblebee"
+	// SPAN_KIND_INTERNAL
+	internalSpanKind = 1
+)
+
+// Correlation names the fields of the events of a map holding the IDs of the
+// distributed trace they belong to.
+type Correlation struct {
+	TraceIDField string
+	SpanIDField  string
+}
+
+type Opts struct {
+	// OTLP/HTTP endpoint of the collector, e.g. http://localhost:4318
+	Endpoint string
+	// service.name of the spans, defaults to bee
+	ServiceName string
+	// Other attributes of the resource of the spans, e.g. the team owning them
+	ResourceAttributes map[string]string
+	// Headers of the requests, e.g. for authentication
+	Headers map[string]string
+	// Correlation fields per map name, the events of these maps link to the spans they
+	// carry the IDs of
+	Correlations map[string]Correlation
+	// Maximum number of spans per request, defaults to 512
+	BatchSize int
+	// Spans are sent at least this often, defaults to 5s
+	FlushInterval time.Duration
+	// Number of times spans are retried when the collector is unavailable, defaults to 5
+	MaxRetries int
+	HTTPClient *http.Client
+}
+
+func (o *Opts) initDefaults() {
+	if o.ServiceName == "" {
+		o.ServiceName = defaultServiceName
+	}
+	if o.BatchSize == 0 {
+		o.BatchSize = defaultBatchSize
+	}
+	if o.FlushInterval == 0 {
+		o.FlushInterval = defaultFlushInterval
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = defaultMaxRetries
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = http.DefaultClient
+	}
+}
+
+// Sink exports the events of the ring buffers of a program to an OpenTelemetry collector,
+// as a span per event with the fields of the event as attributes. Events carrying the
+// trace_id and span_id of a distributed trace link to its span, correlating kernel events
+// with the requests they happened for. Hash maps are ignored, as they are current state
+// rather than events.
+// It implements v1.MapWatcher.
+type Sink struct {
+	ctx       context.Context
+	opts      Opts
+	tracesURL string
+	// attributes of the resource of every request
+	resource []keyValue
+
+	lock    sync.Mutex
+	watched map[string]bool
+	closed  bool
+	dropped uint64
+
+	spans chan span
+	done  chan struct{}
+}
+
+// span, keyValue and the types below are the OTLP/HTTP JSON encoding of traces
+type span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Links             []link     `json:"links,omitempty"`
+}
+
+type link struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource struct {
+		Attributes []keyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type scopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+// New returns a sink exporting to the collector of the options, the context is used for logging.
+func New(ctx context.Context, opts Opts) (*Sink, error) {
+	opts.initDefaults()
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %s", opts.Endpoint)
+	}
+	resource := []keyValue{{Key: "service.name", Value: anyValue{StringValue: opts.ServiceName}}}
+	keys := make([]string, 0, len(opts.ResourceAttributes))
+	for k := range opts.ResourceAttributes {
+		if k != "service.name" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		resource = append(resource, keyValue{Key: k, Value: anyValue{StringValue: opts.ResourceAttributes[k]}})
+	}
+	return &Sink{
+		ctx:       ctx,
+		opts:      opts,
+		tracesURL: strings.TrimSuffix(opts.Endpoint, "/") + "/v1/traces",
+		resource:  resource,
+		watched:   map[string]bool{},
+		spans:     make(chan span, queueSize),
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// Start exports the spans until the sink is closed.
+func (s *Sink) Start() {
+	go s.run()
+}
+
+func (s *Sink) NewRingBuf(name string, keys []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.watched[name] = true
+}
+
+func (s *Sink) NewHashMap(name string, keys []string) {
+	// hash maps are not events
+}
+
+func (s *Sink) SendEntry(entry v1.MapEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.watched[entry.Name] || s.closed {
+		return
+	}
+	select {
+	case s.spans <- s.toSpan(time.Now(), entry):
+	default:
+		s.dropped++
+	}
+}
+
+// toSpan returns the span of an event, in a trace of its own as the event is not part
+// of the trace it carries the IDs of, but linked to it.
+func (s *Sink) toSpan(now time.Time, entry v1.MapEntry) span {
+	ts := strconv.FormatInt(now.UnixNano(), 10)
+	sp := span{
+		TraceID:           randomID(16),
+		SpanID:            randomID(8),
+		Name:              entry.Name,
+		Kind:              internalSpanKind,
+		StartTimeUnixNano: ts,
+		EndTimeUnixNano:   ts,
+		Attributes:        []keyValue{{Key: MapAttribute, Value: anyValue{StringValue: entry.Name}}},
+	}
+	for _, k := range sortedKeys(entry.Entry.Key) {
+		sp.Attributes = append(sp.Attributes, keyValue{Key: k, Value: anyValue{StringValue: entry.Entry.Key[k]}})
+	}
+	if c, ok := s.opts.Correlations[entry.Name]; ok {
+		traceID, spanID := entry.Entry.Key[c.TraceIDField], entry.Entry.Key[c.SpanIDField]
+		// a link needs both IDs, and all zero IDs are invalid, e.g. events outside of a request
+		if validID(traceID, 16) && validID(spanID, 8) {
+			sp.Links = append(sp.Links, link{TraceID: traceID, SpanID: spanID})
+		}
+	}
+	return sp
+}
+
+// Close sends the spans still buffered, and returns once they are exported or dropped.
+func (s *Sink) Close() {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.spans)
+	s.lock.Unlock()
+	<-s.done
+}
+
+func (s *Sink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []span
+	for {
+		select {
+		case sp, ok := <-s.spans:
+			if !ok {
+				s.flush(batch)
+				return
+			}
+			batch = append(batch, sp)
+			if len(batch) >= s.opts.BatchSize {
+				s.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			s.flush(batch)
+			batch = nil
+		}
+	}
+}
+
+// flush exports the batch, retrying while the collector is unavailable.
+func (s *Sink) flush(batch []span) {
+	logger := contextutils.LoggerFrom(s.ctx)
+	s.lock.Lock()
+	dropped := s.dropped
+	s.dropped = 0
+	s.lock.Unlock()
+	if dropped > 0 {
+		logger.Warnf("dropped %d events, the OTLP collector is too slow", dropped)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	req := exportRequest{ResourceSpans: []resourceSpans{{}}}
+	rs := &req.ResourceSpans[0]
+	rs.Resource.Attributes = s.resource
+	rs.ScopeSpans = []scopeSpans{{Spans: batch}}
+	rs.ScopeSpans[0].Scope.Name = scopeName
+	body, err := json.Marshal(req)
+	if err != nil {
+		logger.Errorf("could not encode %d spans: %v", len(batch), err)
+		return
+	}
+
+	backoff := minBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.export(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= s.opts.MaxRetries {
+			logger.Errorf("dropped %d spans, could not export them to %s: %v", len(batch), s.tracesURL, err)
+			return
+		}
+		logger.Debugf("retrying %d spans in %v: %v", len(batch), backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// export sends the spans, and returns whether they should be retried if it fails.
+func (s *Sink) export(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.tracesURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.opts.HTTPClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusBadGateway ||
+		resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout:
+		// the statuses the OTLP specification asks to retry
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+}
+
+func randomID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// validID returns whether the hex ID has the given size in bytes and is not all zeros.
+func validID(id string, size int) bool {
+	raw, err := hex.DecodeString(id)
+	if err != nil || len(raw) != size {
+		return false
+	}
+	for _, b := range raw {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(fields map[string]string) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
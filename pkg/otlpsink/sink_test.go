@@ -0,0 +1,127 @@
+package otlpsink_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/otlpsink"
+)
+
+type exported struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []attribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []struct {
+				TraceID    string      `json:"traceId"`
+				SpanID     string      `json:"spanId"`
+				Name       string      `json:"name"`
+				Attributes []attribute `json:"attributes"`
+				Links      []struct {
+					TraceID string `json:"traceId"`
+					SpanID  string `json:"spanId"`
+				} `json:"links"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+type attribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+// fakeCollector is unavailable for the first request
+type fakeCollector struct {
+	lock     sync.Mutex
+	requests int
+	exported []exported
+}
+
+func (c *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	Expect(r.URL.Path).To(Equal("/v1/traces"))
+	Expect(r.Header.Get("Authorization")).To(Equal("Bearer token"))
+	c.requests++
+	if c.requests == 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var req exported
+	Expect(json.NewDecoder(r.Body).Decode(&req)).To(Succeed())
+	c.exported = append(c.exported, req)
+}
+
+var _ = Describe("Sink", func() {
+	It("exports events as spans linked to the traces they carry the IDs of", func() {
+		collector := &fakeCollector{}
+		server := httptest.NewServer(collector)
+		defer server.Close()
+
+		sink, err := otlpsink.New(context.Background(), otlpsink.Opts{
+			Endpoint:           server.URL,
+			ServiceName:        "tcp-tracer",
+			ResourceAttributes: map[string]string{"team": "payments", "env": "prod"},
+			Headers:            map[string]string{"Authorization": "Bearer token"},
+			Correlations: map[string]otlpsink.Correlation{
+				"events": {TraceIDField: "trace", SpanIDField: "span"},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		sink.Start()
+		sink.NewRingBuf("events", []string{"daddr", "trace", "span"})
+		sink.NewHashMap("counts", []string{"daddr"})
+		sink.SendEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{
+			"daddr": "1.1.1.1",
+			"trace": "4bf92f3577b34da6a3ce929d0e0e4736",
+			"span":  "00f067aa0ba902b7",
+		}}})
+		sink.SendEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{
+			"daddr": "8.8.8.8",
+			"trace": "00000000000000000000000000000000",
+			"span":  "0000000000000000",
+		}}})
+		sink.SendEntry(v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"daddr": "1.1.1.1"}, Value: "1"}})
+		sink.Close()
+
+		Expect(collector.requests).To(Equal(2))
+		Expect(collector.exported).To(HaveLen(1))
+		rs := collector.exported[0].ResourceSpans[0]
+		Expect(rs.Resource.Attributes[0].Key).To(Equal("service.name"))
+		Expect(rs.Resource.Attributes[0].Value.StringValue).To(Equal("tcp-tracer"))
+		Expect(rs.Resource.Attributes).To(HaveLen(3))
+		Expect(rs.Resource.Attributes[1].Key).To(Equal("env"))
+		Expect(rs.Resource.Attributes[2].Key).To(Equal("team"))
+		Expect(rs.Resource.Attributes[2].Value.StringValue).To(Equal("payments"))
+		spans := rs.ScopeSpans[0].Spans
+		Expect(spans).To(HaveLen(2))
+
+		Expect(spans[0].Name).To(Equal("events"))
+		Expect(spans[0].TraceID).To(HaveLen(32))
+		Expect(spans[0].TraceID).NotTo(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(spans[0].Links).To(HaveLen(1))
+		Expect(spans[0].Links[0].TraceID).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(spans[0].Links[0].SpanID).To(Equal("00f067aa0ba902b7"))
+		Expect(spans[0].Attributes[0].Key).To(Equal(otlpsink.MapAttribute))
+		Expect(spans[0].Attributes[1].Key).To(Equal("daddr"))
+		Expect(spans[0].Attributes[1].Value.StringValue).To(Equal("1.1.1.1"))
+
+		// events outside of a trace are not linked
+		Expect(spans[1].Links).To(BeEmpty())
+	})
+
+	It("rejects invalid endpoints", func() {
+		_, err := otlpsink.New(context.Background(), otlpsink.Opts{Endpoint: "localhost:4318"})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,132 @@
+// Package overrides persists the runtime overrides of packages, e.g. the parameters and routes
+// set through the agent API, so they survive the restarts of the agents running them.
+package overrides
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+// FileName is the name of the overrides file of the config directory.
+const FileName = "overrides.json"
+
+// Store holds the overrides of packages by their digest in a file, read again by every access
+// so the agents of a node sharing it see the overrides set by the others. The file is
+// replaced atomically on every change.
+type Store struct {
+	path string
+	lock sync.Mutex
+}
+
+// Open returns the store of the file, created when overrides are first set.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	if _, err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns the overrides of the package of the digest, empty if it has none.
+func (s *Store) Get(dgst digest.Digest) (v1.PackageOverrides, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entries, err := s.load()
+	if err != nil {
+		return v1.PackageOverrides{}, err
+	}
+	return entries[dgst.String()], nil
+}
+
+// Set replaces the overrides of the package of the digest, clearing them if empty.
+func (s *Store) Set(dgst digest.Digest, overrides v1.PackageOverrides) error {
+	return s.Update(dgst, func(o *v1.PackageOverrides) {
+		*o = overrides
+	})
+}
+
+// Update changes the overrides of the package of the digest with the function, e.g. to only
+// set whether it is paused.
+func (s *Store) Update(dgst digest.Digest, update func(*v1.PackageOverrides)) error {
+	if err := dgst.Validate(); err != nil {
+		return fmt.Errorf("invalid package digest %q: %w", dgst, err)
+	}
+	return s.update(func(entries map[string]v1.PackageOverrides) {
+		overrides := entries[dgst.String()]
+		update(&overrides)
+		if isEmpty(overrides) {
+			delete(entries, dgst.String())
+		} else {
+			entries[dgst.String()] = overrides
+		}
+	})
+}
+
+// Clear removes the overrides of the package of the digest.
+func (s *Store) Clear(dgst digest.Digest) error {
+	return s.update(func(entries map[string]v1.PackageOverrides) {
+		delete(entries, dgst.String())
+	})
+}
+
+func (s *Store) update(update func(entries map[string]v1.PackageOverrides)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+	update(entries)
+	return s.save(entries)
+}
+
+func isEmpty(overrides v1.PackageOverrides) bool {
+	return len(overrides.Parameters) == 0 && len(overrides.Routes) == 0 && !overrides.Paused
+}
+
+// load reads the overrides of the file, none if it does not exist yet.
+func (s *Store) load() (map[string]v1.PackageOverrides, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return map[string]v1.PackageOverrides{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string]v1.PackageOverrides{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("could not parse overrides file %s: %w", s.path, err)
+	}
+	return entries, nil
+}
+
+// save writes the overrides to the file, replacing it atomically.
+func (s *Store) save(entries map[string]v1.PackageOverrides) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
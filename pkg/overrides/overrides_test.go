@@ -0,0 +1,80 @@
+package overrides_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/overrides"
+)
+
+var _ = Describe("Store", func() {
+	var (
+		dir  string
+		path string
+		dgst = digest.FromString("tcpconnect")
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "bee-overrides")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "config", overrides.FileName)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("persists the overrides by digest", func() {
+		store, err := overrides.Open(path)
+		Expect(err).NotTo(HaveOccurred())
+		set := v1.PackageOverrides{
+			Parameters: map[string]string{"target_pid": "1234"},
+			Routes:     map[string][]string{"opensearch": {"events_ring"}},
+		}
+		Expect(store.Set(dgst, set)).To(Succeed())
+
+		// as after a restart
+		reopened, err := overrides.Open(path)
+		Expect(err).NotTo(HaveOccurred())
+		got, err := reopened.Get(dgst)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal(set))
+		got, err = reopened.Get(digest.FromString("opensnoop"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal(v1.PackageOverrides{}))
+	})
+
+	It("updates and clears the overrides", func() {
+		store, err := overrides.Open(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.Set(dgst, v1.PackageOverrides{Parameters: map[string]string{"target_pid": "1234"}})).To(Succeed())
+		Expect(store.Update(dgst, func(o *v1.PackageOverrides) { o.Paused = true })).To(Succeed())
+		got, err := store.Get(dgst)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal(v1.PackageOverrides{Parameters: map[string]string{"target_pid": "1234"}, Paused: true}))
+
+		Expect(store.Clear(dgst)).To(Succeed())
+		got, err = store.Get(dgst)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal(v1.PackageOverrides{}))
+		data, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("{}"))
+	})
+
+	It("rejects invalid digests and files", func() {
+		store, err := overrides.Open(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.Set("tcpconnect", v1.PackageOverrides{Paused: true})).To(MatchError(ContainSubstring("invalid package digest")))
+
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(os.WriteFile(path, []byte("not json"), 0644)).To(Succeed())
+		_, err = overrides.Open(path)
+		Expect(err).To(MatchError(ContainSubstring("could not parse overrides file")))
+	})
+})
@@ -0,0 +1,13 @@
+package parquetsink_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestParquetSink(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Parquet Sink Suite")
+}
@@ -0,0 +1,67 @@
+package parquetsink
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+type s3Uploader struct {
+	bucket   string
+	prefix   string
+	baseDir  string
+	uploader *s3manager.Uploader
+}
+
+// NewS3Uploader returns an uploader to the bucket and optional prefix of the URL, e.g.
+// `s3://my-bucket/bee/tcpconnect`. Files are uploaded under their path relative to
+// baseDir. The credentials and region are taken from the environment and the shared
+// AWS config, as for the aws CLI.
+func NewS3Uploader(bucketURL, baseDir string) (Uploader, error) {
+	u, err := url.Parse(bucketURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 URL: %w", err)
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 URL %s, expected s3://BUCKET[/PREFIX]", bucketURL)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create AWS session: %w", err)
+	}
+	return &s3Uploader{
+		bucket:   u.Host,
+		prefix:   strings.Trim(u.Path, "/"),
+		baseDir:  baseDir,
+		uploader: s3manager.NewUploader(sess),
+	}, nil
+}
+
+func (u *s3Uploader) Upload(ctx context.Context, file string) error {
+	rel, err := filepath.Rel(u.baseDir, file)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	key := path.Join(u.prefix, filepath.ToSlash(rel))
+	_, err = u.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: &u.bucket,
+		Key:    &key,
+		Body:   f,
+	})
+	return err
+}
@@ -0,0 +1,261 @@
+package parquetsink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/go-utils/contextutils"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	defaultMaxFileSize = 64 * 1024 * 1024
+	defaultMaxFileAge  = time.Hour
+
+	// rows are buffered in memory up to this size before being written as a row group
+	rowGroupSize = 8 * 1024 * 1024
+
+	// TimeColumn is the column holding the time each event was received at
+	TimeColumn = "time"
+)
+
+var invalidColumnChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Uploader uploads the files the sink is done writing, e.g. to S3.
+type Uploader interface {
+	Upload(ctx context.Context, path string) error
+}
+
+type Opts struct {
+	// Directory the files are written to, in a sub directory per map
+	Dir string
+	// A file is rotated once about this many bytes of events were written to it,
+	// defaults to 64MiB
+	MaxFileSize int64
+	// A file is rotated once it is this old, defaults to 1h
+	MaxFileAge time.Duration
+	// Uploads the rotated files if set
+	Uploader Uploader
+	// Removes the files once uploaded
+	RemoveUploaded bool
+}
+
+func (o *Opts) initDefaults() {
+	if o.MaxFileSize == 0 {
+		o.MaxFileSize = defaultMaxFileSize
+	}
+	if o.MaxFileAge == 0 {
+		o.MaxFileAge = defaultMaxFileAge
+	}
+}
+
+// Sink writes the events of the ring buffers of a program as Parquet files, with a
+// string column per field of the event struct and the time the event was received.
+// Hash maps are ignored, as they are current state rather than events.
+// It implements v1.MapWatcher.
+type Sink struct {
+	ctx  context.Context
+	opts Opts
+
+	lock    sync.Mutex
+	maps    map[string]*mapFile
+	uploads sync.WaitGroup
+	closed  bool
+}
+
+// mapFile is the file currently written for a map.
+type mapFile struct {
+	name    string
+	keys    []string
+	columns []string
+
+	path    string
+	file    *os.File
+	writer  *writer.CSVWriter
+	created time.Time
+	written int64
+	// number of files created, so files created within the same millisecond get distinct names
+	files int
+}
+
+// New returns a sink writing to the directory of the options, the context is used
+// for logging.
+func New(ctx context.Context, opts Opts) (*Sink, error) {
+	opts.initDefaults()
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create parquet directory: %w", err)
+	}
+	return &Sink{
+		ctx:  ctx,
+		opts: opts,
+		maps: map[string]*mapFile{},
+	}, nil
+}
+
+// Start rotates files once they are too old, even when no events are received, until
+// the context is done.
+func (s *Sink) Start(ctx context.Context) {
+	interval := s.opts.MaxFileAge / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.rotateOld(time.Now())
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (s *Sink) NewRingBuf(name string, keys []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	columns := make([]string, len(keys))
+	seen := map[string]bool{TimeColumn: true}
+	for i, key := range keys {
+		column := invalidColumnChars.ReplaceAllString(key, "_")
+		for seen[column] {
+			column += "_"
+		}
+		seen[column] = true
+		columns[i] = column
+	}
+	s.maps[name] = &mapFile{name: name, keys: keys, columns: columns}
+}
+
+func (s *Sink) NewHashMap(name string, keys []string) {
+	// hash maps are not events
+}
+
+func (s *Sink) SendEntry(entry v1.MapEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	mf, ok := s.maps[entry.Name]
+	if !ok || s.closed {
+		return
+	}
+	now := time.Now()
+	if mf.writer == nil {
+		if err := s.open(mf, now); err != nil {
+			contextutils.LoggerFrom(s.ctx).Errorf("could not create parquet file for %s: %v", mf.name, err)
+			return
+		}
+	}
+
+	ts := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	row := make([]*string, 0, len(mf.keys)+1)
+	row = append(row, &ts)
+	size := int64(len(ts))
+	for _, key := range mf.keys {
+		if value, ok := entry.Entry.Key[key]; ok {
+			row = append(row, &value)
+			size += int64(len(value))
+		} else {
+			row = append(row, nil)
+		}
+	}
+	if err := mf.writer.WriteString(row); err != nil {
+		contextutils.LoggerFrom(s.ctx).Errorf("could not write event of %s to %s: %v", mf.name, mf.path, err)
+		return
+	}
+	mf.written += size
+	if mf.written >= s.opts.MaxFileSize {
+		s.rotate(mf)
+	}
+}
+
+// Close finishes writing all the files, and waits for their upload.
+func (s *Sink) Close() {
+	s.lock.Lock()
+	s.closed = true
+	for _, mf := range s.maps {
+		s.rotate(mf)
+	}
+	s.lock.Unlock()
+	s.uploads.Wait()
+}
+
+func (s *Sink) rotateOld(now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, mf := range s.maps {
+		if mf.writer != nil && now.Sub(mf.created) >= s.opts.MaxFileAge {
+			s.rotate(mf)
+		}
+	}
+}
+
+// open must be called with the lock held
+func (s *Sink) open(mf *mapFile, now time.Time) error {
+	dir := filepath.Join(s.opts.Dir, mf.name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	mf.files++
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s-%d.parquet", mf.name, now.UTC().Format("20060102T150405.000Z"), mf.files))
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	metadata := []string{fmt.Sprintf("name=%s, type=INT64, convertedtype=TIMESTAMP_MILLIS", TimeColumn)}
+	for _, column := range mf.columns {
+		metadata = append(metadata, fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL", column))
+	}
+	w, err := writer.NewCSVWriterFromWriter(metadata, f, 1)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	w.RowGroupSize = rowGroupSize
+	mf.path, mf.file, mf.writer = path, f, w
+	mf.created, mf.written = now, 0
+	return nil
+}
+
+// rotate finishes the current file of the map, if any, and uploads it.
+// It must be called with the lock held.
+func (s *Sink) rotate(mf *mapFile) {
+	if mf.writer == nil {
+		return
+	}
+	path := mf.path
+	err := mf.writer.WriteStop()
+	if closeErr := mf.file.Close(); err == nil {
+		err = closeErr
+	}
+	mf.path, mf.file, mf.writer = "", nil, nil
+	if err != nil {
+		contextutils.LoggerFrom(s.ctx).Errorf("could not finish parquet file %s: %v", path, err)
+		return
+	}
+
+	if s.opts.Uploader == nil {
+		return
+	}
+	s.uploads.Add(1)
+	go func() {
+		defer s.uploads.Done()
+		// not bound to the context, so the last files are still uploaded on shutdown
+		if err := s.opts.Uploader.Upload(context.Background(), path); err != nil {
+			contextutils.LoggerFrom(s.ctx).Errorf("could not upload %s: %v", path, err)
+			return
+		}
+		if s.opts.RemoveUploaded {
+			os.Remove(path)
+		}
+	}()
+}
@@ -0,0 +1,96 @@
+package parquetsink_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/parquetsink"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+type fakeUploader struct {
+	lock  sync.Mutex
+	paths []string
+}
+
+func (u *fakeUploader) Upload(ctx context.Context, path string) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.paths = append(u.paths, path)
+	return nil
+}
+
+func readColumn(path, column string) []interface{} {
+	file, err := local.NewLocalFileReader(path)
+	Expect(err).NotTo(HaveOccurred())
+	defer file.Close()
+	pr, err := reader.NewParquetColumnReader(file, 1)
+	Expect(err).NotTo(HaveOccurred())
+	defer pr.ReadStop()
+	values, _, _, err := pr.ReadColumnByPath(common.ReformPathStr("parquet_go_root."+column), pr.GetNumRows())
+	Expect(err).NotTo(HaveOccurred())
+	return values
+}
+
+var _ = Describe("parquet sink", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("writes events with a column per field", func() {
+		uploader := &fakeUploader{}
+		sink, err := parquetsink.New(context.Background(), parquetsink.Opts{
+			Dir:      dir,
+			Uploader: uploader,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		sink.NewRingBuf("events", []string{"saddr", "daddr"})
+		sink.NewHashMap("counts", []string{"saddr"})
+		sink.SendEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"saddr": "10.0.0.1", "daddr": "10.0.0.2"}}})
+		sink.SendEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"saddr": "10.0.0.3"}}})
+		sink.SendEntry(v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"saddr": "10.0.0.1"}, Value: "1"}})
+		sink.Close()
+
+		files, err := filepath.Glob(filepath.Join(dir, "*", "*.parquet"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+		Expect(filepath.Base(filepath.Dir(files[0]))).To(Equal("events"))
+		Expect(uploader.paths).To(Equal(files))
+
+		Expect(readColumn(files[0], "saddr")).To(Equal([]interface{}{"10.0.0.1", "10.0.0.3"}))
+		Expect(readColumn(files[0], "daddr")).To(Equal([]interface{}{"10.0.0.2", nil}))
+		Expect(readColumn(files[0], parquetsink.TimeColumn)).To(HaveLen(2))
+	})
+
+	It("rotates files by size", func() {
+		sink, err := parquetsink.New(context.Background(), parquetsink.Opts{
+			Dir:         dir,
+			MaxFileSize: 1,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		sink.NewRingBuf("events", []string{"saddr"})
+		for i := 0; i < 3; i++ {
+			sink.SendEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"saddr": "10.0.0.1"}}})
+		}
+		sink.Close()
+
+		files, err := filepath.Glob(filepath.Join(dir, "events", "*.parquet"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(3))
+	})
+})
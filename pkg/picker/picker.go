@@ -0,0 +1,218 @@
+package picker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/cilium/ebpf"
+	"github.com/manifoldco/promptui"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"oras.land/oras-go/pkg/content"
+)
+
+// Entry is a package offered by the picker.
+type Entry struct {
+	Ref string
+	// Description of the package, only known for the packages of the local store until
+	// picked, as the others would have to be pulled
+	Description string
+	// Where the package was found, e.g. "local store" or "tag of ghcr.io/solo-io/bumblebee/tcpconnect"
+	Source string
+}
+
+// Options configure the packages offered by the picker, and the terminal it runs in.
+type Options struct {
+	// Completer of the refs of the local store and of the tags of the repositories
+	Completer *spec.Completer
+	// Registry the picked package is pulled from
+	Registry *spec.LocalRegistry
+	// Directory of the local store the descriptions of the local packages are read from,
+	// defaults to spec.EbpfImageDir
+	StorageDir string
+	// Repositories whose tags are offered, along with the repositories of the local store and
+	// the ones whose tags were cached by shell completions
+	Repositories []string
+
+	// Terminal of the prompts, stdin and stdout by default
+	Stdin  io.ReadCloser
+	Stdout io.WriteCloser
+}
+
+// Selection is the package picked and the values of its parameters.
+type Selection struct {
+	Ref     string
+	Package *v1.EbpfPackage
+	// Values of the parameters set to other values than their default
+	Parameters map[string]string
+}
+
+// List returns the packages of the local store, then the tags of the repositories, sorted by
+// ref. Registries which can't be reached are skipped, their error returned along with the
+// packages found.
+func List(ctx context.Context, opts Options) ([]Entry, error) {
+	completions, err := opts.Completer.Complete(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	storageDir := opts.StorageDir
+	if storageDir == "" {
+		storageDir = spec.EbpfImageDir
+	}
+	store, err := content.NewOCI(storageDir)
+	if err != nil {
+		return nil, err
+	}
+	client := spec.NewEbpfOCICLient()
+
+	var entries []Entry
+	seen := map[string]bool{}
+	repos := append([]string{}, opts.Repositories...)
+	for _, c := range completions {
+		if strings.HasSuffix(c.Ref, ":") {
+			repos = append(repos, strings.TrimSuffix(c.Ref, ":"))
+			continue
+		}
+		entry := Entry{Ref: c.Ref, Source: c.Description}
+		// read from the store only, rather than pulled, so listing never needs the registry
+		if pkg, err := client.Pull(ctx, c.Ref, store); err == nil {
+			entry.Description = pkg.Description
+		}
+		seen[c.Ref] = true
+		entries = append(entries, entry)
+	}
+
+	var tagsErr error
+	seenRepos := map[string]bool{}
+	var tags []Entry
+	for _, repo := range repos {
+		if seenRepos[repo] {
+			continue
+		}
+		seenRepos[repo] = true
+		completions, err := opts.Completer.Complete(ctx, repo+":")
+		if err != nil && tagsErr == nil {
+			tagsErr = err
+		}
+		for _, c := range completions {
+			if !seen[c.Ref] {
+				seen[c.Ref] = true
+				tags = append(tags, Entry{Ref: c.Ref, Source: c.Description})
+			}
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Ref < tags[j].Ref
+	})
+	return append(entries, tags...), tagsErr
+}
+
+// Match reports whether the characters of the query appear in order in the string, ignoring
+// case and spaces, e.g. "tcpc" matches "ghcr.io/solo-io/bumblebee/tcpconnect:v1".
+func Match(query, s string) bool {
+	s = strings.ToLower(s)
+	for _, r := range strings.ToLower(query) {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		i := strings.IndexRune(s, r)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(string(r)):]
+	}
+	return true
+}
+
+// Pick lets the user pick a package in a fuzzy finder, then prompts for the values of the
+// parameters it declares, validated against their types.
+func Pick(ctx context.Context, opts Options) (*Selection, error) {
+	entries, err := List(ctx, opts)
+	if len(entries) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("no packages found, pull one with `bee pull` or pass a repository to list the tags of")
+	}
+
+	prompt := promptui.Select{
+		Label: "Package to run (type to search)",
+		Items: entries,
+		Size:  10,
+		Templates: &promptui.SelectTemplates{
+			Label:    "{{ . }}",
+			Active:   "> {{ .Ref | cyan }}",
+			Inactive: "  {{ .Ref }}",
+			Selected: "Package: {{ .Ref | green }}",
+			Details: `
+{{ if .Description }}{{ .Description }}{{ else }}{{ "No description until pulled" | faint }}{{ end }}
+{{ .Source | faint }}`,
+		},
+		Searcher: func(input string, index int) bool {
+			return Match(input, entries[index].Ref+" "+entries[index].Description)
+		},
+		StartInSearchMode: true,
+		Stdin:             opts.Stdin,
+		Stdout:            opts.Stdout,
+	}
+	i, _, err := prompt.Run()
+	if err != nil {
+		return nil, err
+	}
+	ref := entries[i].Ref
+
+	pkg, err := opts.Registry.Pull(ctx, ref, nil)
+	if err != nil {
+		return nil, err
+	}
+	var out io.Writer = os.Stdout
+	if opts.Stdout != nil {
+		out = opts.Stdout
+	}
+	if pkg.Description != "" {
+		fmt.Fprintf(out, "%s\n", pkg.Description)
+	}
+
+	params, err := Parameters(pkg)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, param := range params {
+		param := param
+		p := promptui.Prompt{
+			Label:   fmt.Sprintf("%s (%s)", param.Name, param.Type),
+			Default: param.Default,
+			Validate: func(value string) error {
+				_, err := param.Parse(value)
+				return err
+			},
+			Stdin:  opts.Stdin,
+			Stdout: opts.Stdout,
+		}
+		value, err := p.Run()
+		if err != nil {
+			return nil, err
+		}
+		if value != param.Default {
+			values[param.Name] = value
+		}
+	}
+	return &Selection{Ref: ref, Package: pkg, Parameters: values}, nil
+}
+
+// Parameters returns the parameters declared by the program of the package.
+func Parameters(pkg *v1.EbpfPackage) ([]loader.Parameter, error) {
+	collSpec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(pkg.ProgramFileBytes))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the program of the package: %w", err)
+	}
+	return (&loader.ParsedELF{Spec: collSpec}).Parameters(), nil
+}
@@ -0,0 +1,50 @@
+package picker_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/picker"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"oras.land/oras-go/pkg/content"
+)
+
+var _ = Describe("picker", func() {
+	It("matches queries fuzzily", func() {
+		Expect(picker.Match("tcpc", "ghcr.io/solo-io/bumblebee/tcpconnect:v1")).To(BeTrue())
+		Expect(picker.Match("TCP v1", "ghcr.io/solo-io/bumblebee/tcpconnect:v1")).To(BeTrue())
+		Expect(picker.Match("", "anything")).To(BeTrue())
+		Expect(picker.Match("ctp", "tcpconnect")).To(BeFalse())
+	})
+
+	It("lists the packages of the local store with their descriptions", func() {
+		dir, err := os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		store, err := content.NewOCI(dir)
+		Expect(err).NotTo(HaveOccurred())
+
+		ctx := context.Background()
+		client := spec.NewEbpfOCICLient()
+		for ref, description := range map[string]string{
+			"localhost:5000/tcpconnect:v1": "Traces TCP connections",
+			"localhost:5000/opensnoop:v1":  "Traces opened files",
+		} {
+			err := client.Push(ctx, ref, store, &v1.EbpfPackage{ProgramFileBytes: []byte("program"), Description: description})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		completer := spec.NewCompleter(dir, filepath.Join(dir, "cache.json"), content.RegistryOptions{})
+		completer.Offline = true
+		entries, err := picker.List(ctx, picker.Options{Completer: completer, StorageDir: dir})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(Equal([]picker.Entry{
+			{Ref: "localhost:5000/opensnoop:v1", Description: "Traces opened files", Source: "local store"},
+			{Ref: "localhost:5000/tcpconnect:v1", Description: "Traces TCP connections", Source: "local store"},
+		}))
+	})
+})
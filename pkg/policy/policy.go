@@ -0,0 +1,337 @@
+// Package policy persists the policies of the enforcement maps of programs, e.g. the allow and
+// deny lists of LSM and XDP programs, as versions which can be restored, along with an audit
+// log of who changed them, what and when.
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+// DefaultMaxVersions is the number of versions of a policy kept by default, the audit log
+// being kept entirely.
+const DefaultMaxVersions = 100
+
+var (
+	// ErrInvalid is returned for the updates which can't be applied to the policy
+	ErrInvalid = errors.New("invalid policy update")
+	// ErrConflict is returned for the updates made from a version of the policy which is not
+	// the current one anymore
+	ErrConflict = errors.New("the policy changed since")
+	// ErrNotFound is returned for the versions which are not kept
+	ErrNotFound = errors.New("no such policy version")
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// file is the content of the file of a policy, the versions and audit log being written at
+// once so no change is left unaudited.
+type file struct {
+	// the oldest first
+	Versions []v1.Policy           `json:"versions"`
+	Audit    []v1.PolicyAuditEntry `json:"audit"`
+}
+
+// Store holds the versions of a policy in a file, read again by every access so the agents
+// of a node sharing it see the changes of the others. The file is replaced atomically on
+// every change.
+type Store struct {
+	path        string
+	maxVersions int
+	lock        sync.Mutex
+	now         func() time.Time
+}
+
+// Open returns the store of the policy of the name in the directory, e.g. the one of an
+// enforcement package, shared by its versions. The file is created when the policy is first
+// changed.
+func Open(dir, name string) (*Store, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid policy name %q, only letters, digits, '.', '_' and '-' are allowed", name)
+	}
+	s := &Store{path: filepath.Join(dir, name+".json"), maxVersions: DefaultMaxVersions, now: time.Now}
+	if _, err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetMaxVersions sets the number of versions kept, the oldest ones being dropped once a
+// change makes more of them.
+func (s *Store) SetMaxVersions(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.maxVersions = n
+}
+
+// Current returns the current version of the policy, the empty version 0 if it was never
+// changed.
+func (s *Store) Current() (v1.Policy, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f, err := s.load()
+	if err != nil {
+		return v1.Policy{}, err
+	}
+	return f.current(), nil
+}
+
+// Versions returns the versions of the policy kept, the latest first.
+func (s *Store) Versions() ([]v1.Policy, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]v1.Policy, 0, len(f.Versions))
+	for i := len(f.Versions) - 1; i >= 0; i-- {
+		versions = append(versions, f.Versions[i])
+	}
+	return versions, nil
+}
+
+// Version returns a version of the policy, the empty one for version 0.
+func (s *Store) Version(version int) (v1.Policy, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f, err := s.load()
+	if err != nil {
+		return v1.Policy{}, err
+	}
+	return f.version(version)
+}
+
+// Audit returns the audit log of the changes of the policy, the oldest first.
+func (s *Store) Audit() ([]v1.PolicyAuditEntry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return f.Audit, nil
+}
+
+// Update adds and removes the entries of the update to the current version of the policy,
+// on behalf of the client, and records the new version once applied by the function, e.g. to
+// the maps of the program. Nothing changes if the function fails.
+func (s *Store) Update(update v1.PolicyUpdate, by string, apply func(v1.Policy) error) (v1.Policy, error) {
+	if update.Map == "" {
+		return v1.Policy{}, fmt.Errorf("%w: the map must be set", ErrInvalid)
+	}
+	if len(update.Add) == 0 && len(update.Remove) == 0 {
+		return v1.Policy{}, fmt.Errorf("%w: nothing to add or remove", ErrInvalid)
+	}
+	return s.change(by, apply, func(f *file) (v1.Policy, v1.PolicyAuditEntry, error) {
+		current := f.current()
+		if update.Version != 0 && update.Version != current.Version {
+			return v1.Policy{}, v1.PolicyAuditEntry{}, fmt.Errorf("%w: the update was made from version %d, the policy is at version %d", ErrConflict, update.Version, current.Version)
+		}
+		entries, err := updateEntries(current.Maps[update.Map], update)
+		if err != nil {
+			return v1.Policy{}, v1.PolicyAuditEntry{}, err
+		}
+		next := v1.Policy{Maps: map[string][]v1.PolicyEntry{}}
+		for name, mapEntries := range current.Maps {
+			next.Maps[name] = mapEntries
+		}
+		if len(entries) == 0 {
+			delete(next.Maps, update.Map)
+		} else {
+			next.Maps[update.Map] = entries
+		}
+		return next, v1.PolicyAuditEntry{
+			Action:  v1.PolicyUpdateAction,
+			Map:     update.Map,
+			Added:   update.Add,
+			Removed: update.Remove,
+			Comment: update.Comment,
+		}, nil
+	})
+}
+
+// Rollback restores a version of the policy as a new version, on behalf of the client, once
+// applied by the function.
+func (s *Store) Rollback(version int, by, comment string, apply func(v1.Policy) error) (v1.Policy, error) {
+	return s.change(by, apply, func(f *file) (v1.Policy, v1.PolicyAuditEntry, error) {
+		target, err := f.version(version)
+		if err != nil {
+			return v1.Policy{}, v1.PolicyAuditEntry{}, err
+		}
+		return v1.Policy{Maps: target.Maps}, v1.PolicyAuditEntry{
+			Action:     v1.PolicyRollbackAction,
+			RolledBack: version,
+			Comment:    comment,
+		}, nil
+	})
+}
+
+// change creates the next version of the policy from the file with the function, applies it,
+// then records it along with its audit entry.
+func (s *Store) change(by string, apply func(v1.Policy) error, next func(*file) (v1.Policy, v1.PolicyAuditEntry, error)) (v1.Policy, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f, err := s.load()
+	if err != nil {
+		return v1.Policy{}, err
+	}
+	current := f.current()
+	policy, entry, err := next(f)
+	if err != nil {
+		return v1.Policy{}, err
+	}
+	now := s.now().UTC()
+	policy.Version, policy.Time, policy.By = current.Version+1, now, by
+	entry.Version, entry.Time, entry.By = policy.Version, now, by
+	if err := apply(policy); err != nil {
+		return v1.Policy{}, err
+	}
+
+	f.Versions = append(f.Versions, policy)
+	if s.maxVersions > 0 && len(f.Versions) > s.maxVersions {
+		f.Versions = f.Versions[len(f.Versions)-s.maxVersions:]
+	}
+	f.Audit = append(f.Audit, entry)
+	if err := s.save(f); err != nil {
+		// the maps are left as the recorded version says
+		if restoreErr := apply(current); restoreErr != nil {
+			return v1.Policy{}, fmt.Errorf("could not record policy version %d: %v, nor restore version %d: %w", policy.Version, err, current.Version, restoreErr)
+		}
+		return v1.Policy{}, fmt.Errorf("could not record policy version %d, version %d was restored: %w", policy.Version, current.Version, err)
+	}
+	return policy, nil
+}
+
+// updateEntries returns the entries of a map once the keys of the update are removed and its
+// entries added, keys being compared as they are written.
+func updateEntries(entries []v1.PolicyEntry, update v1.PolicyUpdate) ([]v1.PolicyEntry, error) {
+	index := map[string]int{}
+	for i, entry := range entries {
+		key, err := keyOf(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+		index[key] = i
+	}
+	removed := map[int]bool{}
+	for _, k := range update.Remove {
+		key, err := keyOf(k)
+		if err != nil {
+			return nil, err
+		}
+		i, ok := index[key]
+		if !ok || removed[i] {
+			return nil, fmt.Errorf("%w: map %s has no entry of key %s", ErrInvalid, update.Map, key)
+		}
+		removed[i] = true
+	}
+	var updated []v1.PolicyEntry
+	for i, entry := range entries {
+		if !removed[i] {
+			updated = append(updated, entry)
+		}
+	}
+	index = map[string]int{}
+	for i, entry := range updated {
+		key, _ := keyOf(entry.Key)
+		index[key] = i
+	}
+	for _, entry := range update.Add {
+		if entry.Key == nil {
+			return nil, fmt.Errorf("%w: the entries added must have a key", ErrInvalid)
+		}
+		key, err := keyOf(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := index[key]; ok {
+			updated[i] = entry
+			continue
+		}
+		index[key] = len(updated)
+		updated = append(updated, entry)
+	}
+	return updated, nil
+}
+
+// keyOf returns the JSON encoding of a key, the fields of structs being sorted.
+func keyOf(key interface{}) (string, error) {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid key %v: %v", ErrInvalid, key, err)
+	}
+	return string(data), nil
+}
+
+func (f *file) current() v1.Policy {
+	if len(f.Versions) == 0 {
+		return v1.Policy{}
+	}
+	return f.Versions[len(f.Versions)-1]
+}
+
+func (f *file) version(version int) (v1.Policy, error) {
+	if version == 0 {
+		return v1.Policy{}, nil
+	}
+	for _, policy := range f.Versions {
+		if policy.Version == version {
+			return policy, nil
+		}
+	}
+	return v1.Policy{}, fmt.Errorf("%w %d, only the last %d versions are kept", ErrNotFound, version, len(f.Versions))
+}
+
+// load reads the policy of the file, the empty one if it does not exist yet. Numbers are
+// kept as written, so 64 bits keys are not rounded.
+func (s *Store) load() (*file, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return &file{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("could not parse policy file %s: %w", s.path, err)
+	}
+	return &f, nil
+}
+
+// save writes the policy to the file, replacing it atomically.
+func (s *Store) save(f *file) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,167 @@
+package policy_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/policy"
+)
+
+var _ = Describe("Store", func() {
+	var (
+		dir     string
+		store   *policy.Store
+		applied []v1.Policy
+	)
+
+	apply := func(p v1.Policy) error {
+		applied = append(applied, p)
+		return nil
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "bee-policy")
+		Expect(err).NotTo(HaveOccurred())
+		store, err = policy.Open(filepath.Join(dir, "policies"), "xdp-deny")
+		Expect(err).NotTo(HaveOccurred())
+		applied = nil
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("versions the changes of the policy", func() {
+		current, err := store.Current()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(current).To(Equal(v1.Policy{}))
+
+		updated, err := store.Update(v1.PolicyUpdate{
+			Map: "denylist",
+			Add: []v1.PolicyEntry{{Key: "10.0.0.1"}, {Key: "10.0.0.2", Value: 2}},
+		}, "alice", apply)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.Version).To(Equal(1))
+		Expect(updated.By).To(Equal("alice"))
+		Expect(updated.Time).NotTo(BeZero())
+		Expect(applied).To(Equal([]v1.Policy{updated}))
+
+		// values are replaced, and maps without entries dropped
+		updated, err = store.Update(v1.PolicyUpdate{
+			Map:     "denylist",
+			Add:     []v1.PolicyEntry{{Key: "10.0.0.2", Value: 3}},
+			Remove:  []interface{}{"10.0.0.1"},
+			Version: 1,
+			Comment: "unblock the office",
+		}, "bob", apply)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.Version).To(Equal(2))
+		Expect(updated.Maps).To(Equal(map[string][]v1.PolicyEntry{"denylist": {{Key: "10.0.0.2", Value: 3}}}))
+		updated, err = store.Update(v1.PolicyUpdate{Map: "denylist", Remove: []interface{}{"10.0.0.2"}}, "bob", apply)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.Maps).To(BeEmpty())
+
+		// as after a restart, the numbers kept as written
+		reopened, err := policy.Open(filepath.Join(dir, "policies"), "xdp-deny")
+		Expect(err).NotTo(HaveOccurred())
+		versions, err := reopened.Versions()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(HaveLen(3))
+		Expect(versions[0].Version).To(Equal(3))
+		Expect(versions[1].Maps["denylist"]).To(Equal([]v1.PolicyEntry{{Key: "10.0.0.2", Value: json.Number("3")}}))
+
+		audit, err := reopened.Audit()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(audit).To(HaveLen(3))
+		Expect(audit[1].Version).To(Equal(2))
+		Expect(audit[1].By).To(Equal("bob"))
+		Expect(audit[1].Action).To(Equal(v1.PolicyUpdateAction))
+		Expect(audit[1].Map).To(Equal("denylist"))
+		Expect(audit[1].Removed).To(Equal([]interface{}{"10.0.0.1"}))
+		Expect(audit[1].Comment).To(Equal("unblock the office"))
+	})
+
+	It("restores previous versions", func() {
+		first, err := store.Update(v1.PolicyUpdate{Map: "denylist", Add: []v1.PolicyEntry{{Key: "10.0.0.1"}}}, "alice", apply)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = store.Update(v1.PolicyUpdate{Map: "denylist", Remove: []interface{}{"10.0.0.1"}}, "alice", apply)
+		Expect(err).NotTo(HaveOccurred())
+
+		restored, err := store.Rollback(1, "bob", "the office is compromised", apply)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restored.Version).To(Equal(3))
+		Expect(restored.Maps).To(Equal(first.Maps))
+		audit, err := store.Audit()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(audit[2].Action).To(Equal(v1.PolicyRollbackAction))
+		Expect(audit[2].RolledBack).To(Equal(1))
+		Expect(audit[2].By).To(Equal("bob"))
+
+		// version 0 is the empty policy
+		restored, err = store.Rollback(0, "bob", "", apply)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restored.Maps).To(BeEmpty())
+
+		store.SetMaxVersions(2)
+		_, err = store.Rollback(1, "bob", "", apply)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = store.Version(2)
+		Expect(err).To(MatchError(policy.ErrNotFound))
+		versions, err := store.Versions()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(HaveLen(2))
+		// the audit log is kept entirely
+		audit, err = store.Audit()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(audit).To(HaveLen(5))
+	})
+
+	It("rejects the updates which can't be applied", func() {
+		_, err := store.Update(v1.PolicyUpdate{Map: "denylist", Add: []v1.PolicyEntry{{Key: "10.0.0.1"}}}, "alice", apply)
+		Expect(err).NotTo(HaveOccurred())
+
+		for update, message := range map[*v1.PolicyUpdate]string{
+			{Add: []v1.PolicyEntry{{Key: "10.0.0.1"}}}:                       "the map must be set",
+			{Map: "denylist"}:                                                "nothing to add or remove",
+			{Map: "denylist", Remove: []interface{}{"10.0.0.2"}}:             "map denylist has no entry of key \"10.0.0.2\"",
+			{Map: "denylist", Add: []v1.PolicyEntry{{Value: 1}}}:             "the entries added must have a key",
+			{Map: "denylist", Remove: []interface{}{"10.0.0.1", "10.0.0.1"}}: "no entry of key",
+		} {
+			_, err := store.Update(*update, "alice", apply)
+			Expect(err).To(MatchError(policy.ErrInvalid))
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		}
+		_, err = store.Update(v1.PolicyUpdate{Map: "denylist", Remove: []interface{}{"10.0.0.1"}, Version: 3}, "alice", apply)
+		Expect(err).To(MatchError(policy.ErrConflict))
+		Expect(err).To(MatchError(ContainSubstring("the update was made from version 3, the policy is at version 1")))
+		_, err = store.Rollback(7, "alice", "", apply)
+		Expect(err).To(MatchError(policy.ErrNotFound))
+
+		// nothing is recorded when the policy can't be applied
+		_, err = store.Update(v1.PolicyUpdate{Map: "denylist", Add: []v1.PolicyEntry{{Key: "nope"}}}, "alice", func(v1.Policy) error {
+			return errors.New("not an IPv4 address")
+		})
+		Expect(err).To(MatchError("not an IPv4 address"))
+		current, err := store.Current()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(current.Version).To(Equal(1))
+		audit, err := store.Audit()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(audit).To(HaveLen(1))
+	})
+
+	It("rejects invalid names and files", func() {
+		_, err := policy.Open(dir, "../escape")
+		Expect(err).To(MatchError(ContainSubstring("invalid policy name")))
+
+		Expect(os.WriteFile(filepath.Join(dir, "broken.json"), []byte("not json"), 0644)).To(Succeed())
+		_, err = policy.Open(dir, "broken")
+		Expect(err).To(MatchError(ContainSubstring("could not parse policy file")))
+	})
+})
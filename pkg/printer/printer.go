@@ -0,0 +1,268 @@
+package printer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/hashstructure/v2"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+type Format string
+
+const (
+	// JSONFormat prints an object per line
+	JSONFormat Format = "json"
+	// LogfmtFormat prints key=value pairs
+	LogfmtFormat Format = "logfmt"
+	// ColumnsFormat prints aligned columns, with a header per map
+	ColumnsFormat Format = "columns"
+
+	// TimeField is the field holding the time each entry was received at
+	TimeField = "time"
+	// MapField is the field holding the name of the map of each entry
+	MapField = "map"
+	// ValueField is the field holding the value of hash map entries
+	ValueField = "value"
+
+	// UTC with a fixed width, so the columns format stays aligned
+	timeFormat = "2006-01-02T15:04:05.000000Z"
+)
+
+// ParseFormat returns the format of the given name.
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(name); f {
+	case JSONFormat, LogfmtFormat, ColumnsFormat:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown output format %s, must be one of json, logfmt or columns", name)
+}
+
+type Opts struct {
+	// Format of the maps without one in MapFormats, defaults to JSONFormat
+	Format Format
+	// Format per map name
+	MapFormats map[string]Format
+	// Order of the fields per map name. Fields not listed are printed after, in the
+	// order they are declared in the struct of the map.
+	Fields map[string][]string
+}
+
+// Printer prints the entries of the maps of a program, one per line, e.g. to stdout.
+// Events are printed as they are received, and hash map entries when their value changes.
+// It implements v1.MapWatcher.
+type Printer struct {
+	w    io.Writer
+	opts Opts
+
+	lock sync.Mutex
+	maps map[string]*printedMap
+}
+
+type printedMap struct {
+	format Format
+	fields []string
+	// whether entries have a value
+	hash bool
+	// last value of hash map entries, keyed by the hash of the entry key
+	values map[uint64]string
+	// width of each column, and whether the header was printed, for the columns format
+	mapWidth      int
+	widths        map[string]int
+	headerPrinted bool
+}
+
+func New(w io.Writer, opts Opts) *Printer {
+	if opts.Format == "" {
+		opts.Format = JSONFormat
+	}
+	return &Printer{
+		w:    w,
+		opts: opts,
+		maps: map[string]*printedMap{},
+	}
+}
+
+func (p *Printer) NewRingBuf(name string, keys []string) {
+	p.newMap(name, keys, false)
+}
+
+func (p *Printer) NewHashMap(name string, keys []string) {
+	p.newMap(name, keys, true)
+}
+
+func (p *Printer) newMap(name string, keys []string, hash bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	format, ok := p.opts.MapFormats[name]
+	if !ok {
+		format = p.opts.Format
+	}
+	pm := &printedMap{
+		format: format,
+		fields: orderFields(p.opts.Fields[name], keys),
+		hash:   hash,
+		values: map[uint64]string{},
+		widths: map[string]int{},
+	}
+	if hash {
+		pm.fields = append(pm.fields, ValueField)
+	}
+	pm.mapWidth = len(MapField)
+	if len(name) > pm.mapWidth {
+		pm.mapWidth = len(name)
+	}
+	for _, field := range pm.fields {
+		pm.widths[field] = len(field)
+	}
+	p.maps[name] = pm
+}
+
+// orderFields returns the keys, those listed in order first.
+func orderFields(order, keys []string) []string {
+	declared := map[string]bool{}
+	for _, k := range keys {
+		declared[k] = true
+	}
+	fields := make([]string, 0, len(keys))
+	listed := map[string]bool{}
+	for _, k := range order {
+		if declared[k] && !listed[k] {
+			fields = append(fields, k)
+			listed[k] = true
+		}
+	}
+	for _, k := range keys {
+		if !listed[k] {
+			fields = append(fields, k)
+		}
+	}
+	return fields
+}
+
+func (p *Printer) SendEntry(entry v1.MapEntry) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	pm, ok := p.maps[entry.Name]
+	if !ok {
+		return
+	}
+	if pm.hash {
+		hash, _ := hashstructure.Hash(entry.Entry.Key, hashstructure.FormatV2, nil)
+		if current, ok := pm.values[hash]; ok && current == entry.Entry.Value {
+			// hash maps are polled, only print changes
+			return
+		}
+		pm.values[hash] = entry.Entry.Value
+	}
+
+	values := make([]string, len(pm.fields))
+	for i, field := range pm.fields {
+		if pm.hash && i == len(pm.fields)-1 {
+			values[i] = entry.Entry.Value
+			continue
+		}
+		values[i] = entry.Entry.Key[field]
+	}
+	now := time.Now().UTC().Format(timeFormat)
+
+	var line []byte
+	switch pm.format {
+	case LogfmtFormat:
+		line = logfmtLine(now, entry.Name, pm.fields, values)
+	case ColumnsFormat:
+		line = pm.columnsLine(now, entry.Name, values)
+	default:
+		line = jsonLine(now, entry.Name, pm.fields, values)
+	}
+	p.w.Write(line)
+}
+
+func (p *Printer) Close() {
+	// nothing is buffered
+}
+
+// jsonLine renders the fields in order, which encoding/json does not do for maps.
+func jsonLine(now, name string, fields, values []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	writePair := func(k, v string) {
+		key, _ := json.Marshal(k)
+		value, _ := json.Marshal(v)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	writePair(TimeField, now)
+	buf.WriteByte(',')
+	writePair(MapField, name)
+	for i, field := range fields {
+		buf.WriteByte(',')
+		writePair(field, values[i])
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+func logfmtLine(now, name string, fields, values []string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s=%s %s=%s", TimeField, now, MapField, logfmtValue(name))
+	for i, field := range fields {
+		fmt.Fprintf(&buf, " %s=%s", logfmtKey(field), logfmtValue(values[i]))
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func logfmtKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// logfmtValue quotes values which are empty or contain spaces, equal signs or quotes.
+func logfmtValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\t\n\\") {
+		quoted, _ := json.Marshal(value)
+		return string(quoted)
+	}
+	return value
+}
+
+// columnsLine renders the values padded to the width of their column, preceded by
+// a header the first time the map is printed. Columns widen as longer values are seen.
+func (pm *printedMap) columnsLine(now, name string, values []string) []byte {
+	for i, field := range pm.fields {
+		if len(values[i]) > pm.widths[field] {
+			pm.widths[field] = len(values[i])
+		}
+	}
+	var buf bytes.Buffer
+	if !pm.headerPrinted {
+		pm.headerPrinted = true
+		header := make([]string, len(pm.fields))
+		for i, field := range pm.fields {
+			header[i] = strings.ToUpper(field)
+		}
+		pm.writeColumns(&buf, strings.ToUpper(TimeField), strings.ToUpper(MapField), header)
+	}
+	pm.writeColumns(&buf, now, name, values)
+	return buf.Bytes()
+}
+
+func (pm *printedMap) writeColumns(buf *bytes.Buffer, timeCell, mapCell string, cells []string) {
+	fmt.Fprintf(buf, "%-*s  %-*s", len(timeFormat), timeCell, pm.mapWidth, mapCell)
+	for i, field := range pm.fields {
+		fmt.Fprintf(buf, "  %-*s", pm.widths[field], cells[i])
+	}
+	buf.Truncate(len(bytes.TrimRight(buf.Bytes(), " ")))
+	buf.WriteByte('\n')
+}
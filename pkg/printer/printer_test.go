@@ -0,0 +1,68 @@
+package printer_test
+
+import (
+	"bytes"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/printer"
+)
+
+func conn(saddr, daddr string) v1.KvPair {
+	return v1.KvPair{Key: map[string]string{"saddr": saddr, "daddr": daddr}}
+}
+
+// lines returns the printed lines without their timestamp
+func lines(out *bytes.Buffer) []string {
+	var res []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		res = append(res, line[strings.Index(line, "Z")+1:])
+	}
+	return res
+}
+
+var _ = Describe("printer", func() {
+	var out *bytes.Buffer
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+	})
+
+	It("prints json objects with ordered fields", func() {
+		p := printer.New(out, printer.Opts{
+			Fields: map[string][]string{"events": {"daddr"}},
+		})
+		p.NewRingBuf("events", []string{"saddr", "daddr"})
+		p.SendEntry(v1.MapEntry{Name: "events", Entry: conn("10.0.0.1", "1.1.1.1")})
+		Expect(lines(out)).To(Equal([]string{`","map":"events","daddr":"1.1.1.1","saddr":"10.0.0.1"}`}))
+	})
+
+	It("prints logfmt, quoting values where needed", func() {
+		p := printer.New(out, printer.Opts{Format: printer.LogfmtFormat})
+		p.NewRingBuf("events", []string{"saddr", "daddr"})
+		p.SendEntry(v1.MapEntry{Name: "events", Entry: conn("10.0.0.1", `a "b"`)})
+		p.SendEntry(v1.MapEntry{Name: "events", Entry: conn("10.0.0.1", "")})
+		Expect(lines(out)).To(Equal([]string{
+			` map=events saddr=10.0.0.1 daddr="a \"b\""`,
+			` map=events saddr=10.0.0.1 daddr=""`,
+		}))
+	})
+
+	It("prints hash map entries in columns when they change", func() {
+		p := printer.New(out, printer.Opts{
+			MapFormats: map[string]printer.Format{"counts": printer.ColumnsFormat},
+		})
+		p.NewHashMap("counts", []string{"saddr"})
+		for _, value := range []string{"1", "1", "2"} {
+			p.SendEntry(v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"saddr": "10.0.0.1"}, Value: value}})
+		}
+		printed := strings.Split(strings.TrimSpace(out.String()), "\n")
+		Expect(printed).To(HaveLen(3))
+		Expect(printed[0]).To(MatchRegexp(`^TIME\s+MAP\s+SADDR\s+VALUE$`))
+		Expect(printed[1]).To(HaveSuffix("  counts  10.0.0.1  1"))
+		Expect(printed[2]).To(HaveSuffix("  counts  10.0.0.1  2"))
+		Expect(strings.Index(printed[0], "SADDR")).To(Equal(strings.Index(printed[1], "10.0.0.1")))
+	})
+})
@@ -0,0 +1,142 @@
+package privsep
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+
+	"github.com/cilium/ebpf"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"golang.org/x/sys/unix"
+)
+
+// NewLoader returns a loader which has the program loaded and attached by the helper
+// listening on the socket, and watches the maps it gets back with the given loader.
+// As the helper needs the ELF file, the loader can only load the given program.
+func NewLoader(socket string, prog io.ReaderAt, progLoader loader.Loader) loader.Loader {
+	return &helperLoader{
+		Loader: progLoader,
+		socket: socket,
+		prog:   prog,
+	}
+}
+
+type helperLoader struct {
+	loader.Loader
+	socket string
+	prog   io.ReaderAt
+}
+
+func (h *helperLoader) Load(ctx context.Context, opts *loader.LoadOptions) error {
+	// on shutdown notify watcher we have no more entries to send
+	defer opts.Watcher.Close()
+	// checked here, as the helper does not know the config of the package
+	if err := loader.CheckPrerequisites(opts.HostFeatures); err != nil {
+		return err
+	}
+
+	conn, err := net.DialUnix("unixpacket", nil, &net.UnixAddr{Name: h.socket, Net: "unixpacket"})
+	if err != nil {
+		return fmt.Errorf("could not connect to privileged helper: %w", err)
+	}
+	// closing the connection detaches the program
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	maps, links, err := h.attach(conn, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, m := range maps {
+			m.Close()
+		}
+		for _, l := range links {
+			l.Close()
+		}
+	}()
+	if opts.AfterAttach != nil {
+		if err := opts.AfterAttach(ctx); err != nil {
+			return err
+		}
+	}
+	return h.Loader.WatchMaps(ctx, opts, maps)
+}
+
+// attach returns the maps of the program and its bpf links, which keep the programs attached
+// with one until closed.
+func (h *helperLoader) attach(conn *net.UnixConn, opts *loader.LoadOptions) (map[string]*ebpf.Map, []*os.File, error) {
+	progFile, err := h.programFile()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = sendMsg(conn, attachRequest{
+		PinMaps:        opts.PinMaps,
+		PinProgs:       opts.PinProgs,
+		Interface:      opts.Interface,
+		Netns:          opts.Netns,
+		HostVeth:       opts.HostVeth,
+		ConflictPolicy: opts.ConflictPolicy,
+		Parameters:     opts.Parameters,
+		Cgroups:        opts.Cgroups,
+		ProbeVariants:  opts.ProbeVariants,
+		MultiProbes:    opts.MultiProbes,
+	}, []int{int(progFile.Fd())})
+	progFile.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not send program to privileged helper: %w", err)
+	}
+
+	var resp attachResponse
+	fds, err := recvMsg(conn, &resp)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read response of privileged helper: %w", err)
+	}
+	if resp.Error != "" {
+		closeFDs(fds)
+		return nil, nil, fmt.Errorf("privileged helper could not load program: %s", resp.Error)
+	}
+	if resp.Links < 0 || len(fds) != len(resp.Maps)+resp.Links {
+		closeFDs(fds)
+		return nil, nil, fmt.Errorf("privileged helper sent %d descriptors for %d maps and %d links", len(fds), len(resp.Maps), resp.Links)
+	}
+
+	mapFDs, linkFDs := fds[:len(resp.Maps)], fds[len(resp.Maps):]
+	maps := make(map[string]*ebpf.Map, len(mapFDs))
+	for i, fd := range mapFDs {
+		m, err := ebpf.NewMapFromFD(fd)
+		if err != nil {
+			for _, m := range maps {
+				m.Close()
+			}
+			closeFDs(fds[i:])
+			return nil, nil, fmt.Errorf("could not open map %s: %w", resp.Maps[i], err)
+		}
+		maps[resp.Maps[i]] = m
+	}
+	links := make([]*os.File, 0, len(linkFDs))
+	for _, fd := range linkFDs {
+		links = append(links, os.NewFile(uintptr(fd), "bpf link"))
+	}
+	return maps, links, nil
+}
+
+// programFile returns a memory file holding the program.
+func (h *helperLoader) programFile() (*os.File, error) {
+	fd, err := unix.MemfdCreate("bee-program", unix.MFD_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("could not create program file: %w", err)
+	}
+	f := os.NewFile(uintptr(fd), "bee-program")
+	if _, err := io.Copy(f, io.NewSectionReader(h.prog, 0, math.MaxInt64)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not write program file: %w", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,33 @@
+//go:build !linux
+// +build !linux
+
+package privsep
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/solo-io/bumblebee/pkg/loader"
+)
+
+var errUnsupported = errors.New("the privileged helper is only supported on linux")
+
+// NewLoader returns a loader failing to load the program, as the helper is only supported
+// on Linux.
+func NewLoader(socket string, prog io.ReaderAt, progLoader loader.Loader) loader.Loader {
+	return &helperLoader{Loader: progLoader}
+}
+
+type helperLoader struct {
+	loader.Loader
+}
+
+func (h *helperLoader) Load(ctx context.Context, opts *loader.LoadOptions) error {
+	defer opts.Watcher.Close()
+	return errUnsupported
+}
+
+func (s *Server) Serve(ctx context.Context) error {
+	return errUnsupported
+}
@@ -0,0 +1,96 @@
+package privsep
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// maximum size of the JSON part of a message
+	maxMessageSize = 64 * 1024
+	// maximum number of descriptors passed in a single message, SCM_MAX_FD in the kernel
+	maxFDs = 253
+)
+
+type attachRequest struct {
+	PinMaps        string                 `json:"pinMaps,omitempty"`
+	PinProgs       string                 `json:"pinProgs,omitempty"`
+	Interface      string                 `json:"interface,omitempty"`
+	Netns          []string               `json:"netns,omitempty"`
+	HostVeth       bool                   `json:"hostVeth,omitempty"`
+	ConflictPolicy loader.ConflictPolicy  `json:"conflictPolicy,omitempty"`
+	Parameters     map[string]string      `json:"parameters,omitempty"`
+	Cgroups        []string               `json:"cgroups,omitempty"`
+	ProbeVariants  []v1.ProbeVariantGroup `json:"probeVariants,omitempty"`
+	MultiProbes    []v1.MultiProbe        `json:"multiProbes,omitempty"`
+}
+
+type attachResponse struct {
+	// names of the maps, in the order of the passed descriptors
+	Maps []string `json:"maps,omitempty"`
+	// number of bpf link descriptors passed after the ones of the maps
+	Links int    `json:"links,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func sendMsg(conn *net.UnixConn, msg interface{}, fds []int) error {
+	if len(fds) > maxFDs {
+		return fmt.Errorf("can't pass more than %d descriptors, got %d", maxFDs, len(fds))
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	var oob []byte
+	if len(fds) > 0 {
+		oob = unix.UnixRights(fds...)
+	}
+	_, _, err = conn.WriteMsgUnix(data, oob, nil)
+	return err
+}
+
+// recvMsg reads a message and returns the descriptors passed with it, which the caller must close.
+func recvMsg(conn *net.UnixConn, msg interface{}) ([]int, error) {
+	data := make([]byte, maxMessageSize)
+	oob := make([]byte, unix.CmsgSpace(maxFDs*4))
+	n, oobn, flags, _, err := conn.ReadMsgUnix(data, oob)
+	if err != nil {
+		return nil, err
+	}
+
+	var fds []int
+	if oobn > 0 {
+		cmsgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse control message: %w", err)
+		}
+		for i := range cmsgs {
+			rights, err := unix.ParseUnixRights(&cmsgs[i])
+			if err != nil {
+				closeFDs(fds)
+				return nil, fmt.Errorf("could not parse passed descriptors: %w", err)
+			}
+			fds = append(fds, rights...)
+		}
+	}
+	if flags&(unix.MSG_TRUNC|unix.MSG_CTRUNC) != 0 {
+		closeFDs(fds)
+		return nil, fmt.Errorf("message truncated")
+	}
+	if err := json.Unmarshal(data[:n], msg); err != nil {
+		closeFDs(fds)
+		return nil, fmt.Errorf("could not decode message: %w", err)
+	}
+	return fds, nil
+}
+
+func closeFDs(fds []int) {
+	for _, fd := range fds {
+		unix.Close(fd)
+	}
+}
@@ -0,0 +1,125 @@
+package privsep
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"golang.org/x/sys/unix"
+)
+
+var _ = Describe("protocol", func() {
+	var client, server *net.UnixConn
+
+	BeforeEach(func() {
+		fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET, 0)
+		Expect(err).NotTo(HaveOccurred())
+		client = fileConn(fds[0])
+		server = fileConn(fds[1])
+	})
+
+	AfterEach(func() {
+		client.Close()
+		server.Close()
+	})
+
+	It("passes descriptors with messages", func() {
+		r, w, err := os.Pipe()
+		Expect(err).NotTo(HaveOccurred())
+		defer r.Close()
+
+		Expect(sendMsg(client, attachResponse{Maps: []string{"events"}}, []int{int(w.Fd())})).To(Succeed())
+		w.Close()
+
+		var resp attachResponse
+		fds, err := recvMsg(server, &resp)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Maps).To(Equal([]string{"events"}))
+		Expect(fds).To(HaveLen(1))
+
+		// the received descriptor is another reference to the pipe
+		passed := os.NewFile(uintptr(fds[0]), "passed")
+		_, err = passed.Write([]byte("hello"))
+		Expect(err).NotTo(HaveOccurred())
+		passed.Close()
+		data, err := ioutil.ReadAll(r)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("hello"))
+	})
+
+	It("sends messages without descriptors", func() {
+		Expect(sendMsg(client, attachResponse{Error: "no such program"}, nil)).To(Succeed())
+
+		var resp attachResponse
+		fds, err := recvMsg(server, &resp)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fds).To(BeEmpty())
+		Expect(resp.Error).To(Equal("no such program"))
+	})
+
+	Context("attaching with the helper", func() {
+		// respond answers the request of the client with the response and descriptors
+		respond := func(resp attachResponse, fds []int) {
+			go func() {
+				defer GinkgoRecover()
+				var req attachRequest
+				reqFDs, err := recvMsg(server, &req)
+				Expect(err).NotTo(HaveOccurred())
+				closeFDs(reqFDs)
+				Expect(sendMsg(server, resp, fds)).To(Succeed())
+			}()
+		}
+
+		It("holds the links passed after the maps", func() {
+			r, w, err := os.Pipe()
+			Expect(err).NotTo(HaveOccurred())
+			defer r.Close()
+			respond(attachResponse{Links: 1}, []int{int(w.Fd())})
+
+			h := &helperLoader{prog: strings.NewReader("elf")}
+			maps, links, err := h.attach(client, &loader.LoadOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(maps).To(BeEmpty())
+			Expect(links).To(HaveLen(1))
+			// the helper closing its link leaves the one of the client
+			w.Close()
+			_, err = links[0].Write([]byte("attached"))
+			Expect(err).NotTo(HaveOccurred())
+			links[0].Close()
+			data, err := ioutil.ReadAll(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("attached"))
+		})
+
+		It("fails if the descriptors don't match the maps and links", func() {
+			r, w, err := os.Pipe()
+			Expect(err).NotTo(HaveOccurred())
+			defer r.Close()
+			defer w.Close()
+			respond(attachResponse{Maps: []string{"events"}, Links: 1}, []int{int(w.Fd())})
+
+			h := &helperLoader{prog: strings.NewReader("elf")}
+			_, _, err = h.attach(client, &loader.LoadOptions{})
+			Expect(err).To(MatchError("privileged helper sent 1 descriptors for 1 maps and 1 links"))
+		})
+	})
+
+	It("only pins under the bpf filesystem", func() {
+		Expect(isUnder("/sys/fs/bpf/bee", defaultPinRoot)).To(BeTrue())
+		Expect(isUnder("/sys/fs/bpf", defaultPinRoot)).To(BeTrue())
+		Expect(isUnder("/sys/fs/bpf/../../../etc", defaultPinRoot)).To(BeFalse())
+		Expect(isUnder("/sys/fs/bpfevil", defaultPinRoot)).To(BeFalse())
+	})
+})
+
+func fileConn(fd int) *net.UnixConn {
+	f := os.NewFile(uintptr(fd), "socket")
+	defer f.Close()
+	conn, err := net.FileConn(f)
+	Expect(err).NotTo(HaveOccurred())
+	return conn.(*net.UnixConn)
+}
@@ -0,0 +1,49 @@
+// Package privsep splits running a program between a small privileged helper, which loads
+// and attaches it, and the unprivileged process watching its maps.
+//
+// The helper listens on a unix seqpacket socket. A client sends a single request, passing the
+// ELF file of the program as a file descriptor, and receives the descriptors of the loaded maps
+// and of the bpf links of the programs. The program stays attached until the client closes the
+// connection; the programs attached with a bpf link also stay attached while the client holds
+// it, if the helper goes away first.
+package privsep
+
+import (
+	"github.com/solo-io/bumblebee/pkg/loader"
+)
+
+const (
+	DefaultSocket = "/run/bee/helper.sock"
+	// pinned maps and programs must be under the bpf filesystem
+	defaultPinRoot = "/sys/fs/bpf"
+	// first descriptor passed by systemd socket activation
+	listenFDsStart = 3
+)
+
+type ServerOpts struct {
+	// Path of the socket to listen on, ignored when started by systemd socket activation
+	Socket string
+	// If set, only clients running as one of these users are served
+	AllowedUIDs []uint32
+	// Attach the kprobes of clients to the kernel functions which are unsafe or too hot to
+	// probe, see loader.DangerousProbe
+	AllowDangerousProbes bool
+}
+
+func (o *ServerOpts) initDefaults() {
+	if o.Socket == "" {
+		o.Socket = DefaultSocket
+	}
+}
+
+// Server is the privileged helper, loading and attaching programs on behalf of its clients.
+type Server struct {
+	loader loader.Loader
+	opts   ServerOpts
+}
+
+// NewServer returns a helper parsing programs with the given loader.
+func NewServer(progLoader loader.Loader, opts *ServerOpts) *Server {
+	opts.initDefaults()
+	return &Server{loader: progLoader, opts: *opts}
+}
@@ -0,0 +1,196 @@
+package privsep
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/solo-io/go-utils/contextutils"
+	"golang.org/x/sys/unix"
+)
+
+// Serve accepts clients until the context is done. Programs of connected clients are
+// detached on return.
+func (s *Server) Serve(ctx context.Context) error {
+	listener, err := s.listen()
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	logger := contextutils.LoggerFrom(ctx)
+	logger.Infof("privileged helper listening on %s", listener.Addr())
+	for {
+		conn, err := listener.AcceptUnix()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("could not accept client: %w", err)
+		}
+		go func() {
+			defer conn.Close()
+			if err := s.handle(ctx, conn); err != nil {
+				logger.Errorf("could not serve client: %v", err)
+			}
+		}()
+	}
+}
+
+// listen returns the socket passed by systemd, or creates it.
+func (s *Server) listen() (*net.UnixListener, error) {
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid == os.Getpid() {
+		if fds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS")); fds != 1 {
+			return nil, fmt.Errorf("expected a single socket from systemd, got %d", fds)
+		}
+		f := os.NewFile(listenFDsStart, "systemd socket")
+		defer f.Close()
+		listener, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("could not use socket passed by systemd: %w", err)
+		}
+		unixListener, ok := listener.(*net.UnixListener)
+		if !ok {
+			listener.Close()
+			return nil, fmt.Errorf("socket passed by systemd is not a unix socket")
+		}
+		return unixListener, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.opts.Socket), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(s.opts.Socket); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not remove previous socket: %w", err)
+	}
+	// clients must be allowed explicitly, by AllowedUIDs or the group of the socket
+	mask := 0117
+	if len(s.opts.AllowedUIDs) > 0 {
+		mask = 0111
+	}
+	oldMask := unix.Umask(mask)
+	defer unix.Umask(oldMask)
+	return net.ListenUnix("unixpacket", &net.UnixAddr{Name: s.opts.Socket, Net: "unixpacket"})
+}
+
+func (s *Server) handle(ctx context.Context, conn *net.UnixConn) error {
+	if err := s.checkPeer(conn); err != nil {
+		return err
+	}
+
+	var req attachRequest
+	fds, err := recvMsg(conn, &req)
+	if err != nil {
+		return err
+	}
+	if len(fds) != 1 {
+		closeFDs(fds)
+		return sendMsg(conn, attachResponse{Error: "expected the program file descriptor"}, nil)
+	}
+	prog := os.NewFile(uintptr(fds[0]), "program")
+	attached, err := s.attach(ctx, prog, &req)
+	prog.Close()
+	if err != nil {
+		return sendMsg(conn, attachResponse{Error: err.Error()}, nil)
+	}
+	defer attached.Close()
+
+	names := make([]string, 0, len(attached.Collection.Maps))
+	for name := range attached.Collection.Maps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	mapFDs := make([]int, 0, len(names))
+	for _, name := range names {
+		mapFDs = append(mapFDs, attached.Collection.Maps[name].FD())
+	}
+	linkFDs := attached.LinkFDs()
+	if err := sendMsg(conn, attachResponse{Maps: names, Links: len(linkFDs)}, append(mapFDs, linkFDs...)); err != nil {
+		return err
+	}
+
+	// keep the program attached until the client goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		buf := make([]byte, 1)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case <-closed:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func (s *Server) attach(ctx context.Context, prog *os.File, req *attachRequest) (*loader.Attached, error) {
+	for _, dir := range []string{req.PinMaps, req.PinProgs} {
+		if dir != "" && !isUnder(dir, defaultPinRoot) {
+			return nil, fmt.Errorf("can only pin under %s, got %s", defaultPinRoot, dir)
+		}
+	}
+	parsedELF, err := s.loader.Parse(ctx, prog)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse BPF program: %w", err)
+	}
+	return loader.Attach(ctx, &loader.LoadOptions{
+		ParsedELF:      parsedELF,
+		PinMaps:        req.PinMaps,
+		PinProgs:       req.PinProgs,
+		Interface:      req.Interface,
+		Netns:          req.Netns,
+		HostVeth:       req.HostVeth,
+		ConflictPolicy: req.ConflictPolicy,
+		Parameters:     req.Parameters,
+		Cgroups:        req.Cgroups,
+		ProbeVariants:  req.ProbeVariants,
+		MultiProbes:    req.MultiProbes,
+
+		AllowDangerousProbes: s.opts.AllowDangerousProbes,
+	})
+}
+
+func (s *Server) checkPeer(conn *net.UnixConn) error {
+	if len(s.opts.AllowedUIDs) == 0 {
+		return nil
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return err
+	}
+	if credErr != nil {
+		return fmt.Errorf("could not get client credentials: %w", credErr)
+	}
+	for _, uid := range s.opts.AllowedUIDs {
+		if cred.Uid == uid {
+			return nil
+		}
+	}
+	return fmt.Errorf("client user %d is not allowed", cred.Uid)
+}
+
+// isUnder returns whether the path is root or one of its descendants.
+func isUnder(path, root string) bool {
+	path = filepath.Clean(path)
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
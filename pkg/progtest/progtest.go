@@ -0,0 +1,197 @@
+package progtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"github.com/solo-io/bumblebee/pkg/golden"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+// Result is the outcome of a ProgramTest.
+type Result struct {
+	Name string
+	// Why the test failed, empty if it passed
+	Failures []string
+	// Set if the test could not run, e.g. as the kernel can't test run this type of program
+	Err      error
+	Duration time.Duration
+}
+
+func (r Result) Passed() bool {
+	return r.Err == nil && len(r.Failures) == 0
+}
+
+// Test runs the test cases declared in the config of a package against its programs.
+// Each test case runs with its own maps, created empty.
+func Test(ctx context.Context, pkg *v1.EbpfPackage) ([]Result, error) {
+	// parsed the same way as by bee run, the maps of events being declared with their type
+	parsed, err := loader.NewLoader(decoder.NewDecoderFactory(), nil).Parse(ctx, bytes.NewReader(pkg.ProgramFileBytes))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse BPF program: %w", err)
+	}
+	return RunTests(ctx, parsed.Spec, pkg.Tests)
+}
+
+// Fixtures replays the sample events of the fixtures of a package through the decoder of its
+// programs, and compares the entries with the ones the publisher expects, so consumers can
+// check their pipeline against them. Unlike the test cases, fixtures run without a kernel.
+func Fixtures(ctx context.Context, pkg *v1.EbpfPackage) ([]Result, error) {
+	factory := decoder.NewDecoderFactory()
+	parsed, err := loader.NewLoader(factory, nil).Parse(ctx, bytes.NewReader(pkg.ProgramFileBytes))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse BPF program: %w", err)
+	}
+	results := make([]Result, 0, len(pkg.Fixtures))
+	for _, fixture := range pkg.Fixtures {
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+		start := time.Now()
+		failures, err := runFixture(ctx, factory, parsed, fixture)
+		result := Result{Name: "fixture " + fixture.Name, Failures: failures, Err: err, Duration: time.Since(start)}
+		contextutils.LoggerFrom(ctx).Debugf("ran fixture %s in %s, passed: %v", fixture.Name, result.Duration, result.Passed())
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func runFixture(ctx context.Context, factory decoder.DecoderFactory, parsed *loader.ParsedELF, fixture v1.Fixture) ([]string, error) {
+	records, err := loader.ReadRecords(bytes.NewReader(fixture.Records))
+	if err != nil {
+		return nil, fmt.Errorf("could not read the records: %w", err)
+	}
+	expected, err := golden.Read(bytes.NewReader(fixture.Golden))
+	if err != nil {
+		return nil, fmt.Errorf("could not read the expected entries: %w", err)
+	}
+	watcher := golden.NewWatcher()
+	// the events the decoder rejects are failures of the fixture, not of the harness
+	if err := loader.Replay(ctx, factory, parsed, records, watcher); err != nil {
+		return []string{err.Error()}, nil
+	}
+	return golden.Diff(expected, watcher.Entries()), nil
+}
+
+// RunTests runs the test cases against the programs of the spec.
+func RunTests(ctx context.Context, spec *ebpf.CollectionSpec, tests []v1.ProgramTest) ([]Result, error) {
+	results := make([]Result, 0, len(tests))
+	for i, test := range tests {
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+		if test.Name == "" {
+			test.Name = fmt.Sprintf("%s#%d", test.Program, i)
+		}
+		start := time.Now()
+		failures, err := runTest(spec, test)
+		result := Result{Name: test.Name, Failures: failures, Err: err, Duration: time.Since(start)}
+		contextutils.LoggerFrom(ctx).Debugf("ran test %s in %s, passed: %v", test.Name, result.Duration, result.Passed())
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func runTest(spec *ebpf.CollectionSpec, test v1.ProgramTest) ([]string, error) {
+	if _, ok := spec.Programs[test.Program]; !ok {
+		return nil, fmt.Errorf("the package has no program %s", test.Program)
+	}
+	input, err := decodeHex(test.Input)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+
+	spec = spec.Copy()
+	for _, m := range spec.Maps {
+		// the maps of a test are its own
+		m.Pinning = ebpf.PinNone
+	}
+	coll, err := ebpf.NewCollection(spec)
+	if err != nil {
+		return nil, fmt.Errorf("could not load the program: %w", err)
+	}
+	defer coll.Close()
+
+	for _, entry := range test.Setup {
+		m, key, value, err := lookupEntry(coll, entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid setup: %w", err)
+		}
+		if err := m.Put(key, value); err != nil {
+			return nil, fmt.Errorf("could not set up map %s: %w", entry.Map, err)
+		}
+	}
+
+	ret, _, err := coll.Programs[test.Program].Test(input)
+	if errors.Is(err, ebpf.ErrNotSupported) {
+		return nil, fmt.Errorf("the kernel can't test run the %s program %s: %w", spec.Programs[test.Program].Type, test.Program, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var failures []string
+	if test.Return != nil && ret != *test.Return {
+		failures = append(failures, fmt.Sprintf("returned %d, expected %d", ret, *test.Return))
+	}
+	for _, entry := range test.Expect {
+		m, key, expected, err := lookupEntry(coll, entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expectation: %w", err)
+		}
+		var value []byte
+		err = m.Lookup(key, &value)
+		switch {
+		case errors.Is(err, ebpf.ErrKeyNotExist) && entry.Absent:
+		case errors.Is(err, ebpf.ErrKeyNotExist):
+			failures = append(failures, fmt.Sprintf("map %s has no key %s", entry.Map, entry.Key))
+		case err != nil:
+			return nil, fmt.Errorf("could not read map %s: %w", entry.Map, err)
+		case entry.Absent:
+			failures = append(failures, fmt.Sprintf("map %s has key %s, expected it to be absent", entry.Map, entry.Key))
+		case !bytes.Equal(value, expected):
+			failures = append(failures, fmt.Sprintf("map %s has %s for key %s, expected %s", entry.Map, hex.EncodeToString(value), entry.Key, hex.EncodeToString(expected)))
+		}
+	}
+	return failures, nil
+}
+
+// lookupEntry returns the map of an entry, and its key and value decoded to the size of the map.
+func lookupEntry(coll *ebpf.Collection, entry v1.TestMapEntry) (*ebpf.Map, []byte, []byte, error) {
+	m, ok := coll.Maps[entry.Map]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("the package has no map %s", entry.Map)
+	}
+	key, err := decodeHex(entry.Key)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid key of map %s: %w", entry.Map, err)
+	}
+	if uint32(len(key)) != m.KeySize() {
+		return nil, nil, nil, fmt.Errorf("the keys of map %s are %d bytes, not %d", entry.Map, m.KeySize(), len(key))
+	}
+	if entry.Absent {
+		return m, key, nil, nil
+	}
+	value, err := decodeHex(entry.Value)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid value of map %s: %w", entry.Map, err)
+	}
+	if uint32(len(value)) != m.ValueSize() {
+		return nil, nil, nil, fmt.Errorf("the values of map %s are %d bytes, not %d", entry.Map, m.ValueSize(), len(value))
+	}
+	return m, key, value, nil
+}
+
+// decodeHex decodes hex bytes, which can be separated by spaces for readability.
+func decodeHex(s string) ([]byte, error) {
+	return hex.DecodeString(strings.Join(strings.Fields(s), ""))
+}
@@ -0,0 +1,13 @@
+package progtest_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestProgtest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Progtest Suite")
+}
@@ -0,0 +1,111 @@
+package progtest_test
+
+import (
+	"context"
+	"os"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/progtest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RunTests", func() {
+	var spec *ebpf.CollectionSpec
+
+	BeforeEach(func() {
+		m, err := ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1})
+		if err != nil {
+			Skip("loading programs needs privileges: " + err.Error())
+		}
+		m.Close()
+		spec = &ebpf.CollectionSpec{
+			Maps: map[string]*ebpf.MapSpec{
+				"allowed": {Name: "allowed", Type: ebpf.Hash, KeySize: 4, ValueSize: 4, MaxEntries: 16},
+			},
+			Programs: map[string]*ebpf.ProgramSpec{
+				// drops every packet
+				"xdp_drop": {
+					Name: "xdp_drop",
+					Type: ebpf.XDP,
+					Instructions: asm.Instructions{
+						asm.LoadImm(asm.R0, 1, asm.DWord),
+						asm.Return(),
+					},
+					License: "MIT",
+				},
+			},
+		}
+	})
+
+	ret := func(v uint32) *uint32 { return &v }
+	packet := "ffffffffffff 000000000001 0800" + " 00000000000000000000000000000000"
+
+	It("checks the return value and the maps", func() {
+		results, err := progtest.RunTests(context.Background(), spec, []v1.ProgramTest{
+			{
+				Name:    "drops",
+				Program: "xdp_drop",
+				Input:   packet,
+				Setup:   []v1.TestMapEntry{{Map: "allowed", Key: "0a000001", Value: "01000000"}},
+				Return:  ret(1),
+				Expect: []v1.TestMapEntry{
+					{Map: "allowed", Key: "0a000001", Value: "01000000"},
+					{Map: "allowed", Key: "0a000002", Absent: true},
+				},
+			},
+			{
+				Name:    "passes",
+				Program: "xdp_drop",
+				Input:   packet,
+				Return:  ret(2),
+				Expect:  []v1.TestMapEntry{{Map: "allowed", Key: "0a000001", Value: "01000000"}},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results).To(HaveLen(2))
+		Expect(results[0].Passed()).To(BeTrue(), "%v %v", results[0].Failures, results[0].Err)
+		// the maps of each test start empty
+		Expect(results[1].Failures).To(Equal([]string{
+			"returned 1, expected 2",
+			"map allowed has no key 0a000001",
+		}))
+	})
+
+	It("errors for invalid test cases", func() {
+		results, err := progtest.RunTests(context.Background(), spec, []v1.ProgramTest{
+			{Program: "tc_drop", Input: packet},
+			{Program: "xdp_drop", Input: packet, Setup: []v1.TestMapEntry{{Map: "allowed", Key: "0a", Value: "01000000"}}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].Name).To(Equal("tc_drop#0"))
+		Expect(results[0].Err).To(MatchError(ContainSubstring("no program tc_drop")))
+		Expect(results[1].Err).To(MatchError(ContainSubstring("keys of map allowed are 4 bytes, not 1")))
+	})
+})
+
+var _ = Describe("Fixtures", func() {
+	It("replays the sample events of the package against the expected entries", func() {
+		program, err := os.ReadFile("../spec/array.o")
+		Expect(err).NotTo(HaveOccurred())
+		results, err := progtest.Fixtures(context.Background(), &v1.EbpfPackage{
+			ProgramFileBytes: program,
+			Fixtures: []v1.Fixture{
+				{Name: "quiet"},
+				{Name: "missing", Golden: []byte(`{"map":"events","key":{"pid":"42"}}` + "\n")},
+				{Name: "unknown", Records: []byte(`{"map":"events","data":"2a000000"}` + "\n")},
+				{Name: "broken", Golden: []byte("not json\n")},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results).To(HaveLen(4))
+		Expect(results[0].Name).To(Equal("fixture quiet"))
+		Expect(results[0].Passed()).To(BeTrue())
+		Expect(results[1].Failures).To(Equal([]string{`entry 1: missing {"map":"events","key":{"pid":"42"}}`}))
+		Expect(results[2].Failures).To(ConsistOf(ContainSubstring("the program has no map events")))
+		Expect(results[3].Err).To(MatchError(ContainSubstring("could not read the expected entries")))
+	})
+})
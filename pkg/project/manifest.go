@@ -0,0 +1,109 @@
+package project
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultManifestFile is the name of the manifest at the root of a project.
+const DefaultManifestFile = "bee.yaml"
+
+// Manifest describes all the programs of a project, so they can be built and pushed at once.
+type Manifest struct {
+	Programs []Program `yaml:"programs"`
+}
+
+// Program is a single BPF program of the project.
+// Paths are relative to the directory of the manifest.
+type Program struct {
+	// Name of the program, defaults to the name of the source file
+	Name string `yaml:"name"`
+	// Source is the BPF C file to compile
+	Source string `yaml:"source"`
+	// Output is the compiled ELF file, defaults to the source file with the `.o` extension
+	Output string `yaml:"output"`
+	// Ref the OCI image is saved and pushed to
+	Ref string `yaml:"ref"`
+	// Archs to compile the program for, saved as a multi-arch image, e.g. [amd64, arm64].
+	// The program is compiled for the current host if empty.
+	Archs []string `yaml:"archs"`
+	// CFlags passed to the compiler
+	CFlags []string `yaml:"cflags"`
+	// Config is an optional YAML file holding the v1.EbpfConfig of the package
+	Config string `yaml:"config"`
+	// Fixtures is an optional directory of sample events packaged along with the program,
+	// NAME.records.jsonl files recorded by `bee run --record` and the NAME.golden.jsonl
+	// files of the entries they decode to
+	Fixtures string `yaml:"fixtures"`
+
+	Description string `yaml:"description"`
+	Authors     string `yaml:"authors"`
+}
+
+// Load reads and validates the manifest, resolving the paths of the programs
+// relative to the current directory.
+func Load(path string) (*Manifest, error) {
+	byt, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read manifest: %w", err)
+	}
+	var manifest Manifest
+	if err := yaml.UnmarshalStrict(byt, &manifest); err != nil {
+		return nil, fmt.Errorf("could not parse manifest %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	names := map[string]bool{}
+	for i := range manifest.Programs {
+		prog := &manifest.Programs[i]
+		if prog.Source == "" {
+			return nil, fmt.Errorf("program %d of %s has no source", i, path)
+		}
+		if prog.Name == "" {
+			prog.Name = trimExt(filepath.Base(prog.Source))
+		}
+		if names[prog.Name] {
+			return nil, fmt.Errorf("program %s is declared more than once in %s", prog.Name, path)
+		}
+		names[prog.Name] = true
+
+		prog.Source = filepath.Join(dir, prog.Source)
+		if prog.Output != "" {
+			prog.Output = filepath.Join(dir, prog.Output)
+		}
+		if prog.Config != "" {
+			prog.Config = filepath.Join(dir, prog.Config)
+		}
+		if prog.Fixtures != "" {
+			prog.Fixtures = filepath.Join(dir, prog.Fixtures)
+		}
+	}
+	return &manifest, nil
+}
+
+// LoadConfig reads the package config of the program, if any.
+func (p *Program) LoadConfig() (v1.EbpfConfig, error) {
+	var cfg v1.EbpfConfig
+	if p.Config == "" {
+		return cfg, nil
+	}
+	byt, err := os.ReadFile(p.Config)
+	if err != nil {
+		return cfg, fmt.Errorf("could not read config of program %s: %w", p.Name, err)
+	}
+	if err := yaml.UnmarshalStrict(byt, &cfg); err != nil {
+		return cfg, fmt.Errorf("could not parse config of program %s: %w", p.Name, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid config of program %s: %w", p.Name, err)
+	}
+	return cfg, nil
+}
+
+func trimExt(name string) string {
+	return name[:len(name)-len(filepath.Ext(name))]
+}
@@ -0,0 +1,83 @@
+package project_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/solo-io/bumblebee/pkg/project"
+)
+
+var _ = Describe("Load", func() {
+	var dir string
+
+	writeManifest := func(content string) string {
+		path := filepath.Join(dir, project.DefaultManifestFile)
+		Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		return path
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("defaults programs and resolves their paths", func() {
+		manifest, err := project.Load(writeManifest(`
+programs:
+- source: tcpconnect/tcpconnect.c
+  ref: ghcr.io/solo-io/bumblebee/tcpconnect:v1
+  config: tcpconnect/config.yaml
+  fixtures: tcpconnect/fixtures
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest.Programs).To(HaveLen(1))
+		prog := manifest.Programs[0]
+		Expect(prog.Name).To(Equal("tcpconnect"))
+		Expect(prog.Source).To(Equal(filepath.Join(dir, "tcpconnect/tcpconnect.c")))
+		Expect(prog.Config).To(Equal(filepath.Join(dir, "tcpconnect/config.yaml")))
+		Expect(prog.Fixtures).To(Equal(filepath.Join(dir, "tcpconnect/fixtures")))
+		Expect(prog.Archs).To(BeEmpty())
+	})
+
+	It("rejects duplicate programs", func() {
+		_, err := project.Load(writeManifest(`
+programs:
+- source: a/probe.c
+- source: b/probe.c
+`))
+		Expect(err).To(MatchError(ContainSubstring("more than once")))
+	})
+
+	It("rejects unknown fields", func() {
+		_, err := project.Load(writeManifest(`
+programs:
+- source: probe.c
+  cflag: -DDEBUG
+`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("validates the config of programs", func() {
+		Expect(os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(`
+maps:
+- name: packets
+  metric:
+    type: histogram
+`), 0644)).To(Succeed())
+		manifest, err := project.Load(writeManifest(`
+programs:
+- source: probe.c
+  config: config.yaml
+`))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = manifest.Programs[0].LoadConfig()
+		Expect(err).To(MatchError(ContainSubstring("must be a counter or a gauge")))
+	})
+})
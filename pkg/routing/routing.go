@@ -0,0 +1,378 @@
+// Package routing routes the entries of the maps of programs to sinks by rules matching their
+// program, map and fields, by priority, falling back to other sinks while the ones of a rule
+// are down. The rules can be reloaded while the programs run.
+package routing
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/go-utils/contextutils"
+	"gopkg.in/yaml.v2"
+)
+
+const defaultPollInterval = 10 * time.Second
+
+// Config are the routing rules of the entries of the programs.
+type Config struct {
+	Rules []Rule `yaml:"rules,omitempty"`
+	// Sinks of the entries no rule matches, the sinks of their program if empty
+	Default []string `yaml:"default,omitempty"`
+}
+
+// Rule routes the entries it matches to its sinks.
+type Rule struct {
+	Name string `yaml:"name"`
+	// Rules of higher priorities are matched first, the rules of the same priority in their
+	// order
+	Priority int      `yaml:"priority,omitempty"`
+	Match    Match    `yaml:"match,omitempty"`
+	Sinks    []string `yaml:"sinks"`
+	// Sinks the entries are routed to instead while all the sinks of the rule are down, e.g.
+	// a local file while the SIEM is unreachable
+	Fallback []string `yaml:"fallback,omitempty"`
+	// Match the rules of lower priorities too, the entries being routed to the sinks of all
+	// the rules they match, rather than stopping at this one
+	Continue bool `yaml:"continue,omitempty"`
+}
+
+// Match matches entries by the globs of their program, map and fields, as taken by path.Match.
+// Empty lists match every program or map.
+type Match struct {
+	Programs []string `yaml:"programs,omitempty"`
+	Maps     []string `yaml:"maps,omitempty"`
+	// Globs of the values of the fields of the entries, each field having to match one of
+	// its globs, e.g. `severity: [high, critical]`
+	Fields map[string][]string `yaml:"fields,omitempty"`
+}
+
+// Parse parses and validates routing rules, their sinks having to be known.
+func Parse(data []byte, sinks map[string]bool) (*Config, error) {
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(sinks); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate checks the globs of the rules, and that their sinks are known.
+func (c *Config) Validate(sinks map[string]bool) error {
+	checkSinks := func(what string, names []string) error {
+		for _, name := range names {
+			if !sinks[name] {
+				return fmt.Errorf("%s routes entries to %s, which is not configured", what, name)
+			}
+		}
+		return nil
+	}
+	if err := checkSinks("the default route", c.Default); err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for i, rule := range c.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d has no name", i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("more than one rule is named %s", rule.Name)
+		}
+		names[rule.Name] = true
+		if len(rule.Sinks) == 0 {
+			return fmt.Errorf("rule %s has no sinks", rule.Name)
+		}
+		if err := checkSinks("rule "+rule.Name, append(append([]string{}, rule.Sinks...), rule.Fallback...)); err != nil {
+			return err
+		}
+		globs := append(append([]string{}, rule.Match.Programs...), rule.Match.Maps...)
+		for _, values := range rule.Match.Fields {
+			globs = append(globs, values...)
+		}
+		for _, glob := range globs {
+			if _, err := path.Match(glob, ""); err != nil {
+				return fmt.Errorf("rule %s: invalid glob %q: %w", rule.Name, glob, err)
+			}
+		}
+	}
+	return nil
+}
+
+// matches returns whether the rule matches an entry of the map of the program.
+func (r *Rule) matches(program, mapName string, fields map[string]string) bool {
+	if !matchAny(r.Match.Programs, program) || !matchAny(r.Match.Maps, mapName) {
+		return false
+	}
+	for field, globs := range r.Match.Fields {
+		value, ok := fields[field]
+		if !ok || !matchAny(globs, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAny(globs []string, value string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Table holds the rules the entries are routed by, which are replaced while they are routed
+// when reloaded.
+type Table struct {
+	lock    sync.RWMutex
+	rules   []Rule
+	def     []string
+	hash    [sha256.Size]byte
+	sinks   map[string]bool
+	polling time.Duration
+}
+
+// NewTable returns a table of the rules of the config, their sinks having to be among the
+// given ones, as are the ones of the rules it is reloaded with.
+func NewTable(cfg *Config, sinks map[string]bool) (*Table, error) {
+	t := &Table{sinks: sinks, polling: defaultPollInterval}
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	if err := t.Set(cfg); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Set replaces the rules of the table, once validated.
+func (t *Table) Set(cfg *Config) error {
+	if err := cfg.Validate(t.sinks); err != nil {
+		return err
+	}
+	rules := append([]Rule{}, cfg.Rules...)
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Priority > rules[j].Priority
+	})
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.rules, t.def = rules, cfg.Default
+	return nil
+}
+
+// Route returns the sinks of an entry of the map of the program, among the ones healthy
+// reports as delivering the entries for the fallbacks, or the defaults if no rule matches it:
+// the ones of the table, or the given ones if the table has none.
+func (t *Table) Route(program, mapName string, fields map[string]string, defaults []string, healthy func(sink string) bool) []string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	var sinks []string
+	matched := false
+	for i := range t.rules {
+		rule := &t.rules[i]
+		if !rule.matches(program, mapName, fields) {
+			continue
+		}
+		matched = true
+		routed := rule.Sinks
+		if len(rule.Fallback) > 0 && !anyHealthy(rule.Sinks, healthy) {
+			routed = rule.Fallback
+		}
+		sinks = appendNew(sinks, routed)
+		if !rule.Continue {
+			break
+		}
+	}
+	if matched {
+		return sinks
+	}
+	if len(t.def) > 0 {
+		return t.def
+	}
+	return defaults
+}
+
+func anyHealthy(sinks []string, healthy func(string) bool) bool {
+	for _, sink := range sinks {
+		if healthy == nil || healthy(sink) {
+			return true
+		}
+	}
+	return false
+}
+
+func appendNew(sinks, names []string) []string {
+	for _, name := range names {
+		found := false
+		for _, s := range sinks {
+			found = found || s == name
+		}
+		if !found {
+			sinks = append(sinks, name)
+		}
+	}
+	return sinks
+}
+
+// Watch replaces the rules of the table with the ones of the file, then again whenever it
+// changes until the context is done, or immediately on SIGHUP. The rules of the file failing
+// to parse are logged, the previous ones being kept, except on the first load which fails.
+func (t *Table) Watch(ctx context.Context, file string) error {
+	if _, err := t.reload(file); err != nil {
+		return err
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(t.polling)
+		defer ticker.Stop()
+		logger := contextutils.LoggerFrom(ctx)
+		for {
+			select {
+			case <-ticker.C:
+			case <-hup:
+			case <-ctx.Done():
+				return
+			}
+			changed, err := t.reload(file)
+			if err != nil {
+				logger.Errorf("could not reload the routing rules %s, keeping the previous ones: %v", file, err)
+			} else if changed {
+				logger.Infof("reloaded the routing rules %s", file)
+			}
+		}
+	}()
+	return nil
+}
+
+// reload sets the rules of the file if it changed since the last reload, and returns whether
+// it did.
+func (t *Table) reload(file string) (bool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false, fmt.Errorf("could not read the routing rules: %w", err)
+	}
+	hash := sha256.Sum256(data)
+	t.lock.RLock()
+	unchanged := hash == t.hash
+	t.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	cfg, err := Parse(data, t.sinks)
+	if err != nil {
+		return false, fmt.Errorf("invalid routing rules %s: %w", file, err)
+	}
+	if err := t.Set(cfg); err != nil {
+		return false, err
+	}
+	t.lock.Lock()
+	t.hash = hash
+	t.lock.Unlock()
+	return true, nil
+}
+
+// Sink is a sink entries are routed to.
+type Sink struct {
+	Watcher v1.MapWatcher
+	// Healthy returns whether the sink delivers the entries, always if nil
+	Healthy func() bool
+}
+
+// Router is the watcher of the maps of a program routing their entries to sinks by the rules
+// of a table. The maps are declared to the sinks on the first entry routed to them, so each
+// sink only has the maps it receives entries of.
+type Router struct {
+	program  string
+	table    *Table
+	sinks    map[string]Sink
+	defaults []string
+
+	lock     sync.Mutex
+	maps     map[string]declaredMap
+	declared map[string]map[string]bool
+}
+
+type declaredMap struct {
+	keys    []string
+	ringBuf bool
+}
+
+// NewRouter returns the watcher of the maps of the program routing their entries to the
+// sinks, by name, the entries matching no rule being routed to the defaults if the table has
+// no default route.
+func NewRouter(program string, table *Table, sinks map[string]Sink, defaults []string) *Router {
+	return &Router{
+		program:  program,
+		table:    table,
+		sinks:    sinks,
+		defaults: defaults,
+		maps:     map[string]declaredMap{},
+		declared: map[string]map[string]bool{},
+	}
+}
+
+func (r *Router) NewRingBuf(name string, keys []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.maps[name] = declaredMap{keys: keys, ringBuf: true}
+}
+
+func (r *Router) NewHashMap(name string, keys []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.maps[name] = declaredMap{keys: keys}
+}
+
+func (r *Router) SendEntry(entry v1.MapEntry) {
+	for _, name := range r.table.Route(r.program, entry.Name, entry.Entry.Key, r.defaults, r.healthy) {
+		sink, ok := r.sinks[name]
+		if !ok {
+			continue
+		}
+		r.declare(name, sink, entry.Name)
+		sink.Watcher.SendEntry(entry)
+	}
+}
+
+// declare declares the map to the sink, if it was not yet.
+func (r *Router) declare(name string, sink Sink, mapName string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.declared[name][mapName] {
+		return
+	}
+	if r.declared[name] == nil {
+		r.declared[name] = map[string]bool{}
+	}
+	r.declared[name][mapName] = true
+	m := r.maps[mapName]
+	if m.ringBuf {
+		sink.Watcher.NewRingBuf(mapName, m.keys)
+	} else {
+		sink.Watcher.NewHashMap(mapName, m.keys)
+	}
+}
+
+func (r *Router) healthy(name string) bool {
+	sink, ok := r.sinks[name]
+	return ok && (sink.Healthy == nil || sink.Healthy())
+}
+
+// Close leaves the sinks open, as they are shared with other programs.
+func (r *Router) Close() {}
@@ -0,0 +1,132 @@
+package routing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("routing", func() {
+	sinks := map[string]bool{"output": true, "siem": true, "file": true}
+
+	table := func(rules string) *Table {
+		cfg, err := Parse([]byte(rules), sinks)
+		Expect(err).NotTo(HaveOccurred())
+		t, err := NewTable(cfg, sinks)
+		Expect(err).NotTo(HaveOccurred())
+		return t
+	}
+
+	It("routes entries by the rules of the highest priority they match", func() {
+		t := table(`
+rules:
+- name: debug
+  match:
+    maps: [debug_*]
+  sinks: [output]
+- name: detections
+  priority: 100
+  match:
+    programs: [tcpconnect, exec*]
+    fields:
+      severity: [high, critical]
+  sinks: [siem]
+  continue: true
+- name: archive
+  priority: 50
+  match:
+    fields:
+      severity: ["*"]
+  sinks: [file]
+`)
+		high := map[string]string{"severity": "high"}
+		Expect(t.Route("tcpconnect", "events", high, nil, nil)).To(Equal([]string{"siem", "file"}))
+		Expect(t.Route("execsnoop", "events", high, nil, nil)).To(Equal([]string{"siem", "file"}))
+		Expect(t.Route("other", "events", high, nil, nil)).To(Equal([]string{"file"}))
+		Expect(t.Route("other", "debug_events", nil, []string{"output", "siem"}, nil)).To(Equal([]string{"output"}))
+		Expect(t.Route("other", "events", nil, []string{"output", "siem"}, nil)).To(Equal([]string{"output", "siem"}))
+	})
+
+	It("falls back to other sinks while the sinks of a rule are down", func() {
+		t := table(`
+rules:
+- name: detections
+  sinks: [siem]
+  fallback: [file]
+default: [output]
+`)
+		healthy := true
+		health := func(string) bool { return healthy }
+		Expect(t.Route("tcpconnect", "events", nil, nil, health)).To(Equal([]string{"siem"}))
+		healthy = false
+		Expect(t.Route("tcpconnect", "events", nil, nil, health)).To(Equal([]string{"file"}))
+	})
+
+	It("rejects unknown sinks, rules without name and invalid globs", func() {
+		_, err := Parse([]byte("rules:\n- name: a\n  sinks: [kafka]\n"), sinks)
+		Expect(err).To(MatchError("rule a routes entries to kafka, which is not configured"))
+		_, err = Parse([]byte("rules:\n- sinks: [siem]\n"), sinks)
+		Expect(err).To(MatchError("rule 0 has no name"))
+		_, err = Parse([]byte("rules:\n- name: a\n  sinks: [siem]\n  match:\n    maps: ['[']\n"), sinks)
+		Expect(err).To(MatchError(ContainSubstring("invalid glob")))
+		_, err = Parse([]byte("rules:\n- name: a\n  sink: [siem]\n"), sinks)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("declares the maps to the sinks the entries are routed to", func() {
+		t := table(`
+rules:
+- name: detections
+  match:
+    fields:
+      severity: [high]
+  sinks: [siem]
+`)
+		siem, output := fakes.NewSink(), fakes.NewSink()
+		r := NewRouter("tcpconnect", t, map[string]Sink{"siem": {Watcher: siem}, "output": {Watcher: output}}, []string{"output"})
+		r.NewRingBuf("events", []string{"severity"})
+		r.NewHashMap("counts", []string{"pid"})
+		r.SendEntry(v1.MapEntry{Name: "counts", Entry: v1.KvPair{Key: map[string]string{"pid": "1"}}})
+		Expect(siem.RingBufs()).To(BeEmpty())
+		Expect(siem.HashMaps()).To(BeEmpty())
+		Expect(output.HashMaps()).To(Equal(map[string][]string{"counts": {"pid"}}))
+
+		r.SendEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"severity": "high"}}})
+		r.SendEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"severity": "high"}}})
+		Expect(siem.RingBufs()).To(Equal(map[string][]string{"events": {"severity"}}))
+		Expect(siem.Entries("events")).To(HaveLen(2))
+		Expect(output.RingBufs()).To(BeEmpty())
+	})
+
+	It("reloads the rules of a file once it changes", func() {
+		dir, err := os.MkdirTemp("", "bee-routing")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "routes.yaml")
+		Expect(os.WriteFile(file, []byte("default: [output]\n"), 0644)).To(Succeed())
+
+		t, err := NewTable(nil, sinks)
+		Expect(err).NotTo(HaveOccurred())
+		t.polling = 10 * time.Millisecond
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		Expect(t.Watch(ctx, file)).To(Succeed())
+		Expect(t.Route("tcpconnect", "events", nil, nil, nil)).To(Equal([]string{"output"}))
+
+		// invalid rules are ignored, the previous ones being kept
+		Expect(os.WriteFile(file, []byte("default: [kafka]\n"), 0644)).To(Succeed())
+		Consistently(func() []string { return t.Route("tcpconnect", "events", nil, nil, nil) }, 50*time.Millisecond).Should(Equal([]string{"output"}))
+		Expect(os.WriteFile(file, []byte("default: [siem]\n"), 0644)).To(Succeed())
+		Eventually(func() []string { return t.Route("tcpconnect", "events", nil, nil, nil) }).Should(Equal([]string{"siem"}))
+
+		Expect(os.WriteFile(filepath.Join(dir, "invalid.yaml"), []byte("default: [kafka]\n"), 0644)).To(Succeed())
+		Expect(t.Watch(ctx, filepath.Join(dir, "invalid.yaml"))).To(MatchError(ContainSubstring("not configured")))
+	})
+})
@@ -0,0 +1,30 @@
+package sandbox
+
+import "golang.org/x/sys/unix"
+
+// AUDIT_ARCH_X86_64
+const auditArch = 0xc000003e
+
+// the legacy syscalls of amd64, which arm64 only has the *at and generic versions of
+var archAllowedSyscalls = []uintptr{
+	unix.SYS_OPEN,
+	unix.SYS_STAT,
+	unix.SYS_LSTAT,
+	unix.SYS_NEWFSTATAT,
+	unix.SYS_ACCESS,
+	unix.SYS_READLINK,
+	unix.SYS_RENAME,
+	unix.SYS_UNLINK,
+	unix.SYS_MKDIR,
+	unix.SYS_RMDIR,
+	unix.SYS_CHMOD,
+	unix.SYS_DUP2,
+	unix.SYS_PIPE,
+	unix.SYS_POLL,
+	unix.SYS_SELECT,
+	unix.SYS_EPOLL_CREATE,
+	unix.SYS_EPOLL_WAIT,
+	unix.SYS_GETRLIMIT,
+	unix.SYS_ARCH_PRCTL,
+	unix.SYS_TIME,
+}
@@ -0,0 +1,11 @@
+package sandbox
+
+import "golang.org/x/sys/unix"
+
+// AUDIT_ARCH_AARCH64
+const auditArch = 0xc00000b7
+
+var archAllowedSyscalls = []uintptr{
+	unix.SYS_FSTATAT,
+	unix.SYS_GETRLIMIT,
+}
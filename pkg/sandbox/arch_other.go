@@ -0,0 +1,9 @@
+//go:build linux && !amd64 && !arm64
+// +build linux,!amd64,!arm64
+
+package sandbox
+
+// sandboxing is not supported
+const auditArch = 0
+
+var archAllowedSyscalls []uintptr
@@ -0,0 +1,98 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+var errLandlockUnsupported = errors.New("landlock is not supported")
+
+const (
+	// all access rights of the first landlock ABI
+	accessFSAll = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+	// rights which apply to files, rather than to the content of directories
+	accessFSFile = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE
+
+	accessFSRead  = unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	accessFSWrite = accessFSRead |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE
+)
+
+// restrictPaths denies access to all files except the paths of the options.
+func restrictPaths(opts *Opts) error {
+	attr := unix.LandlockRulesetAttr{Access_fs: accessFSAll}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno == unix.ENOSYS || errno == unix.EOPNOTSUPP {
+		return errLandlockUnsupported
+	}
+	if errno != 0 {
+		return fmt.Errorf("could not create landlock ruleset: %w", errno)
+	}
+	rulesetFD := int(fd)
+	defer unix.Close(rulesetFD)
+
+	for _, path := range opts.ReadPaths {
+		if err := addPathRule(rulesetFD, path, accessFSRead); err != nil {
+			return err
+		}
+	}
+	for _, path := range opts.WritePaths {
+		if err := addPathRule(rulesetFD, path, accessFSWrite); err != nil {
+			return err
+		}
+	}
+
+	if err := allThreads(unix.SYS_LANDLOCK_RESTRICT_SELF, uintptr(rulesetFD), 0, 0); err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return fmt.Errorf("%w in binaries built with cgo", errLandlockUnsupported)
+		}
+		return fmt.Errorf("could not restrict file access: %w", err)
+	}
+	return nil
+}
+
+func addPathRule(rulesetFD int, path string, access uint64) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		access &= accessFSFile
+	}
+
+	pathFD, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("could not open %s: %w", path, err)
+	}
+	defer unix.Close(pathFD)
+	rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(pathFD)}
+	_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(rulesetFD), unix.LANDLOCK_RULE_PATH_BENEATH,
+		uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("could not allow access to %s: %w", path, errno)
+	}
+	return nil
+}
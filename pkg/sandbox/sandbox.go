@@ -0,0 +1,10 @@
+// Package sandbox restricts the running process, once its programs are loaded and attached,
+// to what is needed to read maps and export their entries.
+package sandbox
+
+type Opts struct {
+	// Paths which can still be read, e.g. config files
+	ReadPaths []string
+	// Paths which can still be read and written, e.g. report directories
+	WritePaths []string
+}
@@ -0,0 +1,49 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/solo-io/go-utils/contextutils"
+	"golang.org/x/sys/unix"
+)
+
+// Apply irreversibly sandboxes the whole process:
+//   - a seccomp filter only allows the syscalls of the Go runtime and of reading maps, files,
+//     sockets and the terminal, denying e.g. the ones used to load and attach programs,
+//     execute binaries, trace other processes or change the system, as well as all bpf
+//     commands which don't operate on existing maps
+//   - landlock rules, on kernels supporting them, deny access to all files but the given paths
+//
+// Files opened before Apply can still be used.
+func Apply(ctx context.Context, opts *Opts) error {
+	if auditArch == 0 {
+		return fmt.Errorf("sandboxing is not supported on this architecture")
+	}
+	if err := allThreads(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0); err != nil && !errors.Is(err, unix.ENOTSUP) {
+		return fmt.Errorf("could not set no_new_privs: %w", err)
+	}
+
+	if err := restrictPaths(opts); err != nil {
+		if !errors.Is(err, errLandlockUnsupported) {
+			return err
+		}
+		contextutils.LoggerFrom(ctx).Warnf("not restricting file access: %v", err)
+	}
+	if err := installSeccompFilter(); err != nil {
+		return fmt.Errorf("could not install seccomp filter: %w", err)
+	}
+	return nil
+}
+
+// allThreads runs a syscall on every thread of the process, which is only possible
+// in binaries built without cgo.
+func allThreads(trap, a1, a2, a3 uintptr) error {
+	_, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, a3)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
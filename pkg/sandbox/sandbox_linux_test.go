@@ -0,0 +1,123 @@
+package sandbox
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("seccomp filter", func() {
+	It("only allows the listed syscalls", func() {
+		if os.Getenv("BEE_SANDBOX_TEST") != "" {
+			Skip("the test process may already be sandboxed, which denies installing the filter again")
+		}
+		type result struct {
+			name  string
+			errno unix.Errno
+		}
+		results := make(chan result)
+		go func() {
+			defer close(results)
+			// the filter is only installed on this thread, which exits along with the goroutine
+			// as it is never unlocked
+			runtime.LockOSThread()
+			if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+				results <- result{"no_new_privs", err.(unix.Errno)}
+				return
+			}
+			if err := loadSeccompFilter(0); err != nil {
+				results <- result{"seccomp", err.(unix.Errno)}
+				return
+			}
+			syscall := func(name string, trap, a1, a2, a3 uintptr) {
+				_, _, errno := unix.Syscall(trap, a1, a2, a3)
+				results <- result{name, errno}
+			}
+			syscall("getpid", unix.SYS_GETPID, 0, 0, 0)
+			syscall("getcpu", unix.SYS_GETCPU, 0, 0, 0)
+			syscall("execve", unix.SYS_EXECVE, 0, 0, 0)
+			syscall("unshare", unix.SYS_UNSHARE, unix.CLONE_NEWNET, 0, 0)
+			syscall("clone of a namespace", unix.SYS_CLONE, unix.CLONE_NEWNET, 0, 0)
+			syscall("clone3", unix.SYS_CLONE3, 0, 0, 0)
+			syscall("bpf map lookup", unix.SYS_BPF, unix.BPF_MAP_LOOKUP_ELEM, 0, 0)
+			syscall("bpf prog load", unix.SYS_BPF, unix.BPF_PROG_LOAD, 0, 0)
+			syscall("kill of another process", unix.SYS_KILL, 1, 0, 0)
+			syscall("kill of the process", unix.SYS_KILL, uintptr(os.Getpid()), 0, 0)
+			// the runtime can still serve the goroutine
+			f, err := ioutil.TempFile("", "bee-seccomp")
+			if err == nil {
+				_, err = f.WriteString("ok")
+				f.Close()
+				os.Remove(f.Name())
+			}
+			if err != nil {
+				results <- result{"file", unix.EIO}
+			}
+		}()
+
+		errnos := map[string]unix.Errno{}
+		for result := range results {
+			errnos[result.name] = result.errno
+		}
+		Expect(errnos).To(Equal(map[string]unix.Errno{
+			"getpid": 0,
+			// not listed
+			"getcpu":  unix.EPERM,
+			"execve":  unix.EPERM,
+			"unshare": unix.EPERM,
+			// denied by their arguments
+			"clone of a namespace":    unix.EPERM,
+			"bpf prog load":           unix.EPERM,
+			"kill of another process": unix.EPERM,
+			// allowed, but invalid
+			"bpf map lookup": unix.EINVAL,
+			"clone3":         unix.ENOSYS,
+			// signal 0 only checks the process exists
+			"kill of the process": 0,
+		}))
+	})
+})
+
+var _ = Describe("Apply", func() {
+	It("denies denied syscalls and files outside of the allowed paths", func() {
+		if os.Getenv("BEE_SANDBOX_TEST") == "" {
+			Skip("sandboxing the test process is irreversible, run with BEE_SANDBOX_TEST=1 CGO_ENABLED=0 on a kernel supporting landlock")
+		}
+		dir, err := ioutil.TempDir("", "bee-sandbox")
+		Expect(err).NotTo(HaveOccurred())
+		other, err := ioutil.TempDir("", "bee-sandbox-other")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(Apply(context.Background(), &Opts{WritePaths: []string{dir}})).To(Succeed())
+
+		cmd := exec.Command("true")
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		Expect(cmd.Run()).To(MatchError(ContainSubstring("operation not permitted")))
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "report.html"), []byte("ok"), 0644)).To(Succeed())
+		err = ioutil.WriteFile(filepath.Join(other, "report.html"), []byte("ok"), 0644)
+		Expect(err).To(MatchError(ContainSubstring("permission denied")))
+
+		// the API can still be served
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+		defer server.Close()
+		resp, err := http.Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(body)).To(Equal("ok"))
+	})
+})
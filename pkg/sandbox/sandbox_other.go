@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package sandbox
+
+import (
+	"context"
+	"fmt"
+)
+
+// Apply fails, as seccomp and landlock are only supported on Linux.
+func Apply(ctx context.Context, opts *Opts) error {
+	return fmt.Errorf("sandboxing is only supported on linux")
+}
@@ -0,0 +1,251 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTSync = 1
+
+	seccompRetAllow = 0x7fff0000
+	seccompRetErrno = 0x00050000
+
+	// offsets in struct seccomp_data
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	// low 32 bits of the first argument, on little endian architectures
+	seccompDataArg0 = 16
+
+	// x32 syscalls have this bit set on amd64
+	x32SyscallBit = 0x40000000
+
+	// clone flags creating namespaces, denied like setns and unshare
+	cloneNamespaceFlags = unix.CLONE_NEWNS | unix.CLONE_NEWCGROUP | unix.CLONE_NEWUTS | unix.CLONE_NEWIPC |
+		unix.CLONE_NEWUSER | unix.CLONE_NEWPID | unix.CLONE_NEWNET
+)
+
+// syscalls still allowed once programs are attached, the ones of the Go runtime and of reading
+// maps, files, sockets and the terminal. All others fail with EPERM, e.g. executing binaries,
+// tracing other processes, mounting, loading kernel modules or changing credentials.
+var allowedSyscalls = append([]uintptr{
+	// memory, threads, signals and time
+	unix.SYS_MMAP,
+	unix.SYS_MUNMAP,
+	unix.SYS_MREMAP,
+	unix.SYS_MPROTECT,
+	unix.SYS_MADVISE,
+	unix.SYS_MINCORE,
+	unix.SYS_MSYNC,
+	unix.SYS_BRK,
+	unix.SYS_FUTEX,
+	unix.SYS_SET_ROBUST_LIST,
+	unix.SYS_RSEQ,
+	unix.SYS_SCHED_YIELD,
+	unix.SYS_SCHED_GETAFFINITY,
+	unix.SYS_GETTID,
+	unix.SYS_GETPID,
+	unix.SYS_GETPPID,
+	unix.SYS_GETUID,
+	unix.SYS_GETEUID,
+	unix.SYS_GETGID,
+	unix.SYS_GETEGID,
+	unix.SYS_GETGROUPS,
+	unix.SYS_CAPGET,
+	unix.SYS_PRCTL,
+	unix.SYS_PRLIMIT64,
+	unix.SYS_GETRUSAGE,
+	unix.SYS_SYSINFO,
+	unix.SYS_UNAME,
+	unix.SYS_GETRANDOM,
+	unix.SYS_RT_SIGACTION,
+	unix.SYS_RT_SIGPROCMASK,
+	unix.SYS_RT_SIGRETURN,
+	unix.SYS_SIGALTSTACK,
+	unix.SYS_RESTART_SYSCALL,
+	unix.SYS_NANOSLEEP,
+	unix.SYS_CLOCK_NANOSLEEP,
+	unix.SYS_CLOCK_GETTIME,
+	unix.SYS_CLOCK_GETRES,
+	unix.SYS_GETTIMEOFDAY,
+	unix.SYS_SETITIMER,
+	unix.SYS_TIMER_CREATE,
+	unix.SYS_TIMER_SETTIME,
+	unix.SYS_TIMER_DELETE,
+	unix.SYS_EXIT,
+	unix.SYS_EXIT_GROUP,
+	// files, limited by landlock
+	unix.SYS_READ,
+	unix.SYS_WRITE,
+	unix.SYS_READV,
+	unix.SYS_WRITEV,
+	unix.SYS_PREAD64,
+	unix.SYS_PWRITE64,
+	unix.SYS_OPENAT,
+	unix.SYS_CLOSE,
+	unix.SYS_LSEEK,
+	unix.SYS_FSTAT,
+	unix.SYS_STATX,
+	unix.SYS_STATFS,
+	unix.SYS_FSTATFS,
+	unix.SYS_GETDENTS64,
+	unix.SYS_FCNTL,
+	unix.SYS_FLOCK,
+	unix.SYS_FSYNC,
+	unix.SYS_FDATASYNC,
+	unix.SYS_FTRUNCATE,
+	unix.SYS_FALLOCATE,
+	unix.SYS_RENAMEAT,
+	unix.SYS_RENAMEAT2,
+	unix.SYS_UNLINKAT,
+	unix.SYS_MKDIRAT,
+	unix.SYS_READLINKAT,
+	unix.SYS_FACCESSAT,
+	unix.SYS_FACCESSAT2,
+	unix.SYS_FCHMOD,
+	unix.SYS_FCHMODAT,
+	unix.SYS_UTIMENSAT,
+	unix.SYS_GETCWD,
+	unix.SYS_UMASK,
+	unix.SYS_DUP,
+	unix.SYS_DUP3,
+	unix.SYS_IOCTL,
+	unix.SYS_PIPE2,
+	unix.SYS_EVENTFD2,
+	unix.SYS_INOTIFY_INIT1,
+	unix.SYS_INOTIFY_ADD_WATCH,
+	unix.SYS_INOTIFY_RM_WATCH,
+	unix.SYS_EPOLL_CREATE1,
+	unix.SYS_EPOLL_CTL,
+	unix.SYS_EPOLL_PWAIT,
+	unix.SYS_PPOLL,
+	unix.SYS_PSELECT6,
+	unix.SYS_SPLICE,
+	unix.SYS_SENDFILE,
+	unix.SYS_COPY_FILE_RANGE,
+	// sockets, to serve the API and send events
+	unix.SYS_SOCKET,
+	unix.SYS_SOCKETPAIR,
+	unix.SYS_CONNECT,
+	unix.SYS_ACCEPT,
+	unix.SYS_ACCEPT4,
+	unix.SYS_BIND,
+	unix.SYS_LISTEN,
+	unix.SYS_GETSOCKNAME,
+	unix.SYS_GETPEERNAME,
+	unix.SYS_SETSOCKOPT,
+	unix.SYS_GETSOCKOPT,
+	unix.SYS_SENDTO,
+	unix.SYS_RECVFROM,
+	unix.SYS_SENDMSG,
+	unix.SYS_RECVMSG,
+	unix.SYS_SENDMMSG,
+	unix.SYS_RECVMMSG,
+	unix.SYS_SHUTDOWN,
+}, archAllowedSyscalls...)
+
+// bpf commands operating on existing maps, all other commands are denied
+var allowedBPFCommands = []uint32{
+	unix.BPF_MAP_LOOKUP_ELEM,
+	unix.BPF_MAP_UPDATE_ELEM,
+	unix.BPF_MAP_DELETE_ELEM,
+	unix.BPF_MAP_GET_NEXT_KEY,
+	unix.BPF_OBJ_GET_INFO_BY_FD,
+	unix.BPF_MAP_LOOKUP_AND_DELETE_ELEM,
+	unix.BPF_MAP_FREEZE,
+	unix.BPF_MAP_LOOKUP_BATCH,
+	unix.BPF_MAP_LOOKUP_AND_DELETE_BATCH,
+	unix.BPF_MAP_UPDATE_BATCH,
+	unix.BPF_MAP_DELETE_BATCH,
+}
+
+// seccompFilter returns the classic BPF program of the filter of the process pid, which only
+// allows the allowed syscalls, other syscalls fail with EPERM. Signals can only be sent to
+// the process itself, e.g. by the Go runtime to preempt goroutines.
+func seccompFilter(pid uint32) []unix.SockFilter {
+	var prog []unix.SockFilter
+	load := func(offset uint32) {
+		prog = append(prog, unix.SockFilter{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: offset})
+	}
+	ret := func(k uint32) {
+		prog = append(prog, unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: k})
+	}
+	allow := func() { ret(seccompRetAllow) }
+	deny := func() { ret(seccompRetErrno | uint32(unix.EPERM)) }
+	// each check is followed by what it returns, so no jump is longer than a block, as
+	// conditional jumps are limited to 255 instructions
+	returnIf := func(op uint16, k uint32, ret func()) {
+		prog = append(prog, unix.SockFilter{Code: unix.BPF_JMP | op | unix.BPF_K, K: k, Jt: 0, Jf: 1})
+		ret()
+	}
+	// block checks the arguments of a syscall in the instructions added by check, which must
+	// return, and is skipped by the other syscalls
+	block := func(nr uintptr, check func()) {
+		jump := len(prog)
+		prog = append(prog, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: uint32(nr)})
+		check()
+		prog[jump].Jf = uint8(len(prog) - jump - 1)
+	}
+
+	load(seccompDataArch)
+	// deny syscalls of other architectures, their numbers differ
+	prog = append(prog, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: auditArch, Jt: 1})
+	deny()
+
+	load(seccompDataNr)
+	returnIf(unix.BPF_JGE, x32SyscallBit, deny)
+	// glibc only falls back to clone when clone3 is not implemented
+	returnIf(unix.BPF_JEQ, unix.SYS_CLONE3, func() { ret(seccompRetErrno | uint32(unix.ENOSYS)) })
+	for _, nr := range allowedSyscalls {
+		returnIf(unix.BPF_JEQ, uint32(nr), allow)
+	}
+	block(unix.SYS_BPF, func() {
+		load(seccompDataArg0)
+		for _, cmd := range allowedBPFCommands {
+			returnIf(unix.BPF_JEQ, cmd, allow)
+		}
+		deny()
+	})
+	block(unix.SYS_CLONE, func() {
+		// new threads, but not new namespaces, the flags being the first argument
+		load(seccompDataArg0)
+		returnIf(unix.BPF_JSET, cloneNamespaceFlags, deny)
+		allow()
+	})
+	for _, nr := range []uintptr{unix.SYS_KILL, unix.SYS_TGKILL} {
+		block(nr, func() {
+			load(seccompDataArg0)
+			returnIf(unix.BPF_JEQ, pid, allow)
+			deny()
+		})
+	}
+	deny()
+	return prog
+}
+
+// installSeccompFilter installs the filter on every thread of the process.
+func installSeccompFilter() error {
+	return loadSeccompFilter(seccompFilterFlagTSync)
+}
+
+// loadSeccompFilter installs the filter on the calling thread, and on every thread of the
+// process with seccompFilterFlagTSync.
+func loadSeccompFilter(flags uintptr) error {
+	filter := seccompFilter(uint32(os.Getpid()))
+	prog := unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+	ret, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, flags, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return errno
+	}
+	if ret != 0 {
+		return fmt.Errorf("could not synchronize thread %d", ret)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/solo-io/bumblebee/pkg/internal/kube"
+)
+
+// ServiceAccountDir holds the token and CA certificate Kubernetes secrets are read with by default
+const ServiceAccountDir = kube.ServiceAccountDir
+
+func (r *Resolver) env(name string) (string, error) {
+	lookup := r.LookupEnv
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	value, ok := lookup(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+func readFile(path string) (string, error) {
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(byt), "\r\n"), nil
+}
+
+// splitKey splits the key from a secret ref, e.g. secret/data/bee#password.
+func splitKey(ref string) (string, string, error) {
+	i := strings.LastIndexByte(ref, '#')
+	if i <= 0 || i == len(ref)-1 {
+		return "", "", fmt.Errorf("secret ref %q must be <secret>#<key>", ref)
+	}
+	return ref[:i], ref[i+1:], nil
+}
+
+// vault reads a key of a secret of the KV secrets engine, version 1 or 2.
+func (r *Resolver) vault(ctx context.Context, ref string) (string, error) {
+	secretPath, key, err := splitKey(ref)
+	if err != nil {
+		return "", err
+	}
+	addr := r.VaultAddr
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return "", errors.New("the Vault address is not set, set $VAULT_ADDR")
+	}
+	token := r.VaultToken
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			token, _ = readFile(filepath.Join(home, ".vault-token"))
+		}
+	}
+	if token == "" {
+		return "", errors.New("no Vault token, set $VAULT_TOKEN or log in with `vault login`")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(secretPath, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := getJSON(r.client(), req, &body); err != nil {
+		return "", fmt.Errorf("could not read Vault secret %s: %w", secretPath, err)
+	}
+	data := body.Data
+	// version 2 of the KV engine nests the keys under data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, hasMetadata := data["metadata"]; hasMetadata {
+			data = nested
+		}
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("Vault secret %s has no key %s", secretPath, key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	byt, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(byt), nil
+}
+
+// kubernetes reads a key of a Kubernetes secret, as namespace/name#key.
+func (r *Resolver) kubernetes(ctx context.Context, ref string) (string, error) {
+	secret, key, err := splitKey(ref)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(secret, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("Kubernetes secret ref %q must be <namespace>/<name>#<key>", ref)
+	}
+
+	client, err := kube.NewClient(kube.Config{
+		APIServer:  r.KubernetesAPIServer,
+		TokenFile:  r.KubernetesTokenFile,
+		CAFile:     r.KubernetesCAFile,
+		HTTPClient: r.Client,
+	})
+	if err != nil {
+		return "", err
+	}
+	var body struct {
+		Data map[string]string `json:"data"`
+	}
+	err = client.Do(ctx, http.MethodGet, "/api/v1/namespaces/"+url.PathEscape(parts[0])+"/secrets/"+url.PathEscape(parts[1]), "", nil, &body)
+	var statusErr *kube.StatusError
+	if errors.As(err, &statusErr) {
+		// the body isn't returned, it may echo the request
+		err = fmt.Errorf("unexpected status %s", statusErr.Status)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not read Kubernetes secret %s: %w", secret, err)
+	}
+	encoded, ok := body.Data[key]
+	if !ok {
+		return "", fmt.Errorf("Kubernetes secret %s has no key %s", secret, key)
+	}
+	value, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("could not decode key %s of Kubernetes secret %s: %w", key, secret, err)
+	}
+	return string(value), nil
+}
+
+func getJSON(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// the body isn't returned, it may echo the request
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,217 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Providers of the values of references.
+const (
+	ProviderEnv        = "env"
+	ProviderFile       = "file"
+	ProviderVault      = "vault"
+	ProviderKubernetes = "k8s"
+)
+
+// Resolver resolves the references to environment variables and secrets in the string
+// values of config files, so credentials are read when bee runs rather than written in the
+// files. A reference is either ${NAME}, for the environment variable NAME, or
+// ${provider:ref}:
+//
+//	${env:OPENSEARCH_PASSWORD}         environment variable
+//	${file:/run/secrets/webhook-token} content of a file, without its trailing newline
+//	${vault:secret/data/bee#password}  key of a Vault secret, read with $VAULT_ADDR and $VAULT_TOKEN
+//	${k8s:monitoring/bee#password}     key of a Kubernetes secret, as namespace/name#key
+//
+// $${ escapes a literal ${. Only the references matching the allow-list are resolved, any
+// other is an error, so a config file can't read any secret of the host.
+type Resolver struct {
+	// Patterns of the references allowed, as provider:ref matched with path.Match, e.g.
+	// env:OPENSEARCH_* or vault:secret/data/bee/*. Environment variables referenced as
+	// ${NAME} are matched as env:NAME.
+	Allow []string
+
+	// Environment variables are looked up with LookupEnv, os.LookupEnv by default
+	LookupEnv func(string) (string, bool)
+	// Vault server and token, default to $VAULT_ADDR and $VAULT_TOKEN or ~/.vault-token
+	VaultAddr  string
+	VaultToken string
+	// Kubernetes API server and credentials, default to the ones of the pod bee runs in
+	KubernetesAPIServer string
+	KubernetesTokenFile string
+	KubernetesCAFile    string
+	// Client of the Vault and Kubernetes requests, http.DefaultClient by default
+	Client *http.Client
+}
+
+// Validate checks the patterns of the allow-list.
+func (r *Resolver) Validate() error {
+	for _, pattern := range r.Allow {
+		provider, _, ok := cut(pattern)
+		if !ok {
+			return fmt.Errorf("invalid secret pattern %q, must be provider:ref", pattern)
+		}
+		if !knownProvider(provider) {
+			return fmt.Errorf("unknown secret provider %q in %q, must be one of env, file, vault or k8s", provider, pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid secret pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Unmarshal resolves the references of the string values of the YAML document, keys are
+// left as is, then strictly unmarshals it into out. Secrets are only held in out, errors
+// name the references rather than their values.
+func (r *Resolver) Unmarshal(ctx context.Context, data []byte, out interface{}) error {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if err := r.expandNode(ctx, &doc); err != nil {
+		return err
+	}
+	// encoded again, rather than expanded in the text, so values can't change the structure
+	// of the document, and the values without references are kept as written
+	expanded, err := yamlv3.Marshal(&doc)
+	if err != nil {
+		return err
+	}
+	return yaml.UnmarshalStrict(expanded, out)
+}
+
+func (r *Resolver) expandNode(ctx context.Context, n *yamlv3.Node) error {
+	switch n.Kind {
+	case yamlv3.ScalarNode:
+		if !strings.Contains(n.Value, "${") {
+			return nil
+		}
+		value, err := r.Expand(ctx, n.Value)
+		if err != nil {
+			return err
+		}
+		n.Value = value
+		if n.Tag == "!!str" {
+			// quoted, as the value may look like another type
+			n.Style = yamlv3.DoubleQuotedStyle
+		}
+	case yamlv3.MappingNode:
+		// only the values, at odd indexes
+		for i := 1; i < len(n.Content); i += 2 {
+			if err := r.expandNode(ctx, n.Content[i]); err != nil {
+				return err
+			}
+		}
+	case yamlv3.DocumentNode, yamlv3.SequenceNode:
+		for _, c := range n.Content {
+			if err := r.expandNode(ctx, c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Expand resolves the references of the string.
+func (r *Resolver) Expand(ctx context.Context, s string) (string, error) {
+	var sb strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			sb.WriteString(s)
+			return sb.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			sb.WriteString(s[:i])
+			sb.WriteString("{")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference in %q", s)
+		}
+		ref := s[i+2 : i+end]
+		value, err := r.resolve(ctx, ref)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(s[:i])
+		sb.WriteString(value)
+		s = s[i+end+1:]
+	}
+}
+
+func (r *Resolver) resolve(ctx context.Context, ref string) (string, error) {
+	provider, name, ok := cut(ref)
+	if !ok {
+		provider, name = ProviderEnv, ref
+	}
+	if name == "" {
+		return "", fmt.Errorf("empty reference ${%s}", ref)
+	}
+	if !knownProvider(provider) {
+		return "", fmt.Errorf("unknown secret provider %q in ${%s}, must be one of env, file, vault or k8s", provider, ref)
+	}
+	if !r.allowed(provider + ":" + name) {
+		return "", fmt.Errorf("reference ${%s} is not allowed, allow it with --allow-secret=%s:%s", ref, provider, name)
+	}
+	var (
+		value string
+		err   error
+	)
+	switch provider {
+	case ProviderEnv:
+		value, err = r.env(name)
+	case ProviderFile:
+		value, err = readFile(name)
+	case ProviderVault:
+		value, err = r.vault(ctx, name)
+	case ProviderKubernetes:
+		value, err = r.kubernetes(ctx, name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not resolve ${%s}: %w", ref, err)
+	}
+	return value, nil
+}
+
+func (r *Resolver) allowed(ref string) bool {
+	for _, pattern := range r.Allow {
+		if ok, _ := path.Match(pattern, ref); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// cut splits the provider from the ref.
+func cut(s string) (string, string, bool) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return "", s, false
+	}
+	return s[:i], s[i+1:], true
+}
+
+func knownProvider(provider string) bool {
+	switch provider {
+	case ProviderEnv, ProviderFile, ProviderVault, ProviderKubernetes:
+		return true
+	}
+	return false
+}
+
+func (r *Resolver) client() *http.Client {
+	if r.Client != nil {
+		return r.Client
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,191 @@
+package skeleton
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/cilium/ebpf"
+)
+
+const goTemplate = `// Code generated by bee from {{ .Ref }}; DO NOT EDIT.
+
+package {{ .Package }}
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+
+	"github.com/cilium/ebpf"
+)
+
+// load{{ .Type }} returns the embedded CollectionSpec for {{ .Ident }}.
+func load{{ .Type }}() (*ebpf.CollectionSpec, error) {
+	reader := bytes.NewReader(_{{ .Type }}Bytes)
+	spec, err := ebpf.LoadCollectionSpecFromReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't load {{ .Ident }}: %w", err)
+	}
+	return spec, err
+}
+
+// load{{ .Type }}Objects loads {{ .Ident }} and converts it into a struct.
+//
+// The following types are suitable as obj argument:
+//
+//     *{{ .Ident }}Objects
+//     *{{ .Ident }}Programs
+//     *{{ .Ident }}Maps
+//
+// See ebpf.CollectionSpec.LoadAndAssign documentation for details.
+func load{{ .Type }}Objects(obj interface{}, opts *ebpf.CollectionOptions) error {
+	spec, err := load{{ .Type }}()
+	if err != nil {
+		return err
+	}
+	return spec.LoadAndAssign(obj, opts)
+}
+
+// {{ .Ident }}Objects contains all objects after they have been loaded into the kernel.
+type {{ .Ident }}Objects struct {
+	{{ .Ident }}Programs
+	{{ .Ident }}Maps
+}
+
+func (o *{{ .Ident }}Objects) Close() error {
+	return _{{ .Type }}Close(
+		&o.{{ .Ident }}Programs,
+		&o.{{ .Ident }}Maps,
+	)
+}
+
+// {{ .Ident }}Maps contains all maps after they have been loaded into the kernel.
+type {{ .Ident }}Maps struct {
+{{- range .Maps }}
+	{{ .Field }} *ebpf.Map ` + "`" + `ebpf:"{{ .Name }}"` + "`" + `
+{{- end }}
+}
+
+func (m *{{ .Ident }}Maps) Close() error {
+	return _{{ .Type }}Close(
+{{- range .Maps }}
+		m.{{ .Field }},
+{{- end }}
+	)
+}
+
+// {{ .Ident }}Programs contains all programs after they have been loaded into the kernel.
+type {{ .Ident }}Programs struct {
+{{- range .Programs }}
+	{{ .Field }} *ebpf.Program ` + "`" + `ebpf:"{{ .Name }}"` + "`" + `
+{{- end }}
+}
+
+func (p *{{ .Ident }}Programs) Close() error {
+	return _{{ .Type }}Close(
+{{- range .Programs }}
+		p.{{ .Field }},
+{{- end }}
+	)
+}
+
+func _{{ .Type }}Close(closers ...io.Closer) error {
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do not access this directly.
+//go:embed {{ .ObjectFile }}
+var _{{ .Type }}Bytes []byte
+`
+
+var (
+	parsedGoTemplate = template.Must(template.New("go").Parse(goTemplate))
+
+	identifierSeparators = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+)
+
+type GoOpts struct {
+	// Ref of the package, recorded in the generated code
+	Ref string
+	// Package of the generated file
+	Package string
+	// Ident prefixes the generated types, e.g. `tcpconnect` generates `tcpconnectObjects`
+	Ident string
+	// ObjectFile is the name of the ELF file embedded in the generated code,
+	// relative to the generated file
+	ObjectFile string
+}
+
+type goObject struct {
+	Name  string
+	Field string
+}
+
+type goData struct {
+	GoOpts
+	// Ident, capitalized, for the unexported functions
+	Type     string
+	Maps     []goObject
+	Programs []goObject
+}
+
+// GenerateGo writes a cilium/ebpf loader, embedding the ELF of the package, in the style of bpf2go.
+func GenerateGo(w io.Writer, collection *ebpf.CollectionSpec, opts GoOpts) error {
+	data := goData{
+		GoOpts: opts,
+		Type:   toIdentifier(opts.Ident),
+	}
+	for name := range collection.Maps {
+		data.Maps = append(data.Maps, goObject{Name: name, Field: toIdentifier(name)})
+	}
+	for name := range collection.Programs {
+		data.Programs = append(data.Programs, goObject{Name: name, Field: toIdentifier(name)})
+	}
+	sortObjects(data.Maps)
+	sortObjects(data.Programs)
+
+	var buf bytes.Buffer
+	if err := parsedGoTemplate.Execute(&buf, data); err != nil {
+		return err
+	}
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("could not format generated code: %w", err)
+	}
+	_, err = w.Write(formatted)
+	return err
+}
+
+func sortObjects(objects []goObject) {
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Name < objects[j].Name
+	})
+}
+
+// toIdentifier converts names like `events_hash` or `.rodata` to exported Go identifiers, e.g. `EventsHash`.
+func toIdentifier(name string) string {
+	var sb strings.Builder
+	for _, part := range identifierSeparators.Split(name, -1) {
+		if part == "" {
+			continue
+		}
+		sb.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	ident := sb.String()
+	if ident == "" || (ident[0] >= '0' && ident[0] <= '9') {
+		ident = "X" + ident
+	}
+	return ident
+}
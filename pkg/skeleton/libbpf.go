@@ -0,0 +1,34 @@
+package skeleton
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+type LibbpfOpts struct {
+	// Name of the skeleton, e.g. `tcpconnect` generates `struct tcpconnect_bpf`
+	Name string
+	// Bpftool is the path of the bpftool executable
+	Bpftool string
+}
+
+// GenerateLibbpf returns a libbpf skeleton header for the ELF file, as generated by `bpftool gen skeleton`.
+func GenerateLibbpf(ctx context.Context, objectFile string, opts LibbpfOpts) ([]byte, error) {
+	bpftool := opts.Bpftool
+	if bpftool == "" {
+		bpftool = "bpftool"
+	}
+	args := []string{"gen", "skeleton", objectFile}
+	if opts.Name != "" {
+		args = append(args, "name", opts.Name)
+	}
+	out, err := exec.CommandContext(ctx, bpftool, args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("could not generate skeleton: %w: %s", err, exitErr.Stderr)
+		}
+		return nil, fmt.Errorf("could not generate skeleton: %w", err)
+	}
+	return out, nil
+}
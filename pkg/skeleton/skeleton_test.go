@@ -0,0 +1,82 @@
+package skeleton_test
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/cilium/ebpf"
+	"github.com/solo-io/bumblebee/pkg/skeleton"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the generated code")
+
+var _ = Describe("GenerateGo", func() {
+	opts := skeleton.GoOpts{
+		Ref:        "ghcr.io/solo-io/bumblebee/probe:v1",
+		Package:    "probe",
+		Ident:      "probe",
+		ObjectFile: "probe.o",
+	}
+
+	for _, c := range []struct {
+		golden string
+		maps   map[string]*ebpf.MapSpec
+	}{
+		{"ringbuf", map[string]*ebpf.MapSpec{"events": {Type: ebpf.RingBuf, MaxEntries: 1 << 24}}},
+		{"perf_event_array", map[string]*ebpf.MapSpec{"perf_events": {Type: ebpf.PerfEventArray}}},
+		{"hash", map[string]*ebpf.MapSpec{"sizes_hash": {Type: ebpf.Hash, KeySize: 4, ValueSize: 8, MaxEntries: 1024}}},
+		{"percpu_hash", map[string]*ebpf.MapSpec{"drops_per_cpu": {Type: ebpf.PerCPUHash, KeySize: 4, ValueSize: 8, MaxEntries: 1024}}},
+		{"array", map[string]*ebpf.MapSpec{
+			// the sections of the globals are arrays too
+			".rodata":  {Type: ebpf.Array, KeySize: 4, ValueSize: 4, MaxEntries: 1},
+			"settings": {Type: ebpf.Array, KeySize: 4, ValueSize: 8, MaxEntries: 16},
+		}},
+	} {
+		c := c
+		It("generates the loader of "+c.golden+" maps", func() {
+			collection := &ebpf.CollectionSpec{
+				Maps: c.maps,
+				Programs: map[string]*ebpf.ProgramSpec{
+					"kprobe__tcp_v4_connect": {Type: ebpf.Kprobe},
+				},
+			}
+			var buf bytes.Buffer
+			Expect(skeleton.GenerateGo(&buf, collection, opts)).To(Succeed())
+
+			path := filepath.Join("testdata", c.golden+".golden")
+			if *update {
+				Expect(ioutil.WriteFile(path, buf.Bytes(), 0644)).To(Succeed())
+			}
+			golden, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred(), "run the tests with -update to generate the golden files")
+			Expect(buf.String()).To(Equal(string(golden)))
+		})
+	}
+})
+
+var _ = Describe("GenerateLibbpf", func() {
+	It("generates the skeleton with bpftool", func() {
+		dir, err := ioutil.TempDir("", "bee-skeleton")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		// prints the arguments it is run with as a header
+		bpftool := filepath.Join(dir, "bpftool")
+		Expect(ioutil.WriteFile(bpftool, []byte("#!/bin/sh\necho \"/* $* */\"\n"), 0755)).To(Succeed())
+
+		header, err := skeleton.GenerateLibbpf(context.Background(), "probe.o", skeleton.LibbpfOpts{Name: "probe", Bpftool: bpftool})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(header)).To(Equal("/* gen skeleton probe.o name probe */\n"))
+
+		failing := filepath.Join(dir, "failing")
+		Expect(ioutil.WriteFile(failing, []byte("#!/bin/sh\necho 'Error: failed to open BPF object file' >&2\nexit 1\n"), 0755)).To(Succeed())
+		_, err = skeleton.GenerateLibbpf(context.Background(), "probe.o", skeleton.LibbpfOpts{Bpftool: failing})
+		Expect(err).To(MatchError(ContainSubstring("failed to open BPF object file")))
+	})
+})
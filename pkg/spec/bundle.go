@@ -0,0 +1,264 @@
+package spec
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/pkg/content"
+)
+
+// BundleInfo describes a verified bundle.
+type BundleInfo struct {
+	Ref    string
+	Digest digest.Digest
+	// MediaTypes of the verified blobs, by digest
+	Blobs map[digest.Digest]string
+}
+
+// ExportBundle writes the package, and all the blobs it references, from the local store
+// as a tar archive of an OCI image layout. The bundle can be verified with VerifyBundle
+// without access to the registry.
+func ExportBundle(ctx context.Context, localStorageDir, ref string, w io.Writer) error {
+	if localStorageDir == "" {
+		localStorageDir = EbpfImageDir
+	}
+	localRegistry, err := content.NewOCI(localStorageDir)
+	if err != nil {
+		return err
+	}
+	_, desc, err := localRegistry.Resolve(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("could not find %s in the local store: %w", ref, err)
+	}
+
+	blobs := map[digest.Digest]int64{}
+	if err := collectBlobs(localStorageDir, desc, blobs); err != nil {
+		return fmt.Errorf("could not read package %s: %w", ref, err)
+	}
+	digests := make([]digest.Digest, 0, len(blobs))
+	for dgst := range blobs {
+		digests = append(digests, dgst)
+	}
+	sort.Slice(digests, func(i, j int) bool {
+		return digests[i] < digests[j]
+	})
+
+	if desc.Annotations == nil {
+		desc.Annotations = map[string]string{}
+	}
+	desc.Annotations[ocispec.AnnotationRefName] = ref
+	index, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: []ocispec.Descriptor{desc},
+	})
+	if err != nil {
+		return err
+	}
+	layout, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	writeFile := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := writeFile(ocispec.ImageLayoutFile, layout); err != nil {
+		return err
+	}
+	if err := writeFile("index.json", index); err != nil {
+		return err
+	}
+	for _, dgst := range digests {
+		name := filepath.Join("blobs", dgst.Algorithm().String(), dgst.Encoded())
+		data, err := ioutil.ReadFile(filepath.Join(localStorageDir, name))
+		if err != nil {
+			return fmt.Errorf("could not read blob %s: %w", dgst, err)
+		}
+		if err := writeFile(name, data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// VerifyBundle checks that the bundle contains a single package, that every blob it
+// references is present, and that each blob matches its digest and size.
+func VerifyBundle(r io.Reader) (*BundleInfo, error) {
+	info, _, _, err := readBundle(r)
+	return info, err
+}
+
+// ImportBundle verifies the bundle, as VerifyBundle, and stores its package in the local store
+// of the directory, defaults to EbpfImageDir, tagged with the ref it was exported with, or with
+// the ref given if not empty.
+func ImportBundle(ctx context.Context, localStorageDir string, r io.Reader, ref string) (*BundleInfo, error) {
+	if localStorageDir == "" {
+		localStorageDir = EbpfImageDir
+	}
+	info, root, blobs, err := readBundle(r)
+	if err != nil {
+		return nil, err
+	}
+	if ref != "" {
+		info.Ref = ref
+	}
+	if info.Ref == "" {
+		return nil, errors.New("the bundle has no ref, import it with one")
+	}
+	store, err := content.NewOCI(localStorageDir)
+	if err != nil {
+		return nil, err
+	}
+	// only the verified blobs are stored, not the ones the package does not reference
+	for dgst := range info.Blobs {
+		path := filepath.Join(localStorageDir, "blobs", dgst.Algorithm().String(), dgst.Encoded())
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(path, blobs[dgst]); err != nil {
+			return nil, fmt.Errorf("could not store blob %s: %w", dgst, err)
+		}
+	}
+	delete(root.Annotations, ocispec.AnnotationRefName)
+	if err := addReference(store, info.Ref, root); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// readBundle verifies the bundle, returning its root descriptor and its blobs.
+func readBundle(r io.Reader) (*BundleInfo, ocispec.Descriptor, map[digest.Digest][]byte, error) {
+	var index *ocispec.Index
+	var hasLayout bool
+	blobs := map[digest.Digest][]byte{}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ocispec.Descriptor{}, nil, fmt.Errorf("could not read bundle: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, ocispec.Descriptor{}, nil, fmt.Errorf("could not read %s: %w", hdr.Name, err)
+		}
+		switch name := filepath.ToSlash(filepath.Clean(hdr.Name)); {
+		case name == ocispec.ImageLayoutFile:
+			var layout ocispec.ImageLayout
+			if err := json.Unmarshal(data, &layout); err != nil {
+				return nil, ocispec.Descriptor{}, nil, fmt.Errorf("invalid %s: %w", ocispec.ImageLayoutFile, err)
+			}
+			if layout.Version != ocispec.ImageLayoutVersion {
+				return nil, ocispec.Descriptor{}, nil, fmt.Errorf("unsupported image layout version %s", layout.Version)
+			}
+			hasLayout = true
+		case name == "index.json":
+			index = &ocispec.Index{}
+			if err := json.Unmarshal(data, index); err != nil {
+				return nil, ocispec.Descriptor{}, nil, fmt.Errorf("invalid index.json: %w", err)
+			}
+		case filepath.Dir(filepath.Dir(name)) == "blobs":
+			dgst := digest.NewDigestFromEncoded(digest.Algorithm(filepath.Base(filepath.Dir(name))), filepath.Base(name))
+			if err := dgst.Validate(); err != nil {
+				return nil, ocispec.Descriptor{}, nil, fmt.Errorf("invalid blob %s: %w", name, err)
+			}
+			if actual := dgst.Algorithm().FromBytes(data); actual != dgst {
+				return nil, ocispec.Descriptor{}, nil, fmt.Errorf("blob %s does not match its digest, found %s", dgst, actual)
+			}
+			blobs[dgst] = data
+		}
+	}
+	if !hasLayout {
+		return nil, ocispec.Descriptor{}, nil, fmt.Errorf("bundle is missing %s", ocispec.ImageLayoutFile)
+	}
+	if index == nil {
+		return nil, ocispec.Descriptor{}, nil, errors.New("bundle is missing index.json")
+	}
+	if len(index.Manifests) != 1 {
+		return nil, ocispec.Descriptor{}, nil, fmt.Errorf("bundle must contain a single package, found %d", len(index.Manifests))
+	}
+
+	root := index.Manifests[0]
+	info := &BundleInfo{
+		Ref:    root.Annotations[ocispec.AnnotationRefName],
+		Digest: root.Digest,
+		Blobs:  map[digest.Digest]string{},
+	}
+	if err := verifyBundleBlob(root, blobs, info.Blobs); err != nil {
+		return nil, ocispec.Descriptor{}, nil, err
+	}
+	return info, root, blobs, nil
+}
+
+func verifyBundleBlob(desc ocispec.Descriptor, blobs map[digest.Digest][]byte, verified map[digest.Digest]string) error {
+	if _, ok := verified[desc.Digest]; ok {
+		return nil
+	}
+	data, ok := blobs[desc.Digest]
+	if !ok {
+		return fmt.Errorf("bundle is missing blob %s (%s)", desc.Digest, desc.MediaType)
+	}
+	if int64(len(data)) != desc.Size {
+		return fmt.Errorf("blob %s has size %d, expected %d", desc.Digest, len(data), desc.Size)
+	}
+	verified[desc.Digest] = desc.MediaType
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageManifest:
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("invalid manifest %s: %w", desc.Digest, err)
+		}
+		if err := verifyBundleBlob(manifest.Config, blobs, verified); err != nil {
+			return err
+		}
+		for _, layer := range manifest.Layers {
+			if err := verifyBundleBlob(layer, blobs, verified); err != nil {
+				return err
+			}
+		}
+	case ocispec.MediaTypeImageIndex:
+		var index ocispec.Index
+		if err := json.Unmarshal(data, &index); err != nil {
+			return fmt.Errorf("invalid index %s: %w", desc.Digest, err)
+		}
+		for _, manifest := range index.Manifests {
+			if err := verifyBundleBlob(manifest, blobs, verified); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
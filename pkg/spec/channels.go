@@ -0,0 +1,145 @@
+package spec
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/oras"
+	"oras.land/oras-go/pkg/target"
+)
+
+// AnnotationChannel records, in the local store, the channel a package was pulled from.
+const AnnotationChannel = "io.solo.bumblebee.channel"
+
+// Deprecated: use v1.Channel
+type Channel = v1.Channel
+
+const (
+	// Deprecated: use v1.ChannelNightly
+	ChannelNightly = v1.ChannelNightly
+	// Deprecated: use v1.ChannelBeta
+	ChannelBeta = v1.ChannelBeta
+	// Deprecated: use v1.ChannelStable
+	ChannelStable = v1.ChannelStable
+)
+
+// Deprecated: use v1.ParseChannel
+func ParseChannel(s string) (v1.Channel, error) {
+	return v1.ParseChannel(s)
+}
+
+// ChannelRef returns the reference of the channel in the repository, e.g. `ghcr.io/solo-io/tcpconnect:stable`.
+func ChannelRef(repo string, channel v1.Channel) string {
+	return fmt.Sprintf("%s:%s", repo, channel)
+}
+
+// Promote moves the channel tag to the digest. The digest must be the current release of
+// the previous channel, so packages can't skip a channel. A single tag is written, so clients
+// pulling the channel either get the previous or the promoted package.
+func Promote(
+	ctx context.Context,
+	registry target.Target,
+	repo string,
+	dgst digest.Digest,
+	to v1.Channel,
+) error {
+	if from, ok := to.PromotedFrom(); ok {
+		_, desc, err := registry.Resolve(ctx, ChannelRef(repo, from))
+		if err != nil {
+			return fmt.Errorf("could not resolve channel %s: %w", from, err)
+		}
+		if desc.Digest != dgst {
+			return fmt.Errorf("%s is not the current release of channel %s (%s), it can't be promoted to %s", dgst, from, desc.Digest, to)
+		}
+		if localRegistry, ok := registry.(*content.OCI); ok {
+			// the local store can't resolve digests, tag the resolved descriptor directly
+			localRegistry.AddReference(ChannelRef(repo, to), withoutRefName(desc))
+			return localRegistry.SaveIndex()
+		}
+	}
+
+	_, err := oras.Copy(
+		ctx,
+		registry,
+		fmt.Sprintf("%s@%s", repo, dgst),
+		registry,
+		ChannelRef(repo, to),
+		oras.WithAllowedMediaTypes(AllowedMediaTypes()),
+		oras.WithPullByBFS,
+	)
+	if err != nil {
+		return fmt.Errorf("could not promote %s to channel %s: %w", dgst, to, err)
+	}
+	return nil
+}
+
+// PullChannel pulls the current release of the channel of the repo into the store, as Fetch
+// does, recording the channel it was pulled from. Channels are resolved by the registry
+// target, the remote registry of the repo if nil, so they can't be pulled offline.
+func (l *LocalRegistry) PullChannel(ctx context.Context, repo string, channel v1.Channel, registry target.Target) (*v1.EbpfPackage, error) {
+	ref, err := l.Refs.ExpandRef(ChannelRef(repo, channel))
+	if err != nil {
+		return nil, err
+	}
+	if l.Offline {
+		return nil, fmt.Errorf("channel %s of %s can't be pulled offline, it is resolved by the registry", channel, repo)
+	}
+	store, err := content.NewOCI(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := l.fetch(ctx, store, ref, registry)
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := recordChannel(ctx, store, ref, channel, desc, l.ociClient())
+	if err != nil {
+		return nil, err
+	}
+	return l.transform(ctx, ref, pkg)
+}
+
+func recordChannel(
+	ctx context.Context,
+	localRegistry *content.OCI,
+	ref string,
+	channel v1.Channel,
+	desc ocispec.Descriptor,
+	client EbpfOCICLient,
+) (*EbpfPackage, error) {
+	desc = withoutRefName(desc)
+	if desc.Annotations == nil {
+		desc.Annotations = map[string]string{}
+	}
+	desc.Annotations[AnnotationChannel] = string(channel)
+	localRegistry.AddReference(ref, desc)
+	if err := localRegistry.SaveIndex(); err != nil {
+		return nil, fmt.Errorf("could not record channel: %w", err)
+	}
+
+	pkg, err := client.Pull(ctx, ref, localRegistry)
+	if err != nil {
+		return nil, err
+	}
+	pkg.Channel = channel
+	return pkg, nil
+}
+
+// withoutRefName copies the descriptor without its reference name,
+// so it can be referenced under another name.
+func withoutRefName(desc ocispec.Descriptor) ocispec.Descriptor {
+	if desc.Annotations != nil {
+		annotations := desc.Annotations
+		desc.Annotations = make(map[string]string)
+		for k, v := range annotations {
+			if k != ocispec.AnnotationRefName {
+				desc.Annotations[k] = v
+			}
+		}
+	}
+	return desc
+}
@@ -0,0 +1,215 @@
+package spec
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"oras.land/oras-go/pkg/content"
+)
+
+const (
+	// DefaultCompletionTTL is how long the tags of a repository are completed from the cache
+	// before being listed again
+	DefaultCompletionTTL = 5 * time.Minute
+	// tags are listed from the registry within this time, so completing does not hang a
+	// shell, cached tags are used otherwise
+	completionTimeout = 2 * time.Second
+)
+
+// Completion is a ref completing a partially typed one.
+type Completion struct {
+	Ref string
+	// Where the ref was found, e.g. "local store" or "tag of ghcr.io/solo-io/bumblebee/tcpconnect"
+	Description string
+}
+
+// Completer completes the refs of packages, from the local store and from the tags of their
+// repositories. The tags are cached in a file, so completions run as separate processes by
+// shells only list them once per TTL. It is safe for concurrent use.
+type Completer struct {
+	localStorageDir string
+	cacheFile       string
+	auth            content.RegistryOptions
+
+	// TTL of the cached tags, defaults to DefaultCompletionTTL
+	TTL time.Duration
+	// Offline completes from the local store and the cached tags, even if they expired
+	Offline bool
+
+	lock  sync.Mutex
+	cache map[string]cachedTags
+}
+
+type cachedTags struct {
+	Tags    []string  `json:"tags"`
+	Fetched time.Time `json:"fetched"`
+}
+
+// NewCompleter returns a completer of the refs of the local store of the given directory,
+// defaults to EbpfImageDir, caching the tags listed from the registries in the given file.
+func NewCompleter(localStorageDir, cacheFile string, auth content.RegistryOptions) *Completer {
+	if localStorageDir == "" {
+		localStorageDir = EbpfImageDir
+	}
+	return &Completer{
+		localStorageDir: localStorageDir,
+		cacheFile:       cacheFile,
+		auth:            auth,
+		TTL:             DefaultCompletionTTL,
+	}
+}
+
+// Complete returns the refs starting with the partial one, sorted. The refs of the local
+// store and the repositories of the store and of the cache, ending with a tag separator,
+// are completed, and tags are listed from the registry once the partial ref has a tag
+// separator, e.g. ghcr.io/solo-io/bumblebee/tcpconnect:v. When the registry can't be
+// reached, the candidates found are returned along with its error.
+func (c *Completer) Complete(ctx context.Context, partial string) ([]Completion, error) {
+	candidates := map[string]string{}
+
+	store, err := content.NewOCI(c.localStorageDir)
+	if err != nil {
+		return nil, err
+	}
+	for ref := range store.ListReferences() {
+		if strings.HasPrefix(ref, partial) && !IsSignatureRef(ref) {
+			candidates[ref] = "local store"
+		}
+	}
+
+	var tagsErr error
+	repo, tag, ok := splitTag(partial)
+	if !ok {
+		// repositories are completed up to the tag separator, so their tags are completed next
+		for _, r := range c.repositories(store) {
+			if strings.HasPrefix(r, partial) {
+				candidates[r+":"] = "repository"
+			}
+		}
+	} else {
+		var tags []string
+		tags, tagsErr = c.tags(ctx, repo)
+		for _, t := range tags {
+			ref := repo + ":" + t
+			if _, ok := candidates[ref]; !ok && strings.HasPrefix(t, tag) && !IsSignatureRef(ref) {
+				candidates[ref] = "tag of " + repo
+			}
+		}
+	}
+
+	completions := make([]Completion, 0, len(candidates))
+	for ref, description := range candidates {
+		completions = append(completions, Completion{Ref: ref, Description: description})
+	}
+	sort.Slice(completions, func(i, j int) bool {
+		return completions[i].Ref < completions[j].Ref
+	})
+	return completions, tagsErr
+}
+
+// splitTag splits a ref into its repository and its partial tag, if it has a tag separator.
+func splitTag(ref string) (string, string, bool) {
+	i := strings.LastIndex(ref, ":")
+	// the colon of a port, e.g. localhost:5000/repo, is before the last slash
+	if i < 0 || i < strings.LastIndex(ref, "/") || strings.Contains(ref, "@") {
+		return "", "", false
+	}
+	return ref[:i], ref[i+1:], true
+}
+
+// repositories returns the repositories of the refs of the store and of the cached tags.
+func (c *Completer) repositories(store *content.OCI) []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.cache == nil {
+		c.cache = c.readCache()
+	}
+
+	var repos []string
+	for repo := range c.cache {
+		repos = append(repos, repo)
+	}
+	for ref := range store.ListReferences() {
+		if repo, _, ok := splitTag(ref); ok {
+			repos = append(repos, repo)
+		}
+	}
+	return repos
+}
+
+// tags returns the tags of the repository, from the cache if they are recent enough.
+func (c *Completer) tags(ctx context.Context, repo string) ([]string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.cache == nil {
+		c.cache = c.readCache()
+	}
+	cached, ok := c.cache[repo]
+	if ok && (c.Offline || time.Since(cached.Fetched) < c.TTL) {
+		return cached.Tags, nil
+	}
+	if c.Offline {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, completionTimeout)
+	defer cancel()
+	tags, err := ListTags(ctx, repo, c.auth)
+	if err != nil {
+		// expired tags are still better than no completion
+		return cached.Tags, err
+	}
+	c.cache[repo] = cachedTags{Tags: tags, Fetched: time.Now()}
+	return tags, c.writeCache()
+}
+
+func (c *Completer) readCache() map[string]cachedTags {
+	cache := map[string]cachedTags{}
+	if c.cacheFile == "" {
+		return cache
+	}
+	data, err := ioutil.ReadFile(c.cacheFile)
+	if err != nil {
+		return cache
+	}
+	// a corrupted cache is only a cache miss
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return map[string]cachedTags{}
+	}
+	return cache
+}
+
+func (c *Completer) writeCache() error {
+	if c.cacheFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(c.cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.cacheFile), 0755); err != nil {
+		return err
+	}
+	// completions of several shells may write the cache at once
+	tmp, err := ioutil.TempFile(filepath.Dir(c.cacheFile), filepath.Base(c.cacheFile))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.cacheFile)
+}
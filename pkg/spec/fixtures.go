@@ -0,0 +1,114 @@
+package spec
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+// The files of a fixture in the fixtures layer, and in the directories fixtures are built from.
+const (
+	FixtureRecordsSuffix = ".records.jsonl"
+	FixtureGoldenSuffix  = ".golden.jsonl"
+)
+
+// encodeFixtures returns the gzipped tarball of the fixtures, the records and golden file of
+// each, named after it. The modification times are left unset so the layer only changes with
+// the fixtures.
+func encodeFixtures(fixtures []v1.Fixture) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	names := map[string]bool{}
+	for _, fixture := range fixtures {
+		if fixture.Name == "" || strings.ContainsAny(fixture.Name, "/\\") {
+			return nil, fmt.Errorf("invalid fixture name %q", fixture.Name)
+		}
+		if names[fixture.Name] {
+			return nil, fmt.Errorf("more than one fixture is named %s", fixture.Name)
+		}
+		names[fixture.Name] = true
+		for _, file := range []struct {
+			name  string
+			bytes []byte
+		}{
+			{fixture.Name + FixtureRecordsSuffix, fixture.Records},
+			{fixture.Name + FixtureGoldenSuffix, fixture.Golden},
+		} {
+			if err := tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.bytes))}); err != nil {
+				return nil, err
+			}
+			if _, err := tw.Write(file.bytes); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeFixtures returns the fixtures of the gzipped tarball of the fixtures layer, sorted by
+// name.
+func decodeFixtures(layer []byte) ([]v1.Fixture, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(layer))
+	if err != nil {
+		return nil, fmt.Errorf("invalid fixtures layer: %w", err)
+	}
+	byName := map[string]*v1.Fixture{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid fixtures layer: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Base(header.Name)
+		var records bool
+		switch {
+		case strings.HasSuffix(name, FixtureRecordsSuffix):
+			name, records = strings.TrimSuffix(name, FixtureRecordsSuffix), true
+		case strings.HasSuffix(name, FixtureGoldenSuffix):
+			name = strings.TrimSuffix(name, FixtureGoldenSuffix)
+		default:
+			continue
+		}
+		byt, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fixtures layer: %w", err)
+		}
+		fixture, ok := byName[name]
+		if !ok {
+			fixture = &v1.Fixture{Name: name}
+			byName[name] = fixture
+		}
+		if records {
+			fixture.Records = byt
+		} else {
+			fixture.Golden = byt
+		}
+	}
+	fixtures := make([]v1.Fixture, 0, len(byName))
+	for _, fixture := range byName {
+		fixtures = append(fixtures, *fixture)
+	}
+	sort.Slice(fixtures, func(i, j int) bool { return fixtures[i].Name < fixtures[j].Name })
+	return fixtures, nil
+}
@@ -0,0 +1,102 @@
+package spec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/target"
+)
+
+// List returns the tags of the repository, sorted. The tags of an OCI image layout, e.g. the
+// local store, are read from its index, the others are listed from the registry of the
+// repository with the credentials of the client.
+func (e *ebpfOCIClient) List(ctx context.Context, repoRef string, registry target.Target) ([]string, error) {
+	repoRef, err := normalizeRef(repoRef)
+	if err != nil {
+		return nil, err
+	}
+	if store, ok := registry.(*content.OCI); ok {
+		return storeTags(store, repoRef), nil
+	}
+	tags, err := ListTags(ctx, repoRef, e.auth)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// storeTags returns the tags of the repository in the store, without the ones of signatures.
+func storeTags(store *content.OCI, repoRef string) []string {
+	var tags []string
+	for ref := range store.ListReferences() {
+		if repo, tag, ok := splitTag(ref); ok && repo == repoRef && !IsSignatureRef(ref) {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Inspect returns the manifest and config of the package of the ref, for multi-variant
+// images the one the client selects, without fetching its layers.
+func (e *ebpfOCIClient) Inspect(ctx context.Context, ref string, registry target.Target) (*v1.EbpfPackageInfo, error) {
+	ref, err := normalizeRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	_, rootDesc, err := registry.Resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	info := &v1.EbpfPackageInfo{Manifest: rootDesc}
+	if rootDesc.MediaType == ocispec.MediaTypeImageIndex {
+		index, err := fetchIndex(ctx, registry, ref, rootDesc)
+		if err != nil {
+			return nil, err
+		}
+		variantDesc, err := selectVariant(ctx, registry, ref, rootDesc, e.selector)
+		if err != nil {
+			return nil, err
+		}
+		indexDesc := rootDesc
+		info.Index = &indexDesc
+		info.Variants = index.Manifests
+		info.Manifest = *variantDesc
+	}
+
+	var manifest ocispec.Manifest
+	if err := fetchJSON(ctx, registry, ref, info.Manifest, &manifest); err != nil {
+		return nil, fmt.Errorf("could not fetch manifest: %w", err)
+	}
+	if !isPackageManifest(manifest) {
+		return nil, fmt.Errorf("%s is not an eBPF package, its config is a %s", ref, manifest.Config.MediaType)
+	}
+	if subtype, _ := mediaTypeSubtype(manifest.Config.MediaType); subtype == SubtypeConfig {
+		if err := fetchJSON(ctx, registry, ref, manifest.Config, &info.EbpfConfig); err != nil {
+			return nil, fmt.Errorf("could not fetch config: %w", err)
+		}
+	}
+	info.Annotations = manifest.Annotations
+	info.Config = manifest.Config
+	info.Layers = manifest.Layers
+	return info, nil
+}
+
+func fetchJSON(ctx context.Context, registry target.Target, ref string, desc ocispec.Descriptor, v interface{}) error {
+	fetcher, err := registry.Fetcher(ctx, ref)
+	if err != nil {
+		return err
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return json.NewDecoder(rc).Decode(v)
+}
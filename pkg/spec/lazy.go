@@ -0,0 +1,153 @@
+package spec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"oras.land/oras-go/pkg/target"
+)
+
+// LazyPackage is a package whose manifest and config are pulled, its layers being fetched
+// from the registry on first access, e.g. by agents inspecting many packages but loading
+// few. The layers are verified against their digests, and kept once fetched.
+type LazyPackage struct {
+	ref      string
+	registry target.Target
+	info     *v1.EbpfPackageInfo
+	// transforms applied by Package
+	transforms []Transform
+
+	lock   sync.Mutex
+	layers map[string][]byte
+}
+
+// PullLazy pulls the manifest and config of the package of the ref, selecting the one of
+// the Variant of the options from multi-variant images, and verifying its signature with
+// their Verifier, if any. The Transforms of the options are applied by Package.
+func PullLazy(ctx context.Context, ref string, registry target.Target, opts ClientOptions) (*LazyPackage, error) {
+	ref, err := normalizeRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	client := &ebpfOCIClient{selector: opts.Variant, verifier: opts.Verifier}
+	info, err := client.Inspect(ctx, ref, registry)
+	if err != nil {
+		return nil, err
+	}
+	if client.verifier != nil {
+		rootDesc, variantDesc := info.Manifest, (*ocispec.Descriptor)(nil)
+		if info.Index != nil {
+			rootDesc, variantDesc = *info.Index, &info.Manifest
+		}
+		if err := client.verify(ctx, registry, ref, rootDesc, variantDesc); err != nil {
+			return nil, err
+		}
+	}
+	return &LazyPackage{
+		ref:        ref,
+		registry:   registry,
+		info:       info,
+		transforms: opts.Transforms,
+		layers:     map[string][]byte{},
+	}, nil
+}
+
+// Info returns the manifest and config of the package, which are already pulled.
+func (p *LazyPackage) Info() *v1.EbpfPackageInfo {
+	return p.info
+}
+
+// Program returns the main program of the package, fetching its layer if not fetched yet.
+// Transforms are not applied.
+func (p *LazyPackage) Program(ctx context.Context) ([]byte, error) {
+	desc, ok := programLayer(p.manifest())
+	if !ok {
+		return nil, fmt.Errorf("%s has no program layer", p.ref)
+	}
+	return p.layer(ctx, desc)
+}
+
+// BTF returns the BTF of the package, fetching its layer if not fetched yet, nil if the
+// package has none.
+func (p *LazyPackage) BTF(ctx context.Context) ([]byte, error) {
+	for _, layer := range p.info.Layers {
+		if subtype, _ := mediaTypeSubtype(layer.MediaType); subtype == SubtypeBTF {
+			return p.layer(ctx, layer)
+		}
+	}
+	return nil, nil
+}
+
+// Fixtures returns the fixtures of the package, fetching their layer if not fetched yet, nil
+// if the package has none.
+func (p *LazyPackage) Fixtures(ctx context.Context) ([]v1.Fixture, error) {
+	for _, layer := range p.info.Layers {
+		if subtype, _ := mediaTypeSubtype(layer.MediaType); subtype == SubtypeFixtures {
+			byt, err := p.layer(ctx, layer)
+			if err != nil {
+				return nil, err
+			}
+			return decodeFixtures(byt)
+		}
+	}
+	return nil, nil
+}
+
+// Package returns the package as Pull does, fetching the layers not fetched yet and applying
+// the transforms.
+func (p *LazyPackage) Package(ctx context.Context) (*v1.EbpfPackage, error) {
+	pkg, err := buildPackage(p.info.Manifest, p.manifest(), p.info.EbpfConfig, func(layer ocispec.Descriptor) ([]byte, error) {
+		return p.layer(ctx, layer)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := applyTransforms(ctx, p.ref, pkg, p.transforms); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// Fetched returns the number of layers fetched so far.
+func (p *LazyPackage) Fetched() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.layers)
+}
+
+func (p *LazyPackage) manifest() ocispec.Manifest {
+	return ocispec.Manifest{Config: p.info.Config, Layers: p.info.Layers, Annotations: p.info.Annotations}
+}
+
+// layer returns the content of the layer, fetched once. The lock is held while fetching, so
+// concurrent accesses fetch it once.
+func (p *LazyPackage) layer(ctx context.Context, desc ocispec.Descriptor) ([]byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if byt, ok := p.layers[desc.Digest.String()]; ok {
+		return byt, nil
+	}
+	fetcher, err := p.registry.Fetcher(ctx, p.ref)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch layer %s of %s: %w", desc.Digest, p.ref, err)
+	}
+	defer rc.Close()
+	byt, err := ioutil.ReadAll(io.LimitReader(rc, desc.Size+1))
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch layer %s of %s: %w", desc.Digest, p.ref, err)
+	}
+	if int64(len(byt)) != desc.Size || desc.Digest.Validate() != nil || desc.Digest.Algorithm().FromBytes(byt) != desc.Digest {
+		return nil, fmt.Errorf("layer %s of %s does not match its digest", desc.Digest, p.ref)
+	}
+	p.layers[desc.Digest.String()] = byt
+	return byt, nil
+}
@@ -0,0 +1,53 @@
+package spec
+
+import (
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// foreignConfigMediaTypes are the media types of the configs of the packages pushed following
+// the v0.0.0 spec with other tools than bee, e.g. `oras push` of the program alone. The v0.0.0
+// config has no content, so these packages are read with an empty config.
+var foreignConfigMediaTypes = []string{
+	"application/vnd.unknown.config.v1+json",
+	ocispec.MediaTypeImageConfig,
+}
+
+// isPackageManifest returns whether the manifest is the one of a package: its config is the
+// config of a package, or it is a foreign config next to a program layer.
+func isPackageManifest(manifest ocispec.Manifest) bool {
+	if subtype, _ := mediaTypeSubtype(manifest.Config.MediaType); subtype == SubtypeConfig {
+		return true
+	}
+	if _, ok := programLayer(manifest); !ok {
+		return false
+	}
+	for _, mediaType := range foreignConfigMediaTypes {
+		if manifest.Config.MediaType == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// programLayer returns the layer of the main program of the manifest: the one titled
+// program.o, or the first program layer of the packages pushed without titles, which the
+// v0.0.0 spec did not require.
+func programLayer(manifest ocispec.Manifest) (ocispec.Descriptor, bool) {
+	var untitled *ocispec.Descriptor
+	for i, layer := range manifest.Layers {
+		if subtype, _ := mediaTypeSubtype(layer.MediaType); subtype != SubtypeProgram {
+			continue
+		}
+		title, ok := layer.Annotations[ocispec.AnnotationTitle]
+		if title == ebpfFileName {
+			return layer, true
+		}
+		if !ok && untitled == nil {
+			untitled = &manifest.Layers[i]
+		}
+	}
+	if untitled == nil {
+		return ocispec.Descriptor{}, false
+	}
+	return *untitled, true
+}
@@ -0,0 +1,478 @@
+package spec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/target"
+)
+
+// ErrNotStored is returned by offline pulls of the packages missing from the local store.
+var ErrNotStored = errors.New("the package is not in the local store")
+
+// LocalRegistry is an EbpfOCICLient backed by the OCI image layout of the local store.
+// Its pulls are served from the store when it has the ref, or a package of the same digest,
+// and otherwise copied into the store from the registry target, and its pushes are stored
+// before being copied to the registry target. The target may be nil, in which case the
+// remote registry of the ref is used by pulls, and pushes are only stored.
+type LocalRegistry struct {
+	dir    string
+	auth   content.RegistryOptions
+	client EbpfOCICLient
+
+	// Offline pulls only read the store, failing with ErrNotStored rather than contacting
+	// the registry
+	Offline bool
+	// Variant pulled from multi-variant images, the one of the host by default
+	Variant VariantSelector
+	// Verifier of the signatures of pulled packages, which are stored with the packages
+	// when fetched. Packages are not verified if nil.
+	Verifier *Verifier
+	// Progress and concurrency of the transfers between the store and registries, the reads
+	// and writes of the store itself are not reported
+	Transfer TransferOptions
+	// Rules the short refs of its methods are expanded with, refs being used as is if nil
+	Refs *RefRules
+	// Transforms applied to the packages returned by Pull and Peek, the packages of the
+	// store being left as is
+	Transforms []Transform
+
+	// status of the pull started with StartFetch, if any
+	status *PullStatus
+}
+
+// NewLocalRegistry returns a registry backed by the store of the given directory, defaults
+// to EbpfImageDir, which pulls from remote registries with the given options.
+func NewLocalRegistry(localStorageDir string, auth content.RegistryOptions) *LocalRegistry {
+	if localStorageDir == "" {
+		localStorageDir = EbpfImageDir
+	}
+	return &LocalRegistry{
+		dir:  localStorageDir,
+		auth: auth,
+	}
+}
+
+// ociClient returns the client reading and writing the packages of the store.
+func (l *LocalRegistry) ociClient() EbpfOCICLient {
+	if l.client != nil {
+		return l.client
+	}
+	return NewEbpfOCICLientWith(ClientOptions{Variant: l.Variant, Verifier: l.Verifier, Auth: l.auth})
+}
+
+func (l *LocalRegistry) Push(ctx context.Context, ref string, registry target.Target, pkg *v1.EbpfPackage) error {
+	ref, err := l.pushRef(ref)
+	if err != nil {
+		return err
+	}
+	store, err := content.NewOCI(l.dir)
+	if err != nil {
+		return err
+	}
+	if err := l.ociClient().Push(ctx, ref, store, pkg); err != nil {
+		return err
+	}
+	return l.copyTo(ctx, store, ref, registry)
+}
+
+func (l *LocalRegistry) PushMultiArch(ctx context.Context, ref string, registry target.Target, pkgs []*v1.EbpfPackage) error {
+	ref, err := l.pushRef(ref)
+	if err != nil {
+		return err
+	}
+	store, err := content.NewOCI(l.dir)
+	if err != nil {
+		return err
+	}
+	if err := l.ociClient().PushMultiArch(ctx, ref, store, pkgs); err != nil {
+		return err
+	}
+	return l.copyTo(ctx, store, ref, registry)
+}
+
+func (l *LocalRegistry) PushVariants(ctx context.Context, ref string, registry target.Target, variants []v1.EbpfPackageVariant) error {
+	ref, err := l.pushRef(ref)
+	if err != nil {
+		return err
+	}
+	store, err := content.NewOCI(l.dir)
+	if err != nil {
+		return err
+	}
+	if err := l.ociClient().PushVariants(ctx, ref, store, variants); err != nil {
+		return err
+	}
+	return l.copyTo(ctx, store, ref, registry)
+}
+
+// pushRef returns the full ref of a push, failing with the naming rule its repository breaks
+// before anything is stored or pushed.
+func (l *LocalRegistry) pushRef(ref string) (string, error) {
+	ref, err := l.Refs.ExpandRef(ref)
+	if err != nil {
+		return "", err
+	}
+	if err := l.Refs.CheckRepository(ref); err != nil {
+		return "", err
+	}
+	return ref, nil
+}
+
+func (l *LocalRegistry) copyTo(ctx context.Context, store *content.OCI, ref string, registry target.Target) error {
+	if registry == nil {
+		return nil
+	}
+	_, err := Copy(ctx, store, ref, registry, l.Transfer)
+	return pushError(ref, err)
+}
+
+// Pull returns the package of the ref from the store, fetching it first if the store does
+// not have it.
+func (l *LocalRegistry) Pull(ctx context.Context, ref string, registry target.Target) (*v1.EbpfPackage, error) {
+	ref, err := l.Refs.ExpandRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	store, err := content.NewOCI(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := store.Resolve(ctx, ref); err == nil {
+		// If we find the image locally, return it
+		prog, err := l.ociClient().Pull(ctx, ref, store)
+		if err == nil {
+			return l.transform(ctx, ref, prog)
+		}
+		var verificationErr *VerificationError
+		if l.Offline && errors.As(err, &verificationErr) {
+			return nil, err
+		}
+	}
+	if _, err := l.fetch(ctx, store, ref, registry); err != nil {
+		return nil, err
+	}
+	prog, err := l.ociClient().Pull(ctx, ref, store)
+	if err != nil {
+		return nil, err
+	}
+	return l.transform(ctx, ref, prog)
+}
+
+// Peek returns the package of the ref as Pull does, but without storing it: the package is
+// read from the store if it has the ref, and otherwise from the registry target, the remote
+// registry of the ref if nil.
+func (l *LocalRegistry) Peek(ctx context.Context, ref string, registry target.Target) (*v1.EbpfPackage, error) {
+	ref, err := l.Refs.ExpandRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	store, err := content.NewOCI(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := store.Resolve(ctx, ref); err == nil {
+		pkg, err := l.ociClient().Pull(ctx, ref, store)
+		if err != nil {
+			return nil, err
+		}
+		return l.transform(ctx, ref, pkg)
+	}
+	if l.Offline {
+		return nil, fmt.Errorf("%s: %w", ref, ErrNotStored)
+	}
+	if registry == nil {
+		remoteRegistry, err := content.NewRegistry(l.auth)
+		if err != nil {
+			return nil, err
+		}
+		registry = remoteRegistry
+	}
+	pkg, err := l.ociClient().Pull(ctx, ref, registry)
+	if err != nil {
+		return nil, l.registryError(ctx, ref, registry, err)
+	}
+	return l.transform(ctx, ref, pkg)
+}
+
+// PullLazy returns the package of the ref as Peek does, but only pulling its manifest and
+// config, its layers being fetched on first access through the LazyPackage.
+func (l *LocalRegistry) PullLazy(ctx context.Context, ref string, registry target.Target) (*LazyPackage, error) {
+	ref, err := l.Refs.ExpandRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	opts := ClientOptions{Variant: l.Variant, Verifier: l.Verifier, Auth: l.auth, Transforms: l.Transforms}
+	store, err := content.NewOCI(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := store.Resolve(ctx, ref); err == nil {
+		return PullLazy(ctx, ref, store, opts)
+	}
+	if l.Offline {
+		return nil, fmt.Errorf("%s: %w", ref, ErrNotStored)
+	}
+	if registry == nil {
+		remoteRegistry, err := content.NewRegistry(l.auth)
+		if err != nil {
+			return nil, err
+		}
+		registry = remoteRegistry
+	}
+	pkg, err := PullLazy(ctx, ref, registry, opts)
+	if err != nil {
+		return nil, l.registryError(ctx, ref, registry, err)
+	}
+	return pkg, nil
+}
+
+// transform applies the Transforms of the registry to the package of a pull.
+func (l *LocalRegistry) transform(ctx context.Context, ref string, pkg *v1.EbpfPackage) (*v1.EbpfPackage, error) {
+	if err := applyTransforms(ctx, ref, pkg, l.Transforms); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// Ping checks the registry of the ref is reachable, answering the base of its distribution
+// API, challenges included, so its credentials are not checked. Offline registries are
+// always reachable.
+func (l *LocalRegistry) Ping(ctx context.Context, ref string) error {
+	if l.Offline {
+		return nil
+	}
+	ref, err := l.Refs.ExpandRef(ref)
+	if err != nil {
+		return err
+	}
+	api, err := newRegistryAPI(ref, l.auth)
+	if err != nil {
+		return err
+	}
+	resp, err := api.send(ctx, api.scheme+"://"+api.host+"/v2/", "")
+	if err != nil {
+		return fmt.Errorf("could not reach the registry %s: %w", api.host, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("the registry %s is unavailable: %s", api.host, resp.Status)
+	}
+	return nil
+}
+
+// List returns the tags of the repository in the registry target, the remote registry of the
+// repository if nil. Offline, the tags of the store are listed instead.
+func (l *LocalRegistry) List(ctx context.Context, repoRef string, registry target.Target) ([]string, error) {
+	repoRef, err := l.Refs.ExpandRef(repoRef)
+	if err != nil {
+		return nil, err
+	}
+	if l.Offline {
+		store, err := content.NewOCI(l.dir)
+		if err != nil {
+			return nil, err
+		}
+		registry = store
+	}
+	return l.ociClient().List(ctx, repoRef, registry)
+}
+
+// Inspect returns the manifest and config of the package of the ref from the store if it has
+// it, and otherwise from the registry target, without storing the package.
+func (l *LocalRegistry) Inspect(ctx context.Context, ref string, registry target.Target) (*v1.EbpfPackageInfo, error) {
+	ref, err := l.Refs.ExpandRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	store, err := content.NewOCI(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := store.Resolve(ctx, ref); err == nil {
+		return l.ociClient().Inspect(ctx, ref, store)
+	}
+	if l.Offline {
+		return nil, fmt.Errorf("%s: %w", ref, ErrNotStored)
+	}
+	if registry == nil {
+		remoteRegistry, err := content.NewRegistry(l.auth)
+		if err != nil {
+			return nil, err
+		}
+		registry = remoteRegistry
+	}
+	info, err := l.ociClient().Inspect(ctx, ref, registry)
+	if err != nil {
+		return nil, l.registryError(ctx, ref, registry, err)
+	}
+	return info, nil
+}
+
+// PullVariants returns all the packages of the ref from the store, fetching it first if the
+// store does not have it.
+func (l *LocalRegistry) PullVariants(ctx context.Context, ref string, registry target.Target) ([]v1.EbpfPackageVariant, error) {
+	ref, err := l.Refs.ExpandRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	store, err := content.NewOCI(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := store.Resolve(ctx, ref); err != nil {
+		if _, err := l.fetch(ctx, store, ref, registry); err != nil {
+			return nil, err
+		}
+	}
+	return PullVariants(ctx, ref, store)
+}
+
+// Fetch stores the package of the ref, even if the store already has the ref, so tags are
+// updated. The blobs are only copied if the store does not have a package of the digest
+// the ref resolves to.
+func (l *LocalRegistry) Fetch(ctx context.Context, ref string, registry target.Target) (ocispec.Descriptor, error) {
+	ref, err := l.Refs.ExpandRef(ref)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	store, err := content.NewOCI(l.dir)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return l.fetch(ctx, store, ref, registry)
+}
+
+// StartFetch fetches the package of the ref as Fetch does, in the background, returning the
+// status of the pull to poll, subscribe to or wait for. The size of the package is resolved
+// from its descriptors before its blobs are transferred, so the status has an ETA.
+func (l *LocalRegistry) StartFetch(ctx context.Context, ref string, registry target.Target) *PullStatus {
+	status := newPullStatus(ref)
+	fetcher := *l
+	fetcher.status = status
+	progress := l.Transfer.Progress
+	fetcher.Transfer.Progress = func(p Progress) {
+		status.update(p)
+		if progress != nil {
+			progress(p)
+		}
+	}
+	go func() {
+		desc, err := fetcher.Fetch(ctx, ref, registry)
+		status.finish(desc, err)
+	}()
+	return status
+}
+
+func (l *LocalRegistry) fetch(ctx context.Context, store *content.OCI, ref string, registry target.Target) (ocispec.Descriptor, error) {
+	if dgst, ok := refDigest(ref); ok {
+		// a digest ref can't change, so it never needs the registry if the store has it
+		if desc, ok := l.storedDigest(ctx, store, dgst); ok {
+			return desc, addReference(store, ref, desc)
+		}
+	}
+	if l.Offline {
+		return ocispec.Descriptor{}, fmt.Errorf("%s: %w", ref, ErrNotStored)
+	}
+
+	if registry == nil {
+		remoteRegistry, err := content.NewRegistry(l.auth)
+		if err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		registry = remoteRegistry
+	}
+	_, desc, err := registry.Resolve(ctx, ref)
+	if err != nil {
+		return ocispec.Descriptor{}, l.registryError(ctx, ref, registry, err)
+	}
+	if l.Verifier != nil {
+		l.storeSignatures(ctx, ref, desc, registry, store)
+	}
+	if stored, ok := l.storedDigest(ctx, store, desc.Digest); ok {
+		return stored, addReference(store, ref, stored)
+	}
+
+	if l.status != nil {
+		total, err := pullSize(ctx, registry, ref, desc)
+		if err != nil {
+			return ocispec.Descriptor{}, l.registryError(ctx, ref, registry, err)
+		}
+		l.status.resolve(total)
+	}
+	desc, err = Copy(ctx, registry, ref, store, l.Transfer)
+	if err != nil {
+		return ocispec.Descriptor{}, l.registryError(ctx, ref, registry, err)
+	}
+	return desc, nil
+}
+
+// registryError classifies the errors of the registry target with ClassifyRegistryError,
+// unless it is a store.
+func (l *LocalRegistry) registryError(ctx context.Context, ref string, registry target.Target, err error) error {
+	if _, ok := registry.(*content.OCI); ok {
+		return err
+	}
+	return ClassifyRegistryError(ctx, ref, l.auth, err)
+}
+
+// storeSignatures stores the signature of the image, and of the selected package of an image
+// index, so the package can be verified offline. Unsigned packages are left to fail
+// verification when pulled from the store.
+func (l *LocalRegistry) storeSignatures(ctx context.Context, ref string, desc ocispec.Descriptor, registry, store target.Target) {
+	_ = StoreSignature(ctx, ref, desc.Digest, registry, store)
+	if desc.MediaType != ocispec.MediaTypeImageIndex {
+		return
+	}
+	if variantDesc, err := selectVariant(ctx, registry, ref, desc, l.Variant); err == nil {
+		_ = StoreSignature(ctx, ref, variantDesc.Digest, registry, store)
+	}
+}
+
+// refDigest returns the digest of a ref of the form repo@sha256:...
+func refDigest(ref string) (digest.Digest, bool) {
+	i := strings.LastIndex(ref, "@")
+	if i < 0 {
+		return "", false
+	}
+	dgst, err := digest.Parse(ref[i+1:])
+	return dgst, err == nil
+}
+
+// storedDigest returns the descriptor of a package of the store with the given digest.
+// The refs of the store are looked up, and as a ref is added before the blobs of its
+// package are copied, the package must have all its blobs.
+func (l *LocalRegistry) storedDigest(ctx context.Context, store *content.OCI, dgst digest.Digest) (ocispec.Descriptor, bool) {
+	for _, desc := range store.ListReferences() {
+		if desc.Digest != dgst {
+			continue
+		}
+		blobs := map[digest.Digest]int64{}
+		if err := collectBlobs(l.dir, desc, blobs); err != nil {
+			continue
+		}
+		complete := true
+		for blob := range blobs {
+			if _, err := store.Info(ctx, blob); err != nil {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			return withoutRefName(desc), true
+		}
+	}
+	return ocispec.Descriptor{}, false
+}
+
+func addReference(store *content.OCI, ref string, desc ocispec.Descriptor) error {
+	store.AddReference(ref, desc)
+	return store.SaveIndex()
+}
@@ -0,0 +1,230 @@
+package spec
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/target"
+)
+
+// MaintenanceOptions are what MaintainRepository does to each tag of a repository.
+type MaintenanceOptions struct {
+	// Verifier of the current signatures of the tags, the tags it rejects being reported and
+	// left as they are, if set
+	Verifier *Verifier
+	// Key the tags are signed with again, e.g. the new key of a key rotation, replacing their
+	// cosign signature, if set
+	Key crypto.Signer
+	// Annotations set on the manifest of each tag, or removed if empty
+	Annotations map[string]string
+	// Normalize trims the values of the annotations of the manifests, dropping empty ones
+	Normalize bool
+	// DryRun reports what would be done, without changing the repository
+	DryRun bool
+	// Listing the tags of remote repositories uses the credentials of Auth, ignored by local
+	// stores
+	Auth content.RegistryOptions
+}
+
+// MaintenanceReport reports what MaintainRepository did to the tags of a repository.
+type MaintenanceReport struct {
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished"`
+	Tags     []MaintainedTag `json:"tags"`
+	DryRun   bool            `json:"dryRun,omitempty"`
+
+	Verified  int `json:"verified"`
+	Annotated int `json:"annotated"`
+	Signed    int `json:"signed"`
+	Failed    int `json:"failed"`
+}
+
+// MaintainedTag reports what MaintainRepository did to a tag.
+type MaintainedTag struct {
+	Ref    string        `json:"ref"`
+	Digest digest.Digest `json:"digest,omitempty"`
+	// Digest of the manifest once annotated, which annotating changes
+	Annotated digest.Digest `json:"annotated,omitempty"`
+	Verified  bool          `json:"verified,omitempty"`
+	Signed    bool          `json:"signed,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Summary returns the counts of the report on a line.
+func (r *MaintenanceReport) Summary() string {
+	summary := fmt.Sprintf("%d tags: %d verified, %d annotated, %d signed, %d failed", len(r.Tags), r.Verified, r.Annotated, r.Signed, r.Failed)
+	if r.DryRun {
+		summary += " (dry run)"
+	}
+	return summary
+}
+
+// MaintainRepository walks the tags of a repository, e.g. for a periodic key rotation:
+// it verifies the signatures of each tag with the verifier of the options, fixes its
+// annotations and signs it again with their key. The tags failing verification are left
+// as they are. As annotating a manifest changes its digest, the tags annotated without a key
+// would lose their signature, so they are reported as failed and left as they are instead.
+// Only listing the tags fails the maintenance, the failures of each tag are reported.
+func MaintainRepository(ctx context.Context, repo string, registry target.Target, opts MaintenanceOptions) (*MaintenanceReport, error) {
+	report := &MaintenanceReport{Started: time.Now(), DryRun: opts.DryRun}
+	tags, err := NewEbpfOCICLientWith(ClientOptions{Auth: opts.Auth}).List(ctx, repo, registry)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the tags of %s: %w", repo, err)
+	}
+	repo, err = normalizeRef(repo)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		ref := repo + ":" + tag
+		if IsSignatureRef(ref) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		maintained := maintainTag(ctx, ref, registry, opts)
+		switch {
+		case maintained.Error != "":
+			report.Failed++
+		case maintained.Verified:
+			report.Verified++
+		}
+		if maintained.Annotated != "" {
+			report.Annotated++
+		}
+		if maintained.Signed {
+			report.Signed++
+		}
+		report.Tags = append(report.Tags, maintained)
+	}
+	report.Finished = time.Now()
+	return report, nil
+}
+
+func maintainTag(ctx context.Context, ref string, registry target.Target, opts MaintenanceOptions) MaintainedTag {
+	maintained := MaintainedTag{Ref: ref}
+	fail := func(err error) MaintainedTag {
+		maintained.Error = err.Error()
+		return maintained
+	}
+	_, desc, err := registry.Resolve(ctx, ref)
+	if err != nil {
+		return fail(err)
+	}
+	maintained.Digest = desc.Digest
+	if opts.Verifier != nil {
+		if err := opts.Verifier.Verify(ctx, registry, ref, desc.Digest); err != nil {
+			return fail(err)
+		}
+		maintained.Verified = true
+	}
+
+	rootBytes, changed, err := annotateManifest(ctx, registry, ref, desc, opts)
+	if err != nil {
+		return fail(err)
+	}
+	if changed {
+		if opts.Key == nil {
+			if _, _, err := registry.Resolve(ctx, SignatureRef(ref, desc.Digest)); err == nil {
+				return fail(fmt.Errorf("annotating %s would lose its signature, as its digest changes: sign it again with a key", ref))
+			}
+		}
+		newDesc := ocispec.Descriptor{
+			MediaType: desc.MediaType,
+			Digest:    digest.FromBytes(rootBytes),
+			Size:      int64(len(rootBytes)),
+		}
+		if !opts.DryRun {
+			memoryStore := content.NewMemory()
+			if err := memoryStore.StoreManifest(ref, newDesc, rootBytes); err != nil {
+				return fail(err)
+			}
+			// the blobs are already in the registry
+			if _, err := Copy(ctx, &fallbackTarget{Target: memoryStore, fallback: registry}, ref, registry, TransferOptions{}); err != nil {
+				return fail(fmt.Errorf("could not annotate %s: %w", ref, err))
+			}
+		}
+		maintained.Annotated = newDesc.Digest
+	}
+
+	if opts.Key != nil {
+		if !opts.DryRun {
+			if err := Sign(ctx, ref, registry, opts.Key); err != nil {
+				return fail(fmt.Errorf("could not sign %s: %w", ref, err))
+			}
+		}
+		maintained.Signed = true
+	}
+	return maintained
+}
+
+// annotateManifest returns the manifest, or image index, of the descriptor with the
+// annotations of the options, and whether they changed it.
+func annotateManifest(ctx context.Context, registry target.Target, ref string, desc ocispec.Descriptor, opts MaintenanceOptions) ([]byte, bool, error) {
+	if len(opts.Annotations) == 0 && !opts.Normalize {
+		return nil, false, nil
+	}
+	var root interface{}
+	var annotations *map[string]string
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex:
+		var index ocispec.Index
+		root, annotations = &index, &index.Annotations
+	case ocispec.MediaTypeImageManifest:
+		var manifest ocispec.Manifest
+		root, annotations = &manifest, &manifest.Annotations
+	default:
+		return nil, false, fmt.Errorf("%s is a %s, not an image", ref, desc.MediaType)
+	}
+	if err := fetchJSON(ctx, registry, ref, desc, root); err != nil {
+		return nil, false, err
+	}
+	updated := map[string]string{}
+	for k, v := range *annotations {
+		if opts.Normalize {
+			if v = strings.TrimSpace(v); v == "" {
+				continue
+			}
+		}
+		updated[k] = v
+	}
+	for k, v := range opts.Annotations {
+		if v == "" {
+			delete(updated, k)
+		} else {
+			updated[k] = v
+		}
+	}
+	if equalAnnotations(*annotations, updated) {
+		return nil, false, nil
+	}
+	if len(updated) == 0 {
+		updated = nil
+	}
+	*annotations = updated
+	rootBytes, err := json.Marshal(root)
+	if err != nil {
+		return nil, false, err
+	}
+	return rootBytes, true, nil
+}
+
+func equalAnnotations(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if other, ok := b[k]; !ok || other != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package spec
+
+import "mime"
+
+// MediaTypeV2 is the media type of the config and layers of v2 packages, whose subtype
+// parameter tells them apart and arch parameter records the architecture of the programs,
+// e.g. application/ebpf.solo.io.v2; arch=amd64; subtype=program. The v1 media types, one per
+// layer, are still read, and written with ClientOptions.LegacyMediaTypes.
+const MediaTypeV2 = "application/ebpf.solo.io.v2"
+
+// The subtypes of the blobs of packages.
+const (
+	SubtypeConfig  = "config"
+	SubtypeProgram = "program"
+	SubtypeObject  = "object"
+	SubtypeBTF     = "btf"
+	SubtypeSource  = "source"
+	// Sample events of the maps of the programs, with the entries they decode to
+	SubtypeFixtures = "fixtures"
+)
+
+// v1MediaTypes are the deprecated media types of the subtypes, written by older bee releases.
+var v1MediaTypes = map[string]string{
+	SubtypeConfig:   configMediaType,
+	SubtypeProgram:  eBPFMediaType,
+	SubtypeObject:   objectMediaType,
+	SubtypeBTF:      btfMediaType,
+	SubtypeSource:   sourceMediaType,
+	SubtypeFixtures: fixturesMediaType,
+}
+
+// mediaTypeArchs are the architectures of v2 media types, the GOARCHs, as media types are
+// matched exactly when pulled.
+var mediaTypeArchs = []string{
+	"386", "amd64", "arm", "arm64", "loong64", "mips", "mipsle", "mips64", "mips64le",
+	"ppc64", "ppc64le", "riscv64", "s390x",
+}
+
+// unameArchs are the GOARCHs of the machines of uname -m, recorded by bee build.
+var unameArchs = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"i386":    "386",
+	"i686":    "386",
+	"armv7l":  "arm",
+}
+
+// archOf returns the architecture of the media types of the blobs of the platform, none if
+// unknown.
+func archOf(arch string) string {
+	if goarch, ok := unameArchs[arch]; ok {
+		arch = goarch
+	}
+	for _, a := range mediaTypeArchs {
+		if a == arch {
+			return arch
+		}
+	}
+	return ""
+}
+
+// layerMediaType returns the media type of the blob of the subtype: the v1 one if legacy, or
+// the v2 one, only the programs, objects and BTF of which have an architecture.
+func layerMediaType(subtype, arch string, legacy bool) string {
+	if legacy {
+		return v1MediaTypes[subtype]
+	}
+	params := map[string]string{"subtype": subtype}
+	if arch != "" && subtype != SubtypeConfig && subtype != SubtypeSource && subtype != SubtypeFixtures {
+		params["arch"] = arch
+	}
+	return mime.FormatMediaType(MediaTypeV2, params)
+}
+
+// mediaTypeSubtype returns the subtype of the media type of a blob of a package, whether v1 or
+// v2, or false if it is not one.
+func mediaTypeSubtype(mediaType string) (string, bool) {
+	for subtype, v1 := range v1MediaTypes {
+		if mediaType == v1 {
+			return subtype, true
+		}
+	}
+	base, params, err := mime.ParseMediaType(mediaType)
+	if err != nil || base != MediaTypeV2 {
+		return "", false
+	}
+	subtype := params["subtype"]
+	_, ok := v1MediaTypes[subtype]
+	return subtype, ok
+}
+
+// isLegacyMediaType reports whether the media type is one of the deprecated v1 ones.
+func isLegacyMediaType(mediaType string) bool {
+	for _, v1 := range v1MediaTypes {
+		if mediaType == v1 {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedMediaTypes returns the v1 media types and the v2 ones of every subtype and arch.
+func allowedMediaTypes() []string {
+	types := []string{eBPFMediaType, configMediaType, objectMediaType, btfMediaType, sourceMediaType, fixturesMediaType}
+	for _, subtype := range []string{SubtypeConfig, SubtypeProgram, SubtypeObject, SubtypeBTF, SubtypeSource, SubtypeFixtures} {
+		types = append(types, layerMediaType(subtype, "", false))
+		if subtype == SubtypeConfig || subtype == SubtypeSource || subtype == SubtypeFixtures {
+			continue
+		}
+		for _, arch := range mediaTypeArchs {
+			types = append(types, layerMediaType(subtype, arch, false))
+		}
+	}
+	return types
+}
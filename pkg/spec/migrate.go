@@ -0,0 +1,112 @@
+package spec
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/target"
+)
+
+// MigrateMediaTypes rewrites the manifest of the image of the ref, and of each package of its
+// image index, from the deprecated v1 media types to MediaTypeV2, and tags the ref with it.
+// Only the manifests are rewritten: their config and layers keep their digests, so they are not
+// pushed again, but the digests of the manifests change, so their signatures must be made again.
+// It returns the descriptor of the image, and whether it was rewritten, images without v1
+// media types being left as they are.
+func MigrateMediaTypes(ctx context.Context, ref string, registry target.Target) (ocispec.Descriptor, bool, error) {
+	_, rootDesc, err := registry.Resolve(ctx, ref)
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+	memoryStore := content.NewMemory()
+
+	var rootBytes []byte
+	migrated := false
+	switch rootDesc.MediaType {
+	case ocispec.MediaTypeImageIndex:
+		index, err := fetchIndex(ctx, registry, ref, rootDesc)
+		if err != nil {
+			return ocispec.Descriptor{}, false, err
+		}
+		for i, desc := range index.Manifests {
+			if desc.MediaType != ocispec.MediaTypeImageManifest {
+				continue
+			}
+			arch := ""
+			if desc.Platform != nil {
+				arch = archOf(desc.Platform.Architecture)
+			}
+			manifestBytes, changed, err := migrateManifest(ctx, registry, ref, desc, arch)
+			if err != nil {
+				return ocispec.Descriptor{}, false, err
+			}
+			if !changed {
+				continue
+			}
+			migrated = true
+			desc.Digest = digest.FromBytes(manifestBytes)
+			desc.Size = int64(len(manifestBytes))
+			memoryStore.Set(desc, manifestBytes)
+			index.Manifests[i] = desc
+		}
+		if !migrated {
+			return rootDesc, false, nil
+		}
+		if rootBytes, err = json.Marshal(index); err != nil {
+			return ocispec.Descriptor{}, false, err
+		}
+	case ocispec.MediaTypeImageManifest:
+		if rootBytes, migrated, err = migrateManifest(ctx, registry, ref, rootDesc, ""); err != nil || !migrated {
+			return rootDesc, false, err
+		}
+	default:
+		return rootDesc, false, nil
+	}
+
+	newDesc := ocispec.Descriptor{
+		MediaType: rootDesc.MediaType,
+		Digest:    digest.FromBytes(rootBytes),
+		Size:      int64(len(rootBytes)),
+	}
+	if err := memoryStore.StoreManifest(ref, newDesc, rootBytes); err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+	// the blobs are already in the registry
+	if _, err := Copy(ctx, &fallbackTarget{Target: memoryStore, fallback: registry}, ref, registry, TransferOptions{}); err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+	return newDesc, true, nil
+}
+
+// migrateManifest returns the manifest of the descriptor with the v2 media types of the
+// architecture, and whether it had v1 media types. The blobs of other media types are kept.
+func migrateManifest(ctx context.Context, registry target.Target, ref string, desc ocispec.Descriptor, arch string) ([]byte, bool, error) {
+	var manifest ocispec.Manifest
+	if err := fetchJSON(ctx, registry, ref, desc, &manifest); err != nil {
+		return nil, false, err
+	}
+	changed := false
+	migrate := func(blob *ocispec.Descriptor) {
+		if !isLegacyMediaType(blob.MediaType) {
+			return
+		}
+		subtype, _ := mediaTypeSubtype(blob.MediaType)
+		blob.MediaType = layerMediaType(subtype, arch, false)
+		changed = true
+	}
+	migrate(&manifest.Config)
+	for i := range manifest.Layers {
+		migrate(&manifest.Layers[i])
+	}
+	if !changed {
+		return nil, false, nil
+	}
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, false, err
+	}
+	return manifestBytes, true, nil
+}
@@ -0,0 +1,364 @@
+package spec
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/oras"
+	"oras.land/oras-go/pkg/target"
+)
+
+// MirrorPrune is the policy of the mirrored tags whose source tag was removed.
+type MirrorPrune string
+
+const (
+	// PruneNone keeps the tags removed from the source
+	PruneNone MirrorPrune = "none"
+	// PruneRemoved removes the tags removed from the source, along with their signatures
+	PruneRemoved MirrorPrune = "removed"
+)
+
+// MirrorAction is what a sync did to a mirrored image.
+type MirrorAction string
+
+const (
+	MirrorCopied    MirrorAction = "copied"
+	MirrorUnchanged MirrorAction = "unchanged"
+	MirrorPruned    MirrorAction = "pruned"
+	MirrorFailed    MirrorAction = "failed"
+)
+
+// Mirror replicates the images of source refs to a destination registry, e.g. for edge sites
+// to pull from a registry of their own. Each sync copies the tags whose digest changed, with
+// their cosign and notation signatures, and prunes the tags removed from the source per its
+// policy.
+type Mirror struct {
+	// Listing and pruning the tags of remote repositories use the credentials of FromAuth and
+	// ToAuth, ignored by local stores
+	FromAuth content.RegistryOptions
+	ToAuth   content.RegistryOptions
+	// Policy of the mirrored tags of patterns removed from the source, PruneNone by default
+	Prune MirrorPrune
+	// Progress and concurrency of the transfers of the copied images
+	Transfer TransferOptions
+
+	sources     []mirrorSource
+	destination string
+	from, to    target.Target
+
+	lock   sync.Mutex
+	status *MirrorStatus
+}
+
+type mirrorSource struct {
+	repo string
+	// tag, or glob pattern of the tags, e.g. v1.*
+	tag string
+}
+
+func (s mirrorSource) String() string {
+	return s.repo + ":" + s.tag
+}
+
+func (s mirrorSource) isPattern() bool {
+	return strings.ContainsAny(s.tag, "*?[")
+}
+
+// MirrorStatus reports a sync of a mirror.
+type MirrorStatus struct {
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished"`
+	Images   []MirroredImage `json:"images"`
+
+	Copied    int `json:"copied"`
+	Unchanged int `json:"unchanged"`
+	Pruned    int `json:"pruned"`
+	Failed    int `json:"failed"`
+}
+
+// MirroredImage reports what a sync did to a mirrored image.
+type MirroredImage struct {
+	// Source ref, or source pattern when its tags could not be listed
+	Source      string        `json:"source"`
+	Destination string        `json:"destination,omitempty"`
+	Digest      digest.Digest `json:"digest,omitempty"`
+	Action      MirrorAction  `json:"action"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// NewMirror returns a mirror of the images of the sources in the registry from, to the
+// destination in the registry to. Sources are refs REPO:TAG, REPO:PATTERN for the tags matching
+// a glob pattern, e.g. ghcr.io/solo-io/bumblebee/tcpconnect:v1.*, or REPO for all its tags.
+// Repositories are mirrored under the destination prefix, without their host, e.g.
+// ghcr.io/solo-io/bumblebee/tcpconnect to edge:5000/mirror/solo-io/bumblebee/tcpconnect.
+func NewMirror(sources []string, destination string, from, to target.Target) (*Mirror, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("a mirror needs source refs")
+	}
+	if destination == "" {
+		return nil, fmt.Errorf("a mirror needs a destination")
+	}
+	m := &Mirror{Prune: PruneNone, destination: strings.TrimSuffix(destination, "/"), from: from, to: to}
+	for _, source := range sources {
+		if strings.Contains(source, "@") {
+			return nil, fmt.Errorf("invalid source %s: mirrors copy tags, not digests", source)
+		}
+		repo, tag, ok := splitTag(source)
+		if !ok {
+			repo, tag = source, "*"
+		}
+		if _, err := path.Match(tag, ""); err != nil {
+			return nil, fmt.Errorf("invalid source %s: %w", source, err)
+		}
+		if _, err := reference.Parse(repo); err != nil {
+			return nil, fmt.Errorf("invalid source %s: %w", source, err)
+		}
+		m.sources = append(m.sources, mirrorSource{repo: repo, tag: tag})
+	}
+	return m, nil
+}
+
+// Status returns the status of the last sync, nil before the first one.
+func (m *Mirror) Status() *MirrorStatus {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.status
+}
+
+// Run syncs the mirror, then again every interval until the context is done, reporting the
+// status of each sync. Failed syncs are retried at the next interval.
+func (m *Mirror) Run(ctx context.Context, interval time.Duration, report func(*MirrorStatus, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status, err := m.Sync(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		report(status, err)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sync copies the images of the sources whose digest changed since the last sync, and the
+// signatures not mirrored yet, then prunes the tags removed from the sources per the policy.
+// The images failing to sync are reported in the status, and fail the sync once the others
+// are synced.
+func (m *Mirror) Sync(ctx context.Context) (*MirrorStatus, error) {
+	status := &MirrorStatus{Started: time.Now()}
+	for _, source := range m.sources {
+		m.syncSource(ctx, source, status)
+	}
+	status.Finished = time.Now()
+	for _, image := range status.Images {
+		switch image.Action {
+		case MirrorCopied:
+			status.Copied++
+		case MirrorUnchanged:
+			status.Unchanged++
+		case MirrorPruned:
+			status.Pruned++
+		case MirrorFailed:
+			status.Failed++
+		}
+	}
+
+	m.lock.Lock()
+	m.status = status
+	m.lock.Unlock()
+	if status.Failed > 0 {
+		return status, fmt.Errorf("%d of the %d images of the mirror failed to sync", status.Failed, len(status.Images))
+	}
+	return status, nil
+}
+
+func (m *Mirror) syncSource(ctx context.Context, source mirrorSource, status *MirrorStatus) {
+	destRepo, err := m.destinationRepo(source.repo)
+	if err != nil {
+		status.Images = append(status.Images, MirroredImage{Source: source.String(), Action: MirrorFailed, Error: err.Error()})
+		return
+	}
+	tags := []string{source.tag}
+	if source.isPattern() {
+		if tags, err = m.listTags(ctx, source.repo, source.tag, m.from, m.FromAuth); err != nil {
+			// without the tags of the source, none can be pruned
+			status.Images = append(status.Images, MirroredImage{Source: source.String(), Action: MirrorFailed, Error: err.Error()})
+			return
+		}
+	}
+	for _, tag := range tags {
+		image := MirroredImage{Source: source.repo + ":" + tag, Destination: destRepo + ":" + tag}
+		image.Digest, image.Action, err = m.syncImage(ctx, image.Source, image.Destination)
+		if err != nil {
+			image.Error = err.Error()
+		}
+		status.Images = append(status.Images, image)
+	}
+
+	// the tags of exact sources which can't be resolved may just be unreachable
+	if m.Prune != PruneRemoved || !source.isPattern() {
+		return
+	}
+	mirrored, err := m.listTags(ctx, destRepo, source.tag, m.to, m.ToAuth)
+	if err != nil {
+		status.Images = append(status.Images, MirroredImage{Source: source.String(), Destination: destRepo, Action: MirrorFailed, Error: err.Error()})
+		return
+	}
+	kept := map[string]bool{}
+	for _, tag := range tags {
+		kept[tag] = true
+	}
+	var removed []string
+	for _, tag := range mirrored {
+		if !kept[tag] {
+			removed = append(removed, tag)
+		}
+	}
+	status.Images = append(status.Images, m.prune(ctx, source.repo, destRepo, removed, mirrored)...)
+}
+
+// destinationRepo returns the repository the one of the source is mirrored to.
+func (m *Mirror) destinationRepo(repo string) (string, error) {
+	spec, err := reference.Parse(repo)
+	if err != nil {
+		return "", err
+	}
+	return m.destination + "/" + strings.TrimPrefix(spec.Locator, spec.Hostname()+"/"), nil
+}
+
+// listTags returns the tags of the repository matching the pattern, but the ones of signatures.
+func (m *Mirror) listTags(ctx context.Context, repo, pattern string, registry target.Target, auth content.RegistryOptions) ([]string, error) {
+	tags, err := NewEbpfOCICLientWith(ClientOptions{Auth: auth}).List(ctx, repo, registry)
+	if err != nil {
+		return nil, err
+	}
+	var matching []string
+	for _, tag := range tags {
+		if ok, _ := path.Match(pattern, tag); ok && !IsSignatureRef(repo+":"+tag) {
+			matching = append(matching, tag)
+		}
+	}
+	return matching, nil
+}
+
+// syncImage copies the image of the source ref to the destination ref if their digests differ,
+// then the signatures of the image and of the packages of image indexes not mirrored yet.
+func (m *Mirror) syncImage(ctx context.Context, sourceRef, destRef string) (digest.Digest, MirrorAction, error) {
+	_, desc, err := m.from.Resolve(ctx, sourceRef)
+	if err != nil {
+		return "", MirrorFailed, err
+	}
+	action := MirrorUnchanged
+	if _, mirrored, err := m.to.Resolve(ctx, destRef); err != nil || mirrored.Digest != desc.Digest {
+		action = MirrorCopied
+		if _, err := oras.Copy(
+			ctx,
+			m.Transfer.track(m.from),
+			sourceRef,
+			m.to,
+			destRef,
+			oras.WithAllowedMediaTypes(AllowedMediaTypes()),
+		); err != nil {
+			return desc.Digest, MirrorFailed, err
+		}
+	}
+
+	digests := []digest.Digest{desc.Digest}
+	if desc.MediaType == ocispec.MediaTypeImageIndex {
+		index, err := fetchIndex(ctx, m.from, sourceRef, desc)
+		if err != nil {
+			return desc.Digest, MirrorFailed, err
+		}
+		for _, manifest := range index.Manifests {
+			digests = append(digests, manifest.Digest)
+		}
+	}
+	for _, dgst := range digests {
+		if _, err := copySignatures(ctx, m.from, sourceRef, m.to, destRef, dgst); err != nil {
+			return desc.Digest, MirrorFailed, fmt.Errorf("could not mirror the signatures of %s: %w", dgst, err)
+		}
+	}
+	return desc.Digest, action, nil
+}
+
+// prune removes the removed tags of the destination repository, and the signatures of their
+// manifests. As registries delete manifests rather than tags, the remote manifests still
+// tagged by another of the mirrored tags are kept, and reported unchanged.
+func (m *Mirror) prune(ctx context.Context, sourceRepo, destRepo string, removed, mirrored []string) []MirroredImage {
+	if len(removed) == 0 {
+		return nil
+	}
+	isRemoved := map[string]bool{}
+	for _, tag := range removed {
+		isRemoved[tag] = true
+	}
+	keptDigests := map[digest.Digest]bool{}
+	for _, tag := range mirrored {
+		if isRemoved[tag] {
+			continue
+		}
+		if _, desc, err := m.to.Resolve(ctx, destRepo+":"+tag); err == nil {
+			keptDigests[desc.Digest] = true
+		}
+	}
+
+	sort.Strings(removed)
+	var images []MirroredImage
+	for _, tag := range removed {
+		destRef := destRepo + ":" + tag
+		image := MirroredImage{Source: sourceRepo + ":" + tag, Destination: destRef, Action: MirrorPruned}
+		_, desc, err := m.to.Resolve(ctx, destRef)
+		if err == nil {
+			image.Digest = desc.Digest
+			var untagged bool
+			if untagged, err = m.untag(ctx, destRepo, destRef, desc.Digest, keptDigests[desc.Digest]); !untagged {
+				image.Action = MirrorUnchanged
+			}
+		}
+		if err != nil {
+			image.Action, image.Error = MirrorFailed, err.Error()
+		}
+		images = append(images, image)
+	}
+	return images
+}
+
+// untag removes the tag of the destination and, unless its manifest is kept, the signatures of
+// the manifest, and returns whether it did. Remote registries delete the manifest itself,
+// untagging all its tags, so kept manifests are left tagged.
+func (m *Mirror) untag(ctx context.Context, destRepo, destRef string, dgst digest.Digest, kept bool) (bool, error) {
+	sigRefs := []string{SignatureRef(destRef, dgst), NotationRef(destRef, dgst)}
+	if store, ok := m.to.(*content.OCI); ok {
+		store.DeleteReference(destRef)
+		if !kept {
+			for _, sigRef := range sigRefs {
+				store.DeleteReference(sigRef)
+			}
+		}
+		return true, store.SaveIndex()
+	}
+	if kept {
+		return false, nil
+	}
+	for _, sigRef := range sigRefs {
+		if _, sigDesc, err := m.to.Resolve(ctx, sigRef); err == nil {
+			if err := DeleteManifest(ctx, destRepo, sigDesc.Digest, m.ToAuth); err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, DeleteManifest(ctx, destRepo, dgst, m.ToAuth)
+}
@@ -0,0 +1,300 @@
+package spec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/internal/uname"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/target"
+)
+
+// AnnotationMinKernelVersion records the oldest kernel release the package of a manifest can
+// be loaded on, on the manifest and on its descriptor in the image index.
+const AnnotationMinKernelVersion = "io.solo.bumblebee.kernel.min-version"
+
+// VariantSelector selects the package of multi-variant images, by default the one of the
+// host: the package of the architecture with the highest minimum kernel release the kernel
+// satisfies, so a build requiring a recent kernel is preferred to a fallback for older ones.
+type VariantSelector struct {
+	// Architecture of the package, defaults to the one of the host
+	Arch string
+	// Kernel release the package must be loadable on, e.g. 5.4.0-91-generic, defaults to
+	// the one of the host
+	KernelRelease string
+}
+
+func (s VariantSelector) arch() string {
+	if s.Arch != "" {
+		return s.Arch
+	}
+	return runtime.GOARCH
+}
+
+func (s VariantSelector) kernelRelease() string {
+	if s.KernelRelease != "" {
+		return s.KernelRelease
+	}
+	return uname.Release()
+}
+
+// kernelVersion is the major, minor and patch numbers of a kernel release.
+type kernelVersion [3]int
+
+// parseKernelVersion parses the version of a release, ignoring the suffix of distributions,
+// e.g. 5.4.0 for 5.4.0-91-generic.
+func parseKernelVersion(release string) (kernelVersion, error) {
+	var v kernelVersion
+	end := strings.IndexFunc(release, func(r rune) bool {
+		return r != '.' && (r < '0' || r > '9')
+	})
+	if end < 0 {
+		end = len(release)
+	}
+	parts := strings.Split(release[:end], ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return v, fmt.Errorf("invalid kernel release %q, must be of the form 5.4 or 5.4.0", release)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return v, fmt.Errorf("invalid kernel release %q, must be of the form 5.4 or 5.4.0", release)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// KernelAtLeast returns whether the kernel release is the minimum release or a later one,
+// e.g. true for 5.4.0-91-generic and 4.18.
+func KernelAtLeast(release, min string) (bool, error) {
+	v, err := parseKernelVersion(release)
+	if err != nil {
+		return false, err
+	}
+	minVersion, err := parseKernelVersion(min)
+	if err != nil {
+		return false, err
+	}
+	return !v.less(minVersion), nil
+}
+
+func (v kernelVersion) less(o kernelVersion) bool {
+	for i := range v {
+		if v[i] != o[i] {
+			return v[i] < o[i]
+		}
+	}
+	return false
+}
+
+func (e *ebpfOCIClient) PushMultiArch(
+	ctx context.Context,
+	ref string,
+	registry target.Target,
+	pkgs []*v1.EbpfPackage,
+) error {
+	for _, pkg := range pkgs {
+		if pkg.Platform == nil || pkg.Platform.Architecture == "" {
+			return fmt.Errorf("the packages of a multi-arch image must have an architecture")
+		}
+	}
+	variants := make([]v1.EbpfPackageVariant, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		variants = append(variants, v1.EbpfPackageVariant{Package: pkg})
+	}
+	return e.PushVariants(ctx, ref, registry, variants)
+}
+
+func (e *ebpfOCIClient) PushVariants(
+	ctx context.Context,
+	ref string,
+	registry target.Target,
+	variants []v1.EbpfPackageVariant,
+) error {
+	ref, err := normalizeRef(ref)
+	if err != nil {
+		return err
+	}
+	memoryStore := content.NewMemory()
+
+	index := ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+	}
+	seen := map[string]bool{}
+	for _, variant := range variants {
+		pkg := variant.Package
+		if pkg == nil || pkg.Platform == nil || pkg.Platform.Architecture == "" {
+			return fmt.Errorf("the packages of a multi-variant image must have an architecture")
+		}
+		name := pkg.Platform.Architecture
+		if variant.MinKernelVersion != "" {
+			if _, err := parseKernelVersion(variant.MinKernelVersion); err != nil {
+				return err
+			}
+			name += " for kernels " + variant.MinKernelVersion + "+"
+		}
+		if seen[name] {
+			return fmt.Errorf("more than one package for %s", name)
+		}
+		seen[name] = true
+
+		annotated := *pkg
+		annotated.MinKernelVersion = variant.MinKernelVersion
+		manifestDesc, manifest, err := storePackage(memoryStore, &annotated, e.legacy)
+		if err != nil {
+			return err
+		}
+		if variant.MinKernelVersion != "" {
+			if manifestDesc.Annotations == nil {
+				manifestDesc.Annotations = map[string]string{}
+			}
+			manifestDesc.Annotations[AnnotationMinKernelVersion] = variant.MinKernelVersion
+		}
+		memoryStore.Set(manifestDesc, manifest)
+		index.Manifests = append(index.Manifests, manifestDesc)
+	}
+
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	indexDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Digest:    digest.FromBytes(indexBytes),
+		Size:      int64(len(indexBytes)),
+	}
+	if err := memoryStore.StoreManifest(ref, indexDesc, indexBytes); err != nil {
+		return err
+	}
+
+	_, err = Copy(ctx, memoryStore, ref, registry, e.transfer)
+	return err
+}
+
+// PullVariants pulls all the packages of an image, a single one if it is not an image index.
+func PullVariants(ctx context.Context, ref string, registry target.Target) ([]v1.EbpfPackageVariant, error) {
+	_, rootDesc, err := registry.Resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if rootDesc.MediaType != ocispec.MediaTypeImageIndex {
+		pkg, err := pullManifest(ctx, registry, ref, rootDesc.Digest, nil)
+		if err != nil {
+			return nil, err
+		}
+		return []v1.EbpfPackageVariant{{Package: pkg, MinKernelVersion: pkg.MinKernelVersion}}, nil
+	}
+
+	index, err := fetchIndex(ctx, registry, ref, rootDesc)
+	if err != nil {
+		return nil, err
+	}
+	var variants []v1.EbpfPackageVariant
+	for _, desc := range index.Manifests {
+		desc := desc
+		pkg, err := pullManifest(ctx, registry, ref, rootDesc.Digest, &desc)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, v1.EbpfPackageVariant{Package: pkg, MinKernelVersion: pkg.MinKernelVersion})
+	}
+	return variants, nil
+}
+
+func fetchIndex(ctx context.Context, registry target.Target, ref string, indexDesc ocispec.Descriptor) (*ocispec.Index, error) {
+	fetcher, err := registry.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := fetcher.Fetch(ctx, indexDesc)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch image index: %w", err)
+	}
+	defer rc.Close()
+	var index ocispec.Index
+	if err := json.NewDecoder(rc).Decode(&index); err != nil {
+		return nil, fmt.Errorf("could not decode image index: %w", err)
+	}
+	return &index, nil
+}
+
+// selectVariant returns the manifest of the package selected from the image index.
+func selectVariant(
+	ctx context.Context,
+	registry target.Target,
+	ref string,
+	indexDesc ocispec.Descriptor,
+	selector VariantSelector,
+) (*ocispec.Descriptor, error) {
+	index, err := fetchIndex(ctx, registry, ref, indexDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	arch := selector.arch()
+	release := selector.kernelRelease()
+	kernel, kernelErr := parseKernelVersion(release)
+	if selector.KernelRelease != "" && kernelErr != nil {
+		return nil, kernelErr
+	}
+
+	var (
+		selected    *ocispec.Descriptor
+		selectedMin kernelVersion
+		available   []string
+		tooOld      []string
+	)
+	for _, desc := range index.Manifests {
+		if desc.Platform == nil {
+			continue
+		}
+		minRelease := desc.Annotations[AnnotationMinKernelVersion]
+		if desc.Platform.Architecture != arch {
+			available = append(available, desc.Platform.Architecture)
+			continue
+		}
+		var min kernelVersion
+		if minRelease != "" {
+			min, err = parseKernelVersion(minRelease)
+			if err != nil {
+				return nil, fmt.Errorf("invalid package of %s: %w", ref, err)
+			}
+			// the kernel of the host is only unknown on other systems, where any variant will do
+			if kernelErr == nil && kernel.less(min) {
+				tooOld = append(tooOld, minRelease)
+				continue
+			}
+		}
+		if selected == nil || selectedMin.less(min) {
+			desc := desc
+			selected, selectedMin = &desc, min
+		}
+	}
+	if selected != nil {
+		return selected, nil
+	}
+	if len(tooOld) > 0 {
+		return nil, fmt.Errorf("%s has no package of architecture %s for kernel %s, they require kernels from %s on", ref, arch, release, strings.Join(tooOld, ", "))
+	}
+	return nil, fmt.Errorf("%s has no package for architecture %s, available: %s", ref, arch, strings.Join(available, ", "))
+}
+
+// skipOtherManifests only lets the manifest with the digest be copied from an image index.
+func skipOtherManifests(dgst digest.Digest) images.HandlerFunc {
+	return func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if desc.MediaType == ocispec.MediaTypeImageManifest && desc.Digest != dgst {
+			return nil, images.ErrStopHandler
+		}
+		return nil, nil
+	}
+}
@@ -0,0 +1,817 @@
+package spec
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/oras"
+	"oras.land/oras-go/pkg/target"
+)
+
+// The notation signature format: an image index tagged after the digest of the signed manifest,
+// as the referrers tag schema of OCI distribution, listing the manifests of its signatures.
+// Their config is of the notation artifact type, their subject the signed manifest, and their
+// layer a JWS envelope signed by the key of an X.509 certificate chain.
+const (
+	notationArtifactType = "application/vnd.cncf.notary.signature"
+	jwsMediaType         = "application/jose+json"
+	notationPayloadType  = "application/vnd.cncf.notary.payload.v1+json"
+
+	annotationThumbprints = "io.cncf.notary.x509chain.thumbprint#S256"
+
+	headerSigningScheme = "io.cncf.notary.signingScheme"
+	headerSigningTime   = "io.cncf.notary.signingTime"
+	headerSigningAgent  = "io.cncf.notary.signingAgent"
+	signingSchemeX509   = "notary.x509"
+)
+
+// Levels of the signature verification of trust policies.
+const (
+	VerificationStrict     = "strict"
+	VerificationPermissive = "permissive"
+	VerificationAudit      = "audit"
+	VerificationSkip       = "skip"
+)
+
+// TrustPolicyDocument is a trust policy file of notation, the trustpolicy.json of its config
+// directory, selecting the registries whose packages are verified with notation signatures.
+type TrustPolicyDocument struct {
+	Version       string        `json:"version"`
+	TrustPolicies []TrustPolicy `json:"trustPolicies"`
+}
+
+// TrustPolicy is the policy of the packages of its registry scopes, the repositories, e.g.
+// ghcr.io/solo-io/bumblebee/tcpconnect, or * for the repositories of no other policy.
+type TrustPolicy struct {
+	Name                  string   `json:"name"`
+	RegistryScopes        []string `json:"registryScopes"`
+	SignatureVerification struct {
+		// strict, permissive, audit or skip
+		Level string `json:"level"`
+	} `json:"signatureVerification"`
+	// Trust stores of the roots of the certificates, as ca:NAME or signingAuthority:NAME
+	TrustStores []string `json:"trustStores"`
+	// Identities of the signing certificates, as x509.subject: DN, or *
+	TrustedIdentities []string `json:"trustedIdentities"`
+
+	roots *x509.CertPool
+	// hash of the policy and of the certificates of its trust stores
+	hash []byte
+}
+
+// DefaultTrustPolicyFile and DefaultTrustStoreDir return the trust policy file and trust store
+// of the notation CLI.
+func DefaultTrustPolicyFile() string {
+	return filepath.Join(notationConfigDir(), "trustpolicy.json")
+}
+
+func DefaultTrustStoreDir() string {
+	return filepath.Join(notationConfigDir(), "truststore")
+}
+
+func notationConfigDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "notation"
+	}
+	return filepath.Join(dir, "notation")
+}
+
+// LoadTrustPolicy reads and validates a trust policy file, loading the certificates of its
+// trust stores from the directory, DefaultTrustStoreDir if empty, laid out as
+// x509/<type>/<name>/*.pem.
+func LoadTrustPolicy(policyFile, trustStoreDir string) (*TrustPolicyDocument, error) {
+	data, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+	var doc TrustPolicyDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("could not parse trust policy %s: %w", policyFile, err)
+	}
+	if err := doc.validate(); err != nil {
+		return nil, fmt.Errorf("invalid trust policy %s: %w", policyFile, err)
+	}
+	if trustStoreDir == "" {
+		trustStoreDir = DefaultTrustStoreDir()
+	}
+	for i := range doc.TrustPolicies {
+		policy := &doc.TrustPolicies[i]
+		h := sha256.New()
+		if err := json.NewEncoder(h).Encode(policy); err != nil {
+			return nil, err
+		}
+		if len(policy.TrustStores) > 0 {
+			policy.roots = x509.NewCertPool()
+		}
+		for _, store := range policy.TrustStores {
+			storeType, name, _ := cutPrefix(store)
+			if err := loadTrustStore(policy.roots, h, filepath.Join(trustStoreDir, "x509", storeType, name)); err != nil {
+				return nil, fmt.Errorf("trust policy %s: %w", policy.Name, err)
+			}
+		}
+		policy.hash = h.Sum(nil)
+	}
+	return &doc, nil
+}
+
+func (d *TrustPolicyDocument) validate() error {
+	if d.Version != "1.0" {
+		return fmt.Errorf("unsupported version %q, must be 1.0", d.Version)
+	}
+	if len(d.TrustPolicies) == 0 {
+		return errors.New("no trust policies")
+	}
+	names := map[string]bool{}
+	scopes := map[string]string{}
+	for _, policy := range d.TrustPolicies {
+		if policy.Name == "" {
+			return errors.New("a trust policy has no name")
+		}
+		if names[policy.Name] {
+			return fmt.Errorf("more than one trust policy is named %s", policy.Name)
+		}
+		names[policy.Name] = true
+		if len(policy.RegistryScopes) == 0 {
+			return fmt.Errorf("trust policy %s has no registry scopes", policy.Name)
+		}
+		for _, scope := range policy.RegistryScopes {
+			if scope == "*" && len(policy.RegistryScopes) > 1 {
+				return fmt.Errorf("trust policy %s: the wildcard scope * can't be set with other scopes", policy.Name)
+			}
+			if other, ok := scopes[scope]; ok {
+				return fmt.Errorf("registry scope %s is in both trust policies %s and %s", scope, other, policy.Name)
+			}
+			scopes[scope] = policy.Name
+		}
+		switch policy.SignatureVerification.Level {
+		case VerificationSkip:
+			if len(policy.TrustStores) > 0 || len(policy.TrustedIdentities) > 0 {
+				return fmt.Errorf("trust policy %s skips verification, it can't have trust stores or trusted identities", policy.Name)
+			}
+			continue
+		case VerificationStrict, VerificationPermissive, VerificationAudit:
+		default:
+			return fmt.Errorf("trust policy %s: unknown verification level %q, must be one of strict, permissive, audit or skip", policy.Name, policy.SignatureVerification.Level)
+		}
+		if len(policy.TrustStores) == 0 || len(policy.TrustedIdentities) == 0 {
+			return fmt.Errorf("trust policy %s must have trust stores and trusted identities", policy.Name)
+		}
+		for _, store := range policy.TrustStores {
+			storeType, name, ok := cutPrefix(store)
+			if !ok || name == "" || strings.ContainsAny(name, "/\\") || (storeType != "ca" && storeType != "signingAuthority") {
+				return fmt.Errorf("trust policy %s: invalid trust store %q, must be ca:NAME or signingAuthority:NAME", policy.Name, store)
+			}
+		}
+		for _, identity := range policy.TrustedIdentities {
+			if identity == "*" {
+				if len(policy.TrustedIdentities) > 1 {
+					return fmt.Errorf("trust policy %s: the wildcard identity * can't be set with other identities", policy.Name)
+				}
+				continue
+			}
+			if _, err := parseTrustedIdentity(identity); err != nil {
+				return fmt.Errorf("trust policy %s: %w", policy.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// PolicyFor returns the trust policy of the repository, nil if no policy applies to it.
+func (d *TrustPolicyDocument) PolicyFor(repository string) *TrustPolicy {
+	var wildcard *TrustPolicy
+	for i := range d.TrustPolicies {
+		policy := &d.TrustPolicies[i]
+		for _, scope := range policy.RegistryScopes {
+			if scope == repository {
+				return policy
+			}
+			if scope == "*" {
+				wildcard = policy
+			}
+		}
+	}
+	return wildcard
+}
+
+func loadTrustStore(pool *x509.CertPool, h hash.Hash, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("could not read trust store: %w", err)
+	}
+	var found bool
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		certs, err := parseCertificates(data)
+		if err != nil {
+			return fmt.Errorf("invalid certificate %s: %w", entry.Name(), err)
+		}
+		for _, cert := range certs {
+			pool.AddCert(cert)
+			h.Write(cert.Raw)
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("trust store %s has no certificates", dir)
+	}
+	return nil
+}
+
+// LoadCertificateChain parses the PEM certificates of a chain, the signing certificate first.
+func LoadCertificateChain(data []byte) ([]*x509.Certificate, error) {
+	certs, err := parseCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM certificates")
+	}
+	return certs, nil
+}
+
+// parseCertificates parses PEM certificates, or a DER certificate.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 && len(bytes.TrimSpace(data)) > 0 && !bytes.Contains(data, []byte("-----BEGIN")) {
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// trustedIdentity is the subject of a trusted identity, as attributes, e.g. CN=bee, O=Solo.
+type trustedIdentity map[string]string
+
+func parseTrustedIdentity(identity string) (trustedIdentity, error) {
+	prefix, dn, ok := cutPrefix(identity)
+	if !ok || prefix != "x509.subject" {
+		return nil, fmt.Errorf("invalid trusted identity %q, must be x509.subject: DN or *", identity)
+	}
+	attributes := trustedIdentity{}
+	for _, rdn := range strings.Split(dn, ",") {
+		kv := strings.SplitN(strings.TrimSpace(rdn), "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid trusted identity %q, %q is not ATTRIBUTE=VALUE", identity, rdn)
+		}
+		attributes[strings.ToUpper(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+	}
+	return attributes, nil
+}
+
+// matches reports whether the subject has all the attributes of the identity.
+func (t trustedIdentity) matches(cert *x509.Certificate) bool {
+	subject := map[string][]string{
+		"C":  cert.Subject.Country,
+		"ST": cert.Subject.Province,
+		"L":  cert.Subject.Locality,
+		"O":  cert.Subject.Organization,
+		"OU": cert.Subject.OrganizationalUnit,
+		"CN": {cert.Subject.CommonName},
+	}
+	for attribute, value := range t {
+		var found bool
+		for _, v := range subject[attribute] {
+			if v == value {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// cutPrefix splits the prefix of a trust store or identity, e.g. ca:acme or x509.subject: CN=bee.
+func cutPrefix(s string) (string, string, bool) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return "", s, false
+	}
+	return s[:i], strings.TrimSpace(s[i+1:]), true
+}
+
+// NotationRef returns the ref of the index of the notation signatures of the manifest of the
+// digest, in the repository of the ref, e.g. repo:sha256-4f9a....
+func NotationRef(ref string, dgst digest.Digest) string {
+	return repository(ref) + ":" + dgst.Algorithm().String() + "-" + dgst.Encoded()
+}
+
+// manifestDescriptor is a descriptor of an index with the artifact type of the manifest.
+type manifestDescriptor struct {
+	ocispec.Descriptor
+	ArtifactType string `json:"artifactType,omitempty"`
+}
+
+// referrersIndex is the index of the referrers tag schema.
+type referrersIndex struct {
+	specs.Versioned
+	MediaType string               `json:"mediaType"`
+	Manifests []manifestDescriptor `json:"manifests"`
+}
+
+// signatureManifest is the manifest of a signature, with its subject.
+type signatureManifest struct {
+	specs.Versioned
+	MediaType    string               `json:"mediaType"`
+	ArtifactType string               `json:"artifactType,omitempty"`
+	Config       ocispec.Descriptor   `json:"config"`
+	Layers       []ocispec.Descriptor `json:"layers"`
+	Subject      *ocispec.Descriptor  `json:"subject,omitempty"`
+	Annotations  map[string]string    `json:"annotations,omitempty"`
+}
+
+type notationPayload struct {
+	TargetArtifact ocispec.Descriptor `json:"targetArtifact"`
+}
+
+type jwsHeader struct {
+	Algorithm     string   `json:"alg"`
+	ContentType   string   `json:"cty"`
+	Critical      []string `json:"crit"`
+	SigningScheme string   `json:"io.cncf.notary.signingScheme"`
+	SigningTime   string   `json:"io.cncf.notary.signingTime"`
+}
+
+type jwsEnvelope struct {
+	Payload   string `json:"payload"`
+	Protected string `json:"protected"`
+	Header    struct {
+		CertificateChain []string `json:"x5c"`
+		SigningAgent     string   `json:"io.cncf.notary.signingAgent,omitempty"`
+	} `json:"header"`
+	Signature string `json:"signature"`
+}
+
+// SignNotation stores a notation signature of the image of the ref in the registry, signed by
+// the key of the first certificate of the chain, e.g. a KMS key. Other signatures of the
+// image are kept.
+func SignNotation(ctx context.Context, ref string, registry target.Target, key crypto.Signer, chain []*x509.Certificate) error {
+	if len(chain) == 0 {
+		return errors.New("notation signatures need the certificate chain of the key")
+	}
+	alg, hash, err := jwsAlgorithm(key.Public())
+	if err != nil {
+		return err
+	}
+	if !publicKeysEqual(chain[0].PublicKey, key.Public()) {
+		return errors.New("the key is not the one of the signing certificate")
+	}
+	_, desc, err := registry.Resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(notationPayload{TargetArtifact: ocispec.Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    desc.Digest,
+		Size:      desc.Size,
+	}})
+	if err != nil {
+		return err
+	}
+	protected, err := json.Marshal(jwsHeader{
+		Algorithm:     alg,
+		ContentType:   notationPayloadType,
+		Critical:      []string{headerSigningScheme},
+		SigningScheme: signingSchemeX509,
+		SigningTime:   time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	var envelope jwsEnvelope
+	envelope.Payload = base64.RawURLEncoding.EncodeToString(payload)
+	envelope.Protected = base64.RawURLEncoding.EncodeToString(protected)
+	sig, err := jwsSign(key, hash, []byte(envelope.Protected+"."+envelope.Payload))
+	if err != nil {
+		return err
+	}
+	envelope.Signature = base64.RawURLEncoding.EncodeToString(sig)
+	envelope.Header.SigningAgent = "bumblebee"
+	var thumbprints []string
+	for _, cert := range chain {
+		envelope.Header.CertificateChain = append(envelope.Header.CertificateChain, base64.StdEncoding.EncodeToString(cert.Raw))
+		sum := sha256.Sum256(cert.Raw)
+		thumbprints = append(thumbprints, hex.EncodeToString(sum[:]))
+	}
+	envelopeBytes, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	thumbprintsBytes, err := json.Marshal(thumbprints)
+	if err != nil {
+		return err
+	}
+
+	store := content.NewMemory()
+	layer, err := store.Add("", jwsMediaType, envelopeBytes)
+	if err != nil {
+		return err
+	}
+	layer.Annotations = nil
+	configBytes := []byte("{}")
+	configDesc := ocispec.Descriptor{
+		MediaType: notationArtifactType,
+		Digest:    digest.FromBytes(configBytes),
+		Size:      int64(len(configBytes)),
+	}
+	store.Set(configDesc, configBytes)
+	manifestBytes, err := json.Marshal(signatureManifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    []ocispec.Descriptor{layer},
+		Subject: &ocispec.Descriptor{
+			MediaType: desc.MediaType,
+			Digest:    desc.Digest,
+			Size:      desc.Size,
+		},
+		Annotations: map[string]string{annotationThumbprints: string(thumbprintsBytes)},
+	})
+	if err != nil {
+		return err
+	}
+	manifestDesc := ocispec.Descriptor{
+		MediaType:   ocispec.MediaTypeImageManifest,
+		Digest:      digest.FromBytes(manifestBytes),
+		Size:        int64(len(manifestBytes)),
+		Annotations: map[string]string{annotationThumbprints: string(thumbprintsBytes)},
+	}
+	store.Set(manifestDesc, manifestBytes)
+
+	// the index lists the signatures already stored, whose manifests and blobs are fetched
+	// from the registry if it lacks them
+	indexRef := NotationRef(ref, desc.Digest)
+	index := referrersIndex{Versioned: specs.Versioned{SchemaVersion: 2}, MediaType: ocispec.MediaTypeImageIndex}
+	if _, indexDesc, err := registry.Resolve(ctx, indexRef); err == nil {
+		if err := fetchJSON(ctx, registry, indexRef, indexDesc, &index); err != nil {
+			return fmt.Errorf("could not read the notation signatures of %s: %w", ref, err)
+		}
+	}
+	index.Manifests = append(index.Manifests, manifestDescriptor{Descriptor: manifestDesc, ArtifactType: notationArtifactType})
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	indexDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Digest:    digest.FromBytes(indexBytes),
+		Size:      int64(len(indexBytes)),
+	}
+	if err := store.StoreManifest(indexRef, indexDesc, indexBytes); err != nil {
+		return err
+	}
+	_, err = oras.Copy(
+		ctx,
+		&fallbackTarget{Target: store, fallback: registry},
+		indexRef,
+		registry,
+		"",
+		oras.WithAllowedMediaTypes([]string{notationArtifactType, jwsMediaType}),
+	)
+	return err
+}
+
+// fallbackTarget is a target whose blobs missing from it are fetched from another.
+type fallbackTarget struct {
+	target.Target
+	fallback target.Target
+}
+
+func (f *fallbackTarget) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := f.Target.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return remotes.FetcherFunc(func(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+		rc, err := fetcher.Fetch(ctx, desc)
+		if err == nil {
+			return rc, nil
+		}
+		fallback, fallbackErr := f.fallback.Fetcher(ctx, ref)
+		if fallbackErr != nil {
+			return nil, err
+		}
+		return fallback.Fetch(ctx, desc)
+	}), nil
+}
+
+// verifyNotation checks a notation signature of the manifest of the digest satisfies the
+// trust policy.
+func verifyNotation(ctx context.Context, registry target.Target, ref string, dgst digest.Digest, policy *TrustPolicy) error {
+	if policy.SignatureVerification.Level == VerificationSkip {
+		return nil
+	}
+	indexRef := NotationRef(ref, dgst)
+	_, indexDesc, err := registry.Resolve(ctx, indexRef)
+	if err != nil {
+		if !isNotFound(err) {
+			return fmt.Errorf("could not fetch the signatures of %s: %w", ref, err)
+		}
+		return &VerificationError{Ref: ref, Digest: dgst, Err: ErrUnsigned}
+	}
+	var index referrersIndex
+	if err := fetchJSON(ctx, registry, indexRef, indexDesc, &index); err != nil {
+		return &VerificationError{Ref: ref, Digest: dgst, Err: ErrBadSignature, Reason: err.Error()}
+	}
+
+	var reasons []string
+	var signatures int
+	for _, desc := range index.Manifests {
+		if desc.ArtifactType != "" && desc.ArtifactType != notationArtifactType {
+			continue
+		}
+		var manifest signatureManifest
+		if err := fetchJSON(ctx, registry, indexRef, desc.Descriptor, &manifest); err != nil {
+			reasons = append(reasons, err.Error())
+			continue
+		}
+		if manifest.Config.MediaType != notationArtifactType || manifest.Subject == nil || manifest.Subject.Digest != dgst {
+			continue
+		}
+		for _, layer := range manifest.Layers {
+			if layer.MediaType != jwsMediaType {
+				continue
+			}
+			signatures++
+			envelope, err := fetchEnvelope(ctx, registry, indexRef, layer)
+			if err == nil {
+				err = policy.verifyEnvelope(envelope, dgst)
+			}
+			if err != nil {
+				reasons = append(reasons, err.Error())
+				continue
+			}
+			return nil
+		}
+	}
+	if signatures == 0 && len(reasons) == 0 {
+		return &VerificationError{Ref: ref, Digest: dgst, Err: ErrUnsigned}
+	}
+	return &VerificationError{Ref: ref, Digest: dgst, Err: ErrBadSignature, Reason: strings.Join(reasons, "; ")}
+}
+
+// envelopes are small, larger layers are not read
+const maxEnvelopeSize = 1 << 20
+
+func fetchEnvelope(ctx context.Context, registry target.Target, ref string, desc ocispec.Descriptor) ([]byte, error) {
+	if desc.Size > maxEnvelopeSize {
+		return nil, fmt.Errorf("signature envelope of %d bytes is too large", desc.Size)
+	}
+	fetcher, err := registry.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(rc, maxEnvelopeSize))
+	if err != nil {
+		return nil, err
+	}
+	if digest.FromBytes(data) != desc.Digest {
+		return nil, errors.New("the digest of the signature envelope does not match")
+	}
+	return data, nil
+}
+
+// verifyEnvelope verifies the integrity of the signature, then, unless auditing, the
+// authenticity of its certificate chain and the identity of the certificate. Strict policies
+// also reject certificates which expired since signing.
+func (p *TrustPolicy) verifyEnvelope(data []byte, dgst digest.Digest) error {
+	var envelope jwsEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("invalid signature envelope: %w", err)
+	}
+	protectedBytes, err := base64.RawURLEncoding.DecodeString(envelope.Protected)
+	if err != nil {
+		return fmt.Errorf("invalid protected header: %w", err)
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(protectedBytes, &header); err != nil {
+		return fmt.Errorf("invalid protected header: %w", err)
+	}
+	if header.ContentType != notationPayloadType || header.SigningScheme != signingSchemeX509 {
+		return fmt.Errorf("unsupported signature of %s with scheme %s", header.ContentType, header.SigningScheme)
+	}
+	signingTime, err := time.Parse(time.RFC3339, header.SigningTime)
+	if err != nil {
+		return fmt.Errorf("invalid signing time: %w", err)
+	}
+	if len(envelope.Header.CertificateChain) == 0 {
+		return errors.New("the signature has no certificate")
+	}
+	var chain []*x509.Certificate
+	for _, encoded := range envelope.Header.CertificateChain {
+		der, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("invalid certificate: %w", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("invalid certificate: %w", err)
+		}
+		chain = append(chain, cert)
+	}
+	leaf := chain[0]
+
+	// integrity
+	alg, hash, err := jwsAlgorithm(leaf.PublicKey)
+	if err != nil {
+		return err
+	}
+	if header.Algorithm != alg {
+		return fmt.Errorf("the signature algorithm %s does not match the %s key of the certificate", header.Algorithm, alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(envelope.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if err := jwsVerify(leaf.PublicKey, hash, []byte(envelope.Protected+"."+envelope.Payload), sig); err != nil {
+		return err
+	}
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	var payload notationPayload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	if payload.TargetArtifact.Digest != dgst {
+		return fmt.Errorf("the signature is of %s", payload.TargetArtifact.Digest)
+	}
+	if p.SignatureVerification.Level == VerificationAudit {
+		return nil
+	}
+
+	// authenticity
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	verifyAt := signingTime
+	if p.SignatureVerification.Level == VerificationStrict {
+		verifyAt = time.Now()
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         p.roots,
+		Intermediates: intermediates,
+		CurrentTime:   verifyAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return fmt.Errorf("the certificate is not trusted by trust policy %s: %w", p.Name, err)
+	}
+	for _, identity := range p.TrustedIdentities {
+		if identity == "*" {
+			return nil
+		}
+		trusted, err := parseTrustedIdentity(identity)
+		if err != nil {
+			return err
+		}
+		if trusted.matches(leaf) {
+			return nil
+		}
+	}
+	return fmt.Errorf("the certificate of %s is not a trusted identity of trust policy %s", leaf.Subject, p.Name)
+}
+
+// jwsAlgorithm returns the JWS algorithm of the key, as notation maps them.
+func jwsAlgorithm(key crypto.PublicKey) (string, crypto.Hash, error) {
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return "ES256", crypto.SHA256, nil
+		case elliptic.P384():
+			return "ES384", crypto.SHA384, nil
+		case elliptic.P521():
+			return "ES512", crypto.SHA512, nil
+		}
+		return "", 0, fmt.Errorf("unsupported curve %s", key.Curve.Params().Name)
+	case *rsa.PublicKey:
+		switch key.N.BitLen() {
+		case 2048:
+			return "PS256", crypto.SHA256, nil
+		case 3072:
+			return "PS384", crypto.SHA384, nil
+		case 4096:
+			return "PS512", crypto.SHA512, nil
+		}
+		return "", 0, fmt.Errorf("unsupported RSA key of %d bits", key.N.BitLen())
+	}
+	return "", 0, fmt.Errorf("unsupported key type %T for notation signatures", key)
+}
+
+func jwsSign(key crypto.Signer, hash crypto.Hash, input []byte) ([]byte, error) {
+	h := hash.New()
+	h.Write(input)
+	hashed := h.Sum(nil)
+	switch public := key.Public().(type) {
+	case *rsa.PublicKey:
+		return key.Sign(rand.Reader, hashed, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash})
+	case *ecdsa.PublicKey:
+		der, err := key.Sign(rand.Reader, hashed, hash)
+		if err != nil {
+			return nil, err
+		}
+		// JWS signatures are r || s rather than ASN.1
+		var parsed struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(der, &parsed); err != nil {
+			return nil, fmt.Errorf("invalid ECDSA signature: %w", err)
+		}
+		size := (public.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		parsed.R.FillBytes(sig[:size])
+		parsed.S.FillBytes(sig[size:])
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T for notation signatures", key.Public())
+}
+
+func jwsVerify(key crypto.PublicKey, hash crypto.Hash, input, sig []byte) error {
+	h := hash.New()
+	h.Write(input)
+	hashed := h.Sum(nil)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPSS(key, hash, hashed, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}); err != nil {
+			return fmt.Errorf("the signature was not made by the key of the certificate")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("the signature was not made by the key of the certificate")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, hashed, r, s) {
+			return fmt.Errorf("the signature was not made by the key of the certificate")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key type %T for notation signatures", key)
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	equal, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && equal.Equal(b)
+}
@@ -0,0 +1,243 @@
+package spec
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/pkg/target"
+)
+
+// PullStats is a snapshot of the status of a pull, e.g. to render it in the CLI or to set it in
+// the status of a resource.
+type PullStats struct {
+	Ref string `json:"ref"`
+	// Size of the manifests and blobs of the package, resolved from its descriptors before
+	// they are transferred, 0 until resolved
+	Total int64 `json:"total"`
+	// Bytes transferred so far, and the ones of the blobs reused from the store
+	Transferred int64 `json:"transferred"`
+	Reused      int64 `json:"reused"`
+	// Number of blobs transferred or reused, out of the ones started so far
+	BlobsDone int `json:"blobsDone"`
+	Blobs     int `json:"blobs"`
+	// Bytes transferred per second since the pull started, until it finished
+	Rate     float64   `json:"rate"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Set once the pull succeeded, or failed with Error
+	Done  bool   `json:"done"`
+	Error string `json:"error,omitempty"`
+}
+
+// Remaining returns the bytes left to transfer, 0 if the size is not resolved yet.
+func (s PullStats) Remaining() int64 {
+	remaining := s.Total - s.Transferred - s.Reused
+	if s.Total == 0 || remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// ETA returns the time the remaining bytes take to transfer at the current rate, false if it
+// can't be estimated yet.
+func (s PullStats) ETA() (time.Duration, bool) {
+	if s.Done {
+		return 0, true
+	}
+	if s.Total == 0 || s.Rate <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(s.Remaining()) / s.Rate * float64(time.Second)), true
+}
+
+// String formats the stats, e.g. "12MiB of 40MiB at 3MiB/s, 9s left", followed by the bytes
+// reused, e.g. ", 80MiB reused".
+func (s PullStats) String() string {
+	progress := units.BytesSize(float64(s.Transferred))
+	if s.Total > 0 {
+		progress += " of " + units.BytesSize(float64(s.Total-s.Reused))
+	}
+	progress += fmt.Sprintf(" at %s/s", units.BytesSize(s.Rate))
+	if eta, ok := s.ETA(); ok && !s.Done {
+		progress += fmt.Sprintf(", %s left", eta.Round(time.Second))
+	}
+	if s.Reused > 0 {
+		progress += fmt.Sprintf(", %s reused", units.BytesSize(float64(s.Reused)))
+	}
+	return progress
+}
+
+// PullStatus is the status of a pull started with StartFetch, which callers can poll with
+// Stats or subscribe to with Subscribe.
+type PullStatus struct {
+	lock        sync.Mutex
+	stats       PullStats
+	blobs       map[digest.Digest]Progress
+	subscribers map[chan PullStats]struct{}
+	done        chan struct{}
+	desc        ocispec.Descriptor
+	err         error
+}
+
+func newPullStatus(ref string) *PullStatus {
+	return &PullStatus{
+		stats:       PullStats{Ref: ref, Started: time.Now()},
+		blobs:       map[digest.Digest]Progress{},
+		subscribers: map[chan PullStats]struct{}{},
+		done:        make(chan struct{}),
+	}
+}
+
+// Stats returns the current stats of the pull.
+func (s *PullStatus) Stats() PullStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.snapshot()
+}
+
+// Subscribe returns a channel receiving the stats of the pull as they change, closed once the
+// pull is done, and a function unsubscribing. Slow subscribers only get the latest stats.
+func (s *PullStatus) Subscribe() (<-chan PullStats, func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ch := make(chan PullStats, 1)
+	ch <- s.snapshot()
+	if s.stats.Done {
+		close(ch)
+		return ch, func() {}
+	}
+	s.subscribers[ch] = struct{}{}
+	return ch, func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Wait returns the descriptor of the package once the pull is done, or the error it failed
+// with.
+func (s *PullStatus) Wait(ctx context.Context) (ocispec.Descriptor, error) {
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return ocispec.Descriptor{}, ctx.Err()
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.desc, s.err
+}
+
+// resolve records the size of the package, once resolved from its descriptors.
+func (s *PullStatus) resolve(total int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stats.Total = total
+	s.publish()
+}
+
+// update records the progress of a blob.
+func (s *PullStatus) update(p Progress) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.blobs[p.Descriptor.Digest] = p
+	s.publish()
+}
+
+// finish records the result of the pull, and closes the channels of the subscribers.
+func (s *PullStatus) finish(desc ocispec.Descriptor, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.desc, s.err = desc, err
+	s.stats.Done = true
+	s.stats.Finished = time.Now()
+	if err != nil {
+		s.stats.Error = err.Error()
+	}
+	s.publish()
+	for ch := range s.subscribers {
+		close(ch)
+	}
+	s.subscribers = nil
+	close(s.done)
+}
+
+// snapshot sums up the progress of the blobs, with the lock held.
+func (s *PullStatus) snapshot() PullStats {
+	stats := s.stats
+	stats.Blobs = len(s.blobs)
+	for _, p := range s.blobs {
+		if p.Reused {
+			stats.Reused += p.Descriptor.Size
+		} else {
+			stats.Transferred += p.Transferred
+		}
+		if p.Done && p.Err == nil {
+			stats.BlobsDone++
+		}
+	}
+	end := time.Now()
+	if stats.Done {
+		end = stats.Finished
+	}
+	if elapsed := end.Sub(stats.Started).Seconds(); elapsed > 0 {
+		stats.Rate = float64(stats.Transferred) / elapsed
+	}
+	return stats
+}
+
+// publish sends the stats to the subscribers, replacing the stats they did not receive yet,
+// with the lock held.
+func (s *PullStatus) publish() {
+	if len(s.subscribers) == 0 {
+		return
+	}
+	stats := s.snapshot()
+	for ch := range s.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- stats
+	}
+}
+
+// pullSize returns the size of the manifests and blobs of the descriptor, for image indexes
+// the ones of all their variants, as they are all copied.
+func pullSize(ctx context.Context, registry target.Target, ref string, desc ocispec.Descriptor) (int64, error) {
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex:
+		index, err := fetchIndex(ctx, registry, ref, desc)
+		if err != nil {
+			return 0, err
+		}
+		total := desc.Size
+		for _, manifest := range index.Manifests {
+			size, err := pullSize(ctx, registry, ref, manifest)
+			if err != nil {
+				return 0, err
+			}
+			total += size
+		}
+		return total, nil
+	case ocispec.MediaTypeImageManifest:
+		var manifest ocispec.Manifest
+		if err := fetchJSON(ctx, registry, ref, desc, &manifest); err != nil {
+			return 0, fmt.Errorf("could not fetch manifest: %w", err)
+		}
+		total := desc.Size + manifest.Config.Size
+		for _, layer := range manifest.Layers {
+			total += layer.Size
+		}
+		return total, nil
+	default:
+		return desc.Size, nil
+	}
+}
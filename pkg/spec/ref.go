@@ -0,0 +1,131 @@
+package spec
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// ErrInvalidRef is wrapped by the errors of the refs ParseRef rejects.
+var ErrInvalidRef = errors.New("invalid ref")
+
+var (
+	// tagPattern matches a tag of the distribution spec.
+	tagPattern = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+	// hostPattern matches a registry host, with an optional port.
+	hostPattern = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*|\[[0-9a-fA-F:]+\])(:[0-9]+)?$`)
+)
+
+// Ref is a parsed ref of a package, e.g. ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7, or of a
+// repository when it has neither tag nor digest. Short refs, e.g. tcpconnect:v1, have no
+// registry.
+type Ref struct {
+	// Host of the registry, with its port, e.g. ghcr.io or localhost:5000, lowercased
+	Registry string
+	// Repository in the registry, e.g. solo-io/bumblebee/tcpconnect
+	Repository string
+	Tag        string
+	Digest     digest.Digest
+}
+
+// ParseRef parses and normalizes a ref, failing with ErrInvalidRef if it doesn't follow the
+// distribution spec, e.g. if its repository has uppercase letters or its digest is malformed.
+func ParseRef(ref string) (Ref, error) {
+	var parsed Ref
+	name := ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		parsed.Digest = digest.Digest(name[i+1:])
+		if err := parsed.Digest.Validate(); err != nil {
+			return Ref{}, fmt.Errorf("%w %s: digest %s: %v", ErrInvalidRef, ref, parsed.Digest, err)
+		}
+		name = name[:i]
+	}
+	if repo, tag, ok := splitTag(name); ok {
+		if !tagPattern.MatchString(tag) {
+			return Ref{}, fmt.Errorf("%w %s: tag %q must be up to 128 letters, digits, '_', '.' or '-', not starting with '.' or '-'", ErrInvalidRef, ref, tag)
+		}
+		parsed.Tag = tag
+		name = repo
+	}
+	if IsFullRef(name) {
+		i := strings.Index(name, "/")
+		parsed.Registry, name = strings.ToLower(name[:i]), name[i+1:]
+		if !hostPattern.MatchString(parsed.Registry) {
+			return Ref{}, fmt.Errorf("%w %s: registry %q must be a host, with an optional port", ErrInvalidRef, ref, parsed.Registry)
+		}
+	}
+	if name == "" {
+		return Ref{}, fmt.Errorf("%w %q: it has no repository", ErrInvalidRef, ref)
+	}
+	if err := checkComponents(name, ref); err != nil {
+		return Ref{}, fmt.Errorf("%w %s: %v", ErrInvalidRef, ref, err)
+	}
+	parsed.Repository = name
+	return parsed, nil
+}
+
+// checkComponents returns an error if a component of the repository of a ref breaks the
+// naming rules of the distribution spec.
+func checkComponents(repo, ref string) error {
+	for _, component := range strings.Split(repo, "/") {
+		if lower := strings.ToLower(component); lower != component && repositoryComponentPattern.MatchString(lower) {
+			return fmt.Errorf("repository %s of %s has uppercase letters, which registries refuse: use %s", repo, ref, strings.ToLower(repo))
+		}
+		if !repositoryComponentPattern.MatchString(component) {
+			return fmt.Errorf("component %q of repository %s of %s must be lowercase letters and digits, separated by '.', '_', '__' or dashes", component, repo, ref)
+		}
+	}
+	return nil
+}
+
+// String returns the ref, ParseRef of it returning the same Ref.
+func (r Ref) String() string {
+	ref := r.Name()
+	if r.Tag != "" {
+		ref += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		ref += "@" + r.Digest.String()
+	}
+	return ref
+}
+
+// Name returns the repository of the ref, with its registry, e.g. ghcr.io/solo-io/bumblebee/tcpconnect.
+func (r Ref) Name() string {
+	if r.Registry == "" {
+		return r.Repository
+	}
+	return r.Registry + "/" + r.Repository
+}
+
+// IsFull returns whether the ref has a registry.
+func (r Ref) IsFull() bool {
+	return r.Registry != ""
+}
+
+// IsRepository returns whether the ref has neither tag nor digest.
+func (r Ref) IsRepository() bool {
+	return r.Tag == "" && r.Digest == ""
+}
+
+// WithTag returns the ref of the tag in the repository of the ref, without digest.
+func (r Ref) WithTag(tag string) Ref {
+	return Ref{Registry: r.Registry, Repository: r.Repository, Tag: tag}
+}
+
+// WithDigest returns the ref pinned to the digest, in the repository of the ref, without tag.
+func (r Ref) WithDigest(dgst digest.Digest) Ref {
+	return Ref{Registry: r.Registry, Repository: r.Repository, Digest: dgst}
+}
+
+// normalizeRef returns the ref as normalized by ParseRef, failing if it is invalid.
+func normalizeRef(ref string) (string, error) {
+	parsed, err := ParseRef(ref)
+	if err != nil {
+		return "", err
+	}
+	return parsed.String(), nil
+}
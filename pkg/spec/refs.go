@@ -0,0 +1,297 @@
+package spec
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RefsFileName is the file of the RefRules of a user, in the config directory of bee.
+const RefsFileName = "refs.yaml"
+
+// RefRules expand the short refs of packages, e.g. tcpconnect:v1, into full refs, as
+// container runtimes do. A ref is full when its first component is a registry, i.e. it has
+// a `.` or a port, or is localhost.
+type RefRules struct {
+	// Registry short refs are expanded with, e.g. ghcr.io, short refs being used as is if
+	// empty
+	DefaultRegistry string `yaml:"defaultRegistry,omitempty"`
+	// Namespace of the short refs of a single component, e.g. solo-io/bumblebee
+	DefaultNamespace string `yaml:"defaultNamespace,omitempty"`
+	// Full repositories of short repositories, e.g. ghcr.io/solo-io/bumblebee/tcpconnect for
+	// tcpconnect, taking precedence over the defaults
+	Aliases map[string]string `yaml:"aliases,omitempty"`
+	// Refuse short refs rather than expanding them, e.g. in production, so the registry a
+	// package comes from is always explicit
+	Strict bool `yaml:"strict,omitempty"`
+	// Rules of the repositories of registries, by host or glob of hosts, e.g.
+	// *.dkr.ecr.*.amazonaws.com, taking precedence over the ones known for the registry
+	Registries map[string]RegistryRules `yaml:"registries,omitempty"`
+}
+
+// RegistryRules rewrite the repositories of the refs of a registry, e.g. under the project
+// or repository key it requires, and check they follow its naming rules before pushing, so
+// pushes fail with the rule they break rather than the status of the registry.
+type RegistryRules struct {
+	// Template the repositories below the registry are rewritten with, e.g.
+	// docker-local/{{ .Repository }}, given the .Repository, its last component as .Name and
+	// the components before as .Namespace. Repositories already starting with the text
+	// before the first action of the template are left as is
+	Template string `yaml:"template,omitempty"`
+	// Maximum length of the repositories, 255 by default
+	MaxLength int `yaml:"maxLength,omitempty"`
+	// Minimum and maximum components of the repositories, e.g. 2 when the repositories must
+	// be under a project, not restricted if 0
+	MinComponents int `yaml:"minComponents,omitempty"`
+	MaxComponents int `yaml:"maxComponents,omitempty"`
+
+	template *template.Template
+}
+
+// knownRegistries are the naming rules of the registries which restrict them further than
+// the distribution spec.
+var knownRegistries = map[string]RegistryRules{
+	"*.dkr.ecr.*.amazonaws.com": {MaxLength: 256},
+	"docker.io":                 {MaxComponents: 2},
+	"*-docker.pkg.dev":          {MinComponents: 3},
+}
+
+// defaultMaxLength is the maximum length of repositories of the distribution spec.
+const defaultMaxLength = 255
+
+// repositoryComponentPattern matches a component of a repository of the distribution spec.
+var repositoryComponentPattern = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*$`)
+
+// LoadRefRules reads a refs file, unknown fields are rejected. An absent file has no rules.
+func LoadRefRules(path string) (*RefRules, error) {
+	rules := &RefRules{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return rules, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read refs file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, rules); err != nil {
+		return nil, fmt.Errorf("could not parse refs file %s: %w", path, err)
+	}
+	if err := rules.validate(); err != nil {
+		return nil, fmt.Errorf("invalid refs file %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+func (r *RefRules) validate() error {
+	if r.DefaultRegistry != "" && (strings.Contains(r.DefaultRegistry, "/") || !IsFullRef(r.DefaultRegistry+"/repo")) {
+		return fmt.Errorf("defaultRegistry %s must be a registry host, e.g. ghcr.io", r.DefaultRegistry)
+	}
+	if r.DefaultNamespace != "" && r.DefaultRegistry == "" {
+		return fmt.Errorf("defaultNamespace requires a defaultRegistry")
+	}
+	for host, rules := range r.Registries {
+		if _, err := path.Match(host, ""); err != nil || host == "" || strings.Contains(host, "/") {
+			return fmt.Errorf("registry %q must be a registry host or a glob of hosts", host)
+		}
+		if err := rules.parse(); err != nil {
+			return fmt.Errorf("template of registry %s: %w", host, err)
+		}
+		if rules.MaxLength < 0 || rules.MinComponents < 0 || rules.MaxComponents < 0 ||
+			(rules.MaxComponents != 0 && rules.MinComponents > rules.MaxComponents) {
+			return fmt.Errorf("invalid naming rules of registry %s", host)
+		}
+		r.Registries[host] = rules
+	}
+	for short, full := range r.Aliases {
+		if IsFullRef(short) {
+			return fmt.Errorf("alias %s is already a full ref", short)
+		}
+		if alias, err := ParseRef(full); err != nil || !alias.IsFull() || !alias.IsRepository() {
+			return fmt.Errorf("alias %s must be a full repository, without tag or digest, not %s", short, full)
+		}
+	}
+	return nil
+}
+
+func (rules *RegistryRules) parse() error {
+	if rules.Template == "" {
+		return nil
+	}
+	tmpl, err := template.New("repository").Option("missingkey=error").Parse(rules.Template)
+	if err != nil {
+		return err
+	}
+	rules.template = tmpl
+	return nil
+}
+
+// ExpandRef returns the full ref of the ref, rewritten with the template of its registry, if
+// any. Refs are returned as is when there are no rules to expand them with.
+// Refs are normalized as ParseRef does, and fail with ErrInvalidRef if malformed.
+func (r *RefRules) ExpandRef(ref string) (string, error) {
+	parsed, err := ParseRef(ref)
+	if err != nil {
+		return "", err
+	}
+	if r == nil {
+		return parsed.String(), nil
+	}
+	full, err := r.expandShortRef(parsed)
+	if err != nil {
+		return "", err
+	}
+	if !full.IsFull() {
+		return full.String(), nil
+	}
+	return r.rewrite(full)
+}
+
+func (r *RefRules) expandShortRef(ref Ref) (Ref, error) {
+	if ref.IsFull() {
+		return ref, nil
+	}
+	if r.Strict {
+		return Ref{}, fmt.Errorf("%s is a short ref, which strict refs forbid: use the full ref, with its registry", ref)
+	}
+	if full, ok := r.Aliases[ref.Repository]; ok {
+		alias, err := ParseRef(full)
+		if err != nil {
+			return Ref{}, fmt.Errorf("alias %s: %w", ref.Repository, err)
+		}
+		alias.Tag, alias.Digest = ref.Tag, ref.Digest
+		return alias, nil
+	}
+	if r.DefaultRegistry == "" {
+		return ref, nil
+	}
+	if !strings.Contains(ref.Repository, "/") && r.DefaultNamespace != "" {
+		ref.Repository = strings.Trim(r.DefaultNamespace, "/") + "/" + ref.Repository
+	}
+	ref.Registry = strings.ToLower(r.DefaultRegistry)
+	return ref, nil
+}
+
+// rewrite rewrites the repository of the full ref with the template of its registry.
+func (r *RefRules) rewrite(parsed Ref) (string, error) {
+	ref, repo := parsed.String(), parsed.Repository
+	rules, pattern, ok := r.registryRules(parsed.Registry)
+	if !ok || rules.Template == "" {
+		return ref, nil
+	}
+	// parsed when loaded, or when the rules are set in Go
+	if rules.template == nil {
+		if err := rules.parse(); err != nil {
+			return "", fmt.Errorf("template of registry %s: %w", pattern, err)
+		}
+	}
+	if prefix := strings.SplitN(rules.Template, "{{", 2)[0]; prefix != "" && strings.HasPrefix(repo, prefix) {
+		return ref, nil
+	}
+	data := struct{ Repository, Name, Namespace string }{Repository: repo, Name: repo}
+	if i := strings.LastIndex(repo, "/"); i >= 0 {
+		data.Namespace, data.Name = repo[:i], repo[i+1:]
+	}
+	var out bytes.Buffer
+	if err := rules.template.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("could not rewrite %s with the template of registry %s: %w", ref, pattern, err)
+	}
+	rewritten := strings.Trim(out.String(), "/")
+	if rewritten == "" {
+		return "", fmt.Errorf("the template of registry %s rewrites %s to an empty repository", pattern, ref)
+	}
+	parsed.Repository = rewritten
+	if err := checkComponents(rewritten, ref); err != nil {
+		return "", fmt.Errorf("the template of registry %s rewrites %s to an invalid repository: %w", pattern, ref, err)
+	}
+	return parsed.String(), nil
+}
+
+// CheckRepository returns an error naming the rule the repository of the ref breaks, the
+// ones of the distribution spec or of its registry, none if the ref is not full.
+func (r *RefRules) CheckRepository(ref string) error {
+	if !IsFullRef(ref) {
+		return nil
+	}
+	parsed, err := ParseRef(ref)
+	if err != nil {
+		return err
+	}
+	host, repo := parsed.Registry, parsed.Repository
+	rules, pattern, _ := matchRegistry(knownRegistries, host)
+	// the rules set in the file take precedence over the known ones
+	if configured, configuredPattern, ok := r.registryRules(host); ok {
+		pattern = configuredPattern
+		if configured.MaxLength != 0 {
+			rules.MaxLength = configured.MaxLength
+		}
+		if configured.MinComponents != 0 {
+			rules.MinComponents = configured.MinComponents
+		}
+		if configured.MaxComponents != 0 {
+			rules.MaxComponents = configured.MaxComponents
+		}
+	}
+
+	components := strings.Split(repo, "/")
+	maxLength := rules.MaxLength
+	if maxLength == 0 {
+		maxLength = defaultMaxLength
+	}
+	if len(repo) > maxLength {
+		return fmt.Errorf("repository %s of %s is %d characters long, over the %d the registry %s allows", repo, ref, len(repo), maxLength, pattern)
+	}
+	if rules.MinComponents != 0 && len(components) < rules.MinComponents {
+		return fmt.Errorf("the registry %s requires repositories of at least %d components, e.g. under a project, not %s: "+
+			"add a template for it to the registries of %s", pattern, rules.MinComponents, repo, RefsFileName)
+	}
+	if rules.MaxComponents != 0 && len(components) > rules.MaxComponents {
+		return fmt.Errorf("the registry %s allows repositories of at most %d components, not %s", pattern, rules.MaxComponents, repo)
+	}
+	return nil
+}
+
+// registryRules returns the rules of the registry, of its host or of the first glob
+// matching it, in order, and the host or glob.
+func (r *RefRules) registryRules(host string) (RegistryRules, string, bool) {
+	if r == nil {
+		return RegistryRules{}, host, false
+	}
+	return matchRegistry(r.Registries, host)
+}
+
+// matchRegistry returns the rules of the host, or of the first glob matching it in the order
+// of the globs.
+func matchRegistry(registries map[string]RegistryRules, host string) (RegistryRules, string, bool) {
+	if host == "registry-1.docker.io" || host == "index.docker.io" {
+		host = "docker.io"
+	}
+	if rules, ok := registries[host]; ok {
+		return rules, host, true
+	}
+	patterns := make([]string, 0, len(registries))
+	for pattern := range registries {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, host); matched {
+			return registries[pattern], pattern, true
+		}
+	}
+	return RegistryRules{}, host, false
+}
+
+// IsFullRef returns whether the first component of the ref is a registry.
+func IsFullRef(ref string) bool {
+	i := strings.Index(ref, "/")
+	if i < 0 {
+		return false
+	}
+	host := ref[:i]
+	return strings.ContainsAny(host, ".:") || host == "localhost"
+}
@@ -0,0 +1,299 @@
+package spec
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes/docker"
+	dockerauth "github.com/containerd/containerd/remotes/docker/auth"
+	remoteerrors "github.com/containerd/containerd/remotes/errors"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/pkg/content"
+)
+
+var (
+	// ErrNotFound is returned when the registry does not have the package of a ref, or its
+	// repository.
+	ErrNotFound = errors.New("the package is not in the registry")
+	// ErrUnauthorized is returned when the registry refuses the credentials of a ref, or
+	// requires credentials it was not given.
+	ErrUnauthorized = errors.New("the registry did not authorize the credentials")
+)
+
+// RegistryError is returned when a registry does not serve a ref, it wraps ErrNotFound or
+// ErrUnauthorized. Registries often deny the refs of missing repositories rather than report
+// them missing, so denials are told apart by probing the authentication of the registry.
+type RegistryError struct {
+	Ref string
+	// HTTP status the registry responded with, 0 if unknown
+	Status int
+	// Reason the error is ErrNotFound or ErrUnauthorized, if any
+	Reason string
+	Err    error
+	// Cause is the error of the request the registry denied
+	Cause error
+}
+
+func (e *RegistryError) Error() string {
+	msg := fmt.Sprintf("%s: %v", e.Ref, e.Err)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+func (e *RegistryError) Unwrap() error {
+	return e.Err
+}
+
+// deniedStatusPattern matches the statuses of the denials the containerd resolver only
+// formats.
+var deniedStatusPattern = regexp.MustCompile(`: (401|403) `)
+
+// notFoundCodes are the error codes of the distribution API meaning the repository or the
+// manifest does not exist.
+var notFoundCodes = map[string]bool{
+	"NAME_UNKNOWN":     true,
+	"MANIFEST_UNKNOWN": true,
+	"NOT_FOUND":        true,
+}
+
+// ClassifyRegistryError returns a RegistryError wrapping ErrNotFound or ErrUnauthorized if
+// the error is the one of a pull of the ref, or a listing of the repository, the registry
+// denied or did not find. Denials are probed: the registry is challenged for a token of the
+// pull scope of the repository, then, if the token does not grant it, for a token of no
+// scope to check the credentials, and the ref is requested again with the token. Other
+// errors are returned as is.
+func ClassifyRegistryError(ctx context.Context, ref string, opts content.RegistryOptions, err error) error {
+	var registryErr *RegistryError
+	if err == nil || errors.As(err, &registryErr) {
+		return err
+	}
+	if errdefs.IsNotFound(err) {
+		return &RegistryError{Ref: ref, Status: http.StatusNotFound, Err: ErrNotFound, Cause: err}
+	}
+	status := denialStatus(err)
+	if status == 0 {
+		return err
+	}
+	api, apiErr := newRegistryAPI(ref, opts)
+	if apiErr != nil {
+		return &RegistryError{Ref: ref, Status: status, Err: ErrUnauthorized, Cause: err}
+	}
+	classified := api.probeDenial(ctx, ref, opts)
+	if classified.Status == 0 {
+		classified.Status = status
+	}
+	classified.Ref = ref
+	classified.Cause = err
+	return classified
+}
+
+// isNotFound returns whether the error is the one of a ref the target does not have, which
+// registries report with errdefs.ErrNotFound, and the stores of oras with their own errors.
+func isNotFound(err error) bool {
+	if errdefs.IsNotFound(err) {
+		return true
+	}
+	msg := err.Error()
+	return strings.HasPrefix(msg, "unknown reference: ") || strings.HasSuffix(msg, " not in store")
+}
+
+// pushError returns a RegistryError wrapping ErrUnauthorized if the registry denied the push
+// of the ref. Registries create the repositories packages are pushed to, so pushes are not
+// probed.
+func pushError(ref string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if status := denialStatus(err); status != 0 {
+		return &RegistryError{Ref: ref, Status: status, Err: ErrUnauthorized, Reason: "the registry refused the push", Cause: err}
+	}
+	return err
+}
+
+// denialStatus returns the 401 or 403 status of the error, 0 if it is not a denial.
+func denialStatus(err error) int {
+	if errors.Is(err, docker.ErrInvalidAuthorization) {
+		return http.StatusUnauthorized
+	}
+	code := 0
+	var unexpected remoteerrors.ErrUnexpectedStatus
+	var status *statusError
+	if errors.As(err, &unexpected) {
+		code = unexpected.StatusCode
+	} else if errors.As(err, &status) {
+		code = status.code
+	} else if m := deniedStatusPattern.FindStringSubmatch(err.Error()); m != nil {
+		fmt.Sscan(m[1], &code)
+	}
+	if code != http.StatusUnauthorized && code != http.StatusForbidden {
+		return 0
+	}
+	return code
+}
+
+// probeDenial classifies the denial of the ref by the registry.
+func (a *registryAPI) probeDenial(ctx context.Context, ref string, opts content.RegistryOptions) *RegistryError {
+	username, secret, _ := registryCredentials(opts)(a.host)
+	hasCreds := secret != ""
+	unauthorized := func(reason string) *RegistryError {
+		return &RegistryError{Err: ErrUnauthorized, Reason: reason}
+	}
+
+	resp, err := a.send(ctx, a.scheme+"://"+a.host+"/v2/", "")
+	if err != nil {
+		return unauthorized(fmt.Sprintf("could not probe the authentication of the registry: %v", err))
+	}
+	resp.Body.Close()
+
+	var authorization string
+	// whether the registry authorized the pulls of the repository, so a denial of the ref
+	// is the one of a missing ref
+	authorized := false
+	challenges := dockerauth.ParseAuthHeader(resp.Header)
+	switch {
+	case len(challenges) > 0 && challenges[0].Scheme == dockerauth.BearerAuth:
+		to, err := dockerauth.GenerateTokenOptions(ctx, a.host, username, secret, challenges[0])
+		if err != nil {
+			return unauthorized(err.Error())
+		}
+		to.Scopes = []string{fmt.Sprintf("repository:%s:pull", a.name)}
+		token, err := dockerauth.FetchToken(ctx, a.client, nil, to)
+		if err != nil {
+			return tokenRefused(err, hasCreds)
+		}
+		granted, known := tokenGrants(token.Token, a.name, "pull")
+		if known && !granted {
+			// retry without scope, as logins do, to tell refused credentials apart
+			to.Scopes = nil
+			if _, err := dockerauth.FetchToken(ctx, a.client, nil, to); err != nil {
+				return tokenRefused(err, hasCreds)
+			}
+			if !hasCreds {
+				return unauthorized("the registry denies anonymous pulls of the repository, which may not exist")
+			}
+			return &RegistryError{Err: ErrNotFound, Reason: "the registry accepted the credentials but not the repository, which does not exist or is not shared with them"}
+		}
+		// opaque tokens are only known to authorize the credentials
+		authorized = granted || hasCreds
+		authorization = "Bearer " + token.Token
+	case len(challenges) > 0 && challenges[0].Scheme == dockerauth.BasicAuth && hasCreds:
+		authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+secret))
+	}
+
+	resp, err = a.send(ctx, a.objectURL(ref), authorization)
+	if err != nil {
+		return unauthorized(fmt.Sprintf("could not probe the ref: %v", err))
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	var errs struct {
+		Errors []struct {
+			Code string `json:"code"`
+		} `json:"errors"`
+	}
+	_ = json.Unmarshal(body, &errs)
+	for _, e := range errs.Errors {
+		if notFoundCodes[e.Code] {
+			return &RegistryError{Status: resp.StatusCode, Err: ErrNotFound, Reason: "the registry reported " + e.Code}
+		}
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return &RegistryError{Status: resp.StatusCode, Err: ErrNotFound}
+	case resp.StatusCode < 300:
+		return unauthorized("the registry serves the ref with a token of the pull scope of the repository, but denied the request")
+	case authorized:
+		return &RegistryError{Status: resp.StatusCode, Err: ErrNotFound, Reason: "the registry authorized the pulls of the repository but denied the ref, which does not exist"}
+	case !hasCreds:
+		return &RegistryError{Status: resp.StatusCode, Err: ErrUnauthorized, Reason: "the registry requires credentials"}
+	default:
+		return &RegistryError{Status: resp.StatusCode, Err: ErrUnauthorized, Reason: "the registry refused the credentials"}
+	}
+}
+
+// tokenRefused returns the error of a token the registry refused to issue.
+func tokenRefused(err error, hasCreds bool) *RegistryError {
+	reason := "the registry refused an anonymous token"
+	if hasCreds {
+		reason = "the registry refused the credentials"
+	}
+	var unexpected remoteerrors.ErrUnexpectedStatus
+	if errors.As(err, &unexpected) {
+		return &RegistryError{Status: unexpected.StatusCode, Err: ErrUnauthorized, Reason: reason}
+	}
+	return &RegistryError{Err: ErrUnauthorized, Reason: fmt.Sprintf("could not fetch a token: %v", err)}
+}
+
+// tokenGrants returns whether the JWT token grants the action on the repository, and whether
+// it is known, opaque tokens granting unknown access.
+func tokenGrants(token, repo, action string) (bool, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return false, false
+	}
+	var claims struct {
+		Access *[]struct {
+			Type    string   `json:"type"`
+			Name    string   `json:"name"`
+			Actions []string `json:"actions"`
+		} `json:"access"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Access == nil {
+		return false, false
+	}
+	for _, access := range *claims.Access {
+		if access.Type != "repository" || access.Name != repo {
+			continue
+		}
+		for _, granted := range access.Actions {
+			if granted == action || granted == "*" {
+				return true, true
+			}
+		}
+	}
+	return false, true
+}
+
+// objectURL returns the URL of the manifest of the ref, or of its tags if the ref is a
+// repository.
+func (a *registryAPI) objectURL(ref string) string {
+	spec, err := reference.Parse(ref)
+	if err != nil || spec.Object == "" {
+		return a.base + "/tags/list"
+	}
+	if dgst := spec.Digest(); dgst != "" {
+		return a.base + "/manifests/" + dgst.String()
+	}
+	return a.base + "/manifests/" + spec.Object
+}
+
+// send gets the URL with the authorization, without answering challenges.
+func (a *registryAPI) send(ctx context.Context, url, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join([]string{ocispec.MediaTypeImageManifest, ocispec.MediaTypeImageIndex}, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return a.client.Do(req)
+}
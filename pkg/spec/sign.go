@@ -0,0 +1,622 @@
+package spec
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/oras"
+	"oras.land/oras-go/pkg/target"
+)
+
+// The cosign signature format: a manifest tagged after the digest of the signed manifest, whose
+// layers are simple signing payloads annotated with their signature, and for keyless signatures
+// with the certificate of the signing key.
+const (
+	simpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	signatureConfigType    = "application/vnd.oci.image.config.v1+json"
+
+	annotationSignature   = "dev.cosignproject.cosign/signature"
+	annotationCertificate = "dev.sigstore.cosign/certificate"
+	annotationChain       = "dev.sigstore.cosign/chain"
+
+	signatureType = "cosign container image signature"
+)
+
+// oidcIssuerOID is the extension of Fulcio certificates recording the OIDC issuer of the identity.
+var oidcIssuerOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+
+var (
+	// ErrUnsigned is returned by verified pulls of packages without signature.
+	ErrUnsigned = errors.New("the package is not signed")
+	// ErrBadSignature is returned by verified pulls of packages whose signatures are invalid,
+	// or were not made by the keys or identity of the verifier.
+	ErrBadSignature = errors.New("the signature of the package is invalid")
+)
+
+// VerificationError is returned when a package fails verification, it wraps ErrUnsigned or
+// ErrBadSignature.
+type VerificationError struct {
+	Ref    string
+	Digest digest.Digest
+	// Reason the signatures were rejected, if any
+	Reason string
+	Err    error
+}
+
+func (e *VerificationError) Error() string {
+	msg := fmt.Sprintf("could not verify %s (%s): %v", e.Ref, e.Digest, e.Err)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+type simpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// VerifierOptions configure the signatures accepted by a Verifier, made either by the key of a
+// public key file, or by the keyless identity of a certificate issued by the roots.
+type VerifierOptions struct {
+	// PEM file of the public key, e.g. the cosign.pub of `cosign generate-key-pair`
+	KeyFile string
+	// PEM file of the certificate authorities issuing the certificates of keyless
+	// signatures, e.g. the roots of Fulcio
+	RootsFile string
+	// Email or URI the certificate must be issued to
+	Identity string
+	// OIDC issuer which authenticated the identity, e.g. https://token.actions.githubusercontent.com
+	Issuer string
+	// Public key, e.g. of a KMS key, rather than the one of KeyFile
+	Key crypto.PublicKey
+
+	// Trust policy file of notation, whose registry scopes are verified with notation
+	// signatures rather than cosign ones, e.g. DefaultTrustPolicyFile()
+	TrustPolicyFile string
+	// Trust store of the certificates of the trust policy, DefaultTrustStoreDir() if empty
+	TrustStoreDir string
+
+	// Cache of the packages already verified, skipping their verification, if set
+	Cache *VerificationCache
+}
+
+// Verifier verifies the cosign signatures of packages. Keyless signatures are verified against
+// the roots, identity and issuer of their certificate, but not against a transparency log.
+// The packages of the registry scopes of its trust policies are verified with their notation
+// signatures instead.
+type Verifier struct {
+	key      crypto.PublicKey
+	roots    *x509.CertPool
+	identity string
+	issuer   string
+	policies *TrustPolicyDocument
+	cache    *VerificationCache
+	// hash of the key, or of the roots, identity and issuer
+	fingerprint []byte
+}
+
+// NewVerifier returns a verifier of the signatures made by the key or the keyless identity of
+// the options.
+func NewVerifier(opts VerifierOptions) (*Verifier, error) {
+	var policies *TrustPolicyDocument
+	if opts.TrustPolicyFile != "" {
+		var err error
+		if policies, err = LoadTrustPolicy(opts.TrustPolicyFile, opts.TrustStoreDir); err != nil {
+			return nil, err
+		}
+		if opts.KeyFile == "" && opts.RootsFile == "" && opts.Key == nil {
+			return &Verifier{policies: policies, cache: opts.Cache}, nil
+		}
+	}
+	v, err := newCosignVerifier(opts)
+	if err != nil {
+		return nil, err
+	}
+	v.policies = policies
+	v.cache = opts.Cache
+	return v, nil
+}
+
+func newCosignVerifier(opts VerifierOptions) (*Verifier, error) {
+	if opts.Key != nil {
+		if opts.KeyFile != "" || opts.RootsFile != "" {
+			return nil, fmt.Errorf("signatures must be verified with either a public key or the roots of keyless certificates")
+		}
+		return NewKeyVerifier(opts.Key), nil
+	}
+	if (opts.KeyFile == "") == (opts.RootsFile == "") {
+		return nil, fmt.Errorf("signatures must be verified with either a public key or the roots of keyless certificates")
+	}
+	if opts.KeyFile != "" {
+		data, err := ioutil.ReadFile(opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s is not a PEM file", opts.KeyFile)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the public key of %s: %w", opts.KeyFile, err)
+		}
+		return NewKeyVerifier(key), nil
+	}
+
+	if opts.Identity == "" || opts.Issuer == "" {
+		return nil, fmt.Errorf("keyless signatures must be verified with an identity and an issuer")
+	}
+	data, err := ioutil.ReadFile(opts.RootsFile)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s has no PEM certificates", opts.RootsFile)
+	}
+	h := sha256.New()
+	for _, field := range []string{"roots", string(data), opts.Identity, opts.Issuer} {
+		fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+	return &Verifier{roots: roots, identity: opts.Identity, issuer: opts.Issuer, fingerprint: h.Sum(nil)}, nil
+}
+
+// NewKeyVerifier returns a verifier of the signatures made by the key, e.g. read from a KMS.
+func NewKeyVerifier(key crypto.PublicKey) *Verifier {
+	h := sha256.New()
+	h.Write([]byte("key:"))
+	if der, err := x509.MarshalPKIXPublicKey(key); err == nil {
+		h.Write(der)
+	} else {
+		fmt.Fprintf(h, "%#v", key)
+	}
+	return &Verifier{key: key, fingerprint: h.Sum(nil)}
+}
+
+// policyHash returns the hash of the keys or trust policy the packages of the ref are verified
+// with, the verifications cached under another hash being made again.
+func (v *Verifier) policyHash(ref string) string {
+	h := sha256.New()
+	if v.policies != nil {
+		if policy := v.policies.PolicyFor(repository(ref)); policy != nil {
+			h.Write([]byte("notation:"))
+			h.Write(policy.hash)
+			return fmt.Sprintf("%x", h.Sum(nil))
+		}
+	}
+	h.Write([]byte("cosign:"))
+	h.Write(v.fingerprint)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// Verify checks a signature of the manifest of the digest was stored in the registry, next to
+// the image of the ref. With a cache, the manifests verified within its TTL by the same keys or
+// trust policy are not verified again.
+func (v *Verifier) Verify(ctx context.Context, registry target.Target, ref string, dgst digest.Digest) error {
+	if v.cache == nil {
+		return v.verify(ctx, registry, ref, dgst)
+	}
+	policyHash := v.policyHash(ref)
+	if v.cache.verified(dgst, policyHash) {
+		return nil
+	}
+	if err := v.verify(ctx, registry, ref, dgst); err != nil {
+		return err
+	}
+	// a cache that can't be written only slows the next pulls down
+	_ = v.cache.add(dgst, policyHash)
+	return nil
+}
+
+func (v *Verifier) verify(ctx context.Context, registry target.Target, ref string, dgst digest.Digest) error {
+	if v.policies != nil {
+		if policy := v.policies.PolicyFor(repository(ref)); policy != nil {
+			return verifyNotation(ctx, registry, ref, dgst, policy)
+		}
+		if v.key == nil && v.roots == nil {
+			return &VerificationError{Ref: ref, Digest: dgst, Err: ErrUnsigned, Reason: "no trust policy applies to " + repository(ref)}
+		}
+	}
+	sigRef := SignatureRef(ref, dgst)
+	store := content.NewMemory()
+	_, err := oras.Copy(
+		ctx,
+		registry,
+		sigRef,
+		store,
+		"",
+		oras.WithAllowedMediaTypes([]string{simpleSigningMediaType, signatureConfigType}),
+	)
+	if err != nil {
+		if !isNotFound(err) {
+			return fmt.Errorf("could not fetch the signature of %s: %w", ref, err)
+		}
+		return &VerificationError{Ref: ref, Digest: dgst, Err: ErrUnsigned}
+	}
+	_, manifestDesc, err := store.Resolve(ctx, sigRef)
+	if err != nil {
+		return &VerificationError{Ref: ref, Digest: dgst, Err: ErrUnsigned}
+	}
+	_, manifestBytes, ok := store.Get(manifestDesc)
+	if !ok {
+		return &VerificationError{Ref: ref, Digest: dgst, Err: ErrUnsigned}
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return &VerificationError{Ref: ref, Digest: dgst, Err: ErrBadSignature, Reason: err.Error()}
+	}
+
+	var reasons []string
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != simpleSigningMediaType {
+			continue
+		}
+		_, payload, ok := store.Get(layer)
+		if !ok {
+			reasons = append(reasons, "missing payload")
+			continue
+		}
+		if err := v.verifyLayer(layer, payload, dgst); err != nil {
+			reasons = append(reasons, err.Error())
+			continue
+		}
+		return nil
+	}
+	if len(reasons) == 0 {
+		return &VerificationError{Ref: ref, Digest: dgst, Err: ErrUnsigned}
+	}
+	return &VerificationError{Ref: ref, Digest: dgst, Err: ErrBadSignature, Reason: strings.Join(reasons, "; ")}
+}
+
+func (v *Verifier) verifyLayer(layer ocispec.Descriptor, payload []byte, dgst digest.Digest) error {
+	sig, err := base64.StdEncoding.DecodeString(layer.Annotations[annotationSignature])
+	if err != nil || len(sig) == 0 {
+		return fmt.Errorf("missing signature")
+	}
+
+	key := v.key
+	if v.roots != nil {
+		cert, err := v.verifyCertificate(layer.Annotations[annotationCertificate], layer.Annotations[annotationChain])
+		if err != nil {
+			return err
+		}
+		key = cert.PublicKey
+	}
+	if err := verifySignature(key, payload, sig); err != nil {
+		return err
+	}
+
+	var signed simpleSigning
+	if err := json.Unmarshal(payload, &signed); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	if signed.Critical.Image.DockerManifestDigest != dgst.String() {
+		return fmt.Errorf("the signature is of %s", signed.Critical.Image.DockerManifestDigest)
+	}
+	return nil
+}
+
+// verifyCertificate verifies the certificate of a keyless signature was issued by the roots
+// to the identity. Certificates only live for the time of the signature, so they are verified
+// at the time they were issued.
+func (v *Verifier) verifyCertificate(certPEM, chainPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return nil, fmt.Errorf("missing certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM([]byte(chainPEM))
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		CurrentTime:   cert.NotBefore,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return nil, fmt.Errorf("untrusted certificate: %w", err)
+	}
+
+	identities := append([]string{}, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	found := false
+	for _, identity := range identities {
+		found = found || identity == v.identity
+	}
+	if !found {
+		return nil, fmt.Errorf("the certificate is issued to %s", strings.Join(identities, ", "))
+	}
+	issuer := ""
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidcIssuerOID) {
+			issuer = string(ext.Value)
+		}
+	}
+	if issuer != v.issuer {
+		return nil, fmt.Errorf("the identity of the certificate is issued by %q", issuer)
+	}
+	return cert, nil
+}
+
+func verifySignature(key crypto.PublicKey, payload, sig []byte) error {
+	hash := sha256.Sum256(payload)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hash[:], sig) {
+			return fmt.Errorf("the signature was not made by the key")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig); err != nil {
+			return fmt.Errorf("the signature was not made by the key")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, payload, sig) {
+			return fmt.Errorf("the signature was not made by the key")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	return nil
+}
+
+// SignatureRef returns the ref the cosign signature of the manifest of the digest is tagged
+// with, in the repository of the ref, e.g. repo:sha256-4f9a....sig
+func SignatureRef(ref string, dgst digest.Digest) string {
+	return repository(ref) + ":" + dgst.Algorithm().String() + "-" + dgst.Encoded() + ".sig"
+}
+
+// IsSignatureRef reports whether the ref is the tag of a signature rather than of a package,
+// either cosign or notation signatures.
+func IsSignatureRef(ref string) bool {
+	_, tag, ok := splitTag(ref)
+	if !ok {
+		return false
+	}
+	if strings.HasSuffix(tag, ".sig") && strings.Contains(tag, "-") {
+		return true
+	}
+	// the tag of the referrers of a digest, e.g. sha256-4f9a...
+	dgst := digest.Digest(strings.Replace(tag, "-", ":", 1))
+	return dgst.Validate() == nil
+}
+
+// repository returns the ref without its tag or digest.
+func repository(ref string) string {
+	if parsed, err := ParseRef(ref); err == nil {
+		return parsed.Name()
+	}
+	if i := strings.LastIndex(ref, "@"); i >= 0 {
+		return ref[:i]
+	}
+	if repo, _, ok := splitTag(ref); ok {
+		return repo
+	}
+	return ref
+}
+
+// Sign stores a cosign signature of the image of the ref in the registry, signed by the key.
+func Sign(ctx context.Context, ref string, registry target.Target, key crypto.Signer) error {
+	return SignWithCertificate(ctx, ref, registry, key, nil, nil)
+}
+
+// SignWithCertificate stores a keyless signature of the image, signed by the ephemeral key of
+// the PEM certificate, e.g. issued by Fulcio, and of its PEM chain of intermediates.
+func SignWithCertificate(ctx context.Context, ref string, registry target.Target, key crypto.Signer, cert, chain []byte) error {
+	_, desc, err := registry.Resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	var payload simpleSigning
+	payload.Critical.Identity.DockerReference = repository(ref)
+	payload.Critical.Image.DockerManifestDigest = desc.Digest.String()
+	payload.Critical.Type = signatureType
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	sig, err := signPayload(key, payloadBytes)
+	if err != nil {
+		return err
+	}
+
+	store := content.NewMemory()
+	layer, err := store.Add("", simpleSigningMediaType, payloadBytes)
+	if err != nil {
+		return err
+	}
+	layer.Annotations = map[string]string{annotationSignature: base64.StdEncoding.EncodeToString(sig)}
+	if len(cert) > 0 {
+		layer.Annotations[annotationCertificate] = string(cert)
+	}
+	if len(chain) > 0 {
+		layer.Annotations[annotationChain] = string(chain)
+	}
+	configBytes := []byte("{}")
+	configDesc := ocispec.Descriptor{
+		MediaType: signatureConfigType,
+		Digest:    digest.FromBytes(configBytes),
+		Size:      int64(len(configBytes)),
+	}
+	store.Set(configDesc, configBytes)
+	manifest, manifestDesc, err := content.GenerateManifest(&configDesc, nil, layer)
+	if err != nil {
+		return err
+	}
+	sigRef := SignatureRef(ref, desc.Digest)
+	if err := store.StoreManifest(sigRef, manifestDesc, manifest); err != nil {
+		return err
+	}
+
+	_, err = oras.Copy(
+		ctx,
+		store,
+		sigRef,
+		registry,
+		"",
+		oras.WithAllowedMediaTypes([]string{simpleSigningMediaType, signatureConfigType}),
+	)
+	return err
+}
+
+// PushSigned pushes the package and its signature.
+func PushSigned(ctx context.Context, client EbpfOCICLient, ref string, registry target.Target, pkg *v1.EbpfPackage, key crypto.Signer) error {
+	if err := client.Push(ctx, ref, registry, pkg); err != nil {
+		return err
+	}
+	return Sign(ctx, ref, registry, key)
+}
+
+func signPayload(key crypto.Signer, payload []byte) ([]byte, error) {
+	if _, ok := key.Public().(ed25519.PublicKey); ok {
+		return key.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	hash := sha256.Sum256(payload)
+	return key.Sign(rand.Reader, hash[:], crypto.SHA256)
+}
+
+// encryptedKey is the encrypted private key of `cosign generate-key-pair`.
+type encryptedKey struct {
+	KDF struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// LoadSigningKey parses a PEM private key, either a PKCS#8 or EC key or an encrypted cosign
+// key, decrypted with the password.
+func LoadSigningKey(data, password []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("the key is not a PEM file")
+	}
+	der := block.Bytes
+	switch block.Type {
+	case "ENCRYPTED COSIGN PRIVATE KEY", "ENCRYPTED SIGSTORE PRIVATE KEY":
+		var enc encryptedKey
+		if err := json.Unmarshal(block.Bytes, &enc); err != nil {
+			return nil, fmt.Errorf("invalid encrypted key: %w", err)
+		}
+		if enc.KDF.Name != "scrypt" || enc.Cipher.Name != "nacl/secretbox" || len(enc.Cipher.Nonce) != 24 {
+			return nil, fmt.Errorf("unsupported key encryption %s, %s", enc.KDF.Name, enc.Cipher.Name)
+		}
+		secret, err := scrypt.Key(password, enc.KDF.Salt, enc.KDF.Params.N, enc.KDF.Params.R, enc.KDF.Params.P, 32)
+		if err != nil {
+			return nil, err
+		}
+		var key [32]byte
+		var nonce [24]byte
+		copy(key[:], secret)
+		copy(nonce[:], enc.Cipher.Nonce)
+		decrypted, ok := secretbox.Open(nil, enc.Ciphertext, &nonce, &key)
+		if !ok {
+			return nil, fmt.Errorf("could not decrypt the key, wrong password")
+		}
+		der = decrypted
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(der)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
+
+// StoreSignature copies the signatures of the manifest of the digest, cosign and notation
+// ones, from the registry to the target, so the package can be verified without the registry.
+// It returns ErrUnsigned if the registry has no signature of the manifest.
+func StoreSignature(ctx context.Context, ref string, dgst digest.Digest, registry, to target.Target) error {
+	stored, err := copySignatures(ctx, registry, ref, to, ref, dgst)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return ErrUnsigned
+	}
+	return nil
+}
+
+// copySignatures copies the cosign and notation signatures of the manifest of the digest, next
+// to the image of the ref, to the repository of toRef in the other registry, and returns
+// whether there were any. Signatures already copied are not copied again.
+func copySignatures(ctx context.Context, from target.Target, ref string, to target.Target, toRef string, dgst digest.Digest) (bool, error) {
+	var stored bool
+	for _, sig := range []struct {
+		ref, toRef string
+		mediaTypes []string
+	}{
+		{SignatureRef(ref, dgst), SignatureRef(toRef, dgst), []string{simpleSigningMediaType, signatureConfigType}},
+		{NotationRef(ref, dgst), NotationRef(toRef, dgst), []string{notationArtifactType, jwsMediaType}},
+	} {
+		_, desc, err := from.Resolve(ctx, sig.ref)
+		if err != nil {
+			continue
+		}
+		stored = true
+		if _, existing, err := to.Resolve(ctx, sig.toRef); err == nil && existing.Digest == desc.Digest {
+			continue
+		}
+		toRef := sig.toRef
+		if toRef == sig.ref {
+			// oras tags the ref itself
+			toRef = ""
+		}
+		if _, err := oras.Copy(ctx, from, sig.ref, to, toRef, oras.WithAllowedMediaTypes(sig.mediaTypes)); err != nil {
+			return false, err
+		}
+	}
+	return stored, nil
+}
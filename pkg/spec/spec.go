@@ -5,145 +5,370 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	v1 "github.com/solo-io/bumblebee/api/v1"
 	"oras.land/oras-go/pkg/content"
 	"oras.land/oras-go/pkg/oras"
 	"oras.land/oras-go/pkg/target"
 )
 
+// The v1 media types, deprecated by MediaTypeV2.
 const (
 	configMediaType = "application/ebpf.oci.image.config.v1+json"
 	eBPFMediaType   = "application/ebpf.oci.image.program.v1+binary"
+	objectMediaType = "application/ebpf.oci.image.object.v1+binary"
+	btfMediaType    = "application/ebpf.oci.image.btf.v1+binary"
+	sourceMediaType = "application/ebpf.oci.image.source.v1.tar+gzip"
+	// only written with ClientOptions.LegacyMediaTypes, fixtures postdating the v2 media types
+	fixturesMediaType = "application/ebpf.oci.image.fixtures.v1.tar+gzip"
 
-	ebpfFileName = "program.o"
-	configName   = "config.json"
-)
+	ebpfFileName     = "program.o"
+	configName       = "config.json"
+	btfFileName      = "program.btf"
+	sourceFileName   = "source.tar.gz"
+	fixturesFileName = "fixtures.tar.gz"
 
-type EbpfPackage struct {
-	// File content for eBPF compiled ELF file
-	ProgramFileBytes []byte
-	// Human readable description of the program
-	Description string
-	// Author(s) of the program
-	Authors string
-	// Platform this was built on
-	Platform *ocispec.Platform
-	// Nested config object
-	EbpfConfig
-}
+	// AnnotationObjectName records the name of an ELF object of a package, its layer being
+	// titled <name>.o
+	AnnotationObjectName = "io.solo.bumblebee.object.name"
 
-type EbpfConfig struct{}
+	// AnnotationBuilderImage records the image, pinned by digest, the program was compiled in
+	AnnotationBuilderImage = "io.solo.bumblebee.builder.image"
+)
+
+type (
+	// Deprecated: use v1.EbpfPackage
+	EbpfPackage = v1.EbpfPackage
+	// Deprecated: use v1.EbpfConfig
+	EbpfConfig = v1.EbpfConfig
+)
 
 type EbpfOCICLient interface {
-	Push(ctx context.Context, ref string, registry target.Target, pkg *EbpfPackage) error
-	Pull(ctx context.Context, ref string, registry target.Target) (*EbpfPackage, error)
+	Push(ctx context.Context, ref string, registry target.Target, pkg *v1.EbpfPackage) error
+	// PushMultiArch pushes an image index referencing a package per architecture,
+	// the architecture of each package is taken from its Platform.
+	PushMultiArch(ctx context.Context, ref string, registry target.Target, pkgs []*v1.EbpfPackage) error
+	// PushVariants pushes an image index referencing a package per architecture and minimum
+	// kernel release, annotated with AnnotationMinKernelVersion.
+	PushVariants(ctx context.Context, ref string, registry target.Target, variants []v1.EbpfPackageVariant) error
+	// Pull pulls the package, selecting the one of the host from multi-variant images.
+	Pull(ctx context.Context, ref string, registry target.Target) (*v1.EbpfPackage, error)
+	// List returns the tags of the repository, e.g. ghcr.io/solo-io/bumblebee/tcpconnect.
+	List(ctx context.Context, repoRef string, registry target.Target) ([]string, error)
+	// Inspect returns the manifest and config of the package, without pulling its programs.
+	Inspect(ctx context.Context, ref string, registry target.Target) (*v1.EbpfPackageInfo, error)
 }
 
 func NewEbpfOCICLient() EbpfOCICLient {
 	return &ebpfOCIClient{}
 }
 
-type ebpfOCIClient struct{}
+// NewEbpfOCICLientFor returns a client pulling the packages selected by the selector from
+// multi-variant images, rather than the ones of the host.
+func NewEbpfOCICLientFor(selector VariantSelector) EbpfOCICLient {
+	return NewEbpfOCICLientWith(ClientOptions{Variant: selector})
+}
+
+// ClientOptions configure the pulls of an EbpfOCICLient.
+type ClientOptions struct {
+	// Variant pulled from multi-variant images, the one of the host by default
+	Variant VariantSelector
+	// Verifier of the signatures of the pulled packages, pulls of packages it can't verify
+	// fail with a VerificationError. Packages are not verified if nil.
+	Verifier *Verifier
+	// Credentials the tags of remote repositories are listed with
+	Auth content.RegistryOptions
+	// Progress and concurrency of the transfers of the blobs of pushes and pulls
+	Transfer TransferOptions
+	// Push the deprecated v1 media types rather than MediaTypeV2, for the registries still
+	// pulled by bee releases only reading them
+	LegacyMediaTypes bool
+	// Transforms applied to the pulled packages before they are returned
+	Transforms []Transform
+}
+
+// NewEbpfOCICLientWith returns a client pulling packages with the given options.
+func NewEbpfOCICLientWith(opts ClientOptions) EbpfOCICLient {
+	return &ebpfOCIClient{
+		selector:   opts.Variant,
+		verifier:   opts.Verifier,
+		auth:       opts.Auth,
+		transfer:   opts.Transfer,
+		legacy:     opts.LegacyMediaTypes,
+		transforms: opts.Transforms,
+	}
+}
 
+type ebpfOCIClient struct {
+	selector   VariantSelector
+	verifier   *Verifier
+	auth       content.RegistryOptions
+	transfer   TransferOptions
+	legacy     bool
+	transforms []Transform
+}
+
+// AllowedMediaTypes returns the media types of the blobs of packages, both the v1 and the v2
+// ones.
 func AllowedMediaTypes() []string {
-	return []string{eBPFMediaType, configMediaType}
+	return allowedMediaTypes()
 }
 
 func (e *ebpfOCIClient) Push(
 	ctx context.Context,
 	ref string,
 	registry target.Target,
-	pkg *EbpfPackage,
+	pkg *v1.EbpfPackage,
 ) error {
-
+	ref, err := normalizeRef(ref)
+	if err != nil {
+		return err
+	}
 	memoryStore := content.NewMemory()
 
-	progDesc, err := memoryStore.Add(ebpfFileName, eBPFMediaType, pkg.ProgramFileBytes)
+	manifestDesc, manifest, err := storePackage(memoryStore, pkg, e.legacy)
 	if err != nil {
 		return err
 	}
 
-	configByt, err := json.Marshal(pkg.EbpfConfig)
+	err = memoryStore.StoreManifest(ref, manifestDesc, manifest)
 	if err != nil {
 		return err
 	}
 
-	configDesc, err := buildConfigDescriptor(configByt, nil)
+	_, err = Copy(ctx, memoryStore, ref, registry, e.transfer)
+	return err
+}
+
+// storePackage adds the blobs of the package to the store, of the v1 media types if legacy,
+// and returns its manifest.
+func storePackage(memoryStore *content.Memory, pkg *v1.EbpfPackage, legacy bool) (ocispec.Descriptor, []byte, error) {
+	progDesc, err := memoryStore.Add(ebpfFileName, layerMediaType(SubtypeProgram, packageArch(pkg), legacy), pkg.ProgramFileBytes)
 	if err != nil {
-		return err
+		return ocispec.Descriptor{}, nil, err
+	}
+	return storeLayers(memoryStore, progDesc, pkg, legacy)
+}
+
+// packageArch returns the architecture of the media types of the blobs of the package.
+func packageArch(pkg *v1.EbpfPackage) string {
+	if pkg.Platform == nil {
+		return ""
+	}
+	return archOf(pkg.Platform.Architecture)
+}
+
+// storeLayers adds the blobs of the package but its program, of the given descriptor, to the
+// store, and returns its manifest.
+func storeLayers(memoryStore *content.Memory, progDesc ocispec.Descriptor, pkg *v1.EbpfPackage, legacy bool) (ocispec.Descriptor, []byte, error) {
+	arch := packageArch(pkg)
+
+	configByt, err := json.Marshal(pkg.EbpfConfig)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+
+	configDesc, err := buildConfigDescriptor(configByt, nil, layerMediaType(SubtypeConfig, arch, legacy))
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
 	}
 
 	memoryStore.Set(configDesc, configByt)
 
+	// the main program stays the first layer, so older clients only pulling program.o still
+	// pull the package, skipping the layers they don't know
+	layers := []ocispec.Descriptor{progDesc}
+	names := map[string]bool{}
+	for _, obj := range pkg.Objects {
+		if obj.Name == "" || strings.ContainsAny(obj.Name, "/\\") {
+			return ocispec.Descriptor{}, nil, fmt.Errorf("invalid object name %q", obj.Name)
+		}
+		if names[obj.Name] || obj.Name+".o" == ebpfFileName {
+			return ocispec.Descriptor{}, nil, fmt.Errorf("more than one object is named %s", obj.Name)
+		}
+		names[obj.Name] = true
+		desc, err := memoryStore.Add(obj.Name+".o", layerMediaType(SubtypeObject, arch, legacy), obj.Bytes)
+		if err != nil {
+			return ocispec.Descriptor{}, nil, err
+		}
+		for k, v := range obj.Annotations {
+			desc.Annotations[k] = v
+		}
+		desc.Annotations[AnnotationObjectName] = obj.Name
+		layers = append(layers, desc)
+	}
+	if len(pkg.BTF) > 0 {
+		desc, err := memoryStore.Add(btfFileName, layerMediaType(SubtypeBTF, arch, legacy), pkg.BTF)
+		if err != nil {
+			return ocispec.Descriptor{}, nil, err
+		}
+		layers = append(layers, desc)
+	}
+	if len(pkg.Source) > 0 {
+		desc, err := memoryStore.Add(sourceFileName, layerMediaType(SubtypeSource, arch, legacy), pkg.Source)
+		if err != nil {
+			return ocispec.Descriptor{}, nil, err
+		}
+		layers = append(layers, desc)
+	}
+	if len(pkg.Fixtures) > 0 {
+		fixtures, err := encodeFixtures(pkg.Fixtures)
+		if err != nil {
+			return ocispec.Descriptor{}, nil, err
+		}
+		desc, err := memoryStore.Add(fixturesFileName, layerMediaType(SubtypeFixtures, arch, legacy), fixtures)
+		if err != nil {
+			return ocispec.Descriptor{}, nil, err
+		}
+		layers = append(layers, desc)
+	}
+
 	manifestAnnotations := make(map[string]string)
-	if pkg.Authors != "" {
-		manifestAnnotations[ocispec.AnnotationAuthors] = pkg.Authors
+	authors := pkg.Authors
+	if authors == "" {
+		var formatted []string
+		for _, a := range pkg.EbpfConfig.Authors {
+			formatted = append(formatted, a.String())
+		}
+		authors = strings.Join(formatted, ", ")
+	}
+	if authors != "" {
+		manifestAnnotations[ocispec.AnnotationAuthors] = authors
 	}
 	if pkg.Description != "" {
 		manifestAnnotations[ocispec.AnnotationDescription] = pkg.Description
 	}
+	if pkg.BuilderImage != "" {
+		manifestAnnotations[AnnotationBuilderImage] = pkg.BuilderImage
+	}
+	if pkg.MinKernelVersion != "" {
+		manifestAnnotations[AnnotationMinKernelVersion] = pkg.MinKernelVersion
+	}
 
-	manifest, manifestDesc, err := content.GenerateManifest(
-		&configDesc,
+	manifest, manifestDesc, err := generateManifest(
+		configDesc,
 		manifestAnnotations,
-		progDesc,
+		layers,
 	)
 	if err != nil {
-		return err
+		return ocispec.Descriptor{}, nil, err
 	}
 
 	manifestDesc.Platform = pkg.Platform
+	return manifestDesc, manifest, nil
+}
 
-	err = memoryStore.StoreManifest(ref, manifestDesc, manifest)
+// generateManifest generates the manifest of the layers, like content.GenerateManifest but
+// keeping the layers in order, as content.GenerateManifest sorts them by digest.
+func generateManifest(
+	configDesc ocispec.Descriptor,
+	annotations map[string]string,
+	layers []ocispec.Descriptor,
+) ([]byte, ocispec.Descriptor, error) {
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned:   specs.Versioned{SchemaVersion: 2},
+		Config:      configDesc,
+		Layers:      layers,
+		Annotations: annotations,
+	})
 	if err != nil {
-		return err
+		return nil, ocispec.Descriptor{}, err
 	}
+	return manifest, ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(manifest),
+		Size:      int64(len(manifest)),
+	}, nil
+}
 
-	_, err = oras.Copy(
-		ctx,
-		memoryStore,
-		ref,
-		registry,
-		"",
-		oras.WithAllowedMediaTypes(AllowedMediaTypes()),
-		oras.WithPullByBFS,
-	)
+func (e *ebpfOCIClient) Pull(
+	ctx context.Context,
+	ref string,
+	registry target.Target) (*v1.EbpfPackage, error) {
+	ref, err := normalizeRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	_, rootDesc, err := registry.Resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	var variantDesc *ocispec.Descriptor
+	if rootDesc.MediaType == ocispec.MediaTypeImageIndex {
+		variantDesc, err = selectVariant(ctx, registry, ref, rootDesc, e.selector)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if e.verifier != nil {
+		if err := e.verify(ctx, registry, ref, rootDesc, variantDesc); err != nil {
+			return nil, err
+		}
+	}
+	// the package verified is pulled, even if the tag moved since it was resolved
+	pkg, err := pullManifest(ctx, e.transfer.track(registry), ref, rootDesc.Digest, variantDesc)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyTransforms(ctx, ref, pkg, e.transforms); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// verify verifies the signature of the image, or of the selected package of an image index,
+// as either may be signed.
+func (e *ebpfOCIClient) verify(
+	ctx context.Context,
+	registry target.Target,
+	ref string,
+	rootDesc ocispec.Descriptor,
+	variantDesc *ocispec.Descriptor,
+) error {
+	err := e.verifier.Verify(ctx, registry, ref, rootDesc.Digest)
+	if variantDesc != nil && errors.Is(err, ErrUnsigned) {
+		return e.verifier.Verify(ctx, registry, ref, variantDesc.Digest)
+	}
 	return err
 }
 
-func (e *ebpfOCIClient) Pull(
+// pullManifest pulls the package of the ref, or of the manifest of its image index if set.
+// It fails if the ref no longer resolves to the root digest, unless empty, e.g. once the
+// package of the digest was verified.
+func pullManifest(
 	ctx context.Context,
+	registry target.Target,
 	ref string,
-	registry target.Target) (*EbpfPackage, error) {
+	rootDigest digest.Digest,
+	archDesc *ocispec.Descriptor,
+) (*v1.EbpfPackage, error) {
 	memoryStore := content.NewMemory()
 
+	copyOpts := []oras.CopyOpt{oras.WithAllowedMediaTypes(AllowedMediaTypes())}
+	if archDesc != nil {
+		copyOpts = append(copyOpts, oras.WithPullBaseHandler(skipOtherManifests(archDesc.Digest)))
+	}
+
 	manifestDesc, err := oras.Copy(
 		ctx,
 		registry,
 		ref,
 		memoryStore,
 		"",
-		oras.WithAllowedMediaTypes(AllowedMediaTypes()),
+		copyOpts...,
 	)
 	if err != nil {
 		return nil, err
 	}
-
-	_, ebpfBytes, ok := memoryStore.GetByName(ebpfFileName)
-	if !ok {
-		return nil, errors.New("could not find ebpf bytes in manifest")
+	if rootDigest != "" && manifestDesc.Digest != rootDigest {
+		return nil, fmt.Errorf("%s changed while it was pulled, from %s to %s", ref, rootDigest, manifestDesc.Digest)
 	}
-
-	_, configBytes, ok := memoryStore.GetByName(configName)
-	if !ok {
-		return nil, errors.New("could not find ebpf bytes in manifest")
-	}
-
-	var cfg EbpfConfig
-	if err := json.Unmarshal(configBytes, &cfg); err != nil {
-		return nil, err
+	if archDesc != nil {
+		manifestDesc = *archDesc
 	}
 
 	_, manifestBytes, ok := memoryStore.Get(manifestDesc)
@@ -155,20 +380,101 @@ func (e *ebpfOCIClient) Pull(
 	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
 		return nil, fmt.Errorf("could not unmarshal manifest bytes: %w", err)
 	}
+	if !isPackageManifest(manifest) {
+		return nil, fmt.Errorf("%s is not an eBPF package, its config is a %s", ref, manifest.Config.MediaType)
+	}
 
-	return &EbpfPackage{
+	// the packages pushed with other tools have a foreign config, read as an empty one
+	var cfg v1.EbpfConfig
+	if subtype, _ := mediaTypeSubtype(manifest.Config.MediaType); subtype == SubtypeConfig {
+		_, configBytes, ok := memoryStore.Get(manifest.Config)
+		if !ok {
+			return nil, errors.New("could not find config in manifest")
+		}
+		if err := json.Unmarshal(configBytes, &cfg); err != nil {
+			return nil, err
+		}
+	}
+	return buildPackage(manifestDesc, manifest, cfg, func(layer ocispec.Descriptor) ([]byte, error) {
+		_, byt, ok := memoryStore.Get(layer)
+		if !ok {
+			return nil, fmt.Errorf("could not find layer %s of manifest", layer.Digest)
+		}
+		return byt, nil
+	})
+}
+
+// buildPackage returns the package of the manifest and its config, reading its layers with
+// the given function.
+func buildPackage(
+	manifestDesc ocispec.Descriptor,
+	manifest ocispec.Manifest,
+	cfg v1.EbpfConfig,
+	readLayer func(ocispec.Descriptor) ([]byte, error),
+) (*v1.EbpfPackage, error) {
+	progDesc, ok := programLayer(manifest)
+	if !ok {
+		return nil, errors.New("could not find ebpf bytes in manifest")
+	}
+	ebpfBytes, err := readLayer(progDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := &v1.EbpfPackage{
 		ProgramFileBytes: ebpfBytes,
 		Description:      manifest.Annotations[ocispec.AnnotationDescription],
 		Authors:          manifest.Annotations[ocispec.AnnotationAuthors],
+		BuilderImage:     manifest.Annotations[AnnotationBuilderImage],
+		MinKernelVersion: manifest.Annotations[AnnotationMinKernelVersion],
 		EbpfConfig:       cfg,
 		Platform:         manifestDesc.Platform,
-	}, nil
+		Digest:           manifestDesc.Digest,
+	}
+	// images pushed before the package had more than one layer only have program.o
+	for _, layer := range manifest.Layers {
+		subtype, _ := mediaTypeSubtype(layer.MediaType)
+		if subtype != SubtypeObject && subtype != SubtypeBTF && subtype != SubtypeSource && subtype != SubtypeFixtures {
+			continue
+		}
+		byt, err := readLayer(layer)
+		if err != nil {
+			return nil, err
+		}
+		switch subtype {
+		case SubtypeObject:
+			name := layer.Annotations[AnnotationObjectName]
+			if name == "" {
+				name = strings.TrimSuffix(layer.Annotations[ocispec.AnnotationTitle], ".o")
+			}
+			annotations := map[string]string{}
+			for k, v := range layer.Annotations {
+				if k != ocispec.AnnotationTitle && k != AnnotationObjectName {
+					annotations[k] = v
+				}
+			}
+			if len(annotations) == 0 {
+				annotations = nil
+			}
+			pkg.Objects = append(pkg.Objects, v1.EbpfObject{Name: name, Bytes: byt, Annotations: annotations})
+		case SubtypeBTF:
+			pkg.BTF = byt
+		case SubtypeSource:
+			pkg.Source = byt
+		case SubtypeFixtures:
+			if pkg.Fixtures, err = decodeFixtures(byt); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return pkg, nil
 }
 
 // GenerateConfig generates a blank config with optional annotations.
 func buildConfigDescriptor(
 	byt []byte,
 	annotations map[string]string,
+	mediaType string,
 ) (ocispec.Descriptor, error) {
 	dig := digest.FromBytes(byt)
 	if annotations == nil {
@@ -176,7 +482,7 @@ func buildConfigDescriptor(
 	}
 	annotations[ocispec.AnnotationTitle] = configName
 	config := ocispec.Descriptor{
-		MediaType:   configMediaType,
+		MediaType:   mediaType,
 		Digest:      dig,
 		Size:        int64(len(byt)),
 		Annotations: annotations,
@@ -0,0 +1,44 @@
+package spec_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"oras.land/oras-go/pkg/content"
+)
+
+// BenchmarkPull pulls a package from a local OCI store, as bee run does once the
+// package is in the local store.
+func BenchmarkPull(b *testing.B) {
+	byt, err := os.ReadFile("array.o")
+	if err != nil {
+		b.Fatal(err)
+	}
+	dir, err := os.MkdirTemp("", "bee-bench-pull")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	reg, err := content.NewOCI(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ctx := context.Background()
+	client := spec.NewEbpfOCICLient()
+	ref := "localhost:5000/bench:v1"
+	if err := client.Push(ctx, ref, reg, &spec.EbpfPackage{ProgramFileBytes: byt}); err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(int64(len(byt)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.Pull(ctx, ref, reg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
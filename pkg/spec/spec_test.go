@@ -1,15 +1,42 @@
 package spec_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"debug/elf"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/cilium/ebpf"
+	"github.com/containerd/containerd/remotes"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	apiv1 "github.com/solo-io/bumblebee/api/v1"
 	"github.com/solo-io/bumblebee/pkg/spec"
 	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/oras"
+	"oras.land/oras-go/pkg/target"
 )
 
 var (
@@ -88,3 +115,2129 @@ var _ = Describe("hello", func() {
 		Expect(newPkg.Platform).To(Equal(pkg.Platform))
 	})
 })
+
+var _ = Describe("layers", func() {
+	It("pulls the objects, BTF and source of a package", func() {
+		byt, err := os.ReadFile("array.o")
+		Expect(err).NotTo(HaveOccurred())
+
+		reg, err := content.NewOCI(tmpDir)
+		Expect(err).NotTo(HaveOccurred())
+
+		client := spec.NewEbpfOCICLient()
+		ctx := context.Background()
+		pkg := &spec.EbpfPackage{
+			ProgramFileBytes: byt,
+			Objects: []apiv1.EbpfObject{{
+				Name:        "helper",
+				Bytes:       append(append([]byte{}, byt...), "helper"...),
+				Annotations: map[string]string{"org.example.kind": "helper"},
+			}},
+			BTF:    []byte("btf"),
+			Source: []byte("source"),
+			Fixtures: []apiv1.Fixture{
+				{Name: "connect", Records: []byte("{\"map\":\"events\"}\n"), Golden: []byte("{\"map\":\"events\",\"entry\":{}}\n")},
+				{Name: "accept", Records: []byte("{}\n"), Golden: []byte{}},
+			},
+			EbpfConfig: spec.EbpfConfig{
+				Authors:  []apiv1.Author{{Name: "Jane Doe", Email: "jane@example.com"}},
+				Programs: []apiv1.ProgramDescription{{Name: "xdp_prog", Description: "counts packets"}},
+				Maps: []apiv1.MapDescription{{
+					Name:   "packets",
+					Metric: &apiv1.MetricDescription{Type: "counter", Help: "Packets seen"},
+				}},
+				Constraints: &apiv1.PlatformConstraints{MinKernelVersion: "5.8", RequiresBTF: true},
+			},
+		}
+		err = client.Push(ctx, "localhost:5000/layers:test", reg, pkg)
+		Expect(err).NotTo(HaveOccurred())
+
+		pulled, err := client.Pull(ctx, "localhost:5000/layers:test", reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pulled.ProgramFileBytes).To(Equal(byt))
+		Expect(pulled.Objects).To(Equal(pkg.Objects))
+		Expect(pulled.BTF).To(Equal(pkg.BTF))
+		Expect(pulled.Source).To(Equal(pkg.Source))
+		// sorted by name
+		Expect(pulled.Fixtures).To(Equal([]apiv1.Fixture{pkg.Fixtures[1], pkg.Fixtures[0]}))
+		Expect(pulled.EbpfConfig).To(Equal(pkg.EbpfConfig))
+		Expect(pulled.Authors).To(Equal("Jane Doe <jane@example.com>"))
+	})
+
+	It("keeps the layers in order", func() {
+		reg, err := content.NewOCI(tmpDir)
+		Expect(err).NotTo(HaveOccurred())
+
+		pkg := &spec.EbpfPackage{ProgramFileBytes: []byte("program")}
+		for _, name := range []string{"b", "a", "d", "c"} {
+			pkg.Objects = append(pkg.Objects, apiv1.EbpfObject{Name: name, Bytes: []byte(name)})
+		}
+		client := spec.NewEbpfOCICLient()
+		ctx := context.Background()
+		Expect(client.Push(ctx, "localhost:5000/layers:order", reg, pkg)).To(Succeed())
+
+		info, err := client.Inspect(ctx, "localhost:5000/layers:order", reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Layers[0].Annotations).To(HaveKeyWithValue(v1.AnnotationTitle, "program.o"))
+		pulled, err := client.Pull(ctx, "localhost:5000/layers:order", reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pulled.Objects).To(Equal(pkg.Objects))
+	})
+
+	It("fetches the layers of lazy pulls on first access", func() {
+		reg, err := content.NewOCI(tmpDir)
+		Expect(err).NotTo(HaveOccurred())
+
+		pkg := &spec.EbpfPackage{
+			ProgramFileBytes: []byte("program"),
+			Objects:          []apiv1.EbpfObject{{Name: "helper", Bytes: []byte("helper")}},
+			BTF:              []byte("btf"),
+			Fixtures:         []apiv1.Fixture{{Name: "connect", Records: []byte("{}\n"), Golden: []byte("{}\n")}},
+			EbpfConfig:       spec.EbpfConfig{Programs: []apiv1.ProgramDescription{{Name: "xdp_prog"}}},
+		}
+		ctx := context.Background()
+		Expect(spec.NewEbpfOCICLient().Push(ctx, "localhost:5000/layers:lazy", reg, pkg)).To(Succeed())
+
+		lazy, err := spec.PullLazy(ctx, "localhost:5000/layers:lazy", reg, spec.ClientOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lazy.Info().EbpfConfig.Programs).To(Equal(pkg.Programs))
+		Expect(lazy.Fetched()).To(Equal(0))
+
+		program, err := lazy.Program(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(program).To(Equal(pkg.ProgramFileBytes))
+		btf, err := lazy.BTF(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(btf).To(Equal(pkg.BTF))
+		Expect(lazy.Fetched()).To(Equal(2))
+		fixtures, err := lazy.Fixtures(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fixtures).To(Equal(pkg.Fixtures))
+		Expect(lazy.Fetched()).To(Equal(3))
+
+		pulled, err := lazy.Package(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pulled.Objects).To(Equal(pkg.Objects))
+		Expect(pulled.ProgramFileBytes).To(Equal(pkg.ProgramFileBytes))
+		Expect(pulled.Fixtures).To(Equal(pkg.Fixtures))
+		Expect(lazy.Fetched()).To(Equal(4))
+	})
+
+	It("pulls images with only the program layer", func() {
+		byt, err := os.ReadFile("array.o")
+		Expect(err).NotTo(HaveOccurred())
+
+		reg, err := content.NewOCI(tmpDir)
+		Expect(err).NotTo(HaveOccurred())
+
+		// the layout of the images pushed before packages had more than one layer
+		store := content.NewMemory()
+		progDesc, err := store.Add("program.o", "application/ebpf.oci.image.program.v1+binary", byt)
+		Expect(err).NotTo(HaveOccurred())
+		configDesc, err := store.Add("config.json", "application/ebpf.oci.image.config.v1+json", []byte("{}"))
+		Expect(err).NotTo(HaveOccurred())
+		manifest, manifestDesc, err := content.GenerateManifest(&configDesc, map[string]string{
+			v1.AnnotationDescription: "legacy",
+		}, progDesc)
+		Expect(err).NotTo(HaveOccurred())
+		ref := "localhost:5000/legacy:test"
+		Expect(store.StoreManifest(ref, manifestDesc, manifest)).To(Succeed())
+		_, err = oras.Copy(context.Background(), store, ref, reg, "", oras.WithAllowedMediaTypes(spec.AllowedMediaTypes()))
+		Expect(err).NotTo(HaveOccurred())
+
+		pkg, err := spec.NewEbpfOCICLient().Pull(context.Background(), ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pkg.ProgramFileBytes).To(Equal(byt))
+		Expect(pkg.Description).To(Equal("legacy"))
+		Expect(pkg.Objects).To(BeEmpty())
+		Expect(pkg.BTF).To(BeNil())
+		Expect(pkg.Source).To(BeNil())
+	})
+
+	It("can pull the images pushed without bee following the v0.0.0 spec", func() {
+		byt, err := os.ReadFile("array.o")
+		Expect(err).NotTo(HaveOccurred())
+		reg := content.NewMemory()
+
+		// pushed with `oras push` of the program alone: untitled, with the default config of oras
+		store := content.NewMemory()
+		progDesc := v1.Descriptor{MediaType: "application/ebpf.oci.image.program.v1+binary", Digest: digest.FromBytes(byt), Size: int64(len(byt))}
+		store.Set(progDesc, byt)
+		configDesc := v1.Descriptor{MediaType: "application/vnd.unknown.config.v1+json", Digest: digest.FromBytes([]byte("{}")), Size: 2}
+		store.Set(configDesc, []byte("{}"))
+		manifest, manifestDesc, err := content.GenerateManifest(&configDesc, map[string]string{
+			v1.AnnotationAuthors: "solo.io",
+		}, progDesc)
+		Expect(err).NotTo(HaveOccurred())
+		ref := "localhost:5000/oras-pushed:test"
+		Expect(store.StoreManifest(ref, manifestDesc, manifest)).To(Succeed())
+		_, err = oras.Copy(context.Background(), store, ref, reg, "")
+		Expect(err).NotTo(HaveOccurred())
+
+		client := spec.NewEbpfOCICLient()
+		pkg, err := client.Pull(context.Background(), ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pkg.ProgramFileBytes).To(Equal(byt))
+		Expect(pkg.Authors).To(Equal("solo.io"))
+		Expect(pkg.EbpfConfig).To(Equal(apiv1.EbpfConfig{}))
+		info, err := client.Inspect(context.Background(), ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Layers).To(Equal([]v1.Descriptor{progDesc}))
+
+		// other artifacts still aren't packages
+		otherDesc := v1.Descriptor{MediaType: "application/vnd.cncf.helm.chart.content.v1.tar+gzip", Digest: digest.FromBytes([]byte("chart")), Size: 5}
+		store.Set(otherDesc, []byte("chart"))
+		manifest, manifestDesc, err = content.GenerateManifest(&configDesc, nil, otherDesc)
+		Expect(err).NotTo(HaveOccurred())
+		ref = "localhost:5000/chart:test"
+		Expect(store.StoreManifest(ref, manifestDesc, manifest)).To(Succeed())
+		_, err = oras.Copy(context.Background(), store, ref, reg, "")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.Inspect(context.Background(), ref, reg)
+		Expect(err).To(MatchError(ContainSubstring("is not an eBPF package")))
+	})
+
+	It("rejects objects with the same name", func() {
+		reg := content.NewMemory()
+		err := spec.NewEbpfOCICLient().Push(context.Background(), "localhost:5000/layers:dup", reg, &spec.EbpfPackage{
+			ProgramFileBytes: []byte("program"),
+			Objects:          []apiv1.EbpfObject{{Name: "a", Bytes: []byte("a")}, {Name: "a", Bytes: []byte("b")}},
+		})
+		Expect(err).To(MatchError(ContainSubstring("more than one object is named a")))
+	})
+})
+
+var _ = Describe("channels", func() {
+	It("promotes through channels in order", func() {
+		byt, err := os.ReadFile("array.o")
+		Expect(err).NotTo(HaveOccurred())
+
+		reg, err := content.NewOCI(tmpDir)
+		Expect(err).NotTo(HaveOccurred())
+
+		client := spec.NewEbpfOCICLient()
+		ctx := context.Background()
+		repo := "localhost:5000/channels"
+		err = client.Push(ctx, spec.ChannelRef(repo, spec.ChannelNightly), reg, &spec.EbpfPackage{ProgramFileBytes: byt})
+		Expect(err).NotTo(HaveOccurred())
+
+		nightly, err := client.Pull(ctx, spec.ChannelRef(repo, spec.ChannelNightly), reg)
+		Expect(err).NotTo(HaveOccurred())
+
+		// can't skip beta
+		err = spec.Promote(ctx, reg, repo, nightly.Digest, spec.ChannelStable)
+		Expect(err).To(HaveOccurred())
+
+		err = spec.Promote(ctx, reg, repo, nightly.Digest, spec.ChannelBeta)
+		Expect(err).NotTo(HaveOccurred())
+		err = spec.Promote(ctx, reg, repo, nightly.Digest, spec.ChannelStable)
+		Expect(err).NotTo(HaveOccurred())
+
+		stable, err := client.Pull(ctx, spec.ChannelRef(repo, spec.ChannelStable), reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stable.Digest).To(Equal(nightly.Digest))
+	})
+})
+
+var _ = Describe("multi-arch", func() {
+	It("pulls the package of the current architecture", func() {
+		byt, err := os.ReadFile("array.o")
+		Expect(err).NotTo(HaveOccurred())
+
+		reg, err := content.NewOCI(tmpDir)
+		Expect(err).NotTo(HaveOccurred())
+
+		client := spec.NewEbpfOCICLient()
+		ctx := context.Background()
+		var pkgs []*spec.EbpfPackage
+		for _, arch := range []string{"amd64", "arm64", "riscv64"} {
+			pkgs = append(pkgs, &spec.EbpfPackage{
+				// make the programs differ, so the layers are told apart
+				ProgramFileBytes: append(append([]byte{}, byt...), []byte(arch)...),
+				Description:      arch,
+				Platform:         &v1.Platform{OS: "linux", Architecture: arch},
+			})
+		}
+		err = client.PushMultiArch(ctx, "localhost:5000/multiarch:test", reg, pkgs)
+		Expect(err).NotTo(HaveOccurred())
+
+		pkg, err := client.Pull(ctx, "localhost:5000/multiarch:test", reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pkg.Platform.Architecture).To(Equal(runtime.GOARCH))
+		Expect(pkg.Description).To(Equal(runtime.GOARCH))
+		Expect(pkg.ProgramFileBytes).To(HaveSuffix(runtime.GOARCH))
+	})
+
+	It("pulls the variant of the kernel", func() {
+		reg, err := content.NewOCI(tmpDir)
+		Expect(err).NotTo(HaveOccurred())
+
+		ctx := context.Background()
+		ref := "localhost:5000/variants:test"
+		variant := func(arch, minKernel string) apiv1.EbpfPackageVariant {
+			return apiv1.EbpfPackageVariant{
+				Package: &spec.EbpfPackage{
+					ProgramFileBytes: []byte(arch + minKernel),
+					Platform:         &v1.Platform{OS: "linux", Architecture: arch},
+				},
+				MinKernelVersion: minKernel,
+			}
+		}
+		Expect(spec.NewEbpfOCICLient().PushVariants(ctx, ref, reg, []apiv1.EbpfPackageVariant{
+			variant("amd64", ""), variant("amd64", "5.8"), variant("arm64", "5.8"),
+		})).To(Succeed())
+
+		pull := func(arch, kernel string) (*spec.EbpfPackage, error) {
+			return spec.NewEbpfOCICLientFor(spec.VariantSelector{Arch: arch, KernelRelease: kernel}).Pull(ctx, ref, reg)
+		}
+		pkg, err := pull("amd64", "5.15.0-91-generic")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(pkg.ProgramFileBytes)).To(Equal("amd645.8"))
+		Expect(pkg.MinKernelVersion).To(Equal("5.8"))
+		pkg, err = pull("amd64", "4.19.0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(pkg.ProgramFileBytes)).To(Equal("amd64"))
+		_, err = pull("arm64", "4.19.0")
+		Expect(err).To(MatchError(ContainSubstring("they require kernels from 5.8 on")))
+		_, err = pull("riscv64", "5.15")
+		Expect(err).To(MatchError(ContainSubstring("no package for architecture riscv64")))
+
+		variants, err := spec.PullVariants(ctx, ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(variants).To(HaveLen(3))
+		Expect(variants[2].MinKernelVersion).To(Equal("5.8"))
+		Expect(variants[2].Package.Platform.Architecture).To(Equal("arm64"))
+
+		err = spec.NewEbpfOCICLient().PushVariants(ctx, ref, reg, []apiv1.EbpfPackageVariant{variant("amd64", "5.8"), variant("amd64", "5.8")})
+		Expect(err).To(MatchError(ContainSubstring("more than one package for amd64 for kernels 5.8+")))
+		err = spec.NewEbpfOCICLient().PushVariants(ctx, ref, reg, []apiv1.EbpfPackageVariant{variant("amd64", "latest")})
+		Expect(err).To(MatchError(ContainSubstring("invalid kernel release")))
+	})
+})
+
+var _ = Describe("store usage", func() {
+	It("stores shared blobs once", func() {
+		dir, err := os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		reg, err := content.NewOCI(dir)
+		Expect(err).NotTo(HaveOccurred())
+
+		ctx := context.Background()
+		client := spec.NewEbpfOCICLient()
+		program := []byte("the same program in two packages")
+		for _, ref := range []string{"localhost:5000/oras:a", "localhost:5000/oras:b"} {
+			err = client.Push(ctx, ref, reg, &spec.EbpfPackage{
+				ProgramFileBytes: program,
+				Description:      ref,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		usage, err := spec.LocalStoreUsage(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(usage.Packages).To(HaveLen(2))
+		// the program and the config are shared, but not the manifests
+		Expect(usage.Packages[0].Shared).To(BeNumerically(">=", len(program)))
+		Expect(usage.Packages[0].Shared).To(BeNumerically("<", usage.Packages[0].Size))
+		Expect(usage.Saved()).To(Equal(usage.Packages[0].Shared))
+		Expect(usage.Logical).To(Equal(usage.Packages[0].Size + usage.Packages[1].Size))
+		Expect(usage.Unreferenced).To(BeZero())
+	})
+})
+
+var _ = Describe("bundles", func() {
+	It("verifies an exported package offline", func() {
+		dir, err := os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		reg, err := content.NewOCI(dir)
+		Expect(err).NotTo(HaveOccurred())
+
+		ctx := context.Background()
+		ref := "localhost:5000/oras:bundle"
+		err = spec.NewEbpfOCICLient().Push(ctx, ref, reg, &spec.EbpfPackage{
+			ProgramFileBytes: []byte("program"),
+			Description:      "bundled",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		_, desc, err := reg.Resolve(ctx, ref)
+		Expect(err).NotTo(HaveOccurred())
+
+		var bundle bytes.Buffer
+		Expect(spec.ExportBundle(ctx, dir, ref, &bundle)).To(Succeed())
+		info, err := spec.VerifyBundle(bytes.NewReader(bundle.Bytes()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Ref).To(Equal(ref))
+		Expect(info.Digest).To(Equal(desc.Digest))
+		// manifest, config and program
+		Expect(info.Blobs).To(HaveLen(3))
+
+		tampered := bytes.Replace(bundle.Bytes(), []byte("program"), []byte("PROGRAM"), 1)
+		_, err = spec.VerifyBundle(bytes.NewReader(tampered))
+		Expect(err).To(MatchError(ContainSubstring("does not match its digest")))
+
+		// imported into another store, as exported or under another ref
+		importDir, err := os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(importDir)
+		_, err = spec.ImportBundle(ctx, importDir, bytes.NewReader(tampered), "")
+		Expect(err).To(MatchError(ContainSubstring("does not match its digest")))
+		info, err = spec.ImportBundle(ctx, importDir, bytes.NewReader(bundle.Bytes()), "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Ref).To(Equal(ref))
+		_, err = spec.ImportBundle(ctx, importDir, bytes.NewReader(bundle.Bytes()), "localhost:5000/oras:imported")
+		Expect(err).NotTo(HaveOccurred())
+		local := spec.NewLocalRegistry(importDir, content.RegistryOptions{})
+		local.Offline = true
+		for _, r := range []string{ref, "localhost:5000/oras:imported"} {
+			pkg, err := local.Pull(ctx, r, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pkg.ProgramFileBytes).To(Equal([]byte("program")))
+			Expect(pkg.Description).To(Equal("bundled"))
+		}
+	})
+})
+
+// resolveHook calls after once the ref was first resolved, or fails its resolutions with err.
+type resolveHook struct {
+	target.Target
+	ref   string
+	after func()
+	err   error
+}
+
+func (r *resolveHook) Resolve(ctx context.Context, ref string) (string, v1.Descriptor, error) {
+	if ref == r.ref && r.err != nil {
+		return "", v1.Descriptor{}, r.err
+	}
+	name, desc, err := r.Target.Resolve(ctx, ref)
+	if ref == r.ref && r.after != nil {
+		r.after()
+		r.after = nil
+	}
+	return name, desc, err
+}
+
+// fetchCounter counts the fetches of the blobs of a registry.
+type fetchCounter struct {
+	target.Target
+	fetches int
+}
+
+func (f *fetchCounter) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	f.fetches++
+	return f.Target.Fetcher(ctx, ref)
+}
+
+var _ = Describe("local registry", func() {
+	var (
+		ctx       context.Context
+		storeDir  string
+		remoteDir string
+		remote    *fetchCounter
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		storeDir, err = os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		remoteDir, err = os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		reg, err := content.NewOCI(remoteDir)
+		Expect(err).NotTo(HaveOccurred())
+		remote = &fetchCounter{Target: reg}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(storeDir)
+		os.RemoveAll(remoteDir)
+	})
+
+	It("stores the packages it pushes", func() {
+		local := spec.NewLocalRegistry(storeDir, content.RegistryOptions{})
+		err := local.Push(ctx, "localhost:5000/local:pushed", remote, &spec.EbpfPackage{ProgramFileBytes: []byte("pushed")})
+		Expect(err).NotTo(HaveOccurred())
+
+		local.Offline = true
+		pkg, err := local.Pull(ctx, "localhost:5000/local:pushed", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pkg.ProgramFileBytes).To(Equal([]byte("pushed")))
+		_, _, err = remote.Resolve(ctx, "localhost:5000/local:pushed")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("pulls channels through the store", func() {
+		repo := "localhost:5000/local"
+		err := spec.NewEbpfOCICLient().Push(ctx, spec.ChannelRef(repo, spec.ChannelNightly), remote, &spec.EbpfPackage{ProgramFileBytes: []byte("nightly")})
+		Expect(err).NotTo(HaveOccurred())
+		local := spec.NewLocalRegistry(storeDir, content.RegistryOptions{})
+		nightly, err := local.PullChannel(ctx, repo, spec.ChannelNightly, remote)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nightly.Channel).To(Equal(spec.ChannelNightly))
+		Expect(nightly.ProgramFileBytes).To(Equal([]byte("nightly")))
+
+		// the blobs of the promoted package are already stored
+		Expect(spec.Promote(ctx, remote.Target, repo, nightly.Digest, spec.ChannelBeta)).To(Succeed())
+		fetches := remote.fetches
+		beta, err := local.PullChannel(ctx, repo, spec.ChannelBeta, remote)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(beta.Channel).To(Equal(spec.ChannelBeta))
+		Expect(beta.Digest).To(Equal(nightly.Digest))
+		Expect(remote.fetches).To(Equal(fetches))
+
+		local.Offline = true
+		_, err = local.PullChannel(ctx, repo, spec.ChannelBeta, remote)
+		Expect(err).To(MatchError(ContainSubstring("can't be pulled offline")))
+	})
+
+	It("transforms the packages it pulls", func() {
+		program, err := os.ReadFile("array.o")
+		Expect(err).NotTo(HaveOccurred())
+		local := spec.NewLocalRegistry(storeDir, content.RegistryOptions{})
+		Expect(local.Push(ctx, "localhost:5000/local:debug", nil, &spec.EbpfPackage{ProgramFileBytes: program})).To(Succeed())
+
+		local.Transforms = []spec.Transform{
+			{Name: "describe", Order: 10, Apply: func(_ context.Context, ref string, pkg *apiv1.EbpfPackage) error {
+				pkg.Description = "pulled from " + ref
+				return nil
+			}},
+			spec.StripDebugInfo,
+		}
+		pkg, err := local.Pull(ctx, "localhost:5000/local:debug", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pkg.Transforms).To(Equal([]string{"strip-debug-info", "describe"}))
+		Expect(pkg.Description).To(Equal("pulled from localhost:5000/local:debug"))
+		Expect(len(pkg.ProgramFileBytes)).To(BeNumerically("<", len(program)))
+		f, err := elf.NewFile(bytes.NewReader(pkg.ProgramFileBytes))
+		Expect(err).NotTo(HaveOccurred())
+		for _, sec := range f.Sections {
+			if sec.Type != elf.SHT_NULL {
+				Expect(sec.Name).NotTo(ContainSubstring(".debug_"))
+			}
+		}
+		stripped, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(pkg.ProgramFileBytes))
+		Expect(err).NotTo(HaveOccurred())
+		original, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(program))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stripped.Maps).To(HaveLen(len(original.Maps)))
+		Expect(stripped.Programs).To(HaveLen(len(original.Programs)))
+		for name, prog := range original.Programs {
+			Expect(stripped.Programs[name].Instructions).To(Equal(prog.Instructions))
+		}
+
+		// the store keeps the package as pushed
+		local.Transforms = nil
+		pkg, err = local.Peek(ctx, "localhost:5000/local:debug", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pkg.ProgramFileBytes).To(Equal(program))
+		Expect(pkg.Transforms).To(BeEmpty())
+
+		local.Transforms = []spec.Transform{spec.StripDebugInfo}
+		Expect(local.Push(ctx, "localhost:5000/local:text", nil, &spec.EbpfPackage{ProgramFileBytes: []byte("not an object")})).To(Succeed())
+		_, err = local.Pull(ctx, "localhost:5000/local:text", nil)
+		Expect(err).To(MatchError(ContainSubstring("could not transform localhost:5000/local:text with strip-debug-info")))
+	})
+
+	It("expands short refs", func() {
+		local := spec.NewLocalRegistry(storeDir, content.RegistryOptions{})
+		local.Refs = &spec.RefRules{DefaultRegistry: "localhost:5000", DefaultNamespace: "solo-io/bumblebee"}
+		Expect(local.Push(ctx, "tcpconnect:v1", remote, &spec.EbpfPackage{ProgramFileBytes: []byte("short")})).To(Succeed())
+		_, _, err := remote.Resolve(ctx, "localhost:5000/solo-io/bumblebee/tcpconnect:v1")
+		Expect(err).NotTo(HaveOccurred())
+
+		local.Offline = true
+		pkg, err := local.Pull(ctx, "localhost:5000/solo-io/bumblebee/tcpconnect:v1", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pkg.ProgramFileBytes).To(Equal([]byte("short")))
+
+		local.Refs.Strict = true
+		_, err = local.Pull(ctx, "tcpconnect:v1", nil)
+		Expect(err).To(MatchError(ContainSubstring("tcpconnect:v1 is a short ref, which strict refs forbid")))
+	})
+
+	It("only copies the packages missing from the store", func() {
+		client := spec.NewEbpfOCICLient()
+		pkg := &spec.EbpfPackage{ProgramFileBytes: []byte("cached")}
+		Expect(client.Push(ctx, "localhost:5000/local:v1", remote, pkg)).To(Succeed())
+		Expect(client.Push(ctx, "localhost:5000/local:latest", remote, pkg)).To(Succeed())
+
+		local := spec.NewLocalRegistry(storeDir, content.RegistryOptions{})
+		pulled, err := local.Pull(ctx, "localhost:5000/local:v1", remote)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(remote.fetches).To(Equal(1))
+
+		// the same digest under another tag is served from the store
+		_, err = local.Fetch(ctx, "localhost:5000/local:latest", remote)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(remote.fetches).To(Equal(1))
+
+		local.Offline = true
+		_, err = local.Pull(ctx, "localhost:5000/local:missing", remote)
+		Expect(err).To(MatchError(spec.ErrNotStored))
+		byDigest, err := local.Pull(ctx, "localhost:5000/local@"+pulled.Digest.String(), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(byDigest.ProgramFileBytes).To(Equal([]byte("cached")))
+		latest, err := local.Pull(ctx, "localhost:5000/local:latest", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(latest.Digest).To(Equal(pulled.Digest))
+	})
+
+	It("reuses the blobs already in the store", func() {
+		client := spec.NewEbpfOCICLient()
+		helper := bytes.Repeat([]byte("helper"), 1<<12)
+		v1Pkg := &spec.EbpfPackage{ProgramFileBytes: []byte("program v1"), Source: helper}
+		v2Pkg := &spec.EbpfPackage{ProgramFileBytes: []byte("program v2"), Source: helper}
+		Expect(client.Push(ctx, "localhost:5000/local:v1", remote, v1Pkg)).To(Succeed())
+		Expect(client.Push(ctx, "localhost:5000/local:v2", remote, v2Pkg)).To(Succeed())
+
+		local := spec.NewLocalRegistry(storeDir, content.RegistryOptions{})
+		_, err := local.Fetch(ctx, "localhost:5000/local:v1", remote)
+		Expect(err).NotTo(HaveOccurred())
+
+		var progress spec.ProgressTracker
+		local.Transfer.Progress = progress.Update
+		recorder := &fetchRecorder{Target: remote}
+		_, err = local.Fetch(ctx, "localhost:5000/local:v2", recorder)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.fetched).NotTo(ContainElement(digest.FromBytes(helper).String()))
+
+		downloaded, reused := progress.Bytes()
+		// the helper, and the config which did not change either
+		Expect(reused).To(BeNumerically(">=", len(helper)))
+		Expect(downloaded).To(BeNumerically(">", len("program v2")))
+		Expect(downloaded).To(BeNumerically("<", len(helper)))
+		Expect(progress.String()).To(HaveSuffix(", 24KiB reused"))
+
+		pulled, err := local.Pull(ctx, "localhost:5000/local:v2", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pulled.Source).To(Equal(helper))
+	})
+
+	It("reports the status of pulls, with their size resolved up-front", func() {
+		pkg := &spec.EbpfPackage{ProgramFileBytes: []byte("status"), Source: bytes.Repeat([]byte("source"), 1<<10)}
+		Expect(spec.NewEbpfOCICLient().Push(ctx, "localhost:5000/local:status", remote, pkg)).To(Succeed())
+
+		local := spec.NewLocalRegistry(storeDir, content.RegistryOptions{})
+		status := local.StartFetch(ctx, "localhost:5000/local:status", remote)
+		updates, unsubscribe := status.Subscribe()
+		defer unsubscribe()
+		var last spec.PullStats
+		for stats := range updates {
+			last = stats
+		}
+		desc, err := status.Wait(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(last.Done).To(BeTrue())
+		Expect(last.Total).To(BeNumerically(">", len(pkg.Source)))
+		Expect(last.Transferred).To(Equal(last.Total))
+		Expect(last.BlobsDone).To(Equal(last.Blobs))
+		Expect(last.Remaining()).To(BeZero())
+		Expect(status.Stats()).To(Equal(last))
+
+		info, err := spec.NewEbpfOCICLient().Inspect(ctx, "localhost:5000/local:status", remote)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Manifest.Digest).To(Equal(desc.Digest))
+
+		// subscribing once done gets the final stats
+		final, _ := status.Subscribe()
+		Expect(<-final).To(Equal(last))
+		Expect(final).To(BeClosed())
+
+		failed := local.StartFetch(ctx, "localhost:5000/local:missing", remote)
+		_, err = failed.Wait(ctx)
+		Expect(err).To(HaveOccurred())
+		Expect(failed.Stats().Error).To(Equal(err.Error()))
+	})
+
+	It("estimates the time left from the rate of the pull", func() {
+		stats := spec.PullStats{Total: 3000, Transferred: 1000, Reused: 1000, Rate: 500}
+		eta, ok := stats.ETA()
+		Expect(ok).To(BeTrue())
+		Expect(eta).To(Equal(2 * time.Second))
+		Expect(stats.String()).To(Equal("1000B of 1.953KiB at 500B/s, 2s left, 1000B reused"))
+		_, ok = spec.PullStats{Transferred: 1000, Rate: 500}.ETA()
+		Expect(ok).To(BeFalse())
+	})
+})
+
+// fetchRecorder records the digests of the blobs fetched from a registry.
+type fetchRecorder struct {
+	target.Target
+	fetched []string
+}
+
+func (f *fetchRecorder) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := f.Target.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return remotes.FetcherFunc(func(ctx context.Context, desc v1.Descriptor) (io.ReadCloser, error) {
+		f.fetched = append(f.fetched, desc.Digest.String())
+		return fetcher.Fetch(ctx, desc)
+	}), nil
+}
+
+var _ = Describe("inspection", func() {
+	var (
+		ctx       context.Context
+		remoteDir string
+		remote    *fetchRecorder
+		store     *content.OCI
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		remoteDir, err = os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		store, err = content.NewOCI(remoteDir)
+		Expect(err).NotTo(HaveOccurred())
+		remote = &fetchRecorder{Target: store}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(remoteDir)
+	})
+
+	It("inspects the manifest and config without fetching the layers", func() {
+		returns := uint32(2)
+		pkg := &spec.EbpfPackage{
+			ProgramFileBytes: []byte("program"),
+			Description:      "inspected",
+			Source:           []byte("source"),
+			EbpfConfig: spec.EbpfConfig{
+				Tests: []apiv1.ProgramTest{{Name: "passes", Program: "xdp_prog", Return: &returns}},
+			},
+		}
+		client := spec.NewEbpfOCICLient()
+		Expect(client.Push(ctx, "localhost:5000/inspect:v1", store, pkg)).To(Succeed())
+
+		info, err := client.Inspect(ctx, "localhost:5000/inspect:v1", remote)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Annotations).To(HaveKeyWithValue(v1.AnnotationDescription, "inspected"))
+		Expect(info.EbpfConfig).To(Equal(pkg.EbpfConfig))
+		Expect(info.Layers).To(HaveLen(2))
+		Expect(info.Layers[0].Size).To(BeEquivalentTo(len("program")))
+		Expect(info.Size()).To(Equal(info.Manifest.Size + info.Config.Size + int64(len("program")+len("source"))))
+		Expect(remote.fetched).To(ConsistOf(info.Manifest.Digest.String(), info.Config.Digest.String()))
+	})
+
+	It("inspects the selected variant of multi-variant images", func() {
+		client := spec.NewEbpfOCICLientFor(spec.VariantSelector{Arch: "arm64"})
+		Expect(client.PushMultiArch(ctx, "localhost:5000/inspect:multi", store, []*spec.EbpfPackage{
+			{ProgramFileBytes: []byte("amd64"), Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}},
+			{ProgramFileBytes: []byte("arm64"), Platform: &v1.Platform{OS: "linux", Architecture: "arm64"}},
+		})).To(Succeed())
+
+		info, err := client.Inspect(ctx, "localhost:5000/inspect:multi", remote)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Index).NotTo(BeNil())
+		Expect(info.Variants).To(HaveLen(2))
+		Expect(info.Manifest.Platform.Architecture).To(Equal("arm64"))
+		Expect(info.Layers[0].Size).To(BeEquivalentTo(len("arm64")))
+	})
+
+	It("lists the tags of the store", func() {
+		client := spec.NewEbpfOCICLient()
+		for _, tag := range []string{"v2", "v1"} {
+			Expect(client.Push(ctx, "localhost:5000/inspect:"+tag, store, &spec.EbpfPackage{ProgramFileBytes: []byte(tag)})).To(Succeed())
+		}
+		Expect(client.Push(ctx, "localhost:5000/other:v3", store, &spec.EbpfPackage{ProgramFileBytes: []byte("v3")})).To(Succeed())
+
+		tags, err := client.List(ctx, "localhost:5000/inspect", store)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tags).To(Equal([]string{"v1", "v2"}))
+	})
+})
+
+// slowTarget delays the reads of the blobs of a registry, recording how many are read at once.
+type slowTarget struct {
+	target.Target
+	lock     sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (s *slowTarget) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := s.Target.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return remotes.FetcherFunc(func(ctx context.Context, desc v1.Descriptor) (io.ReadCloser, error) {
+		rc, err := fetcher.Fetch(ctx, desc)
+		if err != nil {
+			return nil, err
+		}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.inFlight++
+		if s.inFlight > s.max {
+			s.max = s.inFlight
+		}
+		return &slowReader{ReadCloser: rc, target: s}, nil
+	}), nil
+}
+
+type slowReader struct {
+	io.ReadCloser
+	target *slowTarget
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(20 * time.Millisecond)
+	return r.ReadCloser.Read(p)
+}
+
+func (r *slowReader) Close() error {
+	r.target.lock.Lock()
+	defer r.target.lock.Unlock()
+	r.target.inFlight--
+	return r.ReadCloser.Close()
+}
+
+var _ = Describe("transfers", func() {
+	var (
+		ctx     context.Context
+		dir     string
+		store   *content.OCI
+		pkg     *spec.EbpfPackage
+		objects []apiv1.EbpfObject
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		dir, err = os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		store, err = content.NewOCI(filepath.Join(dir, "store"))
+		Expect(err).NotTo(HaveOccurred())
+		objects = nil
+		for i := 0; i < 4; i++ {
+			objects = append(objects, apiv1.EbpfObject{Name: fmt.Sprintf("helper%d", i), Bytes: []byte(fmt.Sprintf("helper %d", i))})
+		}
+		pkg = &spec.EbpfPackage{
+			ProgramFileBytes: []byte("program"),
+			Objects:          objects,
+			Source:           bytes.Repeat([]byte("source"), 1<<10),
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("reports the progress of each blob", func() {
+		var (
+			lock     sync.Mutex
+			progress []spec.Progress
+			out      bytes.Buffer
+		)
+		printer := spec.ProgressWriter(&out)
+		client := spec.NewEbpfOCICLientWith(spec.ClientOptions{Transfer: spec.TransferOptions{
+			Progress: func(p spec.Progress) {
+				lock.Lock()
+				defer lock.Unlock()
+				progress = append(progress, p)
+				printer(p)
+			},
+		}})
+		Expect(client.Push(ctx, "localhost:5000/transfer:v1", store, pkg)).To(Succeed())
+
+		done := map[string]int64{}
+		for _, p := range progress {
+			Expect(p.Err).NotTo(HaveOccurred())
+			if p.Done {
+				done[p.Name()] = p.Transferred
+			}
+		}
+		// the program, the objects, the source, the config and the manifest
+		Expect(done).To(HaveLen(len(objects) + 4))
+		Expect(done).To(HaveKeyWithValue("program.o", BeEquivalentTo(len("program"))))
+		Expect(done).To(HaveKeyWithValue("source.tar.gz", BeEquivalentTo(len(pkg.Source))))
+		Expect(out.String()).To(ContainSubstring("source.tar.gz: 6KiB at "))
+
+		var tracker spec.ProgressTracker
+		for _, p := range progress {
+			tracker.Update(p)
+		}
+		Expect(tracker.String()).To(HavePrefix(fmt.Sprintf("%d/%d blobs, ", len(objects)+4, len(objects)+4)))
+	})
+
+	It("bounds the number of blobs transferred at once", func() {
+		Expect(spec.NewEbpfOCICLient().Push(ctx, "localhost:5000/transfer:v1", store, pkg)).To(Succeed())
+
+		remote := &slowTarget{Target: store}
+		client := spec.NewEbpfOCICLientWith(spec.ClientOptions{Transfer: spec.TransferOptions{Concurrency: 2}})
+		pulled, err := client.Pull(ctx, "localhost:5000/transfer:v1", remote)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pulled.Objects).To(Equal(objects))
+		Expect(remote.max).To(Equal(2))
+	})
+
+	It("aborts the transfers once cancelled", func() {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		var (
+			lock   sync.Mutex
+			failed []spec.Progress
+		)
+		client := spec.NewEbpfOCICLientWith(spec.ClientOptions{Transfer: spec.TransferOptions{
+			Progress: func(p spec.Progress) {
+				if p.Name() == "source.tar.gz" {
+					cancel()
+				}
+				lock.Lock()
+				defer lock.Unlock()
+				if p.Done && p.Err != nil {
+					failed = append(failed, p)
+				}
+			},
+		}})
+		err := client.Push(ctx, "localhost:5000/transfer:v1", store, pkg)
+		Expect(err).To(MatchError(context.Canceled))
+		Expect(failed).NotTo(BeEmpty())
+
+		_, _, err = store.Resolve(context.Background(), "localhost:5000/transfer:v1")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("pushes from a file and pulls to a directory", func() {
+		programFile := filepath.Join(dir, "program.o")
+		Expect(os.WriteFile(programFile, []byte("streamed"), 0644)).To(Succeed())
+		pkg.ProgramFileBytes = nil
+		Expect(spec.PushFromFile(ctx, "localhost:5000/transfer:file", store, programFile, pkg, spec.TransferOptions{})).To(Succeed())
+
+		pulled, err := spec.NewEbpfOCICLient().Pull(ctx, "localhost:5000/transfer:file", store)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pulled.ProgramFileBytes).To(Equal([]byte("streamed")))
+		Expect(pulled.Source).To(Equal(pkg.Source))
+
+		out := filepath.Join(dir, "out")
+		info, err := spec.PullToDir(ctx, "localhost:5000/transfer:file", store, out, spec.TransferOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Layers).To(HaveLen(len(objects) + 2))
+		byt, err := os.ReadFile(filepath.Join(out, "program.o"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(byt).To(Equal([]byte("streamed")))
+		byt, err = os.ReadFile(filepath.Join(out, "source.tar.gz"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(byt).To(Equal(pkg.Source))
+		Expect(filepath.Join(out, "config.json")).To(BeAnExistingFile())
+	})
+})
+
+var _ = Describe("completion", func() {
+	var (
+		storeDir string
+		registry *httptest.Server
+		lists    int
+		auth     content.RegistryOptions
+	)
+
+	BeforeEach(func() {
+		var err error
+		storeDir, err = os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		lists = 0
+		registry = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user, pass, ok := r.BasicAuth(); !ok || user != "bee" || pass != "secret" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			lists++
+			tags := []string{"v0.1.0", "v0.2.0"}
+			if r.URL.Query().Get("last") == "" {
+				w.Header().Set("Link", `</v2/bee/tcpconnect/tags/list?n=2&last=v0.2.0>; rel="next"`)
+			} else {
+				tags = []string{"latest"}
+			}
+			Expect(r.URL.Path).To(Equal("/v2/bee/tcpconnect/tags/list"))
+			json.NewEncoder(w).Encode(map[string]interface{}{"name": "bee/tcpconnect", "tags": tags})
+		}))
+		auth = content.RegistryOptions{Username: "bee", Password: "secret", PlainHTTP: true}
+	})
+
+	AfterEach(func() {
+		registry.Close()
+		os.RemoveAll(storeDir)
+	})
+
+	It("lists the tags of a repository", func() {
+		repo := strings.TrimPrefix(registry.URL, "http://") + "/bee/tcpconnect"
+		tags, err := spec.ListTags(context.Background(), repo, auth)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tags).To(Equal([]string{"v0.1.0", "v0.2.0", "latest"}))
+
+		_, err = spec.ListTags(context.Background(), repo, content.RegistryOptions{PlainHTTP: true})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("completes refs from the local store and cached tags", func() {
+		ctx := context.Background()
+		repo := strings.TrimPrefix(registry.URL, "http://") + "/bee/tcpconnect"
+		local := spec.NewLocalRegistry(storeDir, auth)
+		Expect(local.Push(ctx, repo+":dev", nil, &spec.EbpfPackage{ProgramFileBytes: []byte("dev")})).To(Succeed())
+
+		cacheFile := filepath.Join(storeDir, "completion-cache.json")
+		completer := spec.NewCompleter(storeDir, cacheFile, auth)
+		completions, err := completer.Complete(ctx, repo[:len(repo)-3])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(completions).To(Equal([]spec.Completion{
+			{Ref: repo + ":", Description: "repository"},
+			{Ref: repo + ":dev", Description: "local store"},
+		}))
+		Expect(lists).To(BeZero())
+
+		completions, err = completer.Complete(ctx, repo+":v0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(completions).To(Equal([]spec.Completion{
+			{Ref: repo + ":v0.1.0", Description: "tag of " + repo},
+			{Ref: repo + ":v0.2.0", Description: "tag of " + repo},
+		}))
+		Expect(lists).To(Equal(2))
+
+		// another process completes from the cache, until it expires
+		completer = spec.NewCompleter(storeDir, cacheFile, auth)
+		completions, err = completer.Complete(ctx, repo+":")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(completions).To(HaveLen(4))
+		Expect(lists).To(Equal(2))
+		completer.TTL = 0
+		_, err = completer.Complete(ctx, repo+":")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lists).To(Equal(4))
+
+		// expired tags are completed when the registry is unreachable
+		registry.Close()
+		completions, err = completer.Complete(ctx, repo+":l")
+		Expect(err).To(HaveOccurred())
+		Expect(completions).To(Equal([]spec.Completion{{Ref: repo + ":latest", Description: "tag of " + repo}}))
+	})
+})
+
+var _ = Describe("signatures", func() {
+	var (
+		ctx  context.Context
+		dir  string
+		reg  *content.OCI
+		ref  string
+		key  *ecdsa.PrivateKey
+		pull func(verifier *spec.Verifier) error
+	)
+
+	writePEM := func(name, typ string, der []byte) string {
+		path := filepath.Join(dir, name)
+		Expect(os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0644)).To(Succeed())
+		return path
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		dir, err = os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		reg, err = content.NewOCI(dir)
+		Expect(err).NotTo(HaveOccurred())
+
+		byt, err := os.ReadFile("array.o")
+		Expect(err).NotTo(HaveOccurred())
+		ref = "localhost:5000/signed:v1"
+		err = spec.NewEbpfOCICLient().Push(ctx, ref, reg, &apiv1.EbpfPackage{ProgramFileBytes: byt})
+		Expect(err).NotTo(HaveOccurred())
+
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		pull = func(verifier *spec.Verifier) error {
+			_, err := spec.NewEbpfOCICLientWith(spec.ClientOptions{Verifier: verifier}).Pull(ctx, ref, reg)
+			return err
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("verifies the signatures of a key", func() {
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		Expect(err).NotTo(HaveOccurred())
+		verifier, err := spec.NewVerifier(spec.VerifierOptions{KeyFile: writePEM("cosign.pub", "PUBLIC KEY", der)})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = pull(verifier)
+		Expect(errors.Is(err, spec.ErrUnsigned)).To(BeTrue(), "%v", err)
+
+		other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.Sign(ctx, ref, reg, other)).To(Succeed())
+		err = pull(verifier)
+		Expect(errors.Is(err, spec.ErrBadSignature)).To(BeTrue(), "%v", err)
+		var verificationErr *spec.VerificationError
+		Expect(errors.As(err, &verificationErr)).To(BeTrue())
+		Expect(verificationErr.Ref).To(Equal(ref))
+
+		pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+		Expect(err).NotTo(HaveOccurred())
+		signer, err := spec.LoadSigningKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.Sign(ctx, ref, reg, signer)).To(Succeed())
+		Expect(pull(verifier)).To(Succeed())
+		Expect(pull(nil)).To(Succeed())
+	})
+
+	It("pulls the package verified, even if retagged meanwhile", func() {
+		Expect(spec.Sign(ctx, ref, reg, key)).To(Succeed())
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		Expect(err).NotTo(HaveOccurred())
+		verifier, err := spec.NewVerifier(spec.VerifierOptions{KeyFile: writePEM("cosign.pub", "PUBLIC KEY", der)})
+		Expect(err).NotTo(HaveOccurred())
+
+		// an unsigned package is pushed to the tag once it is resolved
+		retagged := &resolveHook{Target: reg, ref: ref, after: func() {
+			err := spec.NewEbpfOCICLient().Push(ctx, ref, reg, &apiv1.EbpfPackage{ProgramFileBytes: []byte("unsigned")})
+			Expect(err).NotTo(HaveOccurred())
+		}}
+		_, err = spec.NewEbpfOCICLientWith(spec.ClientOptions{Verifier: verifier}).Pull(ctx, ref, retagged)
+		Expect(err).To(MatchError(ContainSubstring("changed while it was pulled")))
+	})
+
+	It("only reports the packages whose signatures are missing as unsigned", func() {
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		Expect(err).NotTo(HaveOccurred())
+		verifier, err := spec.NewVerifier(spec.VerifierOptions{KeyFile: writePEM("cosign.pub", "PUBLIC KEY", der)})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, rootDesc, err := reg.Resolve(ctx, ref)
+		Expect(err).NotTo(HaveOccurred())
+		unreachable := &resolveHook{Target: reg, ref: spec.SignatureRef(ref, rootDesc.Digest), err: errors.New("connection refused")}
+		_, err = spec.NewEbpfOCICLientWith(spec.ClientOptions{Verifier: verifier}).Pull(ctx, ref, unreachable)
+		Expect(err).To(MatchError(ContainSubstring("connection refused")))
+		Expect(errors.Is(err, spec.ErrUnsigned)).To(BeFalse())
+	})
+
+	It("verifies the identity of keyless signatures", func() {
+		caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		caTemplate := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "test ca"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+		caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+		Expect(err).NotTo(HaveOccurred())
+		ca, err := x509.ParseCertificate(caDER)
+		Expect(err).NotTo(HaveOccurred())
+		roots := writePEM("roots.pem", "CERTIFICATE", caDER)
+
+		// certificates expire minutes after signing, and are verified at the time they were issued
+		leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber:   big.NewInt(2),
+			NotBefore:      time.Now().Add(-time.Hour),
+			NotAfter:       time.Now().Add(-50 * time.Minute),
+			EmailAddresses: []string{"dev@example.com"},
+			KeyUsage:       x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+			ExtraExtensions: []pkix.Extension{{
+				Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1},
+				Value: []byte("https://accounts.example.com"),
+			}},
+		}, ca, &key.PublicKey, caKey)
+		Expect(err).NotTo(HaveOccurred())
+		cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})
+		Expect(spec.SignWithCertificate(ctx, ref, reg, key, cert, nil)).To(Succeed())
+
+		verifier, err := spec.NewVerifier(spec.VerifierOptions{
+			RootsFile: roots,
+			Identity:  "dev@example.com",
+			Issuer:    "https://accounts.example.com",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pull(verifier)).To(Succeed())
+
+		verifier, err = spec.NewVerifier(spec.VerifierOptions{
+			RootsFile: roots,
+			Identity:  "someone@example.com",
+			Issuer:    "https://accounts.example.com",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		err = pull(verifier)
+		Expect(errors.Is(err, spec.ErrBadSignature)).To(BeTrue(), "%v", err)
+		Expect(err.Error()).To(ContainSubstring("dev@example.com"))
+	})
+
+	It("pulls the signatures into the local store", func() {
+		Expect(spec.Sign(ctx, ref, reg, key)).To(Succeed())
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		Expect(err).NotTo(HaveOccurred())
+		verifier, err := spec.NewVerifier(spec.VerifierOptions{KeyFile: writePEM("cosign.pub", "PUBLIC KEY", der)})
+		Expect(err).NotTo(HaveOccurred())
+
+		localRegistry := spec.NewLocalRegistry(filepath.Join(dir, "store"), content.RegistryOptions{})
+		localRegistry.Verifier = verifier
+		_, err = localRegistry.Pull(ctx, ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+
+		localRegistry.Offline = true
+		_, err = localRegistry.Pull(ctx, ref, nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("notation signatures", func() {
+	var (
+		ctx        context.Context
+		dir        string
+		reg        *content.OCI
+		ref        string
+		key        *ecdsa.PrivateKey
+		chain      []*x509.Certificate
+		trustStore string
+	)
+
+	// newCA returns a CA and its key, with a certificate of the key for code signing.
+	newCA := func(subject pkix.Name, key *ecdsa.PrivateKey) ([]byte, []*x509.Certificate) {
+		caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		caTemplate := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "test ca"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+		caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+		Expect(err).NotTo(HaveOccurred())
+		ca, err := x509.ParseCertificate(caDER)
+		Expect(err).NotTo(HaveOccurred())
+		leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      subject,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		}, ca, &key.PublicKey, caKey)
+		Expect(err).NotTo(HaveOccurred())
+		leaf, err := x509.ParseCertificate(leafDER)
+		Expect(err).NotTo(HaveOccurred())
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), []*x509.Certificate{leaf, ca}
+	}
+
+	writePolicy := func(policies string) string {
+		path := filepath.Join(dir, "trustpolicy.json")
+		Expect(os.WriteFile(path, []byte(`{"version": "1.0", "trustPolicies": [`+policies+`]}`), 0644)).To(Succeed())
+		return path
+	}
+
+	policy := func(scope, level, identity string) string {
+		return `{
+			"name": "` + level + `",
+			"registryScopes": ["` + scope + `"],
+			"signatureVerification": {"level": "` + level + `"},
+			"trustStores": ["ca:solo"],
+			"trustedIdentities": ["` + identity + `"]
+		}`
+	}
+
+	pull := func(opts spec.VerifierOptions) error {
+		opts.TrustStoreDir = trustStore
+		verifier, err := spec.NewVerifier(opts)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = spec.NewEbpfOCICLientWith(spec.ClientOptions{Verifier: verifier}).Pull(ctx, ref, reg)
+		return err
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		dir, err = os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		reg, err = content.NewOCI(filepath.Join(dir, "registry"))
+		Expect(err).NotTo(HaveOccurred())
+		ref = "localhost:5000/signed:v1"
+		Expect(spec.NewEbpfOCICLient().Push(ctx, ref, reg, &apiv1.EbpfPackage{ProgramFileBytes: []byte("program")})).To(Succeed())
+
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		var caPEM []byte
+		caPEM, chain = newCA(pkix.Name{CommonName: "bee", Organization: []string{"Solo"}}, key)
+		trustStore = filepath.Join(dir, "truststore")
+		Expect(os.MkdirAll(filepath.Join(trustStore, "x509", "ca", "solo"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(trustStore, "x509", "ca", "solo", "ca.pem"), caPEM, 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("verifies the signatures of the registries of trust policies", func() {
+		opts := spec.VerifierOptions{TrustPolicyFile: writePolicy(policy("localhost:5000/signed", "strict", "x509.subject: O=Solo, CN=bee"))}
+		err := pull(opts)
+		Expect(errors.Is(err, spec.ErrUnsigned)).To(BeTrue(), "%v", err)
+
+		// signed by a certificate of an untrusted CA
+		other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		_, otherChain := newCA(pkix.Name{CommonName: "bee", Organization: []string{"Solo"}}, other)
+		Expect(spec.SignNotation(ctx, ref, reg, other, otherChain)).To(Succeed())
+		err = pull(opts)
+		Expect(errors.Is(err, spec.ErrBadSignature)).To(BeTrue(), "%v", err)
+		Expect(err.Error()).To(ContainSubstring("not trusted"))
+
+		// the signatures already stored are kept
+		Expect(spec.SignNotation(ctx, ref, reg, key, chain)).To(Succeed())
+		Expect(pull(opts)).To(Succeed())
+
+		tags, err := spec.NewEbpfOCICLient().List(ctx, "localhost:5000/signed", reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tags).To(Equal([]string{"v1"}))
+	})
+
+	It("rejects signatures of untrusted identities", func() {
+		Expect(spec.SignNotation(ctx, ref, reg, key, chain)).To(Succeed())
+		err := pull(spec.VerifierOptions{TrustPolicyFile: writePolicy(policy("localhost:5000/signed", "strict", "x509.subject: CN=other"))})
+		Expect(errors.Is(err, spec.ErrBadSignature)).To(BeTrue(), "%v", err)
+		Expect(err.Error()).To(ContainSubstring("not a trusted identity"))
+
+		// audits only check the integrity of the signature
+		Expect(pull(spec.VerifierOptions{TrustPolicyFile: writePolicy(policy("localhost:5000/signed", "audit", "x509.subject: CN=other"))})).To(Succeed())
+	})
+
+	It("verifies cosign signatures of the other registries", func() {
+		Expect(spec.Sign(ctx, ref, reg, key)).To(Succeed())
+		policyFile := writePolicy(policy("localhost:5000/notation", "strict", "*"))
+
+		err := pull(spec.VerifierOptions{TrustPolicyFile: policyFile})
+		Expect(errors.Is(err, spec.ErrUnsigned)).To(BeTrue(), "%v", err)
+		Expect(err.Error()).To(ContainSubstring("no trust policy applies"))
+
+		Expect(pull(spec.VerifierOptions{TrustPolicyFile: policyFile, Key: &key.PublicKey})).To(Succeed())
+	})
+
+	It("rejects invalid trust policies", func() {
+		_, err := spec.LoadTrustPolicy(writePolicy(`{
+			"name": "all",
+			"registryScopes": ["*", "localhost:5000/signed"],
+			"signatureVerification": {"level": "strict"},
+			"trustStores": ["ca:solo"],
+			"trustedIdentities": ["*"]
+		}`), trustStore)
+		Expect(err).To(MatchError(ContainSubstring("wildcard scope")))
+
+		_, err = spec.LoadTrustPolicy(writePolicy(policy("*", "lenient", "*")), trustStore)
+		Expect(err).To(MatchError(ContainSubstring("unknown verification level")))
+
+		_, err = spec.LoadTrustPolicy(writePolicy(policy("*", "strict", "CN=bee")), trustStore)
+		Expect(err).To(MatchError(ContainSubstring("invalid trusted identity")))
+	})
+
+	It("pulls the signatures into the local store", func() {
+		Expect(spec.SignNotation(ctx, ref, reg, key, chain)).To(Succeed())
+		verifier, err := spec.NewVerifier(spec.VerifierOptions{
+			TrustPolicyFile: writePolicy(policy("*", "strict", "x509.subject: CN=bee")),
+			TrustStoreDir:   trustStore,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		localRegistry := spec.NewLocalRegistry(filepath.Join(dir, "store"), content.RegistryOptions{})
+		localRegistry.Verifier = verifier
+		_, err = localRegistry.Pull(ctx, ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+
+		localRegistry.Offline = true
+		_, err = localRegistry.Pull(ctx, ref, nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("verification cache", func() {
+	var (
+		ctx      context.Context
+		dir      string
+		signed   *content.OCI
+		unsigned *content.OCI
+		ref      string
+		key      *ecdsa.PrivateKey
+	)
+
+	// pull pulls the package from the registry, where it may not be signed.
+	pull := func(verifier *spec.Verifier, registry target.Target) error {
+		_, err := spec.NewEbpfOCICLientWith(spec.ClientOptions{Verifier: verifier}).Pull(ctx, ref, registry)
+		return err
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		dir, err = os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		signed, err = content.NewOCI(filepath.Join(dir, "signed"))
+		Expect(err).NotTo(HaveOccurred())
+		unsigned, err = content.NewOCI(filepath.Join(dir, "unsigned"))
+		Expect(err).NotTo(HaveOccurred())
+
+		// the same package, only signed in one of the registries
+		ref = "localhost:5000/cached:v1"
+		pkg := &apiv1.EbpfPackage{ProgramFileBytes: []byte("program")}
+		Expect(spec.NewEbpfOCICLient().Push(ctx, ref, signed, pkg)).To(Succeed())
+		Expect(spec.NewEbpfOCICLient().Push(ctx, ref, unsigned, pkg)).To(Succeed())
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.Sign(ctx, ref, signed, key)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("skips the verification of the packages verified within the TTL", func() {
+		cache := spec.NewVerificationCache(time.Hour)
+		verifier, err := spec.NewVerifier(spec.VerifierOptions{Key: &key.PublicKey, Cache: cache})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = pull(verifier, unsigned)
+		Expect(errors.Is(err, spec.ErrUnsigned)).To(BeTrue(), "%v", err)
+		Expect(pull(verifier, signed)).To(Succeed())
+		Expect(pull(verifier, unsigned)).To(Succeed())
+
+		// other keys don't share the verifications
+		other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		otherVerifier, err := spec.NewVerifier(spec.VerifierOptions{Key: &other.PublicKey, Cache: cache})
+		Expect(err).NotTo(HaveOccurred())
+		err = pull(otherVerifier, unsigned)
+		Expect(errors.Is(err, spec.ErrUnsigned)).To(BeTrue(), "%v", err)
+
+		_, desc, err := signed.Resolve(ctx, ref)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cache.Invalidate(desc.Digest)).To(Succeed())
+		err = pull(verifier, unsigned)
+		Expect(errors.Is(err, spec.ErrUnsigned)).To(BeTrue(), "%v", err)
+
+		Expect(pull(verifier, signed)).To(Succeed())
+		Expect(cache.InvalidateAll()).To(Succeed())
+		err = pull(verifier, unsigned)
+		Expect(errors.Is(err, spec.ErrUnsigned)).To(BeTrue(), "%v", err)
+	})
+
+	It("verifies the packages again after the TTL", func() {
+		verifier, err := spec.NewVerifier(spec.VerifierOptions{Key: &key.PublicKey, Cache: spec.NewVerificationCache(50 * time.Millisecond)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pull(verifier, signed)).To(Succeed())
+		Expect(pull(verifier, unsigned)).To(Succeed())
+
+		time.Sleep(100 * time.Millisecond)
+		err = pull(verifier, unsigned)
+		Expect(errors.Is(err, spec.ErrUnsigned)).To(BeTrue(), "%v", err)
+	})
+
+	It("shares the verifications of persisted caches", func() {
+		path := filepath.Join(dir, "verified.json")
+		cache, err := spec.OpenVerificationCache(path, time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		verifier, err := spec.NewVerifier(spec.VerifierOptions{Key: &key.PublicKey, Cache: cache})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pull(verifier, signed)).To(Succeed())
+
+		reopened, err := spec.OpenVerificationCache(path, time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		otherVerifier, err := spec.NewVerifier(spec.VerifierOptions{Key: &key.PublicKey, Cache: reopened})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pull(otherVerifier, unsigned)).To(Succeed())
+
+		// the invalidations of one are seen by the other
+		Expect(reopened.InvalidateAll()).To(Succeed())
+		err = pull(verifier, unsigned)
+		Expect(errors.Is(err, spec.ErrUnsigned)).To(BeTrue(), "%v", err)
+	})
+})
+
+var _ = Describe("media types", func() {
+	var (
+		ctx context.Context
+		reg *content.OCI
+		dir string
+		pkg *apiv1.EbpfPackage
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		dir, err = os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		reg, err = content.NewOCI(dir)
+		Expect(err).NotTo(HaveOccurred())
+		pkg = &apiv1.EbpfPackage{
+			ProgramFileBytes: []byte("program"),
+			Objects:          []apiv1.EbpfObject{{Name: "extra", Bytes: []byte("extra")}},
+			Source:           []byte("source"),
+			Platform:         &v1.Platform{OS: "linux", Architecture: "x86_64"},
+			Description:      "media types",
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	mediaTypes := func(ref string) []string {
+		info, err := spec.NewEbpfOCICLient().Inspect(ctx, ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+		types := []string{info.Config.MediaType}
+		for _, layer := range info.Layers {
+			types = append(types, layer.MediaType)
+		}
+		return types
+	}
+
+	It("pushes the v2 media types with the subtype and arch of the blobs", func() {
+		ref := "localhost:5000/types:v2"
+		Expect(spec.NewEbpfOCICLient().Push(ctx, ref, reg, pkg)).To(Succeed())
+		Expect(mediaTypes(ref)).To(Equal([]string{
+			"application/ebpf.solo.io.v2; subtype=config",
+			"application/ebpf.solo.io.v2; arch=amd64; subtype=program",
+			"application/ebpf.solo.io.v2; arch=amd64; subtype=object",
+			"application/ebpf.solo.io.v2; subtype=source",
+		}))
+
+		pulled, err := spec.NewEbpfOCICLient().Pull(ctx, ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pulled.ProgramFileBytes).To(Equal(pkg.ProgramFileBytes))
+		Expect(pulled.Objects).To(Equal(pkg.Objects))
+		Expect(pulled.Source).To(Equal(pkg.Source))
+	})
+
+	It("pushes and pulls the v1 media types", func() {
+		ref := "localhost:5000/types:v1"
+		client := spec.NewEbpfOCICLientWith(spec.ClientOptions{LegacyMediaTypes: true})
+		Expect(client.Push(ctx, ref, reg, pkg)).To(Succeed())
+		Expect(mediaTypes(ref)).To(Equal([]string{
+			"application/ebpf.oci.image.config.v1+json",
+			"application/ebpf.oci.image.program.v1+binary",
+			"application/ebpf.oci.image.object.v1+binary",
+			"application/ebpf.oci.image.source.v1.tar+gzip",
+		}))
+
+		pulled, err := spec.NewEbpfOCICLient().Pull(ctx, ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pulled.Objects).To(Equal(pkg.Objects))
+		Expect(pulled.Source).To(Equal(pkg.Source))
+	})
+
+	It("migrates images to the v2 media types, keeping the digests of their blobs", func() {
+		ref := "localhost:5000/types:migrated"
+		client := spec.NewEbpfOCICLientWith(spec.ClientOptions{LegacyMediaTypes: true})
+		Expect(client.Push(ctx, ref, reg, pkg)).To(Succeed())
+		before, err := spec.NewEbpfOCICLient().Inspect(ctx, ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+
+		desc, migrated, err := spec.MigrateMediaTypes(ctx, ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(migrated).To(BeTrue())
+		after, err := spec.NewEbpfOCICLient().Inspect(ctx, ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(after.Manifest.Digest).To(Equal(desc.Digest))
+		Expect(after.Manifest.Digest).NotTo(Equal(before.Manifest.Digest))
+		Expect(after.Config.Digest).To(Equal(before.Config.Digest))
+		Expect(after.Config.MediaType).To(Equal("application/ebpf.solo.io.v2; subtype=config"))
+		Expect(after.Layers).To(HaveLen(len(before.Layers)))
+		for i, layer := range after.Layers {
+			Expect(layer.Digest).To(Equal(before.Layers[i].Digest))
+			Expect(layer.Annotations).To(Equal(before.Layers[i].Annotations))
+		}
+		// the architecture of single images is unknown
+		Expect(after.Layers[0].MediaType).To(Equal("application/ebpf.solo.io.v2; subtype=program"))
+		Expect(after.Annotations).To(HaveKeyWithValue(v1.AnnotationDescription, "media types"))
+
+		pulled, err := spec.NewEbpfOCICLient().Pull(ctx, ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pulled.Objects).To(Equal(pkg.Objects))
+
+		_, migrated, err = spec.MigrateMediaTypes(ctx, ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(migrated).To(BeFalse())
+	})
+
+	It("migrates the packages of multi-arch images", func() {
+		ref := "localhost:5000/types:multiarch"
+		arm := *pkg
+		arm.Platform = &v1.Platform{OS: "linux", Architecture: "arm64"}
+		arm.ProgramFileBytes = []byte("arm program")
+		client := spec.NewEbpfOCICLientWith(spec.ClientOptions{LegacyMediaTypes: true})
+		Expect(client.PushMultiArch(ctx, ref, reg, []*apiv1.EbpfPackage{pkg, &arm})).To(Succeed())
+
+		_, migrated, err := spec.MigrateMediaTypes(ctx, ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(migrated).To(BeTrue())
+
+		info, err := spec.NewEbpfOCICLientFor(spec.VariantSelector{Arch: "arm64"}).Inspect(ctx, ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Variants).To(HaveLen(2))
+		Expect(info.Manifest.Platform.Architecture).To(Equal("arm64"))
+		Expect(info.Layers[0].MediaType).To(Equal("application/ebpf.solo.io.v2; arch=arm64; subtype=program"))
+		pulled, err := spec.NewEbpfOCICLientFor(spec.VariantSelector{Arch: "arm64"}).Pull(ctx, ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pulled.ProgramFileBytes).To(Equal([]byte("arm program")))
+	})
+
+	It("migrates the layout of the local store in place, backing it up", func() {
+		ref := "localhost:5000/types:store"
+		client := spec.NewEbpfOCICLientWith(spec.ClientOptions{LegacyMediaTypes: true})
+		Expect(client.Push(ctx, ref, reg, pkg)).To(Succeed())
+		Expect(reg.SaveIndex()).To(Succeed())
+		// a ref tagged again by an older release, and one whose manifest was deleted by hand
+		indexFile := filepath.Join(dir, "index.json")
+		data, err := os.ReadFile(indexFile)
+		Expect(err).NotTo(HaveOccurred())
+		var index v1.Index
+		Expect(json.Unmarshal(data, &index)).To(Succeed())
+		missing := v1.Descriptor{
+			MediaType:   v1.MediaTypeImageManifest,
+			Digest:      digest.FromString("deleted"),
+			Annotations: map[string]string{v1.AnnotationRefName: "localhost:5000/types:deleted"},
+		}
+		index.Manifests = append([]v1.Descriptor{missing, index.Manifests[0]}, index.Manifests...)
+		data, err = json.Marshal(index)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(indexFile, data, 0644)).To(Succeed())
+
+		version, err := spec.DetectStoreVersion(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(version).To(Equal(0))
+
+		report, err := spec.MigrateStore(ctx, dir, version, spec.StoreMigrationOptions{DryRun: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.BackupDir).To(BeEmpty())
+		Expect(report.Steps).To(HaveLen(2))
+		Expect(report.Steps[0].Changes).To(ConsistOf(
+			ContainSubstring("dropped the duplicate reference of "+ref),
+			ContainSubstring("dropped localhost:5000/types:deleted, whose manifest"),
+		))
+		Expect(report.Steps[1].Changes).To(ConsistOf(ContainSubstring("rewrote " + ref)))
+		unchanged, err := os.ReadFile(indexFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(unchanged).To(Equal(data))
+
+		backup := filepath.Join(dir, "backup")
+		report, err = spec.MigrateStore(ctx, dir, version, spec.StoreMigrationOptions{BackupDir: backup})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.BackupDir).To(Equal(backup))
+		Expect(report.Steps[1].Changes).To(HaveLen(1))
+		backedUp, err := os.ReadFile(filepath.Join(backup, "index.json"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(backedUp).To(Equal(data))
+
+		version, err = spec.DetectStoreVersion(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(version).To(Equal(spec.StoreVersion))
+		reg, err = content.NewOCI(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reg.ListReferences()).To(HaveLen(1))
+		Expect(mediaTypes(ref)[0]).To(Equal("application/ebpf.solo.io.v2; subtype=config"))
+
+		report, err = spec.MigrateStore(ctx, dir, version, spec.StoreMigrationOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Steps).To(BeEmpty())
+		_, err = spec.MigrateStore(ctx, dir, spec.StoreVersion+1, spec.StoreMigrationOptions{})
+		Expect(err).To(MatchError(ContainSubstring("written by a newer release of bee")))
+	})
+
+	It("restores the backup of the store when a migration fails", func() {
+		ref := "localhost:5000/types:broken"
+		client := spec.NewEbpfOCICLientWith(spec.ClientOptions{LegacyMediaTypes: true})
+		Expect(client.Push(ctx, ref, reg, pkg)).To(Succeed())
+		Expect(reg.SaveIndex()).To(Succeed())
+		info, err := spec.NewEbpfOCICLient().Inspect(ctx, ref, reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Remove(filepath.Join(dir, "blobs", "sha256", info.Config.Digest.Hex()))).To(Succeed())
+		before, err := os.ReadFile(filepath.Join(dir, "index.json"))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = spec.MigrateStore(ctx, dir, 1, spec.StoreMigrationOptions{BackupDir: filepath.Join(dir, "backup")})
+		Expect(err).To(MatchError(ContainSubstring("could not migrate the store")))
+		after, err := os.ReadFile(filepath.Join(dir, "index.json"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(after).To(Equal(before))
+		version, err := spec.DetectStoreVersion(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(version).To(Equal(0))
+	})
+})
+
+var _ = Describe("mirrors", func() {
+	var (
+		ctx    context.Context
+		dir    string
+		source *content.OCI
+		edge   *content.OCI
+		key    *ecdsa.PrivateKey
+	)
+
+	push := func(ref, program string) {
+		Expect(spec.NewEbpfOCICLient().Push(ctx, ref, source, &apiv1.EbpfPackage{ProgramFileBytes: []byte(program)})).To(Succeed())
+	}
+
+	tags := func(repo string) []string {
+		tags, err := spec.NewEbpfOCICLient().List(ctx, repo, edge)
+		Expect(err).NotTo(HaveOccurred())
+		return tags
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		dir, err = os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		source, err = content.NewOCI(filepath.Join(dir, "source"))
+		Expect(err).NotTo(HaveOccurred())
+		edge, err = content.NewOCI(filepath.Join(dir, "edge"))
+		Expect(err).NotTo(HaveOccurred())
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+
+		push("localhost:5000/org/app:v1.0", "v1.0")
+		push("localhost:5000/org/app:v1.1", "v1.1")
+		push("localhost:5000/org/app:v2.0", "v2.0")
+		Expect(spec.Sign(ctx, "localhost:5000/org/app:v1.0", source, key)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("copies the tags matching the sources with their signatures", func() {
+		mirror, err := spec.NewMirror([]string{"localhost:5000/org/app:v1.*"}, "edge:5000/mirror", source, edge)
+		Expect(err).NotTo(HaveOccurred())
+		status, err := mirror.Sync(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Copied).To(Equal(2))
+		Expect(status.Images[0].Destination).To(Equal("edge:5000/mirror/org/app:v1.0"))
+		Expect(tags("edge:5000/mirror/org/app")).To(Equal([]string{"v1.0", "v1.1"}))
+		Expect(mirror.Status()).To(Equal(status))
+
+		verifier := spec.NewKeyVerifier(&key.PublicKey)
+		pulled, err := spec.NewEbpfOCICLientWith(spec.ClientOptions{Verifier: verifier}).Pull(ctx, "edge:5000/mirror/org/app:v1.0", edge)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pulled.ProgramFileBytes).To(Equal([]byte("v1.0")))
+
+		// only the changed tags and the new signatures are copied
+		push("localhost:5000/org/app:v1.1", "v1.1 fixed")
+		Expect(spec.Sign(ctx, "localhost:5000/org/app:v1.1", source, key)).To(Succeed())
+		status, err = mirror.Sync(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Copied).To(Equal(1))
+		Expect(status.Unchanged).To(Equal(1))
+		pulled, err = spec.NewEbpfOCICLientWith(spec.ClientOptions{Verifier: verifier}).Pull(ctx, "edge:5000/mirror/org/app:v1.1", edge)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pulled.ProgramFileBytes).To(Equal([]byte("v1.1 fixed")))
+	})
+
+	It("prunes the tags removed from the source per the policy", func() {
+		mirror, err := spec.NewMirror([]string{"localhost:5000/org/app"}, "edge:5000/mirror", source, edge)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = mirror.Sync(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tags("edge:5000/mirror/org/app")).To(Equal([]string{"v1.0", "v1.1", "v2.0"}))
+
+		source.DeleteReference("localhost:5000/org/app:v1.0")
+		Expect(source.SaveIndex()).To(Succeed())
+		status, err := mirror.Sync(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Pruned).To(Equal(0))
+		Expect(tags("edge:5000/mirror/org/app")).To(HaveLen(3))
+
+		mirror.Prune = spec.PruneRemoved
+		status, err = mirror.Sync(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Pruned).To(Equal(1))
+		Expect(status.Unchanged).To(Equal(2))
+		Expect(tags("edge:5000/mirror/org/app")).To(Equal([]string{"v1.1", "v2.0"}))
+		Expect(edge.ListReferences()).NotTo(HaveKey(HavePrefix("edge:5000/mirror/org/app:sha256-")))
+	})
+
+	It("reports the images failing to sync", func() {
+		mirror, err := spec.NewMirror([]string{"localhost:5000/org/app:v3.0", "localhost:5000/org/app:v2.0"}, "edge:5000/mirror", source, edge)
+		Expect(err).NotTo(HaveOccurred())
+		status, err := mirror.Sync(ctx)
+		Expect(err).To(MatchError(ContainSubstring("1 of the 2 images")))
+		Expect(status.Failed).To(Equal(1))
+		Expect(status.Images[0].Action).To(Equal(spec.MirrorFailed))
+		Expect(status.Images[0].Error).NotTo(BeEmpty())
+		Expect(status.Copied).To(Equal(1))
+		Expect(tags("edge:5000/mirror/org/app")).To(Equal([]string{"v2.0"}))
+	})
+
+	It("syncs on a schedule", func() {
+		mirror, err := spec.NewMirror([]string{"localhost:5000/org/app:v2.0"}, "edge:5000/mirror", source, edge)
+		Expect(err).NotTo(HaveOccurred())
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		reports := make(chan *spec.MirrorStatus, 10)
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			mirror.Run(ctx, 10*time.Millisecond, func(status *spec.MirrorStatus, err error) {
+				Expect(err).NotTo(HaveOccurred())
+				reports <- status
+			})
+		}()
+		Expect((<-reports).Copied).To(Equal(1))
+		Expect((<-reports).Unchanged).To(Equal(1))
+		cancel()
+		Eventually(done).Should(BeClosed())
+	})
+
+	It("rejects invalid sources", func() {
+		_, err := spec.NewMirror([]string{"localhost:5000/org/app@sha256:" + strings.Repeat("0", 64)}, "edge:5000/mirror", source, edge)
+		Expect(err).To(MatchError(ContainSubstring("not digests")))
+		_, err = spec.NewMirror([]string{"localhost:5000/org/app:v1.["}, "edge:5000/mirror", source, edge)
+		Expect(err).To(MatchError(ContainSubstring("invalid source")))
+		_, err = spec.NewMirror(nil, "edge:5000/mirror", source, edge)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+// digest of the refs pinned by digest
+const refDigest = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+var _ = Describe("Refs", func() {
+	rules := &spec.RefRules{
+		DefaultRegistry:  "ghcr.io",
+		DefaultNamespace: "solo-io/bumblebee",
+		Aliases:          map[string]string{"lab/exec": "registry.example.com:5000/lab/execsnoop"},
+	}
+
+	It("parses and normalizes refs", func() {
+		ref, err := spec.ParseRef("Localhost:5000/solo-io/tcpconnect:v1@" + refDigest)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ref).To(Equal(spec.Ref{Registry: "localhost:5000", Repository: "solo-io/tcpconnect", Tag: "v1", Digest: refDigest}))
+		Expect(ref.String()).To(Equal("localhost:5000/solo-io/tcpconnect:v1@" + refDigest))
+		Expect(ref.Name()).To(Equal("localhost:5000/solo-io/tcpconnect"))
+		Expect(ref.WithTag("v2").String()).To(Equal("localhost:5000/solo-io/tcpconnect:v2"))
+		Expect(ref.WithDigest(refDigest).String()).To(Equal("localhost:5000/solo-io/tcpconnect@" + refDigest))
+		for _, valid := range []string{"tcpconnect:v1", "ghcr.io/solo-io/bumblebee/tcpconnect", "[::1]:5000/tcpconnect:v1", "tcpconnect@" + refDigest} {
+			ref, err := spec.ParseRef(valid)
+			Expect(err).NotTo(HaveOccurred(), valid)
+			Expect(ref.String()).To(Equal(valid))
+		}
+		short, _ := spec.ParseRef("tcpconnect")
+		Expect(short.IsFull()).To(BeFalse())
+		Expect(short.IsRepository()).To(BeTrue())
+
+		for ref, message := range map[string]string{
+			"ghcr.io/solo-io/TCPConnect:v1":   "has uppercase letters",
+			"ghcr.io/solo-io/tcpconnect:-v1":  "tag \"-v1\"",
+			"ghcr.io/solo-io/tcpconnect@0a1b": "digest 0a1b",
+			"ghcr.io/solo-io//tcpconnect:v1":  "component \"\"",
+			"ghcr.io/:v1":                     "has no repository",
+			"ghcr_io:5000/tcpconnect":         "registry \"ghcr_io:5000\"",
+		} {
+			_, err := spec.ParseRef(ref)
+			Expect(errors.Is(err, spec.ErrInvalidRef)).To(BeTrue(), ref)
+			Expect(err).To(MatchError(ContainSubstring(message)), ref)
+		}
+	})
+
+	It("pulls malformed refs with an error naming the rule they break", func() {
+		_, err := spec.NewEbpfOCICLient().Pull(context.Background(), "localhost:5000/Tcpconnect:v1", content.NewMemory())
+		Expect(errors.Is(err, spec.ErrInvalidRef)).To(BeTrue())
+		Expect(spec.SignatureRef("localhost:5000/tcpconnect:v1@"+refDigest, refDigest)).To(Equal("localhost:5000/tcpconnect:sha256-" + refDigest[len("sha256:"):] + ".sig"))
+	})
+
+	It("expands short refs with the defaults and aliases", func() {
+		for ref, full := range map[string]string{
+			"tcpconnect:v1":                 "ghcr.io/solo-io/bumblebee/tcpconnect:v1",
+			"tcpconnect":                    "ghcr.io/solo-io/bumblebee/tcpconnect",
+			"acme/tcpconnect@" + refDigest:  "ghcr.io/acme/tcpconnect@" + refDigest,
+			"lab/exec:0.1":                  "registry.example.com:5000/lab/execsnoop:0.1",
+			"localhost:5000/tcpconnect:v1":  "localhost:5000/tcpconnect:v1",
+			"localhost/tcpconnect":          "localhost/tcpconnect",
+			"quay.io/solo-io/tcpconnect:v1": "quay.io/solo-io/tcpconnect:v1",
+		} {
+			Expect(rules.ExpandRef(ref)).To(Equal(full), ref)
+		}
+		// without rules refs are used as is
+		Expect((*spec.RefRules)(nil).ExpandRef("tcpconnect:v1")).To(Equal("tcpconnect:v1"))
+		Expect((&spec.RefRules{}).ExpandRef("tcpconnect:v1")).To(Equal("tcpconnect:v1"))
+	})
+
+	It("reads the refs file", func() {
+		dir, err := os.MkdirTemp("", "bee-refs")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, spec.RefsFileName)
+
+		missing, err := spec.LoadRefRules(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(missing).To(Equal(&spec.RefRules{}))
+
+		Expect(os.WriteFile(path, []byte("defaultRegistry: ghcr.io\ndefaultNamespace: solo-io/bumblebee\nstrict: true\n"), 0644)).To(Succeed())
+		loaded, err := spec.LoadRefRules(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(loaded).To(Equal(&spec.RefRules{DefaultRegistry: "ghcr.io", DefaultNamespace: "solo-io/bumblebee", Strict: true}))
+
+		for content, message := range map[string]string{
+			"defaultRegistry: ghcr.io/solo-io\n":                      "must be a registry host",
+			"defaultNamespace: solo-io\n":                             "requires a defaultRegistry",
+			"aliases:\n  tcpconnect: solo-io/tcpconnect\n":            "must be a full repository",
+			"aliases:\n  tcpconnect: ghcr.io/solo-io/tcpconnect:v1\n": "must be a full repository",
+			"registry: ghcr.io\n":                                     "field registry not found",
+		} {
+			Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+			_, err := spec.LoadRefRules(path)
+			Expect(err).To(MatchError(ContainSubstring(message)), content)
+		}
+	})
+
+	It("rewrites the repositories of registries with their templates", func() {
+		rules := &spec.RefRules{
+			DefaultRegistry: "artifactory.example.com",
+			Registries: map[string]spec.RegistryRules{
+				"artifactory.example.com": {Template: "docker-local/{{ .Repository }}"},
+				"*.harbor.example.com":    {Template: "ebpf/{{ .Name }}"},
+			},
+		}
+		for ref, full := range map[string]string{
+			"tcpconnect:v1": "artifactory.example.com/docker-local/tcpconnect:v1",
+			"artifactory.example.com/solo-io/tcpconnect@" + refDigest: "artifactory.example.com/docker-local/solo-io/tcpconnect@" + refDigest,
+			"artifactory.example.com/docker-local/tcpconnect:v1":      "artifactory.example.com/docker-local/tcpconnect:v1",
+			"eu.harbor.example.com/solo-io/tcpconnect:v1":             "eu.harbor.example.com/ebpf/tcpconnect:v1",
+			"ghcr.io/solo-io/bumblebee/tcpconnect:v1":                 "ghcr.io/solo-io/bumblebee/tcpconnect:v1",
+		} {
+			Expect(rules.ExpandRef(ref)).To(Equal(full), ref)
+		}
+
+		invalid := &spec.RefRules{Registries: map[string]spec.RegistryRules{"ghcr.io": {Template: "{{ .Project }}/{{ .Name }}"}}}
+		_, err := invalid.ExpandRef("ghcr.io/tcpconnect:v1")
+		Expect(err).To(MatchError(ContainSubstring("could not rewrite ghcr.io/tcpconnect:v1 with the template of registry ghcr.io")))
+	})
+
+	It("checks the naming rules of the repositories of pushes", func() {
+		rules := &spec.RefRules{Registries: map[string]spec.RegistryRules{
+			"harbor.example.com":        {MinComponents: 2},
+			"*.dkr.ecr.*.amazonaws.com": {MaxLength: 16},
+		}}
+		Expect(rules.CheckRepository("ghcr.io/solo-io/bumblebee/tcp-connect_v2:v1")).To(Succeed())
+		Expect(rules.CheckRepository("tcpconnect:v1")).To(Succeed())
+		for ref, message := range map[string]string{
+			"ghcr.io/solo-io/TCPConnect:v1":                                     "has uppercase letters, which registries refuse: use solo-io/tcpconnect",
+			"ghcr.io/solo-io/tcp..connect:v1":                                   `component "tcp..connect" of repository solo-io/tcp..connect`,
+			"ghcr.io/solo-io/-tcp:v1":                                           `component "-tcp"`,
+			"ghcr.io/" + strings.Repeat("a", 256) + ":v1":                       "is 256 characters long, over the 255 the registry ghcr.io allows",
+			"harbor.example.com/tcpconnect:v1":                                  "the registry harbor.example.com requires repositories of at least 2 components",
+			"docker.io/solo-io/bumblebee/tcpconnect:v1":                         "the registry docker.io allows repositories of at most 2 components",
+			"1234.dkr.ecr.us-east-1.amazonaws.com/solo-io/bumblebee/tcpconnect": "over the 16 the registry *.dkr.ecr.*.amazonaws.com allows",
+		} {
+			Expect(rules.CheckRepository(ref)).To(MatchError(ContainSubstring(message)), ref)
+		}
+		// the known rules apply without a refs file
+		Expect((*spec.RefRules)(nil).CheckRepository("europe-docker.pkg.dev/tcpconnect:v1")).To(MatchError(ContainSubstring("at least 3 components")))
+	})
+
+	It("refuses to push refs breaking naming rules", func() {
+		dir, err := os.MkdirTemp("", "bee-refs")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		local := spec.NewLocalRegistry(dir, content.RegistryOptions{})
+		err = local.Push(context.Background(), "localhost:5000/solo-io/TCPConnect:v1", nil, &spec.EbpfPackage{ProgramFileBytes: []byte("prog")})
+		Expect(err).To(MatchError(ContainSubstring("has uppercase letters")))
+	})
+})
+
+var _ = Describe("registry errors", func() {
+	var (
+		storeDir string
+		registry *httptest.Server
+		host     string
+	)
+
+	BeforeEach(func() {
+		var err error
+		storeDir, err = os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		// a registry denying the repositories it does not have, as Docker Hub does, and
+		// granting the pulls of bee/private to bee only
+		var mu sync.Mutex
+		tokens := map[string]string{}
+		registry = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			challenge := fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, registry.URL)
+			switch {
+			case r.URL.Path == "/token" && r.Method == http.MethodPost:
+				w.WriteHeader(http.StatusNotFound)
+			case r.URL.Path == "/token":
+				user, pass, ok := r.BasicAuth()
+				if ok && (user != "bee" || pass != "secret") {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				access := []map[string]interface{}{}
+				scope := strings.Split(r.URL.Query().Get("scope"), ":")
+				if len(scope) == 3 && (scope[1] == "bee/public" || scope[1] == "bee/private" && ok) {
+					access = append(access, map[string]interface{}{"type": "repository", "name": scope[1], "actions": []string{"pull"}})
+				}
+				claims, _ := json.Marshal(map[string]interface{}{"access": access})
+				token := "e30." + base64.RawURLEncoding.EncodeToString(claims) + ".sig"
+				if len(access) > 0 {
+					tokens[token] = scope[1]
+				}
+				json.NewEncoder(w).Encode(map[string]string{"token": token})
+			case strings.HasPrefix(r.URL.Path, "/v2/bee/"):
+				// the manifests and tags of bee/<name>
+				repo := strings.Join(strings.SplitN(strings.TrimPrefix(r.URL.Path, "/v2/"), "/", 3)[:2], "/")
+				if tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] != repo {
+					w.Header().Set("WWW-Authenticate", challenge+fmt.Sprintf(`,scope="repository:%s:pull"`, repo))
+					w.WriteHeader(http.StatusUnauthorized)
+					w.Write([]byte(`{"errors":[{"code":"UNAUTHORIZED"}]}`))
+					return
+				}
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"errors":[{"code":"MANIFEST_UNKNOWN"}]}`))
+			default:
+				w.Header().Set("WWW-Authenticate", challenge)
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		}))
+		host = strings.TrimPrefix(registry.URL, "http://")
+	})
+
+	AfterEach(func() {
+		registry.Close()
+		os.RemoveAll(storeDir)
+	})
+
+	pull := func(ref string, opts content.RegistryOptions) error {
+		opts.PlainHTTP = true
+		remote, err := content.NewRegistry(opts)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = spec.NewLocalRegistry(storeDir, opts).Pull(context.Background(), host+"/"+ref, remote)
+		return err
+	}
+
+	It("tells missing packages apart from denied ones", func() {
+		creds := content.RegistryOptions{Username: "bee", Password: "secret"}
+		for ref, want := range map[string]error{
+			// denied, but the token of the credentials grants no access
+			"bee/missing:v1": spec.ErrNotFound,
+			// the token grants pulls of the repository, not the tag
+			"bee/private:v1": spec.ErrNotFound,
+		} {
+			err := pull(ref, creds)
+			Expect(err).To(MatchError(want), ref)
+			var registryErr *spec.RegistryError
+			Expect(errors.As(err, &registryErr)).To(BeTrue())
+			Expect(registryErr.Ref).To(Equal(host + "/" + ref))
+		}
+
+		Expect(pull("bee/public:v1", content.RegistryOptions{})).To(MatchError(spec.ErrNotFound))
+		err := pull("bee/private:v1", content.RegistryOptions{})
+		Expect(err).To(MatchError(spec.ErrUnauthorized))
+		Expect(err).To(MatchError(ContainSubstring("anonymous pulls")))
+		err = pull("bee/private:v1", content.RegistryOptions{Username: "bee", Password: "guess"})
+		Expect(err).To(MatchError(spec.ErrUnauthorized))
+		Expect(err).To(MatchError(ContainSubstring("refused the credentials")))
+		Expect(errors.Is(err, spec.ErrNotFound)).To(BeFalse())
+	})
+
+	It("classifies the denials of tag listings", func() {
+		_, err := spec.ListTags(context.Background(), host+"/bee/missing", content.RegistryOptions{PlainHTTP: true})
+		Expect(err).To(MatchError(spec.ErrUnauthorized))
+		_, err = spec.ListTags(context.Background(), host+"/bee/missing", content.RegistryOptions{Username: "bee", Password: "secret", PlainHTTP: true})
+		Expect(err).To(MatchError(spec.ErrNotFound))
+	})
+})
+
+var _ = Describe("MaintainRepository", func() {
+	var (
+		ctx    context.Context
+		dir    string
+		reg    *content.OCI
+		oldKey *ecdsa.PrivateKey
+		newKey *ecdsa.PrivateKey
+	)
+
+	push := func(ref, description string, key *ecdsa.PrivateKey) {
+		Expect(spec.NewEbpfOCICLient().Push(ctx, ref, reg, &apiv1.EbpfPackage{ProgramFileBytes: []byte(ref), Description: description})).To(Succeed())
+		if key != nil {
+			Expect(spec.Sign(ctx, ref, reg, key)).To(Succeed())
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		dir, err = os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		reg, err = content.NewOCI(dir)
+		Expect(err).NotTo(HaveOccurred())
+		oldKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		newKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("verifies, annotates and signs again each tag", func() {
+		other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		push("localhost:5000/org/app:v1.0", "counts packets", oldKey)
+		push("localhost:5000/org/app:v1.1", "  counts packets\n", oldKey)
+		push("localhost:5000/org/app:v2.0", "counts packets", other)
+
+		report, err := spec.MaintainRepository(ctx, "localhost:5000/org/app", reg, spec.MaintenanceOptions{
+			Verifier:    spec.NewKeyVerifier(&oldKey.PublicKey),
+			Key:         newKey,
+			Annotations: map[string]string{"org.opencontainers.image.vendor": "solo.io"},
+			Normalize:   true,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Tags).To(HaveLen(3))
+		Expect(report.Summary()).To(Equal("3 tags: 2 verified, 2 annotated, 2 signed, 1 failed"))
+		Expect(report.Tags[2].Ref).To(Equal("localhost:5000/org/app:v2.0"))
+		Expect(report.Tags[2].Error).To(ContainSubstring(spec.ErrBadSignature.Error()))
+
+		verified := spec.NewEbpfOCICLientWith(spec.ClientOptions{Verifier: spec.NewKeyVerifier(&newKey.PublicKey)})
+		pulled, err := verified.Pull(ctx, "localhost:5000/org/app:v1.1", reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pulled.Description).To(Equal("counts packets"))
+		info, err := spec.NewEbpfOCICLient().Inspect(ctx, "localhost:5000/org/app:v1.0", reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Annotations).To(HaveKeyWithValue("org.opencontainers.image.vendor", "solo.io"))
+		Expect(info.Manifest.Digest).To(Equal(report.Tags[0].Annotated))
+		// the tags failing verification are left as they are
+		_, err = verified.Pull(ctx, "localhost:5000/org/app:v2.0", reg)
+		Expect(err).To(MatchError(spec.ErrBadSignature))
+	})
+
+	It("keeps the signatures of the tags annotated without a key, and changes nothing on dry runs", func() {
+		push("localhost:5000/org/app:v1.0", " counts packets", oldKey)
+		push("localhost:5000/org/app:v1.1", " counts packets", nil)
+
+		report, err := spec.MaintainRepository(ctx, "localhost:5000/org/app", reg, spec.MaintenanceOptions{Normalize: true, Key: newKey, DryRun: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Summary()).To(Equal("2 tags: 0 verified, 2 annotated, 2 signed, 0 failed (dry run)"))
+		pulled, err := spec.NewEbpfOCICLient().Pull(ctx, "localhost:5000/org/app:v1.1", reg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pulled.Description).To(Equal(" counts packets"))
+
+		report, err = spec.MaintainRepository(ctx, "localhost:5000/org/app", reg, spec.MaintenanceOptions{Normalize: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Tags[0].Error).To(ContainSubstring("would lose its signature"))
+		Expect(report.Tags[1].Annotated).NotTo(BeEmpty())
+		_, err = spec.NewEbpfOCICLientWith(spec.ClientOptions{Verifier: spec.NewKeyVerifier(&oldKey.PublicKey)}).Pull(ctx, "localhost:5000/org/app:v1.0", reg)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
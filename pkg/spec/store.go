@@ -0,0 +1,150 @@
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/pkg/content"
+)
+
+// StoreUsage is the disk usage of a local package store. The store is an OCI image layout,
+// so blobs shared by several packages, e.g. the same program in different packages, are
+// only stored once.
+type StoreUsage struct {
+	Packages []PackageUsage
+	// Size of the blobs referenced by at least one package, each counted once
+	Stored int64
+	// Size the packages would take if each had its own copy of their blobs
+	Logical int64
+	// Size of the blobs no package references anymore
+	Unreferenced int64
+}
+
+// Saved returns the size saved by storing shared blobs once.
+func (u *StoreUsage) Saved() int64 {
+	return u.Logical - u.Stored
+}
+
+type PackageUsage struct {
+	Ref string
+	// Size of all the blobs of the package, including its manifests
+	Size int64
+	// Size of the blobs of the package also referenced by other packages
+	Shared int64
+}
+
+// LocalStoreUsage computes the disk usage of the packages of the local store.
+func LocalStoreUsage(localStorageDir string) (*StoreUsage, error) {
+	if localStorageDir == "" {
+		localStorageDir = EbpfImageDir
+	}
+	localRegistry, err := content.NewOCI(localStorageDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// blobs of each package, and the number of packages referencing each blob
+	packageBlobs := map[string]map[digest.Digest]int64{}
+	refCounts := map[digest.Digest]int{}
+	for ref, desc := range localRegistry.ListReferences() {
+		blobs := map[digest.Digest]int64{}
+		if err := collectBlobs(localStorageDir, desc, blobs); err != nil {
+			return nil, fmt.Errorf("could not read package %s: %w", ref, err)
+		}
+		packageBlobs[ref] = blobs
+		for dgst := range blobs {
+			refCounts[dgst]++
+		}
+	}
+
+	usage := &StoreUsage{}
+	stored := map[digest.Digest]bool{}
+	for ref, blobs := range packageBlobs {
+		pkgUsage := PackageUsage{Ref: ref}
+		for dgst, size := range blobs {
+			pkgUsage.Size += size
+			if refCounts[dgst] > 1 {
+				pkgUsage.Shared += size
+			}
+			if !stored[dgst] {
+				stored[dgst] = true
+				usage.Stored += size
+			}
+		}
+		usage.Logical += pkgUsage.Size
+		usage.Packages = append(usage.Packages, pkgUsage)
+	}
+	sort.Slice(usage.Packages, func(i, j int) bool {
+		return usage.Packages[i].Ref < usage.Packages[j].Ref
+	})
+
+	blobsDir := filepath.Join(localStorageDir, "blobs")
+	err = filepath.WalkDir(blobsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		alg, hex := filepath.Base(filepath.Dir(path)), d.Name()
+		if stored[digest.NewDigestFromEncoded(digest.Algorithm(alg), hex)] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		usage.Unreferenced += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list blobs: %w", err)
+	}
+	return usage, nil
+}
+
+// collectBlobs adds the descriptor, and the blobs it references if it is a manifest or index.
+func collectBlobs(localStorageDir string, desc ocispec.Descriptor, blobs map[digest.Digest]int64) error {
+	if _, ok := blobs[desc.Digest]; ok {
+		return nil
+	}
+	blobs[desc.Digest] = desc.Size
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageManifest:
+		var manifest ocispec.Manifest
+		if err := readBlob(localStorageDir, desc.Digest, &manifest); err != nil {
+			return err
+		}
+		if err := collectBlobs(localStorageDir, manifest.Config, blobs); err != nil {
+			return err
+		}
+		for _, layer := range manifest.Layers {
+			if err := collectBlobs(localStorageDir, layer, blobs); err != nil {
+				return err
+			}
+		}
+	case ocispec.MediaTypeImageIndex:
+		var index ocispec.Index
+		if err := readBlob(localStorageDir, desc.Digest, &index); err != nil {
+			return err
+		}
+		for _, manifest := range index.Manifests {
+			if err := collectBlobs(localStorageDir, manifest, blobs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func readBlob(localStorageDir string, dgst digest.Digest, v interface{}) error {
+	data, err := ioutil.ReadFile(filepath.Join(localStorageDir, "blobs", dgst.Algorithm().String(), dgst.Encoded()))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
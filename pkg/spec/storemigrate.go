@@ -0,0 +1,319 @@
+package spec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/pkg/content"
+)
+
+const (
+	// StoreVersion is the version of the layout of the local store written by this release,
+	// older stores being upgraded by MigrateStore
+	StoreVersion = 2
+	// StoreVersionFile records the version of the layout of a local store, in its directory.
+	// Stores without it are of version 0, unless they have no index yet.
+	StoreVersionFile = "bee-store.json"
+)
+
+// files of a store rewritten by migrations, which are backed up before. Migrations only
+// ever add blobs, as they are content addressed, so restoring them restores the store.
+var storeMetadataFiles = []string{"index.json", "oci-layout", StoreVersionFile}
+
+// storeMigration upgrades a store from a version to the next one.
+type storeMigration struct {
+	description string
+	// returns what it changed, or would change when dry running
+	apply func(ctx context.Context, dir string, dryRun bool) ([]string, error)
+}
+
+// storeMigrations upgrade the stores of the version of their index to the next one.
+var storeMigrations = []storeMigration{
+	{"drop the references of the index to manifests missing from the store, and the duplicate ones", cleanIndex},
+	{"rewrite the images from the deprecated v1 media types to " + MediaTypeV2, migrateStoreMediaTypes},
+}
+
+type storeVersion struct {
+	Version int `json:"version"`
+}
+
+// StoreMigrationOptions are the options of MigrateStore.
+type StoreMigrationOptions struct {
+	// Report what would change without changing anything
+	DryRun bool
+	// Directory the metadata of the store is copied to before it is changed, next to the store
+	// in a directory named after it, the version and the time if empty
+	BackupDir string
+}
+
+// StoreMigrationReport is what MigrateStore changed, or would change.
+type StoreMigrationReport struct {
+	From, To int
+	DryRun   bool
+	// Directory the store was backed up in, empty if nothing was changed
+	BackupDir string
+	Steps     []StoreMigrationStep
+}
+
+// StoreMigrationStep is what a migration from a version to the next one changed.
+type StoreMigrationStep struct {
+	From        int
+	Description string
+	Changes     []string
+}
+
+// DetectStoreVersion returns the version of the layout of the store in the directory. A store
+// without index is new, of StoreVersion. The stores written before the version was recorded
+// are of version 0, as are the ones created since and not migrated yet, which the migrations
+// leave as they are.
+func DetectStoreVersion(dir string) (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, StoreVersionFile))
+	if os.IsNotExist(err) {
+		if _, err := os.Stat(filepath.Join(dir, "index.json")); os.IsNotExist(err) {
+			return StoreVersion, nil
+		}
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var v storeVersion
+	if err := json.Unmarshal(data, &v); err != nil {
+		return 0, fmt.Errorf("could not parse %s: %w", filepath.Join(dir, StoreVersionFile), err)
+	}
+	return v.Version, nil
+}
+
+// MigrateStore upgrades the layout of the local store in the directory, of the version, to
+// StoreVersion, in place. The metadata of the store is backed up before it is changed, and
+// restored if a migration fails. Migrating a store already of StoreVersion does nothing, and a
+// store of a later version, written by a newer release, fails.
+func MigrateStore(ctx context.Context, dir string, fromVersion int, opts StoreMigrationOptions) (*StoreMigrationReport, error) {
+	if dir == "" {
+		dir = EbpfImageDir
+	}
+	report := &StoreMigrationReport{From: fromVersion, To: StoreVersion, DryRun: opts.DryRun}
+	switch {
+	case fromVersion > StoreVersion:
+		return nil, fmt.Errorf("the store %s is of version %d, written by a newer release of bee than this one, which reads version %d", dir, fromVersion, StoreVersion)
+	case fromVersion < 0:
+		return nil, fmt.Errorf("invalid store version %d", fromVersion)
+	case fromVersion == StoreVersion:
+		return report, nil
+	}
+
+	if !opts.DryRun {
+		backup := opts.BackupDir
+		if backup == "" {
+			backup = fmt.Sprintf("%s.backup-v%d-%s", filepath.Clean(dir), fromVersion, time.Now().UTC().Format("20060102T150405Z"))
+		}
+		if err := copyStoreMetadata(dir, backup); err != nil {
+			return nil, fmt.Errorf("could not back up the store %s: %w", dir, err)
+		}
+		report.BackupDir = backup
+	}
+	for version := fromVersion; version < StoreVersion; version++ {
+		migration := storeMigrations[version]
+		changes, err := migration.apply(ctx, dir, opts.DryRun)
+		if err != nil {
+			err = fmt.Errorf("could not migrate the store %s from version %d to %d, to %s: %w", dir, version, version+1, migration.description, err)
+			if report.BackupDir != "" {
+				if restoreErr := copyStoreMetadata(report.BackupDir, dir); restoreErr != nil {
+					return nil, fmt.Errorf("%v, nor restore it from %s: %v", err, report.BackupDir, restoreErr)
+				}
+			}
+			return nil, err
+		}
+		report.Steps = append(report.Steps, StoreMigrationStep{From: version, Description: migration.description, Changes: changes})
+		if !opts.DryRun {
+			if err := writeStoreVersion(dir, version+1); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return report, nil
+}
+
+func writeStoreVersion(dir string, version int) error {
+	data, err := json.Marshal(storeVersion{Version: version})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, StoreVersionFile), data)
+}
+
+// copyStoreMetadata copies the metadata files of a store to another directory, removing
+// the ones the source does not have.
+func copyStoreMetadata(from, to string) error {
+	if err := os.MkdirAll(to, 0755); err != nil {
+		return err
+	}
+	for _, name := range storeMetadataFiles {
+		data, err := ioutil.ReadFile(filepath.Join(from, name))
+		if os.IsNotExist(err) {
+			if err := os.Remove(filepath.Join(to, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(to, name), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file with the data, through a temporary file renamed over it.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// cleanIndex drops the manifests of the index whose blob is missing, e.g. as the store was
+// cleaned up by hand, which fail every operation listing the store, and the entries of a ref
+// after the first one, keeping the last, as older releases appended a ref tagged again.
+func cleanIndex(ctx context.Context, dir string, dryRun bool) ([]string, error) {
+	path := filepath.Join(dir, "index.json")
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+
+	var changes []string
+	last := map[string]int{}
+	for i, desc := range index.Manifests {
+		if ref, ok := desc.Annotations[ocispec.AnnotationRefName]; ok {
+			last[ref] = i
+		}
+	}
+	manifests := index.Manifests[:0]
+	for i, desc := range index.Manifests {
+		ref, tagged := desc.Annotations[ocispec.AnnotationRefName]
+		name := ref
+		if !tagged {
+			name = desc.Digest.String()
+		}
+		if tagged && last[ref] != i {
+			changes = append(changes, fmt.Sprintf("dropped the duplicate reference of %s to %s", ref, desc.Digest))
+			continue
+		}
+		if !hasBlob(dir, desc) {
+			changes = append(changes, fmt.Sprintf("dropped %s, whose manifest %s is missing", name, desc.Digest))
+			continue
+		}
+		manifests = append(manifests, desc)
+	}
+	if dryRun || len(changes) == 0 {
+		return changes, nil
+	}
+	index.Manifests = manifests
+	data, err = json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	return changes, writeFileAtomic(path, data)
+}
+
+func hasBlob(dir string, desc ocispec.Descriptor) bool {
+	_, err := os.Stat(filepath.Join(dir, "blobs", desc.Digest.Algorithm().String(), desc.Digest.Hex()))
+	return err == nil
+}
+
+// migrateStoreMediaTypes rewrites the images of the store with v1 media types, as
+// MigrateMediaTypes, so they are pushed with the v2 ones.
+func migrateStoreMediaTypes(ctx context.Context, dir string, dryRun bool) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(dir, "index.json")); os.IsNotExist(err) {
+		return nil, nil
+	}
+	store, err := content.NewOCI(dir)
+	if err != nil {
+		return nil, err
+	}
+	var refs []string
+	for ref, desc := range store.ListReferences() {
+		// dropped by cleanIndex, unless dry running
+		if !hasBlob(dir, desc) {
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	var changes []string
+	for _, ref := range refs {
+		if dryRun {
+			legacy, err := hasLegacyMediaTypes(ctx, store, ref)
+			if err != nil {
+				return nil, fmt.Errorf("could not read %s: %w", ref, err)
+			}
+			if legacy {
+				changes = append(changes, fmt.Sprintf("rewrote %s with the v2 media types", ref))
+			}
+			continue
+		}
+		desc, migrated, err := MigrateMediaTypes(ctx, ref, store)
+		if err != nil {
+			return nil, fmt.Errorf("could not migrate %s: %w", ref, err)
+		}
+		if migrated {
+			changes = append(changes, fmt.Sprintf("rewrote %s with the v2 media types, as %s", ref, desc.Digest))
+		}
+	}
+	if dryRun || len(changes) == 0 {
+		return changes, nil
+	}
+	return changes, store.SaveIndex()
+}
+
+// hasLegacyMediaTypes returns whether the image of the ref, or a package of its index, has
+// blobs of the v1 media types.
+func hasLegacyMediaTypes(ctx context.Context, store *content.OCI, ref string) (bool, error) {
+	_, desc, err := store.Resolve(ctx, ref)
+	if err != nil {
+		return false, err
+	}
+	manifests := []ocispec.Descriptor{desc}
+	if desc.MediaType == ocispec.MediaTypeImageIndex {
+		index, err := fetchIndex(ctx, store, ref, desc)
+		if err != nil {
+			return false, err
+		}
+		manifests = index.Manifests
+	}
+	for _, desc := range manifests {
+		if desc.MediaType != ocispec.MediaTypeImageManifest {
+			continue
+		}
+		if _, legacy, err := migrateManifest(ctx, store, ref, desc, ""); err != nil || legacy {
+			return legacy, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,195 @@
+package spec
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/opencontainers/go-digest"
+	auth "oras.land/oras-go/pkg/auth/docker"
+	"oras.land/oras-go/pkg/content"
+)
+
+// maxTagPages bounds the pages of tags listed, registries returning 100 tags or more per page
+const maxTagPages = 10
+
+var nextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// ListTags lists the tags of a repository, e.g. ghcr.io/solo-io/bumblebee/tcpconnect, with
+// the tag listing API of the registry.
+func ListTags(ctx context.Context, repo string, opts content.RegistryOptions) ([]string, error) {
+	api, err := newRegistryAPI(repo, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	next := api.base + "/tags/list"
+	for page := 0; next != "" && page < maxTagPages; page++ {
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		link, err := api.getJSON(ctx, next, &list)
+		if err != nil {
+			return nil, ClassifyRegistryError(ctx, repo, opts, fmt.Errorf("could not list the tags of %s: %w", repo, err))
+		}
+		tags = append(tags, list.Tags...)
+		next = ""
+		if m := nextLink.FindStringSubmatch(link); m != nil {
+			next = m[1]
+			if strings.HasPrefix(next, "/") {
+				next = fmt.Sprintf("%s://%s%s", api.scheme, api.host, next)
+			}
+		}
+	}
+	return tags, nil
+}
+
+// DeleteManifest deletes the manifest of the digest from the repository of the registry,
+// untagging all the tags of the manifest.
+func DeleteManifest(ctx context.Context, repo string, dgst digest.Digest, opts content.RegistryOptions) error {
+	api, err := newRegistryAPI(repo, opts)
+	if err != nil {
+		return err
+	}
+	resp, err := api.do(ctx, http.MethodDelete, api.base+"/manifests/"+dgst.String())
+	if err != nil {
+		return fmt.Errorf("could not delete %s@%s: %w", repo, dgst, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("could not delete %s@%s: unexpected status %s: %s", repo, dgst, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// registryAPI calls the distribution API of the registry of a repository.
+type registryAPI struct {
+	client     *http.Client
+	authorizer docker.Authorizer
+	scheme     string
+	host       string
+	// name of the repository, e.g. solo-io/bumblebee/tcpconnect
+	name string
+	// URL of the repository, e.g. https://ghcr.io/v2/solo-io/bumblebee/tcpconnect
+	base string
+}
+
+func newRegistryAPI(repo string, opts content.RegistryOptions) (*registryAPI, error) {
+	spec, err := reference.Parse(repo)
+	if err != nil {
+		return nil, err
+	}
+	host := spec.Hostname()
+	name := strings.TrimPrefix(spec.Locator, host+"/")
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	scheme := "https"
+	if opts.PlainHTTP {
+		scheme = "http"
+	}
+
+	client := &http.Client{}
+	if opts.Insecure {
+		client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+	authorizer := docker.NewDockerAuthorizer(
+		docker.WithAuthClient(client),
+		docker.WithAuthCreds(registryCredentials(opts)),
+	)
+	return &registryAPI{
+		client:     client,
+		authorizer: authorizer,
+		scheme:     scheme,
+		host:       host,
+		name:       name,
+		base:       fmt.Sprintf("%s://%s/v2/%s", scheme, host, name),
+	}, nil
+}
+
+// getJSON decodes the JSON response of the URL, and returns its Link header.
+func (a *registryAPI) getJSON(ctx context.Context, url string, out interface{}) (string, error) {
+	resp, err := a.do(ctx, http.MethodGet, url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", &statusError{status: resp.Status, code: resp.StatusCode, body: strings.TrimSpace(string(body))}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return "", err
+	}
+	return resp.Header.Get("Link"), nil
+}
+
+// do sends the request, authenticating to the registry if it challenges it.
+func (a *registryAPI) do(ctx context.Context, method, url string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.authorizer.Authorize(ctx, req); err != nil {
+			return nil, err
+		}
+		resp, err := a.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			err := a.authorizer.AddResponses(ctx, []*http.Response{resp})
+			resp.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return resp, nil
+	}
+}
+
+// statusError is the unexpected status of a response of the registry.
+type statusError struct {
+	status string
+	code   int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status %s: %s", e.status, e.body)
+}
+
+// registryCredentials returns the credentials of the options, or of their config files,
+// as used by content.NewRegistry.
+func registryCredentials(opts content.RegistryOptions) func(string) (string, string, error) {
+	if opts.Username != "" || opts.Password != "" {
+		return func(string) (string, string, error) {
+			return opts.Username, opts.Password, nil
+		}
+	}
+	return func(host string) (string, string, error) {
+		cli, err := auth.NewClient(opts.Configs...)
+		if err != nil {
+			return "", "", nil
+		}
+		if creds, ok := cli.(interface {
+			Credential(hostname string) (string, string, error)
+		}); ok {
+			return creds.Credential(host)
+		}
+		return "", "", nil
+	}
+}
@@ -0,0 +1,407 @@
+package spec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	ctrcontent "github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes"
+	"github.com/docker/go-units"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"golang.org/x/sync/semaphore"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/oras"
+	"oras.land/oras-go/pkg/target"
+)
+
+// DefaultConcurrency is the number of blobs transferred at once by default.
+const DefaultConcurrency = 4
+
+// progress is reported at most this often while a blob is transferred
+const progressInterval = 100 * time.Millisecond
+
+var errIncomplete = errors.New("the transfer of the blob was interrupted")
+
+// Progress is the progress of the transfer of a blob.
+type Progress struct {
+	Descriptor ocispec.Descriptor
+	// Bytes transferred so far, out of the size of the descriptor
+	Transferred int64
+	Started     time.Time
+	// Set once the blob is transferred, or failed to be with Err
+	Done bool
+	Err  error
+	// Set, along with Done, for the blobs the destination already has, e.g. the local store
+	// when pulling, which are reused rather than transferred again
+	Reused bool
+}
+
+// Name returns the title of the blob, e.g. program.o, or its media type.
+func (p Progress) Name() string {
+	if title := p.Descriptor.Annotations[ocispec.AnnotationTitle]; title != "" {
+		return title
+	}
+	return p.Descriptor.MediaType
+}
+
+// Throughput returns the bytes transferred per second since the transfer started.
+func (p Progress) Throughput() float64 {
+	elapsed := time.Since(p.Started).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Transferred) / elapsed
+}
+
+// ProgressFunc is called as blobs are transferred, concurrently for the blobs transferred at
+// once.
+type ProgressFunc func(Progress)
+
+// ProgressWriter returns a ProgressFunc printing a line per blob once transferred, with its
+// size and throughput, e.g. to the logs of a CI job.
+func ProgressWriter(w io.Writer) ProgressFunc {
+	var lock sync.Mutex
+	return func(p Progress) {
+		if !p.Done {
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if p.Reused {
+			fmt.Fprintf(w, "%s: %s reused\n", p.Name(), units.BytesSize(float64(p.Descriptor.Size)))
+			return
+		}
+		if p.Err != nil {
+			fmt.Fprintf(w, "%s: failed after %s: %v\n", p.Name(), units.BytesSize(float64(p.Transferred)), p.Err)
+			return
+		}
+		fmt.Fprintf(w, "%s: %s at %s/s\n", p.Name(), units.BytesSize(float64(p.Transferred)), units.BytesSize(p.Throughput()))
+	}
+}
+
+// ProgressTracker sums up the progress of the blobs of transfers, e.g. to render it in a
+// spinner.
+type ProgressTracker struct {
+	lock    sync.Mutex
+	blobs   map[digest.Digest]Progress
+	started time.Time
+}
+
+// Update records the progress of a blob.
+func (t *ProgressTracker) Update(p Progress) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.blobs == nil {
+		t.blobs = map[digest.Digest]Progress{}
+		t.started = p.Started
+	}
+	t.blobs[p.Descriptor.Digest] = p
+}
+
+// Bytes returns the bytes transferred so far, and the ones of the blobs reused from the
+// destination.
+func (t *ProgressTracker) Bytes() (transferred, reused int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, p := range t.blobs {
+		if p.Reused {
+			reused += p.Descriptor.Size
+		} else {
+			transferred += p.Transferred
+		}
+	}
+	return transferred, reused
+}
+
+// String formats the progress of all the blobs, e.g. "2/3 blobs, 12MiB of 40MiB at 3MiB/s",
+// followed by the bytes reused, e.g. ", 80MiB reused".
+func (t *ProgressTracker) String() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var done, count int
+	var transferred, total, reused int64
+	for _, p := range t.blobs {
+		if p.Reused {
+			reused += p.Descriptor.Size
+			continue
+		}
+		count++
+		if p.Done && p.Err == nil {
+			done++
+		}
+		transferred += p.Transferred
+		total += p.Descriptor.Size
+	}
+	var throughput float64
+	if elapsed := time.Since(t.started).Seconds(); elapsed > 0 {
+		throughput = float64(transferred) / elapsed
+	}
+	progress := fmt.Sprintf("%d/%d blobs, %s of %s at %s/s",
+		done, count,
+		units.BytesSize(float64(transferred)),
+		units.BytesSize(float64(total)),
+		units.BytesSize(throughput),
+	)
+	if reused > 0 {
+		progress += fmt.Sprintf(", %s reused", units.BytesSize(float64(reused)))
+	}
+	return progress
+}
+
+// TransferOptions configure how the blobs of packages are copied between targets. Blobs are
+// streamed from the source to the destination, several at once, and the transfers abort as
+// soon as their context is done.
+type TransferOptions struct {
+	// Progress, if set, is reported as the blobs are read from the source
+	Progress ProgressFunc
+	// Maximum number of blobs transferred at once, defaults to DefaultConcurrency
+	Concurrency int
+}
+
+// Copy copies the package of the ref, or the image index of a multi-variant package, from a
+// target to another. The blobs the destination already has, by digest, are not transferred,
+// and reported as Reused.
+func Copy(ctx context.Context, from target.Target, ref string, to target.Target, opts TransferOptions) (ocispec.Descriptor, error) {
+	return oras.Copy(
+		ctx,
+		opts.track(from),
+		ref,
+		opts.trackReused(to),
+		"",
+		oras.WithAllowedMediaTypes(AllowedMediaTypes()),
+	)
+}
+
+// PushFromFile pushes the package with the program streamed from the file rather than
+// held in ProgramFileBytes, which is ignored, so large programs are never read in memory.
+func PushFromFile(
+	ctx context.Context,
+	ref string,
+	registry target.Target,
+	programFile string,
+	pkg *v1.EbpfPackage,
+	opts TransferOptions,
+) error {
+	f, err := os.Open(programFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	dgst, err := digest.FromReader(f)
+	if err != nil {
+		return fmt.Errorf("could not digest %s: %w", programFile, err)
+	}
+	progDesc := ocispec.Descriptor{
+		MediaType:   layerMediaType(SubtypeProgram, packageArch(pkg), false),
+		Digest:      dgst,
+		Size:        info.Size(),
+		Annotations: map[string]string{ocispec.AnnotationTitle: ebpfFileName},
+	}
+
+	memoryStore := content.NewMemory()
+	manifestDesc, manifest, err := storeLayers(memoryStore, progDesc, pkg, false)
+	if err != nil {
+		return err
+	}
+	if err := memoryStore.StoreManifest(ref, manifestDesc, manifest); err != nil {
+		return err
+	}
+	_, err = Copy(ctx, &fileBlob{Target: memoryStore, desc: progDesc, path: programFile}, ref, registry, opts)
+	return err
+}
+
+// PullToDir pulls the package of the ref, for multi-variant images the one of the host, into
+// the directory, writing each layer and the config to the file named after its title, e.g.
+// program.o and config.json. The package is streamed to the files rather than held in memory.
+func PullToDir(ctx context.Context, ref string, registry target.Target, dir string, opts TransferOptions) (*v1.EbpfPackageInfo, error) {
+	info, err := NewEbpfOCICLient().Inspect(ctx, ref, registry)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	for _, desc := range append([]ocispec.Descriptor{info.Config}, info.Layers...) {
+		// the files must stay in the directory
+		if title := desc.Annotations[ocispec.AnnotationTitle]; title == "" || strings.ContainsAny(title, "/\\") || title == ".." {
+			return nil, fmt.Errorf("invalid title %q of blob %s", title, desc.Digest)
+		}
+	}
+
+	copyOpts := []oras.CopyOpt{oras.WithAllowedMediaTypes(AllowedMediaTypes())}
+	if info.Index != nil {
+		copyOpts = append(copyOpts, oras.WithPullBaseHandler(skipOtherManifests(info.Manifest.Digest)))
+	}
+	store := content.NewFile(dir, content.WithIgnoreNoName())
+	defer store.Close()
+	if _, err := oras.Copy(ctx, opts.track(registry), ref, store, "", copyOpts...); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// fileBlob is a target serving a blob from a file, and the others from the target.
+type fileBlob struct {
+	target.Target
+	desc ocispec.Descriptor
+	path string
+}
+
+func (f *fileBlob) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := f.Target.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return remotes.FetcherFunc(func(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+		if desc.Digest == f.desc.Digest {
+			return os.Open(f.path)
+		}
+		return fetcher.Fetch(ctx, desc)
+	}), nil
+}
+
+// track returns the target with its reads bounded by the concurrency, reported as progress,
+// and aborted once their context is done.
+func (o TransferOptions) track(t target.Target) target.Target {
+	concurrency := o.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	return &trackedTarget{Target: t, progress: o.Progress, sem: semaphore.NewWeighted(int64(concurrency))}
+}
+
+type trackedTarget struct {
+	target.Target
+	progress ProgressFunc
+	sem      *semaphore.Weighted
+}
+
+func (t *trackedTarget) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := t.Target.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return remotes.FetcherFunc(func(ctx context.Context, desc ocispec.Descriptor) (io.ReadCloser, error) {
+		if err := t.sem.Acquire(ctx, 1); err != nil {
+			return nil, err
+		}
+		rc, err := fetcher.Fetch(ctx, desc)
+		if err != nil {
+			t.sem.Release(1)
+			return nil, err
+		}
+		r := &trackedReader{
+			ctx:      ctx,
+			rc:       rc,
+			report:   t.progress,
+			release:  func() { t.sem.Release(1) },
+			progress: Progress{Descriptor: desc, Started: time.Now()},
+		}
+		r.reportProgress()
+		return r, nil
+	}), nil
+}
+
+// trackReused returns the target with the blobs it already has reported as reused.
+func (o TransferOptions) trackReused(t target.Target) target.Target {
+	if o.Progress == nil {
+		return t
+	}
+	return &reusedTarget{Target: t, progress: o.Progress}
+}
+
+type reusedTarget struct {
+	target.Target
+	progress ProgressFunc
+}
+
+func (t *reusedTarget) Pusher(ctx context.Context, ref string) (remotes.Pusher, error) {
+	pusher, err := t.Target.Pusher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return pusherFunc(func(ctx context.Context, desc ocispec.Descriptor) (ctrcontent.Writer, error) {
+		w, err := pusher.Push(ctx, desc)
+		// manifests are always fetched, to find their blobs
+		if errdefs.IsAlreadyExists(err) && desc.MediaType != ocispec.MediaTypeImageManifest && desc.MediaType != ocispec.MediaTypeImageIndex {
+			t.progress(Progress{Descriptor: desc, Started: time.Now(), Done: true, Reused: true})
+		}
+		return w, err
+	}), nil
+}
+
+type pusherFunc func(ctx context.Context, desc ocispec.Descriptor) (ctrcontent.Writer, error)
+
+func (f pusherFunc) Push(ctx context.Context, desc ocispec.Descriptor) (ctrcontent.Writer, error) {
+	return f(ctx, desc)
+}
+
+type trackedReader struct {
+	ctx        context.Context
+	rc         io.ReadCloser
+	report     ProgressFunc
+	release    func()
+	progress   Progress
+	lastReport time.Time
+	once       sync.Once
+}
+
+func (r *trackedReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		r.finish(err)
+		return 0, err
+	}
+	n, err := r.rc.Read(p)
+	r.progress.Transferred += int64(n)
+	switch {
+	case err == io.EOF:
+		r.finish(nil)
+	case err != nil:
+		r.finish(err)
+	case time.Since(r.lastReport) >= progressInterval:
+		r.reportProgress()
+	}
+	return n, err
+}
+
+func (r *trackedReader) Close() error {
+	err := r.rc.Close()
+	if r.progress.Transferred >= r.progress.Descriptor.Size {
+		r.finish(nil)
+	} else if ctxErr := r.ctx.Err(); ctxErr != nil {
+		r.finish(ctxErr)
+	} else {
+		r.finish(errIncomplete)
+	}
+	return err
+}
+
+func (r *trackedReader) reportProgress() {
+	r.lastReport = time.Now()
+	if r.report != nil {
+		r.report(r.progress)
+	}
+}
+
+// finish reports the blob as done and releases its slot, once.
+func (r *trackedReader) finish(err error) {
+	r.once.Do(func() {
+		r.progress.Done = true
+		r.progress.Err = err
+		r.reportProgress()
+		r.release()
+	})
+}
@@ -0,0 +1,141 @@
+package spec
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+)
+
+// Transform transforms the packages pulled by a client before the pull returns them, e.g. to
+// strip the debug sections of their programs, the packages of the store being left as is.
+type Transform struct {
+	// Name of the transform, recorded in the Transforms of the packages it transformed
+	Name string
+	// Order the transforms run in, ascending, transforms of the same order running in the
+	// order they are given
+	Order int
+	// Apply transforms the package of the ref, failing the pull if it returns an error
+	Apply func(ctx context.Context, ref string, pkg *v1.EbpfPackage) error
+}
+
+// applyTransforms applies the transforms to the package, in order, recording their names in
+// its Transforms.
+func applyTransforms(ctx context.Context, ref string, pkg *v1.EbpfPackage, transforms []Transform) error {
+	ordered := append([]Transform(nil), transforms...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Order < ordered[j].Order
+	})
+	for _, transform := range ordered {
+		if err := transform.Apply(ctx, ref, pkg); err != nil {
+			return fmt.Errorf("could not transform %s with %s: %w", ref, transform.Name, err)
+		}
+		pkg.Transforms = append(pkg.Transforms, transform.Name)
+	}
+	return nil
+}
+
+// StripDebugInfo is a transform stripping the DWARF sections of the ELF objects of packages,
+// which are not loaded, to save the memory of the packages held by long running agents. The
+// BTF sections are kept.
+var StripDebugInfo = Transform{
+	Name: "strip-debug-info",
+	Apply: func(_ context.Context, _ string, pkg *v1.EbpfPackage) error {
+		stripped, err := stripDebugSections(pkg.ProgramFileBytes)
+		if err != nil {
+			return err
+		}
+		pkg.ProgramFileBytes = stripped
+		for i, obj := range pkg.Objects {
+			stripped, err := stripDebugSections(obj.Bytes)
+			if err != nil {
+				return fmt.Errorf("object %s: %w", obj.Name, err)
+			}
+			pkg.Objects[i].Bytes = stripped
+		}
+		return nil
+	},
+}
+
+// The offsets of the fields of the ELF64 header and section headers rewritten when stripping.
+const (
+	elf64ShoffOffset       = 0x28
+	elf64EhsizeOffset      = 0x34
+	elf64ShentsizeOffset   = 0x3a
+	elf64SectionTypeOffset = 4
+	elf64SectionOffOffset  = 24
+	elf64SectionSizeOffset = 32
+)
+
+// stripDebugSections returns the ELF object without the content of its .debug_* sections and
+// of their relocations, whose headers become SHT_NULL so the indexes of the other sections,
+// and of the symbols referencing them, are unchanged.
+func stripDebugSections(obj []byte) ([]byte, error) {
+	f, err := elf.NewFile(bytes.NewReader(obj))
+	if err != nil {
+		return nil, err
+	}
+	if f.Class != elf.ELFCLASS64 || f.Type != elf.ET_REL {
+		return nil, fmt.Errorf("only 64-bit relocatable ELF objects can be stripped, not %s %s", f.Class, f.Type)
+	}
+
+	debug := make([]bool, len(f.Sections))
+	found := false
+	for i, sec := range f.Sections {
+		name := strings.TrimPrefix(strings.TrimPrefix(sec.Name, ".rela"), ".rel")
+		debug[i] = strings.HasPrefix(name, ".debug_")
+		found = found || debug[i]
+	}
+	if !found {
+		return obj, nil
+	}
+
+	order := f.ByteOrder
+	shoff := order.Uint64(obj[elf64ShoffOffset:])
+	shentsize := uint64(order.Uint16(obj[elf64ShentsizeOffset:]))
+	headers := append([]byte(nil), obj[shoff:shoff+shentsize*uint64(len(f.Sections))]...)
+
+	// the header is copied, then the kept sections in the order of their offsets
+	byOffset := make([]int, 0, len(f.Sections))
+	for i := range f.Sections {
+		byOffset = append(byOffset, i)
+	}
+	sort.SliceStable(byOffset, func(i, j int) bool {
+		return f.Sections[byOffset[i]].Offset < f.Sections[byOffset[j]].Offset
+	})
+	out := append([]byte(nil), obj[:order.Uint16(obj[elf64EhsizeOffset:])]...)
+	for _, i := range byOffset {
+		sec := f.Sections[i]
+		header := headers[uint64(i)*shentsize:]
+		if debug[i] {
+			order.PutUint32(header[elf64SectionTypeOffset:], uint32(elf.SHT_NULL))
+			order.PutUint64(header[elf64SectionOffOffset:], 0)
+			order.PutUint64(header[elf64SectionSizeOffset:], 0)
+			continue
+		}
+		if sec.Type == elf.SHT_NULL || sec.Type == elf.SHT_NOBITS {
+			continue
+		}
+		out = pad(out, sec.Addralign)
+		order.PutUint64(header[elf64SectionOffOffset:], uint64(len(out)))
+		out = append(out, obj[sec.Offset:sec.Offset+sec.FileSize]...)
+	}
+	out = pad(out, 8)
+	order.PutUint64(out[elf64ShoffOffset:], uint64(len(out)))
+	return append(out, headers...), nil
+}
+
+// pad pads the bytes with zeros to be aligned.
+func pad(b []byte, align uint64) []byte {
+	if align <= 1 {
+		return b
+	}
+	for uint64(len(b))%align != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
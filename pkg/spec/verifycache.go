@@ -0,0 +1,143 @@
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// VerificationCache caches the packages verified by a Verifier, by the digest of their manifest
+// and the hash of the keys or trust policy they were verified with, for its TTL. Only successful
+// verifications are cached: unsigned packages are verified again on every pull, so signing them
+// takes effect right away.
+type VerificationCache struct {
+	ttl  time.Duration
+	path string
+
+	mu sync.Mutex
+	// verification time of the keys digest/policy hash
+	entries map[string]time.Time
+}
+
+// NewVerificationCache returns an in-memory cache of the verifications of the last TTL.
+func NewVerificationCache(ttl time.Duration) *VerificationCache {
+	return &VerificationCache{ttl: ttl, entries: map[string]time.Time{}}
+}
+
+// OpenVerificationCache returns a cache of the verifications of the last TTL persisted in the
+// file, so they are shared by the processes pulling from the same local store. The file is read
+// again by every lookup, so invalidations by other processes are seen.
+func OpenVerificationCache(path string, ttl time.Duration) (*VerificationCache, error) {
+	c := NewVerificationCache(ttl)
+	c.path = path
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Invalidate removes the verifications of the manifest of the digest, whatever their policy.
+func (c *VerificationCache) Invalidate(dgst digest.Digest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.load(); err != nil {
+		return err
+	}
+	for key := range c.entries {
+		if strings.HasPrefix(key, dgst.String()+"/") {
+			delete(c.entries, key)
+		}
+	}
+	return c.save()
+}
+
+// InvalidateAll removes all the verifications, e.g. after a key was revoked.
+func (c *VerificationCache) InvalidateAll() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]time.Time{}
+	return c.save()
+}
+
+// verified reports whether the manifest of the digest was verified under the policy hash
+// within the TTL.
+func (c *VerificationCache) verified(dgst digest.Digest, policyHash string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.load(); err != nil {
+		return false
+	}
+	at, ok := c.entries[dgst.String()+"/"+policyHash]
+	return ok && time.Since(at) < c.ttl
+}
+
+func (c *VerificationCache) add(dgst digest.Digest, policyHash string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.load(); err != nil {
+		return err
+	}
+	now := time.Now()
+	for key, at := range c.entries {
+		if now.Sub(at) >= c.ttl {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[dgst.String()+"/"+policyHash] = now
+	return c.save()
+}
+
+// load reads the entries of persisted caches.
+func (c *VerificationCache) load() error {
+	if c.path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		c.entries = map[string]time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entries := map[string]time.Time{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("could not parse verification cache %s: %w", c.path, err)
+	}
+	c.entries = entries
+	return nil
+}
+
+// save writes the entries to the file of persisted caches, replacing it atomically.
+func (c *VerificationCache) save() error {
+	if c.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
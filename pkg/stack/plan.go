@@ -0,0 +1,347 @@
+package stack
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/internal/uname"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"github.com/solo-io/bumblebee/pkg/vmlinux"
+	"oras.land/oras-go/pkg/content"
+)
+
+// Plan is what running a stack would do on this host, computed without loading, attaching
+// or storing anything.
+type Plan struct {
+	Programs []ProgramPlan
+	// Estimated kernel memory of the maps of all the programs
+	MemoryBytes uint64
+}
+
+// OK returns whether no program of the plan has a problem, so the stack should run.
+func (p *Plan) OK() bool {
+	for _, prog := range p.Programs {
+		if len(prog.Problems) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ProgramPlan is what running a program of a stack would do.
+type ProgramPlan struct {
+	Name string
+	Ref  string
+	// Digest of the manifest the ref resolves to, empty for program files
+	Digest digest.Digest
+	// Hooks the programs of the ELF file would be attached to
+	Hooks []Hook
+	// Estimated kernel memory of the maps of the program
+	Usage loader.Usage
+	// What the programs can affect, only set for enforcing packages
+	BlastRadius *loader.BlastRadius
+	// Why the program would fail to run on this host, e.g. a missing kernel function
+	Problems []string
+}
+
+// Hook is where a program would be attached.
+type Hook struct {
+	// Name of the function of the program
+	Program string
+	// kprobe, kretprobe, tracepoint, xdp, tc-ingress or tc-egress
+	Type string
+	// Kernel function, tracepoint or network interface attached to
+	Target string
+	// Network namespaces the interface is in, the one of bee if empty
+	Netns    []string
+	HostVeth bool
+}
+
+// host is the kernel the programs of a plan are checked against.
+type host struct {
+	release string
+	arch    []string
+	btf     bool
+	// checks the features of the host, unknown if nil
+	checkFeatures func([]v1.HostFeature) []v1.Prerequisite
+	// kernel functions by name, unknown if nil
+	symbols map[string]bool
+	// tracepoints by category/name, unknown if nil
+	tracepoints map[string]bool
+	// network interfaces of the namespace of bee by name, unknown if nil
+	interfaces map[string]bool
+}
+
+// Plan resolves, parses and validates the programs of the stack as Run does, then reports
+// the hooks they would attach to, the memory of their maps and why they would fail to run on
+// this host. Packages missing from the store are read from their registry without being
+// stored, and nothing is loaded into the kernel.
+func (s *Stack) Plan(ctx context.Context, opts RunOptions) *Plan {
+	if opts.Registry == nil {
+		opts.Registry = spec.NewLocalRegistry("", content.RegistryOptions{})
+	}
+	return s.plan(ctx, opts, hostKernel())
+}
+
+func (s *Stack) plan(ctx context.Context, opts RunOptions, h *host) *Plan {
+	plan := &Plan{}
+	cpus := loader.PossibleCPUs()
+	enrichers, enrichersErr := s.enrichers()
+	for _, p := range s.Programs {
+		progPlan := ProgramPlan{Name: p.Name, Ref: p.Ref}
+		if enrichersErr != nil {
+			progPlan.Problems = append(progPlan.Problems, enrichersErr.Error())
+		}
+		pkg, err := readProgram(ctx, p, opts.Registry.Peek)
+		if err != nil {
+			progPlan.Problems = append(progPlan.Problems, err.Error())
+			plan.Programs = append(plan.Programs, progPlan)
+			continue
+		}
+		progPlan.Digest = pkg.Digest
+		progPlan.Problems = append(progPlan.Problems, h.packageProblems(pkg)...)
+
+		prog, err := parseProgram(ctx, p, pkg, &prefixedProvider{prefix: p.Name}, enrichers)
+		if err != nil {
+			progPlan.Problems = append(progPlan.Problems, err.Error())
+			plan.Programs = append(plan.Programs, progPlan)
+			continue
+		}
+		if pkg.Enforcing {
+			radius := loader.EstimateBlastRadius(prog.loadOpts)
+			progPlan.BlastRadius = &radius
+			if !p.Scope.ConfirmEnforcing {
+				progPlan.Problems = append(progPlan.Problems, "the package is enforcing, and its blast radius is not confirmed with confirmEnforcing in the scope of the program")
+			}
+		}
+		progSpec := prog.loadOpts.ParsedELF.Spec
+		// only the variants selected for the host are attached
+		if h.release != "" {
+			if _, err := loader.SelectProbeVariants(progSpec, pkg.ProbeVariants, h.release); err != nil {
+				progPlan.Problems = append(progPlan.Problems, err.Error())
+			}
+		}
+		progPlan.Usage = loader.EstimateUsage(progSpec, cpus)
+		plan.MemoryBytes += progPlan.Usage.Total
+
+		names := make([]string, 0, len(progSpec.Programs))
+		for name := range progSpec.Programs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			hook, problem := h.hook(progSpec.Programs[name], p.Scope)
+			if hook != nil {
+				progPlan.Hooks = append(progPlan.Hooks, *hook)
+			}
+			if problem != "" {
+				progPlan.Problems = append(progPlan.Problems, problem)
+			}
+		}
+		plan.Programs = append(plan.Programs, progPlan)
+	}
+	return plan
+}
+
+// hook returns where the program would be attached, and why it could not be, if nil the
+// program is not attached.
+func (h *host) hook(prog *ebpf.ProgramSpec, scope Scope) (*Hook, string) {
+	switch prog.Type {
+	case ebpf.Kprobe:
+		hook := &Hook{Program: prog.Name, Type: "kprobe", Target: prog.AttachTo}
+		if strings.HasPrefix(prog.SectionName, "kretprobe/") {
+			hook.Type = "kretprobe"
+		}
+		if loader.IsProbeGlob(prog.AttachTo) {
+			return hook, h.globProblem(prog, hook, scope)
+		}
+		if h.symbols != nil && !h.symbols[prog.AttachTo] {
+			return hook, fmt.Sprintf("the kernel has no function %s for the %s '%s'", prog.AttachTo, hook.Type, prog.Name)
+		}
+		if reason := loader.DangerousProbe(prog.AttachTo); reason != "" && !scope.AllowDangerousProbes {
+			return hook, fmt.Sprintf("the %s '%s' is attached to %s, %s, and dangerous probes are not allowed", hook.Type, prog.Name, prog.AttachTo, reason)
+		}
+		return hook, ""
+	case ebpf.TracePoint:
+		if !strings.HasPrefix(prog.SectionName, "tracepoint/") {
+			return nil, ""
+		}
+		hook := &Hook{Program: prog.Name, Type: "tracepoint", Target: prog.AttachTo}
+		if h.tracepoints != nil && !h.tracepoints[prog.AttachTo] {
+			return hook, fmt.Sprintf("the kernel has no tracepoint %s for '%s'", prog.AttachTo, prog.Name)
+		}
+		return hook, ""
+	case ebpf.XDP, ebpf.SchedCLS:
+		hook := &Hook{Program: prog.Name, Type: "xdp", Target: scope.Interface, Netns: scope.Netns, HostVeth: scope.HostVeth}
+		if prog.Type == ebpf.SchedCLS {
+			hook.Type = "tc-ingress"
+			if strings.HasSuffix(prog.SectionName, "/egress") {
+				hook.Type = "tc-egress"
+			}
+		}
+		if scope.Interface == "" {
+			return hook, fmt.Sprintf("the %s program '%s' requires a network interface to be attached to", prog.Type, prog.Name)
+		}
+		// the interfaces of other namespaces are only known once they are entered
+		if len(scope.Netns) == 0 && h.interfaces != nil && scope.ReattachInterval == 0 && !h.hasInterface(scope.Interface) {
+			return hook, fmt.Sprintf("there is no network interface %s for '%s'", scope.Interface, prog.Name)
+		}
+		return hook, ""
+	default:
+		return nil, fmt.Sprintf("'%s' is a %s program, only kprobe, tracepoint, XDP and TC programs are supported", prog.Name, prog.Type)
+	}
+}
+
+// hasInterface returns whether the host has the network interface, or one matching its glob.
+func (h *host) hasInterface(iface string) bool {
+	if !loader.IsInterfaceGlob(iface) {
+		return h.interfaces[iface]
+	}
+	for name := range h.interfaces {
+		if ok, _ := path.Match(iface, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// packageProblems returns why the constraints of the package, and its compatibility report,
+// do not allow it on the host.
+func (h *host) packageProblems(pkg *v1.EbpfPackage) []string {
+	var problems []string
+	if c := pkg.Constraints; c != nil {
+		if len(c.Architectures) > 0 && !anyOf(c.Architectures, h.arch) {
+			problems = append(problems, fmt.Sprintf("the package requires the architectures %s", strings.Join(c.Architectures, ", ")))
+		}
+		if c.MinKernelVersion != "" && h.release != "" {
+			if ok, err := spec.KernelAtLeast(h.release, c.MinKernelVersion); err == nil && !ok {
+				problems = append(problems, fmt.Sprintf("the package requires a kernel from %s on, the host runs %s", c.MinKernelVersion, h.release))
+			}
+		}
+		if c.RequiresBTF && len(pkg.BTF) == 0 && !h.btf {
+			problems = append(problems, "the package requires the kernel BTF, which the host does not expose")
+		}
+		if h.checkFeatures != nil {
+			for _, p := range h.checkFeatures(c.Features) {
+				if !p.Met {
+					problems = append(problems, fmt.Sprintf("the package requires %s (%s): %s", p.Description, p.Feature, p.Detail))
+				}
+			}
+		}
+	}
+	for _, k := range pkg.Compatibility {
+		if k.Release == h.release && !k.Compatible {
+			problems = append(problems, fmt.Sprintf("bee vmtest found the package incompatible with kernel %s: %s", k.Release, strings.SplitN(k.Error, "\n", 2)[0]))
+		}
+	}
+	return problems
+}
+
+func anyOf(values, candidates []string) bool {
+	for _, c := range candidates {
+		if contains(values, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostKernel describes the kernel bee runs on, leaving unknown what it can't read.
+func hostKernel() *host {
+	h := &host{arch: []string{runtime.GOARCH}, checkFeatures: loader.HostPrerequisites}
+	if h.release = uname.Release(); h.release != "" {
+		h.arch = append(h.arch, uname.Machine())
+	}
+	if _, err := os.Stat(vmlinux.HostBTF); err == nil {
+		h.btf = true
+	}
+	h.symbols = kernelSymbols("/proc/kallsyms")
+	for _, dir := range []string{"/sys/kernel/tracing/events", "/sys/kernel/debug/tracing/events"} {
+		if tracepoints := kernelTracepoints(dir); tracepoints != nil {
+			h.tracepoints = tracepoints
+			break
+		}
+	}
+	if ifaces, err := net.Interfaces(); err == nil {
+		h.interfaces = map[string]bool{}
+		for _, iface := range ifaces {
+			h.interfaces[iface.Name] = true
+		}
+	}
+	return h
+}
+
+// globProblem returns why the kprobe of a glob of functions could not be attached, if the
+// glob matches no function of the kernel, or more than the scope allows.
+func (h *host) globProblem(prog *ebpf.ProgramSpec, hook *Hook, scope Scope) string {
+	if h.symbols == nil {
+		return ""
+	}
+	max := scope.MaxProbeFunctions
+	if max <= 0 {
+		max = loader.DefaultMaxProbeFunctions
+	}
+	matches := 0
+	for symbol := range h.symbols {
+		if ok, _ := path.Match(prog.AttachTo, symbol); ok {
+			matches++
+		}
+	}
+	switch {
+	case matches == 0:
+		return fmt.Sprintf("the kernel has no function matching %s for the %s '%s'", prog.AttachTo, hook.Type, prog.Name)
+	case matches > max:
+		return fmt.Sprintf("%s matches %d functions of the kernel for the %s '%s', more than the %d allowed", prog.AttachTo, matches, hook.Type, prog.Name, max)
+	}
+	return ""
+}
+
+// kernelSymbols returns the names of the functions of the kallsyms file, nil if unreadable.
+func kernelSymbols(path string) map[string]bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	symbols := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// address, type and name, followed by the module if any
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || (fields[1] != "t" && fields[1] != "T") {
+			continue
+		}
+		symbols[fields[2]] = true
+	}
+	if scanner.Err() != nil || len(symbols) == 0 {
+		return nil
+	}
+	return symbols
+}
+
+// kernelTracepoints returns the tracepoints of the events directory of tracefs, nil if it
+// can't be read.
+func kernelTracepoints(dir string) map[string]bool {
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "*", "id"))
+	if err != nil || len(paths) == 0 {
+		return nil
+	}
+	tracepoints := map[string]bool{}
+	for _, path := range paths {
+		tracepoint := filepath.Dir(path)
+		tracepoints[filepath.Base(filepath.Dir(tracepoint))+"/"+filepath.Base(tracepoint)] = true
+	}
+	return tracepoints
+}
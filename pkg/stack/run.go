@@ -0,0 +1,585 @@
+package stack
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/docker/go-units"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"github.com/solo-io/bumblebee/pkg/join"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/solo-io/bumblebee/pkg/opensearchsink"
+	"github.com/solo-io/bumblebee/pkg/otlpsink"
+	"github.com/solo-io/bumblebee/pkg/parquetsink"
+	"github.com/solo-io/bumblebee/pkg/printer"
+	"github.com/solo-io/bumblebee/pkg/routing"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"github.com/solo-io/bumblebee/pkg/stats"
+	"github.com/solo-io/bumblebee/pkg/syslogsink"
+	"github.com/solo-io/bumblebee/pkg/webhooksink"
+	"golang.org/x/sync/errgroup"
+	"oras.land/oras-go/pkg/content"
+	"oras.land/oras-go/pkg/target"
+)
+
+// RunOptions configure how a stack is run.
+type RunOptions struct {
+	// Registry the packages are pulled from, the local store of EbpfImageDir by default
+	Registry *spec.LocalRegistry
+	// Where the output sink prints, stdout by default
+	Stdout io.Writer
+}
+
+// RunStack runs the programs of the stack file until the context is done.
+func RunStack(ctx context.Context, file string) error {
+	stack, err := Load(file)
+	if err != nil {
+		return err
+	}
+	return stack.Run(ctx, RunOptions{})
+}
+
+// program is a program of the stack, parsed and ready to be attached.
+type program struct {
+	Program
+	loader   loader.Loader
+	loadOpts *loader.LoadOptions
+}
+
+// Run pulls, parses and validates all the programs, then attaches them all by priority, as
+// scheduled by the Load options of the stack, or none if any fails to attach, and exports
+// their maps until the context is done or a program fails. All the programs are then detached.
+func (s *Stack) Run(ctx context.Context, opts RunOptions) error {
+	if opts.Registry == nil {
+		opts.Registry = spec.NewLocalRegistry("", content.RegistryOptions{})
+	}
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+	provider, err := stats.NewPrometheusMetricsProvider(ctx, &stats.PrometheusOpts{Port: s.MetricsPort})
+	if err != nil {
+		return err
+	}
+
+	enrichers, err := s.enrichers()
+	if err != nil {
+		return err
+	}
+	if enrichers.GeoIP != nil {
+		enrichers.GeoIP.Start(ctx)
+	}
+	if enrichers.Kubernetes != nil {
+		if err := enrichers.Kubernetes.Start(ctx); err != nil {
+			return err
+		}
+	}
+
+	// everything is validated before any program is loaded
+	var progs []*program
+	for _, p := range s.Programs {
+		prog, err := s.prepare(ctx, p, opts, provider, enrichers)
+		if err != nil {
+			return fmt.Errorf("program %s: %w", p.Name, err)
+		}
+		progs = append(progs, prog)
+	}
+	sinks, err := s.buildSinks(ctx, opts, progs)
+	if err != nil {
+		return err
+	}
+	routes, err := s.routes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, prog := range progs {
+		prog.loadOpts.Watcher = prog.watcher(sinks, routes)
+		prog.loadOpts.Privacy = s.Privacy
+	}
+	joiners, err := s.joiners(progs, sinks, routes)
+	if err != nil {
+		return err
+	}
+
+	// the programs are attached by priority, and detached in the reverse order they were attached in
+	attached := make([]*loader.Attached, len(progs))
+	var (
+		lock        sync.Mutex
+		loaded      []*loader.Attached
+		loadedNames []string
+		failed      = map[string]error{}
+	)
+	detach := func() {
+		for i := len(loaded) - 1; i >= 0; i-- {
+			loaded[i].Close()
+		}
+	}
+	var tasks []loader.LoadTask
+	for i, prog := range progs {
+		i, prog := i, prog
+		tasks = append(tasks, loader.LoadTask{Name: prog.Name, Priority: prog.Priority, Timeout: prog.LoadTimeout, Load: func(ctx context.Context) error {
+			a, err := loader.Attach(ctx, prog.loadOpts)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				// unless canceled once another program failed
+				if !errors.Is(ctx.Err(), context.Canceled) {
+					failed[prog.Name] = err
+				}
+				return err
+			}
+			attached[i] = a
+			loaded = append(loaded, a)
+			loadedNames = append(loadedNames, prog.Name)
+			return nil
+		}})
+	}
+	if err := loader.ScheduleLoads(ctx, tasks, s.Load); err != nil {
+		detach()
+		if len(failed) == 0 {
+			return err
+		}
+		return attachReport(progs, failed, loadedNames)
+	}
+	defer detach()
+
+	for _, sink := range sinks {
+		sink.start(ctx)
+	}
+	defer func() {
+		for _, sink := range sinks {
+			sink.watcher.Close()
+		}
+	}()
+
+	eg, watchCtx := errgroup.WithContext(ctx)
+	for _, j := range joiners {
+		j := j
+		eg.Go(func() error {
+			j.Run(watchCtx)
+			return nil
+		})
+	}
+	for i, prog := range progs {
+		prog, maps := prog, attached[i].Collection.Maps
+		eg.Go(func() error {
+			if err := prog.loader.WatchMaps(watchCtx, prog.loadOpts, maps); err != nil && watchCtx.Err() == nil {
+				return fmt.Errorf("program %s: %w", prog.Name, err)
+			}
+			return nil
+		})
+	}
+	err = eg.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// attachReport returns the failure of an attach of the stack, once the programs attached
+// before it are detached, so no program of the stack is left attached.
+func attachReport(progs []*program, failed map[string]error, loaded []string) error {
+	report := &loader.AttachError{Failed: failed, RolledBack: loaded}
+	attempted := map[string]bool{}
+	for _, name := range loaded {
+		attempted[name] = true
+	}
+	for _, prog := range progs {
+		if _, ok := failed[prog.Name]; !ok && !attempted[prog.Name] {
+			report.Skipped = append(report.Skipped, prog.Name)
+		}
+	}
+	return fmt.Errorf("no program of the stack is attached: %w", report)
+}
+
+// prepare reads and parses the program, and validates its parameters and scope.
+func (s *Stack) prepare(ctx context.Context, p Program, opts RunOptions, provider stats.MetricsProvider, enrichers loader.LabelEnrichers) (*program, error) {
+	pkg, err := readProgram(ctx, p, opts.Registry.Pull)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := parseProgram(ctx, p, pkg, provider, enrichers)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEnforcing(p, pkg, prog.loadOpts); err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+// checkEnforcing returns an error if the package is enforcing, its programs being able to
+// drop packets or deny syscalls, and its scope does not confirm it, with its blast radius.
+func checkEnforcing(p Program, pkg *v1.EbpfPackage, loadOpts *loader.LoadOptions) error {
+	if !pkg.Enforcing || p.Scope.ConfirmEnforcing {
+		return nil
+	}
+	return fmt.Errorf("the package is enforcing, review its blast radius and set confirmEnforcing in the scope of the program to load it:\n%s", loader.EstimateBlastRadius(loadOpts))
+}
+
+// readProgram returns the package of the program with the pull function, or a package of
+// only the program file for files.
+func readProgram(
+	ctx context.Context,
+	p Program,
+	pull func(ctx context.Context, ref string, registry target.Target) (*v1.EbpfPackage, error),
+) (*v1.EbpfPackage, error) {
+	if fileExists(p.Ref) {
+		progBytes, err := os.ReadFile(p.Ref)
+		if err != nil {
+			return nil, err
+		}
+		return &v1.EbpfPackage{ProgramFileBytes: progBytes}, nil
+	}
+	return pull(ctx, p.Ref, nil)
+}
+
+// parseProgram parses the program file of the package, and validates the parameters and scope
+// of the program, the fields of its labels being looked up in the enrichers.
+func parseProgram(ctx context.Context, p Program, pkg *v1.EbpfPackage, provider stats.MetricsProvider, enrichers loader.LabelEnrichers) (*program, error) {
+	progLoader := loader.NewLoader(decoder.NewDecoderFactory(), &prefixedProvider{MetricsProvider: provider, prefix: p.Name})
+	parsedELF, err := progLoader.Parse(ctx, bytes.NewReader(pkg.ProgramFileBytes))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse BPF program: %w", err)
+	}
+	if _, err := loader.ParseConstants(parsedELF.Parameters(), p.Parameters); err != nil {
+		return nil, err
+	}
+	if len(p.Scope.Maps) > 0 {
+		watched := map[string]loader.WatchedMap{}
+		for _, name := range p.Scope.Maps {
+			m, ok := parsedELF.WatchedMaps[name]
+			if !ok {
+				return nil, fmt.Errorf("the program has no %s map", name)
+			}
+			watched[name] = m
+		}
+		parsedELF.WatchedMaps = watched
+	}
+	if err := parsedELF.TransformLabels(p.Labels, enrichers); err != nil {
+		return nil, err
+	}
+	if err := parsedELF.SetTopK(p.Top); err != nil {
+		return nil, err
+	}
+
+	conflictPolicy := loader.ConflictFail
+	if p.Scope.ConflictPolicy != "" {
+		conflictPolicy, _ = loader.ParseConflictPolicy(p.Scope.ConflictPolicy)
+	}
+	return &program{
+		Program: p,
+		loader:  progLoader,
+		loadOpts: &loader.LoadOptions{
+			ParsedELF:      parsedELF,
+			ProgramRef:     p.Ref,
+			Interface:      p.Scope.Interface,
+			Netns:          p.Scope.Netns,
+			HostVeth:       p.Scope.HostVeth,
+			Cgroups:        p.Scope.Cgroups,
+			ConflictPolicy: conflictPolicy,
+			Parameters:     p.Parameters,
+			ProbeVariants:  pkg.ProbeVariants,
+			MultiProbes:    pkg.MultiProbes,
+			HostFeatures:   pkg.HostFeatures(),
+
+			AllowDangerousProbes: p.Scope.AllowDangerousProbes,
+			MaxProbeFunctions:    p.Scope.MaxProbeFunctions,
+			ReattachInterval:     p.Scope.ReattachInterval,
+		},
+	}, nil
+}
+
+// watcher returns the watcher of the program, sending its maps to its sinks, or routing their
+// entries by the routing rules of the stack, if any.
+func (p *program) watcher(sinks map[string]*sink, routes *routing.Table) v1.MapWatcher {
+	var watchers []v1.MapWatcher
+	var names []string
+	for name, sink := range sinks {
+		if len(p.Sinks) == 0 || contains(p.Sinks, name) {
+			watchers, names = append(watchers, sink.watcher), append(names, name)
+		}
+	}
+	if routes != nil {
+		sort.Strings(names)
+		routed := map[string]routing.Sink{}
+		for name, sink := range sinks {
+			routed[name] = routing.Sink{Watcher: &prefixedWatcher{watcher: sink.watcher, prefix: p.Name}, Healthy: sink.healthy}
+		}
+		return routing.NewRouter(p.Name, routes, routed, names)
+	}
+	if len(watchers) == 0 {
+		return loader.NewNoopWatcher()
+	}
+	return &prefixedWatcher{watcher: loader.NewMultiWatcher(watchers...), prefix: p.Name}
+}
+
+// joiners returns the joiners of the joins of the stack, which the watchers of the programs
+// they join send the events of their maps to.
+func (s *Stack) joiners(progs []*program, sinks map[string]*sink, routes *routing.Table) ([]*join.Joiner, error) {
+	byName := map[string]*program{}
+	for _, prog := range progs {
+		byName[prog.Name] = prog
+	}
+	fields := func(j join.Config, side join.Side) ([]string, error) {
+		m, ok := byName[side.Program].loadOpts.ParsedELF.WatchedMaps[side.Map]
+		if !ok {
+			return nil, fmt.Errorf("join %s: program %s has no map %s", j.Name, side.Program, side.Map)
+		}
+		return m.Labels, nil
+	}
+	var joiners []*join.Joiner
+	for _, j := range s.Joins {
+		leftFields, err := fields(j, j.Left)
+		if err != nil {
+			return nil, err
+		}
+		rightFields, err := fields(j, j.Right)
+		if err != nil {
+			return nil, err
+		}
+		// the joined events are sent to the sinks as the ones of a program named after the join
+		out := (&program{Program: Program{Name: j.Name, Sinks: j.Sinks}}).watcher(sinks, routes)
+		joiner, err := join.New(j, leftFields, rightFields, out)
+		if err != nil {
+			return nil, err
+		}
+		left, right := byName[j.Left.Program], byName[j.Right.Program]
+		left.loadOpts.Watcher = loader.NewMultiWatcher(left.loadOpts.Watcher, joiner.Left())
+		right.loadOpts.Watcher = loader.NewMultiWatcher(right.loadOpts.Watcher, joiner.Right())
+		joiners = append(joiners, joiner)
+	}
+	return joiners, nil
+}
+
+// sink is a sink shared by the programs of the stack, started once they are all attached.
+type sink struct {
+	watcher v1.MapWatcher
+	start   func(ctx context.Context)
+	// whether the sink delivers its entries, for the fallbacks of the routing rules, always
+	// if nil
+	healthy func() bool
+}
+
+// routes returns the table of the routing rules of the stack, watching their file if they
+// have one, nil without rules.
+func (s *Stack) routes(ctx context.Context) (*routing.Table, error) {
+	if s.Routing == nil {
+		return nil, nil
+	}
+	table, err := routing.NewTable(&s.Routing.Config, s.Sinks.names())
+	if err != nil {
+		return nil, err
+	}
+	if s.Routing.File != "" {
+		if err := table.Watch(ctx, s.Routing.File); err != nil {
+			return nil, err
+		}
+	}
+	return table, nil
+}
+
+// anyHealthy returns whether any of the remote sinks delivers its entries.
+func anyHealthy(reporters []v1.HealthReporter) func() bool {
+	return func() bool {
+		for _, r := range reporters {
+			if r.Healthy() {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// buildSinks builds the sinks of the stack, by name, without starting them.
+func (s *Stack) buildSinks(ctx context.Context, opts RunOptions, progs []*program) (map[string]*sink, error) {
+	sinks := map[string]*sink{}
+	cfg := s.Sinks
+	if cfg.Output != nil {
+		printerOpts := printer.Opts{Fields: cfg.Output.Fields}
+		if cfg.Output.Format != "" {
+			format, err := printer.ParseFormat(cfg.Output.Format)
+			if err != nil {
+				return nil, err
+			}
+			printerOpts.Format = format
+		}
+		sinks[SinkOutput] = &sink{watcher: printer.New(opts.Stdout, printerOpts), start: func(context.Context) {}}
+	}
+	if cfg.Parquet != nil {
+		sinkOpts := parquetsink.Opts{Dir: cfg.Parquet.Dir, MaxFileAge: cfg.Parquet.MaxAge}
+		if cfg.Parquet.MaxSize != "" {
+			maxSize, err := units.RAMInBytes(cfg.Parquet.MaxSize)
+			if err != nil {
+				return nil, fmt.Errorf("invalid parquet max size: %w", err)
+			}
+			sinkOpts.MaxFileSize = maxSize
+		}
+		if cfg.Parquet.S3 != "" {
+			uploader, err := parquetsink.NewS3Uploader(cfg.Parquet.S3, cfg.Parquet.Dir)
+			if err != nil {
+				return nil, err
+			}
+			sinkOpts.Uploader, sinkOpts.RemoveUploaded = uploader, true
+		}
+		parquet, err := parquetsink.New(ctx, sinkOpts)
+		if err != nil {
+			return nil, err
+		}
+		sinks[SinkParquet] = &sink{watcher: parquet, start: parquet.Start}
+	}
+	if cfg.OpenSearch != nil {
+		opensearch, err := opensearchsink.New(ctx, opensearchsink.Opts{
+			URL:           cfg.OpenSearch.URL,
+			IndexPrefix:   cfg.OpenSearch.IndexPrefix,
+			DeadLetterDir: cfg.OpenSearch.DeadLetterDir,
+		})
+		if err != nil {
+			return nil, err
+		}
+		sinks[SinkOpenSearch] = &sink{watcher: opensearch, start: func(context.Context) { opensearch.Start() }}
+	}
+	if cfg.OTLP != nil {
+		// the maps declaring trace_id and span_id fields, as named in the sinks
+		correlations := map[string]otlpsink.Correlation{}
+		for _, prog := range progs {
+			for name, m := range prog.loadOpts.ParsedELF.WatchedMaps {
+				if m.TraceIDField != "" {
+					correlations[prog.Name+"_"+name] = otlpsink.Correlation{TraceIDField: m.TraceIDField, SpanIDField: m.SpanIDField}
+				}
+			}
+		}
+		otlp, err := otlpsink.New(ctx, otlpsink.Opts{
+			Endpoint:     cfg.OTLP.Endpoint,
+			ServiceName:  cfg.OTLP.ServiceName,
+			Headers:      cfg.OTLP.Headers,
+			Correlations: correlations,
+		})
+		if err != nil {
+			return nil, err
+		}
+		sinks[SinkOTLP] = &sink{watcher: otlp, start: func(context.Context) { otlp.Start() }}
+	}
+	if len(cfg.Webhooks) > 0 {
+		var webhooks []v1.MapWatcher
+		var reporters []v1.HealthReporter
+		var starts []func()
+		for _, webhookCfg := range cfg.Webhooks {
+			webhook, err := webhooksink.New(ctx, webhookCfg)
+			if err != nil {
+				return nil, err
+			}
+			webhooks, starts = append(webhooks, webhook), append(starts, webhook.Start)
+			reporters = append(reporters, webhook)
+		}
+		watcher, err := s.spooled(ctx, SinkWebhooks, loader.NewMultiWatcher(webhooks...), reporters)
+		if err != nil {
+			return nil, err
+		}
+		sinks[SinkWebhooks] = &sink{watcher: watcher, start: startAll(starts), healthy: anyHealthy(reporters)}
+	}
+	if len(cfg.Syslog) > 0 {
+		var syslogs []v1.MapWatcher
+		var reporters []v1.HealthReporter
+		var starts []func()
+		for _, syslogCfg := range cfg.Syslog {
+			syslog, err := syslogsink.New(ctx, syslogCfg)
+			if err != nil {
+				return nil, err
+			}
+			syslogs, starts = append(syslogs, syslog), append(starts, syslog.Start)
+			reporters = append(reporters, syslog)
+		}
+		watcher, err := s.spooled(ctx, SinkSyslog, loader.NewMultiWatcher(syslogs...), reporters)
+		if err != nil {
+			return nil, err
+		}
+		sinks[SinkSyslog] = &sink{watcher: watcher, start: startAll(starts), healthy: anyHealthy(reporters)}
+	}
+	return sinks, nil
+}
+
+// spooled returns the watcher of the sink spooling its events while all its remote sinks are
+// down, in the directory of the sink in the spool of the stack, if configured.
+func (s *Stack) spooled(ctx context.Context, name string, watcher v1.MapWatcher, reporters []v1.HealthReporter) (v1.MapWatcher, error) {
+	if s.Sinks.Spool == nil {
+		return watcher, nil
+	}
+	opts := *s.Sinks.Spool
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("the spool of the stack has no dir")
+	}
+	opts.Dir = filepath.Join(opts.Dir, name)
+	return loader.NewSpool(ctx, watcher, reporters, opts)
+}
+
+func startAll(starts []func()) func(context.Context) {
+	return func(context.Context) {
+		for _, start := range starts {
+			start()
+		}
+	}
+}
+
+// prefixedWatcher names the maps of a program after the program, so the maps of the
+// programs sharing a sink never collide.
+type prefixedWatcher struct {
+	watcher v1.MapWatcher
+	prefix  string
+}
+
+func (p *prefixedWatcher) NewRingBuf(name string, keys []string) {
+	p.watcher.NewRingBuf(p.prefix+"_"+name, keys)
+}
+
+func (p *prefixedWatcher) NewHashMap(name string, keys []string) {
+	p.watcher.NewHashMap(p.prefix+"_"+name, keys)
+}
+
+func (p *prefixedWatcher) SendEntry(entry v1.MapEntry) {
+	entry.Name = p.prefix + "_" + entry.Name
+	p.watcher.SendEntry(entry)
+}
+
+// Close is a noop, the sinks are shared by the programs and closed by the stack.
+func (p *prefixedWatcher) Close() {}
+
+// prefixedProvider names the metrics of a program after the program.
+type prefixedProvider struct {
+	stats.MetricsProvider
+	prefix string
+}
+
+func (p *prefixedProvider) prefixed(opts *stats.MetricOpts) *stats.MetricOpts {
+	prefixed := *opts
+	prefixed.Name = p.prefix + "_" + opts.Name
+	return &prefixed
+}
+
+func (p *prefixedProvider) NewSetCounter(opts *stats.MetricOpts) stats.SetInstrument {
+	return p.MetricsProvider.NewSetCounter(p.prefixed(opts))
+}
+
+func (p *prefixedProvider) NewIncrementCounter(opts *stats.MetricOpts) stats.IncrementInstrument {
+	return p.MetricsProvider.NewIncrementCounter(p.prefixed(opts))
+}
+
+func (p *prefixedProvider) NewGauge(opts *stats.MetricOpts) stats.SetInstrument {
+	return p.MetricsProvider.NewGauge(p.prefixed(opts))
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
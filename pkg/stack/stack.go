@@ -0,0 +1,320 @@
+package stack
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/solo-io/bumblebee/pkg/geoip"
+	"github.com/solo-io/bumblebee/pkg/join"
+	"github.com/solo-io/bumblebee/pkg/kubemeta"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/solo-io/bumblebee/pkg/routing"
+	"github.com/solo-io/bumblebee/pkg/secrets"
+	"github.com/solo-io/bumblebee/pkg/syslogsink"
+	"github.com/solo-io/bumblebee/pkg/webhooksink"
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// DefaultStackFile is the name of the stack file run by default.
+const DefaultStackFile = "bee-stack.yaml"
+
+// Names of the sinks programs send their events to.
+const (
+	SinkOutput     = "output"
+	SinkParquet    = "parquet"
+	SinkOpenSearch = "opensearch"
+	SinkOTLP       = "otlp"
+	SinkWebhooks   = "webhooks"
+	SinkSyslog     = "syslog"
+)
+
+// Stack describes a set of programs run together, and the sinks their events are sent to.
+// The maps of each program are exported as <program>_<map>, in metrics as in sinks, so the
+// maps of several programs never collide.
+type Stack struct {
+	Programs []Program `yaml:"programs"`
+	Sinks    Sinks     `yaml:"sinks,omitempty"`
+	// Port the Prometheus metrics of all the programs are served on, defaults to 9091
+	MetricsPort uint32 `yaml:"metricsPort,omitempty"`
+	// How many programs are loaded at a time, and the share of the CPU their loads may take
+	Load loader.LoadSchedulerOpts `yaml:"load,omitempty"`
+	// MaxMind DB files the address fields of the geoip lookups of the labels of the programs
+	// are looked up in, as taken by `bee run --geoip-db`
+	GeoIP []string `yaml:"geoip,omitempty"`
+	// Caches the metadata of the cluster the kubernetes lookups of the labels of the programs
+	// are looked up in, as with `bee run --kube-metadata`
+	Kubernetes *kubemeta.Config `yaml:"kubernetes,omitempty"`
+	// Rules routing the entries of the programs to the sinks by their program, map and fields,
+	// over the sinks of the programs
+	Routing *Routing `yaml:"routing,omitempty"`
+	// Exports only k-anonymized aggregates of the entries of all the programs, as with
+	// `bee run --aggregate-only`, if set
+	Privacy *loader.Privacy `yaml:"privacy,omitempty"`
+	// Joins of the events of the maps of two programs, sent to the sinks as the events of a
+	// map named after the join, e.g. `connect_dns_events`
+	Joins []join.Config `yaml:"joins,omitempty"`
+}
+
+// Routing are the routing rules of the stack, or the file they are read from.
+type Routing struct {
+	routing.Config `yaml:",inline"`
+	// File the rules are read from instead, relative to the stack file, reloaded once it
+	// changes or on SIGHUP
+	File string `yaml:"file,omitempty"`
+}
+
+// Program is a package of the stack, or a program file relative to the stack file.
+type Program struct {
+	// Name of the program, prefixing the names of its maps
+	Name string `yaml:"name"`
+	// Ref of the package, or path of a program file
+	Ref string `yaml:"ref"`
+	// Programs of higher priorities are loaded first, e.g. the critical ones
+	Priority int `yaml:"priority,omitempty"`
+	// Most time the program may take to be attached, waiting for a loader included, failing
+	// the stack beyond. No limit if 0
+	LoadTimeout time.Duration `yaml:"loadTimeout,omitempty"`
+	// Values of the parameters the program declares, see loader.Parameter
+	Parameters map[string]string `yaml:"parameters,omitempty"`
+	// Where the program is attached, and which of its maps are exported
+	Scope Scope `yaml:"scope,omitempty"`
+	// Sinks the events of the program are sent to, all the sinks of the stack if empty
+	Sinks []string `yaml:"sinks,omitempty"`
+	// How the fields of the maps of the program become labels, as in the labels file of
+	// `bee run --labels`
+	Labels []loader.LabelTransform `yaml:"labels,omitempty"`
+	// Number of the largest entries of hash maps exported by map name, as taken by
+	// `bee run --top`
+	Top map[string]int `yaml:"top,omitempty"`
+}
+
+// Scope restricts where a program is attached, and the maps it exports.
+type Scope struct {
+	// Network interface XDP and TC programs are attached to, or glob of interfaces
+	Interface string `yaml:"interface,omitempty"`
+	// Network namespaces the interface is in, as taken by `bee run --netns`
+	Netns []string `yaml:"netns,omitempty"`
+	// Attach to the host end of the veth of the interface of each namespace
+	HostVeth bool `yaml:"hostVeth,omitempty"`
+	// Attach XDP and TC programs to the interfaces appearing while they run, and detach them
+	// from the ones gone, resolving the interface and namespaces at this interval, as with
+	// `bee run --reattach-interval`
+	ReattachInterval time.Duration `yaml:"reattachInterval,omitempty"`
+	// What to do when other programs are attached to the hook of an XDP or TC program
+	ConflictPolicy string `yaml:"conflictPolicy,omitempty"`
+	// Cgroups the program is scoped to, as taken by `bee run --cgroup`
+	Cgroups []string `yaml:"cgroups,omitempty"`
+	// Attach kprobes to the kernel functions which are unsafe or too hot to probe, as with
+	// `bee run --allow-dangerous-probes`
+	AllowDangerousProbes bool `yaml:"allowDangerousProbes,omitempty"`
+	// Most kernel functions a kprobe program is attached to once its globs are expanded, as
+	// with `bee run --max-probe-functions`
+	MaxProbeFunctions int `yaml:"maxProbeFunctions,omitempty"`
+	// Load the package even though it is enforcing, its programs being able to drop packets
+	// or deny syscalls, as with `bee run --confirm-enforcing`
+	ConfirmEnforcing bool `yaml:"confirmEnforcing,omitempty"`
+	// Maps exported to the metrics and sinks, all of them if empty
+	Maps []string `yaml:"maps,omitempty"`
+}
+
+// Sinks are the sinks of a stack, as configured by the flags of `bee run` and its sinks file.
+type Sinks struct {
+	Output     *OutputSink          `yaml:"output,omitempty"`
+	Parquet    *ParquetSink         `yaml:"parquet,omitempty"`
+	OpenSearch *OpenSearchSink      `yaml:"opensearch,omitempty"`
+	OTLP       *OTLPSink            `yaml:"otlp,omitempty"`
+	Webhooks   []webhooksink.Config `yaml:"webhooks,omitempty"`
+	Syslog     []syslogsink.Config  `yaml:"syslog,omitempty"`
+	// Spools the events of the webhooks, and of the syslog collectors, while they are all down,
+	// in a directory per sink
+	Spool *loader.SpoolOptions `yaml:"spool,omitempty"`
+}
+
+// OutputSink prints the entries of the maps to stdout.
+type OutputSink struct {
+	// json, logfmt or columns, defaults to json
+	Format string `yaml:"format,omitempty"`
+	// Order of the printed fields per map
+	Fields map[string][]string `yaml:"fields,omitempty"`
+}
+
+// ParquetSink writes the events of ring buffers to Parquet files.
+type ParquetSink struct {
+	Dir string `yaml:"dir"`
+	// Files are rotated once about this much event data was written to them, defaults to 64MiB
+	MaxSize string `yaml:"maxSize,omitempty"`
+	// Files are rotated once they are this old, defaults to an hour
+	MaxAge time.Duration `yaml:"maxAge,omitempty"`
+	// S3 bucket and prefix rotated files are uploaded to, e.g. s3://my-bucket/bee
+	S3 string `yaml:"s3,omitempty"`
+}
+
+// OpenSearchSink indexes the events of ring buffers in Elasticsearch or OpenSearch.
+type OpenSearchSink struct {
+	URL           string `yaml:"url"`
+	IndexPrefix   string `yaml:"indexPrefix,omitempty"`
+	DeadLetterDir string `yaml:"deadLetterDir,omitempty"`
+}
+
+// OTLPSink exports the events of ring buffers to an OpenTelemetry collector as spans.
+type OTLPSink struct {
+	Endpoint    string            `yaml:"endpoint"`
+	ServiceName string            `yaml:"serviceName,omitempty"`
+	Headers     map[string]string `yaml:"headers,omitempty"`
+}
+
+// Load reads and validates the stack file, resolving the paths of program files relative
+// to the current directory. The stack file can't reference any secret.
+func Load(path string) (*Stack, error) {
+	return LoadWith(context.Background(), path, &secrets.Resolver{})
+}
+
+// LoadWith reads and validates the stack file, resolving the references to the environment
+// variables and secrets allowed by the resolver, e.g. the credentials of sinks.
+func LoadWith(ctx context.Context, path string, resolver *secrets.Resolver) (*Stack, error) {
+	byt, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read stack file: %w", err)
+	}
+	var stack Stack
+	if err := resolver.Unmarshal(ctx, byt, &stack); err != nil {
+		return nil, fmt.Errorf("could not parse stack file %s: %w", path, err)
+	}
+	if err := stack.validate(); err != nil {
+		return nil, fmt.Errorf("invalid stack file %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	if stack.Routing != nil && stack.Routing.File != "" && !filepath.IsAbs(stack.Routing.File) {
+		stack.Routing.File = filepath.Join(dir, stack.Routing.File)
+	}
+	for i := range stack.Programs {
+		prog := &stack.Programs[i]
+		if file := filepath.Join(dir, prog.Ref); !filepath.IsAbs(prog.Ref) && fileExists(file) {
+			prog.Ref = file
+		}
+	}
+	return &stack, nil
+}
+
+func (s *Stack) validate() error {
+	if len(s.Programs) == 0 {
+		return fmt.Errorf("the stack has no programs")
+	}
+	if err := s.Load.Validate(); err != nil {
+		return err
+	}
+	sinks := s.Sinks.names()
+	names := map[string]bool{}
+	for i, prog := range s.Programs {
+		if prog.Name == "" {
+			return fmt.Errorf("program %d has no name", i)
+		}
+		if !validName.MatchString(prog.Name) {
+			return fmt.Errorf("invalid program name %q, must only have letters, digits and underscores, as it prefixes metric names", prog.Name)
+		}
+		if names[prog.Name] {
+			return fmt.Errorf("more than one program is named %s", prog.Name)
+		}
+		names[prog.Name] = true
+		if prog.Ref == "" {
+			return fmt.Errorf("program %s has no ref", prog.Name)
+		}
+		if prog.LoadTimeout < 0 {
+			return fmt.Errorf("program %s: the load timeout must be positive", prog.Name)
+		}
+		if prog.Scope.ConflictPolicy != "" {
+			if _, err := loader.ParseConflictPolicy(prog.Scope.ConflictPolicy); err != nil {
+				return fmt.Errorf("program %s: %w", prog.Name, err)
+			}
+		}
+		for _, sink := range prog.Sinks {
+			if !sinks[sink] {
+				return fmt.Errorf("program %s sends its events to %s, which the stack does not configure", prog.Name, sink)
+			}
+		}
+	}
+	for _, j := range s.Joins {
+		if err := j.Validate(); err != nil {
+			return err
+		}
+		if !validName.MatchString(j.Name) {
+			return fmt.Errorf("invalid join name %q, must only have letters, digits and underscores, as it prefixes metric names", j.Name)
+		}
+		if names[j.Name] {
+			return fmt.Errorf("join %s is named like another join or a program", j.Name)
+		}
+		names[j.Name] = true
+		for _, side := range []join.Side{j.Left, j.Right} {
+			if !s.hasProgram(side.Program) {
+				return fmt.Errorf("join %s joins the events of %s, which the stack does not run", j.Name, side.Program)
+			}
+		}
+		for _, sink := range j.Sinks {
+			if !sinks[sink] {
+				return fmt.Errorf("join %s sends its events to %s, which the stack does not configure", j.Name, sink)
+			}
+		}
+		if s.Privacy != nil {
+			return fmt.Errorf("join %s: the events can't be joined in the aggregation only mode, as only their counts are exported", j.Name)
+		}
+	}
+	if r := s.Routing; r != nil {
+		if r.File != "" && (len(r.Rules) > 0 || len(r.Default) > 0) {
+			return fmt.Errorf("the routing rules are either in the stack file or in %s, not both", r.File)
+		}
+		if err := r.Validate(sinks); err != nil {
+			return fmt.Errorf("invalid routing rules: %w", err)
+		}
+	}
+	return nil
+}
+
+// hasProgram returns whether the stack runs a program of the name.
+func (s *Stack) hasProgram(name string) bool {
+	for _, p := range s.Programs {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// enrichers opens the geoip databases of the stack and creates its cache of the Kubernetes
+// metadata, which is not started.
+func (s *Stack) enrichers() (loader.LabelEnrichers, error) {
+	var enrichers loader.LabelEnrichers
+	var err error
+	if len(s.GeoIP) > 0 {
+		enrichers.GeoIP, err = geoip.Open(s.GeoIP...)
+		if err != nil {
+			return enrichers, err
+		}
+	}
+	if s.Kubernetes != nil {
+		enrichers.Kubernetes, err = kubemeta.New(*s.Kubernetes)
+	}
+	return enrichers, err
+}
+
+// names returns the names of the configured sinks.
+func (s Sinks) names() map[string]bool {
+	return map[string]bool{
+		SinkOutput:     s.Output != nil,
+		SinkParquet:    s.Parquet != nil,
+		SinkOpenSearch: s.OpenSearch != nil,
+		SinkOTLP:       s.OTLP != nil,
+		SinkWebhooks:   len(s.Webhooks) > 0,
+		SinkSyslog:     len(s.Syslog) > 0,
+	}
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,446 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cilium/ebpf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/fakes"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/solo-io/bumblebee/pkg/secrets"
+	"github.com/solo-io/bumblebee/pkg/spec"
+	"oras.land/oras-go/pkg/content"
+)
+
+var _ = Describe("Stack files", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "bee-stack")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	write := func(content string) string {
+		path := filepath.Join(dir, DefaultStackFile)
+		Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		return path
+	}
+
+	It("loads programs, resolving files relative to the stack file", func() {
+		Expect(os.WriteFile(filepath.Join(dir, "tc.o"), nil, 0644)).To(Succeed())
+		stack, err := Load(write(`
+programs:
+- name: tcpconnect
+  ref: ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7
+  parameters:
+    target_pid: "1234"
+  scope:
+    maps: [events_hash]
+  sinks: [output]
+- name: allowlist
+  ref: tc.o
+  priority: 10
+  loadTimeout: 30s
+  scope:
+    interface: eth0
+    netns: [pod:9a1d3c1e-5b2f-4c8e-8f0a-2d7b6e4c1f3a]
+    conflictPolicy: chain
+sinks:
+  output:
+    format: logfmt
+  webhooks:
+  - url: https://example.com/events
+metricsPort: 9100
+load:
+  concurrency: 4
+  cpuBudget: 0.5
+  bulkConcurrency: 2
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stack.Programs).To(HaveLen(2))
+		Expect(stack.Programs[0].Parameters).To(Equal(map[string]string{"target_pid": "1234"}))
+		Expect(stack.Programs[1].Ref).To(Equal(filepath.Join(dir, "tc.o")))
+		Expect(stack.Programs[1].Priority).To(Equal(10))
+		Expect(stack.Programs[1].LoadTimeout).To(Equal(30 * time.Second))
+		Expect(stack.Programs[1].Scope.Netns).To(HaveLen(1))
+		Expect(stack.Sinks.Output.Format).To(Equal("logfmt"))
+		Expect(stack.MetricsPort).To(Equal(uint32(9100)))
+		Expect(stack.Load).To(Equal(loader.LoadSchedulerOpts{Concurrency: 4, CPUBudget: 0.5, BulkConcurrency: 2}))
+	})
+
+	It("resolves the allowed secrets of sinks", func() {
+		path := write(`
+programs:
+- name: tcpconnect
+  ref: ghcr.io/solo-io/bumblebee/tcpconnect:0.0.7
+sinks:
+  otlp:
+    endpoint: collector:4317
+    headers:
+      Authorization: Bearer ${OTLP_TOKEN}
+`)
+		resolver := &secrets.Resolver{LookupEnv: func(name string) (string, bool) {
+			return "s3cret", name == "OTLP_TOKEN"
+		}}
+		_, err := LoadWith(context.Background(), path, resolver)
+		Expect(err).To(MatchError(ContainSubstring("${OTLP_TOKEN} is not allowed")))
+
+		resolver.Allow = []string{"env:OTLP_TOKEN"}
+		stack, err := LoadWith(context.Background(), path, resolver)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stack.Sinks.OTLP.Headers).To(Equal(map[string]string{"Authorization": "Bearer s3cret"}))
+	})
+
+	It("rejects invalid stacks", func() {
+		_, err := Load(write(`programs: []`))
+		Expect(err).To(MatchError(ContainSubstring("no programs")))
+		_, err = Load(write(`
+programs:
+- name: tcp-connect
+  ref: tcpconnect:v1
+`))
+		Expect(err).To(MatchError(ContainSubstring("invalid program name")))
+		_, err = Load(write(`
+programs:
+- name: tcpconnect
+  ref: tcpconnect:v1
+- name: tcpconnect
+  ref: tcpconnect:v2
+`))
+		Expect(err).To(MatchError(ContainSubstring("more than one program is named tcpconnect")))
+		_, err = Load(write(`
+programs:
+- name: tcpconnect
+  ref: tcpconnect:v1
+  sinks: [parquet]
+`))
+		Expect(err).To(MatchError(ContainSubstring("does not configure")))
+		_, err = Load(write(`
+programs:
+- name: tcpconnect
+  ref: tcpconnect:v1
+load:
+  cpuBudget: 2
+`))
+		Expect(err).To(MatchError(ContainSubstring("invalid load CPU budget")))
+		_, err = Load(write(`
+programs:
+- name: tcpconnect
+  ref: tcpconnect:v1
+  scope:
+    conflictPolicy: ignore
+`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("names the maps of programs after them", func() {
+		output, parquet := fakes.NewSink(), fakes.NewSink()
+		prog := &program{Program: Program{Name: "tcpconnect", Sinks: []string{SinkOutput}}}
+		w := prog.watcher(map[string]*sink{
+			SinkOutput:  {watcher: output},
+			SinkParquet: {watcher: parquet},
+		}, nil)
+		w.NewHashMap("events_hash", []string{"daddr"})
+		w.SendEntry(v1.MapEntry{Name: "events_hash"})
+		w.Close()
+		Expect(output.HashMaps()).To(Equal(map[string][]string{"tcpconnect_events_hash": {"daddr"}}))
+		Expect(output.Entries("")).To(Equal([]v1.MapEntry{{Name: "tcpconnect_events_hash"}}))
+		// shared by the programs, so only closed by the stack
+		Expect(output.Closed()).To(BeFalse())
+		Expect(parquet.Entries("")).To(BeEmpty())
+	})
+
+	It("routes the entries of programs by the routing rules", func() {
+		path := write(`
+programs:
+- name: tcpconnect
+  ref: tcpconnect:v1
+  sinks: [output]
+sinks:
+  output: {}
+  parquet:
+    dir: /tmp/parquet
+routing:
+  rules:
+  - name: detections
+    priority: 10
+    match:
+      fields:
+        severity: [high, critical]
+    sinks: [webhooks]
+`)
+		_, err := Load(path)
+		Expect(err).To(MatchError(ContainSubstring("rule detections routes entries to webhooks, which is not configured")))
+
+		stack, err := Load(write(`
+programs:
+- name: tcpconnect
+  ref: tcpconnect:v1
+  sinks: [output]
+sinks:
+  output: {}
+  parquet:
+    dir: /tmp/parquet
+routing:
+  rules:
+  - name: detections
+    priority: 10
+    match:
+      fields:
+        severity: [high, critical]
+    sinks: [parquet]
+`))
+		Expect(err).NotTo(HaveOccurred())
+		routes, err := stack.routes(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		output, parquet := fakes.NewSink(), fakes.NewSink()
+		prog := &program{Program: stack.Programs[0]}
+		w := prog.watcher(map[string]*sink{
+			SinkOutput:  {watcher: output},
+			SinkParquet: {watcher: parquet},
+		}, routes)
+		w.NewRingBuf("events", []string{"severity"})
+		detection := v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"severity": "high"}}}
+		debug := v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"severity": "debug"}}}
+		w.SendEntry(detection)
+		w.SendEntry(debug)
+		Expect(parquet.RingBufs()).To(Equal(map[string][]string{"tcpconnect_events": {"severity"}}))
+		Expect(parquet.Entries("")).To(HaveLen(1))
+		Expect(parquet.Entries("")[0].Entry.Key).To(HaveKeyWithValue("severity", "high"))
+		// the entries matching no rule are sent to the sinks of the program
+		Expect(output.Entries("")).To(HaveLen(1))
+		Expect(output.Entries("")[0].Entry.Key).To(HaveKeyWithValue("severity", "debug"))
+
+		_, err = Load(write(`
+programs:
+- name: tcpconnect
+  ref: tcpconnect:v1
+sinks:
+  output: {}
+routing:
+  file: routes.yaml
+  default: [output]
+`))
+		Expect(err).To(MatchError(ContainSubstring("either in the stack file or in")))
+	})
+
+	It("joins the events of programs", func() {
+		joins := `
+programs:
+- name: tcpconnect
+  ref: tcpconnect:v1
+- name: dnssnoop
+  ref: dnssnoop:v1
+sinks:
+  output: {}
+joins:
+- name: connect_dns
+  left: {program: tcpconnect, map: events}
+  right: {program: %s, map: queries}
+  on: [pid=tgid]
+  window: 5s
+`
+		_, err := Load(write(fmt.Sprintf(joins, "execsnoop")))
+		Expect(err).To(MatchError(ContainSubstring("join connect_dns joins the events of execsnoop, which the stack does not run")))
+		_, err = Load(write(fmt.Sprintf(joins, "dnssnoop") + "privacy:\n  minCount: 10\n"))
+		Expect(err).To(MatchError(ContainSubstring("can't be joined in the aggregation only mode")))
+
+		stack, err := Load(write(fmt.Sprintf(joins, "dnssnoop")))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stack.Joins[0].Window).To(Equal(5 * time.Second))
+		parsed := func(name string, fields ...string) *program {
+			return &program{Program: Program{Name: name}, loadOpts: &loader.LoadOptions{
+				ParsedELF: &loader.ParsedELF{WatchedMaps: map[string]loader.WatchedMap{name: {Labels: fields}}},
+			}}
+		}
+		tcpconnect, dnssnoop := parsed("tcpconnect", "pid", "daddr"), parsed("dnssnoop", "tgid", "qname")
+		_, err = stack.joiners([]*program{tcpconnect, dnssnoop}, nil, nil)
+		Expect(err).To(MatchError("join connect_dns: program tcpconnect has no map events"))
+
+		tcpconnect.loadOpts.ParsedELF.WatchedMaps = map[string]loader.WatchedMap{"events": {Labels: []string{"pid", "daddr"}}}
+		dnssnoop.loadOpts.ParsedELF.WatchedMaps = map[string]loader.WatchedMap{"queries": {Labels: []string{"tgid", "qname"}}}
+		output := fakes.NewSink()
+		sinks := map[string]*sink{SinkOutput: {watcher: output}}
+		for _, prog := range []*program{tcpconnect, dnssnoop} {
+			prog.loadOpts.Watcher = prog.watcher(sinks, nil)
+		}
+		joiners, err := stack.joiners([]*program{tcpconnect, dnssnoop}, sinks, nil)
+		Expect(err).NotTo(HaveOccurred())
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		joiners[0].Run(ctx)
+
+		tcpconnect.loadOpts.Watcher.NewRingBuf("events", []string{"pid", "daddr"})
+		dnssnoop.loadOpts.Watcher.NewRingBuf("queries", []string{"tgid", "qname"})
+		dnssnoop.loadOpts.Watcher.SendEntry(v1.MapEntry{Name: "queries", Entry: v1.KvPair{Key: map[string]string{"tgid": "42", "qname": "example.com"}}})
+		tcpconnect.loadOpts.Watcher.SendEntry(v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"pid": "42", "daddr": "93.184.216.34"}}})
+		Expect(output.RingBufs()).To(HaveKeyWithValue("connect_dns_events", []string{"pid", "daddr", "dnssnoop_qname"}))
+		Expect(output.Entries("connect_dns_events")).To(Equal([]v1.MapEntry{{
+			Name:  "connect_dns_events",
+			Entry: v1.KvPair{Key: map[string]string{"pid": "42", "daddr": "93.184.216.34", "dnssnoop_qname": "example.com"}},
+		}}))
+		// the events themselves are still sent to the sinks
+		Expect(output.Entries("tcpconnect_events")).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("Plans", func() {
+	var (
+		dir, progFile string
+		registry      *spec.LocalRegistry
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "bee-plan")
+		Expect(err).NotTo(HaveOccurred())
+		progBytes, err := os.ReadFile("../spec/array.o")
+		Expect(err).NotTo(HaveOccurred())
+		progFile = filepath.Join(dir, "retransmit.o")
+		Expect(os.WriteFile(progFile, progBytes, 0644)).To(Succeed())
+
+		storeDir := filepath.Join(dir, "store")
+		store, err := content.NewOCI(storeDir)
+		Expect(err).NotTo(HaveOccurred())
+		pkg := &v1.EbpfPackage{
+			ProgramFileBytes: progBytes,
+			EbpfConfig: v1.EbpfConfig{
+				Constraints: &v1.PlatformConstraints{MinKernelVersion: "5.8", Features: []v1.HostFeature{v1.HostFeatureCgroupV2, v1.HostFeaturePMU}},
+				Compatibility: []v1.KernelCompatibility{
+					{Kernel: "5.4", Release: "5.4.0-91-generic", Error: "could not attach\nvm output"},
+				},
+			},
+		}
+		Expect(spec.NewEbpfOCICLient().Push(context.Background(), "localhost:5000/retransmit:v1", store, pkg)).To(Succeed())
+		registry = spec.NewLocalRegistry(storeDir, content.RegistryOptions{})
+		registry.Offline = true
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("reports the digests, hooks and memory of the programs", func() {
+		s := &Stack{Programs: []Program{
+			{Name: "file", Ref: progFile},
+			{Name: "pkg", Ref: "localhost:5000/retransmit:v1"},
+		}}
+		plan := s.plan(context.Background(), RunOptions{Registry: registry}, &host{release: "5.10.0"})
+		Expect(plan.OK()).To(BeTrue())
+		Expect(plan.Programs).To(HaveLen(2))
+		Expect(plan.Programs[0].Digest).To(BeEmpty())
+		Expect(plan.Programs[1].Digest).NotTo(BeEmpty())
+		for _, prog := range plan.Programs {
+			Expect(prog.Hooks).To(HaveLen(1))
+			Expect(prog.Hooks[0].Type).To(Equal("kprobe"))
+			Expect(prog.Hooks[0].Target).To(Equal("tcp_retransmit_skb"))
+			Expect(prog.Usage.Total).NotTo(BeZero())
+		}
+		Expect(plan.MemoryBytes).To(Equal(2 * plan.Programs[0].Usage.Total))
+	})
+
+	It("reports why the programs would not run on the host", func() {
+		s := &Stack{Programs: []Program{
+			{Name: "file", Ref: progFile, Parameters: map[string]string{"target_pid": "1"}},
+			{Name: "pkg", Ref: "localhost:5000/retransmit:v1"},
+			{Name: "missing", Ref: "localhost:5000/retransmit:v2"},
+		}}
+		plan := s.plan(context.Background(), RunOptions{Registry: registry}, &host{
+			release: "5.4.0-91-generic",
+			symbols: map[string]bool{"tcp_connect": true},
+			checkFeatures: func(features []v1.HostFeature) []v1.Prerequisite {
+				Expect(features).To(Equal([]v1.HostFeature{v1.HostFeatureCgroupV2, v1.HostFeaturePMU}))
+				return []v1.Prerequisite{
+					{Feature: v1.HostFeatureCgroupV2, Description: "the unified cgroup v2 hierarchy", Met: true},
+					{Feature: v1.HostFeaturePMU, Description: "the hardware performance counters of the CPU", Detail: "no event source"},
+				}
+			},
+		})
+		Expect(plan.OK()).To(BeFalse())
+		Expect(plan.Programs[0].Problems).To(ConsistOf(ContainSubstring("target_pid")))
+		Expect(plan.Programs[1].Problems).To(ConsistOf(
+			ContainSubstring("requires a kernel from 5.8 on"),
+			Equal("bee vmtest found the package incompatible with kernel 5.4.0-91-generic: could not attach"),
+			ContainSubstring("no function tcp_retransmit_skb"),
+			Equal("the package requires the hardware performance counters of the CPU (pmu): no event source"),
+		))
+		Expect(plan.Programs[2].Problems).To(ConsistOf(ContainSubstring("not in the local store")))
+	})
+
+	It("reports the blast radius of enforcing packages, unless confirmed", func() {
+		store, err := content.NewOCI(filepath.Join(dir, "store"))
+		Expect(err).NotTo(HaveOccurred())
+		progBytes, err := os.ReadFile(progFile)
+		Expect(err).NotTo(HaveOccurred())
+		pkg := &v1.EbpfPackage{ProgramFileBytes: progBytes, EbpfConfig: v1.EbpfConfig{Enforcing: true}}
+		Expect(spec.NewEbpfOCICLient().Push(context.Background(), "localhost:5000/retransmit:enforcing", store, pkg)).To(Succeed())
+
+		s := &Stack{Programs: []Program{{Name: "pkg", Ref: "localhost:5000/retransmit:enforcing"}}}
+		plan := s.plan(context.Background(), RunOptions{Registry: registry}, &host{})
+		Expect(plan.Programs[0].Problems).To(ConsistOf(ContainSubstring("blast radius is not confirmed")))
+		Expect(plan.Programs[0].BlastRadius).NotTo(BeNil())
+		Expect(plan.Programs[0].BlastRadius.NodeWide).To(BeTrue())
+
+		s.Programs[0].Scope.ConfirmEnforcing = true
+		plan = s.plan(context.Background(), RunOptions{Registry: registry}, &host{})
+		Expect(plan.OK()).To(BeTrue())
+		Expect(plan.Programs[0].BlastRadius.Hooks).To(ConsistOf(loader.BlastHook{
+			Program: "kprobe_retransmit_skb", Type: "kprobe", Target: "tcp_retransmit_skb", Scope: "every process of the node",
+		}))
+	})
+
+	It("reports the programs detached and skipped once one failed to attach", func() {
+		progs := []*program{{Program: Program{Name: "critical"}}, {Program: Program{Name: "exec"}}, {Program: Program{Name: "tcp"}}}
+		err := attachReport(progs, map[string]error{"exec": errors.New("no such tracepoint")}, []string{"critical"})
+		Expect(err).To(MatchError("no program of the stack is attached: could not attach 'exec': no such tracepoint; detached critical again; did not attach tcp"))
+		var report *loader.AttachError
+		Expect(errors.As(err, &report)).To(BeTrue())
+		Expect(report.Skipped).To(Equal([]string{"tcp"}))
+	})
+
+	It("reports the probes of dangerous functions unless allowed", func() {
+		prog := &ebpf.ProgramSpec{Name: "switches", Type: ebpf.Kprobe, SectionName: "kprobe/finish_task_switch", AttachTo: "finish_task_switch"}
+		h := &host{}
+		_, problem := h.hook(prog, Scope{})
+		Expect(problem).To(ContainSubstring("dangerous probes are not allowed"))
+		_, problem = h.hook(prog, Scope{AllowDangerousProbes: true})
+		Expect(problem).To(BeEmpty())
+	})
+
+	It("counts the functions matching the globs of kprobes", func() {
+		prog := &ebpf.ProgramSpec{Name: "tcp", Type: ebpf.Kprobe, SectionName: "kprobe/tcp_v*", AttachTo: "tcp_v*"}
+		h := &host{symbols: map[string]bool{"tcp_v4_connect": true, "tcp_v6_connect": true, "udp_sendmsg": true}}
+		hook, problem := h.hook(prog, Scope{})
+		Expect(problem).To(BeEmpty())
+		Expect(hook.Target).To(Equal("tcp_v*"))
+		_, problem = h.hook(prog, Scope{MaxProbeFunctions: 1})
+		Expect(problem).To(Equal("tcp_v* matches 2 functions of the kernel for the kprobe 'tcp', more than the 1 allowed"))
+		prog.AttachTo = "sctp_*"
+		_, problem = h.hook(prog, Scope{})
+		Expect(problem).To(Equal("the kernel has no function matching sctp_* for the kprobe 'tcp'"))
+	})
+
+	It("matches the globs of interfaces, unless they are reattached to", func() {
+		prog := &ebpf.ProgramSpec{Name: "allow", Type: ebpf.SchedCLS, SectionName: "classifier/ingress"}
+		h := &host{interfaces: map[string]bool{"lo": true, "veth3a1f": true}}
+		_, problem := h.hook(prog, Scope{Interface: "veth*"})
+		Expect(problem).To(BeEmpty())
+		_, problem = h.hook(prog, Scope{Interface: "cali*"})
+		Expect(problem).To(Equal("there is no network interface cali* for 'allow'"))
+		_, problem = h.hook(prog, Scope{Interface: "cali*", ReattachInterval: time.Second})
+		Expect(problem).To(BeEmpty())
+	})
+})
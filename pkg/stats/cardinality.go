@@ -0,0 +1,172 @@
+package stats
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/mitchellh/hashstructure/v2"
+)
+
+// OverflowLabelValue is the value all labels are set to once a metric exceeds its series limit.
+const OverflowLabelValue = "other"
+
+// SeriesCardinality reports the number of series exported for a single metric.
+type SeriesCardinality struct {
+	// Name of the map the metric is derived from
+	Name string `json:"name"`
+	// Maximum number of series, 0 if unlimited
+	Limit int `json:"limit"`
+	// Number of distinct series currently exported, excluding the overflow series
+	Series int `json:"series"`
+	// Number of distinct label sets of hash maps currently folded into the overflow series
+	OverflowedSeries int `json:"overflowedSeries"`
+	// Number of events of ring buffers folded into the overflow series, as their label sets
+	// are not remembered
+	OverflowedEvents uint64 `json:"overflowedEvents,omitempty"`
+}
+
+// seriesLimiter caps the number of distinct label sets of a single metric.
+// Label sets seen after the limit is reached are folded into a single series
+// with every label set to OverflowLabelValue. Only the label sets of the series
+// within the limit are remembered, the folded ones are counted.
+type seriesLimiter struct {
+	name   string
+	limit  int
+	labels []string
+
+	lock       sync.Mutex
+	series     map[uint64]struct{}
+	overflowed int
+	events     uint64
+}
+
+func newSeriesLimiter(name string, limit int, labels []string) *seriesLimiter {
+	return &seriesLimiter{
+		name:   name,
+		limit:  limit,
+		labels: labels,
+		series: map[uint64]struct{}{},
+	}
+}
+
+// add returns the labels to use for a new key of a hash map, and whether it is folded into
+// the overflow series. A folded key stays folded until forgotten.
+func (s *seriesLimiter) add(keyHash uint64, labels map[string]string) (map[string]string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.admit(keyHash) {
+		return labels, false
+	}
+	s.overflowed++
+	return s.overflowLabels(), true
+}
+
+// limitEvent returns the labels to use for an event of a ring buffer, the events of the keys
+// beyond the limit being folded into the overflow series.
+func (s *seriesLimiter) limitEvent(keyHash uint64, labels map[string]string) map[string]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.admit(keyHash) {
+		return labels
+	}
+	s.events++
+	return s.overflowLabels()
+}
+
+// admit returns whether the key has a series, adding it if below the limit. The lock must be
+// held.
+func (s *seriesLimiter) admit(keyHash uint64) bool {
+	if _, ok := s.series[keyHash]; ok {
+		return true
+	}
+	if s.limit <= 0 || len(s.series) < s.limit {
+		s.series[keyHash] = struct{}{}
+		return true
+	}
+	return false
+}
+
+func (s *seriesLimiter) overflowLabels() map[string]string {
+	overflow := make(map[string]string, len(s.labels))
+	for _, k := range s.labels {
+		overflow[k] = OverflowLabelValue
+	}
+	return overflow
+}
+
+// forget removes a key of a hash map from the limiter, freeing up its series, or the count
+// of the folded keys if it was folded.
+func (s *seriesLimiter) forget(keyHash uint64, folded bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if folded {
+		s.overflowed--
+		return
+	}
+	delete(s.series, keyHash)
+}
+
+func (s *seriesLimiter) cardinality() SeriesCardinality {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return SeriesCardinality{
+		Name:             s.name,
+		Limit:            s.limit,
+		Series:           len(s.series),
+		OverflowedSeries: s.overflowed,
+		OverflowedEvents: s.events,
+	}
+}
+
+func hashLabels(labels map[string]string) uint64 {
+	keyHash, err := hashstructure.Hash(labels, hashstructure.FormatV2, nil)
+	if err != nil {
+		log.Fatal("This should never happen")
+	}
+	return keyHash
+}
+
+// cardinalityTracker keeps track of the limiters of all metrics created by a provider.
+type cardinalityTracker struct {
+	defaultLimit int
+	limits       map[string]int
+
+	lock     sync.Mutex
+	limiters []*seriesLimiter
+}
+
+func (c *cardinalityTracker) newLimiter(opts *MetricOpts) *seriesLimiter {
+	limit := c.defaultLimit
+	if l, ok := c.limits[opts.Name]; ok {
+		limit = l
+	}
+	limiter := newSeriesLimiter(opts.Name, limit, opts.Labels)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.limiters = append(c.limiters, limiter)
+	return limiter
+}
+
+func (c *cardinalityTracker) report() []SeriesCardinality {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	report := make([]SeriesCardinality, 0, len(c.limiters))
+	for _, l := range c.limiters {
+		report = append(report, l.cardinality())
+	}
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Name < report[j].Name
+	})
+	return report
+}
+
+func (c *cardinalityTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.report()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,127 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Series limits", func() {
+	var (
+		provider *metricsProvider
+		ctx      = context.Background()
+		opts     = &MetricOpts{Name: "connections", Labels: []string{"daddr"}}
+		other    = prometheus.Labels{"daddr": OverflowLabelValue}
+	)
+
+	key := func(daddr string) map[string]string {
+		return map[string]string{"daddr": daddr}
+	}
+
+	BeforeEach(func() {
+		namer, err := newMetricNamer("")
+		Expect(err).NotTo(HaveOccurred())
+		provider = &metricsProvider{
+			registry: prometheus.NewRegistry(),
+			namer:    namer,
+			tracker:  &cardinalityTracker{defaultLimit: 2, limits: map[string]int{"unlimited": 0}},
+		}
+	})
+
+	It("sums the values of the keys beyond the limit of a gauge in the overflow series", func() {
+		g := provider.NewGauge(opts)
+		vec := g.(*gauge).gauge
+		g.Set(ctx, 1, key("10.0.0.1"))
+		g.Set(ctx, 2, key("10.0.0.2"))
+		g.Set(ctx, 3, key("10.0.0.3"))
+		g.Set(ctx, 4, key("10.0.0.4"))
+		Expect(testutil.ToFloat64(vec.With(prometheus.Labels(key("10.0.0.2"))))).To(Equal(2.0))
+		Expect(testutil.ToFloat64(vec.With(other))).To(Equal(7.0))
+
+		// a folded key replaces its previous value in the sum
+		g.Set(ctx, 10, key("10.0.0.3"))
+		Expect(testutil.ToFloat64(vec.With(other))).To(Equal(14.0))
+		Expect(provider.Cardinality()).To(Equal([]SeriesCardinality{{Name: "connections", Limit: 2, Series: 2, OverflowedSeries: 2}}))
+
+		g.Delete(ctx, key("10.0.0.4"))
+		Expect(testutil.ToFloat64(vec.With(other))).To(Equal(10.0))
+		// the series freed is taken by the next new key, the folded ones stay folded
+		g.Delete(ctx, key("10.0.0.1"))
+		g.Set(ctx, 5, key("10.0.0.3"))
+		g.Set(ctx, 6, key("10.0.0.5"))
+		Expect(testutil.ToFloat64(vec.With(prometheus.Labels(key("10.0.0.5"))))).To(Equal(6.0))
+		Expect(testutil.ToFloat64(vec.With(other))).To(Equal(5.0))
+		Expect(testutil.CollectAndCount(vec)).To(Equal(3))
+		Expect(provider.Cardinality()[0]).To(Equal(SeriesCardinality{Name: "connections", Limit: 2, Series: 2, OverflowedSeries: 1}))
+	})
+
+	It("adds the increases of the keys beyond the limit of a counter to the overflow series", func() {
+		c := provider.NewSetCounter(opts)
+		vec := c.(*setCounter).counter
+		c.Set(ctx, 1, key("10.0.0.1"))
+		c.Set(ctx, 2, key("10.0.0.2"))
+		c.Set(ctx, 3, key("10.0.0.3"))
+		c.Set(ctx, 5, key("10.0.0.3"))
+		c.Set(ctx, 4, key("10.0.0.4"))
+		Expect(testutil.ToFloat64(vec.With(other))).To(Equal(9.0))
+
+		// the overflow series aggregates many keys, so it is kept
+		c.Delete(ctx, key("10.0.0.3"))
+		c.Delete(ctx, key("10.0.0.1"))
+		Expect(testutil.CollectAndCount(vec)).To(Equal(2))
+		Expect(testutil.ToFloat64(vec.With(other))).To(Equal(9.0))
+		Expect(provider.Cardinality()[0]).To(Equal(SeriesCardinality{Name: "connections", Limit: 2, Series: 1, OverflowedSeries: 1}))
+	})
+
+	It("restarts the series of the counter values which go down", func() {
+		c := provider.NewSetCounter(opts)
+		vec := c.(*setCounter).counter
+		c.Set(ctx, 10, key("10.0.0.1"))
+		c.Set(ctx, 1, key("10.0.0.2"))
+		c.Set(ctx, 8, key("10.0.0.3"))
+		Expect(func() {
+			c.Set(ctx, 4, key("10.0.0.1"))
+			c.Set(ctx, 2, key("10.0.0.3"))
+		}).NotTo(Panic())
+		Expect(testutil.ToFloat64(vec.With(prometheus.Labels(key("10.0.0.1"))))).To(Equal(4.0))
+		Expect(testutil.ToFloat64(vec.With(other))).To(Equal(10.0))
+	})
+
+	It("counts the events folded into the overflow series", func() {
+		c := provider.NewIncrementCounter(&MetricOpts{Name: "events", Labels: []string{"daddr"}})
+		for _, daddr := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.1", "10.0.0.3"} {
+			c.Increment(ctx, key(daddr))
+		}
+		vec := c.(*incrementCounter).counter
+		Expect(testutil.ToFloat64(vec.With(prometheus.Labels(key("10.0.0.1"))))).To(Equal(2.0))
+		Expect(testutil.ToFloat64(vec.With(other))).To(Equal(2.0))
+		Expect(provider.Cardinality()).To(Equal([]SeriesCardinality{{Name: "events", Limit: 2, Series: 2, OverflowedEvents: 2}}))
+	})
+
+	It("serves the cardinality of the metrics, sorted by name", func() {
+		unlimited := provider.NewGauge(&MetricOpts{Name: "unlimited", Labels: []string{"daddr"}})
+		g := provider.NewGauge(opts)
+		for _, daddr := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+			unlimited.Set(ctx, 1, key(daddr))
+			g.Set(ctx, 1, key(daddr))
+		}
+
+		recorder := httptest.NewRecorder()
+		provider.tracker.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, cardinalityPath, nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(recorder.Body.String()).To(MatchJSON(`[
+			{"name":"connections","limit":2,"series":2,"overflowedSeries":1},
+			{"name":"unlimited","limit":0,"series":3,"overflowedSeries":0}
+		]`))
+		var report []SeriesCardinality
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &report)).To(Succeed())
+		Expect(report).To(Equal(provider.Cardinality()))
+	})
+})
@@ -0,0 +1,92 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Unit is the base unit the values of a metric are measured in.
+// Following the Prometheus naming conventions, the unit is appended to the metric name.
+type Unit string
+
+const (
+	UnitNone    Unit = ""
+	UnitSeconds Unit = "seconds"
+	UnitBytes   Unit = "bytes"
+	UnitPackets Unit = "packets"
+)
+
+const counterSuffix = "total"
+
+var supportedUnits = []Unit{UnitSeconds, UnitBytes, UnitPackets}
+
+var invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// ParseUnit returns the Unit matching the given string, if it is supported.
+func ParseUnit(s string) (Unit, bool) {
+	for _, u := range supportedUnits {
+		if string(u) == s {
+			return u, true
+		}
+	}
+	return UnitNone, false
+}
+
+// MetricNameData is the data available to a metric name template.
+type MetricNameData struct {
+	// Name of the map the metric is derived from
+	Name string
+	// Unit the metric is measured in, possibly empty
+	Unit Unit
+}
+
+// metricNamer builds the exported name of a metric from the map it was derived from.
+type metricNamer struct {
+	tmpl *template.Template
+}
+
+func newMetricNamer(nameTemplate string) (*metricNamer, error) {
+	if nameTemplate == "" {
+		return &metricNamer{}, nil
+	}
+	tmpl, err := template.New("metric-name").Parse(nameTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse metric name template '%s': %w", nameTemplate, err)
+	}
+	// render once up front so invalid field references are caught before any metric is created
+	if err := tmpl.Execute(&bytes.Buffer{}, MetricNameData{Name: "validate"}); err != nil {
+		return nil, fmt.Errorf("invalid metric name template '%s': %w", nameTemplate, err)
+	}
+	return &metricNamer{tmpl: tmpl}, nil
+}
+
+// name returns the metric name for the given opts.
+// For backwards compatibility the conventional unit and `_total` suffixes are only
+// added when the map declares a unit, otherwise the (templated) map name is used as is.
+func (n *metricNamer) name(opts *MetricOpts, counter bool) string {
+	name := opts.Name
+	if n.tmpl != nil {
+		buf := &bytes.Buffer{}
+		// the template has been validated on creation
+		n.tmpl.Execute(buf, MetricNameData{Name: opts.Name, Unit: opts.Unit})
+		name = buf.String()
+	}
+	name = invalidMetricChars.ReplaceAllString(name, "_")
+
+	if opts.Unit == UnitNone {
+		return name
+	}
+	if counter {
+		name = strings.TrimSuffix(name, "_"+counterSuffix)
+	}
+	if !strings.HasSuffix(name, "_"+string(opts.Unit)) {
+		name += "_" + string(opts.Unit)
+	}
+	if counter {
+		name += "_" + counterSuffix
+	}
+	return name
+}
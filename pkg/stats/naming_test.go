@@ -0,0 +1,52 @@
+package stats
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("metricNamer", func() {
+	It("names the metrics of the maps", func() {
+		for _, c := range []struct {
+			template string
+			mapName  string
+			unit     Unit
+			counter  bool
+			name     string
+		}{
+			// maps without units are named as is, for backwards compatibility
+			{"", "tcp_connections", UnitNone, true, "tcp_connections"},
+			{"", "tcp_connections", UnitNone, false, "tcp_connections"},
+			// prefixed by the template
+			{"ebpf_{{ .Name }}", "connections", UnitNone, true, "ebpf_connections"},
+			{"ebpf_{{ .Name }}_{{ .Unit }}", "rtt", UnitSeconds, false, "ebpf_rtt_seconds"},
+			// sanitised, by the map name or the template
+			{"", "tcp.connections-v4", UnitNone, true, "tcp_connections_v4"},
+			{"ebpf/{{ .Name }}", "connections", UnitNone, false, "ebpf_connections"},
+			{"", "ns:connections", UnitNone, false, "ns:connections"},
+			// suffixed by the unit and _total of counters
+			{"", "sent", UnitBytes, true, "sent_bytes_total"},
+			{"", "sent", UnitBytes, false, "sent_bytes"},
+			{"", "drops", UnitPackets, true, "drops_packets_total"},
+			// but only once
+			{"", "sent_bytes", UnitBytes, true, "sent_bytes_total"},
+			{"", "sent_bytes_total", UnitBytes, true, "sent_bytes_total"},
+			{"", "sent_total", UnitBytes, true, "sent_bytes_total"},
+			{"{{ .Name }}_{{ .Unit }}", "latency", UnitSeconds, false, "latency_seconds"},
+			{"{{ .Name }}_{{ .Unit }}", "latency", UnitSeconds, true, "latency_seconds_total"},
+			// _total is only trimmed from counters
+			{"", "sent_total", UnitBytes, false, "sent_total_bytes"},
+		} {
+			namer, err := newMetricNamer(c.template)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(namer.name(&MetricOpts{Name: c.mapName, Unit: c.unit}, c.counter)).To(Equal(c.name), "%+v", c)
+		}
+	})
+
+	It("rejects invalid templates", func() {
+		_, err := newMetricNamer("{{ .Name ")
+		Expect(err).To(MatchError(ContainSubstring("could not parse metric name template")))
+		_, err = newMetricNamer("{{ .Map }}")
+		Expect(err).To(MatchError(ContainSubstring("invalid metric name template")))
+	})
+})
@@ -3,10 +3,8 @@ package stats
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
 
-	"github.com/mitchellh/hashstructure/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
@@ -14,13 +12,21 @@ import (
 )
 
 const (
-	ebpfNamespace = "ebpf_solo_io"
+	ebpfNamespace   = "ebpf_solo_io"
+	cardinalityPath = "/cardinality"
 )
 
 type PrometheusOpts struct {
 	Port        uint32
 	MetricsPath string
-	Registry *prometheus.Registry
+	Registry    *prometheus.Registry
+	// Optional go template used to build metric names from map names, e.g. "tcp_{{ .Name }}"
+	NameTemplate string
+	// Maximum number of series exported per metric, 0 means unlimited.
+	// Series beyond the limit are aggregated into a single series with all labels set to "other".
+	MaxSeries int
+	// Per metric overrides of MaxSeries, keyed by map name
+	SeriesLimits map[string]int
 }
 
 func (p *PrometheusOpts) initDefaults() {
@@ -35,12 +41,22 @@ func (p *PrometheusOpts) initDefaults() {
 func NewPrometheusMetricsProvider(ctx context.Context, opts *PrometheusOpts) (MetricsProvider, error) {
 	opts.initDefaults()
 
+	namer, err := newMetricNamer(opts.NameTemplate)
+	if err != nil {
+		return nil, err
+	}
+
 	serveMux := http.NewServeMux()
 	handler := promhttp.Handler()
 	if opts.Registry != nil {
 		handler = promhttp.InstrumentMetricHandler(opts.Registry, promhttp.HandlerFor(opts.Registry, promhttp.HandlerOpts{}))
 	}
 	serveMux.Handle(opts.MetricsPath, handler)
+	tracker := &cardinalityTracker{
+		defaultLimit: opts.MaxSeries,
+		limits:       opts.SeriesLimits,
+	}
+	serveMux.Handle(cardinalityPath, tracker)
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", opts.Port),
 		Handler: serveMux,
@@ -59,13 +75,27 @@ func NewPrometheusMetricsProvider(ctx context.Context, opts *PrometheusOpts) (Me
 
 	return &metricsProvider{
 		registry: opts.Registry,
+		namer:    namer,
+		tracker:  tracker,
 	}, nil
 }
 
+// MetricOpts describes a metric derived from a BPF map.
+type MetricOpts struct {
+	// Name of the map the metric is derived from
+	Name string
+	// Label keys of the metric
+	Labels []string
+	// Unit the map values are measured in, if declared
+	Unit Unit
+}
+
 type MetricsProvider interface {
-	NewSetCounter(name string, labels []string) SetInstrument
-	NewIncrementCounter(name string, labels []string) IncrementInstrument
-	NewGauge(name string, labels []string) SetInstrument
+	NewSetCounter(opts *MetricOpts) SetInstrument
+	NewIncrementCounter(opts *MetricOpts) IncrementInstrument
+	NewGauge(opts *MetricOpts) SetInstrument
+	// Cardinality reports the number of series of every metric created by the provider
+	Cardinality() []SeriesCardinality
 }
 
 type IncrementInstrument interface {
@@ -74,49 +104,61 @@ type IncrementInstrument interface {
 
 type SetInstrument interface {
 	Set(ctx context.Context, val int64, labels map[string]string)
+	// Delete stops exporting the series for the given labels
+	Delete(ctx context.Context, labels map[string]string)
 }
 
-type metricsProvider struct{
+type metricsProvider struct {
 	registry *prometheus.Registry
+	namer    *metricNamer
+	tracker  *cardinalityTracker
 }
 
-func (m *metricsProvider) NewSetCounter(name string, labels []string) SetInstrument {
+func (m *metricsProvider) NewSetCounter(opts *MetricOpts) SetInstrument {
 	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: ebpfNamespace,
-		Name:      name,
-	}, labels)
+		Name:      m.namer.name(opts, true),
+	}, opts.Labels)
 
 	m.register(counter)
 	return &setCounter{
 		counter:    counter,
-		counterMap: map[uint64]int64{},
+		counterMap: map[uint64]seriesValue{},
+		limiter:    m.tracker.newLimiter(opts),
 	}
 }
 
-func (m *metricsProvider) NewIncrementCounter(name string, labels []string) IncrementInstrument {
+func (m *metricsProvider) NewIncrementCounter(opts *MetricOpts) IncrementInstrument {
 	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: ebpfNamespace,
-		Name:      name,
-	}, labels)
+		Name:      m.namer.name(opts, true),
+	}, opts.Labels)
 
 	m.register(counter)
 	return &incrementCounter{
 		counter: counter,
+		limiter: m.tracker.newLimiter(opts),
 	}
 }
 
-func (m *metricsProvider) NewGauge(name string, labels []string) SetInstrument {
+func (m *metricsProvider) NewGauge(opts *MetricOpts) SetInstrument {
 	gaugeVec := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: ebpfNamespace,
-		Name:      name,
-	}, labels)
+		Name:      m.namer.name(opts, false),
+	}, opts.Labels)
 
 	m.register(gaugeVec)
 	return &gauge{
-		gauge: gaugeVec,
+		gauge:   gaugeVec,
+		limiter: m.tracker.newLimiter(opts),
+		values:  map[uint64]seriesValue{},
 	}
 }
 
+func (m *metricsProvider) Cardinality() []SeriesCardinality {
+	return m.tracker.report()
+}
+
 func (m *metricsProvider) register(collectors ...prometheus.Collector) {
 	if m.registry != nil {
 		m.registry.MustRegister(collectors...)
@@ -125,9 +167,17 @@ func (m *metricsProvider) register(collectors ...prometheus.Collector) {
 	prometheus.MustRegister(collectors...)
 }
 
+// seriesValue is the last value set for a key of a hash map, and whether the key is folded
+// into the overflow series. The values of the keys are removed once deleted from the map.
+type seriesValue struct {
+	value  int64
+	folded bool
+}
+
 type setCounter struct {
 	counter    *prometheus.CounterVec
-	counterMap map[uint64]int64
+	counterMap map[uint64]seriesValue
+	limiter    *seriesLimiter
 }
 
 func (c *setCounter) Set(
@@ -136,33 +186,67 @@ func (c *setCounter) Set(
 	decodedKey map[string]string,
 ) {
 
-	keyHash, err := hashstructure.Hash(decodedKey, hashstructure.FormatV2, nil)
-	if err != nil {
-		log.Fatal("This should never happen")
+	keyHash := hashLabels(decodedKey)
+
+	old, ok := c.counterMap[keyHash]
+	diff := intVal - old.value
+	if old.value == intVal {
+		return
 	}
+	labels := decodedKey
+	if !ok {
+		labels, old.folded = c.limiter.add(keyHash, decodedKey)
+	} else if old.folded {
+		labels = c.limiter.overflowLabels()
+	}
+	if diff < 0 {
+		// counters can't go down, e.g. once the entry is created again: the series of the key
+		// restarts from the value, the overflow series only adds it
+		diff = intVal
+		if ok && !old.folded {
+			c.counter.Delete(prometheus.Labels(labels))
+		}
+	}
+	c.counterMap[keyHash] = seriesValue{value: intVal, folded: old.folded}
+	c.counter.With(prometheus.Labels(labels)).Add(float64(diff))
+}
 
-	oldVal := c.counterMap[keyHash]
-	diff := intVal - oldVal
-	if oldVal == intVal {
+func (c *setCounter) Delete(
+	ctx context.Context,
+	decodedKey map[string]string,
+) {
+	keyHash := hashLabels(decodedKey)
+	old, ok := c.counterMap[keyHash]
+	if !ok {
 		return
 	}
-	c.counterMap[keyHash] = intVal
-	c.counter.With(prometheus.Labels(decodedKey)).Add(float64(diff))
+	delete(c.counterMap, keyHash)
+	c.limiter.forget(keyHash, old.folded)
+	// the overflow series aggregates many keys, so it is kept around
+	if !old.folded {
+		c.counter.Delete(prometheus.Labels(decodedKey))
+	}
 }
 
 type incrementCounter struct {
 	counter *prometheus.CounterVec
+	limiter *seriesLimiter
 }
 
 func (i *incrementCounter) Increment(
 	ctx context.Context,
 	decodedKey map[string]string,
 ) {
-	i.counter.With(prometheus.Labels(decodedKey)).Inc()
+	labels := i.limiter.limitEvent(hashLabels(decodedKey), decodedKey)
+	i.counter.With(prometheus.Labels(labels)).Inc()
 }
 
 type gauge struct {
-	gauge *prometheus.GaugeVec
+	gauge   *prometheus.GaugeVec
+	limiter *seriesLimiter
+	values  map[uint64]seriesValue
+	// sum of the values of the keys folded into the overflow series, which it reports
+	overflowSum int64
 }
 
 func (g *gauge) Set(
@@ -170,5 +254,38 @@ func (g *gauge) Set(
 	intVal int64,
 	decodedKey map[string]string,
 ) {
-	g.gauge.With(prometheus.Labels(decodedKey)).Set(float64(intVal))
+	keyHash := hashLabels(decodedKey)
+	old, ok := g.values[keyHash]
+	labels := decodedKey
+	if !ok {
+		labels, old.folded = g.limiter.add(keyHash, decodedKey)
+	} else if old.folded {
+		labels = g.limiter.overflowLabels()
+	}
+	g.values[keyHash] = seriesValue{value: intVal, folded: old.folded}
+	if !old.folded {
+		g.gauge.With(prometheus.Labels(labels)).Set(float64(intVal))
+		return
+	}
+	g.overflowSum += intVal - old.value
+	g.gauge.With(prometheus.Labels(labels)).Set(float64(g.overflowSum))
+}
+
+func (g *gauge) Delete(
+	ctx context.Context,
+	decodedKey map[string]string,
+) {
+	keyHash := hashLabels(decodedKey)
+	old, ok := g.values[keyHash]
+	if !ok {
+		return
+	}
+	delete(g.values, keyHash)
+	g.limiter.forget(keyHash, old.folded)
+	if !old.folded {
+		g.gauge.Delete(prometheus.Labels(decodedKey))
+		return
+	}
+	g.overflowSum -= old.value
+	g.gauge.With(prometheus.Labels(g.limiter.overflowLabels())).Set(float64(g.overflowSum))
 }
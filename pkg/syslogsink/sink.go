@@ -0,0 +1,414 @@
+package syslogsink
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/go-utils/contextutils"
+)
+
+const (
+	defaultAppName  = "bee"
+	defaultFacility = "local0"
+	defaultSeverity = "notice"
+
+	// number of events queued before events are dropped
+	queueSize = 10000
+
+	dialTimeout = 10 * time.Second
+	// time given to send the queued events once closed
+	closeTimeout = 10 * time.Second
+
+	// SDID is the id of the structured data element holding the fields of events,
+	// under the example private enterprise number reserved by RFC 5612
+	SDID = "event@32473"
+)
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11, "ntp": 12, "security": 13, "console": 14,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var severities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3, "warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// Config is a syslog collector events are sent to.
+type Config struct {
+	// Address of the collector, e.g. udp://siem:514, tcp://siem:601 or tls://siem:6514
+	Address string `yaml:"address"`
+	// APP-NAME of the messages, defaults to bee
+	AppName string `yaml:"appName,omitempty"`
+	// HOSTNAME of the messages, defaults to the hostname of the machine
+	Hostname string `yaml:"hostname,omitempty"`
+	// Facility of the events matching no mapping, defaults to local0
+	Facility string `yaml:"facility,omitempty"`
+	// Severity of the events matching no mapping, defaults to notice
+	Severity string `yaml:"severity,omitempty"`
+	// Ring buffers whose events are sent, all of them if empty
+	Maps []string `yaml:"maps,omitempty"`
+	// Facility and severity of specific events, the first matching mapping is used
+	Mappings []Mapping `yaml:"mappings,omitempty"`
+	// CA certificates the collector is verified with over TLS, the system ones if empty
+	CAFile             string `yaml:"caFile,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify,omitempty"`
+}
+
+// Mapping sets the facility and severity of the events of a map, optionally only
+// those whose field has the given value.
+type Mapping struct {
+	Map      string `yaml:"map"`
+	Field    string `yaml:"field,omitempty"`
+	Value    string `yaml:"value,omitempty"`
+	Facility string `yaml:"facility,omitempty"`
+	Severity string `yaml:"severity,omitempty"`
+}
+
+type priority struct {
+	facility int
+	severity int
+}
+
+type mapping struct {
+	Mapping
+	priority
+}
+
+// Sink sends the events of the ring buffers of a program as RFC 5424 syslog messages,
+// with the fields of each event as structured data. Hash maps are ignored, as they are
+// current state rather than events.
+// It implements v1.MapWatcher and v1.HealthReporter.
+type Sink struct {
+	ctx      context.Context
+	cfg      Config
+	network  string
+	addr     string
+	tlsCfg   *tls.Config
+	hostname string
+	priority priority
+	mappings []mapping
+	maps     map[string]bool
+
+	lock    sync.Mutex
+	watched map[string]bool
+	closed  bool
+	dropped uint64
+	// whether the last event could not be sent
+	down bool
+
+	messages chan []byte
+	done     chan struct{}
+	stop     chan struct{}
+	conn     net.Conn
+}
+
+// New validates the config and returns a sink, the context is used for logging.
+func New(ctx context.Context, cfg Config) (*Sink, error) {
+	if cfg.AppName == "" {
+		cfg.AppName = defaultAppName
+	}
+	if cfg.Facility == "" {
+		cfg.Facility = defaultFacility
+	}
+	if cfg.Severity == "" {
+		cfg.Severity = defaultSeverity
+	}
+	s := &Sink{
+		ctx:      ctx,
+		cfg:      cfg,
+		hostname: cfg.Hostname,
+		maps:     map[string]bool{},
+		watched:  map[string]bool{},
+		messages: make(chan []byte, queueSize),
+		done:     make(chan struct{}),
+		stop:     make(chan struct{}),
+	}
+
+	u, err := url.Parse(cfg.Address)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid syslog address %s", cfg.Address)
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		s.network = u.Scheme
+	case "tls":
+		s.network = "tcp"
+		s.tlsCfg, err = tlsConfig(cfg, u.Hostname())
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid syslog address %s, the scheme must be udp, tcp or tls", cfg.Address)
+	}
+	s.addr = u.Host
+
+	s.priority, err = parsePriority(cfg.Facility, cfg.Severity, priority{})
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range cfg.Mappings {
+		if m.Map == "" {
+			return nil, fmt.Errorf("syslog mapping %d must have a map", i)
+		}
+		p, err := parsePriority(m.Facility, m.Severity, s.priority)
+		if err != nil {
+			return nil, fmt.Errorf("syslog mapping %d: %w", i, err)
+		}
+		s.mappings = append(s.mappings, mapping{Mapping: m, priority: p})
+	}
+	for _, name := range cfg.Maps {
+		s.maps[name] = true
+	}
+	if s.hostname == "" {
+		s.hostname, _ = os.Hostname()
+	}
+	return s, nil
+}
+
+func tlsConfig(cfg Config, serverName string) (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read syslog CA file: %w", err)
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in syslog CA file %s", cfg.CAFile)
+		}
+	}
+	return tlsCfg, nil
+}
+
+// parsePriority parses the facility and severity names, empty names keep the default.
+func parsePriority(facility, severity string, defaults priority) (priority, error) {
+	p := defaults
+	if facility != "" {
+		f, ok := facilities[strings.ToLower(facility)]
+		if !ok {
+			return p, fmt.Errorf("unknown syslog facility %s", facility)
+		}
+		p.facility = f
+	}
+	if severity != "" {
+		s, ok := severities[strings.ToLower(severity)]
+		if !ok {
+			return p, fmt.Errorf("unknown syslog severity %s", severity)
+		}
+		p.severity = s
+	}
+	return p, nil
+}
+
+// Start sends the messages until the sink is closed.
+func (s *Sink) Start() {
+	go s.run()
+}
+
+func (s *Sink) NewRingBuf(name string, keys []string) {
+	if len(s.maps) > 0 && !s.maps[name] {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.watched[name] = true
+}
+
+func (s *Sink) NewHashMap(name string, keys []string) {
+	// hash maps are not events
+}
+
+func (s *Sink) SendEntry(entry v1.MapEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.watched[entry.Name] || s.closed {
+		return
+	}
+	msg := s.format(time.Now(), entry.Name, entry.Entry.Key)
+	select {
+	case s.messages <- msg:
+	default:
+		s.dropped++
+	}
+}
+
+// Close sends the messages still queued, giving up after a timeout.
+func (s *Sink) Close() {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.messages)
+	s.lock.Unlock()
+
+	timer := time.AfterFunc(closeTimeout, func() { close(s.stop) })
+	defer timer.Stop()
+	<-s.done
+}
+
+// Healthy returns false if the last event could not be sent, until one is.
+func (s *Sink) Healthy() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return !s.down
+}
+
+func (s *Sink) run() {
+	defer close(s.done)
+	defer func() {
+		if s.conn != nil {
+			s.conn.Close()
+		}
+	}()
+	logger := contextutils.LoggerFrom(s.ctx)
+	for msg := range s.messages {
+		s.lock.Lock()
+		dropped := s.dropped
+		s.dropped = 0
+		s.lock.Unlock()
+		if dropped > 0 {
+			logger.Warnf("dropped %d events, syslog collector %s is too slow", dropped, s.cfg.Address)
+		}
+
+		// retry once on a new connection, as stream connections are closed by collectors
+		var err error
+		for attempt := 0; attempt < 2; attempt++ {
+			select {
+			case <-s.stop:
+				logger.Warnf("gave up sending %d events to syslog collector %s on shutdown", len(s.messages)+1, s.cfg.Address)
+				return
+			default:
+			}
+			if err = s.write(msg); err == nil {
+				break
+			}
+			if s.conn != nil {
+				s.conn.Close()
+				s.conn = nil
+			}
+		}
+		s.lock.Lock()
+		s.down = err != nil
+		s.lock.Unlock()
+		if err != nil {
+			logger.Errorf("could not send event to syslog collector %s: %v", s.cfg.Address, err)
+		}
+	}
+}
+
+func (s *Sink) write(msg []byte) error {
+	if s.conn == nil {
+		dialer := &net.Dialer{Timeout: dialTimeout}
+		var err error
+		if s.tlsCfg != nil {
+			s.conn, err = tls.DialWithDialer(dialer, s.network, s.addr, s.tlsCfg)
+		} else {
+			s.conn, err = dialer.Dial(s.network, s.addr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if s.network == "tcp" {
+		// octet counting framing of RFC 6587 and RFC 5425
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+	_, err := s.conn.Write(msg)
+	return err
+}
+
+// priorityOf returns the priority of the first mapping matching the event.
+func (s *Sink) priorityOf(name string, fields map[string]string) priority {
+	for _, m := range s.mappings {
+		if m.Map != name {
+			continue
+		}
+		if m.Field != "" && fields[m.Field] != m.Value {
+			continue
+		}
+		return m.priority
+	}
+	return s.priority
+}
+
+// format renders an RFC 5424 message, with the map name as MSGID and the fields as
+// structured data. The message is the fields as key=value pairs, for collectors
+// ignoring structured data.
+func (s *Sink) format(now time.Time, name string, fields map[string]string) []byte {
+	p := s.priorityOf(name, fields)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sd, text strings.Builder
+	sd.WriteString("[" + SDID)
+	for i, k := range keys {
+		fmt.Fprintf(&sd, ` %s="%s"`, header(sdName(k), 32), sdEscaper.Replace(fields[k]))
+		if i > 0 {
+			text.WriteByte(' ')
+		}
+		fmt.Fprintf(&text, "%s=%s", k, fields[k])
+	}
+	sd.WriteString("]")
+
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		p.facility*8+p.severity,
+		now.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		header(s.hostname, 255),
+		header(s.cfg.AppName, 48),
+		os.Getpid(),
+		header(name, 32),
+		sd.String(),
+		text.String(),
+	))
+}
+
+// escapes the characters of PARAM-VALUEs
+var sdEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+// header returns a header field restricted to printable ASCII and truncated to its
+// maximum length, or the nil value if empty.
+func header(value string, maxLen int) string {
+	value = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+	if len(value) > maxLen {
+		value = value[:maxLen]
+	}
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// sdName replaces the characters not allowed in SD-NAMEs.
+func sdName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,96 @@
+package syslogsink_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/syslogsink"
+)
+
+func exec(comm, user string) v1.MapEntry {
+	return v1.MapEntry{Name: "execs", Entry: v1.KvPair{Key: map[string]string{"comm": comm, "user": user}}}
+}
+
+var _ = Describe("syslog sink", func() {
+	It("sends RFC 5424 messages over UDP with mapped priorities", func() {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		sink, err := syslogsink.New(context.Background(), syslogsink.Config{
+			Address:  "udp://" + conn.LocalAddr().String(),
+			Hostname: "node-1",
+			Mappings: []syslogsink.Mapping{
+				{Map: "execs", Field: "user", Value: "root", Facility: "auth", Severity: "warning"},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		sink.Start()
+		sink.NewRingBuf("execs", []string{"comm", "user"})
+		sink.SendEntry(exec(`sh`, "root"))
+		sink.SendEntry(exec(`"quoted]`, "bee"))
+		sink.Close()
+
+		buf := make([]byte, 2048)
+		var messages []string
+		for i := 0; i < 2; i++ {
+			n, _, err := conn.ReadFrom(buf)
+			Expect(err).NotTo(HaveOccurred())
+			messages = append(messages, string(buf[:n]))
+		}
+		// auth.warning = 4*8+4, local0.notice = 16*8+5
+		Expect(messages[0]).To(HavePrefix("<36>1 "))
+		Expect(messages[0]).To(MatchRegexp(`^<36>1 \S+ node-1 bee \d+ execs \[event@32473 comm="sh" user="root"\] comm=sh user=root$`))
+		Expect(messages[1]).To(HavePrefix("<133>1 "))
+		Expect(messages[1]).To(ContainSubstring(`comm="\"quoted\]"`))
+	})
+
+	It("frames messages over TCP", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+		received := make(chan string, 1)
+		go func() {
+			defer GinkgoRecover()
+			conn, err := listener.Accept()
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			length, err := r.ReadString(' ')
+			Expect(err).NotTo(HaveOccurred())
+			n, err := strconv.Atoi(strings.TrimSpace(length))
+			Expect(err).NotTo(HaveOccurred())
+			msg := make([]byte, n)
+			_, err = r.Read(msg)
+			Expect(err).NotTo(HaveOccurred())
+			received <- string(msg)
+		}()
+
+		sink, err := syslogsink.New(context.Background(), syslogsink.Config{
+			Address:  "tcp://" + listener.Addr().String(),
+			Facility: "security",
+			Severity: "info",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		sink.Start()
+		sink.NewRingBuf("execs", []string{"comm", "user"})
+		sink.SendEntry(exec("sh", "root"))
+		sink.Close()
+
+		Eventually(received).Should(Receive(And(HavePrefix("<110>1 "), HaveSuffix("comm=sh user=root"))))
+	})
+
+	It("rejects unknown facilities", func() {
+		_, err := syslogsink.New(context.Background(), syslogsink.Config{
+			Address:  "udp://localhost:514",
+			Facility: "nope",
+		})
+		Expect(err).To(MatchError(ContainSubstring("unknown syslog facility")))
+	})
+})
@@ -0,0 +1,13 @@
+package syslogsink_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestSyslogSink(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Syslog Sink Suite")
+}
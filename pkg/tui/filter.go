@@ -4,10 +4,20 @@ import (
 	"fmt"
 	"regexp"
 
+	v1 "github.com/solo-io/bumblebee/api/v1"
 	"github.com/solo-io/bumblebee/pkg/loader"
 )
 
-func (a *App) filterMatch(entry loader.MapEntry) bool {
+// SetFilter replaces the filter applied to new entries.
+func (a *App) SetFilter(filter map[string]Filter) {
+	filterMutex.Lock()
+	defer filterMutex.Unlock()
+	a.filter = filter
+}
+
+func (a *App) filterMatch(entry v1.MapEntry) bool {
+	filterMutex.RLock()
+	defer filterMutex.RUnlock()
 	if a.filter == nil {
 		// no filters defined, allow entry
 		return true
@@ -55,7 +65,10 @@ func BuildFilter(filterString []string, watchedMaps map[string]loader.WatchedMap
 			return nil, fmt.Errorf("didnt find key val '%v'", labelName)
 		}
 
-		regex := regexp.MustCompile(thisFilter[2])
+		regex, err := regexp.Compile(thisFilter[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex for key '%v': %w", labelName, err)
+		}
 		filterMap[mapName] = Filter{
 			MapName:  mapName,
 			KeyField: labelName,
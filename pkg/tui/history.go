@@ -0,0 +1,213 @@
+package tui
+
+import (
+	"math"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+const sparklineWidth = 20
+
+var sparklineRunes = []rune("▁▂▃▄▅▆▇█")
+
+type sample struct {
+	time  time.Time
+	value float64
+}
+
+// memory held by a sample, ignoring the spare capacity of the slices holding them
+const sampleBytes = int64(unsafe.Sizeof(sample{}))
+
+// RetentionTier keeps the history at a resolution for a duration, e.g. 1s for 10m.
+type RetentionTier struct {
+	Resolution time.Duration
+	Keep       time.Duration
+}
+
+// HistoryPolicy is how the history of hash map values is kept. Each tier records the
+// last value of every interval of its resolution, so coarser tiers downsample the history.
+type HistoryPolicy struct {
+	// Tiers from the finest resolution, history is disabled if empty
+	Tiers []RetentionTier
+	// Bound of the memory of the samples of all entries, unbounded if 0
+	MaxBytes int64
+}
+
+// DefaultHistoryPolicy keeps the history for the window at the resolution of the sparkline.
+func DefaultHistoryPolicy(window time.Duration) HistoryPolicy {
+	if window <= 0 {
+		return HistoryPolicy{}
+	}
+	return HistoryPolicy{Tiers: []RetentionTier{{Resolution: window / sparklineWidth, Keep: window}}}
+}
+
+func (p HistoryPolicy) enabled() bool {
+	return len(p.Tiers) > 0
+}
+
+// window is how far back the history goes.
+func (p HistoryPolicy) window() time.Duration {
+	var window time.Duration
+	for _, t := range p.Tiers {
+		if t.Keep > window {
+			window = t.Keep
+		}
+	}
+	return window
+}
+
+// historyBudget accounts for the memory of the samples of all histories.
+type historyBudget struct {
+	max  int64
+	used int64
+}
+
+func (b *historyBudget) reserve() bool {
+	if b.max > 0 && b.used+sampleBytes > b.max {
+		return false
+	}
+	b.used += sampleBytes
+	return true
+}
+
+func (b *historyBudget) release(samples int) {
+	b.used -= int64(samples) * sampleBytes
+}
+
+type tier struct {
+	RetentionTier
+	samples []sample
+}
+
+// history is the values of a single map entry, kept at the resolutions of its tiers.
+type history struct {
+	tiers  []*tier
+	budget *historyBudget
+}
+
+func newHistory(policy HistoryPolicy, budget *historyBudget) *history {
+	h := &history{budget: budget}
+	for _, t := range policy.Tiers {
+		h.tiers = append(h.tiers, &tier{RetentionTier: t})
+	}
+	return h
+}
+
+// add records a value in every tier and drops the samples which aged out of them.
+// Once the budget is used up, the oldest sample of a tier is dropped to record the new one.
+func (h *history) add(now time.Time, value float64) {
+	for _, t := range h.tiers {
+		n := len(t.samples)
+		switch {
+		case n > 0 && !t.samples[n-1].time.Before(now.Truncate(t.Resolution)):
+			// same interval, keep its last value
+			t.samples[n-1] = sample{time: now, value: value}
+		case h.budget.reserve():
+			t.samples = append(t.samples, sample{time: now, value: value})
+		case n > 0:
+			t.samples = append(t.samples[1:], sample{time: now, value: value})
+		}
+
+		cutoff := now.Add(-t.Keep)
+		idx := 0
+		for idx < len(t.samples) && t.samples[idx].time.Before(cutoff) {
+			idx++
+		}
+		h.budget.release(idx)
+		t.samples = t.samples[idx:]
+	}
+}
+
+// samples returns the samples of all tiers in order, the finest available for each time.
+func (h *history) samples() []sample {
+	var (
+		merged []sample
+		before time.Time
+	)
+	for i, t := range h.tiers {
+		var older []sample
+		for _, s := range t.samples {
+			if i > 0 && !before.IsZero() && !s.time.Before(before) {
+				break
+			}
+			older = append(older, s)
+		}
+		if len(older) > 0 {
+			before = older[0].time
+		}
+		merged = append(older, merged...)
+	}
+	return merged
+}
+
+// resample moves the samples to the tiers of another policy and budget.
+func (h *history) resample(policy HistoryPolicy, budget *historyBudget) {
+	samples := h.samples()
+	h.clear()
+	*h = *newHistory(policy, budget)
+	for _, s := range samples {
+		h.add(s.time, s.value)
+	}
+}
+
+// clear drops all samples, releasing their memory.
+func (h *history) clear() {
+	for _, t := range h.tiers {
+		h.budget.release(len(t.samples))
+		t.samples = nil
+	}
+}
+
+// sparkline renders the window as a fixed width sparkline, each character representing
+// the last value seen in an equal slice of the window.
+func (h *history) sparkline(now time.Time, window time.Duration) string {
+	samples := h.samples()
+	if len(samples) == 0 {
+		return ""
+	}
+	bucketSize := window / sparklineWidth
+	start := now.Add(-window)
+
+	buckets := make([]float64, sparklineWidth)
+	filled := make([]bool, sparklineWidth)
+	for _, s := range samples {
+		idx := int(s.time.Sub(start) / bucketSize)
+		if idx < 0 {
+			continue
+		}
+		if idx >= sparklineWidth {
+			idx = sparklineWidth - 1
+		}
+		buckets[idx] = s.value
+		filled[idx] = true
+	}
+
+	min, max := math.Inf(1), math.Inf(-1)
+	for i, v := range buckets {
+		if !filled[i] {
+			continue
+		}
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+	}
+
+	var sb strings.Builder
+	for i, v := range buckets {
+		if !filled[i] {
+			if i == 0 || !filled[i-1] {
+				sb.WriteRune(' ')
+				continue
+			}
+			// carry the previous value forward
+			v = buckets[i-1]
+			buckets[i], filled[i] = v, true
+		}
+		level := 0
+		if max > min {
+			level = int((v - min) / (max - min) * float64(len(sparklineRunes)-1))
+		}
+		sb.WriteRune(sparklineRunes[level])
+	}
+	return sb.String()
+}
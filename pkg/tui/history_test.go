@@ -0,0 +1,59 @@
+package tui
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("history", func() {
+	start := time.Date(2022, 1, 12, 10, 0, 0, 0, time.UTC)
+	policy := HistoryPolicy{Tiers: []RetentionTier{
+		{Resolution: time.Second, Keep: 10 * time.Second},
+		{Resolution: 10 * time.Second, Keep: time.Minute},
+	}}
+
+	values := func(samples []sample) []float64 {
+		var vs []float64
+		for _, s := range samples {
+			vs = append(vs, s.value)
+		}
+		return vs
+	}
+
+	It("downsamples older samples", func() {
+		budget := &historyBudget{}
+		h := newHistory(policy, budget)
+		// a value every half second for 2 minutes
+		for i := 0; i < 240; i++ {
+			h.add(start.Add(time.Duration(i)*500*time.Millisecond), float64(i))
+		}
+		Expect(h.tiers[0].samples).To(HaveLen(11))
+		Expect(h.tiers[1].samples).To(HaveLen(7))
+		// the last value of each interval is kept
+		Expect(values(h.tiers[1].samples)).To(Equal([]float64{119, 139, 159, 179, 199, 219, 239}))
+
+		merged := h.samples()
+		Expect(values(merged)).To(Equal([]float64{119, 139, 159, 179, 199, 219, 221, 223, 225, 227, 229, 231, 233, 235, 237, 239}))
+		Expect(budget.used).To(Equal(18 * sampleBytes))
+
+		h.resample(HistoryPolicy{Tiers: policy.Tiers[1:]}, budget)
+		Expect(values(h.samples())).To(Equal([]float64{119, 139, 159, 179, 199, 219, 239}))
+		Expect(budget.used).To(Equal(7 * sampleBytes))
+	})
+
+	It("stays within its memory budget", func() {
+		budget := &historyBudget{max: 5 * sampleBytes}
+		first, second := newHistory(policy, budget), newHistory(policy, budget)
+		for i := 0; i < 20; i++ {
+			first.add(start.Add(time.Duration(i)*time.Second), float64(i))
+		}
+		second.add(start, 1)
+		Expect(budget.used).To(Equal(5 * sampleBytes))
+		// the oldest samples are dropped for the newest ones
+		Expect(values(first.tiers[0].samples)).To(Equal([]float64{16, 17, 18, 19}))
+		Expect(values(first.tiers[1].samples)).To(Equal([]float64{19}))
+		Expect(second.samples()).To(BeEmpty())
+	})
+})
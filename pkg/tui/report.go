@@ -0,0 +1,143 @@
+package tui
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// maximum number of events rendered per ringbuf in a report
+const reportEventLimit = 100
+
+const reportTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>bee report: {{ .ProgLocation }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: left; }
+th { background: #f3f3f3; }
+td.trend { font-family: monospace; color: #0077aa; }
+.meta { color: #666; }
+</style>
+</head>
+<body>
+<h1>{{ .ProgLocation }}</h1>
+<p class="meta">Generated by bee at {{ .Generated.Format "2006-01-02 15:04:05 MST" }}</p>
+{{ range .Maps }}
+<h2>{{ .Name }} <span class="meta">({{ .Type }}{{ if .Truncated }}, last {{ len .Rows }} of {{ .Total }} events{{ end }})</span></h2>
+<table>
+<tr>{{ range .Header }}<th>{{ . }}</th>{{ end }}</tr>
+{{ range .Rows }}<tr>{{ range .Cells }}<td>{{ . }}</td>{{ end }}{{ if .Trend }}<td class="trend">{{ .Trend }}</td>{{ end }}</tr>
+{{ end }}</table>
+{{ end }}
+</body>
+</html>
+`
+
+var parsedReportTemplate = template.Must(template.New("report").Parse(reportTemplate))
+
+type reportData struct {
+	ProgLocation string
+	Generated    time.Time
+	Maps         []reportMap
+}
+
+type reportMap struct {
+	Name      string
+	Type      string
+	Header    []string
+	Rows      []reportRow
+	Total     int
+	Truncated bool
+}
+
+type reportRow struct {
+	Cells []string
+	Trend string
+}
+
+// WriteReport renders the current state of all maps as a self-contained HTML document.
+func (a *App) WriteReport(w io.Writer) error {
+	now := time.Now()
+	data := reportData{
+		ProgLocation: a.progLocation,
+		Generated:    now,
+	}
+
+	mapMutex.RLock()
+	for name, mv := range mapOfMaps {
+		rm := reportMap{
+			Name:   name,
+			Header: append([]string{}, mv.Keys...),
+			Total:  len(mv.Entries),
+		}
+		entries := mv.Entries
+		switch mv.Type {
+		case ebpf.Hash:
+			rm.Type = "hash map"
+			rm.Header = append(rm.Header, "value")
+			if mv.History != nil {
+				rm.Header = append(rm.Header, fmt.Sprintf("last %v", a.sparklineWindow()))
+			}
+		default:
+			rm.Type = "events"
+			if len(entries) > reportEventLimit {
+				entries = entries[len(entries)-reportEventLimit:]
+				rm.Truncated = true
+			}
+		}
+		for _, entry := range entries {
+			row := reportRow{}
+			for _, k := range mv.Keys {
+				row.Cells = append(row.Cells, entry.Key[k])
+			}
+			if mv.Type == ebpf.Hash {
+				row.Cells = append(row.Cells, entry.Value)
+				if h, ok := mv.History[entry.Hash]; ok {
+					row.Trend = h.sparkline(now, a.sparklineWindow())
+				}
+			}
+			rm.Rows = append(rm.Rows, row)
+		}
+		data.Maps = append(data.Maps, rm)
+	}
+	mapMutex.RUnlock()
+
+	sort.Slice(data.Maps, func(i, j int) bool {
+		return data.Maps[i].Name < data.Maps[j].Name
+	})
+	return parsedReportTemplate.Execute(w, data)
+}
+
+// writeReportFile writes a report to a new timestamped file in the report directory
+// and returns its path.
+func (a *App) writeReportFile() (string, error) {
+	dir := a.reportDir
+	if dir == "" {
+		dir = "."
+	}
+	path := filepath.Join(dir, fmt.Sprintf("bee-report-%s.html", time.Now().Format("20060102-150405")))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("could not create report file: %w", err)
+	}
+	if err := a.WriteReport(f); err != nil {
+		f.Close()
+		return "", fmt.Errorf("could not write report: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("could not write report: %w", err)
+	}
+	return path, nil
+}
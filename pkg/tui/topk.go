@@ -0,0 +1,52 @@
+package tui
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/mitchellh/hashstructure/v2"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/loader"
+)
+
+// topEntries tracks the entries of a top-N hash map. The loader sends the largest entries
+// of each read of the map then the entry of the others, so the entries not received since
+// the previous others entry are no longer among the largest ones.
+type topEntries struct {
+	othersHash uint64
+	received   map[uint64]bool
+}
+
+func newTopEntries(keys []string) *topEntries {
+	othersHash, _ := hashstructure.Hash(loader.OthersLabels(keys), hashstructure.FormatV2, nil)
+	return &topEntries{othersHash: othersHash, received: map[uint64]bool{}}
+}
+
+// receive records an entry, and returns the entries without the ones which are no longer
+// among the largest, and whether any was removed.
+func (t *topEntries) receive(entries []v1.KvPair, hash uint64) ([]v1.KvPair, bool) {
+	if hash != t.othersHash {
+		t.received[hash] = true
+		return entries, false
+	}
+	kept := entries[:0]
+	for _, entry := range entries {
+		if t.received[entry.Hash] || entry.Hash == t.othersHash {
+			kept = append(kept, entry)
+		}
+	}
+	t.received = map[uint64]bool{}
+	return kept, len(kept) != len(entries)
+}
+
+// rank sorts the entries by decreasing value, the others entry last.
+func (t *topEntries) rank(entries []v1.KvPair) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if others := entries[i].Hash == t.othersHash; others != (entries[j].Hash == t.othersHash) {
+			return !others
+		}
+		vi, _ := strconv.ParseFloat(entries[i].Value, 64)
+		vj, _ := strconv.ParseFloat(entries[j].Value, 64)
+		return vi > vj
+	})
+}
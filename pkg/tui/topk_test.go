@@ -0,0 +1,33 @@
+package tui
+
+import (
+	"github.com/mitchellh/hashstructure/v2"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("top-N maps", func() {
+	entry := func(pid, value string) v1.KvPair {
+		key := map[string]string{"pid": pid}
+		hash, _ := hashstructure.Hash(key, hashstructure.FormatV2, nil)
+		return v1.KvPair{Key: key, Value: value, Hash: hash}
+	}
+
+	It("only keeps the entries received since the previous others entry", func() {
+		top := newTopEntries([]string{"pid"})
+		others := entry("other", "8")
+		entries := []v1.KvPair{entry("1", "5"), entry("2", "40"), others}
+
+		entries, pruned := top.receive(entries, entry("2", "").Hash)
+		Expect(pruned).To(BeFalse())
+		entries = append(entries, entry("3", "9"))
+		entries, _ = top.receive(entries, entry("3", "").Hash)
+		entries, pruned = top.receive(entries, others.Hash)
+		Expect(pruned).To(BeTrue())
+
+		top.rank(entries)
+		Expect(entries).To(Equal([]v1.KvPair{entry("2", "40"), entry("3", "9"), others}))
+	})
+})
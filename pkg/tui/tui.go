@@ -5,12 +5,15 @@ import (
 	"fmt"
 	"regexp"
 	"sort"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/cilium/ebpf"
 	"github.com/gdamore/tcell/v2"
 	"github.com/mitchellh/hashstructure/v2"
 	"github.com/rivo/tview"
+	v1 "github.com/solo-io/bumblebee/api/v1"
 	"github.com/solo-io/bumblebee/pkg/loader"
 	"github.com/solo-io/go-utils/contextutils"
 	"go.uber.org/zap"
@@ -29,6 +32,7 @@ const titleText = `[aqua] __
 
 const helpText = `[chartreuse]<ctrl-n>   [white]Select next table
 [chartreuse]<ctrl-p>   [white]Select previous table
+[chartreuse]<ctrl-r>   [white]Write HTML report
 [chartreuse]<ctrl-c>   [white]Quit`
 
 type Filter struct {
@@ -39,41 +43,76 @@ type Filter struct {
 
 type MapValue struct {
 	Hash    uint64
-	Entries []loader.KvPair
+	Entries []v1.KvPair
 	Table   *tview.Table
 	Index   int
 	Type    ebpf.MapType
 	Keys    []string
+	// History of the values of each entry, keyed by entry hash
+	History map[uint64]*history
+	// Entries of a top-N map, which only renders its largest entries, nil for other maps
+	Top *topEntries
 }
 
 type AppOpts struct {
 	ProgLocation string
 	Filter       map[string]Filter
 	ParsedELF    *loader.ParsedELF
+	// How long to keep the history of hash map values for, rendered as a sparkline per entry.
+	// History is disabled if 0.
+	HistoryWindow time.Duration
+	// How the history is kept, defaults to keeping HistoryWindow at the resolution of the
+	// sparkline. The sparkline renders HistoryWindow if set, the whole history otherwise.
+	HistoryPolicy *HistoryPolicy
+	// Directory HTML reports are written to, defaults to the current directory
+	ReportDir string
 }
 
 type App struct {
-	Entries chan loader.MapEntry
-
-	tviewApp     *tview.Application
-	flex         *tview.Flex
-	progLocation string
-	filter       map[string]Filter
+	Entries chan v1.MapEntry
+
+	tviewApp      *tview.Application
+	flex          *tview.Flex
+	status        *tview.TextView
+	progLocation  string
+	filter        map[string]Filter
+	historyWindow time.Duration
+	historyPolicy HistoryPolicy
+	historyBudget *historyBudget
+	reportDir     string
+	// number of entries of the top-N maps by name
+	topK map[string]int
 }
 
 func NewApp(opts *AppOpts) App {
 	a := App{
-		progLocation: opts.ProgLocation,
-		filter:       opts.Filter,
+		progLocation:  opts.ProgLocation,
+		filter:        opts.Filter,
+		historyWindow: opts.HistoryWindow,
+		historyPolicy: DefaultHistoryPolicy(opts.HistoryWindow),
+		reportDir:     opts.ReportDir,
+	}
+	if opts.HistoryPolicy != nil {
+		a.historyPolicy = *opts.HistoryPolicy
+	}
+	a.historyBudget = &historyBudget{max: a.historyPolicy.MaxBytes}
+	if opts.ParsedELF != nil {
+		a.topK = map[string]int{}
+		for name, m := range opts.ParsedELF.WatchedMaps {
+			if m.TopK > 0 {
+				a.topK[name] = m.TopK
+			}
+		}
 	}
 	return a
 }
 
 var mapOfMaps = make(map[string]MapValue)
 var mapMutex = sync.RWMutex{}
+var filterMutex = sync.RWMutex{}
 var currentIndex int
 
-func buildTView(logger *zap.SugaredLogger, cancel context.CancelFunc, progLocation string) (*tview.Application, *tview.Flex) {
+func buildTView(logger *zap.SugaredLogger, cancel context.CancelFunc, progLocation string, writeReport func()) (*tview.Application, *tview.Flex, *tview.TextView) {
 	app := tview.NewApplication()
 	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		if event.Key() == tcell.KeyCtrlC || (event.Key() == tcell.KeyRune && event.Rune() == 'q') {
@@ -90,6 +129,9 @@ func buildTView(logger *zap.SugaredLogger, cancel context.CancelFunc, progLocati
 		} else if event.Key() == tcell.KeyCtrlP {
 			prevTable(app)
 			return nil
+		} else if event.Key() == tcell.KeyCtrlR {
+			writeReport()
+			return nil
 		}
 		return event
 	})
@@ -103,6 +145,8 @@ func buildTView(logger *zap.SugaredLogger, cancel context.CancelFunc, progLocati
 	fetchText := tview.NewTextView().SetDynamicColors(true)
 	fmt.Fprintf(fetchText, "Program location: [aqua]%s", progLocation)
 
+	status := tview.NewTextView().SetDynamicColors(true)
+
 	help := tview.NewTextView().SetTextAlign(tview.AlignLeft).SetDynamicColors(true)
 	fmt.Fprint(help, helpText)
 
@@ -110,7 +154,7 @@ func buildTView(logger *zap.SugaredLogger, cancel context.CancelFunc, progLocati
 	fetchMenu := tview.NewFlex().SetDirection(tview.FlexRow).
 		AddItem(tview.NewBox(), 0, 1, false).
 		AddItem(fetchText, 0, 1, false).
-		AddItem(tview.NewBox(), 0, 1, false)
+		AddItem(status, 0, 1, false)
 	fetchMenu.SetBackgroundColor(tcell.ColorBlack)
 	rightMenu.AddItem(fetchMenu, 0, 1, false)
 	rightMenu.AddItem(help, 0, 1, false)
@@ -120,7 +164,7 @@ func buildTView(logger *zap.SugaredLogger, cancel context.CancelFunc, progLocati
 
 	flex.AddItem(header, 10, 0, false)
 
-	return app, flex
+	return app, flex, status
 }
 
 func (a *App) Close() {
@@ -128,13 +172,28 @@ func (a *App) Close() {
 }
 
 func (a *App) Run(ctx context.Context, progLoader loader.Loader, loaderOpts *loader.LoadOptions) error {
+	return a.RunWithSource(ctx, func(ctx context.Context) error {
+		contextutils.LoggerFrom(ctx).Info("calling Load()")
+		err := progLoader.Load(ctx, loaderOpts)
+		contextutils.LoggerFrom(ctx).Info("returned from Load()")
+		return err
+	})
+}
+
+// RunWithSource runs the TUI, rendering the maps and entries the source sends to the App
+// (as a v1.MapWatcher). The source must close the App once it is done sending entries.
+func (a *App) RunWithSource(ctx context.Context, source func(ctx context.Context) error) error {
 	logger := contextutils.LoggerFrom(ctx)
 
 	ctx, cancel := context.WithCancel(ctx)
-	app, flex := buildTView(logger, cancel, a.progLocation)
+	app, flex, status := buildTView(logger, cancel, a.progLocation, func() {
+		// rendering the report can take a while for big maps, don't block the UI
+		go a.reportToStatus(ctx)
+	})
 	a.tviewApp = app
 	a.flex = flex
-	a.Entries = make(chan loader.MapEntry, 20)
+	a.status = status
+	a.Entries = make(chan v1.MapEntry, 20)
 
 	eg := errgroup.Group{}
 	eg.Go(func() error {
@@ -152,10 +211,7 @@ func (a *App) Run(ctx context.Context, progLoader loader.Loader, loaderOpts *loa
 	})
 
 	eg.Go(func() error {
-		logger.Info("calling Load()")
-		err := progLoader.Load(ctx, loaderOpts)
-		logger.Info("returned from Load()")
-		return err
+		return source(ctx)
 	})
 
 	err := eg.Wait()
@@ -168,11 +224,20 @@ func (a *App) watch(ctx context.Context) {
 	logger.Info("beginning Watch() loop")
 	// a.Entries channel will be closed by the Loader
 	for r := range a.Entries {
+		// the maps are only locked to update them, so the reports and the key handlers don't
+		// wait for the tables to be rendered
+		var view *tableView
+		mapMutex.Lock()
 		if mapOfMaps[r.Name].Type == ebpf.Hash {
-			a.renderHash(ctx, r)
+			view = a.updateHash(ctx, r)
 		} else if mapOfMaps[r.Name].Type == ebpf.RingBuf {
-			a.renderRingBuf(ctx, r)
+			view = a.updateRingBuf(ctx, r)
 		}
+		mapMutex.Unlock()
+		if view == nil {
+			continue
+		}
+		view.render()
 		// we need to queue a UI update since tview app is running in a separate goroutine
 		// don't block here as we still want to process entries as they come in
 		go a.tviewApp.QueueUpdateDraw(func() {})
@@ -180,45 +245,88 @@ func (a *App) watch(ctx context.Context) {
 	logger.Info("no more entries, returning from Watch()")
 }
 
-func (a *App) renderRingBuf(ctx context.Context, incoming loader.MapEntry) {
+func (a *App) reportToStatus(ctx context.Context) {
+	path, err := a.writeReportFile()
+	if err != nil {
+		contextutils.LoggerFrom(ctx).Errorf("could not write report: %v", err)
+	}
+	a.tviewApp.QueueUpdateDraw(func() {
+		a.status.Clear()
+		if err != nil {
+			fmt.Fprintf(a.status, "[red]Failed to write report: %v", err)
+			return
+		}
+		fmt.Fprintf(a.status, "Report written to: [aqua]%s", path)
+	})
+}
+
+// tableView is the content of the table of a map, copied under mapMutex so the table is
+// rendered once it is released.
+type tableView struct {
+	table  *tview.Table
+	header []string
+	rows   []reportRow
+	// the entries no longer among the largest leave rows behind
+	clear bool
+}
+
+// render writes the header and the rows to the table.
+func (v *tableView) render() {
+	if v.clear {
+		v.table.Clear()
+	}
+	for i, k := range v.header {
+		cell := tview.NewTableCell(k).SetExpansion(1).SetTextColor(tcell.ColorYellow)
+		v.table.SetCell(0, i, cell)
+	}
+	for r, row := range v.rows {
+		r++ // increment the row index as the 0-th row is taken by the header
+		for c, value := range row.Cells {
+			v.table.SetCell(r, c, tview.NewTableCell(value).SetExpansion(1))
+		}
+		if row.Trend != "" {
+			cell := tview.NewTableCell(row.Trend).SetExpansion(1).SetTextColor(tcell.ColorAqua)
+			v.table.SetCell(r, len(row.Cells), cell)
+		}
+	}
+}
+
+// updateRingBuf adds the event to its map and returns the view of the map. mapMutex must be
+// held.
+func (a *App) updateRingBuf(ctx context.Context, incoming v1.MapEntry) *tableView {
 	current := mapOfMaps[incoming.Name]
 	current.Entries = append(current.Entries, incoming.Entry)
 
 	// update
 	mapOfMaps[incoming.Name] = current
 
-	// get the instance of Table we will update
-	table := current.Table
-	// render the first row containing the keys
-	c := 0
-	for i, k := range current.Keys {
-		cell := tview.NewTableCell(k).SetExpansion(1).SetTextColor(tcell.ColorYellow)
-		table.SetCell(0, i, cell)
-		c++
-	}
-
-	for r, entry := range current.Entries {
-		r++ // increment the row index as the 0-th row is taken by the header
-		ekMap := entry.Key
-		c := 0
-		for kk, kv := range current.Keys {
-			cell := tview.NewTableCell(ekMap[kv]).SetExpansion(1)
-			table.SetCell(r, kk, cell)
-			c++
+	view := &tableView{table: current.Table, header: append([]string(nil), current.Keys...)}
+	for _, entry := range current.Entries {
+		row := reportRow{}
+		for _, k := range current.Keys {
+			row.Cells = append(row.Cells, entry.Key[k])
 		}
+		view.rows = append(view.rows, row)
 	}
+	return view
 }
 
-func (a *App) renderHash(ctx context.Context, incoming loader.MapEntry) {
+// updateHash records the value of the entry in its map and returns the view of the map, nil
+// if it is unchanged. mapMutex must be held.
+func (a *App) updateHash(ctx context.Context, incoming v1.MapEntry) *tableView {
 	logger := contextutils.LoggerFrom(ctx)
 	current := mapOfMaps[incoming.Name]
+	incomingHash, _ := hashstructure.Hash(incoming.Entry.Key, hashstructure.FormatV2, nil)
+	historyChanged := a.recordHistory(current, incomingHash, incoming.Entry.Value)
+	pruned := false
+	if current.Top != nil {
+		current.Entries, pruned = current.Top.receive(current.Entries, incomingHash)
+	}
 	if len(current.Entries) == 0 {
-		newHash, _ := hashstructure.Hash(incoming.Entry.Key, hashstructure.FormatV2, nil)
-		logger.Infof("empty list, no entries for %v, generated new hash: %v\n", incoming.Entry.Key, newHash)
-		incoming.Entry.Hash = newHash
+		logger.Infof("empty list, no entries for %v, generated new hash: %v\n", incoming.Entry.Key, incomingHash)
+		incoming.Entry.Hash = incomingHash
 		current.Entries = append(current.Entries, incoming.Entry)
 	} else {
-		incomingHash, _ := hashstructure.Hash(incoming.Entry.Key, hashstructure.FormatV2, nil)
 		var idx int
 		var found = false
 		for idx = range current.Entries {
@@ -229,16 +337,15 @@ func (a *App) renderHash(ctx context.Context, incoming loader.MapEntry) {
 			}
 		}
 		if found {
-			if incoming.Entry.Value == current.Entries[idx].Value {
+			if incoming.Entry.Value == current.Entries[idx].Value && !historyChanged && !pruned {
 				logger.Infof("for key %v, current value '%v' at index '%v' matches incoming val '%v', continuing...\n", incoming.Entry.Key, current.Entries[idx].Value, idx, incoming.Entry.Value)
-				return
+				return nil
 			}
 			logger.Infof("for existing entry for %v at index '%v' updating val to: %v\n", incoming.Entry.Key, idx, incoming.Entry.Value)
 			current.Entries[idx].Value = incoming.Entry.Value
 		} else {
-			newHash, _ := hashstructure.Hash(incoming.Entry.Key, hashstructure.FormatV2, nil)
-			incoming.Entry.Hash = newHash
-			logger.Infof("since no existing entry for %v, appending with hash: %v\n", incoming.Entry.Key, newHash)
+			incoming.Entry.Hash = incomingHash
+			logger.Infof("since no existing entry for %v, appending with hash: %v\n", incoming.Entry.Key, incomingHash)
 			current.Entries = append(current.Entries, incoming.Entry)
 		}
 	}
@@ -246,31 +353,81 @@ func (a *App) renderHash(ctx context.Context, incoming loader.MapEntry) {
 	// update
 	mapOfMaps[incoming.Name] = current
 
-	// get the instance of Table we will update
-	table := current.Table
-	// render the first row containing the keys
-	c := 0
-	for i, k := range current.Keys {
-		cell := tview.NewTableCell(k).SetExpansion(1).SetTextColor(tcell.ColorYellow)
-		table.SetCell(0, i, cell)
-		c++
+	view := &tableView{table: current.Table, header: append([]string(nil), current.Keys...)}
+	if current.Top != nil {
+		current.Top.rank(current.Entries)
+		view.clear = true
+	}
+	// last column is the value of the map (i.e. the counter/gauge/etc.)
+	view.header = append(view.header, "value")
+	if current.History != nil {
+		view.header = append(view.header, fmt.Sprintf("last %v", a.sparklineWindow()))
 	}
-	// last column in first row is value of the map (i.e. the counter/gauge/etc.)
-	cell := tview.NewTableCell("value").SetExpansion(1).SetTextColor(tcell.ColorYellow)
-	table.SetCell(0, c, cell)
 
-	for r, entry := range current.Entries {
-		r++ // increment the row index as the 0-th row is taken by the header
-		ekMap := entry.Key
-		eVal := entry.Value
-		c := 0
-		for kk, kv := range current.Keys {
-			cell := tview.NewTableCell(ekMap[kv]).SetExpansion(1)
-			table.SetCell(r, kk, cell)
-			c++
+	now := time.Now()
+	for _, entry := range current.Entries {
+		row := reportRow{}
+		for _, k := range current.Keys {
+			row.Cells = append(row.Cells, entry.Key[k])
+		}
+		row.Cells = append(row.Cells, entry.Value)
+		if h, ok := current.History[entry.Hash]; ok {
+			row.Trend = h.sparkline(now, a.sparklineWindow())
+		}
+		view.rows = append(view.rows, row)
+	}
+	return view
+}
+
+// recordHistory adds the value of an entry to its history, if enabled.
+// It returns whether the rendered history should be refreshed.
+func (a *App) recordHistory(current MapValue, hash uint64, value string) bool {
+	if current.History == nil {
+		return false
+	}
+	val, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return false
+	}
+	h, ok := current.History[hash]
+	if !ok {
+		h = newHistory(a.historyPolicy, a.historyBudget)
+		current.History[hash] = h
+	}
+	h.add(time.Now(), val)
+	return true
+}
+
+// sparklineWindow is the window of the history rendered as a sparkline.
+func (a *App) sparklineWindow() time.Duration {
+	if a.historyWindow > 0 {
+		return a.historyWindow
+	}
+	return a.historyPolicy.window()
+}
+
+// SetHistoryPolicy changes how the history of hash map values is kept, the current
+// history being downsampled to the new tiers. History is disabled if it has no tiers.
+func (a *App) SetHistoryPolicy(policy HistoryPolicy) {
+	mapMutex.Lock()
+	defer mapMutex.Unlock()
+	a.historyPolicy = policy
+	a.historyBudget = &historyBudget{max: policy.MaxBytes}
+	for name, mv := range mapOfMaps {
+		if mv.Type != ebpf.Hash {
+			continue
 		}
-		cell := tview.NewTableCell(eVal).SetExpansion(1)
-		table.SetCell(r, c, cell)
+		switch {
+		case !policy.enabled():
+			mv.History = nil
+		case mv.History == nil:
+			mv.History = make(map[uint64]*history)
+		default:
+			for _, h := range mv.History {
+				h.resample(policy, a.historyBudget)
+			}
+		}
+		mapOfMaps[name] = mv
 	}
 }
 
@@ -282,7 +439,7 @@ func (a *App) NewHashMap(name string, keys []string) {
 	a.makeMapValue(name, keys, ebpf.Hash)
 }
 
-func (a *App) SendEntry(entry loader.MapEntry) {
+func (a *App) SendEntry(entry v1.MapEntry) {
 	if a.filterMatch(entry) {
 		a.Entries <- entry
 	}
@@ -295,7 +452,7 @@ func (a *App) makeMapValue(name string, keys []string, mapType ebpf.MapType) {
 	sort.Strings(keysCopy)
 
 	// create the array for containing the entries
-	entries := make([]loader.KvPair, 0, 10)
+	entries := make([]v1.KvPair, 0, 10)
 
 	table := tview.NewTable().SetFixed(1, 0)
 	table.SetBorder(true).SetTitle(name)
@@ -309,6 +466,13 @@ func (a *App) makeMapValue(name string, keys []string, mapType ebpf.MapType) {
 		Keys:    keysCopy,
 		Entries: entries,
 	}
+	if mapType == ebpf.Hash && a.historyPolicy.enabled() {
+		entry.History = make(map[uint64]*history)
+	}
+	if mapType == ebpf.Hash && a.topK[name] > 0 {
+		entry.Top = newTopEntries(keys)
+		table.SetTitle(fmt.Sprintf("%s (top %d)", name, a.topK[name]))
+	}
 	mapOfMaps[name] = entry
 	mapMutex.Unlock()
 
@@ -330,13 +494,13 @@ func nextTable(app *tview.Application) {
 		currentIndex++
 	}
 	mapMutex.RLock()
+	defer mapMutex.RUnlock()
 	for _, v := range mapOfMaps {
 		if v.Index == currentIndex {
 			app.SetFocus(v.Table)
 			return
 		}
 	}
-	mapMutex.RUnlock()
 }
 
 func prevTable(app *tview.Application) {
@@ -349,11 +513,11 @@ func prevTable(app *tview.Application) {
 		currentIndex--
 	}
 	mapMutex.RLock()
+	defer mapMutex.RUnlock()
 	for _, v := range mapOfMaps {
 		if v.Index == currentIndex {
 			app.SetFocus(v.Table)
 			return
 		}
 	}
-	mapMutex.RUnlock()
 }
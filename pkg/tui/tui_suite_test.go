@@ -0,0 +1,13 @@
+package tui
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestTui(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Tui Suite")
+}
@@ -0,0 +1,50 @@
+package tui
+
+import (
+	"context"
+	"os"
+
+	"github.com/cilium/ebpf"
+	"github.com/rivo/tview"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("App", func() {
+	It("renders the tables of the maps once updated", func() {
+		ctx := context.Background()
+		a := NewApp(&AppOpts{HistoryPolicy: &HistoryPolicy{}})
+		entry := v1.MapEntry{Name: "render_counts", Entry: v1.KvPair{Key: map[string]string{"pid": "42"}, Value: "3"}}
+
+		mapMutex.Lock()
+		// without the application, which draws the tables added
+		mapOfMaps["render_counts"] = MapValue{Table: tview.NewTable(), Type: ebpf.Hash, Keys: []string{"pid"}}
+		view := a.updateHash(ctx, entry)
+		mapMutex.Unlock()
+		Expect(view.header).To(Equal([]string{"pid", "value"}))
+		Expect(view.rows).To(Equal([]reportRow{{Cells: []string{"42", "3"}}}))
+		view.render()
+		Expect(view.table.GetCell(0, 1).Text).To(Equal("value"))
+		Expect(view.table.GetCell(1, 1).Text).To(Equal("3"))
+
+		// unchanged values are not rendered again
+		mapMutex.Lock()
+		Expect(a.updateHash(ctx, entry)).To(BeNil())
+		mapMutex.Unlock()
+	})
+
+	It("writes the report to a file", func() {
+		dir, err := os.MkdirTemp("", "")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		a := NewApp(&AppOpts{ReportDir: dir})
+
+		path, err := a.writeReportFile()
+		Expect(err).NotTo(HaveOccurred())
+		report, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(report)).To(ContainSubstring("<html"))
+	})
+})
@@ -0,0 +1,126 @@
+package vmlinux
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/solo-io/bumblebee/pkg/spec"
+)
+
+const (
+	// HostBTF is the BTF of the running kernel
+	HostBTF    = "/sys/kernel/btf/vmlinux"
+	HeaderName = "vmlinux.h"
+)
+
+var (
+	DefaultCacheDir = filepath.Join(spec.EbpfConfigDir, "vmlinux")
+
+	unsafeTagChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+	// hostBTF is read by default, replaced in tests
+	hostBTF = HostBTF
+)
+
+type Opts struct {
+	// BTFFile to generate the header from, defaults to the BTF of the running kernel
+	BTFFile string
+	// CacheDir holds the generated headers, one directory per BTF
+	CacheDir string
+	// Bpftool is the path of the bpftool executable
+	Bpftool string
+}
+
+func (o *Opts) initDefaults() {
+	if o.BTFFile == "" {
+		o.BTFFile = hostBTF
+	}
+	if o.CacheDir == "" {
+		o.CacheDir = DefaultCacheDir
+	}
+	if o.Bpftool == "" {
+		o.Bpftool = "bpftool"
+	}
+}
+
+// Header is a generated vmlinux.h
+type Header struct {
+	// Path of the header, its directory can be added to the include path
+	Path string
+	// Tag identifying the BTF, e.g. the kernel release
+	Tag string
+	// Digest of the BTF the header was generated from
+	Digest string
+}
+
+func (h *Header) Dir() string {
+	return filepath.Dir(h.Path)
+}
+
+// Generate returns the vmlinux.h of the BTF, generating it with bpftool unless it is already cached.
+// Headers are cached by BTF digest, and tagged with the kernel release (or the name of the BTF file).
+func Generate(ctx context.Context, opts *Opts) (*Header, error) {
+	opts.initDefaults()
+
+	btf, err := os.ReadFile(opts.BTFFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read BTF: %w", err)
+	}
+	sum := sha256.Sum256(btf)
+	header := &Header{
+		Tag:    btfTag(ctx, opts.BTFFile),
+		Digest: "sha256:" + hex.EncodeToString(sum[:]),
+	}
+	dir := filepath.Join(opts.CacheDir, fmt.Sprintf("%s-%s", header.Tag, hex.EncodeToString(sum[:6])))
+	header.Path = filepath.Join(dir, HeaderName)
+
+	if _, err := os.Stat(header.Path); err == nil {
+		return header, nil
+	}
+
+	out, err := exec.CommandContext(ctx, opts.Bpftool, "btf", "dump", "file", opts.BTFFile, "format", "c").Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("could not generate %s: %w: %s", HeaderName, err, exitErr.Stderr)
+		}
+		return nil, fmt.Errorf("could not generate %s: %w", HeaderName, err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(dir, HeaderName+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	fmt.Fprintf(tmp, "/* Generated by bee from %s (%s, %s) */\n", opts.BTFFile, header.Tag, header.Digest)
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), header.Path); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+func btfTag(ctx context.Context, btfFile string) string {
+	if btfFile == hostBTF {
+		if out, err := exec.CommandContext(ctx, "uname", "-r").Output(); err == nil {
+			return unsafeTagChars.ReplaceAllString(strings.TrimSpace(string(out)), "_")
+		}
+	}
+	name := filepath.Base(btfFile)
+	return unsafeTagChars.ReplaceAllString(strings.TrimSuffix(name, filepath.Ext(name)), "_")
+}
@@ -0,0 +1,13 @@
+package vmlinux
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestVmlinux(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Vmlinux Suite")
+}
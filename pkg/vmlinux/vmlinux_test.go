@@ -0,0 +1,106 @@
+package vmlinux
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Generate", func() {
+	var (
+		ctx  = context.Background()
+		dir  string
+		opts *Opts
+	)
+
+	// runs counts the runs of the fake bpftool
+	runs := func() int {
+		byt, err := ioutil.ReadFile(filepath.Join(dir, "runs"))
+		if os.IsNotExist(err) {
+			return 0
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return strings.Count(string(byt), "\n")
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "bee-vmlinux")
+		Expect(err).NotTo(HaveOccurred())
+		// dumps the BTF file it is given as the header
+		bpftool := filepath.Join(dir, "bpftool")
+		Expect(ioutil.WriteFile(bpftool, []byte("#!/bin/sh\necho \"$*\" >> "+filepath.Join(dir, "runs")+"\ncat \"$4\"\n"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "sysfs-vmlinux"), []byte("struct task_struct {};\n"), 0644)).To(Succeed())
+		hostBTF = filepath.Join(dir, "sysfs-vmlinux")
+		opts = &Opts{CacheDir: filepath.Join(dir, "cache"), Bpftool: bpftool}
+	})
+
+	AfterEach(func() {
+		hostBTF = HostBTF
+		os.RemoveAll(dir)
+	})
+
+	It("generates the header of the BTF of the running kernel by default", func() {
+		release, err := exec.Command("uname", "-r").Output()
+		Expect(err).NotTo(HaveOccurred())
+
+		header, err := Generate(ctx, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(header.Tag).To(Equal(unsafeTagChars.ReplaceAllString(strings.TrimSpace(string(release)), "_")))
+		Expect(header.Digest).To(HavePrefix("sha256:"))
+		Expect(header.Dir()).To(HavePrefix(filepath.Join(dir, "cache", header.Tag+"-")))
+		byt, err := ioutil.ReadFile(header.Path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(byt)).To(HavePrefix("/* Generated by bee from " + hostBTF))
+		Expect(string(byt)).To(HaveSuffix("*/\nstruct task_struct {};\n"))
+	})
+
+	It("tags the headers of BTF files by their name", func() {
+		opts.BTFFile = filepath.Join(dir, "5.15.0-1019 (aws).btf")
+		Expect(ioutil.WriteFile(opts.BTFFile, []byte("struct sock {};\n"), 0644)).To(Succeed())
+		header, err := Generate(ctx, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(header.Tag).To(Equal("5.15.0-1019__aws_"))
+		byt, err := ioutil.ReadFile(header.Path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(byt)).To(HaveSuffix("*/\nstruct sock {};\n"))
+	})
+
+	It("only generates the header of a BTF once", func() {
+		header, err := Generate(ctx, opts)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runs()).To(Equal(1))
+		cached, err := Generate(ctx, &Opts{CacheDir: opts.CacheDir, Bpftool: opts.Bpftool})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cached).To(Equal(header))
+		Expect(runs()).To(Equal(1))
+
+		// until the kernel, and its BTF, changes
+		Expect(ioutil.WriteFile(hostBTF, []byte("struct task_struct { int pid; };\n"), 0644)).To(Succeed())
+		updated, err := Generate(ctx, &Opts{CacheDir: opts.CacheDir, Bpftool: opts.Bpftool})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runs()).To(Equal(2))
+		Expect(updated.Digest).NotTo(Equal(header.Digest))
+		Expect(updated.Path).NotTo(Equal(header.Path))
+		Expect(header.Path).To(BeAnExistingFile())
+	})
+
+	It("caches nothing when the header can't be generated", func() {
+		failing := filepath.Join(dir, "failing")
+		Expect(ioutil.WriteFile(failing, []byte("#!/bin/sh\necho 'Error: failed to load BTF' >&2\nexit 1\n"), 0755)).To(Succeed())
+		opts.Bpftool = failing
+		_, err := Generate(ctx, opts)
+		Expect(err).To(MatchError(ContainSubstring("could not generate vmlinux.h: exit status 1: Error: failed to load BTF")))
+		Expect(filepath.Join(dir, "cache")).NotTo(BeADirectory())
+
+		opts.BTFFile = filepath.Join(dir, "missing.btf")
+		_, err = Generate(ctx, opts)
+		Expect(err).To(MatchError(ContainSubstring("could not read BTF")))
+	})
+})
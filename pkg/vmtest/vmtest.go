@@ -0,0 +1,238 @@
+// Package vmtest loads and attaches the programs of a package on a matrix of kernels, each
+// booted in a lightweight VM by vmtest (https://github.com/danobi/vmtest), to report the
+// kernels the package runs on.
+package vmtest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf/rlimit"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/decoder"
+	"github.com/solo-io/bumblebee/pkg/internal/uname"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"gopkg.in/yaml.v2"
+)
+
+// resultPrefix marks the line of the output of a VM with the result of the probe, among
+// the logs of the kernel and of the init of the VM.
+const resultPrefix = "bee-vmtest-result: "
+
+// lines of the output of a VM reported when it has no result
+const outputTail = 20
+
+const defaultTimeout = 5 * time.Minute
+
+// Kernel is a kernel of the test matrix.
+type Kernel struct {
+	// Name of the kernel in the report, e.g. 5.10
+	Name string `yaml:"name"`
+	// Kernel image booted, e.g. a bzImage, relative to the matrix file
+	Image string `yaml:"image"`
+}
+
+// Matrix is the kernels a package is tested on.
+type Matrix struct {
+	Kernels []Kernel `yaml:"kernels"`
+}
+
+func LoadMatrix(path string) (*Matrix, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read kernel matrix: %w", err)
+	}
+	matrix := &Matrix{}
+	if err := yaml.UnmarshalStrict(data, matrix); err != nil {
+		return nil, fmt.Errorf("could not parse kernel matrix: %w", err)
+	}
+	if len(matrix.Kernels) == 0 {
+		return nil, fmt.Errorf("the kernel matrix %s declares no kernels", path)
+	}
+	names := make(map[string]bool)
+	for i, k := range matrix.Kernels {
+		if k.Name == "" || k.Image == "" {
+			return nil, fmt.Errorf("kernel #%d of the matrix must have a name and an image", i)
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("kernel %s is declared twice in the matrix", k.Name)
+		}
+		names[k.Name] = true
+		if !filepath.IsAbs(k.Image) {
+			matrix.Kernels[i].Image = filepath.Join(filepath.Dir(path), k.Image)
+		}
+	}
+	return matrix, nil
+}
+
+// Report is the compatibility of a package with the kernels of a matrix.
+type Report struct {
+	Package string                   `json:"package"`
+	Kernels []v1.KernelCompatibility `json:"kernels"`
+}
+
+// Failed returns the number of kernels the package is not compatible with.
+func (r *Report) Failed() int {
+	failed := 0
+	for _, k := range r.Kernels {
+		if !k.Compatible {
+			failed++
+		}
+	}
+	return failed
+}
+
+func (r *Report) Write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Runner boots a VM per kernel with vmtest, which shares the filesystem of the host with
+// the VM, and probes the package in it with `bee vmtest --probe`.
+type Runner struct {
+	// vmtest binary, looked up in the PATH if empty
+	VMTest string
+	// bee binary run in the VMs, the current one if empty
+	Bee string
+	// How long a VM may run, defaults to 5m
+	Timeout time.Duration
+}
+
+// Run probes the program file on each kernel of the matrix in turn.
+func (r *Runner) Run(ctx context.Context, matrix *Matrix, progFile string) ([]v1.KernelCompatibility, error) {
+	vmtest := r.VMTest
+	if vmtest == "" {
+		vmtest = "vmtest"
+	}
+	bee := r.Bee
+	if bee == "" {
+		var err error
+		bee, err = os.Executable()
+		if err != nil {
+			return nil, err
+		}
+	}
+	progFile, err := filepath.Abs(progFile)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]v1.KernelCompatibility, 0, len(matrix.Kernels))
+	for _, kernel := range matrix.Kernels {
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+		result := r.runKernel(ctx, vmtest, bee, kernel, progFile)
+		result.Kernel = kernel.Name
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (r *Runner) runKernel(ctx context.Context, vmtest, bee string, kernel Kernel, progFile string) v1.KernelCompatibility {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	command := strings.Join([]string{shellQuote(bee), "vmtest", "--probe", shellQuote(progFile)}, " ")
+	out, err := exec.CommandContext(ctx, vmtest, "--kernel", kernel.Image, command).CombinedOutput()
+	if result, ok := parseResult(out); ok {
+		return result
+	}
+	if err == nil {
+		err = fmt.Errorf("the VM did not report a result")
+	} else if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("the VM did not report a result within %s", timeout)
+	}
+	return v1.KernelCompatibility{Error: fmt.Sprintf("%v, output:\n%s", err, tail(out, outputTail))}
+}
+
+// parseResult returns the result of the probe from the output of the VM, if reported.
+func parseResult(out []byte) (v1.KernelCompatibility, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		idx := strings.Index(scanner.Text(), resultPrefix)
+		if idx < 0 {
+			continue
+		}
+		var result v1.KernelCompatibility
+		if err := json.Unmarshal([]byte(scanner.Text()[idx+len(resultPrefix):]), &result); err == nil {
+			return result, true
+		}
+	}
+	return v1.KernelCompatibility{}, false
+}
+
+func tail(out []byte, n int) string {
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Probe loads and attaches the programs of the file on the running kernel, then releases
+// them, and writes whether it succeeded to w. It runs in the VMs.
+func Probe(ctx context.Context, progFile string, w io.Writer) error {
+	data, err := json.Marshal(probe(ctx, progFile))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", resultPrefix, data)
+	return err
+}
+
+func probe(ctx context.Context, progFile string) v1.KernelCompatibility {
+	var result v1.KernelCompatibility
+	result.Release = uname.Release()
+
+	if err := rlimit.RemoveMemlock(); err != nil {
+		result.Error = fmt.Sprintf("could not raise memory limit: %v", err)
+		return result
+	}
+	f, err := os.Open(progFile)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer f.Close()
+	parsed, err := loader.NewLoader(decoder.NewDecoderFactory(), nil).Parse(ctx, f)
+	if err != nil {
+		result.Error = fmt.Sprintf("could not parse BPF program: %v", err)
+		return result
+	}
+	attached, err := loader.Attach(ctx, &loader.LoadOptions{ParsedELF: parsed})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer attached.Close()
+
+	for name := range attached.Collection.Programs {
+		result.Programs = append(result.Programs, name)
+	}
+	sort.Strings(result.Programs)
+	result.Compatible = true
+	return result
+}
@@ -0,0 +1,13 @@
+package vmtest_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestVmtest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Vmtest Suite")
+}
@@ -0,0 +1,109 @@
+package vmtest_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/solo-io/bumblebee/pkg/vmtest"
+)
+
+var _ = Describe("vmtest", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "bee-vmtest")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		Expect(ioutil.WriteFile(path, []byte(content), 0755)).To(Succeed())
+		return path
+	}
+
+	Context("kernel matrix", func() {
+		It("resolves the images relative to the matrix", func() {
+			path := write("kernels.yaml", `
+kernels:
+- name: "5.4"
+  image: kernels/5.4/bzImage
+- name: "5.10"
+  image: /boot/vmlinuz-5.10
+`)
+			matrix, err := vmtest.LoadMatrix(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(matrix.Kernels).To(Equal([]vmtest.Kernel{
+				{Name: "5.4", Image: filepath.Join(dir, "kernels/5.4/bzImage")},
+				{Name: "5.10", Image: "/boot/vmlinuz-5.10"},
+			}))
+		})
+
+		It("rejects kernels declared twice", func() {
+			path := write("kernels.yaml", `
+kernels:
+- name: "5.4"
+  image: a
+- name: "5.4"
+  image: b
+`)
+			_, err := vmtest.LoadMatrix(path)
+			Expect(err).To(MatchError(ContainSubstring("declared twice")))
+		})
+
+		It("rejects kernels without an image", func() {
+			path := write("kernels.yaml", "kernels:\n- name: \"5.4\"\n")
+			_, err := vmtest.LoadMatrix(path)
+			Expect(err).To(MatchError(ContainSubstring("must have a name and an image")))
+		})
+	})
+
+	Context("runner", func() {
+		// vmtest stand in, running the command on the host
+		var runner *vmtest.Runner
+
+		BeforeEach(func() {
+			runner = &vmtest.Runner{
+				VMTest: write("vmtest", "#!/bin/sh\n[ \"$1\" = --kernel ] || exit 2\necho booting $2\nsh -c \"$3\"\n"),
+			}
+		})
+
+		It("reports the result of the probe of each kernel", func() {
+			runner.Bee = write("bee", `#!/bin/sh
+echo "[    0.000000] Linux version"
+echo 'bee-vmtest-result: {"release":"5.10.162","compatible":true,"programs":["kprobe_tcp"]}'
+`)
+			results, err := runner.Run(context.Background(), &vmtest.Matrix{Kernels: []vmtest.Kernel{{Name: "5.10", Image: "bzImage"}}}, "prog.o")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Kernel).To(Equal("5.10"))
+			Expect(results[0].Release).To(Equal("5.10.162"))
+			Expect(results[0].Compatible).To(BeTrue())
+			Expect(results[0].Programs).To(Equal([]string{"kprobe_tcp"}))
+		})
+
+		It("reports the output of VMs without a result", func() {
+			runner.Bee = write("bee", "#!/bin/sh\necho 'Kernel panic - not syncing'\nexit 1\n")
+			results, err := runner.Run(context.Background(), &vmtest.Matrix{Kernels: []vmtest.Kernel{{Name: "4.19", Image: "bzImage"}}}, "prog.o")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results[0].Compatible).To(BeFalse())
+			Expect(results[0].Error).To(ContainSubstring("Kernel panic - not syncing"))
+		})
+	})
+
+	It("probes programs which can't be parsed as incompatible", func() {
+		var out bytes.Buffer
+		Expect(vmtest.Probe(context.Background(), write("prog.o", "not an ELF"), &out)).To(Succeed())
+		Expect(out.String()).To(HavePrefix("bee-vmtest-result: "))
+		Expect(out.String()).To(ContainSubstring(`"compatible":false`))
+	})
+})
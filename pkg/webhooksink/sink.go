@@ -0,0 +1,372 @@
+package webhooksink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/solo-io/go-utils/contextutils"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultMethod     = http.MethodPost
+	defaultMaxRetries = 3
+	defaultTimeout    = 10 * time.Second
+
+	// number of requests queued before events are dropped
+	queueSize = 1000
+	// time given to send the queued events once closed
+	closeTimeout = 10 * time.Second
+
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+
+	// DefaultTemplate sends each event as a JSON object, or each batch as a JSON array
+	DefaultTemplate = `{{ json . }}`
+)
+
+// Config is a webhook events are sent to.
+type Config struct {
+	// URL the events are sent to
+	URL    string `yaml:"url"`
+	Method string `yaml:"method,omitempty"`
+	// Headers of the requests, e.g. Authorization or Content-Type
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Ring buffers whose events are sent, all of them if empty
+	Maps []string `yaml:"maps,omitempty"`
+	// Go template rendering the body of the request from an Event, or from the list of the
+	// Events of a batch if batched, defaults to DefaultTemplate
+	Template string `yaml:"template,omitempty"`
+	// Sends the events in batches, a request per batch, if set
+	Batch *loader.BatchOptions `yaml:"batch,omitempty"`
+	// Maximum number of requests per second, unlimited if 0
+	RateLimit float64 `yaml:"rateLimit,omitempty"`
+	// Number of requests which can be sent at once above the rate limit, defaults to 1
+	Burst int `yaml:"burst,omitempty"`
+	// Number of times failed requests are retried, defaults to 3
+	MaxRetries int           `yaml:"maxRetries,omitempty"`
+	Timeout    time.Duration `yaml:"timeout,omitempty"`
+}
+
+func (c *Config) initDefaults() {
+	if c.Method == "" {
+		c.Method = defaultMethod
+	}
+	if c.Template == "" {
+		c.Template = DefaultTemplate
+	}
+	if c.Burst == 0 {
+		c.Burst = 1
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = defaultMaxRetries
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultTimeout
+	}
+}
+
+// Event is the data the template is rendered with.
+type Event struct {
+	// Name of the map
+	Map string `json:"map"`
+	// Time the event was received at, or its batch if batched
+	Time time.Time `json:"time"`
+	// Fields of the event, decoded to strings
+	Fields map[string]string `json:"fields"`
+}
+
+// Sink sends the events of the ring buffers of a program to a webhook, rendering the body
+// of each request with a Go template. Hash maps are ignored, as they are current state
+// rather than events.
+// It implements v1.MapWatcher and v1.BatchWatcher, the MapWatcher method returning the
+// watcher batching the events as configured, and v1.HealthReporter.
+type Sink struct {
+	ctx      context.Context
+	cfg      Config
+	template *template.Template
+	limiter  *rate.Limiter
+	client   *http.Client
+	maps     map[string]bool
+
+	lock    sync.Mutex
+	watched map[string]bool
+	closed  bool
+	dropped uint64
+	// whether the last request failed, after its retries
+	down bool
+
+	// the events of each request, or of each batch if batched
+	events chan []Event
+	done   chan struct{}
+	// cancels sending the queued events once the sink is closed for too long
+	stop context.CancelFunc
+	// context of the requests
+	sendCtx context.Context
+}
+
+// New validates the config and returns a sink, the context is used for logging.
+func New(ctx context.Context, cfg Config) (*Sink, error) {
+	cfg.initDefaults()
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %s", cfg.URL)
+	}
+	tmpl, err := template.New(cfg.URL).Funcs(template.FuncMap{
+		"json": toJSON,
+	}).Option("missingkey=zero").Parse(cfg.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template of webhook %s: %w", cfg.URL, err)
+	}
+	limit := rate.Inf
+	if cfg.RateLimit > 0 {
+		limit = rate.Limit(cfg.RateLimit)
+	}
+	maps := map[string]bool{}
+	for _, name := range cfg.Maps {
+		maps[name] = true
+	}
+	sendCtx, stop := context.WithCancel(context.Background())
+	return &Sink{
+		ctx:      ctx,
+		cfg:      cfg,
+		template: tmpl,
+		limiter:  rate.NewLimiter(limit, cfg.Burst),
+		client:   &http.Client{Timeout: cfg.Timeout},
+		maps:     maps,
+		watched:  map[string]bool{},
+		events:   make(chan []Event, queueSize),
+		done:     make(chan struct{}),
+		stop:     stop,
+		sendCtx:  sendCtx,
+	}, nil
+}
+
+// Start sends the events until the sink is closed.
+func (s *Sink) Start() {
+	go s.run()
+}
+
+func (s *Sink) NewRingBuf(name string, keys []string) {
+	if len(s.maps) > 0 && !s.maps[name] {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.watched[name] = true
+}
+
+func (s *Sink) NewHashMap(name string, keys []string) {
+	// hash maps are not events
+}
+
+// MapWatcher returns the watcher of the maps sending their events to the sink, in batches
+// if configured.
+func (s *Sink) MapWatcher() v1.MapWatcher {
+	if s.cfg.Batch == nil {
+		return s
+	}
+	return loader.NewBatcher(s, *s.cfg.Batch)
+}
+
+func (s *Sink) SendEntry(entry v1.MapEntry) {
+	s.WriteBatch([]v1.MapEntry{entry})
+}
+
+func (s *Sink) WriteBatch(entries []v1.MapEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	now := time.Now()
+	events := make([]Event, 0, len(entries))
+	for _, entry := range entries {
+		if !s.watched[entry.Name] {
+			continue
+		}
+		events = append(events, Event{
+			Map:    entry.Name,
+			Time:   now,
+			Fields: entry.Entry.Key,
+		})
+	}
+	if len(events) == 0 {
+		return
+	}
+	select {
+	case s.events <- events:
+	default:
+		s.dropped += uint64(len(events))
+	}
+}
+
+// Healthy returns false if the last request failed after its retries, until one succeeds.
+func (s *Sink) Healthy() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return !s.down
+}
+
+// Close sends the events still queued, giving up after a timeout.
+func (s *Sink) Close() {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.events)
+	s.lock.Unlock()
+
+	timer := time.AfterFunc(closeTimeout, s.stop)
+	defer timer.Stop()
+	<-s.done
+	s.stop()
+}
+
+func (s *Sink) run() {
+	defer close(s.done)
+	logger := contextutils.LoggerFrom(s.ctx)
+	for events := range s.events {
+		s.lock.Lock()
+		dropped := s.dropped
+		s.dropped = 0
+		s.lock.Unlock()
+		if dropped > 0 {
+			logger.Warnf("dropped %d events, webhook %s is too slow or rate limited", dropped, s.cfg.URL)
+		}
+
+		if s.cfg.Batch != nil {
+			if !s.sendRendered(events, fmt.Sprintf("batch of %d events", len(events))) {
+				return
+			}
+			continue
+		}
+		for _, event := range events {
+			if !s.sendRendered(event, "event of "+event.Map) {
+				return
+			}
+		}
+	}
+}
+
+// sendRendered renders the template with the data and sends it, and returns false if the
+// sink gave up sending on shutdown.
+func (s *Sink) sendRendered(data interface{}, what string) bool {
+	logger := contextutils.LoggerFrom(s.ctx)
+	var body bytes.Buffer
+	if err := s.template.Execute(&body, data); err != nil {
+		logger.Errorf("could not render %s for webhook %s: %v", what, s.cfg.URL, err)
+		return true
+	}
+	if err := s.limiter.Wait(s.sendCtx); err != nil {
+		logger.Warnf("gave up sending the queued events to webhook %s on shutdown", s.cfg.URL)
+		return false
+	}
+	if err := s.send(body.Bytes()); err != nil {
+		logger.Errorf("could not send %s to webhook %s: %v", what, s.cfg.URL, err)
+	}
+	return true
+}
+
+// send sends the body, retrying on network errors, 429 and 5xx statuses.
+func (s *Sink) send(body []byte) error {
+	backoff := minBackoff
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := s.sendOnce(body)
+		if err == nil {
+			s.setDown(false)
+			return nil
+		}
+		if retryAfter < 0 || attempt >= s.cfg.MaxRetries {
+			// requests which should not be retried were answered by the webhook
+			s.setDown(retryAfter >= 0)
+			return err
+		}
+		if retryAfter == 0 {
+			retryAfter = backoff
+		}
+		select {
+		case <-time.After(retryAfter):
+		case <-s.sendCtx.Done():
+			return err
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (s *Sink) setDown(down bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.down = down
+}
+
+// sendOnce returns the delay to retry after, 0 to use the backoff, or a negative delay
+// if the request should not be retried.
+func (s *Sink) sendOnce(body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(s.sendCtx, s.cfg.Method, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	switch {
+	case resp.StatusCode < 300:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return parseRetryAfter(resp.Header.Get("Retry-After")), fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return -1, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+}
+
+// toJSON is the json function of templates, it does not escape HTML characters
+// as payloads are seldom rendered as HTML.
+func toJSON(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// parseRetryAfter returns the delay of a Retry-After header in seconds, capped to the
+// maximum backoff, or 0 if it is not set.
+func parseRetryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	delay := time.Duration(seconds) * time.Second
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
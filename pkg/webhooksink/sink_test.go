@@ -0,0 +1,152 @@
+package webhooksink_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "github.com/solo-io/bumblebee/api/v1"
+	"github.com/solo-io/bumblebee/pkg/loader"
+	"github.com/solo-io/bumblebee/pkg/webhooksink"
+)
+
+// receiver fails the first request, then records the bodies it receives.
+type receiver struct {
+	lock     sync.Mutex
+	requests int
+	bodies   []string
+	headers  []http.Header
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.requests++
+	if r.requests == 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	r.bodies = append(r.bodies, string(body))
+	r.headers = append(r.headers, req.Header)
+}
+
+func event(saddr string) v1.MapEntry {
+	return v1.MapEntry{Name: "events", Entry: v1.KvPair{Key: map[string]string{"saddr": saddr, "daddr": "10.0.0.2"}}}
+}
+
+var _ = Describe("webhook sink", func() {
+	It("renders events with the template and retries failed requests", func() {
+		recv := &receiver{}
+		server := httptest.NewServer(recv)
+		defer server.Close()
+
+		sink, err := webhooksink.New(context.Background(), webhooksink.Config{
+			URL:      server.URL,
+			Headers:  map[string]string{"Authorization": "Bearer token"},
+			Maps:     []string{"events"},
+			Template: `{"text": {{ json (printf "%s -> %s" .Fields.saddr .Fields.daddr) }}}`,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		sink.Start()
+		sink.NewRingBuf("events", []string{"saddr", "daddr"})
+		sink.NewRingBuf("other", []string{"saddr"})
+		sink.SendEntry(event("10.0.0.1"))
+		sink.SendEntry(v1.MapEntry{Name: "other", Entry: v1.KvPair{Key: map[string]string{"saddr": "10.0.0.3"}}})
+		sink.Close()
+
+		Expect(recv.requests).To(Equal(2))
+		Expect(recv.bodies).To(Equal([]string{`{"text": "10.0.0.1 -> 10.0.0.2"}`}))
+		Expect(recv.headers[0].Get("Authorization")).To(Equal("Bearer token"))
+	})
+
+	It("rate limits requests", func() {
+		recv := &receiver{requests: 1}
+		server := httptest.NewServer(recv)
+		defer server.Close()
+
+		sink, err := webhooksink.New(context.Background(), webhooksink.Config{
+			URL:       server.URL,
+			RateLimit: 20,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		sink.Start()
+		sink.NewRingBuf("events", []string{"saddr", "daddr"})
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			sink.SendEntry(event("10.0.0.1"))
+		}
+		sink.Close()
+
+		Expect(recv.bodies).To(HaveLen(5))
+		var sent webhooksink.Event
+		Expect(json.Unmarshal([]byte(recv.bodies[0]), &sent)).To(Succeed())
+		Expect(sent.Map).To(Equal("events"))
+		Expect(sent.Fields).To(HaveKeyWithValue("saddr", "10.0.0.1"))
+		Expect(time.Since(start)).To(BeNumerically(">=", 200*time.Millisecond))
+	})
+
+	It("sends a request per batch if batched", func() {
+		recv := &receiver{requests: 1}
+		server := httptest.NewServer(recv)
+		defer server.Close()
+
+		sink, err := webhooksink.New(context.Background(), webhooksink.Config{
+			URL:   server.URL,
+			Batch: &loader.BatchOptions{MaxCount: 2, MaxLatency: time.Hour},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		sink.Start()
+		watcher := sink.MapWatcher()
+		watcher.NewRingBuf("events", []string{"saddr", "daddr"})
+		for _, saddr := range []string{"10.0.0.1", "10.0.0.3", "10.0.0.4"} {
+			watcher.SendEntry(event(saddr))
+		}
+		watcher.Close()
+
+		Expect(recv.bodies).To(HaveLen(2))
+		var sent []webhooksink.Event
+		Expect(json.Unmarshal([]byte(recv.bodies[0]), &sent)).To(Succeed())
+		Expect(sent).To(HaveLen(2))
+		Expect(sent[1].Fields).To(HaveKeyWithValue("saddr", "10.0.0.3"))
+		Expect(json.Unmarshal([]byte(recv.bodies[1]), &sent)).To(Succeed())
+		Expect(sent).To(HaveLen(1))
+	})
+
+	It("reports whether the webhook is reachable", func() {
+		recv := &receiver{requests: 1}
+		server := httptest.NewServer(recv)
+		defer server.Close()
+
+		down, err := webhooksink.New(context.Background(), webhooksink.Config{URL: "http://127.0.0.1:1", MaxRetries: -1})
+		Expect(err).NotTo(HaveOccurred())
+		down.Start()
+		down.NewRingBuf("events", []string{"saddr", "daddr"})
+		Expect(down.Healthy()).To(BeTrue())
+		down.SendEntry(event("10.0.0.1"))
+		Eventually(down.Healthy).Should(BeFalse())
+		down.Close()
+
+		up, err := webhooksink.New(context.Background(), webhooksink.Config{URL: server.URL})
+		Expect(err).NotTo(HaveOccurred())
+		up.Start()
+		up.NewRingBuf("events", []string{"saddr", "daddr"})
+		up.SendEntry(event("10.0.0.1"))
+		up.Close()
+		Expect(up.Healthy()).To(BeTrue())
+	})
+
+	It("rejects invalid templates", func() {
+		_, err := webhooksink.New(context.Background(), webhooksink.Config{
+			URL:      "https://example.com",
+			Template: "{{ .Fields.saddr ",
+		})
+		Expect(err).To(MatchError(ContainSubstring("invalid template")))
+	})
+})
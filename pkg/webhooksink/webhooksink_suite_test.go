@@ -0,0 +1,13 @@
+package webhooksink_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestWebhookSink(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Webhook Sink Suite")
+}